@@ -0,0 +1,453 @@
+package agfs
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheMaxBytes bounds a Cache when CacheOptions.MaxBytes is not set
+const DefaultCacheMaxBytes = 64 << 20
+
+// cacheEntryOverhead approximates the memory of an entry besides its data
+const cacheEntryOverhead = 256
+
+// cacheRewatchDelay is how long a Cache waits before re-establishing a
+// failed watch
+var cacheRewatchDelay = time.Second
+
+// CacheOptions configures a Cache
+type CacheOptions struct {
+	// MaxBytes bounds the cached file contents; least recently used entries
+	// are evicted first (default DefaultCacheMaxBytes)
+	MaxBytes int64
+	// Watch lists directories to watch recursively. While a watch is
+	// connected, entries below it are reused until the server reports a
+	// change, instead of being revalidated as the server's hints require.
+	Watch []string
+}
+
+// CacheStats counts how a Cache answered reads and stats
+type CacheStats struct {
+	Hits          int64 // Answered from the cache without contacting the server
+	Revalidations int64 // Confirmed unchanged by the server (304 Not Modified)
+	Misses        int64 // Fetched from the server
+	Invalidations int64 // Entries dropped because their file changed
+}
+
+// Cache is a client-side cache of file contents and stats. It follows the
+// Cache-Control and ETag headers of the server: "no-store" responses are
+// never kept, "max-age" responses are reused for that long, and others are
+// revalidated with If-None-Match. Watches on CacheOptions.Watch invalidate
+// entries as soon as their files change, which spares chatty readers most
+// round trips.
+type Cache struct {
+	client   *Client
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+	lru     *list.List // Of *cacheEntry, most recently used first
+	size    int64
+	gen     uint64          // Bumped by every invalidation
+	watched map[string]bool // Roots whose watch is connected
+	stats   CacheStats
+
+	watchers map[string]*Watcher
+	closed   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// cacheKey distinguishes the content and the stat of a path
+type cacheKey struct {
+	stat bool
+	path string
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	data  []byte
+	info  *FileInfo
+	etag  string
+	fresh time.Time // Reused without revalidating until then
+	elem  *list.Element
+}
+
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.data)+len(e.key.path)) + cacheEntryOverhead
+}
+
+// NewCache creates a cache in front of the client and starts its watches
+func (c *Client) NewCache(opts CacheOptions) (*Cache, error) {
+	cc := &Cache{
+		client:   c,
+		maxBytes: opts.MaxBytes,
+		entries:  make(map[cacheKey]*cacheEntry),
+		lru:      list.New(),
+		watched:  make(map[string]bool),
+		watchers: make(map[string]*Watcher),
+		closed:   make(chan struct{}),
+	}
+	if cc.maxBytes <= 0 {
+		cc.maxBytes = DefaultCacheMaxBytes
+	}
+	for _, root := range opts.Watch {
+		root = cleanCachePath(root)
+		w, err := c.Watch(root, WatchOptions{Recursive: true})
+		if err != nil {
+			cc.Close()
+			return nil, fmt.Errorf("failed to watch %s: %w", root, err)
+		}
+		cc.mu.Lock()
+		cc.watchers[root] = w
+		cc.watched[root] = true
+		cc.mu.Unlock()
+		cc.wg.Add(1)
+		go cc.follow(root, w)
+	}
+	return cc, nil
+}
+
+// Read returns the whole content of a file
+func (cc *Cache) Read(p string) ([]byte, error) {
+	p = cleanCachePath(p)
+	key := cacheKey{path: p}
+
+	cc.mu.Lock()
+	entry := cc.lookup(key)
+	if entry != nil && cc.isFresh(entry) {
+		cc.stats.Hits++
+		data := entry.data
+		cc.mu.Unlock()
+		return data, nil
+	}
+	var etag string
+	if entry != nil {
+		etag = entry.etag
+	}
+	gen := cc.gen
+	cc.mu.Unlock()
+
+	resp, err := cc.get("/files", p, etag)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		cc.mu.Lock()
+		cc.stats.Revalidations++
+		if cc.gen == gen {
+			entry.fresh = freshUntil(resp.Header)
+		}
+		cc.mu.Unlock()
+		return entry.data, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		cc.Invalidate(p)
+		return nil, cc.client.handleErrorResponse(resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	cc.mu.Lock()
+	cc.stats.Misses++
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		cc.store(gen, &cacheEntry{key: key, data: data, etag: etag}, resp.Header)
+	}
+	cc.mu.Unlock()
+	return data, nil
+}
+
+// Stat returns file information
+func (cc *Cache) Stat(p string) (*FileInfo, error) {
+	p = cleanCachePath(p)
+	key := cacheKey{stat: true, path: p}
+
+	cc.mu.Lock()
+	if entry := cc.lookup(key); entry != nil && cc.isFresh(entry) {
+		cc.stats.Hits++
+		info := *entry.info
+		cc.mu.Unlock()
+		return &info, nil
+	}
+	gen := cc.gen
+	cc.mu.Unlock()
+
+	resp, err := cc.get("/stat", p, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		cc.Invalidate(p)
+		return nil, cc.client.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var fileInfo FileInfoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&fileInfo); err != nil {
+		return nil, fmt.Errorf("failed to decode file info response: %w", err)
+	}
+	modTime, _ := time.Parse(time.RFC3339Nano, fileInfo.ModTime)
+	info := &FileInfo{
+		Name:      fileInfo.Name,
+		Size:      fileInfo.Size,
+		Mode:      fileInfo.Mode,
+		ModTime:   modTime,
+		IsDir:     fileInfo.IsDir,
+		IsSymlink: fileInfo.IsSymlink(),
+		Meta:      fileInfo.Meta,
+		Checksum:  fileInfo.Checksum,
+		ExpiresAt: fileInfo.expiry(),
+	}
+
+	cc.mu.Lock()
+	cc.stats.Misses++
+	cached := *info
+	cc.store(gen, &cacheEntry{key: key, info: &cached, etag: resp.Header.Get("ETag")}, resp.Header)
+	cc.mu.Unlock()
+	return info, nil
+}
+
+// Write writes a file through the client and drops its cached entries, so
+// the next read sees the new content even before the watch reports it
+func (cc *Cache) Write(p string, data []byte) ([]byte, error) {
+	defer cc.Invalidate(p)
+	return cc.client.Write(p, data)
+}
+
+// Remove removes a file through the client and drops its cached entries
+func (cc *Cache) Remove(p string) error {
+	defer cc.Invalidate(p)
+	return cc.client.Remove(p)
+}
+
+// Invalidate drops the cached entries of a path and everything below it
+func (cc *Cache) Invalidate(p string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.invalidate(cleanCachePath(p))
+}
+
+// Stats returns the cache counters
+func (cc *Cache) Stats() CacheStats {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.stats
+}
+
+// Close stops the watches and empties the cache
+func (cc *Cache) Close() error {
+	cc.mu.Lock()
+	select {
+	case <-cc.closed:
+		cc.mu.Unlock()
+		return nil
+	default:
+	}
+	close(cc.closed)
+	watchers := cc.watchers
+	cc.watchers = make(map[string]*Watcher)
+	cc.mu.Unlock()
+
+	for _, w := range watchers {
+		w.Close()
+	}
+	cc.wg.Wait()
+
+	cc.mu.Lock()
+	cc.entries = make(map[cacheKey]*cacheEntry)
+	cc.lru.Init()
+	cc.size = 0
+	cc.mu.Unlock()
+	return nil
+}
+
+// get sends a GET for path, conditional on etag when it is set
+func (cc *Cache) get(endpoint, p, etag string) (*http.Response, error) {
+	query := url.Values{}
+	query.Set("path", p)
+	req, err := http.NewRequest(http.MethodGet, cc.client.baseURL+endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	cc.client.authorize(req)
+	resp, err := cc.client.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	return resp, nil
+}
+
+// lookup returns the entry of key and marks it recently used
+func (cc *Cache) lookup(key cacheKey) *cacheEntry {
+	entry, ok := cc.entries[key]
+	if !ok {
+		return nil
+	}
+	cc.lru.MoveToFront(entry.elem)
+	return entry
+}
+
+// isFresh reports whether an entry may be reused without asking the server
+func (cc *Cache) isFresh(entry *cacheEntry) bool {
+	return time.Now().Before(entry.fresh) || cc.isWatched(entry.key.path)
+}
+
+// isWatched reports whether a connected watch covers p
+func (cc *Cache) isWatched(p string) bool {
+	for root := range cc.watched {
+		if pathWithin(p, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// store keeps an entry fetched when the cache was at generation gen, unless
+// the headers forbid it or something was invalidated during the fetch
+func (cc *Cache) store(gen uint64, entry *cacheEntry, header http.Header) {
+	if gen != cc.gen || strings.Contains(header.Get("Cache-Control"), "no-store") {
+		return
+	}
+	entry.fresh = freshUntil(header)
+	cc.remove(entry.key)
+	if entry.size() > cc.maxBytes {
+		return
+	}
+	entry.elem = cc.lru.PushFront(entry)
+	cc.entries[entry.key] = entry
+	cc.size += entry.size()
+	for cc.size > cc.maxBytes {
+		cc.remove(cc.lru.Back().Value.(*cacheEntry).key)
+	}
+}
+
+func (cc *Cache) remove(key cacheKey) bool {
+	entry, ok := cc.entries[key]
+	if !ok {
+		return false
+	}
+	cc.lru.Remove(entry.elem)
+	delete(cc.entries, key)
+	cc.size -= entry.size()
+	return true
+}
+
+// invalidate drops the entries of p and below it, and the stat of its
+// parent directory
+func (cc *Cache) invalidate(p string) {
+	cc.gen++
+	for key := range cc.entries {
+		if pathWithin(key.path, p) && cc.remove(key) {
+			cc.stats.Invalidations++
+		}
+	}
+	if cc.remove(cacheKey{stat: true, path: path.Dir(p)}) {
+		cc.stats.Invalidations++
+	}
+}
+
+// follow invalidates entries as the watch of root reports changes. When
+// the watch fails, everything below root is dropped, since changes may have
+// been missed, and the watch is re-established.
+func (cc *Cache) follow(root string, w *Watcher) {
+	defer cc.wg.Done()
+	for w != nil {
+		for ev := range w.Events() {
+			cc.mu.Lock()
+			cc.apply(root, ev)
+			cc.mu.Unlock()
+		}
+
+		cc.mu.Lock()
+		delete(cc.watched, root)
+		cc.invalidate(root)
+		cc.mu.Unlock()
+		w = cc.rewatch(root)
+	}
+}
+
+// rewatch re-establishes the watch of root, retrying until it succeeds or
+// the cache is closed (nil)
+func (cc *Cache) rewatch(root string) *Watcher {
+	for {
+		select {
+		case <-cc.closed:
+			return nil
+		case <-time.After(cacheRewatchDelay):
+		}
+		w, err := cc.client.Watch(root, WatchOptions{Recursive: true})
+		if err != nil {
+			continue
+		}
+
+		cc.mu.Lock()
+		defer cc.mu.Unlock()
+		select {
+		case <-cc.closed:
+			w.Close()
+			return nil
+		default:
+		}
+		cc.watchers[root] = w
+		cc.watched[root] = true
+		// Changes between the failure and the new watch are unknown
+		cc.invalidate(root)
+		return w
+	}
+}
+
+// apply invalidates the entries an event concerns
+func (cc *Cache) apply(root string, ev Event) {
+	switch ev.Type {
+	case "overflow":
+		cc.invalidate(root)
+	case "batch":
+		for _, member := range ev.Events {
+			cc.apply(root, member)
+		}
+	default:
+		cc.invalidate(cleanCachePath(ev.Path))
+		if ev.OldPath != "" {
+			cc.invalidate(cleanCachePath(ev.OldPath))
+		}
+	}
+}
+
+// freshUntil returns until when a response may be reused without
+// revalidating, from its Cache-Control max-age
+func freshUntil(header http.Header) time.Time {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(directive), "max-age=")
+		if !ok {
+			continue
+		}
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds > 0 {
+			return time.Now().Add(time.Duration(seconds) * time.Second)
+		}
+	}
+	return time.Time{}
+}
+
+func cleanCachePath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// pathWithin reports whether p is root or below it
+func pathWithin(p, root string) bool {
+	return p == root || root == "/" || strings.HasPrefix(p, root+"/")
+}
@@ -3,11 +3,16 @@ package agfs
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,8 +22,19 @@ import (
 var (
 	// ErrNotSupported is returned when the server or endpoint does not support the requested operation (HTTP 501)
 	ErrNotSupported = fmt.Errorf("operation not supported")
+	// ErrChecksumMismatch is returned when a transfer was corrupted on every attempt
+	ErrChecksumMismatch = fmt.Errorf("checksum mismatch")
 )
 
+// Headers of transfer checksums, see SetVerifyTransfers
+const (
+	contentSHA256Header = "X-AGFS-Content-SHA256"
+	wantChecksumHeader  = "X-AGFS-Want-Checksum"
+)
+
+// maxChecksumRetries is the number of times a corrupted transfer is retried
+const maxChecksumRetries = 3
+
 // DefaultStreamingProgressTimeout is the default per-chunk inactivity
 // bound applied to streaming reads (ReadStream, ReadHandleStream). It
 // caps how long the client will wait between bytes arriving from the
@@ -31,6 +47,9 @@ type Client struct {
 	baseURL                  string
 	httpClient               *http.Client
 	streamingProgressTimeout time.Duration
+	token                    string
+	session                  string
+	verifyTransfers          bool
 }
 
 // NewClient creates a new AGFS client
@@ -70,6 +89,40 @@ func (c *Client) SetStreamingProgressTimeout(d time.Duration) {
 	c.streamingProgressTimeout = d
 }
 
+// SetToken sets the API token sent as "Authorization: Bearer <token>" on
+// every request. Servers with auth tokens configured map it to the caller's
+// identity; an empty token sends no header.
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// SetSession records every following request into the server-side session
+// trace named id (sent as "X-AGFS-Session: <id>"). Servers without session
+// recording enabled ignore it; an empty id stops sending the header.
+func (c *Client) SetSession(id string) {
+	c.session = id
+}
+
+// SetVerifyTransfers enables end-to-end checksums of file contents. Writes
+// then send the SHA-256 of their data, which the server checks before
+// storing it, and reads ask for the SHA-256 of the bytes sent, which the
+// client checks. A corrupted transfer is retried up to 3 times before
+// failing with ErrChecksumMismatch. Servers without the "integrity" feature
+// ignore the checksums.
+func (c *Client) SetVerifyTransfers(enabled bool) {
+	c.verifyTransfers = enabled
+}
+
+// authorize adds the client's token and session to req
+func (c *Client) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.session != "" {
+		req.Header.Set("X-AGFS-Session", c.session)
+	}
+}
+
 // progressReader wraps an http.Response body with an inactivity
 // timeout. Each successful Read signals progress; if no progress
 // arrives within `timeout`, the request context is canceled, which
@@ -158,7 +211,8 @@ func (pr *progressReader) Close() error {
 	return pr.body.Close()
 }
 
-// normalizeBaseURL ensures the base URL ends with /api/v1
+// normalizeBaseURL ensures the base URL ends with an API prefix, /api/v1
+// unless it names another version
 func normalizeBaseURL(baseURL string) string {
 	// Remove trailing slash
 	if len(baseURL) > 0 && baseURL[len(baseURL)-1] == '/' {
@@ -174,11 +228,13 @@ func normalizeBaseURL(baseURL string) string {
 		return baseURL
 	}
 
-	// Auto-append /api/v1 if not present
-	if len(baseURL) < 7 || baseURL[len(baseURL)-7:] != "/api/v1" {
-		baseURL = baseURL + "/api/v1"
+	// Auto-append /api/v1 if no API version is present
+	for _, version := range supportedAPIVersions {
+		if strings.HasSuffix(baseURL, "/api/"+version) {
+			return baseURL
+		}
 	}
-	return baseURL
+	return baseURL + "/api/v1"
 }
 
 // ErrorResponse represents an error response from the API
@@ -199,6 +255,18 @@ type FileInfoResponse struct {
 	ModTime string   `json:"modTime"`
 	IsDir   bool     `json:"isDir"`
 	Meta    MetaData `json:"meta,omitempty"`
+
+	Checksum  string     `json:"checksum,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Preview   *Preview   `json:"preview,omitempty"`
+}
+
+// expiry returns when the file expires, or a zero time if it has no TTL
+func (f *FileInfoResponse) expiry() time.Time {
+	if f.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return *f.ExpiresAt
 }
 
 // IsSymlink checks if the file info represents a symbolic link
@@ -246,6 +314,7 @@ func (c *Client) doRequest(method, endpoint string, query url.Values, body io.Re
 		req.Header.Set("Content-Type", "application/json")
 	}
 
+	c.authorize(req)
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute request: %w", err)
@@ -257,6 +326,14 @@ func (c *Client) doRequest(method, endpoint string, query url.Values, body io.Re
 func (c *Client) handleErrorResponse(resp *http.Response) error {
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusAccepted {
+		var approval Approval
+		if err := json.NewDecoder(resp.Body).Decode(&approval); err != nil {
+			return fmt.Errorf("HTTP %d: failed to decode held request", resp.StatusCode)
+		}
+		return &PendingApprovalError{Approval: &approval}
+	}
+
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 		return nil
 	}
@@ -286,6 +363,23 @@ func (c *Client) Create(path string) error {
 	return c.handleErrorResponse(resp)
 }
 
+// Reserve creates an empty file as a placeholder for a write of about size
+// bytes, letting the server prepare for it (e.g. preallocate disk space or
+// start a multipart upload). Until the write completes, Stat reports the
+// size hint in Meta.Content["reserved_size"], to measure its progress.
+func (c *Client) Reserve(path string, size int64) error {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("size", strconv.FormatInt(size, 10))
+
+	resp, err := c.doRequest(http.MethodPost, "/files", query, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.handleErrorResponse(resp)
+}
+
 // Mkdir creates a new directory
 func (c *Client) Mkdir(path string, perm uint32) error {
 	query := url.Values{}
@@ -328,6 +422,163 @@ func (c *Client) RemoveAll(path string) error {
 	return c.handleErrorResponse(resp)
 }
 
+// DryRunEffect is one change a previewed operation would make
+type DryRunEffect struct {
+	Action string `json:"action"` // create, overwrite, append, delete, trash, rename, ...
+	Path   string `json:"path"`
+	Target string `json:"target,omitempty"`
+	IsDir  bool   `json:"isDir,omitempty"`
+	Bytes  int64  `json:"bytes,omitempty"`
+}
+
+// DryRunVerdict is the decision of one server policy on a previewed operation
+type DryRunVerdict struct {
+	Policy  string `json:"policy"`
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// DryRunResult describes what an operation would do, as returned for
+// requests sent with dry_run=true
+type DryRunResult struct {
+	Op        string          `json:"op"`
+	Path      string          `json:"path"`
+	Allowed   bool            `json:"allowed"`
+	Effects   []DryRunEffect  `json:"effects"`
+	Paths     int             `json:"paths"`
+	Bytes     int64           `json:"bytes"`
+	Truncated bool            `json:"truncated,omitempty"`
+	Verdicts  []DryRunVerdict `json:"verdicts,omitempty"`
+}
+
+// PreviewRemove reports what Remove (or RemoveAll, if recursive) would
+// delete without deleting anything
+func (c *Client) PreviewRemove(path string, recursive bool) (*DryRunResult, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("recursive", fmt.Sprintf("%t", recursive))
+	query.Set("dry_run", "true")
+
+	resp, err := c.doRequest(http.MethodDelete, "/files", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var result DryRunResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &result, nil
+}
+
+// ApprovalResult is the outcome of applying an approved request
+type ApprovalResult struct {
+	Status int    `json:"status"`
+	Body   string `json:"body,omitempty"`
+}
+
+// Approval is a mutation of a protected path held for approval
+type Approval struct {
+	ID        string            `json:"id"`
+	Identity  string            `json:"identity,omitempty"`
+	Method    string            `json:"method"`
+	Endpoint  string            `json:"endpoint"`
+	Query     string            `json:"query,omitempty"`
+	Header    map[string]string `json:"header,omitempty"`
+	Paths     []string          `json:"paths"`
+	Size      int64             `json:"size"`
+	Status    string            `json:"status"` // pending, approved, rejected or expired
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	DecidedBy string            `json:"decided_by,omitempty"`
+	DecidedAt *time.Time        `json:"decided_at,omitempty"`
+	Reason    string            `json:"reason,omitempty"`
+	Result    *ApprovalResult   `json:"result,omitempty"`
+}
+
+// PendingApprovalError is returned when the server held a mutation of a
+// protected path for approval instead of applying it (HTTP 202)
+type PendingApprovalError struct {
+	Approval *Approval
+}
+
+func (e *PendingApprovalError) Error() string {
+	return fmt.Sprintf("held for approval as %s", e.Approval.ID)
+}
+
+// ListApprovals lists held requests in status ("pending", "approved",
+// "rejected", "expired"), or all of them if status is empty
+func (c *Client) ListApprovals(status string) ([]Approval, error) {
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", status)
+	}
+
+	resp, err := c.doRequest(http.MethodGet, "/approvals", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Approvals []Approval `json:"approvals"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return result.Approvals, nil
+}
+
+// GetApproval returns the held request id
+func (c *Client) GetApproval(id string) (*Approval, error) {
+	return c.approvalRequest(http.MethodGet, "/approvals/"+url.PathEscape(id), "")
+}
+
+// Approve approves the held request id, which the server then applies.
+// The outcome is in the returned approval's Result.
+func (c *Client) Approve(id, reason string) (*Approval, error) {
+	return c.approvalRequest(http.MethodPost, "/approvals/"+url.PathEscape(id)+"/approve", reason)
+}
+
+// Reject rejects the held request id
+func (c *Client) Reject(id, reason string) (*Approval, error) {
+	return c.approvalRequest(http.MethodPost, "/approvals/"+url.PathEscape(id)+"/reject", reason)
+}
+
+// approvalRequest sends a request about one held request
+func (c *Client) approvalRequest(method, endpoint, reason string) (*Approval, error) {
+	var body io.Reader
+	if method == http.MethodPost {
+		data, err := json.Marshal(map[string]string{"reason": reason})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	resp, err := c.doRequest(method, endpoint, nil, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var approval Approval
+	if err := json.NewDecoder(resp.Body).Decode(&approval); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &approval, nil
+}
+
 // Read reads file content with optional offset and size
 // offset: starting position (0 means from beginning)
 // size: number of bytes to read (-1 means read all)
@@ -342,9 +593,35 @@ func (c *Client) Read(path string, offset int64, size int64) ([]byte, error) {
 		query.Set("size", fmt.Sprintf("%d", size))
 	}
 
-	resp, err := c.doRequest(http.MethodGet, "/files", query, nil)
+	if !c.verifyTransfers {
+		return c.read(query)
+	}
+	var err error
+	for attempt := 0; attempt <= maxChecksumRetries; attempt++ {
+		var data []byte
+		data, err = c.read(query)
+		if !errors.Is(err, ErrChecksumMismatch) {
+			return data, err
+		}
+	}
+	return nil, err
+}
+
+// read sends a GET /files request, verifying the body against its
+// checksum trailer when transfers are verified
+func (c *Client) read(query url.Values) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/files?"+query.Encode(), nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.verifyTransfers {
+		req.Header.Set(wantChecksumHeader, "sha256")
+	}
+
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -361,9 +638,22 @@ func (c *Client) Read(path string, offset int64, size int64) ([]byte, error) {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
+	// The trailer is only complete once the body has been read
+	if want := resp.Trailer.Get(contentSHA256Header); c.verifyTransfers && want != "" {
+		if got := sha256Hex(data); !strings.EqualFold(got, want) {
+			return nil, fmt.Errorf("%w: received %d bytes hashing to %s, the server sent %s", ErrChecksumMismatch, len(data), got, want)
+		}
+	}
+
 	return data, nil
 }
 
+// sha256Hex returns the hex SHA-256 digest of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 // Write writes data to a file, creating it if necessary
 // Automatically retries on network errors and timeouts (max 3 retries with exponential backoff)
 func (c *Client) Write(path string, data []byte) ([]byte, error) {
@@ -372,13 +662,34 @@ func (c *Client) Write(path string, data []byte) ([]byte, error) {
 
 // WriteWithRetry writes data to a file with configurable retry attempts
 func (c *Client) WriteWithRetry(path string, data []byte, maxRetries int) ([]byte, error) {
+	return c.writeWithRetry(path, data, EncodingBinary, maxRetries)
+}
+
+// WriteWithEncoding writes data to a file, sending the body in the given
+// content transfer encoding: EncodingBinary sends the bytes as they are,
+// EncodingBase64 base64-encodes them for proxies and gateways that are not
+// 8-bit clean. The server stores the same bytes either way.
+func (c *Client) WriteWithEncoding(path string, data []byte, encoding string) ([]byte, error) {
+	return c.writeWithRetry(path, data, encoding, 3)
+}
+
+func (c *Client) writeWithRetry(path string, data []byte, encoding string, maxRetries int) ([]byte, error) {
 	query := url.Values{}
 	query.Set("path", path)
 
+	body := data
+	if encoding == EncodingBase64 {
+		body = []byte(base64.StdEncoding.EncodeToString(data))
+	}
+	var checksum string
+	if c.verifyTransfers {
+		checksum = sha256Hex(data)
+	}
+
 	var lastErr error
 
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		resp, err := c.doRequest(http.MethodPut, "/files", query, bytes.NewReader(data))
+	for attempt, corrupted := 0, 0; attempt <= maxRetries; attempt++ {
+		resp, err := c.doWriteRequest(query, body, encoding, checksum)
 		if err != nil {
 			lastErr = err
 
@@ -399,6 +710,10 @@ func (c *Client) WriteWithRetry(path string, data []byte, maxRetries int) ([]byt
 
 		defer resp.Body.Close()
 
+		if resp.StatusCode == http.StatusAccepted {
+			return nil, c.handleErrorResponse(resp)
+		}
+
 		if resp.StatusCode != http.StatusOK {
 			var errResp ErrorResponse
 			if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
@@ -407,6 +722,19 @@ func (c *Client) WriteWithRetry(path string, data []byte, maxRetries int) ([]byt
 
 			lastErr = fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
 
+			// The server rejected a body corrupted in transit; resend it
+			// right away, without using up a retry
+			if resp.StatusCode == http.StatusUnprocessableEntity && checksum != "" {
+				lastErr = fmt.Errorf("%w: %s", ErrChecksumMismatch, errResp.Error)
+				if corrupted < maxChecksumRetries {
+					corrupted++
+					attempt--
+					fmt.Printf("⚠ Upload corrupted in transit, resending\n")
+					continue
+				}
+				return nil, lastErr
+			}
+
 			// Retry on server errors (5xx)
 			if resp.StatusCode >= 500 && resp.StatusCode < 600 && attempt < maxRetries {
 				waitTime := time.Duration(1<<uint(attempt)) * time.Second
@@ -438,6 +766,28 @@ func (c *Client) WriteWithRetry(path string, data []byte, maxRetries int) ([]byt
 	return nil, lastErr
 }
 
+// doWriteRequest sends a PUT /files request whose body is content in the
+// given content transfer encoding, with the SHA-256 of the content if
+// checksum is set
+func (c *Client) doWriteRequest(query url.Values, body []byte, encoding, checksum string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPut, c.baseURL+"/files?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Transfer-Encoding", encoding)
+	if checksum != "" {
+		req.Header.Set(contentSHA256Header, checksum)
+	}
+
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	return resp, nil
+}
+
 // isRetryableError checks if an error is retryable (network/timeout errors)
 func isRetryableError(err error) bool {
 	if err == nil {
@@ -466,7 +816,31 @@ func isRetryableError(err error) bool {
 func (c *Client) ReadDir(path string) ([]FileInfo, error) {
 	query := url.Values{}
 	query.Set("path", path)
+	return c.readDir(query)
+}
+
+// ReadDirNames lists only the names of the entries of a directory and
+// whether they are directories. Servers list these much faster than full
+// entries on large directories, e.g. without a HEAD request per S3 object.
+func (c *Client) ReadDirNames(path string) ([]FileInfo, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("names_only", "true")
+	return c.readDir(query)
+}
+
+// ReadDirPreview lists a directory with a Preview of up to n leading bytes
+// of each file (at most 4096), so it can be triaged without reading every
+// file. Files the server can't preview cheaply, such as binary files, have
+// none.
+func (c *Client) ReadDirPreview(path string, n int) ([]FileInfo, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("preview", strconv.Itoa(n))
+	return c.readDir(query)
+}
 
+func (c *Client) readDir(query url.Values) ([]FileInfo, error) {
 	resp, err := c.doRequest(http.MethodGet, "/directories", query, nil)
 	if err != nil {
 		return nil, err
@@ -497,6 +871,9 @@ func (c *Client) ReadDir(path string) ([]FileInfo, error) {
 			IsDir:     f.IsDir,
 			IsSymlink: f.IsSymlink(),
 			Meta:      f.Meta,
+			Checksum:  f.Checksum,
+			ExpiresAt: f.expiry(),
+			Preview:   f.Preview,
 		})
 	}
 
@@ -537,6 +914,8 @@ func (c *Client) Stat(path string) (*FileInfo, error) {
 		IsDir:     fileInfo.IsDir,
 		IsSymlink: fileInfo.IsSymlink(),
 		Meta:      fileInfo.Meta,
+		Checksum:  fileInfo.Checksum,
+		ExpiresAt: fileInfo.expiry(),
 	}, nil
 }
 
@@ -646,6 +1025,94 @@ func (c *Client) GetCapabilities() (*CapabilitiesResponse, error) {
 	return &caps, nil
 }
 
+// supportedAPIVersions are the versions of the server API this client
+// speaks, newest first
+var supportedAPIVersions = []string{"v2", "v1"}
+
+// APIVersionInfo describes one version of the server API
+type APIVersionInfo struct {
+	Version string `json:"version"`
+	Prefix  string `json:"prefix"`
+	Status  string `json:"status"` // "current", "supported" or "deprecated"
+	Sunset  string `json:"sunset,omitempty"`
+}
+
+// APIVersionsResponse lists the versions of the API a server speaks, and
+// its features
+type APIVersionsResponse struct {
+	Current       string           `json:"current"`
+	Versions      []APIVersionInfo `json:"versions"`
+	ServerVersion string           `json:"serverVersion"`
+	Features      []string         `json:"features"`
+}
+
+// rootURL returns the base URL without its API prefix
+func (c *Client) rootURL() string {
+	for _, version := range supportedAPIVersions {
+		if root, ok := strings.CutSuffix(c.baseURL, "/api/"+version); ok {
+			return root
+		}
+	}
+	return c.baseURL
+}
+
+// GetAPIVersions retrieves the versions of the API the server speaks
+func (c *Client) GetAPIVersions() (*APIVersionsResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, c.rootURL()+"/api/versions", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// Older servers only speak v1
+		if resp.StatusCode == http.StatusNotFound {
+			return &APIVersionsResponse{
+				Current:  "v1",
+				Versions: []APIVersionInfo{{Version: "v1", Prefix: "/api/v1", Status: "current"}},
+			}, nil
+		}
+		var errResp ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+	}
+
+	var versions APIVersionsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &versions, nil
+}
+
+// NegotiateAPIVersion switches the client to the newest version of the API
+// that both it and the server speak, and returns it. Under v2, requests
+// the caller abandons are cancelled on the server too. Call it before
+// sharing the client between goroutines.
+func (c *Client) NegotiateAPIVersion() (string, error) {
+	versions, err := c.GetAPIVersions()
+	if err != nil {
+		return "", err
+	}
+	offered := make(map[string]bool, len(versions.Versions))
+	for _, v := range versions.Versions {
+		offered[v.Version] = true
+	}
+	for _, version := range supportedAPIVersions {
+		if offered[version] {
+			c.baseURL = c.rootURL() + "/api/" + version
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("server speaks none of the API versions %v", supportedAPIVersions)
+}
+
 // ReadStream opens a streaming connection to read from a file.
 // Returns an io.ReadCloser that streams data from the server. The
 // caller is responsible for closing the reader.
@@ -674,6 +1141,7 @@ func (c *Client) ReadStream(path string) (io.ReadCloser, error) {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
+	c.authorize(req)
 	resp, err := streamClient.Do(req)
 	if err != nil {
 		cancel()
@@ -751,6 +1219,7 @@ func (c *Client) Grep(path, pattern string, recursive, caseInsensitive bool) (*G
 	}
 	req.Header.Set("Content-Type", "application/json")
 
+	c.authorize(req)
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute request: %w", err)
@@ -792,6 +1261,7 @@ func (c *Client) Digest(path, algorithm string) (*DigestResponse, error) {
 	}
 	req.Header.Set("Content-Type", "application/json")
 
+	c.authorize(req)
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute request: %w", err)
@@ -814,6 +1284,649 @@ func (c *Client) Digest(path, algorithm string) (*DigestResponse, error) {
 	return &digestResp, nil
 }
 
+// ChecksumResponse represents a checksum response
+type ChecksumResponse struct {
+	Path      string `json:"path"`
+	Algorithm string `json:"algorithm"`
+	Checksum  string `json:"checksum"`
+	Native    bool   `json:"native"`
+	Match     *bool  `json:"match,omitempty"`
+}
+
+// Checksum returns the content checksum of a file. Unlike Digest, it uses
+// hashes the backend already stores (e.g. S3 ETags) when available.
+// If expected is non-empty, the response's Match field reports whether it matches.
+func (c *Client) Checksum(path, algorithm, expected string) (*ChecksumResponse, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	if algorithm != "" {
+		query.Set("algorithm", algorithm)
+	}
+	if expected != "" {
+		query.Set("expected", expected)
+	}
+
+	resp, err := c.doRequest(http.MethodGet, "/files/checksum", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	var checksumResp ChecksumResponse
+	if err := json.NewDecoder(resp.Body).Decode(&checksumResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &checksumResp, nil
+}
+
+// WriteWithTTL writes data to a file that expires after ttl. Once expired the
+// file is invisible and is deleted by the server in the background.
+func (c *Client) WriteWithTTL(path string, data []byte, ttl time.Duration) ([]byte, error) {
+	query := url.Values{}
+	query.Set("path", path)
+
+	req, err := http.NewRequest(http.MethodPut, c.baseURL+"/files?"+query.Encode(), bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("X-AGFS-TTL", ttl.String())
+
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var successResp SuccessResponse
+	if err := json.NewDecoder(resp.Body).Decode(&successResp); err != nil {
+		return nil, fmt.Errorf("failed to decode success response: %w", err)
+	}
+	return []byte(successResp.Message), nil
+}
+
+// SetTTL makes an existing file expire after ttl. A ttl of 0 clears the expiry.
+func (c *Client) SetTTL(path string, ttl time.Duration) error {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("ttl", ttl.String())
+
+	resp, err := c.doRequest(http.MethodPut, "/files/ttl", query, nil)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return c.handleErrorResponse(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// GetKV returns the value stored under key in the key-value store kept in
+// directory dir
+func (c *Client) GetKV(dir, key string) ([]byte, error) {
+	query := url.Values{}
+	query.Set("path", dir)
+	query.Set("key", key)
+
+	resp, err := c.doRequest(http.MethodGet, "/kv", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	value, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return value, nil
+}
+
+// SetKV stores value under key in the key-value store kept in directory dir,
+// creating it if needed. A non-zero ttl makes the value expire.
+func (c *Client) SetKV(dir, key string, value []byte, ttl time.Duration) error {
+	query := url.Values{}
+	query.Set("path", dir)
+	query.Set("key", key)
+
+	req, err := http.NewRequest(http.MethodPut, c.baseURL+"/kv?"+query.Encode(), bytes.NewReader(value))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if ttl > 0 {
+		req.Header.Set("X-AGFS-TTL", ttl.String())
+	}
+
+	c.authorize(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return c.handleErrorResponse(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// DeleteKV removes key from the key-value store kept in directory dir
+func (c *Client) DeleteKV(dir, key string) error {
+	query := url.Values{}
+	query.Set("path", dir)
+	query.Set("key", key)
+
+	resp, err := c.doRequest(http.MethodDelete, "/kv", query, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return c.handleErrorResponse(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ListKV returns the keys of the key-value store kept in directory dir that
+// start with prefix, sorted
+func (c *Client) ListKV(dir, prefix string) ([]string, error) {
+	query := url.Values{}
+	query.Set("path", dir)
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+
+	resp, err := c.doRequest(http.MethodGet, "/kv", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Keys []string `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return list.Keys, nil
+}
+
+// Increment atomically adds delta to the counter file at path, creating it
+// if needed, and returns the new value
+func (c *Client) Increment(path string, delta int64) (int64, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("delta", strconv.FormatInt(delta, 10))
+
+	resp, err := c.doRequest(http.MethodPost, "/counter", query, nil)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var counter struct {
+		Value int64 `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&counter); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return counter.Value, nil
+}
+
+// AppendLog appends data as one record to the log file at path, creating it
+// if needed, and returns the offset of the record. Records appended by many
+// clients at once never interleave. source names the writer in the record
+// and timestamp records when it was appended.
+func (c *Client) AppendLog(path string, data []byte, source string, timestamp bool) (int64, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	if source != "" {
+		query.Set("source", source)
+	}
+	if timestamp {
+		query.Set("timestamp", "true")
+	}
+
+	resp, err := c.doRequest(http.MethodPost, "/log", query, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var appended struct {
+		Offset int64 `json:"offset"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&appended); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return appended.Offset, nil
+}
+
+// ReadLog returns up to limit records (0 for the server's maximum) of the
+// log file at path from offset, and the offset to read the next ones from
+func (c *Client) ReadLog(path string, offset int64, limit int) ([]LogRecord, int64, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("offset", strconv.FormatInt(offset, 10))
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	resp, err := c.doRequest(http.MethodGet, "/log", query, nil)
+	if err != nil {
+		return nil, offset, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, offset, c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var read struct {
+		Records    []LogRecord `json:"records"`
+		NextOffset int64       `json:"next_offset"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&read); err != nil {
+		return nil, offset, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return read.Records, read.NextOffset, nil
+}
+
+// SetContentType overrides the media type the server reports for a file in
+// Stat (Meta.Content["content_type"]) and serves it with. An empty
+// contentType clears the override so the type is detected again.
+func (c *Client) SetContentType(path, contentType string) error {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("type", contentType)
+
+	resp, err := c.doRequest(http.MethodPut, "/files/content-type", query, nil)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return c.handleErrorResponse(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// WalkOptions controls a recursive walk request
+type WalkOptions struct {
+	MaxDepth  int      // Maximum depth below the root (0 = unlimited)
+	Include   []string // Glob patterns an entry must match to be returned
+	Exclude   []string // Glob patterns that drop an entry (and its subtree)
+	FilesOnly bool     // Omit directories from the results
+}
+
+// WalkEntry is a single entry returned by Walk
+type WalkEntry struct {
+	Path  string
+	Depth int
+	Info  FileInfo
+}
+
+// walkLine is a single NDJSON line of a walk response (entry or summary)
+type walkLine struct {
+	FileInfoResponse
+	Type  string `json:"type,omitempty"`
+	Path  string `json:"path"`
+	Depth int    `json:"depth"`
+	Count int    `json:"count"`
+	Error string `json:"error,omitempty"`
+}
+
+// Walk lists the whole subtree under path in a single request
+func (c *Client) Walk(path string, opts WalkOptions) ([]WalkEntry, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	if opts.MaxDepth > 0 {
+		query.Set("max_depth", fmt.Sprintf("%d", opts.MaxDepth))
+	}
+	for _, pattern := range opts.Include {
+		query.Add("include", pattern)
+	}
+	for _, pattern := range opts.Exclude {
+		query.Add("exclude", pattern)
+	}
+	if opts.FilesOnly {
+		query.Set("files_only", "true")
+	}
+
+	resp, err := c.doRequest(http.MethodGet, "/walk", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var entries []WalkEntry
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var line walkLine
+		if err := decoder.Decode(&line); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("walk response ended without summary")
+			}
+			return nil, fmt.Errorf("failed to decode walk response: %w", err)
+		}
+		if line.Type == "summary" {
+			if line.Error != "" {
+				return entries, fmt.Errorf("walk incomplete: %s", line.Error)
+			}
+			return entries, nil
+		}
+		entries = append(entries, line.entry())
+	}
+}
+
+// entry converts a decoded walk or find line into a WalkEntry
+func (l *walkLine) entry() WalkEntry {
+	modTime, _ := time.Parse(time.RFC3339Nano, l.ModTime)
+	return WalkEntry{
+		Path:  l.Path,
+		Depth: l.Depth,
+		Info: FileInfo{
+			Name:      l.Name,
+			Size:      l.Size,
+			Mode:      l.Mode,
+			ModTime:   modTime,
+			IsDir:     l.IsDir,
+			IsSymlink: l.IsSymlink(),
+			Meta:      l.Meta,
+			Checksum:  l.Checksum,
+			ExpiresAt: l.expiry(),
+		},
+	}
+}
+
+// Archive streams the subtree under path as an archive in format ("tar",
+// "tar.gz" or "zip"; empty for tar.gz). Walk filters in opts apply, except
+// FilesOnly. The caller must close the returned reader.
+func (c *Client) Archive(path, format string, opts WalkOptions) (io.ReadCloser, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	if format != "" {
+		query.Set("format", format)
+	}
+	if opts.MaxDepth > 0 {
+		query.Set("max_depth", fmt.Sprintf("%d", opts.MaxDepth))
+	}
+	for _, pattern := range opts.Include {
+		query.Add("include", pattern)
+	}
+	for _, pattern := range opts.Exclude {
+		query.Add("exclude", pattern)
+	}
+
+	resp, err := c.doRequest(http.MethodGet, "/archive", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+	return resp.Body, nil
+}
+
+// FindOptions controls a find request
+type FindOptions struct {
+	Name     string // Glob matched against entry names, e.g. "*.log"
+	Type     string // "f" for files, "d" for directories, empty for both
+	MTime    string // "-1h" for modified within an hour, "+7d" for older than 7 days
+	MaxDepth int    // Maximum depth below the root (0 = unlimited)
+	Limit    int    // Maximum number of matches (0 = server default)
+}
+
+// FindResult is the result of Find
+type FindResult struct {
+	Matches   []WalkEntry
+	Truncated bool // More matches exist beyond the limit
+}
+
+// Find searches the subtree under path on the server
+func (c *Client) Find(path string, opts FindOptions) (*FindResult, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	if opts.Name != "" {
+		query.Set("name", opts.Name)
+	}
+	if opts.Type != "" {
+		query.Set("type", opts.Type)
+	}
+	if opts.MTime != "" {
+		query.Set("mtime", opts.MTime)
+	}
+	if opts.MaxDepth > 0 {
+		query.Set("max_depth", fmt.Sprintf("%d", opts.MaxDepth))
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", fmt.Sprintf("%d", opts.Limit))
+	}
+
+	resp, err := c.doRequest(http.MethodGet, "/find", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	var findResp struct {
+		Matches   []walkLine `json:"matches"`
+		Truncated bool       `json:"truncated"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&findResp); err != nil {
+		return nil, fmt.Errorf("failed to decode find response: %w", err)
+	}
+
+	result := &FindResult{Truncated: findResp.Truncated}
+	for i := range findResp.Matches {
+		result.Matches = append(result.Matches, findResp.Matches[i].entry())
+	}
+	return result, nil
+}
+
+// BatchOp represents a single operation in a batch request
+type BatchOp struct {
+	Op        string `json:"op"`                  // mkdir, write, rename, remove
+	Path      string `json:"path"`                // Target path
+	NewPath   string `json:"new_path,omitempty"`  // Destination path (rename)
+	Data      string `json:"data,omitempty"`      // File content (write)
+	Encoding  string `json:"encoding,omitempty"`  // "base64" for binary data
+	Mode      uint32 `json:"mode,omitempty"`      // Directory permissions (mkdir)
+	Recursive bool   `json:"recursive,omitempty"` // Remove directory contents (remove)
+}
+
+// BatchOpResult represents the outcome of one batch operation
+type BatchOpResult struct {
+	Index   int    `json:"index"`
+	Op      string `json:"op"`
+	Path    string `json:"path"`
+	OK      bool   `json:"ok"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BatchResponse represents the result of a batch request
+type BatchResponse struct {
+	Results    []BatchOpResult `json:"results"`
+	Succeeded  int             `json:"succeeded"`
+	Failed     int             `json:"failed"`
+	RolledBack bool            `json:"rolled_back,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// Batch executes ops in order in a single request. With atomic set, the
+// server rolls back applied operations if any operation fails; the response
+// is returned together with the error in that case.
+func (c *Client) Batch(ops []BatchOp, atomic bool) (*BatchResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"ops":    ops,
+		"atomic": atomic,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := c.doRequest(http.MethodPost, "/batch", nil, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var batchResp BatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&batchResp); err != nil {
+		return nil, fmt.Errorf("HTTP %d: failed to decode batch response", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if batchResp.Results == nil {
+			return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, batchResp.Error)
+		}
+		return &batchResp, fmt.Errorf("HTTP %d: %s", resp.StatusCode, batchResp.Error)
+	}
+
+	return &batchResp, nil
+}
+
+// FSQLResponse represents the result of an fsql query
+type FSQLResponse struct {
+	Columns   []string        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	RowCount  int             `json:"row_count"`
+	Truncated bool            `json:"truncated,omitempty"`
+	Duration  string          `json:"duration"`
+}
+
+// FSQL runs a SELECT across mounts. tables binds relation names to paths of
+// structured files (CSV, TSV, JSON, NDJSON), directories of them, or tables
+// such as /sqlfs2/<db>/<table>. maxRows <= 0 uses the server limit.
+func (c *Client) FSQL(query string, tables map[string]string, maxRows int) (*FSQLResponse, error) {
+	req := map[string]interface{}{
+		"query":  query,
+		"tables": tables,
+	}
+	if maxRows > 0 {
+		req["max_rows"] = maxRows
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := c.doRequest(http.MethodPost, "/fsql", nil, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	var fsqlResp FSQLResponse
+	if err := json.NewDecoder(resp.Body).Decode(&fsqlResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &fsqlResp, nil
+}
+
+// Snapshot describes a read-only snapshot of a mount
+type Snapshot struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateSnapshot snapshots the mount at path. The snapshot can be read
+// under <path>/.snapshots/<id>/.
+func (c *Client) CreateSnapshot(path string) (*Snapshot, error) {
+	query := url.Values{}
+	query.Set("path", path)
+
+	resp, err := c.doRequest(http.MethodPost, "/snapshots", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	var snap Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &snap, nil
+}
+
+// ListSnapshots lists the snapshots of the mount at path, oldest first
+func (c *Client) ListSnapshots(path string) ([]Snapshot, error) {
+	query := url.Values{}
+	query.Set("path", path)
+
+	resp, err := c.doRequest(http.MethodGet, "/snapshots", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	var listResp struct {
+		Snapshots []Snapshot `json:"snapshots"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return listResp.Snapshots, nil
+}
+
+// DeleteSnapshot discards a snapshot of the mount at path
+func (c *Client) DeleteSnapshot(path, id string) error {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("id", id)
+
+	resp, err := c.doRequest(http.MethodDelete, "/snapshots", query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return c.handleErrorResponse(resp)
+	}
+	return nil
+}
+
 // OpenHandle opens a file and returns a handle ID
 func (c *Client) OpenHandle(path string, flags OpenFlag, mode uint32) (int64, error) {
 	query := url.Values{}
@@ -916,6 +2029,7 @@ func (c *Client) ReadHandleStream(handleID int64) (io.ReadCloser, error) {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
+	c.authorize(req)
 	resp, err := streamClient.Do(req)
 	if err != nil {
 		cancel()
@@ -948,6 +2062,7 @@ func (c *Client) WriteHandle(handleID int64, data []byte, offset int64) (int, er
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
 
+	c.authorize(req)
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return 0, fmt.Errorf("write handle request failed: %w", err)
@@ -1085,6 +2200,8 @@ func (c *Client) StatHandle(handleID int64) (*FileInfo, error) {
 		IsDir:     fileInfo.IsDir,
 		IsSymlink: fileInfo.IsSymlink(),
 		Meta:      fileInfo.Meta,
+		Checksum:  fileInfo.Checksum,
+		ExpiresAt: fileInfo.expiry(),
 	}, nil
 }
 
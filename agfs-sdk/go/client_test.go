@@ -1,11 +1,20 @@
 package agfs
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestClient_Create(t *testing.T) {
@@ -31,6 +40,25 @@ func TestClient_Create(t *testing.T) {
 	}
 }
 
+func TestClient_Reserve(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/files" {
+			t.Errorf("expected POST /api/v1/files, got %s %s", r.Method, r.URL.Path)
+		}
+		if r.URL.Query().Get("path") != "/big.bin" || r.URL.Query().Get("size") != "1073741824" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(SuccessResponse{Message: "file created"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	if err := client.Reserve("/big.bin", 1<<30); err != nil {
+		t.Errorf("Reserve failed: %v", err)
+	}
+}
+
 func TestClient_Read(t *testing.T) {
 	expectedData := []byte("hello world")
 
@@ -81,6 +109,41 @@ func TestClient_Write(t *testing.T) {
 	}
 }
 
+func TestClient_WriteBinary(t *testing.T) {
+	// NUL bytes and invalid UTF-8 must reach the server unchanged
+	payload := []byte{0x00, 'a', 0xff, 0xfe, 0x00, 0xc3, 0x28, '\n'}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch encoding := r.Header.Get("Content-Transfer-Encoding"); encoding {
+		case EncodingBinary:
+		case EncodingBase64:
+			body, _ = base64.StdEncoding.DecodeString(string(body))
+		default:
+			t.Errorf("unexpected Content-Transfer-Encoding %q", encoding)
+		}
+		if !bytes.Equal(body, payload) {
+			t.Errorf("server received %q, want %q", body, payload)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/octet-stream" {
+			t.Errorf("Content-Type = %q, want application/octet-stream", ct)
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(SuccessResponse{Message: "OK"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	if _, err := client.Write("/test/blob", payload); err != nil {
+		t.Errorf("Write failed: %v", err)
+	}
+	for _, encoding := range []string{EncodingBinary, EncodingBase64} {
+		if _, err := client.WriteWithEncoding("/test/blob", payload, encoding); err != nil {
+			t.Errorf("WriteWithEncoding(%s) failed: %v", encoding, err)
+		}
+	}
+}
+
 func TestClient_Mkdir(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -104,6 +167,25 @@ func TestClient_Mkdir(t *testing.T) {
 	}
 }
 
+func TestClient_ReadDirNames(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/directories" || r.URL.Query().Get("names_only") != "true" {
+			t.Errorf("expected a names_only listing, got %s", r.URL)
+		}
+		w.Write([]byte(`{"files":[{"name":"docs","isDir":true},{"name":"a.txt","isDir":false}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	files, err := client.ReadDirNames("/bucket")
+	if err != nil {
+		t.Fatalf("ReadDirNames failed: %v", err)
+	}
+	if len(files) != 2 || files[0].Name != "docs" || !files[0].IsDir || files[1].Name != "a.txt" || files[1].IsDir {
+		t.Errorf("unexpected entries: %+v", files)
+	}
+}
+
 func TestClient_ErrorHandling(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
@@ -251,6 +333,11 @@ func TestNormalizeBaseURL(t *testing.T) {
 			input:    "http://workstation:8080",
 			expected: "http://workstation:8080/api/v1",
 		},
+		{
+			name:     "full URL with /api/v2",
+			input:    "http://localhost:8080/api/v2/",
+			expected: "http://localhost:8080/api/v2",
+		},
 	}
 
 	for _, tt := range tests {
@@ -262,3 +349,463 @@ func TestNormalizeBaseURL(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_NegotiateAPIVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/versions":
+			json.NewEncoder(w).Encode(APIVersionsResponse{
+				Current:  "v2",
+				Versions: []APIVersionInfo{{Version: "v1", Status: "deprecated"}, {Version: "v2", Status: "current"}},
+			})
+		case "/api/v2/directories":
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(SuccessResponse{Message: "created"})
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	version, err := client.NegotiateAPIVersion()
+	if err != nil || version != "v2" {
+		t.Fatalf("NegotiateAPIVersion() = %q, %v", version, err)
+	}
+	if err := client.Mkdir("/dir", 0755); err != nil {
+		t.Errorf("Mkdir() after negotiation error = %v", err)
+	}
+
+	// Servers without version discovery speak v1
+	old := httptest.NewServer(http.NotFoundHandler())
+	defer old.Close()
+	client = NewClient(old.URL + "/api/v1")
+	if version, err := client.NegotiateAPIVersion(); err != nil || version != "v1" || client.baseURL != old.URL+"/api/v1" {
+		t.Errorf("NegotiateAPIVersion() with an old server = %q, %v, base %q", version, err, client.baseURL)
+	}
+}
+
+func TestClient_Watch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/api/v1/watch" || q.Get("debounce") != "200ms" || q.Get("recursive") != "true" ||
+			len(q["include"]) != 2 || q.Get("types") != "write" || q.Get("since") != "41" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(`{"type":"heartbeat","path":"/data"}` + "\n"))
+		w.Write([]byte(`{"type":"write","path":"/data/a.txt","count":3,"cursor":42}` + "\n"))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	watcher, err := client.Watch("/data", WatchOptions{
+		Recursive: true,
+		Include:   []string{"**/*.md", "*.txt"},
+		Types:     []string{"write"},
+		Debounce:  200 * time.Millisecond,
+		Since:     41,
+	})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer watcher.Close()
+
+	var events []Event
+	for ev := range watcher.Events() {
+		events = append(events, ev)
+	}
+	if len(events) != 1 || events[0].Path != "/data/a.txt" || events[0].Count != 3 || events[0].Cursor != 42 {
+		t.Errorf("events = %+v, want one write without heartbeats", events)
+	}
+	if watcher.Err() == nil {
+		t.Error("expected an error after the stream ended")
+	}
+}
+
+func TestCache(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	events := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Query().Get("path")
+		mu.Lock()
+		requests[r.URL.Path+" "+p]++
+		mu.Unlock()
+		if r.URL.Path == "/api/v1/watch" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			for {
+				select {
+				case <-r.Context().Done():
+					return
+				case ev := <-events:
+					w.Write([]byte(ev + "\n"))
+					w.(http.Flusher).Flush()
+				}
+			}
+		}
+		cacheControl := map[string]string{
+			"/fixed.txt": "max-age=60",
+			"/queue":     "no-store",
+		}[p]
+		if cacheControl == "" {
+			cacheControl = "no-cache"
+		}
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("ETag", `"v1"`)
+		if r.URL.Path == "/api/v1/stat" {
+			json.NewEncoder(w).Encode(FileInfoResponse{Name: p, Size: 2})
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("v1"))
+	}))
+	defer server.Close()
+
+	cache, err := NewClient(server.URL).NewCache(CacheOptions{Watch: []string{"/watched"}})
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	defer cache.Close()
+
+	count := func(endpoint, p string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests["/api/v1/"+endpoint+" "+p]
+	}
+	for _, p := range []string{"/fixed.txt", "/plain.txt", "/queue", "/watched/a.txt"} {
+		for i := 0; i < 3; i++ {
+			if data, err := cache.Read(p); err != nil || string(data) != "v1" {
+				t.Fatalf("Read(%s) = %q, %v", p, data, err)
+			}
+		}
+	}
+	for p, want := range map[string]int{"/fixed.txt": 1, "/plain.txt": 3, "/queue": 3, "/watched/a.txt": 1} {
+		if got := count("files", p); got != want {
+			t.Errorf("%s fetched %d times, want %d", p, got, want)
+		}
+	}
+	if stats := cache.Stats(); stats.Hits != 4 || stats.Revalidations != 2 || stats.Misses != 6 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	cache.Stat("/watched/a.txt")
+	cache.Stat("/watched/a.txt")
+	if got := count("stat", "/watched/a.txt"); got != 1 {
+		t.Errorf("stat fetched %d times, want 1", got)
+	}
+
+	// A change reported by the watch drops the entries
+	events <- `{"type":"write","path":"/watched/a.txt"}`
+	deadline := time.Now().Add(2 * time.Second)
+	for cache.Stats().Invalidations < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("watch event did not invalidate the entries")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cache.Read("/watched/a.txt")
+	if got := count("files", "/watched/a.txt"); got != 2 {
+		t.Errorf("after invalidation fetched %d times, want 2", got)
+	}
+}
+
+func TestClient_VerifyTransfers(t *testing.T) {
+	var stored []byte
+	var puts, gets int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			puts++
+			body, _ := io.ReadAll(r.Body)
+			if puts == 1 {
+				body[0] ^= 0xff // Corrupted in transit
+			}
+			if r.Header.Get("X-AGFS-Content-SHA256") != sha256Hex(body) {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(ErrorResponse{Error: "checksum mismatch"})
+				return
+			}
+			stored = body
+			json.NewEncoder(w).Encode(SuccessResponse{Message: "written"})
+		case http.MethodGet:
+			gets++
+			if r.Header.Get("X-AGFS-Want-Checksum") != "sha256" {
+				t.Errorf("read did not ask for a checksum")
+			}
+			w.Header().Set("Trailer", "X-AGFS-Content-SHA256")
+			data := append([]byte(nil), stored...)
+			w.Header().Set("X-AGFS-Content-SHA256", sha256Hex(data))
+			if gets <= 2 {
+				data[len(data)-1] ^= 0xff
+			}
+			w.Write(data)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.SetVerifyTransfers(true)
+	if _, err := client.Write("/artifact.bin", []byte("agent artifact")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if puts != 2 || string(stored) != "agent artifact" {
+		t.Errorf("after %d uploads stored %q", puts, stored)
+	}
+
+	data, err := client.Read("/artifact.bin", 0, -1)
+	if err != nil || string(data) != "agent artifact" {
+		t.Fatalf("Read = %q, %v", data, err)
+	}
+	if gets != 3 {
+		t.Errorf("Read took %d downloads, want 3", gets)
+	}
+
+	gets = -10 // Every download is corrupted
+	if _, err := client.Read("/artifact.bin", 0, -1); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Read error = %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestClient_Download(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100000)
+	var gets int
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/files/checksum" {
+			json.NewEncoder(w).Encode(ChecksumResponse{Algorithm: "sha256", Checksum: sha256Hex(content)})
+			return
+		}
+		if r.URL.Path == "/api/v1/stat" {
+			json.NewEncoder(w).Encode(FileInfoResponse{Name: "weights.bin", Size: int64(len(content))})
+			return
+		}
+		gets++
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		if gets == 1 {
+			// Drop the connection a third of the way through
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/3])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	var buf bytes.Buffer
+	var done, total int64
+	n, err := client.Download("/models/weights.bin", &buf, DownloadOptions{
+		Progress: func(d, t int64) { done, total = d, t },
+	})
+	if err != nil || n != int64(len(content)) || !bytes.Equal(buf.Bytes(), content) {
+		t.Fatalf("Download = %d, %v", n, err)
+	}
+	if gets != 2 || ranges[1] != "bytes="+strconv.Itoa(len(content)/3)+"-" {
+		t.Errorf("requests = %d with ranges %q, want a resume from %d", gets, ranges, len(content)/3)
+	}
+	if done != int64(len(content)) || total != int64(len(content)) {
+		t.Errorf("progress = %d/%d", done, total)
+	}
+
+	// A wrong digest fails the download
+	_, err = client.Download("/models/weights.bin", io.Discard, DownloadOptions{SHA256: sha256Hex([]byte("other"))})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Download with a wrong digest = %v, want ErrChecksumMismatch", err)
+	}
+
+	// A partial local file is completed
+	local := t.TempDir() + "/weights.bin"
+	if err := os.WriteFile(local, content[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	ranges = nil
+	if err := client.DownloadFile("/models/weights.bin", local, DownloadOptions{}); err != nil {
+		t.Fatalf("DownloadFile failed: %v", err)
+	}
+	if data, _ := os.ReadFile(local); !bytes.Equal(data, content) {
+		t.Errorf("DownloadFile wrote %d bytes", len(data))
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=1000-" {
+		t.Errorf("DownloadFile ranges = %q", ranges)
+	}
+}
+
+func TestClient_ReadDirPreview(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("preview") != "64" {
+			t.Errorf("expected preview=64, got %s", r.URL)
+		}
+		w.Write([]byte(`{"files":[{"name":"notes.md","size":200,"preview":{"title":"Plans","text":"# Plans","truncated":true}},{"name":"img","size":3}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	files, err := client.ReadDirPreview("/memfs", 64)
+	if err != nil {
+		t.Fatalf("ReadDirPreview failed: %v", err)
+	}
+	if len(files) != 2 || files[0].Preview == nil || files[0].Preview.Title != "Plans" || !files[0].Preview.Truncated || files[1].Preview != nil {
+		t.Errorf("unexpected entries: %+v", files)
+	}
+}
+
+func TestClient_UploadFrom(t *testing.T) {
+	var mu sync.Mutex
+	parts := map[int64][]byte{}
+	var committed []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/uploads":
+			if r.URL.Query().Get("part_size") != "4" {
+				t.Errorf("expected part_size=4, got %s", r.URL)
+			}
+			w.Write([]byte(`{"id":"u1","path":"/memfs/f","part_size":4}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/api/v1/uploads/u1":
+			offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+			data, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			parts[offset] = data
+			mu.Unlock()
+			w.Write([]byte(`{}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/uploads/u1/commit":
+			mu.Lock()
+			for offset := int64(0); parts[offset] != nil; offset += 4 {
+				committed = append(committed, parts[offset]...)
+			}
+			mu.Unlock()
+			w.Write([]byte(`{"path":"/memfs/f","size":` + strconv.Itoa(len(committed)) + `}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	content := []byte("hello parallel world")
+	var progress int64
+	err := client.UploadFrom("/memfs/f", bytes.NewReader(content), int64(len(content)), UploadOptions{
+		PartSize:    4,
+		Concurrency: 3,
+		Progress:    func(done, total int64) { progress = done },
+	})
+	if err != nil {
+		t.Fatalf("UploadFrom failed: %v", err)
+	}
+	if string(committed) != string(content) || progress != int64(len(content)) {
+		t.Errorf("committed %q with progress %d, want %q", committed, progress, content)
+	}
+}
+
+func TestClient_Helpers(t *testing.T) {
+	var mu sync.Mutex
+	files := map[string][]byte{}
+	dirs := map[string]bool{"/": true}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		p := r.URL.Query().Get("path")
+		notFound := func() {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+		switch {
+		case r.URL.Path == "/api/v1/stat":
+			if data, ok := files[p]; ok {
+				json.NewEncoder(w).Encode(FileInfoResponse{Name: path.Base(p), Size: int64(len(data))})
+			} else if dirs[p] {
+				json.NewEncoder(w).Encode(FileInfoResponse{Name: path.Base(p), IsDir: true})
+			} else {
+				notFound()
+			}
+		case r.URL.Path == "/api/v1/directories" && r.Method == http.MethodPost:
+			if !dirs[path.Dir(p)] {
+				notFound()
+				return
+			}
+			dirs[p] = true
+			w.Write([]byte(`{}`))
+		case r.URL.Path == "/api/v1/directories":
+			var list ListResponse
+			for name := range dirs {
+				if name != "/" && path.Dir(name) == p {
+					list.Files = append(list.Files, FileInfoResponse{Name: path.Base(name), IsDir: true})
+				}
+			}
+			for name := range files {
+				if path.Dir(name) == p {
+					list.Files = append(list.Files, FileInfoResponse{Name: path.Base(name)})
+				}
+			}
+			json.NewEncoder(w).Encode(list)
+		case r.URL.Path == "/api/v1/files" && r.Method == http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			if r.URL.Query().Get("flags") == "append,create" {
+				data = append(files[p], data...)
+			}
+			files[p] = data
+			json.NewEncoder(w).Encode(SuccessResponse{Message: "ok"})
+		case r.URL.Path == "/api/v1/files":
+			if data, ok := files[p]; ok {
+				w.Write(data)
+			} else {
+				notFound()
+			}
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+	}))
+	defer server.Close()
+	client := NewClient(server.URL)
+
+	if err := client.EnsureDir("/a/b/c"); err != nil {
+		t.Fatalf("EnsureDir failed: %v", err)
+	}
+	if err := client.EnsureDir("/a/b"); err != nil || !dirs["/a/b/c"] {
+		t.Fatalf("EnsureDir of an existing directory: %v, dirs %v", err, dirs)
+	}
+
+	type state struct {
+		Step  int      `json:"step"`
+		Notes []string `json:"notes"`
+	}
+	if err := client.WriteJSON("/a/state.json", state{Step: 2, Notes: []string{"x"}}); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	var got state
+	if err := client.ReadJSON("/a/state.json", &got); err != nil || got.Step != 2 || len(got.Notes) != 1 {
+		t.Errorf("ReadJSON = %+v, %v", got, err)
+	}
+	if err := client.EnsureDir("/a/state.json"); err == nil {
+		t.Error("EnsureDir succeeded on a file")
+	}
+
+	client.WriteLines("/a/b/log", []string{"one"})
+	client.AppendLines("/a/b/log", []string{"two", "three"})
+	if lines, err := client.ReadLines("/a/b/log"); err != nil || len(lines) != 3 || lines[2] != "three" {
+		t.Errorf("ReadLines = %q, %v", lines, err)
+	}
+
+	var walked []string
+	err := client.WalkDir("/a", func(p string, info FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, p)
+		if p == "/a/b/c" {
+			return SkipDir
+		}
+		return nil
+	})
+	if want := "/a /a/b /a/b/c /a/b/log /a/state.json"; err != nil || strings.Join(walked, " ") != want {
+		t.Errorf("WalkDir visited %q, %v, want %q", walked, err, want)
+	}
+}
@@ -0,0 +1,257 @@
+package agfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrChangedDuringDownload is returned when a file changed between the
+// attempts of a resumed download, so its parts would not fit together
+var ErrChangedDuringDownload = errors.New("file changed during download")
+
+// defaultDownloadRetries is the number of resumes of an interrupted download
+const defaultDownloadRetries = 5
+
+// DownloadOptions configures Download and DownloadFile
+type DownloadOptions struct {
+	// MaxRetries is how many times an interrupted transfer is resumed;
+	// 0 means 5, and a negative value disables resuming
+	MaxRetries int
+	// SHA256 is the expected hex digest of the whole file. When empty, the
+	// server's checksum of the file is used instead.
+	SHA256 string
+	// SkipVerify skips the final digest check
+	SkipVerify bool
+	// Progress, if set, is called as data arrives with the bytes received
+	// so far, including any resumed from, and the file size (-1 if unknown)
+	Progress func(done, total int64)
+}
+
+// Download copies a file to w. An interrupted transfer resumes where it
+// stopped with a range request instead of starting over, provided the file
+// has not changed in between; the whole file is then checked against its
+// SHA-256. This makes multi-GB files, such as models and datasets served by
+// httpfs or s3fs, practical to fetch over unreliable links. It returns the
+// number of bytes written.
+func (c *Client) Download(path string, w io.Writer, opts DownloadOptions) (int64, error) {
+	return c.download(path, w, 0, sha256.New(), opts)
+}
+
+// DownloadFile downloads a file to localPath. If localPath already holds
+// the start of the file, e.g. from a download that was killed, only the
+// rest is fetched.
+func (c *Client) DownloadFile(path, localPath string, opts DownloadOptions) error {
+	f, err := os.OpenFile(localPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The digest covers the bytes already there
+	h := sha256.New()
+	offset, err := io.Copy(h, f)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", localPath, err)
+	}
+	if offset > 0 {
+		if info, err := c.Stat(path); err == nil && info.Size < offset {
+			// Longer than the file; not a partial download of it
+			if err := f.Truncate(0); err != nil {
+				return err
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			offset, h = 0, sha256.New()
+		}
+	}
+
+	if _, err := c.download(path, f, offset, h, opts); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// download fetches a file from offset into w, adding what it writes to h,
+// and verifies the digest of the whole file
+func (c *Client) download(path string, w io.Writer, offset int64, h hash.Hash, opts DownloadOptions) (int64, error) {
+	maxRetries := opts.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultDownloadRetries
+	} else if maxRetries < 0 {
+		maxRetries = 0
+	}
+
+	start := offset
+	total := int64(-1)
+	var etag string
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			waitTime := time.Duration(1<<uint(attempt-1)) * time.Second // 1s, 2s, 4s
+			if waitTime > 16*time.Second {
+				waitTime = 16 * time.Second
+			}
+			fmt.Printf("⚠ Download interrupted at %d bytes (attempt %d/%d): %v\n", offset, attempt, maxRetries+1, lastErr)
+			fmt.Printf("  Resuming in %v...\n", waitTime)
+			time.Sleep(waitTime)
+		}
+
+		n, err := c.downloadRange(path, w, h, offset, &etag, &total, opts.Progress)
+		offset += n
+		if err == nil {
+			break
+		}
+		if errors.Is(err, ErrChangedDuringDownload) || !isResumable(err) {
+			return offset - start, err
+		}
+		lastErr = err
+		if attempt == maxRetries {
+			fmt.Printf("✗ Download failed after %d attempts\n", maxRetries+1)
+			return offset - start, err
+		}
+	}
+
+	if opts.SkipVerify {
+		return offset - start, nil
+	}
+	want := opts.SHA256
+	if want == "" {
+		sum, err := c.Checksum(path, "sha256", "")
+		if err != nil {
+			return offset - start, fmt.Errorf("failed to get the checksum of %s: %w", path, err)
+		}
+		want = sum.Checksum
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, want) {
+		return offset - start, fmt.Errorf("%w: downloaded %d bytes hashing to %s, expected %s", ErrChecksumMismatch, offset, got, want)
+	}
+	return offset - start, nil
+}
+
+// downloadRange sends one GET for the file from offset, copying the body to
+// w and h. etag and total are learnt from the first response, and later
+// ones must match them.
+func (c *Client) downloadRange(path string, w io.Writer, h hash.Hash, offset int64, etag *string, total *int64, progress func(done, total int64)) (int64, error) {
+	query := url.Values{}
+	query.Set("path", path)
+
+	// No overall request timeout — per-chunk inactivity is bounded by
+	// the progressReader wrapper below.
+	streamClient := &http.Client{Timeout: 0, Transport: c.httpClient.Transport}
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/files?"+query.Encode(), nil)
+	if err != nil {
+		cancel()
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if *etag != "" {
+			req.Header.Set("If-Range", *etag)
+		}
+	}
+
+	c.authorize(req)
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		cancel()
+		return 0, fmt.Errorf("failed to execute request: %w", err)
+	}
+	body := newProgressReader(resp.Body, cancel, c.streamingProgressTimeout)
+	defer body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if offset > 0 {
+			// The server sent the whole file: either it changed, or it
+			// does not serve ranges of it and the start must be skipped
+			if *etag != "" && resp.Header.Get("ETag") != *etag {
+				return 0, fmt.Errorf("%w: %s", ErrChangedDuringDownload, path)
+			}
+			if _, err := io.CopyN(io.Discard, body, offset); err != nil {
+				return 0, fmt.Errorf("failed to skip to offset %d: %w", offset, err)
+			}
+		}
+		if resp.ContentLength >= 0 {
+			*total = resp.ContentLength
+		}
+	case http.StatusPartialContent:
+		if _, size, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+			if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+				*total = n
+			}
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Nothing is left past offset, unless the file shrank
+		if _, size, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok && size == strconv.FormatInt(offset, 10) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("%w: %s", ErrChangedDuringDownload, path)
+	default:
+		return 0, c.handleErrorResponse(resp)
+	}
+	if *etag == "" {
+		*etag = resp.Header.Get("ETag")
+	}
+
+	buf := make([]byte, 256*1024)
+	var n int64
+	for {
+		read, err := body.Read(buf)
+		if read > 0 {
+			if _, werr := w.Write(buf[:read]); werr != nil {
+				return n, werr
+			}
+			h.Write(buf[:read])
+			n += int64(read)
+			if progress != nil {
+				progress(offset+n, *total)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, &interruptedError{err}
+		}
+	}
+	if *total >= 0 && offset+n < *total {
+		return n, &interruptedError{io.ErrUnexpectedEOF}
+	}
+	return n, nil
+}
+
+// interruptedError is a transfer that broke off part way
+type interruptedError struct {
+	err error
+}
+
+func (e *interruptedError) Error() string {
+	return "transfer interrupted: " + e.err.Error()
+}
+
+func (e *interruptedError) Unwrap() error {
+	return e.err
+}
+
+// isResumable reports whether a download may be resumed after err
+func isResumable(err error) bool {
+	var interrupted *interruptedError
+	if errors.As(err, &interrupted) || isRetryableError(err) {
+		return true
+	}
+	// Retry on server errors (5xx)
+	return strings.HasPrefix(err.Error(), "HTTP 5")
+}
@@ -0,0 +1,168 @@
+package agfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// SkipDir, returned by a WalkDirFunc for a directory, skips its contents.
+// Returned for a file, it skips the rest of the file's directory.
+var SkipDir = fs.SkipDir
+
+// SkipAll, returned by a WalkDirFunc, stops the walk without an error
+var SkipAll = fs.SkipAll
+
+// WalkDirFunc is called by WalkDir for each file and directory. err is set
+// when the entry at p couldn't be listed or stat'ed; info is then empty, or
+// the directory's info if its entries couldn't be listed.
+type WalkDirFunc func(p string, info FileInfo, err error) error
+
+// WriteJSON writes v to path as indented JSON, replacing the file
+func (c *Client) WriteJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	_, err = c.Write(path, append(data, '\n'))
+	return err
+}
+
+// ReadJSON reads the JSON file at path into v
+func (c *Client) ReadJSON(path string, v interface{}) error {
+	data, err := c.Read(path, 0, -1)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// WriteLines writes lines to path, each ending with a newline, replacing
+// the file
+func (c *Client) WriteLines(path string, lines []string) error {
+	_, err := c.Write(path, joinLines(lines))
+	return err
+}
+
+// AppendLines appends lines to path, each ending with a newline, creating
+// the file if needed. Appends are not retried, so a failed call may or may
+// not have appended the lines.
+func (c *Client) AppendLines(path string, lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("flags", "append,create")
+
+	data := joinLines(lines)
+	var checksum string
+	if c.verifyTransfers {
+		checksum = sha256Hex(data)
+	}
+	resp, err := c.doWriteRequest(query, data, EncodingBinary, checksum)
+	if err != nil {
+		return err
+	}
+	return c.handleErrorResponse(resp)
+}
+
+// ReadLines reads the file at path as lines, without their newlines
+func (c *Client) ReadLines(path string) ([]string, error) {
+	data, err := c.Read(path, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
+
+func joinLines(lines []string) []byte {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// EnsureDir creates the directory at p and any missing parents, like
+// mkdir -p. It succeeds if the directory already exists.
+func (c *Client) EnsureDir(p string) error {
+	p = path.Clean("/" + p)
+	if info, err := c.Stat(p); err == nil {
+		if !info.IsDir {
+			return fmt.Errorf("%s exists and is not a directory", p)
+		}
+		return nil
+	}
+	if parent := path.Dir(p); parent != p {
+		if err := c.EnsureDir(parent); err != nil {
+			return err
+		}
+	}
+	if err := c.Mkdir(p, 0755); err != nil {
+		// Another client may have created it meanwhile
+		if info, statErr := c.Stat(p); statErr == nil && info.IsDir {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// WalkDir walks the tree rooted at root, calling fn for root and then for
+// each file and directory below it, in lexical order, listing one
+// directory at a time so that fn can prune the walk with SkipDir. Use Walk
+// to list a whole subtree in a single request instead.
+func (c *Client) WalkDir(root string, fn WalkDirFunc) error {
+	info, err := c.Stat(root)
+	if err != nil {
+		err = fn(root, FileInfo{}, err)
+	} else {
+		err = c.walkDir(root, *info, fn)
+	}
+	if err == SkipDir || err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) walkDir(p string, info FileInfo, fn WalkDirFunc) error {
+	// Symbolic links are reported but not followed
+	isDir := info.IsDir && !info.IsSymlink
+	if err := fn(p, info, nil); err != nil || !isDir {
+		if err == SkipDir && isDir {
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := c.ReadDir(p)
+	if err != nil {
+		if err := fn(p, info, err); err != SkipDir {
+			return err
+		}
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	for _, entry := range entries {
+		if err := c.walkDir(path.Join(p, entry.Name), entry, fn); err != nil {
+			if err == SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package agfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLeaseHeld is returned by AcquireLease when another holder has the lock
+var ErrLeaseHeld = errors.New("lease held by another holder")
+
+// Lease is a lockfs lease kept alive by renewing it in the background
+type Lease struct {
+	client *Client
+	lock   string
+	holder string
+	ttl    time.Duration
+	token  uint64
+
+	lost     chan struct{}
+	lostOnce sync.Once
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// AcquireLease takes the lease on the lockfs lock at lockPath (e.g.
+// "/locks/leader") for holder and renews it every ttl/3 until Release is
+// called. It returns ErrLeaseHeld if someone else holds the lock.
+func (c *Client) AcquireLease(lockPath, holder string, ttl time.Duration) (*Lease, error) {
+	if ttl < 3*time.Second {
+		return nil, fmt.Errorf("lease TTL must be at least 3s")
+	}
+	if err := c.writeLeaseControl(lockPath, "acquire", holder, ttl); err != nil {
+		return nil, err
+	}
+	info, err := c.LeaseHolder(lockPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Holder != holder {
+		return nil, ErrLeaseHeld
+	}
+
+	l := &Lease{
+		client: c,
+		lock:   lockPath,
+		holder: holder,
+		ttl:    ttl,
+		token:  info.Token,
+		lost:   make(chan struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go l.renew()
+	return l, nil
+}
+
+// LeaseHolder returns the current lease of the lockfs lock at lockPath
+func (c *Client) LeaseHolder(lockPath string) (*LeaseInfo, error) {
+	data, err := c.Read(path.Join(lockPath, "holder"), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	var info LeaseInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode lease: %w", err)
+	}
+	return &info, nil
+}
+
+// Token returns the fencing token of the lease
+func (l *Lease) Token() uint64 {
+	return l.token
+}
+
+// Lost is closed when the lease could not be renewed before it expired, or
+// another holder took it. The holder must stop acting on the lease then.
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release stops renewing and gives up the lease
+func (l *Lease) Release() error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	<-l.done
+	select {
+	case <-l.lost:
+		return nil
+	default:
+	}
+	l.markLost()
+	return l.client.writeLeaseControl(l.lock, "release", l.holder, 0)
+}
+
+func (l *Lease) markLost() {
+	l.lostOnce.Do(func() { close(l.lost) })
+}
+
+// renew extends the lease every ttl/3. Failed renewals are retried until
+// the lease would have expired.
+func (l *Lease) renew() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		attempt := time.Now()
+		err := l.client.writeLeaseControl(l.lock, "acquire", l.holder, l.ttl)
+		if err == nil {
+			var info *LeaseInfo
+			if info, err = l.client.LeaseHolder(l.lock); err == nil && info.Token != l.token {
+				// The lock changed hands, e.g. the server restarted
+				err = ErrLeaseHeld
+			}
+		}
+		switch {
+		case err == nil:
+			renewed = attempt
+		case errors.Is(err, ErrLeaseHeld) || time.Since(renewed) >= l.ttl:
+			l.markLost()
+			return
+		}
+	}
+}
+
+// writeLeaseControl writes an acquire or release request for holder
+func (c *Client) writeLeaseControl(lockPath, file, holder string, ttl time.Duration) error {
+	if holder == "" || strings.ContainsAny(holder, " \t\n") {
+		return fmt.Errorf("holder must be non-empty and contain no whitespace")
+	}
+	body := holder
+	if ttl > 0 {
+		body = fmt.Sprintf("%s %ds", holder, int64(ttl/time.Second))
+	}
+
+	query := url.Values{}
+	query.Set("path", path.Join(lockPath, file))
+	resp, err := c.doRequest(http.MethodPut, "/files", query, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusConflict {
+		resp.Body.Close()
+		return ErrLeaseHeld
+	}
+	if resp.StatusCode != http.StatusOK {
+		return c.handleErrorResponse(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
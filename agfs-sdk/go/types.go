@@ -16,8 +16,18 @@ type FileInfo struct {
 	Mode      uint32
 	ModTime   time.Time
 	IsDir     bool
-	IsSymlink bool     // True if this is a symbolic link
-	Meta      MetaData // Structured metadata for additional information
+	IsSymlink bool      // True if this is a symbolic link
+	Meta      MetaData  // Structured metadata for additional information
+	Checksum  string    // "<algorithm>:<hex>" content hash, if the backend provides one
+	ExpiresAt time.Time // When the file expires; zero if it has no TTL
+	Preview   *Preview  // Leading content of the file; only set by ReadDirPreview
+}
+
+// Preview summarizes the content of a file in a directory listing
+type Preview struct {
+	Title     string `json:"title,omitempty"`     // Heading or title, when the server knows one
+	Text      string `json:"text,omitempty"`      // Leading text of the file
+	Truncated bool   `json:"truncated,omitempty"` // The file has more than Text
 }
 
 // OpenFlag represents file open flags
@@ -34,6 +44,12 @@ const (
 	OpenFlagSync      OpenFlag = 1052672
 )
 
+// Content transfer encodings of a write body (see WriteWithEncoding)
+const (
+	EncodingBinary = "binary" // Bytes as they are
+	EncodingBase64 = "base64" // Base64-encoded bytes
+)
+
 // HandleInfo represents an open file handle
 type HandleInfo struct {
 	ID    int64    `json:"id"`
@@ -45,3 +61,60 @@ type HandleInfo struct {
 type HandleResponse struct {
 	HandleID int64 `json:"handle_id"`
 }
+
+// LeaseInfo describes who holds a lockfs lock. Holder is empty when the lock
+// is free; Token increases every time the lock changes hands.
+type LeaseInfo struct {
+	Name       string     `json:"name"`
+	Holder     string     `json:"holder"`
+	Token      uint64     `json:"token"`
+	AcquiredAt *time.Time `json:"acquired_at,omitempty"`
+	RenewedAt  *time.Time `json:"renewed_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// LogRecord is a record of a log file. Data that is not UTF-8 is sent
+// base64-encoded, with Encoding "base64".
+type LogRecord struct {
+	Offset   int64  `json:"offset"`
+	Time     string `json:"time,omitempty"` // RFC 3339, if timestamped
+	Source   string `json:"source,omitempty"`
+	Data     string `json:"data"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Event describes a change reported by Watch. Batch events carry the events
+// of one directory in Events; debounced events count coalesced changes.
+type Event struct {
+	Type    string    `json:"type"` // create, write, mkdir, remove, rename, chmod, batch, overflow
+	Path    string    `json:"path"`
+	OldPath string    `json:"old_path,omitempty"` // Source of a rename
+	Time    time.Time `json:"time"`
+	Count   int       `json:"count,omitempty"`
+	Events  []Event   `json:"events,omitempty"`
+	// Cursor is the point to resume from (WatchOptions.Since) once this
+	// event has been handled
+	Cursor uint64 `json:"cursor,omitempty"`
+}
+
+// WatchOptions controls server-side filtering and coalescing of watch events
+type WatchOptions struct {
+	// Recursive watches a directory's whole subtree instead of its direct entries
+	Recursive bool
+	// Include keeps only events whose path relative to the watched path, or
+	// base name, matches a glob; "**" matches any number of directories
+	Include []string
+	// Exclude drops events matching any of these globs
+	Exclude []string
+	// Types keeps only these event types (create, write, mkdir, remove,
+	// rename, chmod); empty means all
+	Types []string
+	// Debounce coalesces rapid writes to the same file into one event
+	Debounce time.Duration
+	// Batch groups events into one batch event per directory per window
+	Batch time.Duration
+	// Since resumes after the Cursor of an event received earlier: the
+	// changes since then that the server's event history retained are sent
+	// first, preceded by an overflow event if some were not retained
+	Since uint64
+}
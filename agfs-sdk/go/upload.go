@@ -0,0 +1,210 @@
+package agfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultUploadConcurrency is the number of parts UploadFrom writes at once
+const defaultUploadConcurrency = 4
+
+// Upload is a file being assembled on the server from parts
+type Upload struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	PartSize  int64     `json:"part_size"`
+	Parts     []int     `json:"parts"` // Indexes of the parts written, in order
+	Size      int64     `json:"size"`  // Bytes written
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UploadOptions configures UploadFrom
+type UploadOptions struct {
+	// PartSize is the part size in bytes; 0 means the server's default
+	PartSize int64
+	// Concurrency is the number of parts written at once; 0 means 4
+	Concurrency int
+	// Progress, if set, is called as parts are written with the bytes
+	// written so far and the file size
+	Progress func(done, total int64)
+}
+
+// BeginUpload starts assembling the file at path from parts of partSize
+// bytes (0 for the server's default). The parts are written with
+// WriteUploadPart, concurrently and in any order, and the file appears,
+// whole, with CommitUpload.
+func (c *Client) BeginUpload(path string, partSize int64) (*Upload, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	if partSize > 0 {
+		query.Set("part_size", strconv.FormatInt(partSize, 10))
+	}
+
+	resp, err := c.doRequest(http.MethodPost, "/uploads", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	var upload Upload
+	if err := json.NewDecoder(resp.Body).Decode(&upload); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &upload, nil
+}
+
+// WriteUploadPart writes the part of an upload at offset, a multiple of its
+// part size. Every part but the last must be a whole part long.
+func (c *Client) WriteUploadPart(id string, offset int64, data []byte) error {
+	query := url.Values{}
+	query.Set("offset", strconv.FormatInt(offset, 10))
+
+	resp, err := c.doRequest(http.MethodPut, "/uploads/"+url.PathEscape(id), query, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return c.handleErrorResponse(resp)
+	}
+	return nil
+}
+
+// GetUpload returns the state of an upload
+func (c *Client) GetUpload(id string) (*Upload, error) {
+	resp, err := c.doRequest(http.MethodGet, "/uploads/"+url.PathEscape(id), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	var upload Upload
+	if err := json.NewDecoder(resp.Body).Decode(&upload); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &upload, nil
+}
+
+// CommitUpload replaces the file with the parts of an upload, and returns
+// its size. If parts are missing the upload stays open, so they can be
+// written and the commit retried.
+func (c *Client) CommitUpload(id string) (int64, error) {
+	resp, err := c.doRequest(http.MethodPost, "/uploads/"+url.PathEscape(id)+"/commit", nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, c.handleErrorResponse(resp)
+	}
+
+	var commitResp struct {
+		Size int64 `json:"size"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&commitResp); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return commitResp.Size, nil
+}
+
+// AbortUpload discards an upload and its parts
+func (c *Client) AbortUpload(id string) error {
+	resp, err := c.doRequest(http.MethodDelete, "/uploads/"+url.PathEscape(id), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return c.handleErrorResponse(resp)
+	}
+	return nil
+}
+
+// UploadFrom uploads the size bytes of r to path, writing several parts at
+// once. On failure the upload is aborted and the file is left as it was.
+func (c *Client) UploadFrom(path string, r io.ReaderAt, size int64, opts UploadOptions) error {
+	upload, err := c.BeginUpload(path, opts.PartSize)
+	if err != nil {
+		return err
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultUploadConcurrency
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		done     int64
+	)
+	offsets := make(chan int64)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, upload.PartSize)
+			for offset := range offsets {
+				part := buf
+				if rest := size - offset; rest < upload.PartSize {
+					part = buf[:rest]
+				}
+				n, err := r.ReadAt(part, offset)
+				if err == io.EOF && n == len(part) {
+					err = nil
+				}
+				if err == nil {
+					err = c.WriteUploadPart(upload.ID, offset, buf[:n])
+				}
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("part at offset %d: %w", offset, err)
+				}
+				done += int64(n)
+				if err == nil && opts.Progress != nil {
+					opts.Progress(done, size)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for offset := int64(0); offset < size; offset += upload.PartSize {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		offsets <- offset
+	}
+	close(offsets)
+	wg.Wait()
+
+	if firstErr != nil {
+		c.AbortUpload(upload.ID)
+		return firstErr
+	}
+	if _, err := c.CommitUpload(upload.ID); err != nil {
+		c.AbortUpload(upload.ID)
+		return err
+	}
+	return nil
+}
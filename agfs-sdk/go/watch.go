@@ -0,0 +1,131 @@
+package agfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// eventHeartbeat is the type of the keep-alive lines of a watch stream
+const eventHeartbeat = "heartbeat"
+
+// Watcher receives the events of a Watch call
+type Watcher struct {
+	events chan Event
+	body   *progressReader
+
+	mu  sync.Mutex
+	err error
+}
+
+// Watch subscribes to changes of path (a file, or a directory and its direct
+// entries, or its subtree with opts.Recursive). Filters are applied by the
+// server, so unwanted events never cross the network. Events are delivered until Close is called or the connection
+// fails; Err reports why the channel was closed.
+func (c *Client) Watch(path string, opts WatchOptions) (*Watcher, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	if opts.Recursive {
+		query.Set("recursive", "true")
+	}
+	for _, p := range []struct {
+		name   string
+		values []string
+	}{{"include", opts.Include}, {"exclude", opts.Exclude}, {"types", opts.Types}} {
+		for _, v := range p.values {
+			query.Add(p.name, v)
+		}
+	}
+	if opts.Debounce > 0 {
+		query.Set("debounce", opts.Debounce.String())
+	}
+	if opts.Batch > 0 {
+		query.Set("batch", opts.Batch.String())
+	}
+	if opts.Since > 0 {
+		query.Set("since", strconv.FormatUint(opts.Since, 10))
+	}
+
+	// No overall request timeout; the server's heartbeats keep the
+	// progress watchdog from firing on quiet paths
+	streamClient := &http.Client{Timeout: 0}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/watch?%s", c.baseURL, query.Encode()), nil)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	c.authorize(req)
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		cancel()
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	w := &Watcher{
+		events: make(chan Event, 64),
+		body:   newProgressReader(resp.Body, cancel, c.streamingProgressTimeout),
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *Watcher) run() {
+	defer close(w.events)
+	decoder := json.NewDecoder(w.body)
+	for {
+		var ev Event
+		if err := decoder.Decode(&ev); err != nil {
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = fmt.Errorf("watch stream ended: %w", err)
+			}
+			w.mu.Unlock()
+			return
+		}
+		if ev.Type == eventHeartbeat {
+			continue
+		}
+		w.events <- ev
+	}
+}
+
+// Events returns the event channel
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Err returns why the event channel was closed, or nil after Close
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == errWatcherClosed {
+		return nil
+	}
+	return w.err
+}
+
+var errWatcherClosed = fmt.Errorf("watcher closed")
+
+// Close ends the watch
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = errWatcherClosed
+	}
+	w.mu.Unlock()
+	err := w.body.Close()
+	// Drain so run can exit if the consumer stopped reading
+	for range w.events {
+	}
+	return err
+}
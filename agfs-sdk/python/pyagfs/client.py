@@ -243,12 +243,21 @@ class AGFSClient:
                 self._handle_request_error(e)
             return {"version": "unknown", "features": []}
 
-    def ls(self, path: str = "/") -> List[Dict[str, Any]]:
-        """List directory contents"""
+    def ls(self, path: str = "/", names_only: bool = False) -> List[Dict[str, Any]]:
+        """List directory contents
+
+        Args:
+            path: Directory path
+            names_only: Only return the name and isDir of each entry, which
+                the server can list much faster on large directories
+        """
         try:
+            params = {"path": path}
+            if names_only:
+                params["names_only"] = "true"
             response = self.session.get(
                 f"{self.api_base}/directories",
-                params={"path": path},
+                params=params,
                 timeout=self.timeout
             )
             response.raise_for_status()
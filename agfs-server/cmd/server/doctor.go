@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+)
+
+// runDoctor implements "agfs-server doctor": it asks a running server to
+// check its mounts and prints the report, most urgent problems first. The
+// exit status is 1 if a mount is unusable, 2 if the report could not be
+// fetched.
+func runDoctor(args []string) int {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	defaultServer := os.Getenv("AGFS_API_URL")
+	if defaultServer == "" {
+		defaultServer = "http://localhost:8080"
+	}
+	server := flags.String("server", defaultServer, "AGFS server URL (default $AGFS_API_URL)")
+	path := flags.String("path", "", "Only check the mount at this path")
+	noProbe := flags.Bool("no-probe", false, "Skip the write/read/delete round trip in each mount")
+	iterations := flags.Int("iterations", 0, "Stat calls timed per mount (default 5)")
+	token := flags.String("token", "", "Bearer token, when the server requires authentication")
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON")
+	flags.Parse(args)
+
+	query := url.Values{}
+	if *path != "" {
+		query.Set("path", *path)
+	}
+	if *noProbe {
+		query.Set("probe", "false")
+	}
+	if *iterations > 0 {
+		query.Set("iterations", strconv.Itoa(*iterations))
+	}
+	endpoint := strings.TrimSuffix(*server, "/") + "/api/v1/doctor"
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "doctor: %v\n", err)
+		return 2
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	// The server bounds the checks of each mount; leave room for it
+	client := &http.Client{Timeout: 2 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "doctor: cannot reach the server at %s: %v\n", *server, err)
+		return 2
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "doctor: failed to read the report: %v\n", err)
+		return 2
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp handlers.ErrorResponse
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+			body = []byte(errResp.Error)
+		}
+		fmt.Fprintf(os.Stderr, "doctor: server answered %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+		return 2
+	}
+
+	var report handlers.DoctorResponse
+	if err := json.Unmarshal(body, &report); err != nil {
+		fmt.Fprintf(os.Stderr, "doctor: invalid report: %v\n", err)
+		return 2
+	}
+	if *jsonOutput {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		fmt.Print(handlers.FormatDoctorReport(report))
+	}
+	if report.Status == handlers.DoctorCritical {
+		return 1
+	}
+	return 0
+}
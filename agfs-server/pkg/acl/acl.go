@@ -0,0 +1,214 @@
+// Package acl grants identities read, write or admin permission on path
+// prefixes. Rules come from the server configuration and, optionally, from
+// a rules file stored in AGFS that admins can edit at runtime.
+package acl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Permission is a level of access; each level includes the ones below it
+type Permission int
+
+const (
+	None  Permission = iota // No access
+	Read                    // Read, list and search
+	Write                   // Create, modify and remove
+	Admin                   // Manage mounts and plugins, edit the rules file
+)
+
+var permissionNames = []string{"none", "read", "write", "admin"}
+
+func (p Permission) String() string {
+	if p < None || p > Admin {
+		return fmt.Sprintf("Permission(%d)", int(p))
+	}
+	return permissionNames[p]
+}
+
+// ParsePermission parses "none", "read", "write" or "admin"
+func ParsePermission(s string) (Permission, error) {
+	for i, name := range permissionNames {
+		if strings.EqualFold(s, name) {
+			return Permission(i), nil
+		}
+	}
+	return None, fmt.Errorf("unknown permission %q (want none, read, write or admin)", s)
+}
+
+// Rule grants identities matching a pattern a permission on a path prefix
+type Rule struct {
+	Identity   string     // path.Match pattern; "*" matches everyone, including anonymous callers
+	Path       string     // The rule covers this path and everything below it
+	Permission Permission // Granted permission; None denies access
+}
+
+// Config describes an access policy
+type Config struct {
+	Rules   []Rule
+	Default Permission // Permission where no rule matches
+	File    string     // AGFS path of a rules file, loaded at start (optional)
+}
+
+// Policy decides which identity may do what where
+type Policy struct {
+	cfg Config
+
+	mu        sync.RWMutex
+	fileRules []Rule
+}
+
+// NewPolicy creates a policy, loading the rules file from fs if cfg names
+// one that exists
+func NewPolicy(fs filesystem.FileSystem, cfg Config) (*Policy, error) {
+	rules, err := normalize(cfg.Rules)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Rules = rules
+	if cfg.File != "" {
+		cfg.File = filesystem.NormalizePath(cfg.File)
+	}
+	p := &Policy{cfg: cfg}
+
+	if cfg.File != "" {
+		if data, err := fs.Read(cfg.File, 0, -1); err == nil || len(data) > 0 {
+			if err := p.Load(data); err != nil {
+				return nil, fmt.Errorf("%s: %w", cfg.File, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// File returns the AGFS path of the rules file, or "" if there is none
+func (p *Policy) File() string {
+	return p.cfg.File
+}
+
+// Load replaces the rules read from the rules file with the ones in data
+func (p *Policy) Load(data []byte) error {
+	rules, err := ParseRules(data)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fileRules = rules
+	return nil
+}
+
+// Permission returns what identity may do at path. The rule with the
+// longest path decides; among rules for the same path, the last one wins,
+// with rules file entries coming after configured ones.
+func (p *Policy) Permission(identity, path string) Permission {
+	path = filesystem.NormalizePath(path)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	granted, best := p.cfg.Default, -1
+	for _, rules := range [][]Rule{p.cfg.Rules, p.fileRules} {
+		for _, rule := range rules {
+			if len(rule.Path) >= best && covers(rule.Path, path) && matches(rule.Identity, identity) {
+				granted, best = rule.Permission, len(rule.Path)
+			}
+		}
+	}
+	return granted
+}
+
+// Check returns a permission denied error unless identity has need at path.
+// Recursive operations also need it everywhere below path.
+func (p *Policy) Check(identity, path string, need Permission, recursive bool) error {
+	path = filesystem.NormalizePath(path)
+	if p.Permission(identity, path) < need {
+		return filesystem.NewPermissionDeniedError(need.String(), path, "not permitted by access control list")
+	}
+	if !recursive {
+		return nil
+	}
+
+	p.mu.RLock()
+	var below []string
+	for _, rules := range [][]Rule{p.cfg.Rules, p.fileRules} {
+		for _, rule := range rules {
+			if rule.Path != path && covers(path, rule.Path) && matches(rule.Identity, identity) {
+				below = append(below, rule.Path)
+			}
+		}
+	}
+	p.mu.RUnlock()
+	for _, sub := range below {
+		if p.Permission(identity, sub) < need {
+			return filesystem.NewPermissionDeniedError(need.String(), path, "contains "+sub+", which the access control list does not permit")
+		}
+	}
+	return nil
+}
+
+// ParseRules parses a rules file. Each non-empty line not starting with #
+// holds an identity pattern, a permission and a path:
+//
+//	alice   write  /local/projects/alice
+//	agent-* read   /local/shared
+//	*       none   /local/secrets
+func ParseRules(data []byte) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: want \"<identity> <permission> <path>\"", n)
+		}
+		perm, err := ParsePermission(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		rules = append(rules, Rule{Identity: fields[0], Permission: perm, Path: fields[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return normalize(rules)
+}
+
+// normalize validates rules and cleans their paths
+func normalize(rules []Rule) ([]Rule, error) {
+	out := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Identity, ""); err != nil || rule.Identity == "" {
+			return nil, fmt.Errorf("invalid identity pattern %q", rule.Identity)
+		}
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("rule path %q must be absolute", rule.Path)
+		}
+		if rule.Permission < None || rule.Permission > Admin {
+			return nil, fmt.Errorf("invalid permission %d for %s", rule.Permission, rule.Path)
+		}
+		rule.Path = filesystem.NormalizePath(rule.Path)
+		out = append(out, rule)
+	}
+	return out, nil
+}
+
+// covers reports whether prefix is p or one of its parents
+func covers(prefix, p string) bool {
+	return prefix == "/" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// matches reports whether identity matches pattern
+func matches(pattern, identity string) bool {
+	ok, _ := path.Match(pattern, identity)
+	return ok
+}
@@ -0,0 +1,91 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestPermission(t *testing.T) {
+	policy, err := NewPolicy(memfs.NewMemoryFS(), Config{
+		Default: Read,
+		Rules: []Rule{
+			{Identity: "alice", Path: "/projects", Permission: Write},
+			{Identity: "*", Path: "/projects/secret", Permission: None},
+			{Identity: "alice", Path: "/projects/secret", Permission: Read},
+			{Identity: "agent-*", Path: "/", Permission: None},
+			{Identity: "agent-*", Path: "/scratch", Permission: Write},
+			{Identity: "root", Path: "/", Permission: Admin},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+
+	tests := []struct {
+		identity, path string
+		want           Permission
+	}{
+		{"alice", "/projects/a.txt", Write},
+		{"alice", "/projects-old", Read},
+		{"alice", "/projects/secret/key", Read},
+		{"bob", "/projects/secret/key", None},
+		{"bob", "/projects/a.txt", Read},
+		{"", "/other", Read},
+		{"agent-1", "/projects", None},
+		{"agent-1", "/scratch/x", Write},
+		{"root", "/projects/secret", None}, // More specific rules win over admin on /
+	}
+	for _, tt := range tests {
+		if got := policy.Permission(tt.identity, tt.path); got != tt.want {
+			t.Errorf("Permission(%q, %q) = %v, want %v", tt.identity, tt.path, got, tt.want)
+		}
+	}
+
+	if err := policy.Check("bob", "/projects", Read, false); err != nil {
+		t.Errorf("Check(bob, /projects) = %v, want allowed", err)
+	}
+	if err := policy.Check("bob", "/projects", Read, true); err == nil {
+		t.Error("recursive Check(bob, /projects) allowed, want denied because of /projects/secret")
+	}
+	if err := policy.Check("alice", "/projects", Write, true); err == nil {
+		t.Error("recursive write Check(alice, /projects) allowed, want denied because of /projects/secret")
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]byte("# team rules\nalice  write /projects/\n\n*\tnone /secret\n"))
+	if err != nil {
+		t.Fatalf("ParseRules() error = %v", err)
+	}
+	if len(rules) != 2 || rules[0] != (Rule{Identity: "alice", Path: "/projects", Permission: Write}) {
+		t.Errorf("ParseRules() = %+v", rules)
+	}
+
+	for _, bad := range []string{"alice write", "alice sudo /x", "alice read relative", "[ read /x"} {
+		if _, err := ParseRules([]byte(bad)); err == nil {
+			t.Errorf("ParseRules(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRulesFile(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	fs.Mkdir("/etc", 0755)
+	fs.Write("/etc/acl", []byte("bob write /data\n"), -1, filesystem.WriteFlagCreate)
+
+	policy, err := NewPolicy(fs, Config{File: "/etc/acl", Rules: []Rule{{Identity: "bob", Path: "/data", Permission: Read}}})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	if got := policy.Permission("bob", "/data/x"); got != Write {
+		t.Errorf("Permission() = %v, want the rules file to override the configuration", got)
+	}
+	if err := policy.Load([]byte("")); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := policy.Permission("bob", "/data/x"); got != Read {
+		t.Errorf("Permission() after reload = %v, want read", got)
+	}
+}
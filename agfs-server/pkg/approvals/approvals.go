@@ -0,0 +1,251 @@
+// Package approvals holds mutations of protected paths until a human
+// approves them. Held requests wait in a pending queue, kept in memory,
+// and expire if nobody decides on them in time.
+package approvals
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Defaults applied when the corresponding Config field is zero
+const (
+	DefaultTTL      = 24 * time.Hour
+	DefaultRetained = 1000
+)
+
+// Request states
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved" // Approved and applied; Result holds the outcome
+	StatusRejected = "rejected"
+	StatusExpired  = "expired"
+)
+
+// Config describes which paths are protected and who may approve
+type Config struct {
+	Protected []string      // Path prefixes whose mutations need approval
+	Approvers []string      // Identities that may decide; empty for anyone but the requester
+	TTL       time.Duration // How long a request waits for a decision
+	Retained  int           // Decided requests kept for inspection
+}
+
+// Result is the outcome of applying an approved request
+type Result struct {
+	Status int    `json:"status"`
+	Body   string `json:"body,omitempty"`
+}
+
+// Request is a held mutation
+type Request struct {
+	ID        string            `json:"id"`
+	Identity  string            `json:"identity,omitempty"` // Who sent it
+	Method    string            `json:"method"`
+	Endpoint  string            `json:"endpoint"` // API path below /api/v1
+	Query     string            `json:"query,omitempty"`
+	Header    map[string]string `json:"header,omitempty"`
+	Paths     []string          `json:"paths"` // Protected paths it touches
+	Size      int64             `json:"size"`  // Body size in bytes
+	Status    string            `json:"status"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	DecidedBy string            `json:"decided_by,omitempty"`
+	DecidedAt *time.Time        `json:"decided_at,omitempty"`
+	Reason    string            `json:"reason,omitempty"`
+	Result    *Result           `json:"result,omitempty"`
+
+	Body []byte `json:"-"`
+}
+
+// Manager keeps the queue of held requests
+type Manager struct {
+	cfg Config
+
+	mu       sync.Mutex
+	requests map[string]*Request
+	decided  []string // IDs of decided requests, oldest first
+}
+
+// NewManager creates an approval manager
+func NewManager(cfg Config) (*Manager, error) {
+	if len(cfg.Protected) == 0 {
+		return nil, fmt.Errorf("approvals need at least one protected path")
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTTL
+	}
+	if cfg.Retained <= 0 {
+		cfg.Retained = DefaultRetained
+	}
+	protected := make([]string, 0, len(cfg.Protected))
+	for _, p := range cfg.Protected {
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("protected path %q must be absolute", p)
+		}
+		protected = append(protected, filesystem.NormalizePath(p))
+	}
+	cfg.Protected = protected
+
+	return &Manager{cfg: cfg, requests: make(map[string]*Request)}, nil
+}
+
+// Protected reports whether mutating p needs approval
+func (m *Manager) Protected(p string) bool {
+	p = filesystem.NormalizePath(p)
+	for _, prefix := range m.cfg.Protected {
+		if p == prefix || prefix == "/" || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Hold queues req for approval, filling in its ID, status and times
+func (m *Manager) Hold(req *Request) (*Request, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	req.ID = id
+	req.Status = StatusPending
+	req.CreatedAt = now
+	req.ExpiresAt = now.Add(m.cfg.TTL)
+	req.Size = int64(len(req.Body))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[id] = req
+	return req.snapshot(), nil
+}
+
+// Get returns the request with id
+func (m *Manager) Get(id string) (*Request, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	req, ok := m.requests[id]
+	if !ok {
+		return nil, filesystem.NewNotFoundError("approval", id)
+	}
+	return req.snapshot(), nil
+}
+
+// List returns the requests in status, or all if status is "", oldest first
+func (m *Manager) List(status string) []*Request {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	var list []*Request
+	for _, req := range m.requests {
+		if status == "" || req.Status == status {
+			list = append(list, req.snapshot())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// Decide approves or rejects the pending request id on behalf of approver.
+// An approved request is returned with its body so that the caller can
+// apply it and report the outcome with Complete.
+func (m *Manager) Decide(id, approver string, approve bool, reason string) (*Request, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.expire(now)
+
+	req, ok := m.requests[id]
+	if !ok {
+		return nil, filesystem.NewNotFoundError("approval", id)
+	}
+	if req.Status != StatusPending {
+		return nil, filesystem.NewInvalidArgumentError("approval", id, "request is already "+req.Status)
+	}
+	if err := m.mayDecide(req, approver); err != nil {
+		return nil, err
+	}
+
+	req.Status = StatusRejected
+	if approve {
+		req.Status = StatusApproved
+	}
+	req.DecidedBy = approver
+	req.DecidedAt = &now
+	req.Reason = reason
+	m.retire(id)
+
+	decided := req.snapshot()
+	decided.Body = req.Body
+	req.Body = nil
+	return decided, nil
+}
+
+// Complete records the outcome of applying an approved request
+func (m *Manager) Complete(id string, result Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if req, ok := m.requests[id]; ok {
+		req.Result = &result
+	}
+}
+
+// mayDecide checks that approver may decide on req
+func (m *Manager) mayDecide(req *Request, approver string) error {
+	if len(m.cfg.Approvers) > 0 {
+		for _, allowed := range m.cfg.Approvers {
+			if approver == allowed {
+				return nil
+			}
+		}
+		return filesystem.NewPermissionDeniedError("approve", req.ID, "not an approver")
+	}
+	if approver != "" && approver == req.Identity {
+		return filesystem.NewPermissionDeniedError("approve", req.ID, "requests cannot be approved by their sender")
+	}
+	return nil
+}
+
+// expire marks pending requests past their expiry. Caller must hold m.mu.
+func (m *Manager) expire(now time.Time) {
+	for id, req := range m.requests {
+		if req.Status == StatusPending && now.After(req.ExpiresAt) {
+			req.Status = StatusExpired
+			req.Body = nil
+			m.retire(id)
+		}
+	}
+}
+
+// retire records a request as decided and drops the oldest decided ones
+// beyond the retention limit. Caller must hold m.mu.
+func (m *Manager) retire(id string) {
+	m.decided = append(m.decided, id)
+	for len(m.decided) > m.cfg.Retained {
+		delete(m.requests, m.decided[0])
+		m.decided = m.decided[1:]
+	}
+}
+
+// snapshot copies req without its body
+func (req *Request) snapshot() *Request {
+	c := *req
+	c.Body = nil
+	return &c
+}
+
+// newID returns a random request ID
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
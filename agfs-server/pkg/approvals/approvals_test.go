@@ -0,0 +1,99 @@
+package approvals
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProtected(t *testing.T) {
+	m, err := NewManager(Config{Protected: []string{"/prod", "/data/live/"}})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/prod", true},
+		{"/prod/db/a.txt", true},
+		{"/production", false},
+		{"/data/live/x", true},
+		{"/data/lives", false},
+		{"/data", false},
+	}
+	for _, tt := range tests {
+		if got := m.Protected(tt.path); got != tt.want {
+			t.Errorf("Protected(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	if _, err := NewManager(Config{}); err == nil {
+		t.Error("NewManager() without protected paths succeeded")
+	}
+	if _, err := NewManager(Config{Protected: []string{"prod"}}); err == nil {
+		t.Error("NewManager() with a relative path succeeded")
+	}
+}
+
+func TestDecide(t *testing.T) {
+	m, _ := NewManager(Config{Protected: []string{"/prod"}})
+	held, err := m.Hold(&Request{Identity: "agent", Method: "PUT", Endpoint: "/files", Body: []byte("hello")})
+	if err != nil {
+		t.Fatalf("Hold() error = %v", err)
+	}
+	if held.Status != StatusPending || held.Size != 5 || held.Body != nil {
+		t.Errorf("Hold() = %+v, want a pending request without its body", held)
+	}
+
+	if _, err := m.Decide(held.ID, "agent", true, ""); err == nil {
+		t.Error("sender approved its own request")
+	}
+	decided, err := m.Decide(held.ID, "alice", true, "looks fine")
+	if err != nil {
+		t.Fatalf("Decide() error = %v", err)
+	}
+	if decided.Status != StatusApproved || decided.DecidedBy != "alice" || string(decided.Body) != "hello" {
+		t.Errorf("Decide() = %+v, want approved by alice with the body", decided)
+	}
+	if _, err := m.Decide(held.ID, "bob", false, ""); err == nil {
+		t.Error("decided twice")
+	}
+
+	m.Complete(held.ID, Result{Status: 200})
+	got, err := m.Get(held.ID)
+	if err != nil || got.Result == nil || got.Result.Status != 200 {
+		t.Errorf("Get() = %+v, %v, want the recorded result", got, err)
+	}
+	if len(m.List(StatusPending)) != 0 || len(m.List("")) != 1 {
+		t.Errorf("List() = %v, want only the approved request", m.List(""))
+	}
+}
+
+func TestApprovers(t *testing.T) {
+	m, _ := NewManager(Config{Protected: []string{"/prod"}, Approvers: []string{"alice"}})
+	held, _ := m.Hold(&Request{Identity: "agent"})
+	if _, err := m.Decide(held.ID, "bob", false, ""); err == nil {
+		t.Error("non-approver decided")
+	}
+	decided, err := m.Decide(held.ID, "alice", false, "no")
+	if err != nil || decided.Status != StatusRejected || decided.Reason != "no" {
+		t.Errorf("Decide() = %+v, %v, want rejected", decided, err)
+	}
+}
+
+func TestExpiryAndRetention(t *testing.T) {
+	m, _ := NewManager(Config{Protected: []string{"/prod"}, TTL: time.Millisecond, Retained: 2})
+	var ids []string
+	for i := 0; i < 3; i++ {
+		held, _ := m.Hold(&Request{Identity: "agent"})
+		ids = append(ids, held.ID)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := m.Decide(ids[2], "alice", true, ""); err == nil {
+		t.Error("approved an expired request")
+	}
+	if list := m.List(StatusExpired); len(list) != 2 {
+		t.Errorf("List(expired) = %d requests, want 2 retained", len(list))
+	}
+}
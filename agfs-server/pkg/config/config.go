@@ -12,6 +12,15 @@ type Config struct {
 	Server          ServerConfig            `yaml:"server"`
 	Plugins         map[string]PluginConfig `yaml:"plugins"`
 	ExternalPlugins ExternalPluginsConfig   `yaml:"external_plugins"`
+	Auth            AuthConfig              `yaml:"auth"`
+	Homes           HomesConfig             `yaml:"homes"`
+	Sessions        SessionsConfig          `yaml:"sessions"`
+	Approvals       ApprovalsConfig         `yaml:"approvals"`
+	ACL             ACLConfig               `yaml:"acl"`
+	Residency       ResidencyConfig         `yaml:"residency"`
+	Events          EventsConfig            `yaml:"events"`
+	Cache           CacheConfig             `yaml:"cache"`
+	Priority        PriorityConfig          `yaml:"priority"`
 }
 
 // ServerConfig contains server-level configuration
@@ -19,6 +28,109 @@ type ServerConfig struct {
 	Address             string `yaml:"address"`
 	LogLevel            string `yaml:"log_level"`
 	MaxRequestBodyBytes int64  `yaml:"max_request_body_bytes"`
+	V1Sunset            string `yaml:"v1_sunset"` // Date after which /api/v1 may be removed, e.g. 2027-06-30 (deprecates v1)
+}
+
+// AuthConfig maps API tokens to caller identities
+type AuthConfig struct {
+	Required bool          `yaml:"required"` // Reject requests without a valid token
+	Tokens   []TokenConfig `yaml:"tokens"`
+}
+
+// TokenConfig is an API token and the identity it authenticates
+type TokenConfig struct {
+	Token    string `yaml:"token"`
+	Identity string `yaml:"identity"`
+	Priority string `yaml:"priority"` // interactive (default) or batch, with priority scheduling
+}
+
+// HomesConfig contains configuration for per-identity home directories
+type HomesConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Path         string   `yaml:"path"`          // Where homes appear (default: /home)
+	Backend      string   `yaml:"backend"`       // AGFS path homes are stored under
+	Template     string   `yaml:"template"`      // AGFS directory copied into new homes
+	Quota        string   `yaml:"quota"`         // Size limit per home, e.g. "100MB" (empty = unlimited)
+	Ephemeral    []string `yaml:"ephemeral"`     // Identity patterns whose homes are removed when idle
+	EphemeralTTL string   `yaml:"ephemeral_ttl"` // Idle time before an ephemeral home is removed (default: 24h)
+}
+
+// SessionsConfig contains configuration for session recording
+type SessionsConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	Path       string   `yaml:"path"`        // AGFS path traces are stored under (default: /sessions)
+	Identities []string `yaml:"identities"`  // Identity patterns recorded without an X-AGFS-Session header
+	MaxContent string   `yaml:"max_content"` // Largest body or file kept per request, e.g. "1MB" (default: 1MB)
+}
+
+// ApprovalsConfig contains configuration for the approval workflow
+type ApprovalsConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Protected []string `yaml:"protected"` // Path prefixes whose mutations need approval
+	Approvers []string `yaml:"approvers"` // Identities that may decide (empty = anyone but the requester)
+	TTL       string   `yaml:"ttl"`       // How long a request waits for a decision (default: 24h)
+}
+
+// ACLConfig contains configuration for per-path access control
+type ACLConfig struct {
+	Enabled bool            `yaml:"enabled"`
+	Default string          `yaml:"default"` // Permission where no rule matches (default: none)
+	File    string          `yaml:"file"`    // AGFS path of a rules file admins can edit (optional)
+	Rules   []ACLRuleConfig `yaml:"rules"`
+}
+
+// ACLRuleConfig grants identities a permission on a path prefix
+type ACLRuleConfig struct {
+	Identity   string `yaml:"identity"`   // Identity pattern, e.g. "agent-*" ("*" includes anonymous callers)
+	Path       string `yaml:"path"`       // The rule covers this path and everything below it
+	Permission string `yaml:"permission"` // none, read, write or admin
+}
+
+// ResidencyConfig contains configuration for data residency rules
+type ResidencyConfig struct {
+	Enabled bool                  `yaml:"enabled"`
+	Regions map[string]string     `yaml:"regions"` // Region of each path, usually a mount, e.g. /s3-eu: eu-west
+	Rules   []ResidencyRuleConfig `yaml:"rules"`
+}
+
+// ResidencyRuleConfig requires the writes it matches to be stored in a
+// region
+type ResidencyRuleConfig struct {
+	Name       string   `yaml:"name"`
+	Paths      []string `yaml:"paths"`      // Glob patterns of paths, e.g. "/**/customers/eu/**"
+	Identities []string `yaml:"identities"` // Identity patterns, e.g. "eu-*"
+	Region     string   `yaml:"region"`     // Region the matching writes must be stored in
+}
+
+// EventsConfig contains configuration for the event history of watches
+type EventsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	History int    `yaml:"history"` // Events kept per mount (default: 10000)
+	MaxAge  string `yaml:"max_age"` // Older events are dropped, e.g. "24h" (empty = no age limit)
+	Dir     string `yaml:"dir"`     // Host directory the history is persisted to (optional)
+}
+
+// CacheConfig contains the cache hints sent with read and stat responses
+type CacheConfig struct {
+	Rules []CacheRuleConfig `yaml:"rules"`
+}
+
+// CacheRuleConfig sets the cache hints of the files at or below a path
+type CacheRuleConfig struct {
+	Path      string `yaml:"path"`
+	MaxAge    string `yaml:"max_age"`   // Reuse without revalidating for this long, e.g. "5m"
+	Immutable bool   `yaml:"immutable"` // Files are never rewritten once created
+}
+
+// PriorityConfig contains configuration for scheduling requests by priority
+// class, so batch jobs yield to interactive users
+type PriorityConfig struct {
+	Enabled            bool    `yaml:"enabled"`
+	MaxConcurrent      int     `yaml:"max_concurrent"`       // Requests served at once (default: 64)
+	BatchMaxConcurrent int     `yaml:"batch_max_concurrent"` // Of which batch requests may hold (default: a quarter)
+	BatchRate          float64 `yaml:"batch_rate"`           // Batch requests started per second (0 = unlimited)
+	BatchBurst         int     `yaml:"batch_burst"`          // Batch requests that may start at once (default: batch_rate)
+	QueueTimeout       string  `yaml:"queue_timeout"`        // Longest a request waits for a slot (default: 30s)
 }
 
 // ExternalPluginsConfig contains configuration for external plugins
@@ -95,6 +207,37 @@ func (c *Config) GetPluginConfig(pluginName string) (PluginConfig, bool) {
 	return cfg, ok
 }
 
+// TokenIdentities returns the configured tokens mapped to their identities
+func (a AuthConfig) TokenIdentities() (map[string]string, error) {
+	tokens := make(map[string]string, len(a.Tokens))
+	for i, t := range a.Tokens {
+		if t.Token == "" || t.Identity == "" {
+			return nil, fmt.Errorf("auth.tokens[%d]: token and identity are required", i)
+		}
+		if _, ok := tokens[t.Token]; ok {
+			return nil, fmt.Errorf("auth.tokens[%d]: duplicate token for %s", i, t.Identity)
+		}
+		tokens[t.Token] = t.Identity
+	}
+	return tokens, nil
+}
+
+// IdentityPriorities returns the priority class of the identities whose
+// tokens set one
+func (a AuthConfig) IdentityPriorities() (map[string]string, error) {
+	priorities := make(map[string]string)
+	for i, t := range a.Tokens {
+		if t.Priority == "" {
+			continue
+		}
+		if p, ok := priorities[t.Identity]; ok && p != t.Priority {
+			return nil, fmt.Errorf("auth.tokens[%d]: conflicting priorities for %s", i, t.Identity)
+		}
+		priorities[t.Identity] = t.Priority
+	}
+	return priorities, nil
+}
+
 // GetWASMConfig returns the WASM plugin configuration with defaults applied
 func (c *Config) GetWASMConfig() WASMPluginConfig {
 	cfg := c.ExternalPlugins.WASM
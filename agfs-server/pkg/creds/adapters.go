@@ -0,0 +1,67 @@
+package creds
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-sql-driver/mysql"
+)
+
+// AWS returns the credentials of the cache as an AWS SDK credentials
+// provider. It takes "access_key_id", "secret_access_key" and
+// "session_token", or the "access_key", "secret_key" and
+// "security_token" of Vault's AWS secrets engine.
+func (c *Cache) AWS() aws.CredentialsProvider {
+	return aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		creds, err := c.Get(ctx)
+		if err != nil {
+			return aws.Credentials{}, err
+		}
+		keyID := creds.Get("access_key_id", "access_key")
+		secret := creds.Get("secret_access_key", "secret_key")
+		if keyID == "" || secret == "" {
+			return aws.Credentials{}, fmt.Errorf("%s credentials have no access key", c.provider.Name())
+		}
+		return aws.Credentials{
+			AccessKeyID:     keyID,
+			SecretAccessKey: secret,
+			SessionToken:    creds.Get("session_token", "security_token"),
+			Source:          "agfs/" + c.provider.Name(),
+			CanExpire:       !creds.Expires.IsZero(),
+			Expires:         creds.Expires,
+		}, nil
+	})
+}
+
+// ConnMaxLifetime bounds the lifetime of database connections opened with
+// rotated credentials, so that the pool moves to new credentials before
+// the old ones are revoked
+const ConnMaxLifetime = 5 * time.Minute
+
+// MySQLConnector returns a connector of the MySQL DSN that logs in with
+// the "username" (or "user") and "password" of the cache, as they are when
+// each connection is opened
+func (c *Cache) MySQLConnector(dsn string) (driver.Connector, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.Apply(mysql.BeforeConnect(func(ctx context.Context, cfg *mysql.Config) error {
+		creds, err := c.Get(ctx)
+		if err != nil {
+			return err
+		}
+		if user := creds.Get("username", "user"); user != "" {
+			cfg.User = user
+		}
+		cfg.Passwd = creds.Get("password")
+		return nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return mysql.NewConnector(cfg)
+}
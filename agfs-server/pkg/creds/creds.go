@@ -0,0 +1,182 @@
+// Package creds acquires the credentials plugins authenticate to their
+// backends with. Instead of static keys in the mount config, a plugin takes
+// a "credentials" block naming a provider, such as the AWS default chain
+// (IRSA, instance profiles), GCP workload identity, Azure managed identity
+// or Vault dynamic secrets, and gets credentials that are refreshed before
+// they expire.
+//
+//	credentials:
+//	  provider: vault
+//	  path: database/creds/agfs
+package creds
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Credentials are the secrets a provider issued, by name, with when they
+// expire. Names depend on the provider: "access_key_id" and
+// "secret_access_key" for AWS, "token" for OAuth tokens, "username" and
+// "password" for database secrets.
+type Credentials struct {
+	Values  map[string]string
+	Expires time.Time // Zero if they do not expire
+}
+
+// Get returns the first of the named values that is set
+func (c *Credentials) Get(names ...string) string {
+	for _, name := range names {
+		if value := c.Values[name]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Provider issues credentials
+type Provider interface {
+	// Name identifies the provider in logs and errors
+	Name() string
+	// Retrieve issues fresh credentials
+	Retrieve(ctx context.Context) (*Credentials, error)
+}
+
+// Factory creates a provider from its configuration, the credentials block
+// of a mount without the "provider" key. It must not contact the provider,
+// so that configurations can be validated offline.
+type Factory func(cfg map[string]interface{}) (Provider, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register makes a provider available under name, replacing any provider
+// registered under it before
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = factory
+}
+
+// Providers returns the names of the registered providers
+func Providers() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the provider a credentials block names, behind a Cache
+func New(cfg map[string]interface{}) (*Cache, error) {
+	name, _ := cfg["provider"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("credentials: provider is required (one of %s)", strings.Join(Providers(), ", "))
+	}
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("credentials: unknown provider %q (one of %s)", name, strings.Join(Providers(), ", "))
+	}
+
+	rest := make(map[string]interface{}, len(cfg))
+	for key, value := range cfg {
+		if key != "provider" {
+			rest[key] = value
+		}
+	}
+	provider, err := factory(rest)
+	if err != nil {
+		return nil, fmt.Errorf("credentials: %s: %w", name, err)
+	}
+	return NewCache(provider), nil
+}
+
+// FromConfig returns the cache of the credentials block under key in a
+// plugin config, or nil if there is none
+func FromConfig(cfg map[string]interface{}, key string) (*Cache, error) {
+	value, ok := cfg[key]
+	if !ok {
+		return nil, nil
+	}
+	block, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a map", key)
+	}
+	return New(block)
+}
+
+// Cache hands out the credentials of a provider, retrieving new ones once
+// two thirds of their lifetime has passed. If the provider fails then, the
+// old credentials are used until they actually expire.
+type Cache struct {
+	provider Provider
+	now      func() time.Time
+
+	mu      sync.Mutex
+	current *Credentials
+	refresh time.Time // When to retrieve new credentials
+}
+
+// retrieveTimeout bounds each retrieval from a provider
+const retrieveTimeout = 30 * time.Second
+
+// NewCache creates a cache of the credentials of provider
+func NewCache(provider Provider) *Cache {
+	return &Cache{provider: provider, now: time.Now}
+}
+
+// Provider returns the provider behind the cache
+func (c *Cache) Provider() Provider {
+	return c.provider
+}
+
+// Get returns valid credentials, retrieving them if needed
+func (c *Cache) Get(ctx context.Context) (*Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if c.current != nil && now.Before(c.refresh) {
+		return c.current, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, retrieveTimeout)
+	defer cancel()
+	creds, err := c.provider.Retrieve(ctx)
+	if err != nil {
+		if c.current != nil && (c.current.Expires.IsZero() || now.Before(c.current.Expires)) {
+			log.Warnf("[creds] Failed to rotate %s credentials, using the current ones until %s: %v",
+				c.provider.Name(), c.current.Expires.Format(time.RFC3339), err)
+			return c.current, nil
+		}
+		return nil, fmt.Errorf("%s credentials: %w", c.provider.Name(), err)
+	}
+
+	if c.current != nil {
+		log.Debugf("[creds] Rotated %s credentials", c.provider.Name())
+	}
+	c.current = creds
+	if creds.Expires.IsZero() {
+		c.refresh = now.Add(staticRefresh)
+	} else {
+		c.refresh = now.Add(creds.Expires.Sub(now) * 2 / 3)
+	}
+	return creds, nil
+}
+
+// staticRefresh is how often credentials without an expiry are retrieved
+// again, for providers that rotate them in place, e.g. files updated by an
+// agent
+const staticRefresh = 5 * time.Minute
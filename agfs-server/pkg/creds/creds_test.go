@@ -0,0 +1,214 @@
+package creds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// countingProvider issues numbered credentials that expire after ttl
+type countingProvider struct {
+	ttl   time.Duration
+	now   func() time.Time
+	calls int
+	err   error
+}
+
+func (p *countingProvider) Name() string { return "counting" }
+
+func (p *countingProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &Credentials{
+		Values:  map[string]string{"token": strconv.Itoa(p.calls)},
+		Expires: p.now().Add(p.ttl),
+	}, nil
+}
+
+func TestCacheRotation(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	p := &countingProvider{ttl: 30 * time.Minute, now: clock}
+	c := NewCache(p)
+	c.now = clock
+	get := func() string {
+		t.Helper()
+		creds, err := c.Get(context.Background())
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		return creds.Values["token"]
+	}
+
+	if got := get(); got != "1" {
+		t.Fatalf("first Get() = %s", got)
+	}
+	now = now.Add(19 * time.Minute)
+	if got := get(); got != "1" || p.calls != 1 {
+		t.Errorf("Get() before two thirds of the lifetime = %s after %d retrievals", got, p.calls)
+	}
+	now = now.Add(2 * time.Minute)
+	if got := get(); got != "2" {
+		t.Errorf("Get() after two thirds of the lifetime = %s, want rotated", got)
+	}
+
+	// Failed rotations fall back to the current credentials until they expire
+	p.err = errors.New("provider down")
+	now = now.Add(25 * time.Minute)
+	if got := get(); got != "2" {
+		t.Errorf("Get() with the provider down = %s, want the current credentials", got)
+	}
+	now = now.Add(10 * time.Minute)
+	if _, err := c.Get(context.Background()); err == nil {
+		t.Error("Get() with expired credentials and the provider down succeeded")
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Setenv("CREDS_TEST_PASSWORD", "hunter2")
+	c, err := New(map[string]interface{}{
+		"provider": "static",
+		"values":   map[string]interface{}{"username": "agfs", "password": "${CREDS_TEST_PASSWORD}"},
+	})
+	if err != nil {
+		t.Fatalf("New(static) error = %v", err)
+	}
+	creds, err := c.Get(context.Background())
+	if err != nil || creds.Get("user", "username") != "agfs" || creds.Values["password"] != "hunter2" {
+		t.Errorf("static credentials = %+v, %v", creds, err)
+	}
+
+	for name, cfg := range map[string]map[string]interface{}{
+		"no provider":       {},
+		"unknown provider":  {"provider": "kerberos"},
+		"unknown key":       {"provider": "aws", "role": "x"},
+		"no static values":  {"provider": "static"},
+		"azure no resource": {"provider": "azure"},
+		"vault no path":     {"provider": "vault", "address": "http://vault", "token": "t"},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%s) succeeded", name)
+		}
+	}
+
+	if c, err := FromConfig(map[string]interface{}{"bucket": "b"}, "credentials"); c != nil || err != nil {
+		t.Errorf("FromConfig without credentials = %v, %v", c, err)
+	}
+}
+
+func TestMetadataProviders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "gcp-token", "expires_in": 3600})
+		case "/metadata/identity/oauth2/token":
+			if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("resource") != "https://storage.azure.com/" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			expires := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "azure-token", "expires_on": expires})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for _, cfg := range []map[string]interface{}{
+		{"provider": "gcp", "endpoint": server.URL},
+		{"provider": "azure", "endpoint": server.URL, "resource": "https://storage.azure.com/"},
+	} {
+		c, err := New(cfg)
+		if err != nil {
+			t.Fatalf("New(%s) error = %v", cfg["provider"], err)
+		}
+		creds, err := c.Get(context.Background())
+		if err != nil {
+			t.Fatalf("%s Get() error = %v", cfg["provider"], err)
+		}
+		if creds.Values["token"] != cfg["provider"].(string)+"-token" || time.Until(creds.Expires) < 50*time.Minute {
+			t.Errorf("%s credentials = %+v", cfg["provider"], creds)
+		}
+	}
+}
+
+func TestVaultProvider(t *testing.T) {
+	var leases int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("X-Vault-Token") != "agent-token":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+		case r.URL.Path == "/v1/database/creds/agfs":
+			leases++
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"lease_duration": 3600,
+				"data":           map[string]interface{}{"username": "v-agfs-" + strconv.Itoa(leases), "password": "secret"},
+			})
+		case r.URL.Path == "/v1/secret/data/s3":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{
+					"data":     map[string]interface{}{"access_key_id": "AKIA", "secret_access_key": "shh"},
+					"metadata": map[string]interface{}{"version": 3},
+				},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("agent-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(map[string]interface{}{"provider": "vault", "address": server.URL, "path": "/database/creds/agfs", "token_file": tokenFile})
+	if err != nil {
+		t.Fatalf("New(vault) error = %v", err)
+	}
+	creds, err := c.Get(context.Background())
+	if err != nil || creds.Values["username"] != "v-agfs-1" || creds.Values["password"] != "secret" || creds.Expires.IsZero() {
+		t.Fatalf("vault credentials = %+v, %v", creds, err)
+	}
+
+	// KV version 2 secrets are unwrapped, and feed the AWS SDK
+	c, _ = New(map[string]interface{}{"provider": "vault", "address": server.URL, "path": "secret/data/s3", "token": "agent-token"})
+	aws, err := c.AWS().Retrieve(context.Background())
+	if err != nil || aws.AccessKeyID != "AKIA" || aws.SecretAccessKey != "shh" || aws.CanExpire {
+		t.Errorf("AWS credentials = %+v, %v", aws, err)
+	}
+
+	c, _ = New(map[string]interface{}{"provider": "vault", "address": server.URL, "path": "secret/data/s3", "token": "wrong"})
+	if _, err := c.Get(context.Background()); err == nil {
+		t.Error("Get() with a wrong token succeeded")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("test", func(cfg map[string]interface{}) (Provider, error) {
+		return &countingProvider{ttl: time.Hour, now: time.Now}, nil
+	})
+	c, err := New(map[string]interface{}{"provider": "test"})
+	if err != nil {
+		t.Fatalf("New(test) error = %v", err)
+	}
+	if _, err := c.MySQLConnector("root@tcp(127.0.0.1:4000)/agfs"); err != nil {
+		t.Errorf("MySQLConnector() error = %v", err)
+	}
+	if _, err := c.AWS().Retrieve(context.Background()); err == nil {
+		t.Error("AWS credentials without an access key succeeded")
+	}
+}
@@ -0,0 +1,368 @@
+package creds
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+func init() {
+	Register("static", newStaticProvider)
+	Register("aws", newAWSProvider)
+	Register("gcp", newGCPProvider)
+	Register("azure", newAzureProvider)
+	Register("vault", newVaultProvider)
+}
+
+// httpTimeout bounds the requests to metadata servers and Vault
+const httpTimeout = 10 * time.Second
+
+// staticProvider hands out fixed values, e.g. from the environment
+type staticProvider struct {
+	values map[string]string
+}
+
+// newStaticProvider takes "values", a map of names to values in which
+// ${VAR} expands environment variables
+func newStaticProvider(cfg map[string]interface{}) (Provider, error) {
+	if err := config.ValidateOnlyKnownKeys(cfg, []string{"values"}); err != nil {
+		return nil, err
+	}
+	raw, ok := cfg["values"].(map[string]interface{})
+	if !ok || len(raw) == 0 {
+		return nil, fmt.Errorf("values is required")
+	}
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("values.%s must be a string", name)
+		}
+		values[name] = os.ExpandEnv(s)
+	}
+	return &staticProvider{values: values}, nil
+}
+
+func (p *staticProvider) Name() string {
+	return "static"
+}
+
+func (p *staticProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+	return &Credentials{Values: p.values}, nil
+}
+
+// awsProvider follows the AWS SDK's default chain: environment variables,
+// web identity tokens (IRSA on EKS), shared config profiles, ECS task roles
+// and EC2 instance profiles
+type awsProvider struct {
+	profile string
+	region  string
+
+	once  sync.Once
+	creds aws.CredentialsProvider
+	err   error
+}
+
+func newAWSProvider(cfg map[string]interface{}) (Provider, error) {
+	if err := config.ValidateOnlyKnownKeys(cfg, []string{"profile", "region"}); err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"profile", "region"} {
+		if err := config.ValidateStringType(cfg, key); err != nil {
+			return nil, err
+		}
+	}
+	return &awsProvider{
+		profile: config.GetStringConfig(cfg, "profile", ""),
+		region:  config.GetStringConfig(cfg, "region", ""),
+	}, nil
+}
+
+func (p *awsProvider) Name() string {
+	return "aws"
+}
+
+func (p *awsProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+	p.once.Do(func() {
+		var opts []func(*awsconfig.LoadOptions) error
+		if p.profile != "" {
+			opts = append(opts, awsconfig.WithSharedConfigProfile(p.profile))
+		}
+		if p.region != "" {
+			opts = append(opts, awsconfig.WithRegion(p.region))
+		}
+		cfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+		p.creds, p.err = cfg.Credentials, err
+	})
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.creds == nil {
+		return nil, fmt.Errorf("no credentials found in the default chain")
+	}
+	c, err := p.creds.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	creds := &Credentials{Values: map[string]string{
+		"access_key_id":     c.AccessKeyID,
+		"secret_access_key": c.SecretAccessKey,
+		"session_token":     c.SessionToken,
+	}}
+	if c.CanExpire {
+		creds.Expires = c.Expires
+	}
+	return creds, nil
+}
+
+// gcpProvider gets OAuth access tokens of a service account from the GCE
+// metadata server, which GKE workload identity also serves
+type gcpProvider struct {
+	endpoint string
+	scopes   string
+	client   *http.Client
+}
+
+func newGCPProvider(cfg map[string]interface{}) (Provider, error) {
+	if err := config.ValidateOnlyKnownKeys(cfg, []string{"service_account", "scopes", "endpoint"}); err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"service_account", "scopes", "endpoint"} {
+		if err := config.ValidateStringType(cfg, key); err != nil {
+			return nil, err
+		}
+	}
+	endpoint := config.GetStringConfig(cfg, "endpoint", "")
+	if endpoint == "" {
+		host := os.Getenv("GCE_METADATA_HOST")
+		if host == "" {
+			host = "metadata.google.internal"
+		}
+		endpoint = "http://" + host
+	}
+	account := config.GetStringConfig(cfg, "service_account", "default")
+	return &gcpProvider{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/computeMetadata/v1/instance/service-accounts/" + url.PathEscape(account) + "/token",
+		scopes:   config.GetStringConfig(cfg, "scopes", ""),
+		client:   &http.Client{Timeout: httpTimeout},
+	}, nil
+}
+
+func (p *gcpProvider) Name() string {
+	return "gcp"
+}
+
+func (p *gcpProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+	u := p.endpoint
+	if p.scopes != "" {
+		u += "?scopes=" + url.QueryEscape(p.scopes)
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := getJSON(ctx, p.client, u, http.Header{"Metadata-Flavor": {"Google"}}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.AccessToken == "" {
+		return nil, fmt.Errorf("metadata server returned no access token")
+	}
+	return &Credentials{
+		Values:  map[string]string{"token": resp.AccessToken},
+		Expires: time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// azureProvider gets access tokens of a managed identity from the Azure
+// instance metadata service
+type azureProvider struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newAzureProvider(cfg map[string]interface{}) (Provider, error) {
+	if err := config.ValidateOnlyKnownKeys(cfg, []string{"resource", "client_id", "endpoint"}); err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"resource", "client_id", "endpoint"} {
+		if err := config.ValidateStringType(cfg, key); err != nil {
+			return nil, err
+		}
+	}
+	resource, err := config.RequireString(cfg, "resource")
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {resource}}
+	if clientID := config.GetStringConfig(cfg, "client_id", ""); clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	endpoint := config.GetStringConfig(cfg, "endpoint", "http://169.254.169.254")
+	return &azureProvider{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/metadata/identity/oauth2/token?" + query.Encode(),
+		client:   &http.Client{Timeout: httpTimeout},
+	}, nil
+}
+
+func (p *azureProvider) Name() string {
+	return "azure"
+}
+
+func (p *azureProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"` // Unix seconds, as a string
+	}
+	if err := getJSON(ctx, p.client, p.endpoint, http.Header{"Metadata": {"true"}}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.AccessToken == "" {
+		return nil, fmt.Errorf("metadata service returned no access token")
+	}
+	creds := &Credentials{Values: map[string]string{"token": resp.AccessToken}}
+	if expires, err := strconv.ParseInt(resp.ExpiresOn, 10, 64); err == nil {
+		creds.Expires = time.Unix(expires, 0)
+	}
+	return creds, nil
+}
+
+// vaultProvider reads a secret from Vault, typically dynamic credentials
+// of a secrets engine such as database/creds/<role> or aws/creds/<role>,
+// which Vault issues with a lease
+type vaultProvider struct {
+	address   string
+	path      string
+	token     string
+	tokenFile string // Read on every retrieval, for tokens an agent renews
+	namespace string
+	client    *http.Client
+}
+
+func newVaultProvider(cfg map[string]interface{}) (Provider, error) {
+	keys := []string{"address", "path", "token", "token_file", "namespace"}
+	if err := config.ValidateOnlyKnownKeys(cfg, keys); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if err := config.ValidateStringType(cfg, key); err != nil {
+			return nil, err
+		}
+	}
+	path, err := config.RequireString(cfg, "path")
+	if err != nil {
+		return nil, err
+	}
+	p := &vaultProvider{
+		address:   config.GetStringConfig(cfg, "address", os.Getenv("VAULT_ADDR")),
+		path:      strings.Trim(path, "/"),
+		token:     os.ExpandEnv(config.GetStringConfig(cfg, "token", "")),
+		tokenFile: config.GetStringConfig(cfg, "token_file", ""),
+		namespace: config.GetStringConfig(cfg, "namespace", os.Getenv("VAULT_NAMESPACE")),
+		client:    &http.Client{Timeout: httpTimeout},
+	}
+	if p.address == "" {
+		return nil, fmt.Errorf("address is required when VAULT_ADDR is not set")
+	}
+	p.address = strings.TrimSuffix(p.address, "/")
+	if p.token == "" && p.tokenFile == "" {
+		p.token = os.Getenv("VAULT_TOKEN")
+	}
+	if p.token == "" && p.tokenFile == "" {
+		return nil, fmt.Errorf("token or token_file is required when VAULT_TOKEN is not set")
+	}
+	return p, nil
+}
+
+func (p *vaultProvider) Name() string {
+	return "vault"
+}
+
+func (p *vaultProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+	token := p.token
+	if p.tokenFile != "" {
+		data, err := os.ReadFile(p.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token_file: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	header := http.Header{"X-Vault-Token": {token}}
+	if p.namespace != "" {
+		header.Set("X-Vault-Namespace", p.namespace)
+	}
+
+	var resp struct {
+		LeaseDuration int64                  `json:"lease_duration"`
+		Data          map[string]interface{} `json:"data"`
+	}
+	if err := getJSON(ctx, p.client, p.address+"/v1/"+p.path, header, &resp); err != nil {
+		return nil, err
+	}
+	// KV version 2 nests the secret one level further
+	if inner, ok := resp.Data["data"].(map[string]interface{}); ok {
+		if _, versioned := resp.Data["metadata"]; versioned {
+			resp.Data = inner
+		}
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("secret %s has no data", p.path)
+	}
+
+	creds := &Credentials{Values: make(map[string]string, len(resp.Data))}
+	for name, value := range resp.Data {
+		switch value := value.(type) {
+		case string:
+			creds.Values[name] = value
+		case nil:
+		default:
+			creds.Values[name] = fmt.Sprint(value)
+		}
+	}
+	if resp.LeaseDuration > 0 {
+		creds.Expires = time.Now().Add(time.Duration(resp.LeaseDuration) * time.Second)
+	}
+	return creds, nil
+}
+
+// getJSON sends a GET request and decodes its JSON response
+func getJSON(ctx context.Context, client *http.Client, u string, header http.Header, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(body))
+		if len(msg) > 200 {
+			msg = msg[:200] + "..."
+		}
+		return fmt.Errorf("GET %s: HTTP %d: %s", req.URL.Redacted(), resp.StatusCode, msg)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("GET %s: invalid response: %w", req.URL.Redacted(), err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package filesystem
+
+import (
+	"fmt"
+	"io"
+)
+
+// Batch operation names
+const (
+	BatchOpMkdir  = "mkdir"
+	BatchOpWrite  = "write"
+	BatchOpRename = "rename"
+	BatchOpRemove = "remove"
+)
+
+// BatchOp is a single operation in a batch
+type BatchOp struct {
+	Op        string // One of the BatchOp* constants
+	Path      string // Target path
+	NewPath   string // Destination path (rename only)
+	Data      []byte // File content (write only)
+	Mode      uint32 // Directory permissions (mkdir only, 0 means 0755)
+	Recursive bool   // Remove directories and their contents (remove only)
+}
+
+// BatchResult reports the outcome of a single batch operation
+type BatchResult struct {
+	Index   int    // Position of the operation in the batch
+	Op      string // Operation name
+	Path    string // Target path
+	Err     error  // nil on success
+	Skipped bool   // True if the operation was not attempted
+}
+
+// BatchOutcome is the result of executing a batch
+type BatchOutcome struct {
+	Results    []BatchResult
+	Succeeded  int
+	Failed     int
+	RolledBack bool  // True if an atomic batch was undone after a failure
+	Err        error // First error encountered, nil if all operations succeeded
+}
+
+// undoFunc reverses a previously applied batch operation
+type undoFunc func() error
+
+// ValidateBatch checks that every operation is well formed. In atomic mode it
+// also rejects operations whose effect cannot be reversed on fs.
+func ValidateBatch(fs FileSystem, ops []BatchOp, atomic bool) error {
+	if len(ops) == 0 {
+		return NewInvalidArgumentError("ops", ops, "batch must contain at least one operation")
+	}
+
+	for i, op := range ops {
+		if op.Path == "" {
+			return NewInvalidArgumentError(fmt.Sprintf("ops[%d].path", i), op.Path, "path is required")
+		}
+		switch op.Op {
+		case BatchOpMkdir, BatchOpWrite:
+		case BatchOpRename:
+			if op.NewPath == "" {
+				return NewInvalidArgumentError(fmt.Sprintf("ops[%d].new_path", i), op.NewPath, "new_path is required for rename")
+			}
+		case BatchOpRemove:
+			if atomic && op.Recursive {
+				return NewInvalidArgumentError(fmt.Sprintf("ops[%d].recursive", i), op.Recursive, "recursive remove cannot be rolled back in an atomic batch")
+			}
+		default:
+			return NewInvalidArgumentError(fmt.Sprintf("ops[%d].op", i), op.Op, "must be one of mkdir, write, rename, remove")
+		}
+
+		if atomic {
+			if cp, ok := fs.(CapabilityProvider); ok {
+				caps := cp.GetPathCapabilities(op.Path)
+				if caps.IsAppendOnly || caps.IsReadDestructive || caps.IsBroadcast {
+					return NewInvalidArgumentError(fmt.Sprintf("ops[%d].path", i), op.Path, "path does not support atomic batches")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ExecuteBatch applies ops in order.
+//
+// In best-effort mode every operation is attempted and failures are reported
+// per operation. In atomic mode execution stops at the first failure and the
+// operations already applied are undone in reverse order. File systems have no
+// native transactions, so atomicity is provided by compensating actions and is
+// not isolated from concurrent writers.
+func ExecuteBatch(fs FileSystem, ops []BatchOp, atomic bool) BatchOutcome {
+	outcome := BatchOutcome{Results: make([]BatchResult, len(ops))}
+	if err := ValidateBatch(fs, ops, atomic); err != nil {
+		outcome.Err = err
+		for i, op := range ops {
+			outcome.Results[i] = BatchResult{Index: i, Op: op.Op, Path: op.Path, Skipped: true}
+		}
+		return outcome
+	}
+
+	var undo []undoFunc
+	for i, op := range ops {
+		result := BatchResult{Index: i, Op: op.Op, Path: op.Path}
+
+		if atomic && outcome.Err != nil {
+			result.Skipped = true
+			outcome.Results[i] = result
+			continue
+		}
+
+		u, err := applyBatchOp(fs, op, atomic)
+		if err != nil {
+			result.Err = err
+			outcome.Failed++
+			if outcome.Err == nil {
+				outcome.Err = fmt.Errorf("%s %s: %w", op.Op, op.Path, err)
+			}
+		} else {
+			outcome.Succeeded++
+			if u != nil {
+				undo = append(undo, u)
+			}
+		}
+		outcome.Results[i] = result
+	}
+
+	if atomic && outcome.Err != nil {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if err := undo[i](); err != nil {
+				outcome.Err = fmt.Errorf("%w (rollback failed: %v)", outcome.Err, err)
+				return outcome
+			}
+		}
+		outcome.RolledBack = true
+	}
+	return outcome
+}
+
+// applyBatchOp performs one operation. When record is true it returns a
+// function that reverses the operation.
+func applyBatchOp(fs FileSystem, op BatchOp, record bool) (undoFunc, error) {
+	switch op.Op {
+	case BatchOpMkdir:
+		mode := op.Mode
+		if mode == 0 {
+			mode = 0755
+		}
+		if err := fs.Mkdir(op.Path, mode); err != nil {
+			return nil, err
+		}
+		return func() error { return fs.Remove(op.Path) }, nil
+
+	case BatchOpWrite:
+		var restore undoFunc
+		if record {
+			var err error
+			if restore, err = snapshotFile(fs, op.Path); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := fs.Write(op.Path, op.Data, -1, WriteFlagCreate|WriteFlagTruncate); err != nil {
+			return nil, err
+		}
+		return restore, nil
+
+	case BatchOpRename:
+		if err := fs.Rename(op.Path, op.NewPath); err != nil {
+			return nil, err
+		}
+		return func() error { return fs.Rename(op.NewPath, op.Path) }, nil
+
+	case BatchOpRemove:
+		if op.Recursive {
+			return nil, fs.RemoveAll(op.Path)
+		}
+		var restore undoFunc
+		if record {
+			info, err := fs.Stat(op.Path)
+			if err != nil {
+				return nil, err
+			}
+			if info.IsDir {
+				mode := info.Mode
+				restore = func() error { return fs.Mkdir(op.Path, mode) }
+			} else if restore, err = snapshotFile(fs, op.Path); err != nil {
+				return nil, err
+			}
+		}
+		if err := fs.Remove(op.Path); err != nil {
+			return nil, err
+		}
+		return restore, nil
+	}
+	return nil, NewInvalidArgumentError("op", op.Op, "unknown batch operation")
+}
+
+// snapshotFile captures the current state of path so it can be restored.
+// If the file does not exist, restoring removes it.
+func snapshotFile(fs FileSystem, path string) (undoFunc, error) {
+	info, err := fs.Stat(path)
+	if err != nil {
+		return func() error { return fs.Remove(path) }, nil
+	}
+	if info.IsDir {
+		return nil, NewInvalidArgumentError("path", path, "is a directory")
+	}
+
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to snapshot %s: %w", path, err)
+	}
+	mode := info.Mode
+	return func() error {
+		if _, err := fs.Write(path, data, -1, WriteFlagCreate|WriteFlagTruncate); err != nil {
+			return err
+		}
+		return fs.Chmod(path, mode)
+	}, nil
+}
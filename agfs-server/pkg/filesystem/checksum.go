@@ -0,0 +1,89 @@
+package filesystem
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/zeebo/xxh3"
+)
+
+// Checksum algorithms
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumMD5    = "md5"
+	ChecksumXXH3   = "xxh3"
+)
+
+// Checksummer is implemented by file systems that can return a content hash
+// without the caller streaming the file, either because the hash is already
+// stored (e.g. vectorfs digests, S3 ETags) or because it can be computed
+// close to the data.
+type Checksummer interface {
+	// Checksum returns the hex-encoded hash of the file content.
+	// Returns ErrNotSupported if the algorithm is not available natively.
+	Checksum(path string, algorithm string) (string, error)
+}
+
+// NewChecksumHash returns a hash for a supported algorithm
+func NewChecksumHash(algorithm string) (hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumMD5:
+		return md5.New(), nil
+	case ChecksumXXH3:
+		return xxh3.New(), nil
+	default:
+		return nil, NewInvalidArgumentError("algorithm", algorithm, "supported: sha256, md5, xxh3")
+	}
+}
+
+// ChecksumReader hashes everything read from r
+func ChecksumReader(r io.Reader, algorithm string) (string, error) {
+	h, err := NewChecksumHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ComputeChecksum returns the checksum of path, using the file system's
+// Checksummer when available and streaming the content otherwise.
+// native reports whether the file system supplied the value.
+func ComputeChecksum(fs FileSystem, path, algorithm string) (sum string, native bool, err error) {
+	algorithm = strings.ToLower(algorithm)
+	if _, err := NewChecksumHash(algorithm); err != nil {
+		return "", false, err
+	}
+
+	if cs, ok := fs.(Checksummer); ok {
+		sum, err := cs.Checksum(path, algorithm)
+		if err == nil {
+			return sum, true, nil
+		}
+		if err != ErrNotSupported {
+			return "", false, err
+		}
+	}
+
+	reader, err := fs.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer reader.Close()
+
+	sum, err = ChecksumReader(reader, algorithm)
+	return sum, false, err
+}
+
+// FormatChecksum formats a checksum as "<algorithm>:<hex>" for FileInfo.Checksum
+func FormatChecksum(algorithm, sum string) string {
+	return algorithm + ":" + sum
+}
@@ -0,0 +1,18 @@
+package filesystem
+
+import "context"
+
+// ContextFileSystem is implemented by file systems whose operations can be
+// cancelled. Callers with a deadline, such as the timeout mount middleware,
+// use these instead of the plain methods so that the backend call itself
+// is aborted rather than left running after the caller gave up.
+type ContextFileSystem interface {
+	// ReadContext is Read, aborted when ctx is done
+	ReadContext(ctx context.Context, path string, offset int64, size int64) ([]byte, error)
+
+	// WriteContext is Write, aborted when ctx is done
+	WriteContext(ctx context.Context, path string, data []byte, offset int64, flags WriteFlag) (int64, error)
+
+	// ReadDirContext is ReadDir, aborted when ctx is done
+	ReadDirContext(ctx context.Context, path string) ([]FileInfo, error)
+}
@@ -0,0 +1,118 @@
+package filesystem
+
+import (
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Incrementer is implemented by file systems that can update counter files
+// atomically. A counter file holds a decimal integer followed by a newline;
+// a missing or empty file counts as zero.
+type Incrementer interface {
+	// Increment adds delta to the counter at path, creating it if needed,
+	// and returns the new value.
+	// Returns ErrNotSupported if the file system cannot do it natively.
+	Increment(path string, delta int64) (int64, error)
+}
+
+// ParseCounter parses the content of a counter file
+func ParseCounter(path string, data []byte) (int64, error) {
+	s := strings.TrimSpace(string(data))
+	if s == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, NewInvalidArgumentError("path", path, "does not hold an integer counter")
+	}
+	return value, nil
+}
+
+// FormatCounter formats a counter value as file content
+func FormatCounter(value int64) []byte {
+	return []byte(strconv.FormatInt(value, 10) + "\n")
+}
+
+// AddCounter adds delta to value, failing instead of wrapping around
+func AddCounter(path string, value, delta int64) (int64, error) {
+	if (delta > 0 && value > math.MaxInt64-delta) || (delta < 0 && value < math.MinInt64-delta) {
+		return 0, NewInvalidArgumentError("delta", strconv.FormatInt(delta, 10), "counter "+path+" would overflow")
+	}
+	return value + delta, nil
+}
+
+// pathLocks serializes read-modify-write increments and log appends per
+// path for file systems without native support. Entries are dropped when
+// unused.
+var pathLocks = struct {
+	mu    sync.Mutex
+	paths map[string]*pathLock
+}{paths: make(map[string]*pathLock)}
+
+type pathLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func lockPath(path string) func() {
+	pathLocks.mu.Lock()
+	l, ok := pathLocks.paths[path]
+	if !ok {
+		l = &pathLock{}
+		pathLocks.paths[path] = l
+	}
+	l.refs++
+	pathLocks.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		pathLocks.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(pathLocks.paths, path)
+		}
+		pathLocks.mu.Unlock()
+	}
+}
+
+// Increment adds delta to the counter at path and returns the new value,
+// using the file system's Incrementer when available. Otherwise the file is
+// read and rewritten under a per-path lock, which makes increments atomic
+// as long as every writer of the counter goes through this server.
+func Increment(fs FileSystem, path string, delta int64) (int64, error) {
+	path = NormalizePath(path)
+	if inc, ok := fs.(Incrementer); ok {
+		value, err := inc.Increment(path, delta)
+		if err != ErrNotSupported {
+			return value, err
+		}
+	}
+
+	unlock := lockPath(path)
+	defer unlock()
+
+	var current int64
+	if info, err := fs.Stat(path); err == nil {
+		if info.IsDir {
+			return 0, NewInvalidArgumentError("path", path, "is a directory")
+		}
+		data, err := fs.Read(path, 0, -1)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if current, err = ParseCounter(path, data); err != nil {
+			return 0, err
+		}
+	}
+	value, err := AddCounter(path, current, delta)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := fs.Write(path, FormatCounter(value), -1, WriteFlagCreate|WriteFlagTruncate); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
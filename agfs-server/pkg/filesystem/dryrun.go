@@ -0,0 +1,243 @@
+package filesystem
+
+import (
+	"path"
+)
+
+// Operations that can be previewed with DryRun
+const (
+	DryRunWrite    = "write"
+	DryRunCreate   = "create"
+	DryRunMkdir    = "mkdir"
+	DryRunRemove   = "remove"
+	DryRunRename   = "rename"
+	DryRunChmod    = "chmod"
+	DryRunTruncate = "truncate"
+	DryRunTouch    = "touch"
+	DryRunSymlink  = "symlink"
+)
+
+// MaxDryRunEffects bounds the effects listed for one operation; the totals
+// still cover everything
+const MaxDryRunEffects = 1000
+
+// DryRunOp describes a mutating operation to preview
+type DryRunOp struct {
+	Op        string    // One of the DryRun* operations
+	Path      string    // Path the operation applies to
+	Target    string    // New path for rename, link target for symlink
+	Size      int64     // Bytes to write, or the size to truncate to
+	Offset    int64     // Write offset; -1 for the default
+	Flags     WriteFlag // Write flags
+	Mode      uint32    // Mode for mkdir and chmod
+	Recursive bool      // Remove directories with their contents
+}
+
+// DryRunEffect is one change an operation would make
+type DryRunEffect struct {
+	Action string `json:"action"` // create, overwrite, append, delete, trash, rename, chmod, truncate, touch, symlink
+	Path   string `json:"path"`
+	Target string `json:"target,omitempty"` // New path of a rename, target of a symlink
+	IsDir  bool   `json:"isDir,omitempty"`
+	Bytes  int64  `json:"bytes,omitempty"` // Bytes written, or bytes removed by delete, trash and truncate
+}
+
+// DryRunVerdict is the decision of one check on an operation
+type DryRunVerdict struct {
+	Policy  string `json:"policy"` // What decided, e.g. "precondition", "snapshot", "homes", "quota"
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// DryRunResult describes what an operation would do
+type DryRunResult struct {
+	Op        string          `json:"op"`
+	Path      string          `json:"path"`
+	Allowed   bool            `json:"allowed"` // False if any verdict denies the operation
+	Effects   []DryRunEffect  `json:"effects"`
+	Paths     int             `json:"paths"`               // Paths affected, including unlisted ones
+	Bytes     int64           `json:"bytes"`               // Bytes written or removed in total
+	Truncated bool            `json:"truncated,omitempty"` // Effects were cut at MaxDryRunEffects
+	Verdicts  []DryRunVerdict `json:"verdicts,omitempty"`
+}
+
+// AddEffect records an effect, listing it unless the list is full
+func (r *DryRunResult) AddEffect(e DryRunEffect) {
+	r.Paths++
+	r.Bytes += e.Bytes
+	if len(r.Effects) >= MaxDryRunEffects {
+		r.Truncated = true
+		return
+	}
+	r.Effects = append(r.Effects, e)
+}
+
+// Deny records a verdict against the operation
+func (r *DryRunResult) Deny(policy, reason string) {
+	r.Allowed = false
+	r.Verdicts = append(r.Verdicts, DryRunVerdict{Policy: policy, Reason: reason})
+}
+
+// Allow records a verdict for the operation
+func (r *DryRunResult) Allow(policy, reason string) {
+	r.Verdicts = append(r.Verdicts, DryRunVerdict{Policy: policy, Allowed: true, Reason: reason})
+}
+
+// DryRunner is implemented by file systems that preview mutating operations
+// themselves, typically to add verdicts of their own policies to Preview.
+// Paths in the result are relative to the file system.
+type DryRunner interface {
+	DryRun(op DryRunOp) (*DryRunResult, error)
+}
+
+// DryRun previews op on fs without executing it, through the DryRunner of
+// fs or a file system it wraps if there is one, and with Preview otherwise
+func DryRun(fs FileSystem, op DryRunOp) (*DryRunResult, error) {
+	if runner, ok := As[DryRunner](fs); ok {
+		return runner.DryRun(op)
+	}
+	return Preview(fs, op)
+}
+
+// Preview derives what op would do on fs from Stat and ReadDir. It checks
+// the preconditions every file system shares, such as the path existing,
+// under the "precondition" policy.
+func Preview(fs FileSystem, op DryRunOp) (*DryRunResult, error) {
+	op.Path = NormalizePath(op.Path)
+	result := &DryRunResult{Op: op.Op, Path: op.Path, Allowed: true, Effects: []DryRunEffect{}}
+
+	// Plugins report missing paths with assorted errors, so any Stat
+	// failure counts as the path not existing
+	info, err := fs.Stat(op.Path)
+	exists := err == nil
+	deny := func(reason string) (*DryRunResult, error) {
+		result.Deny("precondition", reason)
+		return result, nil
+	}
+	needParent := func() bool {
+		parent, err := fs.Stat(path.Dir(op.Path))
+		return err == nil && parent.IsDir
+	}
+
+	switch op.Op {
+	case DryRunWrite, DryRunCreate:
+		size := op.Size
+		if op.Op == DryRunCreate {
+			size = 0
+		}
+		switch {
+		case !exists && !needParent():
+			return deny("parent directory does not exist")
+		case !exists:
+			result.AddEffect(DryRunEffect{Action: "create", Path: op.Path, Bytes: size})
+		case info.IsDir:
+			return deny("path is a directory")
+		case op.Flags&WriteFlagExclusive != 0:
+			return deny("file already exists")
+		case op.Flags&WriteFlagAppend != 0:
+			result.AddEffect(DryRunEffect{Action: "append", Path: op.Path, Bytes: size})
+		default:
+			result.AddEffect(DryRunEffect{Action: "overwrite", Path: op.Path, Bytes: size})
+		}
+
+	case DryRunMkdir:
+		switch {
+		case exists:
+			return deny("path already exists")
+		case !needParent():
+			return deny("parent directory does not exist")
+		}
+		result.AddEffect(DryRunEffect{Action: "create", Path: op.Path, IsDir: true})
+
+	case DryRunRemove:
+		if !exists {
+			return deny("path does not exist")
+		}
+		if info.IsDir && !op.Recursive {
+			entries, err := fs.ReadDir(op.Path)
+			if err != nil {
+				return nil, err
+			}
+			if len(entries) > 0 {
+				return deny("directory is not empty")
+			}
+		}
+		previewRemove(fs, op.Path, info, result)
+
+	case DryRunRename:
+		if !exists {
+			return deny("path does not exist")
+		}
+		target := NormalizePath(op.Target)
+		if target == op.Path || (info.IsDir && isBelow(target, op.Path)) {
+			return deny("cannot move a path into itself")
+		}
+		if _, err := fs.Stat(path.Dir(target)); err != nil {
+			return deny("target directory does not exist")
+		}
+		if replaced, err := fs.Stat(target); err == nil {
+			result.AddEffect(DryRunEffect{Action: "delete", Path: target, IsDir: replaced.IsDir, Bytes: fileBytes(replaced)})
+		}
+		result.AddEffect(DryRunEffect{Action: "rename", Path: op.Path, Target: target, IsDir: info.IsDir})
+
+	case DryRunChmod:
+		if !exists {
+			return deny("path does not exist")
+		}
+		result.AddEffect(DryRunEffect{Action: "chmod", Path: op.Path, IsDir: info.IsDir})
+
+	case DryRunTruncate:
+		switch {
+		case !exists:
+			return deny("path does not exist")
+		case info.IsDir:
+			return deny("path is a directory")
+		}
+		result.AddEffect(DryRunEffect{Action: "truncate", Path: op.Path, Bytes: max(info.Size-op.Size, 0)})
+
+	case DryRunTouch:
+		switch {
+		case exists:
+			result.AddEffect(DryRunEffect{Action: "touch", Path: op.Path, IsDir: info.IsDir})
+		case !needParent():
+			return deny("parent directory does not exist")
+		default:
+			result.AddEffect(DryRunEffect{Action: "create", Path: op.Path})
+		}
+
+	case DryRunSymlink:
+		if exists {
+			return deny("path already exists")
+		}
+		result.AddEffect(DryRunEffect{Action: "symlink", Path: op.Path, Target: op.Target})
+
+	default:
+		return nil, NewInvalidArgumentError("op", op.Op, "cannot be previewed")
+	}
+	return result, nil
+}
+
+// previewRemove adds the deletion of p and everything below it
+func previewRemove(fs FileSystem, p string, info *FileInfo, result *DryRunResult) {
+	if info.IsDir {
+		if entries, err := fs.ReadDir(p); err == nil {
+			for i := range entries {
+				previewRemove(fs, path.Join(p, entries[i].Name), &entries[i], result)
+			}
+		}
+	}
+	result.AddEffect(DryRunEffect{Action: "delete", Path: p, IsDir: info.IsDir, Bytes: fileBytes(info)})
+}
+
+// fileBytes returns the bytes a file holds, 0 for directories
+func fileBytes(info *FileInfo) int64 {
+	if info.IsDir {
+		return 0
+	}
+	return info.Size
+}
+
+// isBelow reports whether p is strictly below dir
+func isBelow(p, dir string) bool {
+	return dir == "/" && p != "/" || len(p) > len(dir) && p[:len(dir)] == dir && p[len(dir)] == '/'
+}
@@ -3,6 +3,7 @@ package filesystem
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 // Standard error types for filesystem operations
@@ -26,6 +27,19 @@ var (
 
 	// ErrNotSupported indicates the operation is not supported by this filesystem
 	ErrNotSupported = errors.New("operation not supported")
+
+	// ErrQuotaExceeded indicates the operation would exceed a storage quota
+	ErrQuotaExceeded = errors.New("quota exceeded")
+
+	// ErrRateLimited indicates too many operations in too short a time
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrTimeout indicates an operation did not finish within its deadline
+	ErrTimeout = errors.New("operation timed out")
+
+	// ErrBackendUnavailable indicates the storage behind a path is failing
+	// and operations are rejected without trying it
+	ErrBackendUnavailable = errors.New("backend unavailable")
 )
 
 // NotFoundError represents a file or directory not found error with context
@@ -131,6 +145,69 @@ func (e *NotSupportedError) Is(target error) bool {
 	return target == ErrNotSupported
 }
 
+// QuotaExceededError represents a write rejected by a storage quota
+type QuotaExceededError struct {
+	Path  string
+	Limit int64 // Quota in bytes
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s: quota exceeded (limit %d bytes)", e.Path, e.Limit)
+}
+
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// RateLimitedError represents an operation rejected by a rate limit
+type RateLimitedError struct {
+	Path       string
+	RetryAfter time.Duration // When the operation may be retried
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("%s: rate limited (retry after %s)", e.Path, e.RetryAfter.Round(time.Second))
+}
+
+func (e *RateLimitedError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// TimeoutError represents an operation abandoned at its deadline
+type TimeoutError struct {
+	Path    string
+	Op      string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s: %s: timed out after %s", e.Op, e.Path, e.Timeout)
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// BackendUnavailableError represents an operation rejected because the
+// backend of its mount keeps failing
+type BackendUnavailableError struct {
+	Path       string
+	RetryAfter time.Duration // When the backend will be tried again
+	Cause      string        // Last backend error
+}
+
+func (e *BackendUnavailableError) Error() string {
+	msg := fmt.Sprintf("%s: backend unavailable (retry after %s)", e.Path, e.RetryAfter.Round(time.Second))
+	if e.Cause != "" {
+		msg += ": " + e.Cause
+	}
+	return msg
+}
+
+func (e *BackendUnavailableError) Is(target error) bool {
+	return target == ErrBackendUnavailable
+}
+
 // Helper functions to create common errors
 
 // NewNotFoundError creates a new NotFoundError
@@ -162,3 +239,23 @@ func NewNotDirectoryError(path string) error {
 func NewNotSupportedError(op, path string) error {
 	return &NotSupportedError{Op: op, Path: path}
 }
+
+// NewQuotaExceededError creates a new QuotaExceededError
+func NewQuotaExceededError(path string, limit int64) error {
+	return &QuotaExceededError{Path: path, Limit: limit}
+}
+
+// NewRateLimitedError creates a new RateLimitedError
+func NewRateLimitedError(path string, retryAfter time.Duration) error {
+	return &RateLimitedError{Path: path, RetryAfter: retryAfter}
+}
+
+// NewTimeoutError creates a new TimeoutError
+func NewTimeoutError(op, path string, timeout time.Duration) error {
+	return &TimeoutError{Op: op, Path: path, Timeout: timeout}
+}
+
+// NewBackendUnavailableError creates a new BackendUnavailableError
+func NewBackendUnavailableError(path string, retryAfter time.Duration, cause string) error {
+	return &BackendUnavailableError{Path: path, RetryAfter: retryAfter, Cause: cause}
+}
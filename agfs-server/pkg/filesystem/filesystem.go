@@ -26,8 +26,27 @@ const (
 
 	// WriteFlagSync syncs the file after writing (fsync)
 	WriteFlagSync WriteFlag = 1 << 4
+
+	// WriteFlagAtomic replaces the whole file in one step so concurrent readers
+	// see either the old or the new content, never a partial write.
+	// Only valid for whole-file writes (offset < 0, no append).
+	WriteFlagAtomic WriteFlag = 1 << 5
 )
 
+// ValidateAtomicWrite checks that an atomic write is a whole-file replacement
+func ValidateAtomicWrite(path string, offset int64, flags WriteFlag) error {
+	if flags&WriteFlagAtomic == 0 {
+		return nil
+	}
+	if flags&WriteFlagAppend != 0 {
+		return NewInvalidArgumentError("flags", "atomic|append", "atomic writes cannot append")
+	}
+	if offset > 0 {
+		return NewInvalidArgumentError("offset", offset, "atomic writes replace the whole file")
+	}
+	return nil
+}
+
 // OpenFlag defines file open flags (similar to os.O_* flags)
 type OpenFlag int
 
@@ -56,6 +75,13 @@ type FileInfo struct {
 	ModTime time.Time
 	IsDir   bool
 	Meta    MetaData // Structured metadata for additional information
+
+	// Checksum is an optional content hash formatted as "<algorithm>:<hex>"
+	// (see FormatChecksum). Empty when the file system doesn't know it cheaply.
+	Checksum string
+
+	// ExpiresAt is when the file expires (see Expirer). Zero if it doesn't.
+	ExpiresAt time.Time
 }
 
 // FileSystem defines the interface for a POSIX-like file system
@@ -147,3 +173,10 @@ type Symlinker interface {
 	// Returns the target path and error if the operation fails
 	Readlink(linkPath string) (string, error)
 }
+
+// PathResolver is implemented by file systems whose symbolic links can lead
+// a path to another one, so that access checks can see where it leads
+type PathResolver interface {
+	// ResolvePath returns path with the symbolic links along it resolved
+	ResolvePath(path string) (string, error)
+}
@@ -1,6 +1,7 @@
 package filesystem
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -249,3 +250,27 @@ func TestFileInfo(t *testing.T) {
 		t.Errorf("Meta.Content[key]: got %s, want value", info.Meta.Content["key"])
 	}
 }
+
+func TestMatchAnyGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		want    bool
+	}{
+		{"*.md", "docs/a.md", true}, // Matches the base name
+		{"docs/*.md", "docs/a.md", true},
+		{"docs/*.md", "docs/api/a.md", false},
+		{"**/*.md", "a.md", true},
+		{"**/*.md", "docs/api/a.md", true},
+		{"docs/**", "docs/api/a.md", true},
+		{"docs/**/a.md", "docs/a.md", true},
+		{"docs/**/a.md", "other/a.md", false},
+		{"**/*.md", "docs/a.txt", false},
+	}
+	for _, tt := range tests {
+		name := tt.rel[strings.LastIndex(tt.rel, "/")+1:]
+		if got := MatchAnyGlob([]string{tt.pattern}, name, tt.rel); got != tt.want {
+			t.Errorf("MatchAnyGlob(%q, %q) = %v, want %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+}
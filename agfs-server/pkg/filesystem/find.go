@@ -0,0 +1,144 @@
+package filesystem
+
+import (
+	"errors"
+	"path"
+	"time"
+)
+
+// Find entry types
+const (
+	FindTypeFile = "f"
+	FindTypeDir  = "d"
+)
+
+// errFindLimit stops a find once the result limit is reached
+var errFindLimit = errors.New("find limit reached")
+
+// FindOptions selects entries in a subtree, modeled on find(1)
+type FindOptions struct {
+	// Name keeps entries whose base name matches the glob (path.Match syntax).
+	// Empty matches all names.
+	Name string
+
+	// Type is FindTypeFile, FindTypeDir, or empty for both
+	Type string
+
+	// ModifiedWithin keeps entries modified less than this long ago (find -mtime -N)
+	ModifiedWithin time.Duration
+
+	// ModifiedBefore keeps entries modified more than this long ago (find -mtime +N)
+	ModifiedBefore time.Duration
+
+	// MaxDepth limits how deep the search descends. 0 means unlimited.
+	MaxDepth int
+
+	// Limit stops the search after this many matches. 0 means unlimited.
+	Limit int
+}
+
+// Finder is implemented by file systems that can search a subtree more
+// efficiently than a generic walk, e.g. by filtering a flat prefix listing
+// server-side. Implementations must apply MatchFind to every candidate and
+// honour MaxDepth; Find enforces Limit.
+type Finder interface {
+	Find(root string, opts FindOptions, fn WalkFunc) error
+}
+
+// ValidateFindOptions checks that the options are well formed
+func ValidateFindOptions(opts FindOptions) error {
+	if opts.Name != "" {
+		if _, err := path.Match(opts.Name, ""); err != nil {
+			return NewInvalidArgumentError("name", opts.Name, err.Error())
+		}
+	}
+	if opts.Type != "" && opts.Type != FindTypeFile && opts.Type != FindTypeDir {
+		return NewInvalidArgumentError("type", opts.Type, "must be f or d")
+	}
+	if opts.ModifiedWithin < 0 || opts.ModifiedBefore < 0 {
+		return NewInvalidArgumentError("mtime", opts.ModifiedWithin, "must be non-negative")
+	}
+	if opts.MaxDepth < 0 {
+		return NewInvalidArgumentError("max_depth", opts.MaxDepth, "must be non-negative")
+	}
+	if opts.Limit < 0 {
+		return NewInvalidArgumentError("limit", opts.Limit, "must be non-negative")
+	}
+	return nil
+}
+
+// MatchFind reports whether an entry satisfies the name, type and mtime filters.
+// It is exported so Finder implementations apply identical filtering.
+func MatchFind(opts FindOptions, info FileInfo, now time.Time) bool {
+	switch opts.Type {
+	case FindTypeFile:
+		if info.IsDir {
+			return false
+		}
+	case FindTypeDir:
+		if !info.IsDir {
+			return false
+		}
+	}
+	if opts.Name != "" {
+		if ok, _ := path.Match(opts.Name, info.Name); !ok {
+			return false
+		}
+	}
+	age := now.Sub(info.ModTime)
+	if opts.ModifiedWithin > 0 && age > opts.ModifiedWithin {
+		return false
+	}
+	if opts.ModifiedBefore > 0 && age < opts.ModifiedBefore {
+		return false
+	}
+	return true
+}
+
+// Find calls fn for every entry under root that matches opts. If fs implements
+// Finder, the search is delegated to it. It returns truncated=true if the
+// search stopped at opts.Limit.
+func Find(fs FileSystem, root string, opts FindOptions, fn WalkFunc) (truncated bool, err error) {
+	if err := ValidateFindOptions(opts); err != nil {
+		return false, err
+	}
+	if finder, ok := As[Finder](fs); ok {
+		return limitFind(opts.Limit, fn, func(fn WalkFunc) error {
+			return finder.Find(root, opts, fn)
+		})
+	}
+	return FindByWalk(fs, root, opts, fn)
+}
+
+// FindByWalk implements Find with a generic walk, ignoring any Finder
+// implementation on fs. Finder implementations can use it as a fallback.
+func FindByWalk(fs FileSystem, root string, opts FindOptions, fn WalkFunc) (truncated bool, err error) {
+	if err := ValidateFindOptions(opts); err != nil {
+		return false, err
+	}
+	now := time.Now()
+	return limitFind(opts.Limit, fn, func(fn WalkFunc) error {
+		return Walk(fs, root, WalkOptions{MaxDepth: opts.MaxDepth}, func(entry WalkEntry) error {
+			if !MatchFind(opts, entry.Info, now) {
+				return nil
+			}
+			return fn(entry)
+		})
+	})
+}
+
+// limitFind runs search with fn wrapped to stop after limit matches
+func limitFind(limit int, fn WalkFunc, search func(WalkFunc) error) (bool, error) {
+	count := 0
+	err := search(func(entry WalkEntry) error {
+		if limit > 0 && count >= limit {
+			return errFindLimit
+		}
+		count++
+		return fn(entry)
+	})
+	if err == errFindLimit {
+		return true, nil
+	}
+	return false, err
+}
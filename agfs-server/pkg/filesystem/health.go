@@ -0,0 +1,91 @@
+package filesystem
+
+import (
+	"sync"
+	"time"
+)
+
+// HealthNormal is the mode of a file system with all its dependencies up
+const HealthNormal = "normal"
+
+// Health is the operating mode of a file system that keeps serving with
+// reduced function when a soft dependency fails, e.g. read-only while its
+// credentials are expired
+type Health struct {
+	Mode         string    `json:"mode"`             // HealthNormal or one of Modes
+	Reason       string    `json:"reason,omitempty"` // Why the file system is degraded
+	Since        time.Time `json:"since"`            // When it entered Mode
+	Modes        []string  `json:"modes"`            // Degraded modes the file system declares
+	Degradations int64     `json:"degradations"`     // Times it left normal mode
+}
+
+// Degraded reports whether the file system is in a degraded mode
+func (h Health) Degraded() bool {
+	return h.Mode != HealthNormal
+}
+
+// HealthReporter is implemented by file systems that declare degraded modes
+type HealthReporter interface {
+	// Health returns the current mode
+	Health() Health
+}
+
+// HealthState tracks the mode of a HealthReporter. It is safe for
+// concurrent use.
+type HealthState struct {
+	modes []string
+
+	mu           sync.RWMutex
+	mode         string
+	reason       string
+	since        time.Time
+	degradations int64
+}
+
+// NewHealthState creates a state in normal mode, for a file system
+// declaring the given degraded modes
+func NewHealthState(modes ...string) *HealthState {
+	return &HealthState{modes: modes, mode: HealthNormal, since: time.Now()}
+}
+
+// Enter switches to a degraded mode, or updates the reason if already in
+// it. It reports whether the mode changed.
+func (s *HealthState) Enter(mode, reason string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reason = reason
+	if s.mode == mode {
+		return false
+	}
+	if s.mode == HealthNormal {
+		s.degradations++
+	}
+	s.mode = mode
+	s.since = time.Now()
+	return true
+}
+
+// Recover switches back to normal mode. It reports whether the mode changed.
+func (s *HealthState) Recover() bool {
+	return s.Enter(HealthNormal, "")
+}
+
+// Mode returns the current mode
+func (s *HealthState) Mode() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mode
+}
+
+// Health returns the current mode as a Health
+func (s *HealthState) Health() Health {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Health{
+		Mode:         s.mode,
+		Reason:       s.reason,
+		Since:        s.since,
+		Modes:        append([]string(nil), s.modes...),
+		Degradations: s.degradations,
+	}
+}
@@ -0,0 +1,138 @@
+package filesystem
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Appender is implemented by file systems that can append to a file
+// atomically, so that concurrent appends never interleave or overwrite each
+// other
+type Appender interface {
+	// Append writes data at the end of the file at path, creating it if
+	// needed, and returns the offset it was written at.
+	// Returns ErrNotSupported if the file system cannot do it natively.
+	Append(path string, data []byte) (int64, error)
+}
+
+// LogRecord is a record of a log file, a journal that many clients append
+// records to. Each record is stored as a line of JSON holding its data and
+// optionally when and by whom it was appended, so records stay whole however
+// many writers append at once, and readers resume from the offset after the
+// last record they read.
+type LogRecord struct {
+	Offset   int64  `json:"offset"`             // Byte offset of the record in the file
+	Time     string `json:"time,omitempty"`     // When it was appended (RFC 3339), if timestamped
+	Source   string `json:"source,omitempty"`   // Who appended it, as named by the client
+	Data     string `json:"data"`               // Content of the record
+	Encoding string `json:"encoding,omitempty"` // "base64" for data that is not UTF-8
+}
+
+// logLine is a record as stored in a log file, which has no offset
+type logLine struct {
+	Time     string `json:"time,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Data     string `json:"data"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// LogAppendOptions describes a record to append
+type LogAppendOptions struct {
+	Source    string // Who appends the record; empty leaves it out
+	Timestamp bool   // Whether to record when the record was appended
+}
+
+// NewLogRecord returns the record of data, base64-encoded if it is not UTF-8
+func NewLogRecord(data []byte, opts LogAppendOptions, now time.Time) LogRecord {
+	rec := LogRecord{Source: opts.Source, Data: string(data)}
+	if !utf8.Valid(data) {
+		rec.Data = base64.StdEncoding.EncodeToString(data)
+		rec.Encoding = "base64"
+	}
+	if opts.Timestamp {
+		rec.Time = now.UTC().Format(time.RFC3339Nano)
+	}
+	return rec
+}
+
+// EncodeLogRecord returns a record as stored in a log file, ending in a
+// newline
+func EncodeLogRecord(rec LogRecord) []byte {
+	line, _ := json.Marshal(logLine{Time: rec.Time, Source: rec.Source, Data: rec.Data, Encoding: rec.Encoding})
+	return append(line, '\n')
+}
+
+// AppendLog appends a record of data to the log file at path, creating it
+// if needed, and returns the record with its offset. The record is written
+// with the file system's Appender when available. Otherwise the file is
+// appended to under a per-path lock, which keeps records whole as long as
+// every writer of the log goes through this server.
+func AppendLog(fs FileSystem, path string, data []byte, opts LogAppendOptions) (LogRecord, error) {
+	path = NormalizePath(path)
+	rec := NewLogRecord(data, opts, time.Now())
+	line := EncodeLogRecord(rec)
+
+	if appender, ok := fs.(Appender); ok {
+		offset, err := appender.Append(path, line)
+		if err != ErrNotSupported {
+			rec.Offset = offset
+			return rec, err
+		}
+	}
+
+	unlock := lockPath(path)
+	defer unlock()
+
+	if info, err := fs.Stat(path); err == nil {
+		if info.IsDir {
+			return rec, NewInvalidArgumentError("path", path, "is a directory")
+		}
+		rec.Offset = info.Size
+	}
+	if _, err := fs.Write(path, line, -1, WriteFlagCreate|WriteFlagAppend); err != nil {
+		return rec, err
+	}
+	return rec, nil
+}
+
+// ReadLog returns up to limit records (all if limit <= 0) of the log file at
+// path from offset, which must be the start of a record, and the offset to
+// read the next ones from. A record still being written is left for the
+// next read.
+func ReadLog(fs FileSystem, path string, offset int64, limit int) ([]LogRecord, int64, error) {
+	if offset < 0 {
+		return nil, offset, NewInvalidArgumentError("offset", strconv.FormatInt(offset, 10), "must not be negative")
+	}
+	data, err := fs.Read(NormalizePath(path), offset, -1)
+	if err != nil && err != io.EOF {
+		return nil, offset, err
+	}
+
+	records := []LogRecord{}
+	for limit <= 0 || len(records) < limit {
+		end := bytes.IndexByte(data, '\n')
+		if end == -1 {
+			break
+		}
+		var line logLine
+		if err := json.Unmarshal(data[:end], &line); err != nil {
+			return nil, offset, NewInvalidArgumentError("offset", strconv.FormatInt(offset, 10),
+				"is not the start of a log record of "+path)
+		}
+		records = append(records, LogRecord{
+			Offset:   offset,
+			Time:     line.Time,
+			Source:   line.Source,
+			Data:     line.Data,
+			Encoding: line.Encoding,
+		})
+		offset += int64(end + 1)
+		data = data[end+1:]
+	}
+	return records, offset, nil
+}
@@ -0,0 +1,181 @@
+package filesystem
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// Meta.Content keys describing a file's content
+const (
+	MetaContentType = "content_type" // Media type, e.g. "text/markdown; charset=utf-8"
+	MetaEncoding    = "encoding"     // Text encoding ("utf-8", "utf-16le", "utf-16be") or "binary"
+)
+
+// SniffLen is how many leading bytes content detection looks at
+const SniffLen = 512
+
+// ContentTyper is implemented by file systems that can store a media type
+// for a file, overriding detection. Stat reports a stored type in
+// Meta.Content[MetaContentType].
+type ContentTyper interface {
+	// SetContentType stores contentType for the file at path.
+	// An empty contentType clears the override.
+	SetContentType(path string, contentType string) error
+}
+
+// extensionTypes covers common agent-produced formats that the mime
+// package does not know on every platform
+var extensionTypes = map[string]string{
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".txt":      "text/plain",
+	".log":      "text/plain",
+	".csv":      "text/csv",
+	".tsv":      "text/tab-separated-values",
+	".json":     "application/json",
+	".ndjson":   "application/x-ndjson",
+	".jsonl":    "application/x-ndjson",
+	".yaml":     "application/yaml",
+	".yml":      "application/yaml",
+	".toml":     "application/toml",
+	".xml":      "application/xml",
+	".html":     "text/html",
+	".htm":      "text/html",
+	".css":      "text/css",
+	".js":       "text/javascript",
+	".ts":       "text/x-typescript",
+	".go":       "text/x-go",
+	".py":       "text/x-python",
+	".rs":       "text/x-rust",
+	".sh":       "text/x-shellscript",
+	".sql":      "application/sql",
+	".svg":      "image/svg+xml",
+	".pdf":      "application/pdf",
+	".wasm":     "application/wasm",
+	".parquet":  "application/vnd.apache.parquet",
+}
+
+// typeByExtension returns the media type registered for name's extension
+func typeByExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if t, ok := extensionTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		t, _, _ = strings.Cut(t, ";")
+		return t
+	}
+	return ""
+}
+
+// DetectEncoding reports the text encoding of head, the leading bytes of a
+// file, or "binary" if it does not look like text
+func DetectEncoding(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8"
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return "binary"
+	}
+	// head may end in the middle of a multi-byte character
+	for i := 0; i < utf8.UTFMax && len(head) > 0 && !utf8.Valid(head); i++ {
+		head = head[:len(head)-1]
+	}
+	if !utf8.Valid(head) {
+		return "binary"
+	}
+	return "utf-8"
+}
+
+// DetectContentType returns the media type of a file named name whose
+// content starts with head. Content sniffing decides for formats it
+// recognizes; plain text and unrecognized binary data are refined by the
+// file extension. Text types carry their charset.
+func DetectContentType(name string, head []byte) string {
+	if len(head) > SniffLen {
+		head = head[:SniffLen]
+	}
+	encoding := DetectEncoding(head)
+
+	sniffed := http.DetectContentType(head)
+	base, _, _ := strings.Cut(sniffed, ";")
+	contentType := base
+	if base == "text/plain" || base == "application/octet-stream" {
+		if byExt := typeByExtension(name); byExt != "" && (encoding != "binary" || !isTextType(byExt)) {
+			contentType = byExt
+		} else if base == "application/octet-stream" && encoding != "binary" && len(head) > 0 {
+			contentType = "text/plain"
+		}
+	}
+	if isTextType(contentType) && encoding != "binary" {
+		contentType += "; charset=" + encoding
+	}
+	return contentType
+}
+
+// isTextType reports whether contentType is textual
+func isTextType(contentType string) bool {
+	switch {
+	case strings.HasPrefix(contentType, "text/"):
+		return true
+	case strings.HasSuffix(contentType, "+xml"), strings.HasSuffix(contentType, "+json"):
+		return true
+	}
+	switch contentType {
+	case "application/json", "application/x-ndjson", "application/xml", "application/yaml",
+		"application/toml", "application/sql", "application/javascript":
+		return true
+	}
+	return false
+}
+
+// ContentDescriber is implemented by file systems that decide per path how
+// content is described, such as MountableFS routing to its mounts
+type ContentDescriber interface {
+	DescribeContent(path string, info *FileInfo) (contentType, encoding string)
+}
+
+// DescribeContent returns the media type and encoding of the file at path
+// in fs, whose Stat result is info. A type stored through ContentTyper
+// wins. Otherwise the leading bytes are sniffed, but only on file systems
+// implementing ContentTyper: those hold plain data, while reading the
+// files of other file systems (queues, streams) may have side effects.
+// Such files are described by their extension alone.
+func DescribeContent(fs FileSystem, path string, info *FileInfo) (contentType, encoding string) {
+	if d, ok := fs.(ContentDescriber); ok {
+		return d.DescribeContent(path, info)
+	}
+	if info.IsDir {
+		return "", ""
+	}
+
+	contentType = info.Meta.Content[MetaContentType]
+	if _, ok := fs.(ContentTyper); !ok {
+		if contentType == "" {
+			contentType = typeByExtension(info.Name)
+		}
+		return contentType, ""
+	}
+
+	head, err := fs.Read(path, 0, SniffLen)
+	if err != nil && err != io.EOF {
+		return contentType, ""
+	}
+	if contentType == "" {
+		contentType = DetectContentType(info.Name, head)
+	}
+	return contentType, DetectEncoding(head)
+}
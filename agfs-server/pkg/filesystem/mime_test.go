@@ -0,0 +1,46 @@
+package filesystem
+
+import "testing"
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"notes.md", []byte("# Title\n\nbody"), "text/markdown; charset=utf-8"},
+		{"data.json", []byte(`{"a": 1}`), "application/json; charset=utf-8"},
+		{"run.log", []byte("line one\n"), "text/plain; charset=utf-8"},
+		{"noext", []byte("plain words"), "text/plain; charset=utf-8"},
+		{"image.bin", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png"},
+		{"blob", []byte{0x00, 0x01, 0x02, 0xff}, "application/octet-stream"},
+		{"report.pdf", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"fake.md", []byte{0x00, 0x01, 0x02}, "application/octet-stream"},
+		{"empty.txt", nil, "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		if got := DetectContentType(tt.name, tt.head); got != tt.want {
+			t.Errorf("DetectContentType(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		head []byte
+		want string
+	}{
+		{[]byte("hello"), "utf-8"},
+		{[]byte("caf\xc3\xa9"), "utf-8"},
+		{[]byte("caf\xc3"), "utf-8"}, // cut inside a character
+		{[]byte{0xFF, 0xFE, 'h', 0}, "utf-16le"},
+		{[]byte{0xFE, 0xFF, 0, 'h'}, "utf-16be"},
+		{[]byte{'a', 0, 'b'}, "binary"},
+		{[]byte{0xff, 0xfd, 0x80, 0x80, 0x80, 0x80, 'a'}, "binary"},
+	}
+	for _, tt := range tests {
+		if got := DetectEncoding(tt.head); got != tt.want {
+			t.Errorf("DetectEncoding(%q) = %q, want %q", tt.head, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package filesystem
+
+// NameLister is implemented by file systems that list a directory more
+// cheaply when only the names of its entries are needed, e.g. S3 without
+// checking that the prefix exists, or a database without reading sizes.
+// Shell completion and `ls -1` style listings use it.
+type NameLister interface {
+	// ReadDirNames is ReadDir returning entries with only Name and IsDir set
+	ReadDirNames(path string) ([]FileInfo, error)
+}
@@ -0,0 +1,10 @@
+package filesystem
+
+// Prefetcher is implemented by file systems with a content cache, such as
+// the cache mount middleware, that can load files into it ahead of reads,
+// e.g. to warm a working set before latency-sensitive work.
+type Prefetcher interface {
+	// Prefetch loads the content of the file at path into the cache.
+	// Returns ErrNotSupported for directories and files that aren't cached.
+	Prefetch(path string) error
+}
@@ -0,0 +1,100 @@
+package filesystem
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultPreviewBytes is the preview length of listings asking for
+	// previews without giving one
+	DefaultPreviewBytes = 256
+	// MaxPreviewBytes bounds the preview length of listings
+	MaxPreviewBytes = 4096
+)
+
+// FilePreview summarizes the content of a file in a directory listing, so a
+// directory can be triaged without reading each of its files
+type FilePreview struct {
+	Title     string `json:"title,omitempty"`     // Heading or title, when one is known
+	Text      string `json:"text,omitempty"`      // Leading text of the file
+	Truncated bool   `json:"truncated,omitempty"` // The file has more than Text
+}
+
+// DirPreviewer is implemented by file systems that decide how the entries of
+// their directories are previewed, e.g. from a single query for all the
+// rows of a table, or from the indexed text of documents. Entries left out
+// of the result have no preview.
+type DirPreviewer interface {
+	// PreviewDir returns previews of entries, the listing of dir, keyed by
+	// entry name, with Text of at most n bytes
+	PreviewDir(dir string, entries []FileInfo, n int) map[string]FilePreview
+}
+
+// PreviewDir returns previews of entries, the listing of dir in fs, keyed by
+// entry name. A DirPreviewer decides for itself. Otherwise the leading bytes of
+// text files are read, but only on file systems implementing ContentTyper,
+// for the reasons given in DescribeContent.
+func PreviewDir(fs FileSystem, dir string, entries []FileInfo, n int) map[string]FilePreview {
+	if p, ok := As[DirPreviewer](fs); ok {
+		return p.PreviewDir(dir, entries, n)
+	}
+	if _, ok := fs.(ContentTyper); !ok {
+		return nil
+	}
+
+	previews := make(map[string]FilePreview)
+	for _, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+		head, err := fs.Read(path.Join(dir, entry.Name), 0, int64(n))
+		if err != nil && err != io.EOF {
+			continue
+		}
+		if DetectEncoding(head) != "utf-8" {
+			continue
+		}
+		preview := NewFilePreview(string(bytes.TrimPrefix(head, []byte{0xEF, 0xBB, 0xBF})), n)
+		preview.Title = extractTitle(head)
+		preview.Truncated = preview.Truncated || entry.Size > int64(len(head))
+		previews[entry.Name] = preview
+	}
+	return previews
+}
+
+// NewFilePreview returns a preview of text holding at most its first n bytes,
+// cut at a character boundary
+func NewFilePreview(text string, n int) FilePreview {
+	if len(text) <= n {
+		return FilePreview{Text: text}
+	}
+	text = text[:n]
+	for len(text) > 0 && !utf8.ValidString(text) {
+		text = text[:len(text)-1]
+	}
+	return FilePreview{Text: text, Truncated: true}
+}
+
+// htmlTitle matches the title element of an HTML document
+var htmlTitle = regexp.MustCompile(`(?is)<title[^>]*>\s*(.*?)\s*</title>`)
+
+// extractTitle returns the title of an HTML document, or the first level
+// one heading of Markdown, found in head
+func extractTitle(head []byte) string {
+	if m := htmlTitle.FindSubmatch(head); m != nil {
+		return string(m[1])
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(head))
+	for scanner.Scan() {
+		if title, ok := strings.CutPrefix(scanner.Text(), "# "); ok {
+			return strings.TrimSpace(title)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package filesystem
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Reserver is implemented by file systems that can prepare for a large
+// write of known size, e.g. by preallocating disk space or starting a
+// multipart upload. File systems that don't implement it are sent a plain
+// Create.
+type Reserver interface {
+	// Reserve creates an empty file at path as a placeholder for a write
+	// of about size bytes. It fails if the file already exists.
+	Reserve(path string, size int64) error
+}
+
+// Meta.Content keys describing a reserved file while it is being written.
+// Size (or MetaReservedWritten, on file systems that only show the data
+// once the write completes) against MetaReservedSize is its progress.
+const (
+	MetaReservedSize    = "reserved_size"
+	MetaReservedWritten = "reserved_written"
+)
+
+// ValidateReserveSize checks the size hint of a reservation
+func ValidateReserveSize(size int64) error {
+	if size < 0 {
+		return NewInvalidArgumentError("size", size, "must be non-negative")
+	}
+	return nil
+}
+
+// ReservationIndex tracks the size hints of reserved files until they are
+// written in full, for file systems reporting the progress of such writes.
+// It is kept in memory, so reservations do not survive a restart.
+type ReservationIndex struct {
+	entries map[string]int64
+	mu      sync.RWMutex
+}
+
+// NewReservationIndex creates an empty reservation index
+func NewReservationIndex() *ReservationIndex {
+	return &ReservationIndex{entries: make(map[string]int64)}
+}
+
+// Set records the size hint of path. A size of 0 clears the entry.
+func (idx *ReservationIndex) Set(path string, size int64) {
+	path = NormalizePath(path)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if size <= 0 {
+		delete(idx.entries, path)
+		return
+	}
+	idx.entries[path] = size
+}
+
+// Get returns the size hint of path, or 0 if it is not reserved
+func (idx *ReservationIndex) Get(path string) int64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.entries[NormalizePath(path)]
+}
+
+// Written forgets path once written bytes reached its size hint
+func (idx *ReservationIndex) Written(path string, written int64) {
+	if size := idx.Get(path); size > 0 && written >= size {
+		idx.Set(path, 0)
+	}
+}
+
+// Remove forgets path and everything below it
+func (idx *ReservationIndex) Remove(path string) {
+	path = NormalizePath(path)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for p := range idx.entries {
+		if p == path || isUnder(p, path) {
+			delete(idx.entries, p)
+		}
+	}
+}
+
+// Rename moves the entries for oldPath and everything below it to newPath
+func (idx *ReservationIndex) Rename(oldPath, newPath string) {
+	oldPath, newPath = NormalizePath(oldPath), NormalizePath(newPath)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for p, size := range idx.entries {
+		if p == oldPath || isUnder(p, oldPath) {
+			delete(idx.entries, p)
+			idx.entries[newPath+strings.TrimPrefix(p, oldPath)] = size
+		}
+	}
+}
+
+// Annotate adds the size hint of path to the metadata of info, unless
+// info.Size already reached it
+func (idx *ReservationIndex) Annotate(path string, info *FileInfo) {
+	idx.Written(path, info.Size)
+	size := idx.Get(path)
+	if size == 0 {
+		return
+	}
+	if info.Meta.Content == nil {
+		info.Meta.Content = make(map[string]string)
+	}
+	info.Meta.Content[MetaReservedSize] = strconv.FormatInt(size, 10)
+}
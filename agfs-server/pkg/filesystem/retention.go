@@ -0,0 +1,51 @@
+package filesystem
+
+import "time"
+
+// Retention protects a path, and everything below it, from being deleted
+// or overwritten: until RetainUntil, and for as long as LegalHold is set
+type Retention struct {
+	RetainUntil time.Time `json:"retain_until,omitzero"`
+	LegalHold   bool      `json:"legal_hold,omitempty"`
+}
+
+// Active reports whether the retention protects its path as of now
+func (r Retention) Active(now time.Time) bool {
+	return r.LegalHold || now.Before(r.RetainUntil)
+}
+
+// Merge returns the retention protecting a path under both r and other
+func (r Retention) Merge(other Retention) Retention {
+	if other.RetainUntil.After(r.RetainUntil) {
+		r.RetainUntil = other.RetainUntil
+	}
+	r.LegalHold = r.LegalHold || other.LegalHold
+	return r
+}
+
+// Reason describes why an active retention denies an operation
+func (r Retention) Reason() string {
+	if r.LegalHold {
+		return "under legal hold"
+	}
+	return "retained until " + r.RetainUntil.UTC().Format(time.RFC3339)
+}
+
+// Retainer is implemented by file systems that keep retention on paths.
+// Plugins implement it to back the retention enforced by their mount with
+// their storage's own, e.g. S3 Object Lock; they return ErrNotSupported for
+// paths they can't protect.
+type Retainer interface {
+	// SetRetention replaces the retention set on path. The retain-until
+	// time of an active retention can only be extended.
+	SetRetention(path string, r Retention) error
+}
+
+// RetentionReporter is implemented by file systems that report the
+// retention of paths
+type RetentionReporter interface {
+	// GetRetention returns the retention set on path itself, and the one
+	// in effect for it, which includes what it inherits from the
+	// directories above it
+	GetRetention(path string) (own Retention, effective Retention, err error)
+}
@@ -0,0 +1,127 @@
+package filesystem
+
+import (
+	"io"
+	"regexp"
+	"time"
+)
+
+// SnapshotsDir is the directory under a mount root where snapshots appear
+const SnapshotsDir = ".snapshots"
+
+// snapshotIDFormat sorts snapshot IDs by creation time
+const snapshotIDFormat = "20060102T150405.000000000Z"
+
+var snapshotIDPattern = regexp.MustCompile(`^\d{8}T\d{6}\.\d{9}Z$`)
+
+// Snapshot describes a point-in-time, read-only copy of a file system
+type Snapshot struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path,omitempty"` // Mount the snapshot was taken of
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Snapshotter is implemented by file systems that can take snapshots of
+// themselves. A snapshot always covers the whole file system; path must be
+// its root, or, for a MountableFS, a mount point.
+type Snapshotter interface {
+	// CreateSnapshot takes a new snapshot
+	CreateSnapshot(path string) (*Snapshot, error)
+
+	// ListSnapshots returns the existing snapshots, oldest first
+	ListSnapshots(path string) ([]Snapshot, error)
+
+	// DeleteSnapshot discards a snapshot
+	DeleteSnapshot(path string, id string) error
+
+	// OpenSnapshot returns a read-only view of a snapshot
+	OpenSnapshot(path string, id string) (FileSystem, error)
+}
+
+// NewSnapshotID returns the ID of a snapshot taken at t
+func NewSnapshotID(t time.Time) string {
+	return t.UTC().Format(snapshotIDFormat)
+}
+
+// ParseSnapshotID returns the creation time encoded in a snapshot ID
+func ParseSnapshotID(id string) (time.Time, error) {
+	if !snapshotIDPattern.MatchString(id) {
+		return time.Time{}, NewInvalidArgumentError("snapshot", id, "not a snapshot ID")
+	}
+	t, err := time.Parse(snapshotIDFormat, id)
+	if err != nil {
+		return time.Time{}, NewInvalidArgumentError("snapshot", id, "not a snapshot ID")
+	}
+	return t, nil
+}
+
+// CheckSnapshotRoot rejects snapshot requests for anything but the root of
+// a file system
+func CheckSnapshotRoot(path string) error {
+	if NormalizePath(path) != "/" {
+		return NewInvalidArgumentError("path", path, "snapshots cover the whole mount; use the mount point")
+	}
+	return nil
+}
+
+// readOnlyFS wraps a file system and rejects every modification
+type readOnlyFS struct {
+	fs FileSystem
+}
+
+// NewReadOnlyFileSystem returns a view of fs that cannot be modified
+func NewReadOnlyFileSystem(fs FileSystem) FileSystem {
+	return &readOnlyFS{fs: fs}
+}
+
+func readOnlyError(op, path string) error {
+	return NewPermissionDeniedError(op, path, "read-only file system")
+}
+
+func (r *readOnlyFS) Create(path string) error {
+	return readOnlyError("create", path)
+}
+
+func (r *readOnlyFS) Mkdir(path string, perm uint32) error {
+	return readOnlyError("mkdir", path)
+}
+
+func (r *readOnlyFS) Remove(path string) error {
+	return readOnlyError("remove", path)
+}
+
+func (r *readOnlyFS) RemoveAll(path string) error {
+	return readOnlyError("remove", path)
+}
+
+func (r *readOnlyFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	return r.fs.Read(path, offset, size)
+}
+
+func (r *readOnlyFS) Write(path string, data []byte, offset int64, flags WriteFlag) (int64, error) {
+	return 0, readOnlyError("write", path)
+}
+
+func (r *readOnlyFS) ReadDir(path string) ([]FileInfo, error) {
+	return r.fs.ReadDir(path)
+}
+
+func (r *readOnlyFS) Stat(path string) (*FileInfo, error) {
+	return r.fs.Stat(path)
+}
+
+func (r *readOnlyFS) Rename(oldPath, newPath string) error {
+	return readOnlyError("rename", oldPath)
+}
+
+func (r *readOnlyFS) Chmod(path string, mode uint32) error {
+	return readOnlyError("chmod", path)
+}
+
+func (r *readOnlyFS) Open(path string) (io.ReadCloser, error) {
+	return r.fs.Open(path)
+}
+
+func (r *readOnlyFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return nil, readOnlyError("write", path)
+}
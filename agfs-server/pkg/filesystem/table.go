@@ -0,0 +1,17 @@
+package filesystem
+
+// Table is a relation returned by a TableReader
+type Table struct {
+	Columns   []string
+	Rows      [][]interface{} // One value per column; nil is NULL
+	Truncated bool            // More rows existed than were requested
+}
+
+// TableReader is implemented by file systems that expose database tables
+// (e.g. sqlfs2), so query layers can read them as relations instead of
+// parsing files.
+type TableReader interface {
+	// ReadTable returns up to maxRows rows of the table at path (0 means all).
+	// Returns ErrNotSupported if path is not a table.
+	ReadTable(path string, maxRows int) (*Table, error)
+}
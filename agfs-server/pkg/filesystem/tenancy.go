@@ -0,0 +1,18 @@
+package filesystem
+
+// TenantScoper is implemented by file systems whose entries belong to the
+// identities that created them, such as vectorfs namespaces, so that a
+// shared server only shows each API token its own. Identity "" is an
+// anonymous caller.
+type TenantScoper interface {
+	// AuthorizeTenant returns a permission denied error if identity may not
+	// access path, or anything below it when recursive is set
+	AuthorizeTenant(identity, path string, recursive bool) error
+
+	// FilterTenant drops the entries of the directory at path that identity
+	// may not see
+	FilterTenant(identity, path string, entries []FileInfo) []FileInfo
+
+	// MkdirAs is Mkdir, making identity the owner of what it creates
+	MkdirAs(identity, path string, perm uint32) error
+}
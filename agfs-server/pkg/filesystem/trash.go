@@ -0,0 +1,22 @@
+package filesystem
+
+import "time"
+
+// DeletedEntry is a soft-deleted entry that can still be restored
+type DeletedEntry struct {
+	Name      string    `json:"name"`
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"` // When it is removed for good
+}
+
+// Restorer is implemented by file systems that keep removed entries for a
+// retention period, hidden, before removing them for good.
+type Restorer interface {
+	// ListDeleted returns the soft-deleted entries of the directory at
+	// path, oldest first
+	ListDeleted(path string) ([]DeletedEntry, error)
+
+	// Restore brings back the soft-deleted entry at path. Returns
+	// ErrNotFound if there is none.
+	Restore(path string) error
+}
@@ -0,0 +1,149 @@
+package filesystem
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Expirer is implemented by file systems that support expiring files.
+// An expired file is invisible to every operation and is removed by the
+// file system in the background.
+type Expirer interface {
+	// SetExpiry makes the file at path expire at expiresAt.
+	// A zero expiresAt clears any expiry.
+	SetExpiry(path string, expiresAt time.Time) error
+}
+
+// ParseTTL parses a TTL given either as a Go duration ("10m", "1h30m") or as
+// a number of seconds. "0" clears an expiry and returns a zero duration.
+func ParseTTL(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return 0, NewInvalidArgumentError("ttl", s, "must be non-negative")
+		}
+		return d, nil
+	}
+	d, err := time.ParseDuration(s + "s")
+	if err != nil {
+		return 0, NewInvalidArgumentError("ttl", s, "must be a duration (e.g. 10m) or a number of seconds")
+	}
+	if d < 0 {
+		return 0, NewInvalidArgumentError("ttl", s, "must be non-negative")
+	}
+	return d, nil
+}
+
+// ExpiresAt converts a TTL into an absolute expiry, mapping 0 to "no expiry"
+func ExpiresAt(ttl time.Duration) time.Time {
+	if ttl == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// ExpiryIndex tracks file expiry times for file systems without native
+// support. It is kept in memory, so expiries do not survive a restart.
+type ExpiryIndex struct {
+	entries map[string]time.Time
+	mu      sync.RWMutex
+}
+
+// NewExpiryIndex creates an empty expiry index
+func NewExpiryIndex() *ExpiryIndex {
+	return &ExpiryIndex{entries: make(map[string]time.Time)}
+}
+
+// Set records when path expires. A zero expiresAt clears the entry.
+func (idx *ExpiryIndex) Set(path string, expiresAt time.Time) {
+	path = NormalizePath(path)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if expiresAt.IsZero() {
+		delete(idx.entries, path)
+		return
+	}
+	idx.entries[path] = expiresAt
+}
+
+// Get returns when path expires, or a zero time if it does not
+func (idx *ExpiryIndex) Get(path string) time.Time {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.entries[NormalizePath(path)]
+}
+
+// Expired reports whether path has expired
+func (idx *ExpiryIndex) Expired(path string) bool {
+	expiresAt := idx.Get(path)
+	return !expiresAt.IsZero() && !time.Now().Before(expiresAt)
+}
+
+// Remove forgets path and everything below it
+func (idx *ExpiryIndex) Remove(path string) {
+	path = NormalizePath(path)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for p := range idx.entries {
+		if p == path || isUnder(p, path) {
+			delete(idx.entries, p)
+		}
+	}
+}
+
+// Rename moves the entries for oldPath and everything below it to newPath
+func (idx *ExpiryIndex) Rename(oldPath, newPath string) {
+	oldPath, newPath = NormalizePath(oldPath), NormalizePath(newPath)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for p, t := range idx.entries {
+		if p == oldPath || isUnder(p, oldPath) {
+			delete(idx.entries, p)
+			idx.entries[newPath+strings.TrimPrefix(p, oldPath)] = t
+		}
+	}
+}
+
+// Due returns the paths that have expired by now
+func (idx *ExpiryIndex) Due(now time.Time) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var due []string
+	for p, t := range idx.entries {
+		if !now.Before(t) {
+			due = append(due, p)
+		}
+	}
+	return due
+}
+
+// isUnder reports whether p is strictly below dir
+func isUnder(p, dir string) bool {
+	if dir == "/" {
+		return p != "/"
+	}
+	return strings.HasPrefix(p, dir+"/")
+}
+
+// StartExpirySweeper removes expired files every interval until stop is
+// closed. remove is called with each expired path; the entry is dropped from
+// the index whether or not removal succeeds, since a file that is already
+// gone has nothing left to expire.
+func StartExpirySweeper(idx *ExpiryIndex, interval time.Duration, stop <-chan struct{}, remove func(path string) error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, p := range idx.Due(time.Now()) {
+					_ = remove(p)
+					idx.Set(p, time.Time{})
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
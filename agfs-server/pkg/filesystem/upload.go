@@ -0,0 +1,114 @@
+package filesystem
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// DefaultUploadPartSize is the part size of uploads that don't give one
+	DefaultUploadPartSize = 8 << 20
+	// MinUploadPartSize and MaxUploadPartSize bound the part size of
+	// uploads. Parts are held in memory while they are written.
+	MinUploadPartSize = 64 << 10
+	MaxUploadPartSize = 64 << 20
+	// MaxUploadParts bounds the number of parts of an upload, as S3 does
+	MaxUploadParts = 10000
+)
+
+// Uploader is implemented by file systems that assemble a file from parts
+// written concurrently and in any order, e.g. as an S3 multipart upload or
+// in a temporary file next to it. The file appears, whole, when the upload
+// is committed. Other file systems are written through a SpooledUpload.
+type Uploader interface {
+	// BeginUpload starts assembling the file at path from parts of
+	// partSize bytes
+	BeginUpload(path string, partSize int64) (Upload, error)
+}
+
+// Upload is a file being assembled from parts. Part i holds the bytes at
+// offset i*partSize, and every part but the last is partSize bytes long;
+// callers check this before Commit.
+type Upload interface {
+	// WritePart stores part i, replacing an earlier write of it. Parts may
+	// be written concurrently.
+	WritePart(i int, data []byte) error
+	// Commit replaces the file with the first size bytes of the parts,
+	// all of which were written
+	Commit(size int64) error
+	// Abort discards the parts
+	Abort() error
+}
+
+// ValidateUploadPartSize checks the part size of an upload
+func ValidateUploadPartSize(partSize int64) error {
+	if partSize < MinUploadPartSize || partSize > MaxUploadPartSize {
+		return NewInvalidArgumentError("part_size", partSize,
+			fmt.Sprintf("must be between %d and %d bytes", MinUploadPartSize, MaxUploadPartSize))
+	}
+	return nil
+}
+
+// BeginUpload starts an upload of the file at path in fs: natively if fs is
+// an Uploader, or else through a SpooledUpload
+func BeginUpload(fs FileSystem, path string, partSize int64) (Upload, error) {
+	if err := ValidateUploadPartSize(partSize); err != nil {
+		return nil, err
+	}
+	if uploader, ok := As[Uploader](fs); ok {
+		return uploader.BeginUpload(path, partSize)
+	}
+	upload, err := NewSpooledUpload(fs, path, partSize)
+	if err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// SpooledUpload assembles the parts of an upload in a local temporary file,
+// and streams it to the file system with OpenWrite when committed
+type SpooledUpload struct {
+	fs       FileSystem
+	path     string
+	partSize int64
+	spool    *os.File
+}
+
+// NewSpooledUpload starts an upload of the file at path in fs spooled to a
+// temporary file
+func NewSpooledUpload(fs FileSystem, path string, partSize int64) (*SpooledUpload, error) {
+	spool, err := os.CreateTemp("", "agfs-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload spool: %w", err)
+	}
+	return &SpooledUpload{fs: fs, path: path, partSize: partSize, spool: spool}, nil
+}
+
+// WritePart implements Upload
+func (u *SpooledUpload) WritePart(i int, data []byte) error {
+	if _, err := u.spool.WriteAt(data, int64(i)*u.partSize); err != nil {
+		return fmt.Errorf("failed to spool part %d: %w", i, err)
+	}
+	return nil
+}
+
+// Commit implements Upload
+func (u *SpooledUpload) Commit(size int64) error {
+	defer u.Abort()
+	w, err := u.fs.OpenWrite(u.path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(u.spool, 0, size)); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Abort implements Upload
+func (u *SpooledUpload) Abort() error {
+	u.spool.Close()
+	return os.Remove(u.spool.Name())
+}
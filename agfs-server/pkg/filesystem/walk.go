@@ -0,0 +1,194 @@
+package filesystem
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+// SkipDir can be returned from a WalkFunc to skip the directory that was just
+// visited. Returning it for a file is ignored.
+var SkipDir = errors.New("skip this directory")
+
+// WalkOptions controls a recursive directory walk
+type WalkOptions struct {
+	// MaxDepth limits how deep the walk descends below the root.
+	// Entries directly under the root have depth 1. 0 means unlimited.
+	MaxDepth int
+
+	// Include keeps only entries whose name or root-relative path matches
+	// at least one glob pattern (path.Match syntax, plus "**" segments that
+	// match any number of directories). Empty means include all.
+	// Directories are always descended into, even when they don't match.
+	Include []string
+
+	// Exclude drops entries whose name or root-relative path matches any glob.
+	// Excluded directories are not descended into.
+	Exclude []string
+
+	// FilesOnly omits directories from the results (they are still traversed)
+	FilesOnly bool
+}
+
+// WalkEntry is a single result produced by a recursive walk
+type WalkEntry struct {
+	Path  string   // Absolute path of the entry
+	Depth int      // Depth relative to the walk root (1 = direct child)
+	Info  FileInfo // File information as returned by ReadDir
+}
+
+// WalkFunc is called for every entry visited by Walk
+type WalkFunc func(entry WalkEntry) error
+
+// Walker is implemented by file systems that can enumerate a subtree more
+// efficiently than repeated ReadDir calls (e.g. a flat prefix listing in S3).
+// Implementations must honour the same semantics as the generic Walk.
+type Walker interface {
+	Walk(root string, opts WalkOptions, fn WalkFunc) error
+}
+
+// ValidateWalkOptions checks that all glob patterns are well formed
+func ValidateWalkOptions(opts WalkOptions) error {
+	if opts.MaxDepth < 0 {
+		return NewInvalidArgumentError("max_depth", opts.MaxDepth, "must be non-negative")
+	}
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return NewInvalidArgumentError("pattern", pattern, err.Error())
+		}
+	}
+	return nil
+}
+
+// Walk traverses the subtree rooted at root depth-first and calls fn
+// for every entry that passes the filters. If fs implements Walker, the walk is
+// delegated to it.
+func Walk(fs FileSystem, root string, opts WalkOptions, fn WalkFunc) error {
+	if err := ValidateWalkOptions(opts); err != nil {
+		return err
+	}
+	if walker, ok := fs.(Walker); ok {
+		return walker.Walk(root, opts, fn)
+	}
+
+	root = NormalizePath(root)
+	info, err := fs.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir {
+		return NewNotDirectoryError(root)
+	}
+
+	err = walkDir(fs, root, root, 1, opts, fn)
+	if err == SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walkDir(fs FileSystem, root, dir string, depth int, opts WalkOptions, fn WalkFunc) error {
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entryPath := path.Join(dir, entry.Name)
+		rel := strings.TrimPrefix(strings.TrimPrefix(entryPath, root), "/")
+
+		if MatchAnyGlob(opts.Exclude, entry.Name, rel) {
+			continue
+		}
+
+		if WalkIncludes(opts, entry.Name, rel, entry.IsDir) {
+			err := fn(WalkEntry{Path: entryPath, Depth: depth, Info: entry})
+			if err == SkipDir && entry.IsDir {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if entry.IsDir && (opts.MaxDepth == 0 || depth < opts.MaxDepth) {
+			if err := walkDir(fs, root, entryPath, depth+1, opts, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WalkIncludes reports whether an entry that survived the exclude filter should
+// be reported to the caller. It is exported so Walker implementations apply
+// identical filtering.
+func WalkIncludes(opts WalkOptions, name, rel string, isDir bool) bool {
+	if isDir && opts.FilesOnly {
+		return false
+	}
+	if len(opts.Include) == 0 {
+		return true
+	}
+	return MatchAnyGlob(opts.Include, name, rel)
+}
+
+// MatchAnyGlob reports whether name or rel matches any of the glob patterns
+func MatchAnyGlob(patterns []string, name, rel string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) || matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob reports whether name matches a glob pattern of the syntax walks
+// take: path.Match plus "**" segments
+func MatchGlob(pattern, name string) bool {
+	return matchGlob(pattern, name)
+}
+
+// matchGlob is path.Match extended with "**" segments, which match zero or
+// more path elements: "**/*.md" matches "a.md" and "docs/api/a.md"
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "**") {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	return matchGlobSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlobSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// ReadDirRecursive collects all entries of a walk into a slice
+func ReadDirRecursive(fs FileSystem, root string, opts WalkOptions) ([]WalkEntry, error) {
+	var entries []WalkEntry
+	err := Walk(fs, root, opts, func(entry WalkEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
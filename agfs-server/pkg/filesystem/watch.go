@@ -0,0 +1,110 @@
+package filesystem
+
+import (
+	"path"
+	"time"
+)
+
+// Event types reported to watchers
+const (
+	EventCreate = "create"
+	EventWrite  = "write"
+	EventMkdir  = "mkdir"
+	EventRemove = "remove"
+	EventRename = "rename"
+	EventChmod  = "chmod"
+
+	// EventBatch groups the events of one directory (see WatchOptions.BatchWindow)
+	EventBatch = "batch"
+	// EventOverflow reports that events were dropped because the watcher
+	// fell behind; consumers should rescan what they watch
+	EventOverflow = "overflow"
+)
+
+// Bounds for WatchOptions
+const (
+	MaxWatchDebounce    = time.Minute
+	MaxWatchBatchWindow = time.Minute
+)
+
+// Event describes a change to a file or directory
+type Event struct {
+	Type    string    `json:"type"`
+	Path    string    `json:"path"`
+	OldPath string    `json:"old_path,omitempty"` // Source of a rename
+	Time    time.Time `json:"time"`
+	// Count is the number of changes coalesced into this event
+	Count  int     `json:"count,omitempty"`
+	Events []Event `json:"events,omitempty"` // Members of a batch event
+	// Cursor orders events. On a delivered event it is the resume point:
+	// watching again with WatchOptions.Since set to it replays everything
+	// the subscription had not delivered yet.
+	Cursor uint64 `json:"cursor,omitempty"`
+}
+
+// WatchOptions controls which events a subscription receives and how they
+// are coalesced
+type WatchOptions struct {
+	// Recursive extends a directory watch to its whole subtree
+	Recursive bool
+	// Include keeps only events whose path, relative to the watched path,
+	// or base name matches a glob (see WalkOptions.Include). Empty means all.
+	Include []string
+	// Exclude drops events whose relative path or base name matches a glob
+	Exclude []string
+	// Types keeps only events of these types (create, write, mkdir, remove,
+	// rename, chmod). Empty means all. Overflow events are always reported.
+	Types []string
+	// Since replays the retained events after this cursor before live
+	// events. An overflow event comes first when some of them are no longer
+	// retained. 0 starts with live events.
+	Since uint64
+
+	// Debounce coalesces successive creates and writes of the same file: one
+	// event is emitted once the file has been quiet for this long
+	Debounce time.Duration
+	// BatchWindow collects events and emits one batch event per directory
+	// at most once per window
+	BatchWindow time.Duration
+}
+
+// watchEventTypes are the event types a subscription can filter on
+var watchEventTypes = map[string]bool{
+	EventCreate: true, EventWrite: true, EventMkdir: true,
+	EventRemove: true, EventRename: true, EventChmod: true,
+}
+
+// ValidateWatchOptions checks option bounds, glob patterns and event types
+func ValidateWatchOptions(opts WatchOptions) error {
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return NewInvalidArgumentError("pattern", pattern, err.Error())
+		}
+	}
+	for _, t := range opts.Types {
+		if !watchEventTypes[t] {
+			return NewInvalidArgumentError("types", t, "must be one of create, write, mkdir, remove, rename, chmod")
+		}
+	}
+	if opts.Debounce < 0 || opts.Debounce > MaxWatchDebounce {
+		return NewInvalidArgumentError("debounce", opts.Debounce.String(), "must be between 0 and "+MaxWatchDebounce.String())
+	}
+	if opts.BatchWindow < 0 || opts.BatchWindow > MaxWatchBatchWindow {
+		return NewInvalidArgumentError("batch", opts.BatchWindow.String(), "must be between 0 and "+MaxWatchBatchWindow.String())
+	}
+	return nil
+}
+
+// Watcher is implemented by file systems that report changes to subscribers
+type Watcher interface {
+	// Watch subscribes to changes of path: a file, or a directory and its
+	// direct entries (its whole subtree with WatchOptions.Recursive)
+	Watch(path string, opts WatchOptions) (Subscription, error)
+}
+
+// Subscription delivers the events of one Watch call
+type Subscription interface {
+	// Events returns the event channel, closed after Close
+	Events() <-chan Event
+	Close()
+}
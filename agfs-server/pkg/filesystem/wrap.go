@@ -0,0 +1,41 @@
+package filesystem
+
+import "reflect"
+
+// Unwrapper is implemented by file systems that wrap another one, such as
+// mount middleware. Optional interfaces (Truncater, Streamer, ...) that the
+// wrapper doesn't implement itself are looked up on the file system it wraps.
+type Unwrapper interface {
+	// Unwrap returns the wrapped file system
+	Unwrap() FileSystem
+}
+
+// UnwrapFilter is implemented by wrappers that only let some optional
+// interfaces of the file system they wrap be looked up through them, such
+// as the readonly mount middleware. Interfaces it doesn't forward stay
+// hidden, including ones added after it was written.
+type UnwrapFilter interface {
+	// Forwards reports whether the optional interface t may be looked up
+	// on the wrapped file system
+	Forwards(t reflect.Type) bool
+}
+
+// As returns the outermost file system in the wrap chain of fs that
+// implements T, following Unwrap until one does
+func As[T any](fs FileSystem) (T, bool) {
+	for fs != nil {
+		if t, ok := fs.(T); ok {
+			return t, true
+		}
+		u, ok := fs.(Unwrapper)
+		if !ok {
+			break
+		}
+		if f, ok := fs.(UnwrapFilter); ok && !f.Forwards(reflect.TypeFor[T]()) {
+			break
+		}
+		fs = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
@@ -0,0 +1,299 @@
+// Package fsql runs SQL across the unified namespace. Directories of
+// structured files (CSV, TSV, JSON, NDJSON) and database tables exposed
+// through filesystem.TableReader (e.g. sqlfs2) are loaded as relations into
+// an embedded in-memory SQLite database, where the query is executed.
+package fsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Defaults applied when the corresponding Engine field is zero
+const (
+	DefaultMaxRows        = 10000            // Rows returned per query
+	DefaultMaxSourceRows  = 100000           // Rows loaded per relation
+	DefaultMaxSourceBytes = 64 * 1024 * 1024 // Bytes read per relation
+	DefaultTimeout        = 30 * time.Second // Loading plus execution
+)
+
+var relationNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Request is a query over relations bound to paths
+type Request struct {
+	Query   string            // A single SELECT (or WITH ... SELECT) statement
+	Tables  map[string]string // Relation name -> file, directory or table path
+	MaxRows int               // Result row limit; 0 uses the engine's MaxRows
+}
+
+// Result holds the rows produced by a query
+type Result struct {
+	Columns   []string
+	Rows      [][]interface{}
+	Truncated bool // More rows matched than MaxRows
+}
+
+// Engine executes fsql queries against a file system
+type Engine struct {
+	fs filesystem.FileSystem
+
+	MaxRows        int
+	MaxSourceRows  int
+	MaxSourceBytes int64
+	Timeout        time.Duration
+}
+
+// NewEngine creates an engine that reads relations from fs
+func NewEngine(fs filesystem.FileSystem) *Engine {
+	return &Engine{fs: fs}
+}
+
+// Query loads the requested relations and runs req.Query over them.
+// Invalid requests and SQL errors are reported as invalid argument errors.
+func (e *Engine) Query(ctx context.Context, req Request) (*Result, error) {
+	query, err := checkQuery(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Tables) == 0 {
+		return nil, filesystem.NewInvalidArgumentError("tables", nil, "at least one table is required")
+	}
+	names := make([]string, 0, len(req.Tables))
+	for name, path := range req.Tables {
+		if !relationNamePattern.MatchString(name) {
+			return nil, filesystem.NewInvalidArgumentError("tables", name, "name must match [A-Za-z_][A-Za-z0-9_]*")
+		}
+		if path == "" {
+			return nil, filesystem.NewInvalidArgumentError("tables", name, "path is required")
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	maxRows := req.MaxRows
+	if maxRows <= 0 || maxRows > e.maxRows() {
+		maxRows = e.maxRows()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout())
+	defer cancel()
+
+	// Every query gets a private scratch database. It lives in its single
+	// connection, so the pool must never open a second one.
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open query engine: %w", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, name := range names {
+		table, err := e.loadRelation(req.Tables[name])
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", name, err)
+		}
+		if err := createRelation(ctx, db, name, table); err != nil {
+			return nil, fmt.Errorf("table %s: %w", name, err)
+		}
+	}
+
+	// The statement check is lexical; query_only guarantees the scratch
+	// database cannot be modified by anything that slips past it
+	if _, err := db.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+		return nil, fmt.Errorf("failed to enable query_only: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	result := &Result{Columns: columns, Rows: [][]interface{}{}}
+	for rows.Next() {
+		if len(result.Rows) == maxRows {
+			result.Truncated = true
+			break
+		}
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		for i, val := range values {
+			if b, ok := val.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return result, nil
+}
+
+// queryError reports SQL errors as invalid arguments, and timeouts as such
+func queryError(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("query timed out: %w", ctx.Err())
+	}
+	return filesystem.NewInvalidArgumentError("query", nil, err.Error())
+}
+
+// createRelation creates and fills a table in the scratch database.
+// Columns are untyped so values keep the type they were loaded with.
+func createRelation(ctx context.Context, db *sql.DB, name string, table *filesystem.Table) error {
+	if len(table.Columns) == 0 {
+		return filesystem.NewInvalidArgumentError("tables", name, "relation has no columns")
+	}
+	seen := make(map[string]bool, len(table.Columns))
+	quoted := make([]string, len(table.Columns))
+	for i, col := range table.Columns {
+		if col == "" {
+			col = fmt.Sprintf("column%d", i+1)
+		}
+		if seen[strings.ToLower(col)] {
+			return filesystem.NewInvalidArgumentError("column", col, "appears more than once")
+		}
+		seen[strings.ToLower(col)] = true
+		quoted[i] = quoteIdentifier(col)
+	}
+
+	createSQL := fmt.Sprintf("CREATE TABLE %s (%s)", quoteIdentifier(name), strings.Join(quoted, ", "))
+	if _, err := db.ExecContext(ctx, createSQL); err != nil {
+		return fmt.Errorf("failed to create relation: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(quoted)), ", ")
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s VALUES (%s)", quoteIdentifier(name), placeholders))
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, row := range table.Rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return fmt.Errorf("failed to load row: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// quoteIdentifier quotes a SQLite identifier
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// checkQuery accepts a single read-only statement and returns it without a
+// trailing semicolon
+func checkQuery(query string) (string, error) {
+	query = strings.TrimSpace(query)
+	query = strings.TrimSpace(strings.TrimSuffix(query, ";"))
+	if query == "" {
+		return "", filesystem.NewInvalidArgumentError("query", nil, "query is required")
+	}
+
+	end := strings.IndexFunc(query, func(r rune) bool { return !unicode.IsLetter(r) })
+	if end < 0 {
+		end = len(query)
+	}
+	keyword := strings.ToUpper(query[:end])
+	if keyword != "SELECT" && keyword != "WITH" && keyword != "VALUES" {
+		return "", filesystem.NewInvalidArgumentError("query", nil, "only SELECT statements are allowed")
+	}
+	if hasStatementSeparator(query) {
+		return "", filesystem.NewInvalidArgumentError("query", nil, "only a single statement is allowed")
+	}
+	return query, nil
+}
+
+// hasStatementSeparator reports whether query contains a semicolon outside
+// string literals, quoted identifiers and comments
+func hasStatementSeparator(query string) bool {
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; c {
+		case '\'', '"', '`':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				return false
+			}
+			i += end + 1
+		case '[':
+			end := strings.IndexByte(query[i+1:], ']')
+			if end < 0 {
+				return false
+			}
+			i += end + 1
+		case '-':
+			if strings.HasPrefix(query[i:], "--") {
+				end := strings.IndexByte(query[i:], '\n')
+				if end < 0 {
+					return false
+				}
+				i += end
+			}
+		case '/':
+			if strings.HasPrefix(query[i:], "/*") {
+				end := strings.Index(query[i+2:], "*/")
+				if end < 0 {
+					return false
+				}
+				i += end + 3
+			}
+		case ';':
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) maxRows() int {
+	if e.MaxRows > 0 {
+		return e.MaxRows
+	}
+	return DefaultMaxRows
+}
+
+func (e *Engine) maxSourceRows() int {
+	if e.MaxSourceRows > 0 {
+		return e.MaxSourceRows
+	}
+	return DefaultMaxSourceRows
+}
+
+func (e *Engine) maxSourceBytes() int64 {
+	if e.MaxSourceBytes > 0 {
+		return e.MaxSourceBytes
+	}
+	return DefaultMaxSourceBytes
+}
+
+func (e *Engine) timeout() time.Duration {
+	if e.Timeout > 0 {
+		return e.Timeout
+	}
+	return DefaultTimeout
+}
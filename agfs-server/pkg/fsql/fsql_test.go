@@ -0,0 +1,151 @@
+package fsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/sqlfs2"
+)
+
+// newTestNamespace mounts memfs at /local and a SQLite sqlfs2 at /sql
+func newTestNamespace(t *testing.T) *mountablefs.MountableFS {
+	t.Helper()
+	mfs := mountablefs.NewMountableFS(api.PoolConfig{})
+
+	mem := memfs.NewMemFSPlugin()
+	if err := mem.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("memfs Initialize() error = %v", err)
+	}
+	sql := sqlfs2.NewSQLFS2Plugin()
+	if err := sql.Initialize(map[string]interface{}{
+		"backend": "sqlite",
+		"db_path": filepath.Join(t.TempDir(), "fsql.db"),
+	}); err != nil {
+		t.Fatalf("sqlfs2 Initialize() error = %v", err)
+	}
+	t.Cleanup(func() {
+		mem.Shutdown()
+		sql.Shutdown()
+	})
+
+	if err := mfs.Mount("/local", mem); err != nil {
+		t.Fatalf("Mount(/local) error = %v", err)
+	}
+	if err := mfs.Mount("/sql", sql); err != nil {
+		t.Fatalf("Mount(/sql) error = %v", err)
+	}
+	return mfs
+}
+
+func mustWrite(t *testing.T, fs filesystem.FileSystem, path, data string) {
+	t.Helper()
+	if _, err := fs.Write(path, []byte(data), -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+		t.Fatalf("Write(%s) error = %v", path, err)
+	}
+}
+
+func TestQueryJoinsCSVWithSQLTable(t *testing.T) {
+	mfs := newTestNamespace(t)
+	mustWrite(t, mfs, "/local/users.csv", "id,name,zip\n1,Alice,02134\n2,Bob,\n")
+	mustWrite(t, mfs, "/sql/main/orders/import", "user_id,amount\n1,10.5\n1,4.5\n2,3\n")
+
+	result, err := NewEngine(mfs).Query(context.Background(), Request{
+		Query: "SELECT u.name, u.zip, SUM(o.amount) AS total FROM users u JOIN orders o ON o.user_id = u.id GROUP BY u.id ORDER BY u.id;",
+		Tables: map[string]string{
+			"users":  "/local/users.csv",
+			"orders": "/sql/main/orders",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+
+	got := fmt.Sprint(result.Columns, result.Rows)
+	want := "[name zip total] [[Alice 02134 15] [Bob <nil> 3]]"
+	if got != want {
+		t.Fatalf("result = %s, want %s", got, want)
+	}
+}
+
+func TestQueryDirectoryOfJSONFiles(t *testing.T) {
+	mfs := newTestNamespace(t)
+	if err := mfs.Mkdir("/local/events", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	mustWrite(t, mfs, "/local/events/a.json", `[{"kind": "click", "n": 2}, {"kind": "view", "n": 1}]`)
+	mustWrite(t, mfs, "/local/events/b.ndjson", "{\"kind\": \"click\", \"n\": 3, \"meta\": {\"x\": 1}}\n")
+	mustWrite(t, mfs, "/local/events/notes.txt", "ignored")
+
+	result, err := NewEngine(mfs).Query(context.Background(), Request{
+		Query:  "SELECT kind, SUM(n), MAX(meta) FROM events GROUP BY kind ORDER BY kind",
+		Tables: map[string]string{"events": "/local/events"},
+	})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	got := fmt.Sprint(result.Rows)
+	want := `[[click 5 {"x":1}] [view 1 <nil>]]`
+	if got != want {
+		t.Fatalf("rows = %s, want %s", got, want)
+	}
+}
+
+func TestQueryMaxRows(t *testing.T) {
+	mfs := newTestNamespace(t)
+	mustWrite(t, mfs, "/local/n.csv", "n\n1\n2\n3\n")
+
+	result, err := NewEngine(mfs).Query(context.Background(), Request{
+		Query:   "SELECT n FROM t ORDER BY n",
+		Tables:  map[string]string{"t": "/local/n.csv"},
+		MaxRows: 2,
+	})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(result.Rows) != 2 || !result.Truncated {
+		t.Fatalf("rows = %v, truncated = %v; want 2 rows, truncated", result.Rows, result.Truncated)
+	}
+}
+
+func TestQueryRejectsInvalidRequests(t *testing.T) {
+	mfs := newTestNamespace(t)
+	mustWrite(t, mfs, "/local/n.csv", "n\n1\n")
+	if err := mfs.Mkdir("/local/empty", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	tables := map[string]string{"t": "/local/n.csv"}
+
+	tests := []struct {
+		name   string
+		req    Request
+		target error
+	}{
+		{"write statement", Request{Query: "DELETE FROM t", Tables: tables}, filesystem.ErrInvalidArgument},
+		{"multiple statements", Request{Query: "SELECT 1; DROP TABLE t", Tables: tables}, filesystem.ErrInvalidArgument},
+		{"CTE with write", Request{Query: "WITH x AS (SELECT 1) DELETE FROM t", Tables: tables}, filesystem.ErrInvalidArgument},
+		{"SQL error", Request{Query: "SELECT missing FROM t", Tables: tables}, filesystem.ErrInvalidArgument},
+		{"bad relation name", Request{Query: "SELECT 1", Tables: map[string]string{"a-b": "/local/n.csv"}}, filesystem.ErrInvalidArgument},
+		{"unsupported file", Request{Query: "SELECT 1", Tables: map[string]string{"t": "/local/empty"}}, filesystem.ErrInvalidArgument},
+		{"missing file", Request{Query: "SELECT 1", Tables: map[string]string{"t": "/local/none.csv"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewEngine(mfs).Query(context.Background(), tt.req)
+			if err == nil || (tt.target != nil && !errors.Is(err, tt.target)) {
+				t.Fatalf("Query() error = %v, want %v", err, tt.target)
+			}
+		})
+	}
+
+	// A semicolon inside a literal is not a statement separator
+	if _, err := NewEngine(mfs).Query(context.Background(), Request{Query: "SELECT ';' FROM t", Tables: tables}); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+}
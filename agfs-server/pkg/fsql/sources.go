@@ -0,0 +1,329 @@
+package fsql
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Structured file formats, selected by file extension
+const (
+	FormatCSV    = "csv"
+	FormatTSV    = "tsv"
+	FormatJSON   = "json"   // An array of objects, or a single object
+	FormatNDJSON = "ndjson" // One object per line (.ndjson or .jsonl)
+)
+
+// formatOf returns the structured format of a file name, or "" if unsupported
+func formatOf(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".csv":
+		return FormatCSV
+	case ".tsv":
+		return FormatTSV
+	case ".json":
+		return FormatJSON
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	default:
+		return ""
+	}
+}
+
+// loadRelation reads the relation at p. Tables exposed through
+// filesystem.TableReader are read directly; otherwise p must be a structured
+// file, or a directory whose structured files are concatenated.
+func (e *Engine) loadRelation(p string) (*filesystem.Table, error) {
+	if reader, ok := e.fs.(filesystem.TableReader); ok {
+		table, err := reader.ReadTable(p, e.maxSourceRows())
+		if err == nil {
+			if table.Truncated {
+				return nil, fmt.Errorf("%s has more than %d rows", p, e.maxSourceRows())
+			}
+			return table, nil
+		}
+		if !errors.Is(err, filesystem.ErrNotSupported) {
+			return nil, err
+		}
+	}
+
+	info, err := e.fs.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir {
+		return e.loadFile(p, info)
+	}
+
+	entries, err := e.fs.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	var tables []*filesystem.Table
+	var size int64
+	for i := range entries {
+		if entries[i].IsDir || formatOf(entries[i].Name) == "" {
+			continue
+		}
+		size += entries[i].Size
+		if size > e.maxSourceBytes() {
+			return nil, fmt.Errorf("%s is larger than %d bytes", p, e.maxSourceBytes())
+		}
+		table, err := e.loadFile(path.Join(p, entries[i].Name), &entries[i])
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	if len(tables) == 0 {
+		return nil, filesystem.NewInvalidArgumentError("path", p, "directory has no CSV, TSV, JSON or NDJSON files")
+	}
+
+	table := mergeTables(tables)
+	if len(table.Rows) > e.maxSourceRows() {
+		return nil, fmt.Errorf("%s has more than %d rows", p, e.maxSourceRows())
+	}
+	return table, nil
+}
+
+// loadFile reads and parses a single structured file
+func (e *Engine) loadFile(p string, info *filesystem.FileInfo) (*filesystem.Table, error) {
+	format := formatOf(p)
+	if format == "" {
+		return nil, filesystem.NewInvalidArgumentError("path", p, "not a CSV, TSV, JSON or NDJSON file")
+	}
+	if info.Size > e.maxSourceBytes() {
+		return nil, fmt.Errorf("%s is larger than %d bytes", p, e.maxSourceBytes())
+	}
+
+	data, err := e.fs.Read(p, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var table *filesystem.Table
+	switch format {
+	case FormatCSV, FormatTSV:
+		table, err = parseDelimited(data, format)
+	default:
+		table, err = parseJSON(data, format)
+	}
+	if err != nil {
+		return nil, filesystem.NewInvalidArgumentError("path", p, err.Error())
+	}
+	if len(table.Rows) > e.maxSourceRows() {
+		return nil, fmt.Errorf("%s has more than %d rows", p, e.maxSourceRows())
+	}
+	return table, nil
+}
+
+// parseDelimited parses a CSV or TSV file with a header line. Each column
+// becomes integer or real if every non-empty value parses as one, so that
+// numeric comparisons and joins behave. Values with leading zeros (zip
+// codes, IDs) keep the column as text. Empty fields are NULL.
+func parseDelimited(data []byte, format string) (*filesystem.Table, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	if format == FormatTSV {
+		reader.Comma = '\t'
+		reader.LazyQuotes = true
+	}
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing header line")
+	}
+
+	header := records[0]
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	records = records[1:]
+
+	table := &filesystem.Table{Columns: header, Rows: make([][]interface{}, len(records))}
+	for i := range records {
+		table.Rows[i] = make([]interface{}, len(header))
+	}
+	for col := range header {
+		isInt, isFloat := true, true
+		for _, record := range records {
+			if v := record[col]; v != "" {
+				if hasLeadingZero(v) {
+					isInt, isFloat = false, false
+				}
+				if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+					isInt = false
+				}
+				if _, err := strconv.ParseFloat(v, 64); err != nil {
+					isFloat = false
+				}
+			}
+		}
+		for i, record := range records {
+			v := record[col]
+			switch {
+			case v == "":
+				table.Rows[i][col] = nil
+			case isInt:
+				table.Rows[i][col], _ = strconv.ParseInt(v, 10, 64)
+			case isFloat:
+				table.Rows[i][col], _ = strconv.ParseFloat(v, 64)
+			default:
+				table.Rows[i][col] = v
+			}
+		}
+	}
+	return table, nil
+}
+
+// hasLeadingZero reports whether v is a number written with a leading zero
+func hasLeadingZero(v string) bool {
+	v = strings.TrimPrefix(v, "-")
+	return len(v) > 1 && v[0] == '0' && v[1] >= '0' && v[1] <= '9'
+}
+
+// parseJSON parses a JSON or NDJSON file of objects. Columns are the union
+// of all keys in order of first appearance; nested values are stored as JSON.
+func parseJSON(data []byte, format string) (*filesystem.Table, error) {
+	var objects []map[string]interface{}
+	var keyOrder [][]string
+
+	decode := func(raw []byte) error {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var obj map[string]interface{}
+		if err := dec.Decode(&obj); err != nil {
+			return err
+		}
+		keys, err := objectKeys(raw)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, obj)
+		keyOrder = append(keyOrder, keys)
+		return nil
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case format == FormatNDJSON:
+		for n, line := range bytes.Split(trimmed, []byte("\n")) {
+			if line = bytes.TrimSpace(line); len(line) == 0 {
+				continue
+			}
+			if err := decode(line); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+		}
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, err
+		}
+		for i, item := range items {
+			if err := decode(item); err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+	default:
+		if err := decode(trimmed); err != nil {
+			return nil, err
+		}
+	}
+
+	table := &filesystem.Table{}
+	index := make(map[string]int)
+	for _, keys := range keyOrder {
+		for _, k := range keys {
+			if _, ok := index[k]; !ok {
+				index[k] = len(table.Columns)
+				table.Columns = append(table.Columns, k)
+			}
+		}
+	}
+	for _, obj := range objects {
+		row := make([]interface{}, len(table.Columns))
+		for k, v := range obj {
+			row[index[k]] = jsonValue(v)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, nil
+}
+
+// objectKeys returns the keys of a JSON object in document order
+func objectKeys(raw []byte) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil { // {
+		return nil, err
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// jsonValue converts a decoded JSON value into a SQLite value
+func jsonValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		if n, err := val.Int64(); err == nil {
+			return n
+		}
+		f, _ := val.Float64()
+		return f
+	case map[string]interface{}, []interface{}:
+		encoded, _ := json.Marshal(val)
+		return string(encoded)
+	default:
+		return val // string, bool or nil
+	}
+}
+
+// mergeTables concatenates tables, aligning rows on the union of columns
+func mergeTables(tables []*filesystem.Table) *filesystem.Table {
+	if len(tables) == 1 {
+		return tables[0]
+	}
+	merged := &filesystem.Table{}
+	index := make(map[string]int)
+	for _, t := range tables {
+		for _, col := range t.Columns {
+			if _, ok := index[col]; !ok {
+				index[col] = len(merged.Columns)
+				merged.Columns = append(merged.Columns, col)
+			}
+		}
+	}
+	for _, t := range tables {
+		for _, row := range t.Rows {
+			out := make([]interface{}, len(merged.Columns))
+			for i, col := range t.Columns {
+				out[index[col]] = row[i]
+			}
+			merged.Rows = append(merged.Rows, out)
+		}
+	}
+	return merged
+}
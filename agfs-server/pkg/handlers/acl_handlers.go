@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/acl"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// adminEndpoints manage mounts and plugins, restore deleted data or change
+// its retention, and need admin permission on /
+var adminEndpoints = map[string]bool{
+	"/api/v1/mount":          true,
+	"/api/v1/unmount":        true,
+	"/api/v1/plugins/load":   true,
+	"/api/v1/plugins/unload": true,
+	"/api/v1/restore":        true,
+	"/api/v1/retention":      true,
+}
+
+// SetACL enables per-path access control
+func (h *Handler) SetACL(policy *acl.Policy) {
+	h.acl = policy
+}
+
+// ACLMiddleware rejects requests the caller's permissions on their path
+// parameter do not allow. Paths in request bodies are checked by the
+// handlers through authorizePath. The rules file can only be replaced as a
+// whole with PUT /files, which validates and reloads it.
+func (h *Handler) ACLMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.acl == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		identity := IdentityFromContext(r.Context())
+		if adminEndpoints[r.URL.Path] && r.Method != http.MethodGet {
+			if err := h.acl.Check(identity, "/", acl.Admin, false); err != nil {
+				writeError(w, http.StatusForbidden, err.Error())
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		need := requiredPermission(r)
+		if !h.authorizePath(w, r, path, requestRecursive(r)) {
+			return
+		}
+		if need < acl.Write || !h.leadsToACLFile(path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.URL.Path != "/api/v1/files" || r.Method != http.MethodPut || r.URL.Query().Get("offset") != "" {
+			writeError(w, http.StatusForbidden, filesystem.NewPermissionDeniedError("write", path, "the access control list can only be replaced as a whole with PUT /files").Error())
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxRequestBodyBytes))
+		if err != nil {
+			writeRequestBodyError(w, err, h.maxRequestBodyBytes, "failed to read request body")
+			return
+		}
+		if _, err := acl.ParseRules(body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid access control list: "+err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 || rec.status == http.StatusOK {
+			h.acl.Load(body)
+			log.Infof("[acl] Reloaded %s (updated by %q)", path, identity)
+		}
+	})
+}
+
+// requiredPermission returns the permission r needs on its path parameter
+func requiredPermission(r *http.Request) acl.Permission {
+	switch {
+	case r.Method == http.MethodGet || r.Method == http.MethodHead || readOnlyPostEndpoints[r.URL.Path]:
+		return acl.Read
+	case r.URL.Path == "/api/v1/handles/open":
+		flags, err := parseOpenFlags(r.URL.Query().Get("flags"))
+		if err == nil && flags&(filesystem.O_WRONLY|filesystem.O_RDWR|filesystem.O_APPEND|filesystem.O_CREATE|filesystem.O_TRUNC) == 0 {
+			return acl.Read
+		}
+	}
+	return acl.Write
+}
+
+// aclPermission returns the permission r needs on path, which is admin for
+// changes to the rules file
+func (h *Handler) aclPermission(r *http.Request, path string) acl.Permission {
+	need := requiredPermission(r)
+	if need >= acl.Write && h.isACLFile(path) {
+		return acl.Admin
+	}
+	return need
+}
+
+// isACLFile reports whether path is the rules file
+func (h *Handler) isACLFile(path string) bool {
+	return h.acl.File() != "" && filesystem.NormalizePath(path) == h.acl.File()
+}
+
+// leadsToACLFile reports whether path is the rules file, or a symbolic
+// link leads it there
+func (h *Handler) leadsToACLFile(path string) bool {
+	for _, p := range h.authorizedPaths(path) {
+		if h.isACLFile(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusRecorder remembers the status written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/acl"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestACLMiddleware(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	fs.Mkdir("/shared", 0755)
+	fs.Mkdir("/private", 0755)
+	fs.Mkdir("/etc", 0755)
+	fs.Write("/shared/a.txt", []byte("hello"), -1, filesystem.WriteFlagCreate)
+	h := NewHandler(fs, nil)
+	policy, err := acl.NewPolicy(fs, acl.Config{
+		File: "/etc/acl",
+		Rules: []acl.Rule{
+			{Identity: "*", Path: "/shared", Permission: acl.Read},
+			{Identity: "writer", Path: "/shared", Permission: acl.Write},
+			{Identity: "admin", Path: "/", Permission: acl.Admin},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	h.SetACL(policy)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := NewAuthenticator(map[string]string{"r": "reader", "w": "writer", "a": "admin"}, false).Middleware(h.ACLMiddleware(mux))
+
+	do := func(method, target, token, body string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		method, target, token, body string
+		want                        int
+	}{
+		{http.MethodGet, "/api/v1/files?path=/shared/a.txt", "r", "", http.StatusOK},
+		{http.MethodGet, "/api/v1/files?path=/shared/a.txt", "", "", http.StatusOK},
+		{http.MethodPut, "/api/v1/files?path=/shared/a.txt", "r", "x", http.StatusForbidden},
+		{http.MethodPut, "/api/v1/files?path=/shared/b.txt", "w", "x", http.StatusOK},
+		{http.MethodGet, "/api/v1/directories?path=/private", "w", "", http.StatusForbidden},
+		{http.MethodPost, "/api/v1/rename?path=/shared/b.txt", "w", `{"newPath":"/private/b.txt"}`, http.StatusForbidden},
+		{http.MethodPost, "/api/v1/batch", "w", `{"ops":[{"op":"mkdir","path":"/private/x"}]}`, http.StatusForbidden},
+		{http.MethodPost, "/api/v1/unmount", "w", `{"path":"/shared"}`, http.StatusForbidden},
+		{http.MethodPut, "/api/v1/files?path=/etc/acl", "w", "writer admin /\n", http.StatusForbidden},
+		{http.MethodPut, "/api/v1/files?path=/etc/acl", "a", "writer sudo /\n", http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/write?path=/etc/acl", "a", "reader write /private\n", http.StatusForbidden},
+		{http.MethodPut, "/api/v1/files?path=/etc/acl", "a", "reader write /private\n", http.StatusOK},
+		{http.MethodPut, "/api/v1/files?path=/private/r.txt", "r", "x", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := do(tt.method, tt.target, tt.token, tt.body); got != tt.want {
+			t.Errorf("%s %s as %q: status %d, want %d", tt.method, tt.target, tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestACLSymlinks(t *testing.T) {
+	root := mountablefs.NewMountableFS(api.PoolConfig{})
+	store := memfs.NewMemFSPlugin()
+	store.Initialize(map[string]interface{}{})
+	root.Mount("/data", store)
+	for _, dir := range []string{"/data/shared", "/data/private", "/data/etc"} {
+		root.Mkdir(dir, 0755)
+	}
+	root.Write("/data/private/secret.txt", []byte("secret"), -1, filesystem.WriteFlagCreate)
+	root.Symlink("../private", "/data/shared/p")
+	root.Symlink("/data/etc/acl", "/data/shared/acl")
+	h := NewHandler(root, nil)
+	policy, err := acl.NewPolicy(root, acl.Config{
+		File: "/data/etc/acl",
+		Rules: []acl.Rule{
+			{Identity: "*", Path: "/data/shared", Permission: acl.Read},
+			{Identity: "writer", Path: "/data/shared", Permission: acl.Write},
+			{Identity: "admin", Path: "/", Permission: acl.Admin},
+			{Identity: "admin", Path: "/data/shared", Permission: acl.Admin},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	h.SetACL(policy)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := NewAuthenticator(map[string]string{"w": "writer", "a": "admin"}, false).Middleware(h.ACLMiddleware(mux))
+
+	tests := []struct {
+		method, target, token, body string
+		want                        int
+	}{
+		// Rules apply where links lead paths, relative or not
+		{http.MethodGet, "/api/v1/files?path=/data/shared/p/secret.txt", "w", "", http.StatusForbidden},
+		{http.MethodPut, "/api/v1/files?path=/data/shared/p/planted.txt", "w", "x", http.StatusForbidden},
+		{http.MethodPost, "/api/v1/symlink?path=/data/shared/q", "w", `{"target":"../private"}`, http.StatusForbidden},
+		{http.MethodGet, "/api/v1/files?path=/data/shared/p/secret.txt", "a", "", http.StatusOK},
+		// The rules file is validated however it is reached
+		{http.MethodPut, "/api/v1/files?path=/data/shared/acl", "w", "writer admin /\n", http.StatusForbidden},
+		{http.MethodPut, "/api/v1/files?path=/data/shared/acl", "a", "writer sudo /\n", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s as %q: status %d, want %d: %s", tt.method, tt.target, tt.token, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/approvals"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// ApprovalsPagePath serves the web page for deciding on held requests
+const ApprovalsPagePath = "/approvals"
+
+// maxApprovalResult bounds the response body kept for an applied request
+const maxApprovalResult = 64 << 10
+
+// ApprovalsResponse represents a list of held requests
+type ApprovalsResponse struct {
+	Approvals []*approvals.Request `json:"approvals"`
+}
+
+// DecisionRequest is the optional body of an approve or reject request
+type DecisionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// SetApprovals enables the approval workflow for protected paths
+func (h *Handler) SetApprovals(manager *approvals.Manager) {
+	h.approvals = manager
+}
+
+// ApprovalMiddleware holds mutations of protected paths for approval,
+// answering them with 202 Accepted. It also serves the approval API and
+// page, because approving a request applies it on next.
+func (h *Handler) ApprovalMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.approvals == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch {
+		case r.URL.Path == ApprovalsPagePath:
+			h.ApprovalsPage(w, r)
+			return
+		case r.URL.Path == "/api/v1/approvals":
+			h.ListApprovals(w, r)
+			return
+		case strings.HasPrefix(r.URL.Path, "/api/v1/approvals/"):
+			h.approvalAction(w, r, next)
+			return
+		}
+
+		paths, body, err := h.protectedPaths(w, r)
+		if err != nil {
+			writeRequestBodyError(w, err, h.maxRequestBodyBytes, "invalid request body")
+			return
+		}
+		if len(paths) == 0 {
+			if body != nil {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == "/api/v1/handles/open" {
+			writeError(w, http.StatusForbidden, filesystem.NewPermissionDeniedError("open", paths[0], "protected paths cannot be opened for writing; write them with PUT /files to request approval").Error())
+			return
+		}
+		if r.URL.Path == "/api/v1/uploads" {
+			writeError(w, http.StatusForbidden, filesystem.NewPermissionDeniedError("upload", paths[0], "protected paths cannot be uploaded to; write them with PUT /files to request approval").Error())
+			return
+		}
+
+		held, err := h.approvals.Hold(&approvals.Request{
+			Identity: IdentityFromContext(r.Context()),
+			Method:   r.Method,
+			Endpoint: strings.TrimPrefix(r.URL.Path, "/api/v1"),
+			Query:    r.URL.RawQuery,
+			Header:   replayHeaders(r.Header),
+			Paths:    paths,
+			Body:     body,
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		log.Infof("[approvals] Holding %s %s for approval as %s", r.Method, r.URL.Path, held.ID)
+		w.Header().Set("Location", "/api/v1/approvals/"+held.ID)
+		writeJSON(w, http.StatusAccepted, held)
+	})
+}
+
+// protectedPaths returns the protected paths a mutating request touches,
+// and its body if it had to be read to find them
+func (h *Handler) protectedPaths(w http.ResponseWriter, r *http.Request) ([]string, []byte, error) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || readOnlyPostEndpoints[r.URL.Path] {
+		return nil, nil, nil
+	}
+	query := r.URL.Query()
+	if r.URL.Path == "/api/v1/handles/open" {
+		flags, err := parseOpenFlags(query.Get("flags"))
+		writes := filesystem.O_WRONLY | filesystem.O_RDWR | filesystem.O_APPEND | filesystem.O_CREATE | filesystem.O_TRUNC
+		if err != nil || flags&writes == 0 {
+			return nil, nil, nil
+		}
+	}
+
+	var candidates []string
+	if p := query.Get("path"); p != "" {
+		candidates = append(candidates, p)
+	}
+	bodyPaths := r.URL.Path == "/api/v1/rename" || r.URL.Path == "/api/v1/symlink" || r.URL.Path == "/api/v1/batch"
+	if !bodyPaths && !h.anyProtected(candidates) {
+		return nil, nil, nil
+	}
+
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxRequestBodyBytes))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if bodyPaths && len(body) > 0 {
+		var req struct {
+			NewPath string           `json:"newPath"`
+			Target  string           `json:"target"`
+			Ops     []BatchOpRequest `json:"ops"`
+		}
+		if err := json.Unmarshal(body, &req); err == nil {
+			candidates = append(candidates, req.NewPath, req.Target)
+			for _, op := range req.Ops {
+				candidates = append(candidates, op.Path, op.NewPath)
+			}
+		}
+	}
+
+	var paths []string
+	for _, p := range candidates {
+		if p != "" && h.approvals.Protected(p) {
+			paths = append(paths, filesystem.NormalizePath(p))
+		}
+	}
+	return paths, body, nil
+}
+
+// anyProtected reports whether one of paths needs approval
+func (h *Handler) anyProtected(paths []string) bool {
+	for _, p := range paths {
+		if h.approvals.Protected(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListApprovals handles GET /approvals?status=<status>
+func (h *Handler) ListApprovals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	list := h.approvals.List(r.URL.Query().Get("status"))
+	if list == nil {
+		list = []*approvals.Request{}
+	}
+	writeJSON(w, http.StatusOK, ApprovalsResponse{Approvals: list})
+}
+
+// approvalAction handles GET /approvals/<id> and
+// POST /approvals/<id>/approve and /approvals/<id>/reject
+func (h *Handler) approvalAction(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/approvals/"), "/")
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		req, err := h.approvals.Get(id)
+		if err != nil {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, req)
+		return
+	case (action == "approve" || action == "reject") && r.Method == http.MethodPost:
+	case action == "approve" || action == "reject" || action == "":
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	default:
+		writeError(w, http.StatusNotFound, "unknown approval action: "+action)
+		return
+	}
+
+	var decision DecisionRequest
+	if r.ContentLength != 0 {
+		if err := decodeLimitedJSON(w, r, h.maxRequestBodyBytes, &decision); err != nil && !errors.Is(err, io.EOF) {
+			writeRequestBodyError(w, err, h.maxRequestBodyBytes, "invalid request body")
+			return
+		}
+	}
+
+	approver := IdentityFromContext(r.Context())
+	req, err := h.approvals.Decide(id, approver, action == "approve", decision.Reason)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	log.Infof("[approvals] %s %s by %q", id, req.Status, approver)
+	if req.Status == approvals.StatusApproved {
+		result := h.applyApproved(r, req, next)
+		h.approvals.Complete(id, result)
+		req.Result = &result
+	}
+	req.Body = nil
+	writeJSON(w, http.StatusOK, req)
+}
+
+// applyApproved runs an approved request on next as its original sender
+func (h *Handler) applyApproved(r *http.Request, req *approvals.Request, next http.Handler) approvals.Result {
+	target := "/api/v1" + req.Endpoint
+	if req.Query != "" {
+		target += "?" + req.Query
+	}
+	applied, err := http.NewRequestWithContext(WithIdentity(r.Context(), req.Identity), req.Method, target, bytes.NewReader(req.Body))
+	if err != nil {
+		return approvals.Result{Status: http.StatusInternalServerError, Body: err.Error()}
+	}
+	for k, v := range req.Header {
+		applied.Header.Set(k, v)
+	}
+
+	rw := &bufferingWriter{header: make(http.Header)}
+	next.ServeHTTP(rw, applied)
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	return approvals.Result{Status: rw.status, Body: strings.TrimSpace(rw.body.String())}
+}
+
+// bufferingWriter keeps the status and the start of a response body
+type bufferingWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferingWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if room := maxApprovalResult - w.body.Len(); room > 0 {
+		w.body.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func (w *bufferingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// ApprovalsPage handles GET /approvals, a page listing pending requests
+// with buttons to approve or reject them through the API
+func (h *Handler) ApprovalsPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(approvalsPage))
+}
+
+// approvalsPage is the approval web page. The token is kept in the
+// browser's local storage and sent as a bearer token.
+const approvalsPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>AGFS Approvals</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 6px; text-align: left; vertical-align: top; }
+code { font-size: 90%; }
+</style>
+</head>
+<body>
+<h1>Pending approvals</h1>
+<p>Token: <input id="token" type="password" size="40"> <button onclick="saveToken()">Save</button> <button onclick="load()">Refresh</button></p>
+<p id="error" style="color: #b00"></p>
+<table>
+<thead><tr><th>Requested</th><th>By</th><th>Request</th><th>Paths</th><th>Bytes</th><th>Expires</th><th></th></tr></thead>
+<tbody id="rows"></tbody>
+</table>
+<script>
+const tokenInput = document.getElementById("token");
+tokenInput.value = localStorage.getItem("agfs-token") || "";
+function saveToken() { localStorage.setItem("agfs-token", tokenInput.value); load(); }
+function headers() {
+  const h = {"Content-Type": "application/json"};
+  if (tokenInput.value) h["Authorization"] = "Bearer " + tokenInput.value;
+  return h;
+}
+function text(s) { const span = document.createElement("span"); span.textContent = s; return span.innerHTML; }
+async function load() {
+  document.getElementById("error").textContent = "";
+  const resp = await fetch("/api/v1/approvals?status=pending", {headers: headers()});
+  const data = await resp.json();
+  if (!resp.ok) { document.getElementById("error").textContent = data.error; return; }
+  document.getElementById("rows").innerHTML = data.approvals.map(a =>
+    "<tr><td>" + text(new Date(a.created_at).toLocaleString()) + "</td><td>" + text(a.identity || "anonymous") +
+    "</td><td><code>" + text(a.method + " " + a.endpoint + (a.query ? "?" + decodeURIComponent(a.query) : "")) +
+    "</code></td><td>" + a.paths.map(text).join("<br>") + "</td><td>" + a.size +
+    "</td><td>" + text(new Date(a.expires_at).toLocaleString()) +
+    "</td><td><button onclick=\"decide('" + a.id + "', 'approve')\">Approve</button> " +
+    "<button onclick=\"decide('" + a.id + "', 'reject')\">Reject</button></td></tr>").join("");
+}
+async function decide(id, action) {
+  const reason = action === "reject" ? (prompt("Reason for rejecting?") || "") : "";
+  const resp = await fetch("/api/v1/approvals/" + id + "/" + action, {method: "POST", headers: headers(), body: JSON.stringify({reason: reason})});
+  const data = await resp.json();
+  if (!resp.ok) { document.getElementById("error").textContent = data.error; return; }
+  if (data.result && data.result.status >= 400) { document.getElementById("error").textContent = "Applied with status " + data.result.status + ": " + data.result.body; }
+  load();
+}
+load();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/approvals"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestApprovalMiddleware(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	fs.Mkdir("/prod", 0755)
+	fs.Mkdir("/scratch", 0755)
+	h := NewHandler(fs, nil)
+	manager, err := approvals.NewManager(approvals.Config{Protected: []string{"/prod"}})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	h.SetApprovals(manager)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := NewAuthenticator(map[string]string{"a": "agent", "h": "human"}, false).Middleware(h.DryRunMiddleware(h.ApprovalMiddleware(mux)))
+
+	do := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPut, "/api/v1/files?path=/scratch/a.txt", "a", "free"); rec.Code != http.StatusOK {
+		t.Errorf("unprotected write: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec := do(http.MethodPut, "/api/v1/files?path=/prod/a.txt", "a", "hello")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("protected write: status %d, want 202: %s", rec.Code, rec.Body)
+	}
+	var held approvals.Request
+	json.Unmarshal(rec.Body.Bytes(), &held)
+	if held.Identity != "agent" || held.Status != approvals.StatusPending || len(held.Paths) != 1 || held.Paths[0] != "/prod/a.txt" {
+		t.Errorf("held request = %+v", held)
+	}
+	if _, err := fs.Stat("/prod/a.txt"); err == nil {
+		t.Fatal("held write was applied")
+	}
+
+	if rec := do(http.MethodPost, "/api/v1/rename?path=/scratch/a.txt", "a", `{"newPath":"/prod/b.txt"}`); rec.Code != http.StatusAccepted {
+		t.Errorf("rename into a protected path: status %d, want 202", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/v1/handles/open?path=/prod/a.txt&flags="+strconv.Itoa(int(filesystem.O_WRONLY|filesystem.O_CREATE)), "a", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("open for writing: status %d, want 403", rec.Code)
+	}
+
+	rec = do(http.MethodPut, "/api/v1/files?path=/prod/a.txt&dry_run=true", "a", "hello")
+	var preview filesystem.DryRunResult
+	json.Unmarshal(rec.Body.Bytes(), &preview)
+	if rec.Code != http.StatusOK || len(preview.Verdicts) != 1 || preview.Verdicts[0].Policy != "approval" {
+		t.Errorf("dry run: status %d, result %+v, want an approval verdict", rec.Code, preview)
+	}
+
+	if rec := do(http.MethodPost, "/api/v1/approvals/"+held.ID+"/approve", "a", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("self approval: status %d, want 403", rec.Code)
+	}
+	rec = do(http.MethodPost, "/api/v1/approvals/"+held.ID+"/approve", "h", "")
+	var decided approvals.Request
+	json.Unmarshal(rec.Body.Bytes(), &decided)
+	if rec.Code != http.StatusOK || decided.Status != approvals.StatusApproved || decided.Result == nil || decided.Result.Status != http.StatusOK {
+		t.Fatalf("approve: status %d: %s", rec.Code, rec.Body)
+	}
+	if data, _ := fs.Read("/prod/a.txt", 0, -1); string(data) != "hello" {
+		t.Errorf("approved write = %q, want hello", data)
+	}
+
+	var list ApprovalsResponse
+	rec = do(http.MethodGet, "/api/v1/approvals?status=pending", "h", "")
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list.Approvals) != 1 || list.Approvals[0].Endpoint != "/rename" {
+		t.Fatalf("pending approvals = %+v, want the rename", list.Approvals)
+	}
+	rec = do(http.MethodPost, "/api/v1/approvals/"+list.Approvals[0].ID+"/reject", "h", `{"reason":"no"}`)
+	json.Unmarshal(rec.Body.Bytes(), &decided)
+	if rec.Code != http.StatusOK || decided.Status != approvals.StatusRejected || decided.Reason != "no" {
+		t.Errorf("reject: status %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := fs.Stat("/scratch/a.txt"); err != nil {
+		t.Error("rejected rename was applied")
+	}
+
+	if rec := do(http.MethodGet, ApprovalsPagePath, "", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("approvals page: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// Archive formats
+const (
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// archiveErrorsFile lists the files that could not be read, if any, as the
+// last entry of an archive
+const archiveErrorsFile = ".agfs-archive-errors.txt"
+
+// maxBufferedArchiveFile bounds the files read into memory to learn their
+// exact size for tar headers. Larger files are streamed with their stat size.
+const maxBufferedArchiveFile = 64 << 20
+
+var archiveContentTypes = map[string]string{
+	ArchiveTar:   "application/x-tar",
+	ArchiveTarGz: "application/gzip",
+	ArchiveZip:   "application/zip",
+}
+
+// archiveWriter adds entries to an archive
+type archiveWriter interface {
+	addDir(name string, info *filesystem.FileInfo) error
+	addFile(name string, info *filesystem.FileInfo, r io.Reader, size int64) error
+	Close() error
+}
+
+// Archive handles GET /archive?path=<dir>&format=<tar|tar.gz|zip>
+// The subtree is streamed as an archive whose entries are prefixed with the
+// directory's name. Walk filters (max_depth, include, exclude) apply.
+func (h *Handler) Archive(w http.ResponseWriter, r *http.Request) {
+	dir := r.URL.Query().Get("path")
+	if dir == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = ArchiveTarGz
+	case "tgz":
+		format = ArchiveTarGz
+	}
+	contentType, ok := archiveContentTypes[format]
+	if !ok {
+		writeError(w, http.StatusBadRequest, filesystem.NewInvalidArgumentError("format", format, "must be tar, tar.gz or zip").Error())
+		return
+	}
+	opts, err := parseWalkOptions(r)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	// Fail fast with a proper status code before committing to a streamed body
+	dir = filesystem.NormalizePath(dir)
+	info, err := h.fs.Stat(dir)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	if !info.IsDir {
+		writeError(w, http.StatusBadRequest, filesystem.NewNotDirectoryError(dir).Error())
+		return
+	}
+
+	prefix := path.Base(dir)
+	if dir == "/" {
+		prefix = "root"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, prefix, format))
+	w.WriteHeader(http.StatusOK)
+
+	aw := newArchiveWriter(w, format)
+	var unreadable []string
+	count := 0
+	walkErr := aw.addDir(prefix, info)
+	if walkErr == nil {
+		walkErr = filesystem.Walk(h.fs, dir, opts, func(entry filesystem.WalkEntry) error {
+			if err := r.Context().Err(); err != nil {
+				return err
+			}
+			name := prefix + "/" + strings.TrimPrefix(strings.TrimPrefix(entry.Path, dir), "/")
+			if entry.Info.IsDir {
+				return aw.addDir(name, &entry.Info)
+			}
+			count++
+			if err := h.archiveFile(aw, name, entry.Path, &entry.Info); err != nil {
+				if _, unread := err.(*unreadableError); !unread {
+					return err
+				}
+				unreadable = append(unreadable, entry.Path+": "+err.Error())
+			}
+			return nil
+		})
+	}
+	if walkErr == nil && len(unreadable) > 0 {
+		report := []byte(strings.Join(unreadable, "\n") + "\n")
+		errInfo := &filesystem.FileInfo{Name: archiveErrorsFile, Size: int64(len(report)), Mode: 0644, ModTime: time.Now()}
+		walkErr = aw.addFile(prefix+"/"+archiveErrorsFile, errInfo, bytes.NewReader(report), errInfo.Size)
+	}
+	if walkErr != nil {
+		// The archive is left without its end marker, so clients see it as truncated
+		log.Warnf("[handler] Archive %s stopped early: %v", dir, walkErr)
+		return
+	}
+	if err := aw.Close(); err != nil {
+		log.Warnf("[handler] Archive %s: %v", dir, err)
+		return
+	}
+	log.Debugf("[handler] Archived %s: %d files, %d unreadable", dir, count, len(unreadable))
+}
+
+// unreadableError marks a file skipped because it could not be read; the
+// archive goes on without it
+type unreadableError struct {
+	err error
+}
+
+func (e *unreadableError) Error() string {
+	return e.err.Error()
+}
+
+// archiveFile adds the file at p as name
+func (h *Handler) archiveFile(aw archiveWriter, name, p string, info *filesystem.FileInfo) error {
+	reader, err := h.fs.Open(p)
+	if err != nil {
+		// Not every plugin implements Open
+		data, readErr := h.fs.Read(p, 0, -1)
+		if readErr != nil && readErr != io.EOF {
+			return &unreadableError{readErr}
+		}
+		reader = io.NopCloser(bytes.NewReader(data))
+		info.Size = int64(len(data))
+	} else if info.Size <= maxBufferedArchiveFile {
+		// Sizes of generated files are often unknown, so read small files
+		// to learn their exact length
+		data, err := io.ReadAll(io.LimitReader(reader, maxBufferedArchiveFile+1))
+		reader.Close()
+		if err != nil {
+			return &unreadableError{err}
+		}
+		if int64(len(data)) <= maxBufferedArchiveFile {
+			info.Size = int64(len(data))
+			reader = io.NopCloser(bytes.NewReader(data))
+		} else if reader, err = h.fs.Open(p); err != nil {
+			return &unreadableError{err}
+		}
+	}
+	defer reader.Close()
+	h.recordArchiveRead(info.Size)
+	return aw.addFile(name, info, reader, info.Size)
+}
+
+func (h *Handler) recordArchiveRead(n int64) {
+	if h.trafficMonitor != nil && n > 0 {
+		h.trafficMonitor.RecordRead(n)
+	}
+}
+
+func newArchiveWriter(w io.Writer, format string) archiveWriter {
+	switch format {
+	case ArchiveZip:
+		return &zipArchive{zw: zip.NewWriter(w)}
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarArchive{tw: tar.NewWriter(gz), gz: gz}
+	default:
+		return &tarArchive{tw: tar.NewWriter(w)}
+	}
+}
+
+// archiveMode returns the permission bits to archive, with a default for
+// plugins that report none
+func archiveMode(info *filesystem.FileInfo, fallback uint32) int64 {
+	if mode := info.Mode & 0777; mode != 0 {
+		return int64(mode)
+	}
+	return int64(fallback)
+}
+
+type tarArchive struct {
+	tw *tar.Writer
+	gz *gzip.Writer // nil for uncompressed archives
+}
+
+func (a *tarArchive) addDir(name string, info *filesystem.FileInfo) error {
+	return a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     archiveMode(info, 0755),
+		ModTime:  info.ModTime,
+	})
+}
+
+func (a *tarArchive) addFile(name string, info *filesystem.FileInfo, r io.Reader, size int64) error {
+	if err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     archiveMode(info, 0644),
+		ModTime:  info.ModTime,
+	}); err != nil {
+		return err
+	}
+	n, err := io.CopyN(a.tw, r, size)
+	if err == io.EOF {
+		return fmt.Errorf("%s: shrank to %d of %d bytes while archiving", name, n, size)
+	}
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.gz != nil {
+		return a.gz.Close()
+	}
+	return nil
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) addDir(name string, info *filesystem.FileInfo) error {
+	header := &zip.FileHeader{Name: name + "/", Modified: info.ModTime}
+	header.SetMode(os.ModeDir | os.FileMode(archiveMode(info, 0755)))
+	_, err := a.zw.CreateHeader(header)
+	return err
+}
+
+func (a *zipArchive) addFile(name string, info *filesystem.FileInfo, r io.Reader, size int64) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: info.ModTime}
+	header.SetMode(os.FileMode(archiveMode(info, 0644)))
+	fw, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func doArchive(t *testing.T, h *Handler, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.Archive(rec, httptest.NewRequest(http.MethodGet, "/api/v1/archive?"+query, nil))
+	return rec
+}
+
+func readTar(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	entries := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("read tar: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		entries[header.Name] = string(data)
+	}
+}
+
+func names(entries map[string]string) []string {
+	var out []string
+	for name := range entries {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestArchiveFormats(t *testing.T) {
+	h := NewHandler(newWalkTestFS(t), nil)
+	want := []string{"a/", "a/b/", "a/b/c/", "a/b/c/z.txt", "a/b/y.go", "a/x.txt"}
+
+	rec := doArchive(t, h, "path=/a&format=tar")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-tar" {
+		t.Fatalf("tar: status %d, type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	entries := readTar(t, rec.Body)
+	if got := names(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("tar entries = %v, want %v", got, want)
+	}
+	if entries["a/b/c/z.txt"] != "data" {
+		t.Errorf("tar content = %q", entries["a/b/c/z.txt"])
+	}
+
+	rec = doArchive(t, h, "path=/a")
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename="a.tar.gz"` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if got := names(readTar(t, gz)); !reflect.DeepEqual(got, want) {
+		t.Errorf("tar.gz entries = %v, want %v", got, want)
+	}
+
+	rec = doArchive(t, h, "path=/a/b&format=zip")
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	var got []string
+	for _, f := range zr.File {
+		got = append(got, f.Name)
+		if f.Name == "b/y.go" {
+			rc, _ := f.Open()
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(data) != "data" {
+				t.Errorf("zip content = %q", data)
+			}
+		}
+	}
+	sort.Strings(got)
+	if want := []string{"b/", "b/c/", "b/c/z.txt", "b/y.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("zip entries = %v, want %v", got, want)
+	}
+}
+
+func TestArchiveFilters(t *testing.T) {
+	h := NewHandler(newWalkTestFS(t), nil)
+	rec := doArchive(t, h, "path=/a&format=tar&include=*.txt&max_depth=1")
+	if got, want := names(readTar(t, rec.Body)), []string{"a/", "a/x.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+}
+
+func TestArchiveErrors(t *testing.T) {
+	h := NewHandler(newWalkTestFS(t), nil)
+	for query, status := range map[string]int{
+		"":                         http.StatusBadRequest,
+		"path=/a&format=rar":       http.StatusBadRequest,
+		"path=/a/x.txt&format=zip": http.StatusBadRequest,
+	} {
+		if rec := doArchive(t, h, query); rec.Code != status {
+			t.Errorf("%q: status %d, want %d", query, rec.Code, status)
+		}
+	}
+}
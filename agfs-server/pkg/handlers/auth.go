@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+type identityKey struct{}
+
+// IdentityFromContext returns the identity authenticated for a request, or
+// "" for anonymous requests
+func IdentityFromContext(ctx context.Context) string {
+	identity, _ := ctx.Value(identityKey{}).(string)
+	return identity
+}
+
+// WithIdentity returns a copy of ctx carrying identity
+func WithIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// Authenticator resolves "Authorization: Bearer <token>" headers to the
+// identities configured for the tokens
+type Authenticator struct {
+	tokens   map[string]string // Token -> identity
+	required bool
+}
+
+// NewAuthenticator creates an authenticator for tokens. With required set,
+// requests without a valid token are rejected; otherwise they are served
+// anonymously.
+func NewAuthenticator(tokens map[string]string, required bool) *Authenticator {
+	return &Authenticator{tokens: tokens, required: required}
+}
+
+// identify returns the identity of token, comparing against every
+// configured token in constant time
+func (a *Authenticator) identify(token string) (string, bool) {
+	var identity string
+	found := false
+	for candidate, id := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			identity, found = id, true
+		}
+	}
+	return identity, found
+}
+
+// Middleware attaches the caller's identity to the request context.
+// Health and readiness probes and the approvals and inbox pages, which
+// send their own token, never need one.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			if a.required && r.URL.Path != "/api/v1/health" && r.URL.Path != "/api/v1/ready" && r.URL.Path != ApprovalsPagePath && r.URL.Path != InboxPagePath {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "unsupported authorization scheme, use Bearer")
+			return
+		}
+		identity, ok := a.identify(strings.TrimSpace(token))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+	})
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/homes"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestAuthenticatorMiddleware(t *testing.T) {
+	var seen string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = IdentityFromContext(r.Context())
+	})
+	auth := NewAuthenticator(map[string]string{"secret": "alice"}, true).Middleware(next)
+
+	tests := []struct {
+		path   string
+		header string
+		code   int
+		want   string
+	}{
+		{"/api/v1/stat", "", http.StatusUnauthorized, ""},
+		{"/api/v1/stat", "Bearer wrong", http.StatusUnauthorized, ""},
+		{"/api/v1/stat", "Basic c2VjcmV0", http.StatusUnauthorized, ""},
+		{"/api/v1/stat", "Bearer secret", http.StatusOK, "alice"},
+		{"/api/v1/health", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		seen = ""
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		auth.ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("%s with %q: status %d, want %d", tt.path, tt.header, rec.Code, tt.code)
+		}
+		if seen != tt.want {
+			t.Errorf("%s with %q: identity %q, want %q", tt.path, tt.header, seen, tt.want)
+		}
+	}
+}
+
+func TestHomesMiddleware(t *testing.T) {
+	root := memfs.NewMemoryFS()
+	manager, err := homes.NewManager(root, homes.Config{Backend: "/store"})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	h := NewHandler(root, nil)
+	h.SetHomes(manager)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := NewAuthenticator(map[string]string{"a": "alice"}, false).Middleware(h.HomesMiddleware(mux))
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer a")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	do(http.MethodGet, "/api/v1/health", "")
+	if info, err := root.Stat("/store/alice"); err != nil || !info.IsDir {
+		t.Fatalf("home not provisioned: %v", err)
+	}
+
+	if rec := do(http.MethodPut, "/api/v1/files?path=/home/bob/x", "hi"); rec.Code != http.StatusForbidden {
+		t.Errorf("write to another home: status %d, want 403", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/v1/find?path=/home", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("find over all homes: status %d, want 403", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/v1/rename?path=/a", `{"newPath":"/home/bob/a"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("rename into another home: status %d, want 403", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/v1/batch", `{"ops":[{"op":"mkdir","path":"/home/bob/d"}]}`); rec.Code != http.StatusForbidden {
+		t.Errorf("batch into another home: status %d, want 403", rec.Code)
+	}
+
+	// Anonymous callers are not confined
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/files?path=/shared.txt", strings.NewReader("hi"))
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("anonymous write: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHomesSymlinkEscape(t *testing.T) {
+	root := mountablefs.NewMountableFS(api.PoolConfig{})
+	store := memfs.NewMemFSPlugin()
+	store.Initialize(map[string]interface{}{})
+	root.Mount("/store", store)
+	manager, err := homes.NewManager(root, homes.Config{Backend: "/store"})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	root.Mount(manager.Path(), manager.Plugin())
+	manager.Provision("alice")
+	manager.Provision("bob")
+	if _, err := root.Write("/home/bob/secret.txt", []byte("bob's"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	h := NewHandler(root, nil)
+	h.SetHomes(manager)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := NewAuthenticator(map[string]string{"a": "alice"}, false).Middleware(h.DryRunMiddleware(h.HomesMiddleware(mux)))
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer a")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Relative targets are resolved against the link's directory
+	if rec := do(http.MethodPost, "/api/v1/symlink?path=/home/alice/peek", `{"target":"../bob"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("relative symlink into another home: status %d, want 403", rec.Code)
+	}
+	rec := do(http.MethodPost, "/api/v1/symlink?path=/home/alice/peek&dry_run=true", `{"target":"../bob"}`)
+	var result filesystem.DryRunResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || result.Allowed {
+		t.Errorf("dry run of a relative symlink into another home = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, "/api/v1/symlink?path=/home/alice/notes", `{"target":"docs/notes.txt"}`); rec.Code != http.StatusCreated {
+		t.Errorf("relative symlink in the home: status %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Paths are checked where existing links lead them
+	if err := root.Symlink("../bob", "/home/alice/old"); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	if rec := do(http.MethodGet, "/api/v1/files?path=/home/alice/old/secret.txt", ""); rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "bob's") {
+		t.Errorf("read through a symlink into another home: status %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPut, "/api/v1/files?path=/home/alice/old/planted.txt", "x"); rec.Code != http.StatusForbidden {
+		t.Errorf("write through a symlink into another home: status %d", rec.Code)
+	}
+}
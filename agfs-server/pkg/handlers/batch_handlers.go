@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// BatchOpRequest represents a single operation in a batch request
+type BatchOpRequest struct {
+	Op        string `json:"op"`                  // mkdir, write, rename, remove
+	Path      string `json:"path"`                // Target path
+	NewPath   string `json:"new_path,omitempty"`  // Destination path (rename)
+	Data      string `json:"data,omitempty"`      // File content (write)
+	Encoding  string `json:"encoding,omitempty"`  // "base64" for binary data, empty for plain text
+	Mode      uint32 `json:"mode,omitempty"`      // Directory permissions (mkdir)
+	Recursive bool   `json:"recursive,omitempty"` // Remove directory contents (remove)
+}
+
+// BatchRequest represents a batch of operations executed in order
+type BatchRequest struct {
+	Ops    []BatchOpRequest `json:"ops"`
+	Atomic bool             `json:"atomic"` // All-or-nothing: roll back applied ops on first failure
+}
+
+// BatchOpResult represents the outcome of one batch operation
+type BatchOpResult struct {
+	Index   int    `json:"index"`
+	Op      string `json:"op"`
+	Path    string `json:"path"`
+	OK      bool   `json:"ok"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BatchResponse represents the result of a batch request
+type BatchResponse struct {
+	Results    []BatchOpResult `json:"results"`
+	Succeeded  int             `json:"succeeded"`
+	Failed     int             `json:"failed"`
+	RolledBack bool            `json:"rolled_back,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// Batch handles POST /batch
+// Best-effort batches always return 200 with per-operation results. Atomic
+// batches return the status of the failing operation after rolling back.
+func (h *Handler) Batch(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := decodeLimitedJSON(w, r, h.maxRequestBodyBytes, &req); err != nil {
+		writeRequestBodyError(w, err, h.maxRequestBodyBytes, "invalid request body")
+		return
+	}
+
+	ops := make([]filesystem.BatchOp, 0, len(req.Ops))
+	var totalBytes int64
+	for i, o := range req.Ops {
+		if !h.authorizePath(w, r, o.Path, o.Recursive) {
+			return
+		}
+		if o.NewPath != "" && !h.authorizePath(w, r, o.NewPath, false) {
+			return
+		}
+		data := []byte(o.Data)
+		switch o.Encoding {
+		case "":
+		case "base64":
+			decoded, err := base64.StdEncoding.DecodeString(o.Data)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, filesystem.NewInvalidArgumentError("ops.data", i, "invalid base64 data").Error())
+				return
+			}
+			data = decoded
+		default:
+			writeError(w, http.StatusBadRequest, filesystem.NewInvalidArgumentError("ops.encoding", o.Encoding, "must be empty or base64").Error())
+			return
+		}
+		totalBytes += int64(len(data))
+		ops = append(ops, filesystem.BatchOp{
+			Op:        o.Op,
+			Path:      o.Path,
+			NewPath:   o.NewPath,
+			Data:      data,
+			Mode:      o.Mode,
+			Recursive: o.Recursive,
+		})
+	}
+
+	if err := filesystem.ValidateBatch(h.fs, ops, req.Atomic); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	outcome := filesystem.ExecuteBatch(h.fs, ops, req.Atomic)
+
+	if h.trafficMonitor != nil && totalBytes > 0 {
+		h.trafficMonitor.RecordWrite(totalBytes)
+	}
+
+	response := BatchResponse{
+		Results:    make([]BatchOpResult, 0, len(outcome.Results)),
+		Succeeded:  outcome.Succeeded,
+		Failed:     outcome.Failed,
+		RolledBack: outcome.RolledBack,
+	}
+	for _, res := range outcome.Results {
+		item := BatchOpResult{
+			Index:   res.Index,
+			Op:      res.Op,
+			Path:    res.Path,
+			OK:      res.Err == nil && !res.Skipped,
+			Skipped: res.Skipped,
+		}
+		if res.Err != nil {
+			item.Error = res.Err.Error()
+		}
+		response.Results = append(response.Results, item)
+	}
+
+	status := http.StatusOK
+	if outcome.Err != nil {
+		response.Error = outcome.Err.Error()
+		if req.Atomic {
+			status = mapErrorToStatus(outcome.Err)
+			log.Warnf("[handler] Atomic batch of %d ops failed (rolled back: %v): %v", len(ops), outcome.RolledBack, outcome.Err)
+		}
+	}
+	writeJSON(w, status, response)
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func doBatch(t *testing.T, h *Handler, req BatchRequest) (int, BatchResponse) {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	rec := httptest.NewRecorder()
+	h.Batch(rec, httptest.NewRequest(http.MethodPost, "/api/v1/batch", bytes.NewReader(body)))
+
+	var resp BatchResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec.Code, resp
+}
+
+func TestBatchBestEffortContinuesAfterFailure(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	h := NewHandler(fs, nil)
+
+	code, resp := doBatch(t, h, BatchRequest{Ops: []BatchOpRequest{
+		{Op: "mkdir", Path: "/proj"},
+		{Op: "write", Path: "/proj/a.txt", Data: "hello"},
+		{Op: "rename", Path: "/missing", NewPath: "/other"},
+		{Op: "write", Path: "/proj/b.bin", Data: "AAEC", Encoding: "base64"},
+	}})
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if resp.Succeeded != 3 || resp.Failed != 1 || resp.Results[2].OK {
+		t.Fatalf("unexpected batch response: %+v", resp)
+	}
+
+	data, _ := fs.Read("/proj/b.bin", 0, -1)
+	if !bytes.Equal(data, []byte{0, 1, 2}) {
+		t.Errorf("base64 data not decoded: %v", data)
+	}
+}
+
+func TestBatchAtomicRollsBack(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	h := NewHandler(fs, nil)
+	fs.Mkdir("/proj", 0755)
+	fs.Write("/proj/existing.txt", []byte("original"), -1, filesystem.WriteFlagCreate)
+
+	code, resp := doBatch(t, h, BatchRequest{Atomic: true, Ops: []BatchOpRequest{
+		{Op: "mkdir", Path: "/proj/sub"},
+		{Op: "write", Path: "/proj/existing.txt", Data: "changed"},
+		{Op: "remove", Path: "/proj/existing.txt"},
+		{Op: "rename", Path: "/proj/missing", NewPath: "/proj/other"},
+		{Op: "write", Path: "/proj/never.txt", Data: "x"},
+	}})
+	if code == http.StatusOK {
+		t.Fatalf("atomic batch with a failing op should not return 200")
+	}
+	if !resp.RolledBack || !resp.Results[4].Skipped {
+		t.Fatalf("expected rollback and skipped tail: %+v", resp)
+	}
+
+	if _, err := fs.Stat("/proj/sub"); err == nil {
+		t.Errorf("mkdir was not rolled back")
+	}
+	data, err := fs.Read("/proj/existing.txt", 0, -1)
+	if (err != nil && err != io.EOF) || string(data) != "original" {
+		t.Errorf("write/remove not rolled back: %q, %v", data, err)
+	}
+}
+
+func TestBatchRejectsInvalidOps(t *testing.T) {
+	h := NewHandler(memfs.NewMemoryFS(), nil)
+
+	for _, req := range []BatchRequest{
+		{},
+		{Ops: []BatchOpRequest{{Op: "chmod", Path: "/a"}}},
+		{Ops: []BatchOpRequest{{Op: "rename", Path: "/a"}}},
+		{Atomic: true, Ops: []BatchOpRequest{{Op: "remove", Path: "/a", Recursive: true}}},
+	} {
+		if code, _ := doBatch(t, h, req); code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %+v, got %d", req, code)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// ContentTransferEncodingHeader selects how the body of a write is encoded:
+// "binary" (the default) carries the bytes as they are, "base64" carries
+// them base64-encoded for clients and proxies that are not 8-bit clean.
+// Either way the decoded bytes are written unchanged, including NUL bytes
+// and invalid UTF-8.
+const ContentTransferEncodingHeader = "Content-Transfer-Encoding"
+
+// Content transfer encodings of a write body
+const (
+	EncodingBinary = "binary"
+	EncodingBase64 = "base64"
+)
+
+// decodeWriteBody returns the bytes to write from a request body sent in
+// the Content-Transfer-Encoding of r
+func decodeWriteBody(r *http.Request, body []byte) ([]byte, error) {
+	return decodeBody(ContentTransferEncodingHeader, r.Header.Get(ContentTransferEncodingHeader), body)
+}
+
+// decodeBody decodes data sent in encoding; name identifies the encoding
+// parameter in errors. The MIME identity encodings 7bit and 8bit are
+// accepted as aliases of binary.
+func decodeBody(name, encoding string, data []byte) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", EncodingBinary, "8bit", "7bit":
+		return data, nil
+	case EncodingBase64:
+		// Line breaks, as MIME inserts them, are ignored
+		decoded := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+		n, err := base64.StdEncoding.Decode(decoded, data)
+		if err != nil {
+			return nil, filesystem.NewInvalidArgumentError(name, encoding, "body is not valid base64")
+		}
+		return decoded[:n], nil
+	default:
+		return nil, filesystem.NewInvalidArgumentError(name, encoding, "must be binary or base64")
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// binaryPayload has NUL bytes and invalid UTF-8 sequences
+var binaryPayload = []byte{0x00, 'a', 0xff, 0xfe, 0x00, 0xc3, 0x28, '\r', '\n', 0x80}
+
+func TestWriteBinaryContent(t *testing.T) {
+	h := NewHandler(memfs.NewMemoryFS(), nil)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+
+	encoded := base64.StdEncoding.EncodeToString(binaryPayload)
+	jsonBody, _ := json.Marshal(map[string]string{"data": encoded, "encoding": "base64"})
+	tests := []struct {
+		name     string
+		target   string
+		encoding string
+		body     string
+		jsonBody bool
+	}{
+		{name: "raw", target: "/api/v1/files?path=/raw", body: string(binaryPayload)},
+		{name: "binary", target: "/api/v1/files?path=/binary", encoding: "binary", body: string(binaryPayload)},
+		{name: "base64", target: "/api/v1/files?path=/base64", encoding: "base64", body: encoded[:8] + "\r\n" + encoded[8:]},
+		{name: "legacy json", target: "/api/v1/write?path=/json", body: string(jsonBody), jsonBody: true},
+	}
+	for _, tt := range tests {
+		method := http.MethodPut
+		if tt.jsonBody {
+			method = http.MethodPost
+		}
+		req := httptest.NewRequest(method, tt.target, strings.NewReader(tt.body))
+		if tt.encoding != "" {
+			req.Header.Set(ContentTransferEncodingHeader, tt.encoding)
+		}
+		if tt.jsonBody {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: write status %d: %s", tt.name, rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), "Written 10 bytes") {
+			t.Errorf("%s: response = %s, want 10 bytes written", tt.name, rec.Body.String())
+		}
+
+		path := tt.target[strings.Index(tt.target, "path=")+len("path="):]
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/files?path="+path, nil))
+		if !bytes.Equal(rec.Body.Bytes(), binaryPayload) {
+			t.Errorf("%s: read back %q, want %q", tt.name, rec.Body.Bytes(), binaryPayload)
+		}
+	}
+
+	for _, tt := range []struct{ encoding, body string }{
+		{encoding: "base64", body: "not base64!"},
+		{encoding: "gzip", body: "x"},
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/files?path=/bad", strings.NewReader(tt.body))
+		req.Header.Set(ContentTransferEncodingHeader, tt.encoding)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("encoding %q: status %d, want 400", tt.encoding, rec.Code)
+		}
+	}
+}
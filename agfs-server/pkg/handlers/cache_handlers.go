@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// immutableMaxAge is the freshness lifetime announced for immutable files
+// without a configured max age: a year, the longest RFC 9111 recommends
+const immutableMaxAge = 365 * 24 * time.Hour
+
+// CacheRule sets the cache hints of the files at or below Path
+type CacheRule struct {
+	Path      string
+	MaxAge    time.Duration // How long clients may reuse a response without revalidating
+	Immutable bool          // Files here are never rewritten once created
+}
+
+// SetCacheRules configures the Cache-Control hints of read and stat
+// responses. The rule with the longest matching path applies; files without
+// one are sent with "no-cache", so clients may keep them but must revalidate
+// with their ETag.
+func (h *Handler) SetCacheRules(rules []CacheRule) {
+	sorted := make([]CacheRule, len(rules))
+	for i, rule := range rules {
+		rule.Path = filesystem.NormalizePath(rule.Path)
+		sorted[i] = rule
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].Path) > len(sorted[j].Path) })
+	h.cacheRules = sorted
+}
+
+// cacheControl returns the Cache-Control value for a plain file
+func (h *Handler) cacheControl(path string) string {
+	path = filesystem.NormalizePath(path)
+	for _, rule := range h.cacheRules {
+		if path != rule.Path && rule.Path != "/" && !strings.HasPrefix(path, rule.Path+"/") {
+			continue
+		}
+		maxAge := rule.MaxAge
+		if rule.Immutable {
+			if maxAge <= 0 {
+				maxAge = immutableMaxAge
+			}
+			return fmt.Sprintf("max-age=%d, immutable", int64(maxAge/time.Second))
+		}
+		if maxAge > 0 {
+			return fmt.Sprintf("max-age=%d", int64(maxAge/time.Second))
+		}
+		break
+	}
+	return "no-cache"
+}
+
+// setStatCacheHeaders adds the validators and hints of a stat response.
+// Files that are not plain (queues, streams, virtual files) must not be
+// cached at all; directory stats are always revalidated.
+func (h *Handler) setStatCacheHeaders(w http.ResponseWriter, path string, info *filesystem.FileInfo) {
+	header := w.Header()
+	switch {
+	case info.IsDir:
+		header.Set("Cache-Control", "no-cache")
+	case info.Meta.Content[filesystem.MetaEncoding] == "":
+		header.Set("Cache-Control", "no-store")
+	default:
+		header.Set("ETag", fileETag(info))
+		header.Set("Cache-Control", h.cacheControl(path))
+	}
+}
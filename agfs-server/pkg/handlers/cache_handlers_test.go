@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestCacheHints(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	fs.Mkdir("/releases", 0755)
+	fs.Mkdir("/config", 0755)
+	fs.Write("/releases/v1.tar", []byte("v1"), -1, filesystem.WriteFlagCreate)
+	fs.Write("/config/app.yaml", []byte("a: 1"), -1, filesystem.WriteFlagCreate)
+	fs.Write("/notes.txt", []byte("hi"), -1, filesystem.WriteFlagCreate)
+	h := NewHandler(fs, nil)
+	h.SetCacheRules([]CacheRule{
+		{Path: "/", MaxAge: time.Second},
+		{Path: "/releases", Immutable: true},
+		{Path: "/config/", MaxAge: 5 * time.Minute},
+	})
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+	for path, want := range map[string]string{
+		"/releases/v1.tar": "max-age=31536000, immutable",
+		"/config/app.yaml": "max-age=300",
+		"/notes.txt":       "max-age=1",
+	} {
+		read := get("/api/v1/files?path=" + path)
+		stat := get("/api/v1/stat?path=" + path)
+		if got := read.Header().Get("Cache-Control"); got != want {
+			t.Errorf("read %s: Cache-Control %q, want %q", path, got, want)
+		}
+		if got := stat.Header().Get("Cache-Control"); got != want {
+			t.Errorf("stat %s: Cache-Control %q, want %q", path, got, want)
+		}
+		if etag := read.Header().Get("ETag"); etag == "" || stat.Header().Get("ETag") != etag {
+			t.Errorf("%s: read ETag %q, stat ETag %q", path, etag, stat.Header().Get("ETag"))
+		}
+	}
+
+	if got := get("/api/v1/stat?path=/config").Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("stat dir: Cache-Control %q, want no-cache", got)
+	}
+	if got := get("/api/v1/files?path=/notes.txt&offset=1").Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("offset read: Cache-Control %q, want no-store", got)
+	}
+
+	h.SetCacheRules(nil)
+	if got := get("/api/v1/files?path=/notes.txt").Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("without rules: Cache-Control %q, want no-cache", got)
+	}
+}
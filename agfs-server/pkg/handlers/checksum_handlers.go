@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Headers carrying the checksum of a transferred body: Content-MD5 holds
+// its base64 MD5 digest as RFC 1864 defines it, ContentSHA256Header its hex
+// SHA-256 digest. A write may send them as headers or, when the body is
+// streamed, as trailers; a read returns them as trailers.
+const (
+	ContentMD5Header    = "Content-MD5"
+	ContentSHA256Header = "X-AGFS-Content-SHA256"
+)
+
+// WantChecksumHeader asks for the checksum of the bytes of a read, e.g.
+// "X-AGFS-Want-Checksum: sha256" or "md5". The response is then chunked and
+// ends with the checksum trailer, so the client can verify what it received.
+const WantChecksumHeader = "X-AGFS-Want-Checksum"
+
+// ChecksumResponse represents a checksum response
+type ChecksumResponse struct {
+	Path      string `json:"path"`
+	Algorithm string `json:"algorithm"`
+	Checksum  string `json:"checksum"`
+	Native    bool   `json:"native"`          // True if served by the backend without streaming through the server
+	Match     *bool  `json:"match,omitempty"` // Set when an expected checksum was supplied
+}
+
+// Checksum handles GET /files/checksum?path=<path>&algorithm=<sha256|md5|xxh3>&expected=<hex>
+// With expected, the response reports whether the file content matches it.
+func (h *Handler) Checksum(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+
+	algorithm := strings.ToLower(r.URL.Query().Get("algorithm"))
+	if algorithm == "" {
+		algorithm = filesystem.ChecksumSHA256
+	}
+
+	info, err := h.fs.Stat(path)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	if info.IsDir {
+		writeError(w, http.StatusBadRequest, "cannot checksum a directory: "+path)
+		return
+	}
+
+	sum, native, err := filesystem.ComputeChecksum(h.fs, path, algorithm)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), "failed to calculate checksum: "+err.Error())
+		return
+	}
+
+	response := ChecksumResponse{
+		Path:      path,
+		Algorithm: algorithm,
+		Checksum:  sum,
+		Native:    native,
+	}
+	if expected := r.URL.Query().Get("expected"); expected != "" {
+		match := strings.EqualFold(expected, sum)
+		response.Match = &match
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// checkBodyChecksums verifies the body of a write against the checksums
+// the client sent with it, as headers or trailers, so that bytes corrupted
+// in transit are never stored. data is the decoded body, which the
+// checksums cover. On a mismatch it answers 422 Unprocessable Entity, which
+// clients may retry, and returns false.
+func checkBodyChecksums(w http.ResponseWriter, r *http.Request, data []byte) bool {
+	for _, name := range []string{ContentMD5Header, ContentSHA256Header} {
+		want := r.Header.Get(name)
+		if want == "" {
+			want = r.Trailer.Get(name)
+		}
+		if want == "" {
+			continue
+		}
+
+		var got string
+		if name == ContentMD5Header {
+			if sum, err := base64.StdEncoding.DecodeString(want); err != nil || len(sum) != md5.Size {
+				writeError(w, http.StatusBadRequest, filesystem.NewInvalidArgumentError(name, want, "must be a base64 MD5 digest").Error())
+				return false
+			}
+			sum := md5.Sum(data)
+			got = base64.StdEncoding.EncodeToString(sum[:])
+		} else {
+			if sum, err := hex.DecodeString(want); err != nil || len(sum) != sha256.Size {
+				writeError(w, http.StatusBadRequest, filesystem.NewInvalidArgumentError(name, want, "must be a hex SHA-256 digest").Error())
+				return false
+			}
+			sum := sha256.Sum256(data)
+			got = hex.EncodeToString(sum[:])
+		}
+		if !strings.EqualFold(want, got) {
+			writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("checksum mismatch: %s is %s, the body received hashes to %s", name, want, got))
+			return false
+		}
+	}
+	return true
+}
+
+// checksumWriter hashes the body of a successful read and sends the
+// checksum as a trailer once the body is written. Content-Length is
+// dropped, since trailers need a chunked response.
+type checksumWriter struct {
+	http.ResponseWriter
+	trailer string
+	hash    hash.Hash
+	encode  func([]byte) string
+	status  int
+}
+
+// newChecksumWriter returns a checksumWriter for the algorithm of a
+// WantChecksumHeader: "sha256" or "md5"
+func newChecksumWriter(w http.ResponseWriter, algorithm string) (*checksumWriter, error) {
+	switch strings.ToLower(strings.TrimSpace(algorithm)) {
+	case filesystem.ChecksumSHA256:
+		return &checksumWriter{ResponseWriter: w, trailer: ContentSHA256Header, hash: sha256.New(), encode: hex.EncodeToString}, nil
+	case filesystem.ChecksumMD5:
+		return &checksumWriter{ResponseWriter: w, trailer: ContentMD5Header, hash: md5.New(), encode: base64.StdEncoding.EncodeToString}, nil
+	default:
+		return nil, filesystem.NewInvalidArgumentError(WantChecksumHeader, algorithm, "supported: sha256, md5")
+	}
+}
+
+func (cw *checksumWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	if cw.verified() {
+		cw.Header().Del("Content-Length")
+		cw.Header().Add("Trailer", cw.trailer)
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *checksumWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	n, err := cw.ResponseWriter.Write(p)
+	if cw.verified() {
+		cw.hash.Write(p[:n])
+	}
+	return n, err
+}
+
+func (cw *checksumWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *checksumWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// verified reports whether the response carries content to checksum
+func (cw *checksumWriter) verified() bool {
+	return cw.status == http.StatusOK || cw.status == http.StatusPartialContent
+}
+
+// finish sets the checksum trailer of the bytes written
+func (cw *checksumWriter) finish() {
+	if cw.verified() {
+		cw.Header().Set(cw.trailer, cw.encode(cw.hash.Sum(nil)))
+	}
+}
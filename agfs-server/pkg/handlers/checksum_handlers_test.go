@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// sha256("hello world")
+const helloWorldSHA256 = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+
+func doChecksum(t *testing.T, h *Handler, query string) (*httptest.ResponseRecorder, ChecksumResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.Checksum(rec, httptest.NewRequest(http.MethodGet, "/api/v1/files/checksum?"+query, nil))
+	var resp ChecksumResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+		}
+	}
+	return rec, resp
+}
+
+func TestChecksumComputesAndVerifies(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	if _, err := fs.Write("/f.txt", []byte("hello world"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	h := NewHandler(fs, nil)
+
+	rec, resp := doChecksum(t, h, "path=/f.txt")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	if resp.Algorithm != filesystem.ChecksumSHA256 || resp.Checksum != helloWorldSHA256 {
+		t.Errorf("got %s:%s, want sha256:%s", resp.Algorithm, resp.Checksum, helloWorldSHA256)
+	}
+	if resp.Native {
+		t.Errorf("memfs checksum should be computed by streaming")
+	}
+	if resp.Match != nil {
+		t.Errorf("match should be omitted without expected")
+	}
+
+	_, resp = doChecksum(t, h, "path=/f.txt&expected="+helloWorldSHA256)
+	if resp.Match == nil || !*resp.Match {
+		t.Errorf("expected checksum to match")
+	}
+
+	_, resp = doChecksum(t, h, "path=/f.txt&expected=deadbeef")
+	if resp.Match == nil || *resp.Match {
+		t.Errorf("expected checksum mismatch")
+	}
+}
+
+func TestChecksumRejectsBadRequests(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	fs.Mkdir("/dir", 0755)
+	fs.Write("/f.txt", []byte("x"), -1, filesystem.WriteFlagCreate)
+	h := NewHandler(fs, nil)
+
+	for _, query := range []string{"", "path=/f.txt&algorithm=crc99", "path=/dir"} {
+		rec, _ := doChecksum(t, h, query)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d: %s", query, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestWireChecksums(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	h := NewHandler(fs, nil)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	put := func(body io.Reader, header ...string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPut, server.URL+"/api/v1/files?path=/f.txt", body)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := put(strings.NewReader("hello world"), ContentSHA256Header, helloWorldSHA256); resp.StatusCode != http.StatusOK {
+		t.Fatalf("verified write: status %d", resp.StatusCode)
+	}
+	if resp := put(strings.NewReader("hello w0rld"), ContentSHA256Header, helloWorldSHA256); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("corrupted write: status %d", resp.StatusCode)
+	}
+	if resp := put(strings.NewReader("hello w0rld"), ContentMD5Header, "XrY7u+Ae7tCTyyK7j1rNww=="); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("corrupted write with Content-MD5: status %d", resp.StatusCode)
+	}
+	if resp := put(strings.NewReader("hello world"), ContentMD5Header, "not-md5"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("malformed Content-MD5: status %d", resp.StatusCode)
+	}
+	if data, _ := fs.Read("/f.txt", 0, -1); string(data) != "hello world" {
+		t.Fatalf("content = %q after rejected writes", data)
+	}
+
+	// A streamed body sends its checksum as a trailer
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/api/v1/files?path=/f.txt", io.MultiReader(strings.NewReader("hello w0rld")))
+	req.ContentLength = -1
+	req.Trailer = http.Header{ContentSHA256Header: {helloWorldSHA256}}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT with trailer: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("corrupted write with trailer: status %d", resp.StatusCode)
+	}
+
+	for _, tt := range []struct{ algorithm, trailer, want string }{
+		{"sha256", ContentSHA256Header, helloWorldSHA256},
+		{"MD5", ContentMD5Header, "XrY7u+Ae7tCTyyK7j1rNww=="},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/v1/files?path=/f.txt", nil)
+		req.Header.Set(WantChecksumHeader, tt.algorithm)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(data) != "hello world" {
+			t.Fatalf("GET = %d %q", resp.StatusCode, data)
+		}
+		if got := resp.Trailer.Get(tt.trailer); got != tt.want {
+			t.Errorf("%s trailer = %q, want %q", tt.trailer, got, tt.want)
+		}
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/api/v1/files?path=/f.txt", nil)
+	req.Header.Set(WantChecksumHeader, "crc32")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unsupported algorithm: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// ContentTypeHeader sets a media type override on PUT /files
+const ContentTypeHeader = "X-AGFS-Content-Type"
+
+// ContentTypeResponse represents the media type of a file
+type ContentTypeResponse struct {
+	Path        string `json:"path"`
+	ContentType string `json:"content_type"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+// SetContentType handles PUT /files/content-type?path=<path>&type=<media type>
+// An empty type clears the override, so the type is detected again.
+func (h *Handler) SetContentType(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+
+	if err := h.setContentType(path, r.URL.Query().Get("type")); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	info, err := h.fs.Stat(path)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	contentType, encoding := filesystem.DescribeContent(h.fs, path, info)
+	writeJSON(w, http.StatusOK, ContentTypeResponse{Path: path, ContentType: contentType, Encoding: encoding})
+}
+
+// setContentType stores a media type override for path; "" clears it
+func (h *Handler) setContentType(path, contentType string) error {
+	if contentType != "" {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return filesystem.NewInvalidArgumentError("type", contentType, "not a valid media type")
+		}
+	}
+	typer, ok := h.fs.(filesystem.ContentTyper)
+	if !ok {
+		return filesystem.ErrNotSupported
+	}
+	return typer.SetContentType(path, contentType)
+}
+
+// describeContent adds the media type and encoding of a file to info.Meta
+func (h *Handler) describeContent(path string, info *filesystem.FileInfo) {
+	contentType, encoding := filesystem.DescribeContent(h.fs, path, info)
+	if contentType == "" && encoding == "" {
+		return
+	}
+	content := make(map[string]string, len(info.Meta.Content)+2)
+	for k, v := range info.Meta.Content {
+		content[k] = v
+	}
+	if contentType != "" {
+		content[filesystem.MetaContentType] = contentType
+	}
+	if encoding != "" {
+		content[filesystem.MetaEncoding] = encoding
+	}
+	info.Meta.Content = content
+}
+
+// responseContentType returns the Content-Type to serve path with
+func (h *Handler) responseContentType(path string) string {
+	if info, err := h.fs.Stat(path); err == nil {
+		if contentType, _ := filesystem.DescribeContent(h.fs, path, info); contentType != "" {
+			return contentType
+		}
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestStatReportsContentType(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	fs.Write("/notes.md", []byte("# Notes\n"), -1, filesystem.WriteFlagCreate)
+	h := NewHandler(fs, nil)
+
+	_, info := statResponse(t, h, "/notes.md")
+	if got := info.Meta.Content[filesystem.MetaContentType]; got != "text/markdown; charset=utf-8" {
+		t.Errorf("content_type = %q, want text/markdown", got)
+	}
+	if got := info.Meta.Content[filesystem.MetaEncoding]; got != "utf-8" {
+		t.Errorf("encoding = %q, want utf-8", got)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ReadFile(rec, httptest.NewRequest(http.MethodGet, "/api/v1/files?path=/notes.md", nil))
+	if got := rec.Header().Get("Content-Type"); got != "text/markdown; charset=utf-8" {
+		t.Errorf("read Content-Type = %q, want text/markdown", got)
+	}
+}
+
+func TestContentTypeOverride(t *testing.T) {
+	h := NewHandler(memfs.NewMemoryFS(), nil)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/files?path=/page", strings.NewReader("<p>hi</p>"))
+	req.Header.Set(ContentTypeHeader, "text/html; charset=utf-8")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("write status %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, info := statResponse(t, h, "/page"); info.Meta.Content[filesystem.MetaContentType] != "text/html; charset=utf-8" {
+		t.Errorf("content_type = %q after header override", info.Meta.Content[filesystem.MetaContentType])
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/v1/files/content-type?path=/page&type=application/x-custom", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "application/x-custom") {
+		t.Fatalf("set content type: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Clearing the override falls back to detection
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/v1/files/content-type?path=/page&type=", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("clear content type: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, info := statResponse(t, h, "/page"); info.Meta.Content[filesystem.MetaContentType] != "text/html; charset=utf-8" {
+		t.Errorf("content_type = %q after clearing, want detected text/html", info.Meta.Content[filesystem.MetaContentType])
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/api/v1/files?path=/bad", strings.NewReader("x"))
+	req.Header.Set(ContentTypeHeader, "not a type")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid content type: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// CounterResponse reports the value of a counter after an increment
+type CounterResponse struct {
+	Path  string `json:"path"`
+	Value int64  `json:"value"`
+}
+
+// Counter handles POST /counter?path=<path>&delta=<n>, atomically adding
+// delta (default 1) to the counter file at path and returning the new value.
+// The file is created if missing, so the first increment returns delta.
+func (h *Handler) Counter(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	delta := int64(1)
+	if deltaStr := r.URL.Query().Get("delta"); deltaStr != "" {
+		var err error
+		if delta, err = strconv.ParseInt(deltaStr, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "delta must be an integer")
+			return
+		}
+	}
+
+	value, err := filesystem.Increment(h.fs, path, delta)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	log.Debugf("[handler] Counter %s incremented by %d to %d", path, delta, value)
+	writeJSON(w, http.StatusOK, CounterResponse{Path: path, Value: value})
+}
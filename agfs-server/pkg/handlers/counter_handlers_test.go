@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestCounter(t *testing.T) {
+	h := NewHandler(memfs.NewMemoryFS(), nil)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	post := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/counter?"+query, nil))
+		return rec
+	}
+
+	for i, tc := range []struct {
+		query string
+		want  int64
+	}{
+		{"path=/seq", 1},
+		{"path=/seq", 2},
+		{"path=/seq&delta=10", 12},
+		{"path=/seq&delta=-2", 10},
+	} {
+		rec := post(tc.query)
+		var resp CounterResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != http.StatusOK || resp.Value != tc.want {
+			t.Errorf("step %d: status %d, value %d, want %d", i, rec.Code, resp.Value, tc.want)
+		}
+	}
+
+	if rec := post("delta=1"); rec.Code != http.StatusBadRequest {
+		t.Errorf("missing path: status %d", rec.Code)
+	}
+	if rec := post("path=/seq&delta=x"); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad delta: status %d", rec.Code)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/counter?path=/seq", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d", rec.Code)
+	}
+}
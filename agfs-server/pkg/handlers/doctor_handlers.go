@@ -0,0 +1,595 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+// Severities of doctor problems, most urgent first
+const (
+	DoctorCritical = "critical" // The mount is unusable
+	DoctorWarning  = "warning"  // The mount works, but poorly or partly
+	DoctorInfo     = "info"     // Worth knowing, nothing to fix
+)
+
+// Doctor checks, in the order they run for each mount
+const (
+	DoctorCheckMount        = "mount"        // The mount initialized
+	DoctorCheckBreaker      = "breaker"      // Its circuit breaker is closed
+	DoctorCheckConnectivity = "connectivity" // Its root can be listed
+	DoctorCheckRoundTrip    = "round_trip"   // A probe file can be written, read back and removed
+	DoctorCheckLatency      = "latency"      // Stat latency over several calls
+)
+
+const (
+	// doctorProbePrefix names the probe directory (or file, on mounts
+	// without directories) of a round trip
+	doctorProbePrefix = ".agfs-doctor-"
+	// doctorSlowLatency is the operation latency reported as slow
+	doctorSlowLatency = 500 * time.Millisecond
+	// doctorMountTimeout bounds the checks of one mount, so that a hung
+	// backend shows up as a problem instead of hanging the report
+	doctorMountTimeout = 30 * time.Second
+	// Stat calls timed by the latency check
+	defaultDoctorIterations = 5
+	maxDoctorIterations     = 100
+)
+
+// DoctorCheck is the outcome of one check of a mount
+type DoctorCheck struct {
+	Name      string  `json:"name"`
+	OK        bool    `json:"ok"`
+	Skipped   bool    `json:"skipped,omitempty"`
+	Detail    string  `json:"detail,omitempty"`
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+}
+
+// DoctorProblem is something a check found wrong, with a hint to fix it
+type DoctorProblem struct {
+	Severity string `json:"severity"`
+	Path     string `json:"path"`
+	Check    string `json:"check"`
+	Message  string `json:"message"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+// DoctorMountReport holds the checks of one mount
+type DoctorMountReport struct {
+	Path       string        `json:"path"`
+	PluginName string        `json:"pluginName,omitempty"`
+	Checks     []DoctorCheck `json:"checks"`
+}
+
+// DoctorResponse is the report of GET /doctor. Problems are ordered by
+// severity, then path.
+type DoctorResponse struct {
+	Status   string              `json:"status"` // "ok", or the severity of the worst problem
+	Problems []DoctorProblem     `json:"problems"`
+	Mounts   []DoctorMountReport `json:"mounts"`
+}
+
+// doctorOptions are the query parameters of GET /doctor
+type doctorOptions struct {
+	path       string // Only check the mount at this path
+	probe      bool   // Run write/read/delete round trips
+	iterations int    // Stat calls of the latency check
+}
+
+// doctorMount is a mount to check
+type doctorMount struct {
+	path       string
+	pluginName string
+	status     string // A MountStatus*, MountStatusMounted if untracked
+	err        string
+	fs         filesystem.FileSystem // nil unless mounted
+	pluginFS   filesystem.FileSystem // The plugin's own, for its capabilities
+}
+
+// Doctor handles GET /doctor?path=<mount>&probe=<bool>&iterations=<n>
+// It runs end-to-end checks against every mount, or the one at path, and
+// reports what is wrong, most urgent first. probe=false skips the round
+// trips, which write to each mount.
+func (h *Handler) Doctor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	opts, err := parseDoctorOptions(r)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	mounts := h.doctorMounts()
+	if opts.path != "" {
+		var selected []doctorMount
+		for _, m := range mounts {
+			if m.path == opts.path {
+				selected = append(selected, m)
+			}
+		}
+		if len(selected) == 0 {
+			writeError(w, http.StatusNotFound, "no mount at "+opts.path)
+			return
+		}
+		mounts = selected
+	}
+
+	writeJSON(w, http.StatusOK, runDoctor(mounts, h.breakerStatuses(), opts))
+}
+
+// parseDoctorOptions reads the query parameters of GET /doctor
+func parseDoctorOptions(r *http.Request) (doctorOptions, error) {
+	q := r.URL.Query()
+	opts := doctorOptions{probe: true, iterations: defaultDoctorIterations}
+	if path := q.Get("path"); path != "" {
+		opts.path = filesystem.NormalizePath(path)
+	}
+	if probe := q.Get("probe"); probe != "" {
+		value, err := strconv.ParseBool(probe)
+		if err != nil {
+			return opts, filesystem.NewInvalidArgumentError("probe", probe, "must be true or false")
+		}
+		opts.probe = value
+	}
+	if iterations := q.Get("iterations"); iterations != "" {
+		n, err := strconv.Atoi(iterations)
+		if err != nil || n < 1 || n > maxDoctorIterations {
+			return opts, filesystem.NewInvalidArgumentError("iterations", iterations,
+				fmt.Sprintf("must be between 1 and %d", maxDoctorIterations))
+		}
+		opts.iterations = n
+	}
+	return opts, nil
+}
+
+// doctorMounts lists the mounts in the tree and the configured mounts that
+// never made it there, sorted by path
+func (h *Handler) doctorMounts() []doctorMount {
+	byPath := make(map[string]doctorMount)
+	for _, status := range h.mountStatusTracker.Statuses() {
+		byPath[status.Path] = doctorMount{path: status.Path, pluginName: status.PluginName, status: status.Status, err: status.Error}
+	}
+	if mfs, ok := h.fs.(interface {
+		GetMounts() []*mountablefs.MountPoint
+	}); ok {
+		for _, mp := range mfs.GetMounts() {
+			m, tracked := byPath[mp.Path]
+			if !tracked {
+				m = doctorMount{path: mp.Path, status: MountStatusMounted}
+			}
+			if mp.Plugin != nil {
+				if m.pluginName == "" {
+					m.pluginName = mp.Plugin.Name()
+				}
+				m.pluginFS = mp.Plugin.GetFileSystem()
+			}
+			m.fs = h.fs
+			byPath[mp.Path] = m
+		}
+	} else {
+		// A single file system is one mount at the root
+		byPath["/"] = doctorMount{path: "/", status: MountStatusMounted, fs: h.fs, pluginFS: h.fs}
+	}
+
+	mounts := make([]doctorMount, 0, len(byPath))
+	for _, m := range byPath {
+		mounts = append(mounts, m)
+	}
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].path < mounts[j].path })
+	return mounts
+}
+
+// breakerStatuses returns the circuit breakers of the mounts by path
+func (h *Handler) breakerStatuses() map[string]mountablefs.BreakerStatus {
+	statuses := make(map[string]mountablefs.BreakerStatus)
+	if mfs, ok := h.fs.(interface {
+		CircuitBreakers() []mountablefs.BreakerStatus
+	}); ok {
+		for _, b := range mfs.CircuitBreakers() {
+			statuses[b.Path] = b
+		}
+	}
+	return statuses
+}
+
+// runDoctor checks the mounts concurrently and assembles the report
+func runDoctor(mounts []doctorMount, breakers map[string]mountablefs.BreakerStatus, opts doctorOptions) DoctorResponse {
+	type result struct {
+		report   DoctorMountReport
+		problems []DoctorProblem
+	}
+	results := make([]chan result, len(mounts))
+	for i, m := range mounts {
+		results[i] = make(chan result, 1)
+		go func(m doctorMount, out chan<- result) {
+			d := &mountDoctor{mount: m, report: DoctorMountReport{Path: m.path, PluginName: m.pluginName}}
+			d.run(breakers, opts)
+			out <- result{d.report, d.problems}
+		}(m, results[i])
+	}
+
+	response := DoctorResponse{Status: "ok", Problems: []DoctorProblem{}, Mounts: make([]DoctorMountReport, len(mounts))}
+	expired := make(chan struct{})
+	timer := time.AfterFunc(doctorMountTimeout, func() { close(expired) })
+	defer timer.Stop()
+	for i, m := range mounts {
+		var res result
+		var done bool
+		select {
+		case res, done = <-results[i]:
+		case <-expired:
+			// Keep checks that finished in time
+			select {
+			case res, done = <-results[i]:
+			default:
+			}
+		}
+		if done {
+			response.Mounts[i] = res.report
+			response.Problems = append(response.Problems, res.problems...)
+		} else {
+			// The checks keep running in the background until the backend
+			// answers; the report moves on without them
+			response.Mounts[i] = DoctorMountReport{Path: m.path, PluginName: m.pluginName, Checks: []DoctorCheck{}}
+			response.Problems = append(response.Problems, DoctorProblem{
+				Severity: DoctorCritical,
+				Path:     m.path,
+				Check:    DoctorCheckConnectivity,
+				Message:  fmt.Sprintf("checks did not finish within %s", doctorMountTimeout),
+				Hint:     "the backend is hanging; check its network reachability and timeouts",
+			})
+		}
+	}
+
+	sort.SliceStable(response.Problems, func(i, j int) bool {
+		a, b := response.Problems[i], response.Problems[j]
+		if doctorSeverityRank(a.Severity) != doctorSeverityRank(b.Severity) {
+			return doctorSeverityRank(a.Severity) < doctorSeverityRank(b.Severity)
+		}
+		return a.Path < b.Path
+	})
+	for _, p := range response.Problems {
+		if p.Severity != DoctorInfo {
+			response.Status = p.Severity
+			break
+		}
+	}
+	return response
+}
+
+func doctorSeverityRank(severity string) int {
+	switch severity {
+	case DoctorCritical:
+		return 0
+	case DoctorWarning:
+		return 1
+	}
+	return 2
+}
+
+// mountDoctor runs the checks of one mount
+type mountDoctor struct {
+	mount    doctorMount
+	report   DoctorMountReport
+	problems []DoctorProblem
+}
+
+func (d *mountDoctor) check(c DoctorCheck) {
+	d.report.Checks = append(d.report.Checks, c)
+}
+
+func (d *mountDoctor) problem(severity, check, message, hint string) {
+	d.problems = append(d.problems, DoctorProblem{Severity: severity, Path: d.mount.path, Check: check, Message: message, Hint: hint})
+}
+
+// join returns a path under the mount
+func (d *mountDoctor) join(name string) string {
+	return strings.TrimSuffix(d.mount.path, "/") + "/" + name
+}
+
+func (d *mountDoctor) run(breakers map[string]mountablefs.BreakerStatus, opts doctorOptions) {
+	if !d.checkMount() {
+		return
+	}
+	d.checkBreaker(breakers)
+	if !d.checkConnectivity() {
+		return
+	}
+	if opts.probe {
+		d.checkRoundTrip()
+	} else {
+		d.check(DoctorCheck{Name: DoctorCheckRoundTrip, OK: true, Skipped: true, Detail: "probe=false"})
+	}
+	d.checkLatency(opts.iterations)
+}
+
+// checkMount reports mounts that failed to initialize or are still
+// starting; only mounted ones are checked further
+func (d *mountDoctor) checkMount() bool {
+	switch d.mount.status {
+	case MountStatusFailed:
+		d.check(DoctorCheck{Name: DoctorCheckMount, Detail: d.mount.err})
+		d.problem(DoctorCritical, DoctorCheckMount, "mount failed: "+d.mount.err, mountErrorHint(d.mount.err))
+		return false
+	case MountStatusPending:
+		d.check(DoctorCheck{Name: DoctorCheckMount, Detail: "still initializing"})
+		d.problem(DoctorWarning, DoctorCheckMount, "mount is still initializing",
+			"plugins that connect to a backend at startup can take a while; if this persists, the backend may be unreachable")
+		return false
+	}
+	if d.mount.fs == nil {
+		d.check(DoctorCheck{Name: DoctorCheckMount, Detail: "not in the mount tree"})
+		d.problem(DoctorCritical, DoctorCheckMount, "mount is not in the mount tree", "it may have been unmounted; remount it")
+		return false
+	}
+	d.check(DoctorCheck{Name: DoctorCheckMount, OK: true})
+	return true
+}
+
+// mountErrorHint guesses the cause of an initialization error
+func mountErrorHint(msg string) string {
+	lower := strings.ToLower(msg)
+	for _, hint := range []struct {
+		words []string
+		hint  string
+	}{
+		{[]string{"credential", "access key", "secret", "token", "unauthorized", "forbidden", "401", "403", "access denied", "authentication", "password"},
+			"check the credentials in the mount config"},
+		{[]string{"connection refused", "no such host", "timeout", "timed out", "unreachable", "dial tcp"},
+			"check that the backend is running and reachable from the server"},
+		{[]string{"no such file", "does not exist", "not found", "permission denied"},
+			"check that the configured paths exist and are accessible to the server"},
+		{[]string{"unknown plugin"},
+			"check the plugin name, or that its external plugin is loaded"},
+		{[]string{"invalid", "required", "unknown config", "must be"},
+			"fix the mount config"},
+	} {
+		for _, word := range hint.words {
+			if strings.Contains(lower, word) {
+				return hint.hint
+			}
+		}
+	}
+	return "see the server log for details"
+}
+
+// checkBreaker reports an open circuit breaker
+func (d *mountDoctor) checkBreaker(breakers map[string]mountablefs.BreakerStatus) {
+	b, ok := breakers[d.mount.path]
+	if !ok {
+		return
+	}
+	if b.State == mountablefs.BreakerClosed {
+		d.check(DoctorCheck{Name: DoctorCheckBreaker, OK: true, Detail: b.State})
+		return
+	}
+	d.check(DoctorCheck{Name: DoctorCheckBreaker, Detail: b.State})
+	d.problem(DoctorCritical, DoctorCheckBreaker,
+		fmt.Sprintf("circuit breaker is %s after %d consecutive failures: %s", b.State, b.ConsecutiveFailures, b.LastError),
+		"operations fail fast until the backend recovers")
+}
+
+// checkConnectivity stats and lists the root of the mount
+func (d *mountDoctor) checkConnectivity() bool {
+	start := time.Now()
+	_, err := d.mount.fs.Stat(d.mount.path)
+	if err == nil {
+		_, err = d.mount.fs.ReadDir(d.mount.path)
+	}
+	latency := time.Since(start)
+	if err != nil {
+		d.check(DoctorCheck{Name: DoctorCheckConnectivity, Detail: err.Error(), LatencyMs: milliseconds(latency)})
+		d.problem(DoctorCritical, DoctorCheckConnectivity, "cannot list the mount: "+err.Error(), operationErrorHint(err))
+		return false
+	}
+	d.check(DoctorCheck{Name: DoctorCheckConnectivity, OK: true, LatencyMs: milliseconds(latency)})
+	return true
+}
+
+// operationErrorHint suggests a fix for a failed operation
+func operationErrorHint(err error) string {
+	switch {
+	case errors.Is(err, filesystem.ErrPermissionDenied):
+		return "check the credentials and permissions of the mount"
+	case errors.Is(err, filesystem.ErrTimeout), errors.Is(err, filesystem.ErrBackendUnavailable):
+		return "check that the backend is running and reachable from the server"
+	case errors.Is(err, filesystem.ErrQuotaExceeded):
+		return "free space or raise the quota of the mount"
+	case errors.Is(err, filesystem.ErrRateLimited):
+		return "the backend is throttling the server; retry later or raise its limits"
+	}
+	return mountErrorHint(err.Error())
+}
+
+// checkRoundTrip writes a probe file in a probe directory, reads it back
+// and removes both. Mounts without directories get the probe file at their
+// root; read-only mounts are reported without a round trip.
+func (d *mountDoctor) checkRoundTrip() {
+	if cp, ok := d.mount.pluginFS.(filesystem.CapabilityProvider); ok {
+		caps := cp.GetPathCapabilities("/")
+		switch {
+		case caps.IsReadOnly:
+			d.check(DoctorCheck{Name: DoctorCheckRoundTrip, OK: true, Skipped: true, Detail: "read-only"})
+			d.problem(DoctorInfo, DoctorCheckRoundTrip, "mount is read-only, round trip skipped", "")
+			return
+		case caps.IsAppendOnly || caps.IsReadDestructive || caps.IsBroadcast:
+			// A probe would be delivered to, or consumed from under, the
+			// mount's readers
+			d.check(DoctorCheck{Name: DoctorCheckRoundTrip, OK: true, Skipped: true, Detail: "queue or stream semantics"})
+			return
+		}
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	name := doctorProbePrefix + hex.EncodeToString(id)
+	content := []byte("agfs doctor probe " + hex.EncodeToString(id) + "\n")
+	fs := d.mount.fs
+	var steps []string
+	var slowest time.Duration
+	timed := func(step string, op func() error) error {
+		start := time.Now()
+		err := op()
+		elapsed := time.Since(start)
+		slowest = max(slowest, elapsed)
+		steps = append(steps, fmt.Sprintf("%s %.1fms", step, milliseconds(elapsed)))
+		return err
+	}
+	fail := func(step string, err error) {
+		d.check(DoctorCheck{Name: DoctorCheckRoundTrip, Detail: strings.Join(steps, ", ")})
+		d.problem(DoctorCritical, DoctorCheckRoundTrip, fmt.Sprintf("%s failed: %v", step, err), operationErrorHint(err))
+	}
+
+	dir := d.join(name)
+	file := dir + "/probe"
+	err := timed("mkdir", func() error { return fs.Mkdir(dir, 0755) })
+	switch {
+	case errors.Is(err, filesystem.ErrPermissionDenied):
+		d.check(DoctorCheck{Name: DoctorCheckRoundTrip, OK: true, Skipped: true, Detail: "read-only: " + err.Error()})
+		d.problem(DoctorInfo, DoctorCheckRoundTrip, "mount is read-only, round trip skipped", "")
+		return
+	case errors.Is(err, filesystem.ErrNotSupported):
+		dir, file = "", dir
+	case err != nil:
+		fail("mkdir", err)
+		return
+	}
+	cleanup := func() error {
+		if dir != "" {
+			return fs.RemoveAll(dir)
+		}
+		return fs.Remove(file)
+	}
+
+	if err := timed("write", func() error {
+		_, err := fs.Write(file, content, 0, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate)
+		return err
+	}); err != nil {
+		fail("write", err)
+		if dir != "" {
+			fs.RemoveAll(dir)
+		}
+		return
+	}
+	var data []byte
+	if err := timed("read", func() error {
+		var err error
+		data, err = fs.Read(file, 0, -1)
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	}); err != nil {
+		fail("read", err)
+		cleanup()
+		return
+	}
+	if err := timed("delete", cleanup); err != nil {
+		d.check(DoctorCheck{Name: DoctorCheckRoundTrip, Detail: strings.Join(steps, ", ")})
+		d.problem(DoctorWarning, DoctorCheckRoundTrip, fmt.Sprintf("could not remove the probe %s: %v", d.join(name), err),
+			"remove it by hand; "+operationErrorHint(err))
+		return
+	}
+	if !bytes.Equal(data, content) {
+		d.check(DoctorCheck{Name: DoctorCheckRoundTrip, Detail: strings.Join(steps, ", ")})
+		d.problem(DoctorCritical, DoctorCheckRoundTrip,
+			fmt.Sprintf("read back %d bytes that differ from the %d written", len(data), len(content)),
+			"the backend does not return what was stored; check for transforming middleware or a corrupting backend")
+		return
+	}
+
+	d.check(DoctorCheck{Name: DoctorCheckRoundTrip, OK: true, Detail: strings.Join(steps, ", "), LatencyMs: milliseconds(slowest)})
+	if slowest >= doctorSlowLatency {
+		d.problem(DoctorWarning, DoctorCheckRoundTrip,
+			fmt.Sprintf("slowest round trip step took %.0fms (%s)", milliseconds(slowest), strings.Join(steps, ", ")),
+			"the backend is slow; check its load and network latency from the server")
+	}
+}
+
+// checkLatency times repeated stats of the mount root
+func (d *mountDoctor) checkLatency(iterations int) {
+	var total, slowest time.Duration
+	for i := 0; i < iterations; i++ {
+		start := time.Now()
+		if _, err := d.mount.fs.Stat(d.mount.path); err != nil {
+			d.check(DoctorCheck{Name: DoctorCheckLatency, Detail: err.Error()})
+			d.problem(DoctorWarning, DoctorCheckLatency, "stat failed intermittently: "+err.Error(), operationErrorHint(err))
+			return
+		}
+		elapsed := time.Since(start)
+		total += elapsed
+		slowest = max(slowest, elapsed)
+	}
+	mean := total / time.Duration(iterations)
+	d.check(DoctorCheck{
+		Name:      DoctorCheckLatency,
+		OK:        true,
+		Detail:    fmt.Sprintf("%d stats: mean %.1fms, max %.1fms", iterations, milliseconds(mean), milliseconds(slowest)),
+		LatencyMs: milliseconds(mean),
+	})
+	if mean >= doctorSlowLatency {
+		d.problem(DoctorWarning, DoctorCheckLatency, fmt.Sprintf("stat takes %.0fms on average", milliseconds(mean)),
+			"the backend is slow; check its load and network latency from the server")
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// FormatDoctorReport renders a doctor report for a terminal: problems
+// first, most urgent first, then the checks of each mount
+func FormatDoctorReport(report DoctorResponse) string {
+	var sb strings.Builder
+	if len(report.Problems) == 0 {
+		fmt.Fprintf(&sb, "No problems found in %d mount(s).\n", len(report.Mounts))
+	} else {
+		fmt.Fprintf(&sb, "%d problem(s) found (status: %s):\n", len(report.Problems), report.Status)
+		for i, p := range report.Problems {
+			fmt.Fprintf(&sb, "%2d. [%s] %s (%s): %s\n", i+1, strings.ToUpper(p.Severity), p.Path, p.Check, p.Message)
+			if p.Hint != "" {
+				fmt.Fprintf(&sb, "    hint: %s\n", p.Hint)
+			}
+		}
+	}
+
+	sb.WriteString("\nChecks:\n")
+	for _, m := range report.Mounts {
+		fmt.Fprintf(&sb, "%s", m.Path)
+		if m.PluginName != "" {
+			fmt.Fprintf(&sb, " (%s)", m.PluginName)
+		}
+		sb.WriteString("\n")
+		for _, c := range m.Checks {
+			state := "ok"
+			switch {
+			case c.Skipped:
+				state = "skipped"
+			case !c.OK:
+				state = "FAILED"
+			}
+			fmt.Fprintf(&sb, "  %-13s %-7s", c.Name, state)
+			if c.LatencyMs > 0 {
+				fmt.Fprintf(&sb, " %8.1fms", c.LatencyMs)
+			}
+			if c.Detail != "" {
+				fmt.Fprintf(&sb, "  %s", c.Detail)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
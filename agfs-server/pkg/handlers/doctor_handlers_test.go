@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestDoctorReportsProblemsBySeverity(t *testing.T) {
+	root := mountablefs.NewMountableFS(api.PoolConfig{})
+	tracker := NewMountStatusTracker()
+	for _, mount := range []struct {
+		path   string
+		config map[string]interface{}
+	}{
+		{"/mem", map[string]interface{}{}},
+		{"/ro", map[string]interface{}{mountablefs.MiddlewareConfigKey: []interface{}{"readonly"}}},
+	} {
+		opts, rest, err := mountablefs.ParseMountOptions(mount.config)
+		if err != nil {
+			t.Fatalf("ParseMountOptions() error = %v", err)
+		}
+		p := memfs.NewMemFSPlugin()
+		p.Initialize(rest)
+		tracker.Track("memfs", "memfs", mount.path, mount.config)
+		if err := root.MountWithOptions(mount.path, p, opts); err != nil {
+			t.Fatalf("MountWithOptions() error = %v", err)
+		}
+		tracker.SetMounted(mount.path)
+	}
+	tracker.Track("s3fs", "s3fs", "/s3", nil)
+	tracker.SetFailed("/s3", errors.New("dial tcp 10.0.0.1:9000: connection refused"))
+	tracker.Track("sqlfs", "sqlfs", "/db", nil)
+
+	h := NewHandler(root, nil)
+	h.SetMountStatusTracker(tracker)
+	doctor := func(query string) (int, DoctorResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.Doctor(rec, httptest.NewRequest(http.MethodGet, "/api/v1/doctor?"+query, nil))
+		var resp DoctorResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	code, report := doctor("iterations=2")
+	if code != http.StatusOK {
+		t.Fatalf("doctor status = %d", code)
+	}
+	if report.Status != DoctorCritical {
+		t.Errorf("status = %q, want critical", report.Status)
+	}
+	var got []string
+	for _, p := range report.Problems {
+		got = append(got, p.Severity+" "+p.Path+" "+p.Check)
+	}
+	want := []string{"critical /s3 mount", "warning /db mount", "info /ro round_trip"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("problems = %v, want %v", got, want)
+	}
+	if hint := report.Problems[0].Hint; !strings.Contains(hint, "reachable") {
+		t.Errorf("hint = %q, want a reachability hint", hint)
+	}
+
+	checks := make(map[string]string)
+	for _, m := range report.Mounts {
+		for _, c := range m.Checks {
+			state := "ok"
+			if c.Skipped {
+				state = "skipped"
+			} else if !c.OK {
+				state = "failed"
+			}
+			checks[m.Path+" "+c.Name] = state
+		}
+	}
+	for key, state := range map[string]string{
+		"/mem mount": "ok", "/mem connectivity": "ok", "/mem round_trip": "ok", "/mem latency": "ok",
+		"/ro round_trip": "skipped", "/s3 mount": "failed",
+	} {
+		if checks[key] != state {
+			t.Errorf("check %s = %q, want %q", key, checks[key], state)
+		}
+	}
+
+	// The round trip cleans up after itself
+	entries, err := root.ReadDir("/mem")
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name, doctorProbePrefix) {
+			t.Errorf("probe %s left behind", e.Name)
+		}
+	}
+
+	code, report = doctor("path=/mem&probe=false")
+	if code != http.StatusOK || report.Status != "ok" || len(report.Mounts) != 1 || len(report.Problems) != 0 {
+		t.Errorf("doctor(/mem) = %d %+v", code, report)
+	}
+	if text := FormatDoctorReport(report); !strings.Contains(text, "No problems found in 1 mount(s).") || !strings.Contains(text, "round_trip    skipped") {
+		t.Errorf("FormatDoctorReport() = %q", text)
+	}
+
+	if code, _ := doctor("path=/nowhere"); code != http.StatusNotFound {
+		t.Errorf("doctor(/nowhere) = %d, want 404", code)
+	}
+	if code, _ := doctor("iterations=0"); code != http.StatusBadRequest {
+		t.Errorf("doctor(iterations=0) = %d, want 400", code)
+	}
+}
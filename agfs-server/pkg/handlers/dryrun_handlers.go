@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// DryRunParam previews a mutating request instead of executing it
+const DryRunParam = "dry_run"
+
+// readOnlyPostEndpoints take a POST body but change nothing, so they ignore
+// dry_run like GET requests do
+var readOnlyPostEndpoints = map[string]bool{
+	"/api/v1/grep":   true,
+	"/api/v1/digest": true,
+	"/api/v1/fsql":   true,
+}
+
+// DryRunMiddleware answers mutating requests sent with ?dry_run=true with a
+// preview of what they would do, without executing them. Reads ignore the
+// flag; mutations that cannot be previewed are rejected rather than run.
+// It must wrap HomesMiddleware so that home denials become verdicts.
+func (h *Handler) DryRunMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get(DryRunParam) != "true" || r.Method == http.MethodGet || r.Method == http.MethodHead || readOnlyPostEndpoints[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		op, ok, err := h.dryRunOp(w, r)
+		if errors.Is(err, filesystem.ErrNotFound) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			writeRequestBodyError(w, err, h.maxRequestBodyBytes, err.Error())
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusBadRequest, "dry_run is not supported for "+r.Method+" "+r.URL.Path)
+			return
+		}
+
+		// The caller's access is decided before the preview is computed: a
+		// denied caller gets the verdicts but no effects, which would list
+		// what is under the path
+		if denied := h.authorizationVerdicts(r, op); denied != nil {
+			writeJSON(w, http.StatusOK, denied)
+			return
+		}
+		result, err := filesystem.DryRun(h.fs, op)
+		if err != nil {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return
+		}
+		if s := h.dryRunUpload(r); s != nil {
+			h.uploadVerdict(r, s, result)
+		} else {
+			h.residencyVerdict(r, op, result)
+			h.approvalVerdict(op, result)
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+}
+
+// dryRunOp describes the operation r would perform. It returns false if r
+// is not an operation that can be previewed.
+func (h *Handler) dryRunOp(w http.ResponseWriter, r *http.Request) (filesystem.DryRunOp, bool, error) {
+	if strings.HasPrefix(r.URL.Path, "/api/v1/uploads/") {
+		return h.dryRunUploadOp(w, r)
+	}
+	query := r.URL.Query()
+	op := filesystem.DryRunOp{Path: query.Get("path"), Offset: -1}
+	if op.Path == "" {
+		return op, false, filesystem.NewInvalidArgumentError("path", "", "path parameter is required")
+	}
+
+	switch {
+	case r.URL.Path == "/api/v1/files" && r.Method == http.MethodPut,
+		r.URL.Path == "/api/v1/write" && r.Method == http.MethodPost:
+		op.Op = filesystem.DryRunWrite
+		flags, err := parseWriteFlags(query.Get("flags"))
+		if err != nil {
+			return op, false, err
+		}
+		op.Flags = flags
+		if offsetStr := query.Get("offset"); offsetStr != "" {
+			offset, err := strconv.ParseInt(offsetStr, 10, 64)
+			if err != nil {
+				return op, false, filesystem.NewInvalidArgumentError("offset", offsetStr, "must be an integer")
+			}
+			op.Offset = offset
+		}
+		if op.Size, err = h.bodySize(w, r); err != nil {
+			return op, false, err
+		}
+
+	case r.URL.Path == "/api/v1/files" && r.Method == http.MethodPost:
+		op.Op = filesystem.DryRunCreate
+
+	case r.URL.Path == "/api/v1/counter" && r.Method == http.MethodPost:
+		op.Op = filesystem.DryRunWrite
+		op.Flags = filesystem.WriteFlagCreate | filesystem.WriteFlagTruncate
+
+	case r.URL.Path == "/api/v1/log" && r.Method == http.MethodPost:
+		op.Op = filesystem.DryRunWrite
+		op.Flags = filesystem.WriteFlagCreate | filesystem.WriteFlagAppend
+		var err error
+		if op.Size, err = h.bodySize(w, r); err != nil {
+			return op, false, err
+		}
+
+	// Setting a key creates its shard directory, which a write preview
+	// cannot express, so only deletes are previewed
+	case r.URL.Path == "/api/v1/kv" && r.Method == http.MethodDelete:
+		filePath, err := kvFilePath(op.Path, query.Get("key"))
+		if err != nil {
+			return op, false, err
+		}
+		op.Op = filesystem.DryRunRemove
+		op.Path = filePath
+
+	case (r.URL.Path == "/api/v1/files" || r.URL.Path == "/api/v1/directories") && r.Method == http.MethodDelete:
+		op.Op = filesystem.DryRunRemove
+		op.Recursive = query.Get("recursive") == "true"
+
+	case r.URL.Path == "/api/v1/directories" && r.Method == http.MethodPost:
+		op.Op = filesystem.DryRunMkdir
+
+	case r.URL.Path == "/api/v1/rename" && r.Method == http.MethodPost:
+		op.Op = filesystem.DryRunRename
+		var req RenameRequest
+		if err := decodeLimitedJSON(w, r, h.maxRequestBodyBytes, &req); err != nil {
+			return op, false, err
+		}
+		op.Target = req.NewPath
+
+	case r.URL.Path == "/api/v1/chmod" && r.Method == http.MethodPost:
+		op.Op = filesystem.DryRunChmod
+		var req ChmodRequest
+		if err := decodeLimitedJSON(w, r, h.maxRequestBodyBytes, &req); err != nil {
+			return op, false, err
+		}
+		op.Mode = req.Mode
+
+	case r.URL.Path == "/api/v1/truncate" && r.Method == http.MethodPost:
+		op.Op = filesystem.DryRunTruncate
+		size, err := strconv.ParseInt(query.Get("size"), 10, 64)
+		if err != nil || size < 0 {
+			return op, false, filesystem.NewInvalidArgumentError("size", query.Get("size"), "must be a non-negative integer")
+		}
+		op.Size = size
+
+	case r.URL.Path == "/api/v1/touch" && r.Method == http.MethodPost:
+		op.Op = filesystem.DryRunTouch
+
+	case r.URL.Path == "/api/v1/symlink" && r.Method == http.MethodPost:
+		op.Op = filesystem.DryRunSymlink
+		var req SymlinkRequest
+		if err := decodeLimitedJSON(w, r, h.maxRequestBodyBytes, &req); err != nil {
+			return op, false, err
+		}
+		op.Target = req.Target
+
+	default:
+		return op, false, nil
+	}
+
+	if (op.Op == filesystem.DryRunRename || op.Op == filesystem.DryRunSymlink) && op.Target == "" {
+		return op, false, filesystem.NewInvalidArgumentError("target", "", "a target path is required")
+	}
+	return op, true, nil
+}
+
+// dryRunUploadOp describes the write of a part of an upload, or of the
+// file its commit would create, as a write of the upload's path
+func (h *Handler) dryRunUploadOp(w http.ResponseWriter, r *http.Request) (filesystem.DryRunOp, bool, error) {
+	id, operation, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/uploads/"), "/")
+	var op filesystem.DryRunOp
+	switch {
+	case operation == "" && r.Method == http.MethodPut,
+		operation == "commit" && r.Method == http.MethodPost:
+	default:
+		return op, false, nil
+	}
+	s := h.uploads.get(id, IdentityFromContext(r.Context()))
+	if s == nil {
+		return op, false, filesystem.NewNotFoundError("upload", id)
+	}
+	op = filesystem.DryRunOp{Op: filesystem.DryRunWrite, Path: s.path, Offset: -1, Flags: filesystem.WriteFlagCreate | filesystem.WriteFlagTruncate}
+	if operation == "commit" {
+		size, err := s.size()
+		if err != nil {
+			return op, false, filesystem.NewInvalidArgumentError("parts", id, err.Error())
+		}
+		op.Size = size
+		return op, true, nil
+	}
+
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 || offset%s.partSize != 0 {
+		return op, false, filesystem.NewInvalidArgumentError("offset", r.URL.Query().Get("offset"),
+			fmt.Sprintf("must be a non-negative multiple of the part size %d", s.partSize))
+	}
+	op.Offset, op.Flags = offset, filesystem.WriteFlagCreate
+	if op.Size, err = h.bodySize(w, r); err != nil {
+		return op, false, err
+	}
+	return op, true, nil
+}
+
+// dryRunUpload returns the upload r previews a part or commit of, if any
+func (h *Handler) dryRunUpload(r *http.Request) *uploadSession {
+	if !strings.HasPrefix(r.URL.Path, "/api/v1/uploads/") {
+		return nil
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/uploads/"), "/")
+	return h.uploads.get(id, IdentityFromContext(r.Context()))
+}
+
+// uploadVerdict adds the decisions authorizeUpload takes beyond those on
+// the caller's access: uploads are never held for approval, and are checked
+// for residency with the region they were opened with
+func (h *Handler) uploadVerdict(r *http.Request, s *uploadSession, result *filesystem.DryRunResult) {
+	if policy, err := h.uploadDenied(s); err != nil {
+		result.Deny(policy, err.Error())
+	}
+	if h.residency != nil {
+		if err := h.residency.Preview(h.uploadResidencyWrite(r, s)); err != nil {
+			result.Deny("residency", err.Error())
+		}
+	}
+}
+
+// bodySize returns the number of bytes a write request would write,
+// reading the body if its length is not declared
+func (h *Handler) bodySize(w http.ResponseWriter, r *http.Request) (int64, error) {
+	if r.URL.Path == "/api/v1/write" && strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		var req struct {
+			Data string `json:"data"`
+		}
+		if err := decodeLimitedJSON(w, r, h.maxRequestBodyBytes, &req); err != nil {
+			return 0, err
+		}
+		return int64(len(req.Data)), nil
+	}
+	if r.ContentLength >= 0 {
+		return r.ContentLength, nil
+	}
+	return io.Copy(io.Discard, http.MaxBytesReader(w, r.Body, h.maxRequestBodyBytes))
+}
+
+// authorizationVerdicts checks the caller's access to the paths of op, as
+// the middlewares executing it would. It returns a result without effects
+// carrying the verdicts if access is denied, or nil.
+func (h *Handler) authorizationVerdicts(r *http.Request, op filesystem.DryRunOp) *filesystem.DryRunResult {
+	result := &filesystem.DryRunResult{Op: op.Op, Path: filesystem.NormalizePath(op.Path), Allowed: true, Effects: []filesystem.DryRunEffect{}}
+	h.homesVerdict(r, op, result)
+	h.aclVerdict(r, op, result)
+	h.tenantVerdict(r, op, result)
+	if result.Allowed {
+		return nil
+	}
+	return result
+}
+
+// dryRunPath is a path the caller's access to a previewed operation is
+// decided on
+type dryRunPath struct {
+	path      string
+	recursive bool
+}
+
+// dryRunPaths returns the paths of op as authorizePath checks them: its
+// path and target, symlink targets relative to the link made absolute, and
+// the paths symbolic links resolve them to
+func (h *Handler) dryRunPaths(op filesystem.DryRunOp) []dryRunPath {
+	var paths []dryRunPath
+	for _, p := range h.authorizedPaths(op.Path) {
+		paths = append(paths, dryRunPath{p, op.Recursive})
+	}
+	if op.Op != filesystem.DryRunRename && op.Op != filesystem.DryRunSymlink {
+		return paths
+	}
+	target := op.Target
+	if op.Op == filesystem.DryRunSymlink {
+		target = symlinkTarget(op.Path, op.Target)
+	}
+	for _, p := range h.authorizedPaths(target) {
+		paths = append(paths, dryRunPath{p, false})
+	}
+	return paths
+}
+
+// homesVerdict adds the decision of the caller's home confinement
+func (h *Handler) homesVerdict(r *http.Request, op filesystem.DryRunOp, result *filesystem.DryRunResult) {
+	if h.homes == nil {
+		return
+	}
+	identity := IdentityFromContext(r.Context())
+	for _, p := range h.dryRunPaths(op) {
+		if err := h.homes.Authorize(identity, p.path, p.recursive); err != nil {
+			result.Deny("homes", err.Error())
+			return
+		}
+	}
+}
+
+// aclVerdict adds the decision of the access control list
+func (h *Handler) aclVerdict(r *http.Request, op filesystem.DryRunOp, result *filesystem.DryRunResult) {
+	if h.acl == nil {
+		return
+	}
+	identity := IdentityFromContext(r.Context())
+	for _, p := range h.dryRunPaths(op) {
+		if err := h.acl.Check(identity, p.path, h.aclPermission(r, p.path), p.recursive); err != nil {
+			result.Deny("acl", err.Error())
+			return
+		}
+	}
+}
+
+// tenantVerdict adds the decision of the tenant scoping of the mounts
+func (h *Handler) tenantVerdict(r *http.Request, op filesystem.DryRunOp, result *filesystem.DryRunResult) {
+	scoper, ok := filesystem.As[filesystem.TenantScoper](h.fs)
+	if !ok {
+		return
+	}
+	identity := IdentityFromContext(r.Context())
+	for _, p := range h.dryRunPaths(op) {
+		if err := scoper.AuthorizeTenant(identity, p.path, p.recursive); err != nil {
+			result.Deny("tenant", err.Error())
+			return
+		}
+	}
+}
+
+// residencyVerdict adds the decision of the data residency rules, for the
+// path op stores data at as ResidencyMiddleware sees it. Previews are not
+// recorded as violations.
+func (h *Handler) residencyVerdict(r *http.Request, op filesystem.DryRunOp, result *filesystem.DryRunResult) {
+	if h.residency == nil || op.Op == filesystem.DryRunRemove {
+		return
+	}
+	p := op.Path
+	if op.Op == filesystem.DryRunRename {
+		p = op.Target
+	}
+	if err := h.residency.Preview(h.residencyWrite(r, p)); err != nil {
+		result.Deny("residency", err.Error())
+	}
+}
+
+// approvalVerdict notes that the operation would be held for approval
+func (h *Handler) approvalVerdict(op filesystem.DryRunOp, result *filesystem.DryRunResult) {
+	if h.approvals == nil {
+		return
+	}
+	if h.approvals.Protected(op.Path) || (op.Target != "" && h.approvals.Protected(op.Target)) {
+		result.Allow("approval", "the operation touches a protected path and is held for approval before it is applied")
+	}
+}
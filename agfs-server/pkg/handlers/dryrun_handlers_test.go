@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/acl"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/homes"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestDryRunMiddleware(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	fs.Mkdir("/proj", 0755)
+	fs.Write("/proj/a.txt", []byte("hello"), -1, filesystem.WriteFlagCreate)
+	h := NewHandler(fs, nil)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := h.DryRunMiddleware(mux)
+
+	do := func(method, target, body string) (int, filesystem.DryRunResult) {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		var result filesystem.DryRunResult
+		if rec.Code == http.StatusOK {
+			json.Unmarshal(rec.Body.Bytes(), &result)
+		}
+		return rec.Code, result
+	}
+
+	code, result := do(http.MethodDelete, "/api/v1/files?path=/proj&recursive=true&dry_run=true", "")
+	if code != http.StatusOK || !result.Allowed || result.Paths != 2 || result.Bytes != 5 {
+		t.Errorf("dry run delete: status %d, result %+v", code, result)
+	}
+	if _, err := fs.Stat("/proj/a.txt"); err != nil {
+		t.Fatalf("dry run deleted the file: %v", err)
+	}
+
+	code, result = do(http.MethodPut, "/api/v1/files?path=/proj/b.txt&dry_run=true", "12345678")
+	if code != http.StatusOK || len(result.Effects) != 1 || result.Effects[0].Action != "create" || result.Bytes != 8 {
+		t.Errorf("dry run write: status %d, result %+v", code, result)
+	}
+	if _, err := fs.Stat("/proj/b.txt"); err == nil {
+		t.Error("dry run created the file")
+	}
+
+	code, result = do(http.MethodPost, "/api/v1/rename?path=/proj/missing&dry_run=true", `{"newPath":"/proj/c"}`)
+	if code != http.StatusOK || result.Allowed {
+		t.Errorf("dry run rename of a missing file: status %d, result %+v", code, result)
+	}
+
+	if code, _ := do(http.MethodPost, "/api/v1/batch?dry_run=true", `{"ops":[{"op":"remove","path":"/proj/a.txt"}]}`); code != http.StatusBadRequest {
+		t.Errorf("dry run batch: status %d, want 400", code)
+	}
+	if _, err := fs.Stat("/proj/a.txt"); err != nil {
+		t.Fatalf("unsupported dry run executed: %v", err)
+	}
+
+	// Reads ignore the flag
+	if code, _ := do(http.MethodGet, "/api/v1/stat?path=/proj/a.txt&dry_run=true", ""); code != http.StatusOK {
+		t.Errorf("stat with dry_run: status %d", code)
+	}
+}
+
+func TestDryRunHomesVerdicts(t *testing.T) {
+	root := mountablefs.NewMountableFS(api.PoolConfig{})
+	store := memfs.NewMemFSPlugin()
+	store.Initialize(map[string]interface{}{})
+	root.Mount("/store", store)
+	manager, err := homes.NewManager(root, homes.Config{Backend: "/store", Quota: 10})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	root.Mount(manager.Path(), manager.Plugin())
+	manager.Provision("alice")
+	h := NewHandler(root, nil)
+	h.SetHomes(manager)
+	dryRun := h.DryRunMiddleware(http.NotFoundHandler())
+
+	check := func(target, body string, allowed bool, policy string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, target, strings.NewReader(body))
+		req = req.WithContext(WithIdentity(req.Context(), "alice"))
+		rec := httptest.NewRecorder()
+		dryRun.ServeHTTP(rec, req)
+		var result filesystem.DryRunResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		if rec.Code != http.StatusOK || result.Allowed != allowed {
+			t.Errorf("%s: status %d, result %+v, want allowed=%v", target, rec.Code, result, allowed)
+			return
+		}
+		if n := len(result.Verdicts); n == 0 || result.Verdicts[n-1].Policy != policy {
+			t.Errorf("%s: verdicts %+v, want %s last", target, result.Verdicts, policy)
+		}
+	}
+	check("/api/v1/files?path=/home/bob/x&dry_run=true", "hi", false, "homes")
+	check("/api/v1/files?path=/home/alice/x&dry_run=true", "12345678901", false, "quota")
+	check("/api/v1/files?path=/home/alice/x&dry_run=true", "123", true, "quota")
+}
+
+func TestDryRunDeniedWithholdsEffects(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	fs.Mkdir("/private", 0755)
+	fs.Write("/private/secret.txt", []byte("hello"), -1, filesystem.WriteFlagCreate)
+	h := NewHandler(fs, nil)
+	policy, err := acl.NewPolicy(fs, acl.Config{Rules: []acl.Rule{{Identity: "admin", Path: "/", Permission: acl.Admin}}})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	h.SetACL(policy)
+	dryRun := h.DryRunMiddleware(http.NotFoundHandler())
+
+	for identity, allowed := range map[string]bool{"mallory": false, "admin": true} {
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/files?path=/private&recursive=true&dry_run=true", nil)
+		req = req.WithContext(WithIdentity(req.Context(), identity))
+		rec := httptest.NewRecorder()
+		dryRun.ServeHTTP(rec, req)
+		var result filesystem.DryRunResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		if rec.Code != http.StatusOK || result.Allowed != allowed {
+			t.Errorf("%s: status %d, result %+v, want allowed=%v", identity, rec.Code, result, allowed)
+		}
+		if !allowed && (len(result.Effects) != 0 || result.Paths != 0 || result.Bytes != 0 || strings.Contains(rec.Body.String(), "secret")) {
+			t.Errorf("%s: a denied dry run disclosed %s", identity, rec.Body.String())
+		}
+		if allowed && result.Paths != 2 {
+			t.Errorf("%s: result %+v, want 2 paths", identity, result)
+		}
+	}
+}
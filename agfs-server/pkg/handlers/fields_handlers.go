@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// fileInfoFields are the fields of FileInfoResponse, by JSON name, that
+// ?fields= selects from
+var fileInfoFields = []string{"name", "size", "mode", "modTime", "isDir", "meta", "checksum", "expires_at", "preview"}
+
+// nameFields are the fields of the entries filesystem.NameLister returns
+var nameFields = []string{"name", "isDir"}
+
+// SelectedListResponse is a directory listing with the fields selected by
+// ?fields= or names_only=true
+type SelectedListResponse struct {
+	Files []map[string]interface{} `json:"files"`
+}
+
+// parseFields returns the fields a ReadDir or Stat request selects with
+// ?fields=name,size (repeated or comma-separated), or nil for all of them.
+// names_only=true selects the name and isDir of each entry.
+func parseFields(r *http.Request) ([]string, error) {
+	q := r.URL.Query()
+	if q.Get("names_only") == "true" {
+		return nameFields, nil
+	}
+	fields := splitListParam(q["fields"])
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	for _, field := range fields {
+		known := false
+		for _, f := range fileInfoFields {
+			known = known || f == field
+		}
+		if !known {
+			return nil, filesystem.NewInvalidArgumentError("fields", field,
+				fmt.Sprintf("unknown field, expected %s", strings.Join(fileInfoFields, ", ")))
+		}
+	}
+	return fields, nil
+}
+
+// onlyNames reports whether selected fields are all served by a
+// filesystem.NameLister
+func onlyNames(fields []string) bool {
+	if len(fields) == 0 {
+		return false
+	}
+	for _, field := range fields {
+		if field != "name" && field != "isDir" {
+			return false
+		}
+	}
+	return true
+}
+
+// readDirFields lists a directory for a request selecting fields, from the
+// cheaper name listing when the fields allow it
+func (h *Handler) readDirFields(r *http.Request, path string, fields []string) ([]filesystem.FileInfo, error) {
+	if lister, ok := h.fs.(filesystem.NameLister); ok && onlyNames(fields) {
+		return lister.ReadDirNames(path)
+	}
+	return h.readDir(r, path)
+}
+
+// selectFields returns the selected fields of an entry. The checksum,
+// expires_at and preview fields are left out when empty, as in
+// FileInfoResponse.
+func selectFields(info FileInfoResponse, fields []string) map[string]interface{} {
+	selected := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "name":
+			selected[field] = info.Name
+		case "size":
+			selected[field] = info.Size
+		case "mode":
+			selected[field] = info.Mode
+		case "modTime":
+			selected[field] = info.ModTime
+		case "isDir":
+			selected[field] = info.IsDir
+		case "meta":
+			selected[field] = info.Meta
+		case "checksum":
+			if info.Checksum != "" {
+				selected[field] = info.Checksum
+			}
+		case "expires_at":
+			if info.ExpiresAt != nil {
+				selected[field] = info.ExpiresAt
+			}
+		case "preview":
+			if info.Preview != nil {
+				selected[field] = info.Preview
+			}
+		}
+	}
+	return selected
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// nameListingFS counts the full and name-only listings made
+type nameListingFS struct {
+	filesystem.FileSystem
+	readDirs, nameListings int
+}
+
+func (fs *nameListingFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	fs.readDirs++
+	return fs.FileSystem.ReadDir(path)
+}
+
+func (fs *nameListingFS) ReadDirNames(path string) ([]filesystem.FileInfo, error) {
+	fs.nameListings++
+	infos, err := fs.FileSystem.ReadDir(path)
+	for i, info := range infos {
+		infos[i] = filesystem.FileInfo{Name: info.Name, IsDir: info.IsDir}
+	}
+	return infos, err
+}
+
+func TestFieldSelection(t *testing.T) {
+	fs := &nameListingFS{FileSystem: newWalkTestFS(t)}
+	h := NewHandler(fs, nil)
+	get := func(handler http.HandlerFunc, query string) (int, []byte) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/x?"+query, nil))
+		return rec.Code, rec.Body.Bytes()
+	}
+
+	// names_only is served by the name listing
+	code, body := get(h.ListDirectory, "path=/a&names_only=true")
+	var list struct {
+		Files []map[string]interface{} `json:"files"`
+	}
+	json.Unmarshal(body, &list)
+	if code != http.StatusOK || len(list.Files) != 2 || fs.nameListings != 1 || fs.readDirs != 0 {
+		t.Fatalf("names_only listing = %d %s (%d name listings, %d full)", code, body, fs.nameListings, fs.readDirs)
+	}
+	for _, f := range list.Files {
+		if len(f) != 2 || f["name"] == nil || f["isDir"] != (f["name"] == "b") {
+			t.Errorf("names_only entry = %v, want name and isDir", f)
+		}
+	}
+
+	// Other fields need the full listing
+	code, body = get(h.ListDirectory, "path=/a&fields=name,size")
+	list.Files = nil
+	json.Unmarshal(body, &list)
+	if code != http.StatusOK || fs.readDirs != 1 || len(list.Files) != 2 {
+		t.Fatalf("fields listing = %d %s", code, body)
+	}
+	for _, f := range list.Files {
+		if _, ok := f["size"]; len(f) != 2 || !ok {
+			t.Errorf("fields=name,size entry = %v", f)
+		}
+	}
+	if code, _ := get(h.ListDirectory, "path=/a&fields=name"); code != http.StatusOK || fs.nameListings != 2 {
+		t.Errorf("fields=name did not use the name listing")
+	}
+
+	code, body = get(h.Stat, "path=/a/x.txt&fields=size&fields=isDir")
+	var stat map[string]interface{}
+	json.Unmarshal(body, &stat)
+	if code != http.StatusOK || len(stat) != 2 || stat["size"] != float64(4) || stat["isDir"] != false {
+		t.Errorf("stat with fields = %d %s", code, body)
+	}
+
+	if code, _ := get(h.ListDirectory, "path=/a&fields=name,owner"); code != http.StatusBadRequest {
+		t.Errorf("unknown field: status %d, want 400", code)
+	}
+
+	// Without a selection, responses are unchanged
+	code, body = get(h.ListDirectory, "path=/a")
+	var full ListResponse
+	json.Unmarshal(body, &full)
+	if code != http.StatusOK || len(full.Files) != 2 || full.Files[0].ModTime == "" {
+		t.Errorf("plain listing = %d %s", code, body)
+	}
+}
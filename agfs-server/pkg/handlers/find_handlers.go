@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+const (
+	defaultFindLimit = 1000
+	maxFindLimit     = 10000
+)
+
+// FindResponse represents the result of a find request
+type FindResponse struct {
+	Path      string              `json:"path"`
+	Matches   []WalkEntryResponse `json:"matches"`
+	Count     int                 `json:"count"`
+	Truncated bool                `json:"truncated"` // More matches exist beyond limit
+}
+
+// parseFindMTime parses a find(1) style age filter: "-1h" means modified
+// within the last hour, "+7d" means modified more than 7 days ago.
+// Durations use time.ParseDuration units plus "d" for days.
+func parseFindMTime(value string) (within, before time.Duration, err error) {
+	if len(value) < 2 || (value[0] != '-' && value[0] != '+') {
+		return 0, 0, filesystem.NewInvalidArgumentError("mtime", value, "must be -<duration> or +<duration>, e.g. -1h or +7d")
+	}
+
+	durStr := value[1:]
+	var d time.Duration
+	if days, ok := strings.CutSuffix(durStr, "d"); ok {
+		n, convErr := strconv.ParseFloat(days, 64)
+		if convErr != nil {
+			return 0, 0, filesystem.NewInvalidArgumentError("mtime", value, "invalid day count")
+		}
+		d = time.Duration(n * float64(24*time.Hour))
+	} else if d, err = time.ParseDuration(durStr); err != nil {
+		return 0, 0, filesystem.NewInvalidArgumentError("mtime", value, err.Error())
+	}
+	if d <= 0 {
+		return 0, 0, filesystem.NewInvalidArgumentError("mtime", value, "duration must be positive")
+	}
+
+	if value[0] == '-' {
+		return d, 0, nil
+	}
+	return 0, d, nil
+}
+
+// parseFindOptions parses find query parameters
+func parseFindOptions(r *http.Request) (filesystem.FindOptions, error) {
+	q := r.URL.Query()
+	opts := filesystem.FindOptions{
+		Name:  q.Get("name"),
+		Type:  q.Get("type"),
+		Limit: defaultFindLimit,
+	}
+
+	if mtime := q.Get("mtime"); mtime != "" {
+		within, before, err := parseFindMTime(mtime)
+		if err != nil {
+			return opts, err
+		}
+		opts.ModifiedWithin, opts.ModifiedBefore = within, before
+	}
+
+	if depthStr := q.Get("max_depth"); depthStr != "" {
+		depth, err := strconv.Atoi(depthStr)
+		if err != nil {
+			return opts, filesystem.NewInvalidArgumentError("max_depth", depthStr, "must be an integer")
+		}
+		opts.MaxDepth = depth
+	}
+
+	if limitStr := q.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxFindLimit {
+			return opts, filesystem.NewInvalidArgumentError("limit", limitStr, "must be between 1 and "+strconv.Itoa(maxFindLimit))
+		}
+		opts.Limit = limit
+	}
+
+	return opts, filesystem.ValidateFindOptions(opts)
+}
+
+// Find handles GET /find?path=<path>&name=<glob>&type=<f|d>&mtime=<-1h|+7d>&max_depth=<n>&limit=<n>
+func (h *Handler) Find(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = "/"
+	}
+
+	opts, err := parseFindOptions(r)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	info, err := h.fs.Stat(path)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	if !info.IsDir {
+		writeError(w, http.StatusBadRequest, filesystem.NewNotDirectoryError(path).Error())
+		return
+	}
+
+	response := FindResponse{Path: path, Matches: []WalkEntryResponse{}}
+	response.Truncated, err = filesystem.Find(h.fs, path, opts, func(entry filesystem.WalkEntry) error {
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		response.Matches = append(response.Matches, WalkEntryResponse{
+			Path:             entry.Path,
+			Depth:            entry.Depth,
+			FileInfoResponse: fileInfoResponse(entry.Info),
+		})
+		return nil
+	})
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	response.Count = len(response.Matches)
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+func doFind(t *testing.T, h *Handler, query string) (*httptest.ResponseRecorder, FindResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.Find(rec, httptest.NewRequest(http.MethodGet, "/api/v1/find?"+query, nil))
+	var resp FindResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+		}
+	}
+	return rec, resp
+}
+
+func findPaths(resp FindResponse) []string {
+	var paths []string
+	for _, m := range resp.Matches {
+		paths = append(paths, m.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestFindFiltersByNameAndType(t *testing.T) {
+	h := NewHandler(newWalkTestFS(t), nil)
+
+	rec, resp := doFind(t, h, "path=/a&name=*.txt&type=f")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	got := findPaths(resp)
+	want := []string{"/a/b/c/z.txt", "/a/x.txt"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if resp.Count != 2 || resp.Truncated {
+		t.Errorf("count=%d truncated=%v, want 2 false", resp.Count, resp.Truncated)
+	}
+
+	_, resp = doFind(t, h, "path=/a&type=d")
+	if got := findPaths(resp); len(got) != 2 || got[0] != "/a/b" || got[1] != "/a/b/c" {
+		t.Errorf("type=d got %v", got)
+	}
+
+	_, resp = doFind(t, h, "path=/a&type=f&max_depth=1")
+	if got := findPaths(resp); len(got) != 1 || got[0] != "/a/x.txt" {
+		t.Errorf("max_depth=1 got %v", got)
+	}
+}
+
+func TestFindMTimeAndLimit(t *testing.T) {
+	h := NewHandler(newWalkTestFS(t), nil)
+
+	// Everything was just written
+	if _, resp := doFind(t, h, "path=/&type=f&mtime=-1h"); resp.Count != 3 {
+		t.Errorf("mtime=-1h matched %d files, want 3", resp.Count)
+	}
+	if _, resp := doFind(t, h, "path=/&type=f&mtime=%2B1d"); resp.Count != 0 {
+		t.Errorf("mtime=+1d matched %d files, want 0", resp.Count)
+	}
+
+	_, resp := doFind(t, h, "path=/&type=f&limit=2")
+	if resp.Count != 2 || !resp.Truncated {
+		t.Errorf("limit=2: count=%d truncated=%v, want 2 true", resp.Count, resp.Truncated)
+	}
+}
+
+func TestFindRejectsBadParameters(t *testing.T) {
+	h := NewHandler(newWalkTestFS(t), nil)
+
+	for _, query := range []string{"path=/a&type=x", "path=/a&mtime=1h", "path=/a&mtime=-xd", "path=/a&name=[", "path=/a&limit=0", "path=/a/x.txt"} {
+		if rec, _ := doFind(t, h, query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d: %s", query, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestParseFindMTime(t *testing.T) {
+	within, before, err := parseFindMTime("-90m")
+	if err != nil || within != 90*time.Minute || before != 0 {
+		t.Errorf("-90m = %v %v %v", within, before, err)
+	}
+	within, before, err = parseFindMTime("+1.5d")
+	if err != nil || within != 0 || before != 36*time.Hour {
+		t.Errorf("+1.5d = %v %v %v", within, before, err)
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/fsql"
+)
+
+// FSQLRequest represents a SQL query across mounts
+type FSQLRequest struct {
+	Query   string            `json:"query"`              // A single SELECT statement
+	Tables  map[string]string `json:"tables"`             // Relation name -> file, directory or table path
+	MaxRows int               `json:"max_rows,omitempty"` // Result row limit (capped at fsql.DefaultMaxRows)
+}
+
+// FSQLResponse represents the result of an fsql query
+type FSQLResponse struct {
+	Columns   []string        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	RowCount  int             `json:"row_count"`
+	Truncated bool            `json:"truncated,omitempty"` // More rows matched than max_rows
+	Duration  string          `json:"duration"`
+}
+
+// FSQL handles POST /fsql
+// Structured files, directories of them and database tables are loaded as
+// relations and the query runs over them in an embedded SQL engine.
+func (h *Handler) FSQL(w http.ResponseWriter, r *http.Request) {
+	var req FSQLRequest
+	if err := decodeLimitedJSON(w, r, h.maxRequestBodyBytes, &req); err != nil {
+		writeRequestBodyError(w, err, h.maxRequestBodyBytes, "invalid request body")
+		return
+	}
+
+	for _, path := range req.Tables {
+		if !h.authorizePath(w, r, path, false) {
+			return
+		}
+	}
+
+	start := time.Now()
+	result, err := fsql.NewEngine(h.fs).Query(r.Context(), fsql.Request{
+		Query:   req.Query,
+		Tables:  req.Tables,
+		MaxRows: req.MaxRows,
+	})
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, FSQLResponse{
+		Columns:   result.Columns,
+		Rows:      result.Rows,
+		RowCount:  len(result.Rows),
+		Truncated: result.Truncated,
+		Duration:  time.Since(start).String(),
+	})
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func doFSQL(t *testing.T, h *Handler, req FSQLRequest) (int, FSQLResponse, string) {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	rec := httptest.NewRecorder()
+	h.FSQL(rec, httptest.NewRequest(http.MethodPost, "/api/v1/fsql", bytes.NewReader(body)))
+
+	var resp FSQLResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec.Code, resp, rec.Body.String()
+}
+
+func TestFSQLJoinsFiles(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	h := NewHandler(fs, nil)
+	rec := httptest.NewRecorder()
+	h.WriteFile(rec, httptest.NewRequest(http.MethodPut, "/api/v1/files?path=/users.csv", strings.NewReader("id,name\n1,Alice\n2,Bob\n")))
+	rec = httptest.NewRecorder()
+	h.WriteFile(rec, httptest.NewRequest(http.MethodPut, "/api/v1/files?path=/orders.ndjson", strings.NewReader("{\"user_id\": 2, \"item\": \"book\"}\n")))
+
+	code, resp, body := doFSQL(t, h, FSQLRequest{
+		Query:  "SELECT u.name, o.item FROM users u JOIN orders o ON o.user_id = u.id",
+		Tables: map[string]string{"users": "/users.csv", "orders": "/orders.ndjson"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", code, body)
+	}
+	if resp.RowCount != 1 || resp.Rows[0][0] != "Bob" || resp.Rows[0][1] != "book" {
+		t.Fatalf("unexpected response: %s", body)
+	}
+}
+
+func TestFSQLRejectsWrites(t *testing.T) {
+	h := NewHandler(memfs.NewMemoryFS(), nil)
+	code, _, body := doFSQL(t, h, FSQLRequest{Query: "DROP TABLE t", Tables: map[string]string{"t": "/t.csv"}})
+	if code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", code, body)
+	}
+}
@@ -449,16 +449,7 @@ func (h *Handler) HandleStat(w http.ResponseWriter, r *http.Request, handleIDStr
 		return
 	}
 
-	response := FileInfoResponse{
-		Name:    info.Name,
-		Size:    info.Size,
-		Mode:    info.Mode,
-		ModTime: info.ModTime.Format(time.RFC3339Nano),
-		IsDir:   info.IsDir,
-		Meta:    info.Meta,
-	}
-
-	writeJSON(w, http.StatusOK, response)
+	writeJSON(w, http.StatusOK, fileInfoResponse(*info))
 }
 
 // HandleStream handles GET /api/v1/handles/<id>/stream - streaming read
@@ -9,6 +9,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -17,8 +19,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/c4pt0r/agfs/agfs-server/pkg/acl"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/approvals"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/residency"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/sessions"
 	log "github.com/sirupsen/logrus"
 	"github.com/zeebo/xxh3"
 )
@@ -32,6 +38,14 @@ type Handler struct {
 	trafficMonitor      *TrafficMonitor
 	maxRequestBodyBytes int64
 	mountStatusTracker  *MountStatusTracker
+	homes               HomeGuard
+	sessions            *sessions.Recorder
+	approvals           *approvals.Manager
+	acl                 *acl.Policy
+	residency           *residency.Policy
+	cacheRules          []CacheRule // Longest path first
+	v1Sunset            time.Time   // Zero unless v1 of the API is deprecated
+	uploads             *uploadSessions
 }
 
 // NewHandler creates a new Handler
@@ -43,6 +57,7 @@ func NewHandler(fs filesystem.FileSystem, trafficMonitor *TrafficMonitor) *Handl
 		buildTime:           "unknown",
 		trafficMonitor:      trafficMonitor,
 		maxRequestBodyBytes: DefaultMaxRequestBodyBytes,
+		uploads:             newUploadSessions(),
 	}
 }
 
@@ -77,6 +92,10 @@ type FileInfoResponse struct {
 	ModTime string              `json:"modTime"`
 	IsDir   bool                `json:"isDir"`
 	Meta    filesystem.MetaData `json:"meta,omitempty"` // Structured metadata
+
+	Checksum  string                  `json:"checksum,omitempty"`   // "<algorithm>:<hex>" when known cheaply
+	ExpiresAt *time.Time              `json:"expires_at,omitempty"` // Set when the file has a TTL
+	Preview   *filesystem.FilePreview `json:"preview,omitempty"`    // Set in listings asking for ?preview=
 }
 
 // ListResponse represents directory listing response
@@ -149,10 +168,23 @@ func mapErrorToStatus(err error) int {
 	if errors.Is(err, filesystem.ErrNotSupported) {
 		return http.StatusNotImplemented
 	}
+	if errors.Is(err, filesystem.ErrQuotaExceeded) {
+		return http.StatusInsufficientStorage
+	}
+	if errors.Is(err, filesystem.ErrRateLimited) {
+		return http.StatusTooManyRequests
+	}
+	if errors.Is(err, filesystem.ErrTimeout) {
+		return http.StatusGatewayTimeout
+	}
+	if errors.Is(err, filesystem.ErrBackendUnavailable) {
+		return http.StatusServiceUnavailable
+	}
 	return http.StatusInternalServerError
 }
 
-// CreateFile handles POST /files?path=<path>
+// CreateFile handles POST /files?path=<path>&size=<size>
+// With size, the file is reserved for a write of about that many bytes
 func (h *Handler) CreateFile(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
@@ -160,7 +192,22 @@ func (h *Handler) CreateFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.fs.Create(path); err != nil {
+	create := func() error { return h.fs.Create(path) }
+	if sizeStr := r.URL.Query().Get("size"); sizeStr != "" {
+		size, err := strconv.ParseInt(sizeStr, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid size parameter")
+			return
+		}
+		if reserver, ok := filesystem.As[filesystem.Reserver](h.fs); ok {
+			create = func() error { return reserver.Reserve(path, size) }
+		} else if err := filesystem.ValidateReserveSize(size); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if err := create(); err != nil {
 		status := mapErrorToStatus(err)
 		writeError(w, status, err.Error())
 		return
@@ -188,7 +235,7 @@ func (h *Handler) CreateDirectory(w http.ResponseWriter, r *http.Request) {
 		mode = uint32(m)
 	}
 
-	if err := h.fs.Mkdir(path, mode); err != nil {
+	if err := h.mkdir(r, path, mode); err != nil {
 		status := mapErrorToStatus(err)
 		writeError(w, status, err.Error())
 		return
@@ -197,7 +244,7 @@ func (h *Handler) CreateDirectory(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusCreated, SuccessResponse{Message: "directory created"})
 }
 
-// ReadFile handles GET /files?path=<path>&offset=<offset>&size=<size>&stream=<true|false>
+// ReadFile handles GET and HEAD /files?path=<path>&offset=<offset>&size=<size>&stream=<true|false>
 func (h *Handler) ReadFile(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
@@ -205,6 +252,17 @@ func (h *Handler) ReadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The checksum of the bytes sent follows them in a trailer
+	if algorithm := r.Header.Get(WantChecksumHeader); algorithm != "" && r.Method == http.MethodGet {
+		cw, err := newChecksumWriter(w, algorithm)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer cw.finish()
+		w = cw
+	}
+
 	// Check if streaming mode is requested
 	stream := r.URL.Query().Get("stream") == "true"
 	if stream {
@@ -212,6 +270,15 @@ func (h *Handler) ReadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Plain files are served with validators and byte ranges
+	if h.serveFile(w, r, path) {
+		return
+	}
+	if r.Method == http.MethodHead {
+		h.headFile(w, path)
+		return
+	}
+
 	// Parse offset and size parameters
 	offset := int64(0)
 	size := int64(-1) // -1 means read all
@@ -234,11 +301,15 @@ func (h *Handler) ReadFile(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	data, err := h.fs.Read(path, offset, size)
+	// Reads served here may have side effects (queues, streams) or come
+	// from files without validators, so nothing may reuse them
+	w.Header().Set("Cache-Control", "no-store")
+
+	data, err := h.read(r, path, offset, size)
 	if err != nil {
 		// Check if it's EOF (reached end of file)
 		if err == io.EOF {
-			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Type", h.responseContentType(path))
 			w.WriteHeader(http.StatusOK)
 			w.Write(data) // Return partial data with 200 OK
 			// Record downstream traffic
@@ -253,7 +324,7 @@ func (h *Handler) ReadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Type", h.responseContentType(path))
 	w.WriteHeader(http.StatusOK)
 	w.Write(data)
 
@@ -284,8 +355,62 @@ func (h *Handler) WriteFile(w http.ResponseWriter, r *http.Request) {
 		h.trafficMonitor.RecordWrite(int64(len(data)))
 	}
 
-	// Use default flags: create if not exists, truncate (like the old behavior)
-	bytesWritten, err := h.fs.Write(path, data, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate)
+	data, err = decodeWriteBody(r, data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !checkBodyChecksums(w, r, data) {
+		return
+	}
+
+	offset := int64(-1)
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		offset, err = strconv.ParseInt(offsetStr, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid offset parameter")
+			return
+		}
+	}
+
+	// Optional TTL after which the file expires
+	var ttl time.Duration
+	ttlStr := r.Header.Get(TTLHeader)
+	if ttlStr != "" {
+		ttl, err = filesystem.ParseTTL(ttlStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// Optional media type override
+	contentType := r.Header.Get(ContentTypeHeader)
+	if contentType != "" {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			writeError(w, http.StatusBadRequest, filesystem.NewInvalidArgumentError(ContentTypeHeader, contentType, "not a valid media type").Error())
+			return
+		}
+	}
+
+	flags, err := parseWriteFlags(r.URL.Query().Get("flags"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if flags == filesystem.WriteFlagNone && offset < 0 {
+		// Default flags: create if not exists, truncate (like the old behavior)
+		flags = filesystem.WriteFlagCreate | filesystem.WriteFlagTruncate
+	} else if flags == filesystem.WriteFlagAtomic {
+		// "atomic" alone keeps the default overwrite semantics
+		flags |= filesystem.WriteFlagCreate | filesystem.WriteFlagTruncate
+	}
+	if err := filesystem.ValidateAtomicWrite(path, offset, flags); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	bytesWritten, err := h.write(r, path, data, offset, flags)
 	if err != nil {
 		log.Errorf("[handler] WriteFile failed: path=%s, err=%v", path, err)
 		status := mapErrorToStatus(err)
@@ -293,11 +418,52 @@ func (h *Handler) WriteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if ttlStr != "" {
+		if err := h.setExpiry(path, ttl); err != nil {
+			writeError(w, mapErrorToStatus(err), fmt.Sprintf("written %d bytes but TTL not applied: %v", bytesWritten, err))
+			return
+		}
+	}
+	if contentType != "" {
+		if err := h.setContentType(path, contentType); err != nil {
+			writeError(w, mapErrorToStatus(err), fmt.Sprintf("written %d bytes but content type not applied: %v", bytesWritten, err))
+			return
+		}
+	}
+
 	log.Debugf("[handler] WriteFile success: path=%s, written=%d", path, bytesWritten)
 	// Return success with bytes written
 	writeJSON(w, http.StatusOK, SuccessResponse{Message: fmt.Sprintf("Written %d bytes", bytesWritten)})
 }
 
+// parseWriteFlags parses a comma-separated list of write flag names
+func parseWriteFlags(s string) (filesystem.WriteFlag, error) {
+	flags := filesystem.WriteFlagNone
+	if s == "" {
+		return flags, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "append":
+			flags |= filesystem.WriteFlagAppend
+		case "create":
+			flags |= filesystem.WriteFlagCreate
+		case "exclusive":
+			flags |= filesystem.WriteFlagExclusive
+		case "truncate":
+			flags |= filesystem.WriteFlagTruncate
+		case "sync":
+			flags |= filesystem.WriteFlagSync
+		case "atomic":
+			flags |= filesystem.WriteFlagAtomic
+		case "":
+		default:
+			return 0, fmt.Errorf("unknown write flag: %s", name)
+		}
+	}
+	return flags, nil
+}
+
 // Delete handles DELETE /files?path=<path>&recursive=<true|false>
 func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
@@ -324,43 +490,67 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, SuccessResponse{Message: "deleted"})
 }
 
-// ListDirectory handles GET /directories?path=<path>
+// ListDirectory handles GET /directories?path=<path>&fields=<list>&names_only=<bool>&preview=<n>
 func (h *Handler) ListDirectory(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
 		path = "/"
 	}
 
-	files, err := h.fs.ReadDir(path)
+	fields, err := parseFields(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	previewBytes, err := parsePreview(r, fields)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	files, err := h.readDirFields(r, path, fields)
 	if err != nil {
 		// Map error to appropriate HTTP status code
 		status := mapErrorToStatus(err)
 		writeError(w, status, err.Error())
 		return
 	}
+	files = h.filterTenant(r, path, files)
+
+	var previews map[string]filesystem.FilePreview
+	if previewBytes > 0 {
+		previews = filesystem.PreviewDir(h.fs, path, files, previewBytes)
+	}
+
+	if fields != nil {
+		response := SelectedListResponse{Files: make([]map[string]interface{}, 0, len(files))}
+		for _, f := range files {
+			response.Files = append(response.Files, selectFields(withPreview(fileInfoResponse(f), previews), fields))
+		}
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
 
 	var response ListResponse
 	for _, f := range files {
-		response.Files = append(response.Files, FileInfoResponse{
-			Name:    f.Name,
-			Size:    f.Size,
-			Mode:    f.Mode,
-			ModTime: f.ModTime.Format(time.RFC3339Nano),
-			IsDir:   f.IsDir,
-			Meta:    f.Meta,
-		})
+		response.Files = append(response.Files, withPreview(fileInfoResponse(f), previews))
 	}
 
 	writeJSON(w, http.StatusOK, response)
 }
 
-// Stat handles GET /stat?path=<path>
+// Stat handles GET /stat?path=<path>&fields=<list>
 func (h *Handler) Stat(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
 		writeError(w, http.StatusBadRequest, "path parameter is required")
 		return
 	}
+	fields, err := parseFields(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	info, err := h.fs.Stat(path)
 	if err != nil {
@@ -375,16 +565,13 @@ func (h *Handler) Stat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response := FileInfoResponse{
-		Name:    info.Name,
-		Size:    info.Size,
-		Mode:    info.Mode,
-		ModTime: info.ModTime.Format(time.RFC3339Nano),
-		IsDir:   info.IsDir,
-		Meta:    info.Meta,
+	h.describeContent(path, info)
+	h.setStatCacheHeaders(w, path, info)
+	if fields != nil {
+		writeJSON(w, http.StatusOK, selectFields(fileInfoResponse(*info), fields))
+		return
 	}
-
-	writeJSON(w, http.StatusOK, response)
+	writeJSON(w, http.StatusOK, fileInfoResponse(*info))
 }
 
 // Rename handles POST /rename?path=<path>
@@ -405,6 +592,9 @@ func (h *Handler) Rename(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "newPath is required")
 		return
 	}
+	if !h.authorizePath(w, r, req.NewPath, false) {
+		return
+	}
 
 	if err := h.fs.Rename(path, req.NewPath); err != nil {
 		status := mapErrorToStatus(err)
@@ -457,6 +647,9 @@ func (h *Handler) Digest(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "path is required")
 		return
 	}
+	if !h.authorizePath(w, r, req.Path, false) {
+		return
+	}
 
 	// Calculate digest using streaming approach to handle large files
 	var digest string
@@ -554,17 +747,42 @@ type CapabilitiesResponse struct {
 
 // Capabilities handles GET /capabilities
 func (h *Handler) Capabilities(w http.ResponseWriter, r *http.Request) {
-	response := CapabilitiesResponse{
-		Version: h.version,
-		Features: []string{
-			"handlefs", // File handles for stateful operations
-			"grep",     // Server-side grep
-			"digest",   // Server-side checksums
-			"stream",   // Streaming read
-			"touch",    // Touch/update timestamp
-		},
+	writeJSON(w, http.StatusOK, CapabilitiesResponse{Version: h.version, Features: h.features()})
+}
+
+// features lists the optional features of the server
+func (h *Handler) features() []string {
+	return []string{
+		"handlefs",     // File handles for stateful operations
+		"grep",         // Server-side grep
+		"digest",       // Server-side checksums
+		"stream",       // Streaming read
+		"touch",        // Touch/update timestamp
+		"walk",         // Recursive directory walk
+		"batch",        // Multi-operation batches
+		"checksum",     // Content checksums and verification
+		"integrity",    // Checksums of write and read bodies
+		"find",         // Glob/find queries
+		"ttl",          // Expiring files
+		"fsql",         // SQL across mounts
+		"trash",        // Per-mount trash and undelete
+		"snapshots",    // Read-only mount snapshots
+		"content_type", // MIME type detection and overrides
+		"sessions",     // Session recording
+		"dry_run",      // Previews of mutating operations
+		"approvals",    // Approval workflow for protected paths
+		"acl",          // Per-path access control lists
+		"residency",    // Data residency rules for region-pinned mounts
+		"archive",      // Directory downloads as tar/zip
+		"kv",           // Key-value API over any mount
+		"counter",      // Atomic counter files
+		"log",          // Multi-writer append log files
+		"watch",        // Change notifications with debouncing and batching
+		"doctor",       // End-to-end mount diagnostics
+		"api_v2",       // /api/v2 and version discovery at /api/versions
+		"reserve",      // Creates with a size hint for preallocation
+		"uploads",      // Files assembled from parts written in parallel
 	}
-	writeJSON(w, http.StatusOK, response)
 }
 
 // HealthResponse represents the health check response
@@ -576,6 +794,15 @@ type HealthResponse struct {
 	Ready     bool         `json:"ready"`
 	Degraded  bool         `json:"degraded"`
 	Mounts    MountSummary `json:"mounts"`
+
+	// CircuitBreakers lists the mounts with a circuit breaker. An open
+	// breaker makes the server degraded.
+	CircuitBreakers []mountablefs.BreakerStatus `json:"circuitBreakers,omitempty"`
+
+	// MountHealth lists the mounts whose plugins declare degraded modes. A
+	// mount in a degraded mode makes the status "degraded" but not the
+	// server: it still serves, so /ready is unaffected.
+	MountHealth []mountablefs.MountHealth `json:"mountHealth,omitempty"`
 }
 
 // Health handles GET /health
@@ -603,8 +830,33 @@ func (h *Handler) healthResponse() HealthResponse {
 		mounts = h.mountStatusTracker.Summary()
 	}
 
+	var breakers []mountablefs.BreakerStatus
+	if mfs, ok := h.fs.(interface {
+		CircuitBreakers() []mountablefs.BreakerStatus
+	}); ok {
+		breakers = mfs.CircuitBreakers()
+		for _, b := range breakers {
+			if b.State != mountablefs.BreakerClosed {
+				degraded = true
+			}
+		}
+	}
+
+	var mountHealth []mountablefs.MountHealth
+	softDegraded := false
+	if mfs, ok := h.fs.(interface {
+		MountHealth() []mountablefs.MountHealth
+	}); ok {
+		mountHealth = mfs.MountHealth()
+		for _, m := range mountHealth {
+			if m.Degraded() {
+				softDegraded = true
+			}
+		}
+	}
+
 	status := "healthy"
-	if degraded {
+	if degraded || softDegraded {
 		status = "degraded"
 	} else if !ready {
 		status = "starting"
@@ -618,6 +870,9 @@ func (h *Handler) healthResponse() HealthResponse {
 		Ready:     ready,
 		Degraded:  degraded,
 		Mounts:    mounts,
+
+		CircuitBreakers: breakers,
+		MountHealth:     mountHealth,
 	}
 	response.Status = status
 	return response
@@ -700,6 +955,9 @@ func (h *Handler) Symlink(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "target is required")
 		return
 	}
+	if !h.authorizePath(w, r, symlinkTarget(linkPath, req.Target), false) {
+		return
+	}
 
 	// Check if filesystem implements Symlinker
 	symlinker, ok := h.fs.(filesystem.Symlinker)
@@ -793,6 +1051,7 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 		}
 		h.Ready(w, r)
 	})
+	mux.HandleFunc("/api/v1/doctor", h.Doctor)
 	mux.HandleFunc("/api/v1/version", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -811,6 +1070,13 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 		}
 		h.Capabilities(w, r)
 	})
+	mux.HandleFunc("/api/versions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.APIVersions(w, r)
+	})
 
 	// Convenience routes (aliases for common operations)
 	mux.HandleFunc("/api/v1/mkdir", func(w http.ResponseWriter, r *http.Request) {
@@ -830,7 +1096,8 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 		if strings.Contains(contentType, "application/json") {
 			// Parse JSON body
 			var req struct {
-				Data string `json:"data"`
+				Data     string `json:"data"`
+				Encoding string `json:"encoding,omitempty"` // "base64" for binary data, empty for plain text
 			}
 			if err := decodeLimitedJSON(w, r, h.maxRequestBodyBytes, &req); err != nil {
 				writeRequestBodyError(w, err, h.maxRequestBodyBytes, "invalid JSON body")
@@ -843,11 +1110,15 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 				return
 			}
 			// Write data
-			data := []byte(req.Data)
+			data, err := decodeBody("encoding", req.Encoding, []byte(req.Data))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
 			if h.trafficMonitor != nil && len(data) > 0 {
 				h.trafficMonitor.RecordWrite(int64(len(data)))
 			}
-			bytesWritten, err := h.fs.Write(path, data, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate)
+			bytesWritten, err := h.write(r, path, data, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate)
 			if err != nil {
 				status := mapErrorToStatus(err)
 				writeError(w, status, err.Error())
@@ -883,11 +1154,14 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 	// Setup handle routes (file handles for stateful operations)
 	h.SetupHandleRoutes(mux)
 
+	// Setup upload routes (files assembled from parts written in parallel)
+	h.SetupUploadRoutes(mux)
+
 	mux.HandleFunc("/api/v1/files", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodPost:
 			h.CreateFile(w, r)
-		case http.MethodGet:
+		case http.MethodGet, http.MethodHead:
 			h.ReadFile(w, r)
 		case http.MethodPut:
 			h.WriteFile(w, r)
@@ -972,6 +1246,108 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 		}
 		h.Readlink(w, r)
 	})
+	mux.HandleFunc("/api/v1/files/checksum", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Checksum(w, r)
+	})
+	mux.HandleFunc("/api/v1/files/content-type", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.SetContentType(w, r)
+	})
+	mux.HandleFunc("/api/v1/files/ttl", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.SetTTL(w, r)
+	})
+	mux.HandleFunc("/api/v1/find", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Find(w, r)
+	})
+	mux.HandleFunc("/api/v1/walk", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Walk(w, r)
+	})
+	mux.HandleFunc("/api/v1/prefetch", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Prefetch(w, r)
+	})
+	mux.HandleFunc("/api/v1/deleted", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.ListDeleted(w, r)
+	})
+	mux.HandleFunc("/api/v1/restore", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Restore(w, r)
+	})
+	mux.HandleFunc("/api/v1/retention", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPut {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Retention(w, r)
+	})
+	mux.HandleFunc("/api/v1/watch", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Watch(w, r)
+	})
+	mux.HandleFunc("/api/v1/kv", h.KV)
+	mux.HandleFunc("/api/v1/counter", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Counter(w, r)
+	})
+	mux.HandleFunc("/api/v1/log", h.LogFile)
+	mux.HandleFunc(InboxPagePath, h.InboxPage)
+	mux.HandleFunc("/api/v1/archive", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Archive(w, r)
+	})
+	mux.HandleFunc("/api/v1/batch", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Batch(w, r)
+	})
+	mux.HandleFunc("/api/v1/fsql", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.FSQL(w, r)
+	})
+	mux.HandleFunc("/api/v1/snapshots", h.Snapshots)
 }
 
 // streamFile handles streaming file reads with HTTP chunked transfer encoding
@@ -1085,7 +1461,7 @@ type GrepRequest struct {
 	Recursive       bool   `json:"recursive"`        // Whether to search recursively in directories
 	CaseInsensitive bool   `json:"case_insensitive"` // Case-insensitive matching
 	Stream          bool   `json:"stream"`           // Stream results as NDJSON (one match per line)
-	Limit           int    `json:"limit,omitempty"`  // Maximum number of results (0 means no limit, default 10 for custom grep)
+	Limit           int    `json:"limit,omitempty"`  // Maximum number of results (0 means no limit, or the file system's default for custom grep)
 }
 
 // GrepMatch represents a single match result
@@ -1115,6 +1491,9 @@ func (h *Handler) Grep(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "path is required")
 		return
 	}
+	if !h.authorizePath(w, r, req.Path, req.Recursive) {
+		return
+	}
 	if req.Pattern == "" {
 		writeError(w, http.StatusBadRequest, "pattern is required")
 		return
@@ -1125,13 +1504,22 @@ func (h *Handler) Grep(w http.ResponseWriter, r *http.Request) {
 	if cg, ok := h.fs.(interface {
 		CustomGrep(string, string, int) ([]mountablefs.CustomGrepResult, error)
 	}); ok {
-		// Set default limit for custom grep if not specified
-		limit := req.Limit
-		if limit <= 0 {
-			limit = 10 // Default to 10 results for vector search
+		// Attempt custom grep (e.g., vector search); a zero limit lets the
+		// file system apply its own default
+		customResults, err := cg.CustomGrep(req.Path, req.Pattern, req.Limit)
+		if errors.Is(err, filesystem.ErrTimeout) {
+			// Falling back to a text grep would only take longer
+			writeError(w, http.StatusGatewayTimeout, err.Error())
+			return
+		}
+		var limited *filesystem.RateLimitedError
+		if errors.As(err, &limited) {
+			// A throttled search must be retried, not answered by a text grep
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, err.Error())
+			return
 		}
-		// Attempt custom grep (e.g., vector search)
-		customResults, err := cg.CustomGrep(req.Path, req.Pattern, limit)
+		customResults = h.filterTenantResults(r, customResults)
 		if err == nil && len(customResults) > 0 {
 			// Convert custom results to GrepMatch format
 			var matches []GrepMatch
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// HomeGuard provisions home directories and confines identities to them
+type HomeGuard interface {
+	Provision(identity string) error
+	Authorize(identity, path string, recursive bool) error
+}
+
+// SetHomes enables per-identity home directories
+func (h *Handler) SetHomes(homes HomeGuard) {
+	h.homes = homes
+}
+
+// recursiveEndpoints walk the tree below their path
+var recursiveEndpoints = map[string]bool{
+	"/api/v1/find":    true,
+	"/api/v1/walk":    true,
+	"/api/v1/archive": true,
+}
+
+// requestRecursive reports whether r operates on everything below its path
+// parameter
+func requestRecursive(r *http.Request) bool {
+	return recursiveEndpoints[r.URL.Path] || r.URL.Query().Get("recursive") == "true"
+}
+
+// HomesMiddleware provisions the caller's home on each request and rejects
+// requests whose path parameter is in another identity's home. Paths in
+// request bodies are checked by the handlers through authorizePath.
+func (h *Handler) HomesMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := IdentityFromContext(r.Context())
+		if h.homes == nil || identity == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := h.homes.Provision(identity); err != nil {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return
+		}
+		if path := r.URL.Query().Get("path"); path != "" {
+			if !h.authorizePath(w, r, path, requestRecursive(r)) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorizePath checks that the caller may access path, under home
+// confinement, the access control list and tenant scoping, writing a 403
+// and returning false if not
+func (h *Handler) authorizePath(w http.ResponseWriter, r *http.Request, path string, recursive bool) bool {
+	identity := IdentityFromContext(r.Context())
+	if h.homes != nil {
+		for _, p := range h.authorizedPaths(path) {
+			if err := h.homes.Authorize(identity, p, recursive); err != nil {
+				writeError(w, http.StatusForbidden, err.Error())
+				return false
+			}
+		}
+	}
+	if h.acl != nil {
+		for _, p := range h.authorizedPaths(path) {
+			if err := h.acl.Check(identity, p, h.aclPermission(r, p), recursive); err != nil {
+				writeError(w, http.StatusForbidden, err.Error())
+				return false
+			}
+		}
+	}
+	return h.authorizeTenant(w, r, path, recursive)
+}
+
+// authorizedPaths returns the paths access to p is decided on: p itself
+// and, if symbolic links lead it elsewhere, the path they resolve it to
+func (h *Handler) authorizedPaths(p string) []string {
+	paths := []string{p}
+	if resolver, ok := filesystem.As[filesystem.PathResolver](h.fs); ok {
+		if resolved, err := resolver.ResolvePath(p); err == nil && resolved != filesystem.NormalizePath(p) {
+			paths = append(paths, resolved)
+		}
+	}
+	return paths
+}
+
+// symlinkTarget returns the path a symbolic link at linkPath to target
+// points to: relative targets are relative to the link's directory
+func symlinkTarget(linkPath, target string) string {
+	if strings.HasPrefix(target, "/") {
+		return filesystem.NormalizePath(target)
+	}
+	return filesystem.NormalizePath(path.Join(path.Dir(filesystem.NormalizePath(linkPath)), target))
+}
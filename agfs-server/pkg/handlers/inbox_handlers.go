@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// InboxPagePath serves the web page for answering inboxfs tasks
+const InboxPagePath = "/inbox"
+
+// InboxPage handles GET /inbox, a page presenting the pending tasks of every
+// inboxfs mount as forms. It only uses the file API, so answers go through
+// the same authentication and access control as any other write.
+func (h *Handler) InboxPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(inboxPage))
+}
+
+// inboxPage is the inbox web page. Like the approvals page, it keeps the
+// token in the browser's local storage and sends it as a bearer token.
+const inboxPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>AGFS Inbox</title>
+<style>
+body { font-family: sans-serif; margin: 2em; max-width: 50em; }
+fieldset { margin-bottom: 1.5em; border: 1px solid #ddd; }
+legend { font-weight: bold; }
+label { display: block; margin-top: 0.6em; }
+textarea { width: 100%; min-height: 5em; }
+.meta { color: #666; font-size: 90%; }
+.description { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>Inbox</h1>
+<p>Token: <input id="token" type="password" size="40"> <button onclick="saveToken()">Save</button> <button onclick="load()">Refresh</button></p>
+<p id="error" style="color: #b00"></p>
+<div id="tasks"></div>
+<script>
+const tokenInput = document.getElementById("token");
+tokenInput.value = localStorage.getItem("agfs-token") || "";
+function saveToken() { localStorage.setItem("agfs-token", tokenInput.value); load(); }
+function headers() {
+  const h = {};
+  if (tokenInput.value) h["Authorization"] = "Bearer " + tokenInput.value;
+  return h;
+}
+function el(tag, props, children) {
+  const e = Object.assign(document.createElement(tag), props || {});
+  (children || []).forEach(c => e.append(c));
+  return e;
+}
+async function api(path, params) {
+  const resp = await fetch("/api/v1/" + path + "?" + new URLSearchParams(params), {headers: headers()});
+  if (!resp.ok) throw new Error((await resp.json()).error);
+  return resp;
+}
+function input(f) {
+  const name = "field-" + f.name;
+  switch (f.type) {
+  case "textarea":
+    return el("textarea", {name: name, required: !!f.required, value: f.default || ""});
+  case "number":
+    return el("input", {name: name, type: "number", step: "any", required: !!f.required, value: f.default ?? ""});
+  case "boolean":
+    return el("input", {name: name, type: "checkbox", checked: !!f.default});
+  case "select":
+    return el("select", {name: name, required: !!f.required},
+      [el("option", {value: "", textContent: ""})].concat(f.options.map(o => el("option", {value: o, textContent: o, selected: o === f.default}))));
+  default:
+    return el("input", {name: name, type: "text", size: 60, required: !!f.required, value: f.default || ""});
+  }
+}
+function values(form, task) {
+  const out = {};
+  for (const f of task.fields) {
+    const e = form.elements["field-" + f.name];
+    if (f.type === "boolean") out[f.name] = e.checked;
+    else if (e.value === "") continue;
+    else if (f.type === "number") out[f.name] = Number(e.value);
+    else out[f.name] = e.value;
+  }
+  return out;
+}
+function taskForm(mount, task) {
+  const form = el("form", {}, [
+    el("legend", {textContent: task.title}),
+    el("div", {className: "meta", textContent: mount + " · " + task.id + " · " + new Date(task.created_at).toLocaleString()}),
+    el("p", {className: "description", textContent: task.description || ""}),
+  ]);
+  for (const f of task.fields) form.append(el("label", {textContent: (f.label || f.name) + (f.required ? " *" : "")}, [el("br"), input(f)]));
+  form.append(el("p", {}, [el("button", {type: "submit", textContent: "Submit"})]));
+  form.onsubmit = async (ev) => {
+    ev.preventDefault();
+    const resp = await fetch("/api/v1/files?" + new URLSearchParams({path: mount + "/responses/" + task.id}),
+      {method: "PUT", headers: headers(), body: JSON.stringify(values(form, task))});
+    if (!resp.ok) { document.getElementById("error").textContent = (await resp.json()).error; return; }
+    load();
+  };
+  return el("fieldset", {}, [form]);
+}
+async function load() {
+  const error = document.getElementById("error");
+  const list = document.getElementById("tasks");
+  error.textContent = "";
+  try {
+    const mounts = (await (await api("mounts", {})).json()).mounts.filter(m => m.pluginName === "inboxfs");
+    const forms = [];
+    for (const m of mounts) {
+      const files = (await (await api("directories", {path: m.path + "/pending"})).json()).files || [];
+      for (const f of files) {
+        const task = await (await api("files", {path: m.path + "/pending/" + f.name})).json();
+        forms.push(taskForm(m.path, task));
+      }
+    }
+    list.replaceChildren(...(forms.length ? forms : [el("p", {textContent: mounts.length ? "No pending tasks." : "No inboxfs mounts."})]));
+  } catch (e) {
+    error.textContent = e.message;
+  }
+}
+load();
+</script>
+</body>
+</html>
+`
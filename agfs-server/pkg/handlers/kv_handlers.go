@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxKVValueBytes bounds the values stored through the KV API, which is
+// meant for small state; larger data belongs in files
+const maxKVValueBytes = 1 << 20
+
+// maxKVFileName is the longest file name a key may encode to
+const maxKVFileName = 255
+
+// KVListResponse lists the keys stored under a directory
+type KVListResponse struct {
+	Keys []string `json:"keys"`
+}
+
+// kvFilePath returns the file storing key under dir. Keys are spread over
+// 256 shard directories named after the first byte of their SHA-256, so that
+// no directory grows too large to list, and percent-encoded into file names.
+func kvFilePath(dir, key string) (string, error) {
+	if key == "" {
+		return "", filesystem.NewInvalidArgumentError("key", key, "must not be empty")
+	}
+	name := url.PathEscape(key)
+	if strings.HasPrefix(name, ".") {
+		// Keep "." and ".." and hidden names from being special
+		name = "%2E" + name[1:]
+	}
+	if len(name) > maxKVFileName {
+		return "", filesystem.NewInvalidArgumentError("key", key, fmt.Sprintf("encodes to more than %d bytes", maxKVFileName))
+	}
+	sum := sha256.Sum256([]byte(key))
+	return path.Join(filesystem.NormalizePath(dir), hex.EncodeToString(sum[:1]), name), nil
+}
+
+// isKVShard reports whether name is a shard directory name
+func isKVShard(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil && strings.ToLower(name) == name
+}
+
+// KV handles GET, PUT and DELETE /kv?path=<dir>&key=<key>, a key-value
+// store kept as files under dir. GET without a key lists the keys.
+func (h *Handler) KV(w http.ResponseWriter, r *http.Request) {
+	dir := r.URL.Query().Get("path")
+	if dir == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	key := r.URL.Query().Get("key")
+	if key == "" && r.Method == http.MethodGet {
+		h.listKV(w, r, dir)
+		return
+	}
+	filePath, err := kvFilePath(dir, key)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// The middleware only checked dir
+	if !h.authorizePath(w, r, filePath, false) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.getKV(w, r, filePath)
+	case http.MethodPut:
+		h.setKV(w, r, filePath)
+	case http.MethodDelete:
+		if err := h.remove(r, filePath); err != nil {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, SuccessResponse{Message: "deleted"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *Handler) getKV(w http.ResponseWriter, r *http.Request, filePath string) {
+	data, err := h.read(r, filePath, 0, -1)
+	if err != nil && err != io.EOF {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	if h.trafficMonitor != nil && len(data) > 0 {
+		h.trafficMonitor.RecordRead(int64(len(data)))
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// setKV stores the request body as the value, creating the directories it
+// needs. The optional X-AGFS-TTL header makes the value expire.
+func (h *Handler) setKV(w http.ResponseWriter, r *http.Request, filePath string) {
+	var ttl time.Duration
+	ttlStr := r.Header.Get(TTLHeader)
+	if ttlStr != "" {
+		var err error
+		if ttl, err = filesystem.ParseTTL(ttlStr); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	data, err := readLimitedRequestBody(w, r, min(h.maxRequestBodyBytes, maxKVValueBytes))
+	if err != nil {
+		writeRequestBodyError(w, err, min(h.maxRequestBodyBytes, maxKVValueBytes), "failed to read value")
+		return
+	}
+	if h.trafficMonitor != nil && len(data) > 0 {
+		h.trafficMonitor.RecordWrite(int64(len(data)))
+	}
+
+	if err := h.mkdirAll(r, path.Dir(filePath)); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	if _, err := h.write(r, filePath, data, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	if ttlStr != "" {
+		if err := h.setExpiry(filePath, ttl); err != nil {
+			writeError(w, mapErrorToStatus(err), "value stored but TTL not applied: "+err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, SuccessResponse{Message: "stored"})
+}
+
+// listKV lists the keys under dir, optionally only those with a prefix
+func (h *Handler) listKV(w http.ResponseWriter, r *http.Request, dir string) {
+	if !h.authorizePath(w, r, dir, true) {
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	response := KVListResponse{Keys: []string{}}
+	shards, err := h.readDir(r, dir)
+	if err != nil {
+		if mapErrorToStatus(err) == http.StatusNotFound {
+			writeJSON(w, http.StatusOK, response)
+			return
+		}
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	for _, shard := range shards {
+		if !shard.IsDir || !isKVShard(shard.Name) {
+			continue
+		}
+		entries, err := h.readDir(r, path.Join(dir, shard.Name))
+		if err != nil {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return
+		}
+		for _, entry := range entries {
+			key, err := url.PathUnescape(entry.Name)
+			if entry.IsDir || err != nil || !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			response.Keys = append(response.Keys, key)
+		}
+	}
+	sort.Strings(response.Keys)
+	log.Debugf("[handler] Listed %d keys under %s", len(response.Keys), dir)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// mkdirAll creates dir and its missing parents on behalf of r
+func (h *Handler) mkdirAll(r *http.Request, dir string) error {
+	if info, err := h.fs.Stat(dir); err == nil {
+		if !info.IsDir {
+			return filesystem.NewNotDirectoryError(dir)
+		}
+		return nil
+	}
+	if parent := path.Dir(dir); parent != dir {
+		if err := h.mkdirAll(r, parent); err != nil {
+			return err
+		}
+	}
+	if err := r.Context().Err(); err != nil {
+		return err
+	}
+	return h.mkdir(r, dir, 0755)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestKVSetGetListDelete(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	h := NewHandler(fs, nil)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	do := func(method, query, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, "/api/v1/kv?"+query, strings.NewReader(body)))
+		return rec
+	}
+
+	for key, value := range map[string]string{"plan": "step 3", "a/b c": "slashes", "..": "dots", "plan-old": "x"} {
+		if rec := do(http.MethodPut, "path=/state&key="+urlEncode(key), value); rec.Code != http.StatusOK {
+			t.Fatalf("set %q: status %d: %s", key, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := do(http.MethodGet, "path=/state&key=a%2Fb+c", ""); rec.Code != http.StatusOK || rec.Body.String() != "slashes" {
+		t.Errorf("get: status %d, body %q", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPut, "path=/state&key=plan", "step 4"); rec.Code != http.StatusOK {
+		t.Fatalf("overwrite: status %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "path=/state&key=plan", ""); rec.Body.String() != "step 4" {
+		t.Errorf("overwritten value = %q", rec.Body.String())
+	}
+
+	// Keys live in shard directories, so the store's directory stays small
+	entries, _ := fs.ReadDir("/state")
+	for _, entry := range entries {
+		if !entry.IsDir || !isKVShard(entry.Name) {
+			t.Errorf("unexpected entry %q in /state", entry.Name)
+		}
+	}
+
+	var list KVListResponse
+	rec := do(http.MethodGet, "path=/state&prefix=plan", "")
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if want := []string{"plan", "plan-old"}; !reflect.DeepEqual(list.Keys, want) {
+		t.Errorf("keys = %v, want %v", list.Keys, want)
+	}
+
+	if rec := do(http.MethodDelete, "path=/state&key=plan", ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "path=/state&key=plan", ""); rec.Code == http.StatusOK {
+		t.Errorf("get deleted key succeeded: %q", rec.Body.String())
+	}
+}
+
+func TestKVRejectsBadRequests(t *testing.T) {
+	h := NewHandler(memfs.NewMemoryFS(), nil)
+	for _, tc := range []struct {
+		method, query, body string
+		status              int
+	}{
+		{http.MethodPut, "path=/s", "v", http.StatusBadRequest},
+		{http.MethodPut, "key=k", "v", http.StatusBadRequest},
+		{http.MethodPut, "path=/s&key=" + strings.Repeat("%C3%A9", 50), "v", http.StatusBadRequest},
+		{http.MethodPut, "path=/s&key=k", strings.Repeat("x", maxKVValueBytes+1), http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "path=/s&key=k", "v", http.StatusMethodNotAllowed},
+	} {
+		rec := httptest.NewRecorder()
+		h.KV(rec, httptest.NewRequest(tc.method, "/api/v1/kv?"+tc.query, strings.NewReader(tc.body)))
+		if rec.Code != tc.status {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.query, rec.Code, tc.status)
+		}
+	}
+}
+
+func urlEncode(s string) string {
+	return strings.NewReplacer("/", "%2F", " ", "+").Replace(s)
+}
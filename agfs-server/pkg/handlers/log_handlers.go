@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxLogReadRecords bounds the records returned by one read of a log file
+const maxLogReadRecords = 1000
+
+// LogAppendResponse reports where a record was appended to a log file
+type LogAppendResponse struct {
+	Path       string `json:"path"`
+	Offset     int64  `json:"offset"`         // Offset of the record
+	NextOffset int64  `json:"next_offset"`    // Offset after the record
+	Time       string `json:"time,omitempty"` // Timestamp of the record, if requested
+}
+
+// LogReadResponse holds records read from a log file and where to resume
+type LogReadResponse struct {
+	Path       string                 `json:"path"`
+	Records    []filesystem.LogRecord `json:"records"`
+	NextOffset int64                  `json:"next_offset"`
+}
+
+// LogFile handles /log?path=<path>, a log file many clients append records
+// to concurrently:
+//   - POST /log?path=<path>&source=<name>&timestamp=<bool> appends the
+//     request body as one record, creating the file if missing
+//   - GET /log?path=<path>&offset=<n>&limit=<n> returns the records from
+//     offset (default 0) and the offset of the next ones
+func (h *Handler) LogFile(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		h.appendLog(w, r, path)
+	case http.MethodGet:
+		h.readLog(w, r, path)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *Handler) appendLog(w http.ResponseWriter, r *http.Request, path string) {
+	opts := filesystem.LogAppendOptions{
+		Source:    r.URL.Query().Get("source"),
+		Timestamp: r.URL.Query().Get("timestamp") == "true",
+	}
+	data, err := readLimitedRequestBody(w, r, h.maxRequestBodyBytes)
+	if err != nil {
+		writeRequestBodyError(w, err, h.maxRequestBodyBytes, "failed to read record")
+		return
+	}
+	if h.trafficMonitor != nil && len(data) > 0 {
+		h.trafficMonitor.RecordWrite(int64(len(data)))
+	}
+
+	rec, err := filesystem.AppendLog(h.fs, path, data, opts)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	next := rec.Offset + int64(len(filesystem.EncodeLogRecord(rec)))
+	log.Debugf("[handler] Log record of %d bytes appended to %s at %d", len(data), path, rec.Offset)
+	writeJSON(w, http.StatusOK, LogAppendResponse{Path: path, Offset: rec.Offset, NextOffset: next, Time: rec.Time})
+}
+
+func (h *Handler) readLog(w http.ResponseWriter, r *http.Request, path string) {
+	var offset int64
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		var err error
+		if offset, err = strconv.ParseInt(offsetStr, 10, 64); err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+	}
+	limit := maxLogReadRecords
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, maxLogReadRecords)
+	}
+
+	records, next, err := filesystem.ReadLog(h.fs, path, offset, limit)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	if h.trafficMonitor != nil && next > offset {
+		h.trafficMonitor.RecordRead(next - offset)
+	}
+	writeJSON(w, http.StatusOK, LogReadResponse{Path: path, Records: records, NextOffset: next})
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// plainFS hides the native appends of the file system it wraps
+type plainFS struct {
+	filesystem.FileSystem
+}
+
+func TestLogFile(t *testing.T) {
+	for name, fs := range map[string]filesystem.FileSystem{
+		"native":   memfs.NewMemoryFS(),
+		"fallback": plainFS{memfs.NewMemoryFS()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := NewHandler(fs, nil)
+			mux := http.NewServeMux()
+			h.SetupRoutes(mux)
+			do := func(method, query, body string) *httptest.ResponseRecorder {
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, httptest.NewRequest(method, "/api/v1/log?"+query, strings.NewReader(body)))
+				return rec
+			}
+
+			// Concurrent appends each land whole
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					if rec := do(http.MethodPost, fmt.Sprintf("path=/journal&source=agent-%d", i%5), fmt.Sprintf("step %d\ndone", i)); rec.Code != http.StatusOK {
+						t.Errorf("append %d: status %d %s", i, rec.Code, rec.Body.String())
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			var read LogReadResponse
+			var offset int64
+			seen := make(map[string]bool)
+			for pages := 0; ; pages++ {
+				rec := do(http.MethodGet, fmt.Sprintf("path=/journal&offset=%d&limit=20", offset), "")
+				read = LogReadResponse{}
+				json.Unmarshal(rec.Body.Bytes(), &read)
+				if rec.Code != http.StatusOK || pages > 3 {
+					t.Fatalf("read from %d = %d %s", offset, rec.Code, rec.Body.String())
+				}
+				for _, r := range read.Records {
+					if r.Offset != offset || !strings.HasPrefix(r.Source, "agent-") || r.Time != "" {
+						t.Errorf("record %+v at offset %d", r, offset)
+					}
+					seen[r.Data] = true
+					offset += int64(len(filesystem.EncodeLogRecord(r)))
+				}
+				if read.NextOffset != offset {
+					t.Fatalf("next_offset = %d, want %d", read.NextOffset, offset)
+				}
+				if len(read.Records) == 0 {
+					break
+				}
+			}
+			if len(seen) != 50 || !seen["step 7\ndone"] {
+				t.Errorf("read %d distinct records, want 50", len(seen))
+			}
+
+			// Timestamps and binary records
+			rec := do(http.MethodPost, "path=/journal&timestamp=true", "\xff\x00")
+			var appended LogAppendResponse
+			json.Unmarshal(rec.Body.Bytes(), &appended)
+			if rec.Code != http.StatusOK || appended.Offset != offset || appended.Time == "" {
+				t.Fatalf("timestamped append = %d %s", rec.Code, rec.Body.String())
+			}
+			rec = do(http.MethodGet, fmt.Sprintf("path=/journal&offset=%d", offset), "")
+			read = LogReadResponse{}
+			json.Unmarshal(rec.Body.Bytes(), &read)
+			if len(read.Records) != 1 || read.Records[0].Data != "/wA=" || read.Records[0].Encoding != "base64" ||
+				read.Records[0].Time != appended.Time || read.NextOffset != appended.NextOffset {
+				t.Errorf("binary record = %s", rec.Body.String())
+			}
+
+			if rec := do(http.MethodGet, "path=/journal&offset=3", ""); rec.Code != http.StatusBadRequest {
+				t.Errorf("read from inside a record: status %d", rec.Code)
+			}
+			if rec := do(http.MethodPost, "source=x", "data"); rec.Code != http.StatusBadRequest {
+				t.Errorf("missing path: status %d", rec.Code)
+			}
+			if rec := do(http.MethodPut, "path=/journal", "data"); rec.Code != http.StatusMethodNotAllowed {
+				t.Errorf("PUT: status %d", rec.Code)
+			}
+		})
+	}
+}
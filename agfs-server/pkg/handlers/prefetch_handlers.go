@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// PrefetchResponse reports a prefetch. Counts are only set when the client
+// waited for it to finish.
+type PrefetchResponse struct {
+	Path      string `json:"path"`
+	Recursive bool   `json:"recursive"`
+	Status    string `json:"status"`             // "started" or "done"
+	Loaded    int    `json:"loaded"`             // Files now in a cache
+	Skipped   int    `json:"skipped"`            // Files without a cache, or not cacheable
+	Failed    int    `json:"failed"`             // Files that could not be read
+	Error     string `json:"error,omitempty"`    // Why the walk stopped early
+	Duration  string `json:"duration,omitempty"` // Time taken
+}
+
+// Prefetch handles POST /prefetch?path=<path>&recursive=<bool>&wait=<bool>
+// plus the walk filters (max_depth, include, exclude). It loads a file, or
+// the files of a directory (and its subdirectories with recursive), into
+// the content cache of their mounts, so that an agent can warm its working
+// set before latency-sensitive work. Listing the directories also warms the
+// listing and stat caches of backends that have them. Loading happens in
+// the background unless wait is true.
+func (h *Handler) Prefetch(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	p = filesystem.NormalizePath(p)
+	recursive := r.URL.Query().Get("recursive") == "true"
+	wait := r.URL.Query().Get("wait") == "true"
+
+	opts, err := parseWalkOptions(r)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	opts.FilesOnly = true
+	if !recursive {
+		opts.MaxDepth = 1
+	}
+
+	prefetcher, ok := filesystem.As[filesystem.Prefetcher](h.fs)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "file system has no cache to prefetch into")
+		return
+	}
+	info, err := h.fs.Stat(p)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	response := PrefetchResponse{Path: p, Recursive: recursive, Status: "started"}
+	if !wait {
+		go h.prefetch(prefetcher, p, info, opts)
+		writeJSON(w, http.StatusAccepted, response)
+		return
+	}
+	result := h.prefetch(prefetcher, p, info, opts)
+	result.Recursive = recursive
+	writeJSON(w, http.StatusOK, result)
+}
+
+// prefetch loads a file or the files of a directory into their caches
+func (h *Handler) prefetch(prefetcher filesystem.Prefetcher, root string, info *filesystem.FileInfo, opts filesystem.WalkOptions) PrefetchResponse {
+	start := time.Now()
+	result := PrefetchResponse{Path: root, Status: "done"}
+	load := func(p string) {
+		switch err := prefetcher.Prefetch(p); {
+		case err == nil:
+			result.Loaded++
+		case errors.Is(err, filesystem.ErrNotSupported):
+			result.Skipped++
+		default:
+			result.Failed++
+			log.Debugf("[prefetch] %s: %v", p, err)
+		}
+	}
+
+	if info.IsDir {
+		err := filesystem.Walk(h.fs, root, opts, func(entry filesystem.WalkEntry) error {
+			load(entry.Path)
+			return nil
+		})
+		if err != nil {
+			result.Error = err.Error()
+		}
+	} else {
+		load(root)
+	}
+
+	result.Duration = time.Since(start).String()
+	log.Infof("[prefetch] %s: %d loaded, %d skipped, %d failed in %s", root, result.Loaded, result.Skipped, result.Failed, result.Duration)
+	return result
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestPrefetchLoadsCachedMounts(t *testing.T) {
+	root := mountablefs.NewMountableFS(api.PoolConfig{})
+	for _, mount := range []struct {
+		path   string
+		config map[string]interface{}
+	}{
+		{"/cached", map[string]interface{}{mountablefs.MiddlewareConfigKey: []interface{}{"cache"}}},
+		{"/plain", map[string]interface{}{}},
+	} {
+		opts, rest, err := mountablefs.ParseMountOptions(mount.config)
+		if err != nil {
+			t.Fatalf("ParseMountOptions() error = %v", err)
+		}
+		p := memfs.NewMemFSPlugin()
+		p.Initialize(rest)
+		fs := p.GetFileSystem()
+		fs.Mkdir("/sub", 0755)
+		for _, file := range []string{"/a.txt", "/sub/b.txt"} {
+			if _, err := fs.Write(file, []byte("data"), -1, filesystem.WriteFlagCreate); err != nil {
+				t.Fatalf("write %s: %v", file, err)
+			}
+		}
+		if err := root.MountWithOptions(mount.path, p, opts); err != nil {
+			t.Fatalf("MountWithOptions() error = %v", err)
+		}
+	}
+	h := NewHandler(root, nil)
+
+	prefetch := func(query string) (int, PrefetchResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.Prefetch(rec, httptest.NewRequest(http.MethodPost, "/api/v1/prefetch?"+query, nil))
+		var resp PrefetchResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	// Each memfs mount also holds its README
+	tests := []struct {
+		query                   string
+		loaded, skipped, failed int
+	}{
+		{"path=/cached/a.txt&wait=true", 1, 0, 0},
+		{"path=/cached&wait=true", 2, 0, 0},
+		{"path=/cached&recursive=true&wait=true", 3, 0, 0},
+		{"path=/plain&recursive=true&wait=true", 0, 3, 0},
+		{"path=/cached&recursive=true&include=b.*&wait=true", 1, 0, 0},
+	}
+	for _, tt := range tests {
+		code, resp := prefetch(tt.query)
+		if code != http.StatusOK || resp.Status != "done" {
+			t.Errorf("%s: status %d, %q; want 200, done", tt.query, code, resp.Status)
+			continue
+		}
+		if resp.Loaded != tt.loaded || resp.Skipped != tt.skipped || resp.Failed != tt.failed {
+			t.Errorf("%s: loaded %d, skipped %d, failed %d; want %d, %d, %d",
+				tt.query, resp.Loaded, resp.Skipped, resp.Failed, tt.loaded, tt.skipped, tt.failed)
+		}
+	}
+
+	if code, resp := prefetch("path=/cached&recursive=true"); code != http.StatusAccepted || resp.Status != "started" {
+		t.Errorf("background prefetch: status %d, %q; want 202, started", code, resp.Status)
+	}
+	if code, _ := prefetch("path=/nowhere/a.txt"); code != http.StatusNotFound {
+		t.Errorf("missing path: status %d, want 404", code)
+	}
+	if code, _ := prefetch("recursive=true"); code != http.StatusBadRequest {
+		t.Errorf("no path: status %d, want 400", code)
+	}
+}
+
+func TestPrefetchWithoutCache(t *testing.T) {
+	h := NewHandler(memfs.NewMemoryFS(), nil)
+	rec := httptest.NewRecorder()
+	h.Prefetch(rec, httptest.NewRequest(http.MethodPost, "/api/v1/prefetch?path=/", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("status %d, want 501", rec.Code)
+	}
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// parsePreview returns the preview length a directory listing asks for with
+// ?preview=<bytes> or ?preview=true, or 0 for none. Selecting the preview
+// field asks for the default length.
+func parsePreview(r *http.Request, fields []string) (int, error) {
+	value := r.URL.Query().Get("preview")
+	switch value {
+	case "":
+		if slices.Contains(fields, "preview") {
+			return filesystem.DefaultPreviewBytes, nil
+		}
+		return 0, nil
+	case "true":
+		return filesystem.DefaultPreviewBytes, nil
+	case "false":
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > filesystem.MaxPreviewBytes {
+		return 0, filesystem.NewInvalidArgumentError("preview", value,
+			fmt.Sprintf("must be true, false or a number of bytes up to %d", filesystem.MaxPreviewBytes))
+	}
+	return n, nil
+}
+
+// withPreview sets the preview of an entry of a listing, if it has one
+func withPreview(resp FileInfoResponse, previews map[string]filesystem.FilePreview) FileInfoResponse {
+	if p, ok := previews[resp.Name]; ok {
+		resp.Preview = &p
+	}
+	return resp
+}
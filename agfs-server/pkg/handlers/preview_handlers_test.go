@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestListDirectoryPreview(t *testing.T) {
+	root := mountablefs.NewMountableFS(api.PoolConfig{})
+	p := memfs.NewMemFSPlugin()
+	p.Initialize(map[string]interface{}{})
+	if err := root.Mount("/data", p); err != nil {
+		t.Fatalf("Mount() error = %v", err)
+	}
+	defer root.Unmount("/data")
+	for name, content := range map[string]string{
+		"/data/notes.md":  "# Plans\n\nShip the preview.\n",
+		"/data/page.html": "<html><head><title>Home</title></head></html>",
+		"/data/image.bin": "\x00\x01\x02",
+		"/data/short.txt": "hi",
+	} {
+		if _, err := root.Write(name, []byte(content), -1, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+	}
+	if err := root.Mkdir("/data/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(root, nil)
+
+	list := func(query string) (int, map[string]*filesystem.FilePreview) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ListDirectory(rec, httptest.NewRequest(http.MethodGet, "/api/v1/directories?path=/data&"+query, nil))
+		var resp ListResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		previews := make(map[string]*filesystem.FilePreview)
+		for _, f := range resp.Files {
+			previews[f.Name] = f.Preview
+		}
+		return rec.Code, previews
+	}
+
+	code, previews := list("preview=10")
+	if code != http.StatusOK {
+		t.Fatalf("preview=10 status = %d", code)
+	}
+	if p := previews["notes.md"]; p == nil || p.Title != "Plans" || p.Text != "# Plans\n\nS" || !p.Truncated {
+		t.Errorf("notes.md preview = %+v", p)
+	}
+	if p := previews["short.txt"]; p == nil || p.Text != "hi" || p.Truncated {
+		t.Errorf("short.txt preview = %+v", p)
+	}
+	if previews["image.bin"] != nil || previews["sub"] != nil {
+		t.Errorf("binary file or directory previewed: %+v, %+v", previews["image.bin"], previews["sub"])
+	}
+
+	if _, previews := list("preview=true"); previews["page.html"] == nil || previews["page.html"].Title != "Home" {
+		t.Errorf("page.html preview = %+v", previews["page.html"])
+	}
+	if _, previews := list(""); previews["notes.md"] != nil {
+		t.Error("listing without preview= has previews")
+	}
+
+	// Selecting the preview field asks for previews
+	rec := httptest.NewRecorder()
+	h.ListDirectory(rec, httptest.NewRequest(http.MethodGet, "/api/v1/directories?path=/data&fields=name,preview", nil))
+	var selected SelectedListResponse
+	json.Unmarshal(rec.Body.Bytes(), &selected)
+	found := false
+	for _, f := range selected.Files {
+		found = found || (f["name"] == "short.txt" && f["preview"] != nil)
+	}
+	if !found {
+		t.Errorf("fields=name,preview = %s", rec.Body.String())
+	}
+
+	for _, query := range []string{"preview=lots", "preview=-1", "preview=100000"} {
+		if code, _ := list(query); code != http.StatusBadRequest {
+			t.Errorf("%s status = %d, want 400", query, code)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// Request priority classes
+const (
+	// PriorityInteractive is for people at a shell or an editor waiting on
+	// the answer (the default)
+	PriorityInteractive = "interactive"
+	// PriorityBatch is for bulk jobs such as ingestion and backups, which
+	// yield to interactive requests
+	PriorityBatch = "batch"
+)
+
+// PriorityHeader sets the priority of a request. It can only lower the
+// priority of the caller's token: a batch token can't send interactive
+// requests.
+const PriorityHeader = "X-AGFS-Priority"
+
+// ParsePriority checks a priority class name, "" meaning interactive
+func ParsePriority(s string) (string, error) {
+	switch s {
+	case "", PriorityInteractive:
+		return PriorityInteractive, nil
+	case PriorityBatch:
+		return PriorityBatch, nil
+	}
+	return "", fmt.Errorf("invalid priority %q (valid: %s, %s)", s, PriorityInteractive, PriorityBatch)
+}
+
+type priorityKey struct{}
+
+// PriorityFromContext returns the priority class of a request
+func PriorityFromContext(ctx context.Context) string {
+	if priority, ok := ctx.Value(priorityKey{}).(string); ok {
+		return priority
+	}
+	return PriorityInteractive
+}
+
+// WithPriority returns a copy of ctx carrying a priority class
+func WithPriority(ctx context.Context, priority string) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// errServerBusy is returned when a request waited too long for a slot
+var errServerBusy = errors.New("server busy")
+
+// PrioritySchedulerConfig configures a PriorityScheduler
+type PrioritySchedulerConfig struct {
+	MaxConcurrent      int               // Requests served at once
+	BatchMaxConcurrent int               // Slots batch requests may hold; the rest are kept for interactive ones
+	BatchRate          float64           // Batch requests started per second (0 = unlimited)
+	BatchBurst         int               // Batch requests that may start at once above BatchRate
+	QueueTimeout       time.Duration     // Longest a request waits for a slot
+	Identities         map[string]string // Identity -> priority of its requests (default: interactive)
+}
+
+// PriorityScheduler bounds how many requests are served at once and decides
+// which waiting request goes next: interactive requests always before batch
+// ones, and batch requests never hold more than BatchMaxConcurrent slots, so
+// an ingestion job can't starve a person's ls or cat. Batch requests are
+// also rate limited.
+type PriorityScheduler struct {
+	cfg PrioritySchedulerConfig
+	now func() time.Time
+
+	mu      sync.Mutex
+	running map[string]int
+	waiting map[string][]*priorityWaiter
+	tokens  float64   // Batch rate limit bucket
+	refill  time.Time // When tokens was last refilled
+}
+
+type priorityWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// NewPriorityScheduler creates a scheduler. BatchMaxConcurrent defaults to
+// a quarter of MaxConcurrent, BatchBurst to BatchRate and QueueTimeout to
+// 30s.
+func NewPriorityScheduler(cfg PrioritySchedulerConfig) (*PriorityScheduler, error) {
+	if cfg.MaxConcurrent <= 0 {
+		return nil, fmt.Errorf("max_concurrent must be positive")
+	}
+	if cfg.BatchMaxConcurrent == 0 {
+		cfg.BatchMaxConcurrent = max(1, cfg.MaxConcurrent/4)
+	}
+	if cfg.BatchMaxConcurrent < 0 || cfg.BatchMaxConcurrent > cfg.MaxConcurrent {
+		return nil, fmt.Errorf("batch_max_concurrent must be between 1 and max_concurrent (%d)", cfg.MaxConcurrent)
+	}
+	if cfg.BatchRate < 0 || cfg.BatchBurst < 0 {
+		return nil, fmt.Errorf("batch_rate and batch_burst must not be negative")
+	}
+	if cfg.BatchBurst == 0 {
+		cfg.BatchBurst = max(1, int(math.Ceil(cfg.BatchRate)))
+	}
+	if cfg.QueueTimeout <= 0 {
+		cfg.QueueTimeout = 30 * time.Second
+	}
+	for identity, priority := range cfg.Identities {
+		if _, err := ParsePriority(priority); err != nil {
+			return nil, fmt.Errorf("identity %s: %w", identity, err)
+		}
+	}
+	return &PriorityScheduler{
+		cfg:     cfg,
+		now:     time.Now,
+		running: make(map[string]int),
+		waiting: make(map[string][]*priorityWaiter),
+		tokens:  float64(cfg.BatchBurst),
+		refill:  time.Now(),
+	}, nil
+}
+
+// classify returns the priority of a request: that of its identity,
+// lowered by a PriorityHeader
+func (s *PriorityScheduler) classify(r *http.Request) (string, error) {
+	priority, err := ParsePriority(s.cfg.Identities[IdentityFromContext(r.Context())])
+	if err != nil {
+		return "", err
+	}
+	if header := r.Header.Get(PriorityHeader); header != "" {
+		requested, err := ParsePriority(header)
+		if err != nil {
+			return "", err
+		}
+		if requested == PriorityBatch {
+			priority = PriorityBatch
+		}
+	}
+	return priority, nil
+}
+
+// canRun reports whether a request of priority may take a slot now.
+// Called with s.mu held.
+func (s *PriorityScheduler) canRun(priority string) bool {
+	if s.running[PriorityInteractive]+s.running[PriorityBatch] >= s.cfg.MaxConcurrent {
+		return false
+	}
+	return priority == PriorityInteractive || s.running[PriorityBatch] < s.cfg.BatchMaxConcurrent
+}
+
+// takeBatchToken takes a token from the batch rate limit bucket, or returns
+// how long until one is available. Called with s.mu held.
+func (s *PriorityScheduler) takeBatchToken() (time.Duration, bool) {
+	if s.cfg.BatchRate <= 0 {
+		return 0, true
+	}
+	now := s.now()
+	s.tokens = math.Min(float64(s.cfg.BatchBurst), s.tokens+now.Sub(s.refill).Seconds()*s.cfg.BatchRate)
+	s.refill = now
+	if s.tokens >= 1 {
+		s.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - s.tokens) / s.cfg.BatchRate * float64(time.Second)), false
+}
+
+// dispatch hands free slots to waiting requests, interactive ones first.
+// Called with s.mu held.
+func (s *PriorityScheduler) dispatch() {
+	for _, priority := range []string{PriorityInteractive, PriorityBatch} {
+		for len(s.waiting[priority]) > 0 && s.canRun(priority) {
+			w := s.waiting[priority][0]
+			s.waiting[priority] = s.waiting[priority][1:]
+			s.running[priority]++
+			w.granted = true
+			close(w.ready)
+		}
+	}
+}
+
+// acquire waits for a slot for a request of priority. The returned function
+// gives the slot back.
+func (s *PriorityScheduler) acquire(ctx context.Context, priority string) (func(), error) {
+	release := func() {
+		s.mu.Lock()
+		s.running[priority]--
+		s.dispatch()
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	if priority == PriorityBatch {
+		if wait, ok := s.takeBatchToken(); !ok {
+			s.mu.Unlock()
+			return nil, filesystem.NewRateLimitedError("batch requests", wait)
+		}
+	}
+	if len(s.waiting[priority]) == 0 && s.canRun(priority) {
+		s.running[priority]++
+		s.mu.Unlock()
+		return release, nil
+	}
+	w := &priorityWaiter{ready: make(chan struct{})}
+	s.waiting[priority] = append(s.waiting[priority], w)
+	s.mu.Unlock()
+
+	timer := time.NewTimer(s.cfg.QueueTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+		return release, nil
+	case <-timer.C:
+		err = fmt.Errorf("%w: no slot for a %s request within %s", errServerBusy, priority, s.cfg.QueueTimeout)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.granted {
+		// Granted while giving up: pass the slot on
+		s.running[priority]--
+		s.dispatch()
+		return nil, err
+	}
+	queue := s.waiting[priority]
+	for i := range queue {
+		if queue[i] == w {
+			s.waiting[priority] = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	return nil, err
+}
+
+// isLongLived reports whether a request stays open for as long as the
+// client wants, such as watches and streamed reads. These would hold slots
+// indefinitely, so they are not scheduled.
+func isLongLived(r *http.Request) bool {
+	return r.URL.Path == "/api/v1/watch" || r.URL.Query().Get("stream") == "true"
+}
+
+// Middleware tags requests with their priority and serves them as slots
+// free up. Probes and long-lived requests are tagged but not scheduled.
+func (s *PriorityScheduler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		priority, err := s.classify(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		r = r.WithContext(WithPriority(r.Context(), priority))
+		if unrecordedEndpoints[r.URL.Path] || isLongLived(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		release, err := s.acquire(r.Context(), priority)
+		if err != nil {
+			var limited *filesystem.RateLimitedError
+			switch {
+			case errors.As(err, &limited):
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+				writeError(w, http.StatusTooManyRequests, err.Error())
+			case errors.Is(err, errServerBusy):
+				log.Warnf("[priority] %s %s: %v", r.Method, r.URL.Path, err)
+				w.Header().Set("Retry-After", "1")
+				writeError(w, http.StatusServiceUnavailable, err.Error())
+			}
+			// The client is gone otherwise
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
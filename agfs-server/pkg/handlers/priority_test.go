@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPriorityMiddlewareClassifies(t *testing.T) {
+	scheduler, err := NewPriorityScheduler(PrioritySchedulerConfig{
+		MaxConcurrent: 4,
+		Identities:    map[string]string{"ingest": PriorityBatch},
+	})
+	if err != nil {
+		t.Fatalf("NewPriorityScheduler() error = %v", err)
+	}
+	var seen string
+	handler := scheduler.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = PriorityFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		identity string
+		header   string
+		code     int
+		want     string
+	}{
+		{"", "", http.StatusOK, PriorityInteractive},
+		{"", "batch", http.StatusOK, PriorityBatch},
+		{"ingest", "", http.StatusOK, PriorityBatch},
+		{"ingest", "interactive", http.StatusOK, PriorityBatch}, // Can't raise
+		{"", "urgent", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		seen = ""
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/stat?path=/", nil)
+		req = req.WithContext(WithIdentity(req.Context(), tt.identity))
+		if tt.header != "" {
+			req.Header.Set(PriorityHeader, tt.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.code || seen != tt.want {
+			t.Errorf("identity %q, header %q: status %d, priority %q; want %d, %q", tt.identity, tt.header, rec.Code, seen, tt.code, tt.want)
+		}
+	}
+}
+
+func TestPrioritySchedulerServesInteractiveFirst(t *testing.T) {
+	scheduler, err := NewPriorityScheduler(PrioritySchedulerConfig{MaxConcurrent: 1})
+	if err != nil {
+		t.Fatalf("NewPriorityScheduler() error = %v", err)
+	}
+	ctx := context.Background()
+	release, err := scheduler.acquire(ctx, PriorityInteractive)
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	served := make(chan string, 2)
+	waitQueued := func(priority string) {
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			scheduler.mu.Lock()
+			n := len(scheduler.waiting[priority])
+			scheduler.mu.Unlock()
+			if n > 0 {
+				return
+			}
+		}
+		t.Fatalf("%s request was not queued", priority)
+	}
+	for _, priority := range []string{PriorityBatch, PriorityInteractive} {
+		go func(priority string) {
+			done, err := scheduler.acquire(ctx, priority)
+			if err != nil {
+				served <- err.Error()
+				return
+			}
+			served <- priority
+			done()
+		}(priority)
+		waitQueued(priority)
+	}
+
+	release()
+	if first, second := <-served, <-served; first != PriorityInteractive || second != PriorityBatch {
+		t.Errorf("served %s then %s, want interactive then batch", first, second)
+	}
+}
+
+func TestPrioritySchedulerBoundsBatch(t *testing.T) {
+	scheduler, err := NewPriorityScheduler(PrioritySchedulerConfig{
+		MaxConcurrent:      2,
+		BatchMaxConcurrent: 1,
+		QueueTimeout:       20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewPriorityScheduler() error = %v", err)
+	}
+	ctx := context.Background()
+	if _, err := scheduler.acquire(ctx, PriorityBatch); err != nil {
+		t.Fatalf("acquire(batch) error = %v", err)
+	}
+	if _, err := scheduler.acquire(ctx, PriorityBatch); !errors.Is(err, errServerBusy) {
+		t.Errorf("second acquire(batch) error = %v, want server busy", err)
+	}
+	// The slot batch requests may not take is kept for interactive ones
+	if _, err := scheduler.acquire(ctx, PriorityInteractive); err != nil {
+		t.Errorf("acquire(interactive) error = %v", err)
+	}
+	if n := len(scheduler.waiting[PriorityBatch]); n != 0 {
+		t.Errorf("%d batch requests still queued after timing out", n)
+	}
+}
+
+func TestPrioritySchedulerRateLimitsBatch(t *testing.T) {
+	scheduler, err := NewPriorityScheduler(PrioritySchedulerConfig{MaxConcurrent: 4, BatchRate: 0.5})
+	if err != nil {
+		t.Fatalf("NewPriorityScheduler() error = %v", err)
+	}
+	now := time.Now()
+	scheduler.now = func() time.Time { return now }
+	scheduler.refill = now
+	handler := scheduler.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(priority string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/stat?path=/", nil)
+		req.Header.Set(PriorityHeader, priority)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := send(PriorityBatch); rec.Code != http.StatusOK {
+		t.Fatalf("first batch request: status %d, want 200", rec.Code)
+	}
+	rec := send(PriorityBatch)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("second batch request: status %d, Retry-After %q; want 429, 2", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := send(PriorityInteractive); rec.Code != http.StatusOK {
+		t.Errorf("interactive request: status %d, want 200", rec.Code)
+	}
+	now = now.Add(2 * time.Second)
+	if rec := send(PriorityBatch); rec.Code != http.StatusOK {
+		t.Errorf("batch request after refill: status %d, want 200", rec.Code)
+	}
+}
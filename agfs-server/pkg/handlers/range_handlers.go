@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// rangeChunkSize is how much of a file is read at a time when serving it
+const rangeChunkSize = 1 << 20
+
+// serveFile serves a plain file with ETag and Last-Modified validators,
+// honoring conditional requests and a single byte range. It returns false,
+// having written nothing, for requests it leaves to ReadFile: paths that
+// are not plain files, and under v1 of the API those with offset or size
+// parameters. Files of queues and streams are never served this way,
+// because reading them may have side effects.
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, path string) bool {
+	query := r.URL.Query()
+	offset, size, sized := int64(0), int64(-1), query.Has("offset") || query.Has("size")
+	if sized {
+		if APIVersionFromContext(r.Context()) < APIVersion2 {
+			return false
+		}
+		var err error
+		if query.Has("offset") {
+			if offset, err = strconv.ParseInt(query.Get("offset"), 10, 64); err != nil || offset < 0 {
+				return false
+			}
+		}
+		if query.Has("size") {
+			if size, err = strconv.ParseInt(query.Get("size"), 10, 64); err != nil {
+				return false
+			}
+		}
+	}
+	info, err := h.fs.Stat(path)
+	if err != nil || info.IsDir {
+		return false
+	}
+	contentType, encoding := filesystem.DescribeContent(h.fs, path, info)
+	if encoding == "" {
+		return false
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	etag := fileETag(info)
+	modTime := info.ModTime.UTC().Truncate(time.Second)
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", h.cacheControl(path))
+	if !info.ModTime.IsZero() {
+		header.Set("Last-Modified", modTime.Format(http.TimeFormat))
+	}
+	header.Set("Accept-Ranges", "bytes")
+
+	switch checkPreconditions(r, etag, modTime) {
+	case http.StatusPreconditionFailed:
+		writeError(w, http.StatusPreconditionFailed, "precondition failed: "+path+" has changed")
+		return true
+	case http.StatusNotModified:
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+
+	start, length, status := int64(0), info.Size, http.StatusOK
+	if sized {
+		// A slice of the file, as v1 reads it whole
+		start = min(offset, info.Size)
+		if length = info.Size - start; size >= 0 && size < length {
+			length = size
+		}
+	} else if spec := r.Header.Get("Range"); spec != "" && ifRangeMatches(r, etag, modTime) {
+		var ok bool
+		start, length, ok = parseRange(spec, info.Size)
+		switch {
+		case !ok:
+			start, length = 0, info.Size
+		case length < 0:
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, fmt.Sprintf("range %q not satisfiable for %d bytes", spec, info.Size))
+			return true
+		default:
+			status = http.StatusPartialContent
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, info.Size))
+		}
+	}
+
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return true
+	}
+
+	for remaining := length; remaining > 0; {
+		data, err := h.read(r, path, start, min(remaining, rangeChunkSize))
+		if len(data) > 0 {
+			if _, werr := w.Write(data); werr != nil {
+				return true
+			}
+			if h.trafficMonitor != nil {
+				h.trafficMonitor.RecordRead(int64(len(data)))
+			}
+			start += int64(len(data))
+			remaining -= int64(len(data))
+		}
+		// The file shrank since Stat; the client sees a short body
+		if err != nil || len(data) == 0 {
+			break
+		}
+	}
+	return true
+}
+
+// fileETag derives a strong entity tag from a file's size and modification
+// time, so that computing it never requires reading the file
+func fileETag(info *filesystem.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime.UnixNano(), info.Size)
+}
+
+// checkPreconditions evaluates the conditional headers of r in the order
+// RFC 9110 gives, returning 412, 304, or 200 to serve the request
+func checkPreconditions(r *http.Request, etag string, modTime time.Time) int {
+	if match := r.Header.Get("If-Match"); match != "" {
+		if !etagMatches(match, etag) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && modTime.After(since) {
+		return http.StatusPreconditionFailed
+	}
+
+	if noneMatch := r.Header.Get("If-None-Match"); noneMatch != "" {
+		if etagMatches(noneMatch, etag) {
+			return http.StatusNotModified
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modTime.After(since) {
+		return http.StatusNotModified
+	}
+	return http.StatusOK
+}
+
+// etagMatches reports whether a list of entity tags (or "*") includes etag.
+// Weak tags match their strong counterparts, as If-None-Match requires.
+func etagMatches(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifRangeMatches reports whether a Range header should be honored: always
+// without If-Range, otherwise only if the file is unchanged
+func ifRangeMatches(r *http.Request, etag string, modTime time.Time) bool {
+	ifRange := r.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == etag
+	}
+	since, err := http.ParseTime(ifRange)
+	return err == nil && modTime.Equal(since)
+}
+
+// parseRange parses a single "bytes=" range for a file of size bytes. It
+// returns ok=false for headers to ignore, such as multiple ranges, and a
+// negative length for ranges that cannot be satisfied.
+func parseRange(spec string, size int64) (start, length int64, ok bool) {
+	spec, found := strings.CutPrefix(spec, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		if n == 0 || size == 0 {
+			return 0, -1, true
+		}
+		n = min(n, size)
+		return size - n, n, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return 0, -1, true
+	}
+	return start, end - start + 1, true
+}
+
+// headFile answers HEAD for files serveFile does not handle, from Stat
+// alone so that nothing is read
+func (h *Handler) headFile(w http.ResponseWriter, path string) {
+	info, err := h.fs.Stat(path)
+	if err != nil {
+		w.WriteHeader(mapErrorToStatus(err))
+		return
+	}
+	if info.IsDir {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", h.responseContentType(path))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestReadFileRanges(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	fs.Write("/video.txt", []byte("0123456789"), -1, filesystem.WriteFlagCreate)
+	h := NewHandler(fs, nil)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+
+	get := func(method string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/files?path=/video.txt", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(http.MethodGet, nil)
+	etag := rec.Header().Get("ETag")
+	lastModified := rec.Header().Get("Last-Modified")
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" || etag == "" || lastModified == "" || rec.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("GET: status %d, body %q, headers %v", rec.Code, rec.Body, rec.Header())
+	}
+
+	tests := []struct {
+		headers map[string]string
+		status  int
+		body    string
+		content string
+	}{
+		{map[string]string{"Range": "bytes=2-4"}, http.StatusPartialContent, "234", "bytes 2-4/10"},
+		{map[string]string{"Range": "bytes=7-"}, http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{map[string]string{"Range": "bytes=-3"}, http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{map[string]string{"Range": "bytes=5-100"}, http.StatusPartialContent, "56789", "bytes 5-9/10"},
+		{map[string]string{"Range": "bytes=10-"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{map[string]string{"Range": "bytes=0-1,4-5"}, http.StatusOK, "0123456789", ""},
+		{map[string]string{"Range": "bytes=2-4", "If-Range": `"stale"`}, http.StatusOK, "0123456789", ""},
+		{map[string]string{"Range": "bytes=2-4", "If-Range": etag}, http.StatusPartialContent, "234", "bytes 2-4/10"},
+		{map[string]string{"If-None-Match": etag}, http.StatusNotModified, "", ""},
+		{map[string]string{"If-None-Match": `"other"`}, http.StatusOK, "0123456789", ""},
+		{map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified, "", ""},
+		{map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed, "", ""},
+		{map[string]string{"If-Match": etag}, http.StatusOK, "0123456789", ""},
+	}
+	for _, tt := range tests {
+		rec := get(http.MethodGet, tt.headers)
+		if rec.Code != tt.status {
+			t.Errorf("GET with %v: status %d, want %d", tt.headers, rec.Code, tt.status)
+			continue
+		}
+		if tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("GET with %v: body %q, want %q", tt.headers, rec.Body, tt.body)
+		}
+		if got := rec.Header().Get("Content-Range"); got != tt.content {
+			t.Errorf("GET with %v: Content-Range %q, want %q", tt.headers, got, tt.content)
+		}
+	}
+
+	rec = get(http.MethodHead, nil)
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("Content-Length") != "10" {
+		t.Errorf("HEAD: status %d, body %q, Content-Length %q", rec.Code, rec.Body, rec.Header().Get("Content-Length"))
+	}
+
+	fs.Write("/video.txt", []byte("changed!!!!"), -1, filesystem.WriteFlagTruncate)
+	if rec := get(http.MethodGet, map[string]string{"If-None-Match": etag}); rec.Code != http.StatusOK {
+		t.Errorf("GET after a change: status %d, want 200", rec.Code)
+	}
+}
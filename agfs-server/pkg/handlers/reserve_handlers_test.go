@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/localfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestCreateFileWithSize(t *testing.T) {
+	local, err := localfs.NewLocalFS(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalFS() error = %v", err)
+	}
+	for name, fs := range map[string]filesystem.FileSystem{"localfs": local, "memfs": memfs.NewMemoryFS()} {
+		h := NewHandler(fs, nil)
+		mux := http.NewServeMux()
+		h.SetupRoutes(mux)
+		post := func(target string) int {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+			return rec.Code
+		}
+
+		for _, size := range []string{"abc", "-1"} {
+			if code := post("/api/v1/files?path=/big.bin&size=" + size); code != http.StatusBadRequest {
+				t.Errorf("%s: create with size %s: status %d, want 400", name, size, code)
+			}
+		}
+		if code := post("/api/v1/files?path=/big.bin&size=4096"); code != http.StatusCreated {
+			t.Fatalf("%s: create with size: status %d, want 201", name, code)
+		}
+		info, err := fs.Stat("/big.bin")
+		if err != nil || info.Size != 0 {
+			t.Fatalf("%s: Stat() of the reserved file = %+v, %v", name, info, err)
+		}
+		if _, isReserver := fs.(filesystem.Reserver); isReserver && info.Meta.Content[filesystem.MetaReservedSize] != "4096" {
+			t.Errorf("%s: reserved file metadata = %v, want its size hint", name, info.Meta.Content)
+		}
+		if code := post("/api/v1/files?path=/big.bin&size=4096"); code == http.StatusCreated {
+			t.Errorf("%s: reserving an existing file succeeded", name)
+		}
+	}
+}
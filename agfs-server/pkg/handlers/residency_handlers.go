@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/residency"
+	log "github.com/sirupsen/logrus"
+)
+
+// DataRegionHeader tags the data of a write with the region it must be
+// stored in, e.g. "X-AGFS-Data-Region: eu-west"
+const DataRegionHeader = "X-AGFS-Data-Region"
+
+// ResidencyResponse describes the residency policy and its recent violations
+type ResidencyResponse struct {
+	Regions    []residency.Pin       `json:"regions"`
+	Rules      []residency.Rule      `json:"rules"`
+	Violations []residency.Violation `json:"violations"`
+}
+
+// SetResidency enables data residency rules
+func (h *Handler) SetResidency(policy *residency.Policy) {
+	h.residency = policy
+}
+
+// ResidencyMiddleware rejects writes that residency rules, or the client's
+// DataRegionHeader, require to be stored in another region than that of
+// their path, with 403 Forbidden. Removals are always allowed, and renames
+// are checked at their destination only, so misplaced data can be moved
+// out. It also serves GET /residency.
+func (h *Handler) ResidencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.residency == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == "/api/v1/residency" {
+			h.Residency(w, r)
+			return
+		}
+
+		paths, body, err := h.writtenPaths(w, r)
+		if err != nil {
+			writeRequestBodyError(w, err, h.maxRequestBodyBytes, "invalid request body")
+			return
+		}
+		if body != nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		identity := IdentityFromContext(r.Context())
+		for _, p := range paths {
+			if err := h.residency.Check(h.residencyWrite(r, p)); err != nil {
+				log.Warnf("[residency] Rejected %s %s by %q: %v", r.Method, r.URL.Path, identity, err)
+				writeError(w, http.StatusForbidden, err.Error())
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// residencyWrite describes the write of r to p for the residency policy,
+// with the path symbolic links lead p to, where the data lands
+func (h *Handler) residencyWrite(r *http.Request, p string) residency.Write {
+	write := residency.Write{
+		Identity: IdentityFromContext(r.Context()),
+		Method:   r.Method,
+		Endpoint: strings.TrimPrefix(r.URL.Path, "/api/v1"),
+		Path:     p,
+		Region:   r.Header.Get(DataRegionHeader),
+	}
+	if resolver, ok := filesystem.As[filesystem.PathResolver](h.fs); ok {
+		if resolved, err := resolver.ResolvePath(p); err == nil {
+			write.Stored = resolved
+		}
+	}
+	return write
+}
+
+// writtenPaths returns the paths a request stores data at, and its body if
+// it had to be read to find them
+func (h *Handler) writtenPaths(w http.ResponseWriter, r *http.Request) ([]string, []byte, error) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodDelete || readOnlyPostEndpoints[r.URL.Path] {
+		return nil, nil, nil
+	}
+	query := r.URL.Query()
+	if r.URL.Path == "/api/v1/handles/open" {
+		flags, err := parseOpenFlags(query.Get("flags"))
+		writes := filesystem.O_WRONLY | filesystem.O_RDWR | filesystem.O_APPEND | filesystem.O_CREATE | filesystem.O_TRUNC
+		if err != nil || flags&writes == 0 {
+			return nil, nil, nil
+		}
+	}
+	// Renames and batches name the paths they write in their body
+	if r.URL.Path != "/api/v1/rename" && r.URL.Path != "/api/v1/batch" {
+		if p := query.Get("path"); p != "" {
+			return []string{p}, nil, nil
+		}
+		return nil, nil, nil
+	}
+
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxRequestBodyBytes))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	var req struct {
+		NewPath string           `json:"newPath"`
+		Ops     []BatchOpRequest `json:"ops"`
+	}
+	if len(body) > 0 {
+		// Malformed bodies are left for the handler to reject
+		json.Unmarshal(body, &req)
+	}
+	var paths []string
+	if req.NewPath != "" {
+		paths = append(paths, req.NewPath)
+	}
+	for _, op := range req.Ops {
+		switch {
+		case op.Op == "rename" && op.NewPath != "":
+			paths = append(paths, op.NewPath)
+		case op.Op != "remove" && op.Op != "rename" && op.Path != "":
+			paths = append(paths, op.Path)
+		}
+	}
+	return paths, body, nil
+}
+
+// Residency handles GET /residency
+func (h *Handler) Residency(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, ResidencyResponse{
+		Regions:    h.residency.Pins(),
+		Rules:      h.residency.Rules(),
+		Violations: h.residency.Violations(),
+	})
+}
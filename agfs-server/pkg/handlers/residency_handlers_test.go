@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/residency"
+)
+
+func TestResidencyMiddleware(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	for _, dir := range []string{"/s3-eu", "/s3-eu/customers", "/s3-eu/customers/eu", "/s3-us", "/s3-us/customers", "/s3-us/customers/eu"} {
+		fs.Mkdir(dir, 0755)
+	}
+	h := NewHandler(fs, nil)
+	policy, err := residency.NewPolicy(residency.Config{
+		Regions: map[string]string{"/s3-eu": "eu-west", "/s3-us": "us-east"},
+		Rules:   []residency.Rule{{Name: "eu-customers", Paths: []string{"/**/customers/eu/**"}, Region: "eu-west"}},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	h.SetResidency(policy)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := h.ResidencyMiddleware(mux)
+
+	do := func(method, target, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPut, "/api/v1/files?path=/s3-eu/customers/eu/a.json", "{}"); rec.Code != http.StatusOK {
+		t.Fatalf("write in region: status %d %s", rec.Code, rec.Body.String())
+	}
+	rec := do(http.MethodPut, "/api/v1/files?path=/s3-us/customers/eu/b.json", "{}")
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "write it under /s3-eu") {
+		t.Fatalf("write out of region: status %d %s", rec.Code, rec.Body.String())
+	}
+	if _, err := fs.Stat("/s3-us/customers/eu/b.json"); err == nil {
+		t.Error("rejected write was stored")
+	}
+	if rec := do(http.MethodPut, "/api/v1/files?path=/s3-us/notes.txt", "x", DataRegionHeader, "eu-west"); rec.Code != http.StatusForbidden {
+		t.Errorf("tagged write out of region: status %d", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/api/v1/files?path=/s3-us/notes.txt", "x"); rec.Code != http.StatusOK {
+		t.Errorf("untagged write: status %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Renames are checked at their destination, batches op by op
+	if rec := do(http.MethodPost, "/api/v1/rename?path=/s3-eu/customers/eu/a.json", `{"newPath": "/s3-us/customers/eu/a.json"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("rename out of region: status %d", rec.Code)
+	}
+	fs.Write("/s3-us/customers/eu/legacy.json", []byte("{}"), -1, filesystem.WriteFlagCreate)
+	if rec := do(http.MethodPost, "/api/v1/rename?path=/s3-us/customers/eu/legacy.json", `{"newPath": "/s3-eu/customers/eu/legacy.json"}`); rec.Code != http.StatusOK {
+		t.Errorf("rename into region: status %d %s", rec.Code, rec.Body.String())
+	}
+	batch := `{"ops": [{"op": "write", "path": "/s3-us/ok.txt", "data": "x"}, {"op": "write", "path": "/s3-us/customers/eu/c.json", "data": "{}"}]}`
+	if rec := do(http.MethodPost, "/api/v1/batch", batch); rec.Code != http.StatusForbidden {
+		t.Errorf("batch out of region: status %d", rec.Code)
+	}
+	if _, err := fs.Stat("/s3-us/ok.txt"); err == nil {
+		t.Error("batch with a violation was applied")
+	}
+	if rec := do(http.MethodDelete, "/api/v1/files?path=/s3-us/notes.txt", ""); rec.Code != http.StatusOK {
+		t.Errorf("remove: status %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Dry runs report the rejection without recording it
+	dryRun := h.DryRunMiddleware(mux)
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/files?path=/s3-us/customers/eu/d.json&dry_run=true", strings.NewReader("{}"))
+	rec = httptest.NewRecorder()
+	dryRun.ServeHTTP(rec, req)
+	var preview filesystem.DryRunResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &preview); err != nil || preview.Allowed || !strings.Contains(rec.Body.String(), `"residency"`) {
+		t.Errorf("dry run write out of region = %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = do(http.MethodGet, "/api/v1/residency", "")
+	var resp ResidencyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /residency = %d %s", rec.Code, rec.Body.String())
+	}
+	if len(resp.Regions) != 2 || len(resp.Rules) != 1 || len(resp.Violations) != 4 {
+		t.Fatalf("GET /residency = %+v", resp)
+	}
+	if v := resp.Violations[0]; v.Endpoint != "/batch" || v.Path != "/s3-us/customers/eu/c.json" || v.Required != "eu-west" || v.Region != "us-east" {
+		t.Errorf("latest violation = %+v", v)
+	}
+	if v := resp.Violations[2]; v.Rule != "client tag" || v.Method != http.MethodPut {
+		t.Errorf("tagged violation = %+v", v)
+	}
+}
+
+func TestResidencySymlinks(t *testing.T) {
+	root := mountablefs.NewMountableFS(api.PoolConfig{})
+	for _, mount := range []string{"/s3-eu", "/s3-us"} {
+		p := memfs.NewMemFSPlugin()
+		p.Initialize(map[string]interface{}{})
+		root.Mount(mount, p)
+	}
+	root.Mkdir("/s3-us/dump", 0755)
+	root.Symlink("/s3-us/dump", "/s3-eu/link")
+	h := NewHandler(root, nil)
+	policy, err := residency.NewPolicy(residency.Config{
+		Regions: map[string]string{"/s3-eu": "eu-west", "/s3-us": "us-east"},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	h.SetResidency(policy)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := h.DryRunMiddleware(h.ResidencyMiddleware(mux))
+
+	do := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, target, strings.NewReader("{}"))
+		req.Header.Set(DataRegionHeader, "eu-west")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A link in the EU mount does not take EU data to the US one
+	if rec := do("/api/v1/files?path=/s3-eu/link/pii.json"); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "/s3-us/dump/pii.json") {
+		t.Errorf("write through a cross-region link: status %d %s", rec.Code, rec.Body.String())
+	}
+	if _, err := root.Stat("/s3-us/dump/pii.json"); err == nil {
+		t.Error("rejected write was stored")
+	}
+	rec := do("/api/v1/files?path=/s3-eu/link/pii.json&dry_run=true")
+	var preview filesystem.DryRunResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &preview); err != nil || preview.Allowed {
+		t.Errorf("dry run through a cross-region link = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do("/api/v1/files?path=/s3-eu/pii.json"); rec.Code != http.StatusOK {
+		t.Errorf("write in region: status %d %s", rec.Code, rec.Body.String())
+	}
+	if v := policy.Violations(); len(v) != 1 || v[0].Stored != "/s3-us/dump/pii.json" {
+		t.Errorf("Violations() = %+v", v)
+	}
+
+	// An upload is checked again when committed, with the region it was
+	// opened with, after a link has replaced its directory
+	root.Mkdir("/s3-eu/moved", 0755)
+	begin := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/uploads?path=/s3-eu/moved/pii.json&part_size=%d", filesystem.MinUploadPartSize), nil)
+	begin.Header.Set(DataRegionHeader, "eu-west")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, begin)
+	var upload UploadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &upload); err != nil || upload.ID == "" {
+		t.Fatalf("begin upload = %d %s", rec.Code, rec.Body.String())
+	}
+	root.Remove("/s3-eu/moved")
+	root.Symlink("/s3-us/dump", "/s3-eu/moved")
+	commit := httptest.NewRecorder()
+	server.ServeHTTP(commit, httptest.NewRequest(http.MethodPost, "/api/v1/uploads/"+upload.ID+"/commit", nil))
+	if commit.Code != http.StatusForbidden || !strings.Contains(commit.Body.String(), "/s3-us/dump/pii.json") {
+		t.Errorf("commit through a cross-region link: status %d %s", commit.Code, commit.Body.String())
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeletedResponse lists the soft-deleted entries of a directory
+type DeletedResponse struct {
+	Path    string                    `json:"path"`
+	Deleted []filesystem.DeletedEntry `json:"deleted"`
+}
+
+// ListDeleted handles GET /deleted?path=<dir>. It lists the entries of a
+// directory that were removed but are still kept, hidden, by their file
+// system, and when each is removed for good.
+func (h *Handler) ListDeleted(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	p = filesystem.NormalizePath(p)
+
+	restorer, ok := filesystem.As[filesystem.Restorer](h.fs)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "file system does not keep deleted entries")
+		return
+	}
+	deleted, err := restorer.ListDeleted(p)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	if deleted == nil {
+		deleted = []filesystem.DeletedEntry{}
+	}
+	writeJSON(w, http.StatusOK, DeletedResponse{Path: p, Deleted: deleted})
+}
+
+// Restore handles POST /restore?path=<path>. It brings back a soft-deleted
+// entry before its retention period ends. It is an admin endpoint.
+func (h *Handler) Restore(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	p = filesystem.NormalizePath(p)
+
+	restorer, ok := filesystem.As[filesystem.Restorer](h.fs)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "file system does not keep deleted entries")
+		return
+	}
+	if err := restorer.Restore(p); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	log.Infof("[restore] Restored %s (by %q)", p, IdentityFromContext(r.Context()))
+	writeJSON(w, http.StatusOK, SuccessResponse{Message: "restored"})
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// trashFS keeps removed entries of its root until they are restored
+type trashFS struct {
+	filesystem.FileSystem
+	deleted map[string]time.Time
+}
+
+func (t *trashFS) ListDeleted(path string) ([]filesystem.DeletedEntry, error) {
+	var entries []filesystem.DeletedEntry
+	for name, at := range t.deleted {
+		entries = append(entries, filesystem.DeletedEntry{Name: name, DeletedAt: at, PurgeAt: at.Add(time.Hour)})
+	}
+	return entries, nil
+}
+
+func (t *trashFS) Restore(path string) error {
+	name := path[1:]
+	if _, ok := t.deleted[name]; !ok {
+		return filesystem.NewNotFoundError("restore", path)
+	}
+	delete(t.deleted, name)
+	return t.Mkdir(path, 0755)
+}
+
+func TestRestoreHandlers(t *testing.T) {
+	root := mountablefs.NewMountableFS(api.PoolConfig{})
+	p := memfs.NewMemFSPlugin()
+	p.Initialize(map[string]interface{}{})
+	if err := root.Mount("/plain", p); err != nil {
+		t.Fatalf("Mount() error = %v", err)
+	}
+	fs := &trashFS{
+		FileSystem: memfs.NewMemoryFS(),
+		deleted:    map[string]time.Time{"project": time.Now()},
+	}
+	h := NewHandler(root, nil)
+
+	do := func(handler http.HandlerFunc, method, query string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(method, "/api/v1/x?"+query, nil))
+		return rec
+	}
+
+	// Mounts that don't soft-delete say so
+	if rec := do(h.ListDeleted, http.MethodGet, "path=/plain"); rec.Code != http.StatusNotImplemented {
+		t.Errorf("ListDeleted(/plain) status = %d, want 501", rec.Code)
+	}
+	if rec := do(h.Restore, http.MethodPost, "path=/plain/x"); rec.Code != http.StatusNotImplemented {
+		t.Errorf("Restore(/plain/x) status = %d, want 501", rec.Code)
+	}
+	if rec := do(h.Restore, http.MethodPost, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Restore() without path status = %d, want 400", rec.Code)
+	}
+
+	h = NewHandler(fs, nil)
+	rec := do(h.ListDeleted, http.MethodGet, "path=/")
+	var resp DeletedResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK ||
+		len(resp.Deleted) != 1 || resp.Deleted[0].Name != "project" {
+		t.Fatalf("ListDeleted(/) = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(h.Restore, http.MethodPost, "path=/project"); rec.Code != http.StatusOK {
+		t.Errorf("Restore(/project) status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := fs.Stat("/project"); err != nil {
+		t.Errorf("Stat() of restored directory error = %v", err)
+	}
+	if rec := do(h.Restore, http.MethodPost, "path=/project"); rec.Code != http.StatusNotFound {
+		t.Errorf("second Restore(/project) status = %d, want 404", rec.Code)
+	}
+	rec = do(h.ListDeleted, http.MethodGet, "path=/")
+	if rec.Code != http.StatusOK || rec.Body.String() != "{\"path\":\"/\",\"deleted\":[]}\n" {
+		t.Errorf("ListDeleted(/) after restore = %d %q", rec.Code, rec.Body.String())
+	}
+}
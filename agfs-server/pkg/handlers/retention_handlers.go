@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// RetentionResponse is the retention of a path: the one set on it, and the
+// one in effect, which includes what it inherits from its directories
+type RetentionResponse struct {
+	Path        string               `json:"path"`
+	RetainUntil *time.Time           `json:"retain_until,omitempty"`
+	LegalHold   bool                 `json:"legal_hold"`
+	Effective   filesystem.Retention `json:"effective"`
+	Protected   bool                 `json:"protected"` // Deletes and overwrites are refused
+}
+
+// Retention handles /retention?path=<path>. GET reports the retention of
+// the path. PUT, an admin endpoint, changes it with retain_until=<RFC3339>
+// or retain_for=<duration>, and legal_hold=true|false; what is omitted is
+// kept.
+func (h *Handler) Retention(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	p = filesystem.NormalizePath(p)
+
+	reporter, ok := filesystem.As[filesystem.RetentionReporter](h.fs)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "file system does not support retention")
+		return
+	}
+	if r.Method == http.MethodPut {
+		if !h.setRetention(w, r, p, reporter) {
+			return
+		}
+	}
+
+	own, effective, err := reporter.GetRetention(p)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	response := RetentionResponse{
+		Path:      p,
+		LegalHold: own.LegalHold,
+		Effective: effective,
+		Protected: effective.Active(time.Now()),
+	}
+	if !own.RetainUntil.IsZero() {
+		response.RetainUntil = &own.RetainUntil
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// setRetention applies the PUT parameters to the retention set on p,
+// writing the error response if it fails
+func (h *Handler) setRetention(w http.ResponseWriter, r *http.Request, p string, reporter filesystem.RetentionReporter) bool {
+	retainer, ok := filesystem.As[filesystem.Retainer](h.fs)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "file system does not support retention")
+		return false
+	}
+	query := r.URL.Query()
+	until, forStr, holdStr := query.Get("retain_until"), query.Get("retain_for"), query.Get("legal_hold")
+	if until == "" && forStr == "" && holdStr == "" {
+		writeError(w, http.StatusBadRequest, "retain_until, retain_for or legal_hold parameter is required")
+		return false
+	}
+	if until != "" && forStr != "" {
+		writeError(w, http.StatusBadRequest, "retain_until and retain_for cannot be combined")
+		return false
+	}
+
+	retention, _, err := reporter.GetRetention(p)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return false
+	}
+	if until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "retain_until must be an RFC 3339 time")
+			return false
+		}
+		retention.RetainUntil = t
+	}
+	if forStr != "" {
+		d, err := filesystem.ParseTTL(forStr)
+		if err != nil || d == 0 {
+			writeError(w, http.StatusBadRequest, "retain_for must be a positive duration (e.g. 720h) or number of seconds")
+			return false
+		}
+		retention.RetainUntil = time.Now().Add(d)
+	}
+	if holdStr != "" {
+		hold, err := strconv.ParseBool(holdStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "legal_hold must be true or false")
+			return false
+		}
+		retention.LegalHold = hold
+	}
+
+	if err := retainer.SetRetention(p, retention); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return false
+	}
+	log.Infof("[retention] Set retention of %s to %s, legal hold %t (by %q)",
+		p, retention.RetainUntil.UTC().Format(time.RFC3339), retention.LegalHold, IdentityFromContext(r.Context()))
+	return true
+}
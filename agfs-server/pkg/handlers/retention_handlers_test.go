@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestRetentionHandler(t *testing.T) {
+	root := mountablefs.NewMountableFS(api.PoolConfig{})
+	p := memfs.NewMemFSPlugin()
+	p.Initialize(map[string]interface{}{})
+	if err := root.MountWithOptions("/data", p, mountablefs.MountOptions{Retention: true}); err != nil {
+		t.Fatalf("MountWithOptions() error = %v", err)
+	}
+	defer root.Unmount("/data")
+	if _, err := root.Write("/data/a.txt", []byte("a"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(root, nil)
+
+	do := func(method, query string) (*httptest.ResponseRecorder, RetentionResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.Retention(rec, httptest.NewRequest(method, "/api/v1/retention?"+query, nil))
+		var resp RetentionResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	if rec, resp := do(http.MethodGet, "path=/data/a.txt"); rec.Code != http.StatusOK || resp.Protected || resp.RetainUntil != nil {
+		t.Fatalf("GET before retention = %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec, resp := do(http.MethodPut, "path=/data/a.txt&retain_for=24h")
+	if rec.Code != http.StatusOK || !resp.Protected || resp.RetainUntil == nil || resp.RetainUntil.Before(time.Now().Add(23*time.Hour)) {
+		t.Fatalf("PUT retain_for = %d %s", rec.Code, rec.Body.String())
+	}
+	until := *resp.RetainUntil
+
+	// Omitted values are kept
+	rec, resp = do(http.MethodPut, "path=/data/a.txt&legal_hold=true")
+	if rec.Code != http.StatusOK || !resp.LegalHold || resp.RetainUntil == nil || !resp.RetainUntil.Equal(until) {
+		t.Fatalf("PUT legal_hold = %d %s", rec.Code, rec.Body.String())
+	}
+	if err := root.Remove("/data/a.txt"); err == nil {
+		t.Fatal("Remove() of a held file should fail")
+	}
+
+	// Shortening is refused
+	shorter := until.Add(-time.Hour).UTC().Format(time.RFC3339)
+	if rec, _ := do(http.MethodPut, "path=/data/a.txt&retain_until="+shorter); rec.Code != http.StatusForbidden {
+		t.Errorf("PUT shorter retain_until status = %d, want 403: %s", rec.Code, rec.Body.String())
+	}
+
+	for _, query := range []string{
+		"path=/data/a.txt",
+		"path=/data/a.txt&retain_until=tomorrow",
+		"path=/data/a.txt&retain_for=0",
+		"path=/data/a.txt&legal_hold=maybe",
+		"path=/data/a.txt&retain_for=1h&retain_until=" + shorter,
+		"retain_for=1h",
+	} {
+		if rec, _ := do(http.MethodPut, query); rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s status = %d, want 400", query, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/sessions"
+	log "github.com/sirupsen/logrus"
+)
+
+// SessionHeader names the session a request is recorded into
+const SessionHeader = "X-AGFS-Session"
+
+// unrecordedEndpoints are probes and server metadata, not file system activity
+var unrecordedEndpoints = map[string]bool{
+	"/api/v1/health":       true,
+	"/api/v1/ready":        true,
+	"/api/v1/version":      true,
+	"/api/v1/capabilities": true,
+}
+
+// SetSessions enables session recording
+func (h *Handler) SetSessions(recorder *sessions.Recorder) {
+	h.sessions = recorder
+}
+
+// SessionMiddleware records requests that belong to a session: those sent
+// with an X-AGFS-Session header and those of identities configured to be
+// recorded. Writes are recorded with a diff of the file they changed.
+// Requests for the session store itself are not recorded.
+func (h *Handler) SessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.sessions == nil || !strings.HasPrefix(r.URL.Path, "/api/v1/") || unrecordedEndpoints[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		identity := IdentityFromContext(r.Context())
+		session, err := h.sessions.Session(identity, r.Header.Get(SessionHeader))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		path := r.URL.Query().Get("path")
+		if session == "" || (path != "" && isWithin(filesystem.NormalizePath(path), h.sessions.Path())) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit := h.sessions.MaxContent()
+		var change *sessions.Change
+		if path != "" && changesContent(r) {
+			if before, ok := h.captureContent(path, limit); ok {
+				change = &sessions.Change{Path: path, Before: before}
+			}
+		}
+		var body *capturingBody
+		if r.Body != nil && r.Body != http.NoBody {
+			body = &capturingBody{ReadCloser: r.Body, limit: limit}
+			r.Body = body
+		}
+		rw := &recordingWriter{ResponseWriter: w}
+
+		start := time.Now()
+		next.ServeHTTP(rw, r)
+
+		entry := sessions.Entry{
+			Time:       start,
+			Identity:   identity,
+			Method:     r.Method,
+			Endpoint:   strings.TrimPrefix(r.URL.Path, "/api/v1"),
+			Query:      r.URL.RawQuery,
+			Header:     replayHeaders(r.Header),
+			Status:     rw.statusCode(),
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			BytesOut:   rw.written,
+			Error:      rw.errorMessage(),
+		}
+		var data []byte
+		if body != nil {
+			entry.BytesIn = body.read
+			entry.BodyTruncated = body.read > limit
+			data = body.data
+		}
+		if change != nil {
+			after, ok := h.captureContent(path, limit)
+			if entry.Status >= http.StatusMultipleChoices || !ok {
+				change = nil
+			} else {
+				change.After = after
+			}
+		}
+		if err := h.sessions.Record(session, entry, data, change); err != nil {
+			log.Warnf("failed to record request in session %s: %v", session, err)
+		}
+	})
+}
+
+// isWithin reports whether p is dir or below it
+func isWithin(p, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+// changesContent reports whether r rewrites the file named by its path
+func changesContent(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/api/v1/files":
+		return r.Method == http.MethodPut
+	case "/api/v1/write", "/api/v1/truncate":
+		return r.Method == http.MethodPost
+	}
+	return false
+}
+
+// captureContent returns the content of the file at path for a diff: nil if
+// it does not exist, and false if it cannot be diffed. Only plain storage,
+// whose content is described by sniffing, is read; reading the files of
+// other file systems (queues, streams) may have side effects.
+func (h *Handler) captureContent(path string, limit int64) ([]byte, bool) {
+	info, err := h.fs.Stat(path)
+	if err != nil {
+		return nil, true
+	}
+	if info.IsDir || info.Size > limit {
+		return nil, false
+	}
+	if _, encoding := filesystem.DescribeContent(h.fs, path, info); encoding == "" {
+		return nil, false
+	}
+	data, err := h.fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, false
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return data, true
+}
+
+// replayHeaders returns the request headers that change what a request does
+func replayHeaders(header http.Header) map[string]string {
+	var kept map[string]string
+	for name, values := range header {
+		if len(values) == 0 {
+			continue
+		}
+		if name == "Content-Type" || (strings.HasPrefix(name, "X-Agfs-") && name != "X-Agfs-Session") {
+			if kept == nil {
+				kept = make(map[string]string)
+			}
+			kept[name] = values[0]
+		}
+	}
+	return kept
+}
+
+// capturingBody keeps up to limit bytes of a request body as it is read
+type capturingBody struct {
+	io.ReadCloser
+	limit int64
+	data  []byte
+	read  int64
+}
+
+func (b *capturingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if b.read+int64(n) <= b.limit {
+			b.data = append(b.data, p[:n]...)
+		} else {
+			b.data = nil
+		}
+		b.read += int64(n)
+	}
+	return n, err
+}
+
+// maxRecordedError bounds the error response kept for a failed request
+const maxRecordedError = 4096
+
+// recordingWriter notes the status and size of a response, and the body of
+// error responses
+type recordingWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+	errBody []byte
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= http.StatusBadRequest && len(w.errBody) < maxRecordedError {
+		w.errBody = append(w.errBody, p[:min(len(p), maxRecordedError-len(w.errBody))]...)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses streaming
+func (w *recordingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *recordingWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// errorMessage returns the message of an error response
+func (w *recordingWriter) errorMessage() string {
+	if len(w.errBody) == 0 {
+		return ""
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.errBody, &resp); err == nil && resp.Error != "" {
+		return resp.Error
+	}
+	return strings.TrimSpace(string(w.errBody))
+}
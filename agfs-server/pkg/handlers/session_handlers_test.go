@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/sessions"
+)
+
+func TestSessionMiddleware(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	fs.Write("/notes.txt", []byte("one\ntwo\n"), -1, filesystem.WriteFlagCreate)
+	recorder, err := sessions.NewRecorder(fs, sessions.Config{})
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+	h := NewHandler(fs, nil)
+	h.SetSessions(recorder)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := h.SessionMiddleware(mux)
+
+	do := func(method, target, body, session string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if session != "" {
+			req.Header.Set(SessionHeader, session)
+		}
+		req.Header.Set(TTLHeader, "1h")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	do(http.MethodGet, "/api/v1/stat?path=/notes.txt", "", "")
+	do(http.MethodGet, "/api/v1/files?path=/notes.txt", "", "s1")
+	do(http.MethodPut, "/api/v1/files?path=/notes.txt", "one\n2\n", "s1")
+	do(http.MethodGet, "/api/v1/files?path=/missing", "", "s1")
+	do(http.MethodGet, "/api/v1/health", "", "s1")
+	do(http.MethodGet, "/api/v1/files?path=/sessions/s1/trace.ndjson", "", "s1")
+	if code := do(http.MethodGet, "/api/v1/stat?path=/", "", "bad/id"); code != http.StatusBadRequest {
+		t.Errorf("invalid session id: status %d, want 400", code)
+	}
+
+	entries, err := sessions.ReadTrace(fs, "/sessions/s1")
+	if err != nil {
+		t.Fatalf("ReadTrace() error = %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("recorded %d requests, want 3: %+v", len(entries), entries)
+	}
+
+	write := entries[1]
+	if write.Method != http.MethodPut || write.Endpoint != "/files" || write.Query != "path=/notes.txt" {
+		t.Errorf("write entry = %+v", write)
+	}
+	if write.Header["X-Agfs-Ttl"] != "1h" || write.Header["X-Agfs-Session"] != "" {
+		t.Errorf("write headers = %v, want TTL kept and session dropped", write.Header)
+	}
+	if body, _ := fs.Read("/sessions/s1/"+write.Body, 0, -1); string(body) != "one\n2\n" {
+		t.Errorf("recorded body = %q", body)
+	}
+	diff, _ := fs.Read("/sessions/s1/"+write.Diff, 0, -1)
+	if !strings.Contains(string(diff), "-two\n+2\n") {
+		t.Errorf("recorded diff = %q", diff)
+	}
+
+	if failed := entries[2]; failed.Status < http.StatusBadRequest || failed.Error == "" {
+		t.Errorf("failed read entry = %+v, want an error status and message", failed)
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// SnapshotListResponse represents the snapshots of a mount
+type SnapshotListResponse struct {
+	Snapshots []filesystem.Snapshot `json:"snapshots"`
+}
+
+// Snapshots handles /snapshots?path=<mount>:
+//
+//	POST   takes a snapshot; it appears under <mount>/.snapshots/<id>/
+//	GET    lists the snapshots of the mount
+//	DELETE &id=<id> discards a snapshot
+func (h *Handler) Snapshots(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	snapshotter, ok := h.fs.(filesystem.Snapshotter)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "snapshots not supported")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		snap, err := snapshotter.CreateSnapshot(path)
+		if err != nil {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, snap)
+	case http.MethodGet:
+		snapshots, err := snapshotter.ListSnapshots(path)
+		if err != nil {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, SnapshotListResponse{Snapshots: snapshots})
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			writeError(w, http.StatusBadRequest, "id parameter is required")
+			return
+		}
+		if err := snapshotter.DeleteSnapshot(path, id); err != nil {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, SuccessResponse{Message: "snapshot deleted"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
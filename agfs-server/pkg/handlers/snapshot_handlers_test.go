@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestSnapshotsHandler(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	fs.Write("/a.txt", []byte("a"), -1, filesystem.WriteFlagCreate)
+	h := NewHandler(fs, nil)
+
+	rec := httptest.NewRecorder()
+	h.Snapshots(rec, httptest.NewRequest(http.MethodPost, "/api/v1/snapshots?path=/", nil))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status %d: %s", rec.Code, rec.Body.String())
+	}
+	var snap filesystem.Snapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &snap); err != nil || snap.ID == "" {
+		t.Fatalf("invalid snapshot %q: %v", rec.Body.String(), err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.Snapshots(rec, httptest.NewRequest(http.MethodGet, "/api/v1/snapshots?path=/", nil))
+	var list SnapshotListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list.Snapshots) != 1 || list.Snapshots[0].ID != snap.ID {
+		t.Fatalf("list = %q, %v", rec.Body.String(), err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.Snapshots(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/snapshots?path=/&id="+snap.ID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete status %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	h.Snapshots(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/snapshots?path=/&id="+snap.ID, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("second delete status %d, want 404", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.Snapshots(rec, httptest.NewRequest(http.MethodPost, "/api/v1/snapshots?path=/a.txt", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("snapshot of a file: status %d, want 400", rec.Code)
+	}
+}
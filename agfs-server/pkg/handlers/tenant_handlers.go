@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+// TenantMiddleware rejects requests whose path parameter is in an entry
+// owned by another identity, on file systems that scope their entries to
+// the identities that created them (vectorfs with tenant_isolation). Paths
+// in request bodies are checked by the handlers through authorizePath.
+func (h *Handler) TenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path := r.URL.Query().Get("path"); path != "" {
+			if !h.authorizeTenant(w, r, path, requestRecursive(r)) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorizeTenant checks that the caller may access path under tenant
+// scoping, writing an error and returning false if not
+func (h *Handler) authorizeTenant(w http.ResponseWriter, r *http.Request, path string, recursive bool) bool {
+	scoper, ok := filesystem.As[filesystem.TenantScoper](h.fs)
+	if !ok {
+		return true
+	}
+	for _, p := range h.authorizedPaths(path) {
+		if err := scoper.AuthorizeTenant(IdentityFromContext(r.Context()), p, recursive); err != nil {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// filterTenant drops the entries of the directory at path that the caller
+// may not see
+func (h *Handler) filterTenant(r *http.Request, path string, entries []filesystem.FileInfo) []filesystem.FileInfo {
+	scoper, ok := filesystem.As[filesystem.TenantScoper](h.fs)
+	if !ok {
+		return entries
+	}
+	return scoper.FilterTenant(IdentityFromContext(r.Context()), path, entries)
+}
+
+// filterTenantResults drops the search results in entries the caller may
+// not access, such as other tenants' namespaces found by a search of all
+// of them
+func (h *Handler) filterTenantResults(r *http.Request, results []mountablefs.CustomGrepResult) []mountablefs.CustomGrepResult {
+	scoper, ok := filesystem.As[filesystem.TenantScoper](h.fs)
+	if !ok {
+		return results
+	}
+	identity := IdentityFromContext(r.Context())
+	kept := results[:0]
+	for _, result := range results {
+		if scoper.AuthorizeTenant(identity, result.File, false) == nil {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
+
+// mkdir creates the directory at path, owned by the caller on file systems
+// that scope their entries
+func (h *Handler) mkdir(r *http.Request, path string, perm uint32) error {
+	identity := IdentityFromContext(r.Context())
+	if scoper, ok := filesystem.As[filesystem.TenantScoper](h.fs); ok && identity != "" {
+		return scoper.MkdirAs(identity, path, perm)
+	}
+	return h.fs.Mkdir(path, perm)
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// tenantFS owns the top-level directories of a memory file system by the
+// identity that created them
+type tenantFS struct {
+	filesystem.FileSystem
+	mu     sync.Mutex
+	owners map[string]string
+}
+
+func (fs *tenantFS) owner(path string) string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	top, _, _ := strings.Cut(strings.TrimPrefix(filesystem.NormalizePath(path), "/"), "/")
+	return fs.owners[top]
+}
+
+func (fs *tenantFS) AuthorizeTenant(identity, path string, recursive bool) error {
+	if path == "/" && recursive {
+		return filesystem.NewPermissionDeniedError("access", path, "contains other tenants' entries")
+	}
+	if owner := fs.owner(path); owner != "" && owner != identity {
+		return filesystem.NewPermissionDeniedError("access", path, "entry of another tenant")
+	}
+	return nil
+}
+
+func (fs *tenantFS) FilterTenant(identity, path string, entries []filesystem.FileInfo) []filesystem.FileInfo {
+	var visible []filesystem.FileInfo
+	for _, e := range entries {
+		if owner := fs.owner("/" + e.Name); path != "/" || owner == "" || owner == identity {
+			visible = append(visible, e)
+		}
+	}
+	return visible
+}
+
+func (fs *tenantFS) MkdirAs(identity, path string, perm uint32) error {
+	if err := fs.Mkdir(path, perm); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.owners[strings.TrimPrefix(path, "/")] = identity
+	return nil
+}
+
+// CustomGrep finds query in the file named after it in every directory
+func (fs *tenantFS) CustomGrep(path, query string, limit int) ([]mountablefs.CustomGrepResult, error) {
+	var results []mountablefs.CustomGrepResult
+	entries, _ := fs.ReadDir("/")
+	for _, e := range entries {
+		if _, err := fs.Stat("/" + e.Name + "/" + query); err == nil {
+			results = append(results, mountablefs.CustomGrepResult{File: "/" + e.Name + "/" + query, Content: query})
+		}
+	}
+	return results, nil
+}
+
+type tenantPlugin struct {
+	*memfs.MemFSPlugin
+	fs *tenantFS
+}
+
+func (p *tenantPlugin) GetFileSystem() filesystem.FileSystem { return p.fs }
+
+func TestTenantScoping(t *testing.T) {
+	root := mountablefs.NewMountableFS(api.PoolConfig{})
+	for _, mount := range []struct {
+		path   string
+		config map[string]interface{}
+	}{
+		{"/t", map[string]interface{}{}},
+		{"/ro", map[string]interface{}{mountablefs.MiddlewareConfigKey: []interface{}{"readonly"}}},
+	} {
+		opts, rest, err := mountablefs.ParseMountOptions(mount.config)
+		if err != nil {
+			t.Fatalf("ParseMountOptions() error = %v", err)
+		}
+		p := &tenantPlugin{MemFSPlugin: memfs.NewMemFSPlugin()}
+		p.Initialize(rest)
+		p.fs = &tenantFS{FileSystem: p.MemFSPlugin.GetFileSystem(), owners: make(map[string]string)}
+		if err := root.MountWithOptions(mount.path, p, opts); err != nil {
+			t.Fatalf("MountWithOptions() error = %v", err)
+		}
+	}
+	root.Mkdir("/t/shared", 0755)
+	root.Write("/t/shared/cats", []byte("cats"), -1, filesystem.WriteFlagCreate)
+
+	h := NewHandler(root, nil)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := NewAuthenticator(map[string]string{"a": "alice", "b": "bob"}, false).Middleware(h.TenantMiddleware(mux))
+	do := func(method, target, token, body string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, body := do(http.MethodPost, "/api/v1/directories?path=/t/alice", "a", ""); code != http.StatusCreated {
+		t.Fatalf("mkdir /t/alice = %d %s", code, body)
+	}
+	if code, body := do(http.MethodPut, "/api/v1/files?path=/t/alice/cats", "a", "cats"); code != http.StatusOK {
+		t.Fatalf("write /t/alice/cats = %d %s", code, body)
+	}
+	if code, body := do(http.MethodPost, "/api/v1/directories?path=/t/bob", "b", ""); code != http.StatusCreated {
+		t.Fatalf("mkdir /t/bob = %d %s", code, body)
+	}
+	if err := root.Symlink("../alice", "/t/bob/old"); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+
+	tests := []struct {
+		method, target, token, body string
+		want                        int
+	}{
+		{http.MethodGet, "/api/v1/files?path=/t/alice/cats", "a", "", http.StatusOK},
+		{http.MethodGet, "/api/v1/files?path=/t/alice/cats", "b", "", http.StatusForbidden},
+		{http.MethodGet, "/api/v1/files?path=/t/alice/cats", "", "", http.StatusForbidden},
+		{http.MethodGet, "/api/v1/files?path=/t/shared/cats", "b", "", http.StatusOK},
+		{http.MethodPost, "/api/v1/rename?path=/t/shared/cats", "b", `{"newPath":"/t/alice/dogs"}`, http.StatusForbidden},
+		{http.MethodGet, "/api/v1/find?path=/t&name=cats", "b", "", http.StatusForbidden},
+		{http.MethodGet, "/api/v1/find?path=/&name=cats", "b", "", http.StatusForbidden},
+		{http.MethodPost, "/api/v1/directories?path=/ro/bob", "b", "", http.StatusForbidden},
+		// Links are checked where they lead, relative targets from the link
+		{http.MethodPost, "/api/v1/symlink?path=/t/bob/peek", "b", `{"target":"../alice"}`, http.StatusForbidden},
+		{http.MethodGet, "/api/v1/files?path=/t/bob/old/cats", "b", "", http.StatusForbidden},
+		{http.MethodPut, "/api/v1/files?path=/t/bob/old/planted", "b", "x", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if code, body := do(tt.method, tt.target, tt.token, tt.body); code != tt.want {
+			t.Errorf("%s %s (token %q) = %d %s, want %d", tt.method, tt.target, tt.token, code, body, tt.want)
+		}
+	}
+
+	// Dry runs are scoped too, and a denied preview lists nothing
+	dryRun := h.DryRunMiddleware(http.NotFoundHandler())
+	for identity, allowed := range map[string]bool{"alice": true, "bob": false} {
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/files?path=/t/alice&recursive=true&dry_run=true", nil)
+		req = req.WithContext(WithIdentity(req.Context(), identity))
+		rec := httptest.NewRecorder()
+		dryRun.ServeHTTP(rec, req)
+		var result filesystem.DryRunResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		if result.Allowed != allowed || (!allowed && (len(result.Effects) != 0 || strings.Contains(rec.Body.String(), "cats"))) {
+			t.Errorf("%s: dry run remove of /t/alice = %d %s, want allowed=%v", identity, rec.Code, rec.Body.String(), allowed)
+		}
+	}
+
+	list := func(token string) []string {
+		t.Helper()
+		code, body := do(http.MethodGet, "/api/v1/directories?path=/t", token, "")
+		if code != http.StatusOK {
+			t.Fatalf("list /t = %d %s", code, body)
+		}
+		var resp ListResponse
+		json.Unmarshal([]byte(body), &resp)
+		var names []string
+		for _, f := range resp.Files {
+			names = append(names, f.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	if got := strings.Join(list("a"), ","); got != "README,alice,shared" {
+		t.Errorf("alice lists %q", got)
+	}
+	if got := strings.Join(list("b"), ","); got != "README,bob,shared" {
+		t.Errorf("bob lists %q", got)
+	}
+
+	grep := func(token string) int {
+		t.Helper()
+		code, body := do(http.MethodPost, "/api/v1/grep", token, `{"path":"/t","pattern":"cats"}`)
+		if code != http.StatusOK {
+			t.Fatalf("grep = %d %s", code, body)
+		}
+		var resp GrepResponse
+		json.Unmarshal([]byte(body), &resp)
+		return resp.Count
+	}
+	if got := grep("a"); got != 2 {
+		t.Errorf("alice finds %d results, want 2", got)
+	}
+	if got := grep("b"); got != 1 {
+		t.Errorf("bob finds %d results, want 1", got)
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// TTLHeader sets a TTL on PUT /files, e.g. "10m" or "3600" (seconds)
+const TTLHeader = "X-AGFS-TTL"
+
+// TTLResponse represents the result of setting a TTL
+type TTLResponse struct {
+	Path      string     `json:"path"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Omitted when the TTL was cleared
+}
+
+// SetTTL handles PUT /files/ttl?path=<path>&ttl=<duration|seconds>
+// A ttl of 0 clears the expiry.
+func (h *Handler) SetTTL(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	ttlStr := r.URL.Query().Get("ttl")
+	if ttlStr == "" {
+		writeError(w, http.StatusBadRequest, "ttl parameter is required")
+		return
+	}
+	ttl, err := filesystem.ParseTTL(ttlStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.setExpiry(path, ttl); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	response := TTLResponse{Path: path}
+	if info, err := h.fs.Stat(path); err == nil && !info.ExpiresAt.IsZero() {
+		response.ExpiresAt = &info.ExpiresAt
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// setExpiry applies ttl to path; 0 clears the expiry
+func (h *Handler) setExpiry(path string, ttl time.Duration) error {
+	expirer, ok := h.fs.(filesystem.Expirer)
+	if !ok {
+		return filesystem.ErrNotSupported
+	}
+	return expirer.SetExpiry(path, filesystem.ExpiresAt(ttl))
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func statResponse(t *testing.T, h *Handler, path string) (int, FileInfoResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.Stat(rec, httptest.NewRequest(http.MethodGet, "/api/v1/stat?path="+path, nil))
+	var resp FileInfoResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestWriteFileWithTTLHeader(t *testing.T) {
+	h := NewHandler(memfs.NewMemoryFS(), nil)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/files?path=/scratch.txt", strings.NewReader("tmp"))
+	req.Header.Set(TTLHeader, "1h")
+	rec := httptest.NewRecorder()
+	h.WriteFile(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	code, info := statResponse(t, h, "/scratch.txt")
+	if code != http.StatusOK {
+		t.Fatalf("stat status %d", code)
+	}
+	if info.ExpiresAt == nil {
+		t.Fatal("expires_at missing from stat response")
+	}
+	if d := time.Until(*info.ExpiresAt); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("expires_at %v is not about an hour away", info.ExpiresAt)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/api/v1/files?path=/bad.txt", strings.NewReader("x"))
+	req.Header.Set(TTLHeader, "soon")
+	rec = httptest.NewRecorder()
+	h.WriteFile(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid TTL: status %d, want 400", rec.Code)
+	}
+}
+
+func TestSetTTL(t *testing.T) {
+	h := NewHandler(memfs.NewMemoryFS(), nil)
+	rec := httptest.NewRecorder()
+	h.WriteFile(rec, httptest.NewRequest(http.MethodPut, "/api/v1/files?path=/f.txt", strings.NewReader("data")))
+
+	rec = httptest.NewRecorder()
+	h.SetTTL(rec, httptest.NewRequest(http.MethodPut, "/api/v1/files/ttl?path=/f.txt&ttl=600", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp TTLResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+	}
+	if resp.ExpiresAt == nil {
+		t.Fatal("expires_at missing after setting TTL")
+	}
+
+	// ttl=0 clears the expiry
+	rec = httptest.NewRecorder()
+	h.SetTTL(rec, httptest.NewRequest(http.MethodPut, "/api/v1/files/ttl?path=/f.txt&ttl=0", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, info := statResponse(t, h, "/f.txt"); info.ExpiresAt != nil {
+		t.Errorf("expires_at = %v after clearing TTL", info.ExpiresAt)
+	}
+
+	// A file past its TTL disappears
+	rec = httptest.NewRecorder()
+	h.SetTTL(rec, httptest.NewRequest(http.MethodPut, "/api/v1/files/ttl?path=/f.txt&ttl=1ns", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	if code, _ := statResponse(t, h, "/f.txt"); code == http.StatusOK {
+		t.Error("expired file is still visible")
+	}
+
+	rec = httptest.NewRecorder()
+	h.SetTTL(rec, httptest.NewRequest(http.MethodPut, "/api/v1/files/ttl?path=/f.txt", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("missing ttl: status %d, want 400", rec.Code)
+	}
+}
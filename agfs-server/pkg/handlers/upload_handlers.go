@@ -0,0 +1,460 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/residency"
+	log "github.com/sirupsen/logrus"
+)
+
+// UploadIdleTimeout is how long an upload may go without a request before
+// it is aborted
+const UploadIdleTimeout = time.Hour
+
+// UploadResponse describes an upload
+type UploadResponse struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	PartSize  int64     `json:"part_size"`
+	Parts     []int     `json:"parts"`      // Indexes of the parts written, in order
+	Size      int64     `json:"size"`       // Bytes written
+	ExpiresAt time.Time `json:"expires_at"` // When the upload is aborted unless used
+}
+
+// UploadPartResponse represents the response for a part write
+type UploadPartResponse struct {
+	Part   int   `json:"part"`
+	Offset int64 `json:"offset"`
+	Size   int   `json:"size"`
+}
+
+// UploadCommitResponse represents the response for a commit
+type UploadCommitResponse struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// uploadSession is an upload opened through the API. Parts are written
+// concurrently, holding ops for reading; commit and abort hold it for
+// writing, so they wait for the writes in flight.
+type uploadSession struct {
+	id       string
+	path     string
+	owner    string
+	region   string // DataRegionHeader of the request opening the upload
+	partSize int64
+	upload   filesystem.Upload
+
+	ops sync.RWMutex
+
+	mu         sync.Mutex
+	parts      map[int]int64 // Part index to length
+	lastAccess time.Time
+	done       bool // Committed or aborted
+}
+
+func (s *uploadSession) touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastAccess = time.Now()
+}
+
+func (s *uploadSession) response() UploadResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := UploadResponse{
+		ID:        s.id,
+		Path:      s.path,
+		PartSize:  s.partSize,
+		Parts:     make([]int, 0, len(s.parts)),
+		ExpiresAt: s.lastAccess.Add(UploadIdleTimeout),
+	}
+	for i, n := range s.parts {
+		resp.Parts = append(resp.Parts, i)
+		resp.Size += n
+	}
+	sort.Ints(resp.Parts)
+	return resp
+}
+
+// size checks that the parts written make up a file, every part but the
+// last being full, and returns its size
+func (s *uploadSession) size() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var size int64
+	for i := 0; i < len(s.parts); i++ {
+		n, ok := s.parts[i]
+		if !ok {
+			return 0, fmt.Errorf("part %d (offset %d) is missing", i, int64(i)*s.partSize)
+		}
+		if n < s.partSize && i < len(s.parts)-1 {
+			return 0, fmt.Errorf("part %d (offset %d) is %d bytes, only the last part may be shorter than %d",
+				i, int64(i)*s.partSize, n, s.partSize)
+		}
+		size += n
+	}
+	return size, nil
+}
+
+// uploadSessions holds the open uploads. Idle uploads are aborted when
+// uploads are next opened or looked up.
+type uploadSessions struct {
+	mu      sync.Mutex
+	entries map[string]*uploadSession
+}
+
+func newUploadSessions() *uploadSessions {
+	return &uploadSessions{entries: make(map[string]*uploadSession)}
+}
+
+func (u *uploadSessions) add(s *uploadSession) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.expire()
+	u.entries[s.id] = s
+}
+
+// get returns the upload id opened by owner, if it is still open
+func (u *uploadSessions) get(id, owner string) *uploadSession {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.expire()
+	s := u.entries[id]
+	if s == nil || s.owner != owner {
+		return nil
+	}
+	return s
+}
+
+func (u *uploadSessions) remove(id string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.entries, id)
+}
+
+// expire aborts the idle uploads. The caller holds u.mu.
+func (u *uploadSessions) expire() {
+	cutoff := time.Now().Add(-UploadIdleTimeout)
+	for id, s := range u.entries {
+		s.mu.Lock()
+		idle := s.lastAccess.Before(cutoff)
+		s.mu.Unlock()
+		if !idle {
+			continue
+		}
+		delete(u.entries, id)
+		go func() {
+			if err := s.finish(noCheck, s.upload.Abort); err != nil {
+				log.Warnf("[handler] Failed to abort idle upload %s of %s: %v", s.id, s.path, err)
+			}
+		}()
+	}
+}
+
+// finish commits or aborts the upload with op, once the part writes in
+// flight are done. check runs first, and leaves the upload open if it
+// fails. Only the first call to get past check runs op.
+func (s *uploadSession) finish(check func() error, op func() error) error {
+	s.ops.Lock()
+	defer s.ops.Unlock()
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+	if done {
+		return filesystem.NewNotFoundError("upload", s.id)
+	}
+	if err := check(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.done = true
+	s.mu.Unlock()
+	return op()
+}
+
+// noCheck lets finish go ahead unconditionally
+func noCheck() error { return nil }
+
+// BeginUpload handles POST /uploads?path=<path>&part_size=<bytes>
+func (h *Handler) BeginUpload(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	partSize := int64(filesystem.DefaultUploadPartSize)
+	if s := r.URL.Query().Get("part_size"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid part_size parameter")
+			return
+		}
+		partSize = n
+	}
+	if limit := normalizeMaxRequestBodyBytes(h.maxRequestBodyBytes); partSize > limit {
+		writeError(w, http.StatusBadRequest, filesystem.NewInvalidArgumentError("part_size", partSize,
+			fmt.Sprintf("exceeds the maximum request body size of %d bytes", limit)).Error())
+		return
+	}
+
+	upload, err := filesystem.BeginUpload(h.fs, path, partSize)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	s := &uploadSession{
+		id:         hex.EncodeToString(id),
+		path:       path,
+		owner:      IdentityFromContext(r.Context()),
+		region:     r.Header.Get(DataRegionHeader),
+		partSize:   partSize,
+		upload:     upload,
+		parts:      make(map[int]int64),
+		lastAccess: time.Now(),
+	}
+	h.uploads.add(s)
+	log.Debugf("[handler] BeginUpload: id=%s, path=%s, part_size=%d", s.id, path, partSize)
+
+	writeJSON(w, http.StatusOK, s.response())
+}
+
+// lookupUpload returns the upload id of the caller, writing a 404 if there
+// is none
+func (h *Handler) lookupUpload(w http.ResponseWriter, r *http.Request, id string) *uploadSession {
+	s := h.uploads.get(id, IdentityFromContext(r.Context()))
+	if s == nil {
+		writeError(w, http.StatusNotFound, filesystem.NewNotFoundError("upload", id).Error())
+		return nil
+	}
+	s.touch()
+	return s
+}
+
+// authorizeUpload checks that the caller may still write the file of s,
+// writing an error and returning false if not. The middlewares only see
+// the path of the request opening the upload, and the rules or the
+// symbolic links along the path may have changed since.
+func (h *Handler) authorizeUpload(w http.ResponseWriter, r *http.Request, s *uploadSession) bool {
+	if err := r.Context().Err(); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return false
+	}
+	if !h.authorizePath(w, r, s.path, false) {
+		return false
+	}
+	if _, err := h.uploadDenied(s); err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return false
+	}
+	if h.residency != nil {
+		if err := h.residency.Check(h.uploadResidencyWrite(r, s)); err != nil {
+			log.Warnf("[residency] Rejected %s %s by %q: %v", r.Method, r.URL.Path, s.owner, err)
+			writeError(w, http.StatusForbidden, err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// uploadDenied returns why the file of s cannot be written through an
+// upload at all, and the policy deciding it: the rules file and protected
+// paths are written with PUT /files, which validates the rules or holds the
+// write for approval
+func (h *Handler) uploadDenied(s *uploadSession) (string, error) {
+	if h.acl != nil && h.leadsToACLFile(s.path) {
+		return "acl", filesystem.NewPermissionDeniedError("upload", s.path, "the access control list can only be replaced as a whole with PUT /files")
+	}
+	if h.approvals != nil && h.approvals.Protected(s.path) {
+		return "approval", filesystem.NewPermissionDeniedError("upload", s.path, "protected paths cannot be uploaded to; write them with PUT /files to request approval")
+	}
+	return "", nil
+}
+
+// uploadResidencyWrite describes a write to the file of s for the
+// residency policy, tagged with the region the upload was opened with
+// unless r sets its own
+func (h *Handler) uploadResidencyWrite(r *http.Request, s *uploadSession) residency.Write {
+	write := h.residencyWrite(r, s.path)
+	if write.Region == "" {
+		write.Region = s.region
+	}
+	return write
+}
+
+// GetUpload handles GET /uploads/<id>
+func (h *Handler) GetUpload(w http.ResponseWriter, r *http.Request, id string) {
+	s := h.lookupUpload(w, r, id)
+	if s == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.response())
+}
+
+// WriteUploadPart handles PUT /uploads/<id>?offset=<offset>. The offset is a
+// multiple of the part size, and the body at most a part long. Parts may be
+// written concurrently and in any order; writing a part again replaces it.
+func (h *Handler) WriteUploadPart(w http.ResponseWriter, r *http.Request, id string) {
+	s := h.lookupUpload(w, r, id)
+	if s == nil {
+		return
+	}
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "offset parameter is required and must be a non-negative number")
+		return
+	}
+	if !h.authorizeUpload(w, r, s) {
+		return
+	}
+	if offset%s.partSize != 0 {
+		writeError(w, http.StatusBadRequest, filesystem.NewInvalidArgumentError("offset", offset,
+			fmt.Sprintf("must be a multiple of the part size %d", s.partSize)).Error())
+		return
+	}
+	part := int(offset / s.partSize)
+	if part >= filesystem.MaxUploadParts {
+		writeError(w, http.StatusBadRequest, filesystem.NewInvalidArgumentError("offset", offset,
+			fmt.Sprintf("uploads have at most %d parts", filesystem.MaxUploadParts)).Error())
+		return
+	}
+
+	data, err := readLimitedRequestBody(w, r, s.partSize)
+	if err != nil {
+		writeRequestBodyError(w, err, s.partSize, "failed to read request body")
+		return
+	}
+	if h.trafficMonitor != nil && len(data) > 0 {
+		h.trafficMonitor.RecordWrite(int64(len(data)))
+	}
+	if !checkBodyChecksums(w, r, data) {
+		return
+	}
+
+	s.ops.RLock()
+	defer s.ops.RUnlock()
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+	if done {
+		writeError(w, http.StatusNotFound, filesystem.NewNotFoundError("upload", id).Error())
+		return
+	}
+	if err := s.upload.WritePart(part, data); err != nil {
+		log.Errorf("[handler] WriteUploadPart failed: id=%s, part=%d, err=%v", id, part, err)
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	s.mu.Lock()
+	s.parts[part] = int64(len(data))
+	s.lastAccess = time.Now()
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, UploadPartResponse{Part: part, Offset: offset, Size: len(data)})
+}
+
+// CommitUpload handles POST /uploads/<id>/commit. The parts written must
+// cover the file from offset 0, every part but the last being full; if they
+// don't, the upload stays open for the missing parts. The file appears,
+// whole, when the commit succeeds. A failed commit aborts the upload.
+func (h *Handler) CommitUpload(w http.ResponseWriter, r *http.Request, id string) {
+	s := h.lookupUpload(w, r, id)
+	if s == nil || !h.authorizeUpload(w, r, s) {
+		return
+	}
+	var size int64
+	check := func() error {
+		var err error
+		if size, err = s.size(); err != nil {
+			return filesystem.NewInvalidArgumentError("parts", id, err.Error())
+		}
+		return nil
+	}
+	committing := false
+	err := s.finish(check, func() error {
+		committing = true
+		return s.upload.Commit(size)
+	})
+	if err != nil && !committing {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	h.uploads.remove(id)
+	if err != nil {
+		log.Errorf("[handler] CommitUpload failed: id=%s, path=%s, err=%v", id, s.path, err)
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	log.Debugf("[handler] CommitUpload: id=%s, path=%s, size=%d", id, s.path, size)
+	writeJSON(w, http.StatusOK, UploadCommitResponse{Path: s.path, Size: size})
+}
+
+// AbortUpload handles DELETE /uploads/<id>
+func (h *Handler) AbortUpload(w http.ResponseWriter, r *http.Request, id string) {
+	s := h.lookupUpload(w, r, id)
+	if s == nil {
+		return
+	}
+	err := s.finish(noCheck, s.upload.Abort)
+	h.uploads.remove(id)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, SuccessResponse{Message: "upload aborted"})
+}
+
+// SetupUploadRoutes sets up routes for uploads assembled from parts
+func (h *Handler) SetupUploadRoutes(mux *http.ServeMux) {
+	// POST /api/v1/uploads - Begin an upload
+	mux.HandleFunc("/api/v1/uploads", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.BeginUpload(w, r)
+	})
+
+	// Operations on specific uploads: /api/v1/uploads/<id> or
+	// /api/v1/uploads/<id>/commit
+	mux.HandleFunc("/api/v1/uploads/", func(w http.ResponseWriter, r *http.Request) {
+		id, operation, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/uploads/"), "/")
+		if id == "" {
+			writeError(w, http.StatusBadRequest, "upload ID required")
+			return
+		}
+
+		switch operation {
+		case "":
+			switch r.Method {
+			case http.MethodGet:
+				h.GetUpload(w, r, id)
+			case http.MethodPut:
+				h.WriteUploadPart(w, r, id)
+			case http.MethodDelete:
+				h.AbortUpload(w, r, id)
+			default:
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			}
+		case "commit":
+			if r.Method != http.MethodPost {
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			h.CommitUpload(w, r, id)
+		default:
+			writeError(w, http.StatusNotFound, fmt.Sprintf("unknown operation: %s", operation))
+		}
+	})
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/acl"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/approvals"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/localfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestUploadParts(t *testing.T) {
+	local, err := localfs.NewLocalFS(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalFS() error = %v", err)
+	}
+	const partSize = filesystem.MinUploadPartSize
+	content := bytes.Repeat([]byte("0123456789abcdef"), partSize*3/16+100)
+
+	for name, fs := range map[string]filesystem.FileSystem{"localfs": local, "memfs": memfs.NewMemoryFS()} {
+		h := NewHandler(fs, nil)
+		mux := http.NewServeMux()
+		h.SetupRoutes(mux)
+		do := func(method, target string, body []byte) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(method, target, bytes.NewReader(body)))
+			return rec
+		}
+
+		if rec := do(http.MethodPost, "/api/v1/uploads?path=/big.bin&part_size=100", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: begin with a tiny part size: status %d, want 400", name, rec.Code)
+		}
+		rec := do(http.MethodPost, fmt.Sprintf("/api/v1/uploads?path=/big.bin&part_size=%d", partSize), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: begin: status %d: %s", name, rec.Code, rec.Body.String())
+		}
+		var upload UploadResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &upload); err != nil || upload.ID == "" {
+			t.Fatalf("%s: begin response %s: %v", name, rec.Body.String(), err)
+		}
+		target := "/api/v1/uploads/" + upload.ID
+
+		if rec := do(http.MethodPut, target+"?offset=10", []byte("x")); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: part at an unaligned offset: status %d, want 400", name, rec.Code)
+		}
+		if rec := do(http.MethodPut, target+"?offset=0", make([]byte, partSize+1)); rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: part longer than the part size: status %d, want 413", name, rec.Code)
+		}
+
+		// The parts, last first and concurrently
+		var wg sync.WaitGroup
+		for start := len(content) - len(content)%partSize; start >= 0; start -= partSize {
+			wg.Add(1)
+			go func(start int) {
+				defer wg.Done()
+				part := content[start:min(start+partSize, len(content))]
+				if rec := do(http.MethodPut, fmt.Sprintf("%s?offset=%d", target, start), part); rec.Code != http.StatusOK {
+					t.Errorf("%s: part at %d: status %d: %s", name, start, rec.Code, rec.Body.String())
+				}
+			}(start)
+		}
+		wg.Wait()
+		if _, err := fs.Stat("/big.bin"); err == nil {
+			t.Errorf("%s: the file exists before the upload is committed", name)
+		}
+
+		rec = do(http.MethodGet, target, nil)
+		if err := json.Unmarshal(rec.Body.Bytes(), &upload); err != nil || len(upload.Parts) != 4 || upload.Size != int64(len(content)) {
+			t.Errorf("%s: upload status = %s, want 4 parts of %d bytes", name, rec.Body.String(), len(content))
+		}
+
+		if rec := do(http.MethodPost, target+"/commit", nil); rec.Code != http.StatusOK {
+			t.Fatalf("%s: commit: status %d: %s", name, rec.Code, rec.Body.String())
+		}
+		got, err := fs.Read("/big.bin", 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatalf("%s: Read() error = %v", name, err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("%s: committed file is %d bytes, want %d", name, len(got), len(content))
+		}
+		if rec := do(http.MethodGet, target, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: committed upload: status %d, want 404", name, rec.Code)
+		}
+	}
+}
+
+func TestUploadCommitChecksParts(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	h := NewHandler(fs, nil)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	do := func(r *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, r)
+		return rec
+	}
+	const partSize = filesystem.MinUploadPartSize
+
+	rec := do(httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/uploads?path=/f&part_size=%d", partSize), nil))
+	var upload UploadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &upload); err != nil {
+		t.Fatalf("begin response %s: %v", rec.Body.String(), err)
+	}
+	target := "/api/v1/uploads/" + upload.ID
+
+	// A short part followed by another, then a gap
+	do(httptest.NewRequest(http.MethodPut, target+"?offset=0", strings.NewReader("short")))
+	do(httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s?offset=%d", target, partSize), strings.NewReader("more")))
+	if rec := do(httptest.NewRequest(http.MethodPost, target+"/commit", nil)); rec.Code != http.StatusBadRequest {
+		t.Errorf("commit with a short part that is not last: status %d, want 400", rec.Code)
+	}
+	do(httptest.NewRequest(http.MethodPut, target+"?offset=0", bytes.NewReader(make([]byte, partSize))))
+	do(httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s?offset=%d", target, partSize), bytes.NewReader(make([]byte, partSize))))
+	do(httptest.NewRequest(http.MethodPut, fmt.Sprintf("%s?offset=%d", target, 3*partSize), strings.NewReader("end")))
+	if rec := do(httptest.NewRequest(http.MethodPost, target+"/commit", nil)); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "part 2") {
+		t.Errorf("commit with a missing part: status %d: %s, want 400 naming part 2", rec.Code, rec.Body.String())
+	}
+
+	// The upload stays open, and belongs to the identity opening it
+	other := httptest.NewRequest(http.MethodDelete, target, nil)
+	other = other.WithContext(WithIdentity(other.Context(), "mallory"))
+	if rec := do(other); rec.Code != http.StatusNotFound {
+		t.Errorf("abort by another identity: status %d, want 404", rec.Code)
+	}
+	if rec := do(httptest.NewRequest(http.MethodDelete, target, nil)); rec.Code != http.StatusOK {
+		t.Errorf("abort: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := fs.Stat("/f"); err == nil {
+		t.Error("an aborted upload created the file")
+	}
+}
+
+func TestUploadReauthorization(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	fs.Mkdir("/shared", 0755)
+	fs.Mkdir("/etc", 0755)
+	h := NewHandler(fs, nil)
+	policy, err := acl.NewPolicy(fs, acl.Config{
+		File: "/etc/acl",
+		Rules: []acl.Rule{
+			{Identity: "writer", Path: "/shared", Permission: acl.Write},
+			{Identity: "admin", Path: "/", Permission: acl.Admin},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	h.SetACL(policy)
+	manager, err := approvals.NewManager(approvals.Config{Protected: []string{"/shared/prod"}})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	h.SetApprovals(manager)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := NewAuthenticator(map[string]string{"w": "writer"}, false).Middleware(
+		h.DryRunMiddleware(h.ACLMiddleware(h.ApprovalMiddleware(mux))))
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer w")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+	const partSize = filesystem.MinUploadPartSize
+
+	if rec := do(http.MethodPost, fmt.Sprintf("/api/v1/uploads?path=/shared/prod/f&part_size=%d", partSize), ""); rec.Code != http.StatusForbidden {
+		t.Errorf("begin on a protected path: status %d, want 403", rec.Code)
+	}
+	rec := do(http.MethodPost, fmt.Sprintf("/api/v1/uploads?path=/shared/f&part_size=%d", partSize), "")
+	var upload UploadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &upload); err != nil || upload.ID == "" {
+		t.Fatalf("begin response %d %s: %v", rec.Code, rec.Body.String(), err)
+	}
+	target := "/api/v1/uploads/" + upload.ID
+	if rec := do(http.MethodPut, target+"?offset=0", "hello"); rec.Code != http.StatusOK {
+		t.Fatalf("part: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Parts and commits can be previewed
+	var preview filesystem.DryRunResult
+	rec = do(http.MethodPut, target+"?offset=0&dry_run=true", "hello")
+	json.Unmarshal(rec.Body.Bytes(), &preview)
+	if rec.Code != http.StatusOK || !preview.Allowed || preview.Path != "/shared/f" || preview.Bytes != 5 {
+		t.Errorf("dry-run part = %d %s", rec.Code, rec.Body.String())
+	}
+	rec = do(http.MethodPost, target+"/commit?dry_run=true", "")
+	json.Unmarshal(rec.Body.Bytes(), &preview)
+	if rec.Code != http.StatusOK || !preview.Allowed || len(preview.Effects) != 1 || preview.Effects[0].Action != "create" {
+		t.Errorf("dry-run commit = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, "/api/v1/uploads/nope/commit?dry_run=true", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("dry-run commit of an unknown upload: status %d, want 404", rec.Code)
+	}
+
+	// Revoking the caller's access stops the upload they opened before
+	if err := policy.Load([]byte("writer read /shared\nadmin admin /\n")); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if rec := do(http.MethodPut, target+"?offset=0", "hello"); rec.Code != http.StatusForbidden {
+		t.Errorf("part after revocation: status %d, want 403", rec.Code)
+	}
+	rec = do(http.MethodPost, target+"/commit?dry_run=true", "")
+	preview = filesystem.DryRunResult{}
+	json.Unmarshal(rec.Body.Bytes(), &preview)
+	if rec.Code != http.StatusOK || preview.Allowed || len(preview.Verdicts) == 0 || preview.Verdicts[0].Policy != "acl" {
+		t.Errorf("dry-run commit after revocation = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, target+"/commit", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("commit after revocation: status %d, want 403", rec.Code)
+	}
+	if _, err := fs.Stat("/shared/f"); err == nil {
+		t.Error("a revoked upload created the file")
+	}
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// APIVersionHeader tells the client which version of the API answered
+const APIVersionHeader = "X-AGFS-API-Version"
+
+// Versions of the HTTP API. v2 serves the routes of v1 with these
+// semantics:
+//   - reads, writes and listings are bound to the request, so that a
+//     client going away cancels the backend call of plugins that support
+//     it (filesystem.ContextFileSystem)
+//   - reads of plain files with offset and size are streamed in chunks,
+//     with validators, instead of being read whole into memory
+const (
+	APIVersion1      = 1
+	APIVersion2      = 2
+	latestAPIVersion = APIVersion2
+)
+
+// APIVersionInfo describes one version of the API
+type APIVersionInfo struct {
+	Version string `json:"version"` // "v1", "v2"
+	Prefix  string `json:"prefix"`  // Path prefix of its routes
+	Status  string `json:"status"`  // "current", "supported" or "deprecated"
+	Sunset  string `json:"sunset,omitempty"`
+}
+
+// APIVersionsResponse is the answer of GET /api/versions, which clients
+// use to pick the newest version they share with the server and the
+// features they may rely on
+type APIVersionsResponse struct {
+	Current       string           `json:"current"`
+	Versions      []APIVersionInfo `json:"versions"`
+	ServerVersion string           `json:"serverVersion"`
+	Features      []string         `json:"features"`
+}
+
+type apiVersionKey struct{}
+
+// APIVersionFromContext returns the version of the API a request was made
+// to
+func APIVersionFromContext(ctx context.Context) int {
+	if version, ok := ctx.Value(apiVersionKey{}).(int); ok {
+		return version
+	}
+	return APIVersion1
+}
+
+// SetV1Sunset deprecates v1 of the API: its responses carry Deprecation,
+// Sunset and successor Link headers naming when it may be removed
+func (h *Handler) SetV1Sunset(sunset time.Time) {
+	h.v1Sunset = sunset
+}
+
+// VersionMiddleware serves /api/v2 with the routes of /api/v1, marking
+// the requests with their version. It must wrap the other middleware,
+// which then only ever see /api/v1 paths.
+func (h *Handler) VersionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, "/api/v2/")
+		if !ok {
+			if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+				w.Header().Set(APIVersionHeader, "1")
+				if !h.v1Sunset.IsZero() {
+					w.Header().Set("Deprecation", "true")
+					w.Header().Set("Sunset", h.v1Sunset.UTC().Format(http.TimeFormat))
+					w.Header().Set("Link", `</api/v2/>; rel="successor-version"`)
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r = r.Clone(context.WithValue(r.Context(), apiVersionKey{}, APIVersion2))
+		r.URL.Path = "/api/v1/" + rest
+		r.URL.RawPath = ""
+		w.Header().Set(APIVersionHeader, "2")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// APIVersions handles GET /api/versions
+func (h *Handler) APIVersions(w http.ResponseWriter, r *http.Request) {
+	v1 := APIVersionInfo{Version: "v1", Prefix: "/api/v1", Status: "supported"}
+	if !h.v1Sunset.IsZero() {
+		v1.Status = "deprecated"
+		v1.Sunset = h.v1Sunset.UTC().Format(time.RFC3339)
+	}
+	writeJSON(w, http.StatusOK, APIVersionsResponse{
+		Current: "v" + strconv.Itoa(latestAPIVersion),
+		Versions: []APIVersionInfo{
+			v1,
+			{Version: "v2", Prefix: "/api/v2", Status: "current"},
+		},
+		ServerVersion: h.version,
+		Features:      h.features(),
+	})
+}
+
+// contextFS returns the file system of the handler as context-aware for
+// requests to versions of the API bound to their request
+func (h *Handler) contextFS(r *http.Request) (filesystem.ContextFileSystem, bool) {
+	if APIVersionFromContext(r.Context()) < APIVersion2 {
+		return nil, false
+	}
+	cfs, ok := h.fs.(filesystem.ContextFileSystem)
+	return cfs, ok
+}
+
+// read reads a file on behalf of r
+func (h *Handler) read(r *http.Request, path string, offset int64, size int64) ([]byte, error) {
+	if cfs, ok := h.contextFS(r); ok {
+		return cfs.ReadContext(r.Context(), path, offset, size)
+	}
+	return h.fs.Read(path, offset, size)
+}
+
+// write writes a file on behalf of r
+func (h *Handler) write(r *http.Request, path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	if cfs, ok := h.contextFS(r); ok {
+		return cfs.WriteContext(r.Context(), path, data, offset, flags)
+	}
+	return h.fs.Write(path, data, offset, flags)
+}
+
+// remove removes a file on behalf of r, unless r has been abandoned. File
+// systems have no context-aware remove to pass the context down to.
+func (h *Handler) remove(r *http.Request, path string) error {
+	if err := r.Context().Err(); err != nil {
+		return err
+	}
+	return h.fs.Remove(path)
+}
+
+// readDir lists a directory on behalf of r
+func (h *Handler) readDir(r *http.Request, path string) ([]filesystem.FileInfo, error) {
+	if cfs, ok := h.contextFS(r); ok {
+		return cfs.ReadDirContext(r.Context(), path)
+	}
+	return h.fs.ReadDir(path)
+}
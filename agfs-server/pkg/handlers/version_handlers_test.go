@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// contextAwareFS records which of its reads and listings were given the
+// caller's context
+type contextAwareFS struct {
+	filesystem.FileSystem
+	mu    sync.Mutex
+	calls []string
+}
+
+func (fs *contextAwareFS) record(call string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.calls = append(fs.calls, call)
+}
+
+func (fs *contextAwareFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	fs.record("Read")
+	return fs.FileSystem.Read(path, offset, size)
+}
+
+func (fs *contextAwareFS) ReadContext(ctx context.Context, path string, offset int64, size int64) ([]byte, error) {
+	fs.record("ReadContext")
+	return fs.FileSystem.Read(path, offset, size)
+}
+
+func (fs *contextAwareFS) WriteContext(ctx context.Context, path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	fs.record("WriteContext")
+	return fs.FileSystem.Write(path, data, offset, flags)
+}
+
+func (fs *contextAwareFS) ReadDirContext(ctx context.Context, path string) ([]filesystem.FileInfo, error) {
+	fs.record("ReadDirContext")
+	return fs.FileSystem.ReadDir(path)
+}
+
+func (fs *contextAwareFS) takeCalls() string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	calls := strings.Join(fs.calls, ",")
+	fs.calls = nil
+	return calls
+}
+
+type contextAwarePlugin struct {
+	*memfs.MemFSPlugin
+	fs *contextAwareFS
+}
+
+func (p *contextAwarePlugin) GetFileSystem() filesystem.FileSystem { return p.fs }
+
+func TestAPIVersions(t *testing.T) {
+	root := mountablefs.NewMountableFS(api.PoolConfig{})
+	p := &contextAwarePlugin{MemFSPlugin: memfs.NewMemFSPlugin()}
+	p.Initialize(map[string]interface{}{})
+	p.fs = &contextAwareFS{FileSystem: p.MemFSPlugin.GetFileSystem()}
+	if err := root.Mount("/mem", p); err != nil {
+		t.Fatalf("Mount() error = %v", err)
+	}
+	plain := memfs.NewMemFSPlugin()
+	plain.Initialize(map[string]interface{}{})
+	if err := root.Mount("/plain", plain); err != nil {
+		t.Fatalf("Mount() error = %v", err)
+	}
+	root.Write("/plain/notes", []byte("hello world"), -1, filesystem.WriteFlagCreate)
+
+	h := NewHandler(root, nil)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := h.VersionMiddleware(mux)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequestWithContext(ctx, method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/api/versions", "")
+	var versions APIVersionsResponse
+	json.Unmarshal(rec.Body.Bytes(), &versions)
+	if rec.Code != http.StatusOK || versions.Current != "v2" || len(versions.Versions) != 2 || versions.Versions[0].Status != "supported" {
+		t.Fatalf("GET /api/versions = %d %s", rec.Code, rec.Body.String())
+	}
+
+	// v2 serves the v1 routes, passing the request context down
+	if rec := do(http.MethodPut, "/api/v2/files?path=/mem/notes", "hello world"); rec.Code != http.StatusOK || rec.Header().Get(APIVersionHeader) != "2" {
+		t.Fatalf("v2 write = %d %s", rec.Code, rec.Body.String())
+	}
+	if calls := p.fs.takeCalls(); calls != "WriteContext" {
+		t.Errorf("v2 write made calls %q", calls)
+	}
+	if rec := do(http.MethodGet, "/api/v2/files?path=/mem/notes&offset=6&size=3", ""); rec.Code != http.StatusOK || rec.Body.String() != "wor" {
+		t.Errorf("v2 read = %d %q", rec.Code, rec.Body.String())
+	}
+	if calls := p.fs.takeCalls(); calls != "ReadContext" {
+		t.Errorf("v2 read made calls %q", calls)
+	}
+	if rec := do(http.MethodGet, "/api/v2/directories?path=/mem", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "notes") {
+		t.Errorf("v2 list = %d %s", rec.Code, rec.Body.String())
+	}
+	if calls := p.fs.takeCalls(); calls != "ReadDirContext" {
+		t.Errorf("v2 list made calls %q", calls)
+	}
+
+	// So do the KV routes
+	if rec := do(http.MethodPut, "/api/v2/kv?path=/mem/state&key=plan", "step 3"); rec.Code != http.StatusOK {
+		t.Fatalf("v2 kv set = %d %s", rec.Code, rec.Body.String())
+	}
+	if calls := p.fs.takeCalls(); calls != "WriteContext" {
+		t.Errorf("v2 kv set made calls %q", calls)
+	}
+	if rec := do(http.MethodGet, "/api/v2/kv?path=/mem/state&key=plan", ""); rec.Code != http.StatusOK || rec.Body.String() != "step 3" {
+		t.Errorf("v2 kv get = %d %q", rec.Code, rec.Body.String())
+	}
+	if calls := p.fs.takeCalls(); calls != "ReadContext" {
+		t.Errorf("v2 kv get made calls %q", calls)
+	}
+	if rec := do(http.MethodGet, "/api/v2/kv?path=/mem/state", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "plan") {
+		t.Errorf("v2 kv list = %d %s", rec.Code, rec.Body.String())
+	}
+	if calls := p.fs.takeCalls(); calls != "ReadDirContext,ReadDirContext" {
+		t.Errorf("v2 kv list made calls %q", calls)
+	}
+	abandoned, abandon := context.WithCancel(context.Background())
+	abandon()
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequestWithContext(abandoned, http.MethodDelete, "/api/v2/kv?path=/mem/state&key=plan", nil))
+	if rec.Code == http.StatusOK {
+		t.Errorf("v2 kv delete of an abandoned request succeeded")
+	}
+
+	// Slices of plain files are streamed with validators
+	for _, tt := range []struct {
+		target, want string
+		etag         bool
+	}{
+		{"/api/v2/files?path=/plain/notes&offset=6&size=3", "wor", true},
+		{"/api/v2/files?path=/plain/notes&offset=6", "world", true},
+		{"/api/v2/files?path=/plain/notes&offset=20", "", true},
+		{"/api/v1/files?path=/plain/notes&offset=6&size=3", "wor", false},
+	} {
+		rec := do(http.MethodGet, tt.target, "")
+		if rec.Code != http.StatusOK || rec.Body.String() != tt.want || (rec.Header().Get("ETag") != "") != tt.etag {
+			t.Errorf("GET %s = %d %q, ETag %q", tt.target, rec.Code, rec.Body.String(), rec.Header().Get("ETag"))
+		}
+	}
+
+	// v1 is unchanged
+	rec = do(http.MethodGet, "/api/v1/files?path=/mem/notes&offset=6&size=3", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "wor" || rec.Header().Get(APIVersionHeader) != "1" {
+		t.Errorf("v1 read = %d %q", rec.Code, rec.Body.String())
+	}
+	if calls := p.fs.takeCalls(); calls != "Read" {
+		t.Errorf("v1 read made calls %q", calls)
+	}
+	if rec.Header().Get("Deprecation") != "" {
+		t.Errorf("v1 is deprecated without a sunset")
+	}
+
+	h.SetV1Sunset(time.Date(2027, 6, 30, 0, 0, 0, 0, time.UTC))
+	rec = do(http.MethodGet, "/api/v1/stat?path=/mem/notes", "")
+	if rec.Header().Get("Deprecation") != "true" || rec.Header().Get("Sunset") != "Wed, 30 Jun 2027 00:00:00 GMT" ||
+		!strings.Contains(rec.Header().Get("Link"), "successor-version") {
+		t.Errorf("deprecated v1 headers = %v", rec.Header())
+	}
+	rec = do(http.MethodGet, "/api/versions", "")
+	json.Unmarshal(rec.Body.Bytes(), &versions)
+	if v1 := versions.Versions[0]; v1.Status != "deprecated" || v1.Sunset != "2027-06-30T00:00:00Z" {
+		t.Errorf("deprecated v1 = %+v", v1)
+	}
+}
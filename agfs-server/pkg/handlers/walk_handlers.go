@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// WalkEntryResponse represents a single entry emitted by the walk endpoint
+type WalkEntryResponse struct {
+	Path  string `json:"path"`
+	Depth int    `json:"depth"`
+	FileInfoResponse
+}
+
+// WalkSummary is the final NDJSON line of a walk response
+type WalkSummary struct {
+	Type  string `json:"type"` // Always "summary"
+	Count int    `json:"count"`
+	Error string `json:"error,omitempty"`
+}
+
+// fileInfoResponse converts a FileInfo into its API representation
+func fileInfoResponse(info filesystem.FileInfo) FileInfoResponse {
+	resp := FileInfoResponse{
+		Name:     info.Name,
+		Size:     info.Size,
+		Mode:     info.Mode,
+		ModTime:  info.ModTime.Format(time.RFC3339Nano),
+		IsDir:    info.IsDir,
+		Meta:     info.Meta,
+		Checksum: info.Checksum,
+	}
+	if !info.ExpiresAt.IsZero() {
+		expiresAt := info.ExpiresAt
+		resp.ExpiresAt = &expiresAt
+	}
+	return resp
+}
+
+// parseWalkOptions parses walk query parameters.
+// include/exclude may be repeated or given as comma-separated lists.
+func parseWalkOptions(r *http.Request) (filesystem.WalkOptions, error) {
+	q := r.URL.Query()
+	opts := filesystem.WalkOptions{
+		Include:   splitListParam(q["include"]),
+		Exclude:   splitListParam(q["exclude"]),
+		FilesOnly: q.Get("files_only") == "true",
+	}
+
+	if depthStr := q.Get("max_depth"); depthStr != "" {
+		depth, err := strconv.Atoi(depthStr)
+		if err != nil {
+			return opts, filesystem.NewInvalidArgumentError("max_depth", depthStr, "must be an integer")
+		}
+		opts.MaxDepth = depth
+	}
+
+	return opts, filesystem.ValidateWalkOptions(opts)
+}
+
+// splitListParam flattens repeated and comma-separated query values
+func splitListParam(values []string) []string {
+	var result []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				result = append(result, part)
+			}
+		}
+	}
+	return result
+}
+
+// Walk handles GET /walk?path=<path>&max_depth=<n>&include=<glob>&exclude=<glob>&files_only=<bool>
+// The subtree is streamed as NDJSON, one entry per line, followed by a summary line.
+func (h *Handler) Walk(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = "/"
+	}
+
+	opts, err := parseWalkOptions(r)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	// Fail fast with a proper status code before committing to a streamed body
+	info, err := h.fs.Stat(path)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	if !info.IsDir {
+		writeError(w, http.StatusBadRequest, filesystem.NewNotDirectoryError(path).Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	count := 0
+
+	walkErr := filesystem.Walk(h.fs, path, opts, func(entry filesystem.WalkEntry) error {
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		count++
+		if err := encoder.Encode(WalkEntryResponse{
+			Path:             entry.Path,
+			Depth:            entry.Depth,
+			FileInfoResponse: fileInfoResponse(entry.Info),
+		}); err != nil {
+			return err
+		}
+		// Flush periodically so large trees start arriving immediately
+		if flusher != nil && count%100 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+
+	summary := WalkSummary{Type: "summary", Count: count}
+	if walkErr != nil {
+		log.Warnf("[handler] Walk %s stopped early: %v", path, walkErr)
+		summary.Error = walkErr.Error()
+	}
+	encoder.Encode(summary)
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func newWalkTestFS(t *testing.T) filesystem.FileSystem {
+	t.Helper()
+	fs := memfs.NewMemoryFS()
+	for _, dir := range []string{"/a", "/a/b", "/a/b/c"} {
+		if err := fs.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("mkdir %s: %v", dir, err)
+		}
+	}
+	for _, file := range []string{"/a/x.txt", "/a/b/y.go", "/a/b/c/z.txt"} {
+		if _, err := fs.Write(file, []byte("data"), -1, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("write %s: %v", file, err)
+		}
+	}
+	return fs
+}
+
+func doWalk(t *testing.T, h *Handler, query string) ([]WalkEntryResponse, WalkSummary) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.Walk(rec, httptest.NewRequest(http.MethodGet, "/api/v1/walk?"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("walk %q: unexpected status %d: %s", query, rec.Code, rec.Body.String())
+	}
+
+	var entries []WalkEntryResponse
+	var summary WalkSummary
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var probe struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &probe); err != nil {
+			t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		if probe.Type == "summary" {
+			json.Unmarshal(scanner.Bytes(), &summary)
+			continue
+		}
+		var entry WalkEntryResponse
+		json.Unmarshal(scanner.Bytes(), &entry)
+		entries = append(entries, entry)
+	}
+	return entries, summary
+}
+
+func TestWalkStreamsSubtreeWithFilters(t *testing.T) {
+	h := NewHandler(newWalkTestFS(t), nil)
+
+	entries, summary := doWalk(t, h, "path=/a")
+	if len(entries) != 5 || summary.Count != 5 || summary.Error != "" {
+		t.Fatalf("expected 5 entries, got %d (summary %+v)", len(entries), summary)
+	}
+
+	entries, _ = doWalk(t, h, "path=/a&max_depth=1")
+	for _, e := range entries {
+		if e.Depth != 1 {
+			t.Fatalf("max_depth=1 returned %s at depth %d", e.Path, e.Depth)
+		}
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries at depth 1, got %d", len(entries))
+	}
+
+	entries, _ = doWalk(t, h, "path=/a&include=*.txt&files_only=true")
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 txt files, got %+v", entries)
+	}
+
+	entries, _ = doWalk(t, h, "path=/a&exclude=b")
+	if len(entries) != 1 || entries[0].Path != "/a/x.txt" {
+		t.Fatalf("exclude=b should prune the subtree, got %+v", entries)
+	}
+}
+
+func TestWalkRejectsBadRequests(t *testing.T) {
+	h := NewHandler(newWalkTestFS(t), nil)
+
+	cases := map[string]int{
+		"path=/a/x.txt":       http.StatusBadRequest,
+		"path=/a&max_depth=x": http.StatusBadRequest,
+		"path=/a&include=[":   http.StatusBadRequest,
+	}
+	for query, want := range cases {
+		rec := httptest.NewRecorder()
+		h.Walk(rec, httptest.NewRequest(http.MethodGet, "/api/v1/walk?"+query, nil))
+		if rec.Code != want {
+			t.Errorf("walk %q: expected %d, got %d", query, want, rec.Code)
+		}
+	}
+}
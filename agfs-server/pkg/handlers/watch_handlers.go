@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// EventHeartbeat is the type of the keep-alive lines of a watch stream
+const EventHeartbeat = "heartbeat"
+
+// watchHeartbeatInterval keeps idle watch streams alive through proxies and
+// lets clients tell a quiet path from a dead connection
+var watchHeartbeatInterval = 30 * time.Second
+
+// parseWatchOptions parses the filters, the resume cursor and the debounce
+// and batch durations of a watch request. include, exclude and types may be repeated or given as
+// comma-separated lists.
+func parseWatchOptions(r *http.Request) (filesystem.WatchOptions, error) {
+	q := r.URL.Query()
+	opts := filesystem.WatchOptions{
+		Recursive: q.Get("recursive") == "true",
+		Include:   splitListParam(q["include"]),
+		Exclude:   splitListParam(q["exclude"]),
+		Types:     splitListParam(q["types"]),
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Duration
+	}{{"debounce", &opts.Debounce}, {"batch", &opts.BatchWindow}} {
+		value := q.Get(p.name)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return opts, filesystem.NewInvalidArgumentError(p.name, value, "must be a duration such as 200ms")
+		}
+		*p.dst = d
+	}
+	if value := q.Get("since"); value != "" {
+		since, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return opts, filesystem.NewInvalidArgumentError("since", value, "must be the cursor of a received event")
+		}
+		opts.Since = since
+	}
+	return opts, filesystem.ValidateWatchOptions(opts)
+}
+
+// Watch handles GET /watch?path=<path>&recursive=<bool>&include=<glob>&exclude=<glob>&types=<list>&debounce=<duration>&batch=<duration>&since=<cursor>
+// Changes to the path, or to the direct entries of a directory (its subtree
+// with recursive=true), are filtered and streamed as NDJSON events until the
+// client disconnects. With since, the events retained in the history after
+// that cursor are sent first.
+func (h *Handler) Watch(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	if !h.authorizePath(w, r, path, false) {
+		return
+	}
+
+	watcher, ok := h.fs.(filesystem.Watcher)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "watch not supported")
+		return
+	}
+	opts, err := parseWatchOptions(r)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	if _, err := h.fs.Stat(path); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	sub, err := watcher.Watch(path, opts)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	flush()
+
+	encoder := json.NewEncoder(w)
+	heartbeat := time.NewTicker(watchHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if err := encoder.Encode(ev); err != nil {
+				return
+			}
+			flush()
+		case now := <-heartbeat.C:
+			if err := encoder.Encode(filesystem.Event{Type: EventHeartbeat, Path: path, Time: now}); err != nil {
+				return
+			}
+			flush()
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestWatchStreamsEvents(t *testing.T) {
+	root := mountablefs.NewMountableFS(api.PoolConfig{})
+	store := memfs.NewMemFSPlugin()
+	store.Initialize(map[string]interface{}{})
+	root.Mount("/data", store)
+	h := NewHandler(root, nil)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for query, want := range map[string]int{
+		"path=/missing":            http.StatusNotFound,
+		"path=/data&debounce=soon": http.StatusBadRequest,
+		"path=/data&batch=1h":      http.StatusBadRequest,
+		"debounce=100ms":           http.StatusBadRequest,
+	} {
+		resp, err := http.Get(server.URL + "/api/v1/watch?" + query)
+		if err != nil {
+			t.Fatalf("GET %s: %v", query, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s: status %d, want %d", query, resp.StatusCode, want)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/api/v1/watch?path=/data&debounce=50ms")
+	if err != nil {
+		t.Fatalf("GET watch: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("watch status %d", resp.StatusCode)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err := root.Write("/data/a.txt", []byte("x"), -1, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	select {
+	case line := <-lines:
+		var ev filesystem.Event
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("bad event line %q", line)
+		}
+		if ev.Type != filesystem.EventWrite || ev.Path != "/data/a.txt" || ev.Count != 10 {
+			t.Errorf("event = %+v, want 10 coalesced writes", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+}
+
+func TestParseWatchOptions(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/watch?path=/a&debounce=200ms&batch=1s", nil)
+	opts, err := parseWatchOptions(r)
+	if err != nil || opts.Debounce != 200*time.Millisecond || opts.BatchWindow != time.Second {
+		t.Errorf("parseWatchOptions() = %+v, %v", opts, err)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/api/v1/watch?path=/a&recursive=true&include=**/*.md,*.txt&exclude=tmp/**&types=write&types=remove", nil)
+	opts, err = parseWatchOptions(r)
+	if err != nil || !opts.Recursive || len(opts.Include) != 2 || len(opts.Exclude) != 1 || len(opts.Types) != 2 {
+		t.Errorf("parseWatchOptions(filters) = %+v, %v", opts, err)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/api/v1/watch?path=/a&since=1729000000000042", nil)
+	if opts, err = parseWatchOptions(r); err != nil || opts.Since != 1729000000000042 {
+		t.Errorf("parseWatchOptions(since) = %+v, %v", opts, err)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/api/v1/watch?path=/a&since=-1", nil)
+	if _, err := parseWatchOptions(r); err == nil || !strings.Contains(err.Error(), "since") {
+		t.Errorf("bad since: err = %v", err)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/api/v1/watch?path=/a&types=bogus", nil)
+	if _, err := parseWatchOptions(r); err == nil || !strings.Contains(err.Error(), "types") {
+		t.Errorf("unknown type: err = %v", err)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/api/v1/watch?path=/a&debounce=-1s", nil)
+	if _, err := parseWatchOptions(r); err == nil || !strings.Contains(err.Error(), "debounce") {
+		t.Errorf("negative debounce: err = %v", err)
+	}
+}
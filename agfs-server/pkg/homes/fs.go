@@ -0,0 +1,370 @@
+package homes
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// Plugin mounts the homes at the manager's path
+type Plugin struct {
+	manager *Manager
+}
+
+func (p *Plugin) Name() string {
+	return "homes"
+}
+
+func (p *Plugin) Validate(cfg map[string]interface{}) error {
+	return nil
+}
+
+func (p *Plugin) Initialize(cfg map[string]interface{}) error {
+	return nil
+}
+
+func (p *Plugin) GetFileSystem() filesystem.FileSystem {
+	return p.manager.fs
+}
+
+func (p *Plugin) GetReadme() string {
+	return `Homes - Per-Identity Home Directories
+
+Every authenticated identity gets a private home directory here, created
+from the configured template on its first request. Identities can only
+access their own home.
+
+STRUCTURE:
+  /<identity>/   - Home of an identity (stored under ` + p.manager.cfg.Backend + `)
+`
+}
+
+func (p *Plugin) GetConfigParams() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{}
+}
+
+func (p *Plugin) Shutdown() error {
+	p.manager.Stop()
+	return nil
+}
+
+// homesFS serves the homes from the backend path of the root file system,
+// enforcing the per-home quota
+type homesFS struct {
+	root    filesystem.FileSystem
+	backend string
+	quota   int64
+
+	mu    sync.Mutex
+	usage map[string]int64 // Identity -> bytes used; computed on first write
+}
+
+func newHomesFS(root filesystem.FileSystem, backend string, quota int64) *homesFS {
+	return &homesFS{
+		root:    root,
+		backend: backend,
+		quota:   quota,
+		usage:   make(map[string]int64),
+	}
+}
+
+// real maps a path in the mount to the backend
+func (fs *homesFS) real(p string) string {
+	return path.Join(fs.backend, filesystem.NormalizePath(p))
+}
+
+// identityOf returns the identity whose home contains p, or "" for the root
+func identityOf(p string) string {
+	identity, _, _ := strings.Cut(strings.TrimPrefix(filesystem.NormalizePath(p), "/"), "/")
+	return identity
+}
+
+// isHome reports whether p is a home directory itself
+func isHome(p string) bool {
+	p = filesystem.NormalizePath(p)
+	return p != "/" && path.Dir(p) == "/"
+}
+
+// managed rejects changes to the root and the home directories themselves,
+// which are created and removed by the manager
+func managed(op, p string) error {
+	if filesystem.NormalizePath(p) == "/" || isHome(p) {
+		return filesystem.NewPermissionDeniedError(op, p, "home directories are managed by the server")
+	}
+	return nil
+}
+
+// forget drops the cached usage of identity's home; it is recomputed when
+// next needed
+func (fs *homesFS) forget(identity string) {
+	fs.mu.Lock()
+	delete(fs.usage, identity)
+	fs.mu.Unlock()
+}
+
+// used returns the bytes used by identity's home. Caller must hold fs.mu.
+func (fs *homesFS) used(identity string) int64 {
+	if n, ok := fs.usage[identity]; ok {
+		return n
+	}
+	n := fs.du(path.Join(fs.backend, identity))
+	fs.usage[identity] = n
+	return n
+}
+
+// du sums the sizes of the files under dir
+func (fs *homesFS) du(dir string) int64 {
+	entries, err := fs.root.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir {
+			total += fs.du(path.Join(dir, entry.Name))
+		} else {
+			total += entry.Size
+		}
+	}
+	return total
+}
+
+// size returns the current size of the file at p in the mount, 0 if missing
+func (fs *homesFS) size(p string) int64 {
+	info, err := fs.root.Stat(fs.real(p))
+	if err != nil || info.IsDir {
+		return 0
+	}
+	return info.Size
+}
+
+// change runs op, which may grow the file at p by up to growth bytes, if
+// the quota allows it, then accounts for the actual change in size
+func (fs *homesFS) change(p string, growth int64, op func() error) error {
+	if fs.quota <= 0 {
+		return op()
+	}
+	identity := identityOf(p)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	used := fs.used(identity)
+	if growth > 0 && used+growth > fs.quota {
+		return filesystem.NewQuotaExceededError(p, fs.quota)
+	}
+	before := fs.size(p)
+	err := op()
+	fs.usage[identity] = used + fs.size(p) - before
+	return err
+}
+
+// writeGrowth is how much a Write can grow a file of size old
+func writeGrowth(old, offset, n int64, flags filesystem.WriteFlag) int64 {
+	if flags&filesystem.WriteFlagAppend != 0 {
+		return n
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	end := offset + n
+	if flags&filesystem.WriteFlagTruncate != 0 || end > old {
+		return end - old
+	}
+	return 0
+}
+
+func (fs *homesFS) Create(p string) error {
+	if err := managed("create", p); err != nil {
+		return err
+	}
+	return fs.root.Create(fs.real(p))
+}
+
+func (fs *homesFS) Mkdir(p string, perm uint32) error {
+	if err := managed("mkdir", p); err != nil {
+		return err
+	}
+	return fs.root.Mkdir(fs.real(p), perm)
+}
+
+func (fs *homesFS) Remove(p string) error {
+	if err := managed("remove", p); err != nil {
+		return err
+	}
+	defer fs.forget(identityOf(p))
+	return fs.root.Remove(fs.real(p))
+}
+
+func (fs *homesFS) RemoveAll(p string) error {
+	if err := managed("remove", p); err != nil {
+		return err
+	}
+	defer fs.forget(identityOf(p))
+	return fs.root.RemoveAll(fs.real(p))
+}
+
+func (fs *homesFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	return fs.root.Read(fs.real(p), offset, size)
+}
+
+func (fs *homesFS) Write(p string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	if err := managed("write", p); err != nil {
+		return 0, err
+	}
+	var n int64
+	growth := writeGrowth(fs.size(p), offset, int64(len(data)), flags)
+	err := fs.change(p, growth, func() error {
+		var err error
+		n, err = fs.root.Write(fs.real(p), data, offset, flags)
+		return err
+	})
+	return n, err
+}
+
+func (fs *homesFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	return fs.root.ReadDir(fs.real(p))
+}
+
+func (fs *homesFS) Stat(p string) (*filesystem.FileInfo, error) {
+	info, err := fs.root.Stat(fs.real(p))
+	if err != nil {
+		return nil, err
+	}
+	if filesystem.NormalizePath(p) == "/" {
+		info.Name = "/"
+	}
+	return info, nil
+}
+
+func (fs *homesFS) Rename(oldPath, newPath string) error {
+	if err := managed("rename", oldPath); err != nil {
+		return err
+	}
+	if err := managed("rename", newPath); err != nil {
+		return err
+	}
+	from, to := identityOf(oldPath), identityOf(newPath)
+	if from != to {
+		return filesystem.NewPermissionDeniedError("rename", newPath, "cannot move files between homes")
+	}
+	defer fs.forget(from)
+	return fs.root.Rename(fs.real(oldPath), fs.real(newPath))
+}
+
+func (fs *homesFS) Chmod(p string, mode uint32) error {
+	if err := managed("chmod", p); err != nil {
+		return err
+	}
+	return fs.root.Chmod(fs.real(p), mode)
+}
+
+func (fs *homesFS) Open(p string) (io.ReadCloser, error) {
+	return fs.root.Open(fs.real(p))
+}
+
+// OpenWrite replaces the file at p, failing the write that would take the
+// home over its quota
+func (fs *homesFS) OpenWrite(p string) (io.WriteCloser, error) {
+	if err := managed("write", p); err != nil {
+		return nil, err
+	}
+	w, err := fs.root.OpenWrite(fs.real(p))
+	if err != nil || fs.quota <= 0 {
+		return w, err
+	}
+
+	identity := identityOf(p)
+	fs.mu.Lock()
+	allowance := fs.quota - fs.used(identity) + fs.size(p)
+	fs.mu.Unlock()
+	return &quotaWriter{WriteCloser: w, fs: fs, path: p, allowance: allowance}, nil
+}
+
+func (fs *homesFS) Truncate(p string, size int64) error {
+	if err := managed("truncate", p); err != nil {
+		return err
+	}
+	truncater, ok := fs.root.(filesystem.Truncater)
+	if !ok {
+		return filesystem.NewNotSupportedError("truncate", p)
+	}
+	return fs.change(p, size-fs.size(p), func() error {
+		return truncater.Truncate(fs.real(p), size)
+	})
+}
+
+// quotaWriter fails writes beyond the bytes the home has room for
+type quotaWriter struct {
+	io.WriteCloser
+	fs        *homesFS
+	path      string
+	allowance int64
+	written   int64
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	if w.written+int64(len(p)) > w.allowance {
+		return 0, filesystem.NewQuotaExceededError(w.path, w.fs.quota)
+	}
+	n, err := w.WriteCloser.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *quotaWriter) Close() error {
+	err := w.WriteCloser.Close()
+	w.fs.forget(identityOf(w.path))
+	return err
+}
+
+var _ plugin.ServicePlugin = (*Plugin)(nil)
+var _ filesystem.FileSystem = (*homesFS)(nil)
+var _ filesystem.Truncater = (*homesFS)(nil)
+var _ filesystem.DryRunner = (*homesFS)(nil)
+
+// DryRun previews op, adding the verdicts of the homes policies: home
+// directories are managed by the server, files cannot move between homes
+// and writes must fit the quota
+func (fs *homesFS) DryRun(op filesystem.DryRunOp) (*filesystem.DryRunResult, error) {
+	result, err := filesystem.Preview(fs, op)
+	if err != nil || !result.Allowed {
+		return result, err
+	}
+
+	if err := managed(op.Op, op.Path); err != nil {
+		result.Deny("homes", "home directories are managed by the server")
+		return result, nil
+	}
+	if op.Op == filesystem.DryRunRename {
+		if managed(op.Op, op.Target) != nil || identityOf(op.Path) != identityOf(op.Target) {
+			result.Deny("homes", "cannot move files between homes")
+			return result, nil
+		}
+	}
+
+	var growth int64
+	switch op.Op {
+	case filesystem.DryRunWrite:
+		growth = writeGrowth(fs.size(op.Path), op.Offset, op.Size, op.Flags)
+	case filesystem.DryRunTruncate:
+		growth = op.Size - fs.size(op.Path)
+	}
+	if fs.quota > 0 && growth > 0 {
+		identity := identityOf(op.Path)
+		fs.mu.Lock()
+		used := fs.used(identity)
+		fs.mu.Unlock()
+		if used+growth > fs.quota {
+			result.Deny("quota", fmt.Sprintf("home of %s would use %d of %d bytes", identity, used+growth, fs.quota))
+		} else {
+			result.Allow("quota", fmt.Sprintf("home of %s would use %d of %d bytes", identity, used+growth, fs.quota))
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,309 @@
+// Package homes provisions a private home directory for every caller
+// identity. Homes appear under one path (by default /home/<identity>), are
+// stored under a configurable AGFS path, start as a copy of a template
+// directory and can be size-limited. Homes of ephemeral identities are
+// removed once they have been idle for a while.
+package homes
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults applied when the corresponding Config field is zero
+const (
+	DefaultPath            = "/home"
+	DefaultEphemeralTTL    = 24 * time.Hour
+	DefaultCleanupInterval = 5 * time.Minute
+)
+
+// identityPattern restricts identities to names usable as a single path element
+var identityPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,127}$`)
+
+// Config describes where homes live and how they are managed
+type Config struct {
+	Path         string        // Where homes appear in the namespace
+	Backend      string        // AGFS path homes are stored under
+	Template     string        // AGFS directory copied into new homes (optional)
+	Quota        int64         // Bytes per home; 0 for no limit
+	Ephemeral    []string      // path.Match patterns of ephemeral identities
+	EphemeralTTL time.Duration // Idle time before an ephemeral home is removed
+}
+
+// Manager provisions and cleans up homes on the root file system
+type Manager struct {
+	root filesystem.FileSystem
+	cfg  Config
+	fs   *homesFS
+
+	mu          sync.Mutex
+	provisioned map[string]bool
+	lastSeen    map[string]time.Time
+	stop        chan struct{}
+}
+
+// NewManager creates a home manager over root
+func NewManager(root filesystem.FileSystem, cfg Config) (*Manager, error) {
+	if cfg.Path == "" {
+		cfg.Path = DefaultPath
+	}
+	if cfg.EphemeralTTL <= 0 {
+		cfg.EphemeralTTL = DefaultEphemeralTTL
+	}
+	if !strings.HasPrefix(cfg.Backend, "/") {
+		return nil, fmt.Errorf("homes backend must be an absolute AGFS path")
+	}
+	if cfg.Template != "" && !strings.HasPrefix(cfg.Template, "/") {
+		return nil, fmt.Errorf("homes template must be an absolute AGFS path")
+	}
+	if cfg.Quota < 0 {
+		return nil, fmt.Errorf("homes quota must not be negative")
+	}
+	for _, pattern := range cfg.Ephemeral {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid ephemeral pattern %q: %w", pattern, err)
+		}
+	}
+	cfg.Path = filesystem.NormalizePath(cfg.Path)
+	cfg.Backend = filesystem.NormalizePath(cfg.Backend)
+	if within(cfg.Backend, cfg.Path) || within(cfg.Path, cfg.Backend) {
+		return nil, fmt.Errorf("homes path %s and backend %s must not overlap", cfg.Path, cfg.Backend)
+	}
+
+	m := &Manager{
+		root:        root,
+		cfg:         cfg,
+		provisioned: make(map[string]bool),
+		lastSeen:    make(map[string]time.Time),
+	}
+	m.fs = newHomesFS(root, cfg.Backend, cfg.Quota)
+	return m, nil
+}
+
+// Path returns where homes appear in the namespace
+func (m *Manager) Path() string {
+	return m.cfg.Path
+}
+
+// Plugin returns the plugin to mount at Path
+func (m *Manager) Plugin() *Plugin {
+	return &Plugin{manager: m}
+}
+
+// within reports whether p is dir or below it
+func within(p, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+// HomeOf returns the home directory of identity in the namespace
+func (m *Manager) HomeOf(identity string) string {
+	return path.Join(m.cfg.Path, identity)
+}
+
+// ValidIdentity reports whether identity can own a home
+func ValidIdentity(identity string) bool {
+	return identityPattern.MatchString(identity)
+}
+
+// ephemeral reports whether identity's home is removed when idle
+func (m *Manager) ephemeral(identity string) bool {
+	for _, pattern := range m.cfg.Ephemeral {
+		if ok, _ := path.Match(pattern, identity); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Provision makes sure identity has a home, creating it from the template
+// on first use, and records the identity as active
+func (m *Manager) Provision(identity string) error {
+	if !ValidIdentity(identity) {
+		return filesystem.NewInvalidArgumentError("identity", identity, "cannot be used as a home directory name")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastSeen[identity] = time.Now()
+	if m.provisioned[identity] {
+		return nil
+	}
+
+	home := path.Join(m.cfg.Backend, identity)
+	if info, err := m.root.Stat(home); err == nil {
+		if !info.IsDir {
+			return filesystem.NewNotDirectoryError(home)
+		}
+		m.provisioned[identity] = true
+		return nil
+	}
+
+	if err := mkdirAll(m.root, m.cfg.Backend); err != nil {
+		return fmt.Errorf("failed to create homes backend: %w", err)
+	}
+	if err := m.root.Mkdir(home, 0700); err != nil {
+		return fmt.Errorf("failed to create home for %s: %w", identity, err)
+	}
+	if m.cfg.Template != "" {
+		if err := copyTree(m.root, m.cfg.Template, home); err != nil {
+			m.root.RemoveAll(home)
+			return fmt.Errorf("failed to copy home template: %w", err)
+		}
+	}
+	m.provisioned[identity] = true
+	log.Infof("[homes] Provisioned %s for %s", m.HomeOf(identity), identity)
+	return nil
+}
+
+// Authorize checks that identity may access p. Anonymous callers are not
+// confined. Identities may use their own home and anything outside the
+// homes path; recursive operations on the homes path or its parents are
+// refused, as they would reach other homes.
+func (m *Manager) Authorize(identity, p string, recursive bool) error {
+	if identity == "" {
+		return nil
+	}
+	p = filesystem.NormalizePath(p)
+	if within(m.cfg.Path, p) {
+		if recursive {
+			return filesystem.NewPermissionDeniedError("access", p, "contains other identities' homes")
+		}
+		return nil
+	}
+	if !within(p, m.cfg.Path) {
+		return nil
+	}
+	if within(p, m.HomeOf(identity)) {
+		return nil
+	}
+	return filesystem.NewPermissionDeniedError("access", p, "home of another identity")
+}
+
+// Start removes idle ephemeral homes every interval until Stop is called
+func (m *Manager) Start(interval time.Duration) {
+	if len(m.cfg.Ephemeral) == 0 {
+		return
+	}
+	m.mu.Lock()
+	if m.stop != nil {
+		m.mu.Unlock()
+		return
+	}
+	m.stop = make(chan struct{})
+	stop := m.stop
+	m.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Cleanup(time.Now())
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the cleanup started by Start
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+}
+
+// Cleanup removes the homes of ephemeral identities idle for longer than
+// the TTL and returns the identities removed. Homes not used since the
+// server started are judged by their modification time.
+func (m *Manager) Cleanup(now time.Time) []string {
+	entries, err := m.root.ReadDir(m.cfg.Backend)
+	if err != nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var removed []string
+	for _, entry := range entries {
+		identity := entry.Name
+		if !entry.IsDir || !m.ephemeral(identity) {
+			continue
+		}
+		last, ok := m.lastSeen[identity]
+		if !ok {
+			last = entry.ModTime
+		}
+		if now.Sub(last) < m.cfg.EphemeralTTL {
+			continue
+		}
+		if err := m.root.RemoveAll(path.Join(m.cfg.Backend, identity)); err != nil {
+			log.Warnf("[homes] Failed to remove idle home of %s: %v", identity, err)
+			continue
+		}
+		delete(m.provisioned, identity)
+		delete(m.lastSeen, identity)
+		m.fs.forget(identity)
+		removed = append(removed, identity)
+		log.Infof("[homes] Removed idle home of ephemeral identity %s", identity)
+	}
+	return removed
+}
+
+// mkdirAll creates dir and its missing parents
+func mkdirAll(fs filesystem.FileSystem, dir string) error {
+	if info, err := fs.Stat(dir); err == nil {
+		if !info.IsDir {
+			return filesystem.NewNotDirectoryError(dir)
+		}
+		return nil
+	}
+	if parent := path.Dir(dir); parent != dir {
+		if err := mkdirAll(fs, parent); err != nil {
+			return err
+		}
+	}
+	return fs.Mkdir(dir, 0755)
+}
+
+// copyTree copies the contents of directory src into the existing dst
+func copyTree(fs filesystem.FileSystem, src, dst string) error {
+	entries, err := fs.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		from, to := path.Join(src, entry.Name), path.Join(dst, entry.Name)
+		if entry.IsDir {
+			if err := fs.Mkdir(to, 0755); err != nil {
+				return err
+			}
+			if err := copyTree(fs, from, to); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := fs.Read(from, 0, -1)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if _, err := fs.Write(to, data, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package homes
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func newTestManager(t *testing.T, cfg Config) (*Manager, filesystem.FileSystem) {
+	t.Helper()
+	root := memfs.NewMemoryFS()
+	if cfg.Backend == "" {
+		cfg.Backend = "/store/homes"
+	}
+	m, err := NewManager(root, cfg)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	return m, root
+}
+
+func TestProvisionFromTemplate(t *testing.T) {
+	root := memfs.NewMemoryFS()
+	root.Mkdir("/skel", 0755)
+	root.Mkdir("/skel/notes", 0755)
+	root.Write("/skel/README", []byte("welcome"), -1, filesystem.WriteFlagCreate)
+
+	m, err := NewManager(root, Config{Backend: "/homes", Template: "/skel"})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if err := m.Provision("agent-1"); err != nil {
+		t.Fatalf("Provision() error = %v", err)
+	}
+
+	data, _ := m.fs.Read("/agent-1/README", 0, -1)
+	if string(data) != "welcome" {
+		t.Errorf("README = %q, want template content", data)
+	}
+	if info, err := m.fs.Stat("/agent-1/notes"); err != nil || !info.IsDir {
+		t.Errorf("Stat(notes) = %v, %v; want template directory", info, err)
+	}
+
+	// Existing homes are left alone
+	m.fs.Write("/agent-1/README", []byte("changed"), -1, filesystem.WriteFlagTruncate)
+	m.provisioned = map[string]bool{}
+	if err := m.Provision("agent-1"); err != nil {
+		t.Fatalf("Provision() again error = %v", err)
+	}
+	data, _ = m.fs.Read("/agent-1/README", 0, -1)
+	if string(data) != "changed" {
+		t.Errorf("README = %q after reprovisioning, want changed", data)
+	}
+}
+
+func TestProvisionRejectsBadIdentity(t *testing.T) {
+	m, _ := newTestManager(t, Config{})
+	for _, identity := range []string{"", "..", "a/b", ".hidden"} {
+		if err := m.Provision(identity); !errors.Is(err, filesystem.ErrInvalidArgument) {
+			t.Errorf("Provision(%q) error = %v, want invalid argument", identity, err)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	m, _ := newTestManager(t, Config{})
+	tests := []struct {
+		identity  string
+		path      string
+		recursive bool
+		allowed   bool
+	}{
+		{"", "/home/bob/x", false, true},
+		{"alice", "/home/alice", false, true},
+		{"alice", "/home/alice/notes/a.txt", true, true},
+		{"alice", "/home/bob/a.txt", false, false},
+		{"alice", "/home/alice2", false, false},
+		{"alice", "/home", false, true},
+		{"alice", "/home", true, false},
+		{"alice", "/", true, false},
+		{"alice", "/memfs/shared", true, true},
+	}
+	for _, tt := range tests {
+		err := m.Authorize(tt.identity, tt.path, tt.recursive)
+		if (err == nil) != tt.allowed {
+			t.Errorf("Authorize(%q, %q, %v) = %v, want allowed=%v", tt.identity, tt.path, tt.recursive, err, tt.allowed)
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	m, _ := newTestManager(t, Config{Quota: 10})
+	m.Provision("alice")
+	m.Provision("bob")
+
+	if _, err := m.fs.Write("/alice/a", []byte("12345678"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := m.fs.Write("/alice/b", []byte("123"), -1, filesystem.WriteFlagCreate); !errors.Is(err, filesystem.ErrQuotaExceeded) {
+		t.Fatalf("Write() over quota error = %v, want quota exceeded", err)
+	}
+	// Overwriting with smaller content and other homes are not affected
+	if _, err := m.fs.Write("/alice/a", []byte("12"), -1, filesystem.WriteFlagTruncate); err != nil {
+		t.Fatalf("Write() shrinking error = %v", err)
+	}
+	if _, err := m.fs.Write("/bob/a", []byte("12345678"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() in other home error = %v", err)
+	}
+	if _, err := m.fs.Write("/alice/b", []byte("123"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() after freeing space error = %v", err)
+	}
+
+	if err := m.fs.Remove("/alice/a"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := m.fs.Write("/alice/c", []byte("1234567"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() after remove error = %v", err)
+	}
+}
+
+func TestHomesAreManaged(t *testing.T) {
+	m, _ := newTestManager(t, Config{})
+	m.Provision("alice")
+	m.Provision("bob")
+
+	if err := m.fs.RemoveAll("/alice"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("RemoveAll(home) error = %v, want permission denied", err)
+	}
+	if err := m.fs.Mkdir("/carol", 0755); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Mkdir(new home) error = %v, want permission denied", err)
+	}
+	m.fs.Write("/alice/a", []byte("x"), -1, filesystem.WriteFlagCreate)
+	if err := m.fs.Rename("/alice/a", "/bob/a"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Rename() between homes error = %v, want permission denied", err)
+	}
+}
+
+func TestCleanupEphemeral(t *testing.T) {
+	m, _ := newTestManager(t, Config{Ephemeral: []string{"job-*"}, EphemeralTTL: time.Hour})
+	m.Provision("job-1")
+	m.Provision("job-2")
+	m.Provision("alice")
+
+	now := time.Now()
+	m.lastSeen["job-1"] = now.Add(-2 * time.Hour)
+	m.lastSeen["alice"] = now.Add(-48 * time.Hour)
+
+	removed := m.Cleanup(now)
+	if len(removed) != 1 || removed[0] != "job-1" {
+		t.Fatalf("Cleanup() removed %v, want [job-1]", removed)
+	}
+	if _, err := m.fs.Stat("/job-1"); err == nil {
+		t.Error("idle ephemeral home still exists")
+	}
+	for _, home := range []string{"/job-2", "/alice"} {
+		if _, err := m.fs.Stat(home); err != nil {
+			t.Errorf("Stat(%s) error = %v, want kept", home, err)
+		}
+	}
+
+	// A removed home is provisioned again on the next request
+	if err := m.Provision("job-1"); err != nil {
+		t.Fatalf("Provision() error = %v", err)
+	}
+	if _, err := m.fs.Stat("/job-1"); err != nil {
+		t.Errorf("Stat(job-1) after reprovisioning error = %v", err)
+	}
+}
+
+func TestNewManagerValidation(t *testing.T) {
+	root := memfs.NewMemoryFS()
+	for name, cfg := range map[string]Config{
+		"missing backend":    {},
+		"relative backend":   {Backend: "homes"},
+		"backend under path": {Backend: "/home/store"},
+		"bad pattern":        {Backend: "/store", Ephemeral: []string{"["}},
+	} {
+		if _, err := NewManager(root, cfg); err == nil {
+			t.Errorf("%s: NewManager() succeeded, want error", name)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package mountablefs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"    // Operations reach the backend
+	BreakerOpen     = "open"      // Operations fail fast
+	BreakerHalfOpen = "half-open" // One probe operation tests the backend
+)
+
+// breakerMiddleware stops calling a backend that keeps failing. After
+// failures consecutive backend errors the breaker opens and operations fail
+// with filesystem.ErrBackendUnavailable without reaching the backend. Once
+// cooldown has passed, the next operation is let through as a probe: if it
+// succeeds the breaker closes, otherwise it stays open for another cooldown.
+//
+// Errors about the request rather than the backend, such as not found or
+// permission denied, don't count as failures. Options: failures (default
+// 5) and cooldown (default "30s").
+type breakerMiddleware struct {
+	failures int
+	cooldown time.Duration
+}
+
+func newBreakerMiddleware(config map[string]interface{}) (Middleware, error) {
+	if err := pluginconfig.ValidateOnlyKnownKeys(config, []string{"failures", "cooldown"}); err != nil {
+		return nil, err
+	}
+	if err := pluginconfig.ValidateIntType(config, "failures"); err != nil {
+		return nil, err
+	}
+	m := &breakerMiddleware{
+		failures: pluginconfig.GetIntConfig(config, "failures", 5),
+		cooldown: 30 * time.Second,
+	}
+	if m.failures < 1 {
+		return nil, fmt.Errorf("failures must be at least 1")
+	}
+	if v, ok := config["cooldown"]; ok {
+		s, ok := v.(string)
+		d, err := time.ParseDuration(s)
+		if !ok || err != nil || d <= 0 {
+			return nil, fmt.Errorf("cooldown must be a positive duration such as \"30s\"")
+		}
+		m.cooldown = d
+	}
+	return m, nil
+}
+
+func (m *breakerMiddleware) Name() string { return "circuit_breaker" }
+
+func (m *breakerMiddleware) Wrap(next filesystem.FileSystem) filesystem.FileSystem {
+	return &breakerFS{FileSystem: next, m: m, state: BreakerClosed, now: time.Now}
+}
+
+// BreakerStatus reports the circuit breaker of a mount
+type BreakerStatus struct {
+	Path                string    `json:"path"`
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Trips               int64     `json:"trips"`    // Times the breaker opened
+	Rejected            int64     `json:"rejected"` // Operations failed fast
+	LastError           string    `json:"lastError,omitempty"`
+	RetryAt             time.Time `json:"retryAt,omitempty"` // When an open breaker lets a probe through
+}
+
+type breakerFS struct {
+	filesystem.FileSystem
+	m   *breakerMiddleware
+	now func() time.Time
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool // A half-open probe is in flight
+	trips     int64
+	rejected  int64
+	lastError string
+}
+
+func (fs *breakerFS) Unwrap() filesystem.FileSystem { return fs.FileSystem }
+
+// requestErrorMessages are found in the untyped errors plugins return for
+// requests that are wrong, such as memfs's "no such file or directory"
+var requestErrorMessages = []string{"no such file", "not found", "not a directory", "is a directory", "already exists", "not permitted", "read-only"}
+
+// isBackendFailure reports whether err says the backend is unhealthy, as
+// opposed to the request being wrong
+func isBackendFailure(err error) bool {
+	if err == nil || err == io.EOF || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrExist) {
+		return false
+	}
+	for _, target := range []error{
+		filesystem.ErrNotFound,
+		filesystem.ErrPermissionDenied,
+		filesystem.ErrInvalidArgument,
+		filesystem.ErrAlreadyExists,
+		filesystem.ErrNotDirectory,
+		filesystem.ErrNotSupported,
+		filesystem.ErrQuotaExceeded,
+		filesystem.ErrRateLimited,
+		filesystem.ErrBackendUnavailable,
+	} {
+		if errors.Is(err, target) {
+			return false
+		}
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range requestErrorMessages {
+		if strings.Contains(msg, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// allow decides whether an operation may reach the backend. probe is true
+// when the operation tests a half-open breaker.
+func (fs *breakerFS) allow(path string) (probe bool, err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	switch fs.state {
+	case BreakerClosed:
+		return false, nil
+	case BreakerOpen:
+		if wait := fs.openedAt.Add(fs.m.cooldown).Sub(fs.now()); wait > 0 {
+			fs.rejected++
+			return false, filesystem.NewBackendUnavailableError(path, wait, fs.lastError)
+		}
+		fs.state = BreakerHalfOpen
+	}
+	if fs.probing {
+		fs.rejected++
+		return false, filesystem.NewBackendUnavailableError(path, 0, fs.lastError)
+	}
+	fs.probing = true
+	return true, nil
+}
+
+// record updates the breaker with the outcome of an operation
+func (fs *breakerFS) record(probe bool, err error) {
+	failed := isBackendFailure(err)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if probe {
+		fs.probing = false
+	}
+	if !failed {
+		if probe && fs.state == BreakerHalfOpen {
+			log.Infof("[circuit_breaker] backend recovered, closing breaker")
+			fs.state = BreakerClosed
+		}
+		fs.failures = 0
+		return
+	}
+
+	fs.failures++
+	fs.lastError = err.Error()
+	if probe || (fs.state == BreakerClosed && fs.failures >= fs.m.failures) {
+		if fs.state == BreakerClosed {
+			fs.trips++
+			log.Warnf("[circuit_breaker] opening breaker after %d consecutive failures: %v", fs.failures, err)
+		}
+		fs.state = BreakerOpen
+		fs.openedAt = fs.now()
+	}
+}
+
+func (fs *breakerFS) status() BreakerStatus {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	s := BreakerStatus{
+		State:               fs.state,
+		ConsecutiveFailures: fs.failures,
+		Trips:               fs.trips,
+		Rejected:            fs.rejected,
+		LastError:           fs.lastError,
+	}
+	if fs.state == BreakerOpen {
+		s.RetryAt = fs.openedAt.Add(fs.m.cooldown)
+	}
+	return s
+}
+
+// guard runs an operation through the breaker
+func guard[T any](fs *breakerFS, path string, call func() (T, error)) (T, error) {
+	probe, err := fs.allow(path)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	v, err := call()
+	fs.record(probe, err)
+	return v, err
+}
+
+func (fs *breakerFS) guardErr(path string, call func() error) error {
+	_, err := guard(fs, path, func() (struct{}, error) { return struct{}{}, call() })
+	return err
+}
+
+func (fs *breakerFS) Create(path string) error {
+	return fs.guardErr(path, func() error { return fs.FileSystem.Create(path) })
+}
+
+func (fs *breakerFS) Mkdir(path string, perm uint32) error {
+	return fs.guardErr(path, func() error { return fs.FileSystem.Mkdir(path, perm) })
+}
+
+func (fs *breakerFS) Remove(path string) error {
+	return fs.guardErr(path, func() error { return fs.FileSystem.Remove(path) })
+}
+
+func (fs *breakerFS) RemoveAll(path string) error {
+	return fs.guardErr(path, func() error { return fs.FileSystem.RemoveAll(path) })
+}
+
+func (fs *breakerFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	return guard(fs, path, func() ([]byte, error) { return fs.FileSystem.Read(path, offset, size) })
+}
+
+func (fs *breakerFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	return guard(fs, path, func() (int64, error) { return fs.FileSystem.Write(path, data, offset, flags) })
+}
+
+func (fs *breakerFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	return guard(fs, path, func() ([]filesystem.FileInfo, error) { return fs.FileSystem.ReadDir(path) })
+}
+
+func (fs *breakerFS) Stat(path string) (*filesystem.FileInfo, error) {
+	return guard(fs, path, func() (*filesystem.FileInfo, error) { return fs.FileSystem.Stat(path) })
+}
+
+func (fs *breakerFS) Rename(oldPath, newPath string) error {
+	return fs.guardErr(oldPath, func() error { return fs.FileSystem.Rename(oldPath, newPath) })
+}
+
+func (fs *breakerFS) Chmod(path string, mode uint32) error {
+	return fs.guardErr(path, func() error { return fs.FileSystem.Chmod(path, mode) })
+}
+
+func (fs *breakerFS) Open(path string) (io.ReadCloser, error) {
+	return guard(fs, path, func() (io.ReadCloser, error) { return fs.FileSystem.Open(path) })
+}
+
+func (fs *breakerFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return guard(fs, path, func() (io.WriteCloser, error) { return fs.FileSystem.OpenWrite(path) })
+}
+
+// CircuitBreakers returns the state of the circuit breakers of all mounts
+// that have one, ordered by mount path
+func (mfs *MountableFS) CircuitBreakers() []BreakerStatus {
+	var statuses []BreakerStatus
+	for _, mount := range mfs.GetMounts() {
+		breaker, ok := filesystem.As[*breakerFS](mount.fileSystem())
+		if !ok {
+			continue
+		}
+		s := breaker.status()
+		s.Path = mount.Path
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Path < statuses[j].Path })
+	return statuses
+}
@@ -0,0 +1,449 @@
+package mountablefs
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// cacheMiddleware keeps the content of files read from a mount in memory,
+// so that reading them again doesn't reach the backend. Files are cached by
+// whole reads and by prefetching (filesystem.Prefetcher). Changes made
+// through the mount drop the cached content; changes made behind its back
+// are seen once the content expires. Options:
+//   - max_size: memory for cached content (default "64MB")
+//   - max_file_size: larger files are not cached (default "8MB")
+//   - ttl: how long content is served from memory (default "5m")
+//   - prefetch_on_readdir: listing a directory also loads its files in the
+//     background (default false)
+//   - prefetch_max_files: files loaded per listing (default 32)
+type cacheMiddleware struct {
+	maxSize           int64
+	maxFileSize       int64
+	ttl               time.Duration
+	prefetchOnReadDir bool
+	prefetchMaxFiles  int
+}
+
+func newCacheMiddleware(config map[string]interface{}) (Middleware, error) {
+	if err := pluginconfig.ValidateOnlyKnownKeys(config, []string{"max_size", "max_file_size", "ttl", "prefetch_on_readdir", "prefetch_max_files"}); err != nil {
+		return nil, err
+	}
+	if err := pluginconfig.ValidateBoolType(config, "prefetch_on_readdir"); err != nil {
+		return nil, err
+	}
+	if err := pluginconfig.ValidateIntType(config, "prefetch_max_files"); err != nil {
+		return nil, err
+	}
+	m := &cacheMiddleware{
+		ttl:               5 * time.Minute,
+		prefetchOnReadDir: pluginconfig.GetBoolConfig(config, "prefetch_on_readdir", false),
+		prefetchMaxFiles:  pluginconfig.GetIntConfig(config, "prefetch_max_files", 32),
+	}
+	var err error
+	if m.maxSize, err = pluginconfig.GetSizeConfig(config, "max_size", 64*1024*1024); err != nil {
+		return nil, fmt.Errorf("max_size: %w", err)
+	}
+	if m.maxFileSize, err = pluginconfig.GetSizeConfig(config, "max_file_size", 8*1024*1024); err != nil {
+		return nil, fmt.Errorf("max_file_size: %w", err)
+	}
+	if m.maxSize <= 0 || m.maxFileSize <= 0 {
+		return nil, fmt.Errorf("max_size and max_file_size must be positive")
+	}
+	m.maxFileSize = min(m.maxFileSize, m.maxSize)
+	if m.prefetchMaxFiles < 1 {
+		return nil, fmt.Errorf("prefetch_max_files must be at least 1")
+	}
+	if v, ok := config["ttl"]; ok {
+		s, ok := v.(string)
+		d, err := time.ParseDuration(s)
+		if !ok || err != nil || d <= 0 {
+			return nil, fmt.Errorf("ttl must be a positive duration such as \"5m\"")
+		}
+		m.ttl = d
+	}
+	return m, nil
+}
+
+func (m *cacheMiddleware) Name() string { return "cache" }
+
+func (m *cacheMiddleware) Wrap(next filesystem.FileSystem) filesystem.FileSystem {
+	return &cacheFS{
+		FileSystem: next,
+		m:          m,
+		now:        time.Now,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		loading:    make(map[string]bool),
+	}
+}
+
+type cacheEntry struct {
+	path     string
+	data     []byte
+	loadedAt time.Time
+}
+
+type cacheFS struct {
+	filesystem.FileSystem
+	m   *cacheMiddleware
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Of *cacheEntry, most recently used first
+	size    int64
+	gen     uint64          // Bumped by every invalidation
+	loading map[string]bool // Background prefetches in flight
+}
+
+func (fs *cacheFS) Unwrap() filesystem.FileSystem { return fs.FileSystem }
+
+// get returns the cached content of path, if fresh
+func (fs *cacheFS) get(path string) ([]byte, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	elem, ok := fs.entries[path]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if fs.now().Sub(entry.loadedAt) > fs.m.ttl {
+		fs.remove(elem)
+		return nil, false
+	}
+	fs.lru.MoveToFront(elem)
+	return entry.data, true
+}
+
+// generation returns the invalidation count, to be passed to put with
+// content read afterwards
+func (fs *cacheFS) generation() uint64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.gen
+}
+
+// put caches the content of path read at generation gen. Content read
+// before an invalidation may be stale and is dropped.
+func (fs *cacheFS) put(path string, data []byte, gen uint64) {
+	if int64(len(data)) > fs.m.maxFileSize {
+		return
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if gen != fs.gen {
+		return
+	}
+	if elem, ok := fs.entries[path]; ok {
+		fs.remove(elem)
+	}
+	fs.entries[path] = fs.lru.PushFront(&cacheEntry{path: path, data: data, loadedAt: fs.now()})
+	fs.size += int64(len(data))
+	for fs.size > fs.m.maxSize {
+		fs.remove(fs.lru.Back())
+	}
+}
+
+// remove drops an entry. Called with fs.mu held.
+func (fs *cacheFS) remove(elem *list.Element) {
+	entry := fs.lru.Remove(elem).(*cacheEntry)
+	delete(fs.entries, entry.path)
+	fs.size -= int64(len(entry.data))
+}
+
+// invalidate drops the content of path, and with tree that of the files
+// below it
+func (fs *cacheFS) invalidate(path string, tree bool) {
+	path = filesystem.NormalizePath(path)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.gen++
+	if elem, ok := fs.entries[path]; ok {
+		fs.remove(elem)
+	}
+	if !tree {
+		return
+	}
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for p, elem := range fs.entries {
+		if strings.HasPrefix(p, prefix) {
+			fs.remove(elem)
+		}
+	}
+}
+
+// cacheable reports whether reading path has no side effects and returns
+// a file's content, unlike queues and streams
+func (fs *cacheFS) cacheable(path string) bool {
+	if rd, ok := filesystem.As[filesystem.ReadDestructiveFS](fs.FileSystem); ok && rd.IsReadDestructive(path) {
+		return false
+	}
+	if b, ok := filesystem.As[filesystem.BroadcastFS](fs.FileSystem); ok && b.IsBroadcast(path) {
+		return false
+	}
+	return true
+}
+
+func (fs *cacheFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	path = filesystem.NormalizePath(path)
+	if data, ok := fs.get(path); ok {
+		return plugin.ApplyRangeRead(data, offset, size)
+	}
+	if offset > 0 || size >= 0 || !fs.cacheable(path) {
+		return fs.FileSystem.Read(path, offset, size)
+	}
+	gen := fs.generation()
+	data, err := fs.FileSystem.Read(path, offset, size)
+	if err == nil || err == io.EOF {
+		fs.put(path, data, gen)
+	}
+	return data, err
+}
+
+func (fs *cacheFS) Open(path string) (io.ReadCloser, error) {
+	if data, ok := fs.get(filesystem.NormalizePath(path)); ok {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return fs.FileSystem.Open(path)
+}
+
+// Prefetch implements filesystem.Prefetcher. Directories, files that
+// can't be cached and files reported empty, as virtual files often are,
+// return ErrNotSupported.
+func (fs *cacheFS) Prefetch(path string) error {
+	path = filesystem.NormalizePath(path)
+	if _, ok := fs.get(path); ok {
+		return nil
+	}
+	info, err := fs.FileSystem.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir || info.Size == 0 || info.Size > fs.m.maxFileSize || !fs.cacheable(path) {
+		return filesystem.NewNotSupportedError("prefetch", path)
+	}
+	gen := fs.generation()
+	data, err := fs.FileSystem.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	fs.put(path, data, gen)
+	return nil
+}
+
+func (fs *cacheFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	infos, err := fs.FileSystem.ReadDir(path)
+	if err == nil && fs.m.prefetchOnReadDir {
+		fs.prefetchListing(filesystem.NormalizePath(path), infos)
+	}
+	return infos, err
+}
+
+// prefetchListing loads the first files of a listing in the background,
+// on the guess that files listed are about to be read
+func (fs *cacheFS) prefetchListing(dir string, infos []filesystem.FileInfo) {
+	var paths []string
+	fs.mu.Lock()
+	for _, info := range infos {
+		if len(paths) == fs.m.prefetchMaxFiles {
+			break
+		}
+		p := strings.TrimSuffix(dir, "/") + "/" + info.Name
+		if info.IsDir || info.Size == 0 || info.Size > fs.m.maxFileSize || fs.loading[p] || fs.entries[p] != nil {
+			continue
+		}
+		fs.loading[p] = true
+		paths = append(paths, p)
+	}
+	fs.mu.Unlock()
+	if len(paths) == 0 {
+		return
+	}
+
+	go func() {
+		for _, p := range paths {
+			if err := fs.Prefetch(p); err != nil && !errors.Is(err, filesystem.ErrNotSupported) {
+				log.Debugf("[cache] prefetch of %s failed: %v", p, err)
+			}
+			fs.mu.Lock()
+			delete(fs.loading, p)
+			fs.mu.Unlock()
+		}
+	}()
+}
+
+func (fs *cacheFS) Create(path string) error {
+	defer fs.invalidate(path, false)
+	return fs.FileSystem.Create(path)
+}
+
+func (fs *cacheFS) Remove(path string) error {
+	defer fs.invalidate(path, false)
+	return fs.FileSystem.Remove(path)
+}
+
+func (fs *cacheFS) RemoveAll(path string) error {
+	defer fs.invalidate(path, true)
+	return fs.FileSystem.RemoveAll(path)
+}
+
+func (fs *cacheFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	defer fs.invalidate(path, false)
+	return fs.FileSystem.Write(path, data, offset, flags)
+}
+
+func (fs *cacheFS) Rename(oldPath, newPath string) error {
+	defer fs.invalidate(newPath, true)
+	defer fs.invalidate(oldPath, true)
+	return fs.FileSystem.Rename(oldPath, newPath)
+}
+
+func (fs *cacheFS) OpenWrite(path string) (io.WriteCloser, error) {
+	w, err := fs.FileSystem.OpenWrite(path)
+	if err != nil {
+		return nil, err
+	}
+	fs.invalidate(path, false)
+	return &cacheWriter{WriteCloser: w, fs: fs, path: path}, nil
+}
+
+// cacheWriter drops the cached content of its file once written
+type cacheWriter struct {
+	io.WriteCloser
+	fs   *cacheFS
+	path string
+}
+
+func (w *cacheWriter) Close() error {
+	defer w.fs.invalidate(w.path, false)
+	return w.WriteCloser.Close()
+}
+
+// The write-capable optional interfaces are implemented too, or callers
+// would find them on the wrapped file system and bypass invalidation
+
+func (fs *cacheFS) Truncate(path string, size int64) error {
+	t, ok := filesystem.As[filesystem.Truncater](fs.FileSystem)
+	if !ok {
+		return filesystem.NewNotSupportedError("truncate", path)
+	}
+	defer fs.invalidate(path, false)
+	return t.Truncate(path, size)
+}
+
+func (fs *cacheFS) Reserve(path string, size int64) error {
+	r, ok := filesystem.As[filesystem.Reserver](fs.FileSystem)
+	if !ok {
+		return fs.Create(path)
+	}
+	defer fs.invalidate(path, false)
+	return r.Reserve(path, size)
+}
+
+func (fs *cacheFS) BeginUpload(path string, partSize int64) (filesystem.Upload, error) {
+	return beginHookedUpload(fs, fs.FileSystem, path, partSize, func(error) { fs.invalidate(path, false) })
+}
+
+func (fs *cacheFS) WriteAt(path string, data []byte, offset int64) (int64, error) {
+	rw, ok := filesystem.As[filesystem.RandomWriter](fs.FileSystem)
+	if !ok {
+		return 0, filesystem.NewNotSupportedError("writeat", path)
+	}
+	defer fs.invalidate(path, false)
+	return rw.WriteAt(path, data, offset)
+}
+
+func (fs *cacheFS) Increment(path string, delta int64) (int64, error) {
+	inc, ok := filesystem.As[filesystem.Incrementer](fs.FileSystem)
+	if !ok {
+		return 0, filesystem.NewNotSupportedError("increment", path)
+	}
+	defer fs.invalidate(path, false)
+	return inc.Increment(path, delta)
+}
+
+func (fs *cacheFS) Append(path string, data []byte) (int64, error) {
+	appender, ok := filesystem.As[filesystem.Appender](fs.FileSystem)
+	if !ok {
+		// Left to filesystem.AppendLog, whose write invalidates the cache
+		return 0, filesystem.ErrNotSupported
+	}
+	defer fs.invalidate(path, false)
+	return appender.Append(path, data)
+}
+
+func (fs *cacheFS) OpenHandle(path string, flags filesystem.OpenFlag, mode uint32) (filesystem.FileHandle, error) {
+	handleFS, ok := filesystem.As[filesystem.HandleFS](fs.FileSystem)
+	if !ok {
+		return nil, filesystem.NewNotSupportedError("openhandle", path)
+	}
+	h, err := handleFS.OpenHandle(path, flags, mode)
+	if err != nil {
+		return nil, err
+	}
+	return fs.wrapHandle(h), nil
+}
+
+func (fs *cacheFS) GetHandle(id int64) (filesystem.FileHandle, error) {
+	handleFS, ok := filesystem.As[filesystem.HandleFS](fs.FileSystem)
+	if !ok {
+		return nil, filesystem.ErrNotFound
+	}
+	h, err := handleFS.GetHandle(id)
+	if err != nil {
+		return nil, err
+	}
+	return fs.wrapHandle(h), nil
+}
+
+func (fs *cacheFS) CloseHandle(id int64) error {
+	handleFS, ok := filesystem.As[filesystem.HandleFS](fs.FileSystem)
+	if !ok {
+		return filesystem.ErrNotFound
+	}
+	if h, err := handleFS.GetHandle(id); err == nil {
+		defer fs.invalidate(h.Path(), false)
+	}
+	return handleFS.CloseHandle(id)
+}
+
+// wrapHandle makes writes through a writable handle drop the cached content
+// of its file
+func (fs *cacheFS) wrapHandle(h filesystem.FileHandle) filesystem.FileHandle {
+	if h.Flags()&(filesystem.O_WRONLY|filesystem.O_RDWR|filesystem.O_APPEND|filesystem.O_CREATE|filesystem.O_TRUNC) == 0 {
+		return h
+	}
+	fs.invalidate(h.Path(), false)
+	return &cacheHandle{FileHandle: h, fs: fs}
+}
+
+type cacheHandle struct {
+	filesystem.FileHandle
+	fs *cacheFS
+}
+
+func (h *cacheHandle) Write(data []byte) (int, error) {
+	defer h.fs.invalidate(h.Path(), false)
+	return h.FileHandle.Write(data)
+}
+
+func (h *cacheHandle) WriteAt(data []byte, offset int64) (int, error) {
+	defer h.fs.invalidate(h.Path(), false)
+	return h.FileHandle.WriteAt(data, offset)
+}
+
+func (h *cacheHandle) Close() error {
+	defer h.fs.invalidate(h.Path(), false)
+	return h.FileHandle.Close()
+}
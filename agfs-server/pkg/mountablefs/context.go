@@ -0,0 +1,46 @@
+package mountablefs
+
+import (
+	"context"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// MountableFS passes the context of its callers down to the mounts, so
+// that a request abandoned by its client stops its backend call
+var _ filesystem.ContextFileSystem = (*MountableFS)(nil)
+
+// The helpers below call the context-aware method of fs when it has one
+// and ctx can be cancelled. Only fs itself counts, as with the timeout
+// middleware: a mount with middleware is instead checked for cancellation
+// before the call, which then runs to completion.
+
+func readContext(ctx context.Context, fs filesystem.FileSystem, path string, offset int64, size int64) ([]byte, error) {
+	if cfs, ok := fs.(filesystem.ContextFileSystem); ok && ctx.Done() != nil {
+		return cfs.ReadContext(ctx, path, offset, size)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return fs.Read(path, offset, size)
+}
+
+func writeContext(ctx context.Context, fs filesystem.FileSystem, path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	if cfs, ok := fs.(filesystem.ContextFileSystem); ok && ctx.Done() != nil {
+		return cfs.WriteContext(ctx, path, data, offset, flags)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return fs.Write(path, data, offset, flags)
+}
+
+func readDirContext(ctx context.Context, fs filesystem.FileSystem, path string) ([]filesystem.FileInfo, error) {
+	if cfs, ok := fs.(filesystem.ContextFileSystem); ok && ctx.Done() != nil {
+		return cfs.ReadDirContext(ctx, path)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return fs.ReadDir(path)
+}
@@ -0,0 +1,84 @@
+package mountablefs
+
+import (
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestDryRunRemove(t *testing.T) {
+	mfs := newTrashTestFS(t)
+	mfs.Mkdir("/data/docs", 0755)
+	writeTrashTestFile(t, mfs, "/data/docs/a.txt", "hello")
+	writeTrashTestFile(t, mfs, "/data/docs/b.txt", "world!")
+
+	result, err := mfs.DryRun(filesystem.DryRunOp{Op: filesystem.DryRunRemove, Path: "/data/docs"})
+	if err != nil {
+		t.Fatalf("DryRun() error = %v", err)
+	}
+	if result.Allowed || len(result.Verdicts) != 1 || result.Verdicts[0].Policy != "precondition" {
+		t.Errorf("non-recursive remove of a directory = %+v, want denied", result)
+	}
+
+	result, err = mfs.DryRun(filesystem.DryRunOp{Op: filesystem.DryRunRemove, Path: "/data/docs", Recursive: true})
+	if err != nil {
+		t.Fatalf("DryRun() error = %v", err)
+	}
+	if !result.Allowed || result.Paths != 3 || result.Bytes != 11 {
+		t.Errorf("recursive remove = %+v, want 3 paths and 11 bytes", result)
+	}
+	for _, e := range result.Effects {
+		if e.Action != "trash" {
+			t.Errorf("effect %+v, want trash on a mount with a trash", e)
+		}
+	}
+	if result.Effects[len(result.Effects)-1].Path != "/data/docs" {
+		t.Errorf("last effect = %+v, want the directory itself", result.Effects[len(result.Effects)-1])
+	}
+
+	if _, err := mfs.Stat("/data/docs/a.txt"); err != nil {
+		t.Errorf("dry run removed the file: %v", err)
+	}
+}
+
+func TestDryRunWriteAndRename(t *testing.T) {
+	mfs := newTrashTestFS(t)
+	other := memfs.NewMemFSPlugin()
+	other.Initialize(map[string]interface{}{})
+	mfs.Mount("/other", other)
+	defer mfs.Unmount("/other")
+	writeTrashTestFile(t, mfs, "/data/a.txt", "hello")
+
+	tests := []struct {
+		op      filesystem.DryRunOp
+		allowed bool
+		action  string
+	}{
+		{filesystem.DryRunOp{Op: filesystem.DryRunWrite, Path: "/data/new.txt", Size: 3}, true, "create"},
+		{filesystem.DryRunOp{Op: filesystem.DryRunWrite, Path: "/data/a.txt", Size: 3}, true, "overwrite"},
+		{filesystem.DryRunOp{Op: filesystem.DryRunWrite, Path: "/data/a.txt", Flags: filesystem.WriteFlagCreate | filesystem.WriteFlagExclusive}, false, ""},
+		{filesystem.DryRunOp{Op: filesystem.DryRunWrite, Path: "/data/missing/x", Size: 1}, false, ""},
+		{filesystem.DryRunOp{Op: filesystem.DryRunRename, Path: "/data/a.txt", Target: "/data/b.txt"}, true, "rename"},
+		{filesystem.DryRunOp{Op: filesystem.DryRunRename, Path: "/data/a.txt", Target: "/other/a.txt"}, false, ""},
+		{filesystem.DryRunOp{Op: filesystem.DryRunMkdir, Path: "/data/.snapshots/x"}, false, ""},
+	}
+	for _, tt := range tests {
+		result, err := mfs.DryRun(tt.op)
+		if err != nil {
+			t.Fatalf("DryRun(%+v) error = %v", tt.op, err)
+		}
+		if result.Allowed != tt.allowed {
+			t.Errorf("DryRun(%+v) allowed = %v, want %v: %+v", tt.op, result.Allowed, tt.allowed, result.Verdicts)
+			continue
+		}
+		if tt.action != "" && (len(result.Effects) != 1 || result.Effects[0].Action != tt.action) {
+			t.Errorf("DryRun(%+v) effects = %+v, want %s", tt.op, result.Effects, tt.action)
+		}
+	}
+
+	result, _ := mfs.DryRun(filesystem.DryRunOp{Op: filesystem.DryRunRename, Path: "/data/a.txt", Target: "/data/b.txt"})
+	if e := result.Effects[0]; e.Path != "/data/a.txt" || e.Target != "/data/b.txt" {
+		t.Errorf("rename effect = %+v, want paths in the global namespace", e)
+	}
+}
@@ -0,0 +1,303 @@
+package mountablefs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// historyFileSuffix names the file a mount's history is persisted to:
+// <dir>/<escaped mount path>.ndjson
+const historyFileSuffix = ".ndjson"
+
+// EventHistoryConfig bounds the events kept per mount so that watchers can
+// resume from a cursor after disconnecting
+type EventHistoryConfig struct {
+	MaxEvents int           // Events kept per mount; 0 disables history
+	MaxAge    time.Duration // Older events are dropped; 0 keeps them until MaxEvents pushes them out
+	Dir       string        // Host directory the history is persisted to, so it survives restarts (optional)
+}
+
+// eventLog is the retained history of one mount, oldest first
+type eventLog struct {
+	events []filesystem.Event
+	lost   uint64 // Highest cursor dropped from the log; replays from before it have a gap
+
+	file  *os.File // Append-only persistence, nil when not persisted
+	lines int      // Lines in file, compacted when far above len(events)
+}
+
+// eventHistory keeps an eventLog per mount. It is guarded by the hub lock.
+type eventHistory struct {
+	cfg   EventHistoryConfig
+	floor uint64 // Events up to this cursor are unknown for mounts without a log
+	logs  map[string]*eventLog
+}
+
+// historyLine is a line of a persisted log: an event, or a marker carrying
+// the log's lost cursor
+type historyLine struct {
+	filesystem.Event
+	Lost uint64 `json:"lost,omitempty"`
+}
+
+// newEventHistory creates the history and loads persisted logs. Events up to
+// cursor last happened before the history existed. It returns the highest
+// cursor found so new events continue after it.
+func newEventHistory(cfg EventHistoryConfig, last uint64) (*eventHistory, uint64, error) {
+	h := &eventHistory{cfg: cfg, floor: last, logs: make(map[string]*eventLog)}
+	if cfg.Dir == "" {
+		return h, 0, nil
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, 0, fmt.Errorf("failed to create event history directory: %w", err)
+	}
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read event history directory: %w", err)
+	}
+
+	// With persisted logs, mounts without one had no events
+	if len(entries) > 0 {
+		h.floor = 0
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), historyFileSuffix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		mount, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+		l, err := loadEventLog(filepath.Join(cfg.Dir, entry.Name()))
+		if err != nil {
+			h.close()
+			return nil, 0, err
+		}
+		if n := len(l.events); n > 0 && l.events[n-1].Cursor > last {
+			last = l.events[n-1].Cursor
+		}
+		h.trim(l, now)
+		h.logs[mount] = l
+	}
+	return h, last, nil
+}
+
+// loadEventLog reads a persisted log and opens it for appending. A torn
+// last line, left by a crash, is skipped.
+func loadEventLog(name string) (*eventLog, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event history: %w", err)
+	}
+	l := &eventLog{file: f}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		l.lines++
+		var line historyLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			log.Warnf("[mountablefs] Skipping corrupt line %d of %s", l.lines, name)
+			continue
+		}
+		if line.Lost > l.lost {
+			l.lost = line.Lost
+		}
+		if line.Type != "" {
+			l.events = append(l.events, line.Event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read event history %s: %w", name, err)
+	}
+	return l, nil
+}
+
+// logOf returns the log of a mount, creating it on first use
+func (h *eventHistory) logOf(mount string) *eventLog {
+	l, ok := h.logs[mount]
+	if !ok {
+		l = &eventLog{lost: h.floor}
+		if h.cfg.Dir != "" {
+			f, err := os.OpenFile(h.fileName(mount), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				log.Warnf("[mountablefs] Event history of %s is not persisted: %v", mount, err)
+			} else {
+				l.file = f
+				l.persist([]byte(fmt.Sprintf(`{"lost":%d}`, l.lost)))
+			}
+		}
+		h.logs[mount] = l
+	}
+	return l
+}
+
+func (h *eventHistory) fileName(mount string) string {
+	return filepath.Join(h.cfg.Dir, url.PathEscape(mount)+historyFileSuffix)
+}
+
+// append records an event of a mount
+func (h *eventHistory) append(mount string, ev filesystem.Event) {
+	l := h.logOf(mount)
+	l.events = append(l.events, ev)
+	if l.file != nil {
+		data, _ := json.Marshal(historyLine{Event: ev})
+		l.persist(data)
+	}
+	h.trim(l, ev.Time)
+	if l.file != nil && l.lines > 2*len(l.events)+h.cfg.MaxEvents {
+		h.compact(mount, l)
+	}
+}
+
+// trim drops the events beyond the retention bounds
+func (h *eventHistory) trim(l *eventLog, now time.Time) {
+	drop := 0
+	if over := len(l.events) - h.cfg.MaxEvents; over > 0 {
+		drop = over
+	}
+	if h.cfg.MaxAge > 0 {
+		cutoff := now.Add(-h.cfg.MaxAge)
+		for drop < len(l.events) && l.events[drop].Time.Before(cutoff) {
+			drop++
+		}
+	}
+	if drop == 0 {
+		return
+	}
+	if c := l.events[drop-1].Cursor; c > l.lost {
+		l.lost = c
+	}
+	l.events = l.events[drop:]
+}
+
+func (l *eventLog) persist(data []byte) {
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		log.Warnf("[mountablefs] Failed to persist event: %v", err)
+		return
+	}
+	l.lines++
+}
+
+// compact rewrites a persisted log with only the retained events
+func (h *eventHistory) compact(mount string, l *eventLog) {
+	name := h.fileName(mount)
+	tmp, err := os.CreateTemp(h.cfg.Dir, ".history-*")
+	if err != nil {
+		log.Warnf("[mountablefs] Failed to compact event history of %s: %v", mount, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	fmt.Fprintf(w, "{\"lost\":%d}\n", l.lost)
+	for _, ev := range l.events {
+		data, _ := json.Marshal(historyLine{Event: ev})
+		w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		log.Warnf("[mountablefs] Failed to compact event history of %s: %v", mount, err)
+		return
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		log.Warnf("[mountablefs] Failed to compact event history of %s: %v", mount, err)
+		return
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Warnf("[mountablefs] Event history of %s is no longer persisted: %v", mount, err)
+		f = nil
+	}
+	l.file.Close()
+	l.file = f
+	l.lines = len(l.events) + 1
+}
+
+// replay returns the retained events after cursor that w wants, in order,
+// and whether events after cursor that may concern w are no longer retained
+func (h *eventHistory) replay(w *subscription, cursor uint64) ([]filesystem.Event, bool) {
+	var events []filesystem.Event
+	gap := cursor < h.floor
+	for mount, l := range h.logs {
+		if !mountRelated(mount, w.root) {
+			continue
+		}
+		if cursor < l.lost {
+			gap = true
+		}
+		i := sort.Search(len(l.events), func(i int) bool { return l.events[i].Cursor > cursor })
+		for _, ev := range l.events[i:] {
+			if w.wants(ev) {
+				events = append(events, ev)
+			}
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Cursor < events[j].Cursor })
+	return events, gap
+}
+
+// mountRelated reports whether events of a mount can concern a watch of root
+func mountRelated(mount, root string) bool {
+	return mount == "/" || root == "/" || mount == root ||
+		strings.HasPrefix(mount, root+"/") || strings.HasPrefix(root, mount+"/")
+}
+
+func (h *eventHistory) close() {
+	for _, l := range h.logs {
+		if l.file != nil {
+			l.file.Close()
+		}
+	}
+}
+
+// SetEventHistory keeps recent events per mount so watchers can resume from
+// the cursor of the last event they received (filesystem.WatchOptions.Since).
+// With cfg.Dir set, the history is loaded from and persisted to that
+// directory.
+func (mfs *MountableFS) SetEventHistory(cfg EventHistoryConfig) error {
+	if cfg.MaxEvents < 0 || cfg.MaxAge < 0 {
+		return fmt.Errorf("event history bounds must not be negative")
+	}
+	h := &mfs.watches
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.history != nil {
+		h.history.close()
+		h.history = nil
+	}
+	if cfg.MaxEvents == 0 {
+		return nil
+	}
+	history, last, err := newEventHistory(cfg, h.seq)
+	if err != nil {
+		return err
+	}
+	if last > h.seq {
+		h.seq = last
+	}
+	h.history = history
+	return nil
+}
+
+// eventMount returns the mount point whose history records events of p
+func (mfs *MountableFS) eventMount(p string) string {
+	if mount, _, ok := mfs.findMount(p); ok {
+		return mount.Path
+	}
+	return "/"
+}
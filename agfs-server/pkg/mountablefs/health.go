@@ -0,0 +1,74 @@
+package mountablefs
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// HealthFile is the read-only file at the root of mounts whose plugin
+// declares degraded modes, holding the mount's current mode as JSON
+const HealthFile = ".health"
+
+// MountHealth is the mode of a mount whose plugin declares degraded modes
+type MountHealth struct {
+	Path   string `json:"path"`
+	Plugin string `json:"plugin"`
+	filesystem.Health
+}
+
+// health returns the mode of the mount, if its plugin declares degraded
+// modes
+func (mp *MountPoint) health() (MountHealth, bool) {
+	reporter, ok := filesystem.As[filesystem.HealthReporter](mp.fileSystem())
+	if !ok {
+		return MountHealth{}, false
+	}
+	return MountHealth{Path: mp.Path, Plugin: mp.Plugin.Name(), Health: reporter.Health()}, true
+}
+
+// isHealthFile reports whether relPath is the health file of a mount
+func isHealthFile(relPath string) bool {
+	return relPath == "/"+HealthFile
+}
+
+// healthFile returns the content and info of the mount's health file, if
+// it has one
+func (mp *MountPoint) healthFile() ([]byte, *filesystem.FileInfo, bool) {
+	h, ok := mp.health()
+	if !ok {
+		return nil, nil, false
+	}
+	data, _ := json.MarshalIndent(h, "", "  ")
+	data = append(data, '\n')
+	return data, &filesystem.FileInfo{
+		Name:    HealthFile,
+		Size:    int64(len(data)),
+		Mode:    0444,
+		ModTime: time.Now(),
+		Meta:    filesystem.MetaData{Name: "health", Type: h.Mode},
+	}, true
+}
+
+// withHealthFile adds the health file to the listing of a mount's root
+func (mp *MountPoint) withHealthFile(infos []filesystem.FileInfo) []filesystem.FileInfo {
+	if _, info, ok := mp.healthFile(); ok {
+		infos = append(infos, *info)
+	}
+	return infos
+}
+
+// MountHealth returns the modes of the mounts whose plugins declare
+// degraded modes, by path
+func (mfs *MountableFS) MountHealth() []MountHealth {
+	var healths []MountHealth
+	for _, mount := range mfs.GetMounts() {
+		if h, ok := mount.health(); ok {
+			healths = append(healths, h)
+		}
+	}
+	sort.Slice(healths, func(i, j int) bool { return healths[i].Path < healths[j].Path })
+	return healths
+}
@@ -0,0 +1,109 @@
+package mountablefs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// healthPlugin is memfs declaring a degraded mode
+type healthPlugin struct {
+	*memfs.MemFSPlugin
+	health *filesystem.HealthState
+}
+
+type healthFS struct {
+	filesystem.FileSystem
+	health *filesystem.HealthState
+}
+
+func (fs *healthFS) Health() filesystem.Health { return fs.health.Health() }
+
+func (p *healthPlugin) GetFileSystem() filesystem.FileSystem {
+	return &healthFS{FileSystem: p.MemFSPlugin.GetFileSystem(), health: p.health}
+}
+
+func mountTestPlugin(t *testing.T, mfs *MountableFS, path string, p plugin.ServicePlugin) {
+	t.Helper()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	if err := mfs.Mount(path, p); err != nil {
+		t.Fatalf("Mount() error = %v", err)
+	}
+	t.Cleanup(func() { mfs.Unmount(path) })
+}
+
+func TestMountHealthFile(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	state := filesystem.NewHealthState("read_only")
+	mountTestPlugin(t, mfs, "/data", &healthPlugin{MemFSPlugin: memfs.NewMemFSPlugin(), health: state})
+	mountTestPlugin(t, mfs, "/plain", memfs.NewMemFSPlugin())
+
+	readHealth := func() filesystem.Health {
+		t.Helper()
+		data, err := mfs.Read("/data/.health", 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatalf("Read(.health) error = %v", err)
+		}
+		var h MountHealth
+		if err := json.Unmarshal(data, &h); err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", data, err)
+		}
+		if h.Path != "/data" || h.Plugin != "memfs" {
+			t.Errorf(".health = %+v, want the /data mount", h)
+		}
+		return h.Health
+	}
+	if h := readHealth(); h.Mode != filesystem.HealthNormal || len(h.Modes) != 1 {
+		t.Errorf(".health = %+v, want normal", h)
+	}
+
+	state.Enter("read_only", "credentials expired")
+	if h := readHealth(); h.Mode != "read_only" || h.Reason != "credentials expired" || h.Degradations != 1 {
+		t.Errorf(".health = %+v, want read_only", h)
+	}
+	if info, err := mfs.Stat("/data/.health"); err != nil || info.Meta.Type != "read_only" || info.Mode != 0444 {
+		t.Errorf("Stat(.health) = %+v, %v", info, err)
+	}
+	if _, err := mfs.Write("/data/.health", []byte("{}"), -1, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Write(.health) error = %v, want permission denied", err)
+	}
+	if err := mfs.Remove("/data/.health"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Remove(.health) error = %v, want permission denied", err)
+	}
+
+	// Only mounts declaring degraded modes have the file
+	listed := func(dir string) bool {
+		infos, err := mfs.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ReadDir(%s) error = %v", dir, err)
+		}
+		for _, info := range infos {
+			if info.Name == HealthFile {
+				return true
+			}
+		}
+		return false
+	}
+	if !listed("/data") {
+		t.Error("ReadDir(/data) does not list .health")
+	}
+	if listed("/plain") {
+		t.Error("ReadDir(/plain) lists .health")
+	}
+	if _, err := mfs.Stat("/plain/.health"); err == nil {
+		t.Error("Stat(/plain/.health) succeeded")
+	}
+
+	healths := mfs.MountHealth()
+	if len(healths) != 1 || healths[0].Path != "/data" || !healths[0].Degraded() {
+		t.Errorf("MountHealth() = %+v", healths)
+	}
+}
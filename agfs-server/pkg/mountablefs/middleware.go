@@ -0,0 +1,626 @@
+package mountablefs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// MiddlewareConfigKey is the mount option listing the middleware wrapping a
+// mount's file system, outermost first. Each entry is a middleware name or
+// a map with a "name" key and the middleware's options:
+//
+//	middleware:
+//	  - audit
+//	  - name: fault
+//	    error_rate: 0.01
+//	  - readonly
+const MiddlewareConfigKey = "middleware"
+
+// Middleware wraps the file system of a mount to add behavior such as
+// access control, auditing or fault injection
+type Middleware interface {
+	// Name is the name the middleware is registered under
+	Name() string
+
+	// Wrap returns a file system that forwards to next. It should implement
+	// filesystem.Unwrapper so that optional interfaces it doesn't implement
+	// itself are still found on next.
+	Wrap(next filesystem.FileSystem) filesystem.FileSystem
+}
+
+// MiddlewareFactory creates a middleware from its options in the mount
+// configuration
+type MiddlewareFactory func(config map[string]interface{}) (Middleware, error)
+
+var (
+	middlewareMu        sync.RWMutex
+	middlewareFactories = map[string]MiddlewareFactory{
+		"readonly": newReadOnlyMiddleware,
+		"audit":    newAuditMiddleware,
+		"fault":    newFaultMiddleware,
+		"timeout":  newTimeoutMiddleware,
+		"cache":    newCacheMiddleware,
+
+		"circuit_breaker": newBreakerMiddleware,
+	}
+)
+
+// RegisterMiddleware makes a middleware available to mount configurations
+// under name, replacing any registered before
+func RegisterMiddleware(name string, factory MiddlewareFactory) {
+	middlewareMu.Lock()
+	defer middlewareMu.Unlock()
+	middlewareFactories[name] = factory
+}
+
+// MiddlewareNames returns the names of the registered middleware
+func MiddlewareNames() []string {
+	middlewareMu.RLock()
+	defer middlewareMu.RUnlock()
+	names := make([]string, 0, len(middlewareFactories))
+	for name := range middlewareFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseMiddleware creates the middleware listed in a mount option value
+func parseMiddleware(value interface{}) ([]Middleware, error) {
+	entries, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a list of middleware", MiddlewareConfigKey)
+	}
+
+	chain := make([]Middleware, 0, len(entries))
+	for i, entry := range entries {
+		var name string
+		options := map[string]interface{}{}
+		switch e := entry.(type) {
+		case string:
+			name = e
+		case map[string]interface{}:
+			name, _ = e["name"].(string)
+			for k, v := range e {
+				if k != "name" {
+					options[k] = v
+				}
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("%s[%d] must be a name or a map with a name", MiddlewareConfigKey, i)
+		}
+
+		middlewareMu.RLock()
+		factory, ok := middlewareFactories[name]
+		middlewareMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("%s[%d]: unknown middleware %q", MiddlewareConfigKey, i, name)
+		}
+		m, err := factory(options)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d] (%s): %w", MiddlewareConfigKey, i, name, err)
+		}
+		chain = append(chain, m)
+	}
+	return chain, nil
+}
+
+// wrapMiddleware applies a middleware chain to fs, the first middleware
+// being the outermost
+func wrapMiddleware(fs filesystem.FileSystem, chain []Middleware) filesystem.FileSystem {
+	for i := len(chain) - 1; i >= 0; i-- {
+		fs = chain[i].Wrap(fs)
+	}
+	return fs
+}
+
+// ============================================================================
+// readonly
+// ============================================================================
+
+// readOnlyMiddleware rejects every change to the mount. Reads, including
+// through file handles opened read-only, pass through. Only the optional
+// interfaces in readOnlyForwarded are looked up on the wrapped file system,
+// so one that can write is never reached, even if it is added later; the
+// mount's snapshots, which could be taken and deleted, are hidden too.
+type readOnlyMiddleware struct{}
+
+func newReadOnlyMiddleware(config map[string]interface{}) (Middleware, error) {
+	if err := pluginconfig.ValidateOnlyKnownKeys(config, nil); err != nil {
+		return nil, err
+	}
+	return readOnlyMiddleware{}, nil
+}
+
+func (readOnlyMiddleware) Name() string { return "readonly" }
+
+func (readOnlyMiddleware) Wrap(next filesystem.FileSystem) filesystem.FileSystem {
+	return &readOnlyFS{FileSystem: next}
+}
+
+type readOnlyFS struct {
+	filesystem.FileSystem
+}
+
+func readOnlyError(op, path string) error {
+	return filesystem.NewPermissionDeniedError(op, path, "mount is read-only")
+}
+
+func (fs *readOnlyFS) Unwrap() filesystem.FileSystem { return fs.FileSystem }
+
+// readOnlyForwarded are the optional interfaces that only read, which
+// readOnlyFS lets callers find on the file system it wraps
+var readOnlyForwarded = map[reflect.Type]bool{
+	reflect.TypeFor[filesystem.Checksummer]():       true,
+	reflect.TypeFor[filesystem.ContentDescriber]():  true,
+	reflect.TypeFor[filesystem.DirPreviewer]():      true,
+	reflect.TypeFor[filesystem.Finder]():            true,
+	reflect.TypeFor[filesystem.HealthReporter]():    true,
+	reflect.TypeFor[filesystem.NameLister]():        true,
+	reflect.TypeFor[filesystem.PathResolver]():      true,
+	reflect.TypeFor[filesystem.Prefetcher]():        true,
+	reflect.TypeFor[filesystem.RetentionReporter](): true,
+	reflect.TypeFor[filesystem.Streamer]():          true,
+	reflect.TypeFor[filesystem.TableReader]():       true,
+	reflect.TypeFor[filesystem.Walker]():            true,
+	reflect.TypeFor[filesystem.Watcher]():           true,
+	reflect.TypeFor[streamGetter]():                 true,
+	reflect.TypeFor[CustomGrepper]():                true,
+
+	// Markers describing how paths behave
+	reflect.TypeFor[filesystem.AppendOnlyFS]():      true,
+	reflect.TypeFor[filesystem.BroadcastFS]():       true,
+	reflect.TypeFor[filesystem.ObjectStoreFS]():     true,
+	reflect.TypeFor[filesystem.ReadDestructiveFS](): true,
+}
+
+func (fs *readOnlyFS) Forwards(t reflect.Type) bool { return readOnlyForwarded[t] }
+
+func (fs *readOnlyFS) Create(path string) error { return readOnlyError("create", path) }
+
+func (fs *readOnlyFS) Mkdir(path string, perm uint32) error { return readOnlyError("mkdir", path) }
+
+func (fs *readOnlyFS) Remove(path string) error { return readOnlyError("remove", path) }
+
+func (fs *readOnlyFS) RemoveAll(path string) error { return readOnlyError("remove", path) }
+
+func (fs *readOnlyFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	return 0, readOnlyError("write", path)
+}
+
+func (fs *readOnlyFS) Rename(oldPath, newPath string) error { return readOnlyError("rename", oldPath) }
+
+func (fs *readOnlyFS) Chmod(path string, mode uint32) error { return readOnlyError("chmod", path) }
+
+func (fs *readOnlyFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return nil, readOnlyError("write", path)
+}
+
+// The write-capable optional interfaces are implemented too, so that
+// callers get a permission error rather than find them unsupported
+
+func (fs *readOnlyFS) Truncate(path string, size int64) error { return readOnlyError("truncate", path) }
+
+func (fs *readOnlyFS) Touch(path string) error { return readOnlyError("touch", path) }
+
+func (fs *readOnlyFS) WriteAt(path string, data []byte, offset int64) (int64, error) {
+	return 0, readOnlyError("write", path)
+}
+
+func (fs *readOnlyFS) Symlink(targetPath, linkPath string) error {
+	return readOnlyError("symlink", linkPath)
+}
+
+func (fs *readOnlyFS) Reserve(path string, size int64) error { return readOnlyError("create", path) }
+
+func (fs *readOnlyFS) BeginUpload(path string, partSize int64) (filesystem.Upload, error) {
+	return nil, readOnlyError("upload", path)
+}
+
+func (fs *readOnlyFS) SetExpiry(path string, expiresAt time.Time) error {
+	return readOnlyError("setexpiry", path)
+}
+
+func (fs *readOnlyFS) SetRetention(path string, r filesystem.Retention) error {
+	return readOnlyError("retention", path)
+}
+
+func (fs *readOnlyFS) Increment(path string, delta int64) (int64, error) {
+	return 0, readOnlyError("increment", path)
+}
+
+func (fs *readOnlyFS) Append(path string, data []byte) (int64, error) {
+	return 0, readOnlyError("append", path)
+}
+
+func (fs *readOnlyFS) SetContentType(path string, contentType string) error {
+	return readOnlyError("setcontenttype", path)
+}
+
+func (fs *readOnlyFS) ListDeleted(path string) ([]filesystem.DeletedEntry, error) {
+	if r, ok := filesystem.As[filesystem.Restorer](fs.FileSystem); ok {
+		return r.ListDeleted(path)
+	}
+	return nil, filesystem.ErrNotSupported
+}
+
+func (fs *readOnlyFS) Restore(path string) error { return readOnlyError("restore", path) }
+
+func (fs *readOnlyFS) DryRun(op filesystem.DryRunOp) (*filesystem.DryRunResult, error) {
+	result, err := filesystem.DryRun(fs.FileSystem, op)
+	if err != nil {
+		return nil, err
+	}
+	result.Deny("readonly", "mount is read-only")
+	return result, nil
+}
+
+func (fs *readOnlyFS) MkdirAs(identity, path string, perm uint32) error {
+	return readOnlyError("mkdir", path)
+}
+
+func (fs *readOnlyFS) AuthorizeTenant(identity, path string, recursive bool) error {
+	if scoper, ok := filesystem.As[filesystem.TenantScoper](fs.FileSystem); ok {
+		return scoper.AuthorizeTenant(identity, path, recursive)
+	}
+	return nil
+}
+
+func (fs *readOnlyFS) FilterTenant(identity, path string, entries []filesystem.FileInfo) []filesystem.FileInfo {
+	if scoper, ok := filesystem.As[filesystem.TenantScoper](fs.FileSystem); ok {
+		return scoper.FilterTenant(identity, path, entries)
+	}
+	return entries
+}
+
+func (fs *readOnlyFS) OpenHandle(path string, flags filesystem.OpenFlag, mode uint32) (filesystem.FileHandle, error) {
+	if flags&(filesystem.O_WRONLY|filesystem.O_RDWR|filesystem.O_APPEND|filesystem.O_CREATE|filesystem.O_TRUNC) != 0 {
+		return nil, readOnlyError("openhandle", path)
+	}
+	handleFS, ok := filesystem.As[filesystem.HandleFS](fs.FileSystem)
+	if !ok {
+		return nil, filesystem.NewNotSupportedError("openhandle", path)
+	}
+	return handleFS.OpenHandle(path, flags, mode)
+}
+
+func (fs *readOnlyFS) GetHandle(id int64) (filesystem.FileHandle, error) {
+	handleFS, ok := filesystem.As[filesystem.HandleFS](fs.FileSystem)
+	if !ok {
+		return nil, filesystem.ErrNotFound
+	}
+	return handleFS.GetHandle(id)
+}
+
+func (fs *readOnlyFS) CloseHandle(id int64) error {
+	handleFS, ok := filesystem.As[filesystem.HandleFS](fs.FileSystem)
+	if !ok {
+		return filesystem.ErrNotFound
+	}
+	return handleFS.CloseHandle(id)
+}
+
+// ============================================================================
+// audit
+// ============================================================================
+
+// auditMiddleware logs the operations on a mount with their outcome.
+// Options: reads (bool, default false) also logs reads and listings.
+type auditMiddleware struct {
+	reads bool
+}
+
+func newAuditMiddleware(config map[string]interface{}) (Middleware, error) {
+	if err := pluginconfig.ValidateOnlyKnownKeys(config, []string{"reads"}); err != nil {
+		return nil, err
+	}
+	if err := pluginconfig.ValidateBoolType(config, "reads"); err != nil {
+		return nil, err
+	}
+	return &auditMiddleware{reads: pluginconfig.GetBoolConfig(config, "reads", false)}, nil
+}
+
+func (m *auditMiddleware) Name() string { return "audit" }
+
+func (m *auditMiddleware) Wrap(next filesystem.FileSystem) filesystem.FileSystem {
+	return &auditFS{FileSystem: next, reads: m.reads}
+}
+
+type auditFS struct {
+	filesystem.FileSystem
+	reads bool
+}
+
+func (fs *auditFS) Unwrap() filesystem.FileSystem { return fs.FileSystem }
+
+func (fs *auditFS) log(op, path string, start time.Time, err error) {
+	if err != nil {
+		log.Infof("[audit] %s %s failed after %v: %v", op, path, time.Since(start), err)
+		return
+	}
+	log.Infof("[audit] %s %s ok in %v", op, path, time.Since(start))
+}
+
+func (fs *auditFS) Create(path string) error {
+	start := time.Now()
+	err := fs.FileSystem.Create(path)
+	fs.log("create", path, start, err)
+	return err
+}
+
+func (fs *auditFS) Mkdir(path string, perm uint32) error {
+	start := time.Now()
+	err := fs.FileSystem.Mkdir(path, perm)
+	fs.log("mkdir", path, start, err)
+	return err
+}
+
+func (fs *auditFS) Remove(path string) error {
+	start := time.Now()
+	err := fs.FileSystem.Remove(path)
+	fs.log("remove", path, start, err)
+	return err
+}
+
+func (fs *auditFS) RemoveAll(path string) error {
+	start := time.Now()
+	err := fs.FileSystem.RemoveAll(path)
+	fs.log("removeall", path, start, err)
+	return err
+}
+
+func (fs *auditFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	start := time.Now()
+	n, err := fs.FileSystem.Write(path, data, offset, flags)
+	fs.log(fmt.Sprintf("write(%d bytes at %d)", len(data), offset), path, start, err)
+	return n, err
+}
+
+func (fs *auditFS) Rename(oldPath, newPath string) error {
+	start := time.Now()
+	err := fs.FileSystem.Rename(oldPath, newPath)
+	fs.log("rename", oldPath+" -> "+newPath, start, err)
+	return err
+}
+
+func (fs *auditFS) Chmod(path string, mode uint32) error {
+	start := time.Now()
+	err := fs.FileSystem.Chmod(path, mode)
+	fs.log(fmt.Sprintf("chmod(%o)", mode), path, start, err)
+	return err
+}
+
+func (fs *auditFS) OpenWrite(path string) (io.WriteCloser, error) {
+	start := time.Now()
+	w, err := fs.FileSystem.OpenWrite(path)
+	fs.log("openwrite", path, start, err)
+	return w, err
+}
+
+func (fs *auditFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	start := time.Now()
+	data, err := fs.FileSystem.Read(path, offset, size)
+	if fs.reads {
+		logErr := err
+		if err == io.EOF {
+			logErr = nil
+		}
+		fs.log(fmt.Sprintf("read(%d bytes at %d)", len(data), offset), path, start, logErr)
+	}
+	return data, err
+}
+
+func (fs *auditFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	start := time.Now()
+	infos, err := fs.FileSystem.ReadDir(path)
+	if fs.reads {
+		fs.log("readdir", path, start, err)
+	}
+	return infos, err
+}
+
+func (fs *auditFS) Open(path string) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := fs.FileSystem.Open(path)
+	if fs.reads {
+		fs.log("open", path, start, err)
+	}
+	return r, err
+}
+
+// ============================================================================
+// fault
+// ============================================================================
+
+// ErrInjectedFault is returned by operations failed by the fault middleware
+var ErrInjectedFault = errors.New("injected fault")
+
+// faultMiddleware makes operations slow or failing, to test how clients
+// cope. Options:
+//   - error_rate: probability (0-1) that an operation fails with ErrInjectedFault
+//   - latency: delay added to every operation, e.g. "200ms"
+//   - operations: the operations affected (default all), e.g. ["read", "write"]
+type faultMiddleware struct {
+	errorRate  float64
+	latency    time.Duration
+	operations map[string]bool // nil means all
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// faultOperations are the operation names accepted by the fault middleware
+var faultOperations = []string{"create", "mkdir", "remove", "read", "write", "readdir", "stat", "rename", "chmod", "open"}
+
+func newFaultMiddleware(config map[string]interface{}) (Middleware, error) {
+	if err := pluginconfig.ValidateOnlyKnownKeys(config, []string{"error_rate", "latency", "operations"}); err != nil {
+		return nil, err
+	}
+	m := &faultMiddleware{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+	if _, ok := config["error_rate"]; ok {
+		m.errorRate = pluginconfig.GetFloat64Config(config, "error_rate", -1)
+		if m.errorRate < 0 || m.errorRate > 1 {
+			return nil, fmt.Errorf("error_rate must be a number between 0 and 1")
+		}
+	}
+	if v, ok := config["latency"]; ok {
+		s, ok := v.(string)
+		d, err := time.ParseDuration(s)
+		if !ok || err != nil || d < 0 {
+			return nil, fmt.Errorf("latency must be a non-negative duration such as \"200ms\"")
+		}
+		m.latency = d
+	}
+	if err := pluginconfig.ValidateArrayType(config, "operations"); err != nil {
+		return nil, err
+	}
+	if ops, ok := config["operations"].([]interface{}); ok {
+		m.operations = make(map[string]bool)
+		for _, v := range ops {
+			op, _ := v.(string)
+			if !contains(faultOperations, op) {
+				return nil, fmt.Errorf("operations: unknown operation %v (valid: %v)", v, faultOperations)
+			}
+			m.operations[op] = true
+		}
+	}
+	return m, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *faultMiddleware) Name() string { return "fault" }
+
+func (m *faultMiddleware) Wrap(next filesystem.FileSystem) filesystem.FileSystem {
+	return &faultFS{FileSystem: next, m: m}
+}
+
+// inject delays and possibly fails an operation
+func (m *faultMiddleware) inject(op, path string) error {
+	if m.operations != nil && !m.operations[op] {
+		return nil
+	}
+	if m.latency > 0 {
+		time.Sleep(m.latency)
+	}
+	if m.errorRate == 0 {
+		return nil
+	}
+	m.mu.Lock()
+	fail := m.rng.Float64() < m.errorRate
+	m.mu.Unlock()
+	if fail {
+		return fmt.Errorf("%s %s: %w", op, path, ErrInjectedFault)
+	}
+	return nil
+}
+
+type faultFS struct {
+	filesystem.FileSystem
+	m *faultMiddleware
+}
+
+func (fs *faultFS) Unwrap() filesystem.FileSystem { return fs.FileSystem }
+
+func (fs *faultFS) Create(path string) error {
+	if err := fs.m.inject("create", path); err != nil {
+		return err
+	}
+	return fs.FileSystem.Create(path)
+}
+
+func (fs *faultFS) Mkdir(path string, perm uint32) error {
+	if err := fs.m.inject("mkdir", path); err != nil {
+		return err
+	}
+	return fs.FileSystem.Mkdir(path, perm)
+}
+
+func (fs *faultFS) Remove(path string) error {
+	if err := fs.m.inject("remove", path); err != nil {
+		return err
+	}
+	return fs.FileSystem.Remove(path)
+}
+
+func (fs *faultFS) RemoveAll(path string) error {
+	if err := fs.m.inject("remove", path); err != nil {
+		return err
+	}
+	return fs.FileSystem.RemoveAll(path)
+}
+
+func (fs *faultFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	if err := fs.m.inject("read", path); err != nil {
+		return nil, err
+	}
+	return fs.FileSystem.Read(path, offset, size)
+}
+
+func (fs *faultFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	if err := fs.m.inject("write", path); err != nil {
+		return 0, err
+	}
+	return fs.FileSystem.Write(path, data, offset, flags)
+}
+
+func (fs *faultFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	if err := fs.m.inject("readdir", path); err != nil {
+		return nil, err
+	}
+	return fs.FileSystem.ReadDir(path)
+}
+
+func (fs *faultFS) Stat(path string) (*filesystem.FileInfo, error) {
+	if err := fs.m.inject("stat", path); err != nil {
+		return nil, err
+	}
+	return fs.FileSystem.Stat(path)
+}
+
+func (fs *faultFS) Rename(oldPath, newPath string) error {
+	if err := fs.m.inject("rename", oldPath); err != nil {
+		return err
+	}
+	return fs.FileSystem.Rename(oldPath, newPath)
+}
+
+func (fs *faultFS) Chmod(path string, mode uint32) error {
+	if err := fs.m.inject("chmod", path); err != nil {
+		return err
+	}
+	return fs.FileSystem.Chmod(path, mode)
+}
+
+func (fs *faultFS) Open(path string) (io.ReadCloser, error) {
+	if err := fs.m.inject("open", path); err != nil {
+		return nil, err
+	}
+	return fs.FileSystem.Open(path)
+}
+
+func (fs *faultFS) OpenWrite(path string) (io.WriteCloser, error) {
+	if err := fs.m.inject("write", path); err != nil {
+		return nil, err
+	}
+	return fs.FileSystem.OpenWrite(path)
+}
@@ -0,0 +1,598 @@
+package mountablefs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// newMiddlewareTestFS mounts memfs at /data with the middleware of config.
+// /data/a.txt holds "hello" before the middleware applies.
+func newMiddlewareTestFS(t *testing.T, config map[string]interface{}) *MountableFS {
+	t.Helper()
+	opts, rest, err := ParseMountOptions(config)
+	if err != nil {
+		t.Fatalf("ParseMountOptions() error = %v", err)
+	}
+	mfs := NewMountableFS(api.PoolConfig{})
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(rest); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	if _, err := p.GetFileSystem().Write("/a.txt", []byte("hello"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := mfs.MountWithOptions("/data", p, opts); err != nil {
+		t.Fatalf("MountWithOptions() error = %v", err)
+	}
+	t.Cleanup(func() { mfs.Unmount("/data") })
+	return mfs
+}
+
+func TestMiddlewareReadOnly(t *testing.T) {
+	mfs := newMiddlewareTestFS(t, map[string]interface{}{
+		MiddlewareConfigKey: []interface{}{"readonly"},
+	})
+
+	if data, err := mfs.Read("/data/a.txt", 0, -1); (err != nil && err != io.EOF) || string(data) != "hello" {
+		t.Fatalf("Read() = %q, %v, want hello", data, err)
+	}
+	if _, err := mfs.Write("/data/a.txt", []byte("x"), -1, filesystem.WriteFlagTruncate); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Write() error = %v, want permission denied", err)
+	}
+	if err := mfs.Remove("/data/a.txt"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Remove() error = %v, want permission denied", err)
+	}
+	// memfs truncates natively; the middleware must not be bypassed
+	if err := mfs.Truncate("/data/a.txt", 0); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Truncate() error = %v, want permission denied", err)
+	}
+	if _, err := mfs.OpenHandle("/data/a.txt", filesystem.O_RDWR, 0644); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("OpenHandle(O_RDWR) error = %v, want permission denied", err)
+	}
+	h, err := mfs.OpenHandle("/data/a.txt", filesystem.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenHandle(O_RDONLY) error = %v", err)
+	}
+	h.Close()
+}
+
+// restorerPlugin is memfs with a soft-deleted entry at /old.txt
+type restorerPlugin struct {
+	*memfs.MemFSPlugin
+	restored []string
+}
+
+type restorerFS struct {
+	filesystem.FileSystem
+	p *restorerPlugin
+}
+
+func (fs *restorerFS) ListDeleted(path string) ([]filesystem.DeletedEntry, error) {
+	return []filesystem.DeletedEntry{{Name: "old.txt"}}, nil
+}
+
+func (fs *restorerFS) Restore(path string) error {
+	fs.p.restored = append(fs.p.restored, path)
+	return nil
+}
+
+func (p *restorerPlugin) GetFileSystem() filesystem.FileSystem {
+	return &restorerFS{FileSystem: p.MemFSPlugin.GetFileSystem(), p: p}
+}
+
+func TestMiddlewareReadOnlyRestore(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	p := &restorerPlugin{MemFSPlugin: memfs.NewMemFSPlugin()}
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	opts, _, err := ParseMountOptions(map[string]interface{}{MiddlewareConfigKey: []interface{}{"readonly"}})
+	if err != nil {
+		t.Fatalf("ParseMountOptions() error = %v", err)
+	}
+	if err := mfs.MountWithOptions("/data", p, opts); err != nil {
+		t.Fatalf("MountWithOptions() error = %v", err)
+	}
+	t.Cleanup(func() { mfs.Unmount("/data") })
+
+	if deleted, err := mfs.ListDeleted("/data"); err != nil || len(deleted) != 1 {
+		t.Errorf("ListDeleted() = %v, %v", deleted, err)
+	}
+	if err := mfs.Restore("/data/old.txt"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Restore() error = %v, want permission denied", err)
+	}
+	if len(p.restored) != 0 {
+		t.Errorf("restored %v through a read-only mount", p.restored)
+	}
+}
+
+// writableFS implements every optional interface that changes data,
+// recording the calls that reach it
+type writableFS struct {
+	filesystem.FileSystem
+	calls []string
+}
+
+func (fs *writableFS) record(call string) error {
+	fs.calls = append(fs.calls, call)
+	return nil
+}
+
+func (fs *writableFS) WriteAt(path string, data []byte, offset int64) (int64, error) {
+	return 0, fs.record("WriteAt")
+}
+func (fs *writableFS) Truncate(path string, size int64) error    { return fs.record("Truncate") }
+func (fs *writableFS) Touch(path string) error                   { return fs.record("Touch") }
+func (fs *writableFS) Symlink(targetPath, linkPath string) error { return fs.record("Symlink") }
+func (fs *writableFS) Reserve(path string, size int64) error     { return fs.record("Reserve") }
+func (fs *writableFS) SetExpiry(path string, at time.Time) error { return fs.record("SetExpiry") }
+func (fs *writableFS) SetContentType(path, contentType string) error {
+	return fs.record("SetContentType")
+}
+func (fs *writableFS) Restore(path string) error { return fs.record("Restore") }
+func (fs *writableFS) Sync(path string) error    { return fs.record("Sync") }
+func (fs *writableFS) Append(path string, data []byte) (int64, error) {
+	return 0, fs.record("Append")
+}
+func (fs *writableFS) Increment(path string, delta int64) (int64, error) {
+	return 0, fs.record("Increment")
+}
+func (fs *writableFS) SetRetention(path string, r filesystem.Retention) error {
+	return fs.record("SetRetention")
+}
+func (fs *writableFS) ListDeleted(path string) ([]filesystem.DeletedEntry, error) { return nil, nil }
+func (fs *writableFS) BeginUpload(path string, partSize int64) (filesystem.Upload, error) {
+	return nil, fs.record("BeginUpload")
+}
+func (fs *writableFS) OpenHandle(path string, flags filesystem.OpenFlag, mode uint32) (filesystem.FileHandle, error) {
+	return nil, fs.record("OpenHandle")
+}
+func (fs *writableFS) GetHandle(id int64) (filesystem.FileHandle, error) {
+	return nil, filesystem.ErrNotFound
+}
+func (fs *writableFS) CloseHandle(id int64) error { return filesystem.ErrNotFound }
+func (fs *writableFS) AuthorizeTenant(identity, path string, recursive bool) error {
+	return nil
+}
+func (fs *writableFS) FilterTenant(identity, path string, entries []filesystem.FileInfo) []filesystem.FileInfo {
+	return entries
+}
+func (fs *writableFS) MkdirAs(identity, path string, perm uint32) error {
+	return fs.record("MkdirAs")
+}
+func (fs *writableFS) CreateSnapshot(path string) (*filesystem.Snapshot, error) {
+	return nil, fs.record("CreateSnapshot")
+}
+func (fs *writableFS) ListSnapshots(path string) ([]filesystem.Snapshot, error) { return nil, nil }
+func (fs *writableFS) DeleteSnapshot(path string, id string) error {
+	return fs.record("DeleteSnapshot")
+}
+func (fs *writableFS) OpenSnapshot(path string, id string) (filesystem.FileSystem, error) {
+	return nil, filesystem.ErrNotFound
+}
+func (fs *writableFS) ReadContext(ctx context.Context, path string, offset int64, size int64) ([]byte, error) {
+	return fs.FileSystem.Read(path, offset, size)
+}
+func (fs *writableFS) WriteContext(ctx context.Context, path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	return 0, fs.record("WriteContext")
+}
+func (fs *writableFS) ReadDirContext(ctx context.Context, path string) ([]filesystem.FileInfo, error) {
+	return fs.FileSystem.ReadDir(path)
+}
+
+// asCall looks up T on fs as callers do and, if found, calls it
+func asCall[T any](fs filesystem.FileSystem, call func(T) error) error {
+	t, ok := filesystem.As[T](fs)
+	if !ok {
+		return filesystem.ErrNotSupported
+	}
+	return call(t)
+}
+
+func TestMiddlewareReadOnlyHidesWrites(t *testing.T) {
+	inner := &writableFS{FileSystem: memfs.NewMemoryFS()}
+	fs := readOnlyMiddleware{}.Wrap(inner)
+
+	mutations := map[string]func() error{
+		"RandomWriter": func() error {
+			return asCall(fs, func(w filesystem.RandomWriter) error { _, err := w.WriteAt("/f", nil, 0); return err })
+		},
+		"Truncater": func() error { return asCall(fs, func(w filesystem.Truncater) error { return w.Truncate("/f", 0) }) },
+		"Toucher":   func() error { return asCall(fs, func(w filesystem.Toucher) error { return w.Touch("/f") }) },
+		"Symlinker": func() error { return asCall(fs, func(w filesystem.Symlinker) error { return w.Symlink("/f", "/l") }) },
+		"Reserver":  func() error { return asCall(fs, func(w filesystem.Reserver) error { return w.Reserve("/f", 1) }) },
+		"Expirer": func() error {
+			return asCall(fs, func(w filesystem.Expirer) error { return w.SetExpiry("/f", time.Now()) })
+		},
+		"ContentTyper": func() error {
+			return asCall(fs, func(w filesystem.ContentTyper) error { return w.SetContentType("/f", "text/plain") })
+		},
+		"Restorer": func() error { return asCall(fs, func(w filesystem.Restorer) error { return w.Restore("/f") }) },
+		"Syncer":   func() error { return asCall(fs, func(w filesystem.Syncer) error { return w.Sync("/f") }) },
+		"Appender": func() error {
+			return asCall(fs, func(w filesystem.Appender) error { _, err := w.Append("/f", nil); return err })
+		},
+		"Incrementer": func() error {
+			return asCall(fs, func(w filesystem.Incrementer) error { _, err := w.Increment("/f", 1); return err })
+		},
+		"Retainer": func() error {
+			return asCall(fs, func(w filesystem.Retainer) error { return w.SetRetention("/f", filesystem.Retention{}) })
+		},
+		"Uploader": func() error {
+			return asCall(fs, func(w filesystem.Uploader) error { _, err := w.BeginUpload("/f", 1); return err })
+		},
+		"HandleFS": func() error {
+			return asCall(fs, func(w filesystem.HandleFS) error {
+				_, err := w.OpenHandle("/f", filesystem.O_WRONLY|filesystem.O_CREATE, 0644)
+				return err
+			})
+		},
+		"TenantScoper": func() error {
+			return asCall(fs, func(w filesystem.TenantScoper) error { return w.MkdirAs("alice", "/d", 0755) })
+		},
+		"Snapshotter.CreateSnapshot": func() error {
+			return asCall(fs, func(w filesystem.Snapshotter) error { _, err := w.CreateSnapshot("/"); return err })
+		},
+		"Snapshotter.DeleteSnapshot": func() error {
+			return asCall(fs, func(w filesystem.Snapshotter) error { return w.DeleteSnapshot("/", "x") })
+		},
+		"ContextFileSystem": func() error {
+			cfs, ok := fs.(filesystem.ContextFileSystem)
+			if !ok {
+				return filesystem.ErrNotSupported
+			}
+			_, err := cfs.WriteContext(context.Background(), "/f", nil, -1, filesystem.WriteFlagCreate)
+			return err
+		},
+	}
+	for name, mutate := range mutations {
+		if err := mutate(); !errors.Is(err, filesystem.ErrPermissionDenied) && !errors.Is(err, filesystem.ErrNotSupported) {
+			t.Errorf("%s: error = %v, want permission denied or not supported", name, err)
+		}
+	}
+	if len(inner.calls) != 0 {
+		t.Errorf("mutations reached the wrapped file system: %v", inner.calls)
+	}
+
+	result, err := filesystem.DryRun(fs, filesystem.DryRunOp{Op: filesystem.DryRunWrite, Path: "/f", Offset: -1, Flags: filesystem.WriteFlagCreate})
+	if err != nil || result.Allowed {
+		t.Errorf("DryRun() = %+v, %v, want denied", result, err)
+	}
+
+	// Reads of the wrapped file system are still found
+	if _, ok := filesystem.As[filesystem.TenantScoper](fs); !ok {
+		t.Error("tenant checks are hidden")
+	}
+	if _, ok := filesystem.As[filesystem.Restorer](fs); !ok {
+		t.Error("deleted entries are hidden")
+	}
+}
+
+func TestMiddlewarePassesThroughOptionalInterfaces(t *testing.T) {
+	mfs := newMiddlewareTestFS(t, map[string]interface{}{
+		MiddlewareConfigKey: []interface{}{"audit"},
+	})
+
+	if err := mfs.Truncate("/data/a.txt", 2); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+	if data, _ := mfs.Read("/data/a.txt", 0, -1); string(data) != "he" {
+		t.Errorf("content = %q, want he", data)
+	}
+}
+
+// orderMiddleware records the order in which writes go through it
+type orderMiddleware struct {
+	tag string
+	log *[]string
+	mu  *sync.Mutex
+}
+
+func (m orderMiddleware) Name() string { return "order" }
+
+func (m orderMiddleware) Wrap(next filesystem.FileSystem) filesystem.FileSystem {
+	return &orderFS{FileSystem: next, m: m}
+}
+
+type orderFS struct {
+	filesystem.FileSystem
+	m orderMiddleware
+}
+
+func (fs *orderFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	fs.m.mu.Lock()
+	*fs.m.log = append(*fs.m.log, fs.m.tag)
+	fs.m.mu.Unlock()
+	return fs.FileSystem.Write(path, data, offset, flags)
+}
+
+func TestMiddlewareChainOrder(t *testing.T) {
+	var order []string
+	var mu sync.Mutex
+	RegisterMiddleware("order", func(config map[string]interface{}) (Middleware, error) {
+		tag, _ := config["tag"].(string)
+		return orderMiddleware{tag: tag, log: &order, mu: &mu}, nil
+	})
+	t.Cleanup(func() {
+		middlewareMu.Lock()
+		delete(middlewareFactories, "order")
+		middlewareMu.Unlock()
+	})
+
+	mfs := newMiddlewareTestFS(t, map[string]interface{}{
+		MiddlewareConfigKey: []interface{}{
+			map[string]interface{}{"name": "order", "tag": "outer"},
+			map[string]interface{}{"name": "order", "tag": "inner"},
+		},
+	})
+	if _, err := mfs.Write("/data/b.txt", []byte("x"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Errorf("order = %v, want [outer inner]", order)
+	}
+}
+
+func TestMiddlewareFault(t *testing.T) {
+	mfs := newMiddlewareTestFS(t, map[string]interface{}{
+		MiddlewareConfigKey: []interface{}{
+			map[string]interface{}{"name": "fault", "error_rate": 1.0, "operations": []interface{}{"write"}},
+		},
+	})
+
+	if _, err := mfs.Write("/data/a.txt", []byte("x"), -1, filesystem.WriteFlagTruncate); !errors.Is(err, ErrInjectedFault) {
+		t.Errorf("Write() error = %v, want injected fault", err)
+	}
+	if _, err := mfs.Read("/data/a.txt", 0, -1); err != nil && err != io.EOF {
+		t.Errorf("Read() error = %v, reads are not affected", err)
+	}
+}
+
+// slowMiddleware blocks reads until released. Context-aware reads return
+// when their context is done, recording that they were cancelled.
+type slowMiddleware struct {
+	release   chan struct{}
+	cancelled chan struct{}
+	withCtx   bool
+}
+
+func (m *slowMiddleware) Name() string { return "slow" }
+
+func (m *slowMiddleware) Wrap(next filesystem.FileSystem) filesystem.FileSystem {
+	if m.withCtx {
+		return &slowContextFS{slowFS{FileSystem: next, m: m}}
+	}
+	return &slowFS{FileSystem: next, m: m}
+}
+
+type slowFS struct {
+	filesystem.FileSystem
+	m *slowMiddleware
+}
+
+func (fs *slowFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	<-fs.m.release
+	return fs.FileSystem.Read(path, offset, size)
+}
+
+type slowContextFS struct {
+	slowFS
+}
+
+func (fs *slowContextFS) ReadContext(ctx context.Context, path string, offset int64, size int64) ([]byte, error) {
+	select {
+	case <-fs.m.release:
+		return fs.FileSystem.Read(path, offset, size)
+	case <-ctx.Done():
+		close(fs.m.cancelled)
+		return nil, ctx.Err()
+	}
+}
+
+func (fs *slowContextFS) WriteContext(ctx context.Context, path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	return fs.FileSystem.Write(path, data, offset, flags)
+}
+
+func (fs *slowContextFS) ReadDirContext(ctx context.Context, path string) ([]filesystem.FileInfo, error) {
+	return fs.FileSystem.ReadDir(path)
+}
+
+func TestMiddlewareTimeout(t *testing.T) {
+	for _, withCtx := range []bool{false, true} {
+		slow := &slowMiddleware{release: make(chan struct{}), cancelled: make(chan struct{}), withCtx: withCtx}
+		RegisterMiddleware("slow", func(config map[string]interface{}) (Middleware, error) { return slow, nil })
+
+		mfs := newMiddlewareTestFS(t, map[string]interface{}{
+			MiddlewareConfigKey: []interface{}{
+				map[string]interface{}{"name": "timeout", "default": "1h", "read": "20ms"},
+				"slow",
+			},
+		})
+		_, err := mfs.Read("/data/a.txt", 0, -1)
+		if !errors.Is(err, filesystem.ErrTimeout) {
+			t.Errorf("Read() with context = %v: error = %v, want timeout", withCtx, err)
+		}
+		if withCtx {
+			select {
+			case <-slow.cancelled:
+			case <-time.After(time.Second):
+				t.Error("context-aware read was not cancelled")
+			}
+		}
+		close(slow.release)
+
+		// Operations within their timeout are unaffected
+		if _, err := mfs.Write("/data/b.txt", []byte("x"), -1, filesystem.WriteFlagCreate); err != nil {
+			t.Errorf("Write() error = %v", err)
+		}
+	}
+	middlewareMu.Lock()
+	delete(middlewareFactories, "slow")
+	middlewareMu.Unlock()
+}
+
+func TestMiddlewareCircuitBreaker(t *testing.T) {
+	mfs := newMiddlewareTestFS(t, map[string]interface{}{
+		MiddlewareConfigKey: []interface{}{
+			map[string]interface{}{"name": "circuit_breaker", "failures": 2, "cooldown": "1m"},
+			map[string]interface{}{"name": "fault", "error_rate": 1.0, "operations": []interface{}{"read"}},
+		},
+	})
+	mount, _, _ := mfs.findMount("/data")
+	breaker, ok := filesystem.As[*breakerFS](mount.fileSystem())
+	if !ok {
+		t.Fatal("mount has no circuit breaker")
+	}
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+
+	// Errors about the request don't trip the breaker
+	for i := 0; i < 3; i++ {
+		if _, err := mfs.Stat("/data/missing"); err == nil {
+			t.Fatal("Stat() of missing file succeeded")
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := mfs.Read("/data/a.txt", 0, -1); !errors.Is(err, ErrInjectedFault) {
+			t.Fatalf("Read() error = %v, want injected fault", err)
+		}
+	}
+	if _, err := mfs.Stat("/data/a.txt"); !errors.Is(err, filesystem.ErrBackendUnavailable) {
+		t.Errorf("Stat() on open breaker error = %v, want backend unavailable", err)
+	}
+	statuses := mfs.CircuitBreakers()
+	if len(statuses) != 1 || statuses[0].Path != "/data" || statuses[0].State != BreakerOpen ||
+		statuses[0].Trips != 1 || statuses[0].Rejected != 1 || !statuses[0].RetryAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("CircuitBreakers() = %+v", statuses)
+	}
+
+	// A failing probe keeps the breaker open for another cooldown
+	now = now.Add(time.Minute)
+	if _, err := mfs.Read("/data/a.txt", 0, -1); !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("probe Read() error = %v, want injected fault", err)
+	}
+	if _, err := mfs.Stat("/data/a.txt"); !errors.Is(err, filesystem.ErrBackendUnavailable) {
+		t.Errorf("Stat() after failed probe error = %v, want backend unavailable", err)
+	}
+
+	// A successful probe closes it
+	now = now.Add(time.Minute)
+	if _, err := mfs.Stat("/data/a.txt"); err != nil {
+		t.Fatalf("probe Stat() error = %v", err)
+	}
+	if s := mfs.CircuitBreakers()[0]; s.State != BreakerClosed || s.ConsecutiveFailures != 0 || s.Trips != 1 {
+		t.Errorf("status after recovery = %+v, want closed", s)
+	}
+}
+
+func TestMiddlewareCache(t *testing.T) {
+	mfs := newMiddlewareTestFS(t, map[string]interface{}{
+		MiddlewareConfigKey: []interface{}{
+			map[string]interface{}{"name": "cache", "ttl": "1m", "prefetch_on_readdir": true},
+		},
+	})
+	mount, _, _ := mfs.findMount("/data")
+	cache, ok := filesystem.As[*cacheFS](mount.fileSystem())
+	if !ok {
+		t.Fatal("mount has no cache")
+	}
+	now := time.Now()
+	cache.mu.Lock()
+	cache.now = func() time.Time { return now }
+	cache.mu.Unlock()
+	backend := cache.FileSystem
+
+	if err := mfs.Prefetch("/data/a.txt"); err != nil {
+		t.Fatalf("Prefetch() error = %v", err)
+	}
+	if err := mfs.Prefetch("/data"); !errors.Is(err, filesystem.ErrNotSupported) {
+		t.Errorf("Prefetch(dir) error = %v, want not supported", err)
+	}
+
+	// Changes behind the mount's back are seen once the content expires
+	if _, err := backend.Write("/a.txt", []byte("world"), -1, filesystem.WriteFlagTruncate); err != nil {
+		t.Fatalf("backend Write() error = %v", err)
+	}
+	if data, err := mfs.Read("/data/a.txt", 1, 3); (err != nil && err != io.EOF) || string(data) != "ell" {
+		t.Errorf("cached Read() = %q, %v, want ell", data, err)
+	}
+	now = now.Add(2 * time.Minute)
+	if data, err := mfs.Read("/data/a.txt", 0, -1); (err != nil && err != io.EOF) || string(data) != "world" {
+		t.Errorf("Read() after ttl = %q, %v, want world", data, err)
+	}
+
+	// Changes through the mount are seen at once
+	if _, err := mfs.Write("/data/a.txt", []byte("again"), -1, filesystem.WriteFlagTruncate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if data, err := mfs.Read("/data/a.txt", 0, -1); (err != nil && err != io.EOF) || string(data) != "again" {
+		t.Errorf("Read() after write = %q, %v, want again", data, err)
+	}
+
+	// Listing a directory loads its files in the background
+	if _, err := backend.Write("/b.txt", []byte("bee"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("backend Write() error = %v", err)
+	}
+	if _, err := mfs.ReadDir("/data"); err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, ok := cache.get("/b.txt"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("b.txt was not prefetched after listing")
+		}
+	}
+}
+
+func TestParseMountOptionsMiddlewareErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{"not a list", "readonly"},
+		{"unknown", []interface{}{"nope"}},
+		{"missing name", []interface{}{map[string]interface{}{"reads": true}}},
+		{"unknown option", []interface{}{map[string]interface{}{"name": "readonly", "x": 1}}},
+		{"bad error rate", []interface{}{map[string]interface{}{"name": "fault", "error_rate": 2}}},
+		{"bad latency", []interface{}{map[string]interface{}{"name": "fault", "latency": "soon"}}},
+		{"bad operation", []interface{}{map[string]interface{}{"name": "fault", "operations": []interface{}{"explode"}}}},
+		{"no timeouts", []interface{}{"timeout"}},
+		{"bad timeout", []interface{}{map[string]interface{}{"name": "timeout", "read": "-1s"}}},
+		{"bad failures", []interface{}{map[string]interface{}{"name": "circuit_breaker", "failures": 0}}},
+		{"bad cooldown", []interface{}{map[string]interface{}{"name": "circuit_breaker", "cooldown": 30}}},
+		{"bad cache size", []interface{}{map[string]interface{}{"name": "cache", "max_size": "lots"}}},
+		{"bad cache ttl", []interface{}{map[string]interface{}{"name": "cache", "ttl": "0s"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ParseMountOptions(map[string]interface{}{MiddlewareConfigKey: tt.value})
+			if err == nil {
+				t.Error("ParseMountOptions() error = nil, want error")
+			}
+		})
+	}
+
+	opts, rest, err := ParseMountOptions(map[string]interface{}{
+		MiddlewareConfigKey: []interface{}{"audit", "readonly"},
+		"other":             1,
+	})
+	if err != nil {
+		t.Fatalf("ParseMountOptions() error = %v", err)
+	}
+	if len(opts.Middleware) != 2 || opts.Middleware[0].Name() != "audit" || opts.Middleware[1].Name() != "readonly" {
+		t.Errorf("Middleware = %v, want [audit readonly]", opts.Middleware)
+	}
+	if _, ok := rest[MiddlewareConfigKey]; ok || rest["other"] != 1 {
+		t.Errorf("plugin config = %v, want only other", rest)
+	}
+}
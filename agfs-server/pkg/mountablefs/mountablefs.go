@@ -1,6 +1,7 @@
 package mountablefs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -29,6 +30,27 @@ type MountPoint struct {
 	Path   string
 	Plugin plugin.ServicePlugin
 	Config map[string]interface{} // Plugin configuration
+
+	fs    filesystem.FileSystem // Plugin file system wrapped in the mount's middleware
+	trash *trashBin             // Non-nil when removed files go to the mount's trash
+	ttl   *ttlSweeper           // Non-nil when the mount applies TTL policies
+
+	retention *retentionStore // Non-nil when the mount enforces retention
+
+	shadow *shadowFS // Non-nil when the mount is mirrored to another one
+
+	writeback *writebackFS // Non-nil when changes are journaled and applied in the background
+
+	replicas *replicaSet // Non-nil when replicas serve the reads of hot files
+}
+
+// fileSystem returns the file system serving the mount, which is the
+// plugin's own unless the mount has middleware
+func (mp *MountPoint) fileSystem() filesystem.FileSystem {
+	if mp.fs != nil {
+		return mp.fs
+	}
+	return mp.Plugin.GetFileSystem()
 }
 
 // PluginFactory is a function that creates a new plugin instance
@@ -58,6 +80,9 @@ type MountableFS struct {
 	// This allows symlinks to work across all filesystems without backend support
 	symlinks   map[string]string // Key: link path, Value: target path
 	symlinksMu sync.RWMutex
+
+	// Watchers of filesystem events (see watch.go)
+	watches watchHub
 }
 
 // handleInfo stores information about a handle, including its mount point and local handle
@@ -78,6 +103,9 @@ func NewMountableFS(poolConfig api.PoolConfig) *MountableFS {
 	mfs.mountTree.Store(iradix.New())
 	// Start global handle IDs from 1
 	mfs.globalHandleID.Store(0)
+	// Event cursors continue from the clock so that cursors of a previous
+	// process are older than any of this one
+	mfs.watches.seq = uint64(time.Now().UnixMicro())
 	return mfs
 }
 
@@ -150,6 +178,12 @@ func (mfs *MountableFS) CreatePlugin(name string) plugin.ServicePlugin {
 
 // Mount mounts a service plugin at the specified path
 func (mfs *MountableFS) Mount(path string, plugin plugin.ServicePlugin) error {
+	return mfs.MountWithOptions(path, plugin, MountOptions{})
+}
+
+// MountWithOptions mounts an initialized service plugin at the specified
+// path with mount-level options (see ParseMountOptions)
+func (mfs *MountableFS) MountWithOptions(path string, plugin plugin.ServicePlugin, opts MountOptions) error {
 	mfs.mu.Lock()
 	defer mfs.mu.Unlock()
 
@@ -163,6 +197,9 @@ func (mfs *MountableFS) Mount(path string, plugin plugin.ServicePlugin) error {
 	if _, exists := tree.Get([]byte(path)); exists {
 		return filesystem.NewAlreadyExistsError("mount", path)
 	}
+	if err := checkMountOptions(path, opts); err != nil {
+		return err
+	}
 
 	// Special handling for plugins that need parent filesystem reference
 	type parentFSSetter interface {
@@ -173,12 +210,13 @@ func (mfs *MountableFS) Mount(path string, plugin plugin.ServicePlugin) error {
 		log.Debugf("Set parentFS for plugin at %s", path)
 	}
 
+	mount, err := mfs.buildMountFS(path, plugin, make(map[string]interface{}), opts)
+	if err != nil {
+		return err
+	}
+
 	// Create new tree with added mount
-	newTree, _, _ := tree.Insert([]byte(path), &MountPoint{
-		Path:   path,
-		Plugin: plugin,
-		Config: make(map[string]interface{}),
-	})
+	newTree, _, _ := tree.Insert([]byte(path), mount)
 
 	// Atomically update tree
 	mfs.mountTree.Store(newTree)
@@ -229,11 +267,16 @@ func (mfs *MountableFS) MountPlugin(fstype string, path string, config map[strin
 		log.Debugf("Set parentFS for plugin %s at %s", fstype, path)
 	}
 
-	// Inject mount_path into config
-	configWithPath := make(map[string]interface{})
-	for k, v := range config {
-		configWithPath[k] = v
+	// Mount-level options are not part of the plugin's configuration
+	opts, configWithPath, err := ParseMountOptions(config)
+	if err == nil {
+		err = checkMountOptions(path, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to validate plugin: %v", err)
 	}
+
+	// Inject mount_path into config
 	configWithPath["mount_path"] = path
 
 	// Validate plugin configuration
@@ -246,12 +289,14 @@ func (mfs *MountableFS) MountPlugin(fstype string, path string, config map[strin
 		return fmt.Errorf("failed to initialize plugin: %v", err)
 	}
 
+	mount, err := mfs.buildMountFS(path, pluginInstance, config, opts)
+	if err != nil {
+		pluginInstance.Shutdown()
+		return err
+	}
+
 	// Create new tree with added mount
-	newTree, _, _ := tree.Insert([]byte(path), &MountPoint{
-		Path:   path,
-		Plugin: pluginInstance,
-		Config: config,
-	})
+	newTree, _, _ := tree.Insert([]byte(path), mount)
 
 	// Atomically update tree
 	mfs.mountTree.Store(newTree)
@@ -260,6 +305,52 @@ func (mfs *MountableFS) MountPlugin(fstype string, path string, config map[strin
 	return nil
 }
 
+// checkMountOptions checks the mounts opts refer to against the mount at path
+func checkMountOptions(path string, opts MountOptions) error {
+	if err := checkShadowTarget(path, opts.Shadow); err != nil {
+		return err
+	}
+	return checkReplicaTargets(path, opts.Replicas)
+}
+
+// buildMountFS returns the mount point of plugin at path, its file system
+// wrapped as opts require: the middleware, the write-back, then the shadow,
+// with the trash, retention and TTL policies applied on top and replicas
+// serving its reads
+func (mfs *MountableFS) buildMountFS(path string, plugin plugin.ServicePlugin, config map[string]interface{}, opts MountOptions) (*MountPoint, error) {
+	mount := &MountPoint{
+		Path:   path,
+		Plugin: plugin,
+		Config: config,
+		fs:     wrapMiddleware(plugin.GetFileSystem(), opts.Middleware),
+	}
+	if opts.Writeback != nil {
+		writeback, err := openWritebackFS(mount.fs, opts.Writeback)
+		if err != nil {
+			return nil, err
+		}
+		mount.writeback = writeback
+		mount.fs = writeback
+	}
+	if opts.Shadow != nil {
+		mount.shadow = newShadowFS(mfs, mount.fs, opts.Shadow)
+		mount.fs = mount.shadow
+	}
+	if opts.Replicas != nil {
+		mount.replicas = newReplicaSet(mfs, opts.Replicas)
+	}
+	if opts.TrashRetention > 0 {
+		mount.trash = newTrashBin(mount.fs, opts.TrashRetention)
+	}
+	if opts.Retention {
+		mount.retention = newRetentionStore(mount.fs)
+	}
+	if opts.TTLPolicies != nil {
+		mount.ttl = newTTLSweeper(mount.fs, opts.TTLPolicies, mount.retention)
+	}
+	return mount, nil
+}
+
 // Unmount unmounts a plugin from the specified path
 func (mfs *MountableFS) Unmount(path string) error {
 	mfs.mu.Lock()
@@ -280,6 +371,21 @@ func (mfs *MountableFS) Unmount(path string) error {
 		return fmt.Errorf("failed to close open handles for mount %s: %w", path, err)
 	}
 
+	if mount.trash != nil {
+		mount.trash.close()
+		mount.trash = nil
+	}
+	if mount.ttl != nil {
+		mount.ttl.close()
+		mount.ttl = nil
+	}
+	if mount.shadow != nil {
+		mount.shadow.close() // Mirrors what is still queued
+	}
+	if mount.writeback != nil {
+		mount.writeback.close() // Leaves what the backend doesn't take in the journal
+	}
+
 	// Shutdown the plugin
 	if err := mount.Plugin.Shutdown(); err != nil {
 		return fmt.Errorf("failed to shutdown plugin: %v", err)
@@ -483,7 +589,48 @@ func (mfs *MountableFS) Create(path string) error {
 	mount, relPath, found := mfs.findMount(resolved)
 
 	if found {
-		return mount.Plugin.GetFileSystem().Create(relPath)
+		if mount.trash != nil && isControlFile(relPath) {
+			return nil // Control files always exist
+		}
+		if err := mount.checkRetentionOverwrite("create", path, relPath); err != nil {
+			return err
+		}
+		fs, fsPath := mount.route(relPath)
+		err := fs.Create(fsPath)
+		mfs.notify(filesystem.EventCreate, resolved, err)
+		return err
+	}
+	return filesystem.NewPermissionDeniedError("create", path, "not allowed to create file in rootfs, use mount instead")
+}
+
+// Reserve implements filesystem.Reserver. Mounts whose file system can't
+// prepare for the write get a plain Create.
+func (mfs *MountableFS) Reserve(path string, size int64) error {
+	if err := filesystem.ValidateReserveSize(size); err != nil {
+		return err
+	}
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return err
+	}
+
+	mount, relPath, found := mfs.findMount(resolved)
+
+	if found {
+		if mount.trash != nil && isControlFile(relPath) {
+			return nil // Control files always exist
+		}
+		if err := mount.checkRetentionOverwrite("create", path, relPath); err != nil {
+			return err
+		}
+		fs, fsPath := mount.route(relPath)
+		if reserver, ok := filesystem.As[filesystem.Reserver](fs); ok {
+			err = reserver.Reserve(fsPath, size)
+		} else {
+			err = fs.Create(fsPath)
+		}
+		mfs.notify(filesystem.EventCreate, resolved, err)
+		return err
 	}
 	return filesystem.NewPermissionDeniedError("create", path, "not allowed to create file in rootfs, use mount instead")
 }
@@ -498,7 +645,10 @@ func (mfs *MountableFS) Mkdir(path string, perm uint32) error {
 	mount, relPath, found := mfs.findMount(resolved)
 
 	if found {
-		return mount.Plugin.GetFileSystem().Mkdir(relPath, perm)
+		fs, fsPath := mount.route(relPath)
+		err := fs.Mkdir(fsPath, perm)
+		mfs.notify(filesystem.EventMkdir, resolved, err)
+		return err
 	}
 	return filesystem.NewPermissionDeniedError("mkdir", path, "not allowed to create directory in rootfs, use mount instead")
 }
@@ -511,6 +661,7 @@ func (mfs *MountableFS) Remove(path string) error {
 		delete(mfs.symlinks, path)
 		mfs.symlinksMu.Unlock()
 		log.Infof("Removed symlink: %s", path)
+		mfs.notify(filesystem.EventRemove, path, nil)
 		return nil
 	}
 	mfs.symlinksMu.Unlock()
@@ -524,7 +675,23 @@ func (mfs *MountableFS) Remove(path string) error {
 	mount, relPath, found := mfs.findMount(resolved)
 
 	if found {
-		return mount.Plugin.GetFileSystem().Remove(relPath)
+		if isHealthFile(relPath) {
+			if _, _, ok := mount.healthFile(); ok {
+				return filesystem.NewPermissionDeniedError("remove", path, "the health file is read-only")
+			}
+		}
+		if err := mount.checkRetentionRemove("remove", path, relPath); err != nil {
+			return err
+		}
+		if mount.trash != nil && !mount.snapshotPath(relPath) {
+			err := mount.trash.remove(relPath, false)
+			mfs.notify(filesystem.EventRemove, resolved, err)
+			return err
+		}
+		fs, fsPath := mount.route(relPath)
+		err := fs.Remove(fsPath)
+		mfs.notify(filesystem.EventRemove, resolved, err)
+		return err
 	}
 	return filesystem.NewNotFoundError("remove", path)
 }
@@ -533,12 +700,28 @@ func (mfs *MountableFS) RemoveAll(path string) error {
 	mount, relPath, found := mfs.findMount(path)
 
 	if found {
-		return mount.Plugin.GetFileSystem().RemoveAll(relPath)
+		if err := mount.checkRetentionRemove("removeall", path, relPath); err != nil {
+			return err
+		}
+		if mount.trash != nil && !mount.snapshotPath(relPath) {
+			err := mount.trash.remove(relPath, true)
+			mfs.notify(filesystem.EventRemove, path, err)
+			return err
+		}
+		fs, fsPath := mount.route(relPath)
+		err := fs.RemoveAll(fsPath)
+		mfs.notify(filesystem.EventRemove, path, err)
+		return err
 	}
 	return filesystem.NewNotFoundError("removeall", path)
 }
 
 func (mfs *MountableFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	return mfs.ReadContext(context.Background(), path, offset, size)
+}
+
+// ReadContext implements filesystem.ContextFileSystem
+func (mfs *MountableFS) ReadContext(ctx context.Context, path string, offset int64, size int64) ([]byte, error) {
 	// Resolve symlinks in all path components
 	resolved, err := mfs.resolvePath(path)
 	if err != nil {
@@ -548,12 +731,36 @@ func (mfs *MountableFS) Read(path string, offset int64, size int64) ([]byte, err
 	mount, relPath, found := mfs.findMount(resolved)
 
 	if found {
-		return mount.Plugin.GetFileSystem().Read(relPath, offset, size)
+		if mount.trash != nil && isControlFile(relPath) {
+			return plugin.ApplyRangeRead([]byte(trashControlHelp), offset, size)
+		}
+		if mount.ttl != nil && isTTLFile(relPath) {
+			if data, ok := mount.ttl.render(relPath); ok {
+				return plugin.ApplyRangeRead(data, offset, size)
+			}
+		}
+		if isHealthFile(relPath) {
+			if data, _, ok := mount.healthFile(); ok {
+				return plugin.ApplyRangeRead(data, offset, size)
+			}
+		}
+		fs, fsPath := mount.route(relPath)
+		if mount.replicas != nil && !mount.snapshotPath(relPath) {
+			return mount.replicas.read(ctx, relPath, offset, size, func() ([]byte, error) {
+				return readContext(ctx, fs, fsPath, offset, size)
+			})
+		}
+		return readContext(ctx, fs, fsPath, offset, size)
 	}
 	return nil, filesystem.NewNotFoundError("read", path)
 }
 
 func (mfs *MountableFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	return mfs.WriteContext(context.Background(), path, data, offset, flags)
+}
+
+// WriteContext implements filesystem.ContextFileSystem
+func (mfs *MountableFS) WriteContext(ctx context.Context, path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
 	// Resolve symlinks in all path components
 	resolved, err := mfs.resolvePath(path)
 	if err != nil {
@@ -563,12 +770,49 @@ func (mfs *MountableFS) Write(path string, data []byte, offset int64, flags file
 	mount, relPath, found := mfs.findMount(resolved)
 
 	if found {
-		return mount.Plugin.GetFileSystem().Write(relPath, data, offset, flags)
+		if mount.trash != nil && isControlFile(relPath) {
+			if err := mount.trash.control(relPath, data); err != nil {
+				return 0, err
+			}
+			return int64(len(data)), nil
+		}
+		if mount.ttl != nil && isTTLFile(relPath) {
+			n, err := mount.ttl.setPolicy(relPath, data)
+			mfs.notify(filesystem.EventWrite, resolved, err)
+			return n, err
+		}
+		if isHealthFile(relPath) {
+			if _, _, ok := mount.healthFile(); ok {
+				return 0, filesystem.NewPermissionDeniedError("write", path, "the health file is read-only")
+			}
+		}
+		if err := mount.checkRetentionOverwrite("write", path, relPath); err != nil {
+			return 0, err
+		}
+		fs, fsPath := mount.route(relPath)
+		n, err := writeContext(ctx, fs, fsPath, data, offset, flags)
+		mfs.notify(filesystem.EventWrite, resolved, err)
+		return n, err
 	}
 	return 0, filesystem.NewNotFoundError("write", path)
 }
 
 func (mfs *MountableFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	return mfs.ReadDirContext(context.Background(), path)
+}
+
+// ReadDirContext implements filesystem.ContextFileSystem
+func (mfs *MountableFS) ReadDirContext(ctx context.Context, path string) ([]filesystem.FileInfo, error) {
+	return mfs.readDir(ctx, path, false)
+}
+
+// ReadDirNames implements filesystem.NameLister: mounts that are name
+// listers are asked for names only
+func (mfs *MountableFS) ReadDirNames(path string) ([]filesystem.FileInfo, error) {
+	return mfs.readDir(context.Background(), path, true)
+}
+
+func (mfs *MountableFS) readDir(ctx context.Context, path string, namesOnly bool) ([]filesystem.FileInfo, error) {
 	// Lock-free implementation
 	path = filesystem.NormalizePath(path)
 
@@ -582,9 +826,28 @@ func (mfs *MountableFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	mount, relPath, found := mfs.findMount(resolved)
 	if found {
 		// Get contents from the mounted filesystem
-		infos, err := mount.Plugin.GetFileSystem().ReadDir(relPath)
+		fs, fsPath := mount.route(relPath)
+		var infos []filesystem.FileInfo
+		if lister, ok := fs.(filesystem.NameLister); ok && namesOnly {
+			infos, err = lister.ReadDirNames(fsPath)
+		} else {
+			infos, err = readDirContext(ctx, fs, fsPath)
+		}
 		if err != nil {
-			return nil, err
+			if mount.trash == nil || relPath != TrashDir {
+				return nil, err
+			}
+			infos = nil // The trash directory appears with the first removal
+		}
+		if mount.trash != nil && relPath == TrashDir {
+			infos = append(infos, controlFileInfo(TrashRestoreFile), controlFileInfo(TrashPurgeFile))
+		}
+		if mount.ttl != nil {
+			infos = mount.ttl.withPolicyFile(relPath, infos)
+		}
+		if relPath == "/" {
+			infos = mount.withSnapshotDir(infos)
+			infos = mount.withHealthFile(infos)
 		}
 
 		// Also check for any nested mounts directly under this path
@@ -824,8 +1087,26 @@ func (mfs *MountableFS) statWithoutSymlinkCheck(path string) (*filesystem.FileIn
 	// Check if path is a mount point or within a mount
 	mount, relPath, found := mfs.findMount(resolved)
 	if found {
-		stat, err := mount.Plugin.GetFileSystem().Stat(relPath)
+		if mount.trash != nil && isControlFile(relPath) {
+			info := controlFileInfo(filepath.Base(relPath))
+			return &info, nil
+		}
+		if mount.ttl != nil && isTTLFile(relPath) {
+			if info, ok := mount.ttl.fileInfo(relPath); ok {
+				return info, nil
+			}
+		}
+		if isHealthFile(relPath) {
+			if _, info, ok := mount.healthFile(); ok {
+				return info, nil
+			}
+		}
+		fs, fsPath := mount.route(relPath)
+		stat, err := fs.Stat(fsPath)
 		if err != nil {
+			if mount.trash != nil && relPath == TrashDir {
+				return &filesystem.FileInfo{Name: ".trash", Mode: 0755, ModTime: time.Now(), IsDir: true}, nil
+			}
 			return nil, err
 		}
 
@@ -890,7 +1171,18 @@ func (mfs *MountableFS) Rename(oldPath, newPath string) error {
 		if oldMount != newMount {
 			return fmt.Errorf("cannot rename across different mounts")
 		}
-		return oldMount.Plugin.GetFileSystem().Rename(oldRelPath, newRelPath)
+		if oldMount.snapshotPath(oldRelPath) || oldMount.snapshotPath(newRelPath) {
+			return filesystem.NewPermissionDeniedError("rename", oldPath, "snapshots are read-only")
+		}
+		if err := oldMount.checkRetentionRemove("rename", oldPath, oldRelPath); err != nil {
+			return err
+		}
+		if err := oldMount.checkRetentionOverwrite("rename", newPath, newRelPath); err != nil {
+			return err
+		}
+		err := oldMount.fileSystem().Rename(oldRelPath, newRelPath)
+		mfs.notifyRename(oldPath, newPath, err)
+		return err
 	}
 
 	return fmt.Errorf("cannot rename: paths not in same mounted filesystem")
@@ -906,11 +1198,442 @@ func (mfs *MountableFS) Chmod(path string, mode uint32) error {
 	mount, relPath, found := mfs.findMount(resolved)
 
 	if found {
-		return mount.Plugin.GetFileSystem().Chmod(relPath, mode)
+		fs, fsPath := mount.route(relPath)
+		err := fs.Chmod(fsPath, mode)
+		mfs.notify(filesystem.EventChmod, resolved, err)
+		return err
 	}
 	return filesystem.NewNotFoundError("chmod", path)
 }
 
+// Checksum implements filesystem.Checksummer interface
+// Returns ErrNotSupported if the mounted filesystem cannot checksum natively
+func (mfs *MountableFS) Checksum(path string, algorithm string) (string, error) {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return "", err
+	}
+
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return "", filesystem.NewNotFoundError("checksum", path)
+	}
+
+	fs, fsPath := mount.route(relPath)
+	if cs, ok := filesystem.As[filesystem.Checksummer](fs); ok {
+		return cs.Checksum(fsPath, algorithm)
+	}
+	return "", filesystem.ErrNotSupported
+}
+
+// Prefetch implements filesystem.Prefetcher interface
+// Returns ErrNotSupported if the mount has no content cache
+func (mfs *MountableFS) Prefetch(path string) error {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return err
+	}
+
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return filesystem.NewNotFoundError("prefetch", path)
+	}
+
+	fs, fsPath := mount.route(relPath)
+	if p, ok := filesystem.As[filesystem.Prefetcher](fs); ok {
+		return p.Prefetch(fsPath)
+	}
+	return filesystem.ErrNotSupported
+}
+
+// ListDeleted implements filesystem.Restorer interface
+// Returns ErrNotSupported if the mounted filesystem doesn't soft-delete
+func (mfs *MountableFS) ListDeleted(path string) ([]filesystem.DeletedEntry, error) {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return nil, filesystem.NewNotFoundError("listdeleted", path)
+	}
+
+	fs, fsPath := mount.route(relPath)
+	if r, ok := filesystem.As[filesystem.Restorer](fs); ok {
+		return r.ListDeleted(fsPath)
+	}
+	return nil, filesystem.ErrNotSupported
+}
+
+// Restore implements filesystem.Restorer interface
+// Returns ErrNotSupported if the mounted filesystem doesn't soft-delete
+func (mfs *MountableFS) Restore(path string) error {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return err
+	}
+
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return filesystem.NewNotFoundError("restore", path)
+	}
+
+	fs, fsPath := mount.route(relPath)
+	if r, ok := filesystem.As[filesystem.Restorer](fs); ok {
+		err := r.Restore(fsPath)
+		mfs.notify(filesystem.EventMkdir, resolved, err)
+		return err
+	}
+	return filesystem.ErrNotSupported
+}
+
+// AuthorizeTenant implements filesystem.TenantScoper interface. Mounts
+// that don't scope their entries allow everything; a recursive operation
+// on a parent of mounts that do is checked against each of them.
+func (mfs *MountableFS) AuthorizeTenant(identity, path string, recursive bool) error {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return err
+	}
+
+	if mount, relPath, found := mfs.findMount(resolved); found {
+		fs, fsPath := mount.route(relPath)
+		if scoper, ok := filesystem.As[filesystem.TenantScoper](fs); ok {
+			if err := scoper.AuthorizeTenant(identity, fsPath, recursive); err != nil {
+				return err
+			}
+		}
+	}
+	if !recursive {
+		return nil
+	}
+	for _, mount := range mfs.GetMounts() {
+		if mount.Path == resolved || !strings.HasPrefix(mount.Path, strings.TrimSuffix(resolved, "/")+"/") {
+			continue
+		}
+		if scoper, ok := filesystem.As[filesystem.TenantScoper](mount.fileSystem()); ok {
+			if err := scoper.AuthorizeTenant(identity, "/", true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FilterTenant implements filesystem.TenantScoper interface
+func (mfs *MountableFS) FilterTenant(identity, path string, entries []filesystem.FileInfo) []filesystem.FileInfo {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return entries
+	}
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return entries
+	}
+	fs, fsPath := mount.route(relPath)
+	if scoper, ok := filesystem.As[filesystem.TenantScoper](fs); ok {
+		return scoper.FilterTenant(identity, fsPath, entries)
+	}
+	return entries
+}
+
+// MkdirAs implements filesystem.TenantScoper interface
+// Mounts that don't scope their entries create the directory with Mkdir
+func (mfs *MountableFS) MkdirAs(identity, path string, perm uint32) error {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return err
+	}
+
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return mfs.Mkdir(path, perm)
+	}
+	fs, fsPath := mount.route(relPath)
+	scoper, ok := filesystem.As[filesystem.TenantScoper](fs)
+	if !ok {
+		return mfs.Mkdir(path, perm)
+	}
+	err = scoper.MkdirAs(identity, fsPath, perm)
+	mfs.notify(filesystem.EventMkdir, resolved, err)
+	return err
+}
+
+// SetExpiry implements filesystem.Expirer interface
+// Returns ErrNotSupported if the mounted filesystem cannot expire files
+func (mfs *MountableFS) SetExpiry(path string, expiresAt time.Time) error {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return err
+	}
+
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return filesystem.NewNotFoundError("setexpiry", path)
+	}
+
+	fs, fsPath := mount.route(relPath)
+	if _, ok := fs.(*snapshotsFS); ok {
+		return filesystem.NewPermissionDeniedError("setexpiry", path, "snapshots are read-only")
+	}
+	if !expiresAt.IsZero() {
+		if err := mount.checkRetentionRemove("setexpiry", path, relPath); err != nil {
+			return err
+		}
+	}
+	if expirer, ok := filesystem.As[filesystem.Expirer](fs); ok {
+		return expirer.SetExpiry(fsPath, expiresAt)
+	}
+	return filesystem.ErrNotSupported
+}
+
+// Increment implements filesystem.Incrementer interface
+// Returns ErrNotSupported if the mounted filesystem has no native counters
+func (mfs *MountableFS) Increment(path string, delta int64) (int64, error) {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return 0, err
+	}
+
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return 0, filesystem.NewNotFoundError("increment", path)
+	}
+
+	fs, fsPath := mount.route(relPath)
+	if _, ok := fs.(*snapshotsFS); ok {
+		return 0, filesystem.NewPermissionDeniedError("increment", path, "snapshots are read-only")
+	}
+	if err := mount.checkRetentionOverwrite("increment", path, relPath); err != nil {
+		return 0, err
+	}
+	if inc, ok := filesystem.As[filesystem.Incrementer](fs); ok {
+		value, err := inc.Increment(fsPath, delta)
+		if err != filesystem.ErrNotSupported {
+			mfs.notify(filesystem.EventWrite, resolved, err)
+		}
+		return value, err
+	}
+	return 0, filesystem.ErrNotSupported
+}
+
+// Append implements filesystem.Appender interface
+// Returns ErrNotSupported if the mounted filesystem cannot append atomically
+func (mfs *MountableFS) Append(path string, data []byte) (int64, error) {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return 0, err
+	}
+
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return 0, filesystem.NewNotFoundError("append", path)
+	}
+
+	fs, fsPath := mount.route(relPath)
+	if _, ok := fs.(*snapshotsFS); ok {
+		return 0, filesystem.NewPermissionDeniedError("append", path, "snapshots are read-only")
+	}
+	if err := mount.checkRetentionOverwrite("append", path, relPath); err != nil {
+		return 0, err
+	}
+	if appender, ok := filesystem.As[filesystem.Appender](fs); ok {
+		offset, err := appender.Append(fsPath, data)
+		if err != filesystem.ErrNotSupported {
+			mfs.notify(filesystem.EventWrite, resolved, err)
+		}
+		return offset, err
+	}
+	return 0, filesystem.ErrNotSupported
+}
+
+// DescribeContent implements filesystem.ContentDescriber interface,
+// describing the file with the rules of the mount that owns it
+func (mfs *MountableFS) DescribeContent(path string, info *filesystem.FileInfo) (string, string) {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return "", ""
+	}
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return "", ""
+	}
+	fs, fsPath := mount.route(relPath)
+	return filesystem.DescribeContent(fs, fsPath, info)
+}
+
+// PreviewDir implements filesystem.DirPreviewer interface, previewing the
+// entries with the rules of the mount that owns the directory
+func (mfs *MountableFS) PreviewDir(dir string, entries []filesystem.FileInfo, n int) map[string]filesystem.FilePreview {
+	resolved, err := mfs.resolvePath(filesystem.NormalizePath(dir))
+	if err != nil {
+		return nil
+	}
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return nil
+	}
+	fs, fsPath := mount.route(relPath)
+	return filesystem.PreviewDir(fs, fsPath, entries, n)
+}
+
+// DryRun implements filesystem.DryRunner interface
+// The operation is previewed by the mounted filesystem, with the verdicts of
+// the router: snapshots are read-only, renames stay within one mount and
+// removals on mounts with a trash move paths to the trash.
+func (mfs *MountableFS) DryRun(op filesystem.DryRunOp) (*filesystem.DryRunResult, error) {
+	op.Path = filesystem.NormalizePath(op.Path)
+	denied := func(policy, reason string) (*filesystem.DryRunResult, error) {
+		result := &filesystem.DryRunResult{Op: op.Op, Path: op.Path, Effects: []filesystem.DryRunEffect{}}
+		result.Deny(policy, reason)
+		return result, nil
+	}
+
+	// Symlinks live in the router
+	mfs.symlinksMu.RLock()
+	_, isSymlink := mfs.symlinks[op.Path]
+	mfs.symlinksMu.RUnlock()
+	if op.Op == filesystem.DryRunSymlink || (op.Op == filesystem.DryRunRemove && isSymlink) {
+		return filesystem.Preview(mfs, op)
+	}
+
+	resolved := op.Path
+	if op.Op != filesystem.DryRunRename {
+		var err error
+		if resolved, err = mfs.resolvePath(op.Path); err != nil {
+			return nil, err
+		}
+	}
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return denied("mount", "no filesystem is mounted at path")
+	}
+	if mount.snapshotPath(relPath) {
+		return denied("snapshot", "snapshots are read-only")
+	}
+
+	relOp := op
+	relOp.Path = relPath
+	if op.Op == filesystem.DryRunRename {
+		targetMount, targetRel, found := mfs.findMount(op.Target)
+		if !found || targetMount != mount {
+			return denied("mount", "cannot rename across different mounts")
+		}
+		if mount.snapshotPath(targetRel) {
+			return denied("snapshot", "snapshots are read-only")
+		}
+		relOp.Target = targetRel
+	}
+
+	fs, fsPath := mount.route(relPath)
+	relOp.Path = fsPath
+	result, err := filesystem.DryRun(fs, relOp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Report paths in the global namespace
+	result.Path = op.Path
+	toTrash := op.Op == filesystem.DryRunRemove && mount.trash != nil && !inTrash(relPath)
+	for i := range result.Effects {
+		e := &result.Effects[i]
+		e.Path = filepath.Join(mount.Path, e.Path)
+		if e.Action == "rename" {
+			e.Target = filepath.Join(mount.Path, e.Target)
+		}
+		if toTrash && e.Action == "delete" {
+			e.Action = "trash"
+		}
+	}
+	if toTrash && result.Allowed {
+		result.Allow("trash", "removed paths can be restored from "+filepath.Join(mount.Path, TrashDir))
+	}
+	return result, nil
+}
+
+// SetContentType implements filesystem.ContentTyper interface
+// Returns ErrNotSupported if the mounted filesystem cannot store media types
+func (mfs *MountableFS) SetContentType(path string, contentType string) error {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return err
+	}
+
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return filesystem.NewNotFoundError("setcontenttype", path)
+	}
+
+	fs, fsPath := mount.route(relPath)
+	if _, ok := fs.(*snapshotsFS); ok {
+		return filesystem.NewPermissionDeniedError("setcontenttype", path, "snapshots are read-only")
+	}
+	if typer, ok := filesystem.As[filesystem.ContentTyper](fs); ok {
+		return typer.SetContentType(fsPath, contentType)
+	}
+	return filesystem.ErrNotSupported
+}
+
+// ReadTable implements filesystem.TableReader interface
+// Returns ErrNotSupported if the mounted filesystem has no tables
+func (mfs *MountableFS) ReadTable(path string, maxRows int) (*filesystem.Table, error) {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return nil, filesystem.NewNotFoundError("readtable", path)
+	}
+
+	if reader, ok := filesystem.As[filesystem.TableReader](mount.fileSystem()); ok {
+		return reader.ReadTable(relPath, maxRows)
+	}
+	return nil, filesystem.ErrNotSupported
+}
+
+// Find implements filesystem.Finder interface
+// A search inside a single mount is delegated to the mounted filesystem so its
+// Finder fast path is used; searches spanning several mounts fall back to a walk.
+func (mfs *MountableFS) Find(root string, opts filesystem.FindOptions, fn filesystem.WalkFunc) error {
+	// filesystem.Find enforces the limit on the results we emit
+	opts.Limit = 0
+
+	root = filesystem.NormalizePath(root)
+	resolved, err := mfs.resolvePath(root)
+	if err != nil {
+		return err
+	}
+	resolved = filesystem.NormalizePath(resolved)
+
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found || mfs.hasMountsBelow(resolved) {
+		_, err := filesystem.FindByWalk(mfs, root, opts, fn)
+		return err
+	}
+
+	fs, fsPath := mount.route(relPath)
+	_, err = filesystem.Find(fs, fsPath, opts, func(entry filesystem.WalkEntry) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(entry.Path, fsPath), "/")
+		entry.Path = filepath.Join(root, rel)
+		return fn(entry)
+	})
+	return err
+}
+
+// hasMountsBelow reports whether any mount point lies strictly under path
+func (mfs *MountableFS) hasMountsBelow(path string) bool {
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for _, m := range mfs.GetMounts() {
+		if m.Path != path && strings.HasPrefix(m.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // Truncate implements filesystem.Truncater interface
 func (mfs *MountableFS) Truncate(path string, size int64) error {
 	mount, relPath, found := mfs.findMount(path)
@@ -919,9 +1642,18 @@ func (mfs *MountableFS) Truncate(path string, size int64) error {
 		return filesystem.NewNotFoundError("truncate", path)
 	}
 
-	fs := mount.Plugin.GetFileSystem()
-	if truncater, ok := fs.(filesystem.Truncater); ok {
-		return truncater.Truncate(relPath, size)
+	if mount.trash != nil && isControlFile(relPath) {
+		return nil // Opening a control file for writing truncates it
+	}
+	if err := mount.checkRetentionOverwrite("truncate", path, relPath); err != nil {
+		return err
+	}
+
+	fs, fsPath := mount.route(relPath)
+	if truncater, ok := filesystem.As[filesystem.Truncater](fs); ok {
+		err := truncater.Truncate(fsPath, size)
+		mfs.notify(filesystem.EventWrite, path, err)
+		return err
 	}
 	return fmt.Errorf("filesystem does not support truncate: %s", path)
 }
@@ -931,23 +1663,30 @@ func (mfs *MountableFS) Touch(path string) error {
 	mount, relPath, found := mfs.findMount(path)
 
 	if found {
-		fs := mount.Plugin.GetFileSystem()
-		if toucher, ok := fs.(filesystem.Toucher); ok {
-			return toucher.Touch(relPath)
+		if err := mount.checkRetentionOverwrite("touch", path, relPath); err != nil {
+			return err
+		}
+		fs, fsPath := mount.route(relPath)
+		if toucher, ok := filesystem.As[filesystem.Toucher](fs); ok {
+			err := toucher.Touch(fsPath)
+			mfs.notify(filesystem.EventWrite, path, err)
+			return err
 		}
-		info, err := fs.Stat(relPath)
+		info, err := fs.Stat(fsPath)
 		if err == nil {
 			if !info.IsDir {
-				data, readErr := fs.Read(relPath, 0, -1)
+				data, readErr := fs.Read(fsPath, 0, -1)
 				if readErr != nil {
 					return readErr
 				}
-				_, writeErr := fs.Write(relPath, data, -1, filesystem.WriteFlagNone)
+				_, writeErr := fs.Write(fsPath, data, -1, filesystem.WriteFlagNone)
+				mfs.notify(filesystem.EventWrite, path, writeErr)
 				return writeErr
 			}
 			return fmt.Errorf("cannot touch directory")
 		} else {
-			_, err := fs.Write(relPath, []byte{}, -1, filesystem.WriteFlagCreate)
+			_, err := fs.Write(fsPath, []byte{}, -1, filesystem.WriteFlagCreate)
+			mfs.notify(filesystem.EventCreate, path, err)
 			return err
 		}
 	}
@@ -964,7 +1703,11 @@ func (mfs *MountableFS) Open(path string) (io.ReadCloser, error) {
 	mount, relPath, found := mfs.findMount(resolved)
 
 	if found {
-		return mount.Plugin.GetFileSystem().Open(relPath)
+		fs, fsPath := mount.route(relPath)
+		if mount.replicas != nil && !mount.snapshotPath(relPath) {
+			return mount.replicas.open(relPath, func() (io.ReadCloser, error) { return fs.Open(fsPath) })
+		}
+		return fs.Open(fsPath)
 	}
 	return nil, filesystem.NewNotFoundError("open", path)
 }
@@ -979,7 +1722,15 @@ func (mfs *MountableFS) OpenWrite(path string) (io.WriteCloser, error) {
 	mount, relPath, found := mfs.findMount(resolved)
 
 	if found {
-		return mount.Plugin.GetFileSystem().OpenWrite(relPath)
+		if err := mount.checkRetentionOverwrite("openwrite", path, relPath); err != nil {
+			return nil, err
+		}
+		fs, fsPath := mount.route(relPath)
+		w, err := fs.OpenWrite(fsPath)
+		if err != nil {
+			return nil, err
+		}
+		return &watchedWriter{inner: w, mfs: mfs, path: resolved}, nil
 	}
 	return nil, filesystem.NewNotFoundError("openwrite", path)
 }
@@ -992,16 +1743,21 @@ func (mfs *MountableFS) OpenStream(path string) (filesystem.StreamReader, error)
 		return nil, filesystem.NewNotFoundError("openstream", path)
 	}
 
-	fs := mount.Plugin.GetFileSystem()
-	if streamer, ok := fs.(filesystem.Streamer); ok {
+	fs, fsPath := mount.route(relPath)
+	if streamer, ok := filesystem.As[filesystem.Streamer](fs); ok {
 		log.Debugf("[mountablefs] OpenStream: found streamer for path %s (relPath: %s, fs type: %T)", path, relPath, fs)
-		return streamer.OpenStream(relPath)
+		return streamer.OpenStream(fsPath)
 	}
 
 	log.Debugf("[mountablefs] OpenStream: filesystem does not support streaming: %s (fs type: %T)", path, fs)
 	return nil, fmt.Errorf("filesystem does not support streaming: %s", path)
 }
 
+// streamGetter is implemented by file systems serving streams to GetStream
+type streamGetter interface {
+	GetStream(path string) (interface{}, error)
+}
+
 // GetStream tries to get a stream from the underlying filesystem if it supports streaming
 // Deprecated: Use OpenStream instead
 func (mfs *MountableFS) GetStream(path string) (interface{}, error) {
@@ -1011,12 +1767,8 @@ func (mfs *MountableFS) GetStream(path string) (interface{}, error) {
 		return nil, filesystem.NewNotFoundError("getstream", path)
 	}
 
-	type streamGetter interface {
-		GetStream(path string) (interface{}, error)
-	}
-
-	fs := mount.Plugin.GetFileSystem()
-	if sg, ok := fs.(streamGetter); ok {
+	fs := mount.fileSystem()
+	if sg, ok := filesystem.As[streamGetter](fs); ok {
 		log.Debugf("[mountablefs] GetStream: found stream getter for path %s (relPath: %s, fs type: %T)", path, relPath, fs)
 		return sg.GetStream(relPath)
 	}
@@ -1040,15 +1792,20 @@ func (mfs *MountableFS) OpenHandle(path string, flags filesystem.OpenFlag, mode
 	if !found {
 		return nil, filesystem.NewNotFoundError("openhandle", path)
 	}
+	if flags&(filesystem.O_WRONLY|filesystem.O_RDWR|filesystem.O_APPEND|filesystem.O_TRUNC) != 0 {
+		if err := mount.checkRetentionOverwrite("openhandle", path, relPath); err != nil {
+			return nil, err
+		}
+	}
 
-	fs := mount.Plugin.GetFileSystem()
-	handleFS, ok := fs.(filesystem.HandleFS)
+	fs, fsPath := mount.route(relPath)
+	handleFS, ok := filesystem.As[filesystem.HandleFS](fs)
 	if !ok {
 		return nil, filesystem.NewNotSupportedError("openhandle", path)
 	}
 
 	// Open handle in the underlying filesystem
-	localHandle, err := handleFS.OpenHandle(relPath, flags, mode)
+	localHandle, err := handleFS.OpenHandle(fsPath, flags, mode)
 	if err != nil {
 		return nil, err
 	}
@@ -1168,12 +1925,23 @@ func (h *globalFileHandle) ReadAt(buf []byte, offset int64) (int, error) {
 
 // Write delegates to the underlying handle
 func (h *globalFileHandle) Write(data []byte) (int, error) {
-	return h.localHandle.Write(data)
+	n, err := h.localHandle.Write(data)
+	h.notifyWrite(err)
+	return n, err
 }
 
 // WriteAt delegates to the underlying handle
 func (h *globalFileHandle) WriteAt(data []byte, offset int64) (int, error) {
-	return h.localHandle.WriteAt(data, offset)
+	n, err := h.localHandle.WriteAt(data, offset)
+	h.notifyWrite(err)
+	return n, err
+}
+
+// notifyWrite reports a write through the handle to watchers
+func (h *globalFileHandle) notifyWrite(err error) {
+	if h.owner != nil {
+		h.owner.notify(filesystem.EventWrite, h.fullPath, err)
+	}
 }
 
 // Seek delegates to the underlying handle
@@ -1287,6 +2055,11 @@ func (mfs *MountableFS) resolvePath(path string) (string, error) {
 	return mfs.resolvePathWithSymlinks(path, 10)
 }
 
+// ResolvePath implements filesystem.PathResolver interface
+func (mfs *MountableFS) ResolvePath(path string) (string, error) {
+	return mfs.resolvePath(path)
+}
+
 // Symlink implements filesystem.Symlinker interface
 // Creates a virtual symlink at the mountablefs layer without requiring backend support
 func (mfs *MountableFS) Symlink(targetPath, linkPath string) error {
@@ -1326,6 +2099,7 @@ func (mfs *MountableFS) Symlink(targetPath, linkPath string) error {
 	mfs.symlinksMu.Lock()
 	mfs.symlinks[linkPath] = targetPath
 	mfs.symlinksMu.Unlock()
+	mfs.notify(filesystem.EventCreate, linkPath, nil)
 
 	log.Infof("Created symlink: %s -> %s", linkPath, targetPath)
 	return nil
@@ -1374,7 +2148,7 @@ func (mfs *MountableFS) CustomGrep(path, query string, limit int) ([]CustomGrepR
 	}
 
 	// Check if the plugin's filesystem implements CustomGrepper
-	grepper, ok := mount.Plugin.GetFileSystem().(CustomGrepper)
+	grepper, ok := filesystem.As[CustomGrepper](mount.fileSystem())
 	if !ok {
 		return nil, fmt.Errorf("path does not support custom grep: %s", path)
 	}
@@ -1399,3 +2173,45 @@ var _ filesystem.FileHandle = (*globalFileHandle)(nil)
 
 // Ensure MountableFS implements Truncater interface
 var _ filesystem.Truncater = (*MountableFS)(nil)
+
+// Ensure MountableFS implements Checksummer interface
+var _ filesystem.Checksummer = (*MountableFS)(nil)
+
+// Ensure MountableFS implements NameLister interface
+var _ filesystem.NameLister = (*MountableFS)(nil)
+
+// Ensure MountableFS implements Expirer interface
+var _ filesystem.Expirer = (*MountableFS)(nil)
+
+// Ensure MountableFS implements TenantScoper interface
+var _ filesystem.TenantScoper = (*MountableFS)(nil)
+
+// Ensure MountableFS implements Incrementer interface
+var _ filesystem.Incrementer = (*MountableFS)(nil)
+
+// Ensure MountableFS implements Appender interface
+var _ filesystem.Appender = (*MountableFS)(nil)
+
+// Ensure MountableFS implements TableReader interface
+var _ filesystem.TableReader = (*MountableFS)(nil)
+
+// Ensure MountableFS implements Finder interface
+var _ filesystem.Finder = (*MountableFS)(nil)
+
+// Ensure MountableFS implements ContentTyper interface
+var _ filesystem.ContentTyper = (*MountableFS)(nil)
+
+// Ensure MountableFS implements ContentDescriber interface
+var _ filesystem.ContentDescriber = (*MountableFS)(nil)
+
+// Ensure MountableFS implements DirPreviewer interface
+var _ filesystem.DirPreviewer = (*MountableFS)(nil)
+
+// Ensure MountableFS implements DryRunner interface
+var _ filesystem.DryRunner = (*MountableFS)(nil)
+
+// Ensure MountableFS implements Watcher interface
+var _ filesystem.Watcher = (*MountableFS)(nil)
+
+// Ensure MountableFS implements PathResolver interface
+var _ filesystem.PathResolver = (*MountableFS)(nil)
@@ -845,3 +845,44 @@ func TestSymlinkToDirectory(t *testing.T) {
 		t.Errorf("Expected 'test', got %s", string(data))
 	}
 }
+
+func TestFindDelegatesToMountAndRewritesPaths(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+
+	mockPlugin := NewMockServicePlugin("mock")
+	if err := mfs.Mount("/mnt", mockPlugin); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if err := mockPlugin.fs.Mkdir("/logs", 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for _, p := range []string{"/logs/a.log", "/logs/b.txt"} {
+		if _, err := mockPlugin.fs.Write(p, []byte("x"), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	var paths []string
+	truncated, err := filesystem.Find(mfs, "/mnt/logs", filesystem.FindOptions{Name: "*.log"}, func(entry filesystem.WalkEntry) error {
+		paths = append(paths, entry.Path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if truncated || len(paths) != 1 || paths[0] != "/mnt/logs/a.log" {
+		t.Errorf("Expected [/mnt/logs/a.log], got %v (truncated=%v)", paths, truncated)
+	}
+
+	// Searching from the root spans mounts and falls back to a walk
+	paths = nil
+	if _, err := filesystem.Find(mfs, "/", filesystem.FindOptions{Name: "*.txt"}, func(entry filesystem.WalkEntry) error {
+		paths = append(paths, entry.Path)
+		return nil
+	}); err != nil {
+		t.Fatalf("Find from root failed: %v", err)
+	}
+	if len(paths) != 1 || paths[0] != "/mnt/logs/b.txt" {
+		t.Errorf("Expected [/mnt/logs/b.txt], got %v", paths)
+	}
+}
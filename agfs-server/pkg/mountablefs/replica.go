@@ -0,0 +1,347 @@
+package mountablefs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReplicasConfigKey is the mount option serving the reads of hot files from
+// replicas of the mount, such as the targets it is shadowed to, spreading
+// the load of files read by many agents at once:
+//
+//	replicas:
+//	  targets: [/data-r1, /data-r2] # Mount paths holding copies of the mount
+//	  hot_reads: 50                 # Reads per second making a file hot (default 50)
+//	  consistency: bounded          # "bounded" (default) or "eventual"
+//	  max_lag: 5s                   # How long replicas trail changes (default 5s)
+//
+// Reads of files that aren't hot, and reads failing on a replica, are
+// served by the mount. Replicas are reported by MountableFS.Replicas.
+const ReplicasConfigKey = "replicas"
+
+// Replica consistency levels
+const (
+	// ConsistencyBounded keeps reads of a file on the mount for max_lag
+	// after it changed through the mount, so that replicas kept up to date
+	// within max_lag never serve an older version than the mount
+	ConsistencyBounded = "bounded"
+	// ConsistencyEventual lets replicas serve hot files whenever, even
+	// right after they changed
+	ConsistencyEventual = "eventual"
+)
+
+// ReplicaOptions configures the replicas of a mount
+type ReplicaOptions struct {
+	Targets     []string      // Mount paths of the replicas
+	HotReads    int           // Reads per second making a file hot
+	Consistency string        // ConsistencyBounded or ConsistencyEventual
+	MaxLag      time.Duration // How long replicas trail changes, when bounded
+}
+
+// parseReplicaOptions reads the replicas mount option
+func parseReplicaOptions(value interface{}) (*ReplicaOptions, error) {
+	config, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a map with targets", ReplicasConfigKey)
+	}
+	if err := pluginconfig.ValidateOnlyKnownKeys(config, []string{"targets", "hot_reads", "consistency", "max_lag"}); err != nil {
+		return nil, fmt.Errorf("%s: %w", ReplicasConfigKey, err)
+	}
+	if err := pluginconfig.ValidateIntType(config, "hot_reads"); err != nil {
+		return nil, fmt.Errorf("%s: %w", ReplicasConfigKey, err)
+	}
+	targets, _ := config["targets"].([]interface{})
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s: targets must be a non-empty list of mount paths", ReplicasConfigKey)
+	}
+	opts := &ReplicaOptions{
+		HotReads:    pluginconfig.GetIntConfig(config, "hot_reads", 50),
+		Consistency: pluginconfig.GetStringConfig(config, "consistency", ConsistencyBounded),
+		MaxLag:      5 * time.Second,
+	}
+	for _, v := range targets {
+		target, _ := v.(string)
+		if !strings.HasPrefix(target, "/") {
+			return nil, fmt.Errorf("%s: target %v must be an absolute mount path", ReplicasConfigKey, v)
+		}
+		opts.Targets = append(opts.Targets, filesystem.NormalizePath(target))
+	}
+	if opts.HotReads < 1 {
+		return nil, fmt.Errorf("%s: hot_reads must be at least 1", ReplicasConfigKey)
+	}
+	switch opts.Consistency {
+	case ConsistencyBounded, ConsistencyEventual:
+	default:
+		return nil, fmt.Errorf("%s: consistency must be %q or %q", ReplicasConfigKey, ConsistencyBounded, ConsistencyEventual)
+	}
+	if v, ok := config["max_lag"]; ok {
+		s, ok := v.(string)
+		d, err := time.ParseDuration(s)
+		if !ok || err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s: max_lag must be a positive duration such as \"5s\"", ReplicasConfigKey)
+		}
+		opts.MaxLag = d
+	}
+	return opts, nil
+}
+
+// checkReplicaTargets rejects replicas overlapping the mount
+func checkReplicaTargets(mountPath string, opts *ReplicaOptions) error {
+	if opts == nil {
+		return nil
+	}
+	for _, target := range opts.Targets {
+		if target == mountPath || isUnder(target, mountPath) || isUnder(mountPath, target) {
+			return fmt.Errorf("%s: target %s overlaps the mount %s", ReplicasConfigKey, target, mountPath)
+		}
+	}
+	return nil
+}
+
+// ReplicaStatus reports the replicas of a mount
+type ReplicaStatus struct {
+	Path        string           `json:"path"`
+	Targets     []string         `json:"targets"`
+	Consistency string           `json:"consistency"`
+	HotReads    int              `json:"hotReads"`
+	Hot         int              `json:"hot"`       // Files hot in the last second
+	Primary     int64            `json:"primary"`   // Hot reads served by the mount
+	Served      map[string]int64 `json:"served"`    // Hot reads served by each replica
+	Fallbacks   int64            `json:"fallbacks"` // Replica reads that failed and went to the mount
+	Lagging     int64            `json:"lagging"`   // Hot reads kept on the mount after a change
+}
+
+// replicaSet spreads the reads of the hot files of a mount over the mount
+// and its replicas in turn. Reads are counted per file and second; counts
+// of the current and previous second are kept, so memory is bounded by
+// the files read in the last two seconds.
+type replicaSet struct {
+	mfs  *MountableFS
+	opts *ReplicaOptions
+	now  func() time.Time
+
+	mu        sync.Mutex
+	second    time.Time      // Start of the current second
+	current   map[string]int // Reads per file in the current second
+	previous  map[string]int // Reads per file in the previous second
+	next      int            // Turn of the mount (0) or a replica (1 on)
+	changed   map[string]time.Time
+	swept     time.Time // Last sweep of changed
+	primary   int64
+	served    map[string]int64
+	fallbacks int64
+	lagging   int64
+}
+
+func newReplicaSet(mfs *MountableFS, opts *ReplicaOptions) *replicaSet {
+	return &replicaSet{
+		mfs:      mfs,
+		opts:     opts,
+		now:      time.Now,
+		current:  make(map[string]int),
+		previous: make(map[string]int),
+		changed:  make(map[string]time.Time),
+		served:   make(map[string]int64),
+	}
+}
+
+// pick counts a read of p and returns the replica to serve it, or "" for
+// the mount
+func (r *replicaSet) pick(p string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	second := now.Truncate(time.Second)
+	if !second.Equal(r.second) {
+		if second.Sub(r.second) == time.Second {
+			r.previous = r.current
+		} else {
+			r.previous = make(map[string]int)
+		}
+		r.current = make(map[string]int)
+		r.second = second
+	}
+	r.current[p]++
+	if r.current[p] < r.opts.HotReads && r.previous[p] < r.opts.HotReads {
+		return ""
+	}
+
+	if r.opts.Consistency == ConsistencyBounded && r.changedWithin(p, now) {
+		r.lagging++
+		return ""
+	}
+	turn := r.next
+	r.next = (r.next + 1) % (len(r.opts.Targets) + 1)
+	if turn == 0 {
+		r.primary++
+		return ""
+	}
+	return r.opts.Targets[turn-1]
+}
+
+// changedWithin reports whether p, or a directory above it, changed less
+// than max lag ago. The caller holds r.mu.
+func (r *replicaSet) changedWithin(p string, now time.Time) bool {
+	for {
+		if t, ok := r.changed[p]; ok && now.Sub(t) < r.opts.MaxLag {
+			return true
+		}
+		if p == "/" {
+			return false
+		}
+		p = path.Dir(p)
+	}
+}
+
+// change records that p changed through the mount. Changes older than max
+// lag are swept as the map is updated.
+func (r *replicaSet) change(p string) {
+	if r.opts.Consistency != ConsistencyBounded {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	r.changed[p] = now
+	if now.Sub(r.swept) < r.opts.MaxLag {
+		return
+	}
+	for k, t := range r.changed {
+		if now.Sub(t) >= r.opts.MaxLag {
+			delete(r.changed, k)
+		}
+	}
+	r.swept = now
+}
+
+// replica returns the file system and path serving p on the replica at
+// target. Its own replicas are bypassed, so that mounts replicating each
+// other never pass reads back and forth.
+func (r *replicaSet) replica(target, p string) (filesystem.FileSystem, string, bool) {
+	mount, relPath, found := r.mfs.findMount(strings.TrimSuffix(target, "/") + p)
+	if !found {
+		return nil, "", false
+	}
+	fs, fsPath := mount.route(relPath)
+	return fs, fsPath, true
+}
+
+// fellBack counts a replica read that failed
+func (r *replicaSet) fellBack(target, p string, err error) {
+	log.Debugf("[replicas] read of %s from %s failed, served by the mount: %v", p, target, err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallbacks++
+}
+
+func (r *replicaSet) servedBy(target string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.served[target]++
+}
+
+// read serves a read of p, the path relative to the mount, from a replica
+// when p is hot, or else with primary
+func (r *replicaSet) read(ctx context.Context, p string, offset, size int64, primary func() ([]byte, error)) ([]byte, error) {
+	target := r.pick(p)
+	if target == "" {
+		return primary()
+	}
+	fs, fsPath, ok := r.replica(target, p)
+	if !ok {
+		r.fellBack(target, p, filesystem.ErrNotFound)
+		return primary()
+	}
+	data, err := readContext(ctx, fs, fsPath, offset, size)
+	if err != nil && err != io.EOF {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		r.fellBack(target, p, err)
+		return primary()
+	}
+	r.servedBy(target)
+	return data, err
+}
+
+// open serves an open of p, the path relative to the mount, like read
+func (r *replicaSet) open(p string, primary func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	target := r.pick(p)
+	if target == "" {
+		return primary()
+	}
+	fs, fsPath, ok := r.replica(target, p)
+	if !ok {
+		r.fellBack(target, p, filesystem.ErrNotFound)
+		return primary()
+	}
+	rc, err := fs.Open(fsPath)
+	if err != nil {
+		r.fellBack(target, p, err)
+		return primary()
+	}
+	r.servedBy(target)
+	return rc, nil
+}
+
+func (r *replicaSet) status() ReplicaStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := ReplicaStatus{
+		Targets:     r.opts.Targets,
+		Consistency: r.opts.Consistency,
+		HotReads:    r.opts.HotReads,
+		Primary:     r.primary,
+		Served:      make(map[string]int64, len(r.opts.Targets)),
+		Fallbacks:   r.fallbacks,
+		Lagging:     r.lagging,
+	}
+	for _, target := range r.opts.Targets {
+		st.Served[target] = r.served[target]
+	}
+	hot := make(map[string]bool)
+	for _, counts := range []map[string]int{r.current, r.previous} {
+		for p, n := range counts {
+			if n >= r.opts.HotReads {
+				hot[p] = true
+			}
+		}
+	}
+	st.Hot = len(hot)
+	return st
+}
+
+// replicaChanged records a change of the resolved path p for the replicas
+// of its mount
+func (mfs *MountableFS) replicaChanged(p string) {
+	if mount, relPath, ok := mfs.findMount(p); ok && mount.replicas != nil {
+		mount.replicas.change(relPath)
+	}
+}
+
+// Replicas returns the state of the replicas of all mounts that have them,
+// ordered by mount path
+func (mfs *MountableFS) Replicas() []ReplicaStatus {
+	var statuses []ReplicaStatus
+	for _, mount := range mfs.GetMounts() {
+		if mount.replicas == nil {
+			continue
+		}
+		s := mount.replicas.status()
+		s.Path = mount.Path
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Path < statuses[j].Path })
+	return statuses
+}
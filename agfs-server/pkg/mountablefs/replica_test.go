@@ -0,0 +1,125 @@
+package mountablefs
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestParseReplicaOptions(t *testing.T) {
+	opts, rest, err := ParseMountOptions(map[string]interface{}{
+		ReplicasConfigKey: map[string]interface{}{"targets": []interface{}{"/r1/", "/r2"}, "max_lag": "2s"},
+	})
+	if err != nil {
+		t.Fatalf("ParseMountOptions() error = %v", err)
+	}
+	if _, ok := rest[ReplicasConfigKey]; ok {
+		t.Errorf("plugin config = %v", rest)
+	}
+	if r := opts.Replicas; r == nil || len(r.Targets) != 2 || r.Targets[0] != "/r1" || r.HotReads != 50 ||
+		r.Consistency != ConsistencyBounded || r.MaxLag != 2*time.Second {
+		t.Errorf("replica options = %+v", opts.Replicas)
+	}
+
+	for _, bad := range []interface{}{
+		[]interface{}{"/r1"},
+		map[string]interface{}{"targets": []interface{}{}},
+		map[string]interface{}{"targets": []interface{}{"r1"}},
+		map[string]interface{}{"targets": []interface{}{"/r1"}, "hot_reads": 0},
+		map[string]interface{}{"targets": []interface{}{"/r1"}, "consistency": "strong"},
+		map[string]interface{}{"targets": []interface{}{"/r1"}, "max_lag": "soon"},
+	} {
+		if _, _, err := ParseMountOptions(map[string]interface{}{ReplicasConfigKey: bad}); err == nil {
+			t.Errorf("ParseMountOptions(%v) succeeded", bad)
+		}
+	}
+
+	if err := checkReplicaTargets("/data", &ReplicaOptions{Targets: []string{"/r1", "/data/copy"}}); err == nil {
+		t.Error("checkReplicaTargets accepted a target inside the mount")
+	}
+}
+
+func TestReplicas(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	for _, path := range []string{"/data", "/r1", "/r2"} {
+		p := memfs.NewMemFSPlugin()
+		if err := p.Initialize(map[string]interface{}{}); err != nil {
+			t.Fatalf("Initialize() error = %v", err)
+		}
+		var opts MountOptions
+		if path == "/data" {
+			opts.Replicas = &ReplicaOptions{Targets: []string{"/r1", "/r2"}, HotReads: 3, Consistency: ConsistencyBounded, MaxLag: time.Minute}
+		}
+		if err := mfs.MountWithOptions(path, p, opts); err != nil {
+			t.Fatalf("MountWithOptions(%s) error = %v", path, err)
+		}
+	}
+	mount, _, _ := mfs.findMount("/data")
+	replicas := mount.replicas
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	replicas.now = func() time.Time { return now }
+
+	// Each copy tells where a read was served from
+	for path, content := range map[string]string{"/data/hot.txt": "primary", "/r1/hot.txt": "replica 1", "/r2/hot.txt": "replica 2"} {
+		if _, err := mfs.Write(path, []byte(content), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func() string {
+		data, err := mfs.Read("/data/hot.txt", 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatalf("Read() error = %v", err)
+		}
+		return string(data)
+	}
+
+	// The write keeps the file on the mount, hot or not, under bounded
+	// consistency
+	for i := 0; i < 5; i++ {
+		if got := read(); got != "primary" {
+			t.Fatalf("read %d right after the write = %q, want primary", i, got)
+		}
+	}
+
+	// Once replicas had time to catch up, hot reads go round the copies
+	now = now.Add(2 * time.Minute)
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, read())
+	}
+	want := []string{"primary", "primary", "primary", "replica 1", "replica 2", "primary"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("reads = %q, want %q", got, want)
+		}
+	}
+
+	// Reads failing on a replica fall back to the mount
+	mfs.Remove("/r1/hot.txt")
+	for i := 0; i < 3; i++ {
+		if got := read(); got == "replica 1" {
+			t.Fatalf("read served by a replica missing the file")
+		}
+	}
+
+	s := mfs.Replicas()
+	if len(s) != 1 || s[0].Path != "/data" || s[0].Hot != 1 || s[0].Served["/r1"] != 1 || s[0].Served["/r2"] != 2 ||
+		s[0].Fallbacks != 1 || s[0].Lagging != 3 {
+		t.Errorf("Replicas() = %+v", s)
+	}
+
+	// Files read rarely stay on the mount
+	now = now.Add(time.Hour)
+	if _, err := mfs.Write("/r1/cold.txt", []byte("replica"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatal(err)
+	}
+	mfs.Write("/data/cold.txt", []byte("primary"), 0, filesystem.WriteFlagCreate)
+	now = now.Add(time.Hour)
+	if data, _ := mfs.Read("/data/cold.txt", 0, -1); string(data) != "primary" {
+		t.Errorf("cold read = %q, want primary", data)
+	}
+}
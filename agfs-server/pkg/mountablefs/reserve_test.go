@@ -0,0 +1,59 @@
+package mountablefs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// reservePlugin is memfs recording the reservations it is sent
+type reservePlugin struct {
+	*memfs.MemFSPlugin
+	reserved map[string]int64
+}
+
+type reserveFS struct {
+	filesystem.FileSystem
+	reserved map[string]int64
+}
+
+func (fs *reserveFS) Reserve(path string, size int64) error {
+	fs.reserved[path] = size
+	return fs.Create(path)
+}
+
+func (p *reservePlugin) GetFileSystem() filesystem.FileSystem {
+	return &reserveFS{FileSystem: p.MemFSPlugin.GetFileSystem(), reserved: p.reserved}
+}
+
+func TestMountReserve(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	reserving := &reservePlugin{MemFSPlugin: memfs.NewMemFSPlugin(), reserved: map[string]int64{}}
+	mountTestPlugin(t, mfs, "/big", reserving)
+	mountTestPlugin(t, mfs, "/plain", memfs.NewMemFSPlugin())
+
+	if err := mfs.Reserve("/big/video.mp4", 1<<30); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if size := reserving.reserved["/video.mp4"]; size != 1<<30 {
+		t.Errorf("plugin reserved %d bytes for /video.mp4, want %d", size, 1<<30)
+	}
+
+	// File systems that can't reserve get a plain create
+	if err := mfs.Reserve("/plain/video.mp4", 1<<30); err != nil {
+		t.Fatalf("Reserve() on a mount without reservations error = %v", err)
+	}
+	if info, err := mfs.Stat("/plain/video.mp4"); err != nil || info.Size != 0 {
+		t.Errorf("Stat() of the placeholder = %+v, %v", info, err)
+	}
+
+	if err := mfs.Reserve("/plain/other.bin", -1); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("Reserve(-1) error = %v, want invalid argument", err)
+	}
+	if err := mfs.Reserve("/video.mp4", 10); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Reserve() outside mounts error = %v, want permission denied", err)
+	}
+}
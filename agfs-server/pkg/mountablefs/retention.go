@@ -0,0 +1,292 @@
+package mountablefs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// Retention on a mount protects paths, and everything below them, from
+// being deleted or overwritten until a time or while under legal hold.
+// Records are kept as JSON in the mount's /.retention file, which can be
+// read but is only changed through SetRetention.
+const (
+	RetentionConfigKey = "retention" // Mount option: enable retention
+	RetentionFile      = "/.retention"
+)
+
+// retentionStore holds the retention records of a mount, keyed by path
+// relative to the mount. They are loaded from the retention file on first
+// use; until they can be, every protected operation is refused.
+type retentionStore struct {
+	fs      filesystem.FileSystem
+	mu      sync.Mutex
+	records map[string]filesystem.Retention // Nil until loaded
+}
+
+func newRetentionStore(fs filesystem.FileSystem) *retentionStore {
+	return &retentionStore{fs: fs}
+}
+
+// isRetentionFile reports whether relPath is the retention file of a mount
+func isRetentionFile(relPath string) bool {
+	return relPath == RetentionFile
+}
+
+// load reads the records from the retention file, once. A missing file
+// means no records. Must be called with mu held.
+func (s *retentionStore) load() error {
+	if s.records != nil {
+		return nil
+	}
+	data, err := s.fs.Read(RetentionFile, 0, -1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		if missing, statErr := isMissing(s.fs, RetentionFile); statErr != nil || !missing {
+			return fmt.Errorf("failed to load retention records: %w", err)
+		}
+	}
+	records := make(map[string]filesystem.Retention)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return fmt.Errorf("invalid retention file: %w", err)
+		}
+	}
+	s.records = records
+	return nil
+}
+
+// save writes the records to the retention file, dropping those that no
+// longer protect anything. Must be called with mu held.
+func (s *retentionStore) save(now time.Time) error {
+	for p, r := range s.records {
+		if !r.Active(now) {
+			delete(s.records, p)
+		}
+	}
+	data, err := json.MarshalIndent(s.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = s.fs.Write(RetentionFile, data, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate)
+	return err
+}
+
+// get returns the record of relPath and the retention in effect for it
+func (s *retentionStore) get(relPath string) (filesystem.Retention, filesystem.Retention, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return filesystem.Retention{}, filesystem.Retention{}, err
+	}
+	return s.records[relPath], s.effective(relPath), nil
+}
+
+// effective merges the records of relPath and of the directories above it.
+// Must be called with mu held.
+func (s *retentionStore) effective(relPath string) filesystem.Retention {
+	var r filesystem.Retention
+	for p := relPath; ; p = path.Dir(p) {
+		r = r.Merge(s.records[p])
+		if p == "/" {
+			return r
+		}
+	}
+}
+
+// set replaces the record of relPath. An active retain-until can only be
+// extended.
+func (s *retentionStore) set(relPath string, r filesystem.Retention, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	old := s.records[relPath]
+	if now.Before(old.RetainUntil) && r.RetainUntil.Before(old.RetainUntil) {
+		return filesystem.NewPermissionDeniedError("retention", relPath, old.Reason()+", which can only be extended")
+	}
+	s.records[relPath] = r
+	if err := s.save(now); err != nil {
+		s.records[relPath] = old
+		return err
+	}
+	return nil
+}
+
+// covered returns the active retention protecting relPath itself
+func (s *retentionStore) covered(relPath string, now time.Time) (filesystem.Retention, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return filesystem.Retention{}, false, err
+	}
+	r := s.effective(relPath)
+	return r, r.Active(now), nil
+}
+
+// checkRemove refuses to delete or move relPath when it, or anything
+// below it, is protected
+func (s *retentionStore) checkRemove(op, fullPath, relPath string) error {
+	now := time.Now()
+	if isRetentionFile(relPath) {
+		return filesystem.NewPermissionDeniedError(op, fullPath, "the retention file is only changed through the retention API")
+	}
+	r, ok, err := s.covered(relPath, now)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return filesystem.NewPermissionDeniedError(op, fullPath, r.Reason())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := strings.TrimSuffix(relPath, "/") + "/"
+	for p, r := range s.records {
+		if strings.HasPrefix(p, prefix) && r.Active(now) {
+			return filesystem.NewPermissionDeniedError(op, fullPath, fmt.Sprintf("%s is %s", p, r.Reason()))
+		}
+	}
+	return nil
+}
+
+// checkOverwrite refuses to change the content of relPath when it is a
+// protected file with content. New files can be created in protected
+// directories, and empty files written once, so that a file created before
+// it is written can still be.
+func (s *retentionStore) checkOverwrite(op, fullPath, relPath string, fs filesystem.FileSystem, fsPath string) error {
+	if isRetentionFile(relPath) {
+		return filesystem.NewPermissionDeniedError(op, fullPath, "the retention file is only changed through the retention API")
+	}
+	r, ok, err := s.covered(relPath, time.Now())
+	if err != nil || !ok {
+		return err
+	}
+	info, err := fs.Stat(fsPath)
+	if err != nil {
+		if missing, statErr := isMissing(fs, fsPath); statErr != nil || !missing {
+			return err
+		}
+		return nil
+	}
+	if !info.IsDir && info.Size == 0 {
+		return nil
+	}
+	return filesystem.NewPermissionDeniedError(op, fullPath, r.Reason())
+}
+
+// isMissing reports whether p, which failed to stat, doesn't exist rather
+// than couldn't be reached. Plugins don't all return ErrNotFound, so its
+// directory is checked instead.
+func isMissing(fs filesystem.FileSystem, p string) (bool, error) {
+	if _, err := fs.Stat(p); err == nil {
+		return false, nil
+	} else if errors.Is(err, filesystem.ErrNotFound) {
+		return true, nil
+	}
+	if _, err := fs.Stat(path.Dir(p)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// retained reports whether relPath is protected, for the TTL sweeper. Paths
+// whose retention can't be read are kept.
+func (s *retentionStore) retained(relPath string) bool {
+	_, ok, err := s.covered(relPath, time.Now())
+	return ok || err != nil
+}
+
+// checkRetentionRemove checks a delete or move of relPath on mounts with
+// retention
+func (mp *MountPoint) checkRetentionRemove(op, fullPath, relPath string) error {
+	if mp.retention == nil || mp.snapshotPath(relPath) {
+		return nil
+	}
+	return mp.retention.checkRemove(op, fullPath, relPath)
+}
+
+// checkRetentionOverwrite checks a change of the content of relPath on
+// mounts with retention
+func (mp *MountPoint) checkRetentionOverwrite(op, fullPath, relPath string) error {
+	if mp.retention == nil || mp.snapshotPath(relPath) {
+		return nil
+	}
+	fs, fsPath := mp.route(relPath)
+	return mp.retention.checkOverwrite(op, fullPath, relPath, fs, fsPath)
+}
+
+// SetRetention implements filesystem.Retainer for mounts with retention.
+// The path must exist. Plugins that can protect it in their storage as well
+// are asked to first.
+func (mfs *MountableFS) SetRetention(p string, r filesystem.Retention) error {
+	resolved, err := mfs.resolvePath(p)
+	if err != nil {
+		return err
+	}
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return filesystem.NewNotFoundError("retention", p)
+	}
+	if mount.retention == nil {
+		return fmt.Errorf("%w: %s is not mounted with %s enabled", filesystem.ErrNotSupported, mount.Path, RetentionConfigKey)
+	}
+	if isRetentionFile(relPath) || mount.snapshotPath(relPath) || inTrash(relPath) {
+		return filesystem.NewInvalidArgumentError("path", p, "cannot hold retention")
+	}
+	fs, fsPath := mount.route(relPath)
+	info, err := fs.Stat(fsPath)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if own, _, err := mount.retention.get(relPath); err != nil {
+		return err
+	} else if now.Before(own.RetainUntil) && r.RetainUntil.Before(own.RetainUntil) {
+		return filesystem.NewPermissionDeniedError("retention", p, own.Reason()+", which can only be extended")
+	}
+	if retainer, ok := filesystem.As[filesystem.Retainer](fs); ok {
+		if err := retainer.SetRetention(fsPath, r); err != nil && !errors.Is(err, filesystem.ErrNotSupported) {
+			return err
+		}
+	}
+	if err := mount.retention.set(relPath, r, now); err != nil {
+		return err
+	}
+	// An expiry would delete the file regardless of its retention
+	if !info.IsDir && !info.ExpiresAt.IsZero() && r.Active(now) {
+		if expirer, ok := filesystem.As[filesystem.Expirer](fs); ok {
+			if err := expirer.SetExpiry(fsPath, time.Time{}); err != nil {
+				log.Warnf("[retention] Failed to clear the expiry of %s: %v", resolved, err)
+			}
+		}
+	}
+	return nil
+}
+
+// GetRetention implements filesystem.RetentionReporter. Mounts without
+// retention report none.
+func (mfs *MountableFS) GetRetention(p string) (filesystem.Retention, filesystem.Retention, error) {
+	resolved, err := mfs.resolvePath(p)
+	if err != nil {
+		return filesystem.Retention{}, filesystem.Retention{}, err
+	}
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return filesystem.Retention{}, filesystem.Retention{}, filesystem.NewNotFoundError("retention", p)
+	}
+	if mount.retention == nil {
+		return filesystem.Retention{}, filesystem.Retention{}, nil
+	}
+	return mount.retention.get(relPath)
+}
@@ -0,0 +1,186 @@
+package mountablefs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// newRetentionTestFS mounts memfs at /data with retention, and at /plain
+// without
+func newRetentionTestFS(t *testing.T) *MountableFS {
+	t.Helper()
+	mfs := NewMountableFS(api.PoolConfig{})
+	for path, opts := range map[string]MountOptions{"/data": {Retention: true}, "/plain": {}} {
+		p := memfs.NewMemFSPlugin()
+		if err := p.Initialize(map[string]interface{}{}); err != nil {
+			t.Fatalf("Initialize() error = %v", err)
+		}
+		if err := mfs.MountWithOptions(path, p, opts); err != nil {
+			t.Fatalf("MountWithOptions(%s) error = %v", path, err)
+		}
+		t.Cleanup(func() { mfs.Unmount(path) })
+	}
+	return mfs
+}
+
+func requireDenied(t *testing.T, what string, err error) {
+	t.Helper()
+	if !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Fatalf("%s error = %v, want permission denied", what, err)
+	}
+}
+
+func TestRetentionProtectsFiles(t *testing.T) {
+	mfs := newRetentionTestFS(t)
+	writeTrashTestFile(t, mfs, "/data/report.txt", "q3")
+	writeTrashTestFile(t, mfs, "/data/notes.txt", "draft")
+
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := mfs.SetRetention("/data/report.txt", filesystem.Retention{RetainUntil: until}); err != nil {
+		t.Fatalf("SetRetention() error = %v", err)
+	}
+
+	requireDenied(t, "Remove", mfs.Remove("/data/report.txt"))
+	requireDenied(t, "RemoveAll(/data)", mfs.RemoveAll("/data"))
+	requireDenied(t, "Rename", mfs.Rename("/data/report.txt", "/data/old.txt"))
+	requireDenied(t, "Rename over it", mfs.Rename("/data/notes.txt", "/data/report.txt"))
+	_, err := mfs.Write("/data/report.txt", []byte("q4"), -1, filesystem.WriteFlagTruncate)
+	requireDenied(t, "Write", err)
+	requireDenied(t, "Truncate", mfs.Truncate("/data/report.txt", 0))
+	requireDenied(t, "Touch", mfs.Touch("/data/report.txt"))
+	_, err = mfs.OpenWrite("/data/report.txt")
+	requireDenied(t, "OpenWrite", err)
+	requireDenied(t, "SetExpiry", mfs.SetExpiry("/data/report.txt", time.Now().Add(time.Minute)))
+	if data, err := mfs.Read("/data/report.txt", 0, -1); (err != nil && err != io.EOF) || string(data) != "q3" {
+		t.Fatalf("Read() = %q, %v", data, err)
+	}
+
+	// Other files are unaffected
+	writeTrashTestFile(t, mfs, "/data/notes.txt", "final")
+	if err := mfs.Remove("/data/notes.txt"); err != nil {
+		t.Fatalf("Remove(notes.txt) error = %v", err)
+	}
+
+	// Retention can be extended but not shortened
+	if err := mfs.SetRetention("/data/report.txt", filesystem.Retention{RetainUntil: until.Add(-time.Minute)}); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Fatalf("shortening SetRetention() error = %v", err)
+	}
+	if err := mfs.SetRetention("/data/report.txt", filesystem.Retention{RetainUntil: until.Add(time.Hour)}); err != nil {
+		t.Fatalf("extending SetRetention() error = %v", err)
+	}
+
+	// The records are kept in the retention file, which can't be changed
+	data, err := mfs.Read("/data"+RetentionFile, 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read(.retention) error = %v", err)
+	}
+	var records map[string]filesystem.Retention
+	if err := json.Unmarshal(data, &records); err != nil || !records["/report.txt"].RetainUntil.Equal(until.Add(time.Hour)) {
+		t.Fatalf(".retention = %s, %v", data, err)
+	}
+	_, err = mfs.Write("/data"+RetentionFile, []byte("{}"), -1, filesystem.WriteFlagTruncate)
+	requireDenied(t, "Write(.retention)", err)
+	requireDenied(t, "Remove(.retention)", mfs.Remove("/data"+RetentionFile))
+
+	// Mounts without retention don't take any
+	writeTrashTestFile(t, mfs, "/plain/a.txt", "a")
+	if err := mfs.SetRetention("/plain/a.txt", filesystem.Retention{LegalHold: true}); !errors.Is(err, filesystem.ErrNotSupported) {
+		t.Fatalf("SetRetention(/plain) error = %v, want not supported", err)
+	}
+	if err := mfs.SetRetention("/data/missing.txt", filesystem.Retention{LegalHold: true}); err == nil {
+		t.Fatal("SetRetention() of a missing file should fail")
+	}
+}
+
+func TestLegalHoldOnDirectory(t *testing.T) {
+	mfs := newRetentionTestFS(t)
+	if err := mfs.Mkdir("/data/case", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	writeTrashTestFile(t, mfs, "/data/case/evidence.txt", "exhibit a")
+	if err := mfs.SetRetention("/data/case", filesystem.Retention{LegalHold: true}); err != nil {
+		t.Fatalf("SetRetention() error = %v", err)
+	}
+
+	own, effective, err := mfs.GetRetention("/data/case/evidence.txt")
+	if err != nil || own.LegalHold || !effective.LegalHold {
+		t.Fatalf("GetRetention() = %+v, %+v, %v", own, effective, err)
+	}
+	requireDenied(t, "Remove", mfs.Remove("/data/case/evidence.txt"))
+	requireDenied(t, "RemoveAll", mfs.RemoveAll("/data/case"))
+	_, err = mfs.Write("/data/case/evidence.txt", []byte("tampered"), -1, filesystem.WriteFlagTruncate)
+	requireDenied(t, "Write", err)
+
+	// New files can still be added, and written once
+	if err := mfs.Create("/data/case/new.txt"); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	writeTrashTestFile(t, mfs, "/data/case/new.txt", "exhibit b")
+	_, err = mfs.Write("/data/case/new.txt", []byte("again"), -1, filesystem.WriteFlagTruncate)
+	requireDenied(t, "second Write", err)
+
+	// Releasing the hold allows deletes again
+	if err := mfs.SetRetention("/data/case", filesystem.Retention{}); err != nil {
+		t.Fatalf("SetRetention(release) error = %v", err)
+	}
+	if err := mfs.RemoveAll("/data/case"); err != nil {
+		t.Fatalf("RemoveAll() after release error = %v", err)
+	}
+}
+
+// retainerPlugin is memfs recording the retentions it is asked to set
+type retainerPlugin struct {
+	*memfs.MemFSPlugin
+	retained []string
+}
+
+type retainerFS struct {
+	filesystem.FileSystem
+	p *retainerPlugin
+}
+
+func (fs *retainerFS) SetRetention(path string, r filesystem.Retention) error {
+	fs.p.retained = append(fs.p.retained, path)
+	return nil
+}
+
+func (p *retainerPlugin) GetFileSystem() filesystem.FileSystem {
+	return &retainerFS{FileSystem: p.MemFSPlugin.GetFileSystem(), p: p}
+}
+
+func TestRetentionOnReadOnlyMount(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	p := &retainerPlugin{MemFSPlugin: memfs.NewMemFSPlugin()}
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	if _, err := p.GetFileSystem().Write("/a.txt", []byte("hello"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	opts, _, err := ParseMountOptions(map[string]interface{}{
+		RetentionConfigKey:  true,
+		MiddlewareConfigKey: []interface{}{"readonly"},
+	})
+	if err != nil {
+		t.Fatalf("ParseMountOptions() error = %v", err)
+	}
+	if err := mfs.MountWithOptions("/data", p, opts); err != nil {
+		t.Fatalf("MountWithOptions() error = %v", err)
+	}
+	t.Cleanup(func() { mfs.Unmount("/data") })
+
+	requireDenied(t, "SetRetention", mfs.SetRetention("/data/a.txt", filesystem.Retention{LegalHold: true}))
+	if len(p.retained) != 0 {
+		t.Errorf("retention set on %v through a read-only mount", p.retained)
+	}
+	if own, _, err := mfs.GetRetention("/data/a.txt"); err != nil || own.LegalHold {
+		t.Errorf("GetRetention() = %+v, %v, want none", own, err)
+	}
+}
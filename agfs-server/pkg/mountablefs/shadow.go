@@ -0,0 +1,617 @@
+package mountablefs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// ShadowConfigKey is the mount option mirroring a mount to another one, to
+// migrate it to a new backend without downtime. The mount keeps serving
+// every request; its changes are replayed on the target mount, and a
+// sample of its reads are repeated there and compared:
+//
+//	shadow:
+//	  target: /s3-new       # Mount path of the new backend
+//	  mode: both            # "writes", "reads" or "both" (default)
+//	  sample: 0.1           # Fraction of reads compared (default 1)
+//	  queue_size: 1000      # Operations waiting to be mirrored (default 1000)
+//
+// Differences are logged and reported by MountableFS.Shadows.
+const ShadowConfigKey = "shadow"
+
+// Shadow modes
+const (
+	ShadowWrites = "writes" // Replay changes on the target
+	ShadowReads  = "reads"  // Compare reads with the target
+	ShadowBoth   = "both"
+)
+
+// shadowRecentDivergences is how many divergences a shadow keeps for its
+// status
+const shadowRecentDivergences = 50
+
+// ShadowOptions configures the shadowing of a mount
+type ShadowOptions struct {
+	Target    string  // Mount path of the new backend
+	Mode      string  // ShadowWrites, ShadowReads or ShadowBoth
+	Sample    float64 // Fraction of reads compared
+	QueueSize int     // Operations waiting to be mirrored
+}
+
+// parseShadowOptions reads the shadow mount option
+func parseShadowOptions(value interface{}) (*ShadowOptions, error) {
+	config, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a map with a target", ShadowConfigKey)
+	}
+	if err := pluginconfig.ValidateOnlyKnownKeys(config, []string{"target", "mode", "sample", "queue_size"}); err != nil {
+		return nil, fmt.Errorf("%s: %w", ShadowConfigKey, err)
+	}
+	if err := pluginconfig.ValidateIntType(config, "queue_size"); err != nil {
+		return nil, fmt.Errorf("%s: %w", ShadowConfigKey, err)
+	}
+	target, err := pluginconfig.RequireString(config, "target")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ShadowConfigKey, err)
+	}
+	if !strings.HasPrefix(target, "/") {
+		return nil, fmt.Errorf("%s: target must be an absolute mount path", ShadowConfigKey)
+	}
+	opts := &ShadowOptions{
+		Target:    filesystem.NormalizePath(target),
+		Mode:      pluginconfig.GetStringConfig(config, "mode", ShadowBoth),
+		Sample:    pluginconfig.GetFloat64Config(config, "sample", 1),
+		QueueSize: pluginconfig.GetIntConfig(config, "queue_size", 1000),
+	}
+	switch opts.Mode {
+	case ShadowWrites, ShadowReads, ShadowBoth:
+	default:
+		return nil, fmt.Errorf("%s: mode must be %q, %q or %q", ShadowConfigKey, ShadowWrites, ShadowReads, ShadowBoth)
+	}
+	if opts.Sample < 0 || opts.Sample > 1 {
+		return nil, fmt.Errorf("%s: sample must be between 0 and 1", ShadowConfigKey)
+	}
+	if opts.QueueSize < 1 {
+		return nil, fmt.Errorf("%s: queue_size must be at least 1", ShadowConfigKey)
+	}
+	return opts, nil
+}
+
+// checkShadowTarget rejects targets that would mirror a mount onto itself
+func checkShadowTarget(mountPath string, opts *ShadowOptions) error {
+	if opts == nil {
+		return nil
+	}
+	if opts.Target == mountPath || isUnder(opts.Target, mountPath) || isUnder(mountPath, opts.Target) {
+		return fmt.Errorf("%s: target %s overlaps the mount %s", ShadowConfigKey, opts.Target, mountPath)
+	}
+	return nil
+}
+
+// isUnder reports whether p is strictly below dir
+func isUnder(p, dir string) bool {
+	return dir == "/" || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// ShadowDivergence is a difference found between a mount and its shadow
+type ShadowDivergence struct {
+	Time   time.Time `json:"time"`
+	Op     string    `json:"op"`
+	Path   string    `json:"path"` // Relative to the mounts
+	Detail string    `json:"detail"`
+}
+
+// ShadowStatus reports the shadowing of a mount
+type ShadowStatus struct {
+	Path        string             `json:"path"`
+	Target      string             `json:"target"`
+	Mode        string             `json:"mode"`
+	Mirrored    int64              `json:"mirrored"`    // Changes replayed on the target
+	Compared    int64              `json:"compared"`    // Reads compared with the target
+	Divergences int64              `json:"divergences"` // Failed replays and differing reads
+	Dropped     int64              `json:"dropped"`     // Operations skipped because the queue was full
+	Pending     int                `json:"pending"`
+	Recent      []ShadowDivergence `json:"recent,omitempty"` // Most recent first
+}
+
+// shadowOp is an operation waiting to be mirrored or compared
+type shadowOp struct {
+	name string
+	path string
+	run  func() error // Replays the operation on the target; nil for comparisons
+	// compare repeats a read on the target and describes how its result
+	// differs, or returns "" if it doesn't
+	compare func() (string, error)
+}
+
+// shadowFS serves a mount from its file system and mirrors it to the
+// target mount. Operations are mirrored in order by a single worker, after
+// they succeeded on the mount, so that the target sees the same sequence
+// of changes.
+type shadowFS struct {
+	filesystem.FileSystem
+	mfs  *MountableFS
+	opts *ShadowOptions
+	now  func() time.Time
+
+	queue chan shadowOp
+	stop  chan struct{}
+	done  chan struct{}
+
+	mu          sync.Mutex
+	rng         *rand.Rand
+	mirrored    int64
+	compared    int64
+	divergences int64
+	dropped     int64
+	recent      []ShadowDivergence
+}
+
+func newShadowFS(mfs *MountableFS, fs filesystem.FileSystem, opts *ShadowOptions) *shadowFS {
+	s := &shadowFS{
+		FileSystem: fs,
+		mfs:        mfs,
+		opts:       opts,
+		now:        time.Now,
+		queue:      make(chan shadowOp, opts.QueueSize),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		rng:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	go s.run()
+	return s
+}
+
+func (s *shadowFS) Unwrap() filesystem.FileSystem { return s.FileSystem }
+
+// close stops mirroring once the queued operations are done
+func (s *shadowFS) close() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *shadowFS) run() {
+	defer close(s.done)
+	for {
+		select {
+		case op := <-s.queue:
+			s.apply(op)
+		case <-s.stop:
+			for {
+				select {
+				case op := <-s.queue:
+					s.apply(op)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *shadowFS) apply(op shadowOp) {
+	if op.run != nil {
+		err := op.run()
+		s.mu.Lock()
+		s.mirrored++
+		s.mu.Unlock()
+		if err != nil {
+			s.diverge(op.name, op.path, "replay failed: "+err.Error())
+		}
+		return
+	}
+
+	diff, err := op.compare()
+	s.mu.Lock()
+	s.compared++
+	s.mu.Unlock()
+	if err != nil {
+		diff = "target failed: " + err.Error()
+	}
+	if diff != "" {
+		s.diverge(op.name, op.path, diff)
+	}
+}
+
+func (s *shadowFS) diverge(op, path, detail string) {
+	log.Warnf("[shadow] %s %s diverges on %s: %s", op, path, s.opts.Target, detail)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.divergences++
+	s.recent = append(s.recent, ShadowDivergence{Time: s.now(), Op: op, Path: path, Detail: detail})
+	if len(s.recent) > shadowRecentDivergences {
+		s.recent = s.recent[len(s.recent)-shadowRecentDivergences:]
+	}
+}
+
+// enqueue hands an operation to the worker, dropping it if the queue is
+// full rather than slowing down the mount
+func (s *shadowFS) enqueue(op shadowOp) {
+	select {
+	case s.queue <- op:
+	default:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+		log.Warnf("[shadow] queue to %s full, dropped %s %s", s.opts.Target, op.name, op.path)
+	}
+}
+
+// target returns the path of p on the target mount
+func (s *shadowFS) target(p string) string {
+	if p == "/" {
+		return s.opts.Target
+	}
+	return strings.TrimSuffix(s.opts.Target, "/") + p
+}
+
+func (s *shadowFS) mirrorWrites() bool { return s.opts.Mode != ShadowReads }
+
+// mirror queues the replay of a change that succeeded on the mount
+func (s *shadowFS) mirror(name, path string, err error, run func() error) {
+	if err == nil && s.mirrorWrites() {
+		s.enqueue(shadowOp{name: name, path: path, run: run})
+	}
+}
+
+// sampled decides whether a successful read is compared with the target
+func (s *shadowFS) sampled(err error) bool {
+	if (err != nil && err != io.EOF) || s.opts.Mode == ShadowWrites || s.opts.Sample == 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Float64() < s.opts.Sample
+}
+
+// copyFile replays a change the worker can't repeat, such as writes
+// through a stream or a handle, by copying the file as it is now
+func (s *shadowFS) copyFile(path string) func() error {
+	return func() error {
+		data, err := s.FileSystem.Read(path, 0, -1)
+		if err != nil && err != io.EOF {
+			if isNotExist(err) {
+				return nil // Removed since; the removal is queued too
+			}
+			return err
+		}
+		_, err = s.mfs.Write(s.target(path), data, 0, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate)
+		return err
+	}
+}
+
+// isNotExist reports whether err says a path doesn't exist
+func isNotExist(err error) bool {
+	return errors.Is(err, filesystem.ErrNotFound) || errors.Is(err, os.ErrNotExist) ||
+		strings.Contains(strings.ToLower(err.Error()), "no such file") ||
+		strings.Contains(strings.ToLower(err.Error()), "not found")
+}
+
+func (s *shadowFS) status() ShadowStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := ShadowStatus{
+		Target:      s.opts.Target,
+		Mode:        s.opts.Mode,
+		Mirrored:    s.mirrored,
+		Compared:    s.compared,
+		Divergences: s.divergences,
+		Dropped:     s.dropped,
+		Pending:     len(s.queue),
+	}
+	for i := len(s.recent) - 1; i >= 0; i-- {
+		st.Recent = append(st.Recent, s.recent[i])
+	}
+	return st
+}
+
+// Changes
+
+func (s *shadowFS) Create(path string) error {
+	err := s.FileSystem.Create(path)
+	s.mirror("create", path, err, func() error { return s.mfs.Create(s.target(path)) })
+	return err
+}
+
+func (s *shadowFS) Mkdir(path string, perm uint32) error {
+	err := s.FileSystem.Mkdir(path, perm)
+	s.mirror("mkdir", path, err, func() error { return s.mfs.Mkdir(s.target(path), perm) })
+	return err
+}
+
+func (s *shadowFS) Remove(path string) error {
+	err := s.FileSystem.Remove(path)
+	s.mirror("remove", path, err, func() error { return s.mfs.Remove(s.target(path)) })
+	return err
+}
+
+func (s *shadowFS) RemoveAll(path string) error {
+	err := s.FileSystem.RemoveAll(path)
+	s.mirror("removeall", path, err, func() error { return s.mfs.RemoveAll(s.target(path)) })
+	return err
+}
+
+func (s *shadowFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	n, err := s.FileSystem.Write(path, data, offset, flags)
+	if err == nil && s.mirrorWrites() {
+		data := bytes.Clone(data) // The caller may reuse its buffer
+		s.mirror("write", path, nil, func() error {
+			_, err := s.mfs.Write(s.target(path), data, offset, flags)
+			return err
+		})
+	}
+	return n, err
+}
+
+func (s *shadowFS) Rename(oldPath, newPath string) error {
+	err := s.FileSystem.Rename(oldPath, newPath)
+	s.mirror("rename", oldPath, err, func() error { return s.mfs.Rename(s.target(oldPath), s.target(newPath)) })
+	return err
+}
+
+func (s *shadowFS) Chmod(path string, mode uint32) error {
+	err := s.FileSystem.Chmod(path, mode)
+	s.mirror("chmod", path, err, func() error { return s.mfs.Chmod(s.target(path), mode) })
+	return err
+}
+
+func (s *shadowFS) OpenWrite(path string) (io.WriteCloser, error) {
+	w, err := s.FileSystem.OpenWrite(path)
+	if err != nil {
+		return nil, err
+	}
+	return &shadowWriter{WriteCloser: w, s: s, path: path}, nil
+}
+
+// shadowWriter mirrors its file once written
+type shadowWriter struct {
+	io.WriteCloser
+	s    *shadowFS
+	path string
+}
+
+func (w *shadowWriter) Close() error {
+	err := w.WriteCloser.Close()
+	w.s.mirror("write", w.path, err, w.s.copyFile(w.path))
+	return err
+}
+
+// The write-capable optional interfaces are implemented too, or callers
+// would find them on the wrapped file system and bypass the mirroring
+
+func (s *shadowFS) Truncate(path string, size int64) error {
+	t, ok := filesystem.As[filesystem.Truncater](s.FileSystem)
+	if !ok {
+		return filesystem.NewNotSupportedError("truncate", path)
+	}
+	err := t.Truncate(path, size)
+	s.mirror("truncate", path, err, s.copyFile(path))
+	return err
+}
+
+func (s *shadowFS) Reserve(path string, size int64) error {
+	r, ok := filesystem.As[filesystem.Reserver](s.FileSystem)
+	if !ok {
+		return s.Create(path)
+	}
+	err := r.Reserve(path, size)
+	s.mirror("create", path, err, func() error { return s.mfs.Reserve(s.target(path), size) })
+	return err
+}
+
+func (s *shadowFS) BeginUpload(path string, partSize int64) (filesystem.Upload, error) {
+	return beginHookedUpload(s, s.FileSystem, path, partSize, func(err error) {
+		s.mirror("write", path, err, s.copyFile(path))
+	})
+}
+
+func (s *shadowFS) WriteAt(path string, data []byte, offset int64) (int64, error) {
+	rw, ok := filesystem.As[filesystem.RandomWriter](s.FileSystem)
+	if !ok {
+		return 0, filesystem.NewNotSupportedError("writeat", path)
+	}
+	n, err := rw.WriteAt(path, data, offset)
+	s.mirror("write", path, err, s.copyFile(path))
+	return n, err
+}
+
+func (s *shadowFS) Increment(path string, delta int64) (int64, error) {
+	inc, ok := filesystem.As[filesystem.Incrementer](s.FileSystem)
+	if !ok {
+		return 0, filesystem.NewNotSupportedError("increment", path)
+	}
+	v, err := inc.Increment(path, delta)
+	s.mirror("increment", path, err, s.copyFile(path))
+	return v, err
+}
+
+func (s *shadowFS) Append(path string, data []byte) (int64, error) {
+	appender, ok := filesystem.As[filesystem.Appender](s.FileSystem)
+	if !ok {
+		// Left to filesystem.AppendLog, whose write is mirrored
+		return 0, filesystem.ErrNotSupported
+	}
+	n, err := appender.Append(path, data)
+	s.mirror("append", path, err, s.copyFile(path))
+	return n, err
+}
+
+func (s *shadowFS) OpenHandle(path string, flags filesystem.OpenFlag, mode uint32) (filesystem.FileHandle, error) {
+	handleFS, ok := filesystem.As[filesystem.HandleFS](s.FileSystem)
+	if !ok {
+		return nil, filesystem.NewNotSupportedError("openhandle", path)
+	}
+	h, err := handleFS.OpenHandle(path, flags, mode)
+	if err != nil {
+		return nil, err
+	}
+	return s.wrapHandle(h), nil
+}
+
+func (s *shadowFS) GetHandle(id int64) (filesystem.FileHandle, error) {
+	handleFS, ok := filesystem.As[filesystem.HandleFS](s.FileSystem)
+	if !ok {
+		return nil, filesystem.ErrNotFound
+	}
+	h, err := handleFS.GetHandle(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.wrapHandle(h), nil
+}
+
+func (s *shadowFS) CloseHandle(id int64) error {
+	handleFS, ok := filesystem.As[filesystem.HandleFS](s.FileSystem)
+	if !ok {
+		return filesystem.ErrNotFound
+	}
+	h, _ := handleFS.GetHandle(id)
+	err := handleFS.CloseHandle(id)
+	if h != nil && writable(h.Flags()) {
+		s.mirror("write", h.Path(), err, s.copyFile(h.Path()))
+	}
+	return err
+}
+
+func writable(flags filesystem.OpenFlag) bool {
+	return flags&(filesystem.O_WRONLY|filesystem.O_RDWR|filesystem.O_APPEND|filesystem.O_CREATE|filesystem.O_TRUNC) != 0
+}
+
+// wrapHandle makes closing a writable handle mirror its file
+func (s *shadowFS) wrapHandle(h filesystem.FileHandle) filesystem.FileHandle {
+	if !writable(h.Flags()) {
+		return h
+	}
+	return &shadowHandle{FileHandle: h, s: s}
+}
+
+type shadowHandle struct {
+	filesystem.FileHandle
+	s *shadowFS
+}
+
+func (h *shadowHandle) Close() error {
+	err := h.FileHandle.Close()
+	h.s.mirror("write", h.Path(), err, h.s.copyFile(h.Path()))
+	return err
+}
+
+// Reads
+
+func (s *shadowFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	data, err := s.FileSystem.Read(path, offset, size)
+	if s.sampled(err) {
+		sum := sha256.Sum256(data)
+		n := len(data)
+		s.enqueue(shadowOp{name: "read", path: path, compare: func() (string, error) {
+			other, err := s.mfs.Read(s.target(path), offset, size)
+			if err != nil && err != io.EOF {
+				return "", err
+			}
+			if len(other) != n {
+				return fmt.Sprintf("read %d bytes at %d, target has %d", n, offset, len(other)), nil
+			}
+			if sha256.Sum256(other) != sum {
+				return fmt.Sprintf("content of %d bytes at %d differs", n, offset), nil
+			}
+			return "", nil
+		}})
+	}
+	return data, err
+}
+
+func (s *shadowFS) Stat(path string) (*filesystem.FileInfo, error) {
+	info, err := s.FileSystem.Stat(path)
+	if s.sampled(err) {
+		isDir, size := info.IsDir, info.Size
+		s.enqueue(shadowOp{name: "stat", path: path, compare: func() (string, error) {
+			other, err := s.mfs.Stat(s.target(path))
+			if err != nil {
+				return "", err
+			}
+			switch {
+			case other.IsDir != isDir:
+				return fmt.Sprintf("is a directory: %v, on target: %v", isDir, other.IsDir), nil
+			case !isDir && other.Size != size:
+				return fmt.Sprintf("size %d, on target %d", size, other.Size), nil
+			}
+			return "", nil
+		}})
+	}
+	return info, err
+}
+
+func (s *shadowFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	infos, err := s.FileSystem.ReadDir(path)
+	if s.sampled(err) {
+		names := make(map[string]bool, len(infos))
+		for _, info := range infos {
+			names[info.Name] = true
+		}
+		s.enqueue(shadowOp{name: "readdir", path: path, compare: func() (string, error) {
+			other, err := s.mfs.ReadDir(s.target(path))
+			if err != nil {
+				return "", err
+			}
+			var missing, extra []string
+			seen := make(map[string]bool, len(other))
+			for _, info := range other {
+				seen[info.Name] = true
+				if !names[info.Name] {
+					extra = append(extra, info.Name)
+				}
+			}
+			for name := range names {
+				if !seen[name] {
+					missing = append(missing, name)
+				}
+			}
+			if len(missing) == 0 && len(extra) == 0 {
+				return "", nil
+			}
+			return fmt.Sprintf("missing on target: %s; only on target: %s", listNames(missing), listNames(extra)), nil
+		}})
+	}
+	return infos, err
+}
+
+// listNames abbreviates a list of entry names for a divergence
+func listNames(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	sort.Strings(names)
+	if len(names) > 5 {
+		return fmt.Sprintf("%s and %d more", strings.Join(names[:5], ", "), len(names)-5)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Shadows returns the state of the shadowing of all mounts that have it,
+// ordered by mount path
+func (mfs *MountableFS) Shadows() []ShadowStatus {
+	var statuses []ShadowStatus
+	for _, mount := range mfs.GetMounts() {
+		if mount.shadow == nil {
+			continue
+		}
+		s := mount.shadow.status()
+		s.Path = mount.Path
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Path < statuses[j].Path })
+	return statuses
+}
@@ -0,0 +1,141 @@
+package mountablefs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestParseShadowOptions(t *testing.T) {
+	opts, rest, err := ParseMountOptions(map[string]interface{}{
+		ShadowConfigKey: map[string]interface{}{"target": "/new/", "sample": 0.5},
+	})
+	if err != nil {
+		t.Fatalf("ParseMountOptions() error = %v", err)
+	}
+	if _, ok := rest[ShadowConfigKey]; ok {
+		t.Errorf("plugin config = %v", rest)
+	}
+	if s := opts.Shadow; s == nil || s.Target != "/new" || s.Mode != ShadowBoth || s.Sample != 0.5 || s.QueueSize != 1000 {
+		t.Errorf("shadow options = %+v", opts.Shadow)
+	}
+
+	for _, bad := range []interface{}{
+		"/new",
+		map[string]interface{}{},
+		map[string]interface{}{"target": "new"},
+		map[string]interface{}{"target": "/new", "mode": "all"},
+		map[string]interface{}{"target": "/new", "sample": 2},
+		map[string]interface{}{"target": "/new", "queue_size": 0},
+		map[string]interface{}{"target": "/new", "verify": true},
+	} {
+		if _, _, err := ParseMountOptions(map[string]interface{}{ShadowConfigKey: bad}); err == nil {
+			t.Errorf("ParseMountOptions(%v) succeeded", bad)
+		}
+	}
+
+	for _, target := range []string{"/old", "/old/sub", "/"} {
+		if err := checkShadowTarget("/old", &ShadowOptions{Target: target}); err == nil {
+			t.Errorf("checkShadowTarget(/old, %s) succeeded", target)
+		}
+	}
+}
+
+func TestShadow(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	for _, path := range []string{"/old", "/new"} {
+		p := memfs.NewMemFSPlugin()
+		if err := p.Initialize(map[string]interface{}{}); err != nil {
+			t.Fatalf("Initialize() error = %v", err)
+		}
+		var opts MountOptions
+		if path == "/old" {
+			opts.Shadow = &ShadowOptions{Target: "/new", Mode: ShadowBoth, Sample: 1, QueueSize: 100}
+		}
+		if err := mfs.MountWithOptions(path, p, opts); err != nil {
+			t.Fatalf("MountWithOptions(%s) error = %v", path, err)
+		}
+	}
+	mount, _, _ := mfs.findMount("/old")
+	shadow := mount.shadow
+	// wait returns once the operations queued so far are mirrored
+	wait := func() {
+		done := make(chan struct{})
+		shadow.queue <- shadowOp{name: "wait", path: "/", run: func() error { close(done); return nil }}
+		<-done
+	}
+
+	// Changes are replayed on the target
+	if err := mfs.Mkdir("/old/docs", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Write("/old/docs/a.txt", []byte("hello"), 0, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Write("/old/docs/b.txt", []byte("draft"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Rename("/old/docs/b.txt", "/old/docs/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := mfs.OpenWrite("/old/docs/d.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("streamed"))
+	w.Close()
+	upload, err := mfs.BeginUpload("/old/docs/e.txt", filesystem.MinUploadPartSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.WritePart(0, []byte("uploaded"))
+	if err := upload.Commit(8); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+
+	for path, want := range map[string]string{"/new/docs/a.txt": "hello", "/new/docs/c.txt": "draft", "/new/docs/d.txt": "streamed", "/new/docs/e.txt": "uploaded"} {
+		if data, err := mfs.Read(path, 0, -1); string(data) != want {
+			t.Errorf("Read(%s) = %q, %v, want %q", path, data, err, want)
+		}
+	}
+	if _, err := mfs.Stat("/new/docs/b.txt"); err == nil {
+		t.Error("renamed file still on the target")
+	}
+
+	// Reads agree until the target is changed behind the mount's back
+	mfs.Read("/old/docs/a.txt", 0, -1)
+	mfs.ReadDir("/old/docs")
+	wait()
+	if s := mfs.Shadows(); len(s) != 1 || s[0].Path != "/old" || s[0].Divergences != 0 || s[0].Compared != 2 {
+		t.Fatalf("Shadows() = %+v", s)
+	}
+
+	mfs.Write("/new/docs/a.txt", []byte("HELLO"), 0, filesystem.WriteFlagTruncate)
+	mfs.Remove("/new/docs/c.txt")
+	mfs.Read("/old/docs/a.txt", 0, -1)
+	mfs.ReadDir("/old/docs")
+	wait()
+	s := mfs.Shadows()[0]
+	if s.Divergences != 2 || len(s.Recent) != 2 {
+		t.Fatalf("Shadows() = %+v", s)
+	}
+	if d := s.Recent[0]; d.Op != "readdir" || !strings.Contains(d.Detail, "missing on target: c.txt") {
+		t.Errorf("latest divergence = %+v", d)
+	}
+	if d := s.Recent[1]; d.Op != "read" || d.Path != "/docs/a.txt" || !strings.Contains(d.Detail, "differs") {
+		t.Errorf("first divergence = %+v", d)
+	}
+
+	// A failed replay is a divergence too
+	mfs.Unmount("/new")
+	mfs.Remove("/old/docs/a.txt")
+	wait()
+	if s := mfs.Shadows()[0]; s.Divergences != 3 || !strings.Contains(s.Recent[0].Detail, "replay failed") {
+		t.Errorf("Shadows() after unmounting the target = %+v", s)
+	}
+	mfs.Unmount("/old")
+}
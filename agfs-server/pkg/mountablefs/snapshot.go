@@ -0,0 +1,270 @@
+package mountablefs
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// SnapshotDir is where a mount's snapshots appear, relative to the mount
+const SnapshotDir = "/" + filesystem.SnapshotsDir
+
+// inSnapshots reports whether relPath is the snapshot directory or inside it
+func inSnapshots(relPath string) bool {
+	return relPath == SnapshotDir || strings.HasPrefix(relPath, SnapshotDir+"/")
+}
+
+// snapshotPath reports whether relPath is served from the mount's snapshots
+func (mp *MountPoint) snapshotPath(relPath string) bool {
+	_, ok := filesystem.As[filesystem.Snapshotter](mp.fileSystem())
+	return ok && inSnapshots(relPath)
+}
+
+// route returns the file system serving relPath and the path within it.
+// Paths under /.snapshots of a Snapshotter plugin go to a read-only view of
+// its snapshots, hiding wherever the plugin stores them.
+func (mp *MountPoint) route(relPath string) (filesystem.FileSystem, string) {
+	fs := mp.fileSystem()
+	if snapshotter, ok := filesystem.As[filesystem.Snapshotter](fs); ok && inSnapshots(relPath) {
+		inner := strings.TrimPrefix(relPath, SnapshotDir)
+		if inner == "" {
+			inner = "/"
+		}
+		return &snapshotsFS{snapshotter: snapshotter}, inner
+	}
+	return fs, relPath
+}
+
+// snapshotsFS is the read-only /.snapshots directory of a mount. Its
+// entries are snapshot IDs, each the root of a snapshot's tree.
+type snapshotsFS struct {
+	snapshotter filesystem.Snapshotter
+}
+
+// open resolves path to a snapshot view and the path within it. The view
+// is nil when path is the snapshot directory itself.
+func (s *snapshotsFS) open(op, path string) (filesystem.FileSystem, string, error) {
+	path = filesystem.NormalizePath(path)
+	if path == "/" {
+		return nil, "", nil
+	}
+	id, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if _, err := filesystem.ParseSnapshotID(id); err != nil {
+		return nil, "", filesystem.NewNotFoundError(op, SnapshotDir+path)
+	}
+	view, err := s.snapshotter.OpenSnapshot("/", id)
+	if err != nil {
+		return nil, "", err
+	}
+	return view, "/" + rest, nil
+}
+
+func snapshotDirInfo() *filesystem.FileInfo {
+	return &filesystem.FileInfo{
+		Name:    filesystem.SnapshotsDir,
+		Mode:    0555,
+		ModTime: time.Now(),
+		IsDir:   true,
+	}
+}
+
+func (s *snapshotsFS) readOnly(op, path string) error {
+	return filesystem.NewPermissionDeniedError(op, SnapshotDir+path, "snapshots are read-only")
+}
+
+func (s *snapshotsFS) Create(path string) error {
+	return s.readOnly("create", path)
+}
+
+func (s *snapshotsFS) Mkdir(path string, perm uint32) error {
+	return s.readOnly("mkdir", path)
+}
+
+func (s *snapshotsFS) Remove(path string) error {
+	return s.readOnly("remove", path)
+}
+
+func (s *snapshotsFS) RemoveAll(path string) error {
+	return s.readOnly("remove", path)
+}
+
+func (s *snapshotsFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	view, rest, err := s.open("read", path)
+	if err != nil {
+		return nil, err
+	}
+	if view == nil {
+		return nil, filesystem.NewInvalidArgumentError("path", SnapshotDir, "is a directory")
+	}
+	return view.Read(rest, offset, size)
+}
+
+func (s *snapshotsFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	return 0, s.readOnly("write", path)
+}
+
+func (s *snapshotsFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	view, rest, err := s.open("readdir", path)
+	if err != nil {
+		return nil, err
+	}
+	if view != nil {
+		return view.ReadDir(rest)
+	}
+
+	snapshots, err := s.snapshotter.ListSnapshots("/")
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]filesystem.FileInfo, 0, len(snapshots))
+	for _, snap := range snapshots {
+		infos = append(infos, filesystem.FileInfo{
+			Name:    snap.ID,
+			Mode:    0555,
+			ModTime: snap.CreatedAt,
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Type: "snapshot"},
+		})
+	}
+	return infos, nil
+}
+
+func (s *snapshotsFS) Stat(path string) (*filesystem.FileInfo, error) {
+	view, rest, err := s.open("stat", path)
+	if err != nil {
+		return nil, err
+	}
+	if view == nil {
+		return snapshotDirInfo(), nil
+	}
+	info, err := view.Stat(rest)
+	if err != nil {
+		return nil, err
+	}
+	if rest == "/" {
+		info.Name = strings.TrimPrefix(filesystem.NormalizePath(path), "/")
+	}
+	return info, nil
+}
+
+func (s *snapshotsFS) Rename(oldPath, newPath string) error {
+	return s.readOnly("rename", oldPath)
+}
+
+func (s *snapshotsFS) Chmod(path string, mode uint32) error {
+	return s.readOnly("chmod", path)
+}
+
+func (s *snapshotsFS) Open(path string) (io.ReadCloser, error) {
+	view, rest, err := s.open("open", path)
+	if err != nil {
+		return nil, err
+	}
+	if view == nil {
+		return nil, filesystem.NewInvalidArgumentError("path", SnapshotDir, "is a directory")
+	}
+	return view.Open(rest)
+}
+
+func (s *snapshotsFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return nil, s.readOnly("write", path)
+}
+
+func (s *snapshotsFS) Truncate(path string, size int64) error {
+	return s.readOnly("truncate", path)
+}
+
+func (s *snapshotsFS) Reserve(path string, size int64) error {
+	return s.readOnly("create", path)
+}
+
+func (s *snapshotsFS) BeginUpload(path string, partSize int64) (filesystem.Upload, error) {
+	return nil, s.readOnly("upload", path)
+}
+
+// snapshotter returns the Snapshotter of the mount at exactly path
+func (mfs *MountableFS) snapshotter(op, path string) (*MountPoint, filesystem.Snapshotter, error) {
+	mount, relPath, found := mfs.findMount(path)
+	if !found {
+		return nil, nil, filesystem.NewNotFoundError(op, path)
+	}
+	if relPath != "/" {
+		return nil, nil, filesystem.NewInvalidArgumentError("path", path, "snapshots cover the whole mount; use the mount point "+mount.Path)
+	}
+	snapshotter, ok := filesystem.As[filesystem.Snapshotter](mount.fileSystem())
+	if !ok {
+		return nil, nil, filesystem.ErrNotSupported
+	}
+	return mount, snapshotter, nil
+}
+
+// CreateSnapshot snapshots the mount at path
+func (mfs *MountableFS) CreateSnapshot(path string) (*filesystem.Snapshot, error) {
+	mount, snapshotter, err := mfs.snapshotter("snapshot", path)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := snapshotter.CreateSnapshot("/")
+	if err != nil {
+		return nil, err
+	}
+	snap.Path = mount.Path
+	return snap, nil
+}
+
+// ListSnapshots lists the snapshots of the mount at path
+func (mfs *MountableFS) ListSnapshots(path string) ([]filesystem.Snapshot, error) {
+	mount, snapshotter, err := mfs.snapshotter("snapshots", path)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := snapshotter.ListSnapshots("/")
+	if err != nil {
+		return nil, err
+	}
+	for i := range snapshots {
+		snapshots[i].Path = mount.Path
+	}
+	return snapshots, nil
+}
+
+// DeleteSnapshot deletes a snapshot of the mount at path
+func (mfs *MountableFS) DeleteSnapshot(path string, id string) error {
+	_, snapshotter, err := mfs.snapshotter("snapshot", path)
+	if err != nil {
+		return err
+	}
+	return snapshotter.DeleteSnapshot("/", id)
+}
+
+// OpenSnapshot opens a snapshot of the mount at path. The same tree is
+// served under <mount>/.snapshots/<id>.
+func (mfs *MountableFS) OpenSnapshot(path string, id string) (filesystem.FileSystem, error) {
+	_, snapshotter, err := mfs.snapshotter("snapshot", path)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotter.OpenSnapshot("/", id)
+}
+
+// withSnapshotDir adds the snapshot directory to a listing of the mount
+// root if the plugin has snapshots and does not list it already
+func (mp *MountPoint) withSnapshotDir(infos []filesystem.FileInfo) []filesystem.FileInfo {
+	snapshotter, ok := filesystem.As[filesystem.Snapshotter](mp.fileSystem())
+	if !ok {
+		return infos
+	}
+	for _, info := range infos {
+		if info.Name == filesystem.SnapshotsDir {
+			return infos
+		}
+	}
+	if snapshots, err := snapshotter.ListSnapshots("/"); err == nil && len(snapshots) > 0 {
+		infos = append(infos, *snapshotDirInfo())
+	}
+	return infos
+}
+
+var _ filesystem.Snapshotter = (*MountableFS)(nil)
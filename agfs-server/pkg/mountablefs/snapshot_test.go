@@ -0,0 +1,96 @@
+package mountablefs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func hasSnapshotDir(t *testing.T, mfs *MountableFS, path string) bool {
+	t.Helper()
+	infos, err := mfs.ReadDir(path)
+	if err != nil {
+		t.Fatalf("ReadDir(%s) error = %v", path, err)
+	}
+	for _, info := range infos {
+		if info.Name == filesystem.SnapshotsDir {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMountSnapshots(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	if err := mfs.Mount("/data", p); err != nil {
+		t.Fatalf("Mount() error = %v", err)
+	}
+	defer mfs.Unmount("/data")
+
+	if _, err := mfs.Write("/data/notes.txt", []byte("v1"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if hasSnapshotDir(t, mfs, "/data") {
+		t.Fatal("ReadDir(/data) lists .snapshots before the first snapshot")
+	}
+
+	if _, err := mfs.CreateSnapshot("/data/notes.txt"); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Fatalf("CreateSnapshot(file) error = %v, want invalid argument", err)
+	}
+	snap, err := mfs.CreateSnapshot("/data")
+	if err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+	if snap.Path != "/data" {
+		t.Errorf("snapshot path = %q, want /data", snap.Path)
+	}
+	mfs.Write("/data/notes.txt", []byte("v2"), -1, filesystem.WriteFlagTruncate)
+
+	snapPath := "/data/.snapshots/" + snap.ID
+	data, _ := mfs.Read(snapPath+"/notes.txt", 0, -1)
+	if string(data) != "v1" {
+		t.Errorf("snapshot notes.txt = %q, want v1", data)
+	}
+	if info, err := mfs.Stat(snapPath); err != nil || !info.IsDir || info.Name != snap.ID {
+		t.Errorf("Stat(snapshot) = %+v, %v", info, err)
+	}
+	entries, err := mfs.ReadDir("/data/.snapshots")
+	if err != nil || len(entries) != 1 || entries[0].Name != snap.ID {
+		t.Errorf("ReadDir(.snapshots) = %v, %v", entries, err)
+	}
+	if !hasSnapshotDir(t, mfs, "/data") {
+		t.Error("ReadDir(/data) does not list .snapshots")
+	}
+
+	for name, err := range map[string]error{
+		"write": func() error {
+			_, err := mfs.Write(snapPath+"/notes.txt", []byte("x"), -1, filesystem.WriteFlagNone)
+			return err
+		}(),
+		"remove":   mfs.RemoveAll(snapPath),
+		"mkdir":    mfs.Mkdir(snapPath+"/dir", 0755),
+		"rename":   mfs.Rename(snapPath+"/notes.txt", "/data/restored.txt"),
+		"truncate": mfs.Truncate(snapPath+"/notes.txt", 0),
+	} {
+		if !errors.Is(err, filesystem.ErrPermissionDenied) {
+			t.Errorf("%s in snapshot error = %v, want permission denied", name, err)
+		}
+	}
+
+	if _, err := mfs.Stat("/data/.snapshots/bogus"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Stat(bogus snapshot) error = %v, want not found", err)
+	}
+	if err := mfs.DeleteSnapshot("/data", snap.ID); err != nil {
+		t.Fatalf("DeleteSnapshot() error = %v", err)
+	}
+	if snapshots, err := mfs.ListSnapshots("/data"); err != nil || len(snapshots) != 0 {
+		t.Errorf("ListSnapshots() after delete = %v, %v", snapshots, err)
+	}
+}
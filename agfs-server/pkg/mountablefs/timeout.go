@@ -0,0 +1,156 @@
+package mountablefs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// timeoutMiddleware bounds how long reads, writes, listings and custom
+// greps on a mount may take. Options are durations such as "5s": read,
+// write, readdir and grep, with default applying to those not set.
+//
+// A file system implementing filesystem.ContextFileSystem directly below
+// the middleware has its call cancelled at the deadline. Other calls are
+// abandoned: the caller gets a timeout error while the call finishes in
+// the background, so an abandoned write may still take effect. List the
+// timeout middleware last to have it sit right on the plugin.
+type timeoutMiddleware struct {
+	read    time.Duration
+	write   time.Duration
+	readdir time.Duration
+	grep    time.Duration
+}
+
+func newTimeoutMiddleware(config map[string]interface{}) (Middleware, error) {
+	if err := pluginconfig.ValidateOnlyKnownKeys(config, []string{"default", "read", "write", "readdir", "grep"}); err != nil {
+		return nil, err
+	}
+	durations := make(map[string]time.Duration)
+	for key, v := range config {
+		s, ok := v.(string)
+		d, err := time.ParseDuration(s)
+		if !ok || err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s must be a positive duration such as \"5s\"", key)
+		}
+		durations[key] = d
+	}
+	if len(durations) == 0 {
+		return nil, fmt.Errorf("at least one of default, read, write, readdir or grep is required")
+	}
+
+	get := func(key string) time.Duration {
+		if d, ok := durations[key]; ok {
+			return d
+		}
+		return durations["default"]
+	}
+	return &timeoutMiddleware{
+		read:    get("read"),
+		write:   get("write"),
+		readdir: get("readdir"),
+		grep:    get("grep"),
+	}, nil
+}
+
+func (m *timeoutMiddleware) Name() string { return "timeout" }
+
+func (m *timeoutMiddleware) Wrap(next filesystem.FileSystem) filesystem.FileSystem {
+	return &timeoutFS{FileSystem: next, m: m}
+}
+
+type timeoutFS struct {
+	filesystem.FileSystem
+	m *timeoutMiddleware
+}
+
+func (fs *timeoutFS) Unwrap() filesystem.FileSystem { return fs.FileSystem }
+
+// callWithTimeout runs an operation with timeout d (0 means none). It uses
+// ctxCall if it is set, cancelling it at the deadline, and otherwise runs
+// call, abandoning it at the deadline.
+func callWithTimeout[T any](op, path string, d time.Duration, ctxCall func(context.Context) (T, error), call func() (T, error)) (T, error) {
+	if d <= 0 {
+		return call()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	if ctxCall != nil {
+		v, err := ctxCall(ctx)
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			return v, filesystem.NewTimeoutError(op, path, d)
+		}
+		return v, err
+	}
+
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := call()
+		done <- result{v, err}
+	}()
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, filesystem.NewTimeoutError(op, path, d)
+	}
+}
+
+// contextFS returns the wrapped file system if it is context-aware. Only
+// the next file system counts: finding one further down the chain would
+// skip the middleware in between.
+func (fs *timeoutFS) contextFS() (filesystem.ContextFileSystem, bool) {
+	cfs, ok := fs.FileSystem.(filesystem.ContextFileSystem)
+	return cfs, ok
+}
+
+func (fs *timeoutFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	var ctxCall func(context.Context) ([]byte, error)
+	if cfs, ok := fs.contextFS(); ok {
+		ctxCall = func(ctx context.Context) ([]byte, error) { return cfs.ReadContext(ctx, path, offset, size) }
+	}
+	return callWithTimeout("read", path, fs.m.read, ctxCall, func() ([]byte, error) {
+		return fs.FileSystem.Read(path, offset, size)
+	})
+}
+
+func (fs *timeoutFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	var ctxCall func(context.Context) (int64, error)
+	if cfs, ok := fs.contextFS(); ok {
+		ctxCall = func(ctx context.Context) (int64, error) { return cfs.WriteContext(ctx, path, data, offset, flags) }
+	}
+	return callWithTimeout("write", path, fs.m.write, ctxCall, func() (int64, error) {
+		return fs.FileSystem.Write(path, data, offset, flags)
+	})
+}
+
+func (fs *timeoutFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	var ctxCall func(context.Context) ([]filesystem.FileInfo, error)
+	if cfs, ok := fs.contextFS(); ok {
+		ctxCall = func(ctx context.Context) ([]filesystem.FileInfo, error) { return cfs.ReadDirContext(ctx, path) }
+	}
+	return callWithTimeout("readdir", path, fs.m.readdir, ctxCall, func() ([]filesystem.FileInfo, error) {
+		return fs.FileSystem.ReadDir(path)
+	})
+}
+
+// CustomGrep bounds custom searches such as vectorfs queries by the grep
+// timeout
+func (fs *timeoutFS) CustomGrep(path, query string, limit int) ([]CustomGrepResult, error) {
+	grepper, ok := filesystem.As[CustomGrepper](fs.FileSystem)
+	if !ok {
+		return nil, fmt.Errorf("path does not support custom grep: %s", path)
+	}
+	return callWithTimeout("grep", path, fs.m.grep, nil, func() ([]CustomGrepResult, error) {
+		return grepper.CustomGrep(path, query, limit)
+	})
+}
@@ -0,0 +1,429 @@
+package mountablefs
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Trash layout, relative to the mount root. Each Remove/RemoveAll moves its
+// target to /.trash/<stamp>/<original path>, so a batch keeps the directory
+// structure it was removed from.
+const (
+	TrashConfigKey   = "trash_days" // Mount option: days to keep removed files
+	TrashDir         = "/.trash"
+	TrashRestoreFile = "restore"
+	TrashPurgeFile   = "purge"
+
+	trashStampFormat = "20060102T150405.000000000Z"
+)
+
+// trashSweepInterval is how often expired batches are purged
+var trashSweepInterval = time.Hour
+
+const trashControlHelp = `Trash control files:
+  echo '<stamp>[/<path>]' > restore   Move a batch, or one path in it, back to its original location
+  echo '<stamp>[/<path>]' > purge     Delete a batch, or one path in it, permanently
+  echo 'expired' > purge              Delete batches older than the retention period
+  echo 'all' > purge                  Empty the trash
+`
+
+// MountOptions are mount-level settings handled by MountableFS itself rather
+// than by the mounted plugin
+type MountOptions struct {
+	TrashRetention time.Duration // Keep removed files this long; 0 disables the trash
+	Middleware     []Middleware  // Wrap the plugin's file system, outermost first
+
+	// TTLPolicies maps directories, relative to the mount, to the policy
+	// deleting their old files. Non-nil enables TTL policies, including
+	// those set by .ttl files.
+	TTLPolicies map[string]TTLPolicy
+
+	// Shadow mirrors the mount to another one, when non-nil
+	Shadow *ShadowOptions
+
+	// Replicas serve the reads of hot files, when non-nil
+	Replicas *ReplicaOptions
+
+	// Retention protects paths given a retention from being deleted or
+	// overwritten
+	Retention bool
+
+	// Writeback journals changes and applies them in the background, when
+	// non-nil
+	Writeback *WritebackOptions
+}
+
+// ParseMountOptions extracts mount-level options from a plugin config. It
+// returns the options and a copy of config without them, suitable for the
+// plugin's Validate and Initialize.
+func ParseMountOptions(config map[string]interface{}) (MountOptions, map[string]interface{}, error) {
+	var opts MountOptions
+	rest := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		rest[k] = v
+	}
+	if _, ok := config[TrashConfigKey]; ok {
+		days := pluginconfig.GetFloat64Config(config, TrashConfigKey, -1)
+		if days < 0 {
+			return opts, nil, fmt.Errorf("%s must be a non-negative number", TrashConfigKey)
+		}
+		opts.TrashRetention = time.Duration(days * float64(24*time.Hour))
+		delete(rest, TrashConfigKey)
+	}
+	if value, ok := config[MiddlewareConfigKey]; ok {
+		chain, err := parseMiddleware(value)
+		if err != nil {
+			return opts, nil, err
+		}
+		opts.Middleware = chain
+		delete(rest, MiddlewareConfigKey)
+	}
+	if value, ok := config[TTLConfigKey]; ok {
+		policies, err := parseTTLPolicies(value)
+		if err != nil {
+			return opts, nil, err
+		}
+		opts.TTLPolicies = policies
+		delete(rest, TTLConfigKey)
+	}
+	if value, ok := config[ShadowConfigKey]; ok {
+		shadow, err := parseShadowOptions(value)
+		if err != nil {
+			return opts, nil, err
+		}
+		opts.Shadow = shadow
+		delete(rest, ShadowConfigKey)
+	}
+	if value, ok := config[ReplicasConfigKey]; ok {
+		replicas, err := parseReplicaOptions(value)
+		if err != nil {
+			return opts, nil, err
+		}
+		opts.Replicas = replicas
+		delete(rest, ReplicasConfigKey)
+	}
+	if _, ok := config[RetentionConfigKey]; ok {
+		if err := pluginconfig.ValidateBoolType(config, RetentionConfigKey); err != nil {
+			return opts, nil, err
+		}
+		opts.Retention = pluginconfig.GetBoolConfig(config, RetentionConfigKey, false)
+		delete(rest, RetentionConfigKey)
+	}
+	if value, ok := config[WritebackConfigKey]; ok {
+		writeback, err := parseWritebackOptions(value)
+		if err != nil {
+			return opts, nil, err
+		}
+		opts.Writeback = writeback
+		delete(rest, WritebackConfigKey)
+	}
+	return opts, rest, nil
+}
+
+// trashBin implements the per-mount trash on top of the mounted file system
+type trashBin struct {
+	fs        filesystem.FileSystem
+	retention time.Duration
+	mu        sync.Mutex // Serializes moves in and out of the trash
+	stop      chan struct{}
+}
+
+func newTrashBin(fs filesystem.FileSystem, retention time.Duration) *trashBin {
+	t := &trashBin{fs: fs, retention: retention, stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(trashSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := t.purgeExpired(time.Now()); err != nil {
+					log.Warnf("trash sweep failed: %v", err)
+				}
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+func (t *trashBin) close() {
+	close(t.stop)
+}
+
+// inTrash reports whether relPath is the trash directory or inside it
+func inTrash(relPath string) bool {
+	return relPath == TrashDir || strings.HasPrefix(relPath, TrashDir+"/")
+}
+
+// isControlFile reports whether relPath is one of the trash control files
+func isControlFile(relPath string) bool {
+	return relPath == path.Join(TrashDir, TrashRestoreFile) || relPath == path.Join(TrashDir, TrashPurgeFile)
+}
+
+// controlFileInfo describes a trash control file
+func controlFileInfo(name string) filesystem.FileInfo {
+	return filesystem.FileInfo{
+		Name:    name,
+		Size:    int64(len(trashControlHelp)),
+		Mode:    0644,
+		ModTime: time.Now(),
+		IsDir:   false,
+		Meta: filesystem.MetaData{
+			Name: "trash",
+			Type: "control",
+		},
+	}
+}
+
+// remove moves relPath into a new trash batch. With recursive false it keeps
+// Remove's semantics: non-empty directories are refused by the underlying
+// file system, and empty ones are deleted outright since there is nothing
+// to recover.
+func (t *trashBin) remove(relPath string, recursive bool) error {
+	if relPath == "/" || inTrash(relPath) {
+		if isControlFile(relPath) {
+			return filesystem.NewPermissionDeniedError("remove", relPath, "trash control files cannot be removed")
+		}
+		if recursive {
+			return t.fs.RemoveAll(relPath)
+		}
+		return t.fs.Remove(relPath)
+	}
+
+	info, err := t.fs.Stat(relPath)
+	if err != nil {
+		if recursive {
+			// RemoveAll of a missing path is not an error
+			return t.fs.RemoveAll(relPath)
+		}
+		return err
+	}
+	if info.IsDir && !recursive {
+		return t.fs.Remove(relPath) // Fails natively unless empty
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	dest := path.Join(TrashDir, time.Now().UTC().Format(trashStampFormat), relPath)
+	if err := t.mkdirAll(path.Dir(dest)); err != nil {
+		return fmt.Errorf("failed to create trash batch: %w", err)
+	}
+	if err := t.fs.Rename(relPath, dest); err != nil {
+		return fmt.Errorf("failed to move %s to trash: %w", relPath, err)
+	}
+	return nil
+}
+
+// control runs the commands written to a control file, one per line
+func (t *trashBin) control(relPath string, data []byte) error {
+	name := path.Base(relPath)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var err error
+		switch {
+		case name == TrashPurgeFile && line == "all":
+			err = t.purgeAll()
+		case name == TrashPurgeFile && line == "expired":
+			err = t.purgeExpired(time.Now())
+		case name == TrashPurgeFile:
+			err = t.purge(line)
+		default:
+			err = t.restore(line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryPath validates a "<stamp>[/<path>]" reference and returns the batch
+// stamp and the original path it names ("/" for the whole batch)
+func entryPath(ref string) (string, string, error) {
+	ref = strings.TrimPrefix(ref, TrashDir)
+	ref = strings.Trim(ref, "/")
+	stamp, rest, _ := strings.Cut(ref, "/")
+	if _, err := time.Parse(trashStampFormat, stamp); err != nil {
+		return "", "", filesystem.NewInvalidArgumentError("entry", ref, "must be <stamp>[/<path>] of a trash batch")
+	}
+	original := filesystem.NormalizePath("/" + rest)
+	if inTrash(original) {
+		return "", "", filesystem.NewInvalidArgumentError("entry", ref, "path is inside the trash")
+	}
+	return stamp, original, nil
+}
+
+// restore moves a batch, or a path within it, back to its original location.
+// Directories that exist on both sides are merged; files never overwrite.
+func (t *trashBin) restore(ref string) error {
+	stamp, original, err := entryPath(ref)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch := path.Join(TrashDir, stamp)
+	src := path.Join(batch, original)
+	if _, err := t.fs.Stat(src); err != nil {
+		return filesystem.NewNotFoundError("restore", src)
+	}
+	if err := t.move(src, original); err != nil {
+		return err
+	}
+	t.pruneEmpty(path.Dir(src), TrashDir)
+	return nil
+}
+
+// move renames src to dest, merging into dest when both are directories
+func (t *trashBin) move(src, dest string) error {
+	srcInfo, err := t.fs.Stat(src)
+	if err != nil {
+		return err
+	}
+	destInfo, err := t.fs.Stat(dest)
+	if err != nil {
+		if err := t.mkdirAll(path.Dir(dest)); err != nil {
+			return err
+		}
+		return t.fs.Rename(src, dest)
+	}
+	if !srcInfo.IsDir || !destInfo.IsDir {
+		return filesystem.NewAlreadyExistsError("file", dest)
+	}
+
+	entries, err := t.fs.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := t.move(path.Join(src, entry.Name), path.Join(dest, entry.Name)); err != nil {
+			return err
+		}
+	}
+	return t.fs.Remove(src)
+}
+
+// purge permanently deletes a batch or a path within it
+func (t *trashBin) purge(ref string) error {
+	stamp, original, err := entryPath(ref)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	target := path.Join(TrashDir, stamp, original)
+	if _, err := t.fs.Stat(target); err != nil {
+		return filesystem.NewNotFoundError("purge", target)
+	}
+	if err := t.fs.RemoveAll(target); err != nil {
+		return err
+	}
+	t.pruneEmpty(path.Dir(target), TrashDir)
+	return nil
+}
+
+// purgeAll deletes every batch
+func (t *trashBin) purgeAll() error {
+	return t.purgeBatches(func(time.Time) bool { return true })
+}
+
+// purgeExpired deletes batches older than the retention period
+func (t *trashBin) purgeExpired(now time.Time) error {
+	return t.purgeBatches(func(removed time.Time) bool {
+		return now.Sub(removed) >= t.retention
+	})
+}
+
+func (t *trashBin) purgeBatches(match func(removed time.Time) bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batches, err := t.batches()
+	if err != nil {
+		return err
+	}
+	for _, stamp := range batches {
+		removed, _ := time.Parse(trashStampFormat, stamp)
+		if !match(removed) {
+			continue
+		}
+		if err := t.fs.RemoveAll(path.Join(TrashDir, stamp)); err != nil {
+			return fmt.Errorf("failed to purge trash batch %s: %w", stamp, err)
+		}
+	}
+	return nil
+}
+
+// batches lists the batch stamps in the trash, oldest first
+func (t *trashBin) batches() ([]string, error) {
+	entries, err := t.fs.ReadDir(TrashDir)
+	if err != nil {
+		if _, statErr := t.fs.Stat(TrashDir); statErr != nil {
+			return nil, nil // Nothing has been removed yet
+		}
+		return nil, err
+	}
+	var stamps []string
+	for _, entry := range entries {
+		if !entry.IsDir {
+			continue
+		}
+		if _, err := time.Parse(trashStampFormat, entry.Name); err == nil {
+			stamps = append(stamps, entry.Name)
+		}
+	}
+	sort.Strings(stamps)
+	return stamps, nil
+}
+
+// mkdirAll creates dir and any missing parents
+func (t *trashBin) mkdirAll(dir string) error {
+	if dir == "/" {
+		return nil
+	}
+	if info, err := t.fs.Stat(dir); err == nil {
+		if !info.IsDir {
+			return filesystem.NewNotDirectoryError(dir)
+		}
+		return nil
+	}
+	if err := t.mkdirAll(path.Dir(dir)); err != nil {
+		return err
+	}
+	if err := t.fs.Mkdir(dir, 0755); err != nil && !errors.Is(err, filesystem.ErrAlreadyExists) {
+		return err
+	}
+	return nil
+}
+
+// pruneEmpty removes empty directories from dir upwards, stopping at root
+func (t *trashBin) pruneEmpty(dir, root string) {
+	for dir != root && strings.HasPrefix(dir, root+"/") {
+		entries, err := t.fs.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			return
+		}
+		if err := t.fs.Remove(dir); err != nil {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
@@ -0,0 +1,187 @@
+package mountablefs
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// newTrashTestFS mounts memfs at /data with a one day trash
+func newTrashTestFS(t *testing.T) *MountableFS {
+	t.Helper()
+	mfs := NewMountableFS(api.PoolConfig{})
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	if err := mfs.MountWithOptions("/data", p, MountOptions{TrashRetention: 24 * time.Hour}); err != nil {
+		t.Fatalf("MountWithOptions() error = %v", err)
+	}
+	t.Cleanup(func() { mfs.Unmount("/data") })
+	return mfs
+}
+
+func writeTrashTestFile(t *testing.T, mfs *MountableFS, path, data string) {
+	t.Helper()
+	if _, err := mfs.Write(path, []byte(data), -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+		t.Fatalf("Write(%s) error = %v", path, err)
+	}
+}
+
+// trashBatches returns the batch names under /data/.trash
+func trashBatches(t *testing.T, mfs *MountableFS) []string {
+	t.Helper()
+	infos, err := mfs.ReadDir("/data/.trash")
+	if err != nil {
+		t.Fatalf("ReadDir(.trash) error = %v", err)
+	}
+	var batches []string
+	for _, info := range infos {
+		if info.IsDir {
+			batches = append(batches, info.Name)
+		}
+	}
+	return batches
+}
+
+func TestTrashRemoveAndRestore(t *testing.T) {
+	mfs := newTrashTestFS(t)
+	if err := mfs.Mkdir("/data/docs", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	writeTrashTestFile(t, mfs, "/data/docs/a.txt", "hello")
+	writeTrashTestFile(t, mfs, "/data/docs/b.txt", "world")
+
+	if err := mfs.RemoveAll("/data/docs"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if _, err := mfs.Stat("/data/docs"); err == nil {
+		t.Fatal("removed directory is still visible")
+	}
+
+	batches := trashBatches(t, mfs)
+	if len(batches) != 1 {
+		t.Fatalf("batches = %v, want one", batches)
+	}
+	data, err := mfs.Read("/data/.trash/"+batches[0]+"/docs/a.txt", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read(trashed file) error = %v", err)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("trashed file = %q, want hello", data)
+	}
+
+	// Restore one file, then the rest of the batch into the recreated dir
+	writeTrashTestFile(t, mfs, "/data/.trash/restore", batches[0]+"/docs/b.txt\n")
+	writeTrashTestFile(t, mfs, "/data/.trash/restore", batches[0])
+	for _, path := range []string{"/data/docs/a.txt", "/data/docs/b.txt"} {
+		if _, err := mfs.Stat(path); err != nil {
+			t.Fatalf("Stat(%s) after restore error = %v", path, err)
+		}
+	}
+	if batches := trashBatches(t, mfs); len(batches) != 0 {
+		t.Fatalf("batches after restore = %v, want none", batches)
+	}
+}
+
+func TestTrashRestoreDoesNotOverwrite(t *testing.T) {
+	mfs := newTrashTestFS(t)
+	writeTrashTestFile(t, mfs, "/data/a.txt", "old")
+	if err := mfs.Remove("/data/a.txt"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	writeTrashTestFile(t, mfs, "/data/a.txt", "new")
+
+	batch := trashBatches(t, mfs)[0]
+	_, err := mfs.Write("/data/.trash/restore", []byte(batch), -1, filesystem.WriteFlagCreate)
+	if !errors.Is(err, filesystem.ErrAlreadyExists) {
+		t.Fatalf("restore over existing file: error = %v, want already exists", err)
+	}
+
+	if _, err := mfs.Write("/data/.trash/restore", []byte("not-a-batch"), -1, 0); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Fatalf("restore of bad entry: error = %v, want invalid argument", err)
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	mfs := newTrashTestFS(t)
+	for _, name := range []string{"a", "b", "c"} {
+		writeTrashTestFile(t, mfs, "/data/"+name, name)
+		if err := mfs.Remove("/data/" + name); err != nil {
+			t.Fatalf("Remove(%s) error = %v", name, err)
+		}
+	}
+	batches := trashBatches(t, mfs)
+	if len(batches) != 3 {
+		t.Fatalf("batches = %v, want three", batches)
+	}
+
+	writeTrashTestFile(t, mfs, "/data/.trash/purge", batches[0])
+	if got := trashBatches(t, mfs); len(got) != 2 {
+		t.Fatalf("batches after purging one = %v", got)
+	}
+
+	// Nothing has expired within the retention period
+	mount, _, _ := mfs.findMount("/data")
+	if err := mount.trash.purgeExpired(time.Now()); err != nil {
+		t.Fatalf("purgeExpired() error = %v", err)
+	}
+	if got := trashBatches(t, mfs); len(got) != 2 {
+		t.Fatalf("batches after early sweep = %v", got)
+	}
+	if err := mount.trash.purgeExpired(time.Now().Add(25 * time.Hour)); err != nil {
+		t.Fatalf("purgeExpired() error = %v", err)
+	}
+	if got := trashBatches(t, mfs); len(got) != 0 {
+		t.Fatalf("batches after sweep = %v, want none", got)
+	}
+
+	// Control files stay listed and readable
+	data, err := mfs.Read("/data/.trash/purge", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read(purge) error = %v", err)
+	}
+	if !strings.Contains(string(data), "expired") {
+		t.Fatalf("purge help = %q", data)
+	}
+}
+
+func TestTrashRemoveInsideTrashIsPermanent(t *testing.T) {
+	mfs := newTrashTestFS(t)
+	writeTrashTestFile(t, mfs, "/data/a.txt", "a")
+	if err := mfs.Remove("/data/a.txt"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if err := mfs.RemoveAll("/data/.trash/" + trashBatches(t, mfs)[0]); err != nil {
+		t.Fatalf("RemoveAll(batch) error = %v", err)
+	}
+	if got := trashBatches(t, mfs); len(got) != 0 {
+		t.Fatalf("batches = %v, want none", got)
+	}
+	if err := mfs.Remove("/data/.trash/restore"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Fatalf("Remove(control file) error = %v, want permission denied", err)
+	}
+}
+
+func TestParseMountOptions(t *testing.T) {
+	opts, rest, err := ParseMountOptions(map[string]interface{}{"trash_days": 7, "root": "/tmp"})
+	if err != nil {
+		t.Fatalf("ParseMountOptions() error = %v", err)
+	}
+	if opts.TrashRetention != 7*24*time.Hour {
+		t.Fatalf("TrashRetention = %v, want 168h", opts.TrashRetention)
+	}
+	if _, ok := rest["trash_days"]; ok || rest["root"] != "/tmp" {
+		t.Fatalf("plugin config = %v", rest)
+	}
+
+	if _, _, err := ParseMountOptions(map[string]interface{}{"trash_days": "soon"}); err == nil {
+		t.Fatal("non-numeric trash_days should fail")
+	}
+}
@@ -0,0 +1,379 @@
+package mountablefs
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// TTL policies delete the files of a directory once they are older than the
+// policy's TTL. A policy is set by the mount config or by a .ttl file in the
+// directory holding "<duration> [dry-run]", e.g. "24h" or "2h dry-run"; a
+// dry-run policy only reports what it would delete. Reading a .ttl file
+// returns the policy followed by the report of its last sweep as comments.
+const (
+	TTLConfigKey  = "ttl_policies" // Mount option: map of directory to policy
+	TTLPolicyFile = ".ttl"
+
+	ttlDryRun = "dry-run"
+)
+
+// ttlSweepInterval is how often TTL policies are applied
+var ttlSweepInterval = 10 * time.Minute
+
+// ttlPolicyDepth is how deep below the mount root .ttl files are looked for.
+// Policies from the mount config apply at any depth.
+const ttlPolicyDepth = 4
+
+// TTLPolicy deletes the files of a directory older than TTL
+type TTLPolicy struct {
+	TTL    time.Duration
+	DryRun bool // Report what would be deleted without deleting it
+}
+
+// String returns the policy in the form it is parsed from
+func (p TTLPolicy) String() string {
+	if p.DryRun {
+		return p.TTL.String() + " " + ttlDryRun
+	}
+	return p.TTL.String()
+}
+
+// ParseTTLPolicy parses "<duration> [dry-run]". Empty lines and lines
+// starting with # are ignored, so the content read from a .ttl file can be
+// written back.
+func ParseTTLPolicy(s string) (TTLPolicy, error) {
+	var fields []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fields != nil {
+			return TTLPolicy{}, fmt.Errorf("expected a single policy line")
+		}
+		fields = strings.Fields(line)
+	}
+	if len(fields) == 0 || len(fields) > 2 || (len(fields) == 2 && fields[1] != ttlDryRun) {
+		return TTLPolicy{}, fmt.Errorf("expected '<duration> [%s]', e.g. '24h'", ttlDryRun)
+	}
+	ttl, err := time.ParseDuration(fields[0])
+	if err != nil || ttl <= 0 {
+		return TTLPolicy{}, fmt.Errorf("ttl must be a positive duration such as 24h, got %q", fields[0])
+	}
+	return TTLPolicy{TTL: ttl, DryRun: len(fields) == 2}, nil
+}
+
+// parseTTLPolicies reads the ttl_policies mount option, a map of directory,
+// relative to the mount, to policy
+func parseTTLPolicies(value interface{}) (map[string]TTLPolicy, error) {
+	entries, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a map of directory to policy", TTLConfigKey)
+	}
+	policies := make(map[string]TTLPolicy, len(entries))
+	for dir, v := range entries {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s[%s] must be a string such as \"24h\"", TTLConfigKey, dir)
+		}
+		policy, err := ParseTTLPolicy(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%s]: %v", TTLConfigKey, dir, err)
+		}
+		policies[filesystem.NormalizePath(dir)] = policy
+	}
+	return policies, nil
+}
+
+// isTTLFile reports whether relPath is a policy file
+func isTTLFile(relPath string) bool {
+	return path.Base(relPath) == TTLPolicyFile && !inTrash(relPath) && !inSnapshots(relPath)
+}
+
+// ttlRemoval is a path deleted, or that would be deleted, by a sweep
+type ttlRemoval struct {
+	Path    string
+	ModTime time.Time
+	IsDir   bool
+}
+
+// ttlReport is the outcome of the last sweep of a policy directory
+type ttlReport struct {
+	SweptAt  time.Time
+	DryRun   bool
+	Removals []ttlRemoval
+	Errors   []string
+}
+
+// ttlSweeper applies the TTL policies of a mount on top of the mounted file
+// system
+type ttlSweeper struct {
+	fs       filesystem.FileSystem
+	policies map[string]TTLPolicy // From the mount config
+	mu       sync.Mutex           // Serializes sweeps and guards reports
+	reports  map[string]*ttlReport
+	stop     chan struct{}
+
+	retention *retentionStore // Non-nil when retained paths must be kept
+}
+
+func newTTLSweeper(fs filesystem.FileSystem, policies map[string]TTLPolicy, retention *retentionStore) *ttlSweeper {
+	s := &ttlSweeper{fs: fs, policies: policies, reports: make(map[string]*ttlReport), stop: make(chan struct{}), retention: retention}
+	go func() {
+		ticker := time.NewTicker(ttlSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sweep(time.Now(), "")
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *ttlSweeper) close() {
+	close(s.stop)
+}
+
+// policy returns the policy of dir: its .ttl file, else the mount config
+func (s *ttlSweeper) policy(dir string) (TTLPolicy, bool, error) {
+	data, err := s.fs.Read(path.Join(dir, TTLPolicyFile), 0, -1)
+	if err != nil && err != io.EOF {
+		policy, ok := s.policies[dir]
+		return policy, ok, nil
+	}
+	policy, err := ParseTTLPolicy(string(data))
+	return policy, true, err
+}
+
+// setPolicy validates and stores the policy written to a .ttl file, then
+// sweeps its directory so the report is available right away
+func (s *ttlSweeper) setPolicy(relPath string, data []byte) (int64, error) {
+	policy, err := ParseTTLPolicy(string(data))
+	if err != nil {
+		return 0, filesystem.NewInvalidArgumentError("ttl", relPath, err.Error())
+	}
+	content := []byte(policy.String() + "\n")
+	if _, err := s.fs.Write(relPath, content, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+		return 0, err
+	}
+	s.sweep(time.Now(), path.Dir(relPath))
+	return int64(len(data)), nil
+}
+
+// render returns what reading the .ttl file at relPath shows, or false if
+// its directory has no policy
+func (s *ttlSweeper) render(relPath string) ([]byte, bool) {
+	dir := path.Dir(relPath)
+	policy, ok, err := s.policy(dir)
+	if !ok {
+		return nil, false
+	}
+	var sb strings.Builder
+	if err != nil {
+		fmt.Fprintf(&sb, "# invalid policy: %v\n", err)
+		return []byte(sb.String()), true
+	}
+	sb.WriteString(policy.String() + "\n")
+	if _, err := s.fs.Stat(relPath); err != nil {
+		sb.WriteString("# set by the mount config\n")
+	}
+
+	s.mu.Lock()
+	report := s.reports[dir]
+	s.mu.Unlock()
+	if report == nil {
+		sb.WriteString("# last sweep: never\n")
+		return []byte(sb.String()), true
+	}
+	fmt.Fprintf(&sb, "# last sweep: %s\n", report.SweptAt.UTC().Format(time.RFC3339))
+	verb := "removed"
+	if report.DryRun {
+		verb = "would remove"
+	}
+	fmt.Fprintf(&sb, "# %s %d path(s)\n", verb, len(report.Removals))
+	for _, r := range report.Removals {
+		name := r.Path
+		if r.IsDir {
+			name += "/"
+		}
+		fmt.Fprintf(&sb, "#   %s (modified %s)\n", name, r.ModTime.UTC().Format(time.RFC3339))
+	}
+	for _, e := range report.Errors {
+		fmt.Fprintf(&sb, "# error: %s\n", e)
+	}
+	return []byte(sb.String()), true
+}
+
+// fileInfo describes the .ttl file at relPath as it reads, or returns false
+// if its directory has no policy
+func (s *ttlSweeper) fileInfo(relPath string) (*filesystem.FileInfo, bool) {
+	data, ok := s.render(relPath)
+	if !ok {
+		return nil, false
+	}
+	info, err := s.fs.Stat(relPath)
+	if err != nil {
+		info = &filesystem.FileInfo{
+			Name:    TTLPolicyFile,
+			Mode:    0644,
+			ModTime: time.Now(),
+			Meta:    filesystem.MetaData{Name: "ttl", Type: "control"},
+		}
+	}
+	info.Size = int64(len(data))
+	return info, true
+}
+
+// withPolicyFile adds the .ttl file to the listing of a directory whose
+// policy comes from the mount config
+func (s *ttlSweeper) withPolicyFile(dir string, infos []filesystem.FileInfo) []filesystem.FileInfo {
+	if _, ok := s.policies[dir]; !ok {
+		return infos
+	}
+	for _, info := range infos {
+		if info.Name == TTLPolicyFile {
+			return infos
+		}
+	}
+	if info, ok := s.fileInfo(path.Join(dir, TTLPolicyFile)); ok {
+		infos = append(infos, *info)
+	}
+	return infos
+}
+
+// discover returns the policy directories: those of the mount config and
+// those holding a .ttl file within ttlPolicyDepth of the root
+func (s *ttlSweeper) discover() map[string]bool {
+	dirs := make(map[string]bool, len(s.policies))
+	for dir := range s.policies {
+		dirs[dir] = true
+	}
+	var walk func(dir string, depth int)
+	walk = func(dir string, depth int) {
+		entries, err := s.fs.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			child := path.Join(dir, entry.Name)
+			switch {
+			case !entry.IsDir && entry.Name == TTLPolicyFile:
+				dirs[dir] = true
+			case entry.IsDir && depth < ttlPolicyDepth && !inTrash(child) && !inSnapshots(child):
+				walk(child, depth+1)
+			}
+		}
+	}
+	walk("/", 0)
+	return dirs
+}
+
+// sweep applies the policy of every policy directory, or of only that
+// directory, deleting what is older than its TTL as of now
+func (s *ttlSweeper) sweep(now time.Time, only string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dirs := s.discover()
+	if only == "" {
+		for dir := range s.reports {
+			if !dirs[dir] {
+				delete(s.reports, dir)
+			}
+		}
+	}
+	for dir := range dirs {
+		if only != "" && dir != only {
+			continue
+		}
+		policy, ok, err := s.policy(dir)
+		if !ok {
+			continue
+		}
+		report := &ttlReport{SweptAt: now, DryRun: policy.DryRun}
+		if err != nil {
+			report.Errors = append(report.Errors, "invalid policy: "+err.Error())
+		} else {
+			s.sweepDir(dir, policy, now.Add(-policy.TTL), dirs, report)
+		}
+		s.reports[dir] = report
+
+		if len(report.Errors) > 0 {
+			log.Warnf("ttl sweep of %s: %d error(s), first: %s", dir, len(report.Errors), report.Errors[0])
+		}
+		if len(report.Removals) > 0 && !policy.DryRun {
+			log.Infof("ttl sweep of %s removed %d expired path(s)", dir, len(report.Removals))
+		}
+	}
+}
+
+// sweepDir deletes the files of dir modified before cutoff, then the
+// subdirectories that end up empty, leaving subdirectories with their own
+// policy alone. It reports whether dir is left empty.
+func (s *ttlSweeper) sweepDir(dir string, policy TTLPolicy, cutoff time.Time, policyDirs map[string]bool, report *ttlReport) bool {
+	entries, err := s.fs.ReadDir(dir)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", dir, err))
+		return false
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	empty := true
+	for _, entry := range entries {
+		child := path.Join(dir, entry.Name)
+		if entry.Name == TTLPolicyFile || inTrash(child) || inSnapshots(child) || policyDirs[child] || isRetentionFile(child) {
+			empty = false
+			continue
+		}
+		if s.retention != nil && s.retention.retained(child) {
+			empty = false
+			continue
+		}
+		if entry.IsDir {
+			// The directory's own time is checked as it was listed, before
+			// removing its content touched it
+			if !s.sweepDir(child, policy, cutoff, policyDirs, report) || !entry.ModTime.Before(cutoff) {
+				empty = false
+				continue
+			}
+		} else if !entry.ModTime.Before(cutoff) {
+			empty = false
+			continue
+		}
+		if !s.expire(child, entry, policy, cutoff, report) {
+			empty = false
+		}
+	}
+	return empty
+}
+
+// expire deletes an expired path, or only reports it on a dry run. Files are
+// checked again first so that one written during the sweep is kept.
+func (s *ttlSweeper) expire(child string, entry filesystem.FileInfo, policy TTLPolicy, cutoff time.Time, report *ttlReport) bool {
+	if !policy.DryRun {
+		if !entry.IsDir {
+			if info, err := s.fs.Stat(child); err != nil || !info.ModTime.Before(cutoff) {
+				return false
+			}
+		}
+		// Remove refuses directories that gained content since they were listed
+		if err := s.fs.Remove(child); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", child, err))
+			return false
+		}
+	}
+	report.Removals = append(report.Removals, ttlRemoval{Path: child, ModTime: entry.ModTime, IsDir: entry.IsDir})
+	return true
+}
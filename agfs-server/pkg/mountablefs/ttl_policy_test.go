@@ -0,0 +1,125 @@
+package mountablefs
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestParseTTLPolicies(t *testing.T) {
+	opts, rest, err := ParseMountOptions(map[string]interface{}{
+		TTLConfigKey: map[string]interface{}{"scratch": "24h", "/tmp/": "90m dry-run"},
+		"init_dirs":  []interface{}{"/tmp"},
+	})
+	if err != nil {
+		t.Fatalf("ParseMountOptions() error = %v", err)
+	}
+	if _, ok := rest[TTLConfigKey]; ok || rest["init_dirs"] == nil {
+		t.Errorf("plugin config = %v", rest)
+	}
+	want := map[string]TTLPolicy{"/scratch": {TTL: 24 * time.Hour}, "/tmp": {TTL: 90 * time.Minute, DryRun: true}}
+	if len(opts.TTLPolicies) != len(want) {
+		t.Fatalf("policies = %v, want %v", opts.TTLPolicies, want)
+	}
+	for dir, policy := range want {
+		if opts.TTLPolicies[dir] != policy {
+			t.Errorf("policy of %s = %+v, want %+v", dir, opts.TTLPolicies[dir], policy)
+		}
+	}
+
+	for _, bad := range []interface{}{"24h", map[string]interface{}{"/x": "soon"}, map[string]interface{}{"/x": "-1h"}, map[string]interface{}{"/x": "1h maybe"}} {
+		if _, _, err := ParseMountOptions(map[string]interface{}{TTLConfigKey: bad}); err == nil {
+			t.Errorf("ParseMountOptions(%v) succeeded", bad)
+		}
+	}
+}
+
+func TestTTLPolicySweep(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	opts := MountOptions{TTLPolicies: map[string]TTLPolicy{"/cfg": {TTL: time.Hour}}}
+	if err := mfs.MountWithOptions("/data", p, opts); err != nil {
+		t.Fatalf("MountWithOptions() error = %v", err)
+	}
+	t.Cleanup(func() { mfs.Unmount("/data") })
+	mount, _, _ := mfs.findMount("/data")
+
+	for _, dir := range []string{"/data/cfg", "/data/cfg/sub", "/data/scratch", "/data/scratch/keep", "/data/other"} {
+		if err := mfs.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Mkdir(%s) error = %v", dir, err)
+		}
+	}
+	for _, file := range []string{"/data/cfg/a", "/data/cfg/sub/b", "/data/scratch/c", "/data/scratch/keep/d", "/data/other/e"} {
+		writeTrashTestFile(t, mfs, file, "x")
+	}
+	read := func(path string) string {
+		t.Helper()
+		data, err := mfs.Read(path, 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatalf("Read(%s) error = %v", path, err)
+		}
+		return string(data)
+	}
+	exists := func(path string) bool {
+		_, err := mfs.Stat(path)
+		return err == nil
+	}
+
+	// A config policy shows as a .ttl file
+	infos, err := mfs.ReadDir("/data/cfg")
+	if err != nil || len(infos) != 3 {
+		t.Fatalf("ReadDir(cfg) = %v, %v", infos, err)
+	}
+	if got := read("/data/cfg/.ttl"); !strings.HasPrefix(got, "1h0m0s\n") || !strings.Contains(got, "last sweep: never") {
+		t.Errorf("cfg/.ttl = %q", got)
+	}
+	if exists("/data/other/.ttl") {
+		t.Error("directory without policy has a .ttl file")
+	}
+
+	// Policies are set by writing .ttl files, which sweep right away
+	var invalid *filesystem.InvalidArgumentError
+	if _, err := mfs.Write("/data/scratch/.ttl", []byte("forever"), -1, filesystem.WriteFlagCreate); !errors.As(err, &invalid) {
+		t.Fatalf("Write(invalid policy) error = %v", err)
+	}
+	writeTrashTestFile(t, mfs, "/data/scratch/.ttl", "1h dry-run")
+	writeTrashTestFile(t, mfs, "/data/scratch/keep/.ttl", "48h")
+	if got := read("/data/scratch/.ttl"); !strings.Contains(got, "would remove 0 path(s)") {
+		t.Errorf("scratch/.ttl after write = %q", got)
+	}
+
+	later := time.Now().Add(2 * time.Hour)
+	mount.ttl.sweep(later, "")
+	if exists("/data/cfg/a") || exists("/data/cfg/sub") || !exists("/data/cfg") {
+		t.Error("config policy did not remove expired paths")
+	}
+	if got := read("/data/cfg/.ttl"); !strings.Contains(got, "removed 3 path(s)") || !strings.Contains(got, "/cfg/sub/") {
+		t.Errorf("cfg/.ttl = %q", got)
+	}
+	if !exists("/data/scratch/c") {
+		t.Error("dry-run policy removed a file")
+	}
+	if got := read("/data/scratch/.ttl"); !strings.HasPrefix(got, "1h0m0s dry-run\n") || !strings.Contains(got, "would remove 1 path(s)\n#   /scratch/c ") {
+		t.Errorf("scratch/.ttl = %q", got)
+	}
+
+	// Switching to deleting honors the nested policy and leaves others alone
+	writeTrashTestFile(t, mfs, "/data/scratch/.ttl", "# comment\n1h\n")
+	mount.ttl.sweep(later, "")
+	if exists("/data/scratch/c") || !exists("/data/scratch/keep/d") || !exists("/data/scratch/.ttl") || !exists("/data/other/e") {
+		t.Error("sweep removed the wrong paths")
+	}
+	mount.ttl.sweep(later.Add(48*time.Hour), "")
+	if exists("/data/scratch/keep/d") || !exists("/data/other/e") {
+		t.Error("nested policy was not applied")
+	}
+}
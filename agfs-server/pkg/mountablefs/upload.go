@@ -0,0 +1,88 @@
+package mountablefs
+
+import (
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// BeginUpload implements filesystem.Uploader, starting the upload on the
+// mount that owns path. The files the mount itself serves (trash control,
+// TTL policy and health files, snapshots) can't be uploaded.
+func (mfs *MountableFS) BeginUpload(path string, partSize int64) (filesystem.Upload, error) {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return nil, filesystem.NewPermissionDeniedError("upload", path, "not allowed to create file in rootfs, use mount instead")
+	}
+	if isControlFile(relPath) || isTTLFile(relPath) || isHealthFile(relPath) || mount.snapshotPath(relPath) {
+		return nil, filesystem.NewPermissionDeniedError("upload", path, "file is served by the mount")
+	}
+	if err := mount.checkRetentionOverwrite("upload", path, relPath); err != nil {
+		return nil, err
+	}
+	fs, fsPath := mount.route(relPath)
+	upload, err := filesystem.BeginUpload(fs, fsPath, partSize)
+	if err != nil {
+		return nil, err
+	}
+	return &mountUpload{Upload: upload, mfs: mfs, mount: mount, path: path, resolved: resolved, relPath: relPath}, nil
+}
+
+// mountUpload checks retention again when an upload is committed, since
+// the file may have been put under retention while its parts were written,
+// and reports the write event
+type mountUpload struct {
+	filesystem.Upload
+	mfs      *MountableFS
+	mount    *MountPoint
+	path     string
+	resolved string
+	relPath  string
+}
+
+func (u *mountUpload) Commit(size int64) error {
+	if err := u.mount.checkRetentionOverwrite("upload", u.path, u.relPath); err != nil {
+		u.Upload.Abort()
+		return err
+	}
+	err := u.Upload.Commit(size)
+	u.mfs.notify(filesystem.EventWrite, u.resolved, err)
+	return err
+}
+
+// hookedUpload lets the wrappers of a mount's file system see uploads
+// committed through them, e.g. to invalidate or mirror the file
+type hookedUpload struct {
+	filesystem.Upload
+	committed func(err error)
+}
+
+func (u *hookedUpload) Commit(size int64) error {
+	err := u.Upload.Commit(size)
+	u.committed(err)
+	return err
+}
+
+// beginHookedUpload starts an upload on fs, the file system wrapped by
+// wrapper: natively if fs is an Uploader, calling committed once it is
+// committed, or else spooled and written through wrapper
+func beginHookedUpload(wrapper, fs filesystem.FileSystem, path string, partSize int64, committed func(err error)) (filesystem.Upload, error) {
+	uploader, ok := filesystem.As[filesystem.Uploader](fs)
+	if !ok {
+		spooled, err := filesystem.NewSpooledUpload(wrapper, path, partSize)
+		if err != nil {
+			return nil, err
+		}
+		return spooled, nil
+	}
+	upload, err := uploader.BeginUpload(path, partSize)
+	if err != nil {
+		return nil, err
+	}
+	return &hookedUpload{Upload: upload, committed: committed}, nil
+}
+
+// Ensure MountableFS implements Uploader interface
+var _ filesystem.Uploader = (*MountableFS)(nil)
@@ -0,0 +1,416 @@
+package mountablefs
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Buffer sizes and limits of subscriptions
+const (
+	// A path written continuously is still reported every
+	// debounceMaxWaitFactor * Debounce
+	debounceMaxWaitFactor = 4
+
+	watchInputBuffer  = 1024
+	watchOutputBuffer = 256
+)
+
+// subscription receives the events of a directory and its direct entries
+// (or whole subtree when recursive), or of a single file
+type subscription struct {
+	root  string
+	opts  filesystem.WatchOptions
+	types map[string]bool // Nil when all types are wanted
+
+	in      chan filesystem.Event
+	out     chan filesystem.Event
+	done    chan struct{}
+	dropped atomic.Bool
+
+	hub       *watchHub
+	closeOnce sync.Once
+}
+
+// Events returns the event channel, closed when the watcher is closed
+func (w *subscription) Events() <-chan filesystem.Event {
+	return w.out
+}
+
+// Close stops the watcher
+func (w *subscription) Close() {
+	w.closeOnce.Do(func() {
+		w.hub.remove(w)
+		close(w.done)
+	})
+}
+
+// wants reports whether the subscription receives ev: an event of a wanted
+// type whose path, or rename source, is in scope and passes the globs
+func (w *subscription) wants(ev filesystem.Event) bool {
+	if w.types != nil && !w.types[ev.Type] {
+		return false
+	}
+	return w.matches(ev.Path) || (ev.OldPath != "" && w.matches(ev.OldPath))
+}
+
+// matches reports whether p is the watched path or inside it, and passes the
+// include and exclude globs
+func (w *subscription) matches(p string) bool {
+	var rel string
+	switch {
+	case p == w.root:
+		rel = path.Base(p)
+	case path.Dir(p) == w.root:
+		rel = path.Base(p)
+	case w.opts.Recursive && strings.HasPrefix(p, strings.TrimSuffix(w.root, "/")+"/"):
+		rel = strings.TrimPrefix(p, strings.TrimSuffix(w.root, "/")+"/")
+	default:
+		return false
+	}
+	name := path.Base(p)
+	if filesystem.MatchAnyGlob(w.opts.Exclude, name, rel) {
+		return false
+	}
+	return len(w.opts.Include) == 0 || filesystem.MatchAnyGlob(w.opts.Include, name, rel)
+}
+
+// post hands an event to the watcher without blocking the writer
+func (w *subscription) post(ev filesystem.Event) {
+	select {
+	case w.in <- ev:
+	default:
+		w.dropped.Store(true)
+	}
+}
+
+// watchHub numbers events, records them in the history and fans them out
+// to watchers
+type watchHub struct {
+	mu       sync.Mutex
+	watchers map[*subscription]struct{}
+	seq      uint64        // Cursor of the last event
+	history  *eventHistory // Nil when history is disabled
+}
+
+// add registers a watcher. With a Since cursor it also returns the retained
+// events to replay and whether some were lost; registering under the same
+// lock as publish means no event falls between replay and live delivery.
+func (h *watchHub) add(w *subscription) (replay []filesystem.Event, gap bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.watchers == nil {
+		h.watchers = make(map[*subscription]struct{})
+	}
+	h.watchers[w] = struct{}{}
+
+	since := w.opts.Since
+	switch {
+	case since == 0:
+		return nil, false
+	case h.history == nil || since > h.seq:
+		// Nothing retained, or a cursor this history never issued
+		return nil, true
+	default:
+		return h.history.replay(w, since)
+	}
+}
+
+func (h *watchHub) remove(w *subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.watchers, w)
+}
+
+func (h *watchHub) publish(mount string, ev filesystem.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	ev.Cursor = h.seq
+	if h.history != nil {
+		h.history.append(mount, ev)
+	}
+	for w := range h.watchers {
+		if w.wants(ev) {
+			w.post(ev)
+		}
+	}
+}
+
+// Watch implements filesystem.Watcher. Events are reported for changes made
+// through this MountableFS, including writes through handles.
+func (mfs *MountableFS) Watch(p string, opts filesystem.WatchOptions) (filesystem.Subscription, error) {
+	if err := filesystem.ValidateWatchOptions(opts); err != nil {
+		return nil, err
+	}
+	var types map[string]bool
+	if len(opts.Types) > 0 {
+		types = make(map[string]bool, len(opts.Types))
+		for _, t := range opts.Types {
+			types[t] = true
+		}
+	}
+	w := &subscription{
+		root:  filesystem.NormalizePath(p),
+		opts:  opts,
+		types: types,
+		in:    make(chan filesystem.Event, watchInputBuffer),
+		out:   make(chan filesystem.Event, watchOutputBuffer),
+		done:  make(chan struct{}),
+		hub:   &mfs.watches,
+	}
+	replay, gap := mfs.watches.add(w)
+	go w.run(replay, gap)
+	return w, nil
+}
+
+// notify reports a change to watchers when the operation succeeded
+func (mfs *MountableFS) notify(eventType, p string, err error) {
+	if err != nil {
+		return
+	}
+	p = filesystem.NormalizePath(p)
+	mfs.replicaChanged(p)
+	mfs.watches.publish(mfs.eventMount(p), filesystem.Event{Type: eventType, Path: p, Time: time.Now()})
+}
+
+// notifyRename reports a successful rename to watchers
+func (mfs *MountableFS) notifyRename(oldPath, newPath string, err error) {
+	if err != nil {
+		return
+	}
+	newPath = filesystem.NormalizePath(newPath)
+	mfs.replicaChanged(filesystem.NormalizePath(oldPath))
+	mfs.replicaChanged(newPath)
+	mfs.watches.publish(mfs.eventMount(newPath), filesystem.Event{
+		Type:    filesystem.EventRename,
+		Path:    newPath,
+		OldPath: filesystem.NormalizePath(oldPath),
+		Time:    time.Now(),
+	})
+}
+
+// pendingEvent is a debounced event waiting for its file to go quiet
+type pendingEvent struct {
+	event    filesystem.Event
+	first    uint64    // Cursor of the first coalesced event
+	deadline time.Time // Quiet period end
+	maxWait  time.Time // Emitted by then even if writes continue
+}
+
+// run applies debouncing and batching between the hub and the consumer,
+// after replaying history. Delivered events carry the cursor below which
+// nothing is held back, so resuming from it never skips a pending event.
+func (w *subscription) run(replay []filesystem.Event, gap bool) {
+	defer close(w.out)
+
+	pending := make(map[string]*pendingEvent)
+	batches := make(map[string][]filesystem.Event)
+	batchFirst := make(map[string]uint64)
+	var batchDeadline time.Time
+	var received uint64 // Cursor of the last event taken in
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	resumePoint := func() uint64 {
+		mark := received
+		for _, pe := range pending {
+			if pe.first-1 < mark {
+				mark = pe.first - 1
+			}
+		}
+		for _, first := range batchFirst {
+			if first-1 < mark {
+				mark = first - 1
+			}
+		}
+		return mark
+	}
+
+	send := func(ev filesystem.Event) bool {
+		ev.Cursor = resumePoint()
+		select {
+		case w.out <- ev:
+			return true
+		case <-w.done:
+			return false
+		}
+	}
+
+	// release emits an event, or adds it to its directory's batch; first is
+	// the cursor of the oldest change it covers (0 for none)
+	release := func(ev filesystem.Event, first uint64) bool {
+		if w.opts.BatchWindow <= 0 {
+			return send(ev)
+		}
+		dir := path.Dir(ev.Path)
+		batches[dir] = append(batches[dir], ev)
+		if f, ok := batchFirst[dir]; first > 0 && (!ok || first < f) {
+			batchFirst[dir] = first
+		}
+		if batchDeadline.IsZero() {
+			batchDeadline = time.Now().Add(w.opts.BatchWindow)
+		}
+		return true
+	}
+
+	flushPending := func(now time.Time) bool {
+		var due []*pendingEvent
+		for p, pe := range pending {
+			if !now.Before(pe.deadline) || !now.Before(pe.maxWait) {
+				due = append(due, pe)
+				delete(pending, p)
+			}
+		}
+		sort.Slice(due, func(i, j int) bool { return due[i].first < due[j].first })
+		for _, pe := range due {
+			if !release(pe.event, pe.first) {
+				return false
+			}
+		}
+		return true
+	}
+
+	flushBatches := func() bool {
+		dirs := make([]string, 0, len(batches))
+		for dir := range batches {
+			dirs = append(dirs, dir)
+		}
+		sort.Strings(dirs)
+		for _, dir := range dirs {
+			events := batches[dir]
+			delete(batches, dir)
+			delete(batchFirst, dir)
+			if !send(filesystem.Event{Type: filesystem.EventBatch, Path: dir, Time: events[len(events)-1].Time, Count: len(events), Events: events}) {
+				return false
+			}
+		}
+		batchDeadline = time.Time{}
+		return true
+	}
+
+	resetTimer := func() {
+		var next time.Time
+		for _, pe := range pending {
+			due := pe.deadline
+			if pe.maxWait.Before(due) {
+				due = pe.maxWait
+			}
+			if next.IsZero() || due.Before(next) {
+				next = due
+			}
+		}
+		if !batchDeadline.IsZero() && (next.IsZero() || batchDeadline.Before(next)) {
+			next = batchDeadline
+		}
+		timer.Stop()
+		select {
+		case <-timer.C:
+		default:
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+
+	overflow := func() bool {
+		return release(filesystem.Event{Type: filesystem.EventOverflow, Path: w.root, Time: time.Now()}, 0)
+	}
+
+	handle := func(ev filesystem.Event) bool {
+		received = ev.Cursor
+		if w.opts.Debounce > 0 && (ev.Type == filesystem.EventCreate || ev.Type == filesystem.EventWrite) {
+			now := time.Now()
+			if pe, exists := pending[ev.Path]; exists {
+				pe.event.Count++
+				pe.event.Time = ev.Time
+				pe.deadline = now.Add(w.opts.Debounce)
+			} else {
+				ev.Count = 1
+				pending[ev.Path] = &pendingEvent{
+					event:    ev,
+					first:    ev.Cursor,
+					deadline: now.Add(w.opts.Debounce),
+					maxWait:  now.Add(debounceMaxWaitFactor * w.opts.Debounce),
+				}
+			}
+			return true
+		}
+		// Keep per-path order: a debounced write goes out before the remove
+		// or rename that follows it
+		for _, p := range []string{ev.Path, ev.OldPath} {
+			if pe, exists := pending[p]; exists && p != "" {
+				delete(pending, p)
+				if !release(pe.event, pe.first) {
+					return false
+				}
+			}
+		}
+		return release(ev, ev.Cursor)
+	}
+
+	if gap && !overflow() {
+		return
+	}
+	for _, ev := range replay {
+		if !handle(ev) {
+			return
+		}
+	}
+	resetTimer()
+
+	for {
+		select {
+		case <-w.done:
+			return
+
+		case ev := <-w.in:
+			if w.dropped.Swap(false) && !overflow() {
+				return
+			}
+			if !handle(ev) {
+				return
+			}
+			resetTimer()
+
+		case <-timer.C:
+			now := time.Now()
+			if !flushPending(now) {
+				return
+			}
+			if !batchDeadline.IsZero() && !now.Before(batchDeadline) {
+				if !flushBatches() {
+					return
+				}
+			}
+			resetTimer()
+		}
+	}
+}
+
+// watchedWriter reports a write event when a streamed write completes
+type watchedWriter struct {
+	inner interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+	mfs  *MountableFS
+	path string
+}
+
+func (w *watchedWriter) Write(p []byte) (int, error) {
+	return w.inner.Write(p)
+}
+
+func (w *watchedWriter) Close() error {
+	err := w.inner.Close()
+	w.mfs.notify(filesystem.EventWrite, w.path, err)
+	return err
+}
@@ -0,0 +1,404 @@
+package mountablefs
+
+import (
+	"errors"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// newWatchTestFS mounts memfs at /data
+func newWatchTestFS(t *testing.T) *MountableFS {
+	t.Helper()
+	mfs := NewMountableFS(api.PoolConfig{})
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	if err := mfs.Mount("/data", p); err != nil {
+		t.Fatalf("Mount() error = %v", err)
+	}
+	t.Cleanup(func() { mfs.Unmount("/data") })
+	return mfs
+}
+
+func watchTestWrite(t *testing.T, mfs *MountableFS, path string) {
+	t.Helper()
+	if _, err := mfs.Write(path, []byte("x"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write(%s) error = %v", path, err)
+	}
+}
+
+// nextEvent waits for one event
+func nextEvent(t *testing.T, w filesystem.Subscription) filesystem.Event {
+	t.Helper()
+	select {
+	case ev := <-w.Events():
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return filesystem.Event{}
+	}
+}
+
+func expectNoEvent(t *testing.T, w filesystem.Subscription, wait time.Duration) {
+	t.Helper()
+	select {
+	case ev := <-w.Events():
+		t.Fatalf("unexpected event %+v", ev)
+	case <-time.After(wait):
+	}
+}
+
+func TestWatchReportsDirectChanges(t *testing.T) {
+	mfs := newWatchTestFS(t)
+	if err := mfs.Mkdir("/data/sub", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	w, err := mfs.Watch("/data", filesystem.WatchOptions{})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+
+	watchTestWrite(t, mfs, "/data/a.txt")
+	watchTestWrite(t, mfs, "/data/sub/deep.txt") // Not a direct entry
+	if err := mfs.Rename("/data/a.txt", "/data/b.txt"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if err := mfs.Remove("/data/b.txt"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if err := mfs.Remove("/data/missing"); err == nil {
+		t.Fatal("Remove(missing) succeeded")
+	}
+
+	want := []filesystem.Event{
+		{Type: filesystem.EventWrite, Path: "/data/a.txt"},
+		{Type: filesystem.EventRename, Path: "/data/b.txt", OldPath: "/data/a.txt"},
+		{Type: filesystem.EventRemove, Path: "/data/b.txt"},
+	}
+	for _, w2 := range want {
+		ev := nextEvent(t, w)
+		if ev.Type != w2.Type || ev.Path != w2.Path || ev.OldPath != w2.OldPath {
+			t.Errorf("event = %+v, want %+v", ev, w2)
+		}
+	}
+	expectNoEvent(t, w, 50*time.Millisecond)
+
+	w.Close()
+	if _, ok := <-w.Events(); ok {
+		t.Error("events channel still open after Close")
+	}
+}
+
+func TestWatchDebounceCoalescesWrites(t *testing.T) {
+	mfs := newWatchTestFS(t)
+	w, err := mfs.Watch("/data", filesystem.WatchOptions{Debounce: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 20; i++ {
+		watchTestWrite(t, mfs, "/data/hot.txt")
+	}
+	watchTestWrite(t, mfs, "/data/cold.txt")
+
+	got := map[string]int{}
+	for i := 0; i < 2; i++ {
+		ev := nextEvent(t, w)
+		got[ev.Path] = ev.Count
+	}
+	if got["/data/hot.txt"] != 20 || got["/data/cold.txt"] != 1 {
+		t.Errorf("coalesced counts = %v", got)
+	}
+	expectNoEvent(t, w, 150*time.Millisecond)
+
+	// A remove flushes the pending write first, keeping order
+	watchTestWrite(t, mfs, "/data/hot.txt")
+	if err := mfs.Remove("/data/hot.txt"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if ev := nextEvent(t, w); ev.Type != filesystem.EventWrite {
+		t.Errorf("first event = %+v, want write", ev)
+	}
+	if ev := nextEvent(t, w); ev.Type != filesystem.EventRemove {
+		t.Errorf("second event = %+v, want remove", ev)
+	}
+}
+
+func TestWatchDebounceMaxWait(t *testing.T) {
+	mfs := newWatchTestFS(t)
+	w, err := mfs.Watch("/data", filesystem.WatchOptions{Debounce: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+
+	// Writing faster than the debounce must not starve the watcher
+	stop := time.After(400 * time.Millisecond)
+	got := 0
+	for done := false; !done; {
+		select {
+		case <-stop:
+			done = true
+		case <-w.Events():
+			got++
+		default:
+			watchTestWrite(t, mfs, "/data/loop.txt")
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if got == 0 {
+		t.Error("no event while the file was written continuously")
+	}
+}
+
+func TestWatchBatchesPerDirectory(t *testing.T) {
+	mfs := newWatchTestFS(t)
+	w, err := mfs.Watch("/data", filesystem.WatchOptions{BatchWindow: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+
+	watchTestWrite(t, mfs, "/data/a.txt")
+	watchTestWrite(t, mfs, "/data/b.txt")
+	if err := mfs.Mkdir("/data/dir", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+
+	ev := nextEvent(t, w)
+	if ev.Type != filesystem.EventBatch || ev.Path != "/data" || ev.Count != 3 || len(ev.Events) != 3 {
+		t.Fatalf("batch = %+v", ev)
+	}
+	if ev.Events[0].Path != "/data/a.txt" || ev.Events[2].Type != filesystem.EventMkdir {
+		t.Errorf("batch members = %+v", ev.Events)
+	}
+	expectNoEvent(t, w, 150*time.Millisecond)
+}
+
+func TestWatchOptionsValidation(t *testing.T) {
+	mfs := newWatchTestFS(t)
+	for _, opts := range []filesystem.WatchOptions{
+		{Debounce: -time.Second},
+		{Debounce: 2 * filesystem.MaxWatchDebounce},
+		{BatchWindow: 2 * filesystem.MaxWatchBatchWindow},
+	} {
+		if _, err := mfs.Watch("/data", opts); !errors.Is(err, filesystem.ErrInvalidArgument) {
+			t.Errorf("Watch(%+v) error = %v, want invalid argument", opts, err)
+		}
+	}
+}
+
+func TestWatchRecursiveWithFilters(t *testing.T) {
+	mfs := newWatchTestFS(t)
+	for _, dir := range []string{"/data/docs", "/data/docs/api", "/data/docs/api/drafts"} {
+		if err := mfs.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Mkdir(%s) error = %v", dir, err)
+		}
+	}
+	w, err := mfs.Watch("/data", filesystem.WatchOptions{
+		Recursive: true,
+		Include:   []string{"**/*.md"},
+		Exclude:   []string{"drafts/**", "**/drafts/**"},
+		Types:     []string{filesystem.EventWrite, filesystem.EventRename},
+	})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+
+	watchTestWrite(t, mfs, "/data/docs/api/skip.txt")    // Not included
+	watchTestWrite(t, mfs, "/data/docs/api/drafts/x.md") // Excluded
+	if err := mfs.Mkdir("/data/docs/new.md", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err) // Filtered type
+	}
+	watchTestWrite(t, mfs, "/data/docs/api/guide.md")
+	if err := mfs.Rename("/data/docs/api/skip.txt", "/data/docs/api/renamed.md"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+
+	if ev := nextEvent(t, w); ev.Type != filesystem.EventWrite || ev.Path != "/data/docs/api/guide.md" {
+		t.Errorf("first event = %+v, want write of guide.md", ev)
+	}
+	if ev := nextEvent(t, w); ev.Type != filesystem.EventRename || ev.Path != "/data/docs/api/renamed.md" {
+		t.Errorf("second event = %+v, want rename to renamed.md", ev)
+	}
+	expectNoEvent(t, w, 50*time.Millisecond)
+
+	for _, opts := range []filesystem.WatchOptions{
+		{Include: []string{"[bad"}},
+		{Types: []string{"explode"}},
+	} {
+		if _, err := mfs.Watch("/data", opts); !errors.Is(err, filesystem.ErrInvalidArgument) {
+			t.Errorf("Watch(%+v) error = %v, want invalid argument", opts, err)
+		}
+	}
+}
+
+func TestWatchResumeFromCursor(t *testing.T) {
+	mfs := newWatchTestFS(t)
+	if err := mfs.SetEventHistory(EventHistoryConfig{MaxEvents: 100}); err != nil {
+		t.Fatalf("SetEventHistory() error = %v", err)
+	}
+	if err := mfs.Mkdir("/data/sub", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	w, err := mfs.Watch("/data", filesystem.WatchOptions{})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	watchTestWrite(t, mfs, "/data/a.txt")
+	cursor := nextEvent(t, w).Cursor
+	w.Close()
+
+	// Changes made while disconnected are replayed, then live events follow
+	watchTestWrite(t, mfs, "/data/b.txt")
+	watchTestWrite(t, mfs, "/data/sub/other.txt")
+	watchTestWrite(t, mfs, "/data/c.txt")
+	w, err = mfs.Watch("/data", filesystem.WatchOptions{Since: cursor})
+	if err != nil {
+		t.Fatalf("Watch(since) error = %v", err)
+	}
+	defer w.Close()
+	watchTestWrite(t, mfs, "/data/d.txt")
+	last := cursor
+	for _, want := range []string{"/data/b.txt", "/data/c.txt", "/data/d.txt"} {
+		ev := nextEvent(t, w)
+		if ev.Path != want || ev.Cursor <= last {
+			t.Errorf("event = %+v, want %s after cursor %d", ev, want, last)
+		}
+		last = ev.Cursor
+	}
+	expectNoEvent(t, w, 50*time.Millisecond)
+}
+
+func TestWatchResumeReportsGap(t *testing.T) {
+	mfs := newWatchTestFS(t)
+	w, err := mfs.Watch("/data", filesystem.WatchOptions{})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	watchTestWrite(t, mfs, "/data/a.txt")
+	cursor := nextEvent(t, w).Cursor
+	w.Close()
+
+	// Without history there is nothing to replay
+	w, err = mfs.Watch("/data", filesystem.WatchOptions{Since: cursor})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	if ev := nextEvent(t, w); ev.Type != filesystem.EventOverflow {
+		t.Errorf("event = %+v, want overflow", ev)
+	}
+	w.Close()
+
+	if err := mfs.SetEventHistory(EventHistoryConfig{MaxEvents: 2}); err != nil {
+		t.Fatalf("SetEventHistory() error = %v", err)
+	}
+	watchTestWrite(t, mfs, "/data/b.txt")
+	for _, name := range []string{"c", "d", "e"} {
+		watchTestWrite(t, mfs, "/data/"+name+".txt")
+	}
+	w, err = mfs.Watch("/data", filesystem.WatchOptions{Since: cursor})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+	for _, want := range []string{filesystem.EventOverflow, "/data/d.txt", "/data/e.txt"} {
+		if ev := nextEvent(t, w); ev.Type != want && ev.Path != want {
+			t.Errorf("event = %+v, want %s", ev, want)
+		}
+	}
+}
+
+func TestWatchHistoryPersists(t *testing.T) {
+	dir := t.TempDir()
+	mfs := newWatchTestFS(t)
+	if err := mfs.SetEventHistory(EventHistoryConfig{MaxEvents: 3, Dir: dir}); err != nil {
+		t.Fatalf("SetEventHistory() error = %v", err)
+	}
+	w, err := mfs.Watch("/data", filesystem.WatchOptions{})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	watchTestWrite(t, mfs, "/data/a.txt")
+	cursor := nextEvent(t, w).Cursor
+	w.Close()
+	// Enough events to compact the file
+	for i := 0; i < 20; i++ {
+		watchTestWrite(t, mfs, "/data/b.txt")
+	}
+	watchTestWrite(t, mfs, "/data/c.txt")
+	mfs.SetEventHistory(EventHistoryConfig{})
+
+	// A restarted server resumes from the persisted history
+	restarted := newWatchTestFS(t)
+	if err := restarted.SetEventHistory(EventHistoryConfig{MaxEvents: 3, Dir: dir}); err != nil {
+		t.Fatalf("SetEventHistory() after restart error = %v", err)
+	}
+	w, err = restarted.Watch("/data", filesystem.WatchOptions{Since: cursor})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+	for _, want := range []string{filesystem.EventOverflow, "/data/b.txt", "/data/b.txt", "/data/c.txt"} {
+		if ev := nextEvent(t, w); ev.Type != want && ev.Path != want {
+			t.Errorf("event = %+v, want %s", ev, want)
+		}
+	}
+	expectNoEvent(t, w, 50*time.Millisecond)
+
+	// Without a gap, nothing but the tail is replayed
+	last := nextCursorAfterWrite(t, restarted, "/data/d.txt")
+	w2, err := restarted.Watch("/data", filesystem.WatchOptions{Since: last - 1})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w2.Close()
+	if ev := nextEvent(t, w2); ev.Path != "/data/d.txt" {
+		t.Errorf("event = %+v, want d.txt", ev)
+	}
+}
+
+// nextCursorAfterWrite writes p and returns the cursor of its event
+func nextCursorAfterWrite(t *testing.T, mfs *MountableFS, p string) uint64 {
+	t.Helper()
+	w, err := mfs.Watch(path.Dir(p), filesystem.WatchOptions{})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+	watchTestWrite(t, mfs, p)
+	return nextEvent(t, w).Cursor
+}
+
+func TestWatchCursorCoversPendingEvents(t *testing.T) {
+	mfs := newWatchTestFS(t)
+	w, err := mfs.Watch("/data", filesystem.WatchOptions{Debounce: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+
+	watchTestWrite(t, mfs, "/data/hot.txt")
+	if err := mfs.Mkdir("/data/dir", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	mkdir := nextEvent(t, w)
+	write := nextEvent(t, w)
+	if mkdir.Type != filesystem.EventMkdir || write.Type != filesystem.EventWrite {
+		t.Fatalf("events = %+v, %+v", mkdir, write)
+	}
+	// The mkdir went out while the earlier write was held back, so resuming
+	// from its cursor must still include the write
+	if mkdir.Cursor >= write.Cursor-1 {
+		t.Errorf("mkdir cursor %d does not cover the pending write (cursor %d)", mkdir.Cursor, write.Cursor)
+	}
+}
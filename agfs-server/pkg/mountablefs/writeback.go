@@ -0,0 +1,655 @@
+package mountablefs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// WritebackConfigKey is the mount option acknowledging changes to a mount
+// once they are journaled on local disk, and applying them to the backend
+// in the background. Acknowledged changes survive a restart of the server
+// and the backend being unavailable; they are applied in order, retried
+// until the backend takes them:
+//
+//	writeback:
+//	  journal: /var/lib/agfs/wal/s3   # Directory of the journal, one per mount
+//	  max_backoff: 30s                # Longest wait between retries (default "30s")
+//
+// Reads of paths with changes waiting wait for them to be applied, or fail
+// with the error the backend last returned for them. Changes still in the
+// journal when the mount is unmounted are replayed when it is mounted
+// again. Pending changes are reported by MountableFS.Writebacks.
+const WritebackConfigKey = "writeback"
+
+// Journal files, in the journal directory
+const (
+	writebackJournalFile    = "journal"    // JSON records, one per line, oldest first
+	writebackCheckpointFile = "checkpoint" // Sequence number of the last applied record
+)
+
+// writebackMinBackoff is the first wait before retrying a change
+const writebackMinBackoff = 100 * time.Millisecond
+
+// writebackListed is how many pending changes, and how many failures, a
+// write-back status lists
+const writebackListed = 50
+
+// WritebackOptions configures the write-back of a mount
+type WritebackOptions struct {
+	Journal    string        // Directory of the journal
+	MaxBackoff time.Duration // Longest wait between retries of a change
+}
+
+// parseWritebackOptions reads the writeback mount option
+func parseWritebackOptions(value interface{}) (*WritebackOptions, error) {
+	config, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a map with a journal", WritebackConfigKey)
+	}
+	if err := pluginconfig.ValidateOnlyKnownKeys(config, []string{"journal", "max_backoff"}); err != nil {
+		return nil, fmt.Errorf("%s: %w", WritebackConfigKey, err)
+	}
+	journal, err := pluginconfig.RequireString(config, "journal")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", WritebackConfigKey, err)
+	}
+	opts := &WritebackOptions{Journal: filepath.Clean(journal), MaxBackoff: 30 * time.Second}
+	if v, ok := config["max_backoff"]; ok {
+		s, ok := v.(string)
+		d, err := time.ParseDuration(s)
+		if !ok || err != nil || d < writebackMinBackoff {
+			return nil, fmt.Errorf("%s: max_backoff must be a duration of at least %s, such as \"30s\"", WritebackConfigKey, writebackMinBackoff)
+		}
+		opts.MaxBackoff = d
+	}
+	return opts, nil
+}
+
+// writebackRecord is a change in the journal
+type writebackRecord struct {
+	Seq     int64                `json:"seq"`
+	Time    time.Time            `json:"time"`
+	Op      string               `json:"op"`
+	Path    string               `json:"path"`
+	NewPath string               `json:"new_path,omitempty"` // Of renames
+	Data    []byte               `json:"data,omitempty"`     // Of writes
+	Offset  int64                `json:"offset,omitempty"`   // Of writes
+	Flags   filesystem.WriteFlag `json:"flags,omitempty"`    // Of writes
+	Mode    uint32               `json:"mode,omitempty"`     // Of mkdir and chmod
+	Size    int64                `json:"size,omitempty"`     // Of truncates
+}
+
+// touches reports whether the record changes p, a directory above it, or
+// something below it
+func (rec *writebackRecord) touches(p string) bool {
+	for _, q := range []string{rec.Path, rec.NewPath} {
+		if q != "" && (q == p || isUnder(q, p) || isUnder(p, q)) {
+			return true
+		}
+	}
+	return false
+}
+
+// WritebackChange is a change waiting to be applied to the backend
+type WritebackChange struct {
+	Seq     int64     `json:"seq"`
+	Time    time.Time `json:"time"` // When it was acknowledged
+	Op      string    `json:"op"`
+	Path    string    `json:"path"` // Relative to the mount
+	NewPath string    `json:"new_path,omitempty"`
+}
+
+// WritebackFailure is a change the backend rejected
+type WritebackFailure struct {
+	WritebackChange
+	Error string `json:"error"`
+}
+
+// WritebackStatus reports the write-back of a mount
+type WritebackStatus struct {
+	Path      string             `json:"path"`
+	Journal   string             `json:"journal"`
+	Pending   int                `json:"pending"`
+	Applied   int64              `json:"applied"`              // Changes applied since mounted
+	Failed    int64              `json:"failed"`               // Changes the backend rejected
+	LastError string             `json:"last_error,omitempty"` // Why the oldest pending change is being retried
+	Changes   []WritebackChange  `json:"changes,omitempty"`    // Oldest first
+	Failures  []WritebackFailure `json:"failures,omitempty"`   // Most recent first
+}
+
+// openJournals are the journal directories of the mounts, which can't share
+// one
+var openJournals = struct {
+	sync.Mutex
+	dirs map[string]bool
+}{dirs: make(map[string]bool)}
+
+// writebackFS journals the changes made to a mount and applies them to its
+// file system in order, with a single worker. The optional interfaces of
+// the file system are hidden: those that change it would bypass the
+// journal, and those that read it wouldn't wait for the changes pending.
+type writebackFS struct {
+	filesystem.FileSystem
+	opts *WritebackOptions
+	now  func() time.Time
+
+	wake chan struct{} // Signals the worker that a change was journaled
+	stop chan struct{}
+	done chan struct{}
+
+	mu       sync.Mutex
+	cond     *sync.Cond // Broadcast when the worker tried to apply a change
+	journal  *os.File
+	size     int64 // Of the journal
+	seq      int64 // Last journaled
+	pending  []writebackRecord
+	applied  int64
+	failed   int64
+	lastErr  error // Returned by the backend for the oldest pending change
+	failures []WritebackFailure
+	closed   bool
+}
+
+// openWritebackFS opens the journal of a mount, and starts applying the
+// changes it holds that weren't applied before the server stopped
+func openWritebackFS(fs filesystem.FileSystem, opts *WritebackOptions) (*writebackFS, error) {
+	dir, err := filepath.Abs(opts.Journal)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", WritebackConfigKey, err)
+	}
+	openJournals.Lock()
+	defer openJournals.Unlock()
+	if openJournals.dirs[dir] {
+		return nil, fmt.Errorf("%s: journal %s is used by another mount", WritebackConfigKey, dir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("%s: %w", WritebackConfigKey, err)
+	}
+
+	applied, err := readWritebackCheckpoint(filepath.Join(dir, writebackCheckpointFile))
+	if err != nil {
+		return nil, err
+	}
+	journal, err := os.OpenFile(filepath.Join(dir, writebackJournalFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", WritebackConfigKey, err)
+	}
+	records, size, err := readWritebackJournal(journal)
+	if err == nil {
+		// Drops a record cut short by a crash; it was never acknowledged
+		err = journal.Truncate(size)
+	}
+	if err == nil {
+		_, err = journal.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("%s: journal %s: %w", WritebackConfigKey, journal.Name(), err)
+	}
+
+	w := &writebackFS{
+		FileSystem: fs,
+		opts:       &WritebackOptions{Journal: dir, MaxBackoff: opts.MaxBackoff},
+		now:        time.Now,
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		journal:    journal,
+		size:       size,
+		seq:        applied,
+	}
+	w.cond = sync.NewCond(&w.mu)
+	for _, rec := range records {
+		if rec.Seq > applied {
+			w.pending = append(w.pending, rec)
+		}
+		w.seq = max(w.seq, rec.Seq)
+	}
+	if len(w.pending) > 0 {
+		log.Infof("[writeback] replaying %d changes from %s", len(w.pending), dir)
+	}
+	openJournals.dirs[dir] = true
+	go w.run()
+	return w, nil
+}
+
+// readWritebackCheckpoint returns the sequence number of the last record
+// applied, 0 if none was
+func readWritebackCheckpoint(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	var checkpoint struct {
+		Applied int64 `json:"applied"`
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &checkpoint)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: checkpoint %s: %w", WritebackConfigKey, path, err)
+	}
+	return checkpoint.Applied, nil
+}
+
+// readWritebackJournal returns the records of a journal and the size of
+// the part holding them. Reading stops at the first incomplete or corrupt
+// line.
+func readWritebackJournal(r io.Reader) ([]writebackRecord, int64, error) {
+	var records []writebackRecord
+	var size int64
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Warnf("[writeback] dropping an incomplete journal record")
+			}
+			return records, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		var rec writebackRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			log.Warnf("[writeback] dropping a corrupt journal record and what follows: %v", err)
+			return records, size, nil
+		}
+		records = append(records, rec)
+		size += int64(len(line))
+	}
+}
+
+func (w *writebackFS) Unwrap() filesystem.FileSystem { return w.FileSystem }
+
+// writebackForwarded are the optional interfaces that don't involve the
+// content of paths, which writebackFS lets callers find on the file system
+// it wraps
+var writebackForwarded = map[reflect.Type]bool{
+	reflect.TypeFor[filesystem.HealthReporter](): true,
+
+	// Markers describing how paths behave
+	reflect.TypeFor[filesystem.AppendOnlyFS]():      true,
+	reflect.TypeFor[filesystem.BroadcastFS]():       true,
+	reflect.TypeFor[filesystem.ObjectStoreFS]():     true,
+	reflect.TypeFor[filesystem.ReadDestructiveFS](): true,
+}
+
+func (w *writebackFS) Forwards(t reflect.Type) bool { return writebackForwarded[t] }
+
+// close stops the worker once it has applied what the backend takes
+// without waiting, and closes the journal. The changes left are applied
+// when the mount is mounted again.
+func (w *writebackFS) close() {
+	close(w.stop)
+	<-w.done
+
+	w.mu.Lock()
+	w.closed = true
+	if len(w.pending) > 0 {
+		log.Warnf("[writeback] %d changes left in %s", len(w.pending), w.opts.Journal)
+	}
+	w.journal.Close()
+	w.cond.Broadcast()
+	w.mu.Unlock()
+
+	openJournals.Lock()
+	delete(openJournals.dirs, w.opts.Journal)
+	openJournals.Unlock()
+}
+
+func (w *writebackFS) run() {
+	defer close(w.done)
+	backoff := writebackMinBackoff
+	for {
+		rec, ok := w.next()
+		if !ok {
+			select {
+			case <-w.wake:
+				continue
+			case <-w.stop:
+				return
+			}
+		}
+		select {
+		case <-w.stop:
+			w.drain()
+			return
+		default:
+		}
+		if w.step(rec) {
+			backoff = writebackMinBackoff
+			continue
+		}
+		select {
+		case <-time.After(backoff):
+			backoff = min(2*backoff, w.opts.MaxBackoff)
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// drain applies the pending changes until the backend fails to take one
+func (w *writebackFS) drain() {
+	for {
+		rec, ok := w.next()
+		if !ok || !w.step(rec) {
+			return
+		}
+	}
+}
+
+// next returns the oldest pending change. When there is none, it empties
+// the journal.
+func (w *writebackFS) next() (writebackRecord, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) > 0 {
+		return w.pending[0], true
+	}
+	if w.size > 0 {
+		if err := w.journal.Truncate(0); err != nil {
+			log.Warnf("[writeback] compacting %s failed: %v", w.opts.Journal, err)
+		} else {
+			w.journal.Seek(0, io.SeekStart)
+			w.size = 0
+		}
+	}
+	return writebackRecord{}, false
+}
+
+// step applies the oldest pending change. It returns false if the backend
+// is unavailable and the change must be retried; changes the backend
+// rejects are dropped.
+func (w *writebackFS) step(rec writebackRecord) bool {
+	err := w.apply(rec)
+	if isRetryable(err) {
+		log.Warnf("[writeback] %s %s: %v; retrying", rec.Op, rec.Path, err)
+		w.mu.Lock()
+		w.lastErr = err
+		w.cond.Broadcast()
+		w.mu.Unlock()
+		return false
+	}
+
+	// Recorded before the change leaves the queue: after a crash in
+	// between, it is applied again rather than lost
+	if cerr := w.checkpoint(rec.Seq); cerr != nil {
+		log.Warnf("[writeback] checkpoint of %s failed: %v", w.opts.Journal, cerr)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = w.pending[1:]
+	w.lastErr = nil
+	if err != nil {
+		log.Warnf("[writeback] %s %s rejected: %v", rec.Op, rec.Path, err)
+		w.failed++
+		w.failures = append(w.failures, WritebackFailure{WritebackChange: rec.change(), Error: err.Error()})
+		if len(w.failures) > writebackListed {
+			w.failures = w.failures[len(w.failures)-writebackListed:]
+		}
+	} else {
+		w.applied++
+	}
+	w.cond.Broadcast()
+	return true
+}
+
+// isRetryable reports whether a change failed because of the backend
+// rather than the change itself
+func isRetryable(err error) bool {
+	return isBackendFailure(err) || errors.Is(err, filesystem.ErrBackendUnavailable) || errors.Is(err, filesystem.ErrRateLimited)
+}
+
+// apply makes a journaled change to the backend
+func (w *writebackFS) apply(rec writebackRecord) error {
+	switch rec.Op {
+	case "create":
+		return w.FileSystem.Create(rec.Path)
+	case "mkdir":
+		return w.FileSystem.Mkdir(rec.Path, rec.Mode)
+	case "remove":
+		return w.FileSystem.Remove(rec.Path)
+	case "removeall":
+		return w.FileSystem.RemoveAll(rec.Path)
+	case "write":
+		_, err := w.FileSystem.Write(rec.Path, rec.Data, rec.Offset, rec.Flags)
+		return err
+	case "rename":
+		return w.FileSystem.Rename(rec.Path, rec.NewPath)
+	case "chmod":
+		return w.FileSystem.Chmod(rec.Path, rec.Mode)
+	case "truncate":
+		t, ok := filesystem.As[filesystem.Truncater](w.FileSystem)
+		if !ok {
+			return filesystem.NewNotSupportedError("truncate", rec.Path)
+		}
+		return t.Truncate(rec.Path, rec.Size)
+	}
+	return fmt.Errorf("unknown journaled operation %q", rec.Op)
+}
+
+// checkpoint records that the changes up to seq were applied. The file is
+// replaced rather than rewritten, so that a crash leaves the old one.
+func (w *writebackFS) checkpoint(seq int64) error {
+	path := filepath.Join(w.opts.Journal, writebackCheckpointFile)
+	tmp, err := os.CreateTemp(w.opts.Journal, writebackCheckpointFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = fmt.Fprintf(tmp, "{\"applied\":%d}\n", seq)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// record journals a change, which is acknowledged once it returns nil
+func (w *writebackFS) record(rec writebackRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return filesystem.NewBackendUnavailableError(rec.Path, 0, "mount is unmounted")
+	}
+	rec.Seq = w.seq + 1
+	rec.Time = w.now()
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err = w.journal.Write(line); err == nil {
+		err = w.journal.Sync()
+	}
+	if err != nil {
+		// Leaves no partial record for later ones to follow
+		w.journal.Truncate(w.size)
+		w.journal.Seek(w.size, io.SeekStart)
+		return fmt.Errorf("journaling %s %s: %w", rec.Op, rec.Path, err)
+	}
+	w.size += int64(len(line))
+	w.seq = rec.Seq
+	w.pending = append(w.pending, rec)
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// wait returns once the changes pending on path have been applied, or with
+// the error the backend returned for the oldest pending change if they
+// can't be yet
+func (w *writebackFS) wait(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var last int64
+	for i := len(w.pending) - 1; i >= 0; i-- {
+		if w.pending[i].touches(path) {
+			last = w.pending[i].Seq
+			break
+		}
+	}
+	for len(w.pending) > 0 && w.pending[0].Seq <= last {
+		if w.lastErr != nil {
+			return fmt.Errorf("%s has changes waiting to be written back: %w", path, w.lastErr)
+		}
+		if w.closed {
+			return filesystem.NewBackendUnavailableError(path, 0, "mount is unmounted")
+		}
+		w.cond.Wait()
+	}
+	return nil
+}
+
+func (rec *writebackRecord) change() WritebackChange {
+	return WritebackChange{Seq: rec.Seq, Time: rec.Time, Op: rec.Op, Path: rec.Path, NewPath: rec.NewPath}
+}
+
+func (w *writebackFS) status() WritebackStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	st := WritebackStatus{
+		Journal: w.opts.Journal,
+		Pending: len(w.pending),
+		Applied: w.applied,
+		Failed:  w.failed,
+	}
+	if w.lastErr != nil {
+		st.LastError = w.lastErr.Error()
+	}
+	for i := range w.pending[:min(len(w.pending), writebackListed)] {
+		st.Changes = append(st.Changes, w.pending[i].change())
+	}
+	for i := len(w.failures) - 1; i >= 0; i-- {
+		st.Failures = append(st.Failures, w.failures[i])
+	}
+	return st
+}
+
+// Changes
+
+func (w *writebackFS) Create(path string) error {
+	return w.record(writebackRecord{Op: "create", Path: path})
+}
+
+func (w *writebackFS) Mkdir(path string, perm uint32) error {
+	return w.record(writebackRecord{Op: "mkdir", Path: path, Mode: perm})
+}
+
+func (w *writebackFS) Remove(path string) error {
+	return w.record(writebackRecord{Op: "remove", Path: path})
+}
+
+func (w *writebackFS) RemoveAll(path string) error {
+	return w.record(writebackRecord{Op: "removeall", Path: path})
+}
+
+func (w *writebackFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	// Cloned, as the caller may reuse its buffer
+	rec := writebackRecord{Op: "write", Path: path, Data: bytes.Clone(data), Offset: offset, Flags: flags}
+	if err := w.record(rec); err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+func (w *writebackFS) Rename(oldPath, newPath string) error {
+	return w.record(writebackRecord{Op: "rename", Path: oldPath, NewPath: newPath})
+}
+
+func (w *writebackFS) Chmod(path string, mode uint32) error {
+	return w.record(writebackRecord{Op: "chmod", Path: path, Mode: mode})
+}
+
+func (w *writebackFS) Truncate(path string, size int64) error {
+	if _, ok := filesystem.As[filesystem.Truncater](w.FileSystem); !ok {
+		return filesystem.NewNotSupportedError("truncate", path)
+	}
+	return w.record(writebackRecord{Op: "truncate", Path: path, Size: size})
+}
+
+// OpenWrite buffers what is written, which is journaled as a whole when
+// the writer is closed
+func (w *writebackFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return &writebackWriter{w: w, path: path}, nil
+}
+
+type writebackWriter struct {
+	bytes.Buffer
+	w    *writebackFS
+	path string
+}
+
+func (ww *writebackWriter) Close() error {
+	_, err := ww.w.Write(ww.path, ww.Bytes(), 0, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate)
+	return err
+}
+
+// Reads
+
+func (w *writebackFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	if err := w.wait(path); err != nil {
+		return nil, err
+	}
+	return w.FileSystem.Read(path, offset, size)
+}
+
+func (w *writebackFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	if err := w.wait(path); err != nil {
+		return nil, err
+	}
+	return w.FileSystem.ReadDir(path)
+}
+
+func (w *writebackFS) Stat(path string) (*filesystem.FileInfo, error) {
+	if err := w.wait(path); err != nil {
+		return nil, err
+	}
+	return w.FileSystem.Stat(path)
+}
+
+func (w *writebackFS) Open(path string) (io.ReadCloser, error) {
+	if err := w.wait(path); err != nil {
+		return nil, err
+	}
+	return w.FileSystem.Open(path)
+}
+
+// Writebacks returns the state of the write-back of all mounts that have
+// it, ordered by mount path
+func (mfs *MountableFS) Writebacks() []WritebackStatus {
+	var statuses []WritebackStatus
+	for _, mount := range mfs.GetMounts() {
+		if mount.writeback == nil {
+			continue
+		}
+		s := mount.writeback.status()
+		s.Path = mount.Path
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Path < statuses[j].Path })
+	return statuses
+}
@@ -0,0 +1,214 @@
+package mountablefs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestParseWritebackOptions(t *testing.T) {
+	opts, rest, err := ParseMountOptions(map[string]interface{}{
+		WritebackConfigKey: map[string]interface{}{"journal": "/var/lib/agfs/wal/", "max_backoff": "5s"},
+	})
+	if err != nil {
+		t.Fatalf("ParseMountOptions() error = %v", err)
+	}
+	if _, ok := rest[WritebackConfigKey]; ok {
+		t.Errorf("plugin config = %v", rest)
+	}
+	if w := opts.Writeback; w == nil || w.Journal != "/var/lib/agfs/wal" || w.MaxBackoff != 5*time.Second {
+		t.Errorf("writeback options = %+v", opts.Writeback)
+	}
+
+	for _, bad := range []interface{}{
+		"/var/lib/agfs/wal",
+		map[string]interface{}{},
+		map[string]interface{}{"journal": "/wal", "max_backoff": "1ms"},
+		map[string]interface{}{"journal": "/wal", "max_backoff": 30},
+		map[string]interface{}{"journal": "/wal", "fsync": false},
+	} {
+		if _, _, err := ParseMountOptions(map[string]interface{}{WritebackConfigKey: bad}); err == nil {
+			t.Errorf("ParseMountOptions(%v) succeeded", bad)
+		}
+	}
+}
+
+// outageFS fails every change while its backend is down
+type outageFS struct {
+	filesystem.FileSystem
+	down atomic.Bool
+}
+
+var errOutage = errors.New("dial tcp: connection refused")
+
+func (fs *outageFS) Mkdir(path string, perm uint32) error {
+	if fs.down.Load() {
+		return errOutage
+	}
+	return fs.FileSystem.Mkdir(path, perm)
+}
+
+func (fs *outageFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	if fs.down.Load() {
+		return 0, errOutage
+	}
+	return fs.FileSystem.Write(path, data, offset, flags)
+}
+
+func newOutageFS(t *testing.T) *outageFS {
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	return &outageFS{FileSystem: p.GetFileSystem()}
+}
+
+// eventually polls cond until it holds
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWriteback(t *testing.T) {
+	backend := newOutageFS(t)
+	backend.down.Store(true)
+	opts := &WritebackOptions{Journal: t.TempDir(), MaxBackoff: writebackMinBackoff}
+	w, err := openWritebackFS(backend, opts)
+	if err != nil {
+		t.Fatalf("openWritebackFS() error = %v", err)
+	}
+
+	// Changes are acknowledged while the backend is down
+	if err := w.Mkdir("/docs", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	buf := []byte("hello")
+	if n, err := w.Write("/docs/a.txt", buf, 0, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil || n != 5 {
+		t.Fatalf("Write() = %d, %v", n, err)
+	}
+	copy(buf, "xxxxx") // The journal keeps its own copy
+	eventually(t, "a failed attempt", func() bool { return w.status().LastError != "" })
+	if st := w.status(); st.Pending != 2 || len(st.Changes) != 2 || st.Changes[1].Op != "write" || st.Changes[1].Path != "/docs/a.txt" {
+		t.Fatalf("status = %+v", st)
+	}
+
+	// Reads of changed paths fail rather than return what the backend has
+	if _, err := w.Read("/docs/a.txt", 0, -1); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("Read() error = %v, want the backend's error", err)
+	}
+	if _, err := w.ReadDir("/"); err == nil {
+		t.Fatal("ReadDir(/) succeeded with changes under it pending")
+	}
+
+	// A restart replays the journal, without the record cut short by a crash
+	w.close()
+	journal, err := os.OpenFile(filepath.Join(opts.Journal, writebackJournalFile), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.WriteString(`{"seq":3,"op":"mkd`)
+	journal.Close()
+	backend.down.Store(false)
+	w, err = openWritebackFS(backend, opts)
+	if err != nil {
+		t.Fatalf("openWritebackFS() error = %v", err)
+	}
+	defer w.close()
+	if data, err := w.Read("/docs/a.txt", 0, -1); (err != nil && err != io.EOF) || string(data) != "hello" {
+		t.Fatalf("Read() = %q, %v", data, err)
+	}
+	if st := w.status(); st.Pending != 0 || st.Applied != 2 || st.LastError != "" {
+		t.Fatalf("status = %+v", st)
+	}
+
+	// Changes the backend rejects are dropped, not retried
+	if _, err := w.Write("/missing/b.txt", []byte("x"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := w.Write("/docs/c.txt", []byte("after"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if data, err := w.Read("/docs/c.txt", 0, -1); (err != nil && err != io.EOF) || string(data) != "after" {
+		t.Fatalf("Read() = %q, %v", data, err)
+	}
+	if st := w.status(); st.Failed != 1 || len(st.Failures) != 1 || st.Failures[0].Path != "/missing/b.txt" {
+		t.Fatalf("status = %+v", st)
+	}
+
+	// The checkpoint keeps applied changes from being replayed, and the
+	// journal is emptied once they all are
+	eventually(t, "the journal to be compacted", func() bool {
+		info, err := os.Stat(filepath.Join(opts.Journal, writebackJournalFile))
+		return err == nil && info.Size() == 0
+	})
+	applied, err := readWritebackCheckpoint(filepath.Join(opts.Journal, writebackCheckpointFile))
+	if err != nil || applied != 4 {
+		t.Fatalf("checkpoint = %d, %v, want 4", applied, err)
+	}
+}
+
+func TestWritebackMount(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	journal := t.TempDir()
+	for _, path := range []string{"/a", "/b"} {
+		p := memfs.NewMemFSPlugin()
+		if err := p.Initialize(map[string]interface{}{}); err != nil {
+			t.Fatalf("Initialize() error = %v", err)
+		}
+		err := mfs.MountWithOptions(path, p, MountOptions{Writeback: &WritebackOptions{Journal: journal, MaxBackoff: time.Second}})
+		if path == "/b" {
+			// Mounts can't share a journal
+			if err == nil {
+				t.Fatal("second mount on the same journal succeeded")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("MountWithOptions(%s) error = %v", path, err)
+		}
+	}
+
+	if _, err := mfs.Write("/a/f.txt", []byte("data"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if data, err := mfs.Read("/a/f.txt", 0, -1); (err != nil && err != io.EOF) || string(data) != "data" {
+		t.Fatalf("Read() = %q, %v", data, err)
+	}
+	statuses := mfs.Writebacks()
+	if len(statuses) != 1 || statuses[0].Path != "/a" || statuses[0].Applied != 1 {
+		t.Fatalf("Writebacks() = %+v", statuses)
+	}
+
+	// Hidden so that random writes can't bypass the journal
+	mount, _, _ := mfs.findMount("/a")
+	if _, ok := filesystem.As[filesystem.RandomWriter](mount.fileSystem()); ok {
+		t.Error("RandomWriter is reachable through the write-back")
+	}
+
+	// Unmounting releases the journal
+	if err := mfs.Unmount("/a"); err != nil {
+		t.Fatalf("Unmount() error = %v", err)
+	}
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	if err := mfs.MountWithOptions("/b", p, MountOptions{Writeback: &WritebackOptions{Journal: journal, MaxBackoff: time.Second}}); err != nil {
+		t.Fatalf("MountWithOptions(/b) error = %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+// Package migrate applies the versioned schema migrations of plugins that
+// keep their state in a SQL database. The versions applied are recorded per
+// plugin in a shared table, so that each migration runs once per database
+// however many servers start against it, and schema changes roll forward
+// across releases.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Table records the migrations applied to a database, per plugin
+const Table = "agfs_schema_migrations"
+
+// LockTimeout bounds how long Apply waits for another server migrating the
+// same plugin
+var LockTimeout = time.Minute
+
+// Dialect is the SQL dialect of a database
+type Dialect int
+
+const (
+	SQLite Dialect = iota
+	MySQL          // MySQL and TiDB
+	Postgres
+)
+
+func (d Dialect) placeholder(n int) string {
+	if d == Postgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+// Migration is one change to the schema owned by a plugin. Up runs in a
+// transaction, which also records the migration. MySQL and TiDB commit DDL
+// statements implicitly, so Up must tolerate running again after failing
+// halfway, e.g. with IF NOT EXISTS.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *sql.Tx) error
+}
+
+// Apply runs the migrations of plugin that the database hasn't applied yet,
+// in order. Migrations must be listed by increasing version, and released
+// ones must not change: schema changes are new migrations at the end.
+func Apply(db *sql.DB, dialect Dialect, plugin string, migrations []Migration) error {
+	for i, m := range migrations {
+		if m.Version <= 0 || (i > 0 && m.Version <= migrations[i-1].Version) {
+			return fmt.Errorf("migrations of %s must have increasing positive versions, got %d", plugin, m.Version)
+		}
+	}
+
+	unlock, err := lock(db, dialect, plugin)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := createTable(db); err != nil {
+		return err
+	}
+	applied, err := appliedVersions(db, dialect, plugin)
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 && len(migrations) > 0 {
+		if latest, known := applied[len(applied)-1], migrations[len(migrations)-1].Version; latest > known {
+			log.Warnf("[migrate] Schema of %s is at version %d, newer than this server knows (%d)", plugin, latest, known)
+		}
+	}
+
+	done := make(map[int]bool, len(applied))
+	for _, version := range applied {
+		done[version] = true
+	}
+	for _, m := range migrations {
+		if done[m.Version] {
+			continue
+		}
+		ran, err := apply(db, dialect, plugin, m)
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d of %s (%s): %w", m.Version, plugin, m.Description, err)
+		}
+		if ran {
+			log.Infof("[migrate] Applied migration %d of %s: %s", m.Version, plugin, m.Description)
+		}
+	}
+	return nil
+}
+
+// createTable creates the migrations table if missing. The schema is the
+// same for every dialect.
+func createTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			plugin VARCHAR(64) NOT NULL,
+			version INTEGER NOT NULL,
+			description VARCHAR(255) NOT NULL,
+			applied_at BIGINT NOT NULL,
+			PRIMARY KEY (plugin, version)
+		)`, Table))
+	if err != nil {
+		return fmt.Errorf("failed to create schema migrations table: %w", err)
+	}
+	return nil
+}
+
+// appliedVersions returns the versions of plugin applied, in order
+func appliedVersions(db *sql.DB, dialect Dialect, plugin string) ([]int, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT version FROM %s WHERE plugin = %s ORDER BY version",
+		Table, dialect.placeholder(1)), plugin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema migrations of %s: %w", plugin, err)
+	}
+	defer rows.Close()
+	var versions []int
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// apply runs a migration and records it, reporting false if another server
+// applied it first
+func apply(db *sql.DB, dialect Dialect, plugin string, m Migration) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	record := func() error {
+		_, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (plugin, version, description, applied_at) VALUES (%s, %s, %s, %s)",
+			Table, dialect.placeholder(1), dialect.placeholder(2), dialect.placeholder(3), dialect.placeholder(4)),
+			plugin, m.Version, m.Description, time.Now().Unix())
+		return err
+	}
+
+	if dialect == SQLite {
+		// SQLite has no advisory locks: recording the migration first takes
+		// the write lock of the database until it commits, and fails if
+		// another process applied it meanwhile
+		if err := record(); err != nil {
+			tx.Rollback()
+			if versions, readErr := appliedVersions(db, dialect, plugin); readErr == nil {
+				for _, version := range versions {
+					if version == m.Version {
+						return false, nil
+					}
+				}
+			}
+			return false, err
+		}
+	}
+	if err := m.Up(tx); err != nil {
+		return false, err
+	}
+	if dialect != SQLite {
+		if err := record(); err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lock keeps other servers from migrating plugin until the returned func is
+// called. MySQL, TiDB and Postgres hold a named lock on a connection of
+// their own; SQLite is locked by each migration's transaction instead.
+func lock(db *sql.DB, dialect Dialect, plugin string) (func(), error) {
+	if dialect == SQLite {
+		return func() {}, nil
+	}
+	name := "agfs_migrate_" + plugin
+	ctx, cancel := context.WithTimeout(context.Background(), LockTimeout)
+	defer cancel()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock schema of %s: %w", plugin, err)
+	}
+
+	var release func()
+	switch dialect {
+	case MySQL:
+		var locked sql.NullInt64
+		err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, int(LockTimeout.Seconds())).Scan(&locked)
+		if err == nil && locked.Int64 != 1 {
+			err = fmt.Errorf("timed out after %v waiting for another server", LockTimeout)
+		}
+		release = func() {
+			conn.QueryRowContext(context.Background(), "SELECT RELEASE_LOCK(?)", name).Scan(new(sql.NullInt64))
+		}
+	case Postgres:
+		h := fnv.New64a()
+		h.Write([]byte(name))
+		key := int64(h.Sum64())
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key)
+		release = func() {
+			conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+		}
+	default:
+		err = fmt.Errorf("unknown SQL dialect %d", dialect)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to lock schema of %s: %w", plugin, err)
+	}
+	return func() {
+		release()
+		conn.Close()
+	}, nil
+}
@@ -0,0 +1,111 @@
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openTestDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// logging returns a migration that records its version in the log table
+func logging(version int) Migration {
+	return Migration{Version: version, Description: "log", Up: func(tx *sql.Tx) error {
+		if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS log (version INTEGER)"); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT INTO log (version) VALUES (?)", version)
+		return err
+	}}
+}
+
+func countLog(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM log").Scan(&n); err != nil {
+		t.Fatalf("count log: %v", err)
+	}
+	return n
+}
+
+func TestApply(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "test.db"))
+
+	if err := Apply(db, SQLite, "test", []Migration{logging(1), logging(2)}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if err := Apply(db, SQLite, "test", []Migration{logging(1), logging(2), logging(3)}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if n := countLog(t, db); n != 3 {
+		t.Errorf("ran %d migrations, want 3", n)
+	}
+
+	// A failed migration is rolled back and retried on the next start
+	failing := Migration{Version: 4, Description: "fail", Up: func(tx *sql.Tx) error {
+		tx.Exec("INSERT INTO log (version) VALUES (4)")
+		return errors.New("boom")
+	}}
+	if err := Apply(db, SQLite, "test", []Migration{logging(1), logging(2), logging(3), failing}); err == nil {
+		t.Fatal("Apply() with a failing migration succeeded")
+	}
+	if n := countLog(t, db); n != 3 {
+		t.Errorf("failed migration left %d log rows, want 3", n)
+	}
+	if err := Apply(db, SQLite, "test", []Migration{logging(1), logging(2), logging(3), logging(4)}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if versions, _ := appliedVersions(db, SQLite, "test"); len(versions) != 4 || versions[3] != 4 {
+		t.Errorf("applied versions = %v", versions)
+	}
+
+	// Versions are per plugin
+	if err := Apply(db, SQLite, "other", []Migration{logging(1)}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if n := countLog(t, db); n != 5 {
+		t.Errorf("ran %d migrations, want 5", n)
+	}
+
+	if err := Apply(db, SQLite, "test", []Migration{logging(2), logging(1)}); err == nil {
+		t.Error("Apply() accepted unordered migrations")
+	}
+}
+
+func TestApplyConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	migrations := []Migration{logging(1), logging(2), logging(3)}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		db := openTestDB(t, path)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Apply(db, SQLite, "test", migrations)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+	}
+	if n := countLog(t, openTestDB(t, path)); n != 3 {
+		t.Errorf("ran %d migrations, want each once", n)
+	}
+}
@@ -0,0 +1,632 @@
+package execfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PluginName = "execfs"
+
+	runFileName = "run"
+	jobsDirName = "jobs"
+)
+
+// Defaults for the sandbox configuration
+const (
+	defaultSearchPath    = "/usr/local/bin:/usr/bin:/bin"
+	defaultTimeout       = 30 * time.Second
+	defaultMaxOutput     = 1024 * 1024
+	defaultMaxMemory     = 1024 * 1024 * 1024
+	defaultMaxOpenFiles  = 256
+	defaultMaxConcurrent = 4
+	defaultMaxJobs       = 100
+)
+
+// jobFiles are the files of a job directory
+var jobFiles = []string{"cmd", "status", "stdout", "stderr", "exit_code"}
+
+// ExecFSPlugin runs allowlisted commands in a sandbox
+type ExecFSPlugin struct {
+	runner     *runner
+	tempDir    string // Work directory created by Initialize, removed by Shutdown
+	fileSystem *execFS
+}
+
+// NewExecFSPlugin creates a new ExecFS plugin
+func NewExecFSPlugin() *ExecFSPlugin {
+	return &ExecFSPlugin{}
+}
+
+func (p *ExecFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *ExecFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"allowed_commands", "path", "work_dir", "env", "timeout", "cpu_seconds",
+		"max_memory", "max_open_files", "max_output", "allow_network", "max_concurrent", "max_jobs", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+
+	if _, err := sandboxFromConfig(cfg); err != nil {
+		return err
+	}
+
+	for _, key := range []string{"path", "work_dir", "timeout"} {
+		if err := config.ValidateStringType(cfg, key); err != nil {
+			return err
+		}
+	}
+	for _, key := range []string{"cpu_seconds", "max_open_files", "max_concurrent", "max_jobs"} {
+		if err := config.ValidateIntType(cfg, key); err != nil {
+			return err
+		}
+		if config.GetIntConfig(cfg, key, 0) < 0 {
+			return fmt.Errorf("%s must not be negative", key)
+		}
+	}
+	for _, key := range []string{"max_concurrent", "max_jobs"} {
+		if config.GetIntConfig(cfg, key, 1) < 1 {
+			return fmt.Errorf("%s must be at least 1", key)
+		}
+	}
+	if err := config.ValidateBoolType(cfg, "allow_network"); err != nil {
+		return err
+	}
+	if err := config.ValidateMapType(cfg, "env"); err != nil {
+		return err
+	}
+	for _, key := range []string{"max_memory", "max_output"} {
+		if _, err := config.GetSizeConfig(cfg, key, 0); err != nil {
+			return err
+		}
+	}
+	if !networkIsolation && !config.GetBoolConfig(cfg, "allow_network", false) {
+		return fmt.Errorf("network isolation is only supported on Linux; set allow_network: true to run commands with network access")
+	}
+	return nil
+}
+
+// sandboxFromConfig builds the sandbox described by cfg. work_dir is left
+// empty when not configured.
+func sandboxFromConfig(cfg map[string]interface{}) (*Sandbox, error) {
+	s := &Sandbox{
+		SearchPath:   filepath.SplitList(config.GetStringConfig(cfg, "path", defaultSearchPath)),
+		WorkDir:      config.GetStringConfig(cfg, "work_dir", ""),
+		Env:          make(map[string]string),
+		Timeout:      defaultTimeout,
+		CPUSeconds:   config.GetIntConfig(cfg, "cpu_seconds", 0),
+		MaxOpenFiles: config.GetIntConfig(cfg, "max_open_files", defaultMaxOpenFiles),
+		AllowNetwork: config.GetBoolConfig(cfg, "allow_network", false),
+	}
+
+	switch commands := cfg["allowed_commands"].(type) {
+	case []string:
+		s.Allowed = commands
+	case []interface{}:
+		for _, c := range commands {
+			name, ok := c.(string)
+			if !ok {
+				return nil, fmt.Errorf("allowed_commands must be an array of strings")
+			}
+			s.Allowed = append(s.Allowed, name)
+		}
+	case string:
+		for _, name := range strings.Split(commands, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				s.Allowed = append(s.Allowed, name)
+			}
+		}
+	}
+	if len(s.Allowed) == 0 {
+		return nil, fmt.Errorf("allowed_commands is required and must list at least one command")
+	}
+	for _, name := range s.Allowed {
+		if strings.Contains(name, "/") && !filepath.IsAbs(name) {
+			return nil, fmt.Errorf("allowed_commands entry %q must be a command name or an absolute path", name)
+		}
+	}
+	for _, dir := range s.SearchPath {
+		if !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("path entry %q must be absolute", dir)
+		}
+	}
+
+	if timeoutStr := config.GetStringConfig(cfg, "timeout", ""); timeoutStr != "" {
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q: must be a positive duration", timeoutStr)
+		}
+		s.Timeout = timeout
+	}
+
+	maxMemory, err := config.GetSizeConfig(cfg, "max_memory", defaultMaxMemory)
+	if err != nil {
+		return nil, err
+	}
+	s.MaxMemory = maxMemory
+
+	if env, ok := cfg["env"].(map[string]interface{}); ok {
+		for k, v := range env {
+			s.Env[k] = fmt.Sprint(v)
+		}
+	}
+	return s, nil
+}
+
+func (p *ExecFSPlugin) Initialize(cfg map[string]interface{}) error {
+	sandbox, err := sandboxFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if sandbox.WorkDir == "" {
+		dir, err := os.MkdirTemp("", "execfs-")
+		if err != nil {
+			return fmt.Errorf("failed to create work directory: %w", err)
+		}
+		p.tempDir = dir
+		sandbox.WorkDir = dir
+	} else if err := os.MkdirAll(sandbox.WorkDir, 0755); err != nil {
+		return fmt.Errorf("failed to create work directory: %w", err)
+	}
+
+	maxOutput, err := config.GetSizeConfig(cfg, "max_output", defaultMaxOutput)
+	if err != nil {
+		return err
+	}
+	p.runner = newRunner(sandbox, maxOutput,
+		config.GetIntConfig(cfg, "max_concurrent", defaultMaxConcurrent),
+		config.GetIntConfig(cfg, "max_jobs", defaultMaxJobs))
+	p.fileSystem = &execFS{plugin: p}
+
+	log.Infof("[execfs] Initialized with %d allowlisted commands (allow_network: %v)", len(sandbox.Allowed), sandbox.AllowNetwork)
+	return nil
+}
+
+func (p *ExecFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fileSystem
+}
+
+func (p *ExecFSPlugin) GetReadme() string {
+	return `ExecFS Plugin - Sandboxed Command Execution
+
+This plugin runs allowlisted commands and exposes their output as files.
+
+STRUCTURE:
+  /README           - This file
+  /run              - Write a command line to run it and wait for it to finish
+  /jobs/<id>/       - One directory per command
+    cmd             - The command line (write here to start a named job)
+    status          - JSON: status, exit code, timestamps, output sizes
+    stdout          - Captured standard output (up to max_output)
+    stderr          - Captured standard error (up to max_output)
+    exit_code       - Exit code once the command has exited
+
+USAGE:
+  Run a command and read its output:
+    echo "ls -la" > /execfs/run
+    cat /execfs/run                # Status of the last run, including its id
+    cat /execfs/jobs/1/stdout
+
+  Start a named job in the background:
+    mkdir /execfs/jobs/build
+    echo "make -j4" > /execfs/jobs/build/cmd
+    cat /execfs/jobs/build/status  # pending, running, exited, timeout, killed or failed
+
+  Kill a running job, or discard a finished one:
+    rm -r /execfs/jobs/build
+
+SANDBOX:
+  - Only commands listed in allowed_commands can run; names are looked up
+    in the sandbox path, absolute paths must be listed exactly
+  - Command lines are split like a shell would split them, but never run
+    through one: pipes, redirection, globbing and expansion are rejected
+  - The environment is not inherited; commands get PATH, HOME and LANG
+    plus the configured env
+  - Every command has a wall-clock timeout and resource limits
+    (address space, open files, optionally CPU time)
+  - On Linux, commands run in their own network namespace with no
+    interfaces unless allow_network is set
+  - Commands run as the server user and see its file system; mount the
+    server in a container or as an unprivileged user for file isolation
+
+VERSION: 1.0.0
+`
+}
+
+func (p *ExecFSPlugin) GetConfigParams() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{
+			Name:        "allowed_commands",
+			Type:        "array",
+			Required:    true,
+			Default:     "",
+			Description: "Command names or absolute paths that may be run",
+		},
+		{
+			Name:        "path",
+			Type:        "string",
+			Required:    false,
+			Default:     defaultSearchPath,
+			Description: "Directories searched for allowlisted command names",
+		},
+		{
+			Name:        "work_dir",
+			Type:        "string",
+			Required:    false,
+			Default:     "",
+			Description: "Working directory of every command (default: a private temporary directory)",
+		},
+		{
+			Name:        "env",
+			Type:        "map",
+			Required:    false,
+			Default:     "",
+			Description: "Extra environment variables; nothing is inherited from the server",
+		},
+		{
+			Name:        "timeout",
+			Type:        "string",
+			Required:    false,
+			Default:     "30s",
+			Description: "Wall-clock limit per command",
+		},
+		{
+			Name:        "cpu_seconds",
+			Type:        "int",
+			Required:    false,
+			Default:     "0",
+			Description: "CPU time limit per command (0 for none)",
+		},
+		{
+			Name:        "max_memory",
+			Type:        "string",
+			Required:    false,
+			Default:     "1GB",
+			Description: "Address space limit per command (0 for none)",
+		},
+		{
+			Name:        "max_open_files",
+			Type:        "int",
+			Required:    false,
+			Default:     "256",
+			Description: "Open file limit per command (0 for none)",
+		},
+		{
+			Name:        "max_output",
+			Type:        "string",
+			Required:    false,
+			Default:     "1MB",
+			Description: "Output kept per stream; the rest is counted and dropped",
+		},
+		{
+			Name:        "allow_network",
+			Type:        "bool",
+			Required:    false,
+			Default:     "false",
+			Description: "Give commands the server's network instead of an isolated namespace",
+		},
+		{
+			Name:        "max_concurrent",
+			Type:        "int",
+			Required:    false,
+			Default:     "4",
+			Description: "Commands that may run at the same time",
+		},
+		{
+			Name:        "max_jobs",
+			Type:        "int",
+			Required:    false,
+			Default:     "100",
+			Description: "Jobs kept; the oldest finished job is discarded beyond this",
+		},
+	}
+}
+
+func (p *ExecFSPlugin) Shutdown() error {
+	if p.runner != nil {
+		p.runner.stop()
+	}
+	if p.tempDir != "" {
+		if err := os.RemoveAll(p.tempDir); err != nil {
+			return fmt.Errorf("failed to remove work directory: %w", err)
+		}
+		p.tempDir = ""
+	}
+	return nil
+}
+
+// execFS exposes the runner as a file system
+type execFS struct {
+	plugin *ExecFSPlugin
+}
+
+// splitPath splits a path into the job ID and file name for paths inside
+// the jobs directory
+func splitPath(path string) (top, id, file string) {
+	parts := strings.SplitN(strings.Trim(filesystem.NormalizePath(path), "/"), "/", 3)
+	top = parts[0]
+	if len(parts) > 1 {
+		id = parts[1]
+	}
+	if len(parts) > 2 {
+		file = parts[2]
+	}
+	return top, id, file
+}
+
+func (fs *execFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	top, id, file := splitPath(path)
+	switch {
+	case top == "README" && id == "":
+		return plugin.ApplyRangeRead([]byte(fs.plugin.GetReadme()), offset, size)
+	case top == runFileName && id == "":
+		job := fs.plugin.runner.lastJob()
+		if job == nil {
+			return plugin.ApplyRangeRead(nil, offset, size)
+		}
+		return fs.readJobFile(job, "status", offset, size)
+	case top == jobsDirName && file != "":
+		job := fs.plugin.runner.get(id)
+		if job == nil {
+			return nil, filesystem.NewNotFoundError("read", path)
+		}
+		return fs.readJobFile(job, file, offset, size)
+	case top == "" || top == jobsDirName:
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+	return nil, filesystem.NewNotFoundError("read", path)
+}
+
+func (fs *execFS) readJobFile(job *Job, file string, offset, size int64) ([]byte, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	var data []byte
+	switch file {
+	case "cmd":
+		if job.command != "" {
+			data = []byte(job.command + "\n")
+		}
+	case "status":
+		jsonData, err := json.MarshalIndent(job.statusInfo(), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		data = append(jsonData, '\n')
+	case "stdout":
+		data = job.stdout.bytes()
+	case "stderr":
+		data = job.stderr.bytes()
+	case "exit_code":
+		if job.status == JobStatusExited {
+			data = []byte(strconv.Itoa(job.exitCode) + "\n")
+		}
+	default:
+		return nil, filesystem.NewNotFoundError("read", "/"+jobsDirName+"/"+job.id+"/"+file)
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+func (fs *execFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	command := strings.TrimSpace(string(data))
+	top, id, file := splitPath(path)
+	switch {
+	case top == runFileName && id == "":
+		// Non-zero exit codes are reported through the job, not as errors
+		if _, err := fs.plugin.runner.run(command); err != nil {
+			return 0, err
+		}
+		return int64(len(data)), nil
+	case top == jobsDirName && file == "cmd":
+		job := fs.plugin.runner.get(id)
+		if job == nil {
+			return 0, filesystem.NewNotFoundError("write", path)
+		}
+		if err := fs.plugin.runner.start(job, command); err != nil {
+			return 0, err
+		}
+		return int64(len(data)), nil
+	}
+	return 0, filesystem.NewPermissionDeniedError("write", path, "only run and jobs/<id>/cmd are writable")
+}
+
+func (fs *execFS) Stat(path string) (*filesystem.FileInfo, error) {
+	top, id, file := splitPath(path)
+	now := time.Now()
+	switch {
+	case top == "":
+		return &filesystem.FileInfo{Name: "/", Mode: 0755, ModTime: now, IsDir: true,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "directory"}}, nil
+	case top == "README" && id == "":
+		return &filesystem.FileInfo{Name: "README", Size: int64(len(fs.plugin.GetReadme())), Mode: 0444, ModTime: now,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "doc"}}, nil
+	case top == runFileName && id == "":
+		return &filesystem.FileInfo{Name: runFileName, Mode: 0666, ModTime: now,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "control"}}, nil
+	case top == jobsDirName && id == "":
+		return &filesystem.FileInfo{Name: jobsDirName, Mode: 0755, ModTime: now, IsDir: true,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "jobs"}}, nil
+	case top == jobsDirName:
+		job := fs.plugin.runner.get(id)
+		if job == nil {
+			return nil, filesystem.NewNotFoundError("stat", path)
+		}
+		return statJob(job, file)
+	}
+	return nil, filesystem.NewNotFoundError("stat", path)
+}
+
+// statJob describes a job directory (file == "") or one of its files
+func statJob(job *Job, file string) (*filesystem.FileInfo, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	modTime := job.createdAt
+	if !job.finishedAt.IsZero() {
+		modTime = job.finishedAt
+	}
+	info := &filesystem.FileInfo{
+		Name:    file,
+		Mode:    0444,
+		ModTime: modTime,
+		Meta:    filesystem.MetaData{Name: PluginName, Type: "job-" + file},
+	}
+	switch file {
+	case "":
+		info.Name = job.id
+		info.Mode = 0755
+		info.IsDir = true
+		info.Meta.Type = "job"
+	case "cmd":
+		info.Mode = 0644
+		if job.command != "" {
+			info.Size = int64(len(job.command) + 1)
+		}
+	case "stdout":
+		info.Size = int64(len(job.stdout.bytes()))
+	case "stderr":
+		info.Size = int64(len(job.stderr.bytes()))
+	case "status", "exit_code":
+		// Generated on read
+	default:
+		return nil, filesystem.NewNotFoundError("stat", "/"+jobsDirName+"/"+job.id+"/"+file)
+	}
+	return info, nil
+}
+
+func (fs *execFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	top, id, file := splitPath(path)
+	switch {
+	case top == "":
+		var infos []filesystem.FileInfo
+		for _, name := range []string{"README", runFileName, jobsDirName} {
+			info, err := fs.Stat("/" + name)
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, *info)
+		}
+		return infos, nil
+	case top == jobsDirName && id == "":
+		var infos []filesystem.FileInfo
+		for _, jobID := range fs.plugin.runner.list() {
+			if job := fs.plugin.runner.get(jobID); job != nil {
+				if info, err := statJob(job, ""); err == nil {
+					infos = append(infos, *info)
+				}
+			}
+		}
+		return infos, nil
+	case top == jobsDirName && file == "":
+		job := fs.plugin.runner.get(id)
+		if job == nil {
+			return nil, filesystem.NewNotFoundError("readdir", path)
+		}
+		var infos []filesystem.FileInfo
+		for _, name := range jobFiles {
+			info, err := statJob(job, name)
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, *info)
+		}
+		return infos, nil
+	}
+	if _, err := fs.Stat(path); err != nil {
+		return nil, err
+	}
+	return nil, filesystem.NewNotDirectoryError(path)
+}
+
+// Mkdir creates a pending job that starts when its cmd file is written
+func (fs *execFS) Mkdir(path string, perm uint32) error {
+	top, id, file := splitPath(path)
+	if top != jobsDirName || id == "" || file != "" {
+		return filesystem.NewPermissionDeniedError("mkdir", path, "only jobs/<id> directories can be created")
+	}
+	_, err := fs.plugin.runner.create(id)
+	return err
+}
+
+// Create accepts the writable files so that shell redirection works
+func (fs *execFS) Create(path string) error {
+	top, id, file := splitPath(path)
+	switch {
+	case top == runFileName && id == "":
+		return nil
+	case top == jobsDirName && file == "cmd" && fs.plugin.runner.get(id) != nil:
+		return nil
+	}
+	return filesystem.NewPermissionDeniedError("create", path, "files cannot be created in execfs")
+}
+
+func (fs *execFS) Remove(path string) error {
+	top, id, file := splitPath(path)
+	if top != jobsDirName || id == "" || file != "" {
+		return filesystem.NewPermissionDeniedError("remove", path, "only jobs/<id> directories can be removed")
+	}
+	return fs.plugin.runner.remove(id)
+}
+
+func (fs *execFS) RemoveAll(path string) error {
+	return fs.Remove(path)
+}
+
+func (fs *execFS) Rename(oldPath, newPath string) error {
+	return filesystem.NewNotSupportedError("rename", oldPath)
+}
+
+func (fs *execFS) Chmod(path string, mode uint32) error {
+	return filesystem.NewNotSupportedError("chmod", path)
+}
+
+// Truncate is a no-op so that shell redirection works
+func (fs *execFS) Truncate(path string, size int64) error {
+	return nil
+}
+
+func (fs *execFS) Open(path string) (io.ReadCloser, error) {
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (fs *execFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return &execWriter{fs: fs, path: path}, nil
+}
+
+// execWriter buffers a command line and submits it on Close
+type execWriter struct {
+	fs   *execFS
+	path string
+	buf  bytes.Buffer
+}
+
+func (w *execWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *execWriter) Close() error {
+	_, err := w.fs.Write(w.path, w.buf.Bytes(), -1, filesystem.WriteFlagNone)
+	return err
+}
+
+// Ensure ExecFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*ExecFSPlugin)(nil)
+var _ filesystem.FileSystem = (*execFS)(nil)
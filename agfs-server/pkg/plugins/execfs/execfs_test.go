@@ -0,0 +1,239 @@
+package execfs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func newExecFSForTest(t *testing.T, cfg map[string]interface{}) (*ExecFSPlugin, filesystem.FileSystem) {
+	t.Helper()
+	if _, ok := cfg["allowed_commands"]; !ok {
+		cfg["allowed_commands"] = []interface{}{"echo", "sh", "sleep", "cat"}
+	}
+	p := NewExecFSPlugin()
+	if err := p.Validate(cfg); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if err := p.Initialize(cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	t.Cleanup(func() { p.Shutdown() })
+	return p, p.GetFileSystem()
+}
+
+func readString(t *testing.T, fs filesystem.FileSystem, path string) string {
+	t.Helper()
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read(%s) error = %v", path, err)
+	}
+	return string(data)
+}
+
+func readStatus(t *testing.T, fs filesystem.FileSystem, path string) JobStatusInfo {
+	t.Helper()
+	var info JobStatusInfo
+	if err := json.Unmarshal([]byte(readString(t, fs, path)), &info); err != nil {
+		t.Fatalf("status is not JSON: %v", err)
+	}
+	return info
+}
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{"ls -la /tmp", []string{"ls", "-la", "/tmp"}, false},
+		{`grep -E 'a|b' "my file.txt"`, []string{"grep", "-E", "a|b", "my file.txt"}, false},
+		{`echo "say \"hi\"" it\'s`, []string{"echo", `say "hi"`, "it's"}, false},
+		{"echo a~b", []string{"echo", "a~b"}, false},
+		{"ls | wc -l", nil, true},
+		{"echo hi > out", nil, true},
+		{"ls *.go", nil, true},
+		{"echo $HOME", nil, true},
+		{`echo "$(id)"`, nil, true},
+		{"cat ~/secret", nil, true},
+		{"echo 'unterminated", nil, true},
+		{"   ", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCommandLine(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseCommandLine(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("parseCommandLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestExecFSRun(t *testing.T) {
+	_, fs := newExecFSForTest(t, map[string]interface{}{})
+
+	if _, err := fs.Write("/run", []byte("echo hello 'big world'\n"), -1, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(run) error = %v", err)
+	}
+	info := readStatus(t, fs, "/run")
+	if info.Status != JobStatusExited || info.ExitCode == nil || *info.ExitCode != 0 {
+		t.Fatalf("status = %+v, want exited with 0", info)
+	}
+	if got := readString(t, fs, "/jobs/"+info.ID+"/stdout"); got != "hello big world\n" {
+		t.Fatalf("stdout = %q", got)
+	}
+
+	// A failing command is reported through its job, not the write
+	if _, err := fs.Write("/run", []byte(`sh -c "echo oops >&2; exit 3"`), -1, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(run) error = %v", err)
+	}
+	info = readStatus(t, fs, "/run")
+	if got := readString(t, fs, "/jobs/"+info.ID+"/exit_code"); got != "3\n" {
+		t.Fatalf("exit_code = %q, want 3", got)
+	}
+	if got := readString(t, fs, "/jobs/"+info.ID+"/stderr"); got != "oops\n" {
+		t.Fatalf("stderr = %q", got)
+	}
+
+	entries, err := fs.ReadDir("/jobs")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("ReadDir(jobs) = %v, %v; want two jobs", entries, err)
+	}
+}
+
+func TestExecFSRejectsCommands(t *testing.T) {
+	_, fs := newExecFSForTest(t, map[string]interface{}{})
+
+	tests := []struct {
+		command string
+		target  error
+	}{
+		{"rm -rf /", filesystem.ErrPermissionDenied},
+		{"/bin/echo hi", filesystem.ErrPermissionDenied},
+		{"../echo hi", filesystem.ErrPermissionDenied},
+		{"echo hi; rm -rf /", filesystem.ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		_, err := fs.Write("/run", []byte(tt.command), -1, filesystem.WriteFlagNone)
+		if !errors.Is(err, tt.target) {
+			t.Fatalf("Write(run, %q) error = %v, want %v", tt.command, err, tt.target)
+		}
+	}
+}
+
+func TestExecFSEnvironmentIsNotInherited(t *testing.T) {
+	t.Setenv("EXECFS_SECRET", "leaked")
+	_, fs := newExecFSForTest(t, map[string]interface{}{
+		"env": map[string]interface{}{"GREETING": "hi"},
+	})
+
+	if _, err := fs.Write("/run", []byte(`sh -c "echo $GREETING:$EXECFS_SECRET"`), -1, filesystem.WriteFlagNone); err == nil {
+		t.Fatal("$ inside double quotes should be rejected")
+	}
+	if _, err := fs.Write("/run", []byte(`sh -c 'echo "$GREETING:$EXECFS_SECRET"'`), -1, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(run) error = %v", err)
+	}
+	info := readStatus(t, fs, "/run")
+	if got := readString(t, fs, "/jobs/"+info.ID+"/stdout"); got != "hi:\n" {
+		t.Fatalf("stdout = %q, want %q", got, "hi:\n")
+	}
+}
+
+func TestExecFSTimeoutAndOutputLimit(t *testing.T) {
+	_, fs := newExecFSForTest(t, map[string]interface{}{
+		"timeout":    "200ms",
+		"max_output": 4,
+	})
+
+	start := time.Now()
+	if _, err := fs.Write("/run", []byte(`sh -c "echo 123456789; sleep 10"`), -1, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(run) error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("run took %v, timeout not enforced", elapsed)
+	}
+	info := readStatus(t, fs, "/run")
+	if info.Status != JobStatusTimedOut || info.ExitCode != nil {
+		t.Fatalf("status = %+v, want timeout", info)
+	}
+	if !info.Truncated || info.StdoutBytes != 10 {
+		t.Fatalf("status = %+v, want 10 stdout bytes, truncated", info)
+	}
+	if got := readString(t, fs, "/jobs/"+info.ID+"/stdout"); got != "1234" {
+		t.Fatalf("stdout = %q, want 1234", got)
+	}
+}
+
+func TestExecFSNamedJob(t *testing.T) {
+	_, fs := newExecFSForTest(t, map[string]interface{}{})
+
+	if err := fs.Mkdir("/jobs/nap", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if info := readStatus(t, fs, "/jobs/nap/status"); info.Status != JobStatusPending {
+		t.Fatalf("status = %+v, want pending", info)
+	}
+	if _, err := fs.Write("/jobs/nap/cmd", []byte("sleep 10"), -1, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(cmd) error = %v", err)
+	}
+	if info := readStatus(t, fs, "/jobs/nap/status"); info.Status != JobStatusRunning {
+		t.Fatalf("status = %+v, want running", info)
+	}
+	if _, err := fs.Write("/jobs/nap/cmd", []byte("echo again"), -1, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrAlreadyExists) {
+		t.Fatalf("second Write(cmd) error = %v, want already exists", err)
+	}
+	if err := fs.Mkdir("/jobs/nap", 0755); !errors.Is(err, filesystem.ErrAlreadyExists) {
+		t.Fatalf("second Mkdir() error = %v, want already exists", err)
+	}
+
+	if err := fs.RemoveAll("/jobs/nap"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if _, err := fs.Stat("/jobs/nap"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Fatalf("Stat() after remove error = %v, want not found", err)
+	}
+}
+
+func TestExecFSNetworkIsolation(t *testing.T) {
+	if !networkIsolation {
+		t.Skip("network isolation is not supported on this platform")
+	}
+	_, fs := newExecFSForTest(t, map[string]interface{}{})
+
+	// Only a loopback device exists in the command's namespace
+	if _, err := fs.Write("/run", []byte("cat /proc/net/dev"), -1, filesystem.WriteFlagNone); err != nil {
+		if strings.Contains(err.Error(), "operation not permitted") {
+			t.Skipf("user namespaces are not available: %v", err)
+		}
+		t.Fatalf("Write(run) error = %v", err)
+	}
+	info := readStatus(t, fs, "/run")
+	devices := strings.TrimSpace(readString(t, fs, "/jobs/"+info.ID+"/stdout"))
+	lines := strings.Split(devices, "\n")
+	if len(lines) != 3 || !strings.Contains(lines[2], "lo:") {
+		t.Fatalf("network devices = %q, want only lo", devices)
+	}
+}
+
+func TestExecFSValidate(t *testing.T) {
+	tests := []map[string]interface{}{
+		{},
+		{"allowed_commands": []interface{}{}},
+		{"allowed_commands": []interface{}{"bin/ls"}},
+		{"allowed_commands": "ls", "timeout": "soon"},
+		{"allowed_commands": "ls", "max_concurrent": 0},
+		{"allowed_commands": "ls", "unknown": true},
+	}
+	for _, cfg := range tests {
+		if err := NewExecFSPlugin().Validate(cfg); err == nil {
+			t.Fatalf("Validate(%v) should fail", cfg)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package execfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// JobStatus is the lifecycle state of a command
+type JobStatus string
+
+const (
+	JobStatusPending  JobStatus = "pending" // Created with mkdir, waiting for cmd
+	JobStatusRunning  JobStatus = "running"
+	JobStatusExited   JobStatus = "exited"  // Exited on its own; see exit_code
+	JobStatusTimedOut JobStatus = "timeout" // Killed after the timeout
+	JobStatusKilled   JobStatus = "killed"  // Removed while running, or killed by a signal
+	JobStatusFailed   JobStatus = "failed"  // Could not be started
+)
+
+var jobIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Job is one command execution
+type Job struct {
+	id         string
+	command    string
+	status     JobStatus
+	exitCode   int
+	err        string
+	stdout     *outputBuffer
+	stderr     *outputBuffer
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+	cancel     context.CancelFunc
+	done       chan struct{} // Closed when the job leaves the running state
+	mu         sync.Mutex
+}
+
+// JobStatusInfo is the JSON content of jobs/<id>/status
+type JobStatusInfo struct {
+	ID          string    `json:"id"`
+	Command     string    `json:"command,omitempty"`
+	Status      JobStatus `json:"status"`
+	ExitCode    *int      `json:"exit_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at,omitempty"`
+	FinishedAt  time.Time `json:"finished_at,omitempty"`
+	Duration    string    `json:"duration,omitempty"`
+	StdoutBytes int64     `json:"stdout_bytes"`
+	StderrBytes int64     `json:"stderr_bytes"`
+	Truncated   bool      `json:"truncated"` // Output beyond max_output was dropped
+}
+
+func (j *Job) finished() bool {
+	return j.status != JobStatusPending && j.status != JobStatusRunning
+}
+
+// statusInfo returns a snapshot of the job state. Must be called with mu held.
+func (j *Job) statusInfo() JobStatusInfo {
+	info := JobStatusInfo{
+		ID:          j.id,
+		Command:     j.command,
+		Status:      j.status,
+		Error:       j.err,
+		StartedAt:   j.startedAt,
+		FinishedAt:  j.finishedAt,
+		StdoutBytes: j.stdout.total(),
+		StderrBytes: j.stderr.total(),
+		Truncated:   j.stdout.truncated() || j.stderr.truncated(),
+	}
+	if j.status == JobStatusExited {
+		code := j.exitCode
+		info.ExitCode = &code
+	}
+	if !j.startedAt.IsZero() {
+		end := j.finishedAt
+		if end.IsZero() {
+			end = time.Now()
+		}
+		info.Duration = end.Sub(j.startedAt).String()
+	}
+	return info
+}
+
+// outputBuffer captures a stream up to a limit and counts what it drops
+type outputBuffer struct {
+	mu    sync.Mutex
+	data  []byte
+	limit int64
+	size  int64
+}
+
+func newOutputBuffer(limit int64) *outputBuffer {
+	return &outputBuffer{limit: limit}
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(p)
+	b.size += int64(n)
+	if room := b.limit - int64(len(b.data)); room > 0 {
+		if int64(n) > room {
+			p = p[:room]
+		}
+		b.data = append(b.data, p...)
+	}
+	return n, nil
+}
+
+func (b *outputBuffer) bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.data...)
+}
+
+func (b *outputBuffer) total() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.size
+}
+
+func (b *outputBuffer) truncated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.size > int64(len(b.data))
+}
+
+// runner starts commands in the sandbox and keeps their results
+type runner struct {
+	sandbox       *Sandbox
+	maxOutput     int64
+	maxConcurrent int
+	maxJobs       int
+
+	jobs    map[string]*Job
+	nextID  int64
+	running int
+	last    *Job // Most recent job started through the run file
+	mu      sync.Mutex
+
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newRunner(sandbox *Sandbox, maxOutput int64, maxConcurrent, maxJobs int) *runner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &runner{
+		sandbox:       sandbox,
+		maxOutput:     maxOutput,
+		maxConcurrent: maxConcurrent,
+		maxJobs:       maxJobs,
+		jobs:          make(map[string]*Job),
+		nextID:        1,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// create adds a pending job. An empty id allocates the next free number.
+func (r *runner) create(id string) (*Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id == "" {
+		for {
+			id = strconv.FormatInt(r.nextID, 10)
+			r.nextID++
+			if _, exists := r.jobs[id]; !exists {
+				break
+			}
+		}
+	} else if !jobIDPattern.MatchString(id) {
+		return nil, filesystem.NewInvalidArgumentError("job", id, "must match [A-Za-z0-9][A-Za-z0-9._-]{0,63}")
+	} else if _, exists := r.jobs[id]; exists {
+		return nil, filesystem.NewAlreadyExistsError("job", id)
+	}
+
+	if len(r.jobs) >= r.maxJobs && !r.evictOldest() {
+		return nil, fmt.Errorf("too many jobs (limit %d); remove finished jobs first", r.maxJobs)
+	}
+
+	job := &Job{
+		id:        id,
+		status:    JobStatusPending,
+		stdout:    newOutputBuffer(r.maxOutput),
+		stderr:    newOutputBuffer(r.maxOutput),
+		createdAt: time.Now(),
+		done:      make(chan struct{}),
+	}
+	r.jobs[id] = job
+	return job, nil
+}
+
+// evictOldest forgets the oldest finished job. Must be called with mu held.
+func (r *runner) evictOldest() bool {
+	var oldest *Job
+	for _, job := range r.jobs {
+		job.mu.Lock()
+		if job.finished() && (oldest == nil || job.createdAt.Before(oldest.createdAt)) {
+			oldest = job
+		}
+		job.mu.Unlock()
+	}
+	if oldest == nil {
+		return false
+	}
+	delete(r.jobs, oldest.id)
+	return true
+}
+
+// start runs command as job in the background
+func (r *runner) start(job *Job, command string) error {
+	argv, err := parseCommandLine(command)
+	if err != nil {
+		return filesystem.NewInvalidArgumentError("command", command, err.Error())
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.status != JobStatusPending {
+		return filesystem.NewAlreadyExistsError("job", job.id)
+	}
+
+	r.mu.Lock()
+	if r.running >= r.maxConcurrent {
+		r.mu.Unlock()
+		return fmt.Errorf("too many running commands (limit %d)", r.maxConcurrent)
+	}
+	r.running++
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(r.ctx, r.sandbox.Timeout)
+	cmd, err := r.sandbox.command(ctx, argv)
+	if err == nil {
+		cmd.Stdout = job.stdout
+		cmd.Stderr = job.stderr
+		err = cmd.Start()
+	}
+	job.command = command
+	if err != nil {
+		cancel()
+		r.release()
+		job.status = JobStatusFailed
+		job.err = err.Error()
+		job.finishedAt = time.Now()
+		close(job.done)
+		return err
+	}
+
+	job.status = JobStatusRunning
+	job.startedAt = time.Now()
+	job.cancel = cancel
+	log.Debugf("[execfs] job %s started: %s", job.id, command)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer r.release()
+		waitErr := cmd.Wait()
+
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		defer close(job.done)
+		cancel()
+		job.finishedAt = time.Now()
+		switch {
+		case job.status == JobStatusKilled:
+			// Removed while running
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			job.status = JobStatusTimedOut
+			job.err = fmt.Sprintf("killed after %v", r.sandbox.Timeout)
+		case cmd.ProcessState != nil && cmd.ProcessState.ExitCode() >= 0:
+			job.status = JobStatusExited
+			job.exitCode = cmd.ProcessState.ExitCode()
+		default:
+			job.status = JobStatusKilled
+			if waitErr != nil {
+				job.err = waitErr.Error()
+			}
+		}
+		log.Debugf("[execfs] job %s %s", job.id, job.status)
+	}()
+	return nil
+}
+
+func (r *runner) release() {
+	r.mu.Lock()
+	r.running--
+	r.mu.Unlock()
+}
+
+// run starts command as a new job and waits for it to finish
+func (r *runner) run(command string) (*Job, error) {
+	job, err := r.create("")
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.last = job
+	r.mu.Unlock()
+
+	if err := r.start(job, command); err != nil {
+		return job, err
+	}
+	<-job.done
+	return job, nil
+}
+
+func (r *runner) get(id string) *Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.jobs[id]
+}
+
+func (r *runner) lastJob() *Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// list returns the IDs of all jobs, oldest first
+func (r *runner) list() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobs := make([]*Job, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].createdAt.Before(jobs[j].createdAt) })
+	ids := make([]string, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.id
+	}
+	return ids
+}
+
+// remove kills the job if it is running and forgets it
+func (r *runner) remove(id string) error {
+	r.mu.Lock()
+	job, ok := r.jobs[id]
+	delete(r.jobs, id)
+	r.mu.Unlock()
+	if !ok {
+		return filesystem.NewNotFoundError("remove", "/"+jobsDirName+"/"+id)
+	}
+
+	job.mu.Lock()
+	if job.status == JobStatusRunning {
+		job.status = JobStatusKilled
+		job.err = "removed while running"
+		job.cancel()
+	}
+	job.mu.Unlock()
+	return nil
+}
+
+// stop kills all running commands and waits for them to exit
+func (r *runner) stop() {
+	r.cancel()
+	r.wg.Wait()
+}
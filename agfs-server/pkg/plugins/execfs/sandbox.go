@@ -0,0 +1,186 @@
+package execfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Sandbox describes the restricted environment commands run in
+type Sandbox struct {
+	Allowed      []string          // Allowlisted command names or absolute paths
+	SearchPath   []string          // Directories searched for allowlisted names
+	WorkDir      string            // Working directory (and HOME) of every command
+	Env          map[string]string // Extra environment variables
+	Timeout      time.Duration     // Wall-clock limit
+	CPUSeconds   int               // RLIMIT_CPU; 0 for none
+	MaxMemory    int64             // RLIMIT_AS in bytes; 0 for none
+	MaxOpenFiles int               // RLIMIT_NOFILE; 0 for none
+	AllowNetwork bool              // Share the server's network instead of an empty namespace
+}
+
+// shellWrapper applies resource limits before exec'ing the real command,
+// which is passed as positional parameters and never interpreted by the shell
+const shellWrapper = "/bin/sh"
+
+// parseCommandLine splits a command line into arguments. Single and double
+// quotes and backslash escapes work as in a POSIX shell; everything a shell
+// would interpret (pipes, redirection, expansion, command lists) is rejected
+// because commands are never run through one.
+func parseCommandLine(line string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			cur.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case c == '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '$' || line[i] == '`' {
+					return nil, fmt.Errorf("shell expansion is not supported")
+				}
+				if line[i] == '\\' && i+1 < len(line) && strings.IndexByte("\"\\", line[i+1]) >= 0 {
+					i++
+				}
+				cur.WriteByte(line[i])
+			}
+			if i == len(line) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			inArg = true
+		case c == '\\':
+			if i+1 < len(line) {
+				i++
+				cur.WriteByte(line[i])
+			}
+			inArg = true
+		case strings.IndexByte("|&;<>()$`*?[]{}", c) >= 0 || (!inArg && (c == '~' || c == '#')):
+			return nil, fmt.Errorf("shell syntax %q is not supported; commands are not run through a shell", c)
+		default:
+			cur.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return args, nil
+}
+
+// resolve returns the absolute path of an allowlisted command. Names are
+// looked up in the sandbox search path only; paths must be allowlisted
+// exactly.
+func (s *Sandbox) resolve(name string) (string, error) {
+	if strings.Contains(name, "/") {
+		clean := filepath.Clean(name)
+		for _, allowed := range s.Allowed {
+			if filepath.IsAbs(allowed) && filepath.Clean(allowed) == clean {
+				return clean, nil
+			}
+		}
+		return "", filesystem.NewPermissionDeniedError("exec", name, "command is not allowlisted")
+	}
+
+	for _, allowed := range s.Allowed {
+		if filepath.IsAbs(allowed) && filepath.Base(allowed) == name {
+			return filepath.Clean(allowed), nil
+		}
+	}
+	allowedName := false
+	for _, allowed := range s.Allowed {
+		if allowed == name {
+			allowedName = true
+			break
+		}
+	}
+	if !allowedName {
+		return "", filesystem.NewPermissionDeniedError("exec", name, "command is not allowlisted")
+	}
+	for _, dir := range s.SearchPath {
+		candidate := filepath.Join(dir, name)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return candidate, nil
+		}
+	}
+	return "", filesystem.NewNotFoundError("exec", name)
+}
+
+// environ returns the complete environment of a command. Nothing is
+// inherited from the server.
+func (s *Sandbox) environ() []string {
+	env := []string{
+		"PATH=" + strings.Join(s.SearchPath, string(os.PathListSeparator)),
+		"HOME=" + s.WorkDir,
+		"LANG=C.UTF-8",
+	}
+	for k, v := range s.Env {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+// limitArgs returns the shell commands that apply the resource limits
+func (s *Sandbox) limitArgs() []string {
+	var limits []string
+	if s.CPUSeconds > 0 {
+		limits = append(limits, "ulimit -t "+strconv.Itoa(s.CPUSeconds))
+	}
+	if s.MaxMemory > 0 {
+		limits = append(limits, "ulimit -v "+strconv.FormatInt(s.MaxMemory/1024, 10))
+	}
+	if s.MaxOpenFiles > 0 {
+		limits = append(limits, "ulimit -n "+strconv.Itoa(s.MaxOpenFiles))
+	}
+	return limits
+}
+
+// command builds the process for argv. The context bounds its lifetime.
+func (s *Sandbox) command(ctx context.Context, argv []string) (*exec.Cmd, error) {
+	binary, err := s.resolve(argv[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var cmd *exec.Cmd
+	if limits := s.limitArgs(); len(limits) > 0 {
+		script := strings.Join(limits, " && ") + ` && exec "$@"`
+		args := append([]string{"-c", script, "execfs", binary}, argv[1:]...)
+		cmd = exec.CommandContext(ctx, shellWrapper, args...)
+	} else {
+		cmd = exec.CommandContext(ctx, binary, argv[1:]...)
+	}
+	cmd.Dir = s.WorkDir
+	cmd.Env = s.environ()
+	// Grandchildren holding the output pipes must not block Wait forever
+	cmd.WaitDelay = time.Second
+
+	if err := isolate(cmd, s.AllowNetwork); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
@@ -0,0 +1,30 @@
+package execfs
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// networkIsolation reports whether commands can be cut off from the network
+const networkIsolation = true
+
+// isolate runs the command in its own process group, so a timeout kills
+// everything it spawned, and unless allowNetwork is set in new user and
+// network namespaces whose only interface is a down loopback device.
+func isolate(cmd *exec.Cmd, allowNetwork bool) error {
+	attr := &syscall.SysProcAttr{
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+	if !allowNetwork {
+		attr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+	}
+	cmd.SysProcAttr = attr
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return nil
+}
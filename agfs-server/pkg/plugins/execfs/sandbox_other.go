@@ -0,0 +1,20 @@
+//go:build !linux
+
+package execfs
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// networkIsolation reports whether commands can be cut off from the network
+const networkIsolation = false
+
+// isolate requires network access to be allowed explicitly: namespaces are
+// only available on Linux, and commands must not silently get the network.
+func isolate(cmd *exec.Cmd, allowNetwork bool) error {
+	if !allowNetwork {
+		return fmt.Errorf("network isolation is only supported on Linux; set allow_network to run commands with network access")
+	}
+	return nil
+}
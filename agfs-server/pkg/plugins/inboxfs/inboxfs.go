@@ -0,0 +1,588 @@
+package inboxfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PluginName = "inboxfs"
+
+	tasksDir     = "tasks"
+	pendingDir   = "pending"
+	responsesDir = "responses"
+)
+
+// defaultMaxTasks bounds the tasks kept in memory
+const defaultMaxTasks = 1000
+
+// Task states
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+)
+
+// Field types
+const (
+	FieldText     = "text"
+	FieldTextarea = "textarea"
+	FieldNumber   = "number"
+	FieldBoolean  = "boolean"
+	FieldSelect   = "select"
+)
+
+// Field is one input of a task's form
+type Field struct {
+	Name     string      `json:"name"`
+	Label    string      `json:"label,omitempty"`
+	Type     string      `json:"type"`
+	Options  []string    `json:"options,omitempty"` // Choices of select fields
+	Required bool        `json:"required,omitempty"`
+	Default  interface{} `json:"default,omitempty"`
+}
+
+// Task is a request for input from a human
+type Task struct {
+	ID          string                 `json:"id"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description,omitempty"`
+	Fields      []Field                `json:"fields"`
+	Status      string                 `json:"status"`
+	CreatedAt   time.Time              `json:"created_at"`
+	CompletedAt *time.Time             `json:"completed_at,omitempty"`
+	Response    map[string]interface{} `json:"response,omitempty"`
+}
+
+// InboxFSPlugin keeps tasks that agents create for humans, who answer them
+// by filling in their forms
+type InboxFSPlugin struct {
+	maxTasks int
+
+	mu    sync.Mutex
+	tasks map[string]*Task
+}
+
+// NewInboxFSPlugin creates a new InboxFS plugin
+func NewInboxFSPlugin() *InboxFSPlugin {
+	return &InboxFSPlugin{tasks: make(map[string]*Task)}
+}
+
+func (p *InboxFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *InboxFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"max_tasks", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	return config.ValidateIntType(cfg, "max_tasks")
+}
+
+func (p *InboxFSPlugin) Initialize(cfg map[string]interface{}) error {
+	p.maxTasks = config.GetIntConfig(cfg, "max_tasks", defaultMaxTasks)
+	if p.maxTasks < 1 {
+		p.maxTasks = defaultMaxTasks
+	}
+	log.Infof("[inboxfs] Initialized (max %d tasks)", p.maxTasks)
+	return nil
+}
+
+func (p *InboxFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return &inboxFS{plugin: p}
+}
+
+func (p *InboxFSPlugin) GetReadme() string {
+	return `InboxFS Plugin - Human-in-the-loop Tasks
+
+This plugin keeps a task inbox. Agents create task files describing what
+they need from a human, the server's /inbox web page presents pending tasks
+as forms, and the answers appear as response files the agent can poll.
+
+STRUCTURE:
+  /README             - This file
+  /tasks/<id>         - Write to create a task; read it with its status and
+                        response; remove to cancel it
+  /pending/<id>       - The tasks still waiting for a response (read-only)
+  /responses/<id>     - The response once a human has answered; write the
+                        field values here to answer
+
+USAGE:
+  echo "Approve the release?" > /inbox/tasks/release-42
+  cat /inbox/responses/release-42     # not found until answered
+  echo '{"response": "yes"}' > /inbox/responses/release-42
+
+  A task is plain text, whose first line is the title, or a JSON object:
+  {"title": "Deploy to production?", "description": "...",
+   "fields": [{"name": "approve", "type": "boolean", "required": true},
+              {"name": "region", "type": "select", "options": ["eu", "us"]},
+              {"name": "notes", "type": "textarea"}]}
+
+  Field types are text, textarea, number, boolean and select. A task
+  without fields gets a single textarea named "response". A response is a
+  JSON object of field values, or plain text for tasks with one text field.
+  Writing a task again replaces it until it has been answered.
+
+VERSION: 1.0.0
+`
+}
+
+func (p *InboxFSPlugin) GetConfigParams() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{
+			Name:        "max_tasks",
+			Type:        "int",
+			Required:    false,
+			Default:     "1000",
+			Description: "Tasks kept in memory; the oldest answered task is dropped to make room",
+		},
+	}
+}
+
+func (p *InboxFSPlugin) Shutdown() error {
+	return nil
+}
+
+// parseTask reads a plain text or JSON task
+func parseTask(id string, data []byte) (*Task, error) {
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return nil, filesystem.NewInvalidArgumentError("task", "", "must not be empty")
+	}
+	t := &Task{}
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), t); err != nil {
+			return nil, filesystem.NewInvalidArgumentError("task", "", "invalid JSON: "+err.Error())
+		}
+	} else {
+		t.Title, t.Description, _ = strings.Cut(text, "\n")
+		t.Description = strings.TrimSpace(t.Description)
+	}
+	if t.Title == "" {
+		return nil, filesystem.NewInvalidArgumentError("title", "", "must not be empty")
+	}
+	if len(t.Fields) == 0 {
+		t.Fields = []Field{{Name: "response", Type: FieldTextarea, Required: true}}
+	}
+	seen := make(map[string]bool)
+	for i := range t.Fields {
+		f := &t.Fields[i]
+		if f.Name == "" || seen[f.Name] {
+			return nil, filesystem.NewInvalidArgumentError("field", f.Name, "names must be unique and not empty")
+		}
+		seen[f.Name] = true
+		switch f.Type {
+		case "":
+			f.Type = FieldText
+		case FieldText, FieldTextarea, FieldNumber, FieldBoolean:
+		case FieldSelect:
+			if len(f.Options) == 0 {
+				return nil, filesystem.NewInvalidArgumentError("field", f.Name, "select fields need options")
+			}
+		default:
+			return nil, filesystem.NewInvalidArgumentError("field", f.Name, "unknown type "+f.Type)
+		}
+	}
+	t.ID = id
+	t.Status = StatusPending
+	t.CompletedAt = nil
+	t.Response = nil
+	return t, nil
+}
+
+// parseResponse reads the field values answering t
+func parseResponse(t *Task, data []byte) (map[string]interface{}, error) {
+	text := strings.TrimSpace(string(data))
+	values := make(map[string]interface{})
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), &values); err != nil {
+			return nil, filesystem.NewInvalidArgumentError("response", "", "invalid JSON: "+err.Error())
+		}
+	} else if len(t.Fields) == 1 && (t.Fields[0].Type == FieldText || t.Fields[0].Type == FieldTextarea) {
+		if text != "" {
+			values[t.Fields[0].Name] = text
+		}
+	} else {
+		return nil, filesystem.NewInvalidArgumentError("response", "", "must be a JSON object of field values")
+	}
+
+	fields := make(map[string]*Field, len(t.Fields))
+	for i := range t.Fields {
+		fields[t.Fields[i].Name] = &t.Fields[i]
+	}
+	for name, value := range values {
+		f, ok := fields[name]
+		if !ok {
+			return nil, filesystem.NewInvalidArgumentError("response", name, "unknown field")
+		}
+		if err := checkValue(f, value); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range t.Fields {
+		if _, ok := values[f.Name]; !ok && f.Required {
+			return nil, filesystem.NewInvalidArgumentError("response", f.Name, "required field is missing")
+		}
+	}
+	return values, nil
+}
+
+// checkValue validates a response value against its field
+func checkValue(f *Field, value interface{}) error {
+	ok := false
+	switch f.Type {
+	case FieldNumber:
+		_, ok = value.(float64)
+	case FieldBoolean:
+		_, ok = value.(bool)
+	case FieldSelect:
+		s, isString := value.(string)
+		for _, option := range f.Options {
+			ok = ok || (isString && s == option)
+		}
+		if !ok {
+			return filesystem.NewInvalidArgumentError(f.Name, fmt.Sprint(value), "must be one of "+strings.Join(f.Options, ", "))
+		}
+	default:
+		_, ok = value.(string)
+	}
+	if !ok {
+		return filesystem.NewInvalidArgumentError(f.Name, fmt.Sprint(value), "must be a "+f.Type)
+	}
+	return nil
+}
+
+// put creates task t, or replaces it while it is pending
+func (p *InboxFSPlugin) put(t *Task) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if old, ok := p.tasks[t.ID]; ok {
+		if old.Status == StatusCompleted {
+			return filesystem.NewAlreadyExistsError("completed task", "/"+tasksDir+"/"+t.ID)
+		}
+		t.CreatedAt = old.CreatedAt
+		p.tasks[t.ID] = t
+		return nil
+	}
+	if len(p.tasks) >= p.maxTasks && !p.evictLocked() {
+		return filesystem.NewQuotaExceededError("/"+tasksDir, int64(p.maxTasks))
+	}
+	t.CreatedAt = time.Now()
+	p.tasks[t.ID] = t
+	log.Infof("[inboxfs] Task %s created: %s", t.ID, t.Title)
+	return nil
+}
+
+// evictLocked drops the oldest answered task, reporting whether there was one
+func (p *InboxFSPlugin) evictLocked() bool {
+	var oldest *Task
+	for _, t := range p.tasks {
+		if t.Status == StatusCompleted && (oldest == nil || t.CompletedAt.Before(*oldest.CompletedAt)) {
+			oldest = t
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	delete(p.tasks, oldest.ID)
+	return true
+}
+
+// respond answers task id with data
+func (p *InboxFSPlugin) respond(id string, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.tasks[id]
+	if !ok {
+		return filesystem.NewNotFoundError("respond", "/"+tasksDir+"/"+id)
+	}
+	if t.Status == StatusCompleted {
+		return filesystem.NewAlreadyExistsError("response", "/"+responsesDir+"/"+id)
+	}
+	values, err := parseResponse(t, data)
+	if err != nil {
+		return err
+	}
+	// Tasks are replaced rather than modified, so that readers holding the
+	// old one are unaffected
+	done := *t
+	now := time.Now()
+	done.Status = StatusCompleted
+	done.CompletedAt = &now
+	done.Response = values
+	p.tasks[id] = &done
+	log.Infof("[inboxfs] Task %s answered", id)
+	return nil
+}
+
+// task returns the task id
+func (p *InboxFSPlugin) task(id string) (*Task, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.tasks[id]
+	return t, ok
+}
+
+// list returns the tasks, oldest first, optionally only pending ones
+func (p *InboxFSPlugin) list(pendingOnly bool) []*Task {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tasks := make([]*Task, 0, len(p.tasks))
+	for _, t := range p.tasks {
+		if !pendingOnly || t.Status == StatusPending {
+			tasks = append(tasks, t)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].CreatedAt.Equal(tasks[j].CreatedAt) {
+			return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+	return tasks
+}
+
+// remove cancels task id
+func (p *InboxFSPlugin) remove(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.tasks[id]
+	delete(p.tasks, id)
+	return ok
+}
+
+// inboxFS exposes the tasks as a file system
+type inboxFS struct {
+	plugin *InboxFSPlugin
+}
+
+// splitPath splits a path into its directory and task id
+func splitPath(path string) (dir, id string, extra bool) {
+	parts := strings.SplitN(strings.Trim(filesystem.NormalizePath(path), "/"), "/", 3)
+	dir = parts[0]
+	if len(parts) > 1 {
+		id = parts[1]
+	}
+	return dir, id, len(parts) > 2
+}
+
+// file returns the content of the file for task id in dir, if it exists
+func (fs *inboxFS) file(dir, id string) (*Task, []byte, bool) {
+	t, ok := fs.plugin.task(id)
+	if !ok {
+		return nil, nil, false
+	}
+	var v interface{} = t
+	switch dir {
+	case pendingDir:
+		if t.Status != StatusPending {
+			return nil, nil, false
+		}
+	case responsesDir:
+		if t.Status != StatusCompleted {
+			return nil, nil, false
+		}
+		v = t.Response
+	}
+	data, _ := json.MarshalIndent(v, "", "  ")
+	return t, append(data, '\n'), true
+}
+
+func isDir(dir string) bool {
+	return dir == tasksDir || dir == pendingDir || dir == responsesDir
+}
+
+func (fs *inboxFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	dir, id, extra := splitPath(path)
+	switch {
+	case dir == "README" && id == "":
+		return plugin.ApplyRangeRead([]byte(fs.plugin.GetReadme()), offset, size)
+	case dir == "" || (isDir(dir) && id == ""):
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	case !isDir(dir) || extra:
+		return nil, filesystem.NewNotFoundError("read", path)
+	}
+	_, data, ok := fs.file(dir, id)
+	if !ok {
+		return nil, filesystem.NewNotFoundError("read", path)
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+func (fs *inboxFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	dir, id, extra := splitPath(path)
+	if id == "" || extra {
+		return 0, filesystem.NewPermissionDeniedError("write", path, "write to /tasks/<id> or /responses/<id>")
+	}
+	switch dir {
+	case tasksDir:
+		t, err := parseTask(id, data)
+		if err != nil {
+			return 0, err
+		}
+		if err := fs.plugin.put(t); err != nil {
+			return 0, err
+		}
+	case responsesDir:
+		if err := fs.plugin.respond(id, data); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, filesystem.NewPermissionDeniedError("write", path, "write to /tasks/<id> or /responses/<id>")
+	}
+	return int64(len(data)), nil
+}
+
+func (fs *inboxFS) Stat(path string) (*filesystem.FileInfo, error) {
+	dir, id, extra := splitPath(path)
+	now := time.Now()
+	switch {
+	case dir == "":
+		return &filesystem.FileInfo{Name: "/", Mode: 0755, ModTime: now, IsDir: true,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "directory"}}, nil
+	case dir == "README" && id == "":
+		return &filesystem.FileInfo{Name: "README", Size: int64(len(fs.plugin.GetReadme())), Mode: 0444, ModTime: now,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "doc"}}, nil
+	case isDir(dir) && id == "":
+		mode := uint32(0755)
+		if dir == pendingDir {
+			mode = 0555
+		}
+		return &filesystem.FileInfo{Name: dir, Mode: mode, ModTime: now, IsDir: true,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "directory"}}, nil
+	case !isDir(dir) || extra:
+		return nil, filesystem.NewNotFoundError("stat", path)
+	}
+	t, data, ok := fs.file(dir, id)
+	if !ok {
+		return nil, filesystem.NewNotFoundError("stat", path)
+	}
+	return fileInfo(dir, t, data), nil
+}
+
+// fileInfo describes the file for t in dir
+func fileInfo(dir string, t *Task, data []byte) *filesystem.FileInfo {
+	modTime := t.CreatedAt
+	if t.CompletedAt != nil {
+		modTime = *t.CompletedAt
+	}
+	mode := uint32(0644)
+	if dir == pendingDir {
+		mode = 0444
+	}
+	return &filesystem.FileInfo{Name: t.ID, Size: int64(len(data)), Mode: mode, ModTime: modTime,
+		Meta: filesystem.MetaData{Name: PluginName, Type: "task", Content: map[string]string{"status": t.Status, "title": t.Title}}}
+}
+
+func (fs *inboxFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	dir, id, _ := splitPath(path)
+	if dir == "" {
+		infos := make([]filesystem.FileInfo, 0, 4)
+		for _, name := range []string{"README", tasksDir, pendingDir, responsesDir} {
+			info, _ := fs.Stat("/" + name)
+			infos = append(infos, *info)
+		}
+		return infos, nil
+	}
+	if !isDir(dir) || id != "" {
+		if _, err := fs.Stat(path); err != nil {
+			return nil, err
+		}
+		return nil, filesystem.NewNotDirectoryError(path)
+	}
+
+	infos := []filesystem.FileInfo{}
+	for _, t := range fs.plugin.list(dir == pendingDir) {
+		if _, data, ok := fs.file(dir, t.ID); ok {
+			infos = append(infos, *fileInfo(dir, t, data))
+		}
+	}
+	return infos, nil
+}
+
+// Create accepts task and response files so that shell redirection works;
+// they come into existence when written
+func (fs *inboxFS) Create(path string) error {
+	dir, id, extra := splitPath(path)
+	if id == "" || extra || (dir != tasksDir && dir != responsesDir) {
+		return filesystem.NewPermissionDeniedError("create", path, "create /tasks/<id> or /responses/<id>")
+	}
+	return nil
+}
+
+func (fs *inboxFS) Mkdir(path string, perm uint32) error {
+	return filesystem.NewPermissionDeniedError("mkdir", path, "the inbox layout is fixed")
+}
+
+// Remove cancels a task, along with its response
+func (fs *inboxFS) Remove(path string) error {
+	dir, id, extra := splitPath(path)
+	if dir != tasksDir || id == "" || extra {
+		return filesystem.NewPermissionDeniedError("remove", path, "remove /tasks/<id> to cancel a task")
+	}
+	if !fs.plugin.remove(id) {
+		return filesystem.NewNotFoundError("remove", path)
+	}
+	log.Infof("[inboxfs] Task %s removed", id)
+	return nil
+}
+
+func (fs *inboxFS) RemoveAll(path string) error {
+	return fs.Remove(path)
+}
+
+func (fs *inboxFS) Rename(oldPath, newPath string) error {
+	return filesystem.NewNotSupportedError("rename", oldPath)
+}
+
+func (fs *inboxFS) Chmod(path string, mode uint32) error {
+	return filesystem.NewNotSupportedError("chmod", path)
+}
+
+// Truncate is a no-op so that shell redirection works
+func (fs *inboxFS) Truncate(path string, size int64) error {
+	return nil
+}
+
+func (fs *inboxFS) Open(path string) (io.ReadCloser, error) {
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (fs *inboxFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return &inboxWriter{fs: fs, path: path}, nil
+}
+
+// inboxWriter buffers a task or response and writes it on Close
+type inboxWriter struct {
+	fs   *inboxFS
+	path string
+	buf  bytes.Buffer
+}
+
+func (w *inboxWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *inboxWriter) Close() error {
+	_, err := w.fs.Write(w.path, w.buf.Bytes(), -1, filesystem.WriteFlagNone)
+	return err
+}
+
+// Ensure InboxFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*InboxFSPlugin)(nil)
+var _ filesystem.FileSystem = (*inboxFS)(nil)
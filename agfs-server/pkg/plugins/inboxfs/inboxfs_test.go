@@ -0,0 +1,154 @@
+package inboxfs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func newTestFS(t *testing.T, cfg map[string]interface{}) *inboxFS {
+	t.Helper()
+	p := NewInboxFSPlugin()
+	if err := p.Validate(cfg); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if err := p.Initialize(cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	return p.GetFileSystem().(*inboxFS)
+}
+
+func write(t *testing.T, fs *inboxFS, path, data string) error {
+	t.Helper()
+	_, err := fs.Write(path, []byte(data), -1, filesystem.WriteFlagNone)
+	return err
+}
+
+func readJSON(t *testing.T, fs *inboxFS, path string, v interface{}) {
+	t.Helper()
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read(%s) error = %v", path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("Read(%s) returned invalid JSON %q: %v", path, data, err)
+	}
+}
+
+func names(t *testing.T, fs *inboxFS, path string) []string {
+	t.Helper()
+	infos, err := fs.ReadDir(path)
+	if err != nil {
+		t.Fatalf("ReadDir(%s) error = %v", path, err)
+	}
+	var out []string
+	for _, info := range infos {
+		out = append(out, info.Name)
+	}
+	return out
+}
+
+func TestTaskLifecycle(t *testing.T) {
+	fs := newTestFS(t, map[string]interface{}{})
+	task := `{"title": "Deploy?", "fields": [
+		{"name": "approve", "type": "boolean", "required": true},
+		{"name": "region", "type": "select", "options": ["eu", "us"]},
+		{"name": "replicas", "type": "number"}]}`
+	if err := write(t, fs, "/tasks/deploy", task); err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	if got := names(t, fs, "/pending"); len(got) != 1 || got[0] != "deploy" {
+		t.Errorf("pending = %v", got)
+	}
+	if _, err := fs.Stat("/responses/deploy"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("response of pending task: err = %v, want not found", err)
+	}
+
+	for _, bad := range []string{
+		`{"region": "eu"}`,                  // missing required field
+		`{"approve": "yes"}`,                // wrong type
+		`{"approve": true, "region": "ap"}`, // not an option
+		`{"approve": true, "other": 1}`,     // unknown field
+		`yes`,                               // plain text for a form
+	} {
+		if err := write(t, fs, "/responses/deploy", bad); !errors.Is(err, filesystem.ErrInvalidArgument) {
+			t.Errorf("response %s: err = %v, want invalid argument", bad, err)
+		}
+	}
+
+	if err := write(t, fs, "/responses/deploy", `{"approve": true, "region": "eu", "replicas": 3}`); err != nil {
+		t.Fatalf("respond: %v", err)
+	}
+	var values map[string]interface{}
+	readJSON(t, fs, "/responses/deploy", &values)
+	if values["approve"] != true || values["region"] != "eu" || values["replicas"] != 3.0 {
+		t.Errorf("response = %v", values)
+	}
+	var got Task
+	readJSON(t, fs, "/tasks/deploy", &got)
+	if got.Status != StatusCompleted || got.CompletedAt == nil {
+		t.Errorf("task = %+v, want completed", got)
+	}
+	if got := names(t, fs, "/pending"); len(got) != 0 {
+		t.Errorf("pending after response = %v", got)
+	}
+	if err := write(t, fs, "/responses/deploy", `{"approve": false}`); !errors.Is(err, filesystem.ErrAlreadyExists) {
+		t.Errorf("second response: err = %v, want already exists", err)
+	}
+	if err := write(t, fs, "/tasks/deploy", "Again?"); !errors.Is(err, filesystem.ErrAlreadyExists) {
+		t.Errorf("rewrite completed task: err = %v, want already exists", err)
+	}
+
+	if err := fs.Remove("/tasks/deploy"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := fs.Stat("/responses/deploy"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("response after remove: err = %v, want not found", err)
+	}
+}
+
+func TestPlainTextTask(t *testing.T) {
+	fs := newTestFS(t, map[string]interface{}{})
+	if err := write(t, fs, "/tasks/q", "Which color?\nPick one for the logo."); err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	var task Task
+	readJSON(t, fs, "/pending/q", &task)
+	if task.Title != "Which color?" || task.Description != "Pick one for the logo." ||
+		len(task.Fields) != 1 || task.Fields[0].Name != "response" {
+		t.Errorf("task = %+v", task)
+	}
+	if err := write(t, fs, "/responses/q", "blue\n"); err != nil {
+		t.Fatalf("respond: %v", err)
+	}
+	var values map[string]interface{}
+	readJSON(t, fs, "/responses/q", &values)
+	if values["response"] != "blue" {
+		t.Errorf("response = %v", values)
+	}
+	if err := write(t, fs, "/responses/missing", "x"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("respond to missing task: err = %v, want not found", err)
+	}
+	if err := write(t, fs, "/tasks/bad", `{"title": "x", "fields": [{"name": "c", "type": "select"}]}`); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("select without options: err = %v, want invalid argument", err)
+	}
+}
+
+func TestMaxTasks(t *testing.T) {
+	fs := newTestFS(t, map[string]interface{}{"max_tasks": 2})
+	write(t, fs, "/tasks/a", "A")
+	write(t, fs, "/tasks/b", "B")
+	if err := write(t, fs, "/tasks/c", "C"); !errors.Is(err, filesystem.ErrQuotaExceeded) {
+		t.Fatalf("third task: err = %v, want quota exceeded", err)
+	}
+	write(t, fs, "/responses/a", "done")
+	if err := write(t, fs, "/tasks/c", "C"); err != nil {
+		t.Fatalf("third task after answering one: %v", err)
+	}
+	if got := names(t, fs, "/tasks"); len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Errorf("tasks = %v, want the answered task dropped", got)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package localfs
+
+import (
+	"os"
+	"syscall"
+)
+
+// sharesInode reports whether a regular file has other hard links, such as
+// one from a snapshot
+func (fs *LocalFS) sharesInode(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && uint64(st.Nlink) > 1
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package localfs
+
+import (
+	"os"
+)
+
+// sharesInode reports whether a regular file may have other hard links.
+// Link counts are not available from os.FileInfo on Windows, so every file
+// is assumed to be shared while any snapshot exists.
+func (fs *LocalFS) sharesInode(info os.FileInfo) bool {
+	entries, err := os.ReadDir(fs.snapshotRoot())
+	return err == nil && len(entries) > 0
+}
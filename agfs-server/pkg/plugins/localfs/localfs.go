@@ -16,6 +16,9 @@ import (
 
 const (
 	PluginName = "localfs"
+
+	// expirySweepInterval is how often expired files are deleted from disk
+	expirySweepInterval = time.Minute
 )
 
 // LocalFS implements FileSystem interface using local file system as backend
@@ -23,6 +26,10 @@ type LocalFS struct {
 	basePath   string // The local directory to mount
 	mu         sync.RWMutex
 	pluginName string
+	expiry     *filesystem.ExpiryIndex // File TTLs (in memory, lost on restart)
+
+	// Size hints of reserved files until they are written (in memory)
+	reservations *filesystem.ReservationIndex
 }
 
 // NewLocalFS creates a new local file system
@@ -49,6 +56,9 @@ func NewLocalFS(basePath string) (*LocalFS, error) {
 	return &LocalFS{
 		basePath:   absPath,
 		pluginName: PluginName,
+		expiry:     filesystem.NewExpiryIndex(),
+
+		reservations: filesystem.NewReservationIndex(),
 	}, nil
 }
 
@@ -64,12 +74,115 @@ func (fs *LocalFS) resolvePath(path string) string {
 	return filepath.Join(fs.basePath, relativePath)
 }
 
+// checkExpired returns a not found error if path has expired but has not
+// been swept yet. Caller must hold fs.mu.
+func (fs *LocalFS) checkExpired(op, path string) error {
+	if fs.expiry.Expired(path) {
+		return filesystem.NewNotFoundError(op, path)
+	}
+	return nil
+}
+
+// reclaimExpired removes an expired file that is about to be replaced, so a
+// new file at the same path starts without the old content or TTL.
+// Caller must hold fs.mu.
+func (fs *LocalFS) reclaimExpired(path, localPath string) {
+	if fs.expiry.Expired(path) {
+		os.Remove(localPath)
+		fs.expiry.Set(path, time.Time{})
+	}
+}
+
+// removeExpired is called by the sweeper for each expired path
+func (fs *LocalFS) removeExpired(path string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// The file may have been replaced or given a new TTL since it was listed
+	if !fs.expiry.Expired(path) {
+		return nil
+	}
+	fs.expiry.Set(path, time.Time{})
+	if err := os.Remove(fs.resolvePath(path)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove expired file: %w", err)
+	}
+	log.Debugf("[localfs] Removed expired file: %s", path)
+	return nil
+}
+
+// SetExpiry implements filesystem.Expirer. Expiries are tracked in memory and
+// do not survive a restart.
+func (fs *LocalFS) SetExpiry(path string, expiresAt time.Time) error {
+	localPath := fs.resolvePath(path)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.checkExpired("setexpiry", path); err != nil {
+		return err
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return filesystem.NewNotFoundError("setexpiry", path)
+		}
+		return fmt.Errorf("failed to stat: %w", err)
+	}
+	if info.IsDir() {
+		return filesystem.NewInvalidArgumentError("path", path, "TTL can only be set on files")
+	}
+
+	fs.expiry.Set(path, expiresAt)
+	return nil
+}
+
+// SetContentType stores the media type of a file in its user.mime_type
+// extended attribute
+func (fs *LocalFS) SetContentType(path string, contentType string) error {
+	localPath := fs.resolvePath(path)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.checkExpired("setcontenttype", path); err != nil {
+		return err
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return filesystem.NewNotFoundError("setcontenttype", path)
+		}
+		return fmt.Errorf("failed to stat: %w", err)
+	}
+	if info.IsDir() {
+		return filesystem.NewInvalidArgumentError("path", path, "content type can only be set on files")
+	}
+	// Snapshots share the inode, and with it the attribute
+	if err := fs.breakLink(localPath); err != nil {
+		return err
+	}
+	if err := setContentType(localPath, contentType); err != nil {
+		return fmt.Errorf("failed to set content type: %w", err)
+	}
+	return nil
+}
+
+// copyContentType carries the stored media type of from over to to, which
+// is about to replace it. Best effort: the content is what matters.
+func copyContentType(from, to string) {
+	if contentType := getContentType(from); contentType != "" {
+		setContentType(to, contentType)
+	}
+}
+
 func (fs *LocalFS) Create(path string) error {
 	localPath := fs.resolvePath(path)
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	fs.reclaimExpired(path, localPath)
+
 	// Check if file already exists
 	if _, err := os.Stat(localPath); err == nil {
 		return fmt.Errorf("file already exists: %s", path)
@@ -91,12 +204,54 @@ func (fs *LocalFS) Create(path string) error {
 	return nil
 }
 
+// Reserve implements filesystem.Reserver: the file is created empty and,
+// on Linux, size bytes of disk space are allocated to it up front, so a
+// disk too full for the write fails here rather than halfway through it
+func (fs *LocalFS) Reserve(path string, size int64) error {
+	if err := filesystem.ValidateReserveSize(size); err != nil {
+		return err
+	}
+	localPath := fs.resolvePath(path)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.reclaimExpired(path, localPath)
+
+	// Check if parent directory exists
+	parentDir := filepath.Dir(localPath)
+	if _, err := os.Stat(parentDir); os.IsNotExist(err) {
+		return fmt.Errorf("parent directory does not exist: %s", filepath.Dir(path))
+	}
+
+	f, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("file already exists: %s", path)
+		}
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer f.Close()
+
+	if size > 0 {
+		if err := preallocate(f, size); err != nil {
+			os.Remove(localPath)
+			return fmt.Errorf("failed to preallocate %d bytes: %w", size, err)
+		}
+	}
+	fs.reservations.Set(path, size)
+
+	return nil
+}
+
 func (fs *LocalFS) Mkdir(path string, perm uint32) error {
 	localPath := fs.resolvePath(path)
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	fs.reclaimExpired(path, localPath)
+
 	// Check if directory already exists
 	if _, err := os.Stat(localPath); err == nil {
 		return fmt.Errorf("directory already exists: %s", path)
@@ -148,6 +303,8 @@ func (fs *LocalFS) Remove(path string) error {
 	if err != nil {
 		return fmt.Errorf("failed to remove: %w", err)
 	}
+	fs.expiry.Remove(path)
+	fs.reservations.Remove(path)
 
 	return nil
 }
@@ -168,6 +325,8 @@ func (fs *LocalFS) RemoveAll(path string) error {
 	if err != nil {
 		return fmt.Errorf("failed to remove: %w", err)
 	}
+	fs.expiry.Remove(path)
+	fs.reservations.Remove(path)
 
 	return nil
 }
@@ -178,6 +337,10 @@ func (fs *LocalFS) Read(path string, offset int64, size int64) ([]byte, error) {
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
+	if err := fs.checkExpired("read", path); err != nil {
+		return nil, err
+	}
+
 	// Check if exists and is not a directory
 	info, err := os.Stat(localPath)
 	if err != nil {
@@ -242,6 +405,8 @@ func (fs *LocalFS) Write(path string, data []byte, offset int64, flags filesyste
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	fs.reclaimExpired(path, localPath)
+
 	// Check if it's a directory
 	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
 		return 0, fmt.Errorf("is a directory: %s", path)
@@ -253,6 +418,12 @@ func (fs *LocalFS) Write(path string, data []byte, offset int64, flags filesyste
 		return 0, fmt.Errorf("parent directory does not exist: %s", filepath.Dir(path))
 	}
 
+	if flags&filesystem.WriteFlagAtomic != 0 {
+		n, err := fs.writeAtomic(path, localPath, data, offset, flags)
+		fs.reservations.Set(path, 0)
+		return n, err
+	}
+
 	// Build open flags
 	openFlags := os.O_WRONLY
 	if flags&filesystem.WriteFlagCreate != 0 {
@@ -273,6 +444,18 @@ func (fs *LocalFS) Write(path string, data []byte, offset int64, flags filesyste
 		openFlags |= os.O_CREATE | os.O_TRUNC
 	}
 
+	// O_TRUNC would free the space preallocated for a reserved file: it is
+	// truncated to the end of the write instead
+	truncateAfter := false
+	if openFlags&os.O_TRUNC != 0 && fs.reservations.Get(path) > 0 {
+		openFlags &^= os.O_TRUNC
+		truncateAfter = true
+	}
+
+	if err := fs.breakLink(localPath); err != nil {
+		return 0, err
+	}
+
 	f, err := os.OpenFile(localPath, openFlags, 0644)
 	if err != nil {
 		return 0, fmt.Errorf("failed to open file: %w", err)
@@ -292,6 +475,19 @@ func (fs *LocalFS) Write(path string, data []byte, offset int64, flags filesyste
 		return 0, fmt.Errorf("failed to write: %w", err)
 	}
 
+	end := int64(n)
+	if offset >= 0 && flags&filesystem.WriteFlagAppend == 0 {
+		end += offset
+	}
+	if truncateAfter {
+		if err := f.Truncate(end); err != nil {
+			return 0, fmt.Errorf("failed to truncate: %w", err)
+		}
+	}
+	if flags&filesystem.WriteFlagAppend == 0 {
+		fs.reservations.Written(path, end)
+	}
+
 	if flags&filesystem.WriteFlagSync != 0 {
 		f.Sync()
 	}
@@ -299,6 +495,96 @@ func (fs *LocalFS) Write(path string, data []byte, offset int64, flags filesyste
 	return int64(n), nil
 }
 
+// writeAtomic writes data to a temp file in the same directory and renames it
+// over the target, so readers never observe a partially written file.
+// Caller must hold fs.mu.
+func (fs *LocalFS) writeAtomic(path, localPath string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	if err := filesystem.ValidateAtomicWrite(path, offset, flags); err != nil {
+		return 0, err
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(localPath); err == nil {
+		if flags&filesystem.WriteFlagExclusive != 0 {
+			return 0, fmt.Errorf("file already exists: %s", path)
+		}
+		mode = info.Mode().Perm()
+	} else if os.IsNotExist(err) {
+		if flags&filesystem.WriteFlagCreate == 0 {
+			return 0, fmt.Errorf("failed to open file: %w", err)
+		}
+	} else {
+		return 0, fmt.Errorf("failed to stat: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".agfs-tmp-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	n, err := tmp.Write(data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write: %w", err)
+	}
+	if flags&filesystem.WriteFlagSync != 0 {
+		if err := tmp.Sync(); err != nil {
+			return 0, fmt.Errorf("failed to sync: %w", err)
+		}
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return 0, fmt.Errorf("failed to chmod: %w", err)
+	}
+	copyContentType(localPath, tmpPath)
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		return 0, fmt.Errorf("failed to replace file: %w", err)
+	}
+	committed = true
+
+	return int64(n), nil
+}
+
+// Checksum implements filesystem.Checksummer by hashing the file on local disk
+func (fs *LocalFS) Checksum(path string, algorithm string) (string, error) {
+	localPath := fs.resolvePath(path)
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	if err := fs.checkExpired("checksum", path); err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", filesystem.NewNotFoundError("checksum", path)
+		}
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat: %w", err)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("is a directory: %s", path)
+	}
+
+	return filesystem.ChecksumReader(f, algorithm)
+}
+
 func (fs *LocalFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	localPath := fs.resolvePath(path)
 
@@ -326,6 +612,10 @@ func (fs *LocalFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 
 	var files []filesystem.FileInfo
 	for _, entry := range entries {
+		entryPath := filepath.Join(path, entry.Name())
+		if fs.expiry.Expired(entryPath) {
+			continue
+		}
 		entryInfo, err := entry.Info()
 		if err != nil {
 			continue
@@ -341,6 +631,7 @@ func (fs *LocalFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 				Name: PluginName,
 				Type: "local",
 			},
+			ExpiresAt: fs.expiry.Get(entryPath),
 		})
 	}
 
@@ -353,6 +644,10 @@ func (fs *LocalFS) Stat(path string) (*filesystem.FileInfo, error) {
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
+	if err := fs.checkExpired("stat", path); err != nil {
+		return nil, err
+	}
+
 	// Get file info
 	info, err := os.Stat(localPath)
 	if err != nil {
@@ -362,20 +657,30 @@ func (fs *LocalFS) Stat(path string) (*filesystem.FileInfo, error) {
 		return nil, fmt.Errorf("failed to stat: %w", err)
 	}
 
-	return &filesystem.FileInfo{
+	content := map[string]string{
+		"local_path": localPath,
+	}
+	if !info.IsDir() {
+		if contentType := getContentType(localPath); contentType != "" {
+			content[filesystem.MetaContentType] = contentType
+		}
+	}
+
+	fileInfo := &filesystem.FileInfo{
 		Name:    info.Name(),
 		Size:    info.Size(),
 		Mode:    uint32(info.Mode()),
 		ModTime: info.ModTime(),
 		IsDir:   info.IsDir(),
 		Meta: filesystem.MetaData{
-			Name: PluginName,
-			Type: "local",
-			Content: map[string]string{
-				"local_path": localPath,
-			},
+			Name:    PluginName,
+			Type:    "local",
+			Content: content,
 		},
-	}, nil
+		ExpiresAt: fs.expiry.Get(path),
+	}
+	fs.reservations.Annotate(path, fileInfo)
+	return fileInfo, nil
 }
 
 func (fs *LocalFS) Rename(oldPath, newPath string) error {
@@ -385,6 +690,11 @@ func (fs *LocalFS) Rename(oldPath, newPath string) error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	if err := fs.checkExpired("rename", oldPath); err != nil {
+		return err
+	}
+	fs.reclaimExpired(newPath, newLocalPath)
+
 	// Check if old path exists
 	if _, err := os.Stat(oldLocalPath); os.IsNotExist(err) {
 		return fmt.Errorf("no such file or directory: %s", oldPath)
@@ -401,6 +711,10 @@ func (fs *LocalFS) Rename(oldPath, newPath string) error {
 	if err != nil {
 		return fmt.Errorf("failed to rename: %w", err)
 	}
+	fs.expiry.Remove(newPath)
+	fs.expiry.Rename(oldPath, newPath)
+	fs.reservations.Remove(newPath)
+	fs.reservations.Rename(oldPath, newPath)
 
 	return nil
 }
@@ -411,11 +725,19 @@ func (fs *LocalFS) Chmod(path string, mode uint32) error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	if err := fs.checkExpired("chmod", path); err != nil {
+		return err
+	}
+
 	// Check if exists
 	if _, err := os.Stat(localPath); os.IsNotExist(err) {
 		return fmt.Errorf("no such file or directory: %s", path)
 	}
 
+	if err := fs.breakLink(localPath); err != nil {
+		return err
+	}
+
 	// Change permissions
 	err := os.Chmod(localPath, os.FileMode(mode))
 	if err != nil {
@@ -431,6 +753,10 @@ func (fs *LocalFS) Open(path string) (io.ReadCloser, error) {
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
+	if err := fs.checkExpired("open", path); err != nil {
+		return nil, err
+	}
+
 	// Open file
 	f, err := os.Open(localPath)
 	if err != nil {
@@ -449,12 +775,28 @@ func (fs *LocalFS) OpenWrite(path string) (io.WriteCloser, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	fs.reclaimExpired(path, localPath)
+
 	// Check if parent directory exists
 	parentDir := filepath.Dir(localPath)
 	if _, err := os.Stat(parentDir); os.IsNotExist(err) {
 		return nil, fmt.Errorf("parent directory does not exist: %s", filepath.Dir(path))
 	}
 
+	if err := fs.breakLink(localPath); err != nil {
+		return nil, err
+	}
+
+	// A reserved file keeps its preallocated space, and is truncated to
+	// what was written when closed
+	if fs.reservations.Get(path) > 0 {
+		f, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file for writing: %w", err)
+		}
+		return &reservedWriter{file: f, fs: fs, path: path}, nil
+	}
+
 	// Open file for writing (create if not exists, truncate if exists)
 	f, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
@@ -464,6 +806,30 @@ func (fs *LocalFS) OpenWrite(path string) (io.WriteCloser, error) {
 	return f, nil
 }
 
+// reservedWriter writes a reserved file from its start, truncating it to
+// the bytes written when closed
+type reservedWriter struct {
+	file    *os.File
+	fs      *LocalFS
+	path    string
+	written int64
+}
+
+func (w *reservedWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *reservedWriter) Close() error {
+	err := w.file.Truncate(w.written)
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.fs.reservations.Set(w.path, 0)
+	return err
+}
+
 // localFSStreamReader implements filesystem.StreamReader for local files
 type localFSStreamReader struct {
 	file      *os.File
@@ -590,6 +956,10 @@ func (fs *LocalFS) OpenStream(path string) (filesystem.StreamReader, error) {
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
+	if err := fs.checkExpired("open", path); err != nil {
+		return nil, err
+	}
+
 	// Check if file exists and is not a directory
 	info, err := os.Stat(localPath)
 	if err != nil {
@@ -623,6 +993,7 @@ func (fs *LocalFS) OpenStream(path string) (filesystem.StreamReader, error) {
 type LocalFSPlugin struct {
 	fs       *LocalFS
 	basePath string
+	stopCh   chan struct{} // Stops the expiry sweeper
 }
 
 // NewLocalFSPlugin creates a new LocalFS plugin
@@ -682,6 +1053,9 @@ func (p *LocalFSPlugin) Initialize(config map[string]interface{}) error {
 	}
 	p.fs = fs
 
+	p.stopCh = make(chan struct{})
+	filesystem.StartExpirySweeper(fs.expiry, expirySweepInterval, p.stopCh, fs.removeExpired)
+
 	log.Infof("[localfs] Initialized with base path: %s", basePath)
 	return nil
 }
@@ -775,6 +1149,9 @@ EXAMPLES:
 
 NOTES:
   - Changes are directly applied to the local file system
+  - Files written with a TTL (X-AGFS-TTL header) are hidden once expired
+    and deleted within a minute; TTLs are kept in memory and are lost
+    on restart
   - File permissions are preserved and can be modified
   - Symlinks are followed by default
   - Be careful with rm -r as it permanently deletes files
@@ -814,6 +1191,10 @@ func (p *LocalFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 
 func (p *LocalFSPlugin) Shutdown() error {
 	log.Infof("[localfs] Shutting down")
+	if p.stopCh != nil {
+		close(p.stopCh)
+		p.stopCh = nil
+	}
 	return nil
 }
 
@@ -824,6 +1205,10 @@ func (fs *LocalFS) Truncate(path string, size int64) error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	if err := fs.checkExpired("truncate", path); err != nil {
+		return err
+	}
+
 	// Check if file exists
 	info, err := os.Stat(localPath)
 	if err != nil {
@@ -838,6 +1223,10 @@ func (fs *LocalFS) Truncate(path string, size int64) error {
 		return fmt.Errorf("is a directory: %s", path)
 	}
 
+	if err := fs.breakLink(localPath); err != nil {
+		return err
+	}
+
 	// Truncate the file
 	err = os.Truncate(localPath, size)
 	if err != nil {
@@ -851,3 +1240,6 @@ func (fs *LocalFS) Truncate(path string, size int64) error {
 var _ plugin.ServicePlugin = (*LocalFSPlugin)(nil)
 var _ filesystem.FileSystem = (*LocalFS)(nil)
 var _ filesystem.Truncater = (*LocalFS)(nil)
+var _ filesystem.Checksummer = (*LocalFS)(nil)
+var _ filesystem.Expirer = (*LocalFS)(nil)
+var _ filesystem.ContentTyper = (*LocalFS)(nil)
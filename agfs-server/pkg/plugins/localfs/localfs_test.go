@@ -2,10 +2,12 @@ package localfs
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 )
@@ -269,6 +271,87 @@ func TestLocalFSWriteNonExistent(t *testing.T) {
 	}
 }
 
+func TestLocalFSWriteAtomic(t *testing.T) {
+	dir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	fs := newTestFS(t, dir)
+	path := "/atomic.txt"
+
+	// Atomic write without create on a missing file should fail
+	if _, err := fs.Write(path, []byte("x"), -1, filesystem.WriteFlagAtomic); err == nil {
+		t.Error("Expected error for atomic write to non-existent file without create flag")
+	}
+
+	_, err := fs.Write(path, []byte("first version"), -1, filesystem.WriteFlagCreate|filesystem.WriteFlagAtomic)
+	if err != nil {
+		t.Fatalf("Atomic create failed: %v", err)
+	}
+	if err := fs.Chmod(path, 0600); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+
+	_, err = fs.Write(path, []byte("v2"), -1, filesystem.WriteFlagAtomic|filesystem.WriteFlagSync)
+	if err != nil {
+		t.Fatalf("Atomic replace failed: %v", err)
+	}
+
+	content, err := readIgnoreEOF(fs, path)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(content) != "v2" {
+		t.Errorf("Content mismatch: got %q, want %q", string(content), "v2")
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "atomic.txt"))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Atomic write should preserve mode, got %o", info.Mode().Perm())
+	}
+
+	// No temp files should be left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only the target file, found %d entries", len(entries))
+	}
+
+	// Atomic writes are whole-file only
+	if _, err := fs.Write(path, []byte("x"), -1, filesystem.WriteFlagAtomic|filesystem.WriteFlagAppend); err == nil {
+		t.Error("Expected error for atomic append")
+	}
+	if _, err := fs.Write(path, []byte("x"), 5, filesystem.WriteFlagAtomic); err == nil {
+		t.Error("Expected error for atomic write at offset")
+	}
+}
+
+func TestLocalFSChecksum(t *testing.T) {
+	dir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	fs := newTestFS(t, dir)
+	if _, err := fs.Write("/sum.txt", []byte("hello world"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	sum, err := fs.Checksum("/sum.txt", filesystem.ChecksumSHA256)
+	if err != nil {
+		t.Fatalf("Checksum failed: %v", err)
+	}
+	if want := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"; sum != want {
+		t.Errorf("Checksum mismatch: got %s, want %s", sum, want)
+	}
+
+	if _, err := fs.Checksum("/sum.txt", "crc99"); err == nil {
+		t.Error("Expected error for unsupported algorithm")
+	}
+	if _, err := fs.Checksum("/missing.txt", filesystem.ChecksumSHA256); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
 func TestLocalFSReadWithOffset(t *testing.T) {
 	dir, cleanup := setupTestDir(t)
 	defer cleanup()
@@ -666,6 +749,111 @@ func TestLocalFSOpenWrite(t *testing.T) {
 	}
 }
 
+func TestLocalFSReserve(t *testing.T) {
+	dir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	fs := newTestFS(t, dir)
+
+	if err := fs.Reserve("/big.bin", -1); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("Reserve(-1) error = %v, want invalid argument", err)
+	}
+	if err := fs.Reserve("/big.bin", 1000); err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if err := fs.Reserve("/big.bin", 1000); err == nil {
+		t.Error("Reserve of an existing file succeeded")
+	}
+
+	// The placeholder is empty, with the size hint as the target
+	info, err := fs.Stat("/big.bin")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size != 0 || info.Meta.Content[filesystem.MetaReservedSize] != "1000" {
+		t.Errorf("Stat = size %d, meta %v; want an empty file reserved for 1000 bytes", info.Size, info.Meta.Content)
+	}
+
+	// Streamed writes show their progress and keep what was written
+	w, err := fs.OpenWrite("/big.bin")
+	if err != nil {
+		t.Fatalf("OpenWrite failed: %v", err)
+	}
+	w.Write(bytes.Repeat([]byte("a"), 400))
+	if info, _ := fs.Stat("/big.bin"); info.Size != 400 || info.Meta.Content[filesystem.MetaReservedSize] != "1000" {
+		t.Errorf("Stat during the write = size %d, meta %v", info.Size, info.Meta.Content)
+	}
+	w.Write(bytes.Repeat([]byte("b"), 200))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	info, _ = fs.Stat("/big.bin")
+	if info.Size != 600 {
+		t.Errorf("Size after the write = %d, want 600", info.Size)
+	}
+	if _, ok := info.Meta.Content[filesystem.MetaReservedSize]; ok {
+		t.Error("reservation kept after the write completed")
+	}
+
+	// Whole-file writes replace the content like any truncating write
+	fs.Reserve("/small.txt", 100)
+	if _, err := fs.Write("/small.txt", []byte("hello"), -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if content, _ := readIgnoreEOF(fs, "/small.txt"); string(content) != "hello" {
+		t.Errorf("content = %q, want hello", content)
+	}
+	fs.Remove("/small.txt")
+	if fs.reservations.Get("/small.txt") != 0 {
+		t.Error("reservation kept after Remove")
+	}
+}
+
+func TestLocalFSUpload(t *testing.T) {
+	dir, cleanup := setupTestDir(t)
+	defer cleanup()
+
+	fs := newTestFS(t, dir)
+	fs.Write("/data.bin", []byte("old"), -1, filesystem.WriteFlagCreate)
+	fs.Chmod("/data.bin", 0600)
+
+	const partSize = filesystem.MinUploadPartSize
+	upload, err := fs.BeginUpload("/data.bin", partSize)
+	if err != nil {
+		t.Fatalf("BeginUpload failed: %v", err)
+	}
+	// Parts out of order; the file keeps its content until the commit
+	if err := upload.WritePart(1, []byte("tail")); err != nil {
+		t.Fatalf("WritePart(1) failed: %v", err)
+	}
+	if err := upload.WritePart(0, bytes.Repeat([]byte("a"), partSize)); err != nil {
+		t.Fatalf("WritePart(0) failed: %v", err)
+	}
+	if content, _ := readIgnoreEOF(fs, "/data.bin"); string(content) != "old" {
+		t.Errorf("content before the commit = %q, want old", content)
+	}
+	if err := upload.Commit(partSize + 4); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	info, err := fs.Stat("/data.bin")
+	if err != nil || info.Size != partSize+4 || info.Mode != 0600 {
+		t.Errorf("Stat after the commit = %+v, %v; want %d bytes with mode 0600", info, err, partSize+4)
+	}
+
+	// An aborted upload leaves nothing behind
+	upload, err = fs.BeginUpload("/other.bin", partSize)
+	if err != nil {
+		t.Fatalf("BeginUpload failed: %v", err)
+	}
+	upload.WritePart(0, []byte("x"))
+	if err := upload.Abort(); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory after the abort holds %d entries, want only data.bin", len(entries))
+	}
+}
+
 func TestLocalFSOpen(t *testing.T) {
 	dir, cleanup := setupTestDir(t)
 	defer cleanup()
@@ -729,3 +917,59 @@ func TestLocalFSRemoveAll(t *testing.T) {
 		t.Error("Directory should be removed")
 	}
 }
+
+func TestLocalFSExpiry(t *testing.T) {
+	dir, cleanup := setupTestDir(t)
+	defer cleanup()
+	fs := newTestFS(t, dir)
+
+	if _, err := fs.Write("/scratch.txt", []byte("tmp"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := fs.SetExpiry("/scratch.txt", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("SetExpiry failed: %v", err)
+	}
+
+	// Expired but not yet swept: hidden from every read path
+	if _, err := fs.Stat("/scratch.txt"); err == nil {
+		t.Error("Stat of expired file should fail")
+	}
+	if _, err := fs.Read("/scratch.txt", 0, -1); err == nil {
+		t.Error("Read of expired file should fail")
+	}
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("ReadDir returned expired file: %v", entries)
+	}
+
+	// The sweeper deletes it from disk
+	if err := fs.removeExpired("/scratch.txt"); err != nil {
+		t.Fatalf("removeExpired failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "scratch.txt")); !os.IsNotExist(err) {
+		t.Errorf("expired file still on disk: %v", err)
+	}
+
+	// TTLs follow renames and are dropped on remove
+	fs.Write("/a.txt", []byte("a"), -1, filesystem.WriteFlagCreate)
+	expiresAt := time.Now().Add(time.Hour)
+	fs.SetExpiry("/a.txt", expiresAt)
+	if err := fs.Rename("/a.txt", "/b.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	info, err := fs.Stat("/b.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if !info.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("ExpiresAt after rename = %v, want %v", info.ExpiresAt, expiresAt)
+	}
+	fs.Remove("/b.txt")
+	fs.Write("/b.txt", []byte("b"), -1, filesystem.WriteFlagCreate)
+	if info, _ := fs.Stat("/b.txt"); !info.ExpiresAt.IsZero() {
+		t.Errorf("recreated file inherited ExpiresAt %v", info.ExpiresAt)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build linux
+
+package localfs
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate allocates size bytes of disk space for f without changing
+// its size, so a write of that size can't run out of space halfway. File
+// systems without fallocate are left as they are.
+func preallocate(f *os.File, size int64) error {
+	err := unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package localfs
+
+import "os"
+
+// preallocate does nothing: space is only preallocated on Linux
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
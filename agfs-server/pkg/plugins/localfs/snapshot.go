@@ -0,0 +1,231 @@
+package localfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// snapshotBuildSuffix marks a snapshot that is still being linked; such
+// directories are not valid snapshot IDs and are never listed
+const snapshotBuildSuffix = ".partial"
+
+// snapshotRoot is where snapshots are kept, inside the mounted directory
+func (fs *LocalFS) snapshotRoot() string {
+	return filepath.Join(fs.basePath, filesystem.SnapshotsDir)
+}
+
+// snapshotDir returns the directory of snapshot id, rejecting anything that
+// is not a snapshot ID so that id cannot escape the snapshot root
+func (fs *LocalFS) snapshotDir(id string) (string, error) {
+	if _, err := filesystem.ParseSnapshotID(id); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(fs.snapshotRoot(), id)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", filesystem.NewNotFoundError("snapshot", id)
+	}
+	return dir, nil
+}
+
+// CreateSnapshot builds a hardlink farm of the mounted directory under
+// .snapshots/<id>. Files are not copied; writes through LocalFS break the
+// link first, so the snapshot keeps the old content.
+func (fs *LocalFS) CreateSnapshot(path string) (*filesystem.Snapshot, error) {
+	if err := filesystem.CheckSnapshotRoot(path); err != nil {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	root := fs.snapshotRoot()
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	now := time.Now()
+	id := filesystem.NewSnapshotID(now)
+	for {
+		if _, err := os.Lstat(filepath.Join(root, id)); os.IsNotExist(err) {
+			break
+		}
+		now = now.Add(time.Nanosecond)
+		id = filesystem.NewSnapshotID(now)
+	}
+
+	build := filepath.Join(root, id+snapshotBuildSuffix)
+	if err := fs.linkTree(build); err != nil {
+		os.RemoveAll(build)
+		return nil, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	if err := os.Rename(build, filepath.Join(root, id)); err != nil {
+		os.RemoveAll(build)
+		return nil, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	return &filesystem.Snapshot{ID: id, CreatedAt: now.UTC()}, nil
+}
+
+// linkTree recreates the directory tree under dest, hard linking files and
+// copying symlinks. Snapshots and expired files are left out.
+// Caller must hold fs.mu.
+func (fs *LocalFS) linkTree(dest string) error {
+	return filepath.WalkDir(fs.basePath, func(localPath string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(fs.basePath, localPath)
+		if err != nil {
+			return err
+		}
+		if rel == filesystem.SnapshotsDir {
+			return filepath.SkipDir
+		}
+		if rel != "." && fs.expiry.Expired("/"+filepath.ToSlash(rel)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		target := filepath.Join(dest, rel)
+		switch {
+		case d.IsDir():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return os.Mkdir(target, info.Mode().Perm())
+		case d.Type()&os.ModeSymlink != 0:
+			link, err := os.Readlink(localPath)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return os.Link(localPath, target)
+		default:
+			// Sockets, devices and pipes are not snapshotted
+			return nil
+		}
+	})
+}
+
+// ListSnapshots returns the snapshots, oldest first
+func (fs *LocalFS) ListSnapshots(path string) ([]filesystem.Snapshot, error) {
+	if err := filesystem.CheckSnapshotRoot(path); err != nil {
+		return nil, err
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	entries, err := os.ReadDir(fs.snapshotRoot())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []filesystem.Snapshot{}, nil
+		}
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	snapshots := make([]filesystem.Snapshot, 0, len(entries))
+	for _, entry := range entries {
+		createdAt, err := filesystem.ParseSnapshotID(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		snapshots = append(snapshots, filesystem.Snapshot{ID: entry.Name(), CreatedAt: createdAt})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].ID < snapshots[j].ID })
+	return snapshots, nil
+}
+
+// DeleteSnapshot removes a snapshot's hardlink farm
+func (fs *LocalFS) DeleteSnapshot(path string, id string) error {
+	if err := filesystem.CheckSnapshotRoot(path); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	dir, err := fs.snapshotDir(id)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+	return nil
+}
+
+// OpenSnapshot returns a read-only LocalFS rooted at the snapshot
+func (fs *LocalFS) OpenSnapshot(path string, id string) (filesystem.FileSystem, error) {
+	if err := filesystem.CheckSnapshotRoot(path); err != nil {
+		return nil, err
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	dir, err := fs.snapshotDir(id)
+	if err != nil {
+		return nil, err
+	}
+	view, err := NewLocalFS(dir)
+	if err != nil {
+		return nil, err
+	}
+	return filesystem.NewReadOnlyFileSystem(view), nil
+}
+
+// breakLink gives localPath its own inode if it shares one with a snapshot,
+// by copying it and renaming the copy over it. Must be called before a file
+// is modified in place. Caller must hold fs.mu.
+func (fs *LocalFS) breakLink(localPath string) error {
+	info, err := os.Lstat(localPath)
+	if err != nil || !info.Mode().IsRegular() || !fs.sharesInode(info) {
+		return nil
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to copy shared file: %w", err)
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to copy shared file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	_, err = io.Copy(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, info.Mode().Perm())
+	}
+	if err == nil {
+		copyContentType(localPath, tmpPath)
+	}
+	if err == nil {
+		err = os.Chtimes(tmpPath, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, localPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to copy shared file: %w", err)
+	}
+	return nil
+}
+
+var _ filesystem.Snapshotter = (*LocalFS)(nil)
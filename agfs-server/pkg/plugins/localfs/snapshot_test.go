@@ -0,0 +1,88 @@
+package localfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func TestLocalFSSnapshot(t *testing.T) {
+	dir, cleanup := setupTestDir(t)
+	defer cleanup()
+	fs := newTestFS(t, dir)
+
+	if err := fs.Mkdir("/docs", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	for path, data := range map[string]string{"/a.txt": "original", "/docs/b.txt": "bee", "/c.txt": "sea"} {
+		if _, err := fs.Write(path, []byte(data), -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+			t.Fatalf("Write(%s) failed: %v", path, err)
+		}
+	}
+
+	snap, err := fs.CreateSnapshot("/")
+	if err != nil {
+		t.Fatalf("CreateSnapshot failed: %v", err)
+	}
+
+	// Every way of modifying a file in place must leave the snapshot alone
+	if _, err := fs.Write("/a.txt", []byte("O"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("offset Write failed: %v", err)
+	}
+	if err := fs.Truncate("/docs/b.txt", 1); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	w, err := fs.OpenWrite("/c.txt")
+	if err != nil {
+		t.Fatalf("OpenWrite failed: %v", err)
+	}
+	w.Write([]byte("ocean"))
+	w.Close()
+
+	view, err := fs.OpenSnapshot("/", snap.ID)
+	if err != nil {
+		t.Fatalf("OpenSnapshot failed: %v", err)
+	}
+	for path, want := range map[string]string{"/a.txt": "original", "/docs/b.txt": "bee", "/c.txt": "sea"} {
+		data, err := view.Read(path, 0, -1)
+		if err != nil && len(data) == 0 {
+			t.Fatalf("snapshot Read(%s) failed: %v", path, err)
+		}
+		if string(data) != want {
+			t.Errorf("snapshot %s = %q, want %q", path, data, want)
+		}
+	}
+	if got, _ := readIgnoreEOF(fs, "/a.txt"); string(got) != "Original" {
+		t.Errorf("live a.txt = %q, want Original", got)
+	}
+
+	// Snapshots do not contain each other
+	second, err := fs.CreateSnapshot("/")
+	if err != nil {
+		t.Fatalf("second CreateSnapshot failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, filesystem.SnapshotsDir, second.ID, filesystem.SnapshotsDir)); !os.IsNotExist(err) {
+		t.Errorf("snapshot contains .snapshots: %v", err)
+	}
+
+	if _, err := view.Write("/a.txt", []byte("x"), -1, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Write to snapshot error = %v, want permission denied", err)
+	}
+
+	snapshots, err := fs.ListSnapshots("/")
+	if err != nil || len(snapshots) != 2 || snapshots[0].ID != snap.ID {
+		t.Fatalf("ListSnapshots = %v, %v", snapshots, err)
+	}
+	if err := fs.DeleteSnapshot("/", snap.ID); err != nil {
+		t.Fatalf("DeleteSnapshot failed: %v", err)
+	}
+	if err := fs.DeleteSnapshot("/", "../docs"); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("DeleteSnapshot(../docs) error = %v, want invalid argument", err)
+	}
+	if _, err := fs.Stat("/docs"); err != nil {
+		t.Errorf("docs removed by DeleteSnapshot: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package localfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// localUpload assembles an upload in a temporary file next to the file,
+// which replaces it when committed, as atomic writes do
+type localUpload struct {
+	fs        *LocalFS
+	path      string
+	localPath string
+	partSize  int64
+	tmp       *os.File
+}
+
+// BeginUpload implements filesystem.Uploader
+func (fs *LocalFS) BeginUpload(path string, partSize int64) (filesystem.Upload, error) {
+	if err := filesystem.ValidateUploadPartSize(partSize); err != nil {
+		return nil, err
+	}
+	localPath := fs.resolvePath(path)
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		return nil, fmt.Errorf("is a directory: %s", path)
+	}
+	parentDir := filepath.Dir(localPath)
+	if _, err := os.Stat(parentDir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("parent directory does not exist: %s", filepath.Dir(path))
+	}
+	tmp, err := os.CreateTemp(parentDir, "."+filepath.Base(localPath)+".agfs-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	return &localUpload{fs: fs, path: path, localPath: localPath, partSize: partSize, tmp: tmp}, nil
+}
+
+func (u *localUpload) WritePart(i int, data []byte) error {
+	if _, err := u.tmp.WriteAt(data, int64(i)*u.partSize); err != nil {
+		return fmt.Errorf("failed to write part %d: %w", i, err)
+	}
+	return nil
+}
+
+func (u *localUpload) Commit(size int64) error {
+	fs := u.fs
+	committed := false
+	defer func() {
+		if !committed {
+			u.Abort()
+		}
+	}()
+
+	if err := u.tmp.Truncate(size); err != nil {
+		return fmt.Errorf("failed to truncate upload file: %w", err)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.reclaimExpired(u.path, u.localPath)
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(u.localPath); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("is a directory: %s", u.path)
+		}
+		mode = info.Mode().Perm()
+	}
+	if err := u.tmp.Chmod(mode); err != nil {
+		return fmt.Errorf("failed to chmod: %w", err)
+	}
+	copyContentType(u.localPath, u.tmp.Name())
+	if err := u.tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close upload file: %w", err)
+	}
+	if err := os.Rename(u.tmp.Name(), u.localPath); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+	committed = true
+	fs.reservations.Set(u.path, 0)
+	return nil
+}
+
+func (u *localUpload) Abort() error {
+	u.tmp.Close()
+	return os.Remove(u.tmp.Name())
+}
+
+// Ensure LocalFS implements Uploader interface
+var _ filesystem.Uploader = (*LocalFS)(nil)
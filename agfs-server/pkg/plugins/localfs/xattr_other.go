@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package localfs
+
+import "github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+
+// getContentType returns "": extended attributes are not supported here
+func getContentType(localPath string) string {
+	return ""
+}
+
+// setContentType fails: extended attributes are not supported here
+func setContentType(localPath, contentType string) error {
+	return filesystem.ErrNotSupported
+}
@@ -0,0 +1,30 @@
+//go:build linux || darwin
+
+package localfs
+
+import "golang.org/x/sys/unix"
+
+// contentTypeXattr is the extended attribute holding a file's media type,
+// as used by freedesktop.org tools
+const contentTypeXattr = "user.mime_type"
+
+// getContentType returns the media type stored on localPath, or ""
+func getContentType(localPath string) string {
+	buf := make([]byte, 256)
+	n, err := unix.Getxattr(localPath, contentTypeXattr, buf)
+	if err != nil || n <= 0 {
+		return ""
+	}
+	return string(buf[:n])
+}
+
+// setContentType stores contentType on localPath; "" removes it
+func setContentType(localPath, contentType string) error {
+	if contentType == "" {
+		if getContentType(localPath) == "" {
+			return nil
+		}
+		return unix.Removexattr(localPath, contentTypeXattr)
+	}
+	return unix.Setxattr(localPath, contentTypeXattr, []byte(contentType), 0)
+}
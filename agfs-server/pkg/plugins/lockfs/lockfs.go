@@ -0,0 +1,541 @@
+package lockfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PluginName = "lockfs"
+
+	acquireFile = "acquire"
+	releaseFile = "release"
+	holderFile  = "holder"
+)
+
+// defaultTTL is how long a lease lasts when neither the request nor the
+// configuration says otherwise
+const defaultTTL = 30 * time.Second
+
+// Lease describes who holds a named lock. Token increases every time the
+// lock changes hands, so holders can pass it along as a fencing token.
+type Lease struct {
+	Name       string     `json:"name"`
+	Holder     string     `json:"holder"`
+	Token      uint64     `json:"token"`
+	AcquiredAt *time.Time `json:"acquired_at,omitempty"`
+	RenewedAt  *time.Time `json:"renewed_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// lock is the state of a named lock; it is free when holder is empty or
+// the lease has expired
+type lock struct {
+	name       string
+	holder     string
+	token      uint64
+	acquiredAt time.Time
+	renewedAt  time.Time
+	expiresAt  time.Time
+}
+
+func (l *lock) held(now time.Time) bool {
+	return l.holder != "" && now.Before(l.expiresAt)
+}
+
+func (l *lock) lease(now time.Time) *Lease {
+	lease := &Lease{Name: l.name, Token: l.token}
+	if l.held(now) {
+		acquiredAt, renewedAt, expiresAt := l.acquiredAt, l.renewedAt, l.expiresAt
+		lease.Holder = l.holder
+		lease.AcquiredAt, lease.RenewedAt, lease.ExpiresAt = &acquiredAt, &renewedAt, &expiresAt
+	}
+	return lease
+}
+
+// LockFSPlugin provides named locks held as leases: a holder keeps a lock
+// by renewing it before its TTL runs out, so the lock frees itself when the
+// holder dies. Electing a leader is acquiring a well-known lock.
+type LockFSPlugin struct {
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+	now        func() time.Time
+
+	mu    sync.Mutex
+	locks map[string]*lock
+}
+
+// NewLockFSPlugin creates a new LockFS plugin
+func NewLockFSPlugin() *LockFSPlugin {
+	return &LockFSPlugin{
+		defaultTTL: defaultTTL,
+		now:        time.Now,
+		locks:      make(map[string]*lock),
+	}
+}
+
+func (p *LockFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *LockFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"default_ttl", "max_ttl", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	for _, key := range []string{"default_ttl", "max_ttl"} {
+		if s := config.GetStringConfig(cfg, key, ""); s != "" {
+			if _, err := filesystem.ParseTTL(s); err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *LockFSPlugin) Initialize(cfg map[string]interface{}) error {
+	if s := config.GetStringConfig(cfg, "default_ttl", ""); s != "" {
+		d, err := filesystem.ParseTTL(s)
+		if err != nil {
+			return fmt.Errorf("invalid default_ttl: %w", err)
+		}
+		if d > 0 {
+			p.defaultTTL = d
+		}
+	}
+	if s := config.GetStringConfig(cfg, "max_ttl", ""); s != "" {
+		d, err := filesystem.ParseTTL(s)
+		if err != nil {
+			return fmt.Errorf("invalid max_ttl: %w", err)
+		}
+		p.maxTTL = d
+	}
+	if p.maxTTL > 0 && p.defaultTTL > p.maxTTL {
+		p.defaultTTL = p.maxTTL
+	}
+	log.Infof("[lockfs] Initialized (default TTL %v)", p.defaultTTL)
+	return nil
+}
+
+func (p *LockFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return &lockFS{plugin: p}
+}
+
+func (p *LockFSPlugin) GetReadme() string {
+	return `LockFS Plugin - Named Locks and Leases
+
+This plugin provides named locks held as leases. A holder keeps a lock by
+renewing it before its TTL runs out; if the holder dies, the lease expires
+and another worker can take over. Electing a single worker for a task is
+acquiring a well-known lock.
+
+STRUCTURE:
+  /README             - This file
+  /<name>/            - A lock, created on first use (or with mkdir)
+  /<name>/acquire     - Write "<holder> [ttl]" to acquire or renew the lease
+  /<name>/release     - Write "<holder>" to release it
+  /<name>/holder      - The current lease as JSON (read-only)
+
+USAGE:
+  echo "worker-1 30s" > /locks/leader/acquire   # fails if another holder has it
+  cat /locks/leader/holder
+  {"name": "leader", "holder": "worker-1", "token": 7, "expires_at": "..."}
+  echo "worker-1" > /locks/leader/acquire       # renew with the default TTL
+  echo "worker-1" > /locks/leader/release
+
+  The request may also be JSON: {"holder": "worker-1", "ttl": "30s"}.
+  Acquiring a lock held by someone else fails with a conflict. Acquiring
+  a lock you hold renews it. A free lock reads with an empty holder.
+
+  The token increases every time the lock changes hands. Pass it to the
+  resources the holder writes to, so that they can reject a former holder
+  whose lease expired while it was paused (fencing).
+
+  Renew at a fraction of the TTL (e.g. every TTL/3); the Go SDK's
+  AcquireLease does this in the background. rmdir removes a free lock.
+
+VERSION: 1.0.0
+`
+}
+
+func (p *LockFSPlugin) GetConfigParams() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{
+			Name:        "default_ttl",
+			Type:        "string",
+			Required:    false,
+			Default:     "30s",
+			Description: "Lease duration when a request gives none",
+		},
+		{
+			Name:        "max_ttl",
+			Type:        "string",
+			Required:    false,
+			Default:     "",
+			Description: "Longest lease a request may ask for (empty = unlimited)",
+		},
+	}
+}
+
+func (p *LockFSPlugin) Shutdown() error {
+	return nil
+}
+
+// leaseRequest is the JSON form of an acquire or release request
+type leaseRequest struct {
+	Holder string `json:"holder"`
+	TTL    string `json:"ttl,omitempty"`
+}
+
+// parseRequest reads "<holder> [ttl]" or a JSON leaseRequest
+func parseRequest(data []byte) (leaseRequest, error) {
+	var req leaseRequest
+	text := strings.TrimSpace(string(data))
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), &req); err != nil {
+			return req, filesystem.NewInvalidArgumentError("request", text, "invalid JSON: "+err.Error())
+		}
+	} else {
+		fields := strings.Fields(text)
+		if len(fields) > 2 {
+			return req, filesystem.NewInvalidArgumentError("request", text, `expected "<holder> [ttl]"`)
+		}
+		if len(fields) > 0 {
+			req.Holder = fields[0]
+		}
+		if len(fields) > 1 {
+			req.TTL = fields[1]
+		}
+	}
+	if req.Holder == "" {
+		return req, filesystem.NewInvalidArgumentError("holder", "", "a holder is required")
+	}
+	return req, nil
+}
+
+// acquire gives the lock to holder if it is free, or renews it if holder
+// already has it
+func (p *LockFSPlugin) acquire(name string, req leaseRequest) (*Lease, error) {
+	ttl := p.defaultTTL
+	if req.TTL != "" {
+		d, err := filesystem.ParseTTL(req.TTL)
+		if err != nil {
+			return nil, err
+		}
+		if d == 0 {
+			return nil, filesystem.NewInvalidArgumentError("ttl", req.TTL, "must be positive")
+		}
+		ttl = d
+	}
+	if p.maxTTL > 0 && ttl > p.maxTTL {
+		return nil, filesystem.NewInvalidArgumentError("ttl", req.TTL, fmt.Sprintf("must not exceed %v", p.maxTTL))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	l, ok := p.locks[name]
+	if !ok {
+		l = &lock{name: name}
+		p.locks[name] = l
+	}
+	switch {
+	case l.held(now) && l.holder != req.Holder:
+		return nil, filesystem.NewAlreadyExistsError("lease", fmt.Sprintf("%s (held by %s until %s)", name, l.holder, l.expiresAt.Format(time.RFC3339)))
+	case !l.held(now):
+		l.holder = req.Holder
+		l.token++
+		l.acquiredAt = now
+		log.Infof("[lockfs] %s acquired %s (token %d)", req.Holder, name, l.token)
+	}
+	l.renewedAt = now
+	l.expiresAt = now.Add(ttl)
+	return l.lease(now), nil
+}
+
+// release frees the lock if holder has it. Releasing a free lock succeeds.
+func (p *LockFSPlugin) release(name string, req leaseRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.locks[name]
+	if !ok || !l.held(p.now()) {
+		return nil
+	}
+	if l.holder != req.Holder {
+		return filesystem.NewPermissionDeniedError("release", name, "held by "+l.holder)
+	}
+	l.holder = ""
+	log.Infof("[lockfs] %s released %s", req.Holder, name)
+	return nil
+}
+
+// lease returns the state of a lock, if it exists
+func (p *LockFSPlugin) lease(name string) (*Lease, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.locks[name]
+	if !ok {
+		return nil, false
+	}
+	return l.lease(p.now()), true
+}
+
+// create adds a free lock
+func (p *LockFSPlugin) create(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.locks[name]; ok {
+		return filesystem.NewAlreadyExistsError("lock", name)
+	}
+	p.locks[name] = &lock{name: name}
+	return nil
+}
+
+// remove deletes a lock that nobody holds
+func (p *LockFSPlugin) remove(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.locks[name]
+	if !ok {
+		return filesystem.NewNotFoundError("remove", name)
+	}
+	if l.held(p.now()) {
+		return filesystem.NewPermissionDeniedError("remove", name, "held by "+l.holder)
+	}
+	delete(p.locks, name)
+	return nil
+}
+
+// names returns the lock names, sorted
+func (p *LockFSPlugin) names() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]string, 0, len(p.locks))
+	for name := range p.locks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lockFS exposes the locks as a file system
+type lockFS struct {
+	plugin *LockFSPlugin
+}
+
+// splitPath splits a path into the lock name and the file within it
+func splitPath(path string) (name, file string, extra bool) {
+	parts := strings.SplitN(strings.Trim(filesystem.NormalizePath(path), "/"), "/", 3)
+	name = parts[0]
+	if len(parts) > 1 {
+		file = parts[1]
+	}
+	return name, file, len(parts) > 2
+}
+
+func isLockFile(file string) bool {
+	return file == acquireFile || file == releaseFile || file == holderFile
+}
+
+// holderData returns the content of a lock's holder file
+func holderData(lease *Lease) []byte {
+	data, _ := json.MarshalIndent(lease, "", "  ")
+	return append(data, '\n')
+}
+
+func (fs *lockFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	name, file, extra := splitPath(path)
+	switch {
+	case name == "README" && file == "":
+		return plugin.ApplyRangeRead([]byte(fs.plugin.GetReadme()), offset, size)
+	case name == "" || file == "":
+		if _, err := fs.Stat(path); err != nil {
+			return nil, err
+		}
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	case extra || !isLockFile(file):
+		return nil, filesystem.NewNotFoundError("read", path)
+	case file != holderFile:
+		return nil, filesystem.NewPermissionDeniedError("read", path, "write-only; read "+holderFile)
+	}
+	lease, ok := fs.plugin.lease(name)
+	if !ok {
+		return nil, filesystem.NewNotFoundError("read", path)
+	}
+	return plugin.ApplyRangeRead(holderData(lease), offset, size)
+}
+
+func (fs *lockFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	name, file, extra := splitPath(path)
+	if name == "" || name == "README" || extra || (file != acquireFile && file != releaseFile) {
+		return 0, filesystem.NewPermissionDeniedError("write", path, "write to /<name>/acquire or /<name>/release")
+	}
+	req, err := parseRequest(data)
+	if err != nil {
+		return 0, err
+	}
+	if file == acquireFile {
+		_, err = fs.plugin.acquire(name, req)
+	} else {
+		err = fs.plugin.release(name, req)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+func (fs *lockFS) Stat(path string) (*filesystem.FileInfo, error) {
+	name, file, extra := splitPath(path)
+	now := time.Now()
+	switch {
+	case name == "":
+		return &filesystem.FileInfo{Name: "/", Mode: 0755, ModTime: now, IsDir: true,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "directory"}}, nil
+	case name == "README" && file == "":
+		return &filesystem.FileInfo{Name: "README", Size: int64(len(fs.plugin.GetReadme())), Mode: 0444, ModTime: now,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "doc"}}, nil
+	case extra || (file != "" && !isLockFile(file)):
+		return nil, filesystem.NewNotFoundError("stat", path)
+	}
+	lease, ok := fs.plugin.lease(name)
+	if !ok {
+		return nil, filesystem.NewNotFoundError("stat", path)
+	}
+	return fileInfo(lease, file, now), nil
+}
+
+// fileInfo describes the lock directory (file == "") or one of its files
+func fileInfo(lease *Lease, file string, now time.Time) *filesystem.FileInfo {
+	modTime := now
+	if lease.RenewedAt != nil {
+		modTime = *lease.RenewedAt
+	}
+	meta := filesystem.MetaData{Name: PluginName, Type: "lock", Content: map[string]string{"holder": lease.Holder}}
+	switch file {
+	case "":
+		return &filesystem.FileInfo{Name: lease.Name, Mode: 0755, ModTime: modTime, IsDir: true, Meta: meta}
+	case holderFile:
+		return &filesystem.FileInfo{Name: file, Size: int64(len(holderData(lease))), Mode: 0444, ModTime: modTime, Meta: meta}
+	default:
+		return &filesystem.FileInfo{Name: file, Mode: 0222, ModTime: modTime,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "control"}}
+	}
+}
+
+func (fs *lockFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	name, file, _ := splitPath(path)
+	now := time.Now()
+	if name == "" {
+		readme, _ := fs.Stat("/README")
+		infos := []filesystem.FileInfo{*readme}
+		for _, name := range fs.plugin.names() {
+			if lease, ok := fs.plugin.lease(name); ok {
+				infos = append(infos, *fileInfo(lease, "", now))
+			}
+		}
+		return infos, nil
+	}
+	if name == "README" || file != "" {
+		if _, err := fs.Stat(path); err != nil {
+			return nil, err
+		}
+		return nil, filesystem.NewNotDirectoryError(path)
+	}
+	lease, ok := fs.plugin.lease(name)
+	if !ok {
+		return nil, filesystem.NewNotFoundError("readdir", path)
+	}
+	infos := make([]filesystem.FileInfo, 0, 3)
+	for _, file := range []string{acquireFile, holderFile, releaseFile} {
+		infos = append(infos, *fileInfo(lease, file, now))
+	}
+	return infos, nil
+}
+
+// Create accepts the control files so that shell redirection works
+func (fs *lockFS) Create(path string) error {
+	name, file, extra := splitPath(path)
+	if name == "" || name == "README" || extra || (file != acquireFile && file != releaseFile) {
+		return filesystem.NewPermissionDeniedError("create", path, "locks only have acquire, release and holder files")
+	}
+	return nil
+}
+
+// Mkdir creates a free lock
+func (fs *lockFS) Mkdir(path string, perm uint32) error {
+	name, file, _ := splitPath(path)
+	if name == "" || name == "README" || file != "" {
+		return filesystem.NewPermissionDeniedError("mkdir", path, "locks are top-level directories")
+	}
+	return fs.plugin.create(name)
+}
+
+// Remove deletes a lock that nobody holds
+func (fs *lockFS) Remove(path string) error {
+	name, file, _ := splitPath(path)
+	if name == "" || name == "README" || file != "" {
+		return filesystem.NewPermissionDeniedError("remove", path, "only free locks can be removed")
+	}
+	return fs.plugin.remove(name)
+}
+
+func (fs *lockFS) RemoveAll(path string) error {
+	return fs.Remove(path)
+}
+
+func (fs *lockFS) Rename(oldPath, newPath string) error {
+	return filesystem.NewNotSupportedError("rename", oldPath)
+}
+
+func (fs *lockFS) Chmod(path string, mode uint32) error {
+	return filesystem.NewNotSupportedError("chmod", path)
+}
+
+// Truncate is a no-op so that shell redirection works
+func (fs *lockFS) Truncate(path string, size int64) error {
+	return nil
+}
+
+func (fs *lockFS) Open(path string) (io.ReadCloser, error) {
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (fs *lockFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return &lockWriter{fs: fs, path: path}, nil
+}
+
+// lockWriter buffers a request and writes it on Close
+type lockWriter struct {
+	fs   *lockFS
+	path string
+	buf  bytes.Buffer
+}
+
+func (w *lockWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *lockWriter) Close() error {
+	_, err := w.fs.Write(w.path, w.buf.Bytes(), -1, filesystem.WriteFlagNone)
+	return err
+}
+
+// Ensure LockFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*LockFSPlugin)(nil)
+var _ filesystem.FileSystem = (*lockFS)(nil)
@@ -0,0 +1,126 @@
+package lockfs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func newTestFS(t *testing.T, cfg map[string]interface{}) (*lockFS, *time.Time) {
+	t.Helper()
+	p := NewLockFSPlugin()
+	if err := p.Validate(cfg); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if err := p.Initialize(cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+	return p.GetFileSystem().(*lockFS), &now
+}
+
+func write(fs *lockFS, path, data string) error {
+	_, err := fs.Write(path, []byte(data), -1, filesystem.WriteFlagNone)
+	return err
+}
+
+func holder(t *testing.T, fs *lockFS, name string) Lease {
+	t.Helper()
+	data, err := fs.Read("/"+name+"/holder", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read holder error = %v", err)
+	}
+	var lease Lease
+	if err := json.Unmarshal(data, &lease); err != nil {
+		t.Fatalf("holder is not JSON: %q", data)
+	}
+	return lease
+}
+
+func TestLeaseLifecycle(t *testing.T) {
+	fs, now := newTestFS(t, map[string]interface{}{"default_ttl": "30s"})
+
+	if err := write(fs, "/leader/acquire", "worker-1 10s"); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	lease := holder(t, fs, "leader")
+	if lease.Holder != "worker-1" || lease.Token != 1 || !lease.ExpiresAt.Equal(now.Add(10*time.Second)) {
+		t.Errorf("lease = %+v", lease)
+	}
+
+	if err := write(fs, "/leader/acquire", `{"holder": "worker-2"}`); !errors.Is(err, filesystem.ErrAlreadyExists) {
+		t.Errorf("acquire held lock: err = %v, want already exists", err)
+	}
+	if err := write(fs, "/leader/release", "worker-2"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("release by another holder: err = %v, want permission denied", err)
+	}
+
+	// Renewing keeps the token and extends the lease
+	*now = now.Add(8 * time.Second)
+	if err := write(fs, "/leader/acquire", "worker-1"); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	if lease := holder(t, fs, "leader"); lease.Token != 1 || !lease.ExpiresAt.Equal(now.Add(30*time.Second)) {
+		t.Errorf("renewed lease = %+v", lease)
+	}
+
+	// An expired lease can be taken over, with a new token
+	*now = now.Add(31 * time.Second)
+	if lease := holder(t, fs, "leader"); lease.Holder != "" {
+		t.Errorf("expired lease still held by %q", lease.Holder)
+	}
+	if err := write(fs, "/leader/acquire", "worker-2"); err != nil {
+		t.Fatalf("take over: %v", err)
+	}
+	if lease := holder(t, fs, "leader"); lease.Holder != "worker-2" || lease.Token != 2 {
+		t.Errorf("lease after takeover = %+v", lease)
+	}
+
+	if err := fs.Remove("/leader"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("remove held lock: err = %v, want permission denied", err)
+	}
+	if err := write(fs, "/leader/release", "worker-2"); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if err := fs.Remove("/leader"); err != nil {
+		t.Errorf("remove free lock: %v", err)
+	}
+	if _, err := fs.Stat("/leader"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("removed lock: err = %v, want not found", err)
+	}
+}
+
+func TestLeaseRequests(t *testing.T) {
+	fs, _ := newTestFS(t, map[string]interface{}{"max_ttl": "1m"})
+	for _, bad := range []string{"", "a b c", "w 0", "w 2m", "w soon", `{"ttl": "10s"}`} {
+		if err := write(fs, "/job/acquire", bad); !errors.Is(err, filesystem.ErrInvalidArgument) {
+			t.Errorf("acquire %q: err = %v, want invalid argument", bad, err)
+		}
+	}
+	if err := write(fs, "/job/holder", "w"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("write holder: err = %v, want permission denied", err)
+	}
+
+	if err := fs.Mkdir("/job2", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	if len(names) != 2 || names[0] != "README" || names[1] != "job2" {
+		t.Errorf("entries = %v, want rejected requests not to create locks", names)
+	}
+	if lease := holder(t, fs, "job2"); lease.Holder != "" || lease.Token != 0 {
+		t.Errorf("new lock = %+v, want free", lease)
+	}
+}
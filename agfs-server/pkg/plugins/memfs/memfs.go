@@ -2,6 +2,7 @@ package memfs
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
@@ -10,11 +11,15 @@ import (
 
 const (
 	PluginName = "memfs" // Name of this plugin
+
+	// expirySweepInterval is how often expired files are freed
+	expirySweepInterval = time.Minute
 )
 
 // MemFSPlugin wraps MemoryFS as a plugin
 type MemFSPlugin struct {
-	fs *MemoryFS
+	fs     *MemoryFS
+	stopCh chan struct{}
 }
 
 // NewMemFSPlugin creates a new MemFS plugin
@@ -63,9 +68,26 @@ func (p *MemFSPlugin) Initialize(config map[string]interface{}) error {
 			}
 		}
 	}
+
+	p.stopCh = make(chan struct{})
+	go p.sweepLoop()
 	return nil
 }
 
+// sweepLoop periodically frees expired files
+func (p *MemFSPlugin) sweepLoop() {
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.fs.SweepExpired()
+		case <-p.stopCh:
+			return
+		}
+	}
+}
+
 func (p *MemFSPlugin) GetFileSystem() filesystem.FileSystem {
 	return p.fs
 }
@@ -81,6 +103,8 @@ FEATURES:
   - File permissions (chmod)
   - File/directory renaming and moving
   - Metadata tracking
+  - Expiring files (TTL): expired files disappear immediately and are
+    freed in the background
 
 USAGE:
   Create a file:
@@ -108,6 +132,10 @@ USAGE:
   Change permissions:
     chmod 755 /path/to/file
 
+  Write a file that expires after 10 minutes (HTTP API):
+    curl -X PUT -H "X-AGFS-TTL: 10m" --data "scratch" \
+      "http://localhost:8080/api/v1/files?path=/memfs/tmp/scratch.txt"
+
 EXAMPLES:
   agfs:/> mkdir /memfs/data
   agfs:/> echo "hello" > /memfs/data/file.txt
@@ -126,6 +154,10 @@ func (p *MemFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 }
 
 func (p *MemFSPlugin) Shutdown() error {
+	if p.stopCh != nil {
+		close(p.stopCh)
+		p.stopCh = nil
+	}
 	return nil
 }
 
@@ -27,6 +27,40 @@ type Node struct {
 	Mode     uint32
 	ModTime  time.Time
 	Children map[string]*Node
+
+	// ExpiresAt is when the file expires; zero if it never does.
+	// Expired nodes are invisible and are dropped by SweepExpired.
+	ExpiresAt time.Time
+
+	// ContentType is the media type set with SetContentType; empty when
+	// the type is detected from the content
+	ContentType string
+
+	// shared is set when a snapshot references Data
+	shared bool
+}
+
+// expired reports whether the node has passed its expiry time
+func (n *Node) expired(now time.Time) bool {
+	return !n.ExpiresAt.IsZero() && !now.Before(n.ExpiresAt)
+}
+
+// ownData gives the node a private copy of Data if a snapshot shares it.
+// Must be called before Data is modified in place.
+func (n *Node) ownData() {
+	if n.shared {
+		n.Data = append([]byte(nil), n.Data...)
+		n.shared = false
+	}
+}
+
+// child returns the named child, treating expired children as absent
+func (n *Node) child(name string) (*Node, bool) {
+	c, exists := n.Children[name]
+	if !exists || c.expired(time.Now()) {
+		return nil, false
+	}
+	return c, true
 }
 
 // MemoryFS implements FileSystem and HandleFS interfaces with in-memory storage
@@ -39,6 +73,8 @@ type MemoryFS struct {
 	handles      map[int64]*MemoryFileHandle
 	handlesMu    sync.RWMutex
 	nextHandleID int64
+
+	snapshots map[string]*memSnapshot // Guarded by mu
 }
 
 // NewMemoryFS creates a new in-memory file system
@@ -77,7 +113,7 @@ func (mfs *MemoryFS) getNode(path string) (*Node, error) {
 		if !current.IsDir {
 			return nil, fmt.Errorf("not a directory: %s", path)
 		}
-		next, exists := current.Children[part]
+		next, exists := current.child(part)
 		if !exists {
 			return nil, fmt.Errorf("no such file or directory: %s", path)
 		}
@@ -120,7 +156,7 @@ func (mfs *MemoryFS) Create(path string) error {
 		return err
 	}
 
-	if _, exists := parent.Children[name]; exists {
+	if _, exists := parent.child(name); exists {
 		return fmt.Errorf("file already exists: %s", path)
 	}
 
@@ -146,7 +182,7 @@ func (mfs *MemoryFS) Mkdir(path string, perm uint32) error {
 		return err
 	}
 
-	if _, exists := parent.Children[name]; exists {
+	if _, exists := parent.child(name); exists {
 		return fmt.Errorf("directory already exists: %s", path)
 	}
 
@@ -175,7 +211,7 @@ func (mfs *MemoryFS) Remove(path string) error {
 		return err
 	}
 
-	node, exists := parent.Children[name]
+	node, exists := parent.child(name)
 	if !exists {
 		return fmt.Errorf("no such file or directory: %s", path)
 	}
@@ -204,7 +240,7 @@ func (mfs *MemoryFS) RemoveAll(path string) error {
 		return err
 	}
 
-	if _, exists := parent.Children[name]; !exists {
+	if _, exists := parent.child(name); !exists {
 		return fmt.Errorf("no such file or directory: %s", path)
 	}
 
@@ -243,7 +279,7 @@ func (mfs *MemoryFS) Write(path string, data []byte, offset int64, flags filesys
 		return 0, err
 	}
 
-	node, exists := parent.Children[name]
+	node, exists := parent.child(name)
 
 	// Handle exclusive flag
 	if exists && flags&filesystem.WriteFlagExclusive != 0 {
@@ -286,6 +322,7 @@ func (mfs *MemoryFS) Write(path string, data []byte, offset int64, flags filesys
 		node.Data = data
 	} else {
 		// Offset write mode
+		node.ownData()
 		newSize := offset + int64(len(data))
 		if newSize > int64(len(node.Data)) {
 			newData := make([]byte, newSize)
@@ -314,23 +351,25 @@ func (mfs *MemoryFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 		return nil, fmt.Errorf("not a directory: %s", path)
 	}
 
+	now := time.Now()
 	var infos []filesystem.FileInfo
 	for _, child := range node.Children {
+		if child.expired(now) {
+			continue
+		}
 		metaType := MetaValueFile
 		if child.IsDir {
 			metaType = MetaValueDir
 		}
 
 		infos = append(infos, filesystem.FileInfo{
-			Name:    child.Name,
-			Size:    int64(len(child.Data)),
-			Mode:    child.Mode,
-			ModTime: child.ModTime,
-			IsDir:   child.IsDir,
-			Meta: filesystem.MetaData{
-				Name: mfs.pluginName,
-				Type: metaType,
-			},
+			Name:      child.Name,
+			Size:      int64(len(child.Data)),
+			Mode:      child.Mode,
+			ModTime:   child.ModTime,
+			IsDir:     child.IsDir,
+			Meta:      mfs.nodeMeta(child, metaType),
+			ExpiresAt: child.ExpiresAt,
 		})
 	}
 
@@ -353,15 +392,13 @@ func (mfs *MemoryFS) Stat(path string) (*filesystem.FileInfo, error) {
 	}
 
 	return &filesystem.FileInfo{
-		Name:    node.Name,
-		Size:    int64(len(node.Data)),
-		Mode:    node.Mode,
-		ModTime: node.ModTime,
-		IsDir:   node.IsDir,
-		Meta: filesystem.MetaData{
-			Name: mfs.pluginName,
-			Type: metaType,
-		},
+		Name:      node.Name,
+		Size:      int64(len(node.Data)),
+		Mode:      node.Mode,
+		ModTime:   node.ModTime,
+		IsDir:     node.IsDir,
+		Meta:      mfs.nodeMeta(node, metaType),
+		ExpiresAt: node.ExpiresAt,
 	}, nil
 }
 
@@ -375,7 +412,7 @@ func (mfs *MemoryFS) Rename(oldPath, newPath string) error {
 		return err
 	}
 
-	node, exists := oldParent.Children[oldName]
+	node, exists := oldParent.child(oldName)
 	if !exists {
 		return fmt.Errorf("no such file or directory: %s", oldPath)
 	}
@@ -385,7 +422,7 @@ func (mfs *MemoryFS) Rename(oldPath, newPath string) error {
 		return err
 	}
 
-	if _, exists := newParent.Children[newName]; exists {
+	if _, exists := newParent.child(newName); exists {
 		return fmt.Errorf("file already exists: %s", newPath)
 	}
 
@@ -451,6 +488,148 @@ func (mfs *MemoryFS) Truncate(path string, size int64) error {
 // Ensure MemoryFS implements Truncater interface
 var _ filesystem.Truncater = (*MemoryFS)(nil)
 
+// SetExpiry makes a file expire at expiresAt; a zero time clears the expiry
+func (mfs *MemoryFS) SetExpiry(path string, expiresAt time.Time) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	node, err := mfs.getNode(path)
+	if err != nil {
+		return err
+	}
+	if node.IsDir {
+		return filesystem.NewInvalidArgumentError("path", path, "TTL can only be set on files")
+	}
+
+	node.ExpiresAt = expiresAt
+	return nil
+}
+
+// Increment implements filesystem.Incrementer, updating the counter under
+// the file system lock
+func (mfs *MemoryFS) Increment(path string, delta int64) (int64, error) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	parent, name, err := mfs.getParentNode(path)
+	if err != nil {
+		return 0, err
+	}
+	node, exists := parent.child(name)
+	var current int64
+	if exists {
+		if node.IsDir {
+			return 0, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+		}
+		if current, err = filesystem.ParseCounter(path, node.Data); err != nil {
+			return 0, err
+		}
+	}
+	value, err := filesystem.AddCounter(path, current, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		node = &Node{
+			Name: name,
+			Mode: 0644,
+		}
+		parent.Children[name] = node
+	}
+	// Replace rather than modify Data, which a snapshot may share
+	node.Data = filesystem.FormatCounter(value)
+	node.shared = false
+	node.ModTime = time.Now()
+	return value, nil
+}
+
+var _ filesystem.Incrementer = (*MemoryFS)(nil)
+
+// Append implements filesystem.Appender, appending under the file system
+// lock
+func (mfs *MemoryFS) Append(path string, data []byte) (int64, error) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	parent, name, err := mfs.getParentNode(path)
+	if err != nil {
+		return 0, err
+	}
+	node, exists := parent.child(name)
+	if !exists {
+		node = &Node{
+			Name: name,
+			Mode: 0644,
+		}
+		parent.Children[name] = node
+	}
+	if node.IsDir {
+		return 0, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+	offset := int64(len(node.Data))
+	node.ownData()
+	node.Data = append(node.Data, data...)
+	node.ModTime = time.Now()
+	return offset, nil
+}
+
+var _ filesystem.Appender = (*MemoryFS)(nil)
+
+// SetContentType stores the media type reported for a file
+func (mfs *MemoryFS) SetContentType(path string, contentType string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	node, err := mfs.getNode(path)
+	if err != nil {
+		return err
+	}
+	if node.IsDir {
+		return filesystem.NewInvalidArgumentError("path", path, "content type can only be set on files")
+	}
+
+	node.ContentType = contentType
+	return nil
+}
+
+var _ filesystem.ContentTyper = (*MemoryFS)(nil)
+
+// nodeMeta returns the metadata reported for node
+func (mfs *MemoryFS) nodeMeta(node *Node, metaType string) filesystem.MetaData {
+	meta := filesystem.MetaData{
+		Name: mfs.pluginName,
+		Type: metaType,
+	}
+	if node.ContentType != "" {
+		meta.Content = map[string]string{filesystem.MetaContentType: node.ContentType}
+	}
+	return meta
+}
+
+// SweepExpired frees expired files. Expired files are already invisible,
+// so this only reclaims memory.
+func (mfs *MemoryFS) SweepExpired() {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	now := time.Now()
+	var sweep func(dir *Node)
+	sweep = func(dir *Node) {
+		for name, child := range dir.Children {
+			if child.IsDir {
+				sweep(child)
+			} else if child.expired(now) {
+				delete(dir.Children, name)
+			}
+		}
+	}
+	sweep(mfs.root)
+}
+
+// Ensure MemoryFS implements Expirer interface
+var _ filesystem.Expirer = (*MemoryFS)(nil)
+
 // memoryReadCloser wraps a bytes.Reader to implement io.ReadCloser
 type memoryReadCloser struct {
 	*bytes.Reader
@@ -463,7 +642,8 @@ func (m *memoryReadCloser) Close() error {
 // Open opens a file for reading
 func (mfs *MemoryFS) Open(path string) (io.ReadCloser, error) {
 	data, err := mfs.Read(path, 0, -1)
-	if err != nil {
+	// Read reports io.EOF alongside the data when the whole file was read
+	if err != nil && err != io.EOF {
 		return nil, err
 	}
 	return &memoryReadCloser{bytes.NewReader(data)}, nil
@@ -617,6 +797,7 @@ func (h *MemoryFileHandle) Write(data []byte) (int, error) {
 	}
 
 	// Extend data if necessary
+	node.ownData()
 	newSize := writePos + int64(len(data))
 	if newSize > int64(len(node.Data)) {
 		newData := make([]byte, newSize)
@@ -655,6 +836,7 @@ func (h *MemoryFileHandle) WriteAt(data []byte, offset int64) (int, error) {
 	}
 
 	// Extend data if necessary
+	node.ownData()
 	newSize := offset + int64(len(data))
 	if newSize > int64(len(node.Data)) {
 		newData := make([]byte, newSize)
@@ -2,8 +2,11 @@ package memfs
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 )
@@ -1011,3 +1014,111 @@ func TestMemoryFSOpen(t *testing.T) {
 		t.Fatalf("Reader.Close failed: %v", err)
 	}
 }
+
+func TestMemoryFSExpiry(t *testing.T) {
+	fs := NewMemoryFS()
+	if _, err := fs.Write("/scratch.txt", []byte("tmp"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	if err := fs.SetExpiry("/scratch.txt", expiresAt); err != nil {
+		t.Fatalf("SetExpiry failed: %v", err)
+	}
+	info, err := fs.Stat("/scratch.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if !info.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("ExpiresAt = %v, want %v", info.ExpiresAt, expiresAt)
+	}
+
+	// Once expired the file is invisible
+	if err := fs.SetExpiry("/scratch.txt", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("SetExpiry failed: %v", err)
+	}
+	if _, err := fs.Stat("/scratch.txt"); err == nil {
+		t.Error("Stat of expired file should fail")
+	}
+	if _, err := fs.Read("/scratch.txt", 0, -1); err == nil {
+		t.Error("Read of expired file should fail")
+	}
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("ReadDir returned expired file: %v", entries)
+	}
+
+	// Writing to the path creates a fresh file without the old content or TTL
+	if _, err := fs.Write("/scratch.txt", []byte("new"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write after expiry failed: %v", err)
+	}
+	content, err := readIgnoreEOF(fs, "/scratch.txt")
+	if err != nil || string(content) != "new" {
+		t.Errorf("Read = %q, %v; want %q", content, err, "new")
+	}
+	if info, _ := fs.Stat("/scratch.txt"); !info.ExpiresAt.IsZero() {
+		t.Errorf("new file inherited ExpiresAt %v", info.ExpiresAt)
+	}
+
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if err := fs.SetExpiry("/dir", expiresAt); err == nil {
+		t.Error("SetExpiry on a directory should fail")
+	}
+}
+
+func TestMemoryFSSweepExpired(t *testing.T) {
+	fs := NewMemoryFS()
+	fs.Mkdir("/tmp", 0755)
+	fs.Write("/tmp/old.txt", []byte("old"), -1, filesystem.WriteFlagCreate)
+	fs.Write("/tmp/keep.txt", []byte("keep"), -1, filesystem.WriteFlagCreate)
+	fs.SetExpiry("/tmp/old.txt", time.Now().Add(-time.Second))
+
+	fs.SweepExpired()
+
+	tmp, _ := fs.getNode("/tmp")
+	if _, ok := tmp.Children["old.txt"]; ok {
+		t.Error("expired file was not swept")
+	}
+	if _, ok := tmp.Children["keep.txt"]; !ok {
+		t.Error("unexpired file was swept")
+	}
+}
+
+func TestMemoryFSIncrement(t *testing.T) {
+	// The plain FileSystem hides Increment, exercising the locked fallback
+	for name, fs := range map[string]filesystem.FileSystem{
+		"native":   NewMemoryFS(),
+		"fallback": struct{ filesystem.FileSystem }{NewMemoryFS()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := filesystem.Increment(fs, "/seq", 1); err != nil {
+						t.Errorf("Increment failed: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if value, err := filesystem.Increment(fs, "/seq", -10); err != nil || value != 40 {
+				t.Errorf("Increment = %d, %v; want 40", value, err)
+			}
+			if content, _ := fs.Read("/seq", 0, -1); string(content) != "40\n" {
+				t.Errorf("content = %q, want \"40\\n\"", content)
+			}
+
+			fs.Write("/text", []byte("hello"), -1, filesystem.WriteFlagCreate)
+			if _, err := filesystem.Increment(fs, "/text", 1); !errors.Is(err, filesystem.ErrInvalidArgument) {
+				t.Errorf("Increment of non-counter: err = %v, want invalid argument", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package memfs
+
+import (
+	"sort"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// memSnapshot is a frozen copy of the tree. File contents are shared with
+// the live tree until either side is written (see Node.ownData).
+type memSnapshot struct {
+	info filesystem.Snapshot
+	root *Node
+}
+
+// cloneTree copies the directory structure under n, sharing file data and
+// leaving out expired nodes. Must be called with mu held.
+func cloneTree(n *Node, now time.Time) *Node {
+	clone := &Node{
+		Name:        n.Name,
+		IsDir:       n.IsDir,
+		Data:        n.Data,
+		Mode:        n.Mode,
+		ModTime:     n.ModTime,
+		ContentType: n.ContentType,
+	}
+	if !n.IsDir {
+		n.shared = true
+		clone.shared = true
+		return clone
+	}
+	clone.Children = make(map[string]*Node, len(n.Children))
+	for name, child := range n.Children {
+		if !child.expired(now) {
+			clone.Children[name] = cloneTree(child, now)
+		}
+	}
+	return clone
+}
+
+// CreateSnapshot freezes the current tree. Only file metadata is copied.
+func (mfs *MemoryFS) CreateSnapshot(path string) (*filesystem.Snapshot, error) {
+	if err := filesystem.CheckSnapshotRoot(path); err != nil {
+		return nil, err
+	}
+
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	if mfs.snapshots == nil {
+		mfs.snapshots = make(map[string]*memSnapshot)
+	}
+	now := time.Now()
+	id := filesystem.NewSnapshotID(now)
+	for mfs.snapshots[id] != nil {
+		now = now.Add(time.Nanosecond)
+		id = filesystem.NewSnapshotID(now)
+	}
+
+	snap := &memSnapshot{
+		info: filesystem.Snapshot{ID: id, CreatedAt: now.UTC()},
+		root: cloneTree(mfs.root, now),
+	}
+	mfs.snapshots[id] = snap
+	info := snap.info
+	return &info, nil
+}
+
+// ListSnapshots returns the snapshots, oldest first
+func (mfs *MemoryFS) ListSnapshots(path string) ([]filesystem.Snapshot, error) {
+	if err := filesystem.CheckSnapshotRoot(path); err != nil {
+		return nil, err
+	}
+
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	snapshots := make([]filesystem.Snapshot, 0, len(mfs.snapshots))
+	for _, snap := range mfs.snapshots {
+		snapshots = append(snapshots, snap.info)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].ID < snapshots[j].ID })
+	return snapshots, nil
+}
+
+// DeleteSnapshot discards a snapshot. Data it shared stays marked shared
+// until the live file is next copied.
+func (mfs *MemoryFS) DeleteSnapshot(path string, id string) error {
+	if err := filesystem.CheckSnapshotRoot(path); err != nil {
+		return err
+	}
+
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	if mfs.snapshots[id] == nil {
+		return filesystem.NewNotFoundError("snapshot", id)
+	}
+	delete(mfs.snapshots, id)
+	return nil
+}
+
+// OpenSnapshot returns a read-only file system over a snapshot
+func (mfs *MemoryFS) OpenSnapshot(path string, id string) (filesystem.FileSystem, error) {
+	if err := filesystem.CheckSnapshotRoot(path); err != nil {
+		return nil, err
+	}
+
+	mfs.mu.RLock()
+	snap := mfs.snapshots[id]
+	mfs.mu.RUnlock()
+	if snap == nil {
+		return nil, filesystem.NewNotFoundError("snapshot", id)
+	}
+
+	view := NewMemoryFSWithPlugin(mfs.pluginName)
+	view.root = snap.root
+	return filesystem.NewReadOnlyFileSystem(view), nil
+}
+
+var _ filesystem.Snapshotter = (*MemoryFS)(nil)
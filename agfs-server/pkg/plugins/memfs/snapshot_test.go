@@ -0,0 +1,59 @@
+package memfs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func TestMemoryFSSnapshotCopyOnWrite(t *testing.T) {
+	fs := NewMemoryFS()
+	fs.Mkdir("/dir", 0755)
+	fs.Write("/dir/file.txt", []byte("hello world"), -1, filesystem.WriteFlagCreate)
+
+	snap, err := fs.CreateSnapshot("/")
+	if err != nil {
+		t.Fatalf("CreateSnapshot failed: %v", err)
+	}
+
+	// In-place writes, handle writes and truncation must not leak into the snapshot
+	fs.Write("/dir/file.txt", []byte("HELLO"), 0, filesystem.WriteFlagNone)
+	h, err := fs.OpenHandle("/dir/file.txt", filesystem.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("OpenHandle failed: %v", err)
+	}
+	h.WriteAt([]byte("W"), 6)
+	h.Close()
+	fs.Truncate("/dir/file.txt", 7)
+	fs.Write("/dir/new.txt", []byte("new"), -1, filesystem.WriteFlagCreate)
+
+	view, err := fs.OpenSnapshot("/", snap.ID)
+	if err != nil {
+		t.Fatalf("OpenSnapshot failed: %v", err)
+	}
+	data, _ := view.Read("/dir/file.txt", 0, -1)
+	if string(data) != "hello world" {
+		t.Errorf("snapshot content = %q, want %q", data, "hello world")
+	}
+	if _, err := view.Stat("/dir/new.txt"); err == nil {
+		t.Error("file created after the snapshot is visible in it")
+	}
+	live, _ := fs.Read("/dir/file.txt", 0, -1)
+	if string(live) != "HELLO W" {
+		t.Errorf("live content = %q, want %q", live, "HELLO W")
+	}
+
+	if err := view.Remove("/dir/file.txt"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Remove in snapshot error = %v, want permission denied", err)
+	}
+	if _, err := fs.CreateSnapshot("/dir"); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("CreateSnapshot(/dir) error = %v, want invalid argument", err)
+	}
+	if err := fs.DeleteSnapshot("/", snap.ID); err != nil {
+		t.Fatalf("DeleteSnapshot failed: %v", err)
+	}
+	if _, err := fs.OpenSnapshot("/", snap.ID); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("OpenSnapshot after delete error = %v, want not found", err)
+	}
+}
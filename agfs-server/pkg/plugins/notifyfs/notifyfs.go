@@ -0,0 +1,503 @@
+package notifyfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PluginName = "notifyfs"
+
+	logFileName = "log"
+)
+
+// Defaults for the plugin configuration
+const (
+	defaultTimeout    = 10 * time.Second
+	defaultMaxHistory = 100
+	maxSubjectLength  = 200
+)
+
+// Delivery states
+const (
+	DeliverySent        = "sent"
+	DeliveryFailed      = "failed"
+	DeliveryRateLimited = "rate_limited"
+)
+
+// Delivery records the outcome of one notification
+type Delivery struct {
+	Time     time.Time `json:"time"`
+	Provider string    `json:"provider"`
+	Target   string    `json:"target"`
+	Subject  string    `json:"subject"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// NotifyFSPlugin dispatches messages written to /<provider>/<target>
+// through the configured notification providers
+type NotifyFSPlugin struct {
+	providers map[string]*provider
+	timeout   time.Duration
+
+	mu         sync.Mutex
+	history    []Delivery // Oldest first
+	maxHistory int
+}
+
+// NewNotifyFSPlugin creates a new NotifyFS plugin
+func NewNotifyFSPlugin() *NotifyFSPlugin {
+	return &NotifyFSPlugin{}
+}
+
+func (p *NotifyFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *NotifyFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"providers", "timeout", "max_history", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	if err := config.ValidateMapType(cfg, "providers"); err != nil {
+		return err
+	}
+	if err := config.ValidateStringType(cfg, "timeout"); err != nil {
+		return err
+	}
+	if err := config.ValidateIntType(cfg, "max_history"); err != nil {
+		return err
+	}
+	_, _, err := providersFromConfig(cfg)
+	return err
+}
+
+// providersFromConfig builds the configured providers and the delivery
+// timeout
+func providersFromConfig(cfg map[string]interface{}) (map[string]*provider, time.Duration, error) {
+	timeout := defaultTimeout
+	if s := config.GetStringConfig(cfg, "timeout", ""); s != "" {
+		var err error
+		if timeout, err = time.ParseDuration(s); err != nil || timeout <= 0 {
+			return nil, 0, fmt.Errorf("invalid timeout %q: must be a positive duration", s)
+		}
+	}
+
+	configs, _ := cfg["providers"].(map[string]interface{})
+	if len(configs) == 0 {
+		return nil, 0, fmt.Errorf("providers is required and must configure at least one provider")
+	}
+	providers := make(map[string]*provider, len(configs))
+	for name, v := range configs {
+		providerCfg, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, 0, fmt.Errorf("provider %s must be a map", name)
+		}
+		p, err := newProvider(name, providerCfg, timeout)
+		if err != nil {
+			return nil, 0, err
+		}
+		providers[name] = p
+	}
+	return providers, timeout, nil
+}
+
+func (p *NotifyFSPlugin) Initialize(cfg map[string]interface{}) error {
+	providers, timeout, err := providersFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	p.providers = providers
+	p.timeout = timeout
+	p.maxHistory = config.GetIntConfig(cfg, "max_history", defaultMaxHistory)
+	if p.maxHistory < 1 {
+		p.maxHistory = defaultMaxHistory
+	}
+	log.Infof("[notifyfs] Initialized with %d providers", len(providers))
+	return nil
+}
+
+func (p *NotifyFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return &notifyFS{plugin: p}
+}
+
+func (p *NotifyFSPlugin) GetReadme() string {
+	return `NotifyFS Plugin - Notifications
+
+This plugin sends alerts through configured providers when a message is
+written to /<provider>/<target>.
+
+STRUCTURE:
+  /README               - This file
+  /log                  - Recent deliveries, one JSON object per line
+  /<provider>/          - One directory per configured provider
+    <target>            - Write a message to send it to target; read the
+                          last delivery to target
+
+USAGE:
+  echo "Disk almost full on db-1" > /notify/email/ops@example.com
+  echo "Nightly import failed" > /notify/pagerduty/service-x
+  cat /notify/log
+
+  A message is plain text, whose first line is the subject, or a JSON
+  object: {"subject": "...", "message": "...", "severity": "warning",
+  "fields": {"host": "db-1"}}
+
+PROVIDERS:
+  email      - SMTP; the target is the recipient address
+  pagerduty  - Events API v2; the target selects a routing key
+  webhook    - HTTP POST of the rendered template to a URL
+
+  Subjects and bodies are Go templates over .Provider, .Target, .Subject,
+  .Message, .Severity, .Fields and .Time. Each target may be sent
+  rate_limit messages per rate_window; further writes fail until the
+  window has passed.
+
+VERSION: 1.0.0
+`
+}
+
+func (p *NotifyFSPlugin) GetConfigParams() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{
+			Name:        "providers",
+			Type:        "map",
+			Required:    true,
+			Default:     "",
+			Description: "Providers by name, each with a type (email, pagerduty, webhook) and its settings",
+		},
+		{
+			Name:        "timeout",
+			Type:        "string",
+			Required:    false,
+			Default:     "10s",
+			Description: "Time allowed for delivering one notification",
+		},
+		{
+			Name:        "max_history",
+			Type:        "int",
+			Required:    false,
+			Default:     "100",
+			Description: "Deliveries kept in /log",
+		},
+	}
+}
+
+func (p *NotifyFSPlugin) Shutdown() error {
+	return nil
+}
+
+// notify parses data as a message and delivers it to target
+func (p *NotifyFSPlugin) notify(prov *provider, target string, data []byte) error {
+	if err := prov.checkTarget(target); err != nil {
+		return err
+	}
+	n, err := parseMessage(data)
+	if err != nil {
+		return err
+	}
+	n.Provider = prov.name
+	n.Target = target
+	n.Time = time.Now()
+	if prov.kind == providerPagerDuty && !pagerDutySeverities[n.Severity] {
+		return filesystem.NewInvalidArgumentError("severity", n.Severity, "must be critical, error, warning or info")
+	}
+
+	delivery := Delivery{Time: n.Time, Provider: prov.name, Target: target, Subject: n.Subject, Status: DeliverySent}
+	if ok, wait := prov.limiter.allow(target, n.Time); !ok {
+		delivery.Status = DeliveryRateLimited
+		p.record(delivery)
+		return filesystem.NewRateLimitedError("/"+prov.name+"/"+target, wait)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	if err := prov.send(ctx, n); err != nil {
+		delivery.Status = DeliveryFailed
+		delivery.Error = err.Error()
+		p.record(delivery)
+		log.Warnf("[notifyfs] Failed to notify %s/%s: %v", prov.name, target, err)
+		return fmt.Errorf("failed to notify %s/%s: %w", prov.name, target, err)
+	}
+	p.record(delivery)
+	log.Infof("[notifyfs] Notified %s/%s: %s", prov.name, target, n.Subject)
+	return nil
+}
+
+// parseMessage reads a plain text or JSON message
+func parseMessage(data []byte) (*Notification, error) {
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return nil, filesystem.NewInvalidArgumentError("message", "", "must not be empty")
+	}
+	n := &Notification{Message: text}
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), n); err != nil {
+			return nil, filesystem.NewInvalidArgumentError("message", "", "invalid JSON: "+err.Error())
+		}
+		if n.Message == "" && n.Subject == "" {
+			return nil, filesystem.NewInvalidArgumentError("message", "", "needs a subject or a message")
+		}
+	}
+	if n.Subject == "" {
+		n.Subject, _, _ = strings.Cut(n.Message, "\n")
+	}
+	if n.Message == "" {
+		n.Message = n.Subject
+	}
+	if len(n.Subject) > maxSubjectLength {
+		n.Subject = n.Subject[:maxSubjectLength]
+	}
+	if n.Severity == "" {
+		n.Severity = defaultSeverity
+	}
+	return n, nil
+}
+
+// record appends a delivery to the history
+func (p *NotifyFSPlugin) record(d Delivery) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.history = append(p.history, d)
+	if len(p.history) > p.maxHistory {
+		p.history = p.history[len(p.history)-p.maxHistory:]
+	}
+}
+
+// deliveries returns the history, optionally only for one provider
+func (p *NotifyFSPlugin) deliveries(provider string) []Delivery {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []Delivery
+	for _, d := range p.history {
+		if provider == "" || d.Provider == provider {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// notifyFS exposes the providers as a file system
+type notifyFS struct {
+	plugin *NotifyFSPlugin
+}
+
+// splitPath splits a path into the provider and target names
+func splitPath(path string) (name, target string, extra bool) {
+	parts := strings.SplitN(strings.Trim(filesystem.NormalizePath(path), "/"), "/", 3)
+	name = parts[0]
+	if len(parts) > 1 {
+		target = parts[1]
+	}
+	return name, target, len(parts) > 2
+}
+
+// provider returns the provider for path
+func (fs *notifyFS) provider(op, path string) (*provider, string, error) {
+	name, target, extra := splitPath(path)
+	prov, ok := fs.plugin.providers[name]
+	if !ok || extra {
+		return nil, "", filesystem.NewNotFoundError(op, path)
+	}
+	return prov, target, nil
+}
+
+func (fs *notifyFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	name, target, _ := splitPath(path)
+	switch {
+	case name == "README" && target == "":
+		return plugin.ApplyRangeRead([]byte(fs.plugin.GetReadme()), offset, size)
+	case name == logFileName && target == "":
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, d := range fs.plugin.deliveries("") {
+			enc.Encode(d)
+		}
+		return plugin.ApplyRangeRead(buf.Bytes(), offset, size)
+	case name == "":
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+
+	prov, target, err := fs.provider("read", path)
+	if err != nil {
+		return nil, err
+	}
+	if target == "" {
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+	var last []byte
+	for _, d := range fs.plugin.deliveries(prov.name) {
+		if d.Target == target {
+			last, _ = json.MarshalIndent(d, "", "  ")
+			last = append(last, '\n')
+		}
+	}
+	return plugin.ApplyRangeRead(last, offset, size)
+}
+
+func (fs *notifyFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	prov, target, err := fs.provider("write", path)
+	if err != nil {
+		return 0, err
+	}
+	if target == "" {
+		return 0, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+	if err := fs.plugin.notify(prov, target, data); err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+func (fs *notifyFS) Stat(path string) (*filesystem.FileInfo, error) {
+	name, target, _ := splitPath(path)
+	now := time.Now()
+	switch {
+	case name == "":
+		return &filesystem.FileInfo{Name: "/", Mode: 0755, ModTime: now, IsDir: true,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "directory"}}, nil
+	case name == "README" && target == "":
+		return &filesystem.FileInfo{Name: "README", Size: int64(len(fs.plugin.GetReadme())), Mode: 0444, ModTime: now,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "doc"}}, nil
+	case name == logFileName && target == "":
+		return &filesystem.FileInfo{Name: logFileName, Mode: 0444, ModTime: now,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "log"}}, nil
+	}
+
+	prov, target, err := fs.provider("stat", path)
+	if err != nil {
+		return nil, err
+	}
+	if target == "" {
+		return &filesystem.FileInfo{Name: prov.name, Mode: 0755, ModTime: now, IsDir: true,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "provider", Content: map[string]string{"type": prov.kind}}}, nil
+	}
+	// Every target exists, so that messages can be written to it
+	return &filesystem.FileInfo{Name: target, Mode: 0666, ModTime: now,
+		Meta: filesystem.MetaData{Name: PluginName, Type: "target"}}, nil
+}
+
+func (fs *notifyFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	name, target, _ := splitPath(path)
+	if name == "" {
+		infos := make([]filesystem.FileInfo, 0, len(fs.plugin.providers)+2)
+		for _, file := range []string{"README", logFileName} {
+			info, _ := fs.Stat("/" + file)
+			infos = append(infos, *info)
+		}
+		names := make([]string, 0, len(fs.plugin.providers))
+		for name := range fs.plugin.providers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			info, _ := fs.Stat("/" + name)
+			infos = append(infos, *info)
+		}
+		return infos, nil
+	}
+
+	prov, target, err := fs.provider("readdir", path)
+	if err != nil {
+		if name == "README" || name == logFileName {
+			return nil, filesystem.NewNotDirectoryError(path)
+		}
+		return nil, err
+	}
+	if target != "" {
+		return nil, filesystem.NewNotDirectoryError(path)
+	}
+	// List the targets notified recently
+	seen := make(map[string]bool)
+	infos := []filesystem.FileInfo{}
+	for _, d := range fs.plugin.deliveries(prov.name) {
+		if !seen[d.Target] {
+			seen[d.Target] = true
+			infos = append(infos, filesystem.FileInfo{Name: d.Target, Mode: 0666, ModTime: d.Time,
+				Meta: filesystem.MetaData{Name: PluginName, Type: "target"}})
+		}
+	}
+	return infos, nil
+}
+
+// Create accepts targets so that shell redirection works
+func (fs *notifyFS) Create(path string) error {
+	_, target, err := fs.provider("create", path)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		return filesystem.NewAlreadyExistsError("provider", path)
+	}
+	return nil
+}
+
+func (fs *notifyFS) Mkdir(path string, perm uint32) error {
+	return filesystem.NewPermissionDeniedError("mkdir", path, "providers are configured, not created")
+}
+
+func (fs *notifyFS) Remove(path string) error {
+	return filesystem.NewPermissionDeniedError("remove", path, "notifications cannot be removed")
+}
+
+func (fs *notifyFS) RemoveAll(path string) error {
+	return fs.Remove(path)
+}
+
+func (fs *notifyFS) Rename(oldPath, newPath string) error {
+	return filesystem.NewNotSupportedError("rename", oldPath)
+}
+
+func (fs *notifyFS) Chmod(path string, mode uint32) error {
+	return filesystem.NewNotSupportedError("chmod", path)
+}
+
+// Truncate is a no-op so that shell redirection works
+func (fs *notifyFS) Truncate(path string, size int64) error {
+	return nil
+}
+
+func (fs *notifyFS) Open(path string) (io.ReadCloser, error) {
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (fs *notifyFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return &notifyWriter{fs: fs, path: path}, nil
+}
+
+// notifyWriter buffers a message and sends it on Close
+type notifyWriter struct {
+	fs   *notifyFS
+	path string
+	buf  bytes.Buffer
+}
+
+func (w *notifyWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *notifyWriter) Close() error {
+	_, err := w.fs.Write(w.path, w.buf.Bytes(), -1, filesystem.WriteFlagNone)
+	return err
+}
+
+// Ensure NotifyFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*NotifyFSPlugin)(nil)
+var _ filesystem.FileSystem = (*notifyFS)(nil)
@@ -0,0 +1,167 @@
+package notifyfs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func newTestFS(t *testing.T, providers map[string]interface{}) *notifyFS {
+	t.Helper()
+	p := NewNotifyFSPlugin()
+	cfg := map[string]interface{}{"providers": providers}
+	if err := p.Validate(cfg); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if err := p.Initialize(cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	return p.GetFileSystem().(*notifyFS)
+}
+
+func TestWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, r.Header.Get("X-Token")+" "+string(data))
+		mu.Unlock()
+		if strings.Contains(string(data), "fail") {
+			http.Error(w, "boom", http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	fs := newTestFS(t, map[string]interface{}{
+		"chat": map[string]interface{}{
+			"type":       "webhook",
+			"url":        server.URL,
+			"headers":    map[string]interface{}{"X-Token": "secret"},
+			"template":   `{"text": {{json (printf "[%s] %s" .Target .Subject)}}}`,
+			"rate_limit": 2,
+		},
+	})
+
+	if _, err := fs.Write("/chat/ops", []byte("Disk full\nOn db-1"), -1, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if len(bodies) != 1 || bodies[0] != `secret {"text": "[ops] Disk full"}` {
+		t.Errorf("webhook received %q", bodies)
+	}
+
+	if _, err := fs.Write("/chat/ops", []byte("fail"), -1, filesystem.WriteFlagNone); err == nil {
+		t.Error("Write() succeeded although the webhook failed")
+	}
+	_, err := fs.Write("/chat/ops", []byte("again"), -1, filesystem.WriteFlagNone)
+	if !errors.Is(err, filesystem.ErrRateLimited) {
+		t.Errorf("third Write() error = %v, want rate limited", err)
+	}
+	if _, err := fs.Write("/chat/dev", []byte("other target"), -1, filesystem.WriteFlagNone); err != nil {
+		t.Errorf("Write() to another target error = %v", err)
+	}
+
+	data, _ := fs.Read("/log", 0, -1)
+	var statuses []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var d Delivery
+		json.Unmarshal([]byte(line), &d)
+		statuses = append(statuses, d.Status)
+	}
+	if strings.Join(statuses, ",") != "sent,failed,rate_limited,sent" {
+		t.Errorf("log statuses = %v", statuses)
+	}
+
+	entries, err := fs.ReadDir("/chat")
+	if err != nil || len(entries) != 2 {
+		t.Errorf("ReadDir(/chat) = %v, %v, want the two notified targets", entries, err)
+	}
+	if _, err := fs.Write("/missing/ops", []byte("x"), -1, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Write() to an unknown provider error = %v, want not found", err)
+	}
+}
+
+func TestPagerDuty(t *testing.T) {
+	var event map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&event)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	fs := newTestFS(t, map[string]interface{}{
+		"pagerduty": map[string]interface{}{
+			"url":          server.URL,
+			"routing_keys": map[string]interface{}{"service-x": "key-x"},
+		},
+	})
+
+	msg := `{"subject": "Import failed", "message": "see logs", "severity": "critical", "fields": {"job": "nightly"}}`
+	if _, err := fs.Write("/pagerduty/service-x", []byte(msg), -1, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	payload, _ := event["payload"].(map[string]interface{})
+	details, _ := payload["custom_details"].(map[string]interface{})
+	if event["routing_key"] != "key-x" || payload["summary"] != "Import failed" || payload["severity"] != "critical" || details["job"] != "nightly" {
+		t.Errorf("event = %v", event)
+	}
+
+	if _, err := fs.Write("/pagerduty/service-y", []byte("x"), -1, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Write() to an unmapped service error = %v, want not found", err)
+	}
+	if _, err := fs.Write("/pagerduty/service-x", []byte(`{"subject": "x", "severity": "meh"}`), -1, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("Write() with a bad severity error = %v, want invalid argument", err)
+	}
+}
+
+func TestEmail(t *testing.T) {
+	var gotTo []string
+	var gotMsg string
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		gotTo, gotMsg = to, string(msg)
+		return nil
+	}
+	defer func() { sendMail = smtp.SendMail }()
+
+	fs := newTestFS(t, map[string]interface{}{
+		"email": map[string]interface{}{
+			"smtp_host":       "smtp.example.com",
+			"from":            "AGFS <agfs@example.com>",
+			"allowed_targets": []interface{}{"*@example.com"},
+			"subject":         "[agfs] {{.Subject}}",
+		},
+	})
+
+	if _, err := fs.Write("/email/ops@example.com", []byte("Disk full"), -1, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if len(gotTo) != 1 || gotTo[0] != "ops@example.com" || !strings.Contains(gotMsg, "Subject: [agfs] Disk full\r\n") {
+		t.Errorf("sent to %v:\n%s", gotTo, gotMsg)
+	}
+	if _, err := fs.Write("/email/someone@elsewhere.org", []byte("hi"), -1, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Write() outside allowed_targets error = %v, want permission denied", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	bad := []map[string]interface{}{
+		{},
+		{"providers": map[string]interface{}{"x": map[string]interface{}{"type": "sms"}}},
+		{"providers": map[string]interface{}{"hook": map[string]interface{}{"type": "webhook"}}},
+		{"providers": map[string]interface{}{"pagerduty": map[string]interface{}{}}},
+		{"providers": map[string]interface{}{"hook": map[string]interface{}{"type": "webhook", "url": "http://x", "template": "{{"}}},
+	}
+	for _, cfg := range bad {
+		if err := NewNotifyFSPlugin().Validate(cfg); err == nil {
+			t.Errorf("Validate(%v) succeeded", cfg)
+		}
+	}
+}
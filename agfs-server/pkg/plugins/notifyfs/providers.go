@@ -0,0 +1,367 @@
+package notifyfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// Provider types
+const (
+	providerEmail     = "email"
+	providerPagerDuty = "pagerduty"
+	providerWebhook   = "webhook"
+)
+
+// Defaults for provider configuration
+const (
+	defaultSMTPPort     = 587
+	defaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	defaultSeverity     = "error"
+	defaultRateLimit    = 10
+	defaultRateWindow   = time.Minute
+)
+
+// pagerDutySeverities are the severities the PagerDuty Events API accepts
+var pagerDutySeverities = map[string]bool{"critical": true, "error": true, "warning": true, "info": true}
+
+// sendMail is replaced in tests
+var sendMail = smtp.SendMail
+
+// Notification is a message written to /<provider>/<target>, as seen by
+// templates
+type Notification struct {
+	Provider string            `json:"provider"`
+	Target   string            `json:"target"`
+	Subject  string            `json:"subject"`
+	Message  string            `json:"message"`
+	Severity string            `json:"severity"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Time     time.Time         `json:"time"`
+}
+
+// provider dispatches notifications through one configured channel
+type provider struct {
+	name    string
+	kind    string
+	allowed []string // path.Match patterns of permitted targets; empty for any
+	subject *template.Template
+	body    *template.Template
+	limiter *rateLimiter
+
+	// email
+	smtpAddr string
+	auth     smtp.Auth
+	from     string
+
+	// pagerduty and webhook
+	url         string
+	routingKey  string
+	routingKeys map[string]string
+	headers     map[string]string
+	client      *http.Client
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// newProvider builds the provider called name from its configuration
+func newProvider(name string, cfg map[string]interface{}, timeout time.Duration) (*provider, error) {
+	if name == "" || strings.Contains(name, "/") || name == "README" || name == logFileName {
+		return nil, fmt.Errorf("invalid provider name %q", name)
+	}
+	p := &provider{
+		name:   name,
+		kind:   config.GetStringConfig(cfg, "type", name),
+		client: &http.Client{Timeout: timeout},
+	}
+
+	allowed, err := stringList(cfg["allowed_targets"])
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: allowed_targets: %w", name, err)
+	}
+	for _, pattern := range allowed {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("provider %s: invalid allowed_targets pattern %q", name, pattern)
+		}
+	}
+	p.allowed = allowed
+
+	if p.subject, err = template.New("subject").Funcs(templateFuncs).Parse(config.GetStringConfig(cfg, "subject", "{{.Subject}}")); err != nil {
+		return nil, fmt.Errorf("provider %s: invalid subject template: %w", name, err)
+	}
+	defaultBody := "{{.Message}}"
+	if p.kind == providerWebhook {
+		defaultBody = "{{json .}}"
+	}
+	if p.body, err = template.New("template").Funcs(templateFuncs).Parse(config.GetStringConfig(cfg, "template", defaultBody)); err != nil {
+		return nil, fmt.Errorf("provider %s: invalid template: %w", name, err)
+	}
+
+	limit := config.GetIntConfig(cfg, "rate_limit", defaultRateLimit)
+	window := defaultRateWindow
+	if s := config.GetStringConfig(cfg, "rate_window", ""); s != "" {
+		if window, err = time.ParseDuration(s); err != nil || window <= 0 {
+			return nil, fmt.Errorf("provider %s: invalid rate_window %q", name, s)
+		}
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("provider %s: rate_limit must not be negative", name)
+	}
+	p.limiter = newRateLimiter(limit, window)
+
+	switch p.kind {
+	case providerEmail:
+		host, err := config.RequireString(cfg, "smtp_host")
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %w", name, err)
+		}
+		if p.from, err = config.RequireString(cfg, "from"); err != nil {
+			return nil, fmt.Errorf("provider %s: %w", name, err)
+		}
+		if _, err := mail.ParseAddress(p.from); err != nil {
+			return nil, fmt.Errorf("provider %s: invalid from address %q", name, p.from)
+		}
+		p.smtpAddr = net.JoinHostPort(host, fmt.Sprint(config.GetIntConfig(cfg, "smtp_port", defaultSMTPPort)))
+		if username := config.GetStringConfig(cfg, "username", ""); username != "" {
+			p.auth = smtp.PlainAuth("", username, config.GetStringConfig(cfg, "password", ""), host)
+		}
+
+	case providerPagerDuty:
+		p.url = config.GetStringConfig(cfg, "url", defaultPagerDutyURL)
+		p.routingKey = config.GetStringConfig(cfg, "routing_key", "")
+		p.routingKeys, err = stringMap(cfg["routing_keys"])
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: routing_keys: %w", name, err)
+		}
+		if p.routingKey == "" && len(p.routingKeys) == 0 {
+			return nil, fmt.Errorf("provider %s: routing_key or routing_keys is required", name)
+		}
+
+	case providerWebhook:
+		if p.url, err = config.RequireString(cfg, "url"); err != nil {
+			return nil, fmt.Errorf("provider %s: %w", name, err)
+		}
+		if p.headers, err = stringMap(cfg["headers"]); err != nil {
+			return nil, fmt.Errorf("provider %s: headers: %w", name, err)
+		}
+
+	default:
+		return nil, fmt.Errorf("provider %s: unknown type %q (want email, pagerduty or webhook)", name, p.kind)
+	}
+	return p, nil
+}
+
+// checkTarget validates target before anything is sent to it
+func (p *provider) checkTarget(target string) error {
+	if len(p.allowed) > 0 {
+		permitted := false
+		for _, pattern := range p.allowed {
+			if ok, _ := path.Match(pattern, target); ok {
+				permitted = true
+				break
+			}
+		}
+		if !permitted {
+			return filesystem.NewPermissionDeniedError("notify", "/"+p.name+"/"+target, "target not in allowed_targets")
+		}
+	}
+	switch p.kind {
+	case providerEmail:
+		if addr, err := mail.ParseAddress(target); err != nil || addr.Address != target {
+			return filesystem.NewInvalidArgumentError("target", target, "not an email address")
+		}
+	case providerPagerDuty:
+		if p.routingKeys[target] == "" && p.routingKey == "" {
+			return filesystem.NewNotFoundError("notify", "/"+p.name+"/"+target)
+		}
+	}
+	return nil
+}
+
+// send renders n and delivers it
+func (p *provider) send(ctx context.Context, n *Notification) error {
+	var subject, body bytes.Buffer
+	if err := p.subject.Execute(&subject, n); err != nil {
+		return fmt.Errorf("subject template: %w", err)
+	}
+	if err := p.body.Execute(&body, n); err != nil {
+		return fmt.Errorf("template: %w", err)
+	}
+
+	switch p.kind {
+	case providerEmail:
+		return p.sendEmail(n, strings.TrimSpace(subject.String()), body.String())
+	case providerPagerDuty:
+		return p.sendPagerDuty(ctx, n, strings.TrimSpace(subject.String()), body.String())
+	default:
+		return p.post(ctx, p.url, body.Bytes(), p.headers)
+	}
+}
+
+func (p *provider) sendEmail(n *Notification, subject, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", p.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", n.Target)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mimeHeader(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+
+	from, _ := mail.ParseAddress(p.from)
+	return sendMail(p.smtpAddr, p.auth, from.Address, []string{n.Target}, msg.Bytes())
+}
+
+func (p *provider) sendPagerDuty(ctx context.Context, n *Notification, subject, body string) error {
+	routingKey := p.routingKeys[n.Target]
+	if routingKey == "" {
+		routingKey = p.routingKey
+	}
+	details := map[string]interface{}{"message": body}
+	for k, v := range n.Fields {
+		details[k] = v
+	}
+	event := map[string]interface{}{
+		"routing_key":  routingKey,
+		"event_action": "trigger",
+		"payload": map[string]interface{}{
+			"summary":        truncate(subject, 1024),
+			"source":         n.Target,
+			"severity":       n.Severity,
+			"timestamp":      n.Time.Format(time.RFC3339),
+			"custom_details": details,
+		},
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.post(ctx, p.url, data, nil)
+}
+
+// post sends data to url and fails on non-2xx responses
+func (p *provider) post(ctx context.Context, url string, data []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", p.kind, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// rateLimiter allows a number of events per target in a sliding window
+type rateLimiter struct {
+	limit  int // 0 for unlimited
+	window time.Duration
+
+	mu     sync.Mutex
+	events map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, events: make(map[string][]time.Time)}
+}
+
+// allow records an event for key, or returns how long to wait if the
+// limit is reached
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if l.limit == 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := l.events[key]
+	i := sort.Search(len(events), func(i int) bool { return now.Sub(events[i]) < l.window })
+	events = events[i:]
+	if len(events) >= l.limit {
+		l.events[key] = events
+		return false, l.window - now.Sub(events[0])
+	}
+	l.events[key] = append(events, now)
+	return true, 0
+}
+
+// stringList reads a config value holding a list of strings
+func stringList(v interface{}) ([]string, error) {
+	switch list := v.(type) {
+	case nil:
+		return nil, nil
+	case []string:
+		return list, nil
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("must be an array of strings")
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("must be an array of strings")
+}
+
+// stringMap reads a config value holding a map of strings
+func stringMap(v interface{}) (map[string]string, error) {
+	switch m := v.(type) {
+	case nil:
+		return nil, nil
+	case map[string]string:
+		return m, nil
+	case map[string]interface{}:
+		out := make(map[string]string, len(m))
+		for k, item := range m {
+			out[k] = fmt.Sprint(item)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("must be a map")
+}
+
+// mimeHeader makes s safe for a mail header, encoding it if it is not
+// plain ASCII
+func mimeHeader(s string) string {
+	return mime.QEncoding.Encode("utf-8", strings.NewReplacer("\r", " ", "\n", " ").Replace(s))
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,336 @@
+package prometheusfs
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxResponseBytes bounds the responses read from the Prometheus API
+const maxResponseBytes = 64 << 20
+
+// apiResponse is the envelope of every Prometheus HTTP API response
+type apiResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+// queryData is the data of a query response
+type queryData struct {
+	ResultType string          `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// series is an element of a vector or matrix result
+type series struct {
+	Metric map[string]string `json:"metric"`
+	Value  sample            `json:"value"`  // Vector results
+	Values []sample          `json:"values"` // Matrix results
+}
+
+// sample is a [<unix seconds>, "<value>"] pair
+type sample [2]interface{}
+
+func (s sample) time() float64 {
+	t, _ := s[0].(float64)
+	return t
+}
+
+func (s sample) value() string {
+	v, _ := s[1].(string)
+	return v
+}
+
+// get calls the Prometheus API endpoint with query and returns the data of
+// its response. Error responses map to the file system errors closest to
+// them: bad queries are invalid arguments, an unreachable or failing server
+// is unavailable.
+func (p *PrometheusFSPlugin) get(endpoint string, query url.Values, path string) (json.RawMessage, error) {
+	u := p.baseURL + endpoint
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, filesystem.NewInvalidArgumentError("url", u, err.Error())
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, value := range p.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		var timeout interface{ Timeout() bool }
+		if errors.As(err, &timeout) && timeout.Timeout() {
+			return nil, filesystem.NewTimeoutError("read", path, p.timeout)
+		}
+		return nil, filesystem.NewBackendUnavailableError(path, 0, err.Error())
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, filesystem.NewBackendUnavailableError(path, 0, err.Error())
+	}
+	log.Debugf("[prometheusfs] GET %s: %d", req.URL, resp.StatusCode)
+
+	var body apiResponse
+	decodeErr := json.Unmarshal(data, &body)
+	switch {
+	case resp.StatusCode == http.StatusOK && decodeErr == nil && body.Status == "success":
+		return body.Data, nil
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnprocessableEntity:
+		return nil, filesystem.NewInvalidArgumentError("query", path, apiError(resp, body, data))
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, filesystem.NewNotFoundError("read", path)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, filesystem.NewPermissionDeniedError("read", path, apiError(resp, body, data))
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, filesystem.NewRateLimitedError(path, 0)
+	case resp.StatusCode == http.StatusServiceUnavailable && body.ErrorType == "timeout":
+		return nil, filesystem.NewTimeoutError("read", path, p.timeout)
+	default:
+		return nil, filesystem.NewBackendUnavailableError(path, 0, apiError(resp, body, data))
+	}
+}
+
+// apiError describes an error response, preferring the error Prometheus
+// gives over the raw body
+func apiError(resp *http.Response, body apiResponse, data []byte) string {
+	if body.Error != "" {
+		return body.Error
+	}
+	msg := strings.TrimSpace(string(data))
+	if len(msg) > 200 {
+		msg = msg[:200] + "..."
+	}
+	if msg == "" {
+		return resp.Status
+	}
+	return resp.Status + ": " + msg
+}
+
+// metricNames returns the names of all metrics
+func (p *PrometheusFSPlugin) metricNames() ([]string, error) {
+	data, err := p.cached("label:__name__", func() ([]byte, error) {
+		return p.get("/api/v1/label/__name__/values", nil, "/metrics")
+	})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, filesystem.NewBackendUnavailableError("/metrics", 0, err.Error())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// hasMetric reports whether a metric exists
+func (p *PrometheusFSPlugin) hasMetric(name string) (bool, error) {
+	names, err := p.metricNames()
+	if err != nil {
+		return false, err
+	}
+	i := sort.SearchStrings(names, name)
+	return i < len(names) && names[i] == name, nil
+}
+
+// instantQuery evaluates query now and returns its result as text, one
+// sample per line, or as the JSON data of the response if raw is set
+func (p *PrometheusFSPlugin) instantQuery(query, path string, raw bool) ([]byte, error) {
+	data, err := p.get("/api/v1/query", url.Values{"query": {query}}, path)
+	if err != nil {
+		return nil, err
+	}
+	if raw {
+		return append(data, '\n'), nil
+	}
+	var result queryData
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, filesystem.NewBackendUnavailableError(path, 0, err.Error())
+	}
+	return formatResult(result, path)
+}
+
+// rangeQuery evaluates query from start to end every step and returns the
+// result as CSV
+func (p *PrometheusFSPlugin) rangeQuery(query, start, end, step, path string) ([]byte, error) {
+	data, err := p.get("/api/v1/query_range", url.Values{
+		"query": {query},
+		"start": {start},
+		"end":   {end},
+		"step":  {step},
+	}, path)
+	if err != nil {
+		return nil, err
+	}
+	var result queryData
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, filesystem.NewBackendUnavailableError(path, 0, err.Error())
+	}
+	var matrix []series
+	if err := json.Unmarshal(result.Result, &matrix); err != nil {
+		return nil, filesystem.NewBackendUnavailableError(path, 0, err.Error())
+	}
+	return formatCSV(matrix), nil
+}
+
+// seriesOf returns the label sets of the series of a metric, one per line
+func (p *PrometheusFSPlugin) seriesOf(name, path string) ([]byte, error) {
+	data, err := p.get("/api/v1/series", url.Values{"match[]": {name}}, path)
+	if err != nil {
+		return nil, err
+	}
+	var labelSets []map[string]string
+	if err := json.Unmarshal(data, &labelSets); err != nil {
+		return nil, filesystem.NewBackendUnavailableError(path, 0, err.Error())
+	}
+	lines := make([]string, 0, len(labelSets))
+	for _, labels := range labelSets {
+		lines = append(lines, seriesName(labels))
+	}
+	sort.Strings(lines)
+	return joinLines(lines), nil
+}
+
+// metadataOf returns the type, help and unit of a metric, as reported by
+// its targets
+func (p *PrometheusFSPlugin) metadataOf(name, path string) ([]byte, error) {
+	data, err := p.get("/api/v1/metadata", url.Values{"metric": {name}}, path)
+	if err != nil {
+		return nil, err
+	}
+	var metadata map[string][]struct {
+		Type string `json:"type"`
+		Help string `json:"help"`
+		Unit string `json:"unit"`
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, filesystem.NewBackendUnavailableError(path, 0, err.Error())
+	}
+	var buf bytes.Buffer
+	for _, m := range metadata[name] {
+		fmt.Fprintf(&buf, "type: %s\nhelp: %s\n", m.Type, m.Help)
+		if m.Unit != "" {
+			fmt.Fprintf(&buf, "unit: %s\n", m.Unit)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// formatResult renders a query result as text: vector samples as
+// "<series> <value>", matrix samples as "<series> <value> <timestamp ms>"
+// like the exposition format, and scalars and strings as their value
+func formatResult(result queryData, path string) ([]byte, error) {
+	switch result.ResultType {
+	case "vector", "matrix":
+		var list []series
+		if err := json.Unmarshal(result.Result, &list); err != nil {
+			return nil, filesystem.NewBackendUnavailableError(path, 0, err.Error())
+		}
+		sort.Slice(list, func(i, j int) bool { return seriesName(list[i].Metric) < seriesName(list[j].Metric) })
+		var lines []string
+		for _, s := range list {
+			name := seriesName(s.Metric)
+			if result.ResultType == "vector" {
+				lines = append(lines, name+" "+s.Value.value())
+				continue
+			}
+			for _, v := range s.Values {
+				lines = append(lines, fmt.Sprintf("%s %s %d", name, v.value(), int64(v.time()*1000)))
+			}
+		}
+		return joinLines(lines), nil
+	case "scalar", "string":
+		var v sample
+		if err := json.Unmarshal(result.Result, &v); err != nil {
+			return nil, filesystem.NewBackendUnavailableError(path, 0, err.Error())
+		}
+		return []byte(v.value() + "\n"), nil
+	default:
+		return nil, filesystem.NewBackendUnavailableError(path, 0, fmt.Sprintf("unknown result type %q", result.ResultType))
+	}
+}
+
+// formatCSV renders a matrix as CSV: a timestamp column, in RFC 3339, and
+// a column per series. Series without a sample at a time leave it empty.
+func formatCSV(matrix []series) []byte {
+	sort.Slice(matrix, func(i, j int) bool { return seriesName(matrix[i].Metric) < seriesName(matrix[j].Metric) })
+	header := []string{"timestamp"}
+	rows := make(map[float64][]string)
+	var times []float64
+	for i, s := range matrix {
+		header = append(header, seriesName(s.Metric))
+		for _, v := range s.Values {
+			row, ok := rows[v.time()]
+			if !ok {
+				row = make([]string, len(matrix))
+				rows[v.time()] = row
+				times = append(times, v.time())
+			}
+			row[i] = v.value()
+		}
+	}
+	sort.Float64s(times)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(header)
+	for _, t := range times {
+		sec := int64(t)
+		ts := time.Unix(sec, int64((t-float64(sec))*1e9)).UTC().Format(time.RFC3339Nano)
+		w.Write(append([]string{ts}, rows[t]...))
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// seriesName renders a label set as PromQL does:
+// name{label="value",...}, labels in order
+func seriesName(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		if k != "__name__" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(labels["__name__"])
+	if len(keys) == 0 && b.Len() > 0 {
+		return b.String()
+	}
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[k]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
@@ -0,0 +1,561 @@
+package prometheusfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PluginName = "prometheusfs"
+
+	readmeFile = "README"
+	metricsDir = "metrics"
+	queryDir   = "query"
+	rangeDir   = "range"
+)
+
+// Files of a metric directory
+var metricFiles = []string{"value", "series", "metadata"}
+
+const (
+	defaultTimeout  = 30 * time.Second
+	defaultCacheTTL = 5 * time.Second
+
+	// maxCacheEntries bounds the results kept for cache_ttl
+	maxCacheEntries = 256
+)
+
+// PrometheusFSPlugin mounts a Prometheus server: metrics are directories,
+// PromQL queries are files under /query, evaluated when read, and range
+// queries are CSV files under /range/<window>/<step>.
+type PrometheusFSPlugin struct {
+	baseURL  string
+	headers  map[string]string
+	timeout  time.Duration
+	cacheTTL time.Duration
+	client   *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedResult
+}
+
+// cachedResult is a result kept so that the stat and read of a file, or
+// reads by several agents at once, see the same data and cost one query
+type cachedResult struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewPrometheusFSPlugin creates a new PrometheusFS plugin
+func NewPrometheusFSPlugin() *PrometheusFSPlugin {
+	return &PrometheusFSPlugin{cache: make(map[string]cachedResult)}
+}
+
+func (p *PrometheusFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *PrometheusFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"url", "headers", "timeout", "cache_ttl", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	for _, key := range []string{"url", "timeout", "cache_ttl"} {
+		if err := config.ValidateStringType(cfg, key); err != nil {
+			return err
+		}
+	}
+	if err := config.ValidateMapType(cfg, "headers"); err != nil {
+		return err
+	}
+	if config.GetStringConfig(cfg, "url", "") == "" {
+		return fmt.Errorf("url is required")
+	}
+	if _, err := durationConfig(cfg, "timeout", defaultTimeout); err != nil {
+		return err
+	}
+	_, err := durationConfig(cfg, "cache_ttl", defaultCacheTTL)
+	return err
+}
+
+// durationConfig reads a duration option, such as "30s"
+func durationConfig(cfg map[string]interface{}, key string, def time.Duration) (time.Duration, error) {
+	s := config.GetStringConfig(cfg, key, "")
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, s)
+	}
+	return d, nil
+}
+
+func (p *PrometheusFSPlugin) Initialize(cfg map[string]interface{}) error {
+	var err error
+	if p.timeout, err = durationConfig(cfg, "timeout", defaultTimeout); err != nil {
+		return err
+	}
+	if p.cacheTTL, err = durationConfig(cfg, "cache_ttl", defaultCacheTTL); err != nil {
+		return err
+	}
+	p.baseURL = strings.TrimSuffix(os.ExpandEnv(config.GetStringConfig(cfg, "url", "")), "/")
+	if p.baseURL == "" {
+		return fmt.Errorf("url is required")
+	}
+	p.headers = make(map[string]string)
+	if headers, ok := cfg["headers"].(map[string]interface{}); ok {
+		for name, value := range headers {
+			p.headers[name] = os.ExpandEnv(fmt.Sprint(value))
+		}
+	}
+	p.client = &http.Client{Timeout: p.timeout}
+	log.Infof("[prometheusfs] Mounted Prometheus at %s", p.baseURL)
+	return nil
+}
+
+func (p *PrometheusFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return &promFS{plugin: p}
+}
+
+func (p *PrometheusFSPlugin) GetReadme() string {
+	return `PrometheusFS Plugin - Prometheus Metrics as Files
+
+This plugin mounts a Prometheus server, so that metrics can be browsed with
+ls and queried with cat, without knowing its HTTP API.
+
+STRUCTURE:
+  /metrics/<name>/value      Current value of each series of the metric
+  /metrics/<name>/series     Label sets of the series of the metric
+  /metrics/<name>/metadata   Type, help and unit of the metric
+  /query/<promql>            Instant query, evaluated when read
+  /query/<promql>.json       The same, as the API's JSON result
+  /range/<window>/<step>/<promql>[.csv]
+                             Range query, as CSV: a timestamp column and
+                             a column per series
+
+  <window> is a duration ending now, such as 1h or 7d, or <start>..<end>
+  with RFC 3339 or Unix times. <step> is a duration such as 1m, or
+  seconds.
+
+USAGE:
+  ls /prom/metrics
+  cat /prom/metrics/up/value
+  cat '/prom/query/rate(http_requests_total[5m])'
+  cat '/prom/query/sum by (job) (rate(http_requests_total[5m]))'
+  cat '/prom/range/1h/1m/rate(http_requests_total[5m]).csv'
+  cat '/prom/range/2024-05-01T00:00:00Z..2024-05-02T00:00:00Z/1h/up'
+
+  Instant results print a sample per line, "<series> <value>", and
+  range vectors "<series> <value> <timestamp ms>". Bad queries fail with
+  Prometheus's error message.
+
+CONFIGURATION:
+  url: http://localhost:9090         # Prometheus server (required)
+  headers:                           # Sent with every request
+    Authorization: "Bearer ${PROM_TOKEN}"
+  timeout: 30s                       # Per request
+  cache_ttl: 5s                      # How long results are reused
+
+VERSION: 1.0.0
+`
+}
+
+func (p *PrometheusFSPlugin) GetConfigParams() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{
+			Name:        "url",
+			Type:        "string",
+			Required:    true,
+			Default:     "",
+			Description: "Base URL of the Prometheus server, e.g. http://localhost:9090",
+		},
+		{
+			Name:        "headers",
+			Type:        "map",
+			Required:    false,
+			Default:     "",
+			Description: "Headers sent with every request; ${VAR} reads the environment",
+		},
+		{
+			Name:        "timeout",
+			Type:        "string",
+			Required:    false,
+			Default:     "30s",
+			Description: "Timeout of each request",
+		},
+		{
+			Name:        "cache_ttl",
+			Type:        "string",
+			Required:    false,
+			Default:     "5s",
+			Description: "How long query results are reused; 0 disables reuse",
+		},
+	}
+}
+
+func (p *PrometheusFSPlugin) Shutdown() error {
+	if p.client != nil {
+		p.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// cached returns the result kept under key, or fetches and keeps it for
+// cache_ttl. Expired results are swept when the cache is full.
+func (p *PrometheusFSPlugin) cached(key string, fetch func() ([]byte, error)) ([]byte, error) {
+	if p.cacheTTL <= 0 {
+		return fetch()
+	}
+	now := time.Now()
+	p.mu.Lock()
+	entry, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.data, nil
+	}
+
+	data, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.cache) >= maxCacheEntries {
+		for k, e := range p.cache {
+			if !now.Before(e.expires) {
+				delete(p.cache, k)
+			}
+		}
+		if len(p.cache) >= maxCacheEntries {
+			p.cache = make(map[string]cachedResult)
+		}
+	}
+	p.cache[key] = cachedResult{data: data, expires: now.Add(p.cacheTTL)}
+	return data, nil
+}
+
+// node is what a path names
+type node struct {
+	kind   string // "root", "readme", "dir", "metric", "metricFile", "query", "range"
+	name   string // Metric name, or metric file
+	metric string
+	query  string
+	window string
+	step   string
+}
+
+func (n *node) isDir() bool {
+	return n.kind == "root" || n.kind == "dir" || n.kind == "metric"
+}
+
+// resolve returns the node of a path. Everything below /query, and below
+// /range/<window>/<step>, is a query, slashes included, so that PromQL
+// divisions need no escaping.
+func (fs *promFS) resolve(op, path string) (*node, error) {
+	path = filesystem.NormalizePath(path)
+	if path == "/" {
+		return &node{kind: "root"}, nil
+	}
+	elems := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 4)
+	switch elems[0] {
+	case readmeFile:
+		if len(elems) == 1 {
+			return &node{kind: "readme", name: readmeFile}, nil
+		}
+	case metricsDir:
+		if len(elems) == 1 {
+			return &node{kind: "dir", name: metricsDir}, nil
+		}
+		ok, err := fs.plugin.hasMetric(elems[1])
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		switch len(elems) {
+		case 2:
+			return &node{kind: "metric", name: elems[1], metric: elems[1]}, nil
+		case 3:
+			for _, file := range metricFiles {
+				if elems[2] == file {
+					return &node{kind: "metricFile", name: file, metric: elems[1]}, nil
+				}
+			}
+		}
+	case queryDir:
+		if len(elems) == 1 {
+			return &node{kind: "dir", name: queryDir}, nil
+		}
+		query := strings.TrimPrefix(path, "/"+queryDir+"/")
+		return &node{kind: "query", name: baseName(path), query: query}, nil
+	case rangeDir:
+		if len(elems) == 1 {
+			return &node{kind: "dir", name: rangeDir}, nil
+		}
+		if _, _, err := parseWindow(elems[1], time.Now()); err != nil {
+			return nil, err
+		}
+		if len(elems) == 2 {
+			return &node{kind: "dir", name: elems[1]}, nil
+		}
+		if err := checkStep(elems[2]); err != nil {
+			return nil, err
+		}
+		if len(elems) == 3 {
+			return &node{kind: "dir", name: elems[2]}, nil
+		}
+		return &node{kind: "range", name: baseName(path), window: elems[1], step: elems[2], query: elems[3]}, nil
+	}
+	return nil, filesystem.NewNotFoundError(op, path)
+}
+
+// baseName returns the last element of a normalized path
+func baseName(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// parseWindow returns the start and end of a range window: a duration
+// ending at now, such as "1h" or "7d", or "<start>..<end>", passed to
+// Prometheus as they are
+func parseWindow(window string, now time.Time) (string, string, error) {
+	if start, end, ok := strings.Cut(window, ".."); ok {
+		if start == "" || end == "" {
+			return "", "", filesystem.NewInvalidArgumentError("window", window, "must be <start>..<end>")
+		}
+		return start, end, nil
+	}
+	d, err := parseDuration(window)
+	if err != nil || d <= 0 {
+		return "", "", filesystem.NewInvalidArgumentError("window", window, "must be a duration such as 1h, or <start>..<end>")
+	}
+	return unixTime(now.Add(-d)), unixTime(now), nil
+}
+
+// checkStep validates the step of a range query: a duration or seconds
+func checkStep(step string) error {
+	if d, err := parseDuration(step); err == nil && d > 0 {
+		return nil
+	}
+	if s, err := strconv.ParseFloat(step, 64); err == nil && s > 0 {
+		return nil
+	}
+	return filesystem.NewInvalidArgumentError("step", step, "must be a duration such as 1m, or seconds")
+}
+
+// parseDuration parses a Go duration, or a Prometheus one in days, weeks
+// or years, such as "7d"
+func parseDuration(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour, 'y': 365 * 24 * time.Hour}
+	if len(s) > 1 {
+		if unit, ok := units[s[len(s)-1]]; ok {
+			if n, err := strconv.Atoi(s[:len(s)-1]); err == nil {
+				return time.Duration(n) * unit, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid duration %q", s)
+}
+
+func unixTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', 3, 64)
+}
+
+// read returns the content of a file node
+func (p *PrometheusFSPlugin) read(n *node, path string) ([]byte, error) {
+	path = filesystem.NormalizePath(path)
+	switch n.kind {
+	case "readme":
+		return []byte(p.GetReadme()), nil
+	case "metricFile":
+		return p.cached(path, func() ([]byte, error) {
+			switch n.name {
+			case "series":
+				return p.seriesOf(n.metric, path)
+			case "metadata":
+				return p.metadataOf(n.metric, path)
+			default:
+				return p.instantQuery(n.metric, path, false)
+			}
+		})
+	case "query":
+		return p.cached(path, func() ([]byte, error) {
+			if query, ok := strings.CutSuffix(n.query, ".json"); ok {
+				return p.instantQuery(query, path, true)
+			}
+			return p.instantQuery(n.query, path, false)
+		})
+	case "range":
+		return p.cached(path, func() ([]byte, error) {
+			start, end, err := parseWindow(n.window, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			return p.rangeQuery(strings.TrimSuffix(n.query, ".csv"), start, end, n.step, path)
+		})
+	}
+	return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+}
+
+// promFS exposes a Prometheus server as a read-only file system
+type promFS struct {
+	plugin *PrometheusFSPlugin
+}
+
+func readOnly(op, path string) error {
+	return filesystem.NewPermissionDeniedError(op, path, "prometheusfs is read-only")
+}
+
+func (fs *promFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	n, err := fs.resolve("read", path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := fs.plugin.read(n, path)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+func (fs *promFS) Stat(path string) (*filesystem.FileInfo, error) {
+	n, err := fs.resolve("stat", path)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if n.isDir() {
+		return dirInfo(n, now), nil
+	}
+	// Queries are evaluated to know their size; the result is kept for the
+	// read that usually follows
+	data, err := fs.plugin.read(n, path)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo(n, int64(len(data)), now), nil
+}
+
+func dirInfo(n *node, now time.Time) *filesystem.FileInfo {
+	name := n.name
+	if n.kind == "root" {
+		name = "/"
+	}
+	return &filesystem.FileInfo{Name: name, Mode: 0555, ModTime: now, IsDir: true,
+		Meta: filesystem.MetaData{Name: PluginName, Type: "directory"}}
+}
+
+func fileInfo(n *node, size int64, now time.Time) *filesystem.FileInfo {
+	info := &filesystem.FileInfo{Name: n.name, Size: size, Mode: 0444, ModTime: now,
+		Meta: filesystem.MetaData{Name: PluginName, Type: n.kind}}
+	switch {
+	case n.kind == "readme":
+		info.Meta.Type = "doc"
+	case n.kind == "range":
+		info.Meta.Content = map[string]string{"query": strings.TrimSuffix(n.query, ".csv"), "window": n.window, "step": n.step}
+	case n.kind == "query":
+		info.Meta.Content = map[string]string{"query": strings.TrimSuffix(n.query, ".json")}
+	}
+	return info
+}
+
+// ReadDir lists the metrics and their files. Query directories list
+// nothing: their files are the queries read from them. Listed files are
+// not evaluated, so their size is 0 until they are stat'ed.
+func (fs *promFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	n, err := fs.resolve("readdir", path)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isDir() {
+		return nil, filesystem.NewNotDirectoryError(path)
+	}
+
+	now := time.Now()
+	var infos []filesystem.FileInfo
+	switch {
+	case n.kind == "root":
+		readme, _ := fs.Stat("/" + readmeFile)
+		infos = append(infos, *readme)
+		for _, name := range []string{metricsDir, queryDir, rangeDir} {
+			infos = append(infos, *dirInfo(&node{kind: "dir", name: name}, now))
+		}
+	case n.kind == "metric":
+		for _, file := range metricFiles {
+			infos = append(infos, *fileInfo(&node{kind: "metricFile", name: file}, 0, now))
+		}
+	case filesystem.NormalizePath(path) == "/"+metricsDir:
+		names, err := fs.plugin.metricNames()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			infos = append(infos, *dirInfo(&node{kind: "metric", name: name}, now))
+		}
+	}
+	return infos, nil
+}
+
+func (fs *promFS) Create(path string) error {
+	return readOnly("create", path)
+}
+
+func (fs *promFS) Mkdir(path string, perm uint32) error {
+	return readOnly("mkdir", path)
+}
+
+func (fs *promFS) Remove(path string) error {
+	return readOnly("remove", path)
+}
+
+func (fs *promFS) RemoveAll(path string) error {
+	return readOnly("remove", path)
+}
+
+func (fs *promFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	return 0, readOnly("write", path)
+}
+
+func (fs *promFS) Rename(oldPath, newPath string) error {
+	return readOnly("rename", oldPath)
+}
+
+func (fs *promFS) Chmod(path string, mode uint32) error {
+	return readOnly("chmod", path)
+}
+
+func (fs *promFS) Truncate(path string, size int64) error {
+	return readOnly("truncate", path)
+}
+
+func (fs *promFS) Open(path string) (io.ReadCloser, error) {
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (fs *promFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return nil, readOnly("write", path)
+}
+
+// Ensure PrometheusFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*PrometheusFSPlugin)(nil)
+var _ filesystem.FileSystem = (*promFS)(nil)
@@ -0,0 +1,195 @@
+package prometheusfs
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// fakePrometheus serves canned answers of the Prometheus HTTP API
+type fakePrometheus struct {
+	mu      sync.Mutex
+	queries []string
+	ranges  []string // "<query> <start> <end> <step>"
+}
+
+func (f *fakePrometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	q := r.URL.Query()
+	switch r.URL.Path {
+	case "/api/v1/label/__name__/values":
+		w.Write([]byte(`{"status":"success","data":["up","http_requests_total"]}`))
+	case "/api/v1/series":
+		w.Write([]byte(`{"status":"success","data":[{"__name__":"up","job":"api","instance":"b:80"},{"__name__":"up","job":"api","instance":"a:80"}]}`))
+	case "/api/v1/metadata":
+		w.Write([]byte(`{"status":"success","data":{"up":[{"type":"gauge","help":"Whether the target is up.","unit":""}]}}`))
+	case "/api/v1/query":
+		f.queries = append(f.queries, q.Get("query"))
+		switch q.Get("query") {
+		case "up":
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[` +
+				`{"metric":{"__name__":"up","job":"api","instance":"b:80"},"value":[1700000000,"0"]},` +
+				`{"metric":{"__name__":"up","job":"api","instance":"a:80"},"value":[1700000000,"1"]}]}}`))
+		case "sum(up) / count(up)":
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"0.5"]}]}}`))
+		case "up[1m]":
+			w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[` +
+				`{"metric":{"__name__":"up","job":"api"},"values":[[1700000000,"1"],[1700000030.5,"1"]]}]}}`))
+		case "time()":
+			w.Write([]byte(`{"status":"success","data":{"resultType":"scalar","result":[1700000000,"1700000000"]}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error: unexpected end of input"}`))
+		}
+	case "/api/v1/query_range":
+		f.ranges = append(f.ranges, strings.Join([]string{q.Get("query"), q.Get("start"), q.Get("end"), q.Get("step")}, " "))
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[` +
+			`{"metric":{"job":"web"},"values":[[1700000060,"2"]]},` +
+			`{"metric":{"job":"api"},"values":[[1700000000,"1"],[1700000060,"3"]]}]}}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestFS(t *testing.T) (*promFS, *fakePrometheus) {
+	t.Helper()
+	prom := &fakePrometheus{}
+	server := httptest.NewServer(prom)
+	t.Cleanup(server.Close)
+	t.Setenv("PROMETHEUSFS_TEST_TOKEN", "secret")
+
+	cfg := map[string]interface{}{
+		"url":     server.URL + "/",
+		"headers": map[string]interface{}{"Authorization": "Bearer ${PROMETHEUSFS_TEST_TOKEN}"},
+	}
+	p := NewPrometheusFSPlugin()
+	if err := p.Validate(cfg); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if err := p.Initialize(cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	return p.GetFileSystem().(*promFS), prom
+}
+
+func names(infos []filesystem.FileInfo) string {
+	var names []string
+	for _, info := range infos {
+		if info.IsDir {
+			names = append(names, info.Name+"/")
+		} else {
+			names = append(names, info.Name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+func TestValidate(t *testing.T) {
+	for _, cfg := range []map[string]interface{}{
+		{},
+		{"url": "http://prom:9090", "timeout": "soon"},
+		{"url": "http://prom:9090", "headers": "x"},
+		{"url": "http://prom:9090", "step": "1m"},
+	} {
+		if err := NewPrometheusFSPlugin().Validate(cfg); err == nil {
+			t.Errorf("Validate(%v) succeeded", cfg)
+		}
+	}
+}
+
+func TestPrometheusFS(t *testing.T) {
+	fs, prom := newTestFS(t)
+
+	root, err := fs.ReadDir("/")
+	if err != nil || names(root) != "README metrics/ query/ range/" {
+		t.Fatalf("ReadDir(/) = %q, %v", names(root), err)
+	}
+	metrics, err := fs.ReadDir("/metrics")
+	if err != nil || names(metrics) != "http_requests_total/ up/" {
+		t.Fatalf("ReadDir(/metrics) = %q, %v", names(metrics), err)
+	}
+	if files, err := fs.ReadDir("/metrics/up"); err != nil || names(files) != "value series metadata" {
+		t.Errorf("ReadDir(/metrics/up) = %q, %v", names(files), err)
+	}
+	if _, err := fs.Stat("/metrics/missing"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Stat of a missing metric: %v", err)
+	}
+
+	for path, want := range map[string]string{
+		"/metrics/up/value":          "up{instance=\"a:80\",job=\"api\"} 1\nup{instance=\"b:80\",job=\"api\"} 0\n",
+		"/metrics/up/series":         "up{instance=\"a:80\",job=\"api\"}\nup{instance=\"b:80\",job=\"api\"}\n",
+		"/metrics/up/metadata":       "type: gauge\nhelp: Whether the target is up.\n",
+		"/query/sum(up) / count(up)": "{} 0.5\n",
+		"/query/up[1m]":              "up{job=\"api\"} 1 1700000000000\nup{job=\"api\"} 1 1700000030500\n",
+		"/query/time()":              "1700000000\n",
+	} {
+		data, err := fs.Read(path, 0, -1)
+		if (err != nil && err != io.EOF) || string(data) != want {
+			t.Errorf("Read(%s) = %q, %v, want %q", path, data, err, want)
+		}
+	}
+	if data, err := fs.Read("/query/time().json", 0, -1); (err != nil && err != io.EOF) || !strings.Contains(string(data), `"resultType":"scalar"`) {
+		t.Errorf("Read of a JSON query = %q, %v", data, err)
+	}
+
+	// Stat evaluates the query, and the read that follows reuses the result
+	prom.queries = nil
+	info, err := fs.Stat("/query/up")
+	if err != nil || info.IsDir || info.Size == 0 {
+		t.Fatalf("Stat(/query/up) = %+v, %v", info, err)
+	}
+	if data, _ := fs.Read("/query/up", 0, -1); int64(len(data)) != info.Size || len(prom.queries) != 1 {
+		t.Errorf("read %d bytes after a stat of %d, with %d queries", len(data), info.Size, len(prom.queries))
+	}
+
+	if _, err := fs.Read("/query/rate(", 0, -1); !errors.Is(err, filesystem.ErrInvalidArgument) || !strings.Contains(err.Error(), "parse error") {
+		t.Errorf("Read of a bad query: %v", err)
+	}
+	if _, err := fs.Write("/query/up", []byte("x"), -1, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Write: %v", err)
+	}
+}
+
+func TestPrometheusFSRange(t *testing.T) {
+	fs, prom := newTestFS(t)
+
+	for _, dir := range []string{"/range/1h", "/range/7d/1m", "/range/1700000000..1700003600/60"} {
+		if info, err := fs.Stat(dir); err != nil || !info.IsDir {
+			t.Errorf("Stat(%s) = %+v, %v", dir, info, err)
+		}
+	}
+	for _, bad := range []string{"/range/soon", "/range/1h/0s", "/range/..1700003600"} {
+		if _, err := fs.Stat(bad); !errors.Is(err, filesystem.ErrInvalidArgument) {
+			t.Errorf("Stat(%s): %v", bad, err)
+		}
+	}
+
+	data, err := fs.Read("/range/2023-11-14T22:13:20Z..2023-11-14T22:14:20Z/1m/sum by (job) (rate(x[5m])).csv", 0, -1)
+	want := `timestamp,"{job=""api""}","{job=""web""}"` + "\n" +
+		"2023-11-14T22:13:20Z,1,\n" +
+		"2023-11-14T22:14:20Z,3,2\n"
+	if (err != nil && err != io.EOF) || string(data) != want {
+		t.Errorf("range CSV = %q, %v, want %q", data, err, want)
+	}
+	if got := prom.ranges[0]; got != "sum by (job) (rate(x[5m])) 2023-11-14T22:13:20Z 2023-11-14T22:14:20Z 1m" {
+		t.Errorf("range query sent = %q", got)
+	}
+
+	// A relative window ends now
+	fs.Read("/range/1h/30s/up", 0, -1)
+	fields := strings.Fields(prom.ranges[1])
+	if len(fields) != 4 || fields[0] != "up" || fields[3] != "30s" || fields[1] >= fields[2] {
+		t.Errorf("relative range query sent = %q", prom.ranges[1])
+	}
+}
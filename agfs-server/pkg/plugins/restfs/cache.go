@@ -0,0 +1,218 @@
+package restfs
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Control files of the response cache
+const (
+	cacheDir       = ".cache"
+	cacheFlushFile = "flush"
+	cacheStatsFile = "stats"
+)
+
+// Defaults of the response cache
+const (
+	defaultCacheTTL        = 30 * time.Second
+	defaultCacheMaxEntries = 1000
+)
+
+// CacheStats describes the response cache, as read from /.cache/stats
+type CacheStats struct {
+	Entries     int    `json:"entries"`
+	Hits        uint64 `json:"hits"`        // Served without a request
+	Revalidated uint64 `json:"revalidated"` // Confirmed unchanged by a 304
+	Misses      uint64 `json:"misses"`      // Fetched from the API
+	Stale       uint64 `json:"stale"`       // Served expired because the API failed
+}
+
+// responseCache keeps the responses of GET requests by URL, so that
+// listing and reading a remote API does not cost a request every time.
+// Responses are fresh for as long as their Cache-Control or Expires
+// headers say, or a configured TTL when they say nothing; once stale, they
+// are revalidated with If-None-Match or If-Modified-Since.
+type responseCache struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // URL -> *cacheEntry
+	lru     *list.List               // Most recently used first
+	stats   CacheStats
+}
+
+// cacheEntry is a cached response
+type cacheEntry struct {
+	url          string
+	path         string // File system path the response was fetched for
+	body         []byte
+	etag         string
+	lastModified string
+	expires      time.Time
+}
+
+func newResponseCache(ttl time.Duration, maxEntries int) *responseCache {
+	return &responseCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// get returns the entry of a URL, and whether it is fresh
+func (c *responseCache) get(url string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[url]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	entry := elem.Value.(*cacheEntry)
+	return entry, c.now().Before(entry.expires)
+}
+
+// conditional adds the validators of an entry to the headers of a request
+func (e *cacheEntry) conditional(header http.Header) {
+	if e.etag != "" {
+		header.Set("If-None-Match", e.etag)
+	}
+	if e.lastModified != "" {
+		header.Set("If-Modified-Since", e.lastModified)
+	}
+}
+
+// put stores a response, unless the API forbids it or it could neither be
+// reused nor revalidated. ttl applies when the response gives no lifetime.
+func (c *responseCache) put(url, path string, resp *http.Response, body []byte, ttl time.Duration) {
+	lifetime, store := freshness(resp.Header, ttl, c.now())
+	entry := &cacheEntry{
+		url:          url,
+		path:         path,
+		body:         body,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		expires:      c.now().Add(lifetime),
+	}
+	if !store || (lifetime <= 0 && entry.etag == "" && entry.lastModified == "") {
+		c.remove(url)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[url]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[url] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).url)
+	}
+}
+
+// revalidate extends an entry the API confirmed unchanged with a 304
+func (c *responseCache) revalidate(entry *cacheEntry, resp *http.Response, ttl time.Duration) {
+	lifetime, _ := freshness(resp.Header, ttl, c.now())
+	updated := *entry
+	updated.expires = c.now().Add(lifetime)
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		updated.etag = etag
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[entry.url]; ok && elem.Value == entry {
+		elem.Value = &updated
+	}
+	c.stats.Revalidated++
+}
+
+// count records how a request was served
+func (c *responseCache) count(counter *uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*counter++
+}
+
+func (c *responseCache) remove(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[url]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, url)
+	}
+}
+
+// flush drops the entries fetched for path, and unless exact for the paths
+// below it, and returns how many it dropped
+func (c *responseCache) flush(prefix string, exact bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dropped := 0
+	for url, elem := range c.entries {
+		path := elem.Value.(*cacheEntry).path
+		if path == prefix || !exact && (prefix == "/" || strings.HasPrefix(path, prefix+"/")) {
+			c.lru.Remove(elem)
+			delete(c.entries, url)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// statsData returns the content of /.cache/stats
+func (c *responseCache) statsData() []byte {
+	c.mu.Lock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	c.mu.Unlock()
+	data, _ := json.MarshalIndent(stats, "", "  ")
+	return append(data, '\n')
+}
+
+// freshness returns how long a response may be reused without
+// revalidation, following its Cache-Control max-age, no-cache and no-store
+// directives, then its Expires header, then ttl; store is false for
+// no-store responses
+func freshness(header http.Header, ttl time.Duration, now time.Time) (lifetime time.Duration, store bool) {
+	maxAge := -1
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directive)), "=")
+		switch name {
+		case "no-store":
+			return 0, false
+		case "no-cache":
+			maxAge = 0
+		case "max-age", "s-maxage":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && maxAge != 0 {
+				maxAge = seconds
+			}
+		}
+	}
+	if maxAge >= 0 {
+		return time.Duration(maxAge) * time.Second, true
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0, true // An invalid date means already expired
+		}
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			now = date
+		}
+		return max(t.Sub(now), 0), true
+	}
+	return ttl, true
+}
@@ -0,0 +1,637 @@
+package restfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Mapping describes how a REST API is laid out as a file tree. It is
+// written in YAML, per mount, so that an API without an OpenAPI spec can be
+// mounted without writing Go code:
+//
+//	base_url: https://crm.example.com/api
+//	headers:
+//	  Authorization: "Bearer ${CRM_TOKEN}"
+//	resources:
+//	  - path: /contacts
+//	    list: {url: /contacts, result: data, name: id}
+//	  - path: /contacts/{id}.json
+//	    read: {url: "/contacts/{id}", result: data}
+//	    write: {url: "/contacts/{id}", body: '{"data": {content}}'}
+//	    delete: {url: "/contacts/{id}"}
+type Mapping struct {
+	BaseURL   string            `yaml:"base_url"`
+	Headers   map[string]string `yaml:"headers"` // Sent with every request; ${VAR} expands environment variables
+	Timeout   string            `yaml:"timeout"` // Per request, default 30s
+	Resources []*Resource       `yaml:"resources"`
+
+	timeout time.Duration
+}
+
+// Resource maps a path template onto API operations. A resource that can
+// be listed is a directory whose entries are the items of the list; one
+// that can be read is a file. A segment of the template may hold one
+// variable, with a literal prefix or suffix, e.g. "{id}.json".
+type Resource struct {
+	Path   string     `yaml:"path"`
+	List   *Operation `yaml:"list"`
+	Read   *Operation `yaml:"read"`
+	Write  *Operation `yaml:"write"`  // Replaces an existing file
+	Create *Operation `yaml:"create"` // Creates a file that does not exist yet
+	Delete *Operation `yaml:"delete"`
+
+	segments []segment
+}
+
+// Operation is one API call. Its URL, query and body are templates: {var}
+// is replaced by a variable of the resource path, and in the body
+// {content} by the data written, or {content_json} by that data as a JSON
+// string.
+type Operation struct {
+	Method string            `yaml:"method"` // Defaults to GET, PUT, POST or DELETE by operation
+	URL    string            `yaml:"url"`    // Relative to base_url, or absolute; defaults to the resource path
+	Query  map[string]string `yaml:"query"`
+	Body   string            `yaml:"body"`   // Defaults to {content} for writes
+	Result string            `yaml:"result"` // JSON path of the content read, or of the items listed, e.g. "data.items"
+	Name   string            `yaml:"name"`   // For lists, JSON path of an item's name within the item
+
+	// CacheTTL is how long responses of a list or read stay fresh when the
+	// API does not say, instead of the mount's cache_ttl; "0" revalidates
+	// them every time
+	CacheTTL string `yaml:"cache_ttl"`
+
+	// Paginate follows the pages of a list
+	Paginate *Pagination `yaml:"paginate"`
+
+	cacheTTL time.Duration // -1 when unset
+}
+
+// Pagination describes how a list is split into pages: the response of
+// each page holds either the URL of the next one, or a cursor to request
+// it with. Pages are fetched max_pages at a time; the rest of a longer
+// listing is in its .more directory.
+type Pagination struct {
+	Next        string `yaml:"next"`         // JSON path of the next page's URL, absolute or relative to the page
+	Cursor      string `yaml:"cursor"`       // JSON path of the next page's cursor
+	CursorParam string `yaml:"cursor_param"` // Query parameter the cursor is sent in
+	MaxPages    int    `yaml:"max_pages"`    // Pages per listing, default 10
+}
+
+// segment is one element of a path template: a literal, or a variable
+// between a literal prefix and suffix
+type segment struct {
+	literal  string
+	variable string
+	prefix   string
+	suffix   string
+}
+
+// placeholderPattern matches the {name} placeholders of templates, and not
+// the braces of JSON bodies
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Placeholders of the body of a write
+const (
+	contentPlaceholder     = "content"
+	contentJSONPlaceholder = "content_json"
+)
+
+// defaultTimeout bounds each request when the mapping sets no timeout
+const defaultTimeout = 30 * time.Second
+
+// moreDir is the directory holding the rest of a listing cut at max_pages
+const moreDir = ".more"
+
+// defaultMaxPages bounds the pages fetched per listing
+const defaultMaxPages = 10
+
+// ParseMapping parses and validates a mapping
+func ParseMapping(data []byte) (*Mapping, error) {
+	var m Mapping
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid mapping: %w", err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("invalid mapping: %w", err)
+	}
+	return &m, nil
+}
+
+func (m *Mapping) validate() error {
+	m.BaseURL = os.ExpandEnv(m.BaseURL)
+	if u, err := url.Parse(m.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("base_url must be an http(s) URL, got %q", m.BaseURL)
+	}
+	m.BaseURL = strings.TrimSuffix(m.BaseURL, "/")
+	for name, value := range m.Headers {
+		m.Headers[name] = os.ExpandEnv(value)
+	}
+	m.timeout = defaultTimeout
+	if m.Timeout != "" {
+		d, err := time.ParseDuration(m.Timeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", m.Timeout)
+		}
+		m.timeout = d
+	}
+	if len(m.Resources) == 0 {
+		return fmt.Errorf("no resources")
+	}
+
+	paths := make(map[string]bool)
+	for _, r := range m.Resources {
+		if r == nil {
+			return fmt.Errorf("empty resource")
+		}
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("resource %s: %w", r.Path, err)
+		}
+		if paths[r.Path] {
+			return fmt.Errorf("resource %s is mapped twice", r.Path)
+		}
+		paths[r.Path] = true
+	}
+	for _, r := range m.Resources {
+		if r.List != nil && m.entryResource(r) == nil {
+			return fmt.Errorf("resource %s: listed entries need a resource %s/{name}", r.Path, r.Path)
+		}
+	}
+	return nil
+}
+
+func (r *Resource) validate() error {
+	if !strings.HasPrefix(r.Path, "/") || r.Path == "/" {
+		return fmt.Errorf("path must be absolute and below /")
+	}
+	r.Path = strings.TrimSuffix(r.Path, "/")
+	if r.Path == "/"+readmeFile || r.Path == "/"+cacheDir || strings.HasPrefix(r.Path, "/"+cacheDir+"/") {
+		return fmt.Errorf("%s is reserved", r.Path)
+	}
+
+	vars := make(map[string]bool)
+	for _, elem := range strings.Split(r.Path[1:], "/") {
+		seg, err := parseSegment(elem)
+		if err != nil {
+			return err
+		}
+		if seg.literal == moreDir {
+			return fmt.Errorf("%s is reserved", moreDir)
+		}
+		if seg.variable != "" {
+			if vars[seg.variable] || seg.variable == contentPlaceholder || seg.variable == contentJSONPlaceholder {
+				return fmt.Errorf("variable {%s} is used twice or reserved", seg.variable)
+			}
+			vars[seg.variable] = true
+		}
+		r.segments = append(r.segments, seg)
+	}
+
+	switch {
+	case r.List == nil && r.Read == nil && r.Write == nil && r.Create == nil:
+		return fmt.Errorf("needs a list, read, write or create operation")
+	case r.List != nil && (r.Read != nil || r.Write != nil || r.Create != nil):
+		return fmt.Errorf("a listed directory cannot be read or written as a file")
+	}
+	for _, op := range []struct {
+		name   string
+		op     *Operation
+		method string
+		write  bool
+	}{
+		{"list", r.List, "GET", false},
+		{"read", r.Read, "GET", false},
+		{"write", r.Write, "PUT", true},
+		{"create", r.Create, "POST", true},
+		{"delete", r.Delete, "DELETE", false},
+	} {
+		if op.op == nil {
+			continue
+		}
+		if err := op.op.validate(r.Path, op.method, op.write, vars); err != nil {
+			return fmt.Errorf("%s: %w", op.name, err)
+		}
+		if op.op.Paginate != nil && op.op != r.List {
+			return fmt.Errorf("%s: only lists are paginated", op.name)
+		}
+	}
+	if r.List != nil && r.List.Paginate != nil {
+		if err := r.List.Paginate.validate(); err != nil {
+			return fmt.Errorf("list: paginate: %w", err)
+		}
+	}
+	return nil
+}
+
+func (pg *Pagination) validate() error {
+	switch {
+	case (pg.Next == "") == (pg.Cursor == ""):
+		return fmt.Errorf("needs either next or cursor")
+	case pg.Cursor != "" && pg.CursorParam == "":
+		return fmt.Errorf("cursor needs a cursor_param")
+	case pg.MaxPages < 0:
+		return fmt.Errorf("max_pages must be positive")
+	case pg.MaxPages == 0:
+		pg.MaxPages = defaultMaxPages
+	}
+	return nil
+}
+
+// nextPage returns the continuation token in a page, a next URL or a
+// cursor, and the URL of the next page; "" if it is the last page
+func (pg *Pagination) nextPage(doc interface{}, pageURL string) (token, next string) {
+	path := pg.Next
+	if path == "" {
+		path = pg.Cursor
+	}
+	switch value, _ := lookup(doc, path); value := value.(type) {
+	case string:
+		token = value
+	case json.Number:
+		token = value.String()
+	}
+	if token == "" {
+		return "", ""
+	}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return "", ""
+	}
+	if pg.Next != "" {
+		ref, err := url.Parse(token)
+		if err != nil {
+			return "", ""
+		}
+		return token, base.ResolveReference(ref).String()
+	}
+	query := base.Query()
+	query.Set(pg.CursorParam, token)
+	base.RawQuery = query.Encode()
+	return token, base.String()
+}
+
+func (op *Operation) validate(path, method string, write bool, vars map[string]bool) error {
+	if op.Method == "" {
+		op.Method = method
+	}
+	op.Method = strings.ToUpper(op.Method)
+	if op.URL == "" {
+		op.URL = path
+	}
+	if write && op.Body == "" {
+		op.Body = "{" + contentPlaceholder + "}"
+	}
+	op.cacheTTL = -1
+	if op.CacheTTL != "" {
+		d, err := time.ParseDuration(op.CacheTTL)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid cache_ttl %q", op.CacheTTL)
+		}
+		op.cacheTTL = d
+	}
+
+	templates := []string{op.URL}
+	for _, value := range op.Query {
+		templates = append(templates, value)
+	}
+	if err := checkPlaceholders(templates, vars); err != nil {
+		return err
+	}
+	if write {
+		body := make(map[string]bool, len(vars)+2)
+		for name := range vars {
+			body[name] = true
+		}
+		body[contentPlaceholder], body[contentJSONPlaceholder] = true, true
+		return checkPlaceholders([]string{op.Body}, body)
+	}
+	return checkPlaceholders([]string{op.Body}, vars)
+}
+
+// checkPlaceholders rejects placeholders that name no variable
+func checkPlaceholders(templates []string, vars map[string]bool) error {
+	for _, template := range templates {
+		for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+			if !vars[match[1]] {
+				return fmt.Errorf("unknown variable {%s}", match[1])
+			}
+		}
+	}
+	return nil
+}
+
+// parseSegment parses one element of a path template
+func parseSegment(elem string) (segment, error) {
+	open := strings.IndexByte(elem, '{')
+	if open < 0 {
+		if elem == "" || strings.ContainsRune(elem, '}') {
+			return segment{}, fmt.Errorf("invalid path element %q", elem)
+		}
+		return segment{literal: elem}, nil
+	}
+	close := strings.IndexByte(elem, '}')
+	if close < open || !placeholderPattern.MatchString(elem[open:close+1]) || strings.ContainsAny(elem[close+1:], "{}") {
+		return segment{}, fmt.Errorf("invalid path element %q: one {variable} per element", elem)
+	}
+	return segment{variable: elem[open+1 : close], prefix: elem[:open], suffix: elem[close+1:]}, nil
+}
+
+// match binds the segment to a path element
+func (s segment) match(elem string, vars map[string]string) bool {
+	if s.variable == "" {
+		return elem == s.literal
+	}
+	if len(elem) <= len(s.prefix)+len(s.suffix) || !strings.HasPrefix(elem, s.prefix) || !strings.HasSuffix(elem, s.suffix) {
+		return false
+	}
+	vars[s.variable] = elem[len(s.prefix) : len(elem)-len(s.suffix)]
+	return true
+}
+
+// name returns the path element of the segment for a variable value
+func (s segment) name(value string) string {
+	if s.variable == "" {
+		return s.literal
+	}
+	return s.prefix + value + s.suffix
+}
+
+// weight ranks the segment when several match: literals first, then the
+// variables with the longest fixed parts
+func (s segment) weight() int {
+	if s.variable == "" {
+		return 1 << 16
+	}
+	return len(s.prefix) + len(s.suffix)
+}
+
+// entryResource returns the resource naming the entries of a listed
+// directory: the one whose template is that of r plus a variable element
+func (m *Mapping) entryResource(r *Resource) *Resource {
+	for _, child := range m.Resources {
+		if len(child.segments) == len(r.segments)+1 && child.segments[len(r.segments)].variable != "" &&
+			strings.HasPrefix(child.Path, r.Path+"/") {
+			return child
+		}
+	}
+	return nil
+}
+
+// node is a path of the tree: a resource with its variables bound, or a
+// directory implied by longer templates
+type node struct {
+	path     string
+	resource *Resource // nil for implied directories
+	vars     map[string]string
+	chunk    int // For the .more directories of a paged list, how deep
+}
+
+func (n *node) isDir() bool {
+	return n.resource == nil || n.resource.List != nil
+}
+
+// resolve returns the node of a path, or nil if no template covers it. Of
+// the templates matching a path, the most literal one wins.
+func (m *Mapping) resolve(path string) *node {
+	elems := splitPath(path)
+	if len(elems) == 0 {
+		return &node{path: "/"}
+	}
+
+	var best *node
+	bestWeight, implied := -1, false
+	for _, r := range m.Resources {
+		if len(r.segments) < len(elems) {
+			continue
+		}
+		vars := make(map[string]string)
+		weight, ok := 0, true
+		for i, elem := range elems {
+			if !r.segments[i].match(elem, vars) {
+				ok = false
+				break
+			}
+			weight += r.segments[i].weight()
+		}
+		if !ok {
+			continue
+		}
+		if len(r.segments) > len(elems) {
+			implied = true
+			continue
+		}
+		if weight > bestWeight {
+			best, bestWeight = &node{path: path, resource: r, vars: vars}, weight
+		}
+	}
+	if best == nil && implied {
+		return &node{path: path}
+	}
+	return best
+}
+
+// unchunk strips the .more directories from a path, which name the same
+// entries as the listed directory they are in. It returns the path
+// without them, and how many there are if they end the path; ok is false
+// if they are not right below a paged list.
+func (m *Mapping) unchunk(path string) (clean string, chunk int, ok bool) {
+	elems := splitPath(path)
+	first := -1
+	for i, elem := range elems {
+		if elem != moreDir {
+			continue
+		}
+		if first < 0 {
+			first = i
+		} else if elems[i-1] != moreDir {
+			return "", 0, false
+		}
+		chunk++
+	}
+	if first < 0 {
+		return path, 0, true
+	}
+	dir := m.resolve("/" + strings.Join(elems[:first], "/"))
+	if dir == nil || dir.resource == nil || dir.resource.List == nil || dir.resource.List.Paginate == nil {
+		return "", 0, false
+	}
+	rest := elems[first+chunk:]
+	if len(rest) > 0 {
+		chunk = 0
+	}
+	return "/" + strings.Join(append(elems[:first:first], rest...), "/"), chunk, true
+}
+
+// staticChildren returns the literal entries of a directory implied by
+// longer templates, with whether each is a directory
+func (m *Mapping) staticChildren(path string) map[string]bool {
+	elems := splitPath(path)
+	children := make(map[string]bool)
+	for _, r := range m.Resources {
+		if len(r.segments) <= len(elems) {
+			continue
+		}
+		vars := make(map[string]string)
+		ok := true
+		for i, elem := range elems {
+			if !r.segments[i].match(elem, vars) {
+				ok = false
+				break
+			}
+		}
+		next := r.segments[len(elems)]
+		if !ok || next.variable != "" {
+			continue
+		}
+		children[next.literal] = children[next.literal] || len(r.segments) > len(elems)+1 || r.List != nil
+	}
+	return children
+}
+
+// render fills in the placeholders of a template. escape is applied to
+// variable values, e.g. to path-escape them in URLs.
+func render(template string, vars map[string]string, escape func(string) string) string {
+	return placeholderPattern.ReplaceAllStringFunc(template, func(match string) string {
+		value, ok := vars[match[1:len(match)-1]]
+		if !ok {
+			return match
+		}
+		if escape != nil {
+			return escape(value)
+		}
+		return value
+	})
+}
+
+// requestURL returns the URL of an operation on a node
+func (m *Mapping) requestURL(op *Operation, vars map[string]string) string {
+	u := render(op.URL, vars, url.PathEscape)
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		u = m.BaseURL + "/" + strings.TrimPrefix(u, "/")
+	}
+	if len(op.Query) > 0 {
+		query := url.Values{}
+		for name, value := range op.Query {
+			query.Set(name, render(value, vars, nil))
+		}
+		sep := "?"
+		if strings.Contains(u, "?") {
+			sep = "&"
+		}
+		u += sep + query.Encode()
+	}
+	return u
+}
+
+// requestBody returns the body of a write of data
+func requestBody(op *Operation, vars map[string]string, data []byte) []byte {
+	values := make(map[string]string, len(vars)+2)
+	for name, value := range vars {
+		values[name] = value
+	}
+	values[contentPlaceholder] = string(data)
+	quoted, _ := json.Marshal(strings.TrimSuffix(string(data), "\n"))
+	values[contentJSONPlaceholder] = string(quoted)
+	return []byte(render(op.Body, values, nil))
+}
+
+// lookup returns the value at a dotted JSON path, e.g. "data.items" or
+// "results.0"; an empty path is the whole document
+func lookup(doc interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return doc, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if doc, ok = v[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// decodeJSON decodes a response, keeping numbers as written
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("response is not JSON: %w", err)
+	}
+	return doc, nil
+}
+
+// content returns the file content of a value read: strings as they are,
+// anything else as indented JSON
+func content(value interface{}) []byte {
+	if s, ok := value.(string); ok {
+		return []byte(s)
+	}
+	data, _ := json.MarshalIndent(value, "", "  ")
+	return append(data, '\n')
+}
+
+// itemNames returns the names of the items of a list: the value at
+// namePath in each item, or the items themselves if they are scalars. The
+// keys of an object are its names.
+func itemNames(items interface{}, namePath string) ([]string, error) {
+	var names []string
+	switch v := items.(type) {
+	case map[string]interface{}:
+		for key := range v {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		return names, nil
+	case []interface{}:
+		for _, item := range v {
+			value, ok := lookup(item, namePath)
+			if !ok {
+				return nil, fmt.Errorf("item has no %q", namePath)
+			}
+			switch value := value.(type) {
+			case string:
+				names = append(names, value)
+			case json.Number:
+				names = append(names, value.String())
+			default:
+				return nil, fmt.Errorf("name of an item must be a string or number, got %T", value)
+			}
+		}
+		return names, nil
+	default:
+		return nil, fmt.Errorf("list result is not an array or object")
+	}
+}
+
+// splitPath returns the elements of a path
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,880 @@
+package restfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	PluginName = "restfs"
+
+	readmeFile = "README"
+)
+
+// maxResponseBytes bounds the responses read from the API
+const maxResponseBytes = 32 << 20
+
+// RESTFSPlugin mounts a REST API as a file tree described by a Mapping:
+// collections are directories, the items in them files, and reads, writes
+// and removals call the API operations mapped to them.
+type RESTFSPlugin struct {
+	mapping *Mapping
+	client  *http.Client
+	cache   *responseCache // nil when disabled
+
+	mu     sync.Mutex
+	chunks map[string]*continuation // "path#chunk" -> where the chunk of a paged list starts
+}
+
+// continuation is where a paged list resumes past the pages listed
+type continuation struct {
+	token string // The next URL or cursor, as the API gave it
+	url   string
+}
+
+// NewRESTFSPlugin creates a new RESTFS plugin
+func NewRESTFSPlugin() *RESTFSPlugin {
+	return &RESTFSPlugin{chunks: make(map[string]*continuation)}
+}
+
+func (p *RESTFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *RESTFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"mapping", "mapping_file", "base_url", "cache", "cache_ttl", "cache_max_entries", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	for _, key := range []string{"mapping_file", "base_url", "cache_ttl"} {
+		if err := config.ValidateStringType(cfg, key); err != nil {
+			return err
+		}
+	}
+	if err := config.ValidateBoolType(cfg, "cache"); err != nil {
+		return err
+	}
+	if err := config.ValidateIntType(cfg, "cache_max_entries"); err != nil {
+		return err
+	}
+	if _, err := newCache(cfg); err != nil {
+		return err
+	}
+	_, err := loadMapping(cfg)
+	return err
+}
+
+// newCache creates the response cache of a mount, or returns nil if it is
+// disabled
+func newCache(cfg map[string]interface{}) (*responseCache, error) {
+	if !config.GetBoolConfig(cfg, "cache", true) {
+		return nil, nil
+	}
+	ttl := defaultCacheTTL
+	if s := config.GetStringConfig(cfg, "cache_ttl", ""); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid cache_ttl %q", s)
+		}
+		ttl = d
+	}
+	maxEntries := config.GetIntConfig(cfg, "cache_max_entries", defaultCacheMaxEntries)
+	if maxEntries <= 0 {
+		return nil, fmt.Errorf("cache_max_entries must be positive")
+	}
+	return newResponseCache(ttl, maxEntries), nil
+}
+
+func (p *RESTFSPlugin) Initialize(cfg map[string]interface{}) error {
+	m, err := loadMapping(cfg)
+	if err != nil {
+		return err
+	}
+	if p.cache, err = newCache(cfg); err != nil {
+		return err
+	}
+	p.mapping = m
+	p.client = &http.Client{Timeout: m.timeout}
+	log.Infof("[restfs] Mapped %s with %d resources", m.BaseURL, len(m.Resources))
+	return nil
+}
+
+// loadMapping reads the mapping of a mount: inline under "mapping", as
+// YAML text or a nested object, or from the file at "mapping_file". A
+// "base_url" in the configuration overrides that of the mapping, so one
+// mapping can serve several deployments of an API.
+func loadMapping(cfg map[string]interface{}) (*Mapping, error) {
+	var data []byte
+	inline, hasInline := cfg["mapping"]
+	file := config.GetStringConfig(cfg, "mapping_file", "")
+	switch {
+	case hasInline && file != "":
+		return nil, fmt.Errorf("set either mapping or mapping_file, not both")
+	case file != "":
+		var err error
+		if data, err = os.ReadFile(file); err != nil {
+			return nil, fmt.Errorf("failed to read mapping_file: %w", err)
+		}
+	case hasInline:
+		if s, ok := inline.(string); ok {
+			data = []byte(s)
+		} else {
+			var err error
+			if data, err = yaml.Marshal(inline); err != nil {
+				return nil, fmt.Errorf("invalid mapping: %w", err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("mapping or mapping_file is required")
+	}
+
+	if baseURL := config.GetStringConfig(cfg, "base_url", ""); baseURL != "" {
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid mapping: %w", err)
+		}
+		if doc == nil {
+			doc = make(map[string]interface{})
+		}
+		doc["base_url"] = baseURL
+		data, _ = yaml.Marshal(doc)
+	}
+	return ParseMapping(data)
+}
+
+func (p *RESTFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return &restFS{plugin: p}
+}
+
+func (p *RESTFSPlugin) GetReadme() string {
+	return `RESTFS Plugin - REST APIs as Files
+
+This plugin mounts a REST API as a file tree, following a mapping that
+describes path templates, the API calls behind them, and where the data is
+in the JSON responses. Listing a collection directory lists the API's
+items; reading, writing and removing a file call the API.
+
+MAPPING:
+  base_url: https://crm.example.com/api
+  headers:
+    Authorization: "Bearer ${CRM_TOKEN}"
+  resources:
+    - path: /contacts                  # Directory: the listed items
+      list: {url: /contacts, result: data, name: id}
+    - path: /contacts/{id}.json        # File: one item
+      read: {url: "/contacts/{id}", result: data}
+      write: {method: PUT, url: "/contacts/{id}", body: '{"data": {content}}'}
+      create: {url: /contacts, body: '{"data": {content}}'}
+      delete: {url: "/contacts/{id}"}
+
+  Templates take {var} from the path; bodies also {content}, the data
+  written, and {content_json}, the same as a JSON string. result and name
+  are dotted JSON paths such as "data.items" or "results.0".
+
+  Paged lists take paginate: {next: links.next} or paginate: {cursor:
+  meta.after, cursor_param: after}, and max_pages (default 10). Pages past
+  max_pages are listed in the directory's .more subdirectory.
+
+USAGE:
+  ls /crm/contacts
+  cat /crm/contacts/42.json
+  echo '{"name": "Ada"}' > /crm/contacts/42.json   # write, or create if new
+  rm /crm/contacts/42.json
+
+CACHE:
+  Lists and reads are cached for as long as the API's Cache-Control or
+  Expires headers allow, or cache_ttl (default 30s), then revalidated with
+  If-None-Match / If-Modified-Since. Writes drop what they change.
+  echo > /crm/.cache/flush             # Drop everything
+  echo /contacts > /crm/.cache/flush   # Drop what was read below /contacts
+  cat /crm/.cache/stats                # Hits, misses, revalidations
+
+VERSION: 1.0.0
+`
+}
+
+func (p *RESTFSPlugin) GetConfigParams() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{
+			Name:        "mapping",
+			Type:        "string",
+			Required:    false,
+			Default:     "",
+			Description: "Mapping of the API, as YAML text or an object",
+		},
+		{
+			Name:        "mapping_file",
+			Type:        "string",
+			Required:    false,
+			Default:     "",
+			Description: "Path of a YAML mapping file, instead of mapping",
+		},
+		{
+			Name:        "base_url",
+			Type:        "string",
+			Required:    false,
+			Default:     "",
+			Description: "Overrides the base_url of the mapping",
+		},
+		{
+			Name:        "cache",
+			Type:        "bool",
+			Required:    false,
+			Default:     "true",
+			Description: "Cache list and read responses",
+		},
+		{
+			Name:        "cache_ttl",
+			Type:        "string",
+			Required:    false,
+			Default:     "30s",
+			Description: "How long responses stay fresh when the API does not say",
+		},
+		{
+			Name:        "cache_max_entries",
+			Type:        "int",
+			Required:    false,
+			Default:     "1000",
+			Description: "Most responses kept, least recently used dropped first",
+		},
+	}
+}
+
+func (p *RESTFSPlugin) Shutdown() error {
+	if p.client != nil {
+		p.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// call sends an API request for path and returns the response body. Error
+// statuses map to the file system errors closest to them. GET requests go
+// through the response cache; other requests invalidate what they change.
+func (p *RESTFSPlugin) call(op *Operation, n *node, body []byte) ([]byte, error) {
+	return p.callURL(op, n, p.mapping.requestURL(op, n.vars), body)
+}
+
+// callURL is call with the URL given, e.g. that of a page of a list
+func (p *RESTFSPlugin) callURL(op *Operation, n *node, u string, body []byte) ([]byte, error) {
+	if p.cache == nil {
+		_, data, err := p.send(op.Method, u, n.path, body, nil)
+		return data, err
+	}
+	if op.Method != http.MethodGet {
+		_, data, err := p.send(op.Method, u, n.path, body, nil)
+		p.invalidate(n, u)
+		return data, err
+	}
+
+	ttl := op.cacheTTL
+	if ttl < 0 {
+		ttl = p.cache.ttl
+	}
+	entry, fresh := p.cache.get(u)
+	if fresh {
+		p.cache.count(&p.cache.stats.Hits)
+		return entry.body, nil
+	}
+	header := make(http.Header)
+	if entry != nil {
+		entry.conditional(header)
+	}
+	resp, data, err := p.send(op.Method, u, n.path, nil, header)
+	switch {
+	case err != nil && entry != nil && (errors.Is(err, filesystem.ErrRateLimited) || errors.Is(err, filesystem.ErrBackendUnavailable)):
+		// A stale response beats none while the API is throttling or down
+		log.Warnf("[restfs] Serving a stale response for %s: %v", n.path, err)
+		p.cache.count(&p.cache.stats.Stale)
+		return entry.body, nil
+	case err != nil:
+		return nil, err
+	case resp.StatusCode == http.StatusNotModified && entry != nil:
+		p.cache.revalidate(entry, resp, ttl)
+		return entry.body, nil
+	}
+	p.cache.count(&p.cache.stats.Misses)
+	p.cache.put(u, n.path, resp, data, ttl)
+	return data, nil
+}
+
+// invalidate drops the cached responses a change to n may have made
+// stale: those of n and below it, those of the URLs it was read and
+// changed at, and the listing of its directory
+func (p *RESTFSPlugin) invalidate(n *node, url string) {
+	p.cache.remove(url)
+	if n.resource != nil && n.resource.Read != nil {
+		p.cache.remove(p.mapping.requestURL(n.resource.Read, n.vars))
+	}
+	p.cache.flush(n.path, false)
+	if elems := splitPath(n.path); len(elems) > 0 {
+		p.cache.flush("/"+strings.Join(elems[:len(elems)-1], "/"), true)
+	}
+}
+
+// send sends an API request, mapping error statuses to file system errors.
+// A 304 response to a conditional request is not an error.
+func (p *RESTFSPlugin) send(method, url, path string, body []byte, header http.Header) (*http.Response, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, nil, filesystem.NewInvalidArgumentError("url", url, err.Error())
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range p.mapping.Headers {
+		req.Header.Set(name, value)
+	}
+
+	op := strings.ToLower(method)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		var timeout interface{ Timeout() bool }
+		if errors.As(err, &timeout) && timeout.Timeout() {
+			return nil, nil, filesystem.NewTimeoutError(op, path, p.mapping.timeout)
+		}
+		return nil, nil, filesystem.NewBackendUnavailableError(path, 0, err.Error())
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, nil, filesystem.NewBackendUnavailableError(path, 0, err.Error())
+	}
+	log.Debugf("[restfs] %s %s: %d", req.Method, req.URL, resp.StatusCode)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300, resp.StatusCode == http.StatusNotModified && len(header) > 0:
+		return resp, data, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, nil, filesystem.NewNotFoundError(op, path)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, nil, filesystem.NewPermissionDeniedError(op, path, apiError(resp, data))
+	case resp.StatusCode == http.StatusConflict:
+		return nil, nil, filesystem.NewAlreadyExistsError("resource", path)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, nil, filesystem.NewRateLimitedError(path, retryAfter(resp))
+	case resp.StatusCode >= 500:
+		return nil, nil, filesystem.NewBackendUnavailableError(path, retryAfter(resp), apiError(resp, data))
+	default:
+		return nil, nil, filesystem.NewInvalidArgumentError("request", path, apiError(resp, data))
+	}
+}
+
+// apiError describes an error response
+func apiError(resp *http.Response, body []byte) string {
+	msg := strings.TrimSpace(string(body))
+	if len(msg) > 200 {
+		msg = msg[:200] + "..."
+	}
+	if msg == "" {
+		return resp.Status
+	}
+	return resp.Status + ": " + msg
+}
+
+// retryAfter returns the delay of a Retry-After header given in seconds
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// read returns the content of a file node
+func (p *RESTFSPlugin) read(n *node) ([]byte, error) {
+	op := n.resource.Read
+	if op == nil {
+		return nil, filesystem.NewPermissionDeniedError("read", n.path, "write-only")
+	}
+	data, err := p.call(op, n, nil)
+	if err != nil {
+		return nil, err
+	}
+	if op.Result == "" && !isJSON(data) {
+		return data, nil
+	}
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, filesystem.NewBackendUnavailableError(n.path, 0, err.Error())
+	}
+	value, ok := lookup(doc, op.Result)
+	if !ok || value == nil {
+		return nil, filesystem.NewNotFoundError("read", n.path)
+	}
+	return content(value), nil
+}
+
+// isJSON reports whether a response looks like a JSON document, so that
+// reads without a result path return other content as it is
+func isJSON(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && (data[0] == '{' || data[0] == '[')
+}
+
+// list returns the entry names of a listed directory node. A paged list
+// is walked max_pages at a time, starting at the chunk of the node; if
+// pages are left, it also returns where they continue.
+func (p *RESTFSPlugin) list(n *node) ([]string, *continuation, error) {
+	op := n.resource.List
+	u := p.mapping.requestURL(op, n.vars)
+	if n.chunk > 0 {
+		start, err := p.chunkStart(n)
+		if err != nil {
+			return nil, nil, err
+		}
+		u = start.url
+	}
+
+	entry := p.mapping.entryResource(n.resource).segments[len(n.resource.segments)]
+	var entries []string
+	for page := 1; ; page++ {
+		data, err := p.callURL(op, n, u, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		doc, err := decodeJSON(data)
+		if err != nil {
+			return nil, nil, filesystem.NewBackendUnavailableError(n.path, 0, err.Error())
+		}
+		items, ok := lookup(doc, op.Result)
+		if !ok {
+			return nil, nil, filesystem.NewBackendUnavailableError(n.path, 0, fmt.Sprintf("response has no %q", op.Result))
+		}
+		names, err := itemNames(items, op.Name)
+		if err != nil {
+			return nil, nil, filesystem.NewBackendUnavailableError(n.path, 0, err.Error())
+		}
+		for _, name := range names {
+			if name == "" || name == moreDir || strings.Contains(name, "/") {
+				log.Warnf("[restfs] Skipping item %q of %s: not a valid file name", name, n.path)
+				continue
+			}
+			entries = append(entries, entry.name(name))
+		}
+
+		if op.Paginate == nil {
+			return entries, nil, nil
+		}
+		token, next := op.Paginate.nextPage(doc, u)
+		if next == "" {
+			return entries, nil, nil
+		}
+		if page == op.Paginate.MaxPages {
+			more := &continuation{token: token, url: next}
+			p.mu.Lock()
+			p.chunks[chunkKey(n.path, n.chunk+1)] = more
+			p.mu.Unlock()
+			return entries, more, nil
+		}
+		u = next
+	}
+}
+
+// chunkStart returns where a chunk of a paged list starts, walking the
+// chunks before it if they were not listed yet
+func (p *RESTFSPlugin) chunkStart(n *node) (*continuation, error) {
+	p.mu.Lock()
+	start := p.chunks[chunkKey(n.path, n.chunk)]
+	p.mu.Unlock()
+	if start != nil {
+		return start, nil
+	}
+	prev := *n
+	prev.chunk--
+	_, start, err := p.list(&prev)
+	if err != nil {
+		return nil, err
+	}
+	if start == nil {
+		return nil, filesystem.NewNotFoundError("readdir", n.path+"/"+moreDir)
+	}
+	return start, nil
+}
+
+func chunkKey(path string, chunk int) string {
+	return path + "#" + strconv.Itoa(chunk)
+}
+
+// write stores data at a file node, with the create operation if the file
+// does not exist yet, or exclusive is set
+func (p *RESTFSPlugin) write(n *node, data []byte, exclusive bool) error {
+	r := n.resource
+	op := r.Write
+	if r.Create != nil && (op == nil || exclusive) {
+		op = r.Create
+	} else if r.Create != nil && r.Read != nil {
+		if _, err := p.read(n); errors.Is(err, filesystem.ErrNotFound) {
+			op = r.Create
+		} else if err != nil {
+			return err
+		}
+	}
+	_, err := p.call(op, n, requestBody(op, n.vars, data))
+	return err
+}
+
+// restFS exposes the mapped API as a file system
+type restFS struct {
+	plugin *RESTFSPlugin
+}
+
+// resolve returns the node of a path, or a not found error. The .more
+// directories of a paged list resolve to the list, a chunk further on.
+func (fs *restFS) resolve(op, path string) (*node, error) {
+	path = filesystem.NormalizePath(path)
+	clean, chunk, ok := fs.plugin.mapping.unchunk(path)
+	if !ok {
+		return nil, filesystem.NewNotFoundError(op, path)
+	}
+	n := fs.plugin.mapping.resolve(clean)
+	if n == nil {
+		return nil, filesystem.NewNotFoundError(op, path)
+	}
+	n.chunk = chunk
+	return n, nil
+}
+
+// moreInfo describes the .more directory of a listing, with the token it
+// continues from
+func moreInfo(path string, more *continuation, now time.Time) *filesystem.FileInfo {
+	info := dirInfo(path, now)
+	info.Meta.Type = "more"
+	info.Meta.Content = map[string]string{"next": more.token}
+	return info
+}
+
+// cacheFile returns the control file of the cache a path names, "" for
+// the /.cache directory itself
+func (fs *restFS) cacheFile(path string) (string, bool) {
+	elems := splitPath(filesystem.NormalizePath(path))
+	if fs.plugin.cache == nil || len(elems) == 0 || elems[0] != cacheDir {
+		return "", false
+	}
+	if len(elems) == 1 {
+		return "", true
+	}
+	return strings.Join(elems[1:], "/"), true
+}
+
+// cacheInfo describes /.cache or one of its control files
+func (fs *restFS) cacheInfo(file string, now time.Time) (*filesystem.FileInfo, error) {
+	switch file {
+	case "":
+		return &filesystem.FileInfo{Name: cacheDir, Mode: 0755, ModTime: now, IsDir: true,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "directory"}}, nil
+	case cacheFlushFile:
+		return &filesystem.FileInfo{Name: file, Mode: 0222, ModTime: now,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "control"}}, nil
+	case cacheStatsFile:
+		return &filesystem.FileInfo{Name: file, Size: int64(len(fs.plugin.cache.statsData())), Mode: 0444, ModTime: now,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "control"}}, nil
+	default:
+		return nil, filesystem.NewNotFoundError("stat", "/"+cacheDir+"/"+file)
+	}
+}
+
+// flushCache handles a write to /.cache/flush: the path written, or
+// nothing for the whole tree, names what to drop
+func (fs *restFS) flushCache(data []byte) error {
+	prefix := strings.TrimSpace(string(data))
+	if prefix == "" {
+		prefix = "/"
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		return filesystem.NewInvalidArgumentError("path", prefix, "must be absolute")
+	}
+	prefix = filesystem.NormalizePath(prefix)
+	dropped := fs.plugin.cache.flush(prefix, false)
+	log.Infof("[restfs] Flushed %d cached responses under %s", dropped, prefix)
+	return nil
+}
+
+func (fs *restFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	if filesystem.NormalizePath(path) == "/"+readmeFile {
+		return plugin.ApplyRangeRead([]byte(fs.plugin.GetReadme()), offset, size)
+	}
+	if file, ok := fs.cacheFile(path); ok {
+		switch file {
+		case cacheStatsFile:
+			return plugin.ApplyRangeRead(fs.plugin.cache.statsData(), offset, size)
+		case cacheFlushFile:
+			return nil, filesystem.NewPermissionDeniedError("read", path, "write-only")
+		case "":
+			return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+		}
+		return nil, filesystem.NewNotFoundError("read", path)
+	}
+	n, err := fs.resolve("read", path)
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir() {
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+	data, err := fs.plugin.read(n)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+func (fs *restFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	if file, ok := fs.cacheFile(path); ok {
+		if file != cacheFlushFile {
+			return 0, filesystem.NewPermissionDeniedError("write", path, "write to /"+cacheDir+"/"+cacheFlushFile)
+		}
+		if err := fs.flushCache(data); err != nil {
+			return 0, err
+		}
+		return int64(len(data)), nil
+	}
+	n, err := fs.resolve("write", path)
+	if err != nil {
+		return 0, err
+	}
+	if n.isDir() || (n.resource.Write == nil && n.resource.Create == nil) {
+		return 0, filesystem.NewPermissionDeniedError("write", path, "no write operation is mapped")
+	}
+	if offset > 0 || flags&filesystem.WriteFlagAppend != 0 {
+		return 0, filesystem.NewNotSupportedError("partial write", path)
+	}
+	if err := fs.plugin.write(n, data, flags&filesystem.WriteFlagExclusive != 0); err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+func (fs *restFS) Stat(path string) (*filesystem.FileInfo, error) {
+	now := time.Now()
+	if filesystem.NormalizePath(path) == "/"+readmeFile {
+		return &filesystem.FileInfo{Name: readmeFile, Size: int64(len(fs.plugin.GetReadme())), Mode: 0444, ModTime: now,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "doc"}}, nil
+	}
+	if file, ok := fs.cacheFile(path); ok {
+		return fs.cacheInfo(file, now)
+	}
+	n, err := fs.resolve("stat", path)
+	if err != nil {
+		return nil, err
+	}
+	if n.chunk > 0 {
+		more, err := fs.plugin.chunkStart(n)
+		if err != nil {
+			return nil, err
+		}
+		return moreInfo(filesystem.NormalizePath(path), more, now), nil
+	}
+	if n.isDir() {
+		return dirInfo(n.path, now), nil
+	}
+	var size int64
+	if n.resource.Read != nil {
+		data, err := fs.plugin.read(n)
+		if err != nil {
+			return nil, err
+		}
+		size = int64(len(data))
+	}
+	return fileInfo(n, size, now), nil
+}
+
+func dirInfo(path string, now time.Time) *filesystem.FileInfo {
+	name := "/"
+	if elems := splitPath(path); len(elems) > 0 {
+		name = elems[len(elems)-1]
+	}
+	return &filesystem.FileInfo{Name: name, Mode: 0755, ModTime: now, IsDir: true,
+		Meta: filesystem.MetaData{Name: PluginName, Type: "directory"}}
+}
+
+func fileInfo(n *node, size int64, now time.Time) *filesystem.FileInfo {
+	elems := splitPath(n.path)
+	mode := uint32(0444)
+	switch {
+	case n.resource.Read == nil:
+		mode = 0222
+	case n.resource.Write != nil || n.resource.Create != nil:
+		mode = 0644
+	}
+	return &filesystem.FileInfo{Name: elems[len(elems)-1], Size: size, Mode: mode, ModTime: now,
+		Meta: filesystem.MetaData{Name: PluginName, Type: "resource"}}
+}
+
+// ReadDir lists the items of a collection, and the literal entries mapped
+// below the directory. Listed files are not read, so their size is 0
+// until they are stat'ed.
+func (fs *restFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	if file, ok := fs.cacheFile(path); ok {
+		if file != "" {
+			return nil, filesystem.NewNotDirectoryError(path)
+		}
+		now := time.Now()
+		flush, _ := fs.cacheInfo(cacheFlushFile, now)
+		stats, _ := fs.cacheInfo(cacheStatsFile, now)
+		return []filesystem.FileInfo{*flush, *stats}, nil
+	}
+	n, err := fs.resolve("readdir", path)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isDir() {
+		return nil, filesystem.NewNotDirectoryError(path)
+	}
+
+	now := time.Now()
+	var infos []filesystem.FileInfo
+	if n.path == "/" {
+		readme, _ := fs.Stat("/" + readmeFile)
+		infos = append(infos, *readme)
+		if fs.plugin.cache != nil {
+			cache, _ := fs.cacheInfo("", now)
+			infos = append(infos, *cache)
+		}
+	}
+	children := fs.plugin.mapping.staticChildren(n.path)
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if n.chunk > 0 {
+		names = nil // Static entries are in the first chunk
+	}
+	var more *continuation
+	if n.resource != nil && n.resource.List != nil {
+		var listed []string
+		listed, more, err = fs.plugin.list(n)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range listed {
+			if _, ok := children[name]; !ok {
+				names = append(names, name)
+			}
+		}
+	}
+
+	for _, name := range names {
+		child := fs.plugin.mapping.resolve(strings.TrimSuffix(n.path, "/") + "/" + name)
+		switch {
+		case child == nil:
+			continue
+		case child.isDir():
+			infos = append(infos, *dirInfo(child.path, now))
+		default:
+			infos = append(infos, *fileInfo(child, 0, now))
+		}
+	}
+	if more != nil {
+		infos = append(infos, *moreInfo(filesystem.NormalizePath(path)+"/"+moreDir, more, now))
+	}
+	return infos, nil
+}
+
+// Create accepts writable files so that shell redirection works; the file
+// is created by the write that follows
+func (fs *restFS) Create(path string) error {
+	if file, ok := fs.cacheFile(path); ok {
+		if file != cacheFlushFile {
+			return filesystem.NewPermissionDeniedError("create", path, "the cache only has flush and stats files")
+		}
+		return nil
+	}
+	n, err := fs.resolve("create", path)
+	if err != nil {
+		return err
+	}
+	if n.isDir() || (n.resource.Write == nil && n.resource.Create == nil) {
+		return filesystem.NewPermissionDeniedError("create", path, "no write operation is mapped")
+	}
+	return nil
+}
+
+func (fs *restFS) Mkdir(path string, perm uint32) error {
+	return filesystem.NewNotSupportedError("mkdir", path)
+}
+
+// Remove calls the delete operation of a file or directory
+func (fs *restFS) Remove(path string) error {
+	n, err := fs.resolve("remove", path)
+	if err != nil {
+		return err
+	}
+	if n.resource == nil || n.resource.Delete == nil || n.chunk > 0 {
+		return filesystem.NewPermissionDeniedError("remove", path, "no delete operation is mapped")
+	}
+	_, err = fs.plugin.call(n.resource.Delete, n, nil)
+	return err
+}
+
+func (fs *restFS) RemoveAll(path string) error {
+	return fs.Remove(path)
+}
+
+func (fs *restFS) Rename(oldPath, newPath string) error {
+	return filesystem.NewNotSupportedError("rename", oldPath)
+}
+
+func (fs *restFS) Chmod(path string, mode uint32) error {
+	return filesystem.NewNotSupportedError("chmod", path)
+}
+
+// Truncate is a no-op so that shell redirection works
+func (fs *restFS) Truncate(path string, size int64) error {
+	return nil
+}
+
+func (fs *restFS) Open(path string) (io.ReadCloser, error) {
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (fs *restFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return &restWriter{fs: fs, path: path}, nil
+}
+
+// restWriter buffers a file and writes it on Close
+type restWriter struct {
+	fs   *restFS
+	path string
+	buf  bytes.Buffer
+}
+
+func (w *restWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *restWriter) Close() error {
+	_, err := w.fs.Write(w.path, w.buf.Bytes(), -1, filesystem.WriteFlagNone)
+	return err
+}
+
+// Ensure RESTFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*RESTFSPlugin)(nil)
+var _ filesystem.FileSystem = (*restFS)(nil)
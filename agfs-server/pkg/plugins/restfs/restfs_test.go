@@ -0,0 +1,415 @@
+package restfs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+const crmMapping = `
+headers:
+  Authorization: "Bearer ${RESTFS_TEST_TOKEN}"
+resources:
+  - path: /contacts
+    list: {url: /contacts, result: data, name: id}
+  - path: /contacts/{id}.json
+    read: {url: "/contacts/{id}", result: data}
+    write: {url: "/contacts/{id}", body: '{"data": {content}}'}
+    create: {url: /contacts, body: '{"id": "{id}", "data": {content}}'}
+    delete: {url: "/contacts/{id}"}
+  - path: /contacts/{id}.name
+    read: {url: "/contacts/{id}", result: data.name}
+  - path: /tags/{tag}/notes
+    write: {method: POST, url: "/tags/{tag}/notes", query: {source: agfs}, body: '{"text": {content_json}}'}
+`
+
+// fakeCRM serves a small contacts API
+type fakeCRM struct {
+	mu       sync.Mutex
+	contacts map[string]map[string]interface{}
+	notes    []string
+}
+
+func (c *fakeCRM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	id := strings.TrimPrefix(r.URL.Path, "/api/contacts/")
+	switch {
+	case r.URL.Path == "/api/contacts" && r.Method == http.MethodGet:
+		var data []interface{}
+		for id := range c.contacts {
+			data = append(data, map[string]interface{}{"id": id})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	case r.URL.Path == "/api/contacts" && r.Method == http.MethodPost:
+		c.contacts[body["id"].(string)] = body["data"].(map[string]interface{})
+		w.WriteHeader(http.StatusCreated)
+	case r.URL.Path == "/api/tags/vip/notes" && r.Method == http.MethodPost && r.URL.Query().Get("source") == "agfs":
+		c.notes = append(c.notes, body["text"].(string))
+	case c.contacts[id] == nil:
+		http.NotFound(w, r)
+	case r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": c.contacts[id]})
+	case r.Method == http.MethodPut:
+		c.contacts[id] = body["data"].(map[string]interface{})
+	case r.Method == http.MethodDelete:
+		delete(c.contacts, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestFS(t *testing.T) (*restFS, *fakeCRM) {
+	t.Helper()
+	crm := &fakeCRM{contacts: map[string]map[string]interface{}{"1": {"name": "Ada"}}}
+	server := httptest.NewServer(crm)
+	t.Cleanup(server.Close)
+	t.Setenv("RESTFS_TEST_TOKEN", "secret")
+
+	cfg := map[string]interface{}{"mapping": crmMapping, "base_url": server.URL + "/api"}
+	p := NewRESTFSPlugin()
+	if err := p.Validate(cfg); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if err := p.Initialize(cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	return p.GetFileSystem().(*restFS), crm
+}
+
+func names(infos []filesystem.FileInfo) string {
+	var names []string
+	for _, info := range infos {
+		if info.IsDir {
+			names = append(names, info.Name+"/")
+		} else {
+			names = append(names, info.Name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+func TestRESTFS(t *testing.T) {
+	fs, crm := newTestFS(t)
+
+	root, err := fs.ReadDir("/")
+	if err != nil || names(root) != "README .cache/ contacts/ tags/" {
+		t.Fatalf("ReadDir(/) = %q, %v", names(root), err)
+	}
+	contacts, err := fs.ReadDir("/contacts")
+	if err != nil || names(contacts) != "1.json" {
+		t.Fatalf("ReadDir(/contacts) = %q, %v", names(contacts), err)
+	}
+
+	data, err := fs.Read("/contacts/1.json", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read error = %v", err)
+	}
+	if string(data) != "{\n  \"name\": \"Ada\"\n}\n" {
+		t.Errorf("Read = %q", data)
+	}
+	if data, _ := fs.Read("/contacts/1.name", 0, -1); string(data) != "Ada" {
+		t.Errorf("Read name = %q", data)
+	}
+	if info, err := fs.Stat("/contacts/1.json"); err != nil || info.IsDir || info.Size != 20 || info.Mode != 0644 {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+	if _, err := fs.Stat("/contacts/2.json"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Stat missing = %v, want not found", err)
+	}
+	if _, err := fs.Stat("/unmapped"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Stat unmapped = %v, want not found", err)
+	}
+
+	// Existing files are written with write, new ones with create
+	if _, err := fs.Write("/contacts/1.json", []byte(`{"name": "Ada Lovelace"}`), -1, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write error = %v", err)
+	}
+	if _, err := fs.Write("/contacts/2.json", []byte(`{"name": "Grace"}`+"\n"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write new error = %v", err)
+	}
+	if crm.contacts["1"]["name"] != "Ada Lovelace" || crm.contacts["2"]["name"] != "Grace" {
+		t.Errorf("contacts = %v", crm.contacts)
+	}
+	if contacts, _ := fs.ReadDir("/contacts"); len(contacts) != 2 {
+		t.Errorf("ReadDir after create = %q", names(contacts))
+	}
+	if _, err := fs.Write("/contacts/1.name", []byte("x"), -1, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Write read-only = %v, want permission denied", err)
+	}
+
+	// Write-only resources take their content as a JSON string
+	if _, err := fs.Write("/tags/vip/notes", []byte("call \"back\"\n"), -1, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write note error = %v", err)
+	}
+	if len(crm.notes) != 1 || crm.notes[0] != `call "back"` {
+		t.Errorf("notes = %q", crm.notes)
+	}
+	if info, err := fs.Stat("/tags/vip"); err != nil || !info.IsDir {
+		t.Errorf("Stat implied directory = %+v, %v", info, err)
+	}
+
+	if err := fs.Remove("/contacts/2.json"); err != nil {
+		t.Fatalf("Remove error = %v", err)
+	}
+	if _, ok := crm.contacts["2"]; ok {
+		t.Error("contact 2 was not deleted")
+	}
+	if err := fs.Remove("/contacts/1.name"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Remove without delete = %v, want permission denied", err)
+	}
+
+	fs.plugin.mapping.Headers["Authorization"] = "Bearer wrong"
+	if _, err := fs.Read("/contacts/1.json", 0, -1); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Read unauthorized = %v, want permission denied", err)
+	}
+}
+
+func TestParseMappingValidation(t *testing.T) {
+	for name, mapping := range map[string]string{
+		"no base url":       "resources: [{path: /a, read: {}}]",
+		"no resources":      "base_url: http://api",
+		"unknown field":     "base_url: http://api\nresources: [{path: /a, fetch: {}}]",
+		"no operation":      "base_url: http://api\nresources: [{path: /a}]",
+		"list and read":     "base_url: http://api\nresources: [{path: /a, list: {}, read: {}}, {path: '/a/{x}', read: {}}]",
+		"unnamed entries":   "base_url: http://api\nresources: [{path: /a, list: {}}]",
+		"unknown variable":  "base_url: http://api\nresources: [{path: '/a/{x}', read: {url: '/a/{y}'}}]",
+		"two variables":     "base_url: http://api\nresources: [{path: '/a/{x}-{y}', read: {}}]",
+		"duplicate path":    "base_url: http://api\nresources: [{path: /a, read: {}}, {path: /a/, read: {}}]",
+		"reserved variable": "base_url: http://api\nresources: [{path: '/a/{content}', read: {}}]",
+		"content in url":    "base_url: http://api\nresources: [{path: '/a/{x}', write: {url: '/a/{content}'}}]",
+		"reserved more":     "base_url: http://api\nresources: [{path: /a/.more, read: {}}]",
+		"next and cursor":   "base_url: http://api\nresources: [{path: /a, list: {paginate: {next: n, cursor: c, cursor_param: c}}}, {path: '/a/{x}', read: {}}]",
+		"no cursor param":   "base_url: http://api\nresources: [{path: /a, list: {paginate: {cursor: c}}}, {path: '/a/{x}', read: {}}]",
+		"paginated read":    "base_url: http://api\nresources: [{path: /a, read: {paginate: {next: n}}}]",
+	} {
+		if _, err := ParseMapping([]byte(mapping)); err == nil {
+			t.Errorf("ParseMapping(%s) succeeded", name)
+		}
+	}
+
+	m, err := ParseMapping([]byte("base_url: http://api/v1/\nresources: [{path: '/users/{id}/avatar.png', read: {}}, {path: '/users/me/avatar.png', read: {url: /me/avatar}}]"))
+	if err != nil {
+		t.Fatalf("ParseMapping() error = %v", err)
+	}
+	if n := m.resolve("/users/me/avatar.png"); n == nil || m.requestURL(n.resource.Read, n.vars) != "http://api/v1/me/avatar" {
+		t.Errorf("literal template did not win: %+v", n)
+	}
+	if n := m.resolve("/users/a b/avatar.png"); n == nil || m.requestURL(n.resource.Read, n.vars) != "http://api/v1/users/a%20b/avatar.png" {
+		t.Errorf("variable not escaped: %+v", n)
+	}
+}
+
+// fakeDocs serves documents with validators and counts the requests
+type fakeDocs struct {
+	mu           sync.Mutex
+	docs         map[string]string
+	cacheControl string
+	status       int // Forced status, 0 for none
+	gets, hits   int // GETs, and those answered with a 304
+}
+
+func (d *fakeDocs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := strings.TrimPrefix(r.URL.Path, "/docs/")
+	if r.Method == http.MethodPut {
+		data, _ := io.ReadAll(r.Body)
+		d.docs[id] = string(data)
+		return
+	}
+	d.gets++
+	if d.status != 0 {
+		w.WriteHeader(d.status)
+		return
+	}
+	etag := `"` + d.docs[id] + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", d.cacheControl)
+	if r.Header.Get("If-None-Match") == etag {
+		d.hits++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	io.WriteString(w, d.docs[id])
+}
+
+func TestRESTFSCache(t *testing.T) {
+	docs := &fakeDocs{docs: map[string]string{"a": "one"}, cacheControl: "max-age=60"}
+	server := httptest.NewServer(docs)
+	t.Cleanup(server.Close)
+
+	newFS := func(cfg map[string]interface{}) *restFS {
+		cfg["mapping"] = "base_url: " + server.URL + "\nresources: [{path: '/docs/{id}', read: {url: '/docs/{id}'}, write: {url: '/docs/{id}'}}]"
+		p := NewRESTFSPlugin()
+		if err := p.Validate(cfg); err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+		if err := p.Initialize(cfg); err != nil {
+			t.Fatalf("Initialize() error = %v", err)
+		}
+		return p.GetFileSystem().(*restFS)
+	}
+	read := func(fs *restFS, path, want string) {
+		t.Helper()
+		if data, err := fs.Read(path, 0, -1); (err != nil && err != io.EOF) || string(data) != want {
+			t.Fatalf("Read(%s) = %q, %v, want %q", path, data, err, want)
+		}
+	}
+
+	fs := newFS(map[string]interface{}{})
+	now := time.Now()
+	fs.plugin.cache.now = func() time.Time { return now }
+
+	// Fresh responses are served without a request
+	read(fs, "/docs/a", "one")
+	read(fs, "/docs/a", "one")
+	if docs.gets != 1 {
+		t.Errorf("GETs within max-age = %d, want 1", docs.gets)
+	}
+
+	// Stale ones are revalidated
+	now = now.Add(time.Minute)
+	read(fs, "/docs/a", "one")
+	if docs.gets != 2 || docs.hits != 1 {
+		t.Errorf("GETs, 304s after max-age = %d, %d, want 2, 1", docs.gets, docs.hits)
+	}
+
+	// and served stale while the API is throttling
+	now = now.Add(time.Minute)
+	docs.status = http.StatusTooManyRequests
+	read(fs, "/docs/a", "one")
+	docs.status = 0
+
+	// Writes invalidate what they change
+	if _, err := fs.Write("/docs/a", []byte("two"), -1, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write error = %v", err)
+	}
+	read(fs, "/docs/a", "two")
+
+	// The flush file drops responses on demand
+	docs.docs["a"] = "three"
+	read(fs, "/docs/a", "two")
+	if _, err := fs.Write("/.cache/flush", []byte("/docs/a\n"), -1, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write flush error = %v", err)
+	}
+	read(fs, "/docs/a", "three")
+	if _, err := fs.Write("/.cache/flush", []byte("a"), -1, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("Write relative flush = %v, want invalid argument", err)
+	}
+
+	var stats CacheStats
+	data, _ := fs.Read("/.cache/stats", 0, -1)
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatalf("stats = %q: %v", data, err)
+	}
+	if stats != (CacheStats{Entries: 1, Hits: 2, Revalidated: 1, Misses: 3, Stale: 1}) {
+		t.Errorf("stats = %+v", stats)
+	}
+	if entries, err := fs.ReadDir("/.cache"); err != nil || names(entries) != "flush stats" {
+		t.Errorf("ReadDir(/.cache) = %q, %v", names(entries), err)
+	}
+
+	// no-store responses are never kept
+	docs.cacheControl = "no-store"
+	fs.Write("/.cache/flush", nil, -1, filesystem.WriteFlagNone)
+	gets := docs.gets
+	read(fs, "/docs/a", "three")
+	read(fs, "/docs/a", "three")
+	if docs.gets != gets+2 {
+		t.Errorf("GETs of no-store responses = %d, want %d", docs.gets-gets, 2)
+	}
+
+	// and without the cache every read is a request
+	docs.cacheControl = "max-age=60"
+	fs = newFS(map[string]interface{}{"cache": false})
+	gets = docs.gets
+	read(fs, "/docs/a", "three")
+	read(fs, "/docs/a", "three")
+	if docs.gets != gets+2 {
+		t.Errorf("GETs without the cache = %d, want %d", docs.gets-gets, 2)
+	}
+	if _, err := fs.Stat("/.cache"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Stat(/.cache) without the cache = %v, want not found", err)
+	}
+}
+
+func TestRESTFSPagination(t *testing.T) {
+	// 25 items, 3 per page, linked by "next" URLs or cursors
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		start, _ := strconv.Atoi(r.URL.Query().Get("after"))
+		var items []interface{}
+		for i := start + 1; i <= 25 && i <= start+3; i++ {
+			items = append(items, map[string]interface{}{"id": i})
+		}
+		resp := map[string]interface{}{"items": items}
+		if start+3 < 25 {
+			resp["next"] = "/items?after=" + strconv.Itoa(start+3)
+			resp["cursor"] = start + 3
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+
+	for _, paginate := range []string{"{next: next, max_pages: 4}", "{cursor: cursor, cursor_param: after, max_pages: 4}"} {
+		mapping := "base_url: " + server.URL + "\nresources:\n" +
+			"  - path: /items\n    list: {url: /items, result: items, name: id, paginate: " + paginate + "}\n" +
+			"  - path: '/items/{id}'\n    read: {url: '/items/{id}'}\n"
+		cfg := map[string]interface{}{"mapping": mapping, "cache": false}
+		p := NewRESTFSPlugin()
+		if err := p.Validate(cfg); err != nil {
+			t.Fatalf("Validate(%s) error = %v", paginate, err)
+		}
+		if err := p.Initialize(cfg); err != nil {
+			t.Fatalf("Initialize() error = %v", err)
+		}
+		fs := p.GetFileSystem().(*restFS)
+
+		// Each listing walks 4 pages, and the rest is in .more
+		requests = 0
+		first, err := fs.ReadDir("/items")
+		if err != nil || names(first) != "1 2 3 4 5 6 7 8 9 10 11 12 .more/" || requests != 4 {
+			t.Fatalf("ReadDir(/items) with %s = %q, %v after %d requests", paginate, names(first), err, requests)
+		}
+		if token := first[12].Meta.Content["next"]; token != "/items?after=12" && token != "12" {
+			t.Errorf("continuation token = %q", token)
+		}
+		second, err := fs.ReadDir("/items/.more")
+		if err != nil || names(second) != "13 14 15 16 17 18 19 20 21 22 23 24 .more/" || requests != 8 {
+			t.Fatalf("ReadDir(/items/.more) = %q, %v after %d requests", names(second), err, requests)
+		}
+		if last, err := fs.ReadDir("/items/.more/.more"); err != nil || names(last) != "25" {
+			t.Fatalf("ReadDir(/items/.more/.more) = %q, %v", names(last), err)
+		}
+		if _, err := fs.ReadDir("/items/.more/.more/.more"); !errors.Is(err, filesystem.ErrNotFound) {
+			t.Errorf("ReadDir past the last page = %v, want not found", err)
+		}
+		if info, err := fs.Stat("/items/.more"); err != nil || !info.IsDir || info.Name != ".more" {
+			t.Errorf("Stat(/items/.more) = %+v, %v", info, err)
+		}
+		if err := fs.Remove("/items/.more"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+			t.Errorf("Remove(/items/.more) = %v, want permission denied", err)
+		}
+		if _, err := fs.Stat("/items/.more/14"); err != nil {
+			t.Errorf("Stat of an entry in .more: %v", err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/url"
 	"path/filepath"
 	"strings"
 	"time"
@@ -14,6 +15,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/creds"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -30,6 +32,8 @@ type S3Client struct {
 	region    string // AWS region
 	prefix    string // Effective prefix with isolation wrapping applied
 	rawPrefix string // Original user-specified prefix (for display purposes)
+
+	credentials *credentialWatch // Whether the credentials are still valid
 }
 
 // S3Config holds S3 client configuration
@@ -42,6 +46,10 @@ type S3Config struct {
 	Prefix          string // Optional prefix for all keys (will be wrapped for isolation)
 	DisableSSL      bool   // For testing with local S3
 	UsePathStyle    bool   // Use path-style requests (required for MinIO and some S3-compatible services)
+
+	// Credentials, if set, replaces the static keys with a provider's
+	// credentials, rotated as they expire
+	Credentials *creds.Cache
 }
 
 // checkBucketAccess verifies that the bucket exists and is accessible
@@ -68,7 +76,9 @@ func NewS3Client(cfg S3Config) (*S3Client, error) {
 	}
 
 	// Add credentials if provided
-	if cfg.AccessKeyID != "" && cfg.SecretAccessKey != "" {
+	if cfg.Credentials != nil {
+		opts = append(opts, config.WithCredentialsProvider(cfg.Credentials.AWS()))
+	} else if cfg.AccessKeyID != "" && cfg.SecretAccessKey != "" {
 		opts = append(opts, config.WithCredentialsProvider(
 			credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
 		))
@@ -80,7 +90,12 @@ func NewS3Client(cfg S3Config) (*S3Client, error) {
 	}
 
 	// Create S3 client options
-	clientOpts := []func(*s3.Options){}
+	watch := newCredentialWatch(awsCfg.Credentials)
+	clientOpts := []func(*s3.Options){
+		func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, watch.middleware)
+		},
+	}
 
 	// Set custom endpoint if provided (for MinIO, LocalStack, etc.)
 	if cfg.Endpoint != "" {
@@ -124,6 +139,8 @@ func NewS3Client(cfg S3Config) (*S3Client, error) {
 		region:    cfg.Region,
 		prefix:    prefix,
 		rawPrefix: rawPrefix,
+
+		credentials: watch,
 	}, nil
 }
 
@@ -249,6 +266,43 @@ func (c *S3Client) PutObject(ctx context.Context, path string, data []byte) erro
 	return nil
 }
 
+// CopyObject copies an object within the bucket on the server side
+func (c *S3Client) CopyObject(ctx context.Context, srcPath, dstPath string) error {
+	srcKey := c.buildKey(srcPath)
+	dstKey := c.buildKey(dstPath)
+
+	// CopySource is "bucket/key" with the key URL-encoded
+	segments := strings.Split(srcKey, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	_, err := c.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(c.bucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(c.bucket + "/" + strings.Join(segments, "/")),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy object %s to %s: %w", srcKey, dstKey, err)
+	}
+
+	return nil
+}
+
+// WithSubPrefix returns a client that shares the connection but sees only
+// the keys under path
+func (c *S3Client) WithSubPrefix(path string) *S3Client {
+	return &S3Client{
+		client:    c.client,
+		bucket:    c.bucket,
+		region:    c.region,
+		prefix:    c.buildKey(path),
+		rawPrefix: c.rawPrefix,
+
+		credentials: c.credentials,
+	}
+}
+
 // DeleteObject deletes an object from S3
 func (c *S3Client) DeleteObject(ctx context.Context, path string) error {
 	key := c.buildKey(path)
@@ -357,6 +411,46 @@ func (c *S3Client) ListObjects(ctx context.Context, path string) ([]S3Object, er
 	return objects, nil
 }
 
+// ListObjectsRecursive lists every object under path with a flat prefix
+// listing. Keys are relative to path and may contain "/". Directory markers
+// are returned as directories; implicit directories are not synthesized.
+func (c *S3Client) ListObjectsRecursive(ctx context.Context, path string) ([]S3Object, error) {
+	prefix := c.buildKey(path)
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	var objects []S3Object
+	paginator := s3.NewListObjectsV2Paginator(c.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(c.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			if obj.Key == nil || *obj.Key == prefix || !c.isWithinPrefixBoundary(*obj.Key) {
+				continue
+			}
+
+			relPath := strings.TrimPrefix(*obj.Key, prefix)
+			isDir := strings.HasSuffix(relPath, "/")
+			objects = append(objects, S3Object{
+				Key:          strings.TrimSuffix(relPath, "/"),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+				IsDir:        isDir,
+			})
+		}
+	}
+
+	return objects, nil
+}
+
 // CreateDirectory creates a directory marker in S3
 // S3 doesn't have real directories, but we create empty objects ending with "/"
 func (c *S3Client) CreateDirectory(ctx context.Context, path string) error {
@@ -426,6 +520,40 @@ func (c *S3Client) DeleteDirectory(ctx context.Context, path string) error {
 	return nil
 }
 
+// DeleteObjects deletes the objects at paths in batches of up to 1000, the
+// most a single DeleteObjects request accepts
+func (c *S3Client) DeleteObjects(ctx context.Context, paths []string) error {
+	batchSize := 1000
+	for i := 0; i < len(paths); i += batchSize {
+		end := i + batchSize
+		if end > len(paths) {
+			end = len(paths)
+		}
+
+		objects := make([]types.ObjectIdentifier, 0, end-i)
+		for _, path := range paths[i:end] {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(c.buildKey(path))})
+		}
+		result, err := c.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(c.bucket),
+			Delete: &types.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete objects: %w", err)
+		}
+		if len(result.Errors) > 0 {
+			first := result.Errors[0]
+			return fmt.Errorf("failed to delete %d object(s), first %s: %s",
+				len(result.Errors), aws.ToString(first.Key), aws.ToString(first.Message))
+		}
+	}
+
+	return nil
+}
+
 // ObjectExists checks if an object exists
 func (c *S3Client) ObjectExists(ctx context.Context, path string) (bool, error) {
 	_, err := c.HeadObject(ctx, path)
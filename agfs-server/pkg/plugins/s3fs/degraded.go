@@ -0,0 +1,139 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// modeReadOnly is the degraded mode of s3fs while its credentials are
+// expired: reads are still attempted, changes are refused before they reach
+// the bucket
+const modeReadOnly = "read_only"
+
+// credentialsCheckInterval bounds how often the credentials are checked
+// before a change, and so how long s3fs stays read-only once they are
+// renewed
+const credentialsCheckInterval = 30 * time.Second
+
+// credentialErrorCodes are the S3 error codes of expired or revoked
+// credentials
+var credentialErrorCodes = map[string]bool{
+	"ExpiredToken":          true,
+	"ExpiredTokenException": true,
+	"TokenRefreshRequired":  true,
+	"InvalidToken":          true,
+	"InvalidAccessKeyId":    true,
+}
+
+// isCredentialError reports whether S3 rejected a request for its
+// credentials
+func isCredentialError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && credentialErrorCodes[apiErr.ErrorCode()]
+}
+
+// credentialWatch tracks whether the credentials of a client are still
+// valid, switching s3fs to read-only mode when they are not
+type credentialWatch struct {
+	provider aws.CredentialsProvider // nil when unknown
+	health   *filesystem.HealthState
+
+	mu        sync.Mutex
+	checkedAt time.Time
+}
+
+func newCredentialWatch(provider aws.CredentialsProvider) *credentialWatch {
+	return &credentialWatch{provider: provider, health: filesystem.NewHealthState(modeReadOnly)}
+}
+
+// check returns an error when the credentials can't be retrieved or are
+// expired
+func (w *credentialWatch) check(ctx context.Context) error {
+	if w.provider == nil {
+		return nil
+	}
+	creds, err := w.provider.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	if creds.Expired() {
+		return fmt.Errorf("credentials expired at %s", creds.Expires.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (w *credentialWatch) readOnly(err error) {
+	if w.health.Enter(modeReadOnly, err.Error()) {
+		log.Warnf("[s3fs] Credentials expired, read-only until they are renewed: %v", err)
+	}
+}
+
+// observe switches to read-only mode when S3 rejected a request for its
+// credentials
+func (w *credentialWatch) observe(err error) {
+	if !isCredentialError(err) {
+		return
+	}
+	w.mu.Lock()
+	w.checkedAt = time.Now()
+	w.mu.Unlock()
+	w.readOnly(err)
+}
+
+// checkWritable refuses changes while read-only. The credentials are
+// checked again once credentialsCheckInterval has passed, which switches
+// back to normal mode once they are renewed.
+func (w *credentialWatch) checkWritable(ctx context.Context, op, path string) error {
+	w.mu.Lock()
+	if time.Since(w.checkedAt) >= credentialsCheckInterval {
+		w.checkedAt = time.Now()
+		if err := w.check(ctx); err != nil {
+			w.readOnly(err)
+		} else if w.health.Recover() {
+			log.Infof("[s3fs] Credentials renewed, writable again")
+		}
+	}
+	w.mu.Unlock()
+
+	if w.health.Mode() == modeReadOnly {
+		return filesystem.NewPermissionDeniedError(op, path, "s3fs is read-only: credentials expired")
+	}
+	return nil
+}
+
+// middleware observes the outcome of every S3 request
+func (w *credentialWatch) middleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("AGFSCredentialWatch",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			if err != nil {
+				w.observe(err)
+			}
+			return out, metadata, err
+		}), middleware.After)
+}
+
+// checkWritable refuses changes while s3fs is read-only
+func (fs *S3FS) checkWritable(ctx context.Context, op, path string) error {
+	if fs.client.credentials == nil {
+		return nil
+	}
+	return fs.client.credentials.checkWritable(ctx, op, path)
+}
+
+// Health implements filesystem.HealthReporter
+func (fs *S3FS) Health() filesystem.Health {
+	if fs.client.credentials == nil {
+		return filesystem.NewHealthState(modeReadOnly).Health()
+	}
+	return fs.client.credentials.health.Health()
+}
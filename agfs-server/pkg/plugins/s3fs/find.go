@@ -0,0 +1,106 @@
+package s3fs
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Find implements filesystem.Finder.
+// The whole subtree is fetched with one paginated prefix listing instead of a
+// ListObjects call per directory, and filters are applied to the flat result.
+// Directories without a marker object are synthesized from key paths.
+func (fs *S3FS) Find(root string, opts filesystem.FindOptions, fn filesystem.WalkFunc) error {
+	key := filesystem.NormalizeS3Key(root)
+
+	fs.mu.RLock()
+	objects, err := fs.client.ListObjectsRecursive(context.Background(), key)
+	fs.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string]S3Object, len(objects))
+	for _, obj := range objects {
+		if fs.expiry.Expired(path.Join(key, obj.Key)) {
+			continue
+		}
+		if opts.Type != filesystem.FindTypeFile {
+			// Register implicit parent directories
+			for dir := path.Dir(obj.Key); dir != "."; dir = path.Dir(dir) {
+				if _, ok := entries[dir]; ok {
+					break
+				}
+				entries[dir] = S3Object{Key: dir, LastModified: time.Now(), IsDir: true}
+			}
+		}
+		entries[obj.Key] = obj
+	}
+
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rootPath := filesystem.NormalizePath(root)
+	now := time.Now()
+	var skipped []string // Directories whose subtree fn asked to skip
+	for _, rel := range keys {
+		if hasSkippedParent(skipped, rel) {
+			continue
+		}
+		obj := entries[rel]
+		depth := strings.Count(rel, "/") + 1
+		if opts.MaxDepth > 0 && depth > opts.MaxDepth {
+			continue
+		}
+
+		mode := uint32(0644)
+		if obj.IsDir {
+			mode = 0755
+		}
+		info := filesystem.FileInfo{
+			Name:    path.Base(rel),
+			Size:    obj.Size,
+			Mode:    mode,
+			ModTime: obj.LastModified,
+			IsDir:   obj.IsDir,
+			Meta: filesystem.MetaData{
+				Name: PluginName,
+				Type: "s3",
+			},
+			ExpiresAt: fs.expiry.Get(path.Join(key, rel)),
+		}
+		if !filesystem.MatchFind(opts, info, now) {
+			continue
+		}
+
+		if err := fn(filesystem.WalkEntry{Path: path.Join(rootPath, rel), Depth: depth, Info: info}); err != nil {
+			if err == filesystem.SkipDir {
+				if obj.IsDir {
+					skipped = append(skipped, rel+"/")
+				}
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func hasSkippedParent(skipped []string, rel string) bool {
+	for _, prefix := range skipped {
+		if strings.HasPrefix(rel, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ensure S3FS implements Finder interface
+var _ filesystem.Finder = (*S3FS)(nil)
@@ -51,6 +51,18 @@ func (c *S3Client) CreateMultipartUpload(ctx context.Context, key string) (*Mult
 
 // UploadPart uploads a single part
 func (c *S3Client) UploadPart(ctx context.Context, upload *MultipartUpload, partNumber int32, data []byte) error {
+	part, err := c.uploadPart(ctx, upload, partNumber, data)
+	if err != nil {
+		return err
+	}
+	// Record completed part
+	upload.Parts = append(upload.Parts, part)
+	return nil
+}
+
+// uploadPart uploads a single part and returns it, without recording it in
+// the upload, for uploads whose parts are uploaded concurrently
+func (c *S3Client) uploadPart(ctx context.Context, upload *MultipartUpload, partNumber int32, data []byte) (types.CompletedPart, error) {
 	log.Debugf("[s3fs] Uploading part: upload_id=%s, part_number=%d, size=%d",
 		upload.UploadID, partNumber, len(data))
 
@@ -65,18 +77,15 @@ func (c *S3Client) UploadPart(ctx context.Context, upload *MultipartUpload, part
 	result, err := c.client.UploadPart(ctx, input)
 	if err != nil {
 		log.Errorf("[s3fs] Failed to upload part %d: %v", partNumber, err)
-		return fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+		return types.CompletedPart{}, fmt.Errorf("failed to upload part %d: %w", partNumber, err)
 	}
 
-	// Record completed part
-	upload.Parts = append(upload.Parts, types.CompletedPart{
-		ETag:       result.ETag,
-		PartNumber: aws.Int32(partNumber),
-	})
-
 	log.Debugf("[s3fs] Part %d uploaded: etag=%s", partNumber, *result.ETag)
 
-	return nil
+	return types.CompletedPart{
+		ETag:       result.ETag,
+		PartNumber: aws.Int32(partNumber),
+	}, nil
 }
 
 // CompleteMultipartUpload completes the multipart upload
@@ -146,3 +155,40 @@ func (c *S3Client) ListParts(ctx context.Context, key, uploadID string) ([]types
 
 	return result.Parts, nil
 }
+
+// atomicStagePartSize is the part size used for staged atomic writes
+const atomicStagePartSize = 16 * 1024 * 1024
+
+// PutObjectStaged uploads data as a multipart upload. Parts are staged on the
+// server and the object only becomes visible, in full, when the upload
+// completes. On failure the upload is aborted and the previous object is kept.
+func (c *S3Client) PutObjectStaged(ctx context.Context, path string, data []byte, partSize int) error {
+	upload, err := c.CreateMultipartUpload(ctx, c.buildKey(path))
+	if err != nil {
+		return err
+	}
+	return c.uploadStaged(ctx, upload, data, partSize)
+}
+
+// uploadStaged uploads data as the parts of an upload and completes it. On
+// failure the upload is aborted.
+func (c *S3Client) uploadStaged(ctx context.Context, upload *MultipartUpload, data []byte, partSize int) error {
+	var partNumber int32 = 1
+	for start := 0; start < len(data); start += partSize {
+		end := start + partSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := c.UploadPart(ctx, upload, partNumber, data[start:end]); err != nil {
+			c.AbortMultipartUpload(ctx, upload)
+			return err
+		}
+		partNumber++
+	}
+
+	if err := c.CompleteMultipartUpload(ctx, upload); err != nil {
+		c.AbortMultipartUpload(ctx, upload)
+		return err
+	}
+	return nil
+}
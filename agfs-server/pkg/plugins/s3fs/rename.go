@@ -0,0 +1,401 @@
+package s3fs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// renameManifestDir holds one manifest per directory rename, named
+// <id>.json. A manifest records the progress of its rename and lets an
+// interrupted rename resume, either when the same rename is issued again
+// or when the plugin starts.
+const renameManifestDir = ".renames"
+
+// renameBatchSize is how many objects are moved before the manifest is
+// updated; it matches the DeleteObjects limit
+const renameBatchSize = 1000
+
+// Rename defaults
+const (
+	defaultRenameWorkers        = 16
+	defaultRenameAsyncThreshold = 10000
+)
+
+// Rename states
+const (
+	renameStatusRunning   = "running"
+	renameStatusCompleted = "completed"
+	renameStatusFailed    = "failed"
+)
+
+// renameManifest is the JSON content of .renames/<id>.json
+type renameManifest struct {
+	ID          string    `json:"id"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Status      string    `json:"status"`
+	Async       bool      `json:"async"`
+	Total       int       `json:"total"` // Objects to move when the rename (re)started
+	Moved       int       `json:"moved"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	FinishedAt  time.Time `json:"finished_at,omitempty"`
+}
+
+// renameJobs tracks the directory renames in progress
+type renameJobs struct {
+	workers        int // Concurrent CopyObject requests per rename
+	asyncThreshold int // Renames of more objects run in the background; 0 never does
+	active         map[string]bool
+	mu             sync.Mutex
+	wg             sync.WaitGroup
+	ctx            context.Context
+	cancel         context.CancelFunc
+}
+
+func newRenameJobs() *renameJobs {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &renameJobs{
+		workers:        defaultRenameWorkers,
+		asyncThreshold: defaultRenameAsyncThreshold,
+		active:         make(map[string]bool),
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// begin claims a rename; it fails if the same rename is already running
+func (j *renameJobs) begin(id string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.active[id] {
+		return false
+	}
+	j.active[id] = true
+	return true
+}
+
+func (j *renameJobs) end(id string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.active, id)
+}
+
+// stop interrupts the background renames and waits for them. Their
+// manifests stay "running" so they resume on the next start.
+func (j *renameJobs) stop() {
+	j.cancel()
+	j.wg.Wait()
+}
+
+// renameManifestID identifies the rename of source to destination, so that
+// issuing the same rename again finds its manifest
+func renameManifestID(source, destination string) string {
+	sum := sha256.Sum256([]byte(source + "\x00" + destination))
+	return hex.EncodeToString(sum[:8])
+}
+
+func renameManifestPath(id string) string {
+	return renameManifestDir + "/" + id + ".json"
+}
+
+// loadRenameManifest returns the manifest of a rename, or nil if there is none
+func (fs *S3FS) loadRenameManifest(ctx context.Context, id string) (*renameManifest, error) {
+	path := renameManifestPath(id)
+	exists, err := fs.client.ObjectExists(ctx, path)
+	if err != nil || !exists {
+		return nil, err
+	}
+	data, err := fs.client.GetObject(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	var m renameManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid rename manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+func (fs *S3FS) saveRenameManifest(ctx context.Context, m *renameManifest) error {
+	m.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := renameManifestPath(m.ID)
+	if err := fs.client.PutObject(ctx, path, data); err != nil {
+		return err
+	}
+	fs.dirCache.Invalidate(renameManifestDir)
+	fs.statCache.Invalidate(path)
+	return nil
+}
+
+// renameDirectory moves every object under oldPath to newPath with
+// server-side copies. Objects are moved in batches: a batch is copied in
+// parallel, then deleted from the source with one request, then counted in
+// the manifest. Copying an object twice is harmless, so a rename that was
+// interrupted resumes by moving whatever is left under oldPath. Renames of
+// more than asyncThreshold objects return once the manifest is written and
+// continue in the background.
+func (fs *S3FS) renameDirectory(ctx context.Context, oldPath, newPath string) error {
+	if oldPath == "" || newPath == "" {
+		return filesystem.NewInvalidArgumentError("path", "/", "cannot rename the root directory")
+	}
+	if newPath == oldPath || strings.HasPrefix(newPath, oldPath+"/") {
+		return filesystem.NewInvalidArgumentError("path", newPath, "cannot move a directory into itself")
+	}
+	if oldPath == renameManifestDir || strings.HasPrefix(newPath+"/", renameManifestDir+"/") {
+		return filesystem.NewInvalidArgumentError("path", renameManifestDir, "reserved for rename manifests")
+	}
+
+	id := renameManifestID(oldPath, newPath)
+	if !fs.renames.begin(id) {
+		return fmt.Errorf("%w: rename of %s to %s is already in progress", filesystem.ErrAlreadyExists, oldPath, newPath)
+	}
+	started := false
+	defer func() {
+		if !started {
+			fs.renames.end(id)
+		}
+	}()
+
+	m, err := fs.loadRenameManifest(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to read rename manifest: %w", err)
+	}
+	if m != nil && m.Status == renameStatusCompleted {
+		m = nil
+	}
+
+	exists, err := fs.client.DirectoryExists(ctx, oldPath)
+	if err != nil {
+		return fmt.Errorf("failed to check source: %w", err)
+	}
+	if !exists && m == nil {
+		return filesystem.ErrNotFound
+	}
+	if m == nil {
+		// A fresh rename must not merge into an existing destination
+		destFile, err := fs.client.ObjectExists(ctx, newPath)
+		if err != nil {
+			return fmt.Errorf("failed to check destination: %w", err)
+		}
+		destDir, err := fs.client.DirectoryExists(ctx, newPath)
+		if err != nil {
+			return fmt.Errorf("failed to check destination: %w", err)
+		}
+		if destFile || destDir {
+			return fmt.Errorf("%w: %s", filesystem.ErrAlreadyExists, newPath)
+		}
+	}
+
+	objects, err := fs.client.ListObjectsRecursive(ctx, oldPath)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if m == nil {
+		m = &renameManifest{
+			ID:          id,
+			Source:      oldPath,
+			Destination: newPath,
+			StartedAt:   now,
+		}
+	} else {
+		log.Infof("[s3fs] Resuming rename %s: %s -> %s, %d object(s) left", id, oldPath, newPath, len(objects))
+	}
+	m.Status = renameStatusRunning
+	m.Error = ""
+	m.Total = m.Moved + len(objects)
+	m.Async = fs.renames.asyncThreshold > 0 && len(objects) > fs.renames.asyncThreshold
+	if err := fs.saveRenameManifest(ctx, m); err != nil {
+		return fmt.Errorf("failed to write rename manifest: %w", err)
+	}
+
+	started = true
+	if m.Async {
+		fs.renames.wg.Add(1)
+		go func() {
+			defer fs.renames.wg.Done()
+			if err := fs.runRename(fs.renames.ctx, m, objects); err != nil {
+				log.Warnf("[s3fs] Rename %s of %s to %s: %v", m.ID, m.Source, m.Destination, err)
+			}
+		}()
+		log.Infof("[s3fs] Renaming %s to %s in the background (%d objects), progress in /%s",
+			oldPath, newPath, len(objects), renameManifestPath(id))
+		return nil
+	}
+	return fs.runRename(ctx, m, objects)
+}
+
+// runRename moves objects, listed relative to m.Source, to m.Destination
+// and records the outcome in the manifest
+func (fs *S3FS) runRename(ctx context.Context, m *renameManifest, objects []S3Object) error {
+	defer fs.renames.end(m.ID)
+
+	err := fs.moveObjects(ctx, m, objects)
+	if err == nil {
+		// Keep the destination even when the source was an empty directory
+		if err = fs.client.CreateDirectory(ctx, m.Destination); err == nil {
+			err = fs.client.DeleteObject(ctx, m.Source+"/")
+		}
+	}
+
+	fs.dirCache.Invalidate(getParentPath(m.Source))
+	fs.dirCache.Invalidate(getParentPath(m.Destination))
+	fs.dirCache.InvalidatePrefix(m.Source)
+	fs.dirCache.InvalidatePrefix(m.Destination)
+	fs.statCache.InvalidatePrefix(m.Source)
+	fs.statCache.InvalidatePrefix(m.Destination)
+
+	if err != nil && ctx.Err() != nil {
+		// Interrupted by shutdown; the manifest stays running and resumes
+		return err
+	}
+	if err != nil {
+		m.Status = renameStatusFailed
+		m.Error = err.Error()
+	} else {
+		m.Status = renameStatusCompleted
+		fs.expiry.Rename(m.Source, m.Destination)
+	}
+	m.FinishedAt = time.Now()
+	// Recording the outcome must not be skipped because ctx was cancelled
+	if saveErr := fs.saveRenameManifest(context.Background(), m); saveErr != nil {
+		log.Warnf("[s3fs] Failed to update rename manifest %s: %v", m.ID, saveErr)
+	}
+	return err
+}
+
+// moveObjects copies and deletes objects batch by batch, updating the
+// manifest after each batch
+func (fs *S3FS) moveObjects(ctx context.Context, m *renameManifest, objects []S3Object) error {
+	for i := 0; i < len(objects); i += renameBatchSize {
+		end := i + renameBatchSize
+		if end > len(objects) {
+			end = len(objects)
+		}
+
+		var sources []string
+		var destinations []string
+		for _, obj := range objects[i:end] {
+			key := obj.Key
+			if obj.IsDir {
+				key += "/"
+			}
+			sources = append(sources, m.Source+"/"+key)
+			destinations = append(destinations, m.Destination+"/"+key)
+		}
+
+		if err := fs.copyObjects(ctx, sources, destinations); err != nil {
+			return err
+		}
+		if err := fs.client.DeleteObjects(ctx, sources); err != nil {
+			return err
+		}
+
+		m.Moved += len(sources)
+		if err := fs.saveRenameManifest(ctx, m); err != nil {
+			log.Warnf("[s3fs] Failed to update rename manifest %s: %v", m.ID, err)
+		}
+		log.Debugf("[s3fs] Rename %s: moved %d/%d objects", m.ID, m.Moved, m.Total)
+	}
+	return nil
+}
+
+// copyObjects copies sources[i] to destinations[i] with up to the
+// configured number of concurrent requests, stopping at the first error
+func (fs *S3FS) copyObjects(ctx context.Context, sources, destinations []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := fs.renames.workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := fs.client.CopyObject(ctx, sources[i], destinations[i]); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range sources {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// resumeRenames restarts in the background the renames whose manifests are
+// still running, i.e. that were interrupted by a shutdown or crash
+func (fs *S3FS) resumeRenames() {
+	ctx := fs.renames.ctx
+	objects, err := fs.client.ListObjects(ctx, renameManifestDir)
+	if err != nil {
+		log.Warnf("[s3fs] Failed to list rename manifests: %v", err)
+		return
+	}
+
+	for _, obj := range objects {
+		if obj.IsDir || !strings.HasSuffix(obj.Key, ".json") {
+			continue
+		}
+		m, err := fs.loadRenameManifest(ctx, strings.TrimSuffix(obj.Key, ".json"))
+		if err != nil || m == nil {
+			if err != nil {
+				log.Warnf("[s3fs] Skipping rename manifest %s: %v", obj.Key, err)
+			}
+			continue
+		}
+		if m.Status != renameStatusRunning {
+			continue
+		}
+
+		fs.renames.wg.Add(1)
+		go func(m *renameManifest) {
+			defer fs.renames.wg.Done()
+			err := fs.renameDirectory(ctx, m.Source, m.Destination)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				log.Warnf("[s3fs] Failed to resume rename of %s to %s: %v", m.Source, m.Destination, err)
+			}
+		}(m)
+	}
+}
@@ -0,0 +1,211 @@
+package s3fs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// reservedUploadMinSize is the smallest reservation starting a multipart
+// upload: smaller files are written with a single request anyway
+const reservedUploadMinSize = atomicStagePartSize
+
+// reservedUpload is the multipart upload started by the reservation of a
+// large file. The write of the file uploads into it, streamed writes part
+// by part instead of buffering the whole file.
+type reservedUpload struct {
+	upload  *MultipartUpload
+	written atomic.Int64 // Bytes uploaded so far
+}
+
+// reservedUploads holds the uploads of reserved files not written yet
+type reservedUploads struct {
+	mu      sync.Mutex
+	entries map[string]*reservedUpload
+}
+
+func newReservedUploads() *reservedUploads {
+	return &reservedUploads{entries: make(map[string]*reservedUpload)}
+}
+
+func (u *reservedUploads) get(key string) *reservedUpload {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.entries[key]
+}
+
+func (u *reservedUploads) put(key string, r *reservedUpload) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.entries[key] = r
+}
+
+// take removes and returns the upload of key, if any
+func (u *reservedUploads) take(key string) *reservedUpload {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	r := u.entries[key]
+	delete(u.entries, key)
+	return r
+}
+
+// takeUnder removes and returns the uploads of key and the keys below it
+func (u *reservedUploads) takeUnder(key string) []*reservedUpload {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var taken []*reservedUpload
+	for k, r := range u.entries {
+		if k == key || key == "" || strings.HasPrefix(k, key+"/") {
+			taken = append(taken, r)
+			delete(u.entries, k)
+		}
+	}
+	return taken
+}
+
+// Reserve implements filesystem.Reserver: an empty object is put as the
+// placeholder and, for large files, the multipart upload of their write is
+// started ahead of it
+func (fs *S3FS) Reserve(path string, size int64) error {
+	if err := filesystem.ValidateReserveSize(size); err != nil {
+		return err
+	}
+	path = filesystem.NormalizeS3Key(path)
+	ctx := context.Background()
+
+	if err := fs.checkWritable(ctx, "create", path); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.reclaimExpired(ctx, path)
+
+	exists, err := fs.client.ObjectExists(ctx, path)
+	if err != nil {
+		return fmt.Errorf("failed to check if file exists: %w", err)
+	}
+	if exists {
+		return fmt.Errorf("file already exists: %s", path)
+	}
+	parent := getParentPath(path)
+	if parent != "" {
+		dirExists, err := fs.client.DirectoryExists(ctx, parent)
+		if err != nil {
+			return fmt.Errorf("failed to check parent directory: %w", err)
+		}
+		if !dirExists {
+			return fmt.Errorf("parent directory does not exist: %s", parent)
+		}
+	}
+
+	if err := fs.client.PutObject(ctx, path, []byte{}); err != nil {
+		return err
+	}
+	fs.dirCache.Invalidate(parent)
+	fs.statCache.Invalidate(path)
+	fs.reservations.Set(path, size)
+
+	if size >= reservedUploadMinSize {
+		upload, err := fs.client.CreateMultipartUpload(ctx, fs.client.buildKey(path))
+		if err != nil {
+			// The placeholder is there; the write starts its own upload
+			log.Warnf("[s3fs] Reserved %s without a multipart upload: %v", path, err)
+			return nil
+		}
+		fs.uploads.put(path, &reservedUpload{upload: upload})
+	}
+	return nil
+}
+
+// abortReserved aborts the uploads reserved for path and everything below
+// it, which is being removed or replaced
+func (fs *S3FS) abortReserved(ctx context.Context, path string) {
+	for _, r := range fs.uploads.takeUnder(path) {
+		fs.client.AbortMultipartUpload(ctx, r.upload)
+	}
+	fs.reservations.Remove(path)
+}
+
+// withReservation returns info with the size hint and upload progress of a
+// reserved file filled in. Cached entries are copied rather than modified.
+func (fs *S3FS) withReservation(info *filesystem.FileInfo, key string) *filesystem.FileInfo {
+	size := fs.reservations.Get(key)
+	if size == 0 {
+		return info
+	}
+	reserved := *info
+	reserved.Meta.Content = make(map[string]string, len(info.Meta.Content)+2)
+	for k, v := range info.Meta.Content {
+		reserved.Meta.Content[k] = v
+	}
+	reserved.Meta.Content[filesystem.MetaReservedSize] = strconv.FormatInt(size, 10)
+	written := info.Size
+	if r := fs.uploads.get(key); r != nil {
+		written = r.written.Load()
+	}
+	reserved.Meta.Content[filesystem.MetaReservedWritten] = strconv.FormatInt(written, 10)
+	return &reserved
+}
+
+// streamReserved uploads the full parts buffered by a writer of a reserved
+// file, keeping the rest buffered
+func (w *s3fsWriter) streamReserved() error {
+	for len(w.buf) >= atomicStagePartSize {
+		w.partNumber++
+		if err := w.fs.client.UploadPart(context.Background(), w.reserved.upload, w.partNumber, w.buf[:atomicStagePartSize]); err != nil {
+			return err
+		}
+		w.reserved.written.Add(atomicStagePartSize)
+		w.buf = append(w.buf[:0], w.buf[atomicStagePartSize:]...)
+	}
+	return nil
+}
+
+// closeReserved uploads the rest of a reserved file as its last part and
+// completes the upload. Files that didn't fill a part are put as usual.
+func (w *s3fsWriter) closeReserved() error {
+	fs, ctx := w.fs, context.Background()
+	path := filesystem.NormalizeS3Key(w.path)
+	fs.uploads.take(path)
+	if w.partNumber == 0 {
+		fs.client.AbortMultipartUpload(ctx, w.reserved.upload)
+		_, err := fs.Write(w.path, w.buf, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate)
+		return err
+	}
+
+	if err := fs.checkWritable(ctx, "write", path); err != nil {
+		fs.client.AbortMultipartUpload(ctx, w.reserved.upload)
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	err := w.err
+	if err == nil && len(w.buf) > 0 {
+		err = fs.client.UploadPart(ctx, w.reserved.upload, w.partNumber+1, w.buf)
+	}
+	if err == nil {
+		err = fs.client.CompleteMultipartUpload(ctx, w.reserved.upload)
+	}
+	if err != nil {
+		fs.client.AbortMultipartUpload(ctx, w.reserved.upload)
+		return err
+	}
+	if fs.expiry.Expired(path) {
+		fs.expiry.Set(path, time.Time{})
+	}
+	fs.reservations.Set(path, 0)
+	fs.dirCache.Invalidate(getParentPath(path))
+	fs.statCache.Invalidate(path)
+	return nil
+}
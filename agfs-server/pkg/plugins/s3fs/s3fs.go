@@ -2,6 +2,7 @@ package s3fs
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"path/filepath"
@@ -10,6 +11,8 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/creds"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
@@ -18,6 +21,9 @@ import (
 
 const (
 	PluginName = "s3fs"
+
+	// expirySweepInterval is how often expired objects are deleted
+	expirySweepInterval = time.Minute
 )
 
 // S3FS implements FileSystem interface using AWS S3 as backend
@@ -29,6 +35,19 @@ type S3FS struct {
 	// Caches for performance optimization
 	dirCache  *ListDirCache
 	statCache *StatCache
+
+	expiry *filesystem.ExpiryIndex // Object TTLs (in memory, lost on restart)
+
+	renames *renameJobs // Directory renames in progress
+
+	// Size hints of reserved files until they are written, and the
+	// multipart uploads started for the large ones (in memory)
+	reservations *filesystem.ReservationIndex
+	uploads      *reservedUploads
+
+	// Object Lock mode of the retention set on objects; empty when
+	// retention is only enforced by the mount
+	objectLockMode types.ObjectLockRetentionMode
 }
 
 // CacheConfig holds cache configuration
@@ -66,16 +85,120 @@ func NewS3FSWithCache(cfg S3Config, cacheCfg CacheConfig) (*S3FS, error) {
 		pluginName: PluginName,
 		dirCache:   NewListDirCache(cacheCfg.MaxSize, cacheCfg.DirCacheTTL, cacheCfg.Enabled),
 		statCache:  NewStatCache(cacheCfg.MaxSize*5, cacheCfg.StatCacheTTL, cacheCfg.Enabled),
+		expiry:     filesystem.NewExpiryIndex(),
+		renames:    newRenameJobs(),
+
+		reservations: filesystem.NewReservationIndex(),
+		uploads:      newReservedUploads(),
 	}, nil
 }
 
+// withExpiry returns info with ExpiresAt filled in for key. Cached entries
+// are copied rather than modified.
+func (fs *S3FS) withExpiry(info *filesystem.FileInfo, key string) *filesystem.FileInfo {
+	expiresAt := fs.expiry.Get(key)
+	if expiresAt.IsZero() {
+		return info
+	}
+	withTTL := *info
+	withTTL.ExpiresAt = expiresAt
+	return &withTTL
+}
+
+// filterExpired drops expired entries from a listing of dir and fills in
+// ExpiresAt for the rest
+func (fs *S3FS) filterExpired(dir string, files []filesystem.FileInfo) []filesystem.FileInfo {
+	var visible []filesystem.FileInfo
+	for _, f := range files {
+		key := f.Name
+		if dir != "" {
+			key = dir + "/" + f.Name
+		}
+		if fs.expiry.Expired(key) {
+			continue
+		}
+		visible = append(visible, *fs.withExpiry(&f, key))
+	}
+	return visible
+}
+
+// reclaimExpired deletes an expired object that is about to be replaced, so
+// the new object starts without the old TTL. Caller must hold fs.mu.
+func (fs *S3FS) reclaimExpired(ctx context.Context, key string) {
+	if fs.expiry.Expired(key) {
+		fs.client.DeleteObject(ctx, key)
+		fs.expiry.Set(key, time.Time{})
+		fs.dirCache.Invalidate(getParentPath(key))
+		fs.statCache.Invalidate(key)
+	}
+}
+
+// removeExpired is called by the sweeper for each expired path
+func (fs *S3FS) removeExpired(path string) error {
+	key := filesystem.NormalizeS3Key(path)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// The object may have been replaced or given a new TTL since it was listed
+	if !fs.expiry.Expired(key) {
+		return nil
+	}
+	fs.expiry.Set(key, time.Time{})
+	if err := fs.client.DeleteObject(context.Background(), key); err != nil {
+		return fmt.Errorf("failed to delete expired object: %w", err)
+	}
+	fs.dirCache.Invalidate(getParentPath(key))
+	fs.statCache.Invalidate(key)
+	log.Debugf("[s3fs] Deleted expired object: %s", key)
+	return nil
+}
+
+// SetExpiry implements filesystem.Expirer. Expiries are tracked in memory and
+// do not survive a restart.
+func (fs *S3FS) SetExpiry(path string, expiresAt time.Time) error {
+	path = filesystem.NormalizeS3Key(path)
+	ctx := context.Background()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.expiry.Expired(path) {
+		return filesystem.ErrNotFound
+	}
+	exists, err := fs.client.ObjectExists(ctx, path)
+	if err != nil {
+		return fmt.Errorf("failed to check if file exists: %w", err)
+	}
+	if !exists {
+		dirExists, err := fs.client.DirectoryExists(ctx, path)
+		if err != nil {
+			return fmt.Errorf("failed to check directory: %w", err)
+		}
+		if dirExists {
+			return filesystem.NewInvalidArgumentError("path", path, "TTL can only be set on files")
+		}
+		return filesystem.ErrNotFound
+	}
+
+	fs.expiry.Set(path, expiresAt)
+	fs.statCache.Invalidate(path)
+	return nil
+}
+
 func (fs *S3FS) Create(path string) error {
 	path = filesystem.NormalizeS3Key(path)
 	ctx := context.Background()
 
+	if err := fs.checkWritable(ctx, "create", path); err != nil {
+		return err
+	}
+
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	fs.reclaimExpired(ctx, path)
+
 	// Check if file already exists
 	exists, err := fs.client.ObjectExists(ctx, path)
 	if err != nil {
@@ -111,6 +234,10 @@ func (fs *S3FS) Mkdir(path string, perm uint32) error {
 	path = filesystem.NormalizeS3Key(path)
 	ctx := context.Background()
 
+	if err := fs.checkWritable(ctx, "mkdir", path); err != nil {
+		return err
+	}
+
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
@@ -149,6 +276,10 @@ func (fs *S3FS) Remove(path string) error {
 	path = filesystem.NormalizeS3Key(path)
 	ctx := context.Background()
 
+	if err := fs.checkWritable(ctx, "remove", path); err != nil {
+		return err
+	}
+
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
@@ -166,6 +297,8 @@ func (fs *S3FS) Remove(path string) error {
 		if err == nil {
 			fs.dirCache.Invalidate(parent)
 			fs.statCache.Invalidate(path)
+			fs.expiry.Remove(path)
+			fs.abortReserved(ctx, path)
 		}
 		return err
 	}
@@ -204,6 +337,10 @@ func (fs *S3FS) RemoveAll(path string) error {
 	path = filesystem.NormalizeS3Key(path)
 	ctx := context.Background()
 
+	if err := fs.checkWritable(ctx, "remove", path); err != nil {
+		return err
+	}
+
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
@@ -213,17 +350,28 @@ func (fs *S3FS) RemoveAll(path string) error {
 		fs.dirCache.Invalidate(parent)
 		fs.dirCache.InvalidatePrefix(path)
 		fs.statCache.InvalidatePrefix(path)
+		fs.expiry.Remove(path)
+		fs.abortReserved(ctx, path)
 	}
 	return err
 }
 
 func (fs *S3FS) Read(path string, offset int64, size int64) ([]byte, error) {
+	return fs.ReadContext(context.Background(), path, offset, size)
+}
+
+// ReadContext implements filesystem.ContextFileSystem: the S3 requests are
+// cancelled when ctx is done
+func (fs *S3FS) ReadContext(ctx context.Context, path string, offset int64, size int64) ([]byte, error) {
 	path = filesystem.NormalizeS3Key(path)
-	ctx := context.Background()
 
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
+	if fs.expiry.Expired(path) {
+		return nil, filesystem.ErrNotFound
+	}
+
 	// Use S3 Range request for efficient partial reads
 	if offset > 0 || size > 0 {
 		data, err := fs.client.GetObjectRange(ctx, path, offset, size)
@@ -249,8 +397,17 @@ func (fs *S3FS) Read(path string, offset int64, size int64) ([]byte, error) {
 }
 
 func (fs *S3FS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	return fs.WriteContext(context.Background(), path, data, offset, flags)
+}
+
+// WriteContext implements filesystem.ContextFileSystem: the S3 requests are
+// cancelled when ctx is done
+func (fs *S3FS) WriteContext(ctx context.Context, path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
 	path = filesystem.NormalizeS3Key(path)
-	ctx := context.Background()
+
+	if err := fs.checkWritable(ctx, "write", path); err != nil {
+		return 0, err
+	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -267,11 +424,37 @@ func (fs *S3FS) Write(path string, data []byte, offset int64, flags filesystem.W
 		return 0, fmt.Errorf("is a directory: %s", path)
 	}
 
-	// Write to S3 directly - S3 will create parent "directories" implicitly
-	err := fs.client.PutObject(ctx, path, data)
+	if err := filesystem.ValidateAtomicWrite(path, offset, flags); err != nil {
+		return 0, err
+	}
+
+	// An expired object is replaced by a new one without a TTL
+	if fs.expiry.Expired(path) {
+		fs.expiry.Set(path, time.Time{})
+	}
+
+	// Write to S3 directly - S3 will create parent "directories" implicitly.
+	// A single PutObject is already atomic; large atomic writes are staged as
+	// multipart parts that stay invisible until the upload completes.
+	// The upload started by Reserve saves a request, and stays invisible
+	// until completed like a staged write.
+	var err error
+	reserved := fs.uploads.take(path)
+	switch {
+	case reserved != nil && len(data) > atomicStagePartSize:
+		err = fs.client.uploadStaged(ctx, reserved.upload, data, atomicStagePartSize)
+	case flags&filesystem.WriteFlagAtomic != 0 && len(data) > atomicStagePartSize:
+		err = fs.client.PutObjectStaged(ctx, path, data, atomicStagePartSize)
+	default:
+		if reserved != nil {
+			fs.client.AbortMultipartUpload(ctx, reserved.upload)
+		}
+		err = fs.client.PutObject(ctx, path, data)
+	}
 	if err != nil {
 		return 0, err
 	}
+	fs.reservations.Set(path, 0)
 
 	// Invalidate caches
 	parent := getParentPath(path)
@@ -282,25 +465,56 @@ func (fs *S3FS) Write(path string, data []byte, offset int64, flags filesystem.W
 }
 
 func (fs *S3FS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	return fs.ReadDirContext(context.Background(), path)
+}
+
+// ReadDirContext implements filesystem.ContextFileSystem: the S3 requests
+// are cancelled when ctx is done
+func (fs *S3FS) ReadDirContext(ctx context.Context, path string) ([]filesystem.FileInfo, error) {
+	return fs.readDir(ctx, path, false)
+}
+
+// ReadDirNames implements filesystem.NameLister. The listing is requested
+// first, and the directory only checked for existence when it comes back
+// empty, saving a request per listing of a non-empty directory.
+func (fs *S3FS) ReadDirNames(path string) ([]filesystem.FileInfo, error) {
+	files, err := fs.readDir(context.Background(), path, true)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]filesystem.FileInfo, len(files))
+	for i, f := range files {
+		names[i] = filesystem.FileInfo{Name: f.Name, IsDir: f.IsDir}
+	}
+	return names, nil
+}
+
+func (fs *S3FS) readDir(ctx context.Context, path string, listFirst bool) ([]filesystem.FileInfo, error) {
 	path = filesystem.NormalizeS3Key(path)
-	ctx := context.Background()
 
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
 	// Check cache first
 	if cached, ok := fs.dirCache.Get(path); ok {
-		return cached, nil
+		return fs.filterExpired(path, cached), nil
 	}
 
-	// Check if directory exists
-	if path != "" {
+	checkExists := func() error {
 		exists, err := fs.client.DirectoryExists(ctx, path)
 		if err != nil {
-			return nil, fmt.Errorf("failed to check directory: %w", err)
+			return fmt.Errorf("failed to check directory: %w", err)
 		}
 		if !exists {
-			return nil, filesystem.ErrNotFound
+			return filesystem.ErrNotFound
+		}
+		return nil
+	}
+
+	// Check if directory exists
+	if path != "" && !listFirst {
+		if err := checkExists(); err != nil {
+			return nil, err
 		}
 	}
 
@@ -309,6 +523,11 @@ func (fs *S3FS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	if path != "" && listFirst && len(objects) == 0 {
+		if err := checkExists(); err != nil {
+			return nil, err
+		}
+	}
 
 	var files []filesystem.FileInfo
 	for _, obj := range objects {
@@ -332,10 +551,18 @@ func (fs *S3FS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	// Cache the result
 	fs.dirCache.Put(path, files)
 
-	return files, nil
+	return fs.filterExpired(path, files), nil
 }
 
 func (fs *S3FS) Stat(path string) (*filesystem.FileInfo, error) {
+	info, err := fs.stat(path)
+	if err != nil || info.IsDir {
+		return info, err
+	}
+	return fs.withReservation(info, filesystem.NormalizeS3Key(path)), nil
+}
+
+func (fs *S3FS) stat(path string) (*filesystem.FileInfo, error) {
 	path = filesystem.NormalizeS3Key(path)
 	ctx := context.Background()
 
@@ -362,9 +589,13 @@ func (fs *S3FS) Stat(path string) (*filesystem.FileInfo, error) {
 		}, nil
 	}
 
+	if fs.expiry.Expired(path) {
+		return nil, filesystem.ErrNotFound
+	}
+
 	// Check cache first
 	if cached, ok := fs.statCache.Get(path); ok {
-		return cached, nil
+		return fs.withExpiry(cached, path), nil
 	}
 
 	// Try as file first
@@ -386,8 +617,11 @@ func (fs *S3FS) Stat(path string) (*filesystem.FileInfo, error) {
 				},
 			},
 		}
+		if sum, ok := etagMD5(head.ETag); ok {
+			info.Checksum = filesystem.FormatChecksum(filesystem.ChecksumMD5, sum)
+		}
 		fs.statCache.Put(path, info)
-		return info, nil
+		return fs.withExpiry(info, path), nil
 	}
 
 	// Try as directory
@@ -425,17 +659,28 @@ func (fs *S3FS) Rename(oldPath, newPath string) error {
 	newPath = filesystem.NormalizeS3Key(newPath)
 	ctx := context.Background()
 
+	if err := fs.checkWritable(ctx, "rename", oldPath); err != nil {
+		return err
+	}
+
 	fs.mu.Lock()
-	defer fs.mu.Unlock()
+	if fs.expiry.Expired(oldPath) {
+		fs.mu.Unlock()
+		return filesystem.ErrNotFound
+	}
 
 	// Check if old path exists
 	exists, err := fs.client.ObjectExists(ctx, oldPath)
 	if err != nil {
+		fs.mu.Unlock()
 		return fmt.Errorf("failed to check source: %w", err)
 	}
 	if !exists {
-		return filesystem.ErrNotFound
+		// Directory renames move many objects and run without the lock
+		fs.mu.Unlock()
+		return fs.renameDirectory(ctx, oldPath, newPath)
 	}
+	defer fs.mu.Unlock()
 
 	// Get the object
 	data, err := fs.client.GetObject(ctx, oldPath)
@@ -462,6 +707,10 @@ func (fs *S3FS) Rename(oldPath, newPath string) error {
 	fs.dirCache.Invalidate(newParent)
 	fs.statCache.Invalidate(oldPath)
 	fs.statCache.Invalidate(newPath)
+	fs.expiry.Remove(newPath)
+	fs.expiry.Rename(oldPath, newPath)
+	fs.abortReserved(ctx, oldPath)
+	fs.abortReserved(ctx, newPath)
 
 	return nil
 }
@@ -481,21 +730,35 @@ func (fs *S3FS) Open(path string) (io.ReadCloser, error) {
 }
 
 func (fs *S3FS) OpenWrite(path string) (io.WriteCloser, error) {
-	return &s3fsWriter{fs: fs, path: path}, nil
+	// The write of a reserved file streams into its upload
+	reserved := fs.uploads.get(filesystem.NormalizeS3Key(path))
+	return &s3fsWriter{fs: fs, path: path, reserved: reserved}, nil
 }
 
 type s3fsWriter struct {
 	fs   *S3FS
 	path string
 	buf  []byte
+
+	reserved   *reservedUpload // Upload of a reserved file, or nil
+	partNumber int32           // Parts uploaded into reserved
+	err        error           // Why uploading a part failed
 }
 
 func (w *s3fsWriter) Write(p []byte) (n int, err error) {
 	w.buf = append(w.buf, p...)
+	if w.reserved != nil && w.err == nil {
+		if w.err = w.streamReserved(); w.err != nil {
+			return 0, w.err
+		}
+	}
 	return len(p), nil
 }
 
 func (w *s3fsWriter) Close() error {
+	if w.reserved != nil {
+		return w.closeReserved()
+	}
 	_, err := w.fs.Write(w.path, w.buf, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate)
 	return err
 }
@@ -504,6 +767,7 @@ func (w *s3fsWriter) Close() error {
 type S3FSPlugin struct {
 	fs     *S3FS
 	config map[string]interface{}
+	stopCh chan struct{} // Stops the expiry sweeper
 }
 
 // NewS3FSPlugin creates a new S3FS plugin
@@ -520,6 +784,7 @@ func (p *S3FSPlugin) Validate(cfg map[string]interface{}) error {
 	allowedKeys := []string{
 		"bucket", "region", "access_key_id", "secret_access_key", "endpoint", "prefix", "disable_ssl", "mount_path",
 		"cache_enabled", "cache_ttl", "stat_cache_ttl", "cache_max_size", "use_path_request_style",
+		"rename_workers", "rename_async_threshold", "credentials", "object_lock_mode",
 	}
 	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
 		return err
@@ -531,12 +796,23 @@ func (p *S3FSPlugin) Validate(cfg map[string]interface{}) error {
 	}
 
 	// Validate optional string parameters
-	for _, key := range []string{"region", "access_key_id", "secret_access_key", "endpoint", "prefix"} {
+	for _, key := range []string{"region", "access_key_id", "secret_access_key", "endpoint", "prefix", "object_lock_mode"} {
 		if err := config.ValidateStringType(cfg, key); err != nil {
 			return err
 		}
 	}
 
+	// Validate the credential provider
+	if err := config.ValidateMapType(cfg, "credentials"); err != nil {
+		return err
+	}
+	if _, ok := cfg["credentials"]; ok && (cfg["access_key_id"] != nil || cfg["secret_access_key"] != nil) {
+		return fmt.Errorf("credentials cannot be combined with access_key_id and secret_access_key")
+	}
+	if _, err := creds.FromConfig(cfg, "credentials"); err != nil {
+		return err
+	}
+
 	// Validate boolean parameters
 	for _, key := range []string{"disable_ssl", "use_path_request_style", "cache_enabled"} {
 		if err := config.ValidateBoolType(cfg, key); err != nil {
@@ -544,6 +820,22 @@ func (p *S3FSPlugin) Validate(cfg map[string]interface{}) error {
 		}
 	}
 
+	// Validate rename parameters
+	for _, key := range []string{"rename_workers", "rename_async_threshold"} {
+		if err := config.ValidateIntType(cfg, key); err != nil {
+			return err
+		}
+	}
+	if config.GetIntConfig(cfg, "rename_workers", defaultRenameWorkers) <= 0 {
+		return fmt.Errorf("rename_workers must be positive")
+	}
+	if config.GetIntConfig(cfg, "rename_async_threshold", defaultRenameAsyncThreshold) < 0 {
+		return fmt.Errorf("rename_async_threshold must not be negative")
+	}
+	if _, err := parseObjectLockMode(config.GetStringConfig(cfg, "object_lock_mode", "")); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -565,6 +857,11 @@ func (p *S3FSPlugin) Initialize(config map[string]interface{}) error {
 	if cfg.Bucket == "" {
 		return fmt.Errorf("bucket name is required")
 	}
+	credentials, err := creds.FromConfig(config, "credentials")
+	if err != nil {
+		return err
+	}
+	cfg.Credentials = credentials
 
 	// Parse cache configuration
 	cacheCfg := CacheConfig{
@@ -580,6 +877,13 @@ func (p *S3FSPlugin) Initialize(config map[string]interface{}) error {
 		return fmt.Errorf("failed to initialize s3fs: %w", err)
 	}
 	p.fs = fs
+	fs.objectLockMode, _ = parseObjectLockMode(getStringConfig(config, "object_lock_mode", ""))
+	fs.renames.workers = getIntConfig(config, "rename_workers", defaultRenameWorkers)
+	fs.renames.asyncThreshold = getIntConfig(config, "rename_async_threshold", defaultRenameAsyncThreshold)
+
+	p.stopCh = make(chan struct{})
+	filesystem.StartExpirySweeper(fs.expiry, expirySweepInterval, p.stopCh, fs.removeExpired)
+	fs.resumeRenames()
 
 	log.Infof("[s3fs] Initialized with bucket: %s, region: %s, cache: %v", cfg.Bucket, cfg.Region, cacheCfg.Enabled)
 	return nil
@@ -623,6 +927,13 @@ func (p *S3FSPlugin) GetConfigParams() []plugin.ConfigParameter {
 			Default:     "",
 			Description: "AWS secret access key (uses env AWS_SECRET_ACCESS_KEY if not provided)",
 		},
+		{
+			Name:        "credentials",
+			Type:        "map",
+			Required:    false,
+			Default:     "",
+			Description: "Credential provider instead of static keys, e.g. {provider: aws} or {provider: vault, path: aws/creds/agfs}",
+		},
 		{
 			Name:        "endpoint",
 			Type:        "string",
@@ -679,10 +990,38 @@ func (p *S3FSPlugin) GetConfigParams() []plugin.ConfigParameter {
 			Default:     "1000",
 			Description: "Maximum number of entries in each cache",
 		},
+		{
+			Name:        "rename_workers",
+			Type:        "int",
+			Required:    false,
+			Default:     "16",
+			Description: "Concurrent server-side copies when renaming a directory",
+		},
+		{
+			Name:        "rename_async_threshold",
+			Type:        "int",
+			Required:    false,
+			Default:     "10000",
+			Description: "Directory renames of more objects continue in the background (0 = always wait)",
+		},
+		{
+			Name:        "object_lock_mode",
+			Type:        "string",
+			Required:    false,
+			Default:     "",
+			Description: "S3 Object Lock mode (GOVERNANCE or COMPLIANCE) backing the retention of files on mounts with retention; the bucket must have Object Lock enabled",
+		},
 	}
 }
 
 func (p *S3FSPlugin) Shutdown() error {
+	if p.stopCh != nil {
+		close(p.stopCh)
+		p.stopCh = nil
+	}
+	if p.fs != nil {
+		p.fs.renames.stop()
+	}
 	return nil
 }
 
@@ -814,6 +1153,10 @@ EXAMPLES:
   # Move/rename
   agfs:/> mv /s3fs/documents/report.txt /s3fs/documents/report-2024.txt
 
+  # Rename a directory; progress is recorded in .renames/<id>.json
+  agfs:/> mv /s3fs/documents /s3fs/archive
+  agfs:/> cat /s3fs/.renames/*.json
+
   # Stream large files efficiently
   agfs:/> cat --stream /s3fs/videos/movie.mp4 > local-movie.mp4
   # Streams in 256KB chunks, minimal memory usage
@@ -823,6 +1166,14 @@ NOTES:
   - Use --stream flag for large files to minimize memory usage (256KB chunks)
   - Permissions (chmod) are not supported by S3
   - Atomic operations are limited by S3's eventual consistency model
+  - Directory renames move objects in batches of parallel server-side copies
+    (rename_workers = 16). Renames of more than rename_async_threshold
+    (10000) objects continue in the background. Each rename has a manifest
+    at .renames/<id>.json; interrupted renames resume on restart, failed
+    ones when the same mv is issued again.
+  - On a mount with retention: true, object_lock_mode (GOVERNANCE or
+    COMPLIANCE) also gives retained files an S3 Object Lock retention and
+    legal hold. The bucket must have Object Lock enabled.
   - Streaming is automatically used when accessing via Python SDK with stream=True
 
 PREFIX ISOLATION:
@@ -975,6 +1326,10 @@ func (fs *S3FS) OpenStream(path string) (filesystem.StreamReader, error) {
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
+	if fs.expiry.Expired(path) {
+		return nil, filesystem.ErrNotFound
+	}
+
 	// Get streaming reader from S3
 	body, err := fs.client.GetObjectStream(ctx, path)
 	if err != nil {
@@ -998,6 +1353,10 @@ func (fs *S3FS) Truncate(path string, size int64) error {
 	path = filesystem.NormalizeS3Key(path)
 	ctx := context.Background()
 
+	if err := fs.checkWritable(ctx, "truncate", path); err != nil {
+		return err
+	}
+
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
@@ -1006,6 +1365,10 @@ func (fs *S3FS) Truncate(path string, size int64) error {
 		return fmt.Errorf("is a directory: %s", path)
 	}
 
+	if fs.expiry.Expired(path) {
+		return filesystem.ErrNotFound
+	}
+
 	// Check if file exists and get current content
 	exists, err := fs.client.ObjectExists(ctx, path)
 	if err != nil {
@@ -1054,8 +1417,49 @@ func (fs *S3FS) Truncate(path string, size int64) error {
 	return nil
 }
 
+// Checksum implements filesystem.Checksummer.
+// MD5 is served from the object's ETag when it is a plain content hash;
+// other algorithms (and multipart/encrypted objects) fall back to streaming.
+func (fs *S3FS) Checksum(path string, algorithm string) (string, error) {
+	if algorithm != filesystem.ChecksumMD5 {
+		return "", filesystem.ErrNotSupported
+	}
+
+	info, err := fs.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir {
+		return "", fmt.Errorf("is a directory: %s", path)
+	}
+	if sum, ok := strings.CutPrefix(info.Checksum, filesystem.ChecksumMD5+":"); ok {
+		return sum, nil
+	}
+	return "", filesystem.ErrNotSupported
+}
+
+// etagMD5 returns the MD5 hex digest carried by an ETag. Multipart and
+// SSE-KMS ETags are not content hashes and are rejected.
+func etagMD5(etag *string) (string, bool) {
+	if etag == nil {
+		return "", false
+	}
+	sum := strings.Trim(*etag, "\"")
+	if len(sum) != 32 || strings.Contains(sum, "-") {
+		return "", false
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", false
+	}
+	return strings.ToLower(sum), true
+}
+
 // Ensure S3FSPlugin implements ServicePlugin
 var _ plugin.ServicePlugin = (*S3FSPlugin)(nil)
 var _ filesystem.FileSystem = (*S3FS)(nil)
 var _ filesystem.Streamer = (*S3FS)(nil)
+var _ filesystem.Checksummer = (*S3FS)(nil)
 var _ filesystem.Truncater = (*S3FS)(nil)
+var _ filesystem.Expirer = (*S3FS)(nil)
+var _ filesystem.ContextFileSystem = (*S3FS)(nil)
+var _ filesystem.NameLister = (*S3FS)(nil)
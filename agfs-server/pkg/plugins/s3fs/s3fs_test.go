@@ -1,9 +1,16 @@
 package s3fs
 
 import (
+	"context"
+	"errors"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 )
 
@@ -406,3 +413,124 @@ func TestPrefixIsolation(t *testing.T) {
 		}
 	})
 }
+
+// TestRenameDirectory tests batched directory renames, both waiting for the
+// rename and running it in the background
+func TestRenameDirectory(t *testing.T) {
+	fs := newTestFS(t)
+	defer fs.RemoveAll("/rename_src")
+	defer fs.RemoveAll("/rename_dst")
+	defer fs.RemoveAll("/rename_async")
+	defer fs.RemoveAll(renameManifestDir)
+
+	files := map[string]string{
+		"/a.txt":       "a",
+		"/sub/b.txt":   "b",
+		"/sub/c/d.txt": "d",
+	}
+	for name, content := range files {
+		if _, err := fs.Write("/rename_src"+name, []byte(content), -1, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+
+	if err := fs.Rename("/rename_src", "/rename_src/sub/x"); err == nil {
+		t.Error("moving a directory into itself should fail")
+	}
+
+	if err := fs.Rename("/rename_src", "/rename_dst"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	for name, content := range files {
+		data, err := readIgnoreEOF(fs, "/rename_dst"+name)
+		if err != nil || string(data) != content {
+			t.Errorf("/rename_dst%s = %q, %v; want %q", name, data, err, content)
+		}
+	}
+	if _, err := fs.Stat("/rename_src"); err == nil {
+		t.Error("source directory should be gone after rename")
+	}
+
+	// Every object above the threshold makes the rename run in the background
+	fs.renames.asyncThreshold = 1
+	defer func() { fs.renames.asyncThreshold = defaultRenameAsyncThreshold }()
+	if err := fs.Rename("/rename_dst", "/rename_async"); err != nil {
+		t.Fatalf("async Rename failed: %v", err)
+	}
+
+	// Follow the progress in the manifest
+	id := renameManifestID("rename_dst", "rename_async")
+	var m *renameManifest
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		var err error
+		m, err = fs.loadRenameManifest(context.Background(), id)
+		if err != nil || m == nil {
+			t.Fatalf("rename manifest = %v, %v", m, err)
+		}
+		if m.Status != renameStatusRunning {
+			break
+		}
+	}
+	if m.Status != renameStatusCompleted || m.Moved != m.Total || !m.Async {
+		t.Errorf("manifest = %+v, want a completed async rename", m)
+	}
+	for name, content := range files {
+		data, err := readIgnoreEOF(fs, "/rename_async"+name)
+		if err != nil || string(data) != content {
+			t.Errorf("/rename_async%s = %q, %v; want %q", name, data, err, content)
+		}
+	}
+}
+
+func TestReadOnlyWhileCredentialsExpired(t *testing.T) {
+	var expires atomic.Pointer[time.Time]
+	past := time.Now().Add(-time.Minute)
+	expires.Store(&past)
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "id", SecretAccessKey: "secret", CanExpire: true, Expires: *expires.Load()}, nil
+	})
+	fs := &S3FS{client: &S3Client{credentials: newCredentialWatch(provider)}, expiry: filesystem.NewExpiryIndex()}
+
+	// Changes are refused before reaching the bucket
+	if _, err := fs.Write("/a.txt", []byte("x"), 0, filesystem.WriteFlagCreate); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Write() error = %v, want ErrPermissionDenied", err)
+	}
+	for name, err := range map[string]error{
+		"Mkdir":     fs.Mkdir("/dir", 0755),
+		"Remove":    fs.Remove("/a.txt"),
+		"RemoveAll": fs.RemoveAll("/dir"),
+		"Rename":    fs.Rename("/a.txt", "/b.txt"),
+	} {
+		if !errors.Is(err, filesystem.ErrPermissionDenied) {
+			t.Errorf("%s() error = %v, want ErrPermissionDenied", name, err)
+		}
+	}
+	h := fs.Health()
+	if h.Mode != modeReadOnly || h.Degradations != 1 || !strings.Contains(h.Reason, "expired") {
+		t.Errorf("Health() = %+v, want %s", h, modeReadOnly)
+	}
+
+	// Renewed credentials are picked up at the next check
+	future := time.Now().Add(time.Hour)
+	expires.Store(&future)
+	if err := fs.checkWritable(context.Background(), "write", "/a.txt"); err == nil {
+		t.Error("checkWritable() succeeded before the next credentials check")
+	}
+	fs.client.credentials.checkedAt = time.Time{}
+	if err := fs.checkWritable(context.Background(), "write", "/a.txt"); err != nil {
+		t.Errorf("checkWritable() after renewal error = %v", err)
+	}
+	if h := fs.Health(); h.Degraded() || h.Degradations != 1 {
+		t.Errorf("Health() = %+v after renewal", h)
+	}
+
+	// S3 rejecting the credentials switches to read-only at once
+	fs.client.credentials.observe(&smithy.GenericAPIError{Code: "NoSuchKey"})
+	if fs.Health().Degraded() {
+		t.Error("Health() degraded by an error unrelated to credentials")
+	}
+	fs.client.credentials.observe(&smithy.GenericAPIError{Code: "ExpiredToken", Message: "token expired"})
+	if h := fs.Health(); h.Mode != modeReadOnly || h.Degradations != 2 {
+		t.Errorf("Health() = %+v after ExpiredToken, want %s", h, modeReadOnly)
+	}
+}
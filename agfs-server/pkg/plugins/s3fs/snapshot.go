@@ -0,0 +1,183 @@
+package s3fs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// snapshotPrefix is the key prefix of snapshot id
+func snapshotPrefix(id string) string {
+	return filesystem.SnapshotsDir + "/" + id
+}
+
+// CreateSnapshot copies every object to the .snapshots/<id>/ prefix with
+// server-side copies; object data does not pass through the server
+func (fs *S3FS) CreateSnapshot(path string) (*filesystem.Snapshot, error) {
+	if err := filesystem.CheckSnapshotRoot(path); err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+
+	if err := fs.checkWritable(ctx, "create", path); err != nil {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	existing, err := fs.snapshotIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	id := filesystem.NewSnapshotID(now)
+	for existing[id] {
+		now = now.Add(time.Nanosecond)
+		id = filesystem.NewSnapshotID(now)
+	}
+
+	objects, err := fs.client.ListObjectsRecursive(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	prefix := snapshotPrefix(id)
+	for _, obj := range objects {
+		if obj.Key == filesystem.SnapshotsDir || strings.HasPrefix(obj.Key, filesystem.SnapshotsDir+"/") {
+			continue
+		}
+		if fs.expiry.Expired(obj.Key) {
+			continue
+		}
+		if obj.IsDir {
+			err = fs.client.CreateDirectory(ctx, prefix+"/"+obj.Key)
+		} else {
+			err = fs.client.CopyObject(ctx, obj.Key, prefix+"/"+obj.Key)
+		}
+		if err != nil {
+			fs.client.DeleteDirectory(ctx, prefix)
+			return nil, fmt.Errorf("failed to create snapshot: %w", err)
+		}
+	}
+
+	// The marker is written last, so a snapshot is only listed once complete
+	if err := fs.client.CreateDirectory(ctx, prefix); err != nil {
+		fs.client.DeleteDirectory(ctx, prefix)
+		return nil, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	fs.dirCache.Invalidate("")
+	fs.dirCache.Invalidate(filesystem.SnapshotsDir)
+
+	return &filesystem.Snapshot{ID: id, CreatedAt: now.UTC()}, nil
+}
+
+// snapshotIDs returns the IDs of all complete snapshots
+func (fs *S3FS) snapshotIDs(ctx context.Context) (map[string]bool, error) {
+	objects, err := fs.client.ListObjects(ctx, filesystem.SnapshotsDir)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for _, obj := range objects {
+		if !obj.IsDir {
+			continue
+		}
+		if _, err := filesystem.ParseSnapshotID(obj.Key); err != nil {
+			continue
+		}
+		if ok, err := fs.client.ObjectExists(ctx, snapshotPrefix(obj.Key)+"/"); err == nil && ok {
+			ids[obj.Key] = true
+		}
+	}
+	return ids, nil
+}
+
+// ListSnapshots returns the snapshots, oldest first
+func (fs *S3FS) ListSnapshots(path string) ([]filesystem.Snapshot, error) {
+	if err := filesystem.CheckSnapshotRoot(path); err != nil {
+		return nil, err
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	ids, err := fs.snapshotIDs(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]filesystem.Snapshot, 0, len(ids))
+	for id := range ids {
+		createdAt, _ := filesystem.ParseSnapshotID(id)
+		snapshots = append(snapshots, filesystem.Snapshot{ID: id, CreatedAt: createdAt})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].ID < snapshots[j].ID })
+	return snapshots, nil
+}
+
+// DeleteSnapshot deletes every object under the snapshot's prefix
+func (fs *S3FS) DeleteSnapshot(path string, id string) error {
+	if err := filesystem.CheckSnapshotRoot(path); err != nil {
+		return err
+	}
+	if _, err := filesystem.ParseSnapshotID(id); err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	if err := fs.checkWritable(ctx, "remove", path); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	exists, err := fs.client.DirectoryExists(ctx, snapshotPrefix(id))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return filesystem.NewNotFoundError("snapshot", id)
+	}
+	if err := fs.client.DeleteDirectory(ctx, snapshotPrefix(id)); err != nil {
+		return err
+	}
+	fs.dirCache.Invalidate(filesystem.SnapshotsDir)
+	return nil
+}
+
+// OpenSnapshot returns a read-only S3FS over the snapshot's prefix
+func (fs *S3FS) OpenSnapshot(path string, id string) (filesystem.FileSystem, error) {
+	if err := filesystem.CheckSnapshotRoot(path); err != nil {
+		return nil, err
+	}
+	if _, err := filesystem.ParseSnapshotID(id); err != nil {
+		return nil, err
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	exists, err := fs.client.DirectoryExists(context.Background(), snapshotPrefix(id))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, filesystem.NewNotFoundError("snapshot", id)
+	}
+
+	// Snapshots never change, but views are short-lived; skip the caches
+	view := &S3FS{
+		client:     fs.client.WithSubPrefix(snapshotPrefix(id)),
+		pluginName: fs.pluginName,
+		dirCache:   NewListDirCache(0, 0, false),
+		statCache:  NewStatCache(0, 0, false),
+		expiry:     filesystem.NewExpiryIndex(),
+	}
+	return filesystem.NewReadOnlyFileSystem(view), nil
+}
+
+var _ filesystem.Snapshotter = (*S3FS)(nil)
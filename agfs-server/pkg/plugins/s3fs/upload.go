@@ -0,0 +1,143 @@
+package s3fs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// s3MinPartSize is the smallest part S3 accepts, but for the last one
+const s3MinPartSize = 5 << 20
+
+// s3Upload maps an upload onto a multipart upload, part i being uploaded as
+// part number i+1 as soon as it is written
+type s3Upload struct {
+	fs       *S3FS
+	path     string
+	partSize int64
+	upload   *MultipartUpload
+
+	mu    sync.Mutex
+	parts map[int32]types.CompletedPart
+}
+
+// BeginUpload implements filesystem.Uploader. Parts must be at least 5 MiB,
+// the smallest S3 accepts.
+func (fs *S3FS) BeginUpload(path string, partSize int64) (filesystem.Upload, error) {
+	if err := filesystem.ValidateUploadPartSize(partSize); err != nil {
+		return nil, err
+	}
+	if partSize < s3MinPartSize {
+		return nil, filesystem.NewInvalidArgumentError("part_size", partSize,
+			fmt.Sprintf("must be at least %d bytes on S3", s3MinPartSize))
+	}
+	path = filesystem.NormalizeS3Key(path)
+	ctx := context.Background()
+
+	if err := fs.checkWritable(ctx, "upload", path); err != nil {
+		return nil, err
+	}
+	if path == "" || strings.HasSuffix(path, "/") {
+		return nil, fmt.Errorf("is a directory: %s", path)
+	}
+	if parent := getParentPath(path); parent != "" {
+		dirExists, err := fs.client.DirectoryExists(ctx, parent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check parent directory: %w", err)
+		}
+		if !dirExists {
+			return nil, fmt.Errorf("parent directory does not exist: %s", parent)
+		}
+	}
+
+	upload, err := fs.client.CreateMultipartUpload(ctx, fs.client.buildKey(path))
+	if err != nil {
+		return nil, err
+	}
+	return &s3Upload{
+		fs:       fs,
+		path:     path,
+		partSize: partSize,
+		upload:   upload,
+		parts:    make(map[int32]types.CompletedPart),
+	}, nil
+}
+
+func (u *s3Upload) WritePart(i int, data []byte) error {
+	partNumber := int32(i + 1)
+	part, err := u.fs.client.uploadPart(context.Background(), u.upload, partNumber, data)
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.parts[partNumber] = part
+	return nil
+}
+
+// Commit completes the multipart upload with the parts holding the first
+// size bytes. S3 rejects uploads without parts, so an empty file is put
+// instead.
+func (u *s3Upload) Commit(size int64) error {
+	fs := u.fs
+	ctx := context.Background()
+
+	if err := fs.checkWritable(ctx, "upload", u.path); err != nil {
+		u.Abort()
+		return err
+	}
+
+	u.mu.Lock()
+	n := int32((size + u.partSize - 1) / u.partSize)
+	parts := make([]types.CompletedPart, 0, n)
+	for partNumber := int32(1); partNumber <= n; partNumber++ {
+		part, ok := u.parts[partNumber]
+		if !ok {
+			u.mu.Unlock()
+			u.Abort()
+			return fmt.Errorf("part %d of %s was not uploaded", partNumber-1, u.path)
+		}
+		parts = append(parts, part)
+	}
+	u.mu.Unlock()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// An expired object is replaced by a new one without a TTL
+	if fs.expiry.Expired(u.path) {
+		fs.expiry.Set(u.path, time.Time{})
+	}
+
+	var err error
+	if n == 0 {
+		fs.client.AbortMultipartUpload(ctx, u.upload)
+		err = fs.client.PutObject(ctx, u.path, []byte{})
+	} else {
+		u.upload.Parts = parts
+		if err = fs.client.CompleteMultipartUpload(ctx, u.upload); err != nil {
+			fs.client.AbortMultipartUpload(ctx, u.upload)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	// The upload replaces a reserved file, and the upload of its write
+	fs.abortReserved(ctx, u.path)
+
+	fs.dirCache.Invalidate(getParentPath(u.path))
+	fs.statCache.Invalidate(u.path)
+	return nil
+}
+
+func (u *s3Upload) Abort() error {
+	return u.fs.client.AbortMultipartUpload(context.Background(), u.upload)
+}
+
+// Ensure S3FS implements Uploader interface
+var _ filesystem.Uploader = (*S3FS)(nil)
@@ -18,6 +18,11 @@ type ServerInfoFSPlugin struct {
 	startTime      time.Time
 	version        string
 	trafficMonitor TrafficStatsProvider
+	breakerStats   func() interface{}
+	shadowStats    func() interface{}
+	replicaStats   func() interface{}
+	pendingStats   func() interface{}
+	healthStats    func() interface{}
 }
 
 // TrafficStatsProvider provides traffic statistics
@@ -38,6 +43,36 @@ func (p *ServerInfoFSPlugin) SetTrafficMonitor(tm TrafficStatsProvider) {
 	p.trafficMonitor = tm
 }
 
+// SetBreakerStats sets the function reporting the state of the mounts'
+// circuit breakers
+func (p *ServerInfoFSPlugin) SetBreakerStats(stats func() interface{}) {
+	p.breakerStats = stats
+}
+
+// SetShadowStats sets the function reporting the mounts being mirrored to
+// new backends
+func (p *ServerInfoFSPlugin) SetShadowStats(stats func() interface{}) {
+	p.shadowStats = stats
+}
+
+// SetReplicaStats sets the function reporting the mounts whose hot files
+// are read from replicas
+func (p *ServerInfoFSPlugin) SetReplicaStats(stats func() interface{}) {
+	p.replicaStats = stats
+}
+
+// SetPendingStats sets the function reporting the changes of write-back
+// mounts waiting to be applied to their backends
+func (p *ServerInfoFSPlugin) SetPendingStats(stats func() interface{}) {
+	p.pendingStats = stats
+}
+
+// SetHealthStats sets the function reporting the modes of the mounts whose
+// plugins declare degraded modes
+func (p *ServerInfoFSPlugin) SetHealthStats(stats func() interface{}) {
+	p.healthStats = stats
+}
+
 func (p *ServerInfoFSPlugin) Name() string {
 	return "serverinfofs"
 }
@@ -87,12 +122,32 @@ USAGE:
   View real-time traffic:
     cat /traffic
 
+  View circuit breakers:
+    cat /breakers
+
+  View shadowed mounts:
+    cat /shadows
+
+  View replicated mounts:
+    cat /replicas
+
+  View changes waiting to be written back:
+    cat /pending
+
+  View degraded modes of mounts:
+    cat /health
+
 FILES:
   /version  - Server version information
   /uptime   - Server uptime since start
   /info     - Complete server information (JSON)
   /stats    - Runtime statistics (goroutines, memory)
   /traffic  - Real-time network traffic statistics
+  /breakers - Circuit breaker state, trips and rejections per mount
+  /shadows  - Mirrored operations and divergences of shadowed mounts
+  /replicas - Hot files and reads served by the replicas of mounts
+  /pending  - Journaled changes of write-back mounts not applied yet
+  /health   - Mode, reason and degradation count of mounts with degraded modes
   /README   - This file
 
 EXAMPLES:
@@ -124,6 +179,20 @@ EXAMPLES:
     "total_upload_bytes": 536870912,
     "uptime_seconds": 3600
   }
+
+  # Check for failing backends
+  agfs:/> cat /serverinfofs/breakers
+  [
+    {
+      "path": "/s3",
+      "state": "open",
+      "consecutiveFailures": 5,
+      "trips": 1,
+      "rejected": 42,
+      "lastError": "connection refused",
+      "retryAt": "2025-01-01T12:00:30Z"
+    }
+  ]
 `
 }
 
@@ -147,12 +216,17 @@ const (
 	fileVersion    = "/version"
 	fileStats      = "/stats"
 	fileTraffic    = "/traffic"
+	fileBreakers   = "/breakers"
+	fileShadows    = "/shadows"
+	fileReplicas   = "/replicas"
+	filePending    = "/pending"
+	fileHealth     = "/health"
 	fileReadme     = "/README"
 )
 
 func (fs *serverInfoFS) isValidPath(path string) bool {
 	switch path {
-	case "/", fileServerInfo, fileUptime, fileVersion, fileStats, fileTraffic, fileReadme:
+	case "/", fileServerInfo, fileUptime, fileVersion, fileStats, fileTraffic, fileBreakers, fileShadows, fileReplicas, filePending, fileHealth, fileReadme:
 		return true
 	default:
 		return false
@@ -235,6 +309,56 @@ func (fs *serverInfoFS) Read(path string, offset int64, size int64) ([]byte, err
 			}
 		}
 
+	case fileBreakers:
+		if fs.plugin.breakerStats == nil {
+			data = []byte("Circuit breakers not available")
+		} else {
+			data, err = json.MarshalIndent(fs.plugin.breakerStats(), "", "  ")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case fileShadows:
+		if fs.plugin.shadowStats == nil {
+			data = []byte("Shadowing not available")
+		} else {
+			data, err = json.MarshalIndent(fs.plugin.shadowStats(), "", "  ")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case fileReplicas:
+		if fs.plugin.replicaStats == nil {
+			data = []byte("Replicas not available")
+		} else {
+			data, err = json.MarshalIndent(fs.plugin.replicaStats(), "", "  ")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case filePending:
+		if fs.plugin.pendingStats == nil {
+			data = []byte("Write-back not available")
+		} else {
+			data, err = json.MarshalIndent(fs.plugin.pendingStats(), "", "  ")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case fileHealth:
+		if fs.plugin.healthStats == nil {
+			data = []byte("Mount health not available")
+		} else {
+			data, err = json.MarshalIndent(fs.plugin.healthStats(), "", "  ")
+			if err != nil {
+				return nil, err
+			}
+		}
+
 	case fileReadme:
 		data = []byte(fs.plugin.GetReadme())
 
@@ -284,6 +408,11 @@ func (fs *serverInfoFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	versionData, _ := fs.Read(fileVersion, 0, -1)
 	statsData, _ := fs.Read(fileStats, 0, -1)
 	trafficData, _ := fs.Read(fileTraffic, 0, -1)
+	breakersData, _ := fs.Read(fileBreakers, 0, -1)
+	shadowsData, _ := fs.Read(fileShadows, 0, -1)
+	replicasData, _ := fs.Read(fileReplicas, 0, -1)
+	pendingData, _ := fs.Read(filePending, 0, -1)
+	healthData, _ := fs.Read(fileHealth, 0, -1)
 
 	return []filesystem.FileInfo{
 		{
@@ -334,6 +463,46 @@ func (fs *serverInfoFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 			IsDir:   false,
 			Meta:    filesystem.MetaData{Name: "serverinfofs", Type: "traffic"},
 		},
+		{
+			Name:    "breakers",
+			Size:    int64(len(breakersData)),
+			Mode:    0444,
+			ModTime: now,
+			IsDir:   false,
+			Meta:    filesystem.MetaData{Name: "serverinfofs", Type: "info"},
+		},
+		{
+			Name:    "shadows",
+			Size:    int64(len(shadowsData)),
+			Mode:    0444,
+			ModTime: now,
+			IsDir:   false,
+			Meta:    filesystem.MetaData{Name: "serverinfofs", Type: "info"},
+		},
+		{
+			Name:    "replicas",
+			Size:    int64(len(replicasData)),
+			Mode:    0444,
+			ModTime: now,
+			IsDir:   false,
+			Meta:    filesystem.MetaData{Name: "serverinfofs", Type: "info"},
+		},
+		{
+			Name:    "pending",
+			Size:    int64(len(pendingData)),
+			Mode:    0444,
+			ModTime: now,
+			IsDir:   false,
+			Meta:    filesystem.MetaData{Name: "serverinfofs", Type: "info"},
+		},
+		{
+			Name:    "health",
+			Size:    int64(len(healthData)),
+			Mode:    0444,
+			ModTime: now,
+			IsDir:   false,
+			Meta:    filesystem.MetaData{Name: "serverinfofs", Type: "info"},
+		},
 	}, nil
 }
 
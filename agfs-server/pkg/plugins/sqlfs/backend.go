@@ -7,6 +7,8 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/c4pt0r/agfs/agfs-server/pkg/creds"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/migrate"
 	"github.com/go-sql-driver/mysql"
 	_ "github.com/go-sql-driver/mysql" // MySQL/TiDB driver
 	log "github.com/sirupsen/logrus"
@@ -23,11 +25,18 @@ type DBBackend interface {
 	// GetInitSQL returns the SQL statements to initialize the schema
 	GetInitSQL() []string
 
+	// GetDialect returns the SQL dialect, to apply schema migrations
+	GetDialect() migrate.Dialect
+
 	// SupportsTxIsolation returns whether the backend supports transaction isolation levels
 	SupportsTxIsolation() bool
 
 	// GetOptimizationSQL returns SQL statements for optimization (e.g., PRAGMA for SQLite)
 	GetOptimizationSQL() []string
+
+	// GetTableColumns returns the column names of a table in the current
+	// database, or none if it doesn't exist
+	GetTableColumns(db *sql.DB, table string) ([]string, error)
 }
 
 // SQLiteBackend implements DBBackend for SQLite
@@ -69,6 +78,10 @@ func (b *SQLiteBackend) GetInitSQL() []string {
 	}
 }
 
+func (b *SQLiteBackend) GetDialect() migrate.Dialect {
+	return migrate.SQLite
+}
+
 func (b *SQLiteBackend) GetOptimizationSQL() []string {
 	return []string{
 		"PRAGMA journal_mode=WAL",
@@ -81,11 +94,24 @@ func (b *SQLiteBackend) SupportsTxIsolation() bool {
 	return false
 }
 
-// TiDBBackend implements DBBackend for TiDB
-type TiDBBackend struct{}
+func (b *SQLiteBackend) GetTableColumns(db *sql.DB, table string) ([]string, error) {
+	return queryColumns(db, "SELECT name FROM pragma_table_info(?)", table)
+}
+
+// TiDBBackend implements DBBackend for TiDB, and for MySQL, which differs
+// only in defaults
+type TiDBBackend struct {
+	name        string // For logs: "TiDB" or "MySQL"
+	defaultPort string
+}
 
 func NewTiDBBackend() *TiDBBackend {
-	return &TiDBBackend{}
+	return &TiDBBackend{name: "TiDB", defaultPort: "4000"}
+}
+
+// NewMySQLBackend creates the backend of MySQL servers
+func NewMySQLBackend() *TiDBBackend {
+	return &TiDBBackend{name: "MySQL", defaultPort: "3306"}
 }
 
 func (b *TiDBBackend) GetDriverName() string {
@@ -151,7 +177,7 @@ func (b *TiDBBackend) Open(config map[string]interface{}) (*sql.DB, error) {
 		user := getStringConfig(config, "user", "root")
 		password := getStringConfig(config, "password", "")
 		host := getStringConfig(config, "host", "127.0.0.1")
-		port := getStringConfig(config, "port", "4000")
+		port := getPortConfig(config, b.defaultPort)
 		database := getStringConfig(config, "database", "sqlfs")
 
 		// Build base DSN
@@ -169,7 +195,12 @@ func (b *TiDBBackend) Open(config map[string]interface{}) (*sql.DB, error) {
 		}
 	}
 
-	log.Infof("[sqlfs] Connecting to TiDB (TLS: %v)", enableTLS)
+	credentials, err := creds.FromConfig(config, "credentials")
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("[sqlfs] Connecting to %s (TLS: %v)", b.name, enableTLS)
 
 	// Extract database name to create it if needed
 	dbName := extractDatabaseName(dsn, getStringConfig(config, "database", ""))
@@ -178,7 +209,7 @@ func (b *TiDBBackend) Open(config map[string]interface{}) (*sql.DB, error) {
 	if dbName != "" {
 		dsnWithoutDB := removeDatabaseFromDSN(dsn)
 		if dsnWithoutDB != dsn {
-			tempDB, err := sql.Open("mysql", dsnWithoutDB)
+			tempDB, err := openMySQL(dsnWithoutDB, credentials)
 			defer tempDB.Close()
 			if err == nil {
 				// Try to create database if it doesn't exist
@@ -191,9 +222,9 @@ func (b *TiDBBackend) Open(config map[string]interface{}) (*sql.DB, error) {
 		}
 	}
 
-	db, err := sql.Open("mysql", dsn)
+	db, err := openMySQL(dsn, credentials)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open TiDB database: %w", err)
+		return nil, fmt.Errorf("failed to open %s database: %w", b.name, err)
 	}
 
 	// Set connection pool parameters
@@ -204,6 +235,21 @@ func (b *TiDBBackend) Open(config map[string]interface{}) (*sql.DB, error) {
 	return db, nil
 }
 
+// openMySQL opens a MySQL DSN, logging in with the username and password
+// of a credential provider if there is one
+func openMySQL(dsn string, credentials *creds.Cache) (*sql.DB, error) {
+	if credentials == nil {
+		return sql.Open("mysql", dsn)
+	}
+	connector, err := credentials.MySQLConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	db.SetConnMaxLifetime(creds.ConnMaxLifetime)
+	return db, nil
+}
+
 // extractDatabaseName extracts database name from DSN or config
 func extractDatabaseName(dsn string, configDB string) string {
 	if dsn != "" {
@@ -237,6 +283,10 @@ func (b *TiDBBackend) GetInitSQL() []string {
 	}
 }
 
+func (b *TiDBBackend) GetDialect() migrate.Dialect {
+	return migrate.MySQL
+}
+
 func (b *TiDBBackend) GetOptimizationSQL() []string {
 	// TiDB doesn't need special optimization SQL
 	return []string{}
@@ -246,6 +296,31 @@ func (b *TiDBBackend) SupportsTxIsolation() bool {
 	return true
 }
 
+func (b *TiDBBackend) GetTableColumns(db *sql.DB, table string) ([]string, error) {
+	return queryColumns(db,
+		"SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION",
+		table)
+}
+
+// queryColumns runs a query returning one column name per row
+func queryColumns(db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get table columns: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
 // getStringConfig retrieves a string value from config map with default
 func getStringConfig(config map[string]interface{}, key, defaultValue string) string {
 	if val, ok := config[key].(string); ok && val != "" {
@@ -254,6 +329,19 @@ func getStringConfig(config map[string]interface{}, key, defaultValue string) st
 	return defaultValue
 }
 
+// getPortConfig retrieves the port, which may be given as a string or a number
+func getPortConfig(config map[string]interface{}, defaultValue string) string {
+	switch v := config["port"].(type) {
+	case string:
+		if v != "" {
+			return v
+		}
+	case int, int64, float64:
+		return fmt.Sprint(v)
+	}
+	return defaultValue
+}
+
 // getBoolConfig retrieves a boolean value from config map with default
 func getBoolConfig(config map[string]interface{}, key string, defaultValue bool) bool {
 	if val, ok := config[key].(bool); ok {
@@ -269,8 +357,12 @@ func CreateBackend(config map[string]interface{}) (DBBackend, error) {
 	switch backendType {
 	case "sqlite", "sqlite3":
 		return NewSQLiteBackend(), nil
-	case "tidb", "mysql":
+	case "tidb":
 		return NewTiDBBackend(), nil
+	case "mysql":
+		return NewMySQLBackend(), nil
+	case "postgres", "postgresql":
+		return NewPostgresBackend(), nil
 	default:
 		return nil, fmt.Errorf("unsupported database backend: %s", backendType)
 	}
@@ -0,0 +1,120 @@
+package sqlfs
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/migrate"
+	_ "github.com/lib/pq" // Postgres driver
+	log "github.com/sirupsen/logrus"
+)
+
+// pgDriverNames are the database/sql names Postgres drivers register under,
+// in order of preference. lib/pq is always linked; pgx is used instead if a
+// binary registers it.
+var pgDriverNames = []string{"pgx", "postgres"}
+
+// PostgresBackend implements DBBackend for PostgreSQL
+type PostgresBackend struct {
+	driver string // Registered driver, found when opening
+}
+
+func NewPostgresBackend() *PostgresBackend {
+	return &PostgresBackend{}
+}
+
+func (b *PostgresBackend) GetDriverName() string {
+	if b.driver == "" {
+		return "postgres"
+	}
+	return b.driver
+}
+
+// pgDriver returns the name of a registered Postgres driver
+func pgDriver() (string, error) {
+	registered := make(map[string]bool)
+	for _, name := range sql.Drivers() {
+		registered[name] = true
+	}
+	for _, name := range pgDriverNames {
+		if registered[name] {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no Postgres driver is registered")
+}
+
+// postgresDSN returns the dsn config, or a postgres:// URL built from the
+// individual parameters
+func postgresDSN(config map[string]interface{}) string {
+	if dsn := getStringConfig(config, "dsn", ""); dsn != "" {
+		return dsn
+	}
+	u := &url.URL{
+		Scheme: "postgres",
+		Host:   net.JoinHostPort(getStringConfig(config, "host", "127.0.0.1"), getPortConfig(config, "5432")),
+		Path:   "/" + getStringConfig(config, "database", "sqlfs"),
+	}
+	user := getStringConfig(config, "user", "postgres")
+	if password := getStringConfig(config, "password", ""); password != "" {
+		u.User = url.UserPassword(user, password)
+	} else {
+		u.User = url.User(user)
+	}
+	return u.String()
+}
+
+func (b *PostgresBackend) Open(config map[string]interface{}) (*sql.DB, error) {
+	driver, err := pgDriver()
+	if err != nil {
+		return nil, err
+	}
+	b.driver = driver
+
+	log.Infof("[sqlfs] Connecting to Postgres (driver: %s)", driver)
+	db, err := sql.Open(driver, postgresDSN(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Postgres database: %w", err)
+	}
+
+	db.SetMaxOpenConns(100)
+	db.SetMaxIdleConns(10)
+
+	return db, nil
+}
+
+func (b *PostgresBackend) GetInitSQL() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS files (
+			path TEXT PRIMARY KEY,
+			is_dir SMALLINT NOT NULL,
+			mode BIGINT NOT NULL,
+			size BIGINT NOT NULL,
+			mod_time BIGINT NOT NULL,
+			data BYTEA
+		)`,
+		// The primary key index only serves LIKE prefix matches in the C
+		// locale; this one serves them in any locale
+		`CREATE INDEX IF NOT EXISTS idx_files_path_prefix ON files (path text_pattern_ops)`,
+	}
+}
+
+func (b *PostgresBackend) GetDialect() migrate.Dialect {
+	return migrate.Postgres
+}
+
+func (b *PostgresBackend) GetOptimizationSQL() []string {
+	return []string{}
+}
+
+func (b *PostgresBackend) SupportsTxIsolation() bool {
+	return true
+}
+
+func (b *PostgresBackend) GetTableColumns(db *sql.DB, table string) ([]string, error) {
+	return queryColumns(db,
+		"SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position",
+		table)
+}
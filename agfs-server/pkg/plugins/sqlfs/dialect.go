@@ -0,0 +1,67 @@
+package sqlfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/migrate"
+)
+
+// filesColumns are the columns sqlfs needs in the files table
+var filesColumns = []string{"path", "is_dir", "mode", "size", "mod_time", "data"}
+
+// rebind rewrites the ? placeholders of a query for the dialect. Postgres
+// numbers them ($1, $2, ...). Queries must not contain ? otherwise.
+func rebind(dialect migrate.Dialect, query string) string {
+	if dialect != migrate.Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// concatSQL returns the expression concatenating two string expressions.
+// MySQL treats || as a logical OR.
+func concatSQL(dialect migrate.Dialect, a, b string) string {
+	if dialect == migrate.MySQL {
+		return fmt.Sprintf("CONCAT(%s, %s)", a, b)
+	}
+	return a + " || " + b
+}
+
+// deleteBatchSQL returns a statement deleting at most ? files matching
+// where. Only MySQL takes LIMIT on DELETE; SQLite and Postgres delete the
+// paths of a limited subquery. MySQL doesn't allow LIMIT in IN subqueries.
+func deleteBatchSQL(dialect migrate.Dialect, where string) string {
+	if dialect == migrate.MySQL {
+		return "DELETE FROM files WHERE " + where + " LIMIT ?"
+	}
+	return "DELETE FROM files WHERE path IN (SELECT path FROM files WHERE " + where + " LIMIT ?)"
+}
+
+// missingColumns returns the columns of the files table absent in columns,
+// compared case-insensitively
+func missingColumns(columns []string) []string {
+	have := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		have[strings.ToLower(col)] = true
+	}
+	var missing []string
+	for _, col := range filesColumns {
+		if !have[col] {
+			missing = append(missing, col)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,83 @@
+package sqlfs
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/migrate"
+)
+
+func newSQLiteSQLFSForTest(t *testing.T, dbPath string) *SQLFS {
+	t.Helper()
+	fs, err := NewSQLFS(NewSQLiteBackend(), map[string]interface{}{"db_path": dbPath})
+	if err != nil {
+		t.Fatalf("NewSQLFS() error = %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+func TestDialectSQL(t *testing.T) {
+	query := "UPDATE files SET path = " + concatSQL(migrate.Postgres, "?", "SUBSTR(path, ?)") + " WHERE path LIKE ?"
+	if got, want := rebind(migrate.Postgres, query), "UPDATE files SET path = $1 || SUBSTR(path, $2) WHERE path LIKE $3"; got != want {
+		t.Errorf("rebind(Postgres) = %q, want %q", got, want)
+	}
+	if got := rebind(migrate.MySQL, "SELECT 1 WHERE a = ?"); got != "SELECT 1 WHERE a = ?" {
+		t.Errorf("rebind(MySQL) = %q", got)
+	}
+	if got, want := concatSQL(migrate.MySQL, "?", "x"), "CONCAT(?, x)"; got != want {
+		t.Errorf("concatSQL(MySQL) = %q, want %q", got, want)
+	}
+	if got, want := deleteBatchSQL(migrate.MySQL, "path = ?"), "DELETE FROM files WHERE path = ? LIMIT ?"; got != want {
+		t.Errorf("deleteBatchSQL(MySQL) = %q, want %q", got, want)
+	}
+	if got, want := deleteBatchSQL(migrate.SQLite, "path = ?"), "DELETE FROM files WHERE path IN (SELECT path FROM files WHERE path = ? LIMIT ?)"; got != want {
+		t.Errorf("deleteBatchSQL(SQLite) = %q, want %q", got, want)
+	}
+	if got := postgresDSN(map[string]interface{}{"host": "db", "port": 5433, "user": "agfs", "password": "p@ss", "database": "files"}); got != "postgres://agfs:p%40ss@db:5433/files" {
+		t.Errorf("postgresDSN() = %q", got)
+	}
+}
+
+func TestSQLiteRenameAndRemoveAll(t *testing.T) {
+	fs := newSQLiteSQLFSForTest(t, filepath.Join(t.TempDir(), "sqlfs.db"))
+	if err := fs.Mkdir("/a", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/a/b.txt", []byte("hello"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/a", "/c"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if data, _ := fs.Read("/c/b.txt", 0, -1); string(data) != "hello" {
+		t.Fatalf("Read(/c/b.txt) = %q, want hello", data)
+	}
+	if err := fs.RemoveAll("/c"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if _, err := fs.Stat("/c/b.txt"); err == nil {
+		t.Fatal("file remains after RemoveAll")
+	}
+	if err := fs.RemoveAll("/"); err != nil {
+		t.Fatalf("RemoveAll(/) error = %v", err)
+	}
+}
+
+func TestForeignFilesTableIsRejected(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	backend := NewSQLiteBackend()
+	db, err := backend.Open(map[string]interface{}{"db_path": dbPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE files (id INTEGER PRIMARY KEY, path TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if _, err := NewSQLFS(backend, map[string]interface{}{"db_path": dbPath}); err == nil {
+		t.Fatal("NewSQLFS() accepted a files table of another application")
+	}
+}
@@ -22,6 +22,17 @@ type Backend interface {
 	// GetTableColumns retrieves column names and types for a table
 	GetTableColumns(db *sql.DB, dbName, tableName string) ([]ColumnInfo, error)
 
+	// GetPrimaryKey returns the primary key columns of a table in key
+	// order, or none if it has no primary key
+	GetPrimaryKey(db *sql.DB, dbName, tableName string) ([]string, error)
+
+	// UseDatabaseSQL returns the statement that selects dbName on a single
+	// connection, or "" if the backend has no notion of a current database
+	UseDatabaseSQL(dbName string) (string, error)
+
+	// ExplainSQL returns the statement that reports the query plan for query
+	ExplainSQL(query string) string
+
 	// Name returns the backend name
 	Name() string
 }
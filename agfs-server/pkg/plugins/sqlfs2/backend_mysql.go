@@ -119,6 +119,18 @@ func (b *MySQLBackend) SwitchDatabase(db *sql.DB, dbName string) error {
 	return nil
 }
 
+func (b *MySQLBackend) UseDatabaseSQL(dbName string) (string, error) {
+	quotedDB, err := quoteSQLIdentifier("database", dbName)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("USE %s", quotedDB), nil
+}
+
+func (b *MySQLBackend) ExplainSQL(query string) string {
+	return "EXPLAIN " + query
+}
+
 func (b *MySQLBackend) GetTableColumns(db *sql.DB, dbName, tableName string) ([]ColumnInfo, error) {
 	// Switch to database first if needed
 	if dbName != "" {
@@ -151,3 +163,29 @@ func (b *MySQLBackend) GetTableColumns(db *sql.DB, dbName, tableName string) ([]
 	}
 	return columns, nil
 }
+
+func (b *MySQLBackend) GetPrimaryKey(db *sql.DB, dbName, tableName string) ([]string, error) {
+	return mysqlPrimaryKey(db, dbName, tableName)
+}
+
+// mysqlPrimaryKey reads the primary key of a table from information_schema,
+// for MySQL and TiDB
+func mysqlPrimaryKey(db *sql.DB, dbName, tableName string) ([]string, error) {
+	rows, err := db.Query(`SELECT COLUMN_NAME FROM information_schema.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND CONSTRAINT_NAME = 'PRIMARY'
+		ORDER BY ORDINAL_POSITION`, dbName, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get primary key: %w", err)
+	}
+	defer rows.Close()
+
+	var key []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		key = append(key, name)
+	}
+	return key, rows.Err()
+}
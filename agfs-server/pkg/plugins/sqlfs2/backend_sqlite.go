@@ -65,6 +65,16 @@ func (b *SQLiteBackend) SwitchDatabase(db *sql.DB, dbName string) error {
 	return nil
 }
 
+func (b *SQLiteBackend) UseDatabaseSQL(dbName string) (string, error) {
+	// SQLite has a single database per connection
+	return "", nil
+}
+
+// ExplainSQL uses EXPLAIN QUERY PLAN; plain EXPLAIN in SQLite dumps VDBE bytecode
+func (b *SQLiteBackend) ExplainSQL(query string) string {
+	return "EXPLAIN QUERY PLAN " + query
+}
+
 func (b *SQLiteBackend) GetTableColumns(db *sql.DB, dbName, tableName string) ([]ColumnInfo, error) {
 	quotedTable, err := quoteSQLIdentifier("table", tableName)
 	if err != nil {
@@ -91,3 +101,25 @@ func (b *SQLiteBackend) GetTableColumns(db *sql.DB, dbName, tableName string) ([
 	}
 	return columns, nil
 }
+
+func (b *SQLiteBackend) GetPrimaryKey(db *sql.DB, dbName, tableName string) ([]string, error) {
+	if err := validateSQLIdentifier("table", tableName); err != nil {
+		return nil, err
+	}
+	// The pk column of table_info is the 1-based position in the key
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk", tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get primary key: %w", err)
+	}
+	defer rows.Close()
+
+	var key []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		key = append(key, name)
+	}
+	return key, rows.Err()
+}
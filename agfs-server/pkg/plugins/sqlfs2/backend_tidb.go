@@ -226,6 +226,18 @@ func (b *TiDBBackend) SwitchDatabase(db *sql.DB, dbName string) error {
 	return nil
 }
 
+func (b *TiDBBackend) UseDatabaseSQL(dbName string) (string, error) {
+	quotedDB, err := quoteSQLIdentifier("database", dbName)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("USE %s", quotedDB), nil
+}
+
+func (b *TiDBBackend) ExplainSQL(query string) string {
+	return "EXPLAIN " + query
+}
+
 func (b *TiDBBackend) GetTableColumns(db *sql.DB, dbName, tableName string) ([]ColumnInfo, error) {
 	// Switch to database first if needed
 	if dbName != "" {
@@ -259,6 +271,10 @@ func (b *TiDBBackend) GetTableColumns(db *sql.DB, dbName, tableName string) ([]C
 	return columns, nil
 }
 
+func (b *TiDBBackend) GetPrimaryKey(db *sql.DB, dbName, tableName string) ([]string, error) {
+	return mysqlPrimaryKey(db, dbName, tableName)
+}
+
 // extractDatabaseName extracts database name from DSN or config
 func extractDatabaseName(dsn string, configDB string) string {
 	if dsn != "" {
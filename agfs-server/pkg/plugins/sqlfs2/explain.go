@@ -0,0 +1,99 @@
+package sqlfs2
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// explainStatement wraps query in the backend's plan statement.
+// Statements that already start with EXPLAIN are passed through unchanged.
+func explainStatement(backend Backend, query string) string {
+	if strings.HasPrefix(strings.ToUpper(query), "EXPLAIN") {
+		return query
+	}
+	return backend.ExplainSQL(query)
+}
+
+// readExplain returns the query plan for the session's plan query as JSON.
+// The plan is produced inside the session transaction, so it reflects any
+// uncommitted schema or data changes made in the session.
+func (fs *sqlfs2FS) readExplain(path string, session *Session, offset, size int64) ([]byte, error) {
+	session.mu.Lock()
+	defer session.UnlockWithTouch()
+	session.closeCursor()
+
+	if session.planQuery == "" {
+		return nil, fmt.Errorf("no query to explain: write SQL to query or explain first")
+	}
+
+	stmt := explainStatement(fs.plugin.backend, session.planQuery)
+	if err := fs.plugin.policy.Check(path, stmt); err != nil {
+		return nil, err
+	}
+	rows, err := session.tx.Query(stmt)
+	if err != nil {
+		return nil, fmt.Errorf("explain error: %w", err)
+	}
+	defer rows.Close()
+
+	data, _, err := rowsToJSON(rows)
+	if err != nil {
+		return nil, fmt.Errorf("explain error: %w", err)
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+// writeExplain sets the statement reported by the explain file without
+// executing it. Must be called with session.mu held.
+func writeExplain(session *Session, data []byte) (int64, error) {
+	sqlStmt := strings.TrimSpace(string(data))
+	if sqlStmt == "" {
+		return 0, fmt.Errorf("empty SQL statement")
+	}
+	session.planQuery = sqlStmt
+	return int64(len(data)), nil
+}
+
+// rowsToJSON reads all rows into an indented JSON array of objects and
+// returns it along with the number of rows
+func rowsToJSON(rows *sql.Rows) ([]byte, int, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get columns: %w", err)
+	}
+
+	results := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, 0, fmt.Errorf("scan error: %w", err)
+		}
+
+		row := make(map[string]interface{})
+		for i, col := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[col] = string(b)
+			} else {
+				row[col] = values[i]
+			}
+		}
+		results = append(results, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("rows error: %w", err)
+	}
+
+	jsonData, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return nil, 0, fmt.Errorf("json marshal error: %w", err)
+	}
+	return append(jsonData, '\n'), len(results), nil
+}
@@ -0,0 +1,77 @@
+package sqlfs2
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func readSessionFile(t *testing.T, fs *sqlfs2FS, path string) ([]byte, error) {
+	t.Helper()
+	data, err := fs.Read(path, 0, -1)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return data, err
+}
+
+func TestSQLFS2ExplainReportsPlanWithoutExecuting(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+	mustExecSQL(t, plugin.db, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+	mustExecSQL(t, plugin.db, "CREATE INDEX idx_users_name ON users (name)")
+	mustExecSQL(t, plugin.db, "INSERT INTO users (id, name) VALUES (1, 'Alice')")
+
+	sidData, err := readSessionFile(t, fs, "/main/users/ctl")
+	if err != nil {
+		t.Fatalf("Read(ctl) error = %v", err)
+	}
+	sid := strings.TrimSpace(string(sidData))
+	base := "/main/users/" + sid
+
+	if _, err := readSessionFile(t, fs, base+"/explain"); err == nil {
+		t.Fatal("Read(explain) before any query should fail")
+	}
+
+	// Plan of the last statement written to query
+	if _, err := fs.Write(base+"/query", []byte("SELECT * FROM users WHERE name = 'Alice'"), -1, 0); err != nil {
+		t.Fatalf("Write(query) error = %v", err)
+	}
+	data, err := readSessionFile(t, fs, base+"/explain")
+	if err != nil {
+		t.Fatalf("Read(explain) error = %v", err)
+	}
+	var plan []map[string]interface{}
+	if err := json.Unmarshal(data, &plan); err != nil {
+		t.Fatalf("explain output is not a JSON array: %v\n%s", err, data)
+	}
+	if len(plan) == 0 || !strings.Contains(string(data), "idx_users_name") {
+		t.Fatalf("explain output does not mention the index:\n%s", data)
+	}
+
+	// Writing to explain sets the statement without running it
+	if _, err := fs.Write(base+"/explain", []byte("DELETE FROM users WHERE id = 1"), -1, 0); err != nil {
+		t.Fatalf("Write(explain) error = %v", err)
+	}
+	if _, err := readSessionFile(t, fs, base+"/explain"); err != nil {
+		t.Fatalf("Read(explain) error = %v", err)
+	}
+	// Count inside the session transaction, which an executed DELETE would affect
+	var count int
+	session := fs.sessionManager.GetSession("main", "users", sid)
+	if err := session.tx.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		t.Fatalf("count error = %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("explained DELETE was executed: %d rows left", count)
+	}
+
+	info, err := fs.Stat(base + "/explain")
+	if err != nil {
+		t.Fatalf("Stat(explain) error = %v", err)
+	}
+	if info.Mode != 0666 {
+		t.Fatalf("Stat(explain) mode = %o, want 666", info.Mode)
+	}
+}
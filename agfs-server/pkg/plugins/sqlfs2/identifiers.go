@@ -99,3 +99,35 @@ func insertRowsSQL(dbName, tableName string, columnNames []string) (string, erro
 		strings.Join(quotedColumns, ", "),
 		strings.Join(placeholders, ", ")), nil
 }
+
+// insertBatchSQL returns a multi-row INSERT with placeholders for rows rows
+func insertBatchSQL(dbName, tableName string, columnNames []string, rows int) (string, error) {
+	if rows < 1 {
+		return "", fmt.Errorf("rows must be at least 1")
+	}
+	insertSQL, err := insertRowsSQL(dbName, tableName, columnNames)
+	if err != nil {
+		return "", err
+	}
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columnNames)), ", ") + ")"
+	return insertSQL + strings.Repeat(", "+row, rows-1), nil
+}
+
+func createTableSQL(dbName, tableName string, columnNames []string, kinds []importColumnKind) (string, error) {
+	if len(columnNames) == 0 {
+		return "", fmt.Errorf("columnNames must not be empty")
+	}
+	table, err := qualifiedTableName(dbName, tableName)
+	if err != nil {
+		return "", err
+	}
+	quotedColumns, err := quotedColumnNames(columnNames)
+	if err != nil {
+		return "", err
+	}
+	defs := make([]string, len(quotedColumns))
+	for i, col := range quotedColumns {
+		defs[i] = col + " " + kinds[i].sqlType()
+	}
+	return fmt.Sprintf("CREATE TABLE %s (%s)", table, strings.Join(defs, ", ")), nil
+}
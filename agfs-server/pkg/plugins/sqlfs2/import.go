@@ -0,0 +1,317 @@
+package sqlfs2
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// importFileName is the table-level file that bulk-loads CSV, TSV or Parquet data
+const importFileName = "import"
+
+const (
+	// importBatchRows is the number of rows sent per multi-row INSERT
+	importBatchRows = 500
+	// importMaxParams keeps a batch under the placeholder limits of all
+	// backends (SQLite 32766, MySQL/TiDB 65535)
+	importMaxParams = 30000
+)
+
+// ImportResult is the JSON content of <table>/import after a load
+type ImportResult struct {
+	Table    string    `json:"table"`
+	Format   string    `json:"format"`
+	Created  bool      `json:"created"` // Table was created with an inferred schema
+	Columns  []string  `json:"columns"`
+	Rows     int       `json:"rows"`
+	Batches  int       `json:"batches"`
+	Duration string    `json:"duration"`
+	Finished time.Time `json:"finished_at"`
+}
+
+// importColumnKind is the type inferred for a column of a new table
+type importColumnKind int
+
+const (
+	importInteger importColumnKind = iota
+	importFloat
+	importText
+)
+
+// sqlType returns a column type understood by SQLite, MySQL and TiDB
+func (k importColumnKind) sqlType() string {
+	switch k {
+	case importInteger:
+		return "BIGINT"
+	case importFloat:
+		return "DOUBLE"
+	default:
+		return "TEXT"
+	}
+}
+
+// parseImport parses imported data into a header and records: Parquet
+// files are recognized by their magic number, and anything else is CSV or
+// TSV
+func parseImport(data []byte) (format string, header []string, records [][]string, err error) {
+	if bytes.HasPrefix(data, parquetMagic) {
+		header, records, err = parseParquet(data)
+		return "parquet", header, records, err
+	}
+	return parseDelimited(data)
+}
+
+// parseDelimited parses CSV or TSV data into a header and records.
+// The delimiter is a tab if the header contains tabs but no commas.
+func parseDelimited(data []byte) (format string, header []string, records [][]string, err error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // UTF-8 BOM
+
+	firstLine := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		firstLine = data[:i]
+	}
+	format = "csv"
+	reader := csv.NewReader(bytes.NewReader(data))
+	if bytes.IndexByte(firstLine, '\t') >= 0 && bytes.IndexByte(firstLine, ',') < 0 {
+		format = "tsv"
+		reader.Comma = '\t'
+		reader.LazyQuotes = true
+	}
+
+	header, err = reader.Read()
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+		if err := validateSQLIdentifier("column", header[i]); err != nil {
+			return "", nil, nil, filesystem.NewInvalidArgumentError("column", header[i], err.Error())
+		}
+	}
+
+	records, err = reader.ReadAll()
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid %s: %w", format, err)
+	}
+	return format, header, records, nil
+}
+
+// inferColumnKinds picks the narrowest type that fits every non-empty value
+func inferColumnKinds(header []string, records [][]string) []importColumnKind {
+	kinds := make([]importColumnKind, len(header))
+	for _, record := range records {
+		for i, value := range record {
+			if value == "" || kinds[i] == importText {
+				continue
+			}
+			if kinds[i] == importInteger {
+				if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+					continue
+				}
+				kinds[i] = importFloat
+			}
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				kinds[i] = importText
+			}
+		}
+	}
+	// Columns without any values carry no type information
+	for i := range kinds {
+		empty := true
+		for _, record := range records {
+			if record[i] != "" {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			kinds[i] = importText
+		}
+	}
+	return kinds
+}
+
+// importValue converts a field for insertion. Empty fields become NULL.
+func importValue(value string, kind importColumnKind) interface{} {
+	if value == "" {
+		return nil
+	}
+	switch kind {
+	case importInteger:
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case importFloat:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+// importRows loads CSV, TSV or Parquet data into dbName.tableName within tx.
+// An existing table must have every column named in the header; otherwise
+// the table is created with column types inferred from the data.
+func (fs *sqlfs2FS) importRows(tx *sql.Tx, path, dbName, tableName string, data []byte) (*ImportResult, error) {
+	began := time.Now()
+	format, header, records, err := parseImport(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no records to import")
+	}
+
+	exists, err := fs.tableExists(dbName, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check table existence: %w", err)
+	}
+
+	// Text is passed through for existing tables and coerced by the database
+	kinds := make([]importColumnKind, len(header))
+	for i := range kinds {
+		kinds[i] = importText
+	}
+	columns := header
+	if exists {
+		columns, err = fs.matchImportColumns(dbName, tableName, header)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		kinds = inferColumnKinds(header, records)
+		createSQL, err := createTableSQL(dbName, tableName, header, kinds)
+		if err == nil {
+			err = fs.plugin.policy.Check(path, createSQL)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(createSQL); err != nil {
+			return nil, fmt.Errorf("failed to create table: %w", err)
+		}
+	}
+
+	batchRows := importBatchRows
+	if max := importMaxParams / len(columns); max < batchRows {
+		batchRows = max
+	}
+
+	batches := 0
+	for start := 0; start < len(records); start += batchRows {
+		end := start + batchRows
+		if end > len(records) {
+			end = len(records)
+		}
+		insertSQL, err := insertBatchSQL(dbName, tableName, columns, end-start)
+		if err == nil {
+			err = fs.plugin.policy.Check(path, insertSQL)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		values := make([]interface{}, 0, (end-start)*len(columns))
+		for _, record := range records[start:end] {
+			for i, value := range record {
+				values = append(values, importValue(value, kinds[i]))
+			}
+		}
+		if _, err := tx.Exec(insertSQL, values...); err != nil {
+			// Report the data line (header is line 1)
+			return nil, fmt.Errorf("insert error in rows %d-%d: %w", start+2, end+1, err)
+		}
+		batches++
+	}
+
+	return &ImportResult{
+		Table:    tableName,
+		Format:   format,
+		Created:  !exists,
+		Columns:  columns,
+		Rows:     len(records),
+		Batches:  batches,
+		Duration: time.Since(began).String(),
+		Finished: time.Now(),
+	}, nil
+}
+
+// matchImportColumns maps header names case-insensitively onto the table's columns
+func (fs *sqlfs2FS) matchImportColumns(dbName, tableName string, header []string) ([]string, error) {
+	tableColumns, err := fs.plugin.backend.GetTableColumns(fs.plugin.db, dbName, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get table columns: %w", err)
+	}
+	byName := make(map[string]string, len(tableColumns))
+	for _, col := range tableColumns {
+		byName[strings.ToLower(col.Name)] = col.Name
+	}
+
+	columns := make([]string, len(header))
+	seen := make(map[string]bool, len(header))
+	for i, name := range header {
+		col, ok := byName[strings.ToLower(name)]
+		if !ok {
+			return nil, filesystem.NewInvalidArgumentError("column", name, fmt.Sprintf("not a column of %s.%s", dbName, tableName))
+		}
+		if seen[col] {
+			return nil, filesystem.NewInvalidArgumentError("column", name, "appears more than once in the header")
+		}
+		seen[col] = true
+		columns[i] = col
+	}
+	return columns, nil
+}
+
+// importTable runs an import in its own transaction and records the result
+func (fs *sqlfs2FS) importTable(path, dbName, tableName string, data []byte) (int64, error) {
+	if err := fs.plugin.backend.SwitchDatabase(fs.plugin.db, dbName); err != nil {
+		return 0, err
+	}
+	tx, err := fs.plugin.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	result, err := fs.importRows(tx, path, dbName, tableName, data)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("transaction commit failed: %w", err)
+	}
+
+	fs.plugin.recordImport(dbName, tableName, result)
+	return int64(len(data)), nil
+}
+
+// recordImport keeps the result of the last import into a table
+func (p *SQLFS2Plugin) recordImport(dbName, tableName string, result *ImportResult) {
+	p.importsMu.Lock()
+	defer p.importsMu.Unlock()
+	if p.imports == nil {
+		p.imports = make(map[string]*ImportResult)
+	}
+	p.imports[dbName+"."+tableName] = result
+}
+
+// lastImport returns the JSON result of the last import into a table, or
+// nothing if there has been none since the server started
+func (p *SQLFS2Plugin) lastImport(dbName, tableName string) []byte {
+	p.importsMu.Lock()
+	result := p.imports[dbName+"."+tableName]
+	p.importsMu.Unlock()
+	if result == nil {
+		return []byte{}
+	}
+	data, _ := json.MarshalIndent(result, "", "  ")
+	return append(data, '\n')
+}
@@ -0,0 +1,219 @@
+package sqlfs2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/parquet-go/parquet-go"
+)
+
+func readImportResult(t *testing.T, fs *sqlfs2FS, path string) ImportResult {
+	t.Helper()
+	data, err := readSessionFile(t, fs, path)
+	if err != nil {
+		t.Fatalf("Read(import) error = %v", err)
+	}
+	var result ImportResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("import result is not JSON: %v\n%s", err, data)
+	}
+	return result
+}
+
+func TestSQLFS2ImportCreatesTableWithInferredSchema(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+
+	csv := "id,name,score,note\n1,Alice,9.5,\n2,\"Bob, Jr.\",7,hi\n"
+	if _, err := fs.Write("/main/people/import", []byte(csv), -1, 0); err != nil {
+		t.Fatalf("Write(import) error = %v", err)
+	}
+
+	columns, err := plugin.backend.GetTableColumns(plugin.db, "main", "people")
+	if err != nil {
+		t.Fatalf("GetTableColumns() error = %v", err)
+	}
+	want := []ColumnInfo{{"id", "BIGINT"}, {"name", "TEXT"}, {"score", "DOUBLE"}, {"note", "TEXT"}}
+	if fmt.Sprint(columns) != fmt.Sprint(want) {
+		t.Fatalf("columns = %v, want %v", columns, want)
+	}
+
+	var name string
+	var note interface{}
+	if err := plugin.db.QueryRow("SELECT name, note FROM people WHERE id = 1").Scan(&name, &note); err != nil {
+		t.Fatalf("query error = %v", err)
+	}
+	if name != "Alice" || note != nil {
+		t.Fatalf("row 1 = (%q, %v), want (Alice, NULL)", name, note)
+	}
+
+	result := readImportResult(t, fs, "/main/people/import")
+	if !result.Created || result.Rows != 2 || result.Format != "csv" {
+		t.Fatalf("unexpected import result %+v", result)
+	}
+}
+
+func TestSQLFS2ImportIntoExistingTable(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+	mustExecSQL(t, plugin.db, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, age INTEGER)")
+
+	// Header order and case need not match the table; tabs select TSV
+	var b strings.Builder
+	b.WriteString("Name\tID\n")
+	for i := 1; i <= 1200; i++ {
+		fmt.Fprintf(&b, "user%d\t%d\n", i, i)
+	}
+	if _, err := fs.Write("/main/users/import", []byte(b.String()), -1, 0); err != nil {
+		t.Fatalf("Write(import) error = %v", err)
+	}
+
+	var count int
+	var age interface{}
+	if err := plugin.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		t.Fatalf("count error = %v", err)
+	}
+	if err := plugin.db.QueryRow("SELECT age FROM users WHERE name = 'user42' AND id = 42").Scan(&age); err != nil {
+		t.Fatalf("query error = %v", err)
+	}
+	if count != 1200 || age != nil {
+		t.Fatalf("count = %d, age = %v; want 1200, NULL", count, age)
+	}
+
+	result := readImportResult(t, fs, "/main/users/import")
+	if result.Created || result.Format != "tsv" || result.Batches != 3 {
+		t.Fatalf("unexpected import result %+v", result)
+	}
+}
+
+func TestSQLFS2ImportParquet(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+
+	type event struct {
+		ID     int64     `parquet:"id"`
+		Name   *string   `parquet:"name,optional"`
+		Score  float64   `parquet:"score"`
+		Active bool      `parquet:"active"`
+		At     time.Time `parquet:"at,timestamp(millisecond)"`
+	}
+	alice := "Alice"
+	var buf bytes.Buffer
+	if err := parquet.Write(&buf, []event{
+		{ID: 1, Name: &alice, Score: 9.5, Active: true, At: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)},
+		{ID: 2, Score: 7, At: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)},
+	}); err != nil {
+		t.Fatalf("parquet.Write() error = %v", err)
+	}
+	if _, err := fs.Write("/main/events/import", buf.Bytes(), -1, 0); err != nil {
+		t.Fatalf("Write(import) error = %v", err)
+	}
+
+	columns, err := plugin.backend.GetTableColumns(plugin.db, "main", "events")
+	if err != nil {
+		t.Fatalf("GetTableColumns() error = %v", err)
+	}
+	want := []ColumnInfo{{"id", "BIGINT"}, {"name", "TEXT"}, {"score", "DOUBLE"}, {"active", "BIGINT"}, {"at", "TEXT"}}
+	if fmt.Sprint(columns) != fmt.Sprint(want) {
+		t.Fatalf("columns = %v, want %v", columns, want)
+	}
+
+	var name interface{}
+	var active int
+	var at string
+	if err := plugin.db.QueryRow("SELECT name, active, at FROM events WHERE id = 2").Scan(&name, &active, &at); err != nil {
+		t.Fatalf("query error = %v", err)
+	}
+	if name != nil || active != 0 || at != "2024-05-02 00:00:00" {
+		t.Fatalf("row 2 = (%v, %d, %q), want (NULL, 0, 2024-05-02 00:00:00)", name, active, at)
+	}
+
+	result := readImportResult(t, fs, "/main/events/import")
+	if !result.Created || result.Rows != 2 || result.Format != "parquet" {
+		t.Fatalf("unexpected import result %+v", result)
+	}
+
+	// Nested columns have no table equivalent
+	type nested struct {
+		ID   int64    `parquet:"id"`
+		Tags []string `parquet:"tags,list"`
+	}
+	buf.Reset()
+	if err := parquet.Write(&buf, []nested{{ID: 1, Tags: []string{"a"}}}); err != nil {
+		t.Fatalf("parquet.Write() error = %v", err)
+	}
+	if _, err := fs.Write("/main/nested/import", buf.Bytes(), -1, 0); err == nil || !strings.Contains(err.Error(), "tags") {
+		t.Fatalf("nested column: error = %v", err)
+	}
+}
+
+func TestSQLFS2ImportRejectsBadInput(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+	mustExecSQL(t, plugin.db, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+	mustExecSQL(t, plugin.db, "INSERT INTO users (id, name) VALUES (1, 'Alice')")
+
+	_, err := fs.Write("/main/users/import", []byte("id,email\n2,bob@example.com\n"), -1, 0)
+	if err == nil || !strings.Contains(err.Error(), "email") {
+		t.Fatalf("unknown column: error = %v", err)
+	}
+	if _, err := fs.Write("/main/users/import", []byte("PAR1\x15\x04"), -1, 0); err == nil || !strings.Contains(err.Error(), "parquet") {
+		t.Fatalf("truncated parquet: error = %v", err)
+	}
+
+	// A failing row rolls back the whole import
+	if _, err := fs.Write("/main/users/import", []byte("id,name\n2,Bob\n1,Dup\n"), -1, 0); err == nil {
+		t.Fatal("duplicate key import should fail")
+	}
+	var count int
+	if err := plugin.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		t.Fatalf("count error = %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("count = %d after failed import, want 1", count)
+	}
+}
+
+func TestSQLFS2ImportRespectsPolicy(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+	plugin.policy.readOnly = true
+
+	if _, err := fs.Write("/main/people/import", []byte("id\n1\n"), -1, 0); err == nil {
+		t.Fatal("import on a read-only mount should fail")
+	}
+	if tableExistsInSQLite(t, plugin.db, "people") {
+		t.Fatal("rejected import created a table")
+	}
+}
+
+func TestSQLFS2ImportThroughHandle(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+
+	h, err := fs.OpenHandle("/main/events/import", filesystem.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenHandle(import) error = %v", err)
+	}
+	for _, chunk := range []string{"kind,n\nclick,", "1\nview,2\n"} {
+		if _, err := h.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := h.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	var total int
+	if err := plugin.db.QueryRow("SELECT SUM(n) FROM events").Scan(&total); err != nil {
+		t.Fatalf("query error = %v", err)
+	}
+	if total != 3 {
+		t.Fatalf("SUM(n) = %d, want 3", total)
+	}
+	if result := readImportResult(t, fs, "/main/events/import"); result.Rows != 2 {
+		t.Fatalf("unexpected import result %+v", result)
+	}
+}
@@ -0,0 +1,491 @@
+package sqlfs2
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	log "github.com/sirupsen/logrus"
+)
+
+// jobsDirName is the reserved root-level directory holding async query jobs.
+// It shadows any database with the same name.
+const jobsDirName = "jobs"
+
+// JobStatus is the lifecycle state of an async query job
+type JobStatus string
+
+const (
+	JobStatusPending   JobStatus = "pending"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusCompleted JobStatus = "completed"
+	JobStatusFailed    JobStatus = "failed"
+	JobStatusCancelled JobStatus = "cancelled"
+)
+
+// Job is a long-running query executed in the background
+type Job struct {
+	id          int64
+	dbName      string
+	query       string
+	status      JobStatus
+	err         string
+	rows        int // Rows returned (queries) or affected (statements)
+	result      []byte
+	submittedAt time.Time
+	startedAt   time.Time
+	finishedAt  time.Time
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+}
+
+// JobStatusInfo is the JSON content of jobs/<id>/status
+type JobStatusInfo struct {
+	ID          int64     `json:"id"`
+	Status      JobStatus `json:"status"`
+	Database    string    `json:"database,omitempty"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	StartedAt   time.Time `json:"started_at,omitempty"`
+	FinishedAt  time.Time `json:"finished_at,omitempty"`
+	Duration    string    `json:"duration,omitempty"`
+	Rows        int       `json:"rows"`
+	Error       string    `json:"error,omitempty"`
+}
+
+func (j *Job) done() bool {
+	return j.status == JobStatusCompleted || j.status == JobStatusFailed || j.status == JobStatusCancelled
+}
+
+// statusInfo returns a snapshot of the job state. Must be called with mu held.
+func (j *Job) statusInfo() JobStatusInfo {
+	info := JobStatusInfo{
+		ID:          j.id,
+		Status:      j.status,
+		Database:    j.dbName,
+		SubmittedAt: j.submittedAt,
+		StartedAt:   j.startedAt,
+		FinishedAt:  j.finishedAt,
+		Rows:        j.rows,
+		Error:       j.err,
+	}
+	if !j.startedAt.IsZero() {
+		end := j.finishedAt
+		if end.IsZero() {
+			end = time.Now()
+		}
+		info.Duration = end.Sub(j.startedAt).String()
+	}
+	return info
+}
+
+// JobManager runs async query jobs on a fixed pool of workers
+type JobManager struct {
+	db        *sql.DB
+	backend   Backend
+	queue     chan *Job
+	jobs      map[int64]*Job
+	nextID    int64
+	retention time.Duration // How long finished jobs are kept (0 = until removed)
+	mu        sync.RWMutex
+	wg        sync.WaitGroup
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// NewJobManager creates a job manager and starts its workers
+func NewJobManager(db *sql.DB, backend Backend, workers, queueSize int, retention time.Duration) *JobManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	jm := &JobManager{
+		db:        db,
+		backend:   backend,
+		queue:     make(chan *Job, queueSize),
+		jobs:      make(map[int64]*Job),
+		nextID:    1,
+		retention: retention,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	for i := 0; i < workers; i++ {
+		jm.wg.Add(1)
+		go jm.worker()
+	}
+	if retention > 0 {
+		jm.wg.Add(1)
+		go jm.cleanupLoop()
+	}
+	return jm
+}
+
+// Submit queues query for execution in dbName and returns the new job
+func (jm *JobManager) Submit(dbName, query string) (*Job, error) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(jm.ctx)
+	job := &Job{
+		id:          jm.nextID,
+		dbName:      dbName,
+		query:       query,
+		status:      JobStatusPending,
+		submittedAt: time.Now(),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+
+	select {
+	case jm.queue <- job:
+	default:
+		cancel()
+		return nil, fmt.Errorf("job queue is full, please try again later")
+	}
+
+	jm.nextID++
+	jm.jobs[job.id] = job
+	log.Debugf("[sqlfs2] Job %d queued", job.id)
+	return job, nil
+}
+
+// Get returns the job with the given ID
+func (jm *JobManager) Get(id string) *Job {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil
+	}
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
+	return jm.jobs[n]
+}
+
+// List returns the IDs of all known jobs
+func (jm *JobManager) List() []string {
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
+	ids := make([]string, 0, len(jm.jobs))
+	for id := range jm.jobs {
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+	return ids
+}
+
+// Remove cancels the job if it is still pending or running and forgets it
+func (jm *JobManager) Remove(id string) error {
+	job := jm.Get(id)
+	if job == nil {
+		return filesystem.NewNotFoundError("remove", "/"+jobsDirName+"/"+id)
+	}
+
+	job.mu.Lock()
+	if !job.done() {
+		job.status = JobStatusCancelled
+		job.finishedAt = time.Now()
+	}
+	job.mu.Unlock()
+	job.cancel()
+
+	jm.mu.Lock()
+	delete(jm.jobs, job.id)
+	jm.mu.Unlock()
+
+	log.Debugf("[sqlfs2] Job %d removed", job.id)
+	return nil
+}
+
+// Stop cancels all jobs and waits for the workers to exit
+func (jm *JobManager) Stop() {
+	jm.cancel()
+	jm.wg.Wait()
+}
+
+func (jm *JobManager) worker() {
+	defer jm.wg.Done()
+	for {
+		select {
+		case job := <-jm.queue:
+			jm.run(job)
+		case <-jm.ctx.Done():
+			return
+		}
+	}
+}
+
+// run executes a job on a dedicated connection outside any session transaction
+func (jm *JobManager) run(job *Job) {
+	job.mu.Lock()
+	if job.status != JobStatusPending {
+		// Cancelled while queued
+		job.mu.Unlock()
+		return
+	}
+	job.status = JobStatusRunning
+	job.startedAt = time.Now()
+	job.mu.Unlock()
+
+	result, rows, err := jm.execute(job)
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.cancel()
+	if job.status == JobStatusCancelled {
+		return
+	}
+	job.finishedAt = time.Now()
+	if err != nil {
+		job.status = JobStatusFailed
+		job.err = err.Error()
+		log.Warnf("[sqlfs2] Job %d failed: %v", job.id, err)
+		return
+	}
+	job.status = JobStatusCompleted
+	job.result = result
+	job.rows = rows
+	log.Debugf("[sqlfs2] Job %d completed in %v", job.id, job.finishedAt.Sub(job.startedAt))
+}
+
+func (jm *JobManager) execute(job *Job) ([]byte, int, error) {
+	conn, err := jm.db.Conn(job.ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if job.dbName != "" {
+		useSQL, err := jm.backend.UseDatabaseSQL(job.dbName)
+		if err != nil {
+			return nil, 0, err
+		}
+		if useSQL != "" {
+			if _, err := conn.ExecContext(job.ctx, useSQL); err != nil {
+				return nil, 0, fmt.Errorf("failed to switch to database %s: %w", job.dbName, err)
+			}
+		}
+	}
+
+	if isQueryStatement(job.query) {
+		rows, err := conn.QueryContext(job.ctx, job.query)
+		if err != nil {
+			return nil, 0, fmt.Errorf("query error: %w", err)
+		}
+		defer rows.Close()
+		return rowsToJSON(rows)
+	}
+
+	res, err := conn.ExecContext(job.ctx, job.query)
+	if err != nil {
+		return nil, 0, fmt.Errorf("execution error: %w", err)
+	}
+	rowsAffected, _ := res.RowsAffected()
+	lastInsertId, _ := res.LastInsertId()
+	jsonData, _ := json.MarshalIndent(map[string]interface{}{
+		"rows_affected":  rowsAffected,
+		"last_insert_id": lastInsertId,
+	}, "", "  ")
+	return append(jsonData, '\n'), int(rowsAffected), nil
+}
+
+// cleanupLoop forgets finished jobs older than the retention period
+func (jm *JobManager) cleanupLoop() {
+	defer jm.wg.Done()
+	ticker := time.NewTicker(jm.retention / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			jm.mu.Lock()
+			for id, job := range jm.jobs {
+				job.mu.Lock()
+				if job.done() && time.Since(job.finishedAt) > jm.retention {
+					delete(jm.jobs, id)
+				}
+				job.mu.Unlock()
+			}
+			jm.mu.Unlock()
+		case <-jm.ctx.Done():
+			return
+		}
+	}
+}
+
+// isQueryStatement reports whether sqlStmt returns rows
+func isQueryStatement(sqlStmt string) bool {
+	upperSQL := strings.ToUpper(sqlStmt)
+	return strings.HasPrefix(upperSQL, "SELECT") ||
+		strings.HasPrefix(upperSQL, "SHOW") ||
+		strings.HasPrefix(upperSQL, "DESCRIBE") ||
+		strings.HasPrefix(upperSQL, "EXPLAIN")
+}
+
+// parseJobPath reports whether path is inside the jobs directory and splits it
+// into the job ID and file name. Both are empty for the jobs directory itself.
+func parseJobPath(path string) (id, file string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if parts[0] != jobsDirName {
+		return "", "", false
+	}
+	if len(parts) > 1 {
+		id = parts[1]
+	}
+	if len(parts) > 2 {
+		file = strings.Join(parts[2:], "/")
+	}
+	return id, file, true
+}
+
+// submitJob handles writes to a session's async file. The job ID is reported
+// through the session result file. Must be called with session.mu held.
+func (fs *sqlfs2FS) submitJob(path string, session *Session, data []byte) (int64, error) {
+	sqlStmt := strings.TrimSpace(string(data))
+	if sqlStmt == "" {
+		session.lastError = "empty SQL statement"
+		return 0, fmt.Errorf("empty SQL statement")
+	}
+	if err := fs.plugin.policy.Check(path, sqlStmt); err != nil {
+		session.lastError = err.Error()
+		session.result = nil
+		return 0, err
+	}
+
+	job, err := fs.plugin.jobs.Submit(session.dbName, sqlStmt)
+	if err != nil {
+		session.lastError = err.Error()
+		session.result = nil
+		return 0, err
+	}
+
+	jsonData, _ := json.MarshalIndent(map[string]interface{}{
+		"job_id": job.id,
+		"path":   fmt.Sprintf("%s/%d", jobsDirName, job.id),
+	}, "", "  ")
+	session.result = append(jsonData, '\n')
+	session.lastError = ""
+	return int64(len(data)), nil
+}
+
+func (fs *sqlfs2FS) readJob(id, file string, offset, size int64) ([]byte, error) {
+	path := "/" + jobsDirName + "/" + id + "/" + file
+	if id == "" || file == "" {
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+	job := fs.plugin.jobs.Get(id)
+	if job == nil {
+		return nil, filesystem.NewNotFoundError("read", path)
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	var data []byte
+	switch file {
+	case "status":
+		jsonData, err := json.MarshalIndent(job.statusInfo(), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		data = append(jsonData, '\n')
+	case "query":
+		data = []byte(job.query + "\n")
+	case "result":
+		switch job.status {
+		case JobStatusCompleted:
+			data = job.result
+		case JobStatusFailed:
+			return nil, fmt.Errorf("job %s failed: %s", id, job.err)
+		default:
+			return nil, fmt.Errorf("job %s is %s", id, job.status)
+		}
+	default:
+		return nil, filesystem.NewNotFoundError("read", path)
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+func (fs *sqlfs2FS) statJob(id, file string) (*filesystem.FileInfo, error) {
+	now := time.Now()
+	if id == "" {
+		return &filesystem.FileInfo{
+			Name:    jobsDirName,
+			Mode:    0755,
+			ModTime: now,
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "jobs"},
+		}, nil
+	}
+
+	job := fs.plugin.jobs.Get(id)
+	if job == nil {
+		return nil, filesystem.NewNotFoundError("stat", "/"+jobsDirName+"/"+id)
+	}
+	job.mu.Lock()
+	modTime := job.submittedAt
+	if !job.finishedAt.IsZero() {
+		modTime = job.finishedAt
+	}
+	resultSize := int64(len(job.result))
+	job.mu.Unlock()
+
+	switch file {
+	case "":
+		return &filesystem.FileInfo{
+			Name:    id,
+			Mode:    0755,
+			ModTime: modTime,
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "job"},
+		}, nil
+	case "status", "query", "result":
+		size := int64(0)
+		if file == "result" {
+			size = resultSize
+		}
+		return &filesystem.FileInfo{
+			Name:    file,
+			Size:    size,
+			Mode:    0444,
+			ModTime: modTime,
+			IsDir:   false,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "job-" + file},
+		}, nil
+	}
+	return nil, filesystem.NewNotFoundError("stat", "/"+jobsDirName+"/"+id+"/"+file)
+}
+
+func (fs *sqlfs2FS) readDirJobs(id, file string) ([]filesystem.FileInfo, error) {
+	if file != "" {
+		return nil, filesystem.NewNotDirectoryError("/" + jobsDirName + "/" + id + "/" + file)
+	}
+
+	if id == "" {
+		var entries []filesystem.FileInfo
+		for _, jobID := range fs.plugin.jobs.List() {
+			if info, err := fs.statJob(jobID, ""); err == nil {
+				entries = append(entries, *info)
+			}
+		}
+		return entries, nil
+	}
+
+	var entries []filesystem.FileInfo
+	for _, name := range []string{"status", "query", "result"} {
+		info, err := fs.statJob(id, name)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *info)
+	}
+	return entries, nil
+}
+
+// removeJob cancels and forgets a job (rm jobs/<id>)
+func (fs *sqlfs2FS) removeJob(id, file string) error {
+	if id == "" || file != "" {
+		return fmt.Errorf("operation not supported: only job directories can be removed")
+	}
+	return fs.plugin.jobs.Remove(id)
+}
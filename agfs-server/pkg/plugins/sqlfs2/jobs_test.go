@@ -0,0 +1,141 @@
+package sqlfs2
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// submitAsync writes sql to a new root-level session's async file and returns the job ID
+func submitAsync(t *testing.T, fs *sqlfs2FS, sql string) string {
+	t.Helper()
+	sidData, err := readSessionFile(t, fs, "/ctl")
+	if err != nil {
+		t.Fatalf("Read(ctl) error = %v", err)
+	}
+	base := "/" + strings.TrimSpace(string(sidData))
+
+	if _, err := fs.Write(base+"/async", []byte(sql), -1, 0); err != nil {
+		t.Fatalf("Write(async) error = %v", err)
+	}
+	data, err := readSessionFile(t, fs, base+"/result")
+	if err != nil {
+		t.Fatalf("Read(result) error = %v", err)
+	}
+	var submitted struct {
+		JobID int64  `json:"job_id"`
+		Path  string `json:"path"`
+	}
+	if err := json.Unmarshal(data, &submitted); err != nil {
+		t.Fatalf("async result is not JSON: %v\n%s", err, data)
+	}
+	id := strings.TrimPrefix(submitted.Path, jobsDirName+"/")
+	if submitted.JobID == 0 || id == submitted.Path {
+		t.Fatalf("unexpected async result:\n%s", data)
+	}
+	return id
+}
+
+func waitForJob(t *testing.T, fs *sqlfs2FS, id string, want JobStatus) JobStatusInfo {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		data, err := readSessionFile(t, fs, "/jobs/"+id+"/status")
+		if err != nil {
+			t.Fatalf("Read(status) error = %v", err)
+		}
+		var status JobStatusInfo
+		if err := json.Unmarshal(data, &status); err != nil {
+			t.Fatalf("status is not JSON: %v\n%s", err, data)
+		}
+		if status.Status == want {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s status = %s, want %s", id, status.Status, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSQLFS2AsyncJobCompletes(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+	mustExecSQL(t, plugin.db, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+	mustExecSQL(t, plugin.db, "INSERT INTO users (id, name) VALUES (1, 'Alice'), (2, 'Bob')")
+
+	id := submitAsync(t, fs, "SELECT name FROM users ORDER BY id")
+	status := waitForJob(t, fs, id, JobStatusCompleted)
+	if status.Rows != 2 {
+		t.Fatalf("status rows = %d, want 2", status.Rows)
+	}
+
+	data, err := readSessionFile(t, fs, "/jobs/"+id+"/result")
+	if err != nil {
+		t.Fatalf("Read(result) error = %v", err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		t.Fatalf("job result is not a JSON array: %v\n%s", err, data)
+	}
+	if len(rows) != 2 || rows[0]["name"] != "Alice" {
+		t.Fatalf("unexpected job result:\n%s", data)
+	}
+
+	entries, err := fs.ReadDir("/jobs")
+	if err != nil {
+		t.Fatalf("ReadDir(/jobs) error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != id {
+		t.Fatalf("ReadDir(/jobs) = %v, want [%s]", entries, id)
+	}
+}
+
+func TestSQLFS2AsyncJobFailure(t *testing.T) {
+	_, fs := newSQLiteSQLFS2ForTest(t)
+
+	id := submitAsync(t, fs, "SELECT * FROM missing_table")
+	status := waitForJob(t, fs, id, JobStatusFailed)
+	if !strings.Contains(status.Error, "missing_table") {
+		t.Fatalf("status error = %q, want mention of missing_table", status.Error)
+	}
+	if _, err := readSessionFile(t, fs, "/jobs/"+id+"/result"); err == nil {
+		t.Fatal("Read(result) of a failed job should fail")
+	}
+}
+
+func TestSQLFS2AsyncJobCancelledByRemove(t *testing.T) {
+	_, fs := newSQLiteSQLFS2ForTest(t)
+
+	// Never terminates on its own
+	id := submitAsync(t, fs, "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT COUNT(*) FROM c")
+	waitForJob(t, fs, id, JobStatusRunning)
+
+	if err := fs.RemoveAll("/jobs/" + id); err != nil {
+		t.Fatalf("RemoveAll(job) error = %v", err)
+	}
+	if _, err := fs.Stat("/jobs/" + id); err == nil {
+		t.Fatal("Stat(job) after remove should fail")
+	}
+
+	// The worker is released and picks up new jobs
+	next := submitAsync(t, fs, "SELECT 1 AS one")
+	waitForJob(t, fs, next, JobStatusCompleted)
+}
+
+func TestSQLFS2AsyncJobRespectsPolicy(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+	plugin.policy.readOnly = true
+
+	sidData, err := readSessionFile(t, fs, "/ctl")
+	if err != nil {
+		t.Fatalf("Read(ctl) error = %v", err)
+	}
+	base := "/" + strings.TrimSpace(string(sidData))
+	if _, err := fs.Write(base+"/async", []byte("CREATE TABLE t (id INTEGER)"), -1, 0); err == nil {
+		t.Fatal("Write(async) of DDL on a read-only mount should fail")
+	}
+	if ids := plugin.jobs.List(); len(ids) != 0 {
+		t.Fatalf("rejected statement created jobs %v", ids)
+	}
+}
@@ -0,0 +1,151 @@
+package sqlfs2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// parquetMagic starts and ends every Parquet file
+var parquetMagic = []byte("PAR1")
+
+// parquetReadRows is the number of rows read from a Parquet file at a time
+const parquetReadRows = 256
+
+// parquetJulianUnixEpoch is the Julian day of 1970-01-01, which INT96
+// timestamps count their days from
+const parquetJulianUnixEpoch = 2440588
+
+// parseParquet reads a Parquet file into a header and records. Only flat
+// schemas can be imported; values are formatted as text so that they go
+// through the same type inference and conversion as CSV fields, and nulls
+// become empty fields, which are imported as NULL.
+func parseParquet(data []byte) (header []string, records [][]string, err error) {
+	// Malformed files can make the decoder panic rather than fail
+	defer func() {
+		if r := recover(); r != nil {
+			header, records, err = nil, nil, fmt.Errorf("invalid parquet: %v", r)
+		}
+	}()
+
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid parquet: %w", err)
+	}
+	fields := file.Schema().Fields()
+	header = make([]string, len(fields))
+	for i, field := range fields {
+		if !field.Leaf() || field.Repeated() {
+			return nil, nil, filesystem.NewInvalidArgumentError("column", field.Name(), "nested and repeated parquet columns cannot be imported")
+		}
+		header[i] = field.Name()
+		if err := validateSQLIdentifier("column", header[i]); err != nil {
+			return nil, nil, filesystem.NewInvalidArgumentError("column", header[i], err.Error())
+		}
+	}
+
+	reader := parquet.NewReader(file)
+	defer reader.Close()
+	records = make([][]string, 0, reader.NumRows())
+	rows := make([]parquet.Row, parquetReadRows)
+	for {
+		n, err := reader.ReadRows(rows)
+		for _, row := range rows[:n] {
+			record := make([]string, len(fields))
+			for _, v := range row {
+				if !v.IsNull() && v.Column() < len(fields) {
+					record[v.Column()] = parquetValue(v, fields[v.Column()].Type().LogicalType())
+				}
+			}
+			records = append(records, record)
+		}
+		if errors.Is(err, io.EOF) {
+			return header, records, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid parquet: %w", err)
+		}
+	}
+}
+
+// parquetValue formats a value of a column with the given logical type.
+// Booleans become 1 and 0, dates and timestamps are written in UTC as
+// "2006-01-02" and "2006-01-02 15:04:05.999999999", and decimals in full.
+func parquetValue(v parquet.Value, logical *format.LogicalType) string {
+	if v.Kind() == parquet.Boolean {
+		if v.Boolean() {
+			return "1"
+		}
+		return "0"
+	}
+	if v.Kind() == parquet.Int96 {
+		// Legacy timestamps: nanoseconds of the day, then the Julian day
+		i := v.Int96()
+		nanos := int64(i[1])<<32 | int64(i[0])
+		days := int64(i[2]) - parquetJulianUnixEpoch
+		return formatParquetTime(time.Unix(days*86400, nanos))
+	}
+	if logical == nil {
+		return v.String()
+	}
+
+	switch t := logical.Value.(type) {
+	case *format.DateType:
+		return time.Unix(int64(v.Int32())*86400, 0).UTC().Format(time.DateOnly)
+	case *format.TimestampType:
+		n := v.Int64()
+		switch t.Unit.Value.(type) {
+		case *format.MilliSeconds:
+			return formatParquetTime(time.UnixMilli(n))
+		case *format.MicroSeconds:
+			return formatParquetTime(time.UnixMicro(n))
+		default:
+			return formatParquetTime(time.Unix(0, n))
+		}
+	case *format.DecimalType:
+		var unscaled big.Int
+		switch v.Kind() {
+		case parquet.Int32:
+			unscaled.SetInt64(int64(v.Int32()))
+		case parquet.Int64:
+			unscaled.SetInt64(v.Int64())
+		default:
+			// Big-endian two's complement
+			b := v.ByteArray()
+			unscaled.SetBytes(b)
+			if len(b) > 0 && b[0]&0x80 != 0 {
+				unscaled.Sub(&unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+			}
+		}
+		return formatDecimal(&unscaled, int(t.Scale))
+	}
+	return v.String()
+}
+
+func formatParquetTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.999999999")
+}
+
+// formatDecimal writes unscaled / 10^scale without rounding
+func formatDecimal(unscaled *big.Int, scale int) string {
+	digits := new(big.Int).Abs(unscaled).String()
+	if scale <= 0 {
+		return unscaled.String() + strings.Repeat("0", -scale)
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	s := digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	if unscaled.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
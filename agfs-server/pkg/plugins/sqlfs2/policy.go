@@ -0,0 +1,343 @@
+package sqlfs2
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// Statement kinds used by the statement policy
+const (
+	stmtSelect   = "select"
+	stmtShow     = "show"
+	stmtDescribe = "describe"
+	stmtExplain  = "explain"
+	stmtInsert   = "insert"
+	stmtUpdate   = "update"
+	stmtDelete   = "delete"
+	stmtReplace  = "replace"
+	stmtDDL      = "ddl"   // CREATE, ALTER, DROP, TRUNCATE, RENAME
+	stmtOther    = "other" // SET, PRAGMA, GRANT, CALL, SELECT ... INTO, ...
+)
+
+var statementKinds = []string{
+	stmtSelect, stmtShow, stmtDescribe, stmtExplain,
+	stmtInsert, stmtUpdate, stmtDelete, stmtReplace, stmtDDL, stmtOther,
+}
+
+// isReadKind reports whether statements of kind never modify data
+func isReadKind(kind string) bool {
+	switch kind {
+	case stmtSelect, stmtShow, stmtDescribe, stmtExplain:
+		return true
+	}
+	return false
+}
+
+// sqlStatement is the result of classifying a single SQL statement
+type sqlStatement struct {
+	kind     string // One of the stmt* constants
+	keyword  string // Leading keyword, for error messages
+	hasWhere bool   // UPDATE/DELETE has a top-level WHERE clause
+}
+
+// sqlToken is a lexical token. Words are upper-cased; string literals and
+// quoted identifiers are opaque and never match a keyword.
+type sqlToken struct {
+	text   string
+	word   bool
+	quoted bool
+}
+
+// tokenizeSQL splits sql into tokens, dropping comments and whitespace.
+// backslashEscapes selects MySQL string escaping; SQLite treats a backslash
+// as an ordinary character, and getting this wrong would let a statement hide
+// inside what the policy believes is a string literal.
+func tokenizeSQL(sql string, backslashEscapes bool) ([]sqlToken, error) {
+	var tokens []sqlToken
+	i := 0
+	for i < len(sql) {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-', c == '#':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			// MySQL executes the body of /*! ... */ comments
+			if i+2 < len(sql) && sql[i+2] == '!' {
+				return nil, fmt.Errorf("executable comments are not allowed")
+			}
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+			i += end + 4
+
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			for {
+				if j >= len(sql) {
+					return nil, fmt.Errorf("unterminated quoted string")
+				}
+				if backslashEscapes && c != '`' && sql[j] == '\\' {
+					j += 2
+					continue
+				}
+				if sql[j] == c {
+					// A doubled quote is an escaped quote
+					if j+1 < len(sql) && sql[j+1] == c {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			tokens = append(tokens, sqlToken{text: sql[i : j+1], quoted: true})
+			i = j + 1
+
+		case isWordByte(c):
+			j := i
+			for j < len(sql) && isWordByte(sql[j]) {
+				j++
+			}
+			tokens = append(tokens, sqlToken{text: strings.ToUpper(sql[i:j]), word: true})
+			i = j
+
+		default:
+			tokens = append(tokens, sqlToken{text: string(c)})
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// parseStatements splits sql on top-level semicolons and classifies each statement
+func parseStatements(sql string, backslashEscapes bool) ([]sqlStatement, error) {
+	tokens, err := tokenizeSQL(sql, backslashEscapes)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []sqlStatement
+	start := 0
+	for i := 0; i <= len(tokens); i++ {
+		if i < len(tokens) && tokens[i].text != ";" {
+			continue
+		}
+		if i > start {
+			statements = append(statements, classifyStatement(tokens[start:i]))
+		}
+		start = i + 1
+	}
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("empty SQL statement")
+	}
+	return statements, nil
+}
+
+// classifyStatement determines the kind of a single statement
+func classifyStatement(tokens []sqlToken) sqlStatement {
+	// Parenthesized queries: (SELECT ...) UNION (SELECT ...)
+	for len(tokens) > 0 && tokens[0].text == "(" {
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 || !tokens[0].word {
+		return sqlStatement{kind: stmtOther}
+	}
+
+	keyword := tokens[0].text
+	switch keyword {
+	case "SELECT", "VALUES", "TABLE":
+		if hasTopLevelWord(tokens, "INTO") {
+			return sqlStatement{kind: stmtOther, keyword: keyword + " INTO"}
+		}
+		return sqlStatement{kind: stmtSelect, keyword: keyword}
+
+	case "WITH":
+		// The main statement is the first top-level DML keyword after the CTEs
+		for i, depth := 1, 0; i < len(tokens); i++ {
+			switch tokens[i].text {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			case "SELECT", "INSERT", "UPDATE", "DELETE", "REPLACE":
+				if depth == 0 && tokens[i].word {
+					stmt := classifyStatement(tokens[i:])
+					stmt.keyword = "WITH " + stmt.keyword
+					return stmt
+				}
+			}
+		}
+		return sqlStatement{kind: stmtOther, keyword: keyword}
+
+	case "SHOW":
+		return sqlStatement{kind: stmtShow, keyword: keyword}
+
+	case "EXPLAIN", "DESCRIBE", "DESC":
+		return classifyExplain(tokens)
+
+	case "INSERT":
+		return sqlStatement{kind: stmtInsert, keyword: keyword}
+
+	case "REPLACE":
+		return sqlStatement{kind: stmtReplace, keyword: keyword}
+
+	case "UPDATE":
+		return sqlStatement{kind: stmtUpdate, keyword: keyword, hasWhere: hasTopLevelWord(tokens, "WHERE")}
+
+	case "DELETE":
+		return sqlStatement{kind: stmtDelete, keyword: keyword, hasWhere: hasTopLevelWord(tokens, "WHERE")}
+
+	case "CREATE", "ALTER", "DROP", "TRUNCATE", "RENAME":
+		return sqlStatement{kind: stmtDDL, keyword: keyword}
+	}
+	return sqlStatement{kind: stmtOther, keyword: keyword}
+}
+
+// classifyExplain handles EXPLAIN/DESCRIBE. A plain plan request is a read even
+// for DML, but EXPLAIN ANALYZE runs the statement and takes on its kind.
+func classifyExplain(tokens []sqlToken) sqlStatement {
+	keyword := tokens[0].text
+	kind := stmtExplain
+	if keyword != "EXPLAIN" {
+		kind = stmtDescribe
+	}
+
+	analyze := false
+	for i := 1; i < len(tokens); i++ {
+		if !tokens[i].word {
+			continue
+		}
+		switch tokens[i].text {
+		case "ANALYZE":
+			analyze = true
+		case "SELECT", "INSERT", "UPDATE", "DELETE", "REPLACE", "WITH", "TABLE", "VALUES":
+			inner := classifyStatement(tokens[i:])
+			if analyze && !isReadKind(inner.kind) {
+				inner.keyword = keyword + " ANALYZE " + inner.keyword
+				return inner
+			}
+			return sqlStatement{kind: kind, keyword: keyword}
+		}
+	}
+	return sqlStatement{kind: kind, keyword: keyword}
+}
+
+// hasTopLevelWord reports whether word appears outside any parentheses
+func hasTopLevelWord(tokens []sqlToken, word string) bool {
+	depth := 0
+	for _, tok := range tokens {
+		switch {
+		case tok.text == "(":
+			depth++
+		case tok.text == ")":
+			depth--
+		case depth == 0 && tok.word && tok.text == word:
+			return true
+		}
+	}
+	return false
+}
+
+// statementPolicy restricts which SQL statements a mount may execute.
+// It is a guard for exposing sqlfs2 to untrusted agents and is enforced on
+// every statement before it reaches the database; it is not a substitute for
+// database-level privileges.
+type statementPolicy struct {
+	readOnly         bool            // Only SELECT, SHOW, DESCRIBE and EXPLAIN
+	denyDDL          bool            // Reject CREATE, ALTER, DROP, TRUNCATE, RENAME
+	requireWhere     bool            // Reject UPDATE and DELETE without a WHERE clause
+	allowed          map[string]bool // Allowed statement kinds, empty means all
+	backslashEscapes bool            // Backend treats backslash as a string escape
+}
+
+// newStatementPolicy builds the policy from plugin configuration
+func newStatementPolicy(cfg map[string]interface{}, backendType string) (*statementPolicy, error) {
+	p := &statementPolicy{
+		readOnly:         config.GetBoolConfig(cfg, "read_only", false),
+		denyDDL:          config.GetBoolConfig(cfg, "deny_ddl", false),
+		requireWhere:     config.GetBoolConfig(cfg, "require_where", false),
+		backslashEscapes: backendType != "sqlite" && backendType != "sqlite3",
+	}
+
+	if list := config.GetStringConfig(cfg, "allowed_statements", ""); list != "" {
+		p.allowed = make(map[string]bool)
+		for _, kind := range strings.Split(list, ",") {
+			kind = strings.ToLower(strings.TrimSpace(kind))
+			if kind == "" {
+				continue
+			}
+			if !isStatementKind(kind) {
+				return nil, fmt.Errorf("invalid allowed_statements entry %q (valid: %s)", kind, strings.Join(statementKinds, ", "))
+			}
+			p.allowed[kind] = true
+		}
+	}
+	return p, nil
+}
+
+func isStatementKind(kind string) bool {
+	for _, k := range statementKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// active reports whether the policy restricts anything
+func (p *statementPolicy) active() bool {
+	return p != nil && (p.readOnly || p.denyDDL || p.requireWhere || len(p.allowed) > 0)
+}
+
+// Check parses sql and returns a permission denied error for the first
+// statement the policy does not allow. path is used for error context.
+func (p *statementPolicy) Check(path, sql string) error {
+	if !p.active() {
+		return nil
+	}
+
+	statements, err := parseStatements(sql, p.backslashEscapes)
+	if err != nil {
+		return filesystem.NewInvalidArgumentError("sql", sql, err.Error())
+	}
+	for _, stmt := range statements {
+		if reason := p.deny(stmt); reason != "" {
+			return filesystem.NewPermissionDeniedError("query", path, reason)
+		}
+	}
+	return nil
+}
+
+// deny returns why stmt is rejected, or "" if it is allowed
+func (p *statementPolicy) deny(stmt sqlStatement) string {
+	name := stmt.keyword
+	if name == "" {
+		name = "statement"
+	}
+	switch {
+	case p.readOnly && !isReadKind(stmt.kind):
+		return fmt.Sprintf("%s not allowed: mount is read-only", name)
+	case p.denyDDL && stmt.kind == stmtDDL:
+		return fmt.Sprintf("%s not allowed: DDL is disabled", name)
+	case len(p.allowed) > 0 && !p.allowed[stmt.kind]:
+		return fmt.Sprintf("%s not allowed: %s statements are not in allowed_statements", name, stmt.kind)
+	case p.requireWhere && (stmt.kind == stmtUpdate || stmt.kind == stmtDelete) && !stmt.hasWhere:
+		return fmt.Sprintf("%s without WHERE clause not allowed", name)
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package sqlfs2
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func TestSQLFS2ParseStatementsClassifies(t *testing.T) {
+	tests := []struct {
+		sql      string
+		kind     string
+		hasWhere bool
+	}{
+		{"SELECT * FROM users", stmtSelect, false},
+		{"  (SELECT 1) UNION (SELECT 2)", stmtSelect, false},
+		{"select * from t into outfile '/tmp/x'", stmtOther, false},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", stmtSelect, false},
+		{"WITH x AS (SELECT id FROM t) DELETE FROM t WHERE id IN (SELECT id FROM x)", stmtDelete, true},
+		{"SHOW TABLES", stmtShow, false},
+		{"DESC users", stmtDescribe, false},
+		{"EXPLAIN DELETE FROM users", stmtExplain, false},
+		{"EXPLAIN ANALYZE DELETE FROM users", stmtDelete, false},
+		{"EXPLAIN ANALYZE SELECT 1", stmtExplain, false},
+		{"INSERT INTO t VALUES (1)", stmtInsert, false},
+		{"UPDATE t SET a = (SELECT b FROM u WHERE u.id = 1)", stmtUpdate, false},
+		{"DELETE FROM t WHERE id = 1", stmtDelete, true},
+		{"delete from t -- where id = 1", stmtDelete, false},
+		{"DELETE FROM t /* where */", stmtDelete, false},
+		{"DELETE FROM `where`", stmtDelete, false},
+		{"DROP TABLE t", stmtDDL, false},
+		{"truncate t", stmtDDL, false},
+		{"PRAGMA journal_mode = WAL", stmtOther, false},
+	}
+	for _, tt := range tests {
+		stmts, err := parseStatements(tt.sql, true)
+		if err != nil {
+			t.Fatalf("parseStatements(%q) error = %v", tt.sql, err)
+		}
+		if len(stmts) != 1 {
+			t.Fatalf("parseStatements(%q) returned %d statements", tt.sql, len(stmts))
+		}
+		if stmts[0].kind != tt.kind || stmts[0].hasWhere != tt.hasWhere {
+			t.Errorf("parseStatements(%q) = %+v, want kind %s hasWhere %v", tt.sql, stmts[0], tt.kind, tt.hasWhere)
+		}
+	}
+}
+
+func TestSQLFS2ParseStatementsSplitsAndQuotes(t *testing.T) {
+	stmts, err := parseStatements("SELECT ';'; DROP TABLE t;", true)
+	if err != nil {
+		t.Fatalf("parseStatements() error = %v", err)
+	}
+	if len(stmts) != 2 || stmts[1].kind != stmtDDL {
+		t.Fatalf("parseStatements() = %+v, want SELECT then DDL", stmts)
+	}
+
+	// In SQLite a backslash does not escape the quote, so the DROP is a real statement
+	const hidden = `SELECT 'a\'; DROP TABLE t; --'`
+	stmts, err = parseStatements(hidden, false)
+	if err != nil {
+		t.Fatalf("parseStatements() error = %v", err)
+	}
+	if len(stmts) != 2 || stmts[1].kind != stmtDDL {
+		t.Fatalf("sqlite parse = %+v, want SELECT then DDL", stmts)
+	}
+
+	for _, sql := range []string{"SELECT 'unterminated", "SELECT 1 /*! DROP TABLE t */", "  ;  "} {
+		if _, err := parseStatements(sql, true); err == nil {
+			t.Errorf("parseStatements(%q) should fail", sql)
+		}
+	}
+}
+
+func TestSQLFS2StatementPolicyDeny(t *testing.T) {
+	newPolicy := func(cfg map[string]interface{}) *statementPolicy {
+		p, err := newStatementPolicy(cfg, "mysql")
+		if err != nil {
+			t.Fatalf("newStatementPolicy(%v) error = %v", cfg, err)
+		}
+		return p
+	}
+
+	tests := []struct {
+		cfg     map[string]interface{}
+		sql     string
+		allowed bool
+	}{
+		{map[string]interface{}{}, "DROP TABLE t", true},
+		{map[string]interface{}{"read_only": true}, "SELECT * FROM t", true},
+		{map[string]interface{}{"read_only": true}, "EXPLAIN DELETE FROM t", true},
+		{map[string]interface{}{"read_only": true}, "INSERT INTO t VALUES (1)", false},
+		{map[string]interface{}{"read_only": true}, "SELECT 1; DELETE FROM t WHERE id = 1", false},
+		{map[string]interface{}{"read_only": true}, "EXPLAIN ANALYZE DELETE FROM t", false},
+		{map[string]interface{}{"deny_ddl": true}, "INSERT INTO t VALUES (1)", true},
+		{map[string]interface{}{"deny_ddl": true}, "ALTER TABLE t ADD c INT", false},
+		{map[string]interface{}{"require_where": true}, "DELETE FROM t WHERE id = 1", true},
+		{map[string]interface{}{"require_where": true}, "DELETE FROM t", false},
+		{map[string]interface{}{"require_where": true}, "UPDATE t SET a = 1", false},
+		{map[string]interface{}{"allowed_statements": "select, insert"}, "INSERT INTO t VALUES (1)", true},
+		{map[string]interface{}{"allowed_statements": "select, insert"}, "UPDATE t SET a = 1 WHERE id = 1", false},
+	}
+	for _, tt := range tests {
+		err := newPolicy(tt.cfg).Check("/1/query", tt.sql)
+		if tt.allowed && err != nil {
+			t.Errorf("%v: Check(%q) error = %v, want allowed", tt.cfg, tt.sql, err)
+		}
+		if !tt.allowed && !errors.Is(err, filesystem.ErrPermissionDenied) {
+			t.Errorf("%v: Check(%q) error = %v, want permission denied", tt.cfg, tt.sql, err)
+		}
+	}
+
+	if _, err := newStatementPolicy(map[string]interface{}{"allowed_statements": "select,merge"}, "mysql"); err == nil {
+		t.Error("newStatementPolicy() accepted unknown statement kind")
+	}
+}
+
+func TestSQLFS2ReadOnlyMountEnforcesPolicy(t *testing.T) {
+	plugin := NewSQLFS2Plugin()
+	err := plugin.Initialize(map[string]interface{}{
+		"backend":   "sqlite",
+		"db_path":   filepath.Join(t.TempDir(), "sqlfs2.db"),
+		"read_only": true,
+	})
+	if err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	t.Cleanup(func() { plugin.Shutdown() })
+	fs := plugin.GetFileSystem().(*sqlfs2FS)
+	mustExecSQL(t, plugin.db, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+
+	sidData, err := readSessionFile(t, fs, "/main/users/ctl")
+	if err != nil {
+		t.Fatalf("Read(ctl) error = %v", err)
+	}
+	base := "/main/users/" + strings.TrimSpace(string(sidData))
+
+	if _, err := fs.Write(base+"/query", []byte("SELECT * FROM users"), -1, 0); err != nil {
+		t.Fatalf("SELECT on read-only mount error = %v", err)
+	}
+
+	_, err = fs.Write(base+"/query", []byte("DELETE FROM users WHERE id = 1"), -1, 0)
+	if !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Fatalf("DELETE on read-only mount error = %v, want permission denied", err)
+	}
+	if errMsg, _ := readSessionFile(t, fs, base+"/error"); !strings.Contains(string(errMsg), "read-only") {
+		t.Fatalf("error file = %q, want read-only reason", errMsg)
+	}
+
+	_, err = fs.Write(base+"/data", []byte(`{"id": 1, "name": "Alice"}`), -1, 0)
+	if !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Fatalf("data insert on read-only mount error = %v, want permission denied", err)
+	}
+
+	if err := fs.RemoveAll("/main/users"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Fatalf("RemoveAll(table) on read-only mount error = %v, want permission denied", err)
+	}
+	if !tableExistsInSQLite(t, plugin.db, "users") {
+		t.Fatal("users table was dropped on read-only mount")
+	}
+}
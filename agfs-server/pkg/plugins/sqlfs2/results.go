@@ -0,0 +1,486 @@
+package sqlfs2
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+const (
+	// defaultMaxResultSize is the largest query result kept in a session;
+	// larger results are streamed from the database when read
+	defaultMaxResultSize = 16 * 1024 * 1024
+
+	// defaultPageSize is the number of rows per page of result?page=N
+	defaultPageSize = 1000
+
+	// resultChunkSize is how much of a result is encoded at a time while
+	// skipping to an offset or streaming it through OpenStream
+	resultChunkSize = 64 * 1024
+)
+
+// resultPage selects the rows of a query result read through a page suffix:
+// result?page=3, result?page=3&limit=50 or result?limit=50&offset=100
+type resultPage struct {
+	limit  int64 // Rows in the page, 0 for all of them
+	offset int64 // Rows skipped before the page
+}
+
+func (p resultPage) paged() bool {
+	return p.limit > 0 || p.offset > 0
+}
+
+// splitPage splits the page suffix off the query and result files of a
+// session. Writing SQL to query?page=3 runs it for its third page only,
+// reading result?page=3 reads the third page of the last query.
+func (fs *sqlfs2FS) splitPage(path string) (string, resultPage, error) {
+	i := strings.LastIndexByte(path, '?')
+	if i < 0 || strings.Contains(path[i:], "/") {
+		return path, resultPage{}, nil
+	}
+	base := path[:i]
+	if name := base[strings.LastIndexByte(base, '/')+1:]; name != "query" && name != "result" {
+		return "", resultPage{}, filesystem.NewInvalidArgumentError("path", path, "only query and result files take a page suffix")
+	}
+	page, err := parsePage(path[i+1:], fs.plugin.pageSize)
+	if err != nil {
+		return "", resultPage{}, filesystem.NewInvalidArgumentError("path", path, err.Error())
+	}
+	return base, page, nil
+}
+
+// parsePage parses the parameters of a page suffix. With page, limit sets
+// the page size instead of pageSize.
+func parsePage(suffix string, pageSize int64) (resultPage, error) {
+	values, err := url.ParseQuery(suffix)
+	if err != nil {
+		return resultPage{}, err
+	}
+	params := make(map[string]int64, len(values))
+	for key, vals := range values {
+		if key != "page" && key != "limit" && key != "offset" {
+			return resultPage{}, fmt.Errorf("unknown page parameter %q", key)
+		}
+		n, err := strconv.ParseInt(vals[len(vals)-1], 10, 64)
+		if err != nil || n < 0 {
+			return resultPage{}, fmt.Errorf("%s must be a non-negative integer", key)
+		}
+		params[key] = n
+	}
+
+	page := resultPage{limit: params["limit"], offset: params["offset"]}
+	if n, ok := params["page"]; ok {
+		if n < 1 {
+			return resultPage{}, fmt.Errorf("page must be at least 1")
+		}
+		if _, ok := params["offset"]; ok {
+			return resultPage{}, fmt.Errorf("page and offset can't be combined")
+		}
+		if page.limit == 0 {
+			page.limit = pageSize
+		}
+		page.offset = (n - 1) * page.limit
+	}
+	return page, nil
+}
+
+// pagedSQL restricts a SELECT to a page of its rows. Other statements can't
+// be nested, and are paged while their rows are read instead.
+func pagedSQL(query string, page resultPage) (string, bool) {
+	query = strings.TrimRight(strings.TrimSpace(query), "; \t\n")
+	if !page.paged() || !strings.HasPrefix(strings.ToUpper(query), "SELECT") {
+		return query, false
+	}
+	limit := page.limit
+	if limit == 0 {
+		// Both backends require a LIMIT with OFFSET
+		limit = math.MaxInt64
+	}
+	return fmt.Sprintf("SELECT * FROM (%s) AS agfs_page LIMIT %d OFFSET %d", query, limit, page.offset), true
+}
+
+// resultCursor encodes the rows of a query result as they are read, in the
+// indented JSON array written to result files. The encoded bytes not read
+// yet are kept in pending, which starts at offset pos of the result.
+type resultCursor struct {
+	rows    *sql.Rows
+	columns []string
+	page    resultPage // Page of the query the cursor reads
+	skip    int64      // Rows to skip before the page, when not done in SQL
+	left    int64      // Rows left in the page, -1 for all of them
+	count   int64      // Rows encoded so far
+	pending []byte
+	pos     int64
+	done    bool
+}
+
+// openResultCursor runs query in tx for page of its rows
+func openResultCursor(tx *sql.Tx, query string, page resultPage) (*resultCursor, error) {
+	c := &resultCursor{page: page, left: -1}
+	stmt, paged := pagedSQL(query, page)
+	rows, err := tx.Query(stmt)
+	if err != nil && paged {
+		// Queries whose columns can't form a derived table, e.g. joins
+		// with duplicate column names on MySQL, are paged while read
+		rows, err = tx.Query(query)
+		paged = false
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	if !paged && page.paged() {
+		c.skip = page.offset
+		if page.limit > 0 {
+			c.left = page.limit
+		}
+	}
+
+	c.rows = rows
+	c.columns, err = rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	return c, nil
+}
+
+// fill encodes rows until n bytes are pending or the result is complete
+func (c *resultCursor) fill(n int) error {
+	values := make([]interface{}, len(c.columns))
+	valuePtrs := make([]interface{}, len(c.columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	for len(c.pending) < n && !c.done {
+		if c.left == 0 || !c.rows.Next() {
+			if err := c.rows.Err(); err != nil {
+				return fmt.Errorf("rows error: %w", err)
+			}
+			c.close()
+			if c.count == 0 {
+				c.pending = append(c.pending, "[]\n"...)
+			} else {
+				c.pending = append(c.pending, "\n]\n"...)
+			}
+			c.done = true
+			break
+		}
+		if err := c.rows.Scan(valuePtrs...); err != nil {
+			return fmt.Errorf("scan error: %w", err)
+		}
+		if c.skip > 0 {
+			c.skip--
+			continue
+		}
+		if c.left > 0 {
+			c.left--
+		}
+
+		row := make(map[string]interface{}, len(c.columns))
+		for i, col := range c.columns {
+			if b, ok := values[i].([]byte); ok {
+				row[col] = string(b)
+			} else {
+				row[col] = values[i]
+			}
+		}
+		data, err := json.MarshalIndent(row, "  ", "  ")
+		if err != nil {
+			return fmt.Errorf("json marshal error: %w", err)
+		}
+		if c.count == 0 {
+			c.pending = append(c.pending, "[\n  "...)
+		} else {
+			c.pending = append(c.pending, ",\n  "...)
+		}
+		c.pending = append(c.pending, data...)
+		c.count++
+	}
+	return nil
+}
+
+// readAt returns up to size bytes of the result at offset, which must not
+// be before the cursor position
+func (c *resultCursor) readAt(offset int64, size int) ([]byte, error) {
+	for {
+		if drop := min(offset-c.pos, int64(len(c.pending))); drop > 0 {
+			c.pending = c.pending[drop:]
+			c.pos += drop
+		}
+		if c.pos == offset || c.done {
+			break
+		}
+		if err := c.fill(resultChunkSize); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.fill(size); err != nil {
+		return nil, err
+	}
+
+	n := min(size, len(c.pending))
+	data := bytes.Clone(c.pending[:n])
+	c.pending = c.pending[n:]
+	c.pos += int64(n)
+	return data, nil
+}
+
+// exhausted reports whether the whole result was read
+func (c *resultCursor) exhausted() bool {
+	return c.done && len(c.pending) == 0
+}
+
+func (c *resultCursor) close() {
+	if c.rows != nil {
+		c.rows.Close()
+		c.rows = nil
+	}
+}
+
+// closeCursor closes the rows of a result being read, before the session
+// transaction is used for anything else. Must be called with mu held.
+func (s *Session) closeCursor() {
+	if s.cursor != nil {
+		s.cursor.close()
+		s.cursor = nil
+	}
+}
+
+// writeQuery executes the SQL written to the query file of a session and
+// stores its result. Must be called with session.mu held.
+func (fs *sqlfs2FS) writeQuery(path string, session *Session, data []byte, page resultPage) (int64, error) {
+	sqlStmt := strings.TrimSpace(string(data))
+	if sqlStmt == "" {
+		session.lastError = "empty SQL statement"
+		return 0, fmt.Errorf("empty SQL statement")
+	}
+	session.planQuery = sqlStmt
+	session.result = nil
+	session.resultQuery = ""
+	session.streamed = false
+	if err := fs.plugin.policy.Check(path, sqlStmt); err != nil {
+		session.lastError = err.Error()
+		return 0, err
+	}
+
+	if isQueryStatement(sqlStmt) {
+		if err := fs.runQuery(session, sqlStmt, page); err != nil {
+			session.lastError = err.Error()
+			return 0, err
+		}
+		session.lastError = ""
+		return int64(len(data)), nil
+	}
+
+	if page.paged() {
+		err := fmt.Errorf("only statements returning rows can be paged")
+		session.lastError = err.Error()
+		return 0, err
+	}
+	result, err := session.tx.Exec(sqlStmt)
+	if err != nil {
+		session.lastError = err.Error()
+		return 0, fmt.Errorf("execution error: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	lastInsertId, _ := result.LastInsertId()
+	jsonData, _ := json.MarshalIndent(map[string]interface{}{
+		"rows_affected":  rowsAffected,
+		"last_insert_id": lastInsertId,
+	}, "", "  ")
+	session.result = append(jsonData, '\n')
+	session.lastError = ""
+	return int64(len(data)), nil
+}
+
+// runQuery runs a statement returning rows for page of its result. Results
+// up to max_result_size are kept in the session; the rows of larger ones are
+// left to the first read, and later reads run the query again, so they are
+// never held in memory at once. Must be called with session.mu held.
+func (fs *sqlfs2FS) runQuery(session *Session, query string, page resultPage) error {
+	cursor, err := openResultCursor(session.tx, query, page)
+	if err != nil {
+		return err
+	}
+	maxSize := fs.plugin.maxResultSize
+	if maxSize == 0 {
+		maxSize = math.MaxInt - 1
+	}
+	if err := cursor.fill(maxSize + 1); err != nil {
+		cursor.close()
+		return err
+	}
+
+	session.resultQuery = query
+	session.resultPage = page
+	if cursor.done && len(cursor.pending) <= maxSize {
+		session.result = cursor.pending
+		return nil
+	}
+	session.streamed = true
+	session.cursor = cursor
+	return nil
+}
+
+// readResult reads the result file of a session, or a page of its last
+// query. Streamed results and pages are encoded from the query rows as they
+// are read: reading on where the previous read ended continues with the
+// same rows, reading before it runs the query again.
+func (fs *sqlfs2FS) readResult(session *Session, page resultPage, offset, size int64) ([]byte, error) {
+	session.mu.Lock()
+	defer session.UnlockWithTouch()
+
+	if !page.paged() {
+		if !session.streamed {
+			if session.result == nil {
+				return []byte{}, nil
+			}
+			return plugin.ApplyRangeRead(session.result, offset, size)
+		}
+		page = session.resultPage
+	} else if session.resultQuery == "" {
+		return nil, fmt.Errorf("no result to page: write a query returning rows first")
+	}
+
+	maxSize := fs.plugin.maxResultSize
+	n := math.MaxInt
+	if size >= 0 {
+		n = int(min(size, math.MaxInt32))
+	} else if maxSize > 0 {
+		n = maxSize + 1
+	}
+	offset = max(offset, 0)
+
+	c := session.cursor
+	if c == nil || c.page != page || offset < c.pos {
+		session.closeCursor()
+		var err error
+		if c, err = openResultCursor(session.tx, session.resultQuery, page); err != nil {
+			return nil, err
+		}
+		session.cursor = c
+	}
+	data, err := c.readAt(offset, n)
+	if err != nil || c.exhausted() {
+		session.closeCursor()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 && maxSize > 0 && len(data) > maxSize {
+		session.closeCursor()
+		return nil, fmt.Errorf("result larger than max_result_size (%d bytes): read it in ranges, as a stream or by page (result?page=N)", maxSize)
+	}
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+	return data, nil
+}
+
+// resultSession returns the session and page of a result file, and false
+// for other paths
+func (fs *sqlfs2FS) resultSession(path string) (*Session, resultPage, bool, error) {
+	if _, _, ok := parseJobPath(path); ok {
+		return nil, resultPage{}, false, nil
+	}
+	if _, ok := parseRowPath(path); ok {
+		return nil, resultPage{}, false, nil
+	}
+	path, page, err := fs.splitPage(path)
+	if err != nil {
+		return nil, resultPage{}, false, err
+	}
+	dbName, tableName, sid, operation, err := fs.parsePath(path)
+	if err != nil || sid == "" || operation != "result" {
+		return nil, resultPage{}, false, nil
+	}
+	session := fs.sessionManager.GetSession(dbName, tableName, sid)
+	if session == nil {
+		return nil, resultPage{}, true, fmt.Errorf("session not found: %s", sid)
+	}
+	return session, page, true, nil
+}
+
+// resultReader reads a result file from start to end without holding it in
+// memory
+type resultReader struct {
+	fs      *sqlfs2FS
+	session *Session
+	page    resultPage
+	offset  int64
+}
+
+func (r *resultReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	data, err := r.fs.readResult(r.session, r.page, r.offset, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	r.offset += int64(len(data))
+	return copy(p, data), nil
+}
+
+func (r *resultReader) Close() error {
+	r.session.mu.Lock()
+	defer r.session.mu.Unlock()
+	if c := r.session.cursor; c != nil && c.page == r.page && c.pos == r.offset {
+		r.session.closeCursor()
+	}
+	return nil
+}
+
+// resultStream serves a result file to streaming readers
+type resultStream struct {
+	reader *resultReader
+	buf    []byte
+}
+
+// ReadChunk implements filesystem.StreamReader. Rows are read as fast as
+// the database returns them, so the timeout is not used.
+func (s *resultStream) ReadChunk(timeout time.Duration) ([]byte, bool, error) {
+	n, err := s.reader.Read(s.buf)
+	if err == io.EOF {
+		return nil, true, io.EOF
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return bytes.Clone(s.buf[:n]), false, nil
+}
+
+func (s *resultStream) Close() error {
+	return s.reader.Close()
+}
+
+// OpenStream implements filesystem.Streamer for result files, whose
+// results may be too large to read at once
+func (fs *sqlfs2FS) OpenStream(path string) (filesystem.StreamReader, error) {
+	session, page, ok, err := fs.resultSession(path)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, filesystem.NewNotSupportedError("openstream", path)
+	}
+	return &resultStream{
+		reader: &resultReader{fs: fs, session: session, page: page},
+		buf:    make([]byte, resultChunkSize),
+	}, nil
+}
+
+// Ensure sqlfs2FS implements Streamer interface
+var _ filesystem.Streamer = (*sqlfs2FS)(nil)
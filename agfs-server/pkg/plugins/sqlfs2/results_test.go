@@ -0,0 +1,211 @@
+package sqlfs2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// newItemsSessionForTest creates a table of n items and a session bound to it
+func newItemsSessionForTest(t *testing.T, n int) (*SQLFS2Plugin, *sqlfs2FS, string) {
+	t.Helper()
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+	mustExecSQL(t, plugin.db, "CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)")
+	for i := 1; i <= n; i++ {
+		mustExecSQL(t, plugin.db, "INSERT INTO items VALUES (?, ?)", i, fmt.Sprintf("item-%d", i))
+	}
+	sidData, err := readSessionFile(t, fs, "/main/items/ctl")
+	if err != nil {
+		t.Fatalf("Read(ctl) error = %v", err)
+	}
+	return plugin, fs, "/main/items/" + strings.TrimSpace(string(sidData))
+}
+
+func resultIDs(t *testing.T, data []byte) []int {
+	t.Helper()
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		t.Fatalf("result is not a JSON array: %v\n%s", err, data)
+	}
+	ids := make([]int, len(rows))
+	for i, row := range rows {
+		ids[i] = int(row["id"].(float64))
+	}
+	return ids
+}
+
+func requireIDs(t *testing.T, data []byte, from, to int) {
+	t.Helper()
+	ids := resultIDs(t, data)
+	if len(ids) != to-from+1 {
+		t.Fatalf("result has ids %v, want %d..%d", ids, from, to)
+	}
+	for i, id := range ids {
+		if id != from+i {
+			t.Fatalf("result has ids %v, want %d..%d", ids, from, to)
+		}
+	}
+}
+
+func TestSQLFS2SmallResultsKeepTheirFormat(t *testing.T) {
+	_, fs, base := newItemsSessionForTest(t, 3)
+
+	if _, err := fs.Write(base+"/query", []byte("SELECT id, name FROM items ORDER BY id"), -1, 0); err != nil {
+		t.Fatalf("Write(query) error = %v", err)
+	}
+	data, err := readSessionFile(t, fs, base+"/result")
+	if err != nil {
+		t.Fatalf("Read(result) error = %v", err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.MarshalIndent(rows, "", "  ")
+	if string(data) != string(want)+"\n" {
+		t.Fatalf("result =\n%s\nwant\n%s", data, want)
+	}
+
+	if _, err := fs.Write(base+"/query", []byte("SELECT id FROM items WHERE id > 10"), -1, 0); err != nil {
+		t.Fatalf("Write(query) error = %v", err)
+	}
+	if data, _ := readSessionFile(t, fs, base+"/result"); string(data) != "[]\n" {
+		t.Fatalf("empty result = %q, want []", data)
+	}
+}
+
+func TestSQLFS2StreamsLargeResults(t *testing.T) {
+	plugin, fs, base := newItemsSessionForTest(t, 200)
+	plugin.maxResultSize = 1024
+
+	if _, err := fs.Write(base+"/query", []byte("SELECT id, name FROM items ORDER BY id"), -1, 0); err != nil {
+		t.Fatalf("Write(query) error = %v", err)
+	}
+	session := fs.sessionManager.GetSession("main", "items", strings.TrimPrefix(base, "/main/items/"))
+	if !session.streamed || session.result != nil {
+		t.Fatalf("result of %d bytes max was kept in memory", plugin.maxResultSize)
+	}
+
+	// Too large to read at once
+	if _, err := fs.Read(base+"/result", 0, -1); err == nil || !strings.Contains(err.Error(), "max_result_size") {
+		t.Fatalf("Read(result) of a streamed result error = %v", err)
+	}
+
+	r, err := fs.Open(base + "/result")
+	if err != nil {
+		t.Fatalf("Open(result) error = %v", err)
+	}
+	streamed, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("reading result error = %v", err)
+	}
+	requireIDs(t, streamed, 1, 200)
+
+	// Reads in ranges, e.g. through FUSE, continue with the same rows
+	var ranged []byte
+	for offset := int64(0); ; {
+		data, err := fs.Read(base+"/result", offset, 100)
+		ranged = append(ranged, data...)
+		offset += int64(len(data))
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read(result, %d) error = %v", offset, err)
+		}
+	}
+	if string(ranged) != string(streamed) {
+		t.Fatal("result read in ranges differs from the streamed result")
+	}
+
+	// Reading before the last read runs the query again
+	tail, err := fs.Read(base+"/result", int64(len(streamed)-2), 10)
+	if err != nil || string(tail) != "]\n" {
+		t.Fatalf("Read(result tail) = %q, %v", tail, err)
+	}
+	head, err := fs.Read(base+"/result", 0, 3)
+	if err != nil || string(head) != "[\n " {
+		t.Fatalf("Read(result head) = %q, %v", head, err)
+	}
+
+	stream, err := fs.OpenStream(base + "/result")
+	if err != nil {
+		t.Fatalf("OpenStream(result) error = %v", err)
+	}
+	defer stream.Close()
+	var chunks []byte
+	for {
+		chunk, eof, err := stream.ReadChunk(0)
+		if eof {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadChunk() error = %v", err)
+		}
+		chunks = append(chunks, chunk...)
+	}
+	if string(chunks) != string(streamed) {
+		t.Fatal("result read as a stream differs from the opened result")
+	}
+}
+
+func TestSQLFS2PagedResults(t *testing.T) {
+	plugin, fs, base := newItemsSessionForTest(t, 50)
+	plugin.pageSize = 10
+
+	if _, err := readSessionFile(t, fs, base+"/result?page=1"); err == nil {
+		t.Fatal("Read(result?page=1) before any query should fail")
+	}
+
+	if _, err := fs.Write(base+"/query?page=3", []byte("SELECT id, name FROM items ORDER BY id;"), -1, 0); err != nil {
+		t.Fatalf("Write(query?page=3) error = %v", err)
+	}
+	data, err := readSessionFile(t, fs, base+"/result")
+	if err != nil {
+		t.Fatalf("Read(result) error = %v", err)
+	}
+	requireIDs(t, data, 21, 30)
+
+	for _, tc := range []struct {
+		suffix   string
+		from, to int
+	}{
+		{"?page=1", 1, 10},
+		{"?page=5", 41, 50},
+		{"?page=2&limit=20", 21, 40},
+		{"?offset=45", 46, 50},
+		{"?limit=3&offset=10", 11, 13},
+	} {
+		data, err := readSessionFile(t, fs, base+"/result"+tc.suffix)
+		if err != nil {
+			t.Fatalf("Read(result%s) error = %v", tc.suffix, err)
+		}
+		requireIDs(t, data, tc.from, tc.to)
+	}
+	if data, err := readSessionFile(t, fs, base+"/result?page=6"); err != nil || string(data) != "[]\n" {
+		t.Fatalf("Read(result?page=6) = %q, %v, want []", data, err)
+	}
+	if _, err := fs.Stat(base + "/result?page=2"); err != nil {
+		t.Fatalf("Stat(result?page=2) error = %v", err)
+	}
+
+	for _, path := range []string{
+		base + "/result?page=0",
+		base + "/result?page=2&offset=3",
+		base + "/result?rows=3",
+		base + "/error?page=1",
+	} {
+		if _, err := fs.Read(path, 0, -1); !errors.Is(err, filesystem.ErrInvalidArgument) {
+			t.Fatalf("Read(%s) error = %v, want invalid argument", path, err)
+		}
+	}
+	if _, err := fs.Write(base+"/query?page=2", []byte("DELETE FROM items"), -1, 0); err == nil {
+		t.Fatal("Write(query?page=2) of a DELETE should fail")
+	}
+}
@@ -0,0 +1,703 @@
+package sqlfs2
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// rowsDirName is the table-level directory exposing rows as JSON files
+	// named by their primary key
+	rowsDirName = "rows"
+	// newRowFileName is the file in the rows directory that inserts rows
+	newRowFileName = "new"
+	// rowsListLimit caps the rows listed in a rows directory
+	rowsListLimit = 1000
+)
+
+// InsertResult is the JSON content of <table>/rows/new after an insert
+type InsertResult struct {
+	Table    string    `json:"table"`
+	Rows     int       `json:"rows"`
+	Keys     []string  `json:"keys,omitempty"` // File names of the new rows, where known
+	Finished time.Time `json:"finished_at"`
+}
+
+// rowPath is a path in the rows directory of a table
+type rowPath struct {
+	dbName    string
+	tableName string
+	key       string // File name in the directory; "" for the directory
+}
+
+// parseRowPath reports whether path is /<db>/<table>/rows or a file in it
+func parseRowPath(path string) (*rowPath, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 3 || len(parts) > 4 || parts[2] != rowsDirName {
+		return nil, false
+	}
+	rp := &rowPath{dbName: parts[0], tableName: parts[1]}
+	if len(parts) == 4 {
+		rp.key = parts[3]
+	}
+	return rp, true
+}
+
+// rowTable is a table whose rows are exposed as files
+type rowTable struct {
+	dbName    string
+	name      string
+	qualified string            // Quoted database.table
+	columns   map[string]string // Lower-cased name to column name
+	key       []string          // Primary key columns
+}
+
+// rowTable loads the columns and primary key of the table of rp
+func (fs *sqlfs2FS) rowTable(rp *rowPath) (*rowTable, error) {
+	if err := validatePathSQLIdentifiers(rp.dbName, rp.tableName); err != nil {
+		return nil, err
+	}
+	exists, err := fs.tableExists(rp.dbName, rp.tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check table existence: %w", err)
+	}
+	if !exists {
+		return nil, filesystem.NewNotFoundError("rows", "/"+rp.dbName+"/"+rp.tableName)
+	}
+
+	t := &rowTable{dbName: rp.dbName, name: rp.tableName, columns: make(map[string]string)}
+	if t.qualified, err = qualifiedTableName(rp.dbName, rp.tableName); err != nil {
+		return nil, err
+	}
+	columns, err := fs.plugin.backend.GetTableColumns(fs.plugin.db, rp.dbName, rp.tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get table columns: %w", err)
+	}
+	for _, col := range columns {
+		t.columns[strings.ToLower(col.Name)] = col.Name
+	}
+	if t.key, err = fs.plugin.backend.GetPrimaryKey(fs.plugin.db, rp.dbName, rp.tableName); err != nil {
+		return nil, err
+	}
+	if len(t.key) == 0 {
+		return nil, filesystem.NewNotSupportedError("rows", fmt.Sprintf("/%s/%s (table has no primary key)", rp.dbName, rp.tableName))
+	}
+	return t, nil
+}
+
+// hasPrimaryKey reports whether a table gets a rows directory
+func (fs *sqlfs2FS) hasPrimaryKey(dbName, tableName string) bool {
+	key, err := fs.plugin.backend.GetPrimaryKey(fs.plugin.db, dbName, tableName)
+	return err == nil && len(key) > 0
+}
+
+// encodeKey names the file of a row after its primary key values. Values
+// are path-escaped, and joined with commas for composite keys.
+func encodeKey(values []string) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strings.ReplaceAll(url.PathEscape(v), ",", "%2C")
+	}
+	return strings.Join(parts, ",")
+}
+
+// decodeKey returns the primary key values named by a row file
+func (t *rowTable) decodeKey(name string) ([]interface{}, error) {
+	parts := strings.Split(name, ",")
+	if len(parts) != len(t.key) {
+		return nil, filesystem.NewNotFoundError("rows", name)
+	}
+	values := make([]interface{}, len(parts))
+	for i, part := range parts {
+		v, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, filesystem.NewInvalidArgumentError("key", name, err.Error())
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// whereKey returns the WHERE clause matching a row by primary key
+func (t *rowTable) whereKey() (string, error) {
+	quoted, err := quotedColumnNames(t.key)
+	if err != nil {
+		return "", err
+	}
+	for i := range quoted {
+		quoted[i] += " = ?"
+	}
+	return " WHERE " + strings.Join(quoted, " AND "), nil
+}
+
+// scanValue converts a scanned column value for JSON and key encoding
+func scanValue(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+// listRows lists the rows directory: a file per row, by primary key, and
+// the new file unless rows are read-only
+func (fs *sqlfs2FS) listRows(path string, rp *rowPath) ([]filesystem.FileInfo, error) {
+	t, err := fs.rowTable(rp)
+	if err != nil {
+		return nil, err
+	}
+	quoted, err := quotedColumnNames(t.key)
+	if err != nil {
+		return nil, err
+	}
+	cols := strings.Join(quoted, ", ")
+	query := fmt.Sprintf("SELECT %s FROM %s ORDER BY %s LIMIT %d", cols, t.qualified, cols, rowsListLimit)
+	if err := fs.plugin.policy.Check(path, query); err != nil {
+		return nil, err
+	}
+	rows, err := fs.plugin.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var entries []filesystem.FileInfo
+	if !fs.plugin.rowsReadOnly {
+		entries = append(entries, filesystem.FileInfo{
+			Name:    newRowFileName,
+			Mode:    0666, // write JSON to insert rows, read the last result
+			ModTime: now,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "insert"},
+		})
+	}
+	values := make([]interface{}, len(t.key))
+	ptrs := make([]interface{}, len(t.key))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		key := make([]string, len(values))
+		for i, v := range values {
+			key[i] = fmt.Sprint(scanValue(v))
+		}
+		entries = append(entries, filesystem.FileInfo{
+			Name:    encodeKey(key),
+			Mode:    fs.rowMode(),
+			ModTime: now,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "row"},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return entries, nil
+}
+
+func (fs *sqlfs2FS) rowMode() uint32 {
+	if fs.plugin.rowsReadOnly {
+		return 0444
+	}
+	return 0644
+}
+
+// PreviewDir implements filesystem.DirPreviewer. The rows of a rows directory
+// are previewed as compact JSON objects, read with one query; nothing else
+// in the database is.
+func (fs *sqlfs2FS) PreviewDir(dir string, entries []filesystem.FileInfo, n int) map[string]filesystem.FilePreview {
+	rp, ok := parseRowPath(dir)
+	if !ok || rp.key != "" {
+		return nil
+	}
+	previews, err := fs.previewRows(dir, rp, n)
+	if err != nil {
+		log.Debugf("[sqlfs2] Failed to preview rows of %s: %v", dir, err)
+		return nil
+	}
+	return previews
+}
+
+// previewRows returns previews of the rows listRows lists, keyed by file name
+func (fs *sqlfs2FS) previewRows(path string, rp *rowPath, n int) (map[string]filesystem.FilePreview, error) {
+	t, err := fs.rowTable(rp)
+	if err != nil {
+		return nil, err
+	}
+	quoted, err := quotedColumnNames(t.key)
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("SELECT * FROM %s ORDER BY %s LIMIT %d", t.qualified, strings.Join(quoted, ", "), rowsListLimit)
+	if err := fs.plugin.policy.Check(path, query); err != nil {
+		return nil, err
+	}
+	rows, err := fs.plugin.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	// The key columns of each row name its file
+	keyIndex := make([]int, len(t.key))
+	for i, col := range t.key {
+		keyIndex[i] = slices.IndexFunc(columns, func(c string) bool { return strings.EqualFold(c, col) })
+		if keyIndex[i] < 0 {
+			return nil, fmt.Errorf("primary key column %s not in result", col)
+		}
+	}
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	previews := make(map[string]filesystem.FilePreview)
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			row[col] = scanValue(values[i])
+		}
+		key := make([]string, len(keyIndex))
+		for i, idx := range keyIndex {
+			key[i] = fmt.Sprint(row[columns[idx]])
+		}
+		data, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		previews[encodeKey(key)] = filesystem.NewFilePreview(string(data), n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return previews, nil
+}
+
+// readRow returns a row as an indented JSON object
+func (fs *sqlfs2FS) readRow(path string, rp *rowPath) ([]byte, error) {
+	t, err := fs.rowTable(rp)
+	if err != nil {
+		return nil, err
+	}
+	key, err := t.decodeKey(rp.key)
+	if err != nil {
+		return nil, err
+	}
+	where, err := t.whereKey()
+	if err != nil {
+		return nil, err
+	}
+	query := "SELECT * FROM " + t.qualified + where
+	if err := fs.plugin.policy.Check(path, query); err != nil {
+		return nil, err
+	}
+	rows, err := fs.plugin.db.Query(query, key...)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("rows error: %w", err)
+		}
+		return nil, filesystem.NewNotFoundError("read", path)
+	}
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+	row := make(map[string]interface{}, len(columns))
+	for i, col := range columns {
+		row[col] = scanValue(values[i])
+	}
+	data, err := json.MarshalIndent(row, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// parseRowJSON decodes the JSON objects written to a row file, keyed by
+// column name. rows/new also takes an array of objects.
+func (t *rowTable) parseRowJSON(data []byte, many bool) ([]map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, filesystem.NewInvalidArgumentError("json", t.name, err.Error())
+	}
+
+	var objects []interface{}
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		objects = []interface{}{v}
+	case []interface{}:
+		if !many {
+			return nil, filesystem.NewInvalidArgumentError("json", t.name, "expected an object")
+		}
+		objects = v
+	default:
+		return nil, filesystem.NewInvalidArgumentError("json", t.name, "expected an object or an array of objects")
+	}
+
+	result := make([]map[string]interface{}, 0, len(objects))
+	for _, o := range objects {
+		obj, ok := o.(map[string]interface{})
+		if !ok || len(obj) == 0 {
+			return nil, filesystem.NewInvalidArgumentError("json", t.name, "rows must be non-empty objects")
+		}
+		row := make(map[string]interface{}, len(obj))
+		for name, value := range obj {
+			col, ok := t.columns[strings.ToLower(name)]
+			if !ok {
+				return nil, filesystem.NewInvalidArgumentError("column", name, fmt.Sprintf("not a column of %s.%s", t.dbName, t.name))
+			}
+			row[col] = rowValue(value)
+		}
+		result = append(result, row)
+	}
+	return result, nil
+}
+
+// rowValue converts a decoded JSON value for the database. Nested objects
+// and arrays are stored as JSON text.
+func rowValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	return v
+}
+
+// checkRowsWritable rejects changes when rows are read-only
+func (fs *sqlfs2FS) checkRowsWritable(op, path string) error {
+	if fs.plugin.rowsReadOnly {
+		return filesystem.NewPermissionDeniedError(op, path, "rows are read-only")
+	}
+	return nil
+}
+
+// updateRow handles writes to rows/<key>: the columns of the JSON object
+// are updated. The primary key cannot be changed.
+func (fs *sqlfs2FS) updateRow(path string, rp *rowPath, data []byte) (int64, error) {
+	if err := fs.checkRowsWritable("write", path); err != nil {
+		return 0, err
+	}
+	t, err := fs.rowTable(rp)
+	if err != nil {
+		return 0, err
+	}
+	key, err := t.decodeKey(rp.key)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := t.parseRowJSON(data, false)
+	if err != nil {
+		return 0, err
+	}
+	row := rows[0]
+
+	// A row read back and written whole repeats its key, which is fine
+	for i, col := range t.key {
+		if v, ok := row[col]; ok {
+			if fmt.Sprint(v) != key[i] {
+				return 0, filesystem.NewInvalidArgumentError("column", col, "the primary key cannot be changed; write the row to rows/new and remove this one")
+			}
+			delete(row, col)
+		}
+	}
+	if len(row) == 0 {
+		return 0, filesystem.NewInvalidArgumentError("json", path, "no columns to update")
+	}
+
+	var sets []string
+	var args []interface{}
+	for col, value := range row {
+		quoted, err := quoteSQLIdentifier("column", col)
+		if err != nil {
+			return 0, err
+		}
+		sets = append(sets, quoted+" = ?")
+		args = append(args, value)
+	}
+	where, err := t.whereKey()
+	if err != nil {
+		return 0, err
+	}
+	updateSQL := fmt.Sprintf("UPDATE %s SET %s%s", t.qualified, strings.Join(sets, ", "), where)
+	if err := fs.plugin.policy.Check(path, updateSQL); err != nil {
+		return 0, err
+	}
+
+	result, err := fs.plugin.db.Exec(updateSQL, append(args, key...)...)
+	if err != nil {
+		return 0, fmt.Errorf("update error: %w", err)
+	}
+	// MySQL counts changed rows only, so an unchanged row is looked up
+	if n, _ := result.RowsAffected(); n == 0 {
+		var count int
+		if err := fs.plugin.db.QueryRow("SELECT COUNT(*) FROM "+t.qualified+where, key...).Scan(&count); err != nil {
+			return 0, fmt.Errorf("query error: %w", err)
+		}
+		if count == 0 {
+			return 0, filesystem.NewNotFoundError("write", path)
+		}
+	}
+	return int64(len(data)), nil
+}
+
+// insertRows handles writes to rows/new: each JSON object is inserted, in
+// one transaction
+func (fs *sqlfs2FS) insertRows(path string, rp *rowPath, data []byte) (int64, error) {
+	if err := fs.checkRowsWritable("write", path); err != nil {
+		return 0, err
+	}
+	t, err := fs.rowTable(rp)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := t.parseRowJSON(data, true)
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, filesystem.NewInvalidArgumentError("json", path, "no rows to insert")
+	}
+
+	tx, err := fs.plugin.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	result := &InsertResult{Table: t.name}
+	for i, row := range rows {
+		key, err := fs.insertRow(tx, path, t, row)
+		if err != nil {
+			tx.Rollback()
+			if len(rows) > 1 {
+				return 0, fmt.Errorf("row %d: %w", i+1, err)
+			}
+			return 0, err
+		}
+		if key != "" {
+			result.Keys = append(result.Keys, key)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("transaction commit failed: %w", err)
+	}
+
+	result.Rows = len(rows)
+	result.Finished = time.Now()
+	fs.plugin.recordInsert(t.dbName, t.name, result)
+	return int64(len(data)), nil
+}
+
+// insertRow inserts one row and returns its file name, taken from the
+// written key or, for a single-column key the database generated, the
+// last insert ID
+func (fs *sqlfs2FS) insertRow(tx *sql.Tx, path string, t *rowTable, row map[string]interface{}) (string, error) {
+	columns := make([]string, 0, len(row))
+	for col := range row {
+		columns = append(columns, col)
+	}
+	values := make([]interface{}, len(columns))
+	for i, col := range columns {
+		values[i] = row[col]
+	}
+	insertSQL, err := insertRowsSQL(t.dbName, t.name, columns)
+	if err != nil {
+		return "", err
+	}
+	if err := fs.plugin.policy.Check(path, insertSQL); err != nil {
+		return "", err
+	}
+	res, err := tx.Exec(insertSQL, values...)
+	if err != nil {
+		return "", fmt.Errorf("insert error: %w", err)
+	}
+
+	key := make([]string, len(t.key))
+	for i, col := range t.key {
+		v, ok := row[col]
+		if !ok {
+			if len(t.key) > 1 {
+				return "", nil
+			}
+			id, err := res.LastInsertId()
+			if err != nil {
+				return "", nil
+			}
+			v = id
+		}
+		key[i] = fmt.Sprint(v)
+	}
+	return encodeKey(key), nil
+}
+
+// deleteRow handles removing rows/<key>
+func (fs *sqlfs2FS) deleteRow(path string, rp *rowPath) error {
+	if rp.key == "" || rp.key == newRowFileName {
+		return fmt.Errorf("operation not supported: can only remove row files")
+	}
+	if err := fs.checkRowsWritable("remove", path); err != nil {
+		return err
+	}
+	t, err := fs.rowTable(rp)
+	if err != nil {
+		return err
+	}
+	key, err := t.decodeKey(rp.key)
+	if err != nil {
+		return err
+	}
+	where, err := t.whereKey()
+	if err != nil {
+		return err
+	}
+	deleteSQL := "DELETE FROM " + t.qualified + where
+	if err := fs.plugin.policy.Check(path, deleteSQL); err != nil {
+		return err
+	}
+	result, err := fs.plugin.db.Exec(deleteSQL, key...)
+	if err != nil {
+		return fmt.Errorf("delete error: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return filesystem.NewNotFoundError("remove", path)
+	}
+	return nil
+}
+
+// statRow stats the rows directory and its files
+func (fs *sqlfs2FS) statRow(path string, rp *rowPath) (*filesystem.FileInfo, error) {
+	now := time.Now()
+	switch rp.key {
+	case "":
+		if _, err := fs.rowTable(rp); err != nil {
+			return nil, err
+		}
+		return &filesystem.FileInfo{
+			Name:    rowsDirName,
+			Mode:    0755,
+			ModTime: now,
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "rows"},
+		}, nil
+	case newRowFileName:
+		if _, err := fs.rowTable(rp); err != nil {
+			return nil, err
+		}
+		if fs.plugin.rowsReadOnly {
+			return nil, filesystem.NewNotFoundError("stat", path)
+		}
+		return &filesystem.FileInfo{
+			Name:    newRowFileName,
+			Size:    int64(len(fs.plugin.lastInsert(rp.dbName, rp.tableName))),
+			Mode:    0666,
+			ModTime: now,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "insert"},
+		}, nil
+	}
+	data, err := fs.readRow(path, rp)
+	if err != nil {
+		return nil, err
+	}
+	return &filesystem.FileInfo{
+		Name:    rp.key,
+		Size:    int64(len(data)),
+		Mode:    fs.rowMode(),
+		ModTime: now,
+		Meta:    filesystem.MetaData{Name: PluginName, Type: "row"},
+	}, nil
+}
+
+// recordInsert keeps the result of the last insert through rows/new
+func (p *SQLFS2Plugin) recordInsert(dbName, tableName string, result *InsertResult) {
+	p.importsMu.Lock()
+	defer p.importsMu.Unlock()
+	if p.inserts == nil {
+		p.inserts = make(map[string]*InsertResult)
+	}
+	p.inserts[dbName+"."+tableName] = result
+}
+
+// lastInsert returns the JSON result of the last insert into a table
+func (p *SQLFS2Plugin) lastInsert(dbName, tableName string) []byte {
+	p.importsMu.Lock()
+	result := p.inserts[dbName+"."+tableName]
+	p.importsMu.Unlock()
+	if result == nil {
+		return []byte{}
+	}
+	data, _ := json.MarshalIndent(result, "", "  ")
+	return append(data, '\n')
+}
+
+// readRowPath reads a file in the rows directory
+func (fs *sqlfs2FS) readRowPath(path string, rp *rowPath, offset, size int64) ([]byte, error) {
+	switch rp.key {
+	case "":
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	case newRowFileName:
+		if _, err := fs.rowTable(rp); err != nil {
+			return nil, err
+		}
+		return plugin.ApplyRangeRead(fs.plugin.lastInsert(rp.dbName, rp.tableName), offset, size)
+	}
+	data, err := fs.readRow(path, rp)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+// writeRowPath writes a file in the rows directory. Row files take a whole
+// JSON document per write.
+func (fs *sqlfs2FS) writeRowPath(path string, rp *rowPath, data []byte, offset int64) (int64, error) {
+	if rp.key == "" {
+		return 0, fmt.Errorf("cannot write to directory: %s", path)
+	}
+	if offset > 0 {
+		return 0, filesystem.NewInvalidArgumentError("offset", path, "row files must be written whole")
+	}
+	if rp.key == newRowFileName {
+		return fs.insertRows(path, rp, data)
+	}
+	return fs.updateRow(path, rp, data)
+}
@@ -0,0 +1,200 @@
+package sqlfs2
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func readRowForTest(t *testing.T, fs *sqlfs2FS, path string) map[string]interface{} {
+	t.Helper()
+	data, err := readSessionFile(t, fs, path)
+	if err != nil {
+		t.Fatalf("Read(%s) error = %v", path, err)
+	}
+	var row map[string]interface{}
+	if err := json.Unmarshal(data, &row); err != nil {
+		t.Fatalf("row is not JSON: %v\n%s", err, data)
+	}
+	return row
+}
+
+func TestSQLFS2RowsReadAndUpdate(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+	mustExecSQL(t, plugin.db, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, age INTEGER)")
+	mustExecSQL(t, plugin.db, "INSERT INTO users VALUES (1, 'Alice', 30), (2, 'Bob', 25)")
+	mustExecSQL(t, plugin.db, "CREATE TABLE logs (line TEXT)")
+
+	entries, err := fs.ReadDir("/main/users")
+	if err != nil {
+		t.Fatalf("ReadDir(table) error = %v", err)
+	}
+	found := false
+	for _, e := range entries {
+		found = found || (e.Name == "rows" && e.IsDir)
+	}
+	if !found {
+		t.Fatalf("table entries %v have no rows directory", entries)
+	}
+	if _, err := fs.ReadDir("/main/logs/rows"); !errors.Is(err, filesystem.ErrNotSupported) {
+		t.Fatalf("ReadDir(rows) without primary key error = %v, want not supported", err)
+	}
+
+	entries, err = fs.ReadDir("/main/users/rows")
+	if err != nil {
+		t.Fatalf("ReadDir(rows) error = %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	if len(names) != 3 || names[0] != "new" || names[1] != "1" || names[2] != "2" {
+		t.Fatalf("rows = %v, want [new 1 2]", names)
+	}
+
+	if row := readRowForTest(t, fs, "/main/users/rows/1"); row["name"] != "Alice" || row["age"] != float64(30) {
+		t.Fatalf("row 1 = %v", row)
+	}
+
+	// Writing a row back whole, key included, updates it
+	if _, err := fs.Write("/main/users/rows/1", []byte(`{"id": 1, "Age": 31}`), -1, 0); err != nil {
+		t.Fatalf("Write(row) error = %v", err)
+	}
+	if row := readRowForTest(t, fs, "/main/users/rows/1"); row["name"] != "Alice" || row["age"] != float64(31) {
+		t.Fatalf("row 1 after update = %v", row)
+	}
+
+	if _, err := fs.Write("/main/users/rows/1", []byte(`{"id": 5}`), -1, 0); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Fatalf("changing the key error = %v, want invalid argument", err)
+	}
+	if _, err := fs.Write("/main/users/rows/1", []byte(`{"email": "a@example.com"}`), -1, 0); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Fatalf("unknown column error = %v, want invalid argument", err)
+	}
+	if _, err := fs.Write("/main/users/rows/9", []byte(`{"age": 1}`), -1, 0); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Fatalf("updating a missing row error = %v, want not found", err)
+	}
+	if _, err := fs.Read("/main/users/rows/9", 0, -1); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Fatalf("Read(missing row) error = %v, want not found", err)
+	}
+}
+
+func TestSQLFS2RowsInsertAndDelete(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+	mustExecSQL(t, plugin.db, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, tags TEXT)")
+
+	if _, err := fs.Write("/main/users/rows/new", []byte(`{"name": "Alice", "tags": ["a", "b"]}`), -1, 0); err != nil {
+		t.Fatalf("Write(new) error = %v", err)
+	}
+	if row := readRowForTest(t, fs, "/main/users/rows/1"); row["tags"] != `["a","b"]` {
+		t.Fatalf("inserted row = %v", row)
+	}
+
+	if _, err := fs.Write("/main/users/rows/new", []byte(`[{"id": 10, "name": "Bob"}, {"id": 11, "name": "Carol"}]`), -1, 0); err != nil {
+		t.Fatalf("Write(new) array error = %v", err)
+	}
+	data, err := readSessionFile(t, fs, "/main/users/rows/new")
+	if err != nil {
+		t.Fatalf("Read(new) error = %v", err)
+	}
+	var result InsertResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("insert result is not JSON: %v\n%s", err, data)
+	}
+	if result.Rows != 2 || len(result.Keys) != 2 || result.Keys[0] != "10" || result.Keys[1] != "11" {
+		t.Fatalf("insert result = %+v", result)
+	}
+
+	// A failing row rolls the whole write back
+	if _, err := fs.Write("/main/users/rows/new", []byte(`[{"id": 12, "name": "Dave"}, {"id": 10, "name": "Again"}]`), -1, 0); err == nil {
+		t.Fatal("inserting a duplicate key succeeded")
+	}
+	if _, err := fs.Stat("/main/users/rows/12"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Fatalf("Stat(row 12) error = %v, want not found after rollback", err)
+	}
+
+	if err := fs.Remove("/main/users/rows/10"); err != nil {
+		t.Fatalf("Remove(row) error = %v", err)
+	}
+	if err := fs.Remove("/main/users/rows/10"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Fatalf("Remove(removed row) error = %v, want not found", err)
+	}
+	var count int
+	if err := plugin.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil || count != 2 {
+		t.Fatalf("row count = %d, %v, want 2", count, err)
+	}
+}
+
+func TestSQLFS2RowsCompositeKey(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+	mustExecSQL(t, plugin.db, "CREATE TABLE members (team TEXT, user TEXT, role TEXT, PRIMARY KEY (team, user))")
+	mustExecSQL(t, plugin.db, "INSERT INTO members VALUES ('a,b', 'x/y', 'admin')")
+
+	entries, err := fs.ReadDir("/main/members/rows")
+	if err != nil {
+		t.Fatalf("ReadDir(rows) error = %v", err)
+	}
+	if len(entries) != 2 || entries[1].Name != "a%2Cb,x%2Fy" {
+		t.Fatalf("rows = %v, want new and a%%2Cb,x%%2Fy", entries)
+	}
+	if row := readRowForTest(t, fs, "/main/members/rows/"+entries[1].Name); row["role"] != "admin" {
+		t.Fatalf("row = %v", row)
+	}
+}
+
+func TestSQLFS2RowsReadOnly(t *testing.T) {
+	for _, key := range []string{"rows_read_only", "read_only"} {
+		plugin := NewSQLFS2Plugin()
+		err := plugin.Initialize(map[string]interface{}{
+			"backend": "sqlite",
+			"db_path": filepath.Join(t.TempDir(), "sqlfs2.db"),
+			key:       true,
+		})
+		if err != nil {
+			t.Fatalf("Initialize() error = %v", err)
+		}
+		fs := plugin.GetFileSystem().(*sqlfs2FS)
+		mustExecSQL(t, plugin.db, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+		mustExecSQL(t, plugin.db, "INSERT INTO users VALUES (1, 'Alice')")
+
+		if row := readRowForTest(t, fs, "/main/users/rows/1"); row["name"] != "Alice" {
+			t.Fatalf("%s: row = %v", key, row)
+		}
+		if _, err := fs.Write("/main/users/rows/1", []byte(`{"name": "Bob"}`), -1, 0); !errors.Is(err, filesystem.ErrPermissionDenied) {
+			t.Errorf("%s: Write(row) error = %v, want permission denied", key, err)
+		}
+		if _, err := fs.Write("/main/users/rows/new", []byte(`{"name": "Bob"}`), -1, 0); !errors.Is(err, filesystem.ErrPermissionDenied) {
+			t.Errorf("%s: Write(new) error = %v, want permission denied", key, err)
+		}
+		if err := fs.Remove("/main/users/rows/1"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+			t.Errorf("%s: Remove(row) error = %v, want permission denied", key, err)
+		}
+		plugin.Shutdown()
+	}
+}
+
+func TestSQLFS2RowsPreview(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+	mustExecSQL(t, plugin.db, "CREATE TABLE members (team TEXT, user TEXT, role TEXT, PRIMARY KEY (team, user))")
+	mustExecSQL(t, plugin.db, "INSERT INTO members VALUES ('a,b', 'x/y', 'admin'), ('c', 'z', 'a much longer role name')")
+
+	entries, err := fs.ReadDir("/main/members/rows")
+	if err != nil {
+		t.Fatalf("ReadDir(rows) error = %v", err)
+	}
+	previews := filesystem.PreviewDir(fs, "/main/members/rows", entries, 50)
+	if p := previews["a%2Cb,x%2Fy"]; p.Text != `{"role":"admin","team":"a,b","user":"x/y"}` || p.Truncated {
+		t.Errorf("preview of a,b/x/y = %+v", p)
+	}
+	if p := previews["c,z"]; len(p.Text) != 50 || !p.Truncated {
+		t.Errorf("preview of c/z = %+v, want 50 bytes, truncated", p)
+	}
+	if _, ok := previews[newRowFileName]; ok || len(previews) != 2 {
+		t.Errorf("previews = %v, want the two rows", previews)
+	}
+	if previews := filesystem.PreviewDir(fs, "/main/members", nil, 50); previews != nil {
+		t.Errorf("table directory previews = %v, want none", previews)
+	}
+}
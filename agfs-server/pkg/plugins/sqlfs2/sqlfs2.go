@@ -26,10 +26,17 @@ type Session struct {
 	dbName     string
 	tableName  string
 	tx         *sql.Tx   // SQL transaction
-	result     []byte    // Query result (JSON)
+	result     []byte    // Query result (JSON), nil while streamed
+	planQuery  string    // Statement reported by the explain file
 	lastError  string    // Error message
 	lastAccess time.Time // Last access time
 	mu         sync.Mutex
+
+	// Result of the last query returning rows, see results.go
+	resultQuery string        // Query re-run to stream or page its result
+	resultPage  resultPage    // Page of resultQuery held by the result file
+	streamed    bool          // Result exceeded max_result_size and is streamed
+	cursor      *resultCursor // Rows of the result being read
 }
 
 // Touch updates the last access time. Must be called with mu held.
@@ -91,6 +98,7 @@ func (sm *SessionManager) cleanupExpired() {
 	for key, session := range sm.sessions {
 		session.mu.Lock()
 		if now.Sub(session.lastAccess) > sm.timeout {
+			session.closeCursor()
 			if session.tx != nil {
 				session.tx.Rollback()
 			}
@@ -166,6 +174,7 @@ func (sm *SessionManager) CloseSession(dbName, tableName, sid string) error {
 	session.mu.Lock()
 	defer session.UnlockWithTouch()
 
+	session.closeCursor()
 	if session.tx != nil {
 		session.tx.Rollback()
 	}
@@ -198,6 +207,14 @@ type SQLFS2Plugin struct {
 	backend        Backend
 	config         map[string]interface{}
 	sessionManager *SessionManager // Shared across all filesystem instances
+	policy         *statementPolicy
+	jobs           *JobManager              // Async query jobs under /jobs
+	imports        map[string]*ImportResult // Last import per "db.table"
+	inserts        map[string]*InsertResult // Last insert through rows/new per "db.table"
+	importsMu      sync.Mutex               // Guards imports and inserts
+	rowsReadOnly   bool                     // Row files can be read but not written or removed
+	maxResultSize  int                      // Largest query result kept in memory, 0 for no limit
+	pageSize       int64                    // Rows per page of query?page=N and result?page=N
 }
 
 // NewSQLFS2Plugin creates a new SQLFS2 plugin
@@ -211,7 +228,9 @@ func (p *SQLFS2Plugin) Name() string {
 
 func (p *SQLFS2Plugin) Validate(cfg map[string]interface{}) error {
 	allowedKeys := []string{"backend", "db_path", "dsn", "user", "password", "host", "port", "database",
-		"enable_tls", "tls_server_name", "tls_skip_verify", "mount_path", "session_timeout"}
+		"enable_tls", "tls_server_name", "tls_skip_verify", "mount_path", "session_timeout",
+		"read_only", "deny_ddl", "require_where", "allowed_statements",
+		"job_workers", "job_queue_size", "job_retention", "rows_read_only", "max_result_size", "page_size"}
 	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
 		return err
 	}
@@ -229,26 +248,46 @@ func (p *SQLFS2Plugin) Validate(cfg map[string]interface{}) error {
 	}
 
 	// Validate optional string parameters
-	for _, key := range []string{"db_path", "dsn", "user", "password", "host", "database", "tls_server_name"} {
+	for _, key := range []string{"db_path", "dsn", "user", "password", "host", "database", "tls_server_name", "allowed_statements", "job_retention"} {
 		if err := config.ValidateStringType(cfg, key); err != nil {
 			return err
 		}
 	}
 
 	// Validate optional integer parameters
-	for _, key := range []string{"port"} {
+	for _, key := range []string{"port", "job_workers", "job_queue_size", "page_size"} {
 		if err := config.ValidateIntType(cfg, key); err != nil {
 			return err
 		}
 	}
 
 	// Validate optional boolean parameters
-	for _, key := range []string{"enable_tls", "tls_skip_verify"} {
+	for _, key := range []string{"enable_tls", "tls_skip_verify", "read_only", "deny_ddl", "require_where", "rows_read_only"} {
 		if err := config.ValidateBoolType(cfg, key); err != nil {
 			return err
 		}
 	}
 
+	if _, err := newStatementPolicy(cfg, backendType); err != nil {
+		return err
+	}
+
+	if retention := config.GetStringConfig(cfg, "job_retention", ""); retention != "" {
+		if _, err := time.ParseDuration(retention); err != nil {
+			return fmt.Errorf("invalid job_retention %q: %w", retention, err)
+		}
+	}
+	for _, key := range []string{"job_workers", "job_queue_size", "page_size"} {
+		if config.GetIntConfig(cfg, key, 1) < 1 {
+			return fmt.Errorf("%s must be at least 1", key)
+		}
+	}
+	if size, err := config.GetSizeConfig(cfg, "max_result_size", defaultMaxResultSize); err != nil {
+		return err
+	} else if size < 0 {
+		return fmt.Errorf("max_result_size must not be negative")
+	}
+
 	return nil
 }
 
@@ -264,6 +303,19 @@ func (p *SQLFS2Plugin) Initialize(cfg map[string]interface{}) error {
 	}
 	p.backend = backend
 
+	policy, err := newStatementPolicy(cfg, backendType)
+	if err != nil {
+		return err
+	}
+	p.policy = policy
+	p.rowsReadOnly = config.GetBoolConfig(cfg, "rows_read_only", false)
+	maxResultSize, err := config.GetSizeConfig(cfg, "max_result_size", defaultMaxResultSize)
+	if err != nil {
+		return err
+	}
+	p.maxResultSize = int(maxResultSize)
+	p.pageSize = int64(config.GetIntConfig(cfg, "page_size", defaultPageSize))
+
 	// Initialize database connection using the backend
 	db, err := backend.Initialize(cfg)
 	if err != nil {
@@ -280,6 +332,18 @@ func (p *SQLFS2Plugin) Initialize(cfg map[string]interface{}) error {
 	}
 	p.sessionManager = NewSessionManager(timeout)
 
+	// Initialize async job manager
+	retention := time.Hour
+	if retentionStr := config.GetStringConfig(cfg, "job_retention", ""); retentionStr != "" {
+		if parsed, err := time.ParseDuration(retentionStr); err == nil {
+			retention = parsed
+		}
+	}
+	p.jobs = NewJobManager(db, backend,
+		config.GetIntConfig(cfg, "job_workers", 4),
+		config.GetIntConfig(cfg, "job_queue_size", 100),
+		retention)
+
 	log.Infof("[sqlfs2] Initialized with backend: %s", backendType)
 	return nil
 }
@@ -383,6 +447,76 @@ func (p *SQLFS2Plugin) GetConfigParams() []plugin.ConfigParameter {
 			Default:     "",
 			Description: "Session timeout duration (e.g., '10m', '1h'). Empty means no timeout.",
 		},
+		{
+			Name:        "read_only",
+			Type:        "bool",
+			Required:    false,
+			Default:     "false",
+			Description: "Only allow SELECT, SHOW, DESCRIBE and EXPLAIN statements",
+		},
+		{
+			Name:        "deny_ddl",
+			Type:        "bool",
+			Required:    false,
+			Default:     "false",
+			Description: "Reject CREATE, ALTER, DROP, TRUNCATE and RENAME statements",
+		},
+		{
+			Name:        "require_where",
+			Type:        "bool",
+			Required:    false,
+			Default:     "false",
+			Description: "Reject UPDATE and DELETE statements without a WHERE clause",
+		},
+		{
+			Name:        "allowed_statements",
+			Type:        "string",
+			Required:    false,
+			Default:     "",
+			Description: "Comma-separated statement kinds to allow (select, show, describe, explain, insert, update, delete, replace, ddl, other). Empty allows all.",
+		},
+		{
+			Name:        "job_workers",
+			Type:        "int",
+			Required:    false,
+			Default:     "4",
+			Description: "Number of workers executing async query jobs",
+		},
+		{
+			Name:        "job_queue_size",
+			Type:        "int",
+			Required:    false,
+			Default:     "100",
+			Description: "Maximum number of queued async query jobs",
+		},
+		{
+			Name:        "job_retention",
+			Type:        "string",
+			Required:    false,
+			Default:     "1h",
+			Description: "How long finished async jobs are kept (e.g., '30m'). '0' keeps them until removed.",
+		},
+		{
+			Name:        "rows_read_only",
+			Type:        "bool",
+			Required:    false,
+			Default:     "false",
+			Description: "Serve table rows under <table>/rows for reading only, without rows/new, updates or removal",
+		},
+		{
+			Name:        "max_result_size",
+			Type:        "string",
+			Required:    false,
+			Default:     "16MB",
+			Description: "Largest query result kept in memory (e.g., '64MB'); larger results are streamed from the database when read. '0' keeps all results.",
+		},
+		{
+			Name:        "page_size",
+			Type:        "int",
+			Required:    false,
+			Default:     "1000",
+			Description: "Rows per page read through query?page=N and result?page=N",
+		},
 	}
 }
 
@@ -390,6 +524,9 @@ func (p *SQLFS2Plugin) Shutdown() error {
 	if p.sessionManager != nil {
 		p.sessionManager.Stop()
 	}
+	if p.jobs != nil {
+		p.jobs.Stop()
+	}
 	if p.db != nil {
 		return p.db.Close()
 	}
@@ -420,7 +557,7 @@ func isSessionID(s string) bool {
 
 // isTableLevelFile checks if the given name is a table-level special file
 func isTableLevelFile(name string) bool {
-	return name == "ctl" || name == "schema" || name == "count"
+	return name == "ctl" || name == "schema" || name == "count" || name == importFileName
 }
 
 // isRootLevelFile checks if the given name is a root-level special file
@@ -430,7 +567,7 @@ func isRootLevelFile(name string) bool {
 
 // isSessionFile checks if the given name is a session-level file
 func isSessionFile(name string) bool {
-	return name == "ctl" || name == "query" || name == "result" || name == "data" || name == "error"
+	return name == "ctl" || name == "query" || name == "result" || name == "data" || name == "error" || name == "explain" || name == "async"
 }
 
 // isDatabaseLevelFile checks if the given name is a database-level special file
@@ -474,12 +611,18 @@ func validatePathSQLIdentifiers(dbName, tableName string) error {
 //	/dbName/tableName/ctl          -> (dbName, tableName, "", "ctl")
 //	/dbName/tableName/schema       -> (dbName, tableName, "", "schema")
 //	/dbName/tableName/count        -> (dbName, tableName, "", "count")
+//	/dbName/tableName/import       -> (dbName, tableName, "", "import")
 //	/dbName/tableName/<sid>        -> (dbName, tableName, sid, "")
 //	/dbName/tableName/<sid>/query  -> (dbName, tableName, sid, "query")
 //	/dbName/tableName/<sid>/result -> (dbName, tableName, sid, "result")
 //	/dbName/tableName/<sid>/ctl    -> (dbName, tableName, sid, "ctl")
 //	/dbName/tableName/<sid>/data   -> (dbName, tableName, sid, "data")
 //	/dbName/tableName/<sid>/error  -> (dbName, tableName, sid, "error")
+//	/dbName/tableName/<sid>/explain -> (dbName, tableName, sid, "explain")
+//	/dbName/tableName/<sid>/async  -> (dbName, tableName, sid, "async")
+//
+// Paths under /jobs are handled separately by parseJobPath, and those under
+// /dbName/tx by parseTxPath.
 func (fs *sqlfs2FS) parsePath(path string) (dbName, tableName, sid, operation string, err error) {
 	path = strings.TrimPrefix(path, "/")
 	parts := strings.Split(path, "/")
@@ -547,6 +690,7 @@ func (fs *sqlfs2FS) parsePath(path string) (dbName, tableName, sid, operation st
 		// - /dbName/tableName/ctl -> table-level ctl
 		// - /dbName/tableName/schema -> table-level schema
 		// - /dbName/tableName/count -> table-level count
+		// - /dbName/tableName/import -> table-level CSV import
 		// - /dbName/tableName/<sid> -> session directory
 		if isSessionID(parts[1]) && isSessionFile(parts[2]) {
 			// Database level session file: /dbName/<sid>/query
@@ -609,6 +753,20 @@ func (fs *sqlfs2FS) tableExists(dbName, tableName string) (bool, error) {
 }
 
 func (fs *sqlfs2FS) Read(path string, offset int64, size int64) ([]byte, error) {
+	if id, file, ok := parseJobPath(path); ok {
+		return fs.readJob(id, file, offset, size)
+	}
+	if tp, ok := parseTxPath(path); ok {
+		return fs.readTx(path, tp, offset, size)
+	}
+	if rp, ok := parseRowPath(path); ok {
+		return fs.readRowPath(path, rp, offset, size)
+	}
+
+	path, page, err := fs.splitPage(path)
+	if err != nil {
+		return nil, err
+	}
 	dbName, tableName, sid, operation, err := fs.parsePath(path)
 	if err != nil {
 		return nil, err
@@ -644,14 +802,7 @@ func (fs *sqlfs2FS) Read(path string, offset int64, size int64) ([]byte, error)
 
 		switch operation {
 		case "result":
-			session.mu.Lock()
-			result := session.result
-			session.mu.Unlock()
-
-			if result == nil {
-				return []byte{}, nil
-			}
-			return plugin.ApplyRangeRead(result, offset, size)
+			return fs.readResult(session, page, offset, size)
 
 		case "error":
 			session.mu.Lock()
@@ -664,7 +815,10 @@ func (fs *sqlfs2FS) Read(path string, offset int64, size int64) ([]byte, error)
 			data := []byte(errMsg + "\n")
 			return plugin.ApplyRangeRead(data, offset, size)
 
-		case "query", "data", "ctl":
+		case "explain":
+			return fs.readExplain(path, session, offset, size)
+
+		case "query", "data", "ctl", "async":
 			return nil, fmt.Errorf("%s is write-only", operation)
 
 		case "":
@@ -711,14 +865,7 @@ func (fs *sqlfs2FS) Read(path string, offset int64, size int64) ([]byte, error)
 
 		switch operation {
 		case "result":
-			session.mu.Lock()
-			result := session.result
-			session.mu.Unlock()
-
-			if result == nil {
-				return []byte{}, nil
-			}
-			return plugin.ApplyRangeRead(result, offset, size)
+			return fs.readResult(session, page, offset, size)
 
 		case "error":
 			session.mu.Lock()
@@ -731,7 +878,10 @@ func (fs *sqlfs2FS) Read(path string, offset int64, size int64) ([]byte, error)
 			data := []byte(errMsg + "\n")
 			return plugin.ApplyRangeRead(data, offset, size)
 
-		case "query", "data", "ctl":
+		case "explain":
+			return fs.readExplain(path, session, offset, size)
+
+		case "query", "data", "ctl", "async":
 			return nil, fmt.Errorf("%s is write-only", operation)
 
 		case "":
@@ -810,6 +960,9 @@ func (fs *sqlfs2FS) Read(path string, offset int64, size int64) ([]byte, error)
 			data := []byte(fmt.Sprintf("%d\n", count))
 			return plugin.ApplyRangeRead(data, offset, size)
 
+		case importFileName:
+			return plugin.ApplyRangeRead(fs.plugin.lastImport(dbName, tableName), offset, size)
+
 		case "":
 			// Directory read
 			return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
@@ -827,14 +980,7 @@ func (fs *sqlfs2FS) Read(path string, offset int64, size int64) ([]byte, error)
 
 	switch operation {
 	case "result":
-		session.mu.Lock()
-		result := session.result
-		session.mu.Unlock()
-
-		if result == nil {
-			return []byte{}, nil
-		}
-		return plugin.ApplyRangeRead(result, offset, size)
+		return fs.readResult(session, page, offset, size)
 
 	case "error":
 		session.mu.Lock()
@@ -847,7 +993,10 @@ func (fs *sqlfs2FS) Read(path string, offset int64, size int64) ([]byte, error)
 		data := []byte(errMsg + "\n")
 		return plugin.ApplyRangeRead(data, offset, size)
 
-	case "query", "data", "ctl":
+	case "explain":
+		return fs.readExplain(path, session, offset, size)
+
+	case "query", "data", "ctl", "async":
 		return nil, fmt.Errorf("%s is write-only", operation)
 
 	case "":
@@ -860,6 +1009,20 @@ func (fs *sqlfs2FS) Read(path string, offset int64, size int64) ([]byte, error)
 }
 
 func (fs *sqlfs2FS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	if _, _, ok := parseJobPath(path); ok {
+		return 0, fmt.Errorf("%s is read-only", path)
+	}
+	if tp, ok := parseTxPath(path); ok {
+		return fs.writeTx(path, tp, data)
+	}
+	if rp, ok := parseRowPath(path); ok {
+		return fs.writeRowPath(path, rp, data, offset)
+	}
+
+	path, page, err := fs.splitPage(path)
+	if err != nil {
+		return 0, err
+	}
 	dbName, tableName, sid, operation, err := fs.parsePath(path)
 	if err != nil {
 		return 0, err
@@ -886,6 +1049,7 @@ func (fs *sqlfs2FS) Write(path string, data []byte, offset int64, flags filesyst
 
 		session.mu.Lock()
 		defer session.UnlockWithTouch()
+		session.closeCursor()
 
 		switch operation {
 		case "ctl":
@@ -902,97 +1066,13 @@ func (fs *sqlfs2FS) Write(path string, data []byte, offset int64, flags filesyst
 			return 0, fmt.Errorf("unknown ctl command: %s", cmd)
 
 		case "query":
-			// Execute SQL query and store result
-			sqlStmt := strings.TrimSpace(string(data))
-			if sqlStmt == "" {
-				session.lastError = "empty SQL statement"
-				return 0, fmt.Errorf("empty SQL statement")
-			}
-
-			// Determine if this is a SELECT query
-			upperSQL := strings.ToUpper(sqlStmt)
-			isSelect := strings.HasPrefix(upperSQL, "SELECT") ||
-				strings.HasPrefix(upperSQL, "SHOW") ||
-				strings.HasPrefix(upperSQL, "DESCRIBE") ||
-				strings.HasPrefix(upperSQL, "EXPLAIN")
-
-			if isSelect {
-				rows, err := session.tx.Query(sqlStmt)
-				if err != nil {
-					session.lastError = err.Error()
-					session.result = nil
-					return 0, fmt.Errorf("query error: %w", err)
-				}
-				defer rows.Close()
-
-				columns, err := rows.Columns()
-				if err != nil {
-					session.lastError = err.Error()
-					session.result = nil
-					return 0, fmt.Errorf("failed to get columns: %w", err)
-				}
-
-				var results []map[string]interface{}
-				for rows.Next() {
-					values := make([]interface{}, len(columns))
-					valuePtrs := make([]interface{}, len(columns))
-					for i := range values {
-						valuePtrs[i] = &values[i]
-					}
-
-					if err := rows.Scan(valuePtrs...); err != nil {
-						session.lastError = err.Error()
-						session.result = nil
-						return 0, fmt.Errorf("scan error: %w", err)
-					}
-
-					row := make(map[string]interface{})
-					for i, col := range columns {
-						val := values[i]
-						if b, ok := val.([]byte); ok {
-							row[col] = string(b)
-						} else {
-							row[col] = val
-						}
-					}
-					results = append(results, row)
-				}
-
-				if err := rows.Err(); err != nil {
-					session.lastError = err.Error()
-					session.result = nil
-					return 0, fmt.Errorf("rows error: %w", err)
-				}
-
-				jsonData, err := json.MarshalIndent(results, "", "  ")
-				if err != nil {
-					session.lastError = err.Error()
-					session.result = nil
-					return 0, fmt.Errorf("json marshal error: %w", err)
-				}
-				session.result = append(jsonData, '\n')
-				session.lastError = ""
-			} else {
-				result, err := session.tx.Exec(sqlStmt)
-				if err != nil {
-					session.lastError = err.Error()
-					session.result = nil
-					return 0, fmt.Errorf("execution error: %w", err)
-				}
+			return fs.writeQuery(path, session, data, page)
 
-				rowsAffected, _ := result.RowsAffected()
-				lastInsertId, _ := result.LastInsertId()
-
-				resultMap := map[string]interface{}{
-					"rows_affected":  rowsAffected,
-					"last_insert_id": lastInsertId,
-				}
-				jsonData, _ := json.MarshalIndent(resultMap, "", "  ")
-				session.result = append(jsonData, '\n')
-				session.lastError = ""
-			}
+		case "explain":
+			return writeExplain(session, data)
 
-			return int64(len(data)), nil
+		case "async":
+			return fs.submitJob(path, session, data)
 
 		case "result", "error":
 			return 0, fmt.Errorf("%s is read-only", operation)
@@ -1026,6 +1106,7 @@ func (fs *sqlfs2FS) Write(path string, data []byte, offset int64, flags filesyst
 
 		session.mu.Lock()
 		defer session.UnlockWithTouch()
+		session.closeCursor()
 
 		switch operation {
 		case "ctl":
@@ -1042,97 +1123,13 @@ func (fs *sqlfs2FS) Write(path string, data []byte, offset int64, flags filesyst
 			return 0, fmt.Errorf("unknown ctl command: %s", cmd)
 
 		case "query":
-			// Execute SQL query and store result
-			sqlStmt := strings.TrimSpace(string(data))
-			if sqlStmt == "" {
-				session.lastError = "empty SQL statement"
-				return 0, fmt.Errorf("empty SQL statement")
-			}
-
-			// Determine if this is a SELECT query
-			upperSQL := strings.ToUpper(sqlStmt)
-			isSelect := strings.HasPrefix(upperSQL, "SELECT") ||
-				strings.HasPrefix(upperSQL, "SHOW") ||
-				strings.HasPrefix(upperSQL, "DESCRIBE") ||
-				strings.HasPrefix(upperSQL, "EXPLAIN")
-
-			if isSelect {
-				rows, err := session.tx.Query(sqlStmt)
-				if err != nil {
-					session.lastError = err.Error()
-					session.result = nil
-					return 0, fmt.Errorf("query error: %w", err)
-				}
-				defer rows.Close()
-
-				columns, err := rows.Columns()
-				if err != nil {
-					session.lastError = err.Error()
-					session.result = nil
-					return 0, fmt.Errorf("failed to get columns: %w", err)
-				}
-
-				var results []map[string]interface{}
-				for rows.Next() {
-					values := make([]interface{}, len(columns))
-					valuePtrs := make([]interface{}, len(columns))
-					for i := range values {
-						valuePtrs[i] = &values[i]
-					}
-
-					if err := rows.Scan(valuePtrs...); err != nil {
-						session.lastError = err.Error()
-						session.result = nil
-						return 0, fmt.Errorf("scan error: %w", err)
-					}
-
-					row := make(map[string]interface{})
-					for i, col := range columns {
-						val := values[i]
-						if b, ok := val.([]byte); ok {
-							row[col] = string(b)
-						} else {
-							row[col] = val
-						}
-					}
-					results = append(results, row)
-				}
-
-				if err := rows.Err(); err != nil {
-					session.lastError = err.Error()
-					session.result = nil
-					return 0, fmt.Errorf("rows error: %w", err)
-				}
-
-				jsonData, err := json.MarshalIndent(results, "", "  ")
-				if err != nil {
-					session.lastError = err.Error()
-					session.result = nil
-					return 0, fmt.Errorf("json marshal error: %w", err)
-				}
-				session.result = append(jsonData, '\n')
-				session.lastError = ""
-			} else {
-				result, err := session.tx.Exec(sqlStmt)
-				if err != nil {
-					session.lastError = err.Error()
-					session.result = nil
-					return 0, fmt.Errorf("execution error: %w", err)
-				}
+			return fs.writeQuery(path, session, data, page)
 
-				rowsAffected, _ := result.RowsAffected()
-				lastInsertId, _ := result.LastInsertId()
+		case "explain":
+			return writeExplain(session, data)
 
-				resultMap := map[string]interface{}{
-					"rows_affected":  rowsAffected,
-					"last_insert_id": lastInsertId,
-				}
-				jsonData, _ := json.MarshalIndent(resultMap, "", "  ")
-				session.result = append(jsonData, '\n')
-				session.lastError = ""
-			}
-
-			return int64(len(data)), nil
+		case "async":
+			return fs.submitJob(path, session, data)
 
 		case "result", "error":
 			return 0, fmt.Errorf("%s is read-only", operation)
@@ -1152,6 +1149,8 @@ func (fs *sqlfs2FS) Write(path string, data []byte, offset int64, flags filesyst
 			return 0, fmt.Errorf("cannot write to directory: %s", path)
 		case "ctl", "schema", "count":
 			return 0, fmt.Errorf("%s is read-only", operation)
+		case importFileName:
+			return fs.importTable(path, dbName, tableName, data)
 		default:
 			return 0, fmt.Errorf("unknown table-level file: %s", operation)
 		}
@@ -1165,6 +1164,7 @@ func (fs *sqlfs2FS) Write(path string, data []byte, offset int64, flags filesyst
 
 	session.mu.Lock()
 	defer session.UnlockWithTouch()
+	session.closeCursor()
 
 	switch operation {
 	case "ctl":
@@ -1182,103 +1182,7 @@ func (fs *sqlfs2FS) Write(path string, data []byte, offset int64, flags filesyst
 		return 0, fmt.Errorf("unknown ctl command: %s", cmd)
 
 	case "query":
-		// Execute SQL query and store result
-		sqlStmt := strings.TrimSpace(string(data))
-		if sqlStmt == "" {
-			session.lastError = "empty SQL statement"
-			return 0, fmt.Errorf("empty SQL statement")
-		}
-
-		// Determine if this is a SELECT query
-		upperSQL := strings.ToUpper(sqlStmt)
-		isSelect := strings.HasPrefix(upperSQL, "SELECT") ||
-			strings.HasPrefix(upperSQL, "SHOW") ||
-			strings.HasPrefix(upperSQL, "DESCRIBE") ||
-			strings.HasPrefix(upperSQL, "EXPLAIN")
-
-		if isSelect {
-			// Execute SELECT query
-			rows, err := session.tx.Query(sqlStmt)
-			if err != nil {
-				session.lastError = err.Error()
-				session.result = nil
-				return 0, fmt.Errorf("query error: %w", err)
-			}
-			defer rows.Close()
-
-			// Get column names
-			columns, err := rows.Columns()
-			if err != nil {
-				session.lastError = err.Error()
-				session.result = nil
-				return 0, fmt.Errorf("failed to get columns: %w", err)
-			}
-
-			// Read all results
-			var results []map[string]interface{}
-			for rows.Next() {
-				values := make([]interface{}, len(columns))
-				valuePtrs := make([]interface{}, len(columns))
-				for i := range values {
-					valuePtrs[i] = &values[i]
-				}
-
-				if err := rows.Scan(valuePtrs...); err != nil {
-					session.lastError = err.Error()
-					session.result = nil
-					return 0, fmt.Errorf("scan error: %w", err)
-				}
-
-				row := make(map[string]interface{})
-				for i, col := range columns {
-					val := values[i]
-					if b, ok := val.([]byte); ok {
-						row[col] = string(b)
-					} else {
-						row[col] = val
-					}
-				}
-				results = append(results, row)
-			}
-
-			if err := rows.Err(); err != nil {
-				session.lastError = err.Error()
-				session.result = nil
-				return 0, fmt.Errorf("rows error: %w", err)
-			}
-
-			// Store results as JSON
-			jsonData, err := json.MarshalIndent(results, "", "  ")
-			if err != nil {
-				session.lastError = err.Error()
-				session.result = nil
-				return 0, fmt.Errorf("json marshal error: %w", err)
-			}
-			session.result = append(jsonData, '\n')
-			session.lastError = ""
-		} else {
-			// Execute DML statement (INSERT, UPDATE, DELETE, etc.)
-			result, err := session.tx.Exec(sqlStmt)
-			if err != nil {
-				session.lastError = err.Error()
-				session.result = nil
-				return 0, fmt.Errorf("execution error: %w", err)
-			}
-
-			rowsAffected, _ := result.RowsAffected()
-			lastInsertId, _ := result.LastInsertId()
-
-			// Store result as JSON
-			resultMap := map[string]interface{}{
-				"rows_affected":  rowsAffected,
-				"last_insert_id": lastInsertId,
-			}
-			jsonData, _ := json.MarshalIndent(resultMap, "", "  ")
-			session.result = append(jsonData, '\n')
-			session.lastError = ""
-		}
-
-		return int64(len(data)), nil
+		return fs.writeQuery(path, session, data, page)
 
 	case "data":
 		// Insert JSON data
@@ -1379,6 +1283,9 @@ func (fs *sqlfs2FS) Write(path string, data []byte, offset int64, flags filesyst
 			}
 
 			insertSQL, err := insertRowsSQL(dbName, tableName, columnNames)
+			if err == nil {
+				err = fs.plugin.policy.Check(path, insertSQL)
+			}
 			if err != nil {
 				session.lastError = err.Error()
 				session.result = nil
@@ -1403,6 +1310,12 @@ func (fs *sqlfs2FS) Write(path string, data []byte, offset int64, flags filesyst
 
 		return int64(len(data)), nil
 
+	case "explain":
+		return writeExplain(session, data)
+
+	case "async":
+		return fs.submitJob(path, session, data)
+
 	case "result", "error":
 		return 0, fmt.Errorf("%s is read-only", operation)
 
@@ -1415,18 +1328,63 @@ func (fs *sqlfs2FS) Write(path string, data []byte, offset int64, flags filesyst
 }
 
 func (fs *sqlfs2FS) Create(path string) error {
+	// Creating the commit or rollback file of a transaction finishes it
+	if tp, ok := parseTxPath(path); ok && (tp.file == txCommitFile || tp.file == txRollbackFile) {
+		return fs.finishTx(path, tp, tp.file == txCommitFile)
+	}
 	return fmt.Errorf("operation not supported: create")
 }
 
 func (fs *sqlfs2FS) Mkdir(path string, perm uint32) error {
+	// Creating a directory under <db>/tx begins a transaction
+	if tp, ok := parseTxPath(path); ok {
+		return fs.beginTx(path, tp)
+	}
 	return fmt.Errorf("operation not supported: mkdir")
 }
 
+// Touch implements filesystem.Toucher: touching the commit or rollback file
+// of a transaction finishes it. Other files are rewritten as they are.
+func (fs *sqlfs2FS) Touch(path string) error {
+	if tp, ok := parseTxPath(path); ok {
+		_, err := fs.writeTx(path, tp, nil)
+		return err
+	}
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	_, err = fs.Write(path, data, -1, filesystem.WriteFlagNone)
+	return err
+}
+
 func (fs *sqlfs2FS) Remove(path string) error {
+	// Removing a job directory cancels the job
+	if id, file, ok := parseJobPath(path); ok {
+		return fs.removeJob(id, file)
+	}
+	// Removing a transaction directory rolls it back
+	if tp, ok := parseTxPath(path); ok && tp.file == "" {
+		return fs.finishTx(path, tp, false)
+	}
+	// Removing a row file deletes the row
+	if rp, ok := parseRowPath(path); ok {
+		return fs.deleteRow(path, rp)
+	}
 	return fmt.Errorf("operation not supported: remove")
 }
 
 func (fs *sqlfs2FS) RemoveAll(path string) error {
+	if id, file, ok := parseJobPath(path); ok {
+		return fs.removeJob(id, file)
+	}
+	if tp, ok := parseTxPath(path); ok && tp.file == "" {
+		return fs.finishTx(path, tp, false)
+	}
+	if rp, ok := parseRowPath(path); ok {
+		return fs.deleteRow(path, rp)
+	}
+
 	dbName, tableName, sid, operation, err := fs.parsePath(path)
 	if err != nil {
 		return err
@@ -1452,6 +1410,9 @@ func (fs *sqlfs2FS) RemoveAll(path string) error {
 		if err != nil {
 			return err
 		}
+		if err := fs.plugin.policy.Check(path, sqlStmt); err != nil {
+			return err
+		}
 		_, err = fs.plugin.db.Exec(sqlStmt)
 		if err != nil {
 			return fmt.Errorf("failed to drop database: %w", err)
@@ -1474,6 +1435,9 @@ func (fs *sqlfs2FS) RemoveAll(path string) error {
 		if err != nil {
 			return err
 		}
+		if err := fs.plugin.policy.Check(path, sqlStmt); err != nil {
+			return err
+		}
 		_, err = fs.plugin.db.Exec(sqlStmt)
 		if err != nil {
 			return fmt.Errorf("failed to drop table: %w", err)
@@ -1493,6 +1457,19 @@ func (fs *sqlfs2FS) RemoveAll(path string) error {
 }
 
 func (fs *sqlfs2FS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	if id, file, ok := parseJobPath(path); ok {
+		return fs.readDirJobs(id, file)
+	}
+	if tp, ok := parseTxPath(path); ok {
+		return fs.readDirTx(path, tp)
+	}
+	if rp, ok := parseRowPath(path); ok {
+		if rp.key != "" {
+			return nil, fmt.Errorf("not a directory: %s", path)
+		}
+		return fs.listRows(path, rp)
+	}
+
 	dbName, tableName, sid, operation, err := fs.parsePath(path)
 	if err != nil {
 		return nil, err
@@ -1511,6 +1488,14 @@ func (fs *sqlfs2FS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 				IsDir:   false,
 				Meta:    filesystem.MetaData{Name: PluginName, Type: "ctl"},
 			},
+			{
+				Name:    jobsDirName,
+				Size:    0,
+				Mode:    0755,
+				ModTime: now,
+				IsDir:   true,
+				Meta:    filesystem.MetaData{Name: PluginName, Type: "jobs"},
+			},
 		}
 
 		// Add root-level sessions
@@ -1584,6 +1569,22 @@ func (fs *sqlfs2FS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 				IsDir:   false,
 				Meta:    filesystem.MetaData{Name: PluginName, Type: "error"},
 			},
+			{
+				Name:    "explain",
+				Size:    0,
+				Mode:    0666, // write SQL to explain, read the plan
+				ModTime: now,
+				IsDir:   false,
+				Meta:    filesystem.MetaData{Name: PluginName, Type: "explain"},
+			},
+			{
+				Name:    "async",
+				Size:    0,
+				Mode:    0222, // write SQL to run it as a background job
+				ModTime: now,
+				IsDir:   false,
+				Meta:    filesystem.MetaData{Name: PluginName, Type: "async"},
+			},
 		}, nil
 	}
 
@@ -1600,6 +1601,15 @@ func (fs *sqlfs2FS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 			},
 		}
 
+		entries = append(entries, filesystem.FileInfo{
+			Name:    txDirName,
+			Size:    0,
+			Mode:    0755,
+			ModTime: now,
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "transactions"},
+		})
+
 		// Add database-level sessions
 		sids := fs.sessionManager.ListSessions(dbName, "")
 		for _, s := range sids {
@@ -1619,6 +1629,9 @@ func (fs *sqlfs2FS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 			return nil, err
 		}
 		for _, name := range tableNames {
+			if name == txDirName {
+				continue // Shadowed by the transaction directory
+			}
 			entries = append(entries, filesystem.FileInfo{
 				Name:    name,
 				Size:    0,
@@ -1671,6 +1684,22 @@ func (fs *sqlfs2FS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 				IsDir:   false,
 				Meta:    filesystem.MetaData{Name: PluginName, Type: "error"},
 			},
+			{
+				Name:    "explain",
+				Size:    0,
+				Mode:    0666, // write SQL to explain, read the plan
+				ModTime: now,
+				IsDir:   false,
+				Meta:    filesystem.MetaData{Name: PluginName, Type: "explain"},
+			},
+			{
+				Name:    "async",
+				Size:    0,
+				Mode:    0222, // write SQL to run it as a background job
+				ModTime: now,
+				IsDir:   false,
+				Meta:    filesystem.MetaData{Name: PluginName, Type: "async"},
+			},
 		}, nil
 	}
 
@@ -1710,6 +1739,24 @@ func (fs *sqlfs2FS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 				IsDir:   false,
 				Meta:    filesystem.MetaData{Name: PluginName, Type: "count"},
 			},
+			{
+				Name:    importFileName,
+				Size:    0,
+				Mode:    0666, // write CSV to load it, read the last result
+				ModTime: now,
+				IsDir:   false,
+				Meta:    filesystem.MetaData{Name: PluginName, Type: "import"},
+			},
+		}
+		if fs.hasPrimaryKey(dbName, tableName) {
+			entries = append(entries, filesystem.FileInfo{
+				Name:    rowsDirName,
+				Size:    0,
+				Mode:    0755,
+				ModTime: now,
+				IsDir:   true,
+				Meta:    filesystem.MetaData{Name: PluginName, Type: "rows"},
+			})
 		}
 
 		// Add active session directories
@@ -1776,6 +1823,22 @@ func (fs *sqlfs2FS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 				IsDir:   false,
 				Meta:    filesystem.MetaData{Name: PluginName, Type: "error"},
 			},
+			{
+				Name:    "explain",
+				Size:    0,
+				Mode:    0666, // write SQL to explain, read the plan
+				ModTime: now,
+				IsDir:   false,
+				Meta:    filesystem.MetaData{Name: PluginName, Type: "explain"},
+			},
+			{
+				Name:    "async",
+				Size:    0,
+				Mode:    0222, // write SQL to run it as a background job
+				ModTime: now,
+				IsDir:   false,
+				Meta:    filesystem.MetaData{Name: PluginName, Type: "async"},
+			},
 		}, nil
 	}
 
@@ -1783,6 +1846,20 @@ func (fs *sqlfs2FS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 }
 
 func (fs *sqlfs2FS) Stat(path string) (*filesystem.FileInfo, error) {
+	if id, file, ok := parseJobPath(path); ok {
+		return fs.statJob(id, file)
+	}
+	if tp, ok := parseTxPath(path); ok {
+		return fs.statTx(path, tp)
+	}
+	if rp, ok := parseRowPath(path); ok {
+		return fs.statRow(path, rp)
+	}
+
+	path, _, err := fs.splitPage(path)
+	if err != nil {
+		return nil, err
+	}
 	dbName, tableName, sid, operation, err := fs.parsePath(path)
 	if err != nil {
 		return nil, err
@@ -1838,10 +1915,12 @@ func (fs *sqlfs2FS) Stat(path string) (*filesystem.FileInfo, error) {
 		}
 		var mode uint32
 		switch operation {
-		case "ctl", "query":
+		case "ctl", "query", "async":
 			mode = 0222 // write-only
 		case "result", "error":
 			mode = 0444 // read-only
+		case "explain":
+			mode = 0666 // write SQL to explain, read the plan
 		default:
 			return nil, fmt.Errorf("unknown session file: %s", operation)
 		}
@@ -1903,10 +1982,12 @@ func (fs *sqlfs2FS) Stat(path string) (*filesystem.FileInfo, error) {
 		}
 		var mode uint32
 		switch operation {
-		case "ctl", "query":
+		case "ctl", "query", "async":
 			mode = 0222 // write-only
 		case "result", "error":
 			mode = 0444 // read-only
+		case "explain":
+			mode = 0666 // write SQL to explain, read the plan
 		default:
 			return nil, fmt.Errorf("unknown session file: %s", operation)
 		}
@@ -1941,9 +2022,12 @@ func (fs *sqlfs2FS) Stat(path string) (*filesystem.FileInfo, error) {
 		}, nil
 	}
 
-	// Table-level files (ctl, schema, count)
+	// Table-level files (ctl, schema, count, import)
 	if sid == "" && operation != "" {
 		mode := uint32(0444) // read-only by default
+		if operation == importFileName {
+			mode = 0666 // write CSV to load it, read the last result
+		}
 		return &filesystem.FileInfo{
 			Name:    operation,
 			Size:    0,
@@ -1980,10 +2064,12 @@ func (fs *sqlfs2FS) Stat(path string) (*filesystem.FileInfo, error) {
 
 		var mode uint32
 		switch operation {
-		case "ctl", "query", "data":
+		case "ctl", "query", "data", "async":
 			mode = 0222 // write-only
 		case "result", "error":
 			mode = 0444 // read-only
+		case "explain":
+			mode = 0666 // write SQL to explain, read the plan
 		default:
 			return nil, fmt.Errorf("unknown session file: %s", operation)
 		}
@@ -2016,6 +2102,13 @@ func (fs *sqlfs2FS) Truncate(path string, size int64) error {
 }
 
 func (fs *sqlfs2FS) Open(path string) (io.ReadCloser, error) {
+	// Results are encoded as they are read rather than read at once
+	if session, page, ok, err := fs.resultSession(path); err != nil {
+		return nil, err
+	} else if ok {
+		return &resultReader{fs: fs, session: session, page: page}, nil
+	}
+
 	data, err := fs.Read(path, 0, -1)
 	if err != nil && err != io.EOF {
 		return nil, err
@@ -2038,12 +2131,30 @@ DIRECTORY STRUCTURE:
     ctl              # Read to create new session, returns session ID
     schema           # Read-only: table structure (CREATE TABLE)
     count            # Read-only: row count
+    import           # Write CSV/TSV/Parquet to bulk-load (creates the table if needed)
+    rows/            # Tables with a primary key: one JSON file per row
+      new            # Write a JSON object or array to insert, read the last insert
+      <key>          # Read a row, write a JSON object to update it, rm to delete it
     <sid>/           # Session directory (numeric ID)
       ctl            # Write "close" to close session
       query          # Write SQL to execute
       result         # Read query results (JSON)
       data           # Write JSON to insert
       error          # Read error messages
+      explain        # Read query plan of the last query (write SQL to explain it without running)
+      async          # Write SQL to run it as a background job
+
+  /sqlfs2/<dbName>/tx/<name>/ # Transaction begun by mkdir (rm -r to roll back)
+    query            # Write SQL to execute within the transaction
+    result           # Read-only: result of the last statement (JSON)
+    error            # Read-only: error of the last statement
+    commit           # Touch to commit; the directory goes away
+    rollback         # Touch to roll back; the directory goes away
+
+  /sqlfs2/jobs/<id>/ # Background query jobs (rm -r to cancel)
+    status           # Read-only: job state (JSON)
+    query            # Read-only: submitted SQL
+    result           # Read-only: query results once completed (JSON)
 
 BASIC WORKFLOW:
 
@@ -2098,8 +2209,49 @@ CONFIGURATION:
     database = "test"
     enable_tls = true  # For TiDB Cloud
 
+  Statement Policy (optional, for untrusted clients):
+    [plugins.sqlfs2.config]
+    read_only = true                          # Only SELECT, SHOW, DESCRIBE, EXPLAIN
+    deny_ddl = true                           # Reject CREATE/ALTER/DROP/TRUNCATE/RENAME
+    require_where = true                      # Reject UPDATE/DELETE without WHERE
+    allowed_statements = "select,insert"      # Allowlist of statement kinds
+
+  Statements are parsed before execution; denied statements fail with
+  "permission denied" and the reason is written to the session error file.
+
+  Async Jobs (optional):
+    [plugins.sqlfs2.config]
+    job_workers = 4                           # Concurrently running jobs
+    job_queue_size = 100                      # Maximum queued jobs
+    job_retention = "1h"                      # Keep finished jobs this long
+
+  Row Files (optional):
+    [plugins.sqlfs2.config]
+    rows_read_only = true                     # Serve rows/ without insert, update or delete
+
+  Large Results (optional):
+    [plugins.sqlfs2.config]
+    max_result_size = "16MB"                  # Larger results are streamed when read
+    page_size = 1000                          # Rows per page of query?page=N, result?page=N
+
 USAGE EXAMPLES:
 
+  # Change several tables atomically
+  mkdir /sqlfs2/mydb/tx/transfer
+  echo 'UPDATE accounts SET balance = balance - 10 WHERE id = 1' > /sqlfs2/mydb/tx/transfer/query
+  echo 'UPDATE accounts SET balance = balance + 10 WHERE id = 2' > /sqlfs2/mydb/tx/transfer/query
+  touch /sqlfs2/mydb/tx/transfer/commit
+
+  # Read a result page by page
+  echo 'SELECT * FROM events ORDER BY id' > /sqlfs2/mydb/$sid/query
+  cat '/sqlfs2/mydb/$sid/result?page=2'
+
+  # Run a long query in the background
+  echo 'SELECT COUNT(*) FROM events' > /sqlfs2/mydb/$sid/async
+  cat /sqlfs2/mydb/$sid/result      # {"job_id": 1, "path": "jobs/1"}
+  cat /sqlfs2/jobs/1/status
+  cat /sqlfs2/jobs/1/result
+
   # View table schema
   cat /sqlfs2/mydb/users/schema
 
@@ -2127,6 +2279,18 @@ USAGE EXAMPLES:
   {"name": "Frank", "age": 35}
   EOF
 
+  # Bulk-load a CSV file (no session needed; creates the table if missing)
+  cp users.csv /sqlfs2/mydb/users/import
+  cat /sqlfs2/mydb/users/import
+
+  # Edit rows as JSON files named by primary key (composite keys: "1,2")
+  ls /sqlfs2/mydb/users/rows/
+  cat /sqlfs2/mydb/users/rows/42
+  echo '{"age": 31}' > /sqlfs2/mydb/users/rows/42
+  echo '{"name": "Grace", "age": 41}' > /sqlfs2/mydb/users/rows/new
+  cat /sqlfs2/mydb/users/rows/new      # {"table": "users", "rows": 1, "keys": ["43"], ...}
+  rm /sqlfs2/mydb/users/rows/43
+
   # Check for errors
   cat /sqlfs2/mydb/users/$sid/error
 
@@ -2158,6 +2322,7 @@ ADVANTAGES:
   - Support for SQLite, MySQL, and TiDB backends
   - Auto-generate INSERT from JSON documents
   - NDJSON streaming for large imports
+  - CSV/TSV/Parquet bulk import with schema inference
   - Configurable session timeout
 `
 }
@@ -2187,6 +2352,8 @@ type SQLFileHandle struct {
 	// Buffer for read results
 	readBuffer bytes.Buffer
 	readPos    int64
+	// Result of a CSV import, recorded once the transaction commits
+	pendingImport *ImportResult
 
 	// Parsed path components
 	dbName    string
@@ -2314,6 +2481,9 @@ func (h *SQLFileHandle) populateReadBuffer() error {
 		}
 		h.readBuffer.WriteString(fmt.Sprintf("%d\n", count))
 
+	case importFileName:
+		h.readBuffer.Write(h.fs.plugin.lastImport(h.dbName, h.tableName))
+
 	case "result":
 		// Result is read from session, but for handle-based access we need to get it from somewhere
 		// For now, return empty - the session-based Read handles this case
@@ -2477,6 +2647,10 @@ func (h *SQLFileHandle) Sync() error {
 	}
 
 	h.committed = true
+	if h.pendingImport != nil {
+		h.fs.plugin.recordImport(h.dbName, h.tableName, h.pendingImport)
+		h.pendingImport = nil
+	}
 	log.Debugf("[sqlfs2] Transaction committed for handle %d", h.id)
 	return nil
 }
@@ -2487,6 +2661,11 @@ func (h *SQLFileHandle) executeBufferedSQL() error {
 	if sqlStmt == "" {
 		return nil
 	}
+	if h.operation == "query" || h.operation == "execute" {
+		if err := h.fs.plugin.policy.Check(h.path, sqlStmt); err != nil {
+			return err
+		}
+	}
 
 	switch h.operation {
 	case "query":
@@ -2555,6 +2734,14 @@ func (h *SQLFileHandle) executeBufferedSQL() error {
 			return err
 		}
 
+	case importFileName:
+		// Load CSV in transaction; the result is recorded once committed
+		result, err := h.fs.importRows(h.tx, h.path, h.dbName, h.tableName, h.writeBuffer.Bytes())
+		if err != nil {
+			return err
+		}
+		h.pendingImport = result
+
 	default:
 		return fmt.Errorf("unknown operation: %s", h.operation)
 	}
@@ -2659,6 +2846,9 @@ func (h *SQLFileHandle) executeInsertJSON(data string) error {
 		if err != nil {
 			return err
 		}
+		if err := h.fs.plugin.policy.Check(h.path, insertSQL); err != nil {
+			return err
+		}
 
 		if _, err := h.tx.Exec(insertSQL, values...); err != nil {
 			return fmt.Errorf("insert error at record %d: %w", idx+1, err)
@@ -2710,6 +2900,19 @@ func (h *SQLFileHandle) Stat() (*filesystem.FileInfo, error) {
 
 // OpenHandle opens a file and returns a handle with a new transaction
 func (fs *sqlfs2FS) OpenHandle(path string, flags filesystem.OpenFlag, mode uint32) (filesystem.FileHandle, error) {
+	// Job files are served from in-memory state; use Read instead
+	if _, _, ok := parseJobPath(path); ok {
+		return nil, filesystem.ErrNotSupported
+	}
+	// Row files are written whole; use Read/Write instead
+	if _, ok := parseRowPath(path); ok {
+		return nil, filesystem.ErrNotSupported
+	}
+	// Transaction files execute on write, like session files
+	if _, ok := parseTxPath(path); ok {
+		return nil, filesystem.ErrNotSupported
+	}
+
 	dbName, tableName, sid, operation, err := fs.parsePath(path)
 	if err != nil {
 		return nil, err
@@ -2729,8 +2932,9 @@ func (fs *sqlfs2FS) OpenHandle(path string, flags filesystem.OpenFlag, mode uint
 		return nil, filesystem.ErrNotSupported
 	}
 
-	// Check if table exists for table-level operations
-	if tableName != "" {
+	// Check if table exists for table-level operations.
+	// An import creates the table when it does not exist yet.
+	if tableName != "" && operation != importFileName {
 		exists, err := fs.tableExists(dbName, tableName)
 		if err != nil {
 			return nil, fmt.Errorf("failed to check table existence: %w", err)
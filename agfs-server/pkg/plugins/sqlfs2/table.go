@@ -0,0 +1,87 @@
+package sqlfs2
+
+import (
+	"fmt"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// ReadTable implements filesystem.TableReader for table directories
+// (/<db>/<table>), so query layers such as fsql can join sqlfs2 tables with
+// files from other mounts.
+func (fs *sqlfs2FS) ReadTable(path string, maxRows int) (*filesystem.Table, error) {
+	if _, _, ok := parseJobPath(path); ok {
+		return nil, filesystem.ErrNotSupported
+	}
+	dbName, tableName, sid, operation, err := fs.parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if tableName == "" || sid != "" || operation != "" {
+		return nil, filesystem.ErrNotSupported
+	}
+
+	exists, err := fs.tableExists(dbName, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check table existence: %w", err)
+	}
+	if !exists {
+		return nil, filesystem.NewNotFoundError("readtable", path)
+	}
+
+	table, err := qualifiedTableName(dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	query := "SELECT * FROM " + table
+	if maxRows > 0 {
+		// Fetch one extra row to detect truncation
+		query += fmt.Sprintf(" LIMIT %d", maxRows+1)
+	}
+	if err := fs.plugin.policy.Check(path, query); err != nil {
+		return nil, err
+	}
+	if err := fs.plugin.backend.SwitchDatabase(fs.plugin.db, dbName); err != nil {
+		return nil, err
+	}
+
+	rows, err := fs.plugin.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	result := &filesystem.Table{Columns: columns}
+	for rows.Next() {
+		if maxRows > 0 && len(result.Rows) == maxRows {
+			result.Truncated = true
+			break
+		}
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		for i, val := range values {
+			if b, ok := val.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return result, nil
+}
+
+// Ensure sqlfs2FS implements TableReader interface
+var _ filesystem.TableReader = (*sqlfs2FS)(nil)
+var _ filesystem.DirPreviewer = (*sqlfs2FS)(nil)
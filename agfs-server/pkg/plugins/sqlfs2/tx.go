@@ -0,0 +1,311 @@
+package sqlfs2
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// txDirName is the reserved database-level directory of explicit
+	// transactions. It shadows any table with the same name.
+	txDirName = "tx"
+	// txCommitFile and txRollbackFile finish a transaction when touched
+	txCommitFile   = "commit"
+	txRollbackFile = "rollback"
+)
+
+// txPath is a path in the transaction directory of a database:
+// /<db>/tx/<name>/<file>
+type txPath struct {
+	dbName string
+	name   string // Transaction; "" for the transaction directory itself
+	file   string // File of the transaction; "" for its directory
+}
+
+// parseTxPath reports whether path is /<db>/tx or a path below it
+func parseTxPath(path string) (*txPath, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 4 || parts[1] != txDirName || parts[0] == jobsDirName {
+		return nil, false
+	}
+	tp := &txPath{dbName: parts[0]}
+	if len(parts) > 2 {
+		tp.name = parts[2]
+	}
+	if len(parts) > 3 {
+		tp.file = parts[3]
+	}
+	return tp, true
+}
+
+// validateTxName checks the name given to a transaction directory
+func validateTxName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") {
+		return filesystem.NewInvalidArgumentError("transaction", name, "must not be empty or start with '.'")
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return filesystem.NewInvalidArgumentError("transaction", name, "may only contain letters, digits, '-', '_' and '.'")
+		}
+	}
+	return nil
+}
+
+// BeginTransaction starts the transaction of the directory /<db>/tx/<name>.
+// It is a session keyed under the tx directory, so it expires like one.
+func (sm *SessionManager) BeginTransaction(db *sql.DB, dbName, name, useSQL string) (*Session, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	key := fmt.Sprintf("%s/%s/%s", dbName, txDirName, name)
+	if _, exists := sm.sessions[key]; exists {
+		return nil, filesystem.NewAlreadyExistsError("mkdir", "/"+key)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if useSQL != "" {
+		if _, err := tx.Exec(useSQL); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to switch to database %s: %w", dbName, err)
+		}
+	}
+
+	session := &Session{
+		id:         sm.nextID,
+		dbName:     dbName,
+		tableName:  txDirName,
+		tx:         tx,
+		lastAccess: time.Now(),
+	}
+	sm.nextID++
+	sm.sessions[key] = session
+
+	log.Debugf("[sqlfs2] Began transaction %s in %s", name, dbName)
+	return session, nil
+}
+
+// FinishTransaction commits or rolls back the transaction of the directory
+// /<db>/tx/<name>, which goes away either way
+func (sm *SessionManager) FinishTransaction(dbName, name string, commit bool) error {
+	sm.mu.Lock()
+	key := fmt.Sprintf("%s/%s/%s", dbName, txDirName, name)
+	session, exists := sm.sessions[key]
+	if !exists {
+		sm.mu.Unlock()
+		return filesystem.NewNotFoundError("transaction", "/"+key)
+	}
+	delete(sm.sessions, key)
+	sm.mu.Unlock()
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.closeCursor()
+	if !commit {
+		return session.tx.Rollback()
+	}
+	if err := session.tx.Commit(); err != nil {
+		return fmt.Errorf("transaction commit failed: %w", err)
+	}
+	log.Debugf("[sqlfs2] Committed transaction %s in %s", name, dbName)
+	return nil
+}
+
+// txSession returns the session of the transaction of tp
+func (fs *sqlfs2FS) txSession(tp *txPath) (*Session, error) {
+	session := fs.sessionManager.GetSession(tp.dbName, txDirName, tp.name)
+	if session == nil {
+		return nil, filesystem.NewNotFoundError("transaction", "/"+tp.dbName+"/"+txDirName+"/"+tp.name)
+	}
+	return session, nil
+}
+
+// beginTx handles mkdir /<db>/tx/<name>
+func (fs *sqlfs2FS) beginTx(path string, tp *txPath) error {
+	if tp.name == "" || tp.file != "" {
+		return fmt.Errorf("operation not supported: mkdir %s", path)
+	}
+	if err := validatePathSQLIdentifiers(tp.dbName, ""); err != nil {
+		return err
+	}
+	if err := validateTxName(tp.name); err != nil {
+		return err
+	}
+	useSQL, err := fs.plugin.backend.UseDatabaseSQL(tp.dbName)
+	if err != nil {
+		return err
+	}
+	_, err = fs.sessionManager.BeginTransaction(fs.plugin.db, tp.dbName, tp.name, useSQL)
+	return err
+}
+
+// finishTx commits or rolls back the transaction of tp
+func (fs *sqlfs2FS) finishTx(path string, tp *txPath, commit bool) error {
+	if tp.name == "" {
+		return fmt.Errorf("operation not supported: %s", path)
+	}
+	return fs.sessionManager.FinishTransaction(tp.dbName, tp.name, commit)
+}
+
+// readTx handles reads of the files of a transaction
+func (fs *sqlfs2FS) readTx(path string, tp *txPath, offset, size int64) ([]byte, error) {
+	path, page, err := fs.splitPage(path)
+	if err != nil {
+		return nil, err
+	}
+	if tp.name == "" || tp.file == "" {
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+	session, err := fs.txSession(tp)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name := strings.SplitN(tp.file, "?", 2)[0]; name {
+	case "result":
+		return fs.readResult(session, page, offset, size)
+	case "error":
+		session.mu.Lock()
+		errMsg := session.lastError
+		session.mu.Unlock()
+		if errMsg == "" {
+			return []byte{}, nil
+		}
+		return plugin.ApplyRangeRead([]byte(errMsg+"\n"), offset, size)
+	case "query", txCommitFile, txRollbackFile:
+		return nil, fmt.Errorf("%s is write-only", name)
+	default:
+		return nil, filesystem.NewNotFoundError("read", path)
+	}
+}
+
+// writeTx handles writes to the files of a transaction: statements written
+// to query run within it, and any write to commit or rollback finishes it
+func (fs *sqlfs2FS) writeTx(path string, tp *txPath, data []byte) (int64, error) {
+	path, page, err := fs.splitPage(path)
+	if err != nil {
+		return 0, err
+	}
+	if tp.name == "" || tp.file == "" {
+		return 0, fmt.Errorf("cannot write to directory: %s", path)
+	}
+
+	switch name := strings.SplitN(tp.file, "?", 2)[0]; name {
+	case "query":
+		session, err := fs.txSession(tp)
+		if err != nil {
+			return 0, err
+		}
+		session.mu.Lock()
+		defer session.UnlockWithTouch()
+		session.closeCursor()
+		return fs.writeQuery(path, session, data, page)
+	case txCommitFile, txRollbackFile:
+		if err := fs.finishTx(path, tp, name == txCommitFile); err != nil {
+			return 0, err
+		}
+		return int64(len(data)), nil
+	case "result", "error":
+		return 0, fmt.Errorf("%s is read-only", name)
+	default:
+		return 0, filesystem.NewNotFoundError("write", path)
+	}
+}
+
+// txFiles are the files of a transaction directory and their modes
+var txFiles = []struct {
+	name string
+	mode uint32
+}{
+	{"query", 0222},
+	{"result", 0444},
+	{"error", 0444},
+	{txCommitFile, 0222},
+	{txRollbackFile, 0222},
+}
+
+// statTx returns the info of the tx directory, a transaction or its files
+func (fs *sqlfs2FS) statTx(path string, tp *txPath) (*filesystem.FileInfo, error) {
+	path, _, err := fs.splitPage(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePathSQLIdentifiers(tp.dbName, ""); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if tp.name == "" {
+		return &filesystem.FileInfo{
+			Name:    txDirName,
+			Mode:    0755,
+			ModTime: now,
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "transactions"},
+		}, nil
+	}
+	if _, err := fs.txSession(tp); err != nil {
+		return nil, err
+	}
+	if tp.file == "" {
+		return &filesystem.FileInfo{
+			Name:    tp.name,
+			Mode:    0755,
+			ModTime: now,
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "transaction"},
+		}, nil
+	}
+	name := strings.SplitN(tp.file, "?", 2)[0]
+	for _, f := range txFiles {
+		if f.name == name {
+			return &filesystem.FileInfo{
+				Name:    name,
+				Mode:    f.mode,
+				ModTime: now,
+				Meta:    filesystem.MetaData{Name: PluginName, Type: "tx-" + name},
+			}, nil
+		}
+	}
+	return nil, filesystem.NewNotFoundError("stat", path)
+}
+
+// readDirTx lists the open transactions of a database, or the files of one
+func (fs *sqlfs2FS) readDirTx(path string, tp *txPath) ([]filesystem.FileInfo, error) {
+	if tp.file != "" {
+		return nil, filesystem.NewNotDirectoryError(path)
+	}
+	if tp.name == "" {
+		if err := validatePathSQLIdentifiers(tp.dbName, ""); err != nil {
+			return nil, err
+		}
+		var entries []filesystem.FileInfo
+		for _, name := range fs.sessionManager.ListSessions(tp.dbName, txDirName) {
+			entries = append(entries, filesystem.FileInfo{
+				Name:    name,
+				Mode:    0755,
+				ModTime: time.Now(),
+				IsDir:   true,
+				Meta:    filesystem.MetaData{Name: PluginName, Type: "transaction"},
+			})
+		}
+		return entries, nil
+	}
+
+	var entries []filesystem.FileInfo
+	for _, f := range txFiles {
+		info, err := fs.statTx(path+"/"+f.name, &txPath{dbName: tp.dbName, name: tp.name, file: f.name})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *info)
+	}
+	return entries, nil
+}
@@ -0,0 +1,106 @@
+package sqlfs2
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func countAccounts(t *testing.T, plugin *SQLFS2Plugin, where string) int {
+	t.Helper()
+	var n int
+	if err := plugin.db.QueryRow("SELECT COUNT(*) FROM accounts WHERE " + where).Scan(&n); err != nil {
+		t.Fatalf("count error = %v", err)
+	}
+	return n
+}
+
+func TestSQLFS2TransactionCommit(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+	mustExecSQL(t, plugin.db, "CREATE TABLE accounts (id INTEGER PRIMARY KEY, balance INTEGER)")
+	mustExecSQL(t, plugin.db, "INSERT INTO accounts VALUES (1, 100), (2, 0)")
+
+	if err := fs.Mkdir("/main/tx/transfer", 0755); err != nil {
+		t.Fatalf("Mkdir(tx/transfer) error = %v", err)
+	}
+	if err := fs.Mkdir("/main/tx/transfer", 0755); !errors.Is(err, filesystem.ErrAlreadyExists) {
+		t.Fatalf("second Mkdir(tx/transfer) error = %v, want already exists", err)
+	}
+
+	for _, stmt := range []string{
+		"UPDATE accounts SET balance = balance - 10 WHERE id = 1",
+		"UPDATE accounts SET balance = balance + 10 WHERE id = 2",
+	} {
+		if _, err := fs.Write("/main/tx/transfer/query", []byte(stmt), -1, 0); err != nil {
+			t.Fatalf("Write(query) error = %v", err)
+		}
+	}
+	data, err := readSessionFile(t, fs, "/main/tx/transfer/result")
+	if err != nil || !strings.Contains(string(data), `"rows_affected": 1`) {
+		t.Fatalf("Read(result) = %s, %v", data, err)
+	}
+
+	// Statements within the transaction see its changes
+	if _, err := fs.Write("/main/tx/transfer/query", []byte("SELECT id FROM accounts WHERE balance = 10"), -1, 0); err != nil {
+		t.Fatalf("Write(SELECT) error = %v", err)
+	}
+	data, _ = readSessionFile(t, fs, "/main/tx/transfer/result")
+	requireIDs(t, data, 2, 2)
+
+	entries, err := fs.ReadDir("/main/tx")
+	if err != nil || len(entries) != 1 || entries[0].Name != "transfer" {
+		t.Fatalf("ReadDir(tx) = %v, %v", entries, err)
+	}
+
+	if err := fs.Touch("/main/tx/transfer/commit"); err != nil {
+		t.Fatalf("Touch(commit) error = %v", err)
+	}
+	if n := countAccounts(t, plugin, "balance = 90 OR balance = 10"); n != 2 {
+		t.Fatalf("%d accounts changed after commit, want 2", n)
+	}
+	if _, err := fs.Stat("/main/tx/transfer"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Fatalf("Stat(tx/transfer) after commit error = %v, want not found", err)
+	}
+}
+
+func TestSQLFS2TransactionRollback(t *testing.T) {
+	plugin, fs := newSQLiteSQLFS2ForTest(t)
+	mustExecSQL(t, plugin.db, "CREATE TABLE accounts (id INTEGER PRIMARY KEY, balance INTEGER)")
+	mustExecSQL(t, plugin.db, "INSERT INTO accounts VALUES (1, 100)")
+
+	for _, finish := range []func() error{
+		func() error { return fs.Create("/main/tx/t1/rollback") },
+		func() error { return fs.RemoveAll("/main/tx/t1") },
+	} {
+		if err := fs.Mkdir("/main/tx/t1", 0755); err != nil {
+			t.Fatalf("Mkdir(tx/t1) error = %v", err)
+		}
+		if _, err := fs.Write("/main/tx/t1/query", []byte("DELETE FROM accounts WHERE id = 1"), -1, 0); err != nil {
+			t.Fatalf("Write(query) error = %v", err)
+		}
+		// A failed statement leaves the transaction open
+		if _, err := fs.Write("/main/tx/t1/query", []byte("DELETE FROM missing"), -1, 0); err == nil {
+			t.Fatal("Write(query) of a bad statement should fail")
+		}
+		if data, _ := readSessionFile(t, fs, "/main/tx/t1/error"); len(data) == 0 {
+			t.Fatal("error file is empty after a failed statement")
+		}
+		if err := finish(); err != nil {
+			t.Fatalf("rollback error = %v", err)
+		}
+		if n := countAccounts(t, plugin, "id = 1"); n != 1 {
+			t.Fatal("row deleted by a rolled back transaction")
+		}
+	}
+
+	for _, path := range []string{"/main/tx/bad name", "/main/tx/.hidden"} {
+		if err := fs.Mkdir(path, 0755); !errors.Is(err, filesystem.ErrInvalidArgument) {
+			t.Fatalf("Mkdir(%s) error = %v, want invalid argument", path, err)
+		}
+	}
+	if err := fs.Touch("/main/tx/t1/commit"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Fatalf("Touch(commit) of a finished transaction error = %v, want not found", err)
+	}
+}
@@ -0,0 +1,249 @@
+package vectorfs
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// askFile is the file of a namespace that answers questions from its
+// documents: write a question, then read the answer with its sources
+//
+//	echo "How do I deploy?" > /vectorfs/my_project/ask
+//	cat /vectorfs/my_project/ask
+const askFile = "ask"
+
+// Answer providers accepted by the "answer_provider" config key
+const (
+	// AnswerProviderOpenAI asks a chat model behind an OpenAI-compatible
+	// chat API (OpenAI, Ollama, vLLM...)
+	AnswerProviderOpenAI = "openai"
+)
+
+// answerProviders lists the valid answer_provider values
+var answerProviders = []string{AnswerProviderOpenAI}
+
+const (
+	defaultAnswerModel = "gpt-4o-mini"
+	// defaultAnswerTopK is how many chunks an answer is grounded in
+	defaultAnswerTopK = 5
+
+	// defaultAnswerPrompt keeps answers to what the sources say, cited by
+	// their number
+	defaultAnswerPrompt = `Answer the question using only the numbered sources. ` +
+		`Cite the sources each statement relies on as [1], [2]... ` +
+		`If the sources do not answer the question, say so instead of guessing.`
+
+	// answerTimeout bounds a chat model call
+	answerTimeout = 2 * time.Minute
+)
+
+// AnswerConfig holds the configuration of answer synthesis
+type AnswerConfig struct {
+	Provider string // Provider name; empty disables ask
+	APIKey   string // API key; optional for a custom endpoint
+	Model    string // Chat model name
+	Endpoint string // Chat completions endpoint (empty = OpenAI)
+	TopK     int    // Chunks retrieved to answer a question
+	Prompt   string // Instructions given to the chat model
+}
+
+// parseAnswerConfig reads the optional answer settings. The OpenAI key of
+// the embedding configuration is reused when answer_api_key is unset.
+func parseAnswerConfig(cfg map[string]interface{}) (AnswerConfig, error) {
+	ac := AnswerConfig{
+		Provider: config.GetStringConfig(cfg, "answer_provider", ""),
+		APIKey:   config.GetStringConfig(cfg, "answer_api_key", ""),
+		Model:    config.GetStringConfig(cfg, "answer_model", defaultAnswerModel),
+		Endpoint: config.GetStringConfig(cfg, "answer_endpoint", ""),
+		TopK:     config.GetIntConfig(cfg, "answer_top_k", defaultAnswerTopK),
+		Prompt:   config.GetStringConfig(cfg, "answer_prompt", defaultAnswerPrompt),
+	}
+	if ac.APIKey == "" && ac.Endpoint == "" {
+		ac.APIKey = config.GetStringConfig(cfg, "openai_api_key", "")
+	}
+	return ac, ac.Validate()
+}
+
+// Validate checks the answer configuration
+func (ac AnswerConfig) Validate() error {
+	switch ac.Provider {
+	case "":
+		return nil
+	case AnswerProviderOpenAI:
+		if ac.APIKey == "" && ac.Endpoint == "" {
+			return fmt.Errorf("answer_api_key or openai_api_key is required when answer_provider is openai")
+		}
+	default:
+		return fmt.Errorf("unsupported answer_provider: %s (supported: %s)", ac.Provider, strings.Join(answerProviders, ", "))
+	}
+	if ac.TopK <= 0 {
+		return fmt.Errorf("answer_top_k must be positive, got %d", ac.TopK)
+	}
+	return nil
+}
+
+// Answerer writes the answer to a question from numbered sources
+type Answerer interface {
+	// Answer returns the answer to question, citing sources by their
+	// number, from 1
+	Answer(question string, sources []string) (string, error)
+}
+
+// NewAnswerer creates the answerer of a configuration, or nil if ask is
+// disabled
+func NewAnswerer(ac AnswerConfig) (Answerer, error) {
+	if err := ac.Validate(); err != nil {
+		return nil, err
+	}
+	switch ac.Provider {
+	case AnswerProviderOpenAI:
+		endpoint := ac.Endpoint
+		if endpoint == "" {
+			endpoint = defaultOpenAIChatEndpoint
+		}
+		return &openAIAnswerer{
+			apiKey:   ac.APIKey,
+			model:    ac.Model,
+			endpoint: endpoint,
+			prompt:   ac.Prompt,
+			client:   &http.Client{Timeout: answerTimeout},
+		}, nil
+	}
+	return nil, nil
+}
+
+type openAIAnswerRequest struct {
+	Model       string              `json:"model"`
+	Messages    []openAIChatMessage `json:"messages"`
+	Temperature float64             `json:"temperature"`
+}
+
+// openAIAnswerer answers with an OpenAI chat model
+type openAIAnswerer struct {
+	apiKey   string
+	model    string
+	endpoint string
+	prompt   string
+	client   *http.Client
+}
+
+func (a *openAIAnswerer) Answer(question string, sources []string) (string, error) {
+	var content strings.Builder
+	for i, source := range sources {
+		fmt.Fprintf(&content, "Source [%d]:\n%s\n\n", i+1, source)
+	}
+	fmt.Fprintf(&content, "Question: %s\n", question)
+	request := openAIAnswerRequest{
+		Model: a.model,
+		Messages: []openAIChatMessage{
+			{Role: "system", Content: a.prompt},
+			{Role: "user", Content: content.String()},
+		},
+	}
+
+	var response openAIChatResponse
+	if err := postJSON(a.client, a.endpoint, a.apiKey, "answer", request, &response); err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("no completion returned from API")
+	}
+	return strings.TrimSpace(response.Choices[0].Message.Content), nil
+}
+
+// askAnswer is the answer to the last question asked of a namespace
+type askAnswer struct {
+	Question string
+	Answer   string
+	Sources  []mountablefs.CustomGrepResult
+	AskedAt  time.Time
+}
+
+// noSourcesAnswer answers questions that no document matches, without
+// asking the model
+const noSourcesAnswer = "No indexed document matches the question."
+
+// formatAskAnswer renders the ask file: the question, the answer and the
+// chunks it cites by number
+func formatAskAnswer(a *askAnswer) string {
+	if a == nil {
+		return "no question asked yet; write one to ask\n"
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "question: %s\n", a.Question)
+	fmt.Fprintf(&sb, "asked: %s\n\n", a.AskedAt.Format(time.RFC3339))
+	sb.WriteString(a.Answer)
+	sb.WriteString("\n")
+	if len(a.Sources) > 0 {
+		sb.WriteString("\nsources:\n")
+		for i, r := range a.Sources {
+			fmt.Fprintf(&sb, "[%d] %s (chunk %d, score %.4f)\n", i+1, r.File, r.Line, resultScore(r))
+		}
+	}
+	return sb.String()
+}
+
+// readAskAnswer reads the answer to the last question asked of a namespace
+func (vfs *vectorFS) readAskAnswer(namespace string) ([]byte, error) {
+	if err := vfs.requireNamespace("ask", namespace); err != nil {
+		return nil, err
+	}
+	vfs.plugin.asksMu.Lock()
+	defer vfs.plugin.asksMu.Unlock()
+	return []byte(formatAskAnswer(vfs.plugin.asks[namespace])), nil
+}
+
+// writeAskQuestion answers a question from the best answer_top_k chunks of
+// a namespace. Questions accept the same modifiers as grep.
+func (vfs *vectorFS) writeAskQuestion(namespace string, data []byte) error {
+	p := vfs.plugin
+	if p.answerer == nil {
+		return filesystem.NewInvalidArgumentError("ask", namespace, "answer_provider is not configured")
+	}
+	question := strings.TrimSpace(string(data))
+	if question == "" {
+		return filesystem.NewInvalidArgumentError("question", "", "empty question")
+	}
+	if err := vfs.requireNamespace("ask", namespace); err != nil {
+		return err
+	}
+	text, _, err := parseSearchModifiers(question)
+	if err != nil {
+		return err
+	}
+
+	results, err := vfs.VectorSearch(namespace, question, p.answer.TopK)
+	if err != nil {
+		return err
+	}
+	answer := &askAnswer{Question: strings.TrimSpace(text), Answer: noSourcesAnswer, Sources: results, AskedAt: time.Now()}
+	if len(results) > 0 {
+		sources := make([]string, len(results))
+		for i, r := range results {
+			sources[i] = fmt.Sprintf("(%s)\n%s", r.File, r.Content)
+		}
+		if answer.Answer, err = p.answerer.Answer(answer.Question, sources); err != nil {
+			return fmt.Errorf("failed to answer question: %w", err)
+		}
+	}
+	log.Debugf("[vectorfs] Answered question in %s from %d chunk(s)", namespace, len(results))
+
+	p.asksMu.Lock()
+	defer p.asksMu.Unlock()
+	p.asks[namespace] = answer
+	return nil
+}
+
+// forgetAskAnswer drops the last answer of a removed namespace
+func (v *VectorFSPlugin) forgetAskAnswer(namespace string) {
+	v.asksMu.Lock()
+	defer v.asksMu.Unlock()
+	delete(v.asks, namespace)
+}
@@ -0,0 +1,347 @@
+package vectorfs
+
+import (
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Chunking strategies accepted by the "chunk_strategy" config key
+const (
+	// ChunkStrategyParagraph splits on blank lines, cutting long paragraphs
+	// by sentences (the default)
+	ChunkStrategyParagraph = "paragraph"
+	// ChunkStrategySentence packs sentences into chunks regardless of
+	// paragraph breaks
+	ChunkStrategySentence = "sentence"
+	// ChunkStrategyMarkdown splits on headings and prefixes each chunk with
+	// the headings it is under
+	ChunkStrategyMarkdown = "markdown"
+	// ChunkStrategyCode splits source code on top-level declarations
+	ChunkStrategyCode = "code"
+	// ChunkStrategyAuto picks markdown or code by file extension and
+	// paragraph otherwise
+	ChunkStrategyAuto = "auto"
+)
+
+// chunkStrategies lists the valid chunk_strategy values
+var chunkStrategies = []string{ChunkStrategyParagraph, ChunkStrategySentence, ChunkStrategyMarkdown, ChunkStrategyCode, ChunkStrategyAuto}
+
+func isChunkStrategy(s string) bool {
+	for _, strategy := range chunkStrategies {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
+}
+
+// markdownExtensions are chunked with the markdown strategy in auto mode
+var markdownExtensions = map[string]bool{".md": true, ".markdown": true, ".mdx": true}
+
+// codeExtensions are chunked with the code strategy in auto mode
+var codeExtensions = map[string]bool{
+	".go": true, ".py": true, ".js": true, ".jsx": true, ".ts": true, ".tsx": true, ".mjs": true,
+	".java": true, ".kt": true, ".scala": true, ".c": true, ".h": true, ".cc": true, ".cpp": true,
+	".hpp": true, ".cs": true, ".rs": true, ".rb": true, ".php": true, ".swift": true, ".sh": true,
+	".lua": true, ".pl": true, ".r": true, ".sql": true,
+}
+
+// strategyFor returns the strategy chunking fileName
+func (cfg ChunkerConfig) strategyFor(fileName string) string {
+	switch cfg.Strategy {
+	case "":
+		return ChunkStrategyParagraph
+	case ChunkStrategyAuto:
+		ext := strings.ToLower(filepath.Ext(fileName))
+		switch {
+		case markdownExtensions[ext]:
+			return ChunkStrategyMarkdown
+		case codeExtensions[ext]:
+			return ChunkStrategyCode
+		}
+		return ChunkStrategyParagraph
+	}
+	return cfg.Strategy
+}
+
+// chunkSentences packs the sentences of text into chunks, ignoring
+// paragraph breaks
+func chunkSentences(text string, cfg ChunkerConfig) []string {
+	return splitLongText(strings.Join(splitParagraphs(text), cfg.sentenceSeparator()), cfg)
+}
+
+// ============================================================================
+// Markdown
+// ============================================================================
+
+var (
+	mdHeadingLine = regexp.MustCompile(`^\s{0,3}(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdFenceLine   = regexp.MustCompile("^\\s{0,3}(```|~~~)")
+)
+
+// markdownSection is the text under a heading, up to the next heading
+type markdownSection struct {
+	headings []string // Enclosing headings, outermost first
+	body     string
+}
+
+// splitMarkdownSections splits a Markdown document on its ATX headings.
+// Headings inside fenced code blocks don't count.
+func splitMarkdownSections(text string) []markdownSection {
+	var sections []markdownSection
+	var path []string // Heading text per level
+	var body strings.Builder
+	inFence := false
+
+	flush := func() {
+		var headings []string
+		for _, h := range path {
+			if h != "" {
+				headings = append(headings, h)
+			}
+		}
+		sections = append(sections, markdownSection{headings: headings, body: body.String()})
+		body.Reset()
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if mdFenceLine.MatchString(line) {
+			inFence = !inFence
+		}
+		if m := mdHeadingLine.FindStringSubmatch(line); m != nil && !inFence {
+			flush()
+			level := len(m[1])
+			for len(path) < level {
+				path = append(path, "")
+			}
+			path = append(path[:level-1], m[2])
+			continue
+		}
+		body.WriteString(line)
+		body.WriteString("\n")
+	}
+	flush()
+	return sections
+}
+
+// chunkMarkdown splits a Markdown document into sections and chunks each
+// on its own. Every chunk starts with its heading path ("Guide > Install")
+// so that it is found by searches for what the section is about.
+func chunkMarkdown(text string, cfg ChunkerConfig) []string {
+	tokenizer := cfg.tokenizer()
+	var chunks []string
+	for _, section := range splitMarkdownSections(text) {
+		body, _ := markdownExtractor{}.Extract([]byte(section.body))
+		if body == "" {
+			continue
+		}
+		prefix := strings.Join(section.headings, " > ")
+		if prefix != "" {
+			prefix += "\n\n"
+		}
+
+		// The heading path must leave room for the body
+		bodyCfg := cfg
+		bodyCfg.ChunkSize = cfg.ChunkSize - tokenizer.Count(prefix)
+		if bodyCfg.ChunkSize < cfg.ChunkSize/2 {
+			prefix = ""
+			bodyCfg.ChunkSize = cfg.ChunkSize
+		}
+
+		var pieces []string
+		if tokenizer.Count(body) <= bodyCfg.ChunkSize {
+			pieces = []string{body}
+		} else {
+			pieces = packParagraphs(body, bodyCfg)
+		}
+		for _, piece := range pieces {
+			chunks = append(chunks, prefix+piece)
+		}
+	}
+	return chunks
+}
+
+// packParagraphs packs consecutive paragraphs into chunks, splitting the
+// paragraphs that don't fit in a chunk by sentences
+func packParagraphs(text string, cfg ChunkerConfig) []string {
+	tokenizer := cfg.tokenizer()
+	var chunks []string
+	current := ""
+	for _, para := range splitParagraphs(text) {
+		if tokenizer.Count(para) > cfg.ChunkSize {
+			if current != "" {
+				chunks = append(chunks, current)
+				current = ""
+			}
+			chunks = append(chunks, splitLongText(para, cfg)...)
+			continue
+		}
+		candidate := para
+		if current != "" {
+			candidate = current + "\n\n" + para
+		}
+		if tokenizer.Count(candidate) <= cfg.ChunkSize {
+			current = candidate
+			continue
+		}
+		chunks = append(chunks, current)
+		current = para
+	}
+	if current != "" {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// ============================================================================
+// Code
+// ============================================================================
+
+// codeDeclaration matches the unindented first line of a declaration in
+// common languages: functions, classes, types and their modifiers
+var codeDeclaration = regexp.MustCompile(`^(export\s+)?(default\s+)?(pub(\([a-z]+\))?\s+)?(async\s+)?((public|private|protected|internal|static|abstract|final|open|override|inline|extern\s+"C")\s+)*` +
+	`(func|def|class|fn|impl|trait|struct|enum|interface|type|function|module|object|record|namespace|template|sub|CREATE)\b`)
+
+// codeDecorator matches lines attached to the declaration that follows,
+// such as comments, decorators and attributes
+var codeDecorator = regexp.MustCompile(`^(//|#|/\*|\*|@|--|"""|''')`)
+
+func isCodeDecorator(line string) bool {
+	line = strings.TrimSpace(line)
+	return line != "" && codeDecorator.MatchString(line)
+}
+
+// splitCodeBlocks splits source code into top-level blocks, each starting
+// at a declaration together with its comments and decorators. Go source is
+// split with go/parser; other languages by unindented declaration keywords,
+// which needs no grammar per language but misses declarations that are
+// indented or start with an unlisted keyword.
+func splitCodeBlocks(fileName, text string) []string {
+	lines := strings.Split(text, "\n")
+	var starts []int
+	if strings.EqualFold(filepath.Ext(fileName), ".go") {
+		starts = goDeclarationLines(text)
+	}
+	if starts == nil {
+		for i, line := range lines {
+			if codeDeclaration.MatchString(line) {
+				starts = append(starts, i)
+			}
+		}
+	}
+
+	// Move each start up over the comments and decorators above it, but
+	// not into the previous declaration
+	var boundaries []int
+	floor := 0
+	for _, start := range starts {
+		first := start
+		for first > floor && isCodeDecorator(lines[first-1]) {
+			first--
+		}
+		if len(boundaries) == 0 || first > boundaries[len(boundaries)-1] {
+			boundaries = append(boundaries, first)
+		}
+		floor = start + 1
+	}
+
+	var blocks []string
+	begin := 0
+	for _, b := range boundaries {
+		if b > begin {
+			blocks = append(blocks, strings.Join(lines[begin:b], "\n"))
+		}
+		begin = b
+	}
+	blocks = append(blocks, strings.Join(lines[begin:], "\n"))
+
+	var result []string
+	for _, block := range blocks {
+		if strings.TrimSpace(block) != "" {
+			result = append(result, strings.Trim(block, "\n"))
+		}
+	}
+	return result
+}
+
+// goDeclarationLines returns the 0-based first lines of the top-level
+// declarations of Go source, or nil if it doesn't parse
+func goDeclarationLines(src string) []int {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+	var lines []int
+	for _, decl := range file.Decls {
+		lines = append(lines, fset.Position(decl.Pos()).Line-1)
+	}
+	return lines
+}
+
+// chunkCode packs consecutive top-level blocks of source code into chunks.
+// Blocks larger than a chunk are cut between lines.
+func chunkCode(fileName, text string, cfg ChunkerConfig) []string {
+	tokenizer := cfg.tokenizer()
+	var chunks []string
+	current := ""
+	for _, block := range splitCodeBlocks(fileName, text) {
+		if tokenizer.Count(block) > cfg.ChunkSize {
+			if current != "" {
+				chunks = append(chunks, current)
+				current = ""
+			}
+			chunks = append(chunks, splitLines(block, cfg)...)
+			continue
+		}
+		candidate := block
+		if current != "" {
+			candidate = current + "\n\n" + block
+		}
+		if tokenizer.Count(candidate) <= cfg.ChunkSize {
+			current = candidate
+			continue
+		}
+		chunks = append(chunks, current)
+		current = block
+	}
+	if current != "" {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// splitLines packs the lines of text into chunks of at most cfg.ChunkSize
+// tokens, cutting lines that are longer by tokens
+func splitLines(text string, cfg ChunkerConfig) []string {
+	tokenizer := cfg.tokenizer()
+	var chunks []string
+	current := ""
+	for _, line := range strings.Split(text, "\n") {
+		if tokenizer.Count(line) > cfg.ChunkSize {
+			if current != "" {
+				chunks = append(chunks, current)
+				current = ""
+			}
+			chunks = append(chunks, tokenizer.Split(line, cfg.ChunkSize)...)
+			continue
+		}
+		candidate := line
+		if current != "" {
+			candidate = current + "\n" + line
+		}
+		if tokenizer.Count(candidate) <= cfg.ChunkSize {
+			current = candidate
+			continue
+		}
+		chunks = append(chunks, current)
+		current = line
+	}
+	if strings.TrimSpace(current) != "" {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
@@ -7,8 +7,27 @@ import (
 
 // ChunkerConfig holds chunking configuration
 type ChunkerConfig struct {
-	ChunkSize    int // Approximate chunk size in tokens
-	ChunkOverlap int // Overlap between chunks in tokens
+	ChunkSize    int       // Maximum chunk size in tokens
+	ChunkOverlap int       // Overlap between consecutive chunks of a long paragraph, in tokens
+	Tokenizer    Tokenizer // Token counter (nil = approximate, 1 token ≈ 4 bytes)
+	Strategy     string    // How documents are split, a ChunkStrategy* value ("" = paragraph)
+	Language     string    // Language of the document, as detected by detectLanguage ("" = unknown)
+}
+
+// tokenizer returns the configured tokenizer or the approximate default
+func (cfg ChunkerConfig) tokenizer() Tokenizer {
+	if cfg.Tokenizer == nil {
+		return approxTokenizer{}
+	}
+	return cfg.Tokenizer
+}
+
+// sentenceSeparator returns what joins the sentences of a chunk
+func (cfg ChunkerConfig) sentenceSeparator() string {
+	if unspacedLanguages[cfg.Language] {
+		return ""
+	}
+	return " "
 }
 
 // Chunk represents a text chunk
@@ -17,39 +36,33 @@ type Chunk struct {
 	Index int
 }
 
-// ChunkDocument splits a document into chunks
+// ChunkDocument splits a document into chunks with the configured strategy.
+// Use ChunkFile when the strategy may depend on the file type.
 func ChunkDocument(text string, cfg ChunkerConfig) []Chunk {
-	// Simple chunking strategy:
-	// 1. Split by paragraphs (double newline)
-	// 2. If paragraph is too long, split by sentences
-	// 3. If sentence is too long, split by words
-
-	paragraphs := splitParagraphs(text)
-	var chunks []Chunk
-	chunkIndex := 0
+	return ChunkFile("", text, cfg)
+}
 
-	for _, para := range paragraphs {
-		// Estimate tokens (rough approximation: 1 token ≈ 4 characters)
-		estimatedTokens := len(para) / 4
+// ChunkFile splits the document fileName into chunks with the strategy
+// configured for its type (see ChunkStrategyAuto)
+func ChunkFile(fileName, text string, cfg ChunkerConfig) []Chunk {
+	var texts []string
+	switch cfg.strategyFor(fileName) {
+	case ChunkStrategySentence:
+		texts = chunkSentences(text, cfg)
+	case ChunkStrategyMarkdown:
+		texts = chunkMarkdown(text, cfg)
+	case ChunkStrategyCode:
+		texts = chunkCode(fileName, text, cfg)
+	default:
+		texts = chunkParagraphs(text, cfg)
+	}
 
-		if estimatedTokens <= cfg.ChunkSize {
-			// Paragraph fits in one chunk
-			chunks = append(chunks, Chunk{
-				Text:  para,
-				Index: chunkIndex,
-			})
-			chunkIndex++
-		} else {
-			// Split paragraph into smaller chunks
-			subChunks := splitLongText(para, cfg.ChunkSize)
-			for _, subChunk := range subChunks {
-				chunks = append(chunks, Chunk{
-					Text:  subChunk,
-					Index: chunkIndex,
-				})
-				chunkIndex++
-			}
-		}
+	var chunks []Chunk
+	for i, t := range texts {
+		chunks = append(chunks, Chunk{
+			Text:  t,
+			Index: i,
+		})
 	}
 
 	// If no chunks were created (empty document), create one empty chunk
@@ -63,6 +76,25 @@ func ChunkDocument(text string, cfg ChunkerConfig) []Chunk {
 	return chunks
 }
 
+// chunkParagraphs implements ChunkStrategyParagraph:
+// 1. Split by paragraphs (double newline)
+// 2. If paragraph is too long, split by sentences
+// 3. If sentence is too long, split by words
+func chunkParagraphs(text string, cfg ChunkerConfig) []string {
+	tokenizer := cfg.tokenizer()
+	var chunks []string
+	for _, para := range splitParagraphs(text) {
+		if tokenizer.Count(para) <= cfg.ChunkSize {
+			// Paragraph fits in one chunk
+			chunks = append(chunks, para)
+		} else {
+			// Split paragraph into smaller chunks
+			chunks = append(chunks, splitLongText(para, cfg)...)
+		}
+	}
+	return chunks
+}
+
 // splitParagraphs splits text by paragraphs (double newline or single newline)
 func splitParagraphs(text string) []string {
 	// First try double newline
@@ -96,46 +128,69 @@ func splitParagraphs(text string) []string {
 	return result
 }
 
-// splitLongText splits long text into chunks of approximately chunkSize tokens
-func splitLongText(text string, chunkSize int) []string {
-	// Split by sentences first
-	sentences := splitSentences(text)
+// splitLongText splits long text into chunks of at most cfg.ChunkSize tokens.
+// Consecutive chunks share up to cfg.ChunkOverlap tokens.
+func splitLongText(text string, cfg ChunkerConfig) []string {
+	tokenizer := cfg.tokenizer()
+	chunkSize := cfg.ChunkSize
+	overlap := cfg.ChunkOverlap
+	if overlap < 0 || overlap >= chunkSize {
+		overlap = 0
+	}
+
+	// Split by sentences first; sentences that alone exceed the chunk size
+	// are cut by tokens so providers never truncate them silently
+	var sentences []string
+	for _, sentence := range splitSentences(text, unspacedLanguages[cfg.Language]) {
+		if tokenizer.Count(sentence) > chunkSize {
+			sentences = append(sentences, tokenizer.Split(sentence, chunkSize)...)
+		} else {
+			sentences = append(sentences, sentence)
+		}
+	}
 
 	var chunks []string
-	var currentChunk strings.Builder
-	currentTokens := 0
+	current := ""
+	separator := cfg.sentenceSeparator()
 
 	for _, sentence := range sentences {
-		sentenceTokens := len(sentence) / 4 // Rough estimate
+		candidate := sentence
+		if current != "" {
+			candidate = current + separator + sentence
+		}
 
-		if currentTokens+sentenceTokens <= chunkSize {
+		if tokenizer.Count(candidate) <= chunkSize {
 			// Add to current chunk
-			if currentChunk.Len() > 0 {
-				currentChunk.WriteString(" ")
-			}
-			currentChunk.WriteString(sentence)
-			currentTokens += sentenceTokens
-		} else {
-			// Start new chunk
-			if currentChunk.Len() > 0 {
-				chunks = append(chunks, currentChunk.String())
+			current = candidate
+			continue
+		}
+
+		// Start new chunk, seeded with the tail of the previous one
+		if current != "" {
+			chunks = append(chunks, current)
+		}
+		next := sentence
+		if overlap > 0 && current != "" {
+			if seed := tokenizer.Tail(current, overlap); seed != "" {
+				if seeded := seed + separator + sentence; tokenizer.Count(seeded) <= chunkSize {
+					next = seeded
+				}
 			}
-			currentChunk.Reset()
-			currentChunk.WriteString(sentence)
-			currentTokens = sentenceTokens
 		}
+		current = next
 	}
 
 	// Add remaining chunk
-	if currentChunk.Len() > 0 {
-		chunks = append(chunks, currentChunk.String())
+	if current != "" {
+		chunks = append(chunks, current)
 	}
 
 	return chunks
 }
 
-// splitSentences splits text into sentences
-func splitSentences(text string) []string {
+// splitSentences splits text into sentences. In unspaced languages a
+// full-width terminator ends a sentence even without whitespace after it.
+func splitSentences(text string, unspaced bool) []string {
 	var sentences []string
 	var currentSentence strings.Builder
 
@@ -144,7 +199,7 @@ func splitSentences(text string) []string {
 		currentSentence.WriteRune(runes[i])
 
 		// Check for sentence ending
-		if isSentenceEnd(runes, i) {
+		if isSentenceEnd(runes, i, unspaced) {
 			sentence := strings.TrimSpace(currentSentence.String())
 			if sentence != "" {
 				sentences = append(sentences, sentence)
@@ -165,12 +220,15 @@ func splitSentences(text string) []string {
 }
 
 // isSentenceEnd checks if current position is a sentence ending
-func isSentenceEnd(runes []rune, i int) bool {
+func isSentenceEnd(runes []rune, i int, unspaced bool) bool {
 	if i >= len(runes) {
 		return false
 	}
 
 	r := runes[i]
+	if unspaced && (r == '。' || r == '！' || r == '？') {
+		return true
+	}
 
 	// Check for common sentence endings
 	if r == '.' || r == '!' || r == '?' || r == '。' || r == '！' || r == '？' {
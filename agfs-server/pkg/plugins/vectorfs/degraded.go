@@ -0,0 +1,145 @@
+package vectorfs
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// modeIndexingPaused is the degraded mode of vectorfs while its embedding
+// providers are down: documents are still written and read, but their
+// indexing waits until a provider answers again
+const modeIndexingPaused = "indexing_paused"
+
+// Delay before probing a provider again after an outage, doubling with
+// each failed probe
+const (
+	indexPauseMinBackoff = 5 * time.Second
+	indexPauseMaxBackoff = 5 * time.Minute
+)
+
+// errEmbeddingFailed wraps the errors of embedding providers while indexing
+var errEmbeddingFailed = errors.New("failed to generate embeddings")
+
+// errIndexingPaused is returned to writers waiting on a document whose
+// indexing waits for the embedding providers to come back
+var errIndexingPaused = errors.New("indexing paused: embedding provider unavailable")
+
+// isEmbeddingOutage reports whether an indexing error means the embedding
+// providers are down, rather than the document can't be embedded
+func isEmbeddingOutage(err error) bool {
+	return errors.Is(err, errEmbeddingFailed) && isProviderFailure(err)
+}
+
+// indexPause pauses the index workers while the embedding providers are
+// down. Once the backoff is over, one worker probes the providers with its
+// document while the others keep waiting.
+type indexPause struct {
+	mu      sync.Mutex
+	health  *filesystem.HealthState
+	paused  bool
+	retryAt time.Time
+	backoff time.Duration
+	probing bool
+	resumed chan struct{} // Closed when indexing resumes
+}
+
+// state returns the health state, creating it on first use. The caller
+// holds mu.
+func (p *indexPause) state() *filesystem.HealthState {
+	if p.health == nil {
+		p.health = filesystem.NewHealthState(modeIndexingPaused)
+	}
+	return p.health
+}
+
+// Health returns the mode of the index workers
+func (p *indexPause) Health() filesystem.Health {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state().Health()
+}
+
+// isPaused reports whether indexing is paused, and until when
+func (p *indexPause) isPaused() (bool, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused, p.retryAt
+}
+
+// pause pauses indexing after an outage. A failed probe doubles the
+// backoff; failures of documents that were already being embedded when the
+// outage began don't.
+func (p *indexPause) pause(err error, probe bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case !p.paused:
+		p.paused = true
+		p.backoff = indexPauseMinBackoff
+		p.resumed = make(chan struct{})
+		log.Warnf("[vectorfs] Embedding provider unavailable, pausing indexing: %v", err)
+	case probe:
+		p.backoff = min(p.backoff*2, indexPauseMaxBackoff)
+		log.Warnf("[vectorfs] Embedding provider still unavailable, retrying in %v: %v", p.backoff, err)
+	default:
+		return
+	}
+	p.probing = false
+	p.retryAt = time.Now().Add(p.backoff)
+	p.state().Enter(modeIndexingPaused, err.Error())
+}
+
+// resume resumes indexing once a provider answered
+func (p *indexPause) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.paused {
+		return
+	}
+	p.paused = false
+	p.probing = false
+	close(p.resumed)
+	p.state().Recover()
+	log.Infof("[vectorfs] Embedding provider is back, resuming indexing")
+}
+
+// await blocks while indexing is paused. It reports whether the caller
+// should probe the providers, and false for ok when shutdown is closed
+// first.
+func (p *indexPause) await(shutdown <-chan struct{}) (probe, ok bool) {
+	for {
+		p.mu.Lock()
+		if !p.paused {
+			p.mu.Unlock()
+			return false, true
+		}
+		wait := time.Until(p.retryAt)
+		if wait <= 0 && !p.probing {
+			p.probing = true
+			p.mu.Unlock()
+			return true, true
+		}
+		resumed := p.resumed
+		p.mu.Unlock()
+
+		// A failed probe moves retryAt, so look again now and then
+		if wait <= 0 {
+			wait = indexPauseMinBackoff
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-resumed:
+		case <-timer.C:
+		case <-shutdown:
+			timer.Stop()
+			return false, false
+		}
+		timer.Stop()
+	}
+}
@@ -0,0 +1,188 @@
+package vectorfs
+
+import (
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+// defaultDedupThreshold collapses the chunks of a file nearly identical to
+// a better one; maximal marginal relevance (MMR) is off by default
+const defaultDedupThreshold = 0.9
+
+// diversityCandidateFactor is how many extra candidates are fetched when
+// results are diversified, so that there is something to pick from
+const diversityCandidateFactor = 3
+
+// diversity is how the results of a search are diversified
+type diversity struct {
+	// lambda trades relevance (towards 1) for novelty (towards 0) in MMR
+	// selection; 0 or 1 keeps the relevance order
+	lambda float64
+	// threshold is the content similarity from which a chunk duplicates a
+	// better chunk of the same file; 0 keeps duplicates
+	threshold float64
+}
+
+func (d diversity) mmr() bool {
+	return d.lambda > 0 && d.lambda < 1
+}
+
+func (d diversity) enabled() bool {
+	return d.mmr() || d.threshold > 0
+}
+
+// candidateLimit returns how many candidates a search fetches to return
+// limit results
+func (d diversity) candidateLimit(limit int) int {
+	if d.enabled() {
+		return limit * diversityCandidateFactor
+	}
+	return limit
+}
+
+// apply collapses near-duplicate chunks and picks limit results by MMR from
+// candidates ordered best first. A result that absorbed duplicates counts
+// them in its "duplicates" metadata.
+func (d diversity) apply(candidates []mountablefs.CustomGrepResult, limit int) []mountablefs.CustomGrepResult {
+	terms := make([]termVector, len(candidates))
+	for i, c := range candidates {
+		terms[i] = newTermVector(c.Content)
+	}
+
+	// Collapse duplicates into the best chunk of their file
+	var kept []int
+	if d.threshold > 0 {
+		for i, c := range candidates {
+			dup := -1
+			for _, k := range kept {
+				if candidates[k].File == c.File && terms[k].cosine(terms[i]) >= d.threshold {
+					dup = k
+					break
+				}
+			}
+			if dup < 0 {
+				kept = append(kept, i)
+				continue
+			}
+			if candidates[dup].Metadata == nil {
+				candidates[dup].Metadata = make(map[string]interface{})
+			}
+			n, _ := candidates[dup].Metadata["duplicates"].(int)
+			candidates[dup].Metadata["duplicates"] = n + 1
+		}
+	} else {
+		for i := range candidates {
+			kept = append(kept, i)
+		}
+	}
+
+	if limit <= 0 || limit > len(kept) {
+		limit = len(kept)
+	}
+	selected := kept
+	if d.mmr() {
+		selected = d.selectMMR(candidates, terms, kept, limit)
+	}
+	if len(selected) > limit {
+		selected = selected[:limit]
+	}
+
+	results := make([]mountablefs.CustomGrepResult, len(selected))
+	for i, k := range selected {
+		results[i] = candidates[k]
+	}
+	return results
+}
+
+// selectMMR greedily picks limit of the kept candidates, each time the one
+// with the best lambda*relevance - (1-lambda)*similarity to those picked.
+// Relevance is the result score scaled to [0, 1] over the candidates, so
+// that cosine, fused and rerank scores weigh the same.
+func (d diversity) selectMMR(candidates []mountablefs.CustomGrepResult, terms []termVector, kept []int, limit int) []int {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, k := range kept {
+		s := resultScore(candidates[k])
+		lo, hi = math.Min(lo, s), math.Max(hi, s)
+	}
+	relevance := func(k int) float64 {
+		if hi == lo {
+			return 1
+		}
+		return (resultScore(candidates[k]) - lo) / (hi - lo)
+	}
+
+	remaining := append([]int(nil), kept...)
+	maxSim := make(map[int]float64, len(kept))
+	var selected []int
+	for len(selected) < limit && len(remaining) > 0 {
+		best, bestValue := 0, math.Inf(-1)
+		for i, k := range remaining {
+			value := d.lambda*relevance(k) - (1-d.lambda)*maxSim[k]
+			if value > bestValue {
+				best, bestValue = i, value
+			}
+		}
+		pick := remaining[best]
+		selected = append(selected, pick)
+		remaining = append(remaining[:best], remaining[best+1:]...)
+		for _, k := range remaining {
+			maxSim[k] = math.Max(maxSim[k], terms[k].cosine(terms[pick]))
+		}
+	}
+	return selected
+}
+
+// termVector counts the words of a text, for content similarity. Letters
+// of scripts written without spaces count as words of their own.
+type termVector struct {
+	counts map[string]float64
+	norm   float64
+}
+
+func newTermVector(text string) termVector {
+	tv := termVector{counts: make(map[string]float64)}
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tv.counts[word.String()]++
+			word.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai):
+			flush()
+			tv.counts[string(r)]++
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	for _, c := range tv.counts {
+		tv.norm += c * c
+	}
+	tv.norm = math.Sqrt(tv.norm)
+	return tv
+}
+
+// cosine returns the cosine similarity of two term vectors, 0 if either is
+// empty
+func (tv termVector) cosine(other termVector) float64 {
+	if tv.norm == 0 || other.norm == 0 {
+		return 0
+	}
+	small, large := tv.counts, other.counts
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+	var dot float64
+	for w, c := range small {
+		dot += c * large[w]
+	}
+	return dot / (tv.norm * other.norm)
+}
@@ -0,0 +1,166 @@
+package vectorfs
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// embeddingCacheTable holds the embedding cache of a vector store, shared by
+// all its namespaces
+const embeddingCacheTable = "tbl_embedding_cache"
+
+// embeddingCacheBatch bounds the keys looked up by one query
+const embeddingCacheBatch = 500
+
+// embeddingCache stores embeddings by embeddingCacheKey. Vector stores
+// implement it.
+type embeddingCache interface {
+	// GetCachedEmbeddings returns the cached embeddings of keys. Keys
+	// without an entry are absent from the result.
+	GetCachedEmbeddings(keys []string) (map[string][]float32, error)
+	// PutCachedEmbeddings caches embeddings, keeping existing entries
+	PutCachedEmbeddings(entries map[string][]float32) error
+}
+
+// embeddingCacheKey identifies the embedding of text by a model: the hex
+// SHA256 of the model name and the text
+func embeddingCacheKey(model, text string) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write([]byte(text))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachingEmbedder consults an embedding cache before calling its backend
+// for the chunks of a document, so that rewriting a mostly unchanged
+// document only embeds the chunks that changed. Query embeddings are not
+// cached. Cache failures are logged and fall back to the backend.
+type cachingEmbedder struct {
+	backend embeddingBackend
+	model   string
+	cache   embeddingCache
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func newCachingEmbedder(backend embeddingBackend, model string, cache embeddingCache) *cachingEmbedder {
+	return &cachingEmbedder{backend: backend, model: model, cache: cache}
+}
+
+func (e *cachingEmbedder) GetDimension() int {
+	return e.backend.GetDimension()
+}
+
+func (e *cachingEmbedder) GenerateEmbedding(text string) ([]float32, error) {
+	return e.backend.GenerateEmbedding(text)
+}
+
+// GenerateBatchEmbeddings returns cached embeddings and embeds the rest of
+// texts in one backend call
+func (e *cachingEmbedder) GenerateBatchEmbeddings(texts []string) ([][]float32, error) {
+	keys := make([]string, len(texts))
+	for i, text := range texts {
+		keys[i] = embeddingCacheKey(e.model, text)
+	}
+	cached, err := e.cache.GetCachedEmbeddings(keys)
+	if err != nil {
+		log.Warnf("[vectorfs/embedding] Failed to read embedding cache: %v", err)
+		cached = nil
+	}
+
+	dim := e.backend.GetDimension()
+	result := make([][]float32, len(texts))
+	var missing []string
+	var missingAt []int
+	seen := make(map[string]int) // Key -> index in missing, for repeated chunks
+	for i, key := range keys {
+		if embedding, ok := cached[key]; ok && len(embedding) == dim {
+			result[i] = embedding
+			continue
+		}
+		if _, ok := seen[key]; !ok {
+			seen[key] = len(missing)
+			missing = append(missing, texts[i])
+		}
+		missingAt = append(missingAt, i)
+	}
+	e.hits.Add(int64(len(texts) - len(missingAt)))
+	e.misses.Add(int64(len(missing)))
+	if len(missing) == 0 {
+		log.Debugf("[vectorfs/embedding] All %d embeddings cached", len(texts))
+		return result, nil
+	}
+
+	embeddings, err := e.backend.GenerateBatchEmbeddings(missing)
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) != len(missing) {
+		return nil, fmt.Errorf("embedding provider returned %d embeddings for %d texts", len(embeddings), len(missing))
+	}
+	fresh := make(map[string][]float32, len(missing))
+	for _, i := range missingAt {
+		result[i] = embeddings[seen[keys[i]]]
+		fresh[keys[i]] = result[i]
+	}
+	if err := e.cache.PutCachedEmbeddings(fresh); err != nil {
+		log.Warnf("[vectorfs/embedding] Failed to write embedding cache: %v", err)
+	}
+	log.Debugf("[vectorfs/embedding] Reused %d of %d embeddings", len(texts)-len(missingAt), len(texts))
+	return result, nil
+}
+
+// cacheStats sums the embedding cache hits and misses of the providers of
+// r. ok is false if they don't use a cache.
+func (r *EmbeddingRouter) cacheStats() (hits, misses int64, ok bool) {
+	if r == nil {
+		return 0, 0, false
+	}
+	for _, b := range []embeddingBackend{r.primary, r.fallback, r.candidate} {
+		if c, isCaching := b.(*cachingEmbedder); isCaching {
+			hits += c.hits.Load()
+			misses += c.misses.Load()
+			ok = true
+		}
+	}
+	return hits, misses, ok
+}
+
+// chunkKeys splits keys into batches of at most embeddingCacheBatch
+func chunkKeys(keys []string) [][]string {
+	var batches [][]string
+	for len(keys) > embeddingCacheBatch {
+		batches = append(batches, keys[:embeddingCacheBatch])
+		keys = keys[embeddingCacheBatch:]
+	}
+	if len(keys) > 0 {
+		batches = append(batches, keys)
+	}
+	return batches
+}
+
+// scanCachedEmbeddings adds rows of cache keys and encoded embeddings to
+// cached, and closes rows
+func scanCachedEmbeddings(rows *sql.Rows, cached map[string][]float32) error {
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var blob []byte
+		if err := rows.Scan(&key, &blob); err != nil {
+			return err
+		}
+		embedding, err := decodeEmbedding(blob)
+		if err != nil {
+			continue
+		}
+		cached[key] = embedding
+	}
+	return rows.Err()
+}
@@ -0,0 +1,241 @@
+package vectorfs
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ============================================================================
+// Azure OpenAI
+// ============================================================================
+
+const (
+	// azureOpenAIAPIVersion is the API version of deployment URLs built from
+	// a resource endpoint
+	azureOpenAIAPIVersion = "2024-10-21"
+	azureOpenAIMaxBatch   = 2048
+)
+
+// azureOpenAIProvider calls an embeddings deployment of an Azure OpenAI
+// resource. It speaks the OpenAI API but authenticates with an api-key
+// header, and the model is the deployment named in the URL.
+type azureOpenAIProvider struct {
+	apiKey   string
+	endpoint string
+	client   *http.Client
+}
+
+// newAzureOpenAIProvider takes either the full URL of a deployment, or the
+// resource endpoint (https://<resource>.openai.azure.com) and the deployment
+// name as the model
+func newAzureOpenAIProvider(cfg EmbeddingConfig, client *http.Client) *azureOpenAIProvider {
+	endpoint := cfg.Endpoint
+	if !strings.Contains(endpoint, "/deployments/") {
+		endpoint = strings.TrimRight(endpoint, "/") + "/openai/deployments/" + url.PathEscape(cfg.Model) +
+			"/embeddings?api-version=" + azureOpenAIAPIVersion
+	}
+	return &azureOpenAIProvider{apiKey: cfg.APIKey, endpoint: endpoint, client: client}
+}
+
+func (p *azureOpenAIProvider) name() string { return "Azure OpenAI" }
+
+func (p *azureOpenAIProvider) maxBatch() int { return azureOpenAIMaxBatch }
+
+func (p *azureOpenAIProvider) embed(texts []string, _ embeddingInput) ([][]float32, error) {
+	var response openAIEmbeddingResponse
+	header := http.Header{"Api-Key": {p.apiKey}}
+	if err := postJSONWithHeader(p.client, p.endpoint, header, p.name(), openAIBatchEmbeddingRequest{Input: texts}, &response); err != nil {
+		return nil, err
+	}
+	log.Debugf("[vectorfs/embedding] Generated %d embeddings (tokens: %d)", len(texts), response.Usage.TotalTokens)
+	return response.embeddings(len(texts))
+}
+
+// ============================================================================
+// Google Gemini
+// ============================================================================
+
+const (
+	defaultGeminiEndpoint = "https://generativelanguage.googleapis.com/v1beta/models/"
+	// geminiMaxBatch is the most requests batchEmbedContents takes at once
+	geminiMaxBatch = 100
+)
+
+type geminiEmbedRequest struct {
+	Model   string `json:"model"`
+	Content struct {
+		Parts []geminiPart `json:"parts"`
+	} `json:"content"`
+	TaskType string `json:"taskType,omitempty"`
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiBatchEmbedRequest struct {
+	Requests []geminiEmbedRequest `json:"requests"`
+}
+
+type geminiBatchEmbedResponse struct {
+	Embeddings []struct {
+		Values []float32 `json:"values"`
+	} `json:"embeddings"`
+}
+
+// geminiProvider calls the batchEmbedContents method of the Gemini API,
+// authenticated with an x-goog-api-key header
+type geminiProvider struct {
+	apiKey   string
+	model    string
+	endpoint string
+	client   *http.Client
+}
+
+func newGeminiProvider(cfg EmbeddingConfig, client *http.Client) *geminiProvider {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaultGeminiEndpoint + url.PathEscape(cfg.Model) + ":batchEmbedContents"
+	}
+	return &geminiProvider{apiKey: cfg.APIKey, model: cfg.Model, endpoint: endpoint, client: client}
+}
+
+func (p *geminiProvider) name() string { return "Gemini" }
+
+func (p *geminiProvider) maxBatch() int { return geminiMaxBatch }
+
+func (p *geminiProvider) embed(texts []string, input embeddingInput) ([][]float32, error) {
+	taskType := "RETRIEVAL_DOCUMENT"
+	if input == inputQuery {
+		taskType = "RETRIEVAL_QUERY"
+	}
+	request := geminiBatchEmbedRequest{Requests: make([]geminiEmbedRequest, len(texts))}
+	for i, text := range texts {
+		request.Requests[i].Model = "models/" + p.model
+		request.Requests[i].Content.Parts = []geminiPart{{Text: text}}
+		request.Requests[i].TaskType = taskType
+	}
+
+	var response geminiBatchEmbedResponse
+	header := http.Header{}
+	if p.apiKey != "" {
+		header.Set("X-Goog-Api-Key", p.apiKey)
+	}
+	if err := postJSONWithHeader(p.client, p.endpoint, header, p.name(), request, &response); err != nil {
+		return nil, err
+	}
+	embeddings := make([][]float32, len(response.Embeddings))
+	for i, embedding := range response.Embeddings {
+		embeddings[i] = embedding.Values
+	}
+	log.Debugf("[vectorfs/embedding] Generated %d embeddings", len(embeddings))
+	return embeddings, nil
+}
+
+// ============================================================================
+// Voyage AI
+// ============================================================================
+
+const (
+	defaultVoyageEndpoint = "https://api.voyageai.com/v1/embeddings"
+	voyageMaxBatch        = 1000
+)
+
+type voyageEmbedRequest struct {
+	Input     []string `json:"input"`
+	Model     string   `json:"model"`
+	InputType string   `json:"input_type"`
+}
+
+// voyageProvider calls the Voyage AI embeddings API, which answers like
+// OpenAI and authenticates with a bearer token
+type voyageProvider struct {
+	apiKey   string
+	model    string
+	endpoint string
+	client   *http.Client
+}
+
+func newVoyageProvider(cfg EmbeddingConfig, client *http.Client) *voyageProvider {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaultVoyageEndpoint
+	}
+	return &voyageProvider{apiKey: cfg.APIKey, model: cfg.Model, endpoint: endpoint, client: client}
+}
+
+func (p *voyageProvider) name() string { return "Voyage" }
+
+func (p *voyageProvider) maxBatch() int { return voyageMaxBatch }
+
+func (p *voyageProvider) embed(texts []string, input embeddingInput) ([][]float32, error) {
+	request := voyageEmbedRequest{Input: texts, Model: p.model, InputType: "document"}
+	if input == inputQuery {
+		request.InputType = "query"
+	}
+	var response openAIEmbeddingResponse
+	if err := postJSON(p.client, p.endpoint, p.apiKey, p.name(), request, &response); err != nil {
+		return nil, err
+	}
+	log.Debugf("[vectorfs/embedding] Generated %d embeddings (tokens: %d)", len(texts), response.Usage.TotalTokens)
+	return response.embeddings(len(texts))
+}
+
+// ============================================================================
+// Cohere
+// ============================================================================
+
+const (
+	defaultCohereEmbedEndpoint = "https://api.cohere.com/v2/embed"
+	cohereMaxBatch             = 96
+)
+
+type cohereEmbedRequest struct {
+	Model          string   `json:"model"`
+	Texts          []string `json:"texts"`
+	InputType      string   `json:"input_type"`
+	EmbeddingTypes []string `json:"embedding_types"`
+}
+
+type cohereEmbedResponse struct {
+	Embeddings struct {
+		Float [][]float32 `json:"float"`
+	} `json:"embeddings"`
+}
+
+// cohereProvider calls the Cohere v2 embed API, authenticated with a bearer
+// token
+type cohereProvider struct {
+	apiKey   string
+	model    string
+	endpoint string
+	client   *http.Client
+}
+
+func newCohereProvider(cfg EmbeddingConfig, client *http.Client) *cohereProvider {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaultCohereEmbedEndpoint
+	}
+	return &cohereProvider{apiKey: cfg.APIKey, model: cfg.Model, endpoint: endpoint, client: client}
+}
+
+func (p *cohereProvider) name() string { return "Cohere" }
+
+func (p *cohereProvider) maxBatch() int { return cohereMaxBatch }
+
+func (p *cohereProvider) embed(texts []string, input embeddingInput) ([][]float32, error) {
+	request := cohereEmbedRequest{Model: p.model, Texts: texts, InputType: "search_document", EmbeddingTypes: []string{"float"}}
+	if input == inputQuery {
+		request.InputType = "search_query"
+	}
+	var response cohereEmbedResponse
+	if err := postJSON(p.client, p.endpoint, p.apiKey, p.name(), request, &response); err != nil {
+		return nil, err
+	}
+	log.Debugf("[vectorfs/embedding] Generated %d embeddings", len(response.Embeddings.Float))
+	return response.Embeddings.Float, nil
+}
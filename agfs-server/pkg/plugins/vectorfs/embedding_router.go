@@ -0,0 +1,281 @@
+package vectorfs
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// embeddingBackend is a single embedding provider. *EmbeddingClient implements it.
+type embeddingBackend interface {
+	GenerateEmbedding(text string) ([]float32, error)
+	GenerateBatchEmbeddings(texts []string) ([][]float32, error)
+	GetDimension() int
+}
+
+// Embedding routes
+const (
+	routePrimary   = "primary"
+	routeCandidate = "candidate"
+	// routeModelPrefix starts the route of namespaces with their own model
+	routeModelPrefix = "model:"
+)
+
+// EmbeddingRouterConfig controls failover and A/B routing
+type EmbeddingRouterConfig struct {
+	// FailoverCooldown is how long the primary is bypassed after it fails
+	FailoverCooldown time.Duration
+
+	// CandidatePercent is the share of namespaces (0-100) routed to the
+	// candidate provider. Routing is by namespace hash so a namespace always
+	// uses the same model for both indexing and queries.
+	CandidatePercent int
+}
+
+// EmbeddingRouter picks an embedding provider per request.
+//
+// Namespaces on the primary route fail over to the fallback provider while the
+// primary is unhealthy. The fallback must produce vectors compatible with the
+// primary (same model behind another endpoint), otherwise search quality
+// degrades for documents indexed during the outage. Namespaces on the
+// candidate route never fail over, so A/B results stay comparable.
+type EmbeddingRouter struct {
+	primary   embeddingBackend
+	fallback  embeddingBackend // Optional
+	candidate embeddingBackend // Optional
+	cfg       EmbeddingRouterConfig
+
+	mu             sync.Mutex
+	unhealthyUntil time.Time
+	now            func() time.Time
+
+	// Models of the routes above, for display
+	routeModels map[string]string
+
+	// Namespaces configured with a model of their own are embedded with a
+	// client of the primary's provider for that model, without failover
+	// or A/B routing
+	namespaceModel func(namespace string) (string, error) // "" = routed as above
+	newModelClient func(model string) (embeddingBackend, error)
+	modelClients   map[string]embeddingBackend
+	modelsMu       sync.Mutex
+
+	// Embedding calls made for each namespace, for .stats
+	usageMu sync.Mutex
+	usage   map[string]*embeddingUsage
+}
+
+// NewEmbeddingRouter creates a router. fallback and candidate may be nil.
+func NewEmbeddingRouter(primary, fallback, candidate embeddingBackend, cfg EmbeddingRouterConfig) (*EmbeddingRouter, error) {
+	if primary == nil {
+		return nil, fmt.Errorf("primary embedding provider is required")
+	}
+	for name, b := range map[string]embeddingBackend{"fallback": fallback, "candidate": candidate} {
+		if b != nil && b.GetDimension() != primary.GetDimension() {
+			return nil, fmt.Errorf("%s embedding dimension %d does not match primary dimension %d",
+				name, b.GetDimension(), primary.GetDimension())
+		}
+	}
+	if cfg.CandidatePercent < 0 || cfg.CandidatePercent > 100 {
+		return nil, fmt.Errorf("candidate_embedding_percent must be between 0 and 100")
+	}
+	if cfg.FailoverCooldown <= 0 {
+		cfg.FailoverCooldown = 30 * time.Second
+	}
+	return &EmbeddingRouter{
+		primary:   primary,
+		fallback:  fallback,
+		candidate: candidate,
+		cfg:       cfg,
+		now:       time.Now,
+	}, nil
+}
+
+// GetDimension returns the embedding dimension shared by all providers
+func (r *EmbeddingRouter) GetDimension() int {
+	return r.primary.GetDimension()
+}
+
+// Route returns which provider group serves a namespace
+func (r *EmbeddingRouter) Route(namespace string) string {
+	if model := r.modelOverride(namespace); model != "" {
+		return routeModelPrefix + model
+	}
+	if r.candidate == nil || r.cfg.CandidatePercent == 0 {
+		return routePrimary
+	}
+	h := fnv.New32a()
+	h.Write([]byte(namespace))
+	if int(h.Sum32()%100) < r.cfg.CandidatePercent {
+		return routeCandidate
+	}
+	return routePrimary
+}
+
+// GenerateEmbedding generates an embedding for text in namespace
+func (r *EmbeddingRouter) GenerateEmbedding(namespace, text string) ([]float32, error) {
+	var result []float32
+	err := r.do(namespace, func(b embeddingBackend) error {
+		var err error
+		result, err = b.GenerateEmbedding(text)
+		return err
+	})
+	r.recordUsage(namespace, func(u *embeddingUsage) {
+		u.Queries++
+		if err != nil {
+			u.Failures++
+		}
+	})
+	return result, err
+}
+
+// GenerateBatchEmbeddings generates embeddings for texts in namespace
+func (r *EmbeddingRouter) GenerateBatchEmbeddings(namespace string, texts []string) ([][]float32, error) {
+	var result [][]float32
+	err := r.do(namespace, func(b embeddingBackend) error {
+		var err error
+		result, err = b.GenerateBatchEmbeddings(texts)
+		return err
+	})
+	r.recordUsage(namespace, func(u *embeddingUsage) {
+		u.Batches++
+		u.Texts += int64(len(texts))
+		if err != nil {
+			u.Failures++
+		}
+	})
+	return result, err
+}
+
+// PrimaryHealthy reports whether the primary provider is currently in use
+func (r *EmbeddingRouter) PrimaryHealthy() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.now().Before(r.unhealthyUntil)
+}
+
+func (r *EmbeddingRouter) do(namespace string, call func(embeddingBackend) error) error {
+	if r.namespaceModel != nil {
+		model, err := r.namespaceModel(namespace)
+		if err != nil {
+			return fmt.Errorf("failed to read embedding model of %s: %w", namespace, err)
+		}
+		if model != "" {
+			client, err := r.modelClient(model)
+			if err != nil {
+				return err
+			}
+			return call(client)
+		}
+	}
+
+	if r.Route(namespace) == routeCandidate {
+		return call(r.candidate)
+	}
+
+	if r.fallback == nil {
+		return call(r.primary)
+	}
+
+	if r.PrimaryHealthy() {
+		err := call(r.primary)
+		if err == nil || !isProviderFailure(err) {
+			return err
+		}
+		r.markPrimaryUnhealthy(err)
+		if fbErr := call(r.fallback); fbErr != nil {
+			return fmt.Errorf("primary embedding provider failed: %v; fallback failed: %w", err, fbErr)
+		}
+		return nil
+	}
+
+	err := call(r.fallback)
+	if err != nil && isProviderFailure(err) {
+		// Fallback is down as well; give the primary another chance early
+		if primaryErr := call(r.primary); primaryErr == nil {
+			r.markPrimaryHealthy()
+			return nil
+		}
+	}
+	return err
+}
+
+// Model returns the embedding model that serves a namespace
+func (r *EmbeddingRouter) Model(namespace string) string {
+	if model := r.modelOverride(namespace); model != "" {
+		return model
+	}
+	return r.routeModels[r.Route(namespace)]
+}
+
+// modelOverride returns the model a namespace is configured with, or "".
+// Lookup failures are left for do to report.
+func (r *EmbeddingRouter) modelOverride(namespace string) string {
+	if r.namespaceModel == nil {
+		return ""
+	}
+	model, _ := r.namespaceModel(namespace)
+	return model
+}
+
+// modelClient returns the client of a namespace model, creating it on
+// first use
+func (r *EmbeddingRouter) modelClient(model string) (embeddingBackend, error) {
+	if r.newModelClient == nil {
+		return nil, fmt.Errorf("namespace embedding models are not supported")
+	}
+	r.modelsMu.Lock()
+	defer r.modelsMu.Unlock()
+	if client, ok := r.modelClients[model]; ok {
+		return client, nil
+	}
+	client, err := r.newModelClient(model)
+	if err != nil {
+		return nil, err
+	}
+	if r.modelClients == nil {
+		r.modelClients = make(map[string]embeddingBackend)
+	}
+	r.modelClients[model] = client
+	return client, nil
+}
+
+// CheckModel embeds a probe text with a namespace model, to check that the
+// primary's provider serves it with vectors of the router's dimension
+func (r *EmbeddingRouter) CheckModel(model string) error {
+	client, err := r.modelClient(model)
+	if err != nil {
+		return err
+	}
+	_, err = client.GenerateEmbedding("dimension probe")
+	return err
+}
+
+func (r *EmbeddingRouter) markPrimaryUnhealthy(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unhealthyUntil = r.now().Add(r.cfg.FailoverCooldown)
+	log.Warnf("[vectorfs/embedding] Primary provider failed, using fallback for %v: %v", r.cfg.FailoverCooldown, err)
+}
+
+func (r *EmbeddingRouter) markPrimaryHealthy() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unhealthyUntil = time.Time{}
+	log.Infof("[vectorfs/embedding] Primary provider recovered")
+}
+
+// isProviderFailure reports whether err indicates the provider itself is
+// unavailable (as opposed to a bad request that would fail anywhere)
+func isProviderFailure(err error) bool {
+	var apiErr *embeddingAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
@@ -1,55 +1,191 @@
 package vectorfs
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// Embedding providers
+const (
+	ProviderOpenAI      = "openai"
+	ProviderOllama      = "ollama"
+	ProviderAzureOpenAI = "azure_openai"
+	ProviderGemini      = "gemini"
+	ProviderVoyage      = "voyage"
+	ProviderCohere      = "cohere"
+)
+
+// embeddingProviders lists the valid embedding_provider values
+var embeddingProviders = []string{ProviderOpenAI, ProviderOllama, ProviderAzureOpenAI, ProviderGemini, ProviderVoyage, ProviderCohere}
+
+// defaultOpenAIEndpoint is the OpenAI embeddings API URL
+const defaultOpenAIEndpoint = "https://api.openai.com/v1/embeddings"
+
+// defaultOllamaEndpoint is the batch embeddings API of a local Ollama server
+const defaultOllamaEndpoint = "http://localhost:11434/api/embed"
+
+// defaultEmbeddingModel returns the model used when embedding_model is unset
+func defaultEmbeddingModel(provider string) string {
+	switch provider {
+	case ProviderOllama:
+		return "nomic-embed-text"
+	case ProviderGemini:
+		return "text-embedding-004"
+	case ProviderVoyage:
+		return "voyage-3"
+	case ProviderCohere:
+		return "embed-english-v3.0"
+	}
+	return "text-embedding-3-small"
+}
+
+// defaultEmbeddingDim returns the dimension used when embedding_dim is unset.
+// Local models vary too much to guess, so theirs is detected (0).
+func defaultEmbeddingDim(provider string) int {
+	switch provider {
+	case ProviderOllama:
+		return 0
+	case ProviderGemini:
+		return 768
+	case ProviderVoyage, ProviderCohere:
+		return 1024
+	}
+	return 1536
+}
+
 // EmbeddingConfig holds embedding configuration
 type EmbeddingConfig struct {
-	Provider  string // Provider name (openai)
-	APIKey    string // API key
-	Model     string // Model name
-	Dimension int    // Embedding dimension
+	Provider  string // Provider name (openai, ollama, azure_openai, gemini, voyage, cohere)
+	APIKey    string // API key; optional for ollama and, with a custom endpoint, for the others
+	Model     string // Model name; the deployment name for azure_openai
+	Dimension int    // Embedding dimension (0 = detect by embedding a probe text)
+	Endpoint  string // API endpoint (empty = provider default); any OpenAI-compatible URL works for openai
+}
+
+// Validate checks that the provider is known and has what it needs to
+// authenticate
+func (cfg EmbeddingConfig) Validate() error {
+	switch cfg.Provider {
+	case ProviderOllama:
+	case ProviderAzureOpenAI:
+		// The endpoint names the Azure resource, so there is no default
+		if cfg.Endpoint == "" {
+			return fmt.Errorf("endpoint is required for azure_openai provider (https://<resource>.openai.azure.com)")
+		}
+		if cfg.APIKey == "" {
+			return fmt.Errorf("API key is required for %s provider", cfg.Provider)
+		}
+	case ProviderOpenAI, ProviderGemini, ProviderVoyage, ProviderCohere:
+		// Local or proxied servers at a custom endpoint may need no key
+		if cfg.APIKey == "" && cfg.Endpoint == "" {
+			return fmt.Errorf("API key is required for %s provider", cfg.Provider)
+		}
+	default:
+		return fmt.Errorf("unsupported embedding provider: %s (supported: %s)", cfg.Provider, strings.Join(embeddingProviders, ", "))
+	}
+	if cfg.Dimension < 0 {
+		return fmt.Errorf("embedding dimension must not be negative")
+	}
+	return nil
+}
+
+// embeddingInput tells providers that distinguish them whether texts are
+// search queries or documents to index
+type embeddingInput int
+
+const (
+	inputDocument embeddingInput = iota
+	inputQuery
+)
+
+// embeddingProvider calls the embeddings API of one service
+type embeddingProvider interface {
+	// name is the provider in errors and logs
+	name() string
+	// maxBatch is the most texts one request may carry (0 = no limit)
+	maxBatch() int
+	// embed returns the embeddings of texts, in order
+	embed(texts []string, input embeddingInput) ([][]float32, error)
+}
+
+// newEmbeddingProvider creates the API client of a validated configuration
+func newEmbeddingProvider(cfg EmbeddingConfig, client *http.Client) embeddingProvider {
+	switch cfg.Provider {
+	case ProviderOllama:
+		endpoint := cfg.Endpoint
+		if endpoint == "" {
+			endpoint = defaultOllamaEndpoint
+		}
+		return &ollamaProvider{apiKey: cfg.APIKey, model: cfg.Model, endpoint: endpoint, client: client}
+	case ProviderAzureOpenAI:
+		return newAzureOpenAIProvider(cfg, client)
+	case ProviderGemini:
+		return newGeminiProvider(cfg, client)
+	case ProviderVoyage:
+		return newVoyageProvider(cfg, client)
+	case ProviderCohere:
+		return newCohereProvider(cfg, client)
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaultOpenAIEndpoint
+	}
+	return &openAIProvider{apiKey: cfg.APIKey, model: cfg.Model, endpoint: endpoint, client: client}
 }
 
-// EmbeddingClient handles embedding generation
+// EmbeddingClient handles embedding generation. It splits batches to the
+// limit of its provider and checks the dimension of what comes back.
 type EmbeddingClient struct {
-	provider  string
-	apiKey    string
+	provider  embeddingProvider
 	model     string
 	dimension int
 	client    *http.Client
 }
 
+// embeddingAPIError is returned when the provider answers with a non-200 status
+type embeddingAPIError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+}
+
+func (e *embeddingAPIError) Error() string {
+	return fmt.Sprintf("%s API error (status %d): %s", e.Provider, e.StatusCode, e.Body)
+}
+
 // NewEmbeddingClient creates a new embedding client
 func NewEmbeddingClient(cfg EmbeddingConfig) (*EmbeddingClient, error) {
-	if cfg.Provider != "openai" {
-		return nil, fmt.Errorf("unsupported embedding provider: %s", cfg.Provider)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
-	if cfg.APIKey == "" {
-		return nil, fmt.Errorf("API key is required")
+	client := &http.Client{
+		Timeout: 60 * time.Second, // Prevent indefinite blocking on API calls
 	}
-
-	log.Infof("[vectorfs/embedding] Initialized %s embedding client (model: %s, dim: %d)",
-		cfg.Provider, cfg.Model, cfg.Dimension)
-
-	return &EmbeddingClient{
-		provider:  cfg.Provider,
-		apiKey:    cfg.APIKey,
+	e := &EmbeddingClient{
+		provider:  newEmbeddingProvider(cfg, client),
 		model:     cfg.Model,
 		dimension: cfg.Dimension,
-		client: &http.Client{
-			Timeout: 60 * time.Second, // Prevent indefinite blocking on API calls
-		},
-	}, nil
+		client:    client,
+	}
+	if e.dimension == 0 {
+		probe, err := e.GenerateEmbedding("dimension probe")
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect embedding dimension: %w", err)
+		}
+		if len(probe) == 0 {
+			return nil, fmt.Errorf("failed to detect embedding dimension: empty embedding returned")
+		}
+		e.dimension = len(probe)
+	}
+
+	log.Infof("[vectorfs/embedding] Initialized %s embedding client (model: %s, dim: %d)",
+		cfg.Provider, cfg.Model, e.dimension)
+	return e, nil
 }
 
 // GetDimension returns the embedding dimension
@@ -57,39 +193,68 @@ func (e *EmbeddingClient) GetDimension() int {
 	return e.dimension
 }
 
-// GenerateEmbedding generates an embedding for the given text
+// GenerateEmbedding generates the embedding of a search query
 func (e *EmbeddingClient) GenerateEmbedding(text string) ([]float32, error) {
-	switch e.provider {
-	case "openai":
-		return e.generateOpenAIEmbedding(text)
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", e.provider)
+	embeddings, err := e.generate([]string{text}, inputQuery)
+	if err != nil {
+		return nil, err
 	}
+	return embeddings[0], nil
 }
 
-// GenerateBatchEmbeddings generates embeddings for multiple texts
+// GenerateBatchEmbeddings generates the embeddings of document chunks, in as
+// few requests as the provider allows
 func (e *EmbeddingClient) GenerateBatchEmbeddings(texts []string) ([][]float32, error) {
 	if len(texts) == 0 {
 		return nil, nil
 	}
 
-	switch e.provider {
-	case "openai":
-		return e.generateOpenAIBatchEmbeddingsImpl(texts)
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", e.provider)
+	size := e.provider.maxBatch()
+	if size <= 0 {
+		size = len(texts)
+	}
+	embeddings := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += size {
+		end := min(start+size, len(texts))
+		batch, err := e.generate(texts[start:end], inputDocument)
+		if err != nil {
+			return nil, err
+		}
+		embeddings = append(embeddings, batch...)
 	}
+	return embeddings, nil
 }
 
-// OpenAI API structures
-type openAIEmbeddingRequest struct {
-	Input string `json:"input"`
-	Model string `json:"model"`
+// generate embeds one batch and checks the count and dimension of the result
+func (e *EmbeddingClient) generate(texts []string, input embeddingInput) ([][]float32, error) {
+	embeddings, err := e.provider.embed(texts, input)
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(embeddings))
+	}
+	for _, embedding := range embeddings {
+		if len(embedding) == 0 {
+			return nil, fmt.Errorf("no embedding returned from %s", e.provider.name())
+		}
+		if e.dimension > 0 && len(embedding) != e.dimension {
+			return nil, fmt.Errorf("model %s returned %d-dimensional embeddings, expected %d", e.model, len(embedding), e.dimension)
+		}
+	}
+	return embeddings, nil
 }
 
+// ============================================================================
+// OpenAI and OpenAI-compatible servers
+// ============================================================================
+
+// openAIMaxBatch is the most inputs the OpenAI embeddings API takes at once
+const openAIMaxBatch = 2048
+
 type openAIBatchEmbeddingRequest struct {
 	Input []string `json:"input"`
-	Model string   `json:"model"`
+	Model string   `json:"model,omitempty"`
 }
 
 type openAIEmbeddingResponse struct {
@@ -104,98 +269,78 @@ type openAIEmbeddingResponse struct {
 	} `json:"usage"`
 }
 
-// generateOpenAIEmbedding generates embedding using OpenAI API
-func (e *EmbeddingClient) generateOpenAIEmbedding(text string) ([]float32, error) {
-	requestBody := openAIEmbeddingRequest{
-		Input: text,
-		Model: e.model,
+// embeddings returns the embeddings of the response in input order
+func (r *openAIEmbeddingResponse) embeddings(count int) ([][]float32, error) {
+	if len(r.Data) != count {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", count, len(r.Data))
 	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/embeddings", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	embeddings := make([][]float32, count)
+	for _, data := range r.Data {
+		if data.Index < 0 || data.Index >= count {
+			return nil, fmt.Errorf("embedding index %d out of range", data.Index)
+		}
+		embeddings[data.Index] = data.Embedding
 	}
+	return embeddings, nil
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+e.apiKey)
+// openAIProvider calls the OpenAI embeddings API, authenticated with a
+// bearer token
+type openAIProvider struct {
+	apiKey   string
+	model    string
+	endpoint string
+	client   *http.Client
+}
 
-	resp, err := e.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
+func (p *openAIProvider) name() string { return "OpenAI" }
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("OpenAI API error (status %d): %s", resp.StatusCode, string(body))
-	}
+func (p *openAIProvider) maxBatch() int { return openAIMaxBatch }
 
+func (p *openAIProvider) embed(texts []string, _ embeddingInput) ([][]float32, error) {
 	var response openAIEmbeddingResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
-	}
-
-	if len(response.Data) == 0 {
-		return nil, fmt.Errorf("no embedding returned from API")
+	request := openAIBatchEmbeddingRequest{Input: texts, Model: p.model}
+	if err := postJSON(p.client, p.endpoint, p.apiKey, p.name(), request, &response); err != nil {
+		return nil, err
 	}
-
-	log.Debugf("[vectorfs/embedding] Generated embedding (tokens: %d)", response.Usage.TotalTokens)
-	return response.Data[0].Embedding, nil
+	log.Debugf("[vectorfs/embedding] Generated %d embeddings (tokens: %d)", len(texts), response.Usage.TotalTokens)
+	return response.embeddings(len(texts))
 }
 
-// generateOpenAIBatchEmbeddingsImpl generates embeddings for multiple texts using OpenAI
-func (e *EmbeddingClient) generateOpenAIBatchEmbeddingsImpl(texts []string) ([][]float32, error) {
-	// OpenAI supports batch requests
-	requestBody := openAIBatchEmbeddingRequest{
-		Input: texts,
-		Model: e.model,
-	}
+// ============================================================================
+// Ollama
+// ============================================================================
 
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/embeddings", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+e.apiKey)
+// Ollama API structures
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
 
-	resp, err := e.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
+type ollamaEmbedResponse struct {
+	Embeddings      [][]float32 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("OpenAI API error (status %d): %s", resp.StatusCode, string(body))
-	}
+// ollamaProvider calls the /api/embed endpoint of an Ollama server, which
+// takes a batch of inputs of any size in one request
+type ollamaProvider struct {
+	apiKey   string
+	model    string
+	endpoint string
+	client   *http.Client
+}
 
-	var response openAIEmbeddingResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
-	}
+func (p *ollamaProvider) name() string { return "Ollama" }
 
-	if len(response.Data) != len(texts) {
-		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(response.Data))
-	}
+func (p *ollamaProvider) maxBatch() int { return 0 }
 
-	// Sort by index to ensure order matches input
-	embeddings := make([][]float32, len(texts))
-	for _, data := range response.Data {
-		embeddings[data.Index] = data.Embedding
+func (p *ollamaProvider) embed(texts []string, _ embeddingInput) ([][]float32, error) {
+	var response ollamaEmbedResponse
+	request := ollamaEmbedRequest{Model: p.model, Input: texts}
+	if err := postJSON(p.client, p.endpoint, p.apiKey, p.name(), request, &response); err != nil {
+		return nil, err
 	}
-
-	log.Debugf("[vectorfs/embedding] Generated %d embeddings (tokens: %d)",
-		len(embeddings), response.Usage.TotalTokens)
-	return embeddings, nil
+	log.Debugf("[vectorfs/embedding] Generated %d embeddings (tokens: %d)", len(texts), response.PromptEvalCount)
+	return response.Embeddings, nil
 }
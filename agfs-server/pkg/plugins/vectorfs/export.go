@@ -0,0 +1,342 @@
+package vectorfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// exportFile and importFile are the control files of a namespace that dump
+// it into a gzipped tarball and restore such a tarball, e.g. to back it up
+// or move it to another vector backend:
+//
+//	cat /vectorfs/my_project/.export > my_project.tar.gz
+//	cat my_project.tar.gz > /vectorfs/restored/.import
+const (
+	exportFile = ".export"
+	importFile = ".import"
+)
+
+// exportFormatVersion is the version of the archive layout
+const exportFormatVersion = 1
+
+// An export archive holds manifest.json, then for each document, in name
+// order, its content as docs/<name> and its index entry as index/<name>.json
+const (
+	exportManifestName = "manifest.json"
+	exportDocsDir      = "docs/"
+	exportIndexDir     = "index/"
+)
+
+// exportManifest describes an export archive
+type exportManifest struct {
+	Version      int    `json:"version"`
+	Namespace    string `json:"namespace"`
+	EmbeddingDim int    `json:"embedding_dim"`
+	Documents    int    `json:"documents"`
+}
+
+// exportedDocument is the index entry of a document
+type exportedDocument struct {
+	Digest    string          `json:"digest"`
+	Size      int64           `json:"size"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Chunks    []exportedChunk `json:"chunks,omitempty"`
+}
+
+// exportedChunk is an indexed chunk. The embedding is stored as little
+// endian float32s, which JSON encodes as base64.
+type exportedChunk struct {
+	Index     int         `json:"index"`
+	Text      string      `json:"text"`
+	Embedding []byte      `json:"embedding"`
+	Metadata  DocMetadata `json:"metadata,omitempty"`
+}
+
+// exportNamespace writes the archive of a namespace. The archive only
+// depends on the namespace content, so reading it in ranges is consistent.
+func (vfs *vectorFS) exportNamespace(namespace string) ([]byte, error) {
+	exists, err := vfs.plugin.store.NamespaceExists(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, filesystem.NewNotFoundError("export", namespace)
+	}
+	files, err := vfs.plugin.store.ListFiles(namespace)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].FileName < files[j].FileName })
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	addFile := func(name string, data []byte, modTime time.Time) error {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: modTime, Format: tar.FormatPAX}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	manifest, _ := json.MarshalIndent(exportManifest{
+		Version:      exportFormatVersion,
+		Namespace:    namespace,
+		EmbeddingDim: vfs.plugin.embedder.GetDimension(),
+		Documents:    len(files),
+	}, "", "  ")
+	if err := addFile(exportManifestName, manifest, time.Unix(0, 0)); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	for _, f := range files {
+		data, err := vfs.plugin.docs.DownloadDocument(ctx, namespace, f.FileDigest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", f.FileName, err)
+		}
+		chunks, err := vfs.plugin.store.ListChunks(namespace, f.FileDigest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunks of %s: %w", f.FileName, err)
+		}
+		doc := exportedDocument{Digest: f.FileDigest, Size: f.FileSize, CreatedAt: f.CreatedAt.UTC(), UpdatedAt: f.UpdatedAt.UTC()}
+		for _, c := range chunks {
+			doc.Chunks = append(doc.Chunks, exportedChunk{Index: c.ChunkIndex, Text: c.ChunkText, Embedding: encodeEmbedding(c.Embedding), Metadata: c.Metadata})
+		}
+		entry, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		if err := addFile(exportDocsDir+f.FileName, data, f.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := addFile(exportIndexDir+f.FileName+".json", entry, f.UpdatedAt); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	log.Infof("[vectorfs] Exported %d document(s) of %s (%d bytes)", len(files), namespace, buf.Len())
+	return buf.Bytes(), nil
+}
+
+// importedDocument is a document read from an export archive
+type importedDocument struct {
+	name  string
+	data  []byte
+	entry *exportedDocument
+}
+
+// readExportArchive parses an export archive into its documents
+func readExportArchive(archive []byte) (*exportManifest, []*importedDocument, error) {
+	invalid := func(reason string) error {
+		return filesystem.NewInvalidArgumentError("archive", importFile, reason)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, nil, invalid("not a gzipped tarball")
+	}
+	tr := tar.NewReader(gz)
+
+	var manifest *exportManifest
+	docs := make(map[string]*importedDocument)
+	document := func(name string) *importedDocument {
+		if docs[name] == nil {
+			docs[name] = &importedDocument{name: name}
+		}
+		return docs[name]
+	}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, invalid(fmt.Sprintf("corrupt tarball: %v", err))
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, invalid(fmt.Sprintf("corrupt tarball: %v", err))
+		}
+		name := header.Name
+		if name != path.Clean(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "../") {
+			return nil, nil, invalid(fmt.Sprintf("unsafe entry name %q", name))
+		}
+
+		switch {
+		case name == exportManifestName:
+			manifest = &exportManifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, nil, invalid(fmt.Sprintf("malformed manifest: %v", err))
+			}
+		case strings.HasPrefix(name, exportDocsDir):
+			document(strings.TrimPrefix(name, exportDocsDir)).data = data
+		case strings.HasPrefix(name, exportIndexDir) && strings.HasSuffix(name, ".json"):
+			entry := &exportedDocument{}
+			if err := json.Unmarshal(data, entry); err != nil {
+				return nil, nil, invalid(fmt.Sprintf("malformed index entry %s: %v", name, err))
+			}
+			document(strings.TrimSuffix(strings.TrimPrefix(name, exportIndexDir), ".json")).entry = entry
+		}
+	}
+	if manifest == nil {
+		return nil, nil, invalid("missing " + exportManifestName)
+	}
+	if manifest.Version != exportFormatVersion {
+		return nil, nil, invalid(fmt.Sprintf("unsupported archive version %d", manifest.Version))
+	}
+
+	names := make([]string, 0, len(docs))
+	for name, doc := range docs {
+		if doc.entry == nil || doc.data == nil {
+			return nil, nil, invalid(fmt.Sprintf("document %s lacks its content or index entry", name))
+		}
+		// Empty documents are keyed by a filename hash instead
+		if len(doc.data) > 0 {
+			hash := sha256.Sum256(doc.data)
+			if hex.EncodeToString(hash[:]) != doc.entry.Digest {
+				return nil, nil, invalid(fmt.Sprintf("document %s does not match its digest", name))
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ordered := make([]*importedDocument, len(names))
+	for i, name := range names {
+		ordered[i] = docs[name]
+	}
+	return manifest, ordered, nil
+}
+
+// importNamespace restores an export archive into a namespace, creating it
+// if needed. Documents replace those of the same name. Their chunks are
+// restored as exported when the embedding dimension matches, and otherwise
+// re-embedded. It returns how many documents were imported.
+func (vfs *vectorFS) importNamespace(namespace string, archive []byte) (int, error) {
+	manifest, docs, err := readExportArchive(archive)
+	if err != nil {
+		return 0, err
+	}
+
+	dim := vfs.plugin.embedder.GetDimension()
+	exists, err := vfs.plugin.store.NamespaceExists(namespace)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		if err := vfs.Mkdir("/"+namespace, 0755); err != nil {
+			return 0, err
+		}
+	}
+	reuseEmbeddings := manifest.EmbeddingDim == dim
+	if !reuseEmbeddings {
+		log.Warnf("[vectorfs] Archive of %s has %d-dimensional embeddings, %s uses %d: re-embedding its documents",
+			manifest.Namespace, manifest.EmbeddingDim, namespace, dim)
+	}
+
+	// Documents replace those of the same name, as when written one by one
+	release, err := vfs.reserveQuota(namespace, func() (documents, bytes int64) {
+		for _, doc := range docs {
+			d, b := vfs.replaceDelta(namespace, doc.name, int64(len(doc.data)))
+			documents, bytes = documents+d, bytes+b
+		}
+		return documents, bytes
+	}, true)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	ctx := context.Background()
+	var reembed []indexTask
+	for _, doc := range docs {
+		if err := vfs.plugin.store.DeleteFileByName(namespace, doc.name); err != nil {
+			log.Warnf("[vectorfs] Failed to delete old versions of %s: %v", doc.name, err)
+		}
+		// Content shared with another document of the namespace is
+		// already stored and indexed
+		contentExists, err := vfs.plugin.store.FileExists(namespace, doc.entry.Digest)
+		if err != nil {
+			return 0, err
+		}
+		if !contentExists {
+			if err := vfs.plugin.docs.UploadDocument(ctx, namespace, doc.entry.Digest, doc.data); err != nil {
+				return 0, fmt.Errorf("failed to store %s: %w", doc.name, err)
+			}
+		}
+		if err := vfs.plugin.store.InsertFileMetadata(namespace, FileMetadata{
+			FileDigest: doc.entry.Digest,
+			FileName:   doc.name,
+			S3Key:      vfs.plugin.docs.buildKey(namespace, doc.entry.Digest),
+			FileSize:   int64(len(doc.data)),
+			CreatedAt:  doc.entry.CreatedAt,
+			UpdatedAt:  doc.entry.UpdatedAt,
+		}); err != nil {
+			return 0, fmt.Errorf("failed to register %s: %w", doc.name, err)
+		}
+		if contentExists {
+			continue
+		}
+
+		chunks, ok := importedChunks(doc.entry, dim)
+		if ok && reuseEmbeddings {
+			if err := vfs.plugin.store.InsertChunksBatch(namespace, doc.entry.Digest, chunks); err != nil {
+				return 0, fmt.Errorf("failed to restore chunks of %s: %w", doc.name, err)
+			}
+			continue
+		}
+		// Documents exported before they were indexed, or whose embeddings
+		// don't fit, are indexed once every document is in place so that
+		// they find their metadata sidecars
+		if _, isSidecar := sidecarTarget(doc.name); !isSidecar && len(doc.data) > 0 && vfs.plugin.indexer.CanIndex(doc.name, doc.data) {
+			reembed = append(reembed, indexTask{namespace: namespace, digest: doc.entry.Digest, fileName: doc.name, data: string(doc.data)})
+		}
+	}
+	for _, task := range reembed {
+		vfs.plugin.queueIndexing(task)
+	}
+
+	log.Infof("[vectorfs] Imported %d document(s) of %s into %s, %d queued for indexing",
+		len(docs), manifest.Namespace, namespace, len(reembed))
+	return len(docs), nil
+}
+
+// importedChunks decodes the chunks of an index entry. ok is false if there
+// are none or an embedding doesn't have dim dimensions.
+func importedChunks(entry *exportedDocument, dim int) ([]ChunkData, bool) {
+	if len(entry.Chunks) == 0 {
+		return nil, false
+	}
+	chunks := make([]ChunkData, 0, len(entry.Chunks))
+	for _, c := range entry.Chunks {
+		embedding, err := decodeEmbedding(c.Embedding)
+		if err != nil || len(embedding) != dim {
+			return nil, false
+		}
+		chunks = append(chunks, ChunkData{ChunkIndex: c.Index, ChunkText: c.Text, Embedding: embedding, Metadata: c.Metadata})
+	}
+	return chunks, true
+}
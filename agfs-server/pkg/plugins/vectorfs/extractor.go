@@ -0,0 +1,374 @@
+package vectorfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errExtractionFailed marks documents whose text cannot be extracted.
+// Retrying doesn't help, so they are not kept pending for a restart.
+var errExtractionFailed = errors.New("text extraction failed")
+
+// MIME types sniffed from document content
+const (
+	mimePDF  = "application/pdf"
+	mimeHTML = "text/html"
+	mimeDOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+)
+
+// extractCommandTimeout bounds an external extractor command
+const extractCommandTimeout = 2 * time.Minute
+
+// Extractor converts a document format to plain text before chunking
+type Extractor interface {
+	// Name identifies the extractor in logs and errors
+	Name() string
+	// Extract returns the text of a document
+	Extract(data []byte) (string, error)
+}
+
+// ExtractorRegistry selects the extractor of a document by its file
+// extension, falling back to sniffing the MIME type of its content.
+// Documents without an extractor are indexed as they are if they are text.
+type ExtractorRegistry struct {
+	byExtension map[string]Extractor
+	byMIME      map[string]Extractor
+}
+
+// NewExtractorRegistry returns a registry with the built-in extractors for
+// PDF, DOCX, HTML and Markdown
+func NewExtractorRegistry() *ExtractorRegistry {
+	r := &ExtractorRegistry{
+		byExtension: make(map[string]Extractor),
+		byMIME:      make(map[string]Extractor),
+	}
+	r.Register(pdfExtractor{}, []string{".pdf"}, []string{mimePDF})
+	r.Register(docxExtractor{}, []string{".docx"}, []string{mimeDOCX})
+	r.Register(htmlExtractor{}, []string{".html", ".htm", ".xhtml"}, []string{mimeHTML})
+	r.Register(markdownExtractor{}, []string{".md", ".markdown"}, nil)
+	return r
+}
+
+// Register makes e the extractor of the given file extensions (".pdf") and
+// MIME types, replacing any registered before
+func (r *ExtractorRegistry) Register(e Extractor, extensions, mimeTypes []string) {
+	for _, ext := range extensions {
+		r.byExtension[strings.ToLower(ext)] = e
+	}
+	for _, mime := range mimeTypes {
+		r.byMIME[strings.ToLower(mime)] = e
+	}
+}
+
+// Select returns the extractor of a document, or nil if it has none
+func (r *ExtractorRegistry) Select(fileName string, data []byte) Extractor {
+	if e, ok := r.byExtension[strings.ToLower(filepath.Ext(fileName))]; ok {
+		return e
+	}
+	return r.byMIME[sniffMIME(data)]
+}
+
+// Extensions returns the file extensions with an extractor
+func (r *ExtractorRegistry) Extensions() []string {
+	var exts []string
+	for ext := range r.byExtension {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+// sniffMIME returns the MIME type of document content, without parameters.
+// DOCX files are told apart from other ZIP archives by their main part.
+func sniffMIME(data []byte) string {
+	mime := http.DetectContentType(data)
+	if i := strings.Index(mime, ";"); i != -1 {
+		mime = mime[:i]
+	}
+	if mime == "application/zip" {
+		if zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+			for _, f := range zr.File {
+				if f.Name == "word/document.xml" {
+					return mimeDOCX
+				}
+			}
+		}
+	}
+	return mime
+}
+
+// newExtractorRegistryFromConfig returns the built-in extractors and the
+// configured image extractor, with the configured extractor commands taking
+// over their file extensions
+func newExtractorRegistryFromConfig(cfg map[string]interface{}) (*ExtractorRegistry, error) {
+	commands, err := parseExtractorCommands(cfg)
+	if err != nil {
+		return nil, err
+	}
+	images, err := parseImageConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r := NewExtractorRegistry()
+	if e := newImageExtractor(images); e != nil {
+		r.Register(e, imageExtensions, imageMIMETypes)
+	}
+	for ext, e := range commands {
+		r.Register(e, []string{ext}, nil)
+	}
+	return r, nil
+}
+
+// parseExtractorCommands reads extractor_commands, a map of file extension
+// to the command converting it: the document is written to its stdin and
+// its stdout is the text, e.g. ".pdf" = "pdftotext -layout - -"
+func parseExtractorCommands(cfg map[string]interface{}) (map[string]Extractor, error) {
+	raw, ok := cfg["extractor_commands"]
+	if !ok {
+		return nil, nil
+	}
+	commands, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("extractor_commands must be a map of file extension to command")
+	}
+
+	extractors := make(map[string]Extractor)
+	for ext, v := range commands {
+		command, ok := v.(string)
+		if !ok || len(strings.Fields(command)) == 0 {
+			return nil, fmt.Errorf("extractor_commands: command for %s must be a non-empty string", ext)
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		extractors[strings.ToLower(ext)] = commandExtractor{args: strings.Fields(command)}
+	}
+	return extractors, nil
+}
+
+// commandExtractor runs an external program, e.g. pdftotext or pandoc
+type commandExtractor struct {
+	args []string
+}
+
+func (e commandExtractor) Name() string { return e.args[0] }
+
+func (e commandExtractor) Extract(data []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), extractCommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.args[0], e.args[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%v: %s", err, msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// docxExtractor reads the paragraphs of the main part of a DOCX document
+type docxExtractor struct{}
+
+func (docxExtractor) Name() string { return "docx" }
+
+func (docxExtractor) Extract(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("not a DOCX archive: %w", err)
+	}
+	var part *zip.File
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" {
+			part = f
+			break
+		}
+	}
+	if part == nil {
+		return "", fmt.Errorf("word/document.xml not found")
+	}
+	rc, err := part.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	var sb strings.Builder
+	inText := false
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid word/document.xml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				sb.WriteString("\t")
+			case "br", "cr":
+				sb.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				sb.WriteString("\n\n")
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		}
+	}
+	return sb.String(), nil
+}
+
+// htmlExtractor strips markup, scripts and styles from an HTML document,
+// keeping block elements as paragraph breaks
+type htmlExtractor struct{}
+
+func (htmlExtractor) Name() string { return "html" }
+
+var (
+	htmlSkippedElements = map[string]bool{"script": true, "style": true, "noscript": true, "template": true, "svg": true}
+	htmlParagraphTags   = map[string]bool{
+		"p": true, "div": true, "section": true, "article": true, "header": true, "footer": true,
+		"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+		"ul": true, "ol": true, "table": true, "blockquote": true, "pre": true, "title": true, "hr": true,
+	}
+	htmlLineTags = map[string]bool{"br": true, "li": true, "tr": true, "dt": true, "dd": true}
+)
+
+func (htmlExtractor) Extract(data []byte) (string, error) {
+	doc := string(data)
+	var sb strings.Builder
+	for len(doc) > 0 {
+		lt := strings.IndexByte(doc, '<')
+		if lt == -1 {
+			sb.WriteString(html.UnescapeString(doc))
+			break
+		}
+		sb.WriteString(html.UnescapeString(doc[:lt]))
+		doc = doc[lt:]
+
+		if strings.HasPrefix(doc, "<!--") {
+			end := strings.Index(doc, "-->")
+			if end == -1 {
+				break
+			}
+			doc = doc[end+len("-->"):]
+			continue
+		}
+		gt := strings.IndexByte(doc, '>')
+		if gt == -1 {
+			break
+		}
+		tag := doc[1:gt]
+		doc = doc[gt+1:]
+
+		closing := strings.HasPrefix(tag, "/")
+		name := strings.ToLower(strings.TrimLeft(tag, "/!?"))
+		if i := strings.IndexAny(name, " \t\r\n/"); i != -1 {
+			name = name[:i]
+		}
+		switch {
+		case !closing && htmlSkippedElements[name]:
+			end := strings.Index(strings.ToLower(doc), "</"+name)
+			if end == -1 {
+				doc = ""
+				continue
+			}
+			doc = doc[end:]
+		case htmlParagraphTags[name]:
+			sb.WriteString("\n\n")
+		case htmlLineTags[name]:
+			sb.WriteString("\n")
+		}
+	}
+	return collapseWhitespace(sb.String()), nil
+}
+
+// collapseWhitespace joins runs of spaces within lines and keeps at most
+// one blank line between paragraphs, as markup leaves plenty of both
+func collapseWhitespace(text string) string {
+	lines := strings.Split(text, "\n")
+	var out []string
+	blank := true
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			if !blank {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		out = append(out, line)
+		blank = false
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// markdownExtractor drops Markdown syntax that carries no meaning for
+// search: front matter, link targets, emphasis and heading markers
+type markdownExtractor struct{}
+
+func (markdownExtractor) Name() string { return "markdown" }
+
+var (
+	mdImage    = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLink     = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	mdRefLink  = regexp.MustCompile(`\[([^\]]+)\]\[[^\]]*\]`)
+	mdLinkDef  = regexp.MustCompile(`(?m)^\s{0,3}\[[^\]]+\]:\s+\S+.*$`)
+	mdComment  = regexp.MustCompile(`(?s)<!--.*?-->`)
+	mdHeading  = regexp.MustCompile(`(?m)^\s{0,3}#{1,6}\s+`)
+	mdQuote    = regexp.MustCompile(`(?m)^\s{0,3}>\s?`)
+	mdFence    = regexp.MustCompile("(?m)^\\s{0,3}(```|~~~).*$")
+	mdEmphasis = regexp.MustCompile(`(\*\*|__|~~|\*|` + "`" + `)`)
+)
+
+func (markdownExtractor) Extract(data []byte) (string, error) {
+	text := string(data)
+
+	// YAML front matter
+	if strings.HasPrefix(text, "---\n") {
+		if end := strings.Index(text[4:], "\n---"); end != -1 {
+			text = text[4+end+len("\n---"):]
+		}
+	}
+
+	text = mdComment.ReplaceAllString(text, "")
+	text = mdLinkDef.ReplaceAllString(text, "")
+	text = mdImage.ReplaceAllString(text, "$1")
+	text = mdLink.ReplaceAllString(text, "$1")
+	text = mdRefLink.ReplaceAllString(text, "$1")
+	text = mdFence.ReplaceAllString(text, "")
+	text = mdHeading.ReplaceAllString(text, "")
+	text = mdQuote.ReplaceAllString(text, "")
+	text = mdEmphasis.ReplaceAllString(text, "")
+	return strings.TrimSpace(text), nil
+}
@@ -0,0 +1,138 @@
+package vectorfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+// searchAllNamespace is the control path that searches every namespace:
+//
+//	grep 'query' /vectorfs/search-all
+const searchAllNamespace = "search-all"
+
+// parseNamespaceSet expands a federated namespace path component.
+// "{team-a,team-b}" expands to both namespaces; any other value is returned
+// as-is with federated=false.
+func parseNamespaceSet(component string) (namespaces []string, federated bool) {
+	if !strings.HasPrefix(component, "{") || !strings.HasSuffix(component, "}") {
+		return []string{component}, false
+	}
+
+	seen := make(map[string]bool)
+	for _, ns := range strings.Split(component[1:len(component)-1], ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "" || seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces, true
+}
+
+// FederatedSearch runs one query against several namespaces and merges the
+// results by score. Each result carries a "namespace" metadata field.
+func (vfs *vectorFS) FederatedSearch(namespaces []string, query string, limit int) ([]mountablefs.CustomGrepResult, error) {
+	if len(namespaces) == 0 {
+		return nil, fmt.Errorf("no namespaces to search")
+	}
+
+	// Only namespaces that exist are searched; unknown names are an error so
+	// typos don't silently shrink the result set.
+	existing, err := vfs.plugin.store.ListNamespaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	known := make(map[string]bool, len(existing))
+	for _, ns := range existing {
+		known[ns] = true
+	}
+	for _, ns := range namespaces {
+		if !known[sanitizeTableName(ns)] {
+			return nil, fmt.Errorf("namespace not found: %s", ns)
+		}
+	}
+
+	query, mods, err := parseSearchModifiers(query)
+	if err != nil {
+		return nil, err
+	}
+	limit = vfs.plugin.search.resultLimit(limit, mods)
+	div := vfs.plugin.search.diversity(mods)
+	text, keywords, err := parseHybridQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Embed the query once per embedding route and reuse it across namespaces
+	embeddings := make(map[string][]float32)
+	for _, ns := range namespaces {
+		route := vfs.plugin.embedder.Route(ns)
+		if _, ok := embeddings[route]; ok {
+			continue
+		}
+		queryEmbedding, err := vfs.plugin.queryEmbedding(ns, text)
+		if err != nil {
+			return nil, err
+		}
+		embeddings[route] = queryEmbedding
+	}
+
+	hooked := vfs.plugin.hasScoringHook(namespaces...)
+	candidates := vfs.plugin.searchCandidates(limit, div, hooked)
+	perNamespace := make([][]mountablefs.CustomGrepResult, len(namespaces))
+	errs := make([]error, len(namespaces))
+	var wg sync.WaitGroup
+	for i, ns := range namespaces {
+		wg.Add(1)
+		go func(i int, ns string) {
+			defer wg.Done()
+			perNamespace[i], errs[i] = vfs.searchNamespace(ns, embeddings[vfs.plugin.embedder.Route(ns)], keywords, mods, candidates)
+		}(i, ns)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("search in namespace %s failed: %w", namespaces[i], err)
+		}
+	}
+
+	// Reranking after the merge also makes scores from different
+	// namespaces comparable
+	return vfs.plugin.rankResults("", text, mergeFederatedResults(namespaces, perNamespace, candidates), div, hooked, limit), nil
+}
+
+// mergeFederatedResults tags results with their namespace and keeps the
+// best-scoring limit results overall
+func mergeFederatedResults(namespaces []string, perNamespace [][]mountablefs.CustomGrepResult, limit int) []mountablefs.CustomGrepResult {
+	var merged []mountablefs.CustomGrepResult
+	for i, results := range perNamespace {
+		for _, r := range results {
+			if r.Metadata == nil {
+				r.Metadata = make(map[string]interface{})
+			}
+			r.Metadata["namespace"] = namespaces[i]
+			merged = append(merged, r)
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return resultScore(merged[i]) > resultScore(merged[j])
+	})
+
+	if limit > 0 && len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged
+}
+
+// resultScore extracts the ranking score from a search result
+func resultScore(r mountablefs.CustomGrepResult) float64 {
+	score, _ := r.Metadata["score"].(float64)
+	return score
+}
@@ -0,0 +1,336 @@
+package vectorfs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// gcFile is the control file of a namespace that collects its orphaned data
+// right away, and shows the report of the last collection:
+//
+//	echo > /vectorfs/my_project/.gc
+//	echo dry-run > /vectorfs/my_project/.gc
+//	cat /vectorfs/my_project/.gc
+const gcFile = ".gc"
+
+// Requests written to .gc
+const (
+	gcRequestCollect = "collect" // Remove orphaned data (the default)
+	gcRequestDryRun  = "dry-run" // Only report it
+)
+
+const (
+	// defaultGCInterval is how often every namespace is collected
+	defaultGCInterval = time.Hour
+	// defaultGCGracePeriod is how old stored content must be before it
+	// counts as orphaned: a write stores the content before its metadata
+	defaultGCGracePeriod = 10 * time.Minute
+)
+
+// StoredDocument is a document as kept by a DocumentStore
+type StoredDocument struct {
+	Digest  string
+	Size    int64
+	ModTime time.Time
+}
+
+// gcReport is what a collection of a namespace found and removed
+type gcReport struct {
+	Namespace  string
+	DryRun     bool
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Stored content that no document refers to
+	OrphanedDocuments []StoredDocument
+	// Digests of chunks that no document refers to
+	OrphanedChunks []string
+	// Documents whose stored content is gone; they cannot be read or
+	// indexed again, and are left for their owner to rewrite or remove
+	MissingDocuments []string
+	Errors           []string
+}
+
+// parseGCConfig reads how often namespaces are collected (0 disables it)
+// and how old stored content must be before it is collected
+func parseGCConfig(cfg map[string]interface{}) (interval, gracePeriod time.Duration, err error) {
+	parse := func(key string, def time.Duration) (time.Duration, error) {
+		value := config.GetStringConfig(cfg, key, "")
+		if value == "" {
+			return def, nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+		}
+		if d < 0 {
+			return 0, fmt.Errorf("%s must not be negative", key)
+		}
+		return d, nil
+	}
+	if interval, err = parse("gc_interval", defaultGCInterval); err != nil {
+		return 0, 0, err
+	}
+	if gracePeriod, err = parse("gc_grace_period", defaultGCGracePeriod); err != nil {
+		return 0, 0, err
+	}
+	return interval, gracePeriod, nil
+}
+
+// parseGCRequest reads a write to .gc: empty or "collect" removes orphaned
+// data, "dry-run" only reports it
+func parseGCRequest(data []byte) (dryRun bool, err error) {
+	switch request := strings.TrimSpace(string(data)); request {
+	case "", gcRequestCollect:
+		return false, nil
+	case gcRequestDryRun:
+		return true, nil
+	default:
+		return false, filesystem.NewInvalidArgumentError("gc", request,
+			fmt.Sprintf("expected %s or %s", gcRequestCollect, gcRequestDryRun))
+	}
+}
+
+// queryOrphanedChunkDigests returns the digests of the chunks that no file
+// metadata refers to. The schema is the same for every backend.
+func queryOrphanedChunkDigests(db *sql.DB, metaTable, chunksTable string) ([]string, error) {
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT DISTINCT file_digest FROM %s
+		WHERE file_digest NOT IN (SELECT file_digest FROM %s)
+	`, chunksTable, metaTable))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orphaned chunks: %w", err)
+	}
+	defer rows.Close()
+
+	var digests []string
+	for rows.Next() {
+		var digest string
+		if err := rows.Scan(&digest); err != nil {
+			return nil, err
+		}
+		digests = append(digests, digest)
+	}
+	return digests, rows.Err()
+}
+
+// indexingPending reports whether a document is queued or being embedded.
+// Its chunks may be written before its metadata is visible to a collection
+// that started earlier, so it is left alone.
+func (v *VectorFSPlugin) indexingPending(namespace, digest string) bool {
+	v.indexingStatusMu.RLock()
+	defer v.indexingStatusMu.RUnlock()
+	info := v.indexingStatus[namespace][digest]
+	return info != nil && info.pending()
+}
+
+// collectGarbage removes the data of a namespace left behind by failed or
+// interrupted writes and removals: stored content without metadata, past
+// the grace period, and chunks without metadata. Documents whose content is
+// missing are only reported. Collections run one at a time.
+func (v *VectorFSPlugin) collectGarbage(namespace string, dryRun bool) *gcReport {
+	v.gcRunMu.Lock()
+	defer v.gcRunMu.Unlock()
+
+	ctx := context.Background()
+	report := &gcReport{Namespace: namespace, DryRun: dryRun, StartedAt: time.Now()}
+	fail := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		log.Warnf("[vectorfs] Garbage collection of %s: %s", namespace, msg)
+		report.Errors = append(report.Errors, msg)
+	}
+	defer func() {
+		report.FinishedAt = time.Now()
+		v.gcMu.Lock()
+		v.gcReports[namespace] = report
+		v.gcMu.Unlock()
+	}()
+
+	stored, err := v.docs.ListDocuments(ctx, namespace)
+	if err != nil {
+		fail("failed to list stored documents: %v", err)
+		return report
+	}
+	files, err := v.store.ListFiles(namespace)
+	if err != nil {
+		fail("failed to list documents: %v", err)
+		return report
+	}
+
+	referenced := make(map[string]bool, len(files))
+	for _, f := range files {
+		referenced[f.FileDigest] = true
+	}
+	isStored := make(map[string]bool, len(stored))
+	for _, doc := range stored {
+		isStored[doc.Digest] = true
+		if referenced[doc.Digest] || report.StartedAt.Sub(doc.ModTime) < v.gcGracePeriod {
+			continue
+		}
+		// Look again, in case a write of the same content just came in
+		if exists, err := v.store.FileExists(namespace, doc.Digest); err != nil || exists {
+			if err != nil {
+				fail("failed to check stored document %s: %v", doc.Digest, err)
+			}
+			continue
+		}
+		if !dryRun {
+			if err := v.docs.DeleteDocument(ctx, namespace, doc.Digest); err != nil {
+				fail("failed to remove stored document %s: %v", doc.Digest, err)
+				continue
+			}
+		}
+		report.OrphanedDocuments = append(report.OrphanedDocuments, doc)
+	}
+
+	for _, f := range files {
+		if isStored[f.FileDigest] {
+			continue
+		}
+		// Content stored after the listing above is not missing
+		if exists, err := v.docs.DocumentExists(ctx, namespace, f.FileDigest); err != nil || exists {
+			continue
+		}
+		report.MissingDocuments = append(report.MissingDocuments, f.FileName)
+	}
+
+	digests, err := v.store.ListOrphanedChunkDigests(namespace)
+	if err != nil {
+		fail("%v", err)
+		return report
+	}
+	for _, digest := range digests {
+		if v.indexingPending(namespace, digest) {
+			continue
+		}
+		if exists, err := v.store.FileExists(namespace, digest); err != nil || exists {
+			if err != nil {
+				fail("failed to check chunks of %s: %v", digest, err)
+			}
+			continue
+		}
+		if !dryRun {
+			if err := v.store.DeleteFileChunks(namespace, digest); err != nil {
+				fail("failed to remove chunks of %s: %v", digest, err)
+				continue
+			}
+		}
+		report.OrphanedChunks = append(report.OrphanedChunks, digest)
+	}
+
+	if len(report.OrphanedDocuments)+len(report.OrphanedChunks)+len(report.MissingDocuments) > 0 {
+		log.Infof("[vectorfs] Garbage collection of %s: %d orphaned stored document(s), %d orphaned chunk set(s), %d missing document(s) (dry run: %v)",
+			namespace, len(report.OrphanedDocuments), len(report.OrphanedChunks), len(report.MissingDocuments), dryRun)
+	}
+	return report
+}
+
+// lastGCReport returns the report of the last collection of a namespace, or
+// nil if there was none
+func (v *VectorFSPlugin) lastGCReport(namespace string) *gcReport {
+	v.gcMu.Lock()
+	defer v.gcMu.Unlock()
+	return v.gcReports[namespace]
+}
+
+// forgetGCReport drops the report of a removed namespace
+func (v *VectorFSPlugin) forgetGCReport(namespace string) {
+	v.gcMu.Lock()
+	defer v.gcMu.Unlock()
+	delete(v.gcReports, namespace)
+}
+
+// gcLoop collects every namespace once per gc interval until shutdown
+func (v *VectorFSPlugin) gcLoop(shutdown <-chan struct{}) {
+	defer v.workerWg.Done()
+
+	ticker := time.NewTicker(v.gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+		namespaces, err := v.store.ListNamespaces()
+		if err != nil {
+			log.Warnf("[vectorfs] Failed to list namespaces for garbage collection: %v", err)
+			continue
+		}
+		for _, namespace := range namespaces {
+			v.collectGarbage(namespace, false)
+		}
+	}
+}
+
+// formatGCReport renders a report as read from .gc
+func formatGCReport(report *gcReport) string {
+	if report == nil {
+		return "no garbage collection has run yet; write to .gc to run one\n"
+	}
+	var sb strings.Builder
+	action := "removed"
+	if report.DryRun {
+		action = "found (dry run)"
+	}
+	fmt.Fprintf(&sb, "started: %s\n", report.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(&sb, "finished: %s\n", report.FinishedAt.Format(time.RFC3339))
+
+	var size int64
+	for _, doc := range report.OrphanedDocuments {
+		size += doc.Size
+	}
+	fmt.Fprintf(&sb, "orphaned stored documents %s: %d (%d bytes)\n", action, len(report.OrphanedDocuments), size)
+	for _, doc := range report.OrphanedDocuments {
+		fmt.Fprintf(&sb, "  %s (%d bytes, stored %s)\n", doc.Digest, doc.Size, doc.ModTime.Format(time.RFC3339))
+	}
+	fmt.Fprintf(&sb, "orphaned chunks %s: %d document(s)\n", action, len(report.OrphanedChunks))
+	for _, digest := range report.OrphanedChunks {
+		fmt.Fprintf(&sb, "  %s\n", digest)
+	}
+	missing := append([]string(nil), report.MissingDocuments...)
+	sort.Strings(missing)
+	fmt.Fprintf(&sb, "documents missing their stored content: %d\n", len(missing))
+	for _, name := range missing {
+		fmt.Fprintf(&sb, "  docs/%s\n", name)
+	}
+	if len(report.Errors) > 0 {
+		fmt.Fprintf(&sb, "errors: %d\n", len(report.Errors))
+		for _, msg := range report.Errors {
+			fmt.Fprintf(&sb, "  %s\n", msg)
+		}
+	}
+	return sb.String()
+}
+
+// readGCReport renders the last collection of a namespace
+func (vfs *vectorFS) readGCReport(namespace string) ([]byte, error) {
+	if err := vfs.requireNamespace("gc", namespace); err != nil {
+		return nil, err
+	}
+	return []byte(formatGCReport(vfs.plugin.lastGCReport(namespace))), nil
+}
+
+// writeGCRequest collects a namespace now. A collection that hit errors
+// fails, with the report left in .gc.
+func (vfs *vectorFS) writeGCRequest(namespace string, data []byte) error {
+	dryRun, err := parseGCRequest(data)
+	if err != nil {
+		return err
+	}
+	if err := vfs.requireNamespace("gc", namespace); err != nil {
+		return err
+	}
+	report := vfs.plugin.collectGarbage(namespace, dryRun)
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("garbage collection of %s failed: %s", namespace, report.Errors[0])
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package vectorfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+// keywordPrefix starts a hybrid query, which also matches its terms
+// literally:
+//
+//	grep 'kw:deploy AND production' /vectorfs/my_project/docs
+const keywordPrefix = "kw:"
+
+// rrfK is the rank constant of reciprocal rank fusion. Larger values flatten
+// the advantage of top ranks; 60 is the value from the original paper.
+const rrfK = 60
+
+// keywordCandidateFactor is how many candidates each half of a hybrid search
+// fetches per requested result, so that fusion has overlap to work with
+const keywordCandidateFactor = 3
+
+// KeywordQuery is a keyword expression in disjunctive normal form: a chunk
+// matches when it contains every term of at least one group. Terms are
+// lower-cased and matched case-insensitively.
+type KeywordQuery [][]string
+
+// parseHybridQuery splits a search query into the text to embed and, for
+// queries starting with "kw:", the keyword expression. The expression is
+// made of terms or "quoted phrases" joined by AND (the default between
+// adjacent terms) and OR, with AND binding tighter.
+func parseHybridQuery(query string) (text string, keywords KeywordQuery, err error) {
+	expr, hybrid := strings.CutPrefix(strings.TrimSpace(query), keywordPrefix)
+	if !hybrid {
+		return query, nil, nil
+	}
+
+	tokens, err := tokenizeKeywords(expr)
+	if err != nil {
+		return "", nil, err
+	}
+	var group, terms []string
+	expectTerm := true
+	for _, tok := range tokens {
+		switch {
+		case !tok.quoted && tok.text == "AND":
+			if expectTerm {
+				return "", nil, fmt.Errorf("invalid keyword query %q: AND without a term before it", expr)
+			}
+			expectTerm = true
+		case !tok.quoted && tok.text == "OR":
+			if expectTerm {
+				return "", nil, fmt.Errorf("invalid keyword query %q: OR without a term before it", expr)
+			}
+			keywords = append(keywords, group)
+			group = nil
+			expectTerm = true
+		default:
+			group = append(group, strings.ToLower(tok.text))
+			terms = append(terms, tok.text)
+			expectTerm = false
+		}
+	}
+	if expectTerm {
+		return "", nil, fmt.Errorf("invalid keyword query %q: expected a term at the end", expr)
+	}
+	keywords = append(keywords, group)
+	return strings.Join(terms, " "), keywords, nil
+}
+
+type keywordToken struct {
+	text   string
+	quoted bool
+}
+
+// tokenizeKeywords splits a keyword expression on spaces, keeping quoted
+// phrases together
+func tokenizeKeywords(expr string) ([]keywordToken, error) {
+	var tokens []keywordToken
+	for {
+		expr = strings.TrimLeft(expr, " \t")
+		if expr == "" {
+			return tokens, nil
+		}
+		if expr[0] == '"' {
+			phrase, rest, found := strings.Cut(expr[1:], `"`)
+			if !found {
+				return nil, fmt.Errorf("invalid keyword query: unterminated quote")
+			}
+			if phrase != "" {
+				tokens = append(tokens, keywordToken{text: phrase, quoted: true})
+			}
+			expr = rest
+			continue
+		}
+		end := strings.IndexAny(expr, " \t")
+		if end < 0 {
+			end = len(expr)
+		}
+		tokens = append(tokens, keywordToken{text: expr[:end]})
+		expr = expr[end:]
+	}
+}
+
+// terms returns the distinct terms of the expression
+func (q KeywordQuery) terms() []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, group := range q {
+		for _, term := range group {
+			if !seen[term] {
+				seen[term] = true
+				terms = append(terms, term)
+			}
+		}
+	}
+	return terms
+}
+
+// hits counts the occurrences of the expression's terms in text
+func (q KeywordQuery) hits(text string) int {
+	text = strings.ToLower(text)
+	n := 0
+	for _, term := range q.terms() {
+		n += strings.Count(text, term)
+	}
+	return n
+}
+
+// hybridSearch searches a namespace by vector similarity and by keywords and
+// fuses the two rankings. minScore applies to the vector ranking only, as
+// fused scores are not comparable to similarities: weak semantic matches
+// don't count, while keyword matches always do.
+func (vfs *vectorFS) hybridSearch(namespace string, queryEmbedding []float32, keywords KeywordQuery, filter MetadataFilter, minScore float64, limit int) ([]mountablefs.CustomGrepResult, error) {
+	candidates := limit * keywordCandidateFactor
+	ranking := vfs.plugin.ranking
+	vectorMatches, err := vfs.plugin.store.VectorSearch(namespace, queryEmbedding, filter, ranking.candidateLimit(candidates))
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform vector search: %w", err)
+	}
+	keywordMatches, err := vfs.plugin.store.KeywordSearch(namespace, keywords, filter, candidates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform keyword search: %w", err)
+	}
+	var ranked []RankedMatch
+	for _, m := range ranking.Rank(vectorMatches, candidates, time.Now()) {
+		if m.Score >= minScore {
+			ranked = append(ranked, m)
+		}
+	}
+	return fuseResults(namespace, ranked, keywordMatches, keywords, limit), nil
+}
+
+// fuseResults combines vector and keyword rankings with reciprocal rank
+// fusion: each chunk scores the sum of 1/(rrfK + rank) over the rankings it
+// appears in. Keyword matches are ranked by how often the terms occur.
+func fuseResults(namespace string, vector []RankedMatch, keyword []VectorMatch, keywords KeywordQuery, limit int) []mountablefs.CustomGrepResult {
+	type fused struct {
+		match    VectorMatch
+		metadata map[string]interface{}
+		score    float64
+	}
+	byChunk := make(map[string]*fused)
+	var order []*fused
+	entry := func(m VectorMatch) *fused {
+		key := fmt.Sprintf("%s/%d", m.FileDigest, m.ChunkIndex)
+		f, ok := byChunk[key]
+		if !ok {
+			f = &fused{match: m, metadata: make(map[string]interface{})}
+			byChunk[key] = f
+			order = append(order, f)
+		}
+		return f
+	}
+
+	for i, m := range vector {
+		f := entry(m.VectorMatch)
+		f.score += 1.0 / float64(rrfK+i+1)
+		f.metadata["vector_rank"] = i + 1
+		f.metadata["distance"] = m.Distance
+	}
+
+	hits := make([]int, len(keyword))
+	for i, m := range keyword {
+		hits[i] = keywords.hits(m.ChunkText)
+	}
+	ranked := make([]int, len(keyword))
+	for i := range ranked {
+		ranked[i] = i
+	}
+	sort.SliceStable(ranked, func(a, b int) bool { return hits[ranked[a]] > hits[ranked[b]] })
+	for rank, i := range ranked {
+		f := entry(keyword[i])
+		f.score += 1.0 / float64(rrfK+rank+1)
+		f.metadata["keyword_rank"] = rank + 1
+		f.metadata["keyword_hits"] = hits[i]
+	}
+
+	sort.SliceStable(order, func(a, b int) bool { return order[a].score > order[b].score })
+	if limit > 0 && len(order) > limit {
+		order = order[:limit]
+	}
+	results := make([]mountablefs.CustomGrepResult, 0, len(order))
+	for _, f := range order {
+		f.metadata["score"] = f.score
+		if len(f.match.Metadata) > 0 {
+			f.metadata["meta"] = resultMetadata(f.match.Metadata)
+		}
+		results = append(results, mountablefs.CustomGrepResult{
+			File:     namespace + "/docs/" + f.match.FileName,
+			Line:     f.match.ChunkIndex + 1,
+			Content:  f.match.ChunkText,
+			Metadata: f.metadata,
+		})
+	}
+	return results
+}
@@ -0,0 +1,175 @@
+package vectorfs
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// Image providers accepted by the "image_provider" config key
+const (
+	// ImageProviderTesseract runs an OCR command, tesseract by default
+	ImageProviderTesseract = "tesseract"
+	// ImageProviderOpenAI asks a vision model behind an OpenAI-compatible
+	// chat API (OpenAI, Ollama, vLLM...) to transcribe and caption images
+	ImageProviderOpenAI = "openai"
+)
+
+// imageProviders lists the valid image_provider values
+var imageProviders = []string{ImageProviderTesseract, ImageProviderOpenAI}
+
+const (
+	defaultTesseractCommand = "tesseract stdin stdout"
+	defaultVisionModel      = "gpt-4o-mini"
+
+	// defaultImagePrompt asks a vision model for text that is worth
+	// searching: what the image says, then what it shows
+	defaultImagePrompt = `Transcribe all text visible in this image verbatim, keeping its line breaks. ` +
+		`Then describe what the image shows in one or two sentences. Reply with plain text only.`
+
+	// visionTimeout bounds a vision model call, which is slow for large images
+	visionTimeout = 2 * time.Minute
+)
+
+// Image formats converted to text, by extension and sniffed MIME type
+var (
+	imageExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".webp", ".bmp", ".tif", ".tiff"}
+	imageMIMETypes  = []string{"image/png", "image/jpeg", "image/gif", "image/webp", "image/bmp"}
+)
+
+// errExtractorUnavailable marks extraction failures of an unavailable
+// provider. Unlike errExtractionFailed, retrying may help, so the document
+// stays pending.
+var errExtractorUnavailable = errors.New("extractor unavailable")
+
+// ImageConfig holds the configuration of image OCR and captioning
+type ImageConfig struct {
+	Provider string // Provider name; empty leaves images unindexed
+	Command  string // OCR command for tesseract (image on stdin, text on stdout)
+	APIKey   string // API key; optional for a custom endpoint
+	Model    string // Vision model name
+	Endpoint string // Chat completions endpoint (empty = OpenAI)
+	Prompt   string // Instructions given to the vision model
+}
+
+// parseImageConfig reads the optional image settings. The OpenAI key of the
+// embedding configuration is reused when image_api_key is unset.
+func parseImageConfig(cfg map[string]interface{}) (ImageConfig, error) {
+	ic := ImageConfig{
+		Provider: config.GetStringConfig(cfg, "image_provider", ""),
+		Command:  config.GetStringConfig(cfg, "image_command", defaultTesseractCommand),
+		APIKey:   config.GetStringConfig(cfg, "image_api_key", ""),
+		Model:    config.GetStringConfig(cfg, "image_model", defaultVisionModel),
+		Endpoint: config.GetStringConfig(cfg, "image_endpoint", ""),
+		Prompt:   config.GetStringConfig(cfg, "image_prompt", defaultImagePrompt),
+	}
+	if ic.APIKey == "" && ic.Endpoint == "" {
+		ic.APIKey = config.GetStringConfig(cfg, "openai_api_key", "")
+	}
+	return ic, ic.Validate()
+}
+
+// Validate checks the image configuration
+func (ic ImageConfig) Validate() error {
+	switch ic.Provider {
+	case "":
+	case ImageProviderTesseract:
+		if len(strings.Fields(ic.Command)) == 0 {
+			return fmt.Errorf("image_command must not be empty when image_provider is tesseract")
+		}
+	case ImageProviderOpenAI:
+		if ic.APIKey == "" && ic.Endpoint == "" {
+			return fmt.Errorf("image_api_key or openai_api_key is required when image_provider is openai")
+		}
+	default:
+		return fmt.Errorf("unsupported image_provider: %s (supported: %s)", ic.Provider, strings.Join(imageProviders, ", "))
+	}
+	return nil
+}
+
+// newImageExtractor creates the extractor of a configuration, or nil if
+// images are not converted
+func newImageExtractor(ic ImageConfig) Extractor {
+	switch ic.Provider {
+	case ImageProviderTesseract:
+		return commandExtractor{args: strings.Fields(ic.Command)}
+	case ImageProviderOpenAI:
+		endpoint := ic.Endpoint
+		if endpoint == "" {
+			endpoint = defaultOpenAIChatEndpoint
+		}
+		return &visionExtractor{
+			apiKey:   ic.APIKey,
+			model:    ic.Model,
+			endpoint: endpoint,
+			prompt:   ic.Prompt,
+			client:   &http.Client{Timeout: visionTimeout},
+		}
+	}
+	return nil
+}
+
+type visionContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *visionImageURL `json:"image_url,omitempty"`
+}
+
+type visionImageURL struct {
+	URL string `json:"url"`
+}
+
+type visionMessage struct {
+	Role    string              `json:"role"`
+	Content []visionContentPart `json:"content"`
+}
+
+type visionChatRequest struct {
+	Model       string          `json:"model"`
+	Messages    []visionMessage `json:"messages"`
+	Temperature float64         `json:"temperature"`
+}
+
+// visionExtractor transcribes and captions images with a vision model
+type visionExtractor struct {
+	apiKey   string
+	model    string
+	endpoint string
+	prompt   string
+	client   *http.Client
+}
+
+func (e *visionExtractor) Name() string { return "vision" }
+
+// Extract sends the image as a data URL, typed by its sniffed content
+func (e *visionExtractor) Extract(data []byte) (string, error) {
+	request := visionChatRequest{
+		Model: e.model,
+		Messages: []visionMessage{{
+			Role: "user",
+			Content: []visionContentPart{
+				{Type: "text", Text: e.prompt},
+				{Type: "image_url", ImageURL: &visionImageURL{
+					URL: "data:" + sniffMIME(data) + ";base64," + base64.StdEncoding.EncodeToString(data),
+				}},
+			},
+		}},
+	}
+
+	var response openAIChatResponse
+	if err := postJSON(e.client, e.endpoint, e.apiKey, "vision", request, &response); err != nil {
+		if isProviderFailure(err) {
+			return "", fmt.Errorf("%w: %v", errExtractorUnavailable, err)
+		}
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("no completion returned from API")
+	}
+	return strings.TrimSpace(response.Choices[0].Message.Content), nil
+}
@@ -0,0 +1,81 @@
+package vectorfs
+
+import (
+	"crypto/sha256"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// chunkEmbeddings holds the embeddings of the previous version of a
+// rewritten document by chunk text, so that indexing the new version only
+// embeds the chunks that changed. Re-uploading a long document after a
+// small edit then costs a few embeddings instead of all of them.
+type chunkEmbeddings struct {
+	model   string // Embedding model of the namespace when they were read
+	vectors map[[sha256.Size]byte][]float32
+}
+
+// previousEmbeddings reads the chunks of the version of a document about to
+// be replaced. They must be read before the write deletes them; a failure
+// only means every chunk of the new version is embedded.
+func (idx *Indexer) previousEmbeddings(namespace, digest string) *chunkEmbeddings {
+	chunks, err := idx.store.ListChunks(namespace, digest)
+	if err != nil {
+		log.Warnf("[vectorfs/indexer] Failed to read chunks of %s, re-embedding all: %v", digest, err)
+		return nil
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+	previous := &chunkEmbeddings{
+		model:   idx.embedder.Model(namespace),
+		vectors: make(map[[sha256.Size]byte][]float32, len(chunks)),
+	}
+	for _, c := range chunks {
+		if len(c.Embedding) > 0 {
+			previous.vectors[sha256.Sum256([]byte(c.ChunkText))] = c.Embedding
+		}
+	}
+	return previous
+}
+
+// embedChunks returns the embeddings of chunks, reusing those of previous
+// for unchanged chunks and embedding the others in one batch. Previous
+// embeddings of another model than the namespace's current one are not
+// reused. It also returns how many were reused.
+func (idx *Indexer) embedChunks(namespace string, chunks []Chunk, previous *chunkEmbeddings) ([][]float32, int, error) {
+	if previous != nil && previous.model != idx.embedder.Model(namespace) {
+		previous = nil
+	}
+
+	embeddings := make([][]float32, len(chunks))
+	var changed []int
+	var texts []string
+	for i, chunk := range chunks {
+		if previous != nil {
+			if vector, ok := previous.vectors[sha256.Sum256([]byte(chunk.Text))]; ok {
+				embeddings[i] = vector
+				continue
+			}
+		}
+		changed = append(changed, i)
+		texts = append(texts, chunk.Text)
+	}
+
+	reused := len(chunks) - len(changed)
+	if len(texts) > 0 {
+		vectors, err := idx.embedder.GenerateBatchEmbeddings(namespace, texts)
+		if err != nil {
+			return nil, reused, err
+		}
+		for j, i := range changed {
+			embeddings[i] = vectors[j]
+		}
+	}
+	if reused > 0 {
+		idx.embedder.recordUsage(namespace, func(u *embeddingUsage) {
+			u.ReusedChunks += int64(reused)
+		})
+	}
+	return embeddings, reused, nil
+}
@@ -2,40 +2,48 @@ package vectorfs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	log "github.com/sirupsen/logrus"
 )
 
 // Indexer handles document indexing
 type Indexer struct {
-	s3Client        *S3Client
-	tidbClient      *TiDBClient
-	embeddingClient *EmbeddingClient
-	chunkerConfig   ChunkerConfig
+	docs          DocumentStore
+	store         VectorStore
+	embedder      *EmbeddingRouter
+	chunkerConfig ChunkerConfig // Chunking of namespaces without overrides
+	extractors    *ExtractorRegistry
+	quota         NamespaceQuota // Configured limits of namespaces without overrides
 }
 
 // NewIndexer creates a new indexer
 func NewIndexer(
-	s3Client *S3Client,
-	tidbClient *TiDBClient,
-	embeddingClient *EmbeddingClient,
+	docs DocumentStore,
+	store VectorStore,
+	embedder *EmbeddingRouter,
 	chunkerConfig ChunkerConfig,
+	extractors *ExtractorRegistry,
+	quota NamespaceQuota,
 ) *Indexer {
 	return &Indexer{
-		s3Client:        s3Client,
-		tidbClient:      tidbClient,
-		embeddingClient: embeddingClient,
-		chunkerConfig:   chunkerConfig,
+		docs:          docs,
+		store:         store,
+		embedder:      embedder,
+		chunkerConfig: chunkerConfig,
+		extractors:    extractors,
+		quota:         quota,
 	}
 }
 
 // PrepareDocument uploads document to S3 and registers metadata in TiDB (synchronous phase).
 // After this completes, the file is visible via ls/cat.
 // Returns (alreadyExists, error) - if alreadyExists is true, no further indexing is needed.
-func (idx *Indexer) PrepareDocument(namespace, digest, fileName, content string) (bool, error) {
+func (idx *Indexer) PrepareDocument(namespace, digest, fileName string, data []byte) (bool, error) {
 	ctx := context.Background()
 
 	log.Infof("[vectorfs/indexer] Preparing document: %s (namespace: %s, digest: %s)",
@@ -43,16 +51,16 @@ func (idx *Indexer) PrepareDocument(namespace, digest, fileName, content string)
 
 	// Check if content already indexed (same digest = same content)
 	// If so, skip S3 upload but still create file metadata for the new filename
-	contentExists, err := idx.tidbClient.FileExists(namespace, digest)
+	contentExists, err := idx.store.FileExists(namespace, digest)
 	if err != nil {
 		return false, fmt.Errorf("failed to check if file exists: %w", err)
 	}
 
-	s3Key := idx.s3Client.buildKey(namespace, digest)
+	s3Key := idx.docs.buildKey(namespace, digest)
 
 	if !contentExists {
 		// Upload to S3 only if content doesn't exist
-		err = idx.s3Client.UploadDocument(ctx, namespace, digest, []byte(content))
+		err = idx.docs.UploadDocument(ctx, namespace, digest, data)
 		if err != nil {
 			return false, fmt.Errorf("failed to upload to S3: %w", err)
 		}
@@ -68,12 +76,12 @@ func (idx *Indexer) PrepareDocument(namespace, digest, fileName, content string)
 		FileDigest: digest,
 		FileName:   fileName,
 		S3Key:      s3Key,
-		FileSize:   int64(len(content)),
+		FileSize:   int64(len(data)),
 		CreatedAt:  now,
 		UpdatedAt:  now,
 	}
 
-	err = idx.tidbClient.InsertFileMetadata(namespace, metadata)
+	err = idx.store.InsertFileMetadata(namespace, metadata)
 	if err != nil {
 		return false, fmt.Errorf("failed to insert file metadata: %w", err)
 	}
@@ -83,12 +91,34 @@ func (idx *Indexer) PrepareDocument(namespace, digest, fileName, content string)
 	return contentExists, nil
 }
 
-// IndexChunks performs chunking, embedding generation, and stores chunks in TiDB (async phase).
-// This is called after PrepareDocument to enable vector search on the document.
-func (idx *Indexer) IndexChunks(namespace, digest, fileName, content string) error {
+// IndexChunks performs text extraction, chunking, embedding generation, and stores chunks in TiDB (async phase).
+// This is called after PrepareDocument to enable vector search on the document,
+// and again to re-index it; the new chunks replace any stored ones.
+// previous, if not nil, holds the embeddings of the version of the document
+// it replaces, which unchanged chunks reuse.
+func (idx *Indexer) IndexChunks(namespace, digest, fileName, content string, previous *chunkEmbeddings) error {
 	log.Infof("[vectorfs/indexer] Indexing chunks for document: %s (namespace: %s, digest: %s)",
 		fileName, namespace, digest)
 
+	chunkerConfig := idx.chunkerConfigFor(namespace)
+
+	// The markdown strategy splits on the headings the markdown extractor
+	// strips, and cleans up each section itself
+	_, isMarkdown := idx.selectExtractor(fileName, []byte(content)).(markdownExtractor)
+	if !isMarkdown || chunkerConfig.strategyFor(fileName) != ChunkStrategyMarkdown {
+		var err error
+		content, err = idx.ExtractText(fileName, []byte(content))
+		if err != nil {
+			return err
+		}
+	} else {
+		content = decodedText([]byte(content))
+	}
+
+	// Normalize the text and chunk it by the rules of its language
+	content = normalizeText(content)
+	chunkerConfig.Language = detectLanguage(content)
+
 	// Skip empty files - they have no content to index
 	if strings.TrimSpace(content) == "" {
 		log.Infof("[vectorfs/indexer] Skipping empty file: %s", fileName)
@@ -96,20 +126,25 @@ func (idx *Indexer) IndexChunks(namespace, digest, fileName, content string) err
 	}
 
 	// Chunk the document
-	chunks := ChunkDocument(content, idx.chunkerConfig)
+	chunks := ChunkFile(fileName, content, chunkerConfig)
 	log.Infof("[vectorfs/indexer] Split into %d chunks", len(chunks))
 
-	// Generate embeddings for all chunks (batch)
-	var chunkTexts []string
-	for _, chunk := range chunks {
-		chunkTexts = append(chunkTexts, chunk.Text)
+	// Refuse before paying for the embeddings
+	if err := idx.checkChunkQuota(namespace, digest, len(chunks)); err != nil {
+		return err
 	}
 
-	embeddings, err := idx.embeddingClient.GenerateBatchEmbeddings(chunkTexts)
+	// Generate embeddings for the chunks that changed (batch)
+	embeddings, reused, err := idx.embedChunks(namespace, chunks, previous)
 	if err != nil {
-		return fmt.Errorf("failed to generate embeddings: %w", err)
+		return fmt.Errorf("%w: %w", errEmbeddingFailed, err)
+	}
+	if reused > 0 {
+		log.Infof("[vectorfs/indexer] Reused the embeddings of %d unchanged chunks of %s", reused, fileName)
 	}
 
+	metadata := withLanguage(idx.loadMetadata(namespace, fileName), chunkerConfig.Language)
+
 	// Prepare chunk data for batch insert
 	chunkDataList := make([]ChunkData, len(chunks))
 	for i, chunk := range chunks {
@@ -117,11 +152,27 @@ func (idx *Indexer) IndexChunks(namespace, digest, fileName, content string) err
 			ChunkIndex: chunk.Index,
 			ChunkText:  chunk.Text,
 			Embedding:  embeddings[i],
+			Metadata:   metadata,
 		}
 	}
 
+	// The document may have been removed while it was being embedded
+	exists, err := idx.store.FileExists(namespace, digest)
+	if err != nil {
+		return fmt.Errorf("failed to check if file exists: %w", err)
+	}
+	if !exists {
+		log.Infof("[vectorfs/indexer] Document removed before indexing finished: %s", fileName)
+		return nil
+	}
+
+	// Replace the chunks of an earlier indexing run
+	if err := idx.store.DeleteFileChunks(namespace, digest); err != nil {
+		return fmt.Errorf("failed to delete old chunks: %w", err)
+	}
+
 	// Batch insert all chunks (reduces N database round-trips to 1-2)
-	err = idx.tidbClient.InsertChunksBatch(namespace, digest, chunkDataList)
+	err = idx.store.InsertChunksBatch(namespace, digest, chunkDataList)
 	if err != nil {
 		return fmt.Errorf("failed to batch insert chunks: %w", err)
 	}
@@ -131,18 +182,108 @@ func (idx *Indexer) IndexChunks(namespace, digest, fileName, content string) err
 	return nil
 }
 
+// chunkerConfigFor returns the chunking settings of a namespace. If its
+// overrides can't be read, the configured chunking is used.
+func (idx *Indexer) chunkerConfigFor(namespace string) ChunkerConfig {
+	cfg, err := idx.store.NamespaceConfig(namespace)
+	if err != nil {
+		log.Warnf("[vectorfs/indexer] Failed to read config of %s, using the plugin config: %v", namespace, err)
+	}
+	return cfg.chunker(idx.chunkerConfig)
+}
+
+// loadMetadata reads the metadata sidecar of a document. A missing or
+// unreadable sidecar leaves the document without metadata.
+func (idx *Indexer) loadMetadata(namespace, fileName string) DocMetadata {
+	sidecar, err := idx.store.GetFileMetadataByName(namespace, fileName+metadataSidecarSuffix)
+	if err != nil {
+		return nil
+	}
+	data, err := idx.docs.DownloadDocument(context.Background(), namespace, sidecar.FileDigest)
+	if err != nil {
+		log.Warnf("[vectorfs/indexer] Failed to read metadata of %s: %v", fileName, err)
+		return nil
+	}
+	metadata, err := parseDocMetadata(data)
+	if err != nil {
+		log.Warnf("[vectorfs/indexer] Ignoring invalid metadata of %s: %v", fileName, err)
+		return nil
+	}
+	return metadata
+}
+
+// CanIndex reports whether a document can be indexed: it has an extractor
+// or is text already
+func (idx *Indexer) CanIndex(fileName string, data []byte) bool {
+	return idx.selectExtractor(fileName, data) != nil || isIndexableText(data)
+}
+
+// ExtractText converts a document to the text that is chunked. Documents
+// without an extractor are decoded to UTF-8 (see decodeText).
+func (idx *Indexer) ExtractText(fileName string, data []byte) (string, error) {
+	e := idx.selectExtractor(fileName, data)
+	if e == nil {
+		text, encoding, ok := decodeText(data)
+		if !ok {
+			return string(data), nil
+		}
+		if encoding != encodingUTF8 {
+			log.Debugf("[vectorfs/indexer] Decoded %s from %s", fileName, encoding)
+		}
+		return text, nil
+	}
+	text, err := e.Extract(data)
+	if errors.Is(err, errExtractorUnavailable) {
+		return "", fmt.Errorf("%s extractor: %w", e.Name(), err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %s extractor: %v", errExtractionFailed, e.Name(), err)
+	}
+	if !utf8.ValidString(text) {
+		text = strings.ToValidUTF8(text, "")
+	}
+	log.Debugf("[vectorfs/indexer] Extracted %d bytes of text from %s with the %s extractor",
+		len(text), fileName, e.Name())
+	return text, nil
+}
+
+// selectExtractor returns the extractor of a document, or nil if it has none
+func (idx *Indexer) selectExtractor(fileName string, data []byte) Extractor {
+	if idx.extractors == nil {
+		return nil
+	}
+	return idx.extractors.Select(fileName, data)
+}
+
+// isIndexableText reports whether document content is text that can be
+// chunked and embedded, in any encoding decodeText reads. Binary content
+// is stored and read back unchanged, but not indexed.
+func isIndexableText(data []byte) bool {
+	_, _, ok := decodeText(data)
+	return ok
+}
+
+// decodedText returns document content as UTF-8 text; content that is not
+// text is returned as it is
+func decodedText(data []byte) string {
+	if text, _, ok := decodeText(data); ok {
+		return text
+	}
+	return string(data)
+}
+
 // IndexDocument indexes a document (upload to S3, chunk, generate embeddings, store in TiDB)
 // Deprecated: Use PrepareDocument + IndexChunks for better performance.
 // This method is kept for backward compatibility.
 func (idx *Indexer) IndexDocument(namespace, digest, fileName, content string) error {
-	alreadyExists, err := idx.PrepareDocument(namespace, digest, fileName, content)
+	alreadyExists, err := idx.PrepareDocument(namespace, digest, fileName, []byte(content))
 	if err != nil {
 		return err
 	}
 	if alreadyExists {
 		return nil
 	}
-	return idx.IndexChunks(namespace, digest, fileName, content)
+	return idx.IndexChunks(namespace, digest, fileName, content, nil)
 }
 
 // DeleteDocument removes a document from the index
@@ -150,17 +291,17 @@ func (idx *Indexer) DeleteDocument(namespace, digest string) error {
 	ctx := context.Background()
 
 	// Delete chunks from TiDB
-	if err := idx.tidbClient.DeleteFileChunks(namespace, digest); err != nil {
+	if err := idx.store.DeleteFileChunks(namespace, digest); err != nil {
 		return fmt.Errorf("failed to delete chunks: %w", err)
 	}
 
 	// Delete metadata from TiDB
-	if err := idx.tidbClient.DeleteFileMetadata(namespace, digest); err != nil {
+	if err := idx.store.DeleteFileMetadata(namespace, digest); err != nil {
 		return fmt.Errorf("failed to delete metadata: %w", err)
 	}
 
 	// Delete from S3
-	if err := idx.s3Client.DeleteDocument(ctx, namespace, digest); err != nil {
+	if err := idx.docs.DeleteDocument(ctx, namespace, digest); err != nil {
 		return fmt.Errorf("failed to delete from S3: %w", err)
 	}
 
@@ -0,0 +1,364 @@
+package vectorfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// indexingStatusDir is the virtual directory of a namespace holding the
+// indexing state of each document: .indexing.d/<file relative to docs/>
+const indexingStatusDir = ".indexing.d"
+
+// Indexing states of a document
+const (
+	indexStateQueued    = "queued"    // Waiting for an index worker
+	indexStateEmbedding = "embedding" // Being chunked, embedded and stored
+	indexStateStored    = "stored"    // Searchable
+	indexStateFailed    = "failed"    // Not searchable; see Error
+)
+
+// indexingHistoryLimit bounds the finished documents remembered per
+// namespace; the oldest are forgotten first
+const indexingHistoryLimit = 1000
+
+// indexingSummaryFiles bounds the pending documents listed in .indexing
+const indexingSummaryFiles = 20
+
+// indexingFileInfo tracks the indexing of a document
+type indexingFileInfo struct {
+	FileName  string
+	Digest    string
+	State     string
+	StartTime time.Time // When the document was queued
+	WorkStart time.Time // When an index worker picked it up
+	UpdatedAt time.Time // Last state change
+	Error     string    // Why indexing failed
+	err       error     // The error itself, for writers waiting on it
+}
+
+func (info *indexingFileInfo) pending() bool {
+	return info.State == indexStateQueued || info.State == indexStateEmbedding
+}
+
+// addIndexingTask registers a document as queued for indexing, replacing
+// the state of its earlier content
+func (v *VectorFSPlugin) addIndexingTask(namespace, digest, fileName string) {
+	v.indexingStatusMu.Lock()
+	defer v.indexingStatusMu.Unlock()
+
+	if v.indexingStatus[namespace] == nil {
+		v.indexingStatus[namespace] = make(map[string]*indexingFileInfo)
+	}
+	tasks := v.indexingStatus[namespace]
+	for d, info := range tasks {
+		if info.FileName == fileName && d != digest {
+			delete(tasks, d)
+		}
+	}
+	now := time.Now()
+	tasks[digest] = &indexingFileInfo{
+		FileName:  fileName,
+		Digest:    digest,
+		State:     indexStateQueued,
+		StartTime: now,
+		UpdatedAt: now,
+	}
+}
+
+// startIndexingTask marks a document as being embedded
+func (v *VectorFSPlugin) startIndexingTask(namespace, digest string) {
+	v.indexingStatusMu.Lock()
+	defer v.indexingStatusMu.Unlock()
+
+	if info := v.indexingStatus[namespace][digest]; info != nil {
+		info.State = indexStateEmbedding
+		info.UpdatedAt = time.Now()
+		info.WorkStart = info.UpdatedAt
+	}
+}
+
+// requeueIndexingTask puts a document back in the queue while indexing is
+// paused, waking its writers with errIndexingPaused
+func (v *VectorFSPlugin) requeueIndexingTask(namespace, digest string) {
+	v.indexingStatusMu.Lock()
+	defer v.indexingStatusMu.Unlock()
+
+	if info := v.indexingStatus[namespace][digest]; info != nil {
+		info.State = indexStateQueued
+		info.UpdatedAt = time.Now()
+	}
+	v.notifyIndexWaiters(namespace, digest, errIndexingPaused)
+}
+
+// finishIndexingTask records the outcome of indexing a document
+func (v *VectorFSPlugin) finishIndexingTask(namespace, digest string, err error) {
+	v.indexingStatusMu.Lock()
+	defer v.indexingStatusMu.Unlock()
+
+	tasks := v.indexingStatus[namespace]
+	info := tasks[digest]
+	if info == nil {
+		return
+	}
+	info.UpdatedAt = time.Now()
+	if err != nil {
+		info.State = indexStateFailed
+		info.Error = err.Error()
+	} else {
+		info.State = indexStateStored
+		info.Error = ""
+		v.recordIndexLatency(namespace, info)
+	}
+	info.err = err
+	v.notifyIndexWaiters(namespace, digest, err)
+
+	// Forget the oldest finished documents beyond the limit
+	var finished []*indexingFileInfo
+	for _, info := range tasks {
+		if !info.pending() {
+			finished = append(finished, info)
+		}
+	}
+	if over := len(finished) - indexingHistoryLimit; over > 0 {
+		sort.Slice(finished, func(i, j int) bool { return finished[i].UpdatedAt.Before(finished[j].UpdatedAt) })
+		for _, info := range finished[:over] {
+			delete(tasks, info.Digest)
+		}
+	}
+}
+
+// removeIndexingTask forgets a document, e.g. when it was removed or its
+// task was dropped at shutdown
+func (v *VectorFSPlugin) removeIndexingTask(namespace, digest string) {
+	v.indexingStatusMu.Lock()
+	defer v.indexingStatusMu.Unlock()
+
+	if v.indexingStatus[namespace] != nil {
+		delete(v.indexingStatus[namespace], digest)
+		if len(v.indexingStatus[namespace]) == 0 {
+			delete(v.indexingStatus, namespace)
+		}
+	}
+	v.notifyIndexWaiters(namespace, digest, errIndexingAbandoned)
+}
+
+// clearIndexingStatus forgets every document of a namespace
+func (v *VectorFSPlugin) clearIndexingStatus(namespace string) {
+	v.indexingStatusMu.Lock()
+	defer v.indexingStatusMu.Unlock()
+	delete(v.indexingStatus, namespace)
+	delete(v.lastIndexed, namespace)
+	for digest := range v.indexWaiters[namespace] {
+		v.notifyIndexWaiters(namespace, digest, filesystem.NewNotFoundError("index", namespace))
+	}
+}
+
+// pendingIndexing returns how many documents of a namespace are queued or
+// being embedded
+func (v *VectorFSPlugin) pendingIndexing(namespace string) int {
+	v.indexingStatusMu.RLock()
+	defer v.indexingStatusMu.RUnlock()
+
+	pending := 0
+	for _, info := range v.indexingStatus[namespace] {
+		if info.pending() {
+			pending++
+		}
+	}
+	return pending
+}
+
+// getIndexingStatus returns the indexing summary of a namespace. Its first
+// line is "idle" once every document written so far is searchable or
+// failed, so that `head -1 .indexing` tells whether search is consistent.
+func (v *VectorFSPlugin) getIndexingStatus(namespace string) string {
+	v.indexingStatusMu.RLock()
+	defer v.indexingStatusMu.RUnlock()
+
+	tasks := v.indexingStatus[namespace]
+	if len(tasks) == 0 {
+		return "idle"
+	}
+
+	counts := make(map[string]int)
+	var pending []*indexingFileInfo
+	var lastError *indexingFileInfo
+	for _, info := range tasks {
+		counts[info.State]++
+		if info.pending() {
+			pending = append(pending, info)
+		}
+		if info.State == indexStateFailed && (lastError == nil || info.UpdatedAt.After(lastError.UpdatedAt)) {
+			lastError = info
+		}
+	}
+	queueDepth := 0
+	for _, nsTasks := range v.indexingStatus {
+		for _, info := range nsTasks {
+			if info.State == indexStateQueued {
+				queueDepth++
+			}
+		}
+	}
+
+	var sb strings.Builder
+	if len(pending) == 0 {
+		sb.WriteString("idle\n")
+	} else {
+		sb.WriteString(fmt.Sprintf("indexing %d file(s): %d queued, %d embedding\n",
+			len(pending), counts[indexStateQueued], counts[indexStateEmbedding]))
+	}
+	sb.WriteString(fmt.Sprintf("queue depth: %d (all namespaces)\n", queueDepth))
+	if paused, retryAt := v.indexPause.isPaused(); paused {
+		sb.WriteString(fmt.Sprintf("paused: embedding provider unavailable, retrying in %v\n",
+			max(time.Until(retryAt), 0).Round(time.Second)))
+	}
+	sb.WriteString(fmt.Sprintf("stored: %d\n", counts[indexStateStored]))
+	sb.WriteString(fmt.Sprintf("failed: %d\n", counts[indexStateFailed]))
+	if hits, misses, ok := v.embedder.cacheStats(); ok {
+		sb.WriteString(fmt.Sprintf("embedding cache: %d hit(s), %d miss(es) (all namespaces)\n", hits, misses))
+	}
+	if lastError != nil {
+		sb.WriteString(fmt.Sprintf("last error: %s: %s (%s)\n",
+			lastError.FileName, lastError.Error, lastError.UpdatedAt.Format(time.RFC3339)))
+	}
+
+	// Embedding first, then the longest waiting
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].State != pending[j].State {
+			return pending[i].State == indexStateEmbedding
+		}
+		return pending[i].StartTime.Before(pending[j].StartTime)
+	})
+	for i, info := range pending {
+		if i == indexingSummaryFiles {
+			sb.WriteString(fmt.Sprintf("  ... and %d more\n", len(pending)-i))
+			break
+		}
+		elapsed := time.Since(info.StartTime).Round(time.Second)
+		sb.WriteString(fmt.Sprintf("  - %s (%s, %v)\n", info.FileName, info.State, elapsed))
+	}
+	return sb.String()
+}
+
+// indexingFiles returns the tracked documents of a namespace whose names
+// start with prefix
+func (v *VectorFSPlugin) indexingFiles(namespace, prefix string) []indexingFileInfo {
+	v.indexingStatusMu.RLock()
+	defer v.indexingStatusMu.RUnlock()
+
+	var files []indexingFileInfo
+	for _, info := range v.indexingStatus[namespace] {
+		if strings.HasPrefix(info.FileName, prefix) {
+			files = append(files, *info)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].FileName < files[j].FileName })
+	return files
+}
+
+// formatIndexingFile renders the content of .indexing.d/<file>
+func formatIndexingFile(info indexingFileInfo) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("file: %s\n", info.FileName))
+	sb.WriteString(fmt.Sprintf("state: %s\n", info.State))
+	sb.WriteString(fmt.Sprintf("digest: %s\n", info.Digest))
+	sb.WriteString(fmt.Sprintf("queued_at: %s\n", info.StartTime.Format(time.RFC3339)))
+	sb.WriteString(fmt.Sprintf("updated_at: %s\n", info.UpdatedAt.Format(time.RFC3339)))
+	if info.Error != "" {
+		sb.WriteString(fmt.Sprintf("error: %s\n", info.Error))
+	}
+	return sb.String()
+}
+
+// indexingEntry resolves a path below .indexing.d: the status of a tracked
+// document, or a directory of them
+func (v *VectorFSPlugin) indexingEntry(namespace, name string) (*indexingFileInfo, bool, error) {
+	if name == "" {
+		return nil, true, nil
+	}
+	for _, info := range v.indexingFiles(namespace, name) {
+		if info.FileName == name {
+			return &info, false, nil
+		}
+		if strings.HasPrefix(info.FileName, name+"/") {
+			return nil, true, nil
+		}
+	}
+	return nil, false, filesystem.ErrNotFound
+}
+
+// statIndexingEntry returns the file info of a path below .indexing.d
+func (vfs *vectorFS) statIndexingEntry(namespace, name string) (*filesystem.FileInfo, error) {
+	info, isDir, err := vfs.plugin.indexingEntry(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if isDir {
+		dirName := indexingStatusDir
+		if name != "" {
+			dirName = filepath.Base(name)
+		}
+		return &filesystem.FileInfo{
+			Name:    dirName,
+			Mode:    0555,
+			ModTime: time.Now(),
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "status"},
+		}, nil
+	}
+	return &filesystem.FileInfo{
+		Name:    filepath.Base(name),
+		Size:    int64(len(formatIndexingFile(*info))),
+		Mode:    0444,
+		ModTime: info.UpdatedAt,
+		IsDir:   false,
+		Meta:    filesystem.MetaData{Name: PluginName, Type: "status"},
+	}, nil
+}
+
+// readIndexingDir lists a directory below .indexing.d
+func (vfs *vectorFS) readIndexingDir(namespace, name string) ([]filesystem.FileInfo, error) {
+	prefix := ""
+	if name != "" {
+		prefix = name + "/"
+	}
+	files := vfs.plugin.indexingFiles(namespace, prefix)
+	if name != "" && len(files) == 0 {
+		return nil, filesystem.ErrNotFound
+	}
+
+	seenDirs := make(map[string]bool)
+	var infos []filesystem.FileInfo
+	for _, f := range files {
+		rest := strings.TrimPrefix(f.FileName, prefix)
+		if idx := strings.Index(rest, "/"); idx != -1 {
+			dirName := rest[:idx]
+			if !seenDirs[dirName] {
+				seenDirs[dirName] = true
+				infos = append(infos, filesystem.FileInfo{
+					Name:    dirName,
+					Mode:    0555,
+					ModTime: time.Now(),
+					IsDir:   true,
+					Meta:    filesystem.MetaData{Name: PluginName, Type: "status"},
+				})
+			}
+			continue
+		}
+		infos = append(infos, filesystem.FileInfo{
+			Name:    rest,
+			Size:    int64(len(formatIndexingFile(f))),
+			Mode:    0444,
+			ModTime: f.UpdatedAt,
+			IsDir:   false,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "status"},
+		})
+	}
+	return infos, nil
+}
@@ -0,0 +1,108 @@
+package vectorfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LocalDocumentStore keeps original documents as files on local disk, laid
+// out like the S3 keys: dir/namespace/digest
+type LocalDocumentStore struct {
+	dir string
+}
+
+// NewLocalDocumentStore creates the document directory if needed
+func NewLocalDocumentStore(dir string) (*LocalDocumentStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create document directory: %w", err)
+	}
+	return &LocalDocumentStore{dir: dir}, nil
+}
+
+func (s *LocalDocumentStore) buildKey(namespace, digest string) string {
+	return filepath.Join(s.dir, namespace, digest)
+}
+
+// UploadDocument writes a document, replacing it atomically if it exists
+func (s *LocalDocumentStore) UploadDocument(ctx context.Context, namespace, digest string, data []byte) error {
+	key := s.buildKey(namespace, digest)
+	if err := os.MkdirAll(filepath.Dir(key), 0755); err != nil {
+		return fmt.Errorf("failed to store document: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(key), "."+digest+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to store document: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to store document: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to store document: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), key); err != nil {
+		return fmt.Errorf("failed to store document: %w", err)
+	}
+
+	log.Debugf("[vectorfs/local] Stored document: %s", key)
+	return nil
+}
+
+// DownloadDocument reads a document
+func (s *LocalDocumentStore) DownloadDocument(ctx context.Context, namespace, digest string) ([]byte, error) {
+	data, err := os.ReadFile(s.buildKey(namespace, digest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document: %w", err)
+	}
+	return data, nil
+}
+
+// DocumentExists checks if a document exists
+func (s *LocalDocumentStore) DocumentExists(ctx context.Context, namespace, digest string) (bool, error) {
+	_, err := os.Stat(s.buildKey(namespace, digest))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ListDocuments lists the documents of a namespace. Temporary files of
+// uploads in progress are left out.
+func (s *LocalDocumentStore) ListDocuments(ctx context.Context, namespace string) ([]StoredDocument, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, namespace))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+	var docs []StoredDocument
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed since listed
+		}
+		docs = append(docs, StoredDocument{Digest: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return docs, nil
+}
+
+// DeleteDocument deletes a document; deleting a missing document succeeds
+func (s *LocalDocumentStore) DeleteDocument(ctx context.Context, namespace, digest string) error {
+	key := s.buildKey(namespace, digest)
+	if err := os.Remove(key); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete document: %w", err)
+	}
+	log.Debugf("[vectorfs/local] Deleted document: %s", key)
+	return nil
+}
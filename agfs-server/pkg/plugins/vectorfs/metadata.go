@@ -0,0 +1,235 @@
+package vectorfs
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// metadataSidecarSuffix marks the metadata file of a document:
+// docs/guide.md.meta.json holds the metadata of docs/guide.md. Sidecars are
+// stored and listed like any document but never indexed.
+const metadataSidecarSuffix = ".meta.json"
+
+// modifierSeparator separates a search query from its modifiers:
+//
+//	grep 'how to deploy -- filter:author=alice,tag=api' /vectorfs/my_project/docs
+//	grep 'how to deploy -- topk:25 minscore:0.7' /vectorfs/my_project/docs
+const modifierSeparator = " -- "
+
+// filterModifier introduces a metadata filter modifier
+const filterModifier = "filter:"
+
+// metadataKeyPattern restricts metadata keys to names that are safe in JSON
+// paths of every backend
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// DocMetadata is the metadata attached to a document's chunks. Every value
+// is normalized to a list of strings so that scalar and list values filter
+// the same way.
+type DocMetadata map[string][]string
+
+// sidecarTarget returns the document a metadata sidecar describes
+func sidecarTarget(fileName string) (string, bool) {
+	target, ok := strings.CutSuffix(fileName, metadataSidecarSuffix)
+	if !ok || target == "" || strings.HasSuffix(target, "/") {
+		return "", false
+	}
+	return target, true
+}
+
+// parseDocMetadata parses a sidecar: a JSON object whose values are strings,
+// numbers, booleans or arrays of them
+func parseDocMetadata(data []byte) (DocMetadata, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, filesystem.NewInvalidArgumentError("metadata", string(data), "must be a JSON object")
+	}
+
+	meta := make(DocMetadata, len(raw))
+	for key, value := range raw {
+		if !metadataKeyPattern.MatchString(key) {
+			return nil, filesystem.NewInvalidArgumentError("metadata key", key, "must be 1-64 letters, digits, '_', '.' or '-'")
+		}
+		items, isList := value.([]interface{})
+		if !isList {
+			items = []interface{}{value}
+		}
+		for _, item := range items {
+			s, ok := metadataScalar(item)
+			if !ok {
+				return nil, filesystem.NewInvalidArgumentError("metadata", key, "values must be strings, numbers, booleans or arrays of them")
+			}
+			meta[key] = append(meta[key], s)
+		}
+	}
+	return meta, nil
+}
+
+// metadataScalar formats a scalar JSON value as a string
+func metadataScalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return fmt.Sprint(v), true
+	default:
+		return "", false
+	}
+}
+
+// value returns the metadata as a database column value, NULL when empty
+func (m DocMetadata) value() interface{} {
+	if len(m) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(m)
+	return string(data)
+}
+
+// decodeDocMetadata parses a metadata column; NULL and malformed values
+// yield no metadata
+func decodeDocMetadata(column sql.NullString) DocMetadata {
+	if !column.Valid || column.String == "" {
+		return nil
+	}
+	var meta DocMetadata
+	if err := json.Unmarshal([]byte(column.String), &meta); err != nil {
+		return nil
+	}
+	return meta
+}
+
+// MetadataCondition requires a metadata key to have a value
+type MetadataCondition struct {
+	Key   string
+	Value string
+}
+
+// MetadataFilter restricts a search to chunks matching every condition
+type MetadataFilter []MetadataCondition
+
+// containment returns, per condition, the JSON document that a chunk's
+// metadata must contain: {"key":["value"]}
+func (f MetadataFilter) containment() []string {
+	docs := make([]string, len(f))
+	for i, cond := range f {
+		data, _ := json.Marshal(map[string][]string{cond.Key: {cond.Value}})
+		docs[i] = string(data)
+	}
+	return docs
+}
+
+// searchModifiers are the options that follow " -- " in a search query
+type searchModifiers struct {
+	filter         MetadataFilter
+	topK           int     // Result count; 0 when not set
+	minScore       float64 // Score threshold, if minScoreSet
+	minScoreSet    bool
+	mmrLambda      float64 // MMR relevance weight, if mmrSet; 0 is off
+	mmrSet         bool
+	dedupThreshold float64 // Duplicate similarity, if dedupSet; 0 is off
+	dedupSet       bool
+}
+
+// parseSearchModifiers splits a query at its last " -- " into the search
+// text and space-separated modifiers. filter:k=v,k2=v2 requires every listed
+// key to have the value; repeated filter modifiers are combined. topk:N sets
+// the number of results and minscore:S drops results scoring below S.
+// mmr:L selects results by maximal marginal relevance with relevance weight
+// L, and dedup:T collapses chunks of a file with content similarity from T;
+// "off" disables either. All also accept "=".
+func parseSearchModifiers(query string) (string, searchModifiers, error) {
+	var mods searchModifiers
+	// Queries are composed like the documents they are matched against
+	query = composeNFC(query)
+	i := strings.LastIndex(query, modifierSeparator)
+	if i < 0 {
+		return query, mods, nil
+	}
+	text, rest := strings.TrimSpace(query[:i]), query[i+len(modifierSeparator):]
+
+	for _, field := range strings.Fields(rest) {
+		expr, ok := strings.CutPrefix(field, filterModifier)
+		if !ok {
+			if err := mods.parseOption(field); err != nil {
+				return "", mods, err
+			}
+			continue
+		}
+		for _, pair := range strings.Split(expr, ",") {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok || value == "" || !metadataKeyPattern.MatchString(key) {
+				return "", mods, fmt.Errorf("invalid metadata filter %q: expected key=value", pair)
+			}
+			mods.filter = append(mods.filter, MetadataCondition{Key: key, Value: value})
+		}
+	}
+	if text == "" {
+		return "", mods, fmt.Errorf("search query is empty")
+	}
+	return text, mods, nil
+}
+
+// parseOption parses a topk or minscore modifier
+func (mods *searchModifiers) parseOption(field string) error {
+	name, value, ok := strings.Cut(field, ":")
+	if !ok {
+		name, value, ok = strings.Cut(field, "=")
+	}
+	switch {
+	case ok && name == "topk":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid topk %q: expected a positive number", value)
+		}
+		mods.topK = n
+	case ok && name == "minscore":
+		score, err := strconv.ParseFloat(value, 64)
+		if err != nil || score < 0 || score > 1 {
+			return fmt.Errorf("invalid minscore %q: expected a number between 0 and 1", value)
+		}
+		mods.minScore, mods.minScoreSet = score, true
+	case ok && (name == "mmr" || name == "dedup"):
+		fraction := 0.0
+		if value != "off" {
+			var err error
+			fraction, err = strconv.ParseFloat(value, 64)
+			if err != nil || fraction < 0 || fraction > 1 {
+				return fmt.Errorf("invalid %s %q: expected a number between 0 and 1, or off", name, value)
+			}
+		}
+		if name == "mmr" {
+			mods.mmrLambda, mods.mmrSet = fraction, true
+		} else {
+			mods.dedupThreshold, mods.dedupSet = fraction, true
+		}
+	default:
+		return fmt.Errorf("unknown search modifier %q (supported: filter:key=value,..., topk:N, minscore:S, mmr:L, dedup:T)", field)
+	}
+	return nil
+}
+
+// resultMetadata converts document metadata for a search result, showing
+// single values as plain strings
+func resultMetadata(meta DocMetadata) map[string]interface{} {
+	out := make(map[string]interface{}, len(meta))
+	for k, values := range meta {
+		if len(values) == 1 {
+			out[k] = values[0]
+		} else {
+			out[k] = values
+		}
+	}
+	return out
+}
@@ -0,0 +1,409 @@
+package vectorfs
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// namespaceConfigFile is the control file of a namespace that shows its
+// settings and overrides them, as TOML or JSON:
+//
+//	cat /vectorfs/my_project/.config
+//	echo 'chunk_size = 256' > /vectorfs/my_project/.config
+const namespaceConfigFile = ".config"
+
+// namespaceConfigTable holds the setting overrides of every namespace of a
+// vector store, as JSON
+const namespaceConfigTable = "tbl_namespace_config"
+
+// namespaceConfigKeys are the settings a namespace can override, as named in
+// the plugin config
+var namespaceConfigKeys = []string{
+	"chunk_size", "chunk_overlap", "chunk_strategy", "embedding_model", "default_topk", "min_score",
+	"write_consistency", "scoring_hook", "query_qps",
+}
+
+// NamespaceConfig overrides plugin settings for one namespace. Unset
+// settings (zero, or nil where zero is a valid value) follow the plugin
+// config.
+type NamespaceConfig struct {
+	ChunkSize      int      `json:"chunk_size,omitempty"`
+	ChunkOverlap   *int     `json:"chunk_overlap,omitempty"`
+	ChunkStrategy  string   `json:"chunk_strategy,omitempty"`
+	EmbeddingModel string   `json:"embedding_model,omitempty"` // A model of the primary provider
+	DefaultTopK    int      `json:"default_topk,omitempty"`
+	MinScore       *float64 `json:"min_score,omitempty"`
+	// WriteConsistency is when writes to docs/ return, a WriteConsistency*
+	WriteConsistency string `json:"write_consistency,omitempty"`
+	// ScoringHook adjusts search results: a registered hook, a webhook URL
+	// or "none"
+	ScoringHook string `json:"scoring_hook,omitempty"`
+	// QueryQPS limits the search queries of the namespace embedded per
+	// second; 0 is unlimited
+	QueryQPS *float64 `json:"query_qps,omitempty"`
+}
+
+// chunker returns the chunking settings of the namespace
+func (c NamespaceConfig) chunker(base ChunkerConfig) ChunkerConfig {
+	if c.ChunkSize > 0 {
+		base.ChunkSize = c.ChunkSize
+	}
+	if c.ChunkOverlap != nil {
+		base.ChunkOverlap = *c.ChunkOverlap
+	}
+	if c.ChunkStrategy != "" {
+		base.Strategy = c.ChunkStrategy
+	}
+	return base
+}
+
+// search returns the search defaults of the namespace
+func (c NamespaceConfig) search(base SearchConfig) SearchConfig {
+	if c.DefaultTopK > 0 {
+		base.DefaultTopK = c.DefaultTopK
+	}
+	if c.MinScore != nil {
+		base.MinScore = *c.MinScore
+	}
+	if c.QueryQPS != nil {
+		base.QueryQPS = *c.QueryQPS
+	}
+	return base
+}
+
+// validate checks the overrides against the plugin settings they apply to
+func (c NamespaceConfig) validate(chunker ChunkerConfig, search SearchConfig) error {
+	invalid := func(key string, value interface{}, reason string) error {
+		return filesystem.NewInvalidArgumentError(key, fmt.Sprint(value), reason)
+	}
+	if c.ChunkSize < 0 {
+		return invalid("chunk_size", c.ChunkSize, "must be positive")
+	}
+	if c.ChunkOverlap != nil && *c.ChunkOverlap < 0 {
+		return invalid("chunk_overlap", *c.ChunkOverlap, "must not be negative")
+	}
+	if resolved := c.chunker(chunker); (c.ChunkSize > 0 || c.ChunkOverlap != nil) && resolved.ChunkOverlap >= resolved.ChunkSize {
+		return invalid("chunk_overlap", resolved.ChunkOverlap, fmt.Sprintf("must be below chunk_size (%d)", resolved.ChunkSize))
+	}
+	if c.ChunkStrategy != "" && !isChunkStrategy(c.ChunkStrategy) {
+		return invalid("chunk_strategy", c.ChunkStrategy, "expected one of "+strings.Join(chunkStrategies, ", "))
+	}
+	if c.DefaultTopK < 0 || c.DefaultTopK > search.MaxTopK {
+		return invalid("default_topk", c.DefaultTopK, fmt.Sprintf("must be between 1 and max_topk (%d)", search.MaxTopK))
+	}
+	if c.MinScore != nil && (*c.MinScore < 0 || *c.MinScore > 1) {
+		return invalid("min_score", *c.MinScore, "must be between 0 and 1")
+	}
+	if c.QueryQPS != nil && *c.QueryQPS < 0 {
+		return invalid("query_qps", *c.QueryQPS, "must not be negative")
+	}
+	if c.WriteConsistency != "" && !isWriteConsistency(c.WriteConsistency) {
+		return invalid("write_consistency", c.WriteConsistency, "expected one of "+strings.Join(writeConsistencies, ", "))
+	}
+	if err := validateScoringHook(c.ScoringHook); err != nil {
+		return invalid("scoring_hook", c.ScoringHook, err.Error())
+	}
+	return nil
+}
+
+// parseNamespaceConfig reads the overrides written to .config: a JSON
+// object, or TOML "key = value" lines. Settings left out follow the plugin
+// config, so an empty file drops every override.
+func parseNamespaceConfig(data []byte) (NamespaceConfig, error) {
+	var cfg NamespaceConfig
+	values := make(map[string]interface{})
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return cfg, filesystem.NewInvalidArgumentError("config", "", fmt.Sprintf("invalid JSON: %v", err))
+		}
+	} else {
+		var err error
+		if values, err = parseTOMLSettings(string(data)); err != nil {
+			return cfg, err
+		}
+	}
+	if err := config.ValidateOnlyKnownKeys(values, namespaceConfigKeys); err != nil {
+		return cfg, filesystem.NewInvalidArgumentError("config", "", err.Error())
+	}
+
+	for key, value := range values {
+		invalid := func(expected string) error {
+			return filesystem.NewInvalidArgumentError(key, fmt.Sprint(value), "expected "+expected)
+		}
+		switch key {
+		case "chunk_size", "chunk_overlap", "default_topk":
+			f, ok := value.(float64)
+			if !ok || f != math.Trunc(f) {
+				return cfg, invalid("an integer")
+			}
+			n := int(f)
+			switch key {
+			case "chunk_size":
+				cfg.ChunkSize = n
+			case "chunk_overlap":
+				cfg.ChunkOverlap = &n
+			default:
+				cfg.DefaultTopK = n
+			}
+		case "min_score", "query_qps":
+			f, ok := value.(float64)
+			if !ok {
+				return cfg, invalid("a number")
+			}
+			if key == "min_score" {
+				cfg.MinScore = &f
+			} else {
+				cfg.QueryQPS = &f
+			}
+		case "chunk_strategy", "embedding_model", "write_consistency", "scoring_hook":
+			s, ok := value.(string)
+			if !ok {
+				return cfg, invalid("a string")
+			}
+			switch key {
+			case "chunk_strategy":
+				cfg.ChunkStrategy = s
+			case "embedding_model":
+				cfg.EmbeddingModel = s
+			case "scoring_hook":
+				cfg.ScoringHook = s
+			default:
+				cfg.WriteConsistency = s
+			}
+		}
+	}
+	if cfg.ChunkSize == 0 && values["chunk_size"] != nil {
+		return cfg, filesystem.NewInvalidArgumentError("chunk_size", "0", "must be positive")
+	}
+	if cfg.DefaultTopK == 0 && values["default_topk"] != nil {
+		return cfg, filesystem.NewInvalidArgumentError("default_topk", "0", "must be positive")
+	}
+	return cfg, nil
+}
+
+// parseTOMLSettings reads flat TOML "key = value" lines, where a value is a
+// quoted string or a number. Numbers are returned as float64, like JSON.
+func parseTOMLSettings(text string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, filesystem.NewInvalidArgumentError("config", line, "expected key = value")
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			s, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, filesystem.NewInvalidArgumentError(key, value, "invalid string")
+			}
+			values[key] = s
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, filesystem.NewInvalidArgumentError(key, value, "expected a quoted string or a number")
+		}
+		values[key] = f
+	}
+	return values, nil
+}
+
+// formatNamespaceConfig renders .config as TOML: overrides as settings,
+// and the plugin config they would replace as comments, so the file can
+// be edited and written back
+func formatNamespaceConfig(cfg NamespaceConfig, chunker ChunkerConfig, search SearchConfig, model, consistency, scoringHook string) string {
+	var sb strings.Builder
+	sb.WriteString("# Settings of this namespace. Uncommented lines override the plugin config;\n")
+	sb.WriteString("# write this file (TOML or JSON) to change them.\n")
+	line := func(key string, value interface{}, set bool) {
+		if !set {
+			sb.WriteString("# ")
+		}
+		if s, ok := value.(string); ok {
+			value = strconv.Quote(s)
+		}
+		fmt.Fprintf(&sb, "%s = %v\n", key, value)
+	}
+	resolvedChunker := cfg.chunker(chunker)
+	resolvedSearch := cfg.search(search)
+	strategy := resolvedChunker.Strategy
+	if strategy == "" {
+		strategy = ChunkStrategyParagraph
+	}
+	line("chunk_size", resolvedChunker.ChunkSize, cfg.ChunkSize > 0)
+	line("chunk_overlap", resolvedChunker.ChunkOverlap, cfg.ChunkOverlap != nil)
+	line("chunk_strategy", strategy, cfg.ChunkStrategy != "")
+	line("embedding_model", model, cfg.EmbeddingModel != "")
+	line("default_topk", resolvedSearch.DefaultTopK, cfg.DefaultTopK > 0)
+	line("min_score", resolvedSearch.MinScore, cfg.MinScore != nil)
+	if cfg.WriteConsistency != "" {
+		consistency = cfg.WriteConsistency
+	}
+	line("write_consistency", consistency, cfg.WriteConsistency != "")
+	if cfg.ScoringHook != "" {
+		scoringHook = cfg.ScoringHook
+	}
+	if scoringHook == "" {
+		scoringHook = scoringHookNone
+	}
+	line("scoring_hook", scoringHook, cfg.ScoringHook != "")
+	line("query_qps", resolvedSearch.QueryQPS, cfg.QueryQPS != nil)
+	return sb.String()
+}
+
+// createNamespaceConfigTable creates the namespace config table if missing.
+// The schema is the same for every backend.
+func createNamespaceConfigTable(db sqlExecer) error {
+	_, err := db.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			namespace VARCHAR(255) PRIMARY KEY,
+			config TEXT NOT NULL
+		)`, namespaceConfigTable))
+	if err != nil {
+		return fmt.Errorf("failed to create namespace config table: %w", err)
+	}
+	return nil
+}
+
+// queryNamespaceConfig reads the overrides of a namespace; a namespace
+// without any has an empty config
+func queryNamespaceConfig(db *sql.DB, ph sqlPlaceholder, namespace string) (NamespaceConfig, error) {
+	var cfg NamespaceConfig
+	var data string
+	err := db.QueryRow(fmt.Sprintf("SELECT config FROM %s WHERE namespace = %s", namespaceConfigTable, ph(1)), namespace).Scan(&data)
+	if err == sql.ErrNoRows {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config of %s: %w", namespace, err)
+	}
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid config of %s: %w", namespace, err)
+	}
+	return cfg, nil
+}
+
+// updateNamespaceConfig replaces the overrides of a namespace
+func updateNamespaceConfig(db *sql.DB, ph sqlPlaceholder, namespace string, cfg NamespaceConfig) error {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := deleteNamespaceConfig(tx, ph, namespace); err != nil {
+		return err
+	}
+	if cfg != (NamespaceConfig{}) {
+		_, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (namespace, config) VALUES (%s, %s)",
+			namespaceConfigTable, ph(1), ph(2)), namespace, string(data))
+		if err != nil {
+			return fmt.Errorf("failed to set config of %s: %w", namespace, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteNamespaceConfig forgets the overrides of a namespace
+func deleteNamespaceConfig(db sqlExecer, ph sqlPlaceholder, namespace string) error {
+	if _, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE namespace = %s", namespaceConfigTable, ph(1)), namespace); err != nil {
+		return fmt.Errorf("failed to delete config of %s: %w", namespace, err)
+	}
+	return nil
+}
+
+// namespaceConfig returns the overrides of a namespace. On failure the
+// namespace follows the plugin config.
+func (v *VectorFSPlugin) namespaceConfig(namespace string) NamespaceConfig {
+	cfg, err := v.store.NamespaceConfig(namespace)
+	if err != nil {
+		log.Warnf("[vectorfs] Failed to read config of %s, using the plugin config: %v", namespace, err)
+	}
+	return cfg
+}
+
+// namespaceModel returns the embedding model a namespace overrides, or ""
+func (v *VectorFSPlugin) namespaceModel(namespace string) (string, error) {
+	cfg, err := v.store.NamespaceConfig(namespace)
+	return cfg.EmbeddingModel, err
+}
+
+// readNamespaceConfig renders the .config file of a namespace
+func (vfs *vectorFS) readNamespaceConfig(namespace string) ([]byte, error) {
+	if err := vfs.requireNamespace("config", namespace); err != nil {
+		return nil, err
+	}
+	cfg, err := vfs.plugin.store.NamespaceConfig(namespace)
+	if err != nil {
+		return nil, err
+	}
+	model := vfs.plugin.embedder.Model(namespace)
+	return []byte(formatNamespaceConfig(cfg, vfs.plugin.indexer.chunkerConfig, vfs.plugin.search, model, vfs.plugin.consistency, vfs.plugin.scoringHookName)), nil
+}
+
+// writeNamespaceConfig replaces the overrides of a namespace. A new
+// embedding model is checked first, and the namespace is re-indexed with
+// it: vectors of different models can't be compared.
+func (vfs *vectorFS) writeNamespaceConfig(namespace string, data []byte) error {
+	if err := vfs.requireNamespace("config", namespace); err != nil {
+		return err
+	}
+	cfg, err := parseNamespaceConfig(data)
+	if err != nil {
+		return err
+	}
+	if err := cfg.validate(vfs.plugin.indexer.chunkerConfig, vfs.plugin.search); err != nil {
+		return err
+	}
+	old, err := vfs.plugin.store.NamespaceConfig(namespace)
+	if err != nil {
+		return err
+	}
+	oldModel := vfs.plugin.embedder.Model(namespace)
+	if cfg.EmbeddingModel != "" && cfg.EmbeddingModel != old.EmbeddingModel {
+		if err := vfs.plugin.embedder.CheckModel(cfg.EmbeddingModel); err != nil {
+			return filesystem.NewInvalidArgumentError("embedding_model", cfg.EmbeddingModel, err.Error())
+		}
+	}
+	if err := vfs.plugin.store.SetNamespaceConfig(namespace, cfg); err != nil {
+		return err
+	}
+
+	if model := vfs.plugin.embedder.Model(namespace); model != oldModel {
+		queued, err := vfs.reindex(namespace, []string{"*"})
+		if err != nil {
+			return fmt.Errorf("config saved, but re-indexing with %s failed: %w", model, err)
+		}
+		log.Infof("[vectorfs] Namespace %s now uses embedding model %s, re-indexing %d document(s)", namespace, model, queued)
+	}
+	return nil
+}
+
+// requireNamespace fails with a not found error if a namespace doesn't exist
+func (vfs *vectorFS) requireNamespace(op, namespace string) error {
+	exists, err := vfs.plugin.store.NamespaceExists(namespace)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return filesystem.NewNotFoundError(op, namespace)
+	}
+	return nil
+}
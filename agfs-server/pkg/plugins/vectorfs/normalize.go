@@ -0,0 +1,370 @@
+package vectorfs
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Text normalization runs on every document before it is chunked, and on
+// search queries, so that the same text written in different forms embeds
+// and matches the same way:
+//
+//  1. Decoding: UTF-8 (with or without BOM), UTF-16 with a BOM, and legacy
+//     8-bit text (read as Windows-1252) become UTF-8
+//  2. Unicode canonical composition (NFC), e.g. "e" + U+0301 becomes "é",
+//     and CRLF line endings become LF
+//  3. Language detection: the language is stored in the chunk metadata
+//     under languageMetadataKey and picks the sentence splitting rules
+
+// languageMetadataKey is the chunk metadata key holding the detected
+// language of a document, unless its metadata sidecar sets it:
+//
+//	grep 'how to deploy -- filter:language=fr' /vectorfs/my_project/docs
+const languageMetadataKey = "language"
+
+// Text encodings recognized by decodeText
+const (
+	encodingUTF8        = "utf-8"
+	encodingUTF16LE     = "utf-16le"
+	encodingUTF16BE     = "utf-16be"
+	encodingWindows1252 = "windows-1252"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// windows1252High maps the bytes 0x80-0x9F of Windows-1252; the five bytes
+// it leaves undefined map to the C1 control of the same value
+var windows1252High = [32]rune{
+	0x20AC, 0x81, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x8D, 0x017D, 0x8F,
+	0x90, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x9D, 0x017E, 0x0178,
+}
+
+// decodeText converts document content to UTF-8 and returns the encoding
+// it was in. ok is false for content that is not text in any of them:
+// binary data, which holds NUL bytes or other control characters.
+func decodeText(data []byte) (text, encoding string, ok bool) {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		data = data[len(bomUTF8):]
+	case bytes.HasPrefix(data, bomUTF16LE), bytes.HasPrefix(data, bomUTF16BE):
+		return decodeUTF16(data)
+	}
+	if utf8.Valid(data) {
+		return string(data), encodingUTF8, bytes.IndexByte(data, 0) == -1
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(data) + len(data)/2)
+	for _, b := range data {
+		switch {
+		case b < 0x20 && !isTextControl(rune(b)), b == 0x7F:
+			return "", "", false
+		case b >= 0x80 && b < 0xA0:
+			sb.WriteRune(windows1252High[b-0x80])
+		default:
+			sb.WriteRune(rune(b))
+		}
+	}
+	return sb.String(), encodingWindows1252, true
+}
+
+// decodeUTF16 decodes UTF-16 text starting with its BOM
+func decodeUTF16(data []byte) (text, encoding string, ok bool) {
+	if len(data)%2 != 0 {
+		return "", "", false
+	}
+	bigEndian := data[0] == 0xFE
+	encoding = encodingUTF16LE
+	if bigEndian {
+		encoding = encodingUTF16BE
+	}
+	units := make([]uint16, 0, len(data)/2-1)
+	for i := 2; i < len(data); i += 2 {
+		if bigEndian {
+			units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+		} else {
+			units = append(units, uint16(data[i+1])<<8|uint16(data[i]))
+		}
+	}
+	runes := utf16.Decode(units)
+	for _, r := range runes {
+		if r < 0x20 && !isTextControl(r) || r == utf8.RuneError {
+			return "", "", false
+		}
+	}
+	return string(runes), encoding, true
+}
+
+// isTextControl reports whether a control character occurs in text
+func isTextControl(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' || r == '\f' || r == '\v'
+}
+
+// normalizeText composes text to NFC and converts its line endings to LF
+func normalizeText(text string) string {
+	text = composeNFC(text)
+	if strings.Contains(text, "\r") {
+		text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+	}
+	return text
+}
+
+// Hangul syllables are composed algorithmically (Unicode 3.12)
+const (
+	hangulSBase  = 0xAC00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11A7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+	hangulNCount = hangulVCount * hangulTCount
+	hangulSCount = hangulLCount * hangulNCount
+)
+
+// combiningClass returns the canonical combining class of a rune
+func combiningClass(r rune) int {
+	if r < 0x300 {
+		return 0
+	}
+	i := sort.Search(len(combiningClassRanges), func(i int) bool {
+		return combiningClassRanges[i][1] >= r
+	})
+	if i < len(combiningClassRanges) && combiningClassRanges[i][0] <= r {
+		return int(combiningClassRanges[i][2])
+	}
+	return 0
+}
+
+// composePair returns the primary composite of a starter and the rune
+// following it
+func composePair(a, b rune) (rune, bool) {
+	if a >= hangulLBase && a < hangulLBase+hangulLCount && b >= hangulVBase && b < hangulVBase+hangulVCount {
+		return hangulSBase + ((a-hangulLBase)*hangulVCount+(b-hangulVBase))*hangulTCount, true
+	}
+	if s := a - hangulSBase; s >= 0 && s < hangulSCount && s%hangulTCount == 0 && b > hangulTBase && b < hangulTBase+hangulTCount {
+		return a + b - hangulTBase, true
+	}
+	c, ok := canonicalCompositions[[2]rune{a, b}]
+	return c, ok
+}
+
+// composeNFC applies canonical ordering and composition to text. Text in
+// NFC already, such as any text without combining marks or Hangul jamo, is
+// returned as it is. Precomposed characters are not decomposed first, so
+// the few that NFC rewrites (such as U+212B ANGSTROM SIGN) are kept.
+func composeNFC(text string) string {
+	needed := false
+	for _, r := range text {
+		if r >= 0x300 && (combiningClass(r) != 0 || r >= hangulVBase && r < hangulTBase+hangulTCount) {
+			needed = true
+			break
+		}
+	}
+	if !needed {
+		return text
+	}
+
+	runes := []rune(text)
+	// Canonical ordering: each run of non-starters sorted by class
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && combiningClass(runes[j]) != 0 {
+			j++
+		}
+		if j-i > 1 {
+			run := runes[i:j]
+			sort.SliceStable(run, func(a, b int) bool { return combiningClass(run[a]) < combiningClass(run[b]) })
+		}
+		if j == i {
+			j++
+		}
+		i = j
+	}
+
+	// Canonical composition: a rune joins the last starter unless another
+	// rune of the same or a higher class, or a starter, comes in between
+	out := runes[:0]
+	starter := -1
+	lastClass := -1 // Class of the last rune kept after the starter; -1 if none
+	for _, r := range runes {
+		class := combiningClass(r)
+		if starter >= 0 && (lastClass == -1 || lastClass != 0 && lastClass < class) {
+			if c, ok := composePair(out[starter], r); ok {
+				out[starter] = c
+				continue
+			}
+		}
+		if class == 0 {
+			starter = len(out)
+			lastClass = -1
+		} else {
+			lastClass = class
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+// unspacedLanguages write sentences without spaces between them: they end
+// at a full-width terminator and are joined without a space when chunked
+var unspacedLanguages = map[string]bool{"zh": true, "ja": true}
+
+// scriptLanguages are the languages told apart by their script, checked in
+// order. Han text is Japanese if it uses kana and Chinese otherwise.
+var scriptLanguages = []struct {
+	language string
+	script   *unicode.RangeTable
+}{
+	{"ko", unicode.Hangul},
+	{"ja", unicode.Hiragana},
+	{"ja", unicode.Katakana},
+	{"zh", unicode.Han},
+	{"ru", unicode.Cyrillic},
+	{"el", unicode.Greek},
+	{"ar", unicode.Arabic},
+	{"he", unicode.Hebrew},
+	{"hi", unicode.Devanagari},
+	{"th", unicode.Thai},
+}
+
+// latinStopwords are frequent words that tell Latin-script languages apart
+var latinStopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "that", "with", "for", "this", "are", "was", "you"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "mit", "ein", "eine", "den", "auf", "ich"},
+	"fr": {"le", "la", "les", "et", "est", "des", "une", "pour", "dans", "pas", "qui", "du"},
+	"es": {"el", "los", "las", "y", "es", "del", "por", "una", "para", "con", "que", "como"},
+	"it": {"il", "della", "di", "che", "è", "gli", "per", "non", "una", "sono", "con", "nel"},
+	"pt": {"o", "os", "da", "do", "não", "uma", "para", "com", "que", "em", "é", "dos"},
+	"nl": {"de", "het", "een", "en", "van", "is", "niet", "op", "dat", "met", "voor", "zijn"},
+}
+
+// languageSampleRunes bounds how much of a document is read to detect its
+// language
+const languageSampleRunes = 16384
+
+// minLanguageLetters is how many letters a text needs for its language to
+// be detected
+const minLanguageLetters = 20
+
+// detectLanguage guesses the language of a text as an ISO 639-1 code, or
+// returns "" if the text is too short or its language is not recognized.
+// Scripts used by one language decide it; Latin-script languages are told
+// apart by their most frequent words.
+func detectLanguage(text string) string {
+	counts := make(map[*unicode.RangeTable]int)
+	letters, latin := 0, 0
+	n := 0
+	for _, r := range text {
+		if n++; n > languageSampleRunes {
+			break
+		}
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, s := range scriptLanguages {
+			if unicode.Is(s.script, r) {
+				counts[s.script]++
+				break
+			}
+		}
+	}
+	if letters < minLanguageLetters {
+		return ""
+	}
+
+	// The script of at least a third of the letters wins over Latin, which
+	// identifiers and loanwords bring into any text
+	best, bestCount := "", 0
+	for _, s := range scriptLanguages {
+		if c := counts[s.script]; c*3 >= letters && c > bestCount {
+			best, bestCount = s.language, c
+		}
+	}
+	switch {
+	case best == "zh" && counts[unicode.Hiragana]+counts[unicode.Katakana] > 0:
+		return "ja"
+	case best == "ru" && strings.ContainsAny(text, "іїєґІЇЄҐ"):
+		return "uk"
+	case best != "":
+		return best
+	case latin*2 < letters:
+		return ""
+	}
+	return detectLatinLanguage(text)
+}
+
+// detectLatinLanguage picks the Latin-script language whose stopwords are
+// most frequent, if clearly ahead of the others
+func detectLatinLanguage(text string) string {
+	if len(text) > languageSampleRunes*4 {
+		text = text[:languageSampleRunes*4]
+	}
+	words := make(map[string]int)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		words[w]++
+	}
+	scores := make(map[string]int, len(latinStopwords))
+	for language, stopwords := range latinStopwords {
+		for _, w := range stopwords {
+			scores[language] += words[w]
+		}
+	}
+	best, second := "", 0
+	for _, language := range sortedKeys(scores) {
+		score := scores[language]
+		if best == "" || score > scores[best] {
+			if best != "" {
+				second = scores[best]
+			}
+			best = language
+		} else if score > second {
+			second = score
+		}
+	}
+	// Require a few hits and a margin, so that short or mixed texts stay
+	// untagged rather than mistagged
+	if best == "" || scores[best] < 3 || scores[best]*4 < second*5 {
+		return ""
+	}
+	return best
+}
+
+// sortedKeys returns the keys of a map in order, for deterministic ties
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// withLanguage adds the detected language to the metadata of a document,
+// unless it is unknown or the metadata sets one itself
+func withLanguage(metadata DocMetadata, language string) DocMetadata {
+	if language == "" || len(metadata[languageMetadataKey]) > 0 {
+		return metadata
+	}
+	tagged := make(DocMetadata, len(metadata)+1)
+	for k, v := range metadata {
+		tagged[k] = v
+	}
+	tagged[languageMetadataKey] = []string{language}
+	return tagged
+}
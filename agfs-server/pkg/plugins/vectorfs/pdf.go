@@ -0,0 +1,308 @@
+package vectorfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// pdfExtractor reads the text shown by the content streams of a PDF. It
+// handles uncompressed and FlateDecode streams with simple fonts, which
+// covers most generated documents. Text drawn with composite (CID) fonts
+// needs their ToUnicode maps and comes out empty or garbled; convert such
+// documents with an extractor command such as pdftotext instead.
+type pdfExtractor struct{}
+
+func (pdfExtractor) Name() string { return "pdf" }
+
+// pdfMaxStreamSize bounds a decompressed stream, guarding against
+// decompression bombs
+const pdfMaxStreamSize = 64 << 20
+
+func (pdfExtractor) Extract(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return "", fmt.Errorf("not a PDF document")
+	}
+
+	var sb strings.Builder
+	rest := data
+	for {
+		start := bytes.Index(rest, []byte("stream"))
+		if start == -1 {
+			break
+		}
+		// "endstream" also contains "stream"
+		if start >= 3 && bytes.Equal(rest[start-3:start], []byte("end")) {
+			rest = rest[start+len("stream"):]
+			continue
+		}
+		dict := pdfStreamDict(rest[:start])
+		body := rest[start+len("stream"):]
+		body = bytes.TrimPrefix(body, []byte("\r"))
+		body = bytes.TrimPrefix(body, []byte("\n"))
+		end := bytes.Index(body, []byte("endstream"))
+		if end == -1 {
+			break
+		}
+		content := body[:end]
+		rest = body[end+len("endstream"):]
+
+		// Images, fonts and cross-reference streams hold no page text
+		if bytes.Contains(dict, []byte("/Image")) || bytes.Contains(dict, []byte("/XRef")) ||
+			bytes.Contains(dict, []byte("/FontFile")) || bytes.Contains(dict, []byte("/Length1")) {
+			continue
+		}
+		if bytes.Contains(dict, []byte("/Filter")) {
+			if !bytes.Contains(dict, []byte("/FlateDecode")) || pdfHasOtherFilter(dict) {
+				continue
+			}
+			decoded, err := pdfInflate(content)
+			if err != nil {
+				continue
+			}
+			content = decoded
+		}
+		if !bytes.Contains(content, []byte("BT")) {
+			continue
+		}
+		pdfShowText(content, &sb)
+	}
+
+	text := collapseWhitespace(sb.String())
+	if text == "" {
+		return "", fmt.Errorf("no extractable text (scanned or CID-font PDF?)")
+	}
+	return text, nil
+}
+
+// pdfStreamDict returns the dictionary of the stream whose "stream"
+// keyword follows before
+func pdfStreamDict(before []byte) []byte {
+	if i := bytes.LastIndex(before, []byte("obj")); i != -1 {
+		return before[i:]
+	}
+	if len(before) > 512 {
+		return before[len(before)-512:]
+	}
+	return before
+}
+
+// pdfHasOtherFilter reports whether a stream is encoded with filters other
+// than FlateDecode, e.g. DCTDecode images or LZW
+func pdfHasOtherFilter(dict []byte) bool {
+	for _, f := range []string{"/DCTDecode", "/JPXDecode", "/LZWDecode", "/ASCII85Decode", "/ASCIIHexDecode", "/RunLengthDecode", "/CCITTFaxDecode", "/JBIG2Decode", "/Crypt"} {
+		if bytes.Contains(dict, []byte(f)) {
+			return true
+		}
+	}
+	return false
+}
+
+func pdfInflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, pdfMaxStreamSize))
+	// Truncated streams are common; keep what was decoded
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+// pdfShowText writes the strings drawn by the text operators of a content
+// stream: Tj, TJ, ' and ". Moves to a new line become line breaks and
+// wide gaps within TJ arrays become spaces.
+func pdfShowText(content []byte, sb *strings.Builder) {
+	var operands []string // Strings of the pending operator
+	var arrayText strings.Builder
+	inArray := false
+
+	i := 0
+	for i < len(content) {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := pdfLiteralString(content[i:])
+			if inArray {
+				arrayText.WriteString(s)
+			} else {
+				operands = append(operands, s)
+			}
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			s, n := pdfHexString(content[i:])
+			if inArray {
+				arrayText.WriteString(s)
+			} else {
+				operands = append(operands, s)
+			}
+			i += n
+		case c == '[':
+			inArray = true
+			arrayText.Reset()
+			i++
+		case c == ']':
+			inArray = false
+			operands = append(operands, arrayText.String())
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(content) && (content[j] == '.' || (content[j] >= '0' && content[j] <= '9')) {
+				j++
+			}
+			// Kerning of more than a fifth of a glyph in a TJ array is a word gap
+			if inArray {
+				if v, err := strconv.ParseFloat(string(content[i:j]), 64); err == nil && v < -200 {
+					arrayText.WriteString(" ")
+				}
+			}
+			i = j
+		case pdfIsRegular(c):
+			j := i
+			for j < len(content) && pdfIsRegular(content[j]) {
+				j++
+			}
+			op := string(content[i:j])
+			i = j
+			switch op {
+			case "Tj", "TJ":
+				sb.WriteString(strings.Join(operands, ""))
+			case "'", "\"":
+				sb.WriteString("\n")
+				sb.WriteString(strings.Join(operands, ""))
+			case "Td", "TD", "T*", "Tm":
+				sb.WriteString("\n")
+			case "ET":
+				sb.WriteString("\n\n")
+			}
+			if !inArray {
+				operands = operands[:0]
+			}
+		default:
+			i++
+		}
+	}
+}
+
+// pdfIsRegular reports whether c may be part of an operator or name
+func pdfIsRegular(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0, '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return false
+	}
+	return true
+}
+
+// pdfLiteralString decodes the (string) at the start of data and returns
+// it with the number of bytes consumed
+func pdfLiteralString(data []byte) (string, int) {
+	var buf []byte
+	depth := 0
+	i := 0
+	for ; i < len(data); i++ {
+		c := data[i]
+		switch c {
+		case '(':
+			depth++
+			if depth > 1 {
+				buf = append(buf, c)
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfDecodeText(buf), i + 1
+			}
+			buf = append(buf, c)
+		case '\\':
+			i++
+			if i >= len(data) {
+				break
+			}
+			switch e := data[i]; e {
+			case 'n':
+				buf = append(buf, '\n')
+			case 'r':
+				buf = append(buf, '\r')
+			case 't':
+				buf = append(buf, '\t')
+			case 'b', 'f':
+			case '\r':
+				// Line continuation
+				if i+1 < len(data) && data[i+1] == '\n' {
+					i++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					j := 0
+					for ; j < 3 && i+j < len(data) && data[i+j] >= '0' && data[i+j] <= '7'; j++ {
+						v = v*8 + int(data[i+j]-'0')
+					}
+					buf = append(buf, byte(v))
+					i += j - 1
+				} else {
+					buf = append(buf, e)
+				}
+			}
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return pdfDecodeText(buf), i
+}
+
+// pdfHexString decodes the <hex string> at the start of data
+func pdfHexString(data []byte) (string, int) {
+	end := bytes.IndexByte(data, '>')
+	if end == -1 {
+		return "", len(data)
+	}
+	var digits []byte
+	for _, c := range data[1:end] {
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	buf := make([]byte, len(digits)/2)
+	for i := range buf {
+		v, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		buf[i] = byte(v)
+	}
+	return pdfDecodeText(buf), end + 1
+}
+
+// pdfDecodeText converts string bytes to UTF-8: UTF-16BE with a byte order
+// mark, otherwise single-byte (PDFDocEncoding, which matches Latin-1 for
+// printable text). Control characters other than whitespace are dropped.
+func pdfDecodeText(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff {
+		units := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	var sb strings.Builder
+	for _, c := range b {
+		if c < 0x20 && c != '\n' && c != '\r' && c != '\t' {
+			continue
+		}
+		sb.WriteRune(rune(c))
+	}
+	return sb.String()
+}
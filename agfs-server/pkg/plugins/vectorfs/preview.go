@@ -0,0 +1,144 @@
+package vectorfs
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// titleMetadataKey is the sidecar metadata key naming a document
+	titleMetadataKey = "title"
+	// previewTitleBytes caps titles taken from the text of a document
+	previewTitleBytes = 120
+	// firstChunksBatch caps the digests queried at once by queryFirstChunks
+	firstChunksBatch = 500
+)
+
+// queryFirstChunks returns the first chunk of each of the files with the
+// given digests, without embedding, keyed by digest. Files not indexed yet
+// are left out. metadataColumn selects the metadata column as text. The
+// schema is the same for every backend.
+func queryFirstChunks(db *sql.DB, ph sqlPlaceholder, chunksTable, metadataColumn string, digests []string) (map[string]ChunkData, error) {
+	chunks := make(map[string]ChunkData, len(digests))
+	for start := 0; start < len(digests); start += firstChunksBatch {
+		batch := digests[start:min(start+firstChunksBatch, len(digests))]
+		placeholders := make([]string, len(batch))
+		args := make([]interface{}, len(batch))
+		for i, digest := range batch {
+			placeholders[i] = ph(i + 1)
+			args[i] = digest
+		}
+		rows, err := db.Query(fmt.Sprintf(
+			"SELECT file_digest, chunk_text, %s FROM %s WHERE chunk_index = 0 AND file_digest IN (%s)",
+			metadataColumn, chunksTable, strings.Join(placeholders, ", ")), args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list first chunks: %w", err)
+		}
+		for rows.Next() {
+			var digest string
+			var chunk ChunkData
+			var metadata sql.NullString
+			if err := rows.Scan(&digest, &chunk.ChunkText, &metadata); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			chunk.Metadata = decodeDocMetadata(metadata)
+			chunks[digest] = chunk
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
+
+// PreviewDir implements filesystem.DirPreviewer. Documents are previewed
+// from their first indexed chunk, read for the whole directory with one
+// query, rather than from the document store. Their title is the title
+// sidecar metadata, or else the first line of their text. Documents not
+// indexed yet, and the control files of a namespace, have no preview.
+func (vfs *vectorFS) PreviewDir(dir string, entries []filesystem.FileInfo, n int) map[string]filesystem.FilePreview {
+	namespace, relativePath, err := parsePath(dir)
+	if err != nil || namespace == "" || (relativePath != "docs" && !strings.HasPrefix(relativePath, "docs/")) {
+		return nil
+	}
+	previews, err := vfs.previewDocs(namespace, strings.TrimPrefix(strings.TrimPrefix(relativePath, "docs"), "/"), entries, n)
+	if err != nil {
+		log.Debugf("[vectorfs] Failed to preview %s: %v", dir, err)
+		return nil
+	}
+	return previews
+}
+
+// previewDocs returns previews of the documents among entries, the listing
+// of the docs subdirectory subdir
+func (vfs *vectorFS) previewDocs(namespace, subdir string, entries []filesystem.FileInfo, n int) (map[string]filesystem.FilePreview, error) {
+	prefix := ""
+	if subdir != "" {
+		prefix = subdir + "/"
+	}
+	var files []FileMetadata
+	var err error
+	if prefix != "" {
+		files, err = vfs.plugin.store.ListFilesWithPrefix(namespace, prefix)
+	} else {
+		files, err = vfs.plugin.store.ListFiles(namespace)
+	}
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]FileMetadata, len(files))
+	for _, f := range files {
+		byName[strings.TrimPrefix(f.FileName, prefix)] = f
+	}
+
+	var digests []string
+	for _, entry := range entries {
+		if f, ok := byName[entry.Name]; ok && !entry.IsDir {
+			digests = append(digests, f.FileDigest)
+		}
+	}
+	if len(digests) == 0 {
+		return nil, nil
+	}
+	chunks, err := vfs.plugin.store.FirstChunks(namespace, digests)
+	if err != nil {
+		return nil, err
+	}
+
+	previews := make(map[string]filesystem.FilePreview)
+	for _, entry := range entries {
+		f, ok := byName[entry.Name]
+		if !ok || entry.IsDir {
+			continue
+		}
+		chunk, ok := chunks[f.FileDigest]
+		if !ok {
+			continue
+		}
+		preview := filesystem.NewFilePreview(chunk.ChunkText, n)
+		preview.Title = documentTitle(chunk)
+		preview.Truncated = preview.Truncated || f.FileSize > int64(len(chunk.ChunkText))
+		previews[entry.Name] = preview
+	}
+	return previews, nil
+}
+
+// documentTitle returns the title of a document from its first chunk
+func documentTitle(chunk ChunkData) string {
+	if titles := chunk.Metadata[titleMetadataKey]; len(titles) > 0 && titles[0] != "" {
+		return titles[0]
+	}
+	for _, line := range strings.Split(chunk.ChunkText, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return filesystem.NewFilePreview(line, previewTitleBytes).Text
+		}
+	}
+	return ""
+}
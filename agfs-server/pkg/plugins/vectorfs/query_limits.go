@@ -0,0 +1,159 @@
+package vectorfs
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// defaultQueryCacheSize is how many query embeddings are kept when
+// query_cache_size is unset
+const defaultQueryCacheSize = 1000
+
+// parseQueryCacheSize reads query_cache_size; 0 disables the cache
+func parseQueryCacheSize(cfg map[string]interface{}) (int, error) {
+	size := config.GetIntConfig(cfg, "query_cache_size", defaultQueryCacheSize)
+	if size < 0 {
+		return 0, fmt.Errorf("query_cache_size must not be negative, got %d", size)
+	}
+	return size, nil
+}
+
+// queryEmbeddingCache keeps the embeddings of recent search queries, least
+// recently used first out, so that agents repeating a search don't call the
+// embedding API again. Keys name the model, so a namespace switching models
+// misses. A nil cache keeps nothing.
+type queryEmbeddingCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List // Of *queryCacheEntry, most recently used first
+}
+
+type queryCacheEntry struct {
+	key       string
+	embedding []float32
+}
+
+// newQueryEmbeddingCache creates a cache of size embeddings, or nil if size
+// is 0
+func newQueryEmbeddingCache(size int) *queryEmbeddingCache {
+	if size <= 0 {
+		return nil
+	}
+	return &queryEmbeddingCache{size: size, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (c *queryEmbeddingCache) get(key string) ([]float32, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*queryCacheEntry).embedding, true
+}
+
+func (c *queryEmbeddingCache) put(key string, embedding []float32) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*queryCacheEntry).embedding = embedding
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&queryCacheEntry{key: key, embedding: embedding})
+	for c.lru.Len() > c.size {
+		delete(c.entries, c.lru.Remove(c.lru.Back()).(*queryCacheEntry).key)
+	}
+}
+
+// queryLimiter holds a token bucket per namespace, limiting how many
+// search queries a second are sent to the embedding API for it
+type queryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*queryBucket
+	now     func() time.Time
+}
+
+type queryBucket struct {
+	tokens float64
+	refill time.Time // When tokens was last refilled
+}
+
+// take takes a token from the bucket of a namespace refilled at qps, or
+// returns how long until one is available. A qps of 0 is unlimited.
+func (l *queryLimiter) take(namespace string, qps float64, burst int) (time.Duration, bool) {
+	if qps <= 0 {
+		return 0, true
+	}
+	capacity := float64(burst)
+	if burst <= 0 {
+		capacity = math.Max(1, math.Ceil(qps))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.now != nil {
+		now = l.now()
+	}
+	if l.buckets == nil {
+		l.buckets = make(map[string]*queryBucket)
+	}
+	b, ok := l.buckets[namespace]
+	if !ok {
+		b = &queryBucket{tokens: capacity, refill: now}
+		l.buckets[namespace] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.refill).Seconds()*qps)
+	b.refill = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - b.tokens) / qps * float64(time.Second)), false
+}
+
+// forget drops the bucket of a removed namespace
+func (l *queryLimiter) forget(namespace string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, namespace)
+}
+
+// queryEmbedding returns the embedding of a search query in a namespace,
+// from the query cache if it was embedded recently. Otherwise the embedding
+// API is called, unless the namespace exceeds its query_qps: the search
+// then fails as rate limited, with when to retry.
+func (v *VectorFSPlugin) queryEmbedding(namespace, text string) ([]float32, error) {
+	key := embeddingCacheKey(v.embedder.Model(namespace), text)
+	if embedding, ok := v.queryCache.get(key); ok {
+		v.embedder.recordUsage(namespace, func(u *embeddingUsage) { u.CachedQueries++ })
+		return embedding, nil
+	}
+
+	search := v.namespaceConfig(namespace).search(v.search)
+	if retryAfter, ok := v.queryLimiter.take(namespace, search.QueryQPS, search.QueryBurst); !ok {
+		v.embedder.recordUsage(namespace, func(u *embeddingUsage) { u.ThrottledQueries++ })
+		return nil, fmt.Errorf("searches of %s exceed query_qps (%g per second): %w",
+			namespace, search.QueryQPS, filesystem.NewRateLimitedError(namespace, retryAfter))
+	}
+	embedding, err := v.embedder.GenerateEmbedding(namespace, text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate query embedding: %w", err)
+	}
+	v.queryCache.put(key, embedding)
+	return embedding, nil
+}
@@ -0,0 +1,250 @@
+package vectorfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// quotaFile is the control file of a namespace that reports its usage and
+// overrides its quota:
+//
+//	cat /vectorfs/my_project/.quota
+//	echo 'max_bytes = 1GB' > /vectorfs/my_project/.quota
+const quotaFile = ".quota"
+
+// Quota limits, as named in the plugin config and in .quota
+const (
+	quotaMaxDocuments = "max_documents"
+	quotaMaxBytes     = "max_bytes"
+	quotaMaxChunks    = "max_chunks"
+)
+
+// parseQuotaConfig reads the quota of namespaces without overrides. Every
+// limit defaults to 0, unlimited.
+func parseQuotaConfig(cfg map[string]interface{}) (NamespaceQuota, error) {
+	maxBytes, err := config.GetSizeConfig(cfg, quotaMaxBytes, 0)
+	if err != nil {
+		return NamespaceQuota{}, fmt.Errorf("invalid %s: %w", quotaMaxBytes, err)
+	}
+	quota := NamespaceQuota{
+		MaxDocuments: int64(config.GetIntConfig(cfg, quotaMaxDocuments, 0)),
+		MaxBytes:     maxBytes,
+		MaxChunks:    int64(config.GetIntConfig(cfg, quotaMaxChunks, 0)),
+	}
+	if quota.MaxDocuments < 0 || quota.MaxBytes < 0 || quota.MaxChunks < 0 {
+		return NamespaceQuota{}, fmt.Errorf("%s, %s and %s must not be negative", quotaMaxDocuments, quotaMaxBytes, quotaMaxChunks)
+	}
+	return quota, nil
+}
+
+// resolve returns the limits that apply given the configured defaults
+func (q NamespaceQuota) resolve(defaults NamespaceQuota) NamespaceQuota {
+	pick := func(override, def int64) int64 {
+		if override == quotaInherit {
+			return def
+		}
+		return override
+	}
+	return NamespaceQuota{
+		MaxDocuments: pick(q.MaxDocuments, defaults.MaxDocuments),
+		MaxBytes:     pick(q.MaxBytes, defaults.MaxBytes),
+		MaxChunks:    pick(q.MaxChunks, defaults.MaxChunks),
+	}
+}
+
+func (q NamespaceQuota) unlimited() bool {
+	return q.MaxDocuments == 0 && q.MaxBytes == 0 && q.MaxChunks == 0
+}
+
+// namespaceQuotaError rejects a change that would take a namespace over
+// one of its limits
+type namespaceQuotaError struct {
+	Namespace string
+	Resource  string // documents, bytes or chunks
+	Used      int64
+	Adding    int64
+	Limit     int64
+}
+
+func (e *namespaceQuotaError) Error() string {
+	return fmt.Sprintf("namespace %s: %s quota exceeded (%d in use, adding %d, limit %d)",
+		e.Namespace, e.Resource, e.Used, e.Adding, e.Limit)
+}
+
+func (e *namespaceQuotaError) Is(target error) bool {
+	return target == filesystem.ErrQuotaExceeded
+}
+
+// checkQuota fails if adding to used passes a limit (0 is unlimited)
+func checkQuota(namespace, resource string, used, adding, limit int64) error {
+	if limit > 0 && adding > 0 && used+adding > limit {
+		return &namespaceQuotaError{Namespace: namespace, Resource: resource, Used: used, Adding: adding, Limit: limit}
+	}
+	return nil
+}
+
+// namespaceLimits returns the usage of a namespace and the limits that
+// apply to it
+func namespaceLimits(store VectorStore, defaults NamespaceQuota, namespace string) (NamespaceUsage, NamespaceQuota, error) {
+	usage, err := store.NamespaceUsage(namespace)
+	if err != nil {
+		return usage, NamespaceQuota{}, err
+	}
+	return usage, usage.Quota.resolve(defaults), nil
+}
+
+// quotaLocks serializes the quota check and the write of documents per
+// namespace, so that concurrent writers cannot overshoot a limit together
+type quotaLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func (l *quotaLocks) lock(namespace string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sync.Mutex)
+	}
+	m, ok := l.locks[namespace]
+	if !ok {
+		m = &sync.Mutex{}
+		l.locks[namespace] = m
+	}
+	l.mu.Unlock()
+	m.Lock()
+	return m.Unlock
+}
+
+// reserveQuota checks that a namespace can take documents more documents
+// and bytes more bytes, and, for content still to be indexed, that its
+// chunk quota isn't used up. Chunks are only counted once indexed; the
+// indexer enforces the chunk limit itself. Unless the namespace is
+// unlimited, the check holds the namespace's quota lock until release is
+// called, which must happen once the change is stored. Calling release
+// again does nothing.
+func (vfs *vectorFS) reserveQuota(namespace string, delta func() (documents, bytes int64), indexed bool) (release func(), err error) {
+	usage, limits, err := namespaceLimits(vfs.plugin.store, vfs.plugin.quota, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if limits.unlimited() {
+		return func() {}, nil
+	}
+
+	unlock := vfs.plugin.quotaLocks.lock(namespace)
+	fail := func(err error) (func(), error) {
+		unlock()
+		return nil, err
+	}
+	// Read again now that no other write can change it
+	if usage, err = vfs.plugin.store.NamespaceUsage(namespace); err != nil {
+		return fail(err)
+	}
+	documents, bytes := delta()
+	if err := checkQuota(namespace, "documents", usage.Documents, documents, limits.MaxDocuments); err != nil {
+		return fail(err)
+	}
+	if err := checkQuota(namespace, "bytes", usage.Bytes, bytes, limits.MaxBytes); err != nil {
+		return fail(err)
+	}
+	if indexed {
+		if err := checkQuota(namespace, "chunks", usage.Chunks, 1, limits.MaxChunks); err != nil {
+			return fail(err)
+		}
+	}
+	var once sync.Once
+	return func() { once.Do(unlock) }, nil
+}
+
+// replaceDelta returns how many documents and bytes writing size bytes to
+// fileName adds to a namespace, taking the version it replaces into account
+func (vfs *vectorFS) replaceDelta(namespace, fileName string, size int64) (documents, bytes int64) {
+	if meta, err := vfs.plugin.store.GetFileMetadataByName(namespace, fileName); err == nil {
+		return 0, size - meta.FileSize
+	}
+	return 1, size
+}
+
+// checkChunkQuota fails if replacing the chunks of a document with count
+// new ones would take its namespace over its chunk quota
+func (idx *Indexer) checkChunkQuota(namespace, digest string, count int) error {
+	usage, limits, err := namespaceLimits(idx.store, idx.quota, namespace)
+	if err != nil || limits.MaxChunks == 0 {
+		return err
+	}
+	old, err := idx.store.ListChunks(namespace, digest)
+	if err != nil {
+		return err
+	}
+	return checkQuota(namespace, "chunks", usage.Chunks-int64(len(old)), int64(count)-int64(len(old)), limits.MaxChunks)
+}
+
+// formatQuota renders .quota: each counter with its limit, and whether the
+// limit is the namespace's own
+func formatQuota(usage NamespaceUsage, defaults NamespaceQuota) string {
+	limits := usage.Quota.resolve(defaults)
+	var sb strings.Builder
+	line := func(name string, used, limit, override int64) {
+		fmt.Fprintf(&sb, "%s: %d", name, used)
+		if limit == 0 {
+			sb.WriteString(" / unlimited")
+		} else {
+			fmt.Fprintf(&sb, " / %d", limit)
+		}
+		if override != quotaInherit {
+			sb.WriteString(" (namespace quota)")
+		}
+		sb.WriteString("\n")
+	}
+	line("documents", usage.Documents, limits.MaxDocuments, usage.Quota.MaxDocuments)
+	line("bytes", usage.Bytes, limits.MaxBytes, usage.Quota.MaxBytes)
+	line("chunks", usage.Chunks, limits.MaxChunks, usage.Quota.MaxChunks)
+	return sb.String()
+}
+
+// parseQuotaUpdate applies the "limit = value" lines written to .quota to
+// the overrides of a namespace. A value of 0 is unlimited and "default"
+// drops the override; max_bytes takes sizes such as "500MB".
+func parseQuotaUpdate(data []byte, quota NamespaceQuota) (NamespaceQuota, error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return quota, filesystem.NewInvalidArgumentError("quota", line, "expected limit = value")
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		limit := int64(quotaInherit)
+		if value != "default" {
+			var err error
+			if key == quotaMaxBytes {
+				limit, err = config.ParseSize(value)
+			} else {
+				limit, err = strconv.ParseInt(value, 10, 64)
+			}
+			if err != nil || limit < 0 {
+				return quota, filesystem.NewInvalidArgumentError(key, value, "expected a non-negative limit or default")
+			}
+		}
+		switch key {
+		case quotaMaxDocuments:
+			quota.MaxDocuments = limit
+		case quotaMaxBytes:
+			quota.MaxBytes = limit
+		case quotaMaxChunks:
+			quota.MaxChunks = limit
+		default:
+			return quota, filesystem.NewInvalidArgumentError("quota", key,
+				fmt.Sprintf("unknown limit (expected %s, %s or %s)", quotaMaxDocuments, quotaMaxBytes, quotaMaxChunks))
+		}
+	}
+	return quota, nil
+}
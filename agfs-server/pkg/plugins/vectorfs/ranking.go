@@ -0,0 +1,90 @@
+package vectorfs
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// RankingConfig controls how vector search results are ordered
+type RankingConfig struct {
+	// RecencyHalfLife enables time-decay ranking when non-zero. A document's
+	// recency factor halves every RecencyHalfLife since its last update.
+	RecencyHalfLife time.Duration
+
+	// RecencyWeight is the share of the final score taken by recency (0..1).
+	// The remainder is cosine similarity.
+	RecencyWeight float64
+}
+
+// recencyCandidateFactor is how many extra candidates are fetched from the
+// vector index when recency ranking is on, so newer but slightly less similar
+// chunks have a chance to surface.
+const recencyCandidateFactor = 3
+
+// RankedMatch is a vector match together with its computed scores
+type RankedMatch struct {
+	VectorMatch
+	Similarity float64 // 1 - cosine distance
+	Recency    float64 // Time-decay factor in (0, 1], 1 when recency ranking is off
+	Score      float64 // Final blended score used for ordering
+}
+
+// RecencyEnabled reports whether time-decay ranking is active
+func (rc RankingConfig) RecencyEnabled() bool {
+	return rc.RecencyHalfLife > 0 && rc.RecencyWeight > 0
+}
+
+// Validate checks the ranking configuration
+func (rc RankingConfig) Validate() error {
+	if rc.RecencyHalfLife < 0 {
+		return fmt.Errorf("recency_half_life must be non-negative")
+	}
+	if rc.RecencyWeight < 0 || rc.RecencyWeight > 1 {
+		return fmt.Errorf("recency_weight must be between 0 and 1, got %v", rc.RecencyWeight)
+	}
+	return nil
+}
+
+// candidateLimit returns how many rows to fetch from the index for a query
+func (rc RankingConfig) candidateLimit(limit int) int {
+	if rc.RecencyEnabled() {
+		return limit * recencyCandidateFactor
+	}
+	return limit
+}
+
+// recencyFactor returns 0.5^(age/halfLife). Future timestamps count as fresh.
+func (rc RankingConfig) recencyFactor(updatedAt, now time.Time) float64 {
+	age := now.Sub(updatedAt)
+	if age <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, float64(age)/float64(rc.RecencyHalfLife))
+}
+
+// Rank scores matches and returns at most limit of them, best first
+func (rc RankingConfig) Rank(matches []VectorMatch, limit int, now time.Time) []RankedMatch {
+	ranked := make([]RankedMatch, 0, len(matches))
+	for _, m := range matches {
+		r := RankedMatch{VectorMatch: m, Similarity: 1.0 - m.Distance, Recency: 1}
+		r.Score = r.Similarity
+		if rc.RecencyEnabled() {
+			r.Recency = rc.recencyFactor(m.UpdatedAt, now)
+			r.Score = (1-rc.RecencyWeight)*r.Similarity + rc.RecencyWeight*r.Recency
+		}
+		ranked = append(ranked, r)
+	}
+
+	if rc.RecencyEnabled() {
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return ranked[i].Score > ranked[j].Score
+		})
+	}
+
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
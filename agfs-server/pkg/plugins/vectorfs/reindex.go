@@ -0,0 +1,131 @@
+package vectorfs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// reindexFile is the control file of a namespace that re-chunks and
+// re-embeds documents, e.g. after the chunking or embedding settings changed:
+//
+//	echo guides/setup.md > /vectorfs/my_project/.reindex
+//	echo '*' > /vectorfs/my_project/.reindex
+const reindexFile = ".reindex"
+
+// keepFile makes an otherwise empty docs/ subdirectory visible
+const keepFile = ".keep"
+
+// parseReindexRequest returns the documents listed in a write to .reindex,
+// one per line, relative to docs/. "*" selects every document and a name
+// ending in "/" the documents below that directory.
+func parseReindexRequest(data []byte) []string {
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		name := strings.TrimSpace(line)
+		name = strings.TrimPrefix(strings.TrimPrefix(name, "/"), "docs/")
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// reindex queues the selected documents of a namespace for indexing and
+// returns how many were queued. Their current chunks stay searchable until
+// the new ones replace them.
+func (vfs *vectorFS) reindex(namespace string, names []string) (int, error) {
+	var files []FileMetadata
+	for _, name := range names {
+		switch {
+		case name == "*":
+			all, err := vfs.plugin.store.ListFiles(namespace)
+			if err != nil {
+				return 0, err
+			}
+			files = append(files, all...)
+		case strings.HasSuffix(name, "/"):
+			under, err := vfs.plugin.store.ListFilesWithPrefix(namespace, name)
+			if err != nil {
+				return 0, err
+			}
+			files = append(files, under...)
+		default:
+			meta, err := vfs.plugin.store.GetFileMetadataByName(namespace, name)
+			if err != nil {
+				return 0, fmt.Errorf("cannot reindex %s: %w", name, filesystem.ErrNotFound)
+			}
+			files = append(files, *meta)
+		}
+	}
+
+	queued := 0
+	seen := make(map[string]bool)
+	for _, f := range files {
+		// Sidecars and directory placeholders have nothing to index
+		if _, isSidecar := sidecarTarget(f.FileName); isSidecar || seen[f.FileDigest] || f.FileSize == 0 {
+			continue
+		}
+		seen[f.FileDigest] = true
+		data, err := vfs.plugin.docs.DownloadDocument(context.Background(), namespace, f.FileDigest)
+		if err != nil {
+			return queued, fmt.Errorf("failed to read %s: %w", f.FileName, err)
+		}
+		if !vfs.plugin.indexer.CanIndex(f.FileName, data) {
+			continue
+		}
+		vfs.plugin.queueIndexing(indexTask{
+			namespace: namespace,
+			digest:    f.FileDigest,
+			fileName:  f.FileName,
+			data:      string(data),
+		})
+		queued++
+	}
+	log.Infof("[vectorfs] Queued %d document(s) of %s for re-indexing", queued, namespace)
+	return queued, nil
+}
+
+// removeDocument deletes a document with its chunks and stored content. A
+// removed sidecar also clears the metadata of its document's chunks.
+func (vfs *vectorFS) removeDocument(namespace string, meta *FileMetadata) error {
+	if err := vfs.plugin.indexer.DeleteDocument(namespace, meta.FileDigest); err != nil {
+		return err
+	}
+	vfs.plugin.removeIndexingTask(namespace, meta.FileDigest)
+	if target, isSidecar := sidecarTarget(meta.FileName); isSidecar {
+		if targetMeta, err := vfs.plugin.store.GetFileMetadataByName(namespace, target); err == nil {
+			if err := vfs.plugin.store.UpdateChunkMetadata(namespace, targetMeta.FileDigest, nil); err != nil {
+				return fmt.Errorf("failed to clear metadata of %s: %w", target, err)
+			}
+		}
+	}
+	return nil
+}
+
+// removeDirectory removes a docs/ subdirectory. Without recursive, only a
+// directory holding nothing but its placeholder can be removed.
+func (vfs *vectorFS) removeDirectory(namespace, dir string, recursive bool) error {
+	files, err := vfs.plugin.store.ListFilesWithPrefix(namespace, dir+"/")
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		if recursive {
+			return nil
+		}
+		return filesystem.ErrNotFound
+	}
+	if !recursive && (len(files) > 1 || files[0].FileName != dir+"/"+keepFile) {
+		return fmt.Errorf("directory not empty: docs/%s", dir)
+	}
+	for i := range files {
+		if err := vfs.removeDocument(namespace, &files[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
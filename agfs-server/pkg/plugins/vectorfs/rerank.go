@@ -0,0 +1,361 @@
+package vectorfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Rerank providers accepted by the "rerank_provider" config key
+const (
+	// RerankProviderCohere uses the Cohere Rerank API
+	RerankProviderCohere = "cohere"
+	// RerankProviderOpenAI asks an OpenAI chat model to grade each result
+	RerankProviderOpenAI = "openai"
+	// RerankProviderLocal uses a cross-encoder served with a Cohere-compatible
+	// /rerank API, such as llama.cpp, Text Embeddings Inference or Infinity
+	RerankProviderLocal = "local"
+)
+
+// rerankProviders lists the valid rerank_provider values
+var rerankProviders = []string{RerankProviderCohere, RerankProviderOpenAI, RerankProviderLocal}
+
+const (
+	defaultCohereRerankEndpoint = "https://api.cohere.com/v2/rerank"
+	defaultOpenAIChatEndpoint   = "https://api.openai.com/v1/chat/completions"
+
+	// defaultRerankTopN is how many first-stage results are reranked
+	defaultRerankTopN = 50
+)
+
+// defaultRerankModel returns the model used when rerank_model is unset
+func defaultRerankModel(provider string) string {
+	switch provider {
+	case RerankProviderCohere:
+		return "rerank-v3.5"
+	case RerankProviderOpenAI:
+		return "gpt-4o-mini"
+	}
+	return ""
+}
+
+// RerankConfig holds the configuration of the reranking stage
+type RerankConfig struct {
+	Provider string // Provider name; empty disables reranking
+	APIKey   string // API key; optional for local
+	Model    string // Model name
+	Endpoint string // API endpoint (empty = provider default; required for local)
+	TopN     int    // First-stage results reranked per search
+}
+
+// parseRerankConfig reads the optional reranking settings. The OpenAI key of
+// the embedding configuration is reused when rerank_api_key is unset.
+func parseRerankConfig(cfg map[string]interface{}) (RerankConfig, error) {
+	provider := config.GetStringConfig(cfg, "rerank_provider", "")
+	rc := RerankConfig{
+		Provider: provider,
+		APIKey:   config.GetStringConfig(cfg, "rerank_api_key", ""),
+		Model:    config.GetStringConfig(cfg, "rerank_model", defaultRerankModel(provider)),
+		Endpoint: config.GetStringConfig(cfg, "rerank_endpoint", ""),
+		TopN:     config.GetIntConfig(cfg, "rerank_top_n", defaultRerankTopN),
+	}
+	if rc.APIKey == "" && provider == RerankProviderOpenAI {
+		rc.APIKey = config.GetStringConfig(cfg, "openai_api_key", "")
+	}
+	return rc, rc.Validate()
+}
+
+// Validate checks the reranking configuration
+func (rc RerankConfig) Validate() error {
+	switch rc.Provider {
+	case "":
+		return nil
+	case RerankProviderCohere:
+		if rc.APIKey == "" && rc.Endpoint == "" {
+			return fmt.Errorf("rerank_api_key is required when rerank_provider is cohere")
+		}
+	case RerankProviderOpenAI:
+		if rc.APIKey == "" && rc.Endpoint == "" {
+			return fmt.Errorf("rerank_api_key or openai_api_key is required when rerank_provider is openai")
+		}
+	case RerankProviderLocal:
+		if rc.Endpoint == "" {
+			return fmt.Errorf("rerank_endpoint is required when rerank_provider is local")
+		}
+	default:
+		return fmt.Errorf("unsupported rerank_provider: %s (supported: %s)", rc.Provider, strings.Join(rerankProviders, ", "))
+	}
+	if rc.TopN <= 0 {
+		return fmt.Errorf("rerank_top_n must be positive, got %d", rc.TopN)
+	}
+	return nil
+}
+
+// Reranker scores how relevant documents are to a query, more precisely
+// but more slowly than vector similarity
+type Reranker interface {
+	// Rerank returns the relevance of each document to query, higher is
+	// better, in document order
+	Rerank(query string, documents []string) ([]float64, error)
+}
+
+// NewReranker creates the reranker of a configuration, or nil if reranking
+// is disabled
+func NewReranker(rc RerankConfig) (Reranker, error) {
+	if err := rc.Validate(); err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 60 * time.Second}
+	switch rc.Provider {
+	case RerankProviderCohere, RerankProviderLocal:
+		endpoint := rc.Endpoint
+		if endpoint == "" {
+			endpoint = defaultCohereRerankEndpoint
+		}
+		return &cohereReranker{name: rc.Provider, apiKey: rc.APIKey, model: rc.Model, endpoint: endpoint, client: client}, nil
+	case RerankProviderOpenAI:
+		endpoint := rc.Endpoint
+		if endpoint == "" {
+			endpoint = defaultOpenAIChatEndpoint
+		}
+		return &openAIReranker{apiKey: rc.APIKey, model: rc.Model, endpoint: endpoint, client: client}, nil
+	}
+	return nil, nil
+}
+
+// postJSON sends a JSON request, authenticated with apiKey as a bearer token
+// if set, and decodes the JSON response into out
+func postJSON(client *http.Client, endpoint, apiKey, provider string, in, out interface{}) error {
+	header := http.Header{}
+	if apiKey != "" {
+		header.Set("Authorization", "Bearer "+apiKey)
+	}
+	return postJSONWithHeader(client, endpoint, header, provider, in, out)
+}
+
+// postJSONWithHeader is postJSON for APIs with their own auth headers
+func postJSONWithHeader(client *http.Client, endpoint string, header http.Header, provider string, in, out interface{}) error {
+	jsonData, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return &embeddingAPIError{Provider: provider, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// ============================================================================
+// Cohere and local cross-encoders
+// ============================================================================
+
+type cohereRerankRequest struct {
+	Model     string   `json:"model,omitempty"`
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+	TopN      int      `json:"top_n"`
+}
+
+type cohereRerankResponse struct {
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float64 `json:"relevance_score"`
+	} `json:"results"`
+}
+
+// cohereReranker calls a Cohere-compatible /rerank API
+type cohereReranker struct {
+	name     string
+	apiKey   string
+	model    string
+	endpoint string
+	client   *http.Client
+}
+
+func (r *cohereReranker) Rerank(query string, documents []string) ([]float64, error) {
+	var response cohereRerankResponse
+	request := cohereRerankRequest{Model: r.model, Query: query, Documents: documents, TopN: len(documents)}
+	if err := postJSON(r.client, r.endpoint, r.apiKey, r.name, request, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Results) != len(documents) {
+		return nil, fmt.Errorf("expected %d rerank scores, got %d", len(documents), len(response.Results))
+	}
+	scores := make([]float64, len(documents))
+	for _, result := range response.Results {
+		if result.Index < 0 || result.Index >= len(documents) {
+			return nil, fmt.Errorf("rerank result index %d out of range", result.Index)
+		}
+		scores[result.Index] = result.RelevanceScore
+	}
+	return scores, nil
+}
+
+// ============================================================================
+// OpenAI
+// ============================================================================
+
+// openAIRerankPrompt asks a chat model to grade passages
+const openAIRerankPrompt = `Rate how well each passage answers the search query, from 0 (unrelated) to 10 (fully answers it). ` +
+	`Reply with a JSON object {"scores": [...]} holding one number per passage, in passage order.`
+
+type openAIChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIChatRequest struct {
+	Model          string              `json:"model"`
+	Messages       []openAIChatMessage `json:"messages"`
+	Temperature    float64             `json:"temperature"`
+	ResponseFormat map[string]string   `json:"response_format"`
+}
+
+type openAIChatResponse struct {
+	Choices []struct {
+		Message openAIChatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+// openAIReranker grades results with an OpenAI chat model, which has no
+// rerank API
+type openAIReranker struct {
+	apiKey   string
+	model    string
+	endpoint string
+	client   *http.Client
+}
+
+func (r *openAIReranker) Rerank(query string, documents []string) ([]float64, error) {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Query: %s\n", query)
+	for i, doc := range documents {
+		fmt.Fprintf(&prompt, "\nPassage %d:\n%s\n", i+1, doc)
+	}
+	request := openAIChatRequest{
+		Model: r.model,
+		Messages: []openAIChatMessage{
+			{Role: "system", Content: openAIRerankPrompt},
+			{Role: "user", Content: prompt.String()},
+		},
+		ResponseFormat: map[string]string{"type": "json_object"},
+	}
+
+	var response openAIChatResponse
+	if err := postJSON(r.client, r.endpoint, r.apiKey, "OpenAI", request, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Choices) == 0 {
+		return nil, fmt.Errorf("no completion returned from API")
+	}
+	var graded struct {
+		Scores []float64 `json:"scores"`
+	}
+	if err := json.Unmarshal([]byte(response.Choices[0].Message.Content), &graded); err != nil {
+		return nil, fmt.Errorf("failed to parse rerank scores: %w", err)
+	}
+	if len(graded.Scores) != len(documents) {
+		return nil, fmt.Errorf("expected %d rerank scores, got %d", len(documents), len(graded.Scores))
+	}
+	for i := range graded.Scores {
+		graded.Scores[i] /= 10
+	}
+	return graded.Scores, nil
+}
+
+// ============================================================================
+// Search integration
+// ============================================================================
+
+// rerankCandidates returns how many first-stage results a search returning
+// limit results fetches
+func (v *VectorFSPlugin) rerankCandidates(limit int) int {
+	if v.reranker == nil || v.rerank.TopN <= limit {
+		return limit
+	}
+	return v.rerank.TopN
+}
+
+// searchCandidates returns how many first-stage results a search returning
+// limit results fetches, for reranking, scoring hooks and diversification
+func (v *VectorFSPlugin) searchCandidates(limit int, d diversity, hooked bool) int {
+	candidates := max(v.rerankCandidates(limit), d.candidateLimit(limit))
+	if hooked {
+		candidates = max(candidates, limit*scoringHookCandidateFactor)
+	}
+	return candidates
+}
+
+// rankResults turns first-stage results into the best limit results of a
+// search: they are reranked, adjusted by the scoring hooks of their
+// namespace if hooked, then diversified
+func (v *VectorFSPlugin) rankResults(namespace, query string, results []mountablefs.CustomGrepResult, d diversity, hooked bool, limit int) []mountablefs.CustomGrepResult {
+	if !hooked && !d.enabled() {
+		return v.rerankResults(query, results, limit)
+	}
+	results = v.rerankResults(query, results, 0)
+	if hooked {
+		results = v.scoreResults(namespace, query, results)
+	}
+	return d.apply(results, limit)
+}
+
+// rerankResults reorders first-stage results by reranker relevance and
+// keeps the best limit of them. Each result keeps its first-stage score as
+// "first_stage_score" and rank as "first_stage_rank"; "score" becomes the
+// rerank score. If the reranker fails, the first-stage order is kept, so a
+// reranking outage degrades search quality rather than failing searches.
+func (v *VectorFSPlugin) rerankResults(query string, results []mountablefs.CustomGrepResult, limit int) []mountablefs.CustomGrepResult {
+	if v.reranker != nil && strings.TrimSpace(query) != "" && len(results) > 1 {
+		documents := make([]string, len(results))
+		for i, r := range results {
+			documents[i] = r.Content
+		}
+		scores, err := v.reranker.Rerank(query, documents)
+		if err != nil {
+			log.Warnf("[vectorfs] Reranking failed, keeping vector ranking: %v", err)
+		} else {
+			for i := range results {
+				if results[i].Metadata == nil {
+					results[i].Metadata = make(map[string]interface{})
+				}
+				results[i].Metadata["first_stage_rank"] = i + 1
+				results[i].Metadata["first_stage_score"] = resultScore(results[i])
+				results[i].Metadata["score"] = scores[i]
+			}
+			sort.SliceStable(results, func(i, j int) bool { return resultScore(results[i]) > resultScore(results[j]) })
+		}
+	}
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
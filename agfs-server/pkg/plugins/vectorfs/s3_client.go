@@ -5,11 +5,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/creds"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -21,6 +23,8 @@ type S3Config struct {
 	KeyPrefix string
 	Region    string
 	Endpoint  string
+
+	Credentials *creds.Cache // Optional provider, instead of the keys
 }
 
 // S3Client handles S3 operations for document storage
@@ -38,7 +42,12 @@ func NewS3Client(cfg S3Config) (*S3Client, error) {
 	var err error
 
 	// Configure AWS SDK
-	if cfg.AccessKey != "" && cfg.SecretKey != "" {
+	if cfg.Credentials != nil {
+		awsCfg, err = config.LoadDefaultConfig(ctx,
+			config.WithRegion(cfg.Region),
+			config.WithCredentialsProvider(cfg.Credentials.AWS()),
+		)
+	} else if cfg.AccessKey != "" && cfg.SecretKey != "" {
 		// Use static credentials
 		awsCfg, err = config.LoadDefaultConfig(ctx,
 			config.WithRegion(cfg.Region),
@@ -143,6 +152,35 @@ func (c *S3Client) DocumentExists(ctx context.Context, namespace, digest string)
 	return true, nil
 }
 
+// ListDocuments lists the documents of a namespace in S3
+func (c *S3Client) ListDocuments(ctx context.Context, namespace string) ([]StoredDocument, error) {
+	prefix := c.buildKey(namespace, "")
+	paginator := s3.NewListObjectsV2Paginator(c.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(c.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	var docs []StoredDocument
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list S3 objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			digest := strings.TrimPrefix(aws.ToString(obj.Key), prefix)
+			if digest == "" || strings.Contains(digest, "/") {
+				continue
+			}
+			docs = append(docs, StoredDocument{
+				Digest:  digest,
+				Size:    aws.ToInt64(obj.Size),
+				ModTime: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return docs, nil
+}
+
 // DeleteDocument deletes a document from S3
 func (c *S3Client) DeleteDocument(ctx context.Context, namespace, digest string) error {
 	key := c.buildKey(namespace, digest)
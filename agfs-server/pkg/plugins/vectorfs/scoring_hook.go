@@ -0,0 +1,219 @@
+package vectorfs
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// scoringHookNone is the scoring_hook of a namespace that opts out of the
+// plugin's hook
+const scoringHookNone = "none"
+
+// defaultScoringHookTimeout bounds each call of a scoring webhook
+const defaultScoringHookTimeout = 5 * time.Second
+
+// scoringHookCandidateFactor is how many extra candidates are fetched when
+// a scoring hook may drop results
+const scoringHookCandidateFactor = 3
+
+// ScoringHook adjusts search results after retrieval, for ranking rules
+// that only the deployment knows, such as boosting the documents of a team
+type ScoringHook interface {
+	// Score returns the results to keep, with their "score" metadata
+	// adjusted as needed. Results come best first, with their metadata
+	// sidecar under "meta"; the returned ones are sorted by score again.
+	Score(namespace, query string, results []mountablefs.CustomGrepResult) ([]mountablefs.CustomGrepResult, error)
+}
+
+var (
+	scoringHooksMu sync.RWMutex
+	scoringHooks   = make(map[string]ScoringHook)
+)
+
+// RegisterScoringHook makes a hook compiled into the server available as
+// scoring_hook = "name". Register hooks from an init function, before the
+// plugin is configured.
+func RegisterScoringHook(name string, hook ScoringHook) {
+	if name == "" || name == scoringHookNone || isWebhookURL(name) {
+		panic(fmt.Sprintf("vectorfs: invalid scoring hook name %q", name))
+	}
+	scoringHooksMu.Lock()
+	defer scoringHooksMu.Unlock()
+	scoringHooks[name] = hook
+}
+
+// isWebhookURL reports whether a scoring_hook names a webhook
+func isWebhookURL(hook string) bool {
+	return strings.HasPrefix(hook, "http://") || strings.HasPrefix(hook, "https://")
+}
+
+// validateScoringHook checks that a scoring_hook is empty, "none", a
+// webhook URL or a registered hook
+func validateScoringHook(hook string) error {
+	if hook == "" || hook == scoringHookNone || isWebhookURL(hook) {
+		return nil
+	}
+	scoringHooksMu.RLock()
+	defer scoringHooksMu.RUnlock()
+	if _, ok := scoringHooks[hook]; !ok {
+		names := make([]string, 0, len(scoringHooks))
+		for name := range scoringHooks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown scoring hook %q: expected an http(s) webhook URL, %q or a registered hook (%s)",
+			hook, scoringHookNone, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// parseScoringHookConfig reads the scoring hook of every namespace that
+// doesn't set its own, and the timeout of webhook calls
+func parseScoringHookConfig(cfg map[string]interface{}) (hook string, timeout time.Duration, err error) {
+	hook = config.GetStringConfig(cfg, "scoring_hook", "")
+	if err := validateScoringHook(hook); err != nil {
+		return "", 0, err
+	}
+	timeout = defaultScoringHookTimeout
+	if value := config.GetStringConfig(cfg, "scoring_hook_timeout", ""); value != "" {
+		if timeout, err = time.ParseDuration(value); err != nil {
+			return "", 0, fmt.Errorf("invalid scoring_hook_timeout %q: %w", value, err)
+		}
+		if timeout <= 0 {
+			return "", 0, fmt.Errorf("scoring_hook_timeout must be positive")
+		}
+	}
+	return hook, timeout, nil
+}
+
+// webhookScoringHook asks an HTTP endpoint to score results
+type webhookScoringHook struct {
+	url    string
+	client *http.Client
+}
+
+// scoringWebhookRequest is the body POSTed to a scoring webhook
+type scoringWebhookRequest struct {
+	Namespace string                 `json:"namespace"`
+	Query     string                 `json:"query"`
+	Results   []scoringWebhookResult `json:"results"`
+}
+
+type scoringWebhookResult struct {
+	ID      int                    `json:"id"` // Position in the request
+	File    string                 `json:"file"`
+	Line    int                    `json:"line"`
+	Content string                 `json:"content"`
+	Score   float64                `json:"score"`
+	Meta    map[string]interface{} `json:"meta,omitempty"`
+}
+
+// scoringWebhookResponse lists the results to keep by id, with an optional
+// new score each
+type scoringWebhookResponse struct {
+	Results []struct {
+		ID    int      `json:"id"`
+		Score *float64 `json:"score"`
+	} `json:"results"`
+}
+
+func (h *webhookScoringHook) Score(namespace, query string, results []mountablefs.CustomGrepResult) ([]mountablefs.CustomGrepResult, error) {
+	req := scoringWebhookRequest{Namespace: namespace, Query: query, Results: make([]scoringWebhookResult, len(results))}
+	for i, r := range results {
+		meta, _ := r.Metadata["meta"].(map[string]interface{})
+		req.Results[i] = scoringWebhookResult{ID: i, File: r.File, Line: r.Line, Content: r.Content, Score: resultScore(r), Meta: meta}
+	}
+	var resp scoringWebhookResponse
+	if err := postJSON(h.client, h.url, "", "scoring webhook", req, &resp); err != nil {
+		return nil, err
+	}
+
+	kept := make([]mountablefs.CustomGrepResult, 0, len(resp.Results))
+	seen := make(map[int]bool, len(resp.Results))
+	for _, scored := range resp.Results {
+		if scored.ID < 0 || scored.ID >= len(results) || seen[scored.ID] {
+			return nil, fmt.Errorf("scoring webhook returned unknown or repeated result id %d", scored.ID)
+		}
+		seen[scored.ID] = true
+		r := results[scored.ID]
+		if scored.Score != nil {
+			if r.Metadata == nil {
+				r.Metadata = make(map[string]interface{})
+			}
+			r.Metadata["score"] = *scored.Score
+		}
+		kept = append(kept, r)
+	}
+	return kept, nil
+}
+
+// scoringHook returns the scoring hook of a namespace, or nil if it has
+// none. Its own scoring_hook takes precedence over the plugin's.
+func (v *VectorFSPlugin) scoringHook(namespace string) ScoringHook {
+	name := v.scoringHookName
+	if hook := v.namespaceConfig(namespace).ScoringHook; hook != "" {
+		name = hook
+	}
+	switch {
+	case name == "" || name == scoringHookNone:
+		return nil
+	case isWebhookURL(name):
+		return &webhookScoringHook{url: name, client: v.scoringHookClient}
+	}
+	scoringHooksMu.RLock()
+	defer scoringHooksMu.RUnlock()
+	return scoringHooks[name]
+}
+
+// scoreResults runs the scoring hook of each namespace over its results,
+// ordered best first. Federated results are grouped by their "namespace"
+// metadata. If a hook fails, the results of its namespace are kept as they
+// are, so a hook outage degrades ranking rather than failing searches.
+func (v *VectorFSPlugin) scoreResults(namespace, query string, results []mountablefs.CustomGrepResult) []mountablefs.CustomGrepResult {
+	var order []string
+	groups := make(map[string][]mountablefs.CustomGrepResult)
+	for _, r := range results {
+		ns := namespace
+		if n, ok := r.Metadata["namespace"].(string); ok {
+			ns = n
+		}
+		if _, ok := groups[ns]; !ok {
+			order = append(order, ns)
+		}
+		groups[ns] = append(groups[ns], r)
+	}
+
+	scored := make([]mountablefs.CustomGrepResult, 0, len(results))
+	for _, ns := range order {
+		group := groups[ns]
+		if hook := v.scoringHook(ns); hook != nil {
+			kept, err := hook.Score(ns, query, group)
+			if err != nil {
+				log.Warnf("[vectorfs] Scoring hook of %s failed, keeping its results: %v", ns, err)
+			} else {
+				group = kept
+			}
+		}
+		scored = append(scored, group...)
+	}
+	sort.SliceStable(scored, func(i, j int) bool { return resultScore(scored[i]) > resultScore(scored[j]) })
+	return scored
+}
+
+// hasScoringHook reports whether any of namespaces has a scoring hook
+func (v *VectorFSPlugin) hasScoringHook(namespaces ...string) bool {
+	for _, ns := range namespaces {
+		if v.scoringHook(ns) != nil {
+			return true
+		}
+	}
+	return false
+}
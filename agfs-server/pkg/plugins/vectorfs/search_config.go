@@ -0,0 +1,111 @@
+package vectorfs
+
+import (
+	"fmt"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// Result count defaults of a search
+const (
+	defaultTopK    = 10
+	defaultMaxTopK = 100
+)
+
+// SearchConfig holds the result count and score threshold of searches that
+// don't set their own with the topk: and minscore: modifiers or a request
+// limit (fsgrep -n / -m)
+type SearchConfig struct {
+	DefaultTopK int     // Results returned when nothing else sets a count
+	MaxTopK     int     // Upper bound on any requested count
+	MinScore    float64 // Results scoring below are dropped; 0 keeps all
+
+	// Result diversity, unless set with the mmr: and dedup: modifiers
+	MMRLambda      float64 // Relevance weight of MMR selection; 0 or 1 is off
+	DedupThreshold float64 // Similarity collapsing chunks of a file; 0 is off
+
+	// Search queries a second sent to the embedding API per namespace, in
+	// bursts of up to QueryBurst; 0 is unlimited. Cached queries are free.
+	QueryQPS   float64
+	QueryBurst int // 0 = QueryQPS rounded up
+}
+
+// parseSearchConfig reads the optional search defaults
+func parseSearchConfig(cfg map[string]interface{}) (SearchConfig, error) {
+	sc := SearchConfig{
+		DefaultTopK: config.GetIntConfig(cfg, "default_topk", defaultTopK),
+		MaxTopK:     config.GetIntConfig(cfg, "max_topk", defaultMaxTopK),
+		MinScore:    config.GetFloat64Config(cfg, "min_score", 0),
+
+		MMRLambda:      config.GetFloat64Config(cfg, "mmr_lambda", 0),
+		DedupThreshold: config.GetFloat64Config(cfg, "dedup_threshold", defaultDedupThreshold),
+
+		QueryQPS:   config.GetFloat64Config(cfg, "query_qps", 0),
+		QueryBurst: config.GetIntConfig(cfg, "query_burst", 0),
+	}
+	return sc, sc.Validate()
+}
+
+// Validate checks the search configuration
+func (sc SearchConfig) Validate() error {
+	if sc.DefaultTopK <= 0 {
+		return fmt.Errorf("default_topk must be positive, got %d", sc.DefaultTopK)
+	}
+	if sc.MaxTopK < sc.DefaultTopK {
+		return fmt.Errorf("max_topk (%d) must be at least default_topk (%d)", sc.MaxTopK, sc.DefaultTopK)
+	}
+	if sc.MinScore < 0 || sc.MinScore > 1 {
+		return fmt.Errorf("min_score must be between 0 and 1, got %v", sc.MinScore)
+	}
+	if sc.MMRLambda < 0 || sc.MMRLambda > 1 {
+		return fmt.Errorf("mmr_lambda must be between 0 and 1, got %v", sc.MMRLambda)
+	}
+	if sc.DedupThreshold < 0 || sc.DedupThreshold > 1 {
+		return fmt.Errorf("dedup_threshold must be between 0 and 1, got %v", sc.DedupThreshold)
+	}
+	if sc.QueryQPS < 0 {
+		return fmt.Errorf("query_qps must not be negative, got %v", sc.QueryQPS)
+	}
+	if sc.QueryBurst < 0 {
+		return fmt.Errorf("query_burst must not be negative, got %d", sc.QueryBurst)
+	}
+	return nil
+}
+
+// resultLimit returns how many results a search returns: the topk modifier,
+// else the requested limit, else the default, capped at MaxTopK
+func (sc SearchConfig) resultLimit(requested int, mods searchModifiers) int {
+	limit := sc.DefaultTopK
+	if limit <= 0 {
+		limit = defaultTopK
+	}
+	if mods.topK > 0 {
+		limit = mods.topK
+	} else if requested > 0 {
+		limit = requested
+	}
+	if sc.MaxTopK > 0 && limit > sc.MaxTopK {
+		limit = sc.MaxTopK
+	}
+	return limit
+}
+
+// diversity returns how the results of a search are diversified
+func (sc SearchConfig) diversity(mods searchModifiers) diversity {
+	d := diversity{lambda: sc.MMRLambda, threshold: sc.DedupThreshold}
+	if mods.mmrSet {
+		d.lambda = mods.mmrLambda
+	}
+	if mods.dedupSet {
+		d.threshold = mods.dedupThreshold
+	}
+	return d
+}
+
+// minScore returns the score threshold of a search
+func (sc SearchConfig) minScore(mods searchModifiers) float64 {
+	if mods.minScoreSet {
+		return mods.minScore
+	}
+	return sc.MinScore
+}
@@ -0,0 +1,240 @@
+package vectorfs
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+// searchDir is the virtual directory of a namespace whose subdirectories are
+// searches: <namespace>/search/<url-encoded query>/ lists one file per result
+const searchDir = "search"
+
+const (
+	// searchDirTTL is how long the results of a search directory are reused,
+	// so that listing it and reading each result runs the search once
+	searchDirTTL = 30 * time.Second
+	// searchDirCacheSize bounds the searches kept
+	searchDirCacheSize = 64
+)
+
+// searchDirResults are the results of a search directory
+type searchDirResults struct {
+	names   []string // Result file names, in rank order
+	results []mountablefs.CustomGrepResult
+	at      time.Time
+}
+
+// searchDirCache keeps recent search directory results, keyed by namespace
+// and query
+type searchDirCache struct {
+	mu      sync.Mutex
+	entries map[string]*searchDirResults
+}
+
+func (c *searchDirCache) get(key string, now time.Time) (*searchDirResults, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || now.Sub(entry.at) > searchDirTTL {
+		return nil, false
+	}
+	return entry, true
+}
+
+func (c *searchDirCache) put(key string, entry *searchDirResults) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*searchDirResults)
+	}
+	if len(c.entries) >= searchDirCacheSize {
+		// Drop expired searches, or the oldest one if none has expired
+		var oldest string
+		for k, e := range c.entries {
+			if entry.at.Sub(e.at) > searchDirTTL {
+				delete(c.entries, k)
+			} else if oldest == "" || e.at.Before(c.entries[oldest].at) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= searchDirCacheSize {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = entry
+}
+
+// searchResultName names the result file of a result: its rank, zero-padded
+// so that listings sort in rank order, the document path with slashes
+// replaced and the chunk number, e.g. "01-guides_deploy.md-3.txt"
+func searchResultName(rank, count int, r mountablefs.CustomGrepResult) string {
+	width := max(2, len(strconv.Itoa(count)))
+	_, doc, _ := strings.Cut(r.File, "/docs/")
+	return fmt.Sprintf("%0*d-%s-%d.txt", width, rank, strings.ReplaceAll(doc, "/", "_"), r.Line)
+}
+
+// formatSearchResult renders a result file: the document, chunk and score,
+// a blank line, then the chunk text
+func formatSearchResult(r mountablefs.CustomGrepResult) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "file: %s\n", r.File)
+	fmt.Fprintf(&sb, "chunk: %d\n", r.Line)
+	fmt.Fprintf(&sb, "score: %.4f\n", resultScore(r))
+	if first, ok := r.Metadata["first_stage_score"].(float64); ok {
+		fmt.Fprintf(&sb, "first_stage_score: %.4f\n", first)
+	}
+	sb.WriteString("\n")
+	sb.WriteString(r.Content)
+	if !strings.HasSuffix(r.Content, "\n") {
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// splitSearchPath splits a path below search/ into the decoded query and
+// the result file name. Both are empty for search/ itself.
+func splitSearchPath(rest string) (query, name string, err error) {
+	if rest == "" {
+		return "", "", nil
+	}
+	encoded, name, _ := strings.Cut(rest, "/")
+	if strings.Contains(name, "/") {
+		return "", "", filesystem.NewNotFoundError("stat", rest)
+	}
+	query, err = url.PathUnescape(encoded)
+	if err != nil {
+		return "", "", filesystem.NewInvalidArgumentError("query", encoded, "not a valid URL-encoded query")
+	}
+	if strings.TrimSpace(query) == "" {
+		return "", "", filesystem.NewInvalidArgumentError("query", encoded, "empty query")
+	}
+	return query, name, nil
+}
+
+// searchDirResults runs the search of a search directory, or reuses its
+// recent results
+func (vfs *vectorFS) searchDirResults(namespace, query string) (*searchDirResults, error) {
+	key := namespace + "\x00" + query
+	now := time.Now()
+	if entry, ok := vfs.plugin.searchDirs.get(key, now); ok {
+		return entry, nil
+	}
+	exists, err := vfs.plugin.store.NamespaceExists(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, filesystem.NewNotFoundError("search", namespace)
+	}
+	results, err := vfs.VectorSearch(namespace, query, 0)
+	if err != nil {
+		return nil, err
+	}
+	entry := &searchDirResults{results: results, at: now}
+	for i, r := range results {
+		entry.names = append(entry.names, searchResultName(i+1, len(results), r))
+	}
+	vfs.plugin.searchDirs.put(key, entry)
+	return entry, nil
+}
+
+// searchResult finds a result file of a search directory
+func (vfs *vectorFS) searchResult(namespace, query, name string) (*searchDirResults, *mountablefs.CustomGrepResult, error) {
+	entry, err := vfs.searchDirResults(namespace, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, n := range entry.names {
+		if n == name {
+			return entry, &entry.results[i], nil
+		}
+	}
+	return nil, nil, filesystem.NewNotFoundError("read", namespace+"/"+searchDir+"/"+name)
+}
+
+// readSearchFile reads a result file below search/
+func (vfs *vectorFS) readSearchFile(namespace, rest string) ([]byte, error) {
+	query, name, err := splitSearchPath(rest)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, fmt.Errorf("cannot read directory, specify a file")
+	}
+	_, result, err := vfs.searchResult(namespace, query, name)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(formatSearchResult(*result)), nil
+}
+
+// readSearchDir lists search/, which is always empty, or the results of a
+// search
+func (vfs *vectorFS) readSearchDir(namespace, rest string) ([]filesystem.FileInfo, error) {
+	query, name, err := splitSearchPath(rest)
+	if err != nil {
+		return nil, err
+	}
+	if query == "" {
+		return []filesystem.FileInfo{}, nil
+	}
+	if name != "" {
+		return nil, fmt.Errorf("not a directory")
+	}
+	entry, err := vfs.searchDirResults(namespace, query)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]filesystem.FileInfo, 0, len(entry.results))
+	for i, r := range entry.results {
+		infos = append(infos, searchResultInfo(entry.names[i], r, entry.at))
+	}
+	return infos, nil
+}
+
+// statSearchEntry returns the file info of search/, a search directory or
+// a result file. Any query is a directory; it is searched when listed.
+func (vfs *vectorFS) statSearchEntry(namespace, rest string) (*filesystem.FileInfo, error) {
+	query, name, err := splitSearchPath(rest)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		dirName := searchDir
+		if query != "" {
+			dirName = filepath.Base(rest)
+		}
+		return &filesystem.FileInfo{
+			Name:    dirName,
+			Mode:    0555,
+			ModTime: time.Now(),
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "search"},
+		}, nil
+	}
+	entry, result, err := vfs.searchResult(namespace, query, name)
+	if err != nil {
+		return nil, err
+	}
+	info := searchResultInfo(name, *result, entry.at)
+	return &info, nil
+}
+
+func searchResultInfo(name string, r mountablefs.CustomGrepResult, at time.Time) filesystem.FileInfo {
+	return filesystem.FileInfo{
+		Name:    name,
+		Size:    int64(len(formatSearchResult(r))),
+		Mode:    0444,
+		ModTime: at,
+		IsDir:   false,
+		Meta:    filesystem.MetaData{Name: PluginName, Type: "search_result"},
+	}
+}
@@ -0,0 +1,250 @@
+package vectorfs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// deletedNamespacesTable records the soft-deleted namespaces of a vector
+// store. Their tables stay in place, hidden, until they are purged.
+const deletedNamespacesTable = "tbl_deleted_namespaces"
+
+// softDeletePurgeInterval is how often namespaces past their retention
+// period are purged
+const softDeletePurgeInterval = time.Minute
+
+// parseSoftDeleteRetention reads how long deleted namespaces are kept, as a
+// duration such as "72h". Unset or 0 deletes namespaces at once.
+func parseSoftDeleteRetention(cfg map[string]interface{}) (time.Duration, error) {
+	value := config.GetStringConfig(cfg, "soft_delete_retention", "")
+	if value == "" {
+		return 0, nil
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid soft_delete_retention %q: %w", value, err)
+	}
+	if retention < 0 {
+		return 0, fmt.Errorf("soft_delete_retention must not be negative")
+	}
+	return retention, nil
+}
+
+// createDeletedNamespacesTable creates the table of soft-deleted namespaces
+// if missing. The schema is the same for every backend.
+func createDeletedNamespacesTable(db sqlExecer) error {
+	_, err := db.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			namespace VARCHAR(255) PRIMARY KEY,
+			deleted_at BIGINT NOT NULL
+		)`, deletedNamespacesTable))
+	if err != nil {
+		return fmt.Errorf("failed to create deleted namespaces table: %w", err)
+	}
+	return nil
+}
+
+// markNamespaceDeleted records that a namespace was soft-deleted at a time
+func markNamespaceDeleted(db *sql.DB, ph sqlPlaceholder, namespace string, at time.Time) error {
+	_, err := db.Exec(fmt.Sprintf("INSERT INTO %s (namespace, deleted_at) VALUES (%s, %s)",
+		deletedNamespacesTable, ph(1), ph(2)), namespace, at.UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to delete namespace %s: %w", namespace, err)
+	}
+	return nil
+}
+
+// unmarkNamespaceDeleted forgets that a namespace was soft-deleted. Returns
+// a not found error if it wasn't.
+func unmarkNamespaceDeleted(db sqlExecer, ph sqlPlaceholder, namespace string) error {
+	result, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE namespace = %s", deletedNamespacesTable, ph(1)), namespace)
+	if err != nil {
+		return fmt.Errorf("failed to restore namespace %s: %w", namespace, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return filesystem.NewNotFoundError("restore", namespace)
+	}
+	return nil
+}
+
+// namespaceDeleted reports whether a namespace is soft-deleted
+func namespaceDeleted(db *sql.DB, ph sqlPlaceholder, namespace string) (bool, error) {
+	var count int
+	err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE namespace = %s", deletedNamespacesTable, ph(1)), namespace).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// queryDeletedNamespaces returns when each soft-deleted namespace was deleted
+func queryDeletedNamespaces(db *sql.DB) (map[string]time.Time, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT namespace, deleted_at FROM %s", deletedNamespacesTable))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deleted namespaces: %w", err)
+	}
+	defer rows.Close()
+
+	deleted := make(map[string]time.Time)
+	for rows.Next() {
+		var namespace string
+		var at int64
+		if err := rows.Scan(&namespace, &at); err != nil {
+			return nil, err
+		}
+		deleted[namespace] = time.Unix(0, at)
+	}
+	return deleted, rows.Err()
+}
+
+// hideDeletedNamespaces drops the soft-deleted namespaces from namespaces
+// listed by table name
+func hideDeletedNamespaces(db *sql.DB, namespaces []string) ([]string, error) {
+	deleted, err := queryDeletedNamespaces(db)
+	if err != nil || len(deleted) == 0 {
+		return namespaces, err
+	}
+	hidden := make(map[string]bool, len(deleted))
+	for namespace := range deleted {
+		hidden[sanitizeTableName(namespace)] = true
+	}
+	visible := namespaces[:0]
+	for _, namespace := range namespaces {
+		if !hidden[namespace] {
+			visible = append(visible, namespace)
+		}
+	}
+	return visible, nil
+}
+
+// errNamespaceDeleted rejects creating a namespace that is soft-deleted
+func errNamespaceDeleted(namespace string) error {
+	return fmt.Errorf("namespace %s was deleted and is kept until it is purged; restore it instead: %w",
+		namespace, filesystem.ErrAlreadyExists)
+}
+
+// removeNamespace deletes a namespace: with a retention period it is only
+// hidden, until purgeExpiredNamespaces removes it
+func (v *VectorFSPlugin) removeNamespace(namespace string) error {
+	if v.softDeleteRetention <= 0 {
+		if err := v.purgeNamespace(namespace); err != nil {
+			return err
+		}
+	} else {
+		if err := v.store.SoftDeleteNamespace(namespace, time.Now()); err != nil {
+			return err
+		}
+		log.Infof("[vectorfs] Deleted namespace %s, kept until %s", namespace,
+			time.Now().Add(v.softDeleteRetention).Format(time.RFC3339))
+	}
+	v.clearIndexingStatus(namespace)
+	v.forgetGCReport(namespace)
+	v.forgetAskAnswer(namespace)
+	v.embedder.forgetUsage(namespace)
+	v.queryLimiter.forget(namespace)
+	return nil
+}
+
+// purgeNamespace deletes the stored documents of a namespace and then its
+// tables, for good
+func (v *VectorFSPlugin) purgeNamespace(namespace string) error {
+	files, err := v.store.ListFiles(namespace)
+	if err != nil {
+		return fmt.Errorf("failed to list documents of %s: %w", namespace, err)
+	}
+	ctx := context.Background()
+	for _, f := range files {
+		if err := v.docs.DeleteDocument(ctx, namespace, f.FileDigest); err != nil {
+			return fmt.Errorf("failed to delete %s from document storage: %w", f.FileName, err)
+		}
+	}
+	return v.store.DeleteNamespace(namespace)
+}
+
+// purgeExpiredNamespaces purges the soft-deleted namespaces whose retention
+// period is over. Failures are logged and retried on the next run.
+func (v *VectorFSPlugin) purgeExpiredNamespaces(now time.Time) {
+	deleted, err := v.store.ListDeletedNamespaces()
+	if err != nil {
+		log.Warnf("[vectorfs] Failed to list deleted namespaces: %v", err)
+		return
+	}
+	for namespace, at := range deleted {
+		if now.Before(at.Add(v.softDeleteRetention)) {
+			continue
+		}
+		if err := v.purgeNamespace(namespace); err != nil {
+			log.Warnf("[vectorfs] Failed to purge deleted namespace %s: %v", namespace, err)
+			continue
+		}
+		log.Infof("[vectorfs] Purged namespace %s, deleted at %s", namespace, at.Format(time.RFC3339))
+	}
+}
+
+// purgeLoop purges expired namespaces until shutdown
+func (v *VectorFSPlugin) purgeLoop(shutdown <-chan struct{}) {
+	defer v.workerWg.Done()
+
+	ticker := time.NewTicker(softDeletePurgeInterval)
+	defer ticker.Stop()
+	for {
+		v.purgeExpiredNamespaces(time.Now())
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ListDeleted implements filesystem.Restorer. Only namespaces are
+// soft-deleted, so only the root has deleted entries.
+func (vfs *vectorFS) ListDeleted(path string) ([]filesystem.DeletedEntry, error) {
+	if filesystem.NormalizePath(path) != "/" {
+		return nil, filesystem.NewNotSupportedError("listdeleted", path)
+	}
+	deleted, err := vfs.plugin.store.ListDeletedNamespaces()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]filesystem.DeletedEntry, 0, len(deleted))
+	for namespace, at := range deleted {
+		entries = append(entries, filesystem.DeletedEntry{
+			Name:      namespace,
+			DeletedAt: at,
+			PurgeAt:   at.Add(vfs.plugin.softDeleteRetention),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.Before(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// Restore implements filesystem.Restorer for soft-deleted namespaces. Their
+// documents left pending are queued for indexing again.
+func (vfs *vectorFS) Restore(path string) error {
+	namespace, relativePath, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	if namespace == "" || relativePath != "" || strings.ContainsAny(namespace, "{},") {
+		return filesystem.NewNotSupportedError("restore", path)
+	}
+	if err := vfs.plugin.store.RestoreNamespace(namespace); err != nil {
+		return err
+	}
+	log.Infof("[vectorfs] Restored namespace %s", namespace)
+	if err := vfs.plugin.queuePendingIndexing([]string{namespace}); err != nil {
+		log.Warnf("[vectorfs] Failed to queue pending documents of %s: %v", namespace, err)
+	}
+	return nil
+}
@@ -0,0 +1,940 @@
+package vectorfs
+
+import (
+	"container/heap"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/migrate"
+	_ "github.com/mattn/go-sqlite3"
+	log "github.com/sirupsen/logrus"
+)
+
+// SQLiteConfig holds configuration for the embedded vector store
+type SQLiteConfig struct {
+	Path string // Database file, created if missing
+}
+
+// SQLiteClient keeps namespaces in a local SQLite file. Embeddings are
+// stored as little-endian float32 blobs and searched exactly by scanning a
+// namespace, which is fast enough for the document counts of a laptop or a
+// test suite and needs no server or extension.
+type SQLiteClient struct {
+	db *sql.DB
+}
+
+// NewSQLiteClient opens (or creates) the SQLite vector store
+func NewSQLiteClient(cfg SQLiteConfig) (*SQLiteClient, error) {
+	if dir := filepath.Dir(cfg.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory for %s: %w", cfg.Path, err)
+		}
+	}
+
+	db, err := sql.Open("sqlite3", cfg.Path+"?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	// A single connection serializes writers, which SQLite requires anyway
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+
+	log.Infof("[vectorfs/sqlite] Opened vector store: %s", cfg.Path)
+	client := &SQLiteClient{db: db}
+	if err := migrate.Apply(db, migrate.SQLite, "vectorfs", client.migrations()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := client.trackNamespaceUsage(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// migrations are the schema changes of the SQLite store, in order. Those
+// predating versioned migrations tolerate stores that already have them.
+func (c *SQLiteClient) migrations() []migrate.Migration {
+	return []migrate.Migration{
+		{Version: 1, Description: "deleted namespaces table", Up: func(tx *sql.Tx) error { return createDeletedNamespacesTable(tx) }},
+		{Version: 2, Description: "chunk metadata column", Up: c.migrateChunkMetadata},
+		{Version: 3, Description: "index_pending column", Up: c.migrateIndexPending},
+		{Version: 4, Description: "embedding cache table", Up: c.createEmbeddingCache},
+		{Version: 5, Description: "namespace config table", Up: func(tx *sql.Tx) error { return createNamespaceConfigTable(tx) }},
+		{Version: 6, Description: "namespace owners table", Up: func(tx *sql.Tx) error { return createNamespaceOwnersTable(tx) }},
+		{Version: 7, Description: "namespace usage table", Up: func(tx *sql.Tx) error { return createUsageTable(tx) }},
+	}
+}
+
+// trackNamespaceUsage counts the namespaces whose usage isn't tracked,
+// such as those created before it was
+func (c *SQLiteClient) trackNamespaceUsage() error {
+	namespaces, err := c.listNamespaceTables(c.db)
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	for _, ns := range namespaces {
+		metaTable, chunksTable := sqliteTables(ns)
+		if err := initNamespaceUsage(c.db, questionPlaceholder, ns, metaTable, chunksTable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createEmbeddingCache creates the embedding cache table if missing
+func (c *SQLiteClient) createEmbeddingCache(tx *sql.Tx) error {
+	_, err := tx.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			cache_key TEXT PRIMARY KEY,
+			embedding BLOB NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`, embeddingCacheTable))
+	if err != nil {
+		return fmt.Errorf("failed to create embedding cache table: %w", err)
+	}
+	return nil
+}
+
+// migrateChunkMetadata adds the metadata column to chunks tables created
+// before document metadata was supported
+func (c *SQLiteClient) migrateChunkMetadata(tx *sql.Tx) error {
+	namespaces, err := c.listNamespaceTables(tx)
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	for _, ns := range namespaces {
+		_, chunksTable := sqliteTables(ns)
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = 'metadata'",
+			"tbl_chunks_"+sanitizeTableName(ns)).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to inspect %s: %w", chunksTable, err)
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN metadata TEXT", chunksTable)); err != nil {
+			return fmt.Errorf("failed to add metadata column to %s: %w", chunksTable, err)
+		}
+	}
+	return nil
+}
+
+// migrateIndexPending adds the index_pending column to metadata tables
+// created before the index queue was durable
+func (c *SQLiteClient) migrateIndexPending(tx *sql.Tx) error {
+	namespaces, err := c.listNamespaceTables(tx)
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	for _, ns := range namespaces {
+		metaTable, _ := sqliteTables(ns)
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = 'index_pending'",
+			"tbl_meta_"+sanitizeTableName(ns)).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to inspect %s: %w", metaTable, err)
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN index_pending INTEGER NOT NULL DEFAULT 0", metaTable)); err != nil {
+			return fmt.Errorf("failed to add index_pending column to %s: %w", metaTable, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the database
+func (c *SQLiteClient) Close() error {
+	if c.db != nil {
+		return c.db.Close()
+	}
+	return nil
+}
+
+func sqliteTables(namespace string) (metaTable, chunksTable string) {
+	tableSuffix := sanitizeTableName(namespace)
+	return pgQuoteIdent("tbl_meta_" + tableSuffix), pgQuoteIdent("tbl_chunks_" + tableSuffix)
+}
+
+// encodeEmbedding packs an embedding as little-endian float32 values
+func encodeEmbedding(embedding []float32) []byte {
+	buf := make([]byte, 4*len(embedding))
+	for i, f := range embedding {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return buf
+}
+
+// decodeEmbedding is the inverse of encodeEmbedding
+func decodeEmbedding(buf []byte) ([]float32, error) {
+	if len(buf)%4 != 0 {
+		return nil, fmt.Errorf("corrupt embedding of %d bytes", len(buf))
+	}
+	embedding := make([]float32, len(buf)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return embedding, nil
+}
+
+// cosineDistance returns 1 - cosine similarity, matching the
+// VEC_COSINE_DISTANCE and <=> operators of the other backends
+func cosineDistance(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 1
+	}
+	return 1 - dot/(math.Sqrt(normA)*math.Sqrt(normB))
+}
+
+// CreateNamespace creates tables for a new namespace (fails if already exists)
+func (c *SQLiteClient) CreateNamespace(namespace string, embeddingDim int) error {
+	metaTable, chunksTable := sqliteTables(namespace)
+
+	exists, err := c.NamespaceExists(namespace)
+	if err != nil {
+		return fmt.Errorf("failed to check namespace existence: %w", err)
+	}
+	if exists {
+		return fmt.Errorf("namespace already exists: %s", namespace)
+	}
+	if deleted, err := namespaceDeleted(c.db, questionPlaceholder, namespace); err != nil {
+		return fmt.Errorf("failed to check namespace existence: %w", err)
+	} else if deleted {
+		return errNamespaceDeleted(namespace)
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		fmt.Sprintf(`
+			CREATE TABLE %s (
+				file_digest TEXT PRIMARY KEY,
+				file_name TEXT NOT NULL,
+				s3_key TEXT NOT NULL,
+				file_size INTEGER NOT NULL DEFAULT 0,
+				created_at DATETIME NOT NULL,
+				updated_at DATETIME NOT NULL,
+				index_pending INTEGER NOT NULL DEFAULT 0
+			)
+		`, metaTable),
+		fmt.Sprintf("CREATE INDEX %s ON %s (file_name)", pgQuoteIdent("idx_meta_"+sanitizeTableName(namespace)), metaTable),
+		fmt.Sprintf(`
+			CREATE TABLE %s (
+				chunk_id INTEGER PRIMARY KEY AUTOINCREMENT,
+				file_digest TEXT NOT NULL,
+				chunk_index INTEGER NOT NULL,
+				chunk_text TEXT NOT NULL,
+				embedding BLOB NOT NULL CHECK (length(embedding) = %d),
+				metadata TEXT
+			)
+		`, chunksTable, 4*embeddingDim),
+		fmt.Sprintf("CREATE INDEX %s ON %s (file_digest)", pgQuoteIdent("idx_chunks_"+sanitizeTableName(namespace)), chunksTable),
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create namespace tables: %w", err)
+		}
+	}
+	if err := resetNamespaceUsage(tx, questionPlaceholder, namespace); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Infof("[vectorfs/sqlite] Created tables for namespace: %s", namespace)
+	return nil
+}
+
+// DeleteNamespace drops all tables for a namespace
+func (c *SQLiteClient) DeleteNamespace(namespace string) error {
+	metaTable, chunksTable := sqliteTables(namespace)
+
+	if _, err := c.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", chunksTable)); err != nil {
+		return fmt.Errorf("failed to drop chunks table: %w", err)
+	}
+	if _, err := c.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", metaTable)); err != nil {
+		return fmt.Errorf("failed to drop metadata table: %w", err)
+	}
+	if err := deleteNamespaceUsage(c.db, questionPlaceholder, namespace); err != nil {
+		return err
+	}
+	if err := deleteNamespaceConfig(c.db, questionPlaceholder, namespace); err != nil {
+		return err
+	}
+	if err := deleteNamespaceOwner(c.db, questionPlaceholder, namespace); err != nil {
+		return err
+	}
+	if err := unmarkNamespaceDeleted(c.db, questionPlaceholder, namespace); err != nil && !errors.Is(err, filesystem.ErrNotFound) {
+		return err
+	}
+
+	log.Infof("[vectorfs/sqlite] Deleted tables for namespace: %s", namespace)
+	return nil
+}
+
+// NamespaceExists checks if a namespace exists
+func (c *SQLiteClient) NamespaceExists(namespace string) (bool, error) {
+	// Soft-deleted namespaces keep their tables, hidden
+	if deleted, err := namespaceDeleted(c.db, questionPlaceholder, namespace); err != nil || deleted {
+		return false, err
+	}
+	var count int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+		"tbl_meta_"+sanitizeTableName(namespace)).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// ListNamespaces lists all namespaces but the soft-deleted ones
+func (c *SQLiteClient) ListNamespaces() ([]string, error) {
+	namespaces, err := c.listNamespaceTables(c.db)
+	if err != nil {
+		return nil, err
+	}
+	return hideDeletedNamespaces(c.db, namespaces)
+}
+
+// listNamespaceTables lists all namespaces, soft-deleted ones included (by
+// finding all tbl_meta_* tables)
+func (c *SQLiteClient) listNamespaceTables(db sqlQueryer) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE 'tbl\_meta\_%' ESCAPE '\'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var namespaces []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, strings.TrimPrefix(tableName, "tbl_meta_"))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return namespaces, nil
+}
+
+// FileExists checks if a file (by digest) is already indexed
+func (c *SQLiteClient) FileExists(namespace, digest string) (bool, error) {
+	metaTable, _ := sqliteTables(namespace)
+
+	var count int
+	err := c.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE file_digest = ?", metaTable), digest).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// InsertFileMetadata inserts file metadata
+func (c *SQLiteClient) InsertFileMetadata(namespace string, meta FileMetadata) error {
+	metaTable, _ := sqliteTables(namespace)
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldSize sql.NullInt64
+	err = tx.QueryRow(fmt.Sprintf("SELECT file_size FROM %s WHERE file_digest = ?", metaTable), meta.FileDigest).Scan(&oldSize)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to insert file metadata: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (file_digest, file_name, s3_key, file_size, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (file_digest) DO UPDATE SET
+			file_name = excluded.file_name,
+			s3_key = excluded.s3_key,
+			file_size = excluded.file_size,
+			updated_at = excluded.updated_at
+	`, metaTable)
+
+	_, err = tx.Exec(query, meta.FileDigest, meta.FileName, meta.S3Key, meta.FileSize,
+		meta.CreatedAt, meta.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert file metadata: %w", err)
+	}
+	var added int64
+	if !oldSize.Valid {
+		added = 1
+	}
+	if err := adjustUsage(tx, questionPlaceholder, namespace, added, meta.FileSize-oldSize.Int64, 0); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// InsertChunksBatch inserts multiple chunks in one transaction
+func (c *SQLiteClient) InsertChunksBatch(namespace, fileDigest string, chunks []ChunkData) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	_, chunksTable := sqliteTables(namespace)
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf(
+		"INSERT INTO %s (file_digest, chunk_index, chunk_text, embedding, metadata) VALUES (?, ?, ?, ?, ?)", chunksTable))
+	if err != nil {
+		return fmt.Errorf("failed to prepare chunk insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, chunk := range chunks {
+		if _, err := stmt.Exec(fileDigest, chunk.ChunkIndex, chunk.ChunkText, encodeEmbedding(chunk.Embedding), chunk.Metadata.value()); err != nil {
+			return fmt.Errorf("failed to insert chunk %d: %w", chunk.ChunkIndex, err)
+		}
+	}
+	if err := adjustUsage(tx, questionPlaceholder, namespace, 0, 0, int64(len(chunks))); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Debugf("[vectorfs/sqlite] Batch inserted %d chunks for file %s", len(chunks), fileDigest)
+	return nil
+}
+
+// ListChunks returns the chunks of a file with their embeddings
+func (c *SQLiteClient) ListChunks(namespace, fileDigest string) ([]ChunkData, error) {
+	_, chunksTable := sqliteTables(namespace)
+	rows, err := c.db.Query(fmt.Sprintf(
+		"SELECT chunk_index, chunk_text, embedding, metadata FROM %s WHERE file_digest = ? ORDER BY chunk_index", chunksTable),
+		fileDigest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunks: %w", err)
+	}
+	defer rows.Close()
+
+	var chunks []ChunkData
+	for rows.Next() {
+		var chunk ChunkData
+		var blob []byte
+		var metadata sql.NullString
+		if err := rows.Scan(&chunk.ChunkIndex, &chunk.ChunkText, &blob, &metadata); err != nil {
+			return nil, err
+		}
+		if chunk.Embedding, err = decodeEmbedding(blob); err != nil {
+			return nil, err
+		}
+		chunk.Metadata = decodeDocMetadata(metadata)
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+// FirstChunks returns the first chunk of each of the files with digests
+func (c *SQLiteClient) FirstChunks(namespace string, digests []string) (map[string]ChunkData, error) {
+	_, chunksTable := sqliteTables(namespace)
+	return queryFirstChunks(c.db, questionPlaceholder, chunksTable, "metadata", digests)
+}
+
+// NamespaceUsage returns the usage counters and quota overrides of a namespace
+func (c *SQLiteClient) NamespaceUsage(namespace string) (NamespaceUsage, error) {
+	return queryNamespaceUsage(c.db, questionPlaceholder, namespace)
+}
+
+// NamespaceConfig returns the setting overrides of a namespace
+func (c *SQLiteClient) NamespaceConfig(namespace string) (NamespaceConfig, error) {
+	return queryNamespaceConfig(c.db, questionPlaceholder, namespace)
+}
+
+// SetNamespaceConfig replaces the setting overrides of a namespace
+func (c *SQLiteClient) SetNamespaceConfig(namespace string, cfg NamespaceConfig) error {
+	return updateNamespaceConfig(c.db, questionPlaceholder, namespace, cfg)
+}
+
+// NamespaceOwner returns the identity owning a namespace, "" if none
+func (c *SQLiteClient) NamespaceOwner(namespace string) (string, error) {
+	return queryNamespaceOwner(c.db, questionPlaceholder, namespace)
+}
+
+// NamespaceOwners returns the owner of every owned namespace
+func (c *SQLiteClient) NamespaceOwners() (map[string]string, error) {
+	return queryNamespaceOwners(c.db)
+}
+
+// SetNamespaceOwner replaces the owner of a namespace
+func (c *SQLiteClient) SetNamespaceOwner(namespace, owner string) error {
+	return updateNamespaceOwner(c.db, questionPlaceholder, namespace, owner)
+}
+
+// SoftDeleteNamespace hides a namespace, keeping its tables until
+// DeleteNamespace purges it
+func (c *SQLiteClient) SoftDeleteNamespace(namespace string, at time.Time) error {
+	exists, err := c.NamespaceExists(namespace)
+	if err != nil {
+		return fmt.Errorf("failed to check namespace existence: %w", err)
+	}
+	if !exists {
+		return filesystem.NewNotFoundError("delete", namespace)
+	}
+	return markNamespaceDeleted(c.db, questionPlaceholder, namespace, at)
+}
+
+// RestoreNamespace makes a soft-deleted namespace visible again
+func (c *SQLiteClient) RestoreNamespace(namespace string) error {
+	return unmarkNamespaceDeleted(c.db, questionPlaceholder, namespace)
+}
+
+// ListDeletedNamespaces returns when each soft-deleted namespace was deleted
+func (c *SQLiteClient) ListDeletedNamespaces() (map[string]time.Time, error) {
+	return queryDeletedNamespaces(c.db)
+}
+
+// SetNamespaceQuota replaces the quota overrides of a namespace
+func (c *SQLiteClient) SetNamespaceQuota(namespace string, quota NamespaceQuota) error {
+	return updateNamespaceQuota(c.db, questionPlaceholder, namespace, quota)
+}
+
+// GetCachedEmbeddings returns the cached embeddings of keys
+func (c *SQLiteClient) GetCachedEmbeddings(keys []string) (map[string][]float32, error) {
+	cached := make(map[string][]float32)
+	for _, batch := range chunkKeys(keys) {
+		args := make([]interface{}, len(batch))
+		for i, key := range batch {
+			args[i] = key
+		}
+		rows, err := c.db.Query(fmt.Sprintf("SELECT cache_key, embedding FROM %s WHERE cache_key IN (%s)",
+			embeddingCacheTable, strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")), args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read embedding cache: %w", err)
+		}
+		if err := scanCachedEmbeddings(rows, cached); err != nil {
+			return nil, err
+		}
+	}
+	return cached, nil
+}
+
+// PutCachedEmbeddings caches embeddings, keeping existing entries
+func (c *SQLiteClient) PutCachedEmbeddings(entries map[string][]float32) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT OR IGNORE INTO %s (cache_key, embedding) VALUES (?, ?)", embeddingCacheTable))
+	if err != nil {
+		return fmt.Errorf("failed to write embedding cache: %w", err)
+	}
+	defer stmt.Close()
+	for key, embedding := range entries {
+		if _, err := stmt.Exec(key, encodeEmbedding(embedding)); err != nil {
+			return fmt.Errorf("failed to write embedding cache: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// UpdateChunkMetadata replaces the metadata of every chunk of a file
+func (c *SQLiteClient) UpdateChunkMetadata(namespace, fileDigest string, metadata DocMetadata) error {
+	_, chunksTable := sqliteTables(namespace)
+	_, err := c.db.Exec(fmt.Sprintf("UPDATE %s SET metadata = ? WHERE file_digest = ?", chunksTable),
+		metadata.value(), fileDigest)
+	if err != nil {
+		return fmt.Errorf("failed to update chunk metadata: %w", err)
+	}
+	return nil
+}
+
+// sqliteFilterClause returns the WHERE conditions of a metadata filter,
+// each looking the value up in the key's list with json_each
+func sqliteFilterClause(filter MetadataFilter) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	for _, cond := range filter {
+		conds = append(conds, "EXISTS (SELECT 1 FROM json_each(c.metadata, ?) WHERE value = ?)")
+		args = append(args, `$."`+cond.Key+`"`, cond.Value)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// matchHeap is a max-heap on distance holding the best matches seen so far
+type matchHeap []VectorMatch
+
+func (h matchHeap) Len() int            { return len(h) }
+func (h matchHeap) Less(i, j int) bool  { return h[i].Distance > h[j].Distance }
+func (h matchHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *matchHeap) Push(x interface{}) { *h = append(*h, x.(VectorMatch)) }
+func (h *matchHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// VectorSearch performs an exact cosine distance search over the namespace
+func (c *SQLiteClient) VectorSearch(namespace string, queryEmbedding []float32, filter MetadataFilter, limit int) ([]VectorMatch, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	metaTable, chunksTable := sqliteTables(namespace)
+
+	where, args := "", []interface{}(nil)
+	if len(filter) > 0 {
+		var conds string
+		conds, args = sqliteFilterClause(filter)
+		where = "WHERE " + conds
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			c.file_digest,
+			m.file_name,
+			c.chunk_text,
+			c.chunk_index,
+			c.embedding,
+			m.updated_at,
+			c.metadata
+		FROM %s c
+		JOIN %s m ON c.file_digest = m.file_digest
+		%s
+	`, chunksTable, metaTable, where)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute vector search: %w", err)
+	}
+	defer rows.Close()
+
+	best := make(matchHeap, 0, limit)
+	for rows.Next() {
+		var match VectorMatch
+		var blob []byte
+		var metadata sql.NullString
+		if err := rows.Scan(&match.FileDigest, &match.FileName, &match.ChunkText,
+			&match.ChunkIndex, &blob, &match.UpdatedAt, &metadata); err != nil {
+			return nil, err
+		}
+		match.Metadata = decodeDocMetadata(metadata)
+		embedding, err := decodeEmbedding(blob)
+		if err != nil {
+			return nil, err
+		}
+		if len(embedding) != len(queryEmbedding) {
+			return nil, fmt.Errorf("query has dimension %d, namespace %s has %d", len(queryEmbedding), namespace, len(embedding))
+		}
+		match.Distance = cosineDistance(queryEmbedding, embedding)
+
+		if best.Len() < limit {
+			heap.Push(&best, match)
+		} else if match.Distance < best[0].Distance {
+			best[0] = match
+			heap.Fix(&best, 0)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]VectorMatch, best.Len())
+	for i := len(results) - 1; i >= 0; i-- {
+		results[i] = heap.Pop(&best).(VectorMatch)
+	}
+
+	log.Debugf("[vectorfs/sqlite] Vector search returned %d results", len(results))
+	return results, nil
+}
+
+// KeywordSearch returns up to limit chunks matching keywords, most recently
+// updated documents first
+func (c *SQLiteClient) KeywordSearch(namespace string, keywords KeywordQuery, filter MetadataFilter, limit int) ([]VectorMatch, error) {
+	metaTable, chunksTable := sqliteTables(namespace)
+
+	var groups []string
+	var args []interface{}
+	for _, group := range keywords {
+		conds := make([]string, len(group))
+		for i, term := range group {
+			conds[i] = `LOWER(c.chunk_text) LIKE ? ESCAPE '\'`
+			args = append(args, "%"+escapeLike(term)+"%")
+		}
+		groups = append(groups, "("+strings.Join(conds, " AND ")+")")
+	}
+	if len(groups) == 0 {
+		return nil, nil
+	}
+	where := "(" + strings.Join(groups, " OR ") + ")"
+	if len(filter) > 0 {
+		conds, filterArgs := sqliteFilterClause(filter)
+		where += " AND " + conds
+		args = append(args, filterArgs...)
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`
+		SELECT
+			c.file_digest,
+			m.file_name,
+			c.chunk_text,
+			c.chunk_index,
+			m.updated_at,
+			c.metadata
+		FROM %s c
+		JOIN %s m ON c.file_digest = m.file_digest
+		WHERE %s
+		ORDER BY m.updated_at DESC, c.chunk_id
+		LIMIT ?
+	`, chunksTable, metaTable, where)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute keyword search: %w", err)
+	}
+	defer rows.Close()
+
+	var results []VectorMatch
+	for rows.Next() {
+		var match VectorMatch
+		var metadata sql.NullString
+		if err := rows.Scan(&match.FileDigest, &match.FileName, &match.ChunkText,
+			&match.ChunkIndex, &match.UpdatedAt, &metadata); err != nil {
+			return nil, err
+		}
+		match.Metadata = decodeDocMetadata(metadata)
+		results = append(results, match)
+	}
+
+	log.Debugf("[vectorfs/sqlite] Keyword search returned %d results", len(results))
+	return results, rows.Err()
+}
+
+// queryFiles runs a metadata query returning full rows
+func (c *SQLiteClient) queryFiles(query string, args ...interface{}) ([]FileMetadata, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []FileMetadata
+	for rows.Next() {
+		var file FileMetadata
+		if err := rows.Scan(&file.FileDigest, &file.FileName, &file.S3Key, &file.FileSize,
+			&file.CreatedAt, &file.UpdatedAt); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// ListFiles lists all files in a namespace
+func (c *SQLiteClient) ListFiles(namespace string) ([]FileMetadata, error) {
+	metaTable, _ := sqliteTables(namespace)
+	return c.queryFiles(fmt.Sprintf(`
+		SELECT file_digest, file_name, s3_key, file_size, created_at, updated_at
+		FROM %s
+		ORDER BY updated_at DESC
+	`, metaTable))
+}
+
+// SetIndexPending marks whether a file still has to be indexed
+func (c *SQLiteClient) SetIndexPending(namespace, fileDigest string, pending bool) error {
+	metaTable, _ := sqliteTables(namespace)
+	query := fmt.Sprintf("UPDATE %s SET index_pending = ? WHERE file_digest = ?", metaTable)
+	if _, err := c.db.Exec(query, pending, fileDigest); err != nil {
+		return fmt.Errorf("failed to update index_pending: %w", err)
+	}
+	return nil
+}
+
+// ListPendingFiles lists the files of a namespace still to be indexed
+func (c *SQLiteClient) ListPendingFiles(namespace string) ([]FileMetadata, error) {
+	metaTable, _ := sqliteTables(namespace)
+	return c.queryFiles(fmt.Sprintf(`
+		SELECT file_digest, file_name, s3_key, file_size, created_at, updated_at
+		FROM %s
+		WHERE index_pending
+		ORDER BY updated_at
+	`, metaTable))
+}
+
+// ListFilesWithPrefix lists files in a namespace with a given prefix
+func (c *SQLiteClient) ListFilesWithPrefix(namespace, prefix string) ([]FileMetadata, error) {
+	metaTable, _ := sqliteTables(namespace)
+	return c.queryFiles(fmt.Sprintf(`
+		SELECT file_digest, file_name, s3_key, file_size, created_at, updated_at
+		FROM %s
+		WHERE file_name LIKE ? ESCAPE '\'
+		ORDER BY file_name
+	`, metaTable), escapeLike(prefix)+"%")
+}
+
+// HasFilesWithPrefix checks if any files exist with the given prefix (for directory detection)
+func (c *SQLiteClient) HasFilesWithPrefix(namespace, prefix string) (bool, error) {
+	metaTable, _ := sqliteTables(namespace)
+
+	var exists int
+	err := c.db.QueryRow(fmt.Sprintf(`SELECT 1 FROM %s WHERE file_name LIKE ? ESCAPE '\' LIMIT 1`, metaTable),
+		escapeLike(prefix)+"%").Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteFileChunks deletes all chunks for a file
+func (c *SQLiteClient) DeleteFileChunks(namespace, fileDigest string) error {
+	_, chunksTable := sqliteTables(namespace)
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE file_digest = ?", chunksTable), fileDigest)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if err := adjustUsage(tx, questionPlaceholder, namespace, 0, 0, -deleted); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListOrphanedChunkDigests lists the digests of chunks without file metadata
+func (c *SQLiteClient) ListOrphanedChunkDigests(namespace string) ([]string, error) {
+	metaTable, chunksTable := sqliteTables(namespace)
+	return queryOrphanedChunkDigests(c.db, metaTable, chunksTable)
+}
+
+// DeleteFileMetadata deletes file metadata
+func (c *SQLiteClient) DeleteFileMetadata(namespace, fileDigest string) error {
+	metaTable, _ := sqliteTables(namespace)
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var size int64
+	err = tx.QueryRow(fmt.Sprintf("SELECT file_size FROM %s WHERE file_digest = ?", metaTable), fileDigest).Scan(&size)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE file_digest = ?", metaTable), fileDigest); err != nil {
+		return err
+	}
+	if err := adjustUsage(tx, questionPlaceholder, namespace, -1, -size, 0); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteFileByName deletes all versions of a file by name in one transaction
+func (c *SQLiteClient) DeleteFileByName(namespace, fileName string) error {
+	metaTable, chunksTable := sqliteTables(namespace)
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var documents, size int64
+	err = tx.QueryRow(fmt.Sprintf("SELECT COUNT(*), COALESCE(SUM(file_size), 0) FROM %s WHERE file_name = ?", metaTable), fileName).Scan(&documents, &size)
+	if err != nil || documents == 0 {
+		return err
+	}
+	query := fmt.Sprintf(`
+		DELETE FROM %s WHERE file_digest IN (SELECT file_digest FROM %s WHERE file_name = ?)
+	`, chunksTable, metaTable)
+	result, err := tx.Exec(query, fileName)
+	if err != nil {
+		return err
+	}
+	chunks, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE file_name = ?", metaTable), fileName); err != nil {
+		return err
+	}
+	if err := adjustUsage(tx, questionPlaceholder, namespace, -documents, -size, -chunks); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetFileMetadataByName retrieves file metadata by file name (returns the latest version)
+func (c *SQLiteClient) GetFileMetadataByName(namespace, fileName string) (*FileMetadata, error) {
+	metaTable, _ := sqliteTables(namespace)
+
+	query := fmt.Sprintf(`
+		SELECT file_digest, file_name, s3_key, file_size, created_at, updated_at
+		FROM %s
+		WHERE file_name = ?
+		ORDER BY updated_at DESC
+		LIMIT 1
+	`, metaTable)
+
+	var meta FileMetadata
+	err := c.db.QueryRow(query, fileName).Scan(
+		&meta.FileDigest,
+		&meta.FileName,
+		&meta.S3Key,
+		&meta.FileSize,
+		&meta.CreatedAt,
+		&meta.UpdatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("file not found: %s", fileName)
+		}
+		return nil, err
+	}
+	return &meta, nil
+}
@@ -0,0 +1,150 @@
+package vectorfs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// statsFile is the read-only control file of a namespace reporting what it
+// stores and the embedding work done for it
+const statsFile = ".stats"
+
+// embeddingUsage counts the embedding calls made for a namespace since the
+// server started
+type embeddingUsage struct {
+	Queries  int64 // Search queries embedded
+	Batches  int64 // Batches of document chunks embedded
+	Texts    int64 // Chunks in those batches, cached or not
+	Failures int64 // Calls that failed
+
+	// Unchanged chunks of rewritten documents that kept the embedding of
+	// the previous version instead of being embedded again
+	ReusedChunks int64
+
+	CachedQueries    int64 // Search queries found in the query cache
+	ThrottledQueries int64 // Search queries rejected by query_qps
+}
+
+// recordUsage updates the embedding usage of a namespace
+func (r *EmbeddingRouter) recordUsage(namespace string, update func(*embeddingUsage)) {
+	r.usageMu.Lock()
+	defer r.usageMu.Unlock()
+	if r.usage == nil {
+		r.usage = make(map[string]*embeddingUsage)
+	}
+	u := r.usage[namespace]
+	if u == nil {
+		u = &embeddingUsage{}
+		r.usage[namespace] = u
+	}
+	update(u)
+}
+
+// namespaceUsage returns the embedding usage of a namespace
+func (r *EmbeddingRouter) namespaceUsage(namespace string) embeddingUsage {
+	if r == nil {
+		return embeddingUsage{}
+	}
+	r.usageMu.Lock()
+	defer r.usageMu.Unlock()
+	if u := r.usage[namespace]; u != nil {
+		return *u
+	}
+	return embeddingUsage{}
+}
+
+// forgetUsage drops the embedding usage of a removed namespace
+func (r *EmbeddingRouter) forgetUsage(namespace string) {
+	if r == nil {
+		return
+	}
+	r.usageMu.Lock()
+	defer r.usageMu.Unlock()
+	delete(r.usage, namespace)
+}
+
+// indexLatency is how long the last document indexed in a namespace took
+type indexLatency struct {
+	FileName  string
+	Queued    time.Duration // From write to an index worker picking it up
+	Embedding time.Duration // Chunking, embedding and storing
+	At        time.Time
+}
+
+// recordIndexLatency remembers a document as the last one indexed in its
+// namespace. The caller holds indexingStatusMu.
+func (v *VectorFSPlugin) recordIndexLatency(namespace string, info *indexingFileInfo) {
+	started := info.WorkStart
+	if started.IsZero() {
+		started = info.StartTime
+	}
+	if v.lastIndexed == nil {
+		v.lastIndexed = make(map[string]indexLatency)
+	}
+	v.lastIndexed[namespace] = indexLatency{
+		FileName:  info.FileName,
+		Queued:    started.Sub(info.StartTime),
+		Embedding: info.UpdatedAt.Sub(started),
+		At:        info.UpdatedAt,
+	}
+}
+
+// lastIndexLatency returns the latency of the last document indexed in a
+// namespace, if any
+func (v *VectorFSPlugin) lastIndexLatency(namespace string) (indexLatency, bool) {
+	v.indexingStatusMu.RLock()
+	defer v.indexingStatusMu.RUnlock()
+	latency, ok := v.lastIndexed[namespace]
+	return latency, ok
+}
+
+// readStats renders the statistics of a namespace: what it stores, read
+// live from the vector store and the document store, and the embedding
+// work done for it since the server started
+func (vfs *vectorFS) readStats(namespace string) ([]byte, error) {
+	if err := vfs.requireNamespace("stats", namespace); err != nil {
+		return nil, err
+	}
+	p := vfs.plugin
+	usage, err := p.store.NamespaceUsage(namespace)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := p.docs.ListDocuments(context.Background(), namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored documents: %w", err)
+	}
+	var storedBytes int64
+	for _, doc := range stored {
+		storedBytes += doc.Size
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "documents: %d\n", usage.Documents)
+	fmt.Fprintf(&sb, "chunks: %d\n", usage.Chunks)
+	fmt.Fprintf(&sb, "stored_objects: %d\n", len(stored))
+	fmt.Fprintf(&sb, "stored_bytes: %d\n", storedBytes)
+
+	embedding := p.embedder.namespaceUsage(namespace)
+	fmt.Fprintf(&sb, "embedding_queries: %d\n", embedding.Queries)
+	fmt.Fprintf(&sb, "embedding_batches: %d\n", embedding.Batches)
+	fmt.Fprintf(&sb, "embedded_chunks: %d\n", embedding.Texts)
+	fmt.Fprintf(&sb, "reused_chunks: %d\n", embedding.ReusedChunks)
+	fmt.Fprintf(&sb, "embedding_failures: %d\n", embedding.Failures)
+	fmt.Fprintf(&sb, "cached_queries: %d\n", embedding.CachedQueries)
+	fmt.Fprintf(&sb, "throttled_queries: %d\n", embedding.ThrottledQueries)
+	if hits, misses, ok := p.embedder.cacheStats(); ok {
+		fmt.Fprintf(&sb, "embedding_cache: %d hits / %d misses (all namespaces)\n", hits, misses)
+	}
+
+	if latency, ok := p.lastIndexLatency(namespace); ok {
+		fmt.Fprintf(&sb, "last_index: %s in %s (queued %s) at %s\n", latency.FileName,
+			latency.Embedding.Round(time.Millisecond), latency.Queued.Round(time.Millisecond),
+			latency.At.Format(time.RFC3339))
+	} else {
+		sb.WriteString("last_index: none\n")
+	}
+	return []byte(sb.String()), nil
+}
@@ -0,0 +1,187 @@
+package vectorfs
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/creds"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// Vector store backends accepted by the "vector_backend" config key
+const (
+	BackendTiDB     = "tidb"
+	BackendPGVector = "pgvector"
+	BackendSQLite   = "sqlite"
+)
+
+// Document storage backends accepted by the "storage_backend" config key
+const (
+	StorageS3    = "s3"
+	StorageLocal = "local"
+)
+
+// VectorStore keeps the per-namespace file metadata and embedded chunks and
+// answers similarity and keyword searches over them. A namespace is a pair
+// of tables, tbl_meta_<ns> and tbl_chunks_<ns>, in every backend. Chunks
+// carry the document metadata of their file as a JSON object of string
+// lists, which searches filter on.
+type VectorStore interface {
+	CreateNamespace(namespace string, embeddingDim int) error
+	DeleteNamespace(namespace string) error
+	NamespaceExists(namespace string) (bool, error)
+	ListNamespaces() ([]string, error)
+
+	FileExists(namespace, digest string) (bool, error)
+	InsertFileMetadata(namespace string, meta FileMetadata) error
+	GetFileMetadataByName(namespace, fileName string) (*FileMetadata, error)
+	ListFiles(namespace string) ([]FileMetadata, error)
+	ListFilesWithPrefix(namespace, prefix string) ([]FileMetadata, error)
+	HasFilesWithPrefix(namespace, prefix string) (bool, error)
+	DeleteFileMetadata(namespace, fileDigest string) error
+	DeleteFileByName(namespace, fileName string) error
+
+	// SetIndexPending marks whether a file still has to be chunked and
+	// embedded. The mark outlives the in-memory index queue, so files
+	// pending at a crash or shutdown are indexed after a restart.
+	SetIndexPending(namespace, fileDigest string, pending bool) error
+	// ListPendingFiles returns the files marked pending, oldest first
+	ListPendingFiles(namespace string) ([]FileMetadata, error)
+
+	InsertChunksBatch(namespace, fileDigest string, chunks []ChunkData) error
+	DeleteFileChunks(namespace, fileDigest string) error
+
+	// ListChunks returns the chunks of a file with their embeddings, in
+	// chunk order
+	ListChunks(namespace, fileDigest string) ([]ChunkData, error)
+	// FirstChunks returns the first chunk of each of the files with the
+	// given digests, without embedding, keyed by digest. Files not indexed
+	// yet are left out.
+	FirstChunks(namespace string, digests []string) (map[string]ChunkData, error)
+
+	// UpdateChunkMetadata replaces the metadata of every chunk of a file
+	UpdateChunkMetadata(namespace, fileDigest string, metadata DocMetadata) error
+
+	// ListOrphanedChunkDigests returns the digests of the chunks that no
+	// file metadata refers to
+	ListOrphanedChunkDigests(namespace string) ([]string, error)
+
+	// NamespaceUsage returns the document, byte and chunk counters of a
+	// namespace, which the methods above keep up to date transactionally,
+	// and its quota overrides
+	NamespaceUsage(namespace string) (NamespaceUsage, error)
+	// SetNamespaceQuota replaces the quota overrides of a namespace
+	SetNamespaceQuota(namespace string, quota NamespaceQuota) error
+
+	// NamespaceConfig returns the setting overrides of a namespace (empty
+	// if it has none)
+	NamespaceConfig(namespace string) (NamespaceConfig, error)
+	// SetNamespaceConfig replaces the setting overrides of a namespace
+	SetNamespaceConfig(namespace string, cfg NamespaceConfig) error
+
+	// NamespaceOwner returns the identity owning a namespace, "" if it has
+	// none. Owners outlive soft deletes and go with DeleteNamespace.
+	NamespaceOwner(namespace string) (string, error)
+	// NamespaceOwners returns the owner of every owned namespace, keyed by
+	// the names ListNamespaces returns
+	NamespaceOwners() (map[string]string, error)
+	// SetNamespaceOwner replaces the owner of a namespace; "" removes it
+	SetNamespaceOwner(namespace, owner string) error
+
+	// SoftDeleteNamespace hides a namespace from the methods above while
+	// keeping its tables, until DeleteNamespace purges it
+	SoftDeleteNamespace(namespace string, at time.Time) error
+	// RestoreNamespace makes a soft-deleted namespace visible again
+	RestoreNamespace(namespace string) error
+	// ListDeletedNamespaces returns when each soft-deleted namespace was deleted
+	ListDeletedNamespaces() (map[string]time.Time, error)
+
+	// The embedding cache is shared by all namespaces of a store
+	embeddingCache
+
+	// VectorSearch returns the limit chunks closest to queryEmbedding by
+	// cosine distance among those matching filter
+	VectorSearch(namespace string, queryEmbedding []float32, filter MetadataFilter, limit int) ([]VectorMatch, error)
+
+	// KeywordSearch returns up to limit chunks matching keywords and filter,
+	// most recently updated documents first
+	KeywordSearch(namespace string, keywords KeywordQuery, filter MetadataFilter, limit int) ([]VectorMatch, error)
+
+	Close() error
+}
+
+// DocumentStore keeps the original content of indexed documents, addressed
+// by namespace and content digest
+type DocumentStore interface {
+	// buildKey returns the location recorded in file metadata (s3_key)
+	buildKey(namespace, digest string) string
+	UploadDocument(ctx context.Context, namespace, digest string, data []byte) error
+	DownloadDocument(ctx context.Context, namespace, digest string) ([]byte, error)
+	DocumentExists(ctx context.Context, namespace, digest string) (bool, error)
+	DeleteDocument(ctx context.Context, namespace, digest string) error
+	// ListDocuments returns the documents stored for a namespace
+	ListDocuments(ctx context.Context, namespace string) ([]StoredDocument, error)
+}
+
+// sqlitePath returns the SQLite database file, which defaults to a file in
+// local_dir
+func sqlitePath(cfg map[string]interface{}) string {
+	if path := config.GetStringConfig(cfg, "sqlite_path", ""); path != "" {
+		return path
+	}
+	if dir := config.GetStringConfig(cfg, "local_dir", ""); dir != "" {
+		return filepath.Join(dir, "vectors.db")
+	}
+	return ""
+}
+
+// newVectorStoreFromConfig connects to the backend selected by vector_backend
+func newVectorStoreFromConfig(cfg map[string]interface{}) (VectorStore, error) {
+	switch backend := config.GetStringConfig(cfg, "vector_backend", BackendTiDB); backend {
+	case BackendTiDB:
+		credentials, err := creds.FromConfig(cfg, "tidb_credentials")
+		if err != nil {
+			return nil, err
+		}
+		return NewTiDBClient(TiDBConfig{DSN: config.GetStringConfig(cfg, "tidb_dsn", ""), Credentials: credentials})
+	case BackendPGVector:
+		return NewPGVectorClient(PGVectorConfig{DSN: config.GetStringConfig(cfg, "pg_dsn", "")})
+	case BackendSQLite:
+		return NewSQLiteClient(SQLiteConfig{Path: sqlitePath(cfg)})
+	default:
+		return nil, fmt.Errorf("unsupported vector_backend: %s", backend)
+	}
+}
+
+// newDocumentStoreFromConfig opens the backend selected by storage_backend
+func newDocumentStoreFromConfig(cfg map[string]interface{}) (DocumentStore, error) {
+	switch backend := config.GetStringConfig(cfg, "storage_backend", StorageS3); backend {
+	case StorageS3:
+		credentials, err := creds.FromConfig(cfg, "s3_credentials")
+		if err != nil {
+			return nil, err
+		}
+		s3Config := S3Config{
+			AccessKey: config.GetStringConfig(cfg, "s3_access_key", ""),
+			SecretKey: config.GetStringConfig(cfg, "s3_secret_key", ""),
+			Bucket:    config.GetStringConfig(cfg, "s3_bucket", ""),
+			KeyPrefix: config.GetStringConfig(cfg, "s3_key_prefix", "vectorfs"),
+			Region:    config.GetStringConfig(cfg, "s3_region", "us-east-1"),
+			Endpoint:  config.GetStringConfig(cfg, "s3_endpoint", ""),
+		}
+		s3Config.Credentials = credentials
+		return NewS3Client(s3Config)
+	case StorageLocal:
+		return NewLocalDocumentStore(filepath.Join(config.GetStringConfig(cfg, "local_dir", ""), "documents"))
+	default:
+		return nil, fmt.Errorf("unsupported storage_backend: %s", backend)
+	}
+}
+
+var _ VectorStore = (*TiDBClient)(nil)
+var _ VectorStore = (*PGVectorClient)(nil)
+var _ VectorStore = (*SQLiteClient)(nil)
+var _ DocumentStore = (*S3Client)(nil)
+var _ DocumentStore = (*LocalDocumentStore)(nil)
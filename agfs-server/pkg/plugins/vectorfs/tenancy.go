@@ -0,0 +1,243 @@
+package vectorfs
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// namespaceOwnersTable records the identity owning each namespace created
+// by an authenticated caller. Namespaces are keyed by table name, so that
+// names sharing tables share an owner.
+const namespaceOwnersTable = "tbl_namespace_owners"
+
+// createNamespaceOwnersTable creates the namespace owners table if missing.
+// The schema is the same for every backend.
+func createNamespaceOwnersTable(db sqlExecer) error {
+	_, err := db.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			namespace VARCHAR(255) PRIMARY KEY,
+			owner VARCHAR(255) NOT NULL
+		)`, namespaceOwnersTable))
+	if err != nil {
+		return fmt.Errorf("failed to create namespace owners table: %w", err)
+	}
+	return nil
+}
+
+// queryNamespaceOwner returns the owner of a namespace, "" if it has none
+func queryNamespaceOwner(db *sql.DB, ph sqlPlaceholder, namespace string) (string, error) {
+	var owner string
+	err := db.QueryRow(fmt.Sprintf("SELECT owner FROM %s WHERE namespace = %s", namespaceOwnersTable, ph(1)),
+		sanitizeTableName(namespace)).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read owner of %s: %w", namespace, err)
+	}
+	return owner, nil
+}
+
+// queryNamespaceOwners returns the owner of every owned namespace, by
+// table name
+func queryNamespaceOwners(db *sql.DB) (map[string]string, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT namespace, owner FROM %s", namespaceOwnersTable))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespace owners: %w", err)
+	}
+	defer rows.Close()
+	owners := make(map[string]string)
+	for rows.Next() {
+		var namespace, owner string
+		if err := rows.Scan(&namespace, &owner); err != nil {
+			return nil, err
+		}
+		owners[namespace] = owner
+	}
+	return owners, rows.Err()
+}
+
+// updateNamespaceOwner replaces the owner of a namespace; "" leaves it
+// without one
+func updateNamespaceOwner(db *sql.DB, ph sqlPlaceholder, namespace, owner string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := deleteNamespaceOwner(tx, ph, namespace); err != nil {
+		return err
+	}
+	if owner != "" {
+		_, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (namespace, owner) VALUES (%s, %s)",
+			namespaceOwnersTable, ph(1), ph(2)), sanitizeTableName(namespace), owner)
+		if err != nil {
+			return fmt.Errorf("failed to set owner of %s: %w", namespace, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteNamespaceOwner forgets the owner of a namespace
+func deleteNamespaceOwner(db sqlExecer, ph sqlPlaceholder, namespace string) error {
+	if _, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE namespace = %s", namespaceOwnersTable, ph(1)), sanitizeTableName(namespace)); err != nil {
+		return fmt.Errorf("failed to delete owner of %s: %w", namespace, err)
+	}
+	return nil
+}
+
+// parseTenantConfig reads whether namespaces are isolated between the
+// identities of API tokens, and the identities that see every namespace.
+// tenant_admins is a list or a comma-separated string.
+func parseTenantConfig(cfg map[string]interface{}) (isolation bool, admins map[string]bool, err error) {
+	if err := config.ValidateBoolType(cfg, "tenant_isolation"); err != nil {
+		return false, nil, err
+	}
+	isolation = config.GetBoolConfig(cfg, "tenant_isolation", false)
+
+	admins = make(map[string]bool)
+	switch value := cfg["tenant_admins"].(type) {
+	case nil:
+	case string:
+		for _, identity := range strings.Split(value, ",") {
+			if identity = strings.TrimSpace(identity); identity != "" {
+				admins[identity] = true
+			}
+		}
+	case []string:
+		for _, identity := range value {
+			admins[identity] = true
+		}
+	case []interface{}:
+		for _, item := range value {
+			identity, ok := item.(string)
+			if !ok {
+				return false, nil, fmt.Errorf("tenant_admins must be a list of identities")
+			}
+			admins[identity] = true
+		}
+	default:
+		return false, nil, fmt.Errorf("tenant_admins must be a list of identities")
+	}
+	return isolation, admins, nil
+}
+
+// tenantUnscoped reports whether identity sees every namespace
+func (v *VectorFSPlugin) tenantUnscoped(identity string) bool {
+	return !v.tenantIsolation || v.tenantAdmins[identity]
+}
+
+// AuthorizeTenant implements filesystem.TenantScoper. With tenant_isolation
+// on, a namespace created by an authenticated caller is only accessible to
+// that identity and the tenant_admins; namespaces without an owner, such
+// as those created before, stay shared. search-all is allowed: the server
+// drops its results in other tenants' namespaces.
+func (vfs *vectorFS) AuthorizeTenant(identity, path string, recursive bool) error {
+	p := vfs.plugin
+	if p.tenantUnscoped(identity) {
+		return nil
+	}
+	namespace, _, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	switch namespace {
+	case "":
+		if recursive {
+			return filesystem.NewPermissionDeniedError("access", path, "contains the namespaces of other tenants")
+		}
+		return nil
+	case searchAllNamespace:
+		return nil
+	}
+
+	namespaces, _ := parseNamespaceSet(namespace)
+	for _, ns := range namespaces {
+		owner, err := p.store.NamespaceOwner(ns)
+		if err != nil {
+			return err
+		}
+		if owner != "" && owner != identity {
+			return filesystem.NewPermissionDeniedError("access", "/"+ns, "namespace of another tenant")
+		}
+	}
+	return nil
+}
+
+// FilterTenant implements filesystem.TenantScoper: listing the root only
+// shows the namespaces identity may access. If owners can't be read, no
+// namespace is shown.
+func (vfs *vectorFS) FilterTenant(identity, path string, entries []filesystem.FileInfo) []filesystem.FileInfo {
+	p := vfs.plugin
+	if p.tenantUnscoped(identity) {
+		return entries
+	}
+	if namespace, _, err := parsePath(path); err != nil || namespace != "" {
+		return entries
+	}
+	owners, err := p.store.NamespaceOwners()
+	if err != nil {
+		log.Warnf("[vectorfs] Failed to read namespace owners, hiding namespaces from %q: %v", identity, err)
+	}
+
+	visible := make([]filesystem.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Meta.Type == "namespace" {
+			if owner, ok := owners[sanitizeTableName(entry.Name)]; err != nil || (ok && owner != identity) {
+				continue
+			}
+		}
+		visible = append(visible, entry)
+	}
+	return visible
+}
+
+// MkdirAs implements filesystem.TenantScoper: with tenant_isolation on, a
+// namespace created by an authenticated caller is owned by its identity.
+// Creating a namespace that exists fails as Mkdir does, leaving its owner
+// alone.
+func (vfs *vectorFS) MkdirAs(identity, path string, perm uint32) error {
+	p := vfs.plugin
+	namespace, relativePath, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	if !p.tenantIsolation || identity == "" || namespace == "" || relativePath != "" {
+		return vfs.Mkdir(path, perm)
+	}
+	if err := vfs.AuthorizeTenant(identity, path, false); err != nil {
+		return err
+	}
+
+	// Serialize creations, so that two callers can't both claim a namespace
+	p.tenantMu.Lock()
+	defer p.tenantMu.Unlock()
+	exists, err := p.store.NamespaceExists(namespace)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return vfs.Mkdir(path, perm)
+	}
+	previous, err := p.store.NamespaceOwner(namespace)
+	if err != nil {
+		return err
+	}
+	// Own the namespace before it is visible, so it is never shared
+	if err := p.store.SetNamespaceOwner(namespace, identity); err != nil {
+		return err
+	}
+	if err := vfs.Mkdir(path, perm); err != nil {
+		if restoreErr := p.store.SetNamespaceOwner(namespace, previous); restoreErr != nil {
+			log.Warnf("[vectorfs] Failed to restore the owner of %s: %v", namespace, restoreErr)
+		}
+		return err
+	}
+	log.Infof("[vectorfs] Created namespace %s owned by %s", namespace, identity)
+	return nil
+}
@@ -2,17 +2,23 @@ package vectorfs
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/c4pt0r/agfs/agfs-server/pkg/creds"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/migrate"
 	_ "github.com/go-sql-driver/mysql"
 	log "github.com/sirupsen/logrus"
 )
 
 // TiDBConfig holds TiDB configuration
 type TiDBConfig struct {
-	DSN string // Connection string
+	DSN         string       // Connection string
+	Credentials *creds.Cache // Optional provider of the username and password
 }
 
 // TiDBClient handles TiDB operations for vector search
@@ -37,13 +43,24 @@ type VectorMatch struct {
 	ChunkText  string
 	ChunkIndex int
 	Distance   float64
+	UpdatedAt  time.Time   // Last update time of the source document
+	Metadata   DocMetadata // Document metadata stored with the chunk
 }
 
 // NewTiDBClient creates a new TiDB client
 func NewTiDBClient(cfg TiDBConfig) (*TiDBClient, error) {
-	db, err := sql.Open("mysql", cfg.DSN)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to TiDB: %w", err)
+	var db *sql.DB
+	if cfg.Credentials != nil {
+		connector, err := cfg.Credentials.MySQLConnector(cfg.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to TiDB: %w", err)
+		}
+		db = sql.OpenDB(connector)
+	} else {
+		var err error
+		if db, err = sql.Open("mysql", cfg.DSN); err != nil {
+			return nil, fmt.Errorf("failed to connect to TiDB: %w", err)
+		}
 	}
 
 	// Test connection
@@ -58,7 +75,92 @@ func NewTiDBClient(cfg TiDBConfig) (*TiDBClient, error) {
 
 	log.Infof("[vectorfs/tidb] Connected to TiDB successfully")
 
-	return &TiDBClient{db: db}, nil
+	client := &TiDBClient{db: db}
+	if err := migrate.Apply(db, migrate.MySQL, "vectorfs", client.migrations()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := client.trackNamespaceUsage(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// migrations are the schema changes of the TiDB store, in order. Those
+// predating versioned migrations tolerate stores that already have them.
+func (c *TiDBClient) migrations() []migrate.Migration {
+	return []migrate.Migration{
+		{Version: 1, Description: "deleted namespaces table", Up: func(tx *sql.Tx) error { return createDeletedNamespacesTable(tx) }},
+		{Version: 2, Description: "chunk metadata column", Up: c.migrateChunkMetadata},
+		{Version: 3, Description: "index_pending column", Up: c.migrateIndexPending},
+		{Version: 4, Description: "embedding cache table", Up: c.createEmbeddingCache},
+		{Version: 5, Description: "namespace config table", Up: func(tx *sql.Tx) error { return createNamespaceConfigTable(tx) }},
+		{Version: 6, Description: "namespace owners table", Up: func(tx *sql.Tx) error { return createNamespaceOwnersTable(tx) }},
+		{Version: 7, Description: "namespace usage table", Up: func(tx *sql.Tx) error { return createUsageTable(tx) }},
+	}
+}
+
+// trackNamespaceUsage counts the namespaces whose usage isn't tracked,
+// such as those created before it was
+func (c *TiDBClient) trackNamespaceUsage() error {
+	namespaces, err := c.listNamespaceTables(c.db)
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	for _, ns := range namespaces {
+		tableSuffix := sanitizeTableName(ns)
+		if err := initNamespaceUsage(c.db, questionPlaceholder, ns, "tbl_meta_"+tableSuffix, "tbl_chunks_"+tableSuffix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createEmbeddingCache creates the embedding cache table if missing
+func (c *TiDBClient) createEmbeddingCache(tx *sql.Tx) error {
+	_, err := tx.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			cache_key CHAR(64) PRIMARY KEY,
+			embedding LONGBLOB NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`, embeddingCacheTable))
+	if err != nil {
+		return fmt.Errorf("failed to create embedding cache table: %w", err)
+	}
+	return nil
+}
+
+// migrateChunkMetadata adds the metadata column to chunks tables created
+// before document metadata was supported
+func (c *TiDBClient) migrateChunkMetadata(tx *sql.Tx) error {
+	namespaces, err := c.listNamespaceTables(tx)
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	for _, ns := range namespaces {
+		chunksTable := fmt.Sprintf("tbl_chunks_%s", sanitizeTableName(ns))
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS metadata JSON", chunksTable)); err != nil {
+			return fmt.Errorf("failed to add metadata column to %s: %w", chunksTable, err)
+		}
+	}
+	return nil
+}
+
+// migrateIndexPending adds the index_pending column to metadata tables
+// created before the index queue was durable
+func (c *TiDBClient) migrateIndexPending(tx *sql.Tx) error {
+	namespaces, err := c.listNamespaceTables(tx)
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	for _, ns := range namespaces {
+		metaTable := fmt.Sprintf("tbl_meta_%s", sanitizeTableName(ns))
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS index_pending BOOLEAN NOT NULL DEFAULT FALSE", metaTable)); err != nil {
+			return fmt.Errorf("failed to add index_pending column to %s: %w", metaTable, err)
+		}
+	}
+	return nil
 }
 
 // Close closes the TiDB connection
@@ -95,6 +197,11 @@ func (c *TiDBClient) CreateNamespace(namespace string, embeddingDim int) error {
 	if exists {
 		return fmt.Errorf("namespace already exists: %s", namespace)
 	}
+	if deleted, err := namespaceDeleted(c.db, questionPlaceholder, namespace); err != nil {
+		return fmt.Errorf("failed to check namespace existence: %w", err)
+	} else if deleted {
+		return errNamespaceDeleted(namespace)
+	}
 
 	// Create metadata table
 	createMetaSQL := fmt.Sprintf(`
@@ -105,6 +212,7 @@ func (c *TiDBClient) CreateNamespace(namespace string, embeddingDim int) error {
 			file_size BIGINT NOT NULL DEFAULT 0,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			index_pending BOOLEAN NOT NULL DEFAULT FALSE,
 			INDEX idx_file_name (file_name)
 		)
 	`, metaTable)
@@ -121,6 +229,7 @@ func (c *TiDBClient) CreateNamespace(namespace string, embeddingDim int) error {
 			chunk_index INT NOT NULL,
 			chunk_text TEXT NOT NULL,
 			embedding VECTOR(%d) NOT NULL,
+			metadata JSON,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			INDEX idx_file_digest (file_digest),
 			VECTOR INDEX idx_embedding ((VEC_COSINE_DISTANCE(embedding)))
@@ -131,6 +240,10 @@ func (c *TiDBClient) CreateNamespace(namespace string, embeddingDim int) error {
 		return fmt.Errorf("failed to create chunks table: %w", err)
 	}
 
+	if err := resetNamespaceUsage(c.db, questionPlaceholder, namespace); err != nil {
+		return err
+	}
+
 	log.Infof("[vectorfs/tidb] Created tables for namespace: %s", namespace)
 	return nil
 }
@@ -151,12 +264,29 @@ func (c *TiDBClient) DeleteNamespace(namespace string) error {
 		return fmt.Errorf("failed to drop metadata table: %w", err)
 	}
 
+	if err := deleteNamespaceUsage(c.db, questionPlaceholder, namespace); err != nil {
+		return err
+	}
+	if err := deleteNamespaceConfig(c.db, questionPlaceholder, namespace); err != nil {
+		return err
+	}
+	if err := deleteNamespaceOwner(c.db, questionPlaceholder, namespace); err != nil {
+		return err
+	}
+	if err := unmarkNamespaceDeleted(c.db, questionPlaceholder, namespace); err != nil && !errors.Is(err, filesystem.ErrNotFound) {
+		return err
+	}
+
 	log.Infof("[vectorfs/tidb] Deleted tables for namespace: %s", namespace)
 	return nil
 }
 
 // NamespaceExists checks if a namespace exists
 func (c *TiDBClient) NamespaceExists(namespace string) (bool, error) {
+	// Soft-deleted namespaces keep their tables, hidden
+	if deleted, err := namespaceDeleted(c.db, questionPlaceholder, namespace); err != nil || deleted {
+		return false, err
+	}
 	tableSuffix := sanitizeTableName(namespace)
 	metaTable := fmt.Sprintf("tbl_meta_%s", tableSuffix)
 
@@ -176,8 +306,18 @@ func (c *TiDBClient) NamespaceExists(namespace string) (bool, error) {
 	return count > 0, nil
 }
 
-// ListNamespaces lists all namespaces (by finding all tbl_meta_* tables)
+// ListNamespaces lists all namespaces but the soft-deleted ones
 func (c *TiDBClient) ListNamespaces() ([]string, error) {
+	namespaces, err := c.listNamespaceTables(c.db)
+	if err != nil {
+		return nil, err
+	}
+	return hideDeletedNamespaces(c.db, namespaces)
+}
+
+// listNamespaceTables lists all namespaces, soft-deleted ones included (by
+// finding all tbl_meta_* tables)
+func (c *TiDBClient) listNamespaceTables(db sqlQueryer) ([]string, error) {
 	query := `
 		SELECT table_name
 		FROM information_schema.tables
@@ -185,7 +325,7 @@ func (c *TiDBClient) ListNamespaces() ([]string, error) {
 		AND table_name LIKE 'tbl_meta_%'
 	`
 
-	rows, err := c.db.Query(query)
+	rows, err := db.Query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -237,20 +377,79 @@ func (c *TiDBClient) InsertFileMetadata(namespace string, meta FileMetadata) err
 			updated_at = VALUES(updated_at)
 	`, metaTable)
 
-	_, err := c.db.Exec(query, meta.FileDigest, meta.FileName, meta.S3Key, meta.FileSize,
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldSize sql.NullInt64
+	err = tx.QueryRow(fmt.Sprintf("SELECT file_size FROM %s WHERE file_digest = ? FOR UPDATE", metaTable), meta.FileDigest).Scan(&oldSize)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to insert file metadata: %w", err)
+	}
+	_, err = tx.Exec(query, meta.FileDigest, meta.FileName, meta.S3Key, meta.FileSize,
 		meta.CreatedAt, meta.UpdatedAt)
 	if err != nil {
 		return fmt.Errorf("failed to insert file metadata: %w", err)
 	}
+	var added int64
+	if !oldSize.Valid {
+		added = 1
+	}
+	if err := adjustUsage(tx, questionPlaceholder, namespace, added, meta.FileSize-oldSize.Int64, 0); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
+// SetIndexPending marks whether a file still has to be indexed
+func (c *TiDBClient) SetIndexPending(namespace, fileDigest string, pending bool) error {
+	metaTable := fmt.Sprintf("tbl_meta_%s", sanitizeTableName(namespace))
+
+	// Assigning updated_at to itself keeps ON UPDATE from touching it
+	query := fmt.Sprintf("UPDATE %s SET index_pending = ?, updated_at = updated_at WHERE file_digest = ?", metaTable)
+	if _, err := c.db.Exec(query, pending, fileDigest); err != nil {
+		return fmt.Errorf("failed to update index_pending: %w", err)
+	}
 	return nil
 }
 
+// ListPendingFiles lists the files of a namespace still to be indexed
+func (c *TiDBClient) ListPendingFiles(namespace string) ([]FileMetadata, error) {
+	metaTable := fmt.Sprintf("tbl_meta_%s", sanitizeTableName(namespace))
+
+	query := fmt.Sprintf(`
+		SELECT file_digest, file_name, s3_key, file_size, created_at, updated_at
+		FROM %s
+		WHERE index_pending
+		ORDER BY updated_at
+	`, metaTable)
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []FileMetadata
+	for rows.Next() {
+		var file FileMetadata
+		if err := rows.Scan(&file.FileDigest, &file.FileName, &file.S3Key, &file.FileSize,
+			&file.CreatedAt, &file.UpdatedAt); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
 // ChunkData represents a chunk to be inserted
 type ChunkData struct {
 	ChunkIndex int
 	ChunkText  string
 	Embedding  []float32
+	Metadata   DocMetadata
 }
 
 // InsertChunk inserts a document chunk with embedding
@@ -286,9 +485,15 @@ func (c *TiDBClient) InsertChunksBatch(namespace, fileDigest string, chunks []Ch
 	chunksTable := fmt.Sprintf("tbl_chunks_%s", tableSuffix)
 
 	// Build batch insert query with multiple value sets
-	// INSERT INTO table (cols) VALUES (?, ?, ?, ?), (?, ?, ?, ?), ...
+	// INSERT INTO table (cols) VALUES (?, ?, ?, ?, ?), (?, ?, ?, ?, ?), ...
 	const batchSize = 50 // Optimal batch size to avoid query size limits
 
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	for i := 0; i < len(chunks); i += batchSize {
 		end := i + batchSize
 		if end > len(chunks) {
@@ -298,28 +503,132 @@ func (c *TiDBClient) InsertChunksBatch(namespace, fileDigest string, chunks []Ch
 
 		// Build placeholders and args
 		placeholders := make([]string, len(batch))
-		args := make([]interface{}, 0, len(batch)*4)
+		args := make([]interface{}, 0, len(batch)*5)
 
 		for j, chunk := range batch {
-			placeholders[j] = "(?, ?, ?, ?)"
-			args = append(args, fileDigest, chunk.ChunkIndex, chunk.ChunkText, formatVector(chunk.Embedding))
+			placeholders[j] = "(?, ?, ?, ?, ?)"
+			args = append(args, fileDigest, chunk.ChunkIndex, chunk.ChunkText, formatVector(chunk.Embedding), chunk.Metadata.value())
 		}
 
 		query := fmt.Sprintf(`
-			INSERT INTO %s (file_digest, chunk_index, chunk_text, embedding)
+			INSERT INTO %s (file_digest, chunk_index, chunk_text, embedding, metadata)
 			VALUES %s
 		`, chunksTable, strings.Join(placeholders, ", "))
 
-		_, err := c.db.Exec(query, args...)
+		_, err := tx.Exec(query, args...)
 		if err != nil {
 			return fmt.Errorf("failed to batch insert chunks (batch starting at %d): %w", i, err)
 		}
 	}
+	if err := adjustUsage(tx, questionPlaceholder, namespace, 0, 0, int64(len(chunks))); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 
 	log.Debugf("[vectorfs/tidb] Batch inserted %d chunks for file %s", len(chunks), fileDigest)
 	return nil
 }
 
+// NamespaceUsage returns the usage counters and quota overrides of a namespace
+func (c *TiDBClient) NamespaceUsage(namespace string) (NamespaceUsage, error) {
+	return queryNamespaceUsage(c.db, questionPlaceholder, namespace)
+}
+
+// NamespaceConfig returns the setting overrides of a namespace
+func (c *TiDBClient) NamespaceConfig(namespace string) (NamespaceConfig, error) {
+	return queryNamespaceConfig(c.db, questionPlaceholder, namespace)
+}
+
+// SetNamespaceConfig replaces the setting overrides of a namespace
+func (c *TiDBClient) SetNamespaceConfig(namespace string, cfg NamespaceConfig) error {
+	return updateNamespaceConfig(c.db, questionPlaceholder, namespace, cfg)
+}
+
+// NamespaceOwner returns the identity owning a namespace, "" if none
+func (c *TiDBClient) NamespaceOwner(namespace string) (string, error) {
+	return queryNamespaceOwner(c.db, questionPlaceholder, namespace)
+}
+
+// NamespaceOwners returns the owner of every owned namespace
+func (c *TiDBClient) NamespaceOwners() (map[string]string, error) {
+	return queryNamespaceOwners(c.db)
+}
+
+// SetNamespaceOwner replaces the owner of a namespace
+func (c *TiDBClient) SetNamespaceOwner(namespace, owner string) error {
+	return updateNamespaceOwner(c.db, questionPlaceholder, namespace, owner)
+}
+
+// SoftDeleteNamespace hides a namespace, keeping its tables until
+// DeleteNamespace purges it
+func (c *TiDBClient) SoftDeleteNamespace(namespace string, at time.Time) error {
+	exists, err := c.NamespaceExists(namespace)
+	if err != nil {
+		return fmt.Errorf("failed to check namespace existence: %w", err)
+	}
+	if !exists {
+		return filesystem.NewNotFoundError("delete", namespace)
+	}
+	return markNamespaceDeleted(c.db, questionPlaceholder, namespace, at)
+}
+
+// RestoreNamespace makes a soft-deleted namespace visible again
+func (c *TiDBClient) RestoreNamespace(namespace string) error {
+	return unmarkNamespaceDeleted(c.db, questionPlaceholder, namespace)
+}
+
+// ListDeletedNamespaces returns when each soft-deleted namespace was deleted
+func (c *TiDBClient) ListDeletedNamespaces() (map[string]time.Time, error) {
+	return queryDeletedNamespaces(c.db)
+}
+
+// SetNamespaceQuota replaces the quota overrides of a namespace
+func (c *TiDBClient) SetNamespaceQuota(namespace string, quota NamespaceQuota) error {
+	return updateNamespaceQuota(c.db, questionPlaceholder, namespace, quota)
+}
+
+// GetCachedEmbeddings returns the cached embeddings of keys
+func (c *TiDBClient) GetCachedEmbeddings(keys []string) (map[string][]float32, error) {
+	cached := make(map[string][]float32)
+	for _, batch := range chunkKeys(keys) {
+		args := make([]interface{}, len(batch))
+		for i, key := range batch {
+			args[i] = key
+		}
+		rows, err := c.db.Query(fmt.Sprintf("SELECT cache_key, embedding FROM %s WHERE cache_key IN (%s)",
+			embeddingCacheTable, strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")), args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read embedding cache: %w", err)
+		}
+		if err := scanCachedEmbeddings(rows, cached); err != nil {
+			return nil, err
+		}
+	}
+	return cached, nil
+}
+
+// PutCachedEmbeddings caches embeddings, keeping existing entries
+func (c *TiDBClient) PutCachedEmbeddings(entries map[string][]float32) error {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	for _, batch := range chunkKeys(keys) {
+		args := make([]interface{}, 0, 2*len(batch))
+		for _, key := range batch {
+			args = append(args, key, encodeEmbedding(entries[key]))
+		}
+		query := fmt.Sprintf("INSERT IGNORE INTO %s (cache_key, embedding) VALUES %s",
+			embeddingCacheTable, strings.TrimSuffix(strings.Repeat("(?, ?),", len(batch)), ","))
+		if _, err := c.db.Exec(query, args...); err != nil {
+			return fmt.Errorf("failed to write embedding cache: %w", err)
+		}
+	}
+	return nil
+}
+
 // formatVector converts float32 array to vector string format
 func formatVector(vec []float32) string {
 	strVals := make([]string, len(vec))
@@ -329,13 +638,107 @@ func formatVector(vec []float32) string {
 	return fmt.Sprintf("[%s]", strings.Join(strVals, ","))
 }
 
+// parseVector is the inverse of formatVector
+func parseVector(s string) ([]float32, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("malformed vector %q", s)
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	if s == "" {
+		return []float32{}, nil
+	}
+	fields := strings.Split(s, ",")
+	vec := make([]float32, len(fields))
+	for i, field := range fields {
+		f, err := strconv.ParseFloat(strings.TrimSpace(field), 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed vector element %q: %w", field, err)
+		}
+		vec[i] = float32(f)
+	}
+	return vec, nil
+}
+
+// scanChunks reads chunk_index, chunk_text, embedding and metadata rows,
+// with the embedding in the text form of formatVector
+func scanChunks(rows *sql.Rows) ([]ChunkData, error) {
+	defer rows.Close()
+	var chunks []ChunkData
+	for rows.Next() {
+		var chunk ChunkData
+		var embedding string
+		var metadata sql.NullString
+		if err := rows.Scan(&chunk.ChunkIndex, &chunk.ChunkText, &embedding, &metadata); err != nil {
+			return nil, err
+		}
+		vec, err := parseVector(embedding)
+		if err != nil {
+			return nil, err
+		}
+		chunk.Embedding = vec
+		chunk.Metadata = decodeDocMetadata(metadata)
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+// ListChunks returns the chunks of a file with their embeddings
+func (c *TiDBClient) ListChunks(namespace, fileDigest string) ([]ChunkData, error) {
+	chunksTable := fmt.Sprintf("tbl_chunks_%s", sanitizeTableName(namespace))
+	rows, err := c.db.Query(fmt.Sprintf(
+		"SELECT chunk_index, chunk_text, VEC_AS_TEXT(embedding), metadata FROM %s WHERE file_digest = ? ORDER BY chunk_index", chunksTable),
+		fileDigest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunks: %w", err)
+	}
+	return scanChunks(rows)
+}
+
+// FirstChunks returns the first chunk of each of the files with digests
+func (c *TiDBClient) FirstChunks(namespace string, digests []string) (map[string]ChunkData, error) {
+	chunksTable := fmt.Sprintf("tbl_chunks_%s", sanitizeTableName(namespace))
+	return queryFirstChunks(c.db, questionPlaceholder, chunksTable, "metadata", digests)
+}
+
+// UpdateChunkMetadata replaces the metadata of every chunk of a file
+func (c *TiDBClient) UpdateChunkMetadata(namespace, fileDigest string, metadata DocMetadata) error {
+	chunksTable := fmt.Sprintf("tbl_chunks_%s", sanitizeTableName(namespace))
+	_, err := c.db.Exec(fmt.Sprintf("UPDATE %s SET metadata = ? WHERE file_digest = ?", chunksTable),
+		metadata.value(), fileDigest)
+	if err != nil {
+		return fmt.Errorf("failed to update chunk metadata: %w", err)
+	}
+	return nil
+}
+
+// tidbFilterClause returns the WHERE conditions of a metadata filter, each
+// a JSON containment test against the chunk metadata
+func tidbFilterClause(filter MetadataFilter) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	for _, doc := range filter.containment() {
+		conds = append(conds, "JSON_CONTAINS(c.metadata, ?)")
+		args = append(args, doc)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
 // VectorSearch performs vector similarity search
-func (c *TiDBClient) VectorSearch(namespace string, queryEmbedding []float32, limit int) ([]VectorMatch, error) {
+func (c *TiDBClient) VectorSearch(namespace string, queryEmbedding []float32, filter MetadataFilter, limit int) ([]VectorMatch, error) {
 	tableSuffix := sanitizeTableName(namespace)
 	metaTable := fmt.Sprintf("tbl_meta_%s", tableSuffix)
 	chunksTable := fmt.Sprintf("tbl_chunks_%s", tableSuffix)
 
 	embeddingStr := formatVector(queryEmbedding)
+	args := []interface{}{embeddingStr}
+	where := ""
+	if len(filter) > 0 {
+		conds, filterArgs := tidbFilterClause(filter)
+		where = "WHERE " + conds
+		args = append(args, filterArgs...)
+	}
+	args = append(args, limit)
 
 	// Use parameterized query for vector parameter
 	query := fmt.Sprintf(`
@@ -344,14 +747,17 @@ func (c *TiDBClient) VectorSearch(namespace string, queryEmbedding []float32, li
 			m.file_name,
 			c.chunk_text,
 			c.chunk_index,
-			VEC_COSINE_DISTANCE(c.embedding, ?) AS distance
+			VEC_COSINE_DISTANCE(c.embedding, ?) AS distance,
+			m.updated_at,
+			c.metadata
 		FROM %s c
 		JOIN %s m ON c.file_digest = m.file_digest
+		%s
 		ORDER BY distance
 		LIMIT ?
-	`, chunksTable, metaTable)
+	`, chunksTable, metaTable, where)
 
-	rows, err := c.db.Query(query, embeddingStr, limit)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute vector search: %w", err)
 	}
@@ -360,10 +766,12 @@ func (c *TiDBClient) VectorSearch(namespace string, queryEmbedding []float32, li
 	var results []VectorMatch
 	for rows.Next() {
 		var match VectorMatch
+		var metadata sql.NullString
 		if err := rows.Scan(&match.FileDigest, &match.FileName, &match.ChunkText,
-			&match.ChunkIndex, &match.Distance); err != nil {
+			&match.ChunkIndex, &match.Distance, &match.UpdatedAt, &metadata); err != nil {
 			return nil, err
 		}
+		match.Metadata = decodeDocMetadata(metadata)
 		results = append(results, match)
 	}
 
@@ -371,6 +779,77 @@ func (c *TiDBClient) VectorSearch(namespace string, queryEmbedding []float32, li
 	return results, nil
 }
 
+// KeywordSearch returns up to limit chunks matching keywords, most recently
+// updated documents first. Terms are matched case-insensitively with LIKE,
+// so exact identifiers such as error codes are found.
+func (c *TiDBClient) KeywordSearch(namespace string, keywords KeywordQuery, filter MetadataFilter, limit int) ([]VectorMatch, error) {
+	tableSuffix := sanitizeTableName(namespace)
+	metaTable := fmt.Sprintf("tbl_meta_%s", tableSuffix)
+	chunksTable := fmt.Sprintf("tbl_chunks_%s", tableSuffix)
+
+	var groups []string
+	var args []interface{}
+	for _, group := range keywords {
+		conds := make([]string, len(group))
+		for i, term := range group {
+			conds[i] = "LOWER(c.chunk_text) LIKE ?"
+			args = append(args, "%"+escapeLike(term)+"%")
+		}
+		groups = append(groups, "("+strings.Join(conds, " AND ")+")")
+	}
+	if len(groups) == 0 {
+		return nil, nil
+	}
+	where := "(" + strings.Join(groups, " OR ") + ")"
+	if len(filter) > 0 {
+		conds, filterArgs := tidbFilterClause(filter)
+		where += " AND " + conds
+		args = append(args, filterArgs...)
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`
+		SELECT
+			c.file_digest,
+			m.file_name,
+			c.chunk_text,
+			c.chunk_index,
+			m.updated_at,
+			c.metadata
+		FROM %s c
+		JOIN %s m ON c.file_digest = m.file_digest
+		WHERE %s
+		ORDER BY m.updated_at DESC, c.chunk_id
+		LIMIT ?
+	`, chunksTable, metaTable, where)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute keyword search: %w", err)
+	}
+	defer rows.Close()
+
+	var results []VectorMatch
+	for rows.Next() {
+		var match VectorMatch
+		var metadata sql.NullString
+		if err := rows.Scan(&match.FileDigest, &match.FileName, &match.ChunkText,
+			&match.ChunkIndex, &match.UpdatedAt, &metadata); err != nil {
+			return nil, err
+		}
+		match.Metadata = decodeDocMetadata(metadata)
+		results = append(results, match)
+	}
+
+	log.Debugf("[vectorfs/tidb] Keyword search returned %d results", len(results))
+	return results, rows.Err()
+}
+
+// escapeLike escapes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
 // ListFiles lists all files in a namespace
 func (c *TiDBClient) ListFiles(namespace string) ([]FileMetadata, error) {
 	tableSuffix := sanitizeTableName(namespace)
@@ -468,6 +947,13 @@ func (c *TiDBClient) HasFilesWithPrefix(namespace, prefix string) (bool, error)
 	return true, nil
 }
 
+// ListOrphanedChunkDigests lists the digests of chunks without file metadata
+func (c *TiDBClient) ListOrphanedChunkDigests(namespace string) ([]string, error) {
+	tableSuffix := sanitizeTableName(namespace)
+	return queryOrphanedChunkDigests(c.db,
+		fmt.Sprintf("tbl_meta_%s", tableSuffix), fmt.Sprintf("tbl_chunks_%s", tableSuffix))
+}
+
 // DeleteFileChunks deletes all chunks for a file
 func (c *TiDBClient) DeleteFileChunks(namespace, fileDigest string) error {
 	tableSuffix := sanitizeTableName(namespace)
@@ -475,8 +961,24 @@ func (c *TiDBClient) DeleteFileChunks(namespace, fileDigest string) error {
 
 	query := fmt.Sprintf("DELETE FROM %s WHERE file_digest = ?", chunksTable)
 
-	_, err := c.db.Exec(query, fileDigest)
-	return err
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, fileDigest)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if err := adjustUsage(tx, questionPlaceholder, namespace, 0, 0, -deleted); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // DeleteFileMetadata deletes file metadata
@@ -484,10 +986,27 @@ func (c *TiDBClient) DeleteFileMetadata(namespace, fileDigest string) error {
 	tableSuffix := sanitizeTableName(namespace)
 	metaTable := fmt.Sprintf("tbl_meta_%s", tableSuffix)
 
-	query := fmt.Sprintf("DELETE FROM %s WHERE file_digest = ?", metaTable)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := c.db.Exec(query, fileDigest)
-	return err
+	var size int64
+	err = tx.QueryRow(fmt.Sprintf("SELECT file_size FROM %s WHERE file_digest = ? FOR UPDATE", metaTable), fileDigest).Scan(&size)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE file_digest = ?", metaTable), fileDigest); err != nil {
+		return err
+	}
+	if err := adjustUsage(tx, questionPlaceholder, namespace, -1, -size, 0); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // DeleteFileByName deletes all versions of a file by name (used before writing new content)
@@ -496,38 +1015,63 @@ func (c *TiDBClient) DeleteFileByName(namespace, fileName string) error {
 	metaTable := fmt.Sprintf("tbl_meta_%s", tableSuffix)
 	chunksTable := fmt.Sprintf("tbl_chunks_%s", tableSuffix)
 
-	// First get all digests for this filename
-	query := fmt.Sprintf("SELECT file_digest FROM %s WHERE file_name = ?", metaTable)
-	rows, err := c.db.Query(query, fileName)
+	tx, err := c.db.Begin()
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
+	// First get all digests for this filename
+	query := fmt.Sprintf("SELECT file_digest, file_size FROM %s WHERE file_name = ? FOR UPDATE", metaTable)
+	rows, err := tx.Query(query, fileName)
+	if err != nil {
+		return err
+	}
 	var digests []string
+	var size int64
 	for rows.Next() {
 		var digest string
-		if err := rows.Scan(&digest); err != nil {
+		var fileSize int64
+		if err := rows.Scan(&digest, &fileSize); err != nil {
+			rows.Close()
 			return err
 		}
 		digests = append(digests, digest)
+		size += fileSize
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(digests) == 0 {
+		return nil
 	}
 
 	// Delete chunks and metadata for each digest
+	var chunks int64
 	for _, digest := range digests {
 		// Delete chunks
 		chunkQuery := fmt.Sprintf("DELETE FROM %s WHERE file_digest = ?", chunksTable)
-		if _, err := c.db.Exec(chunkQuery, digest); err != nil {
+		result, err := tx.Exec(chunkQuery, digest)
+		if err != nil {
+			return err
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
 			return err
 		}
+		chunks += deleted
 		// Delete metadata
 		metaQuery := fmt.Sprintf("DELETE FROM %s WHERE file_digest = ?", metaTable)
-		if _, err := c.db.Exec(metaQuery, digest); err != nil {
+		if _, err := tx.Exec(metaQuery, digest); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	if err := adjustUsage(tx, questionPlaceholder, namespace, -int64(len(digests)), -size, -chunks); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // GetFileMetadataByName retrieves file metadata by file name (returns the latest version)
@@ -0,0 +1,163 @@
+package vectorfs
+
+import (
+	"fmt"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// Tokenizer counts and splits text in embedding-model tokens
+type Tokenizer interface {
+	// Count returns the number of tokens in text
+	Count(text string) int
+
+	// Split cuts text into consecutive pieces of at most maxTokens tokens
+	Split(text string, maxTokens int) []string
+
+	// Tail returns the last n tokens of text
+	Tail(text string, n int) string
+}
+
+// Tokenizer names accepted by the "tokenizer" config key
+const (
+	TokenizerApprox   = "approx"
+	TokenizerTiktoken = "tiktoken"
+)
+
+// defaultTiktokenEncoding is used when the embedding model is unknown to tiktoken
+const defaultTiktokenEncoding = "cl100k_base"
+
+// NewTokenizer creates a tokenizer by name. For tiktoken, encoding selects
+// the BPE explicitly; otherwise it is derived from model.
+func NewTokenizer(name, model, encoding string) (Tokenizer, error) {
+	switch name {
+	case "", TokenizerApprox:
+		return approxTokenizer{}, nil
+	case TokenizerTiktoken:
+		return newTiktokenTokenizer(model, encoding)
+	default:
+		return nil, fmt.Errorf("unsupported tokenizer: %s (supported: approx, tiktoken)", name)
+	}
+}
+
+// approxTokenizer estimates 1 token ≈ 4 bytes of text
+type approxTokenizer struct{}
+
+const approxBytesPerToken = 4
+
+func (approxTokenizer) Count(text string) int {
+	return len(text) / approxBytesPerToken
+}
+
+func (approxTokenizer) Split(text string, maxTokens int) []string {
+	if maxTokens <= 0 {
+		return []string{text}
+	}
+	limit := maxTokens * approxBytesPerToken
+	var pieces []string
+	for len(text) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			cut = limit
+		}
+		pieces = append(pieces, text[:cut])
+		text = text[cut:]
+	}
+	if text != "" {
+		pieces = append(pieces, text)
+	}
+	return pieces
+}
+
+func (approxTokenizer) Tail(text string, n int) string {
+	limit := n * approxBytesPerToken
+	if n <= 0 {
+		return ""
+	}
+	if len(text) <= limit {
+		return text
+	}
+	start := len(text) - limit
+	for start < len(text) && !utf8.RuneStart(text[start]) {
+		start++
+	}
+	return text[start:]
+}
+
+// tiktokenTokenizer counts exact tokens with an OpenAI BPE encoding
+type tiktokenTokenizer struct {
+	tk *tiktoken.Tiktoken
+}
+
+var setBpeLoaderOnce sync.Once
+
+func newTiktokenTokenizer(model, encoding string) (*tiktokenTokenizer, error) {
+	// Use the BPE files embedded in the binary instead of downloading them
+	setBpeLoaderOnce.Do(func() {
+		tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+	})
+
+	var tk *tiktoken.Tiktoken
+	var err error
+	if encoding != "" {
+		tk, err = tiktoken.GetEncoding(encoding)
+	} else if tk, err = tiktoken.EncodingForModel(model); err != nil {
+		tk, err = tiktoken.GetEncoding(defaultTiktokenEncoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tiktoken encoding: %w", err)
+	}
+	return &tiktokenTokenizer{tk: tk}, nil
+}
+
+func (t *tiktokenTokenizer) Count(text string) int {
+	return len(t.tk.EncodeOrdinary(text))
+}
+
+func (t *tiktokenTokenizer) Split(text string, maxTokens int) []string {
+	tokens := t.tk.EncodeOrdinary(text)
+	if maxTokens <= 0 || len(tokens) <= maxTokens {
+		return []string{text}
+	}
+
+	var pieces []string
+	for start := 0; start < len(tokens); {
+		end := start + maxTokens
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+		// Multi-byte characters may span tokens; shrink the window so every
+		// piece decodes to valid UTF-8
+		piece := t.tk.Decode(tokens[start:end])
+		for end-start > 1 && end < len(tokens) && !utf8.ValidString(piece) {
+			end--
+			piece = t.tk.Decode(tokens[start:end])
+		}
+		pieces = append(pieces, piece)
+		start = end
+	}
+	return pieces
+}
+
+func (t *tiktokenTokenizer) Tail(text string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	tokens := t.tk.EncodeOrdinary(text)
+	if len(tokens) <= n {
+		return text
+	}
+	start := len(tokens) - n
+	tail := t.tk.Decode(tokens[start:])
+	for start < len(tokens)-1 && !utf8.ValidString(tail) {
+		start++
+		tail = t.tk.Decode(tokens[start:])
+	}
+	return tail
+}
@@ -0,0 +1,166 @@
+package vectorfs
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// usageTable holds the usage counters and quota overrides of every namespace
+// of a vector store. The counters are adjusted in the transaction that adds
+// or removes documents and chunks, so they never drift from the tables.
+const usageTable = "tbl_namespace_usage"
+
+// NamespaceUsage is what a namespace stores, and its quota overrides
+type NamespaceUsage struct {
+	Documents int64
+	Bytes     int64 // Total size of the documents
+	Chunks    int64
+	Quota     NamespaceQuota
+}
+
+// NamespaceQuota limits a namespace. A limit of 0 is unlimited, and
+// quotaInherit takes the limit configured for all namespaces.
+type NamespaceQuota struct {
+	MaxDocuments int64
+	MaxBytes     int64
+	MaxChunks    int64
+}
+
+// quotaInherit marks a namespace limit that follows the plugin config
+const quotaInherit = -1
+
+// sqlPlaceholder returns the n-th (1-based) bind parameter of a dialect
+type sqlPlaceholder func(n int) string
+
+func questionPlaceholder(int) string { return "?" }
+
+func dollarPlaceholder(n int) string { return fmt.Sprintf("$%d", n) }
+
+// sqlExecer is a *sql.DB or a *sql.Tx
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// sqlQueryer is a *sql.DB or a *sql.Tx that can also query
+type sqlQueryer interface {
+	sqlExecer
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// createUsageTable creates the usage table if missing. The schema is the
+// same for every backend.
+func createUsageTable(db sqlExecer) error {
+	_, err := db.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			namespace VARCHAR(255) PRIMARY KEY,
+			documents BIGINT NOT NULL DEFAULT 0,
+			bytes BIGINT NOT NULL DEFAULT 0,
+			chunks BIGINT NOT NULL DEFAULT 0,
+			max_documents BIGINT,
+			max_bytes BIGINT,
+			max_chunks BIGINT
+		)`, usageTable))
+	if err != nil {
+		return fmt.Errorf("failed to create namespace usage table: %w", err)
+	}
+	return nil
+}
+
+// initNamespaceUsage counts the documents and chunks of a namespace created
+// before usage was tracked. Namespaces already tracked are left alone.
+func initNamespaceUsage(db sqlQueryer, ph sqlPlaceholder, namespace, metaTable, chunksTable string) error {
+	var tracked int
+	if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE namespace = %s", usageTable, ph(1)), namespace).Scan(&tracked); err != nil {
+		return fmt.Errorf("failed to read usage of %s: %w", namespace, err)
+	}
+	if tracked > 0 {
+		return nil
+	}
+	var usage NamespaceUsage
+	if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*), COALESCE(SUM(file_size), 0) FROM %s", metaTable)).Scan(&usage.Documents, &usage.Bytes); err != nil {
+		return fmt.Errorf("failed to count documents of %s: %w", namespace, err)
+	}
+	if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", chunksTable)).Scan(&usage.Chunks); err != nil {
+		return fmt.Errorf("failed to count chunks of %s: %w", namespace, err)
+	}
+	_, err := db.Exec(fmt.Sprintf("INSERT INTO %s (namespace, documents, bytes, chunks) VALUES (%s, %s, %s, %s)",
+		usageTable, ph(1), ph(2), ph(3), ph(4)), namespace, usage.Documents, usage.Bytes, usage.Chunks)
+	if err != nil {
+		return fmt.Errorf("failed to record usage of %s: %w", namespace, err)
+	}
+	return nil
+}
+
+// resetNamespaceUsage starts the counters of a new namespace at zero
+func resetNamespaceUsage(db sqlExecer, ph sqlPlaceholder, namespace string) error {
+	if err := deleteNamespaceUsage(db, ph, namespace); err != nil {
+		return err
+	}
+	_, err := db.Exec(fmt.Sprintf("INSERT INTO %s (namespace) VALUES (%s)", usageTable, ph(1)), namespace)
+	if err != nil {
+		return fmt.Errorf("failed to record usage of %s: %w", namespace, err)
+	}
+	return nil
+}
+
+// deleteNamespaceUsage forgets the counters and quota of a namespace
+func deleteNamespaceUsage(db sqlExecer, ph sqlPlaceholder, namespace string) error {
+	if _, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE namespace = %s", usageTable, ph(1)), namespace); err != nil {
+		return fmt.Errorf("failed to delete usage of %s: %w", namespace, err)
+	}
+	return nil
+}
+
+// adjustUsage adds to the counters of a namespace
+func adjustUsage(tx sqlExecer, ph sqlPlaceholder, namespace string, documents, bytes, chunks int64) error {
+	if documents == 0 && bytes == 0 && chunks == 0 {
+		return nil
+	}
+	_, err := tx.Exec(fmt.Sprintf("UPDATE %s SET documents = documents + %s, bytes = bytes + %s, chunks = chunks + %s WHERE namespace = %s",
+		usageTable, ph(1), ph(2), ph(3), ph(4)), documents, bytes, chunks, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to update usage of %s: %w", namespace, err)
+	}
+	return nil
+}
+
+// queryNamespaceUsage reads the counters and quota overrides of a namespace
+func queryNamespaceUsage(db *sql.DB, ph sqlPlaceholder, namespace string) (NamespaceUsage, error) {
+	var usage NamespaceUsage
+	var maxDocuments, maxBytes, maxChunks sql.NullInt64
+	err := db.QueryRow(fmt.Sprintf("SELECT documents, bytes, chunks, max_documents, max_bytes, max_chunks FROM %s WHERE namespace = %s",
+		usageTable, ph(1)), namespace).Scan(&usage.Documents, &usage.Bytes, &usage.Chunks, &maxDocuments, &maxBytes, &maxChunks)
+	if err == sql.ErrNoRows {
+		return usage, filesystem.NewNotFoundError("usage", namespace)
+	}
+	if err != nil {
+		return usage, fmt.Errorf("failed to read usage of %s: %w", namespace, err)
+	}
+	limit := func(v sql.NullInt64) int64 {
+		if !v.Valid {
+			return quotaInherit
+		}
+		return v.Int64
+	}
+	usage.Quota = NamespaceQuota{MaxDocuments: limit(maxDocuments), MaxBytes: limit(maxBytes), MaxChunks: limit(maxChunks)}
+	return usage, nil
+}
+
+// updateNamespaceQuota replaces the quota overrides of a namespace
+func updateNamespaceQuota(db *sql.DB, ph sqlPlaceholder, namespace string, quota NamespaceQuota) error {
+	value := func(limit int64) interface{} {
+		if limit == quotaInherit {
+			return nil
+		}
+		return limit
+	}
+	_, err := db.Exec(fmt.Sprintf("UPDATE %s SET max_documents = %s, max_bytes = %s, max_chunks = %s WHERE namespace = %s",
+		usageTable, ph(1), ph(2), ph(3), ph(4)), value(quota.MaxDocuments), value(quota.MaxBytes), value(quota.MaxChunks), namespace)
+	if err != nil {
+		return fmt.Errorf("failed to set quota of %s: %w", namespace, err)
+	}
+	return nil
+}
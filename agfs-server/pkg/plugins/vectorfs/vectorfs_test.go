@@ -1,15 +1,32 @@
 package vectorfs
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
 
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/migrate"
 	_ "github.com/go-sql-driver/mysql"
 )
 
@@ -65,6 +82,9 @@ func TestFormatVector(t *testing.T) {
 			if result != tt.expected {
 				t.Errorf("formatVector(%v) = %s, want %s", tt.input, result, tt.expected)
 			}
+			if parsed, err := parseVector(result); err != nil || !reflect.DeepEqual(parsed, tt.input) {
+				t.Errorf("parseVector(%s) = %v, %v; want %v", result, parsed, err, tt.input)
+			}
 		})
 	}
 }
@@ -92,6 +112,19 @@ func TestSanitizeTableName(t *testing.T) {
 	}
 }
 
+func TestPGTables(t *testing.T) {
+	meta, chunks := pgTables("My-Project")
+	if meta != `"tbl_meta_My_Project"` || chunks != `"tbl_chunks_My_Project"` {
+		t.Errorf("pgTables() = %s, %s", meta, chunks)
+	}
+}
+
+func TestPGDriver(t *testing.T) {
+	if driver, err := pgDriver(); err != nil || driver != "postgres" {
+		t.Errorf("pgDriver() = %q, %v, want lib/pq", driver, err)
+	}
+}
+
 // ============================================================================
 // Unit Tests for HTTP Client Timeout
 // ============================================================================
@@ -141,6 +174,221 @@ func TestNewEmbeddingClientHasTimeout(t *testing.T) {
 	}
 }
 
+// ============================================================================
+// Unit Tests for the Ollama Provider
+// ============================================================================
+
+func TestOllamaEmbeddingClient(t *testing.T) {
+	var requests []ollamaEmbedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaEmbedRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		resp := ollamaEmbedResponse{}
+		for i := range req.Input {
+			resp.Embeddings = append(resp.Embeddings, []float32{float32(i), 0.5, 0.25, 0.125})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	client, err := NewEmbeddingClient(EmbeddingConfig{Provider: ProviderOllama, Model: "nomic-embed-text", Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("NewEmbeddingClient failed: %v", err)
+	}
+	if client.GetDimension() != 4 {
+		t.Errorf("detected dimension = %d, want 4", client.GetDimension())
+	}
+
+	embeddings, err := client.GenerateBatchEmbeddings([]string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("GenerateBatchEmbeddings failed: %v", err)
+	}
+	if len(embeddings) != 3 || embeddings[2][0] != 2 {
+		t.Errorf("embeddings = %v", embeddings)
+	}
+	if last := requests[len(requests)-1]; len(requests) != 2 || last.Model != "nomic-embed-text" || len(last.Input) != 3 {
+		t.Errorf("requests = %+v, want a probe and one batch request", requests)
+	}
+
+	// A configured dimension that the model does not produce is an error
+	client, err = NewEmbeddingClient(EmbeddingConfig{Provider: ProviderOllama, Model: "m", Dimension: 768, Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("NewEmbeddingClient failed: %v", err)
+	}
+	if _, err := client.GenerateEmbedding("a"); err == nil {
+		t.Error("expected dimension mismatch error")
+	}
+}
+
+func TestOllamaEmbeddingClientErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	if _, err := NewEmbeddingClient(EmbeddingConfig{Provider: ProviderOllama, Model: "missing", Endpoint: server.URL}); err == nil ||
+		!strings.Contains(err.Error(), "model not found") {
+		t.Errorf("dimension detection error = %v", err)
+	}
+	if _, err := NewEmbeddingClient(EmbeddingConfig{Provider: "palm", Dimension: 3}); err == nil {
+		t.Error("expected unsupported provider error")
+	}
+	if _, err := NewEmbeddingClient(EmbeddingConfig{Provider: ProviderOpenAI, Dimension: 3, Endpoint: server.URL}); err != nil {
+		t.Errorf("OpenAI-compatible endpoint without API key: %v", err)
+	}
+}
+
+func TestEmbeddingProviders(t *testing.T) {
+	type request struct {
+		path   string
+		header http.Header
+		body   map[string]interface{}
+	}
+	var mu sync.Mutex
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		requests = append(requests, request{path: r.URL.String(), header: r.Header, body: body})
+		mu.Unlock()
+
+		// Each embedding is [position in the batch, 1]
+		count := 0
+		for _, key := range []string{"input", "texts", "requests"} {
+			if items, ok := body[key].([]interface{}); ok {
+				count = len(items)
+			}
+		}
+		var embeddings [][]float32
+		for i := 0; i < count; i++ {
+			embeddings = append(embeddings, []float32{float32(i), 1})
+		}
+		switch {
+		case strings.Contains(r.URL.Path, "batchEmbedContents"):
+			var resp geminiBatchEmbedResponse
+			for _, e := range embeddings {
+				resp.Embeddings = append(resp.Embeddings, struct {
+					Values []float32 `json:"values"`
+				}{e})
+			}
+			json.NewEncoder(w).Encode(resp)
+		case strings.HasSuffix(r.URL.Path, "/embed"):
+			var resp cohereEmbedResponse
+			resp.Embeddings.Float = embeddings
+			json.NewEncoder(w).Encode(resp)
+		default:
+			data := []map[string]interface{}{}
+			for i := len(embeddings) - 1; i >= 0; i-- { // Out of order
+				data = append(data, map[string]interface{}{"index": i, "embedding": embeddings[i]})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		cfg        EmbeddingConfig
+		path       string // Request URL
+		authHeader string
+		authValue  string
+		batch      int    // Texts per request
+		inputKey   string // Where the query/document input type goes
+		query      string
+		document   string
+	}{
+		{
+			cfg:  EmbeddingConfig{Provider: ProviderAzureOpenAI, APIKey: "azure-key", Model: "my-embeddings", Endpoint: server.URL + "/"},
+			path: "/openai/deployments/my-embeddings/embeddings?api-version=" + azureOpenAIAPIVersion, authHeader: "Api-Key", authValue: "azure-key",
+			batch: azureOpenAIMaxBatch,
+		},
+		{
+			cfg:  EmbeddingConfig{Provider: ProviderGemini, APIKey: "gemini-key", Model: "text-embedding-004", Endpoint: server.URL + "/models/text-embedding-004:batchEmbedContents"},
+			path: "/models/text-embedding-004:batchEmbedContents", authHeader: "X-Goog-Api-Key", authValue: "gemini-key",
+			batch: geminiMaxBatch, inputKey: "taskType", query: "RETRIEVAL_QUERY", document: "RETRIEVAL_DOCUMENT",
+		},
+		{
+			cfg:  EmbeddingConfig{Provider: ProviderVoyage, APIKey: "voyage-key", Model: "voyage-3", Endpoint: server.URL + "/v1/embeddings"},
+			path: "/v1/embeddings", authHeader: "Authorization", authValue: "Bearer voyage-key",
+			batch: voyageMaxBatch, inputKey: "input_type", query: "query", document: "document",
+		},
+		{
+			cfg:  EmbeddingConfig{Provider: ProviderCohere, APIKey: "cohere-key", Model: "embed-english-v3.0", Endpoint: server.URL + "/v2/embed"},
+			path: "/v2/embed", authHeader: "Authorization", authValue: "Bearer cohere-key",
+			batch: cohereMaxBatch, inputKey: "input_type", query: "search_query", document: "search_document",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cfg.Provider, func(t *testing.T) {
+			mu.Lock()
+			requests = nil
+			mu.Unlock()
+
+			// The dimension is detected with a query
+			client, err := NewEmbeddingClient(tt.cfg)
+			if err != nil {
+				t.Fatalf("NewEmbeddingClient() error = %v", err)
+			}
+			if client.GetDimension() != 2 {
+				t.Errorf("GetDimension() = %d, want 2", client.GetDimension())
+			}
+			texts := make([]string, tt.batch+1)
+			for i := range texts {
+				texts[i] = fmt.Sprintf("text %d", i)
+			}
+			embeddings, err := client.GenerateBatchEmbeddings(texts)
+			if err != nil {
+				t.Fatalf("GenerateBatchEmbeddings() error = %v", err)
+			}
+			if len(embeddings) != len(texts) || embeddings[1][0] != 1 || embeddings[tt.batch][0] != 0 {
+				t.Errorf("GenerateBatchEmbeddings() returned %d embeddings, want %d in order", len(embeddings), len(texts))
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(requests) != 3 {
+				t.Fatalf("got %d requests, want a probe and two batches", len(requests))
+			}
+			for _, req := range requests {
+				if req.path != tt.path {
+					t.Errorf("request URL = %s, want %s", req.path, tt.path)
+				}
+				if got := req.header.Get(tt.authHeader); got != tt.authValue {
+					t.Errorf("%s header = %q, want %q", tt.authHeader, got, tt.authValue)
+				}
+			}
+			if tt.inputKey == "" {
+				return
+			}
+			inputType := func(body map[string]interface{}) interface{} {
+				if reqs, ok := body["requests"].([]interface{}); ok {
+					return reqs[0].(map[string]interface{})[tt.inputKey]
+				}
+				return body[tt.inputKey]
+			}
+			if got := inputType(requests[0].body); got != tt.query {
+				t.Errorf("probe input type = %v, want %s", got, tt.query)
+			}
+			if got := inputType(requests[1].body); got != tt.document {
+				t.Errorf("batch input type = %v, want %s", got, tt.document)
+			}
+		})
+	}
+
+	// Each provider has its own requirements
+	for _, cfg := range []EmbeddingConfig{
+		{Provider: ProviderAzureOpenAI, APIKey: "key"},
+		{Provider: ProviderAzureOpenAI, Endpoint: server.URL},
+		{Provider: ProviderGemini},
+		{Provider: ProviderCohere, Dimension: -1, APIKey: "key"},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded", cfg)
+		}
+	}
+}
+
 // ============================================================================
 // Unit Tests for Queue Overflow Handling
 // ============================================================================
@@ -315,6 +563,74 @@ func TestChunkDocument(t *testing.T) {
 	}
 }
 
+func TestChunkStrategies(t *testing.T) {
+	chunkTexts := func(fileName, text string, cfg ChunkerConfig) []string {
+		var texts []string
+		for i, chunk := range ChunkFile(fileName, text, cfg) {
+			if chunk.Index != i {
+				t.Errorf("chunk %d has index %d", i, chunk.Index)
+			}
+			if n := (approxTokenizer{}).Count(chunk.Text); n > cfg.ChunkSize {
+				t.Errorf("chunk %d has %d tokens, more than %d", i, n, cfg.ChunkSize)
+			}
+			texts = append(texts, chunk.Text)
+		}
+		return texts
+	}
+
+	t.Run("markdown", func(t *testing.T) {
+		doc := "# Guide\n\nIntro text.\n\n## Install\n\nRun **make**.\n\n```sh\n# not a heading\nmake\n```\n\n## Usage\n\nSee [docs](http://x).\n"
+		texts := chunkTexts("guide.md", doc, ChunkerConfig{ChunkSize: 100, Strategy: ChunkStrategyMarkdown})
+		want := []string{
+			"Guide\n\nIntro text.",
+			"Guide > Install\n\nRun make.\nnot a heading\nmake",
+			"Guide > Usage\n\nSee docs.",
+		}
+		if !reflect.DeepEqual(texts, want) {
+			t.Errorf("chunks = %q, want %q", texts, want)
+		}
+	})
+
+	t.Run("sentence", func(t *testing.T) {
+		doc := "One short sentence.\n\nTwo short sentence.\n\nThree short sentence."
+		texts := chunkTexts("a.txt", doc, ChunkerConfig{ChunkSize: 100, Strategy: ChunkStrategySentence})
+		if len(texts) != 1 {
+			t.Errorf("chunks = %q, want sentences packed into one", texts)
+		}
+		if texts := chunkTexts("a.txt", doc, ChunkerConfig{ChunkSize: 100}); len(texts) != 3 {
+			t.Errorf("paragraph chunks = %q, want one per paragraph", texts)
+		}
+	})
+
+	t.Run("go", func(t *testing.T) {
+		src := "package p\n\nimport \"fmt\"\n\n// A says a\nfunc A() {\n\tfmt.Println(\"" + strings.Repeat("a", 200) + "\")\n}\n\n// B says b\nfunc B() {\n\tfmt.Println(\"" + strings.Repeat("b", 200) + "\")\n}\n"
+		texts := chunkTexts("p.go", src, ChunkerConfig{ChunkSize: 80, Strategy: ChunkStrategyAuto})
+		// The preamble is small enough to share a chunk with A
+		if len(texts) != 2 || !strings.Contains(texts[0], "\n\n// A says a\nfunc A()") || !strings.HasPrefix(texts[1], "// B says b\nfunc B()") {
+			t.Errorf("chunks = %q, want the preamble with A, and B, with their comments", texts)
+		}
+	})
+
+	t.Run("python", func(t *testing.T) {
+		src := "import os\n\n@cache\ndef a():\n    return 1\n\n\nclass B:\n    x = 1\n"
+		texts := chunkTexts("m.py", src, ChunkerConfig{ChunkSize: 8, Strategy: ChunkStrategyCode})
+		want := []string{"import os", "@cache\ndef a():\n    return 1", "class B:\n    x = 1"}
+		if !reflect.DeepEqual(texts, want) {
+			t.Errorf("chunks = %q, want %q", texts, want)
+		}
+	})
+
+	t.Run("auto falls back to paragraph", func(t *testing.T) {
+		cfg := ChunkerConfig{Strategy: ChunkStrategyAuto}
+		if got := cfg.strategyFor("notes.txt"); got != ChunkStrategyParagraph {
+			t.Errorf("strategyFor(notes.txt) = %s, want paragraph", got)
+		}
+		if got := cfg.strategyFor("README.MD"); got != ChunkStrategyMarkdown {
+			t.Errorf("strategyFor(README.MD) = %s, want markdown", got)
+		}
+	})
+}
+
 // ============================================================================
 // Unit Tests for Indexing Status
 // ============================================================================
@@ -362,6 +678,62 @@ func TestIndexingStatus(t *testing.T) {
 	}
 }
 
+func TestIndexingStatusStates(t *testing.T) {
+	plugin := &VectorFSPlugin{
+		indexingStatus: make(map[string]map[string]*indexingFileInfo),
+	}
+
+	plugin.addIndexingTask("test-ns", "digest1", "a.txt")
+	plugin.addIndexingTask("test-ns", "digest2", "sub/b.txt")
+	plugin.addIndexingTask("other-ns", "digest3", "c.txt")
+	plugin.startIndexingTask("test-ns", "digest1")
+
+	status := plugin.getIndexingStatus("test-ns")
+	for _, want := range []string{"indexing 2 file(s): 1 queued, 1 embedding", "queue depth: 2", "a.txt (embedding"} {
+		if !strings.Contains(status, want) {
+			t.Errorf("status missing %q: %q", want, status)
+		}
+	}
+
+	plugin.finishIndexingTask("test-ns", "digest1", nil)
+	plugin.startIndexingTask("test-ns", "digest2")
+	plugin.finishIndexingTask("test-ns", "digest2", errors.New("embedding service unavailable"))
+	if n := plugin.pendingIndexing("test-ns"); n != 0 {
+		t.Errorf("pendingIndexing() = %d, want 0", n)
+	}
+	status = plugin.getIndexingStatus("test-ns")
+	if !strings.HasPrefix(status, "idle\n") {
+		t.Errorf("status = %q, want idle first", status)
+	}
+	for _, want := range []string{"stored: 1", "failed: 1", "last error: sub/b.txt: embedding service unavailable"} {
+		if !strings.Contains(status, want) {
+			t.Errorf("status missing %q: %q", want, status)
+		}
+	}
+
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	entries, err := vfs.readIndexingDir("test-ns", "")
+	if err != nil || len(entries) != 2 || entries[0].Name != "a.txt" || entries[1].Name != "sub" || !entries[1].IsDir {
+		t.Fatalf("readIndexingDir() = %+v, %v", entries, err)
+	}
+	data, err := vfs.Read("/test-ns/.indexing.d/sub/b.txt", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !strings.Contains(string(data), "state: failed") || !strings.Contains(string(data), "error: embedding service unavailable") {
+		t.Errorf("Read() = %q", data)
+	}
+	if _, err := vfs.Stat("/test-ns/.indexing.d/missing.txt"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Stat(missing) error = %v, want not found", err)
+	}
+
+	// Rewriting a document replaces its earlier state
+	plugin.addIndexingTask("test-ns", "digest4", "sub/b.txt")
+	if status := plugin.getIndexingStatus("test-ns"); strings.Contains(status, "failed: 1") {
+		t.Errorf("status after rewrite = %q", status)
+	}
+}
+
 // ============================================================================
 // Integration Tests (require database connection)
 // ============================================================================
@@ -643,3 +1015,2842 @@ func getTestDSN() string {
 	// Example: export TIDB_TEST_DSN="user:pass@tcp(localhost:4000)/test?parseTime=true"
 	return os.Getenv("TIDB_TEST_DSN")
 }
+
+// ============================================================================
+// Unit Tests for Recency Ranking
+// ============================================================================
+
+func TestRankingDefaultKeepsSimilarityOrder(t *testing.T) {
+	now := time.Now()
+	matches := []VectorMatch{
+		{FileName: "old.md", Distance: 0.1, UpdatedAt: now.Add(-365 * 24 * time.Hour)},
+		{FileName: "new.md", Distance: 0.2, UpdatedAt: now},
+	}
+
+	ranked := RankingConfig{}.Rank(matches, 10, now)
+	if len(ranked) != 2 || ranked[0].FileName != "old.md" {
+		t.Fatalf("expected similarity order to be preserved, got %+v", ranked)
+	}
+	if ranked[0].Score != 0.9 || ranked[0].Recency != 1 {
+		t.Errorf("unexpected score without recency: %+v", ranked[0])
+	}
+}
+
+func TestRankingRecencyDecay(t *testing.T) {
+	now := time.Now()
+	rc := RankingConfig{RecencyHalfLife: 24 * time.Hour, RecencyWeight: 0.5}
+	matches := []VectorMatch{
+		{FileName: "old.md", Distance: 0.1, UpdatedAt: now.Add(-30 * 24 * time.Hour)},
+		{FileName: "new.md", Distance: 0.2, UpdatedAt: now.Add(-24 * time.Hour)},
+		{FileName: "mid.md", Distance: 0.3, UpdatedAt: now.Add(-48 * time.Hour)},
+	}
+
+	ranked := rc.Rank(matches, 2, now)
+	if len(ranked) != 2 {
+		t.Fatalf("expected results truncated to limit, got %d", len(ranked))
+	}
+	if ranked[0].FileName != "new.md" || ranked[1].FileName != "mid.md" {
+		t.Fatalf("expected newer documents first, got %s, %s", ranked[0].FileName, ranked[1].FileName)
+	}
+	if diff := ranked[0].Recency - 0.5; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("recency after one half-life should be 0.5, got %v", ranked[0].Recency)
+	}
+	if rc.candidateLimit(10) != 10*recencyCandidateFactor {
+		t.Errorf("expected over-fetch when recency ranking is enabled")
+	}
+}
+
+func TestParseRankingConfig(t *testing.T) {
+	rc, err := parseRankingConfig(map[string]interface{}{"recency_half_life": "72h", "recency_weight": 0.4})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rc.RecencyHalfLife != 72*time.Hour || rc.RecencyWeight != 0.4 || !rc.RecencyEnabled() {
+		t.Errorf("unexpected ranking config: %+v", rc)
+	}
+
+	if rc, _ := parseRankingConfig(map[string]interface{}{}); rc.RecencyEnabled() {
+		t.Errorf("recency ranking should be disabled by default")
+	}
+
+	for _, cfg := range []map[string]interface{}{
+		{"recency_half_life": "soon"},
+		{"recency_half_life": "1h", "recency_weight": 1.5},
+	} {
+		if _, err := parseRankingConfig(cfg); err == nil {
+			t.Errorf("expected error for %v", cfg)
+		}
+	}
+}
+
+// ============================================================================
+// Unit Tests for Federated Search
+// ============================================================================
+
+func TestParseNamespaceSet(t *testing.T) {
+	namespaces, federated := parseNamespaceSet("{team-a, team-b,team-a}")
+	if !federated || len(namespaces) != 2 || namespaces[0] != "team-a" || namespaces[1] != "team-b" {
+		t.Errorf("unexpected expansion: %v (federated=%v)", namespaces, federated)
+	}
+
+	namespaces, federated = parseNamespaceSet("team-a")
+	if federated || len(namespaces) != 1 || namespaces[0] != "team-a" {
+		t.Errorf("plain namespace should not be federated: %v", namespaces)
+	}
+}
+
+func TestMergeFederatedResults(t *testing.T) {
+	perNamespace := [][]mountablefs.CustomGrepResult{
+		{
+			{File: "a/docs/1.md", Metadata: map[string]interface{}{"score": 0.9}},
+			{File: "a/docs/2.md", Metadata: map[string]interface{}{"score": 0.5}},
+		},
+		{
+			{File: "b/docs/1.md", Metadata: map[string]interface{}{"score": 0.7}},
+		},
+	}
+
+	merged := mergeFederatedResults([]string{"a", "b"}, perNamespace, 2)
+	if len(merged) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(merged))
+	}
+	if merged[0].File != "a/docs/1.md" || merged[1].File != "b/docs/1.md" {
+		t.Errorf("results not merged by score: %s, %s", merged[0].File, merged[1].File)
+	}
+	if merged[1].Metadata["namespace"] != "b" {
+		t.Errorf("missing namespace attribution: %v", merged[1].Metadata)
+	}
+}
+
+// ============================================================================
+// Unit Tests for Hybrid Search
+// ============================================================================
+
+func TestParseHybridQuery(t *testing.T) {
+	text, keywords, err := parseHybridQuery("how to deploy")
+	if err != nil || text != "how to deploy" || keywords != nil {
+		t.Errorf("plain query: %q %v %v", text, keywords, err)
+	}
+
+	text, keywords, err = parseHybridQuery(`kw:deploy AND Production OR "ERR 4012" rollback`)
+	if err != nil {
+		t.Fatalf("parseHybridQuery() error = %v", err)
+	}
+	if text != "deploy Production ERR 4012 rollback" {
+		t.Errorf("text = %q", text)
+	}
+	want := KeywordQuery{{"deploy", "production"}, {"err 4012", "rollback"}}
+	if fmt.Sprint(keywords) != fmt.Sprint(want) {
+		t.Errorf("keywords = %q, want %q", keywords, want)
+	}
+
+	for _, bad := range []string{"kw:", "kw:AND x", "kw:x OR", `kw:"open`, "kw:a OR OR b"} {
+		if _, _, err := parseHybridQuery(bad); err == nil {
+			t.Errorf("parseHybridQuery(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestFuseResults(t *testing.T) {
+	vector := RankingConfig{}.Rank([]VectorMatch{
+		{FileDigest: "a", FileName: "a.md", ChunkText: "deploying services", Distance: 0.1},
+		{FileDigest: "b", FileName: "b.md", ChunkText: "ERR_4012 on deploy", Distance: 0.3},
+	}, 10, time.Now())
+	keyword := []VectorMatch{
+		{FileDigest: "c", FileName: "c.md", ChunkText: "err_4012"},
+		{FileDigest: "b", FileName: "b.md", ChunkText: "ERR_4012 on deploy, ERR_4012 again"},
+	}
+	keywords := KeywordQuery{{"err_4012"}}
+
+	results := fuseResults("ns", vector, keyword, keywords, 2)
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	// b is in both rankings, so it wins over the top vector-only result
+	if results[0].File != "ns/docs/b.md" || results[1].File != "ns/docs/a.md" {
+		t.Errorf("fused order = %s, %s", results[0].File, results[1].File)
+	}
+	meta := results[0].Metadata
+	if meta["vector_rank"] != 2 || meta["keyword_rank"] != 1 || meta["keyword_hits"] != 2 {
+		t.Errorf("metadata = %v", meta)
+	}
+	want := 1.0 / 62 // vector rank 2
+	want += 1.0 / 61 // keyword rank 1
+	if resultScore(results[0]) != want {
+		t.Errorf("score = %v, want %v", resultScore(results[0]), want)
+	}
+}
+
+// ============================================================================
+// Unit Tests for Metadata Filtering
+// ============================================================================
+
+func TestParseSearchModifiers(t *testing.T) {
+	text, mods, err := parseSearchModifiers("how to deploy")
+	if err != nil || text != "how to deploy" || mods.filter != nil {
+		t.Errorf("plain query: %q %v %v", text, mods, err)
+	}
+
+	text, mods, err = parseSearchModifiers("kw:a -- b -- filter:author=alice,tag=api filter:tag=v2")
+	if err != nil {
+		t.Fatalf("parseSearchModifiers() error = %v", err)
+	}
+	if text != "kw:a -- b" {
+		t.Errorf("text = %q", text)
+	}
+	want := MetadataFilter{{"author", "alice"}, {"tag", "api"}, {"tag", "v2"}}
+	if fmt.Sprint(mods.filter) != fmt.Sprint(want) {
+		t.Errorf("filter = %v, want %v", mods.filter, want)
+	}
+	if docs := mods.filter.containment(); docs[0] != `{"author":["alice"]}` {
+		t.Errorf("containment = %v", docs)
+	}
+
+	text, mods, err = parseSearchModifiers("deploy -- topk:25 minscore=0.7 filter:a=b")
+	if err != nil || text != "deploy" || mods.topK != 25 || !mods.minScoreSet || mods.minScore != 0.7 || len(mods.filter) != 1 {
+		t.Errorf("topk/minscore: %q %+v %v", text, mods, err)
+	}
+
+	text, mods, err = parseSearchModifiers("deploy -- mmr:0.5 dedup=off")
+	if err != nil || text != "deploy" || !mods.mmrSet || mods.mmrLambda != 0.5 || !mods.dedupSet || mods.dedupThreshold != 0 {
+		t.Errorf("mmr/dedup: %q %+v %v", text, mods, err)
+	}
+
+	for _, bad := range []string{"q -- filter:author", "q -- filter:=x", "q -- top:3", "q -- topk:0", "q -- topk:x", "q -- minscore:1.5", " -- filter:a=b", "q -- mmr:2", "q -- dedup:on"} {
+		if _, _, err := parseSearchModifiers(bad); err == nil {
+			t.Errorf("parseSearchModifiers(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestSearchConfigLimits(t *testing.T) {
+	sc, err := parseSearchConfig(map[string]interface{}{"default_topk": 5, "max_topk": 20, "min_score": 0.2})
+	if err != nil {
+		t.Fatalf("parseSearchConfig() error = %v", err)
+	}
+	tests := []struct {
+		requested int
+		mods      searchModifiers
+		want      int
+	}{
+		{0, searchModifiers{}, 5},
+		{8, searchModifiers{}, 8},
+		{8, searchModifiers{topK: 3}, 3},
+		{0, searchModifiers{topK: 50}, 20},
+		{500, searchModifiers{}, 20},
+	}
+	for _, tt := range tests {
+		if got := sc.resultLimit(tt.requested, tt.mods); got != tt.want {
+			t.Errorf("resultLimit(%d, %+v) = %d, want %d", tt.requested, tt.mods, got, tt.want)
+		}
+	}
+	if got := sc.minScore(searchModifiers{}); got != 0.2 {
+		t.Errorf("minScore() = %v, want configured 0.2", got)
+	}
+	if got := sc.minScore(searchModifiers{minScore: 0, minScoreSet: true}); got != 0 {
+		t.Errorf("minScore(minscore:0) = %v, want 0", got)
+	}
+
+	for _, cfg := range []map[string]interface{}{
+		{"default_topk": 0},
+		{"default_topk": 50, "max_topk": 10},
+		{"min_score": 1.5},
+		{"mmr_lambda": -0.5},
+		{"dedup_threshold": 1.1},
+	} {
+		if _, err := parseSearchConfig(cfg); err == nil {
+			t.Errorf("parseSearchConfig(%v) succeeded, want error", cfg)
+		}
+	}
+}
+
+func TestDiversityApply(t *testing.T) {
+	result := func(file, content string, score float64) mountablefs.CustomGrepResult {
+		return mountablefs.CustomGrepResult{File: file, Content: content, Metadata: map[string]interface{}{"score": score}}
+	}
+	candidates := func() []mountablefs.CustomGrepResult {
+		return []mountablefs.CustomGrepResult{
+			result("a.txt", "deploy the service with helm", 0.9),
+			result("a.txt", "Deploy the service with Helm.", 0.89),
+			result("a.txt", "roll back a failed release", 0.85),
+			result("b.txt", "deploy the service with helm charts", 0.5),
+		}
+	}
+	contents := func(results []mountablefs.CustomGrepResult) []string {
+		var out []string
+		for _, r := range results {
+			out = append(out, r.File+": "+r.Content)
+		}
+		return out
+	}
+
+	// Only chunks of the same file collapse
+	got := diversity{threshold: 0.9}.apply(candidates(), 3)
+	want := []string{"a.txt: deploy the service with helm", "a.txt: roll back a failed release", "b.txt: deploy the service with helm charts"}
+	if fmt.Sprint(contents(got)) != fmt.Sprint(want) {
+		t.Errorf("dedup = %v, want %v", contents(got), want)
+	}
+	if got[0].Metadata["duplicates"] != 1 {
+		t.Errorf("duplicates = %v, want 1", got[0].Metadata["duplicates"])
+	}
+
+	// MMR passes over the near copy of the first result
+	got = diversity{lambda: 0.5}.apply(candidates(), 2)
+	want = []string{"a.txt: deploy the service with helm", "a.txt: roll back a failed release"}
+	if fmt.Sprint(contents(got)) != fmt.Sprint(want) {
+		t.Errorf("mmr = %v, want %v", contents(got), want)
+	}
+
+	// Neither keeps the relevance order
+	got = diversity{lambda: 1}.apply(candidates(), 2)
+	if len(got) != 2 || got[1].Content != "Deploy the service with Helm." {
+		t.Errorf("no diversity = %v", contents(got))
+	}
+}
+
+func TestParseDocMetadata(t *testing.T) {
+	meta, err := parseDocMetadata([]byte(`{"author":"alice","tags":["api",2],"draft":false}`))
+	if err != nil {
+		t.Fatalf("parseDocMetadata() error = %v", err)
+	}
+	if fmt.Sprint(meta) != "map[author:[alice] draft:[false] tags:[api 2]]" {
+		t.Errorf("metadata = %v", meta)
+	}
+	for _, bad := range []string{`[1]`, `{"a":{"b":1}}`, `{"a b":"c"}`, `{"a":null}`, `not json`} {
+		if _, err := parseDocMetadata([]byte(bad)); !errors.Is(err, filesystem.ErrInvalidArgument) {
+			t.Errorf("parseDocMetadata(%s) error = %v, want invalid argument", bad, err)
+		}
+	}
+
+	if target, ok := sidecarTarget("sub/guide.md.meta.json"); !ok || target != "sub/guide.md" {
+		t.Errorf("sidecarTarget() = %q, %v", target, ok)
+	}
+	if _, ok := sidecarTarget("sub/.meta.json"); ok {
+		t.Error("bare .meta.json treated as a sidecar")
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got := escapeLike(`50%_off\`); got != `50\%\_off\\` {
+		t.Errorf("escapeLike() = %q", got)
+	}
+}
+
+func TestTiDBKeywordSearch(t *testing.T) {
+	dsn := getTestDSN()
+	if dsn == "" {
+		t.Skip("Skipping database test: TIDB_TEST_DSN not set")
+	}
+
+	client, err := NewTiDBClient(TiDBConfig{DSN: dsn})
+	if err != nil {
+		t.Fatalf("Failed to connect to TiDB: %v", err)
+	}
+	defer client.Close()
+
+	namespace := fmt.Sprintf("test_keyword_%d", time.Now().UnixNano())
+	if err := client.CreateNamespace(namespace, 3); err != nil {
+		t.Fatalf("Failed to create namespace: %v", err)
+	}
+	defer client.DeleteNamespace(namespace)
+
+	meta := FileMetadata{FileDigest: "kw-digest", FileName: "errors.md", S3Key: "test/key", FileSize: 100}
+	if err := client.InsertFileMetadata(namespace, meta); err != nil {
+		t.Fatalf("Failed to insert metadata: %v", err)
+	}
+	chunks := []ChunkData{
+		{ChunkIndex: 0, ChunkText: "Deploy failed with ERR_4012", Embedding: []float32{0.1, 0.2, 0.3}},
+		{ChunkIndex: 1, ChunkText: "deploy to production", Embedding: []float32{0.4, 0.5, 0.6}},
+		{ChunkIndex: 2, ChunkText: "ERR40120 is unrelated", Embedding: []float32{0.7, 0.8, 0.9}},
+	}
+	if err := client.InsertChunksBatch(namespace, "kw-digest", chunks); err != nil {
+		t.Fatalf("Batch insert failed: %v", err)
+	}
+
+	matches, err := client.KeywordSearch(namespace, KeywordQuery{{"err_4012"}, {"deploy", "production"}}, nil, 10)
+	if err != nil {
+		t.Fatalf("KeywordSearch failed: %v", err)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 matches, got %+v", matches)
+	}
+	for _, m := range matches {
+		if m.ChunkIndex == 2 {
+			t.Errorf("underscore matched as a wildcard: %+v", m)
+		}
+	}
+}
+
+// ============================================================================
+// Unit Tests for Embedding Failover and A/B Routing
+// ============================================================================
+
+type fakeEmbedder struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (f *fakeEmbedder) GenerateEmbedding(text string) ([]float32, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []float32{float32(len(f.name))}, nil
+}
+
+func (f *fakeEmbedder) GenerateBatchEmbeddings(texts []string) ([][]float32, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return make([][]float32, len(texts)), nil
+}
+
+func (f *fakeEmbedder) GetDimension() int { return 3 }
+
+func TestEmbeddingRouterFailover(t *testing.T) {
+	primary := &fakeEmbedder{name: "primary", err: &embeddingAPIError{StatusCode: 503}}
+	fallback := &fakeEmbedder{name: "fb"}
+	router, err := NewEmbeddingRouter(primary, fallback, nil, EmbeddingRouterConfig{FailoverCooldown: time.Minute})
+	if err != nil {
+		t.Fatalf("NewEmbeddingRouter failed: %v", err)
+	}
+	now := time.Now()
+	router.now = func() time.Time { return now }
+
+	if _, err := router.GenerateEmbedding("ns", "q"); err != nil {
+		t.Fatalf("expected fallback to succeed: %v", err)
+	}
+	if router.PrimaryHealthy() {
+		t.Error("primary should be marked unhealthy after a 503")
+	}
+
+	// While unhealthy the primary is bypassed
+	router.GenerateBatchEmbeddings("ns", []string{"a", "b"})
+	if primary.calls != 1 || fallback.calls != 2 {
+		t.Errorf("unexpected calls: primary=%d fallback=%d", primary.calls, fallback.calls)
+	}
+
+	// After the cooldown the primary is retried
+	primary.err = nil
+	now = now.Add(2 * time.Minute)
+	router.GenerateEmbedding("ns", "q")
+	if primary.calls != 2 {
+		t.Errorf("primary should be retried after cooldown, calls=%d", primary.calls)
+	}
+}
+
+func TestEmbeddingRouterClientErrorsDoNotFailOver(t *testing.T) {
+	primary := &fakeEmbedder{name: "primary", err: &embeddingAPIError{StatusCode: 400}}
+	fallback := &fakeEmbedder{name: "fb"}
+	router, _ := NewEmbeddingRouter(primary, fallback, nil, EmbeddingRouterConfig{})
+
+	if _, err := router.GenerateEmbedding("ns", "q"); err == nil {
+		t.Fatal("expected 400 to be returned to the caller")
+	}
+	if fallback.calls != 0 || !router.PrimaryHealthy() {
+		t.Error("bad requests must not trigger failover")
+	}
+}
+
+func TestEmbeddingRouterCandidateRoutingIsStable(t *testing.T) {
+	router, _ := NewEmbeddingRouter(&fakeEmbedder{name: "p"}, nil, &fakeEmbedder{name: "c"},
+		EmbeddingRouterConfig{CandidatePercent: 50})
+
+	candidates := 0
+	for i := 0; i < 200; i++ {
+		ns := fmt.Sprintf("ns-%d", i)
+		route := router.Route(ns)
+		if router.Route(ns) != route {
+			t.Fatalf("route for %s is not stable", ns)
+		}
+		if route == routeCandidate {
+			candidates++
+		}
+	}
+	if candidates == 0 || candidates == 200 {
+		t.Errorf("expected a mix of routes at 50%%, got %d/200 candidates", candidates)
+	}
+
+	if _, err := NewEmbeddingRouter(&fakeEmbedder{}, nil, nil, EmbeddingRouterConfig{CandidatePercent: 101}); err == nil {
+		t.Error("expected error for percent > 100")
+	}
+}
+
+// ============================================================================
+// Unit Tests for Token-Accurate Chunking
+// ============================================================================
+
+func TestTiktokenChunkingRespectsTokenLimit(t *testing.T) {
+	tokenizer, err := NewTokenizer(TokenizerTiktoken, "text-embedding-3-small", "")
+	if err != nil {
+		t.Fatalf("NewTokenizer failed: %v", err)
+	}
+
+	if n := tokenizer.Count("hello world"); n != 2 {
+		t.Errorf("expected 2 cl100k tokens for 'hello world', got %d", n)
+	}
+
+	cfg := ChunkerConfig{ChunkSize: 50, ChunkOverlap: 10, Tokenizer: tokenizer}
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 40) +
+		strings.Repeat("unbrokenrunonsentencewithoutanypunctuation", 30)
+
+	chunks := ChunkDocument(text, cfg)
+	if len(chunks) < 2 {
+		t.Fatalf("expected multiple chunks, got %d", len(chunks))
+	}
+	for _, chunk := range chunks {
+		if n := tokenizer.Count(chunk.Text); n > cfg.ChunkSize {
+			t.Errorf("chunk %d has %d tokens, limit is %d", chunk.Index, n, cfg.ChunkSize)
+		}
+	}
+
+	// Consecutive chunks of a long paragraph share overlapping text
+	tail := tokenizer.Tail(chunks[0].Text, cfg.ChunkOverlap)
+	if !strings.HasPrefix(chunks[1].Text, tail) {
+		t.Errorf("expected chunk 1 to start with the tail of chunk 0 (%q)", tail)
+	}
+}
+
+func TestApproxTokenizerSplitIsRuneSafe(t *testing.T) {
+	text := strings.Repeat("数据库", 20)
+	for _, piece := range (approxTokenizer{}).Split(text, 3) {
+		if !utf8.ValidString(piece) {
+			t.Fatalf("split produced invalid UTF-8: %q", piece)
+		}
+	}
+
+	if _, err := NewTokenizer("bogus", "", ""); err == nil {
+		t.Error("expected error for unknown tokenizer")
+	}
+}
+
+// ============================================================================
+// Local Mode (SQLite + local documents)
+// ============================================================================
+
+// newLocalTestPlugin starts a plugin with no external services: documents
+// and index under a temp dir, and a fake Ollama that embeds texts by counting
+// "cat" and "dog"
+func newLocalTestPlugin(t *testing.T) *VectorFSPlugin {
+	t.Helper()
+	return startLocalTestPlugin(t, localTestConfig(t))
+}
+
+// localTestConfig returns the configuration of newLocalTestPlugin, for
+// tests that restart a plugin on the same data
+func localTestConfig(t *testing.T) map[string]interface{} {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaEmbedRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := ollamaEmbedResponse{}
+		for _, text := range req.Input {
+			resp.Embeddings = append(resp.Embeddings, []float32{
+				float32(strings.Count(text, "cat")), float32(strings.Count(text, "dog")), 0.1,
+			})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := map[string]interface{}{
+		"storage_backend":    "local",
+		"vector_backend":     "sqlite",
+		"local_dir":          t.TempDir(),
+		"embedding_provider": "ollama",
+		"embedding_endpoint": server.URL,
+		"index_workers":      1,
+	}
+	return cfg
+}
+
+func startLocalTestPlugin(t *testing.T, cfg map[string]interface{}) *VectorFSPlugin {
+	t.Helper()
+	plugin := NewVectorFSPlugin()
+	if err := plugin.Validate(cfg); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if err := plugin.Initialize(cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	t.Cleanup(func() { plugin.Shutdown() })
+	return plugin
+}
+
+func TestLocalModeChunksMarkdownByHeading(t *testing.T) {
+	cfg := localTestConfig(t)
+	cfg["chunk_strategy"] = ChunkStrategyAuto
+	plugin := startLocalTestPlugin(t, cfg)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	doc := "# Pets\n\n## Cats\n\nA **cat** naps.\n\n## Dogs\n\nA dog runs.\n"
+	if _, err := vfs.Write("/pets/docs/pets.md", []byte(doc), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	waitIndexed(t, plugin, "pets")
+
+	results, err := vfs.VectorSearch("pets", "cat", 1)
+	if err != nil || len(results) != 1 || results[0].Content != "Pets > Cats\n\nA cat naps." {
+		t.Errorf("VectorSearch(cat) = %+v, %v; want the Cats section", results, err)
+	}
+}
+
+func TestLocalModeSearchDiversity(t *testing.T) {
+	cfg := localTestConfig(t)
+	cfg["chunk_size"] = 8
+	cfg["chunk_overlap"] = 0
+	plugin := startLocalTestPlugin(t, cfg)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	docs := map[string]string{
+		"dogs.txt":  "a dog barks at the mail carrier\n\nA dog barks at the mail carrier!\n\nA DOG barks at the mail carrier",
+		"mixed.txt": "a dog chases the cat",
+	}
+	for name, content := range docs {
+		if _, err := vfs.Write("/pets/docs/"+name, []byte(content), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+	}
+	waitIndexed(t, plugin, "pets")
+
+	// The three barking chunks collapse into one by default
+	results, err := vfs.VectorSearch("pets", "dog", 2)
+	if err != nil {
+		t.Fatalf("VectorSearch() error = %v", err)
+	}
+	if len(results) != 2 || !strings.HasSuffix(results[0].File, "dogs.txt") || !strings.HasSuffix(results[1].File, "mixed.txt") {
+		t.Fatalf("results = %+v, want dogs.txt then mixed.txt", results)
+	}
+	if results[0].Metadata["duplicates"] != 2 {
+		t.Errorf("duplicates = %v, want 2", results[0].Metadata["duplicates"])
+	}
+
+	results, err = vfs.VectorSearch("pets", "dog -- dedup:off", 2)
+	if err != nil {
+		t.Fatalf("VectorSearch(dedup:off) error = %v", err)
+	}
+	if len(results) != 2 || !strings.HasSuffix(results[1].File, "dogs.txt") {
+		t.Errorf("dedup:off results = %+v, want two dogs.txt chunks", results)
+	}
+
+	// MMR alone also skips the copies
+	results, err = vfs.VectorSearch("pets", "dog -- dedup:off mmr:0.5", 2)
+	if err != nil {
+		t.Fatalf("VectorSearch(mmr:0.5) error = %v", err)
+	}
+	if len(results) != 2 || !strings.HasSuffix(results[1].File, "mixed.txt") {
+		t.Errorf("mmr:0.5 results = %+v, want mixed.txt second", results)
+	}
+}
+
+// scoringHookFunc adapts a function to a ScoringHook
+type scoringHookFunc func(namespace, query string, results []mountablefs.CustomGrepResult) ([]mountablefs.CustomGrepResult, error)
+
+func (f scoringHookFunc) Score(namespace, query string, results []mountablefs.CustomGrepResult) ([]mountablefs.CustomGrepResult, error) {
+	return f(namespace, query, results)
+}
+
+func TestParseScoringHookConfig(t *testing.T) {
+	RegisterScoringHook("test-noop", scoringHookFunc(func(_, _ string, results []mountablefs.CustomGrepResult) ([]mountablefs.CustomGrepResult, error) {
+		return results, nil
+	}))
+	hook, timeout, err := parseScoringHookConfig(map[string]interface{}{})
+	if err != nil || hook != "" || timeout != defaultScoringHookTimeout {
+		t.Errorf("parseScoringHookConfig(defaults) = %q, %v, %v", hook, timeout, err)
+	}
+	for _, name := range []string{"none", "https://hooks.example.com/score", "test-noop"} {
+		if hook, _, err := parseScoringHookConfig(map[string]interface{}{"scoring_hook": name}); err != nil || hook != name {
+			t.Errorf("parseScoringHookConfig(%q) = %q, %v", name, hook, err)
+		}
+	}
+	for _, cfg := range []map[string]interface{}{
+		{"scoring_hook": "no-such-hook"}, {"scoring_hook_timeout": "soon"}, {"scoring_hook_timeout": "0s"},
+	} {
+		if _, _, err := parseScoringHookConfig(cfg); err == nil {
+			t.Errorf("parseScoringHookConfig(%v) succeeded", cfg)
+		}
+	}
+}
+
+func TestLocalModeScoringHook(t *testing.T) {
+	var failing atomic.Bool
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var req scoringWebhookRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Namespace != "pets" || req.Query != "dog" {
+			t.Errorf("webhook request = %+v", req)
+		}
+		// Boost the cats, keep the rest as they are
+		var resp scoringWebhookResponse
+		for _, result := range req.Results {
+			scored := struct {
+				ID    int      `json:"id"`
+				Score *float64 `json:"score"`
+			}{ID: result.ID}
+			if strings.HasSuffix(result.File, "cats.txt") {
+				boosted := 2.0
+				scored.Score = &boosted
+			}
+			resp.Results = append(resp.Results, scored)
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	RegisterScoringHook("test-drop-cats", scoringHookFunc(func(_, _ string, results []mountablefs.CustomGrepResult) ([]mountablefs.CustomGrepResult, error) {
+		var kept []mountablefs.CustomGrepResult
+		for _, r := range results {
+			if !strings.HasSuffix(r.File, "cats.txt") {
+				kept = append(kept, r)
+			}
+		}
+		return kept, nil
+	}))
+
+	cfg := localTestConfig(t)
+	cfg["scoring_hook"] = server.URL
+	plugin := startLocalTestPlugin(t, cfg)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	for name, content := range map[string]string{
+		"cats.txt": "the cat sat on the cat mat",
+		"dogs.txt": "a dog chased another dog",
+	} {
+		if _, err := vfs.Write("/pets/docs/"+name, []byte(content), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+	}
+	waitIndexed(t, plugin, "pets")
+
+	files := func(results []mountablefs.CustomGrepResult) []string {
+		var names []string
+		for _, r := range results {
+			names = append(names, path.Base(r.File))
+		}
+		return names
+	}
+	search := func(want ...string) {
+		t.Helper()
+		results, err := vfs.VectorSearch("pets", "dog", 2)
+		if err != nil {
+			t.Fatalf("VectorSearch() error = %v", err)
+		}
+		if fmt.Sprint(files(results)) != fmt.Sprint(want) {
+			t.Errorf("results = %v, want %v", files(results), want)
+		}
+	}
+
+	search("cats.txt", "dogs.txt")
+	if requests.Load() != 1 {
+		t.Errorf("webhook called %d times, want 1", requests.Load())
+	}
+
+	// A failing hook keeps the search results
+	failing.Store(true)
+	search("dogs.txt", "cats.txt")
+
+	// The namespace picks its own hook, or none
+	setHook := func(hook string) {
+		t.Helper()
+		if _, err := vfs.Write("/pets/.config", []byte(fmt.Sprintf("scoring_hook = %q\n", hook)), 0, filesystem.WriteFlagNone); err != nil {
+			t.Fatalf("Write(.config) error = %v", err)
+		}
+	}
+	setHook("test-drop-cats")
+	search("dogs.txt")
+	setHook(scoringHookNone)
+	search("dogs.txt", "cats.txt")
+	if _, err := vfs.Write("/pets/.config", []byte(`scoring_hook = "no-such-hook"`), 0, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("Write(.config) with an unknown hook error = %v, want ErrInvalidArgument", err)
+	}
+}
+
+func TestLocalModeSearchDirectory(t *testing.T) {
+	plugin := newLocalTestPlugin(t)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	for name, content := range map[string]string{"cats.txt": "the cat sat", "more/dogs.txt": "a dog ran"} {
+		if _, err := vfs.Write("/pets/docs/"+name, []byte(content), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+	}
+	waitIndexed(t, plugin, "pets")
+
+	info, err := vfs.Stat("/pets/search/a%20dog")
+	if err != nil || !info.IsDir {
+		t.Fatalf("Stat(search dir) = %+v, %v; want a directory", info, err)
+	}
+	entries, err := vfs.ReadDir("/pets/search/a%20dog%20--%20topk%3A1")
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "01-more_dogs.txt-1.txt" {
+		t.Fatalf("ReadDir() = %+v, want the dogs.txt chunk", entries)
+	}
+	data, err := vfs.Read("/pets/search/a%20dog%20--%20topk%3A1/"+entries[0].Name, 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !strings.HasPrefix(string(data), "file: pets/docs/more/dogs.txt\nchunk: 1\nscore: ") || !strings.HasSuffix(string(data), "\n\na dog ran\n") {
+		t.Errorf("Read() = %q", data)
+	}
+	if int64(len(data)) != entries[0].Size {
+		t.Errorf("Read() returned %d bytes, listing says %d", len(data), entries[0].Size)
+	}
+
+	if _, err := vfs.Read("/pets/search/a%20dog/99-missing.txt-1.txt", 0, -1); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Read(missing result) error = %v, want not found", err)
+	}
+	if _, err := vfs.ReadDir("/pets/search/bad%zzquery"); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("ReadDir(bad encoding) error = %v, want invalid argument", err)
+	}
+	if _, err := vfs.Write("/pets/search/a%20dog/x.txt", []byte("x"), 0, filesystem.WriteFlagCreate); err == nil {
+		t.Error("Write() below search/ succeeded")
+	}
+}
+
+func TestLocalModeExportImport(t *testing.T) {
+	plugin := newLocalTestPlugin(t)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	docs := map[string]string{
+		"cats.txt":           "the cat sat",
+		"more/dogs.txt":      "a dog ran",
+		"cats.txt.meta.json": `{"author":"alice"}`,
+	}
+	for name, content := range docs {
+		if _, err := vfs.Write("/pets/docs/"+name, []byte(content), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+	}
+	if err := vfs.Mkdir("/pets/docs/empty", 0755); err != nil {
+		t.Fatalf("Mkdir(docs/empty) error = %v", err)
+	}
+	waitIndexed(t, plugin, "pets")
+
+	archive, err := vfs.Read("/pets/.export", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read(.export) error = %v", err)
+	}
+	if again, _ := vfs.Read("/pets/.export", 0, -1); !bytes.Equal(again, archive) {
+		t.Error("exporting twice gave different archives")
+	}
+	if _, err := vfs.Write("/copy/.import", archive, 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write(.import) error = %v", err)
+	}
+
+	// Embeddings come with the archive, so nothing is re-indexed
+	if n := plugin.pendingIndexing("copy"); n != 0 {
+		t.Errorf("%d documents queued for indexing after import", n)
+	}
+	for name, content := range docs {
+		data, err := vfs.Read("/copy/docs/"+name, 0, -1)
+		if (err != nil && err != io.EOF) || string(data) != content {
+			t.Errorf("Read(copy/docs/%s) = %q, %v; want %q", name, data, err, content)
+		}
+	}
+	if info, err := vfs.Stat("/copy/docs/empty"); err != nil || !info.IsDir {
+		t.Errorf("Stat(copy/docs/empty) = %+v, %v; want a directory", info, err)
+	}
+	results, err := vfs.VectorSearch("copy", "dog -- filter:author=alice", 5)
+	if err != nil || len(results) != 1 || results[0].File != "copy/docs/cats.txt" {
+		t.Errorf("VectorSearch(copy) = %+v, %v; want cats.txt with its metadata", results, err)
+	}
+	if results, err = vfs.VectorSearch("copy", "dog", 1); err != nil || len(results) != 1 || results[0].File != "copy/docs/more/dogs.txt" {
+		t.Errorf("VectorSearch(copy, dog) = %+v, %v; want dogs.txt", results, err)
+	}
+
+	if _, err := vfs.Write("/copy/.import", []byte("not an archive"), 0, filesystem.WriteFlagCreate); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("Write(.import, garbage) error = %v, want invalid argument", err)
+	}
+	if _, err := vfs.Read("/missing/.export", 0, -1); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Read(missing/.export) error = %v, want not found", err)
+	}
+}
+
+func TestLocalModeReranks(t *testing.T) {
+	// The reranker prefers documents about dogs, unlike the embeddings
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		var req cohereRerankRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		var resp cohereRerankResponse
+		for i, doc := range req.Documents {
+			resp.Results = append(resp.Results, struct {
+				Index          int     `json:"index"`
+				RelevanceScore float64 `json:"relevance_score"`
+			}{i, float64(strings.Count(doc, "dog"))})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	cfg := localTestConfig(t)
+	cfg["rerank_provider"] = RerankProviderLocal
+	cfg["rerank_endpoint"] = server.URL
+	plugin := startLocalTestPlugin(t, cfg)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	docs := map[string]string{
+		"cats.txt": "the cat sat on the cat mat",
+		"both.txt": "a cat met a dog and another dog",
+	}
+	for name, content := range docs {
+		if _, err := vfs.Write("/pets/docs/"+name, []byte(content), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+	}
+	waitIndexed(t, plugin, "pets")
+
+	results, err := vfs.VectorSearch("pets", "cat", 1)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("VectorSearch() = %+v, %v; want 1 result", results, err)
+	}
+	if results[0].File != "pets/docs/both.txt" || results[0].Metadata["first_stage_rank"] != 2 || results[0].Metadata["score"] != 2.0 {
+		t.Errorf("reranked result = %+v, want both.txt from first-stage rank 2", results[0])
+	}
+
+	// A failing reranker leaves the vector ranking
+	failing = true
+	results, err = vfs.VectorSearch("pets", "cat", 1)
+	if err != nil || len(results) != 1 || results[0].File != "pets/docs/cats.txt" {
+		t.Errorf("VectorSearch() with failing reranker = %+v, %v; want cats.txt", results, err)
+	}
+}
+
+func TestRerankConfigValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  map[string]interface{}
+		ok   bool
+	}{
+		{"disabled", map[string]interface{}{}, true},
+		{"local without endpoint", map[string]interface{}{"rerank_provider": "local"}, false},
+		{"cohere without key", map[string]interface{}{"rerank_provider": "cohere"}, false},
+		{"openai reuses embedding key", map[string]interface{}{"rerank_provider": "openai", "openai_api_key": "k"}, true},
+		{"unknown provider", map[string]interface{}{"rerank_provider": "magic", "rerank_endpoint": "http://x"}, false},
+		{"bad top n", map[string]interface{}{"rerank_provider": "local", "rerank_endpoint": "http://x", "rerank_top_n": 0}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseRerankConfig(tt.cfg); (err == nil) != tt.ok {
+				t.Errorf("parseRerankConfig() error = %v, want ok = %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLocalModeEndToEnd(t *testing.T) {
+	plugin := newLocalTestPlugin(t)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	docs := map[string]string{
+		"cats.txt": "the cat sat on the cat mat",
+		"dogs.txt": "a dog chased another dog",
+	}
+	for name, content := range docs {
+		if _, err := vfs.Write("/pets/docs/"+name, []byte(content), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+	}
+	waitIndexed(t, plugin, "pets")
+
+	data, err := vfs.Read("/pets/.indexing.d/cats.txt", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read(.indexing.d) error = %v", err)
+	}
+	if !strings.Contains(string(data), "state: stored") {
+		t.Errorf("Read(.indexing.d) = %q, want stored", data)
+	}
+
+	data, err = vfs.Read("/pets/docs/cats.txt", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(data) != docs["cats.txt"] {
+		t.Errorf("Read() = %q", data)
+	}
+
+	results, err := vfs.VectorSearch("pets", "dog", 2)
+	if err != nil {
+		t.Fatalf("VectorSearch() error = %v", err)
+	}
+	if len(results) != 2 || !strings.HasSuffix(results[0].File, "dogs.txt") {
+		t.Errorf("results = %+v, want dogs.txt first", results)
+	}
+
+	// topk and minscore modifiers override the requested count
+	if results, err = vfs.VectorSearch("pets", "dog -- topk:1", 5); err != nil || len(results) != 1 {
+		t.Errorf("topk:1 results = %+v, %v", results, err)
+	}
+	if results, err = vfs.VectorSearch("pets", "dog -- minscore:0.9", 5); err != nil || len(results) != 1 || !strings.HasSuffix(results[0].File, "dogs.txt") {
+		t.Errorf("minscore:0.9 results = %+v, %v, want only dogs.txt", results, err)
+	}
+	if results, err = vfs.VectorSearch("pets", "dog", 0); err != nil || len(results) != 2 {
+		t.Errorf("default limit results = %+v, %v", results, err)
+	}
+
+	results, err = vfs.VectorSearch("pets", `kw: mat`, 5)
+	if err != nil {
+		t.Fatalf("hybrid VectorSearch() error = %v", err)
+	}
+	if len(results) == 0 || !strings.HasSuffix(results[0].File, "cats.txt") {
+		t.Errorf("hybrid results = %+v, want cats.txt", results)
+	}
+
+	// A sidecar written after indexing tags the existing chunks
+	if _, err := vfs.Write("/pets/docs/cats.txt.meta.json", []byte(`{"author":"alice","tag":["pets","api"]}`), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write(sidecar) error = %v", err)
+	}
+	if _, err := vfs.Write("/pets/docs/dogs.txt.meta.json", []byte(`{"author":`), 0, filesystem.WriteFlagCreate); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("Write(bad sidecar) error = %v, want invalid argument", err)
+	}
+	for _, query := range []string{"dog -- filter:author=alice", "kw:a -- filter:author=alice,tag=api"} {
+		results, err = vfs.VectorSearch("pets", query, 5)
+		if err != nil {
+			t.Fatalf("VectorSearch(%q) error = %v", query, err)
+		}
+		if len(results) != 1 || !strings.HasSuffix(results[0].File, "cats.txt") {
+			t.Fatalf("VectorSearch(%q) = %+v, want only cats.txt", query, results)
+		}
+		if meta, _ := results[0].Metadata["meta"].(map[string]interface{}); meta["author"] != "alice" {
+			t.Errorf("VectorSearch(%q) metadata = %v", query, results[0].Metadata)
+		}
+	}
+	if results, err = vfs.VectorSearch("pets", "dog -- filter:author=bob", 5); err != nil || len(results) != 0 {
+		t.Errorf("filter with no match = %+v, %v", results, err)
+	}
+
+	if err := vfs.RemoveAll("/pets"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	namespaces, err := plugin.store.ListNamespaces()
+	if err != nil || len(namespaces) != 0 {
+		t.Errorf("ListNamespaces() = %v, %v after removal", namespaces, err)
+	}
+}
+
+func waitIndexed(t *testing.T, plugin *VectorFSPlugin, namespace string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for plugin.pendingIndexing(namespace) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("indexing did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLocalModeRemoveAndReindex(t *testing.T) {
+	plugin := newLocalTestPlugin(t)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	for name, content := range map[string]string{
+		"cats.txt":           "the cat sat on the cat mat",
+		"cats.txt.meta.json": `{"author":"alice"}`,
+		"old/dogs.txt":       "a dog chased another dog",
+	} {
+		if _, err := vfs.Write("/pets/docs/"+name, []byte(content), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+	}
+	waitIndexed(t, plugin, "pets")
+
+	// Re-indexing replaces the chunks instead of adding to them
+	if _, err := vfs.Write("/pets/.reindex", []byte("*\n"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(.reindex) error = %v", err)
+	}
+	waitIndexed(t, plugin, "pets")
+	results, err := vfs.VectorSearch("pets", "cat -- filter:author=alice", 10)
+	if err != nil || len(results) != 1 || !strings.HasSuffix(results[0].File, "cats.txt") {
+		t.Errorf("results after reindex = %+v, %v, want one cats.txt chunk", results, err)
+	}
+	if _, err := vfs.Write("/pets/.reindex", []byte("docs/missing.txt"), 0, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Write(.reindex missing) error = %v, want not found", err)
+	}
+
+	// Removing the sidecar clears the metadata; removing the document drops it
+	if err := vfs.Remove("/pets/docs/cats.txt.meta.json"); err != nil {
+		t.Fatalf("Remove(sidecar) error = %v", err)
+	}
+	if results, err = vfs.VectorSearch("pets", "cat -- filter:author=alice", 10); err != nil || len(results) != 0 {
+		t.Errorf("filter after sidecar removal = %+v, %v", results, err)
+	}
+	if err := vfs.Remove("/pets/docs/cats.txt"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := vfs.Stat("/pets/docs/cats.txt"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Stat() after Remove error = %v, want not found", err)
+	}
+	if err := vfs.Remove("/pets/docs/cats.txt"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("second Remove() error = %v, want not found", err)
+	}
+
+	if err := vfs.Remove("/pets/docs/old"); err == nil {
+		t.Error("Remove() of a non-empty directory succeeded")
+	}
+	if err := vfs.RemoveAll("/pets/docs/old"); err != nil {
+		t.Fatalf("RemoveAll(dir) error = %v", err)
+	}
+	if results, err = vfs.VectorSearch("pets", "dog", 10); err != nil || len(results) != 0 {
+		t.Errorf("results after removing everything = %+v, %v", results, err)
+	}
+	if files, err := plugin.store.ListFiles("pets"); err != nil || len(files) != 0 {
+		t.Errorf("ListFiles() = %+v, %v", files, err)
+	}
+}
+
+func TestEncodeEmbeddingRoundTrip(t *testing.T) {
+	in := []float32{0, -1.5, 3.25}
+	out, err := decodeEmbedding(encodeEmbedding(in))
+	if err != nil || len(out) != 3 || out[1] != -1.5 || out[2] != 3.25 {
+		t.Errorf("round trip = %v, %v", out, err)
+	}
+	if _, err := decodeEmbedding([]byte{1, 2, 3}); err == nil {
+		t.Error("expected error for truncated embedding")
+	}
+	if d := cosineDistance([]float32{1, 0}, []float32{1, 0}); d > 1e-9 {
+		t.Errorf("cosineDistance(identical) = %v", d)
+	}
+}
+
+func TestSQLiteMigratesChunkMetadata(t *testing.T) {
+	path := t.TempDir() + "/vectors.db"
+	client, err := NewSQLiteClient(SQLiteConfig{Path: path})
+	if err != nil {
+		t.Fatalf("NewSQLiteClient() error = %v", err)
+	}
+	if err := client.CreateNamespace("old", 2); err != nil {
+		t.Fatalf("CreateNamespace() error = %v", err)
+	}
+	// Recreate the chunks table as it was before metadata existed
+	_, chunksTable := sqliteTables("old")
+	for _, stmt := range []string{
+		"DROP TABLE " + chunksTable,
+		"CREATE TABLE " + chunksTable + " (chunk_id INTEGER PRIMARY KEY, file_digest TEXT, chunk_index INTEGER, chunk_text TEXT, embedding BLOB)",
+		"DELETE FROM " + migrate.Table,
+	} {
+		if _, err := client.db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	client.Close()
+
+	client, err = NewSQLiteClient(SQLiteConfig{Path: path})
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer client.Close()
+	if err := client.UpdateChunkMetadata("old", "digest", DocMetadata{"a": {"b"}}); err != nil {
+		t.Errorf("UpdateChunkMetadata() after migration error = %v", err)
+	}
+}
+
+func TestLocalModeBinaryDocument(t *testing.T) {
+	plugin := newLocalTestPlugin(t)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	// NUL bytes and invalid UTF-8 are stored unchanged but not indexed
+	blob := []byte{0x00, 'c', 'a', 't', 0xff, 0xfe, 0xc3, 0x28, 0x00}
+	if _, err := vfs.Write("/pets/docs/cat.bin", blob, 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := vfs.Write("/pets/docs/cats.txt", []byte("the cat sat on the cat mat"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	waitIndexed(t, plugin, "pets")
+
+	data, err := vfs.Read("/pets/docs/cat.bin", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(data) != string(blob) {
+		t.Errorf("Read() = %q, want %q", data, blob)
+	}
+	if info, err := vfs.Stat("/pets/docs/cat.bin"); err != nil || info.Size != int64(len(blob)) {
+		t.Errorf("Stat() = %+v, %v", info, err)
+	}
+	if _, err := vfs.Stat("/pets/.indexing.d/cat.bin"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Stat(.indexing.d/cat.bin) error = %v, want not found", err)
+	}
+
+	if _, err := vfs.Write("/pets/.reindex", []byte("*"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(.reindex) error = %v", err)
+	}
+	waitIndexed(t, plugin, "pets")
+	results, err := vfs.VectorSearch("pets", "cat", 10)
+	if err != nil {
+		t.Fatalf("VectorSearch() error = %v", err)
+	}
+	for _, r := range results {
+		if strings.HasSuffix(r.File, "cat.bin") {
+			t.Errorf("binary document in results: %+v", r)
+		}
+	}
+}
+
+func TestLocalModeResumesPendingIndexing(t *testing.T) {
+	cfg := localTestConfig(t)
+	plugin := startLocalTestPlugin(t, cfg)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if _, err := vfs.Write("/pets/docs/cats.txt", []byte("the cat sat on the cat mat"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	waitIndexed(t, plugin, "pets")
+	if pending, err := plugin.store.ListPendingFiles("pets"); err != nil || len(pending) != 0 {
+		t.Fatalf("ListPendingFiles() after indexing = %+v, %v", pending, err)
+	}
+
+	// Crash after the write was stored but before it was indexed
+	meta, err := plugin.store.GetFileMetadataByName("pets", "cats.txt")
+	if err != nil {
+		t.Fatalf("GetFileMetadataByName() error = %v", err)
+	}
+	if err := plugin.store.DeleteFileChunks("pets", meta.FileDigest); err != nil {
+		t.Fatalf("DeleteFileChunks() error = %v", err)
+	}
+	if err := plugin.store.SetIndexPending("pets", meta.FileDigest, true); err != nil {
+		t.Fatalf("SetIndexPending() error = %v", err)
+	}
+	plugin.Shutdown()
+
+	plugin = startLocalTestPlugin(t, cfg)
+	vfs = plugin.GetFileSystem().(*vectorFS)
+	if files := plugin.indexingFiles("pets", "cats.txt"); len(files) != 1 {
+		t.Fatalf("indexingFiles() after restart = %+v, want cats.txt", files)
+	}
+	waitIndexed(t, plugin, "pets")
+	results, err := vfs.VectorSearch("pets", "cat", 5)
+	if err != nil || len(results) != 1 || !strings.HasSuffix(results[0].File, "cats.txt") {
+		t.Errorf("results after restart = %+v, %v, want cats.txt", results, err)
+	}
+	if pending, err := plugin.store.ListPendingFiles("pets"); err != nil || len(pending) != 0 {
+		t.Errorf("ListPendingFiles() after recovery = %+v, %v", pending, err)
+	}
+}
+
+// buildTestDOCX returns a minimal DOCX document with one paragraph per text
+func buildTestDOCX(t *testing.T, paragraphs ...string) []byte {
+	t.Helper()
+	var body strings.Builder
+	for _, p := range paragraphs {
+		body.WriteString(`<w:p><w:r><w:t>` + p + `</w:t></w:r></w:p>`)
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("word/document.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>%s</w:body></w:document>`, body.String())
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// buildTestPDF returns a one-page PDF whose content stream draws lines,
+// compressed with FlateDecode when compress is set
+func buildTestPDF(t *testing.T, compress bool, lines ...string) []byte {
+	t.Helper()
+	var content strings.Builder
+	content.WriteString("BT /F1 12 Tf 72 720 Td\n")
+	for i, line := range lines {
+		if i > 0 {
+			content.WriteString("0 -14 Td\n")
+		}
+		fmt.Fprintf(&content, "(%s) Tj\n", strings.NewReplacer(`(`, `\(`, `)`, `\)`).Replace(line))
+	}
+	content.WriteString("ET\n")
+
+	stream := []byte(content.String())
+	filter := ""
+	if compress {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(stream)
+		zw.Close()
+		stream = buf.Bytes()
+		filter = " /Filter /FlateDecode"
+	}
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n1 0 obj\n<< /Type /Catalog >>\nendobj\n")
+	fmt.Fprintf(&pdf, "4 0 obj\n<< /Length %d%s >>\nstream\n", len(stream), filter)
+	pdf.Write(stream)
+	pdf.WriteString("\nendstream\nendobj\ntrailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+func TestExtractors(t *testing.T) {
+	registry := NewExtractorRegistry()
+	tests := []struct {
+		name    string
+		file    string
+		data    []byte
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "pdf", file: "report.pdf",
+			data:    buildTestPDF(t, true, "Quarterly report", "Revenue (net) grew"),
+			want:    []string{"Quarterly report\nRevenue (net) grew"},
+			notWant: []string{"Tj", "%PDF"},
+		},
+		{
+			name: "uncompressed pdf", file: "plain.pdf",
+			data: buildTestPDF(t, false, "Hello PDF"),
+			want: []string{"Hello PDF"},
+		},
+		{
+			name: "docx", file: "memo.docx",
+			data:    buildTestDOCX(t, "First paragraph", "Second &amp; last"),
+			want:    []string{"First paragraph\n\nSecond & last"},
+			notWant: []string{"<w:"},
+		},
+		{
+			name: "html", file: "page.html",
+			data: []byte(`<html><head><title>Title</title><style>p{color:red}</style><script>var x = "<p>";</script></head>` +
+				`<body><!-- hidden --><h1>Heading</h1><p>Fish &amp; chips<br>are   great</p></body></html>`),
+			want:    []string{"Title\n\nHeading\n\nFish & chips\nare great"},
+			notWant: []string{"color", "var x", "hidden", "<"},
+		},
+		{
+			name: "markdown", file: "README.md",
+			data: []byte("---\ntitle: Doc\n---\n# Install\n\nSee the [guide](https://example.com/guide) and ![logo](logo.png).\n\n" +
+				"**Bold** and `code`.\n\n```go\nfmt.Println()\n```\n"),
+			want:    []string{"Install\n\nSee the guide and logo.", "Bold and code.", "fmt.Println()"},
+			notWant: []string{"title: Doc", "https://", "#", "**", "```"},
+		},
+	}
+	for _, tt := range tests {
+		e := registry.Select(tt.file, tt.data)
+		if e == nil {
+			t.Errorf("%s: no extractor selected", tt.name)
+			continue
+		}
+		text, err := e.Extract(tt.data)
+		if err != nil {
+			t.Errorf("%s: Extract() error = %v", tt.name, err)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(text, want) {
+				t.Errorf("%s: Extract() = %q, want it to contain %q", tt.name, text, want)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(text, notWant) {
+				t.Errorf("%s: Extract() = %q, should not contain %q", tt.name, text, notWant)
+			}
+		}
+	}
+
+	if _, err := (pdfExtractor{}).Extract([]byte("%PDF-1.4\n%%EOF\n")); err == nil {
+		t.Error("Extract() of a PDF without text should fail")
+	}
+}
+
+func TestExtractorSelection(t *testing.T) {
+	registry := NewExtractorRegistry()
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	zw.Create("data.csv")
+	zw.Close()
+
+	tests := []struct {
+		file string
+		data []byte
+		want string
+	}{
+		{"REPORT.PDF", []byte("anything"), "pdf"},
+		{"upload.bin", buildTestPDF(t, true, "x"), "pdf"},
+		{"upload", buildTestDOCX(t, "x"), "docx"},
+		{"index", []byte("<!DOCTYPE html><html><body>x</body></html>"), "html"},
+		{"notes.markdown", []byte("# x"), "markdown"},
+		{"archive.zip", zipBuf.Bytes(), ""},
+		{"notes.txt", []byte("plain text"), ""},
+	}
+	for _, tt := range tests {
+		name := ""
+		if e := registry.Select(tt.file, tt.data); e != nil {
+			name = e.Name()
+		}
+		if name != tt.want {
+			t.Errorf("Select(%q) = %q, want %q", tt.file, name, tt.want)
+		}
+	}
+
+	// A configured command takes over the extension of a built-in
+	if _, err := exec.LookPath("tr"); err != nil {
+		t.Skip("tr not available")
+	}
+	registry, err := newExtractorRegistryFromConfig(map[string]interface{}{
+		"extractor_commands": map[string]interface{}{"md": "tr a-z A-Z"},
+	})
+	if err != nil {
+		t.Fatalf("newExtractorRegistryFromConfig() error = %v", err)
+	}
+	text, err := registry.Select("x.md", nil).Extract([]byte("# shout"))
+	if err != nil || text != "# SHOUT" {
+		t.Errorf("command Extract() = %q, %v; want %q", text, err, "# SHOUT")
+	}
+	if _, err := newExtractorRegistryFromConfig(map[string]interface{}{"extractor_commands": "pdftotext"}); err == nil {
+		t.Error("extractor_commands that is not a map should be rejected")
+	}
+}
+
+func TestLocalModeExtractsDocuments(t *testing.T) {
+	plugin := newLocalTestPlugin(t)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	docs := map[string][]byte{
+		"cats.pdf":   buildTestPDF(t, true, "the cat sat on the cat mat"),
+		"dogs.docx":  buildTestDOCX(t, "a dog chased another dog"),
+		"broken.pdf": []byte("%PDF-1.4\n%%EOF\n"),
+	}
+	for name, data := range docs {
+		if _, err := vfs.Write("/pets/docs/"+name, data, 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+	}
+	waitIndexed(t, plugin, "pets")
+
+	results, err := vfs.VectorSearch("pets", "cat", 10)
+	if err != nil {
+		t.Fatalf("VectorSearch() error = %v", err)
+	}
+	if len(results) == 0 || !strings.HasSuffix(results[0].File, "cats.pdf") || results[0].Content != "the cat sat on the cat mat" {
+		t.Errorf("VectorSearch(cat) = %+v, want the text of cats.pdf first", results)
+	}
+	results, err = vfs.VectorSearch("pets", "dog", 1)
+	if err != nil || len(results) != 1 || !strings.HasSuffix(results[0].File, "dogs.docx") {
+		t.Errorf("VectorSearch(dog) = %+v, %v; want dogs.docx", results, err)
+	}
+
+	// The original bytes are still what is read back
+	data, err := vfs.Read("/pets/docs/cats.pdf", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !bytes.Equal(data, docs["cats.pdf"]) {
+		t.Error("Read() of cats.pdf differs from what was written")
+	}
+
+	// A document that can't be extracted fails without staying pending
+	status, err := vfs.Read("/pets/.indexing.d/broken.pdf", 0, -1)
+	if (err != nil && err != io.EOF) || !strings.Contains(string(status), "state: failed") ||
+		!strings.Contains(string(status), "pdf extractor") {
+		t.Errorf("status of broken.pdf = %q, %v", status, err)
+	}
+	if pending, err := plugin.store.ListPendingFiles("pets"); err != nil || len(pending) != 0 {
+		t.Errorf("ListPendingFiles() = %+v, %v; want none", pending, err)
+	}
+}
+
+func TestLocalModeIndexesImages(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 32)...)
+	var mu sync.Mutex
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req visionChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		defer mu.Unlock()
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		parts := req.Messages[0].Content
+		if len(parts) != 2 || parts[0].Text != defaultImagePrompt ||
+			parts[1].ImageURL.URL != "data:image/png;base64,"+base64.StdEncoding.EncodeToString(png) {
+			t.Errorf("vision request = %+v", req)
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"ERROR: cat not found\nA terminal showing a failed command."}}]}`)
+	}))
+	defer server.Close()
+
+	cfg := localTestConfig(t)
+	cfg["image_provider"] = ImageProviderOpenAI
+	cfg["image_endpoint"] = server.URL
+	plugin := startLocalTestPlugin(t, cfg)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	if err := vfs.Mkdir("/shots", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if _, err := vfs.Write("/shots/docs/screen.png", png, 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	waitIndexed(t, plugin, "shots")
+
+	results, err := vfs.VectorSearch("shots", "cat", 1)
+	if err != nil || len(results) != 1 || !strings.HasSuffix(results[0].File, "screen.png") ||
+		!strings.Contains(results[0].Content, "ERROR: cat not found") {
+		t.Errorf("VectorSearch(cat) = %+v, %v; want the text of screen.png", results, err)
+	}
+	if data, err := vfs.Read("/shots/docs/screen.png", 0, -1); (err != nil && err != io.EOF) || !bytes.Equal(data, png) {
+		t.Error("Read() of screen.png differs from what was written")
+	}
+
+	// Images sent while the provider is down stay pending for a retry
+	mu.Lock()
+	status = http.StatusServiceUnavailable
+	mu.Unlock()
+	if _, err := vfs.Write("/shots/docs/other.png", append(png, 1), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	waitIndexed(t, plugin, "shots")
+	if pending, err := plugin.store.ListPendingFiles("shots"); err != nil || len(pending) != 1 || pending[0].FileName != "other.png" {
+		t.Errorf("ListPendingFiles() = %+v, %v; want other.png", pending, err)
+	}
+
+	cfg["image_provider"] = "paint"
+	if err := NewVectorFSPlugin().Validate(cfg); err == nil {
+		t.Error("Validate() accepted an unknown image_provider")
+	}
+}
+
+func TestLocalModeEmbeddingCache(t *testing.T) {
+	var mu sync.Mutex
+	embedded := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaEmbedRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := ollamaEmbedResponse{}
+		for _, text := range req.Input {
+			resp.Embeddings = append(resp.Embeddings, []float32{
+				float32(strings.Count(text, "cat")), float32(strings.Count(text, "dog")), 0.1,
+			})
+		}
+		mu.Lock()
+		embedded += len(req.Input)
+		mu.Unlock()
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	embeddedSince := func() func() int {
+		mu.Lock()
+		start := embedded
+		mu.Unlock()
+		return func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return embedded - start
+		}
+	}
+
+	cfg := localTestConfig(t)
+	cfg["embedding_endpoint"] = server.URL
+	cfg["chunk_size"] = 8
+	cfg["chunk_overlap"] = 0
+	plugin := startLocalTestPlugin(t, cfg)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+
+	paragraphs := []string{
+		"the cat sleeps in the warm sun",
+		"a dog digs a hole in the garden",
+		"the cat watches birds from the window",
+		"a dog barks at the mail carrier",
+	}
+	write := func(name string, paragraphs []string) {
+		t.Helper()
+		doc := strings.Join(paragraphs, "\n\n")
+		if _, err := vfs.Write("/pets/docs/"+name, []byte(doc), 0, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+		waitIndexed(t, plugin, "pets")
+	}
+
+	count := embeddedSince()
+	write("pets.txt", paragraphs)
+	meta, err := plugin.store.GetFileMetadataByName("pets", "pets.txt")
+	if err != nil {
+		t.Fatalf("GetFileMetadataByName() error = %v", err)
+	}
+	chunks, err := plugin.store.ListChunks("pets", meta.FileDigest)
+	if err != nil || len(chunks) < 3 {
+		t.Fatalf("ListChunks() = %d chunks, %v; want several", len(chunks), err)
+	}
+	if n := count(); n != len(chunks) {
+		t.Errorf("first write embedded %d texts, want %d", n, len(chunks))
+	}
+
+	// Rewriting with one paragraph changed only embeds its chunk
+	count = embeddedSince()
+	changed := append([]string(nil), paragraphs...)
+	changed[1] = "a dog chases a dog around the yard"
+	write("pets.txt", changed)
+	if n := count(); n != 1 {
+		t.Errorf("rewrite embedded %d texts, want 1", n)
+	}
+	results, err := vfs.VectorSearch("pets", "dog dog", 1)
+	if err != nil || len(results) != 1 || !strings.Contains(results[0].Content, "chases") {
+		t.Errorf("VectorSearch(dog dog) = %+v, %v; want the changed paragraph", results, err)
+	}
+
+	// The cache is kept in the vector store, across restarts
+	plugin.Shutdown()
+	plugin = startLocalTestPlugin(t, cfg)
+	vfs = plugin.GetFileSystem().(*vectorFS)
+	count = embeddedSince()
+	if _, err := vfs.Write("/pets/.reindex", []byte("*\n"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(.reindex) error = %v", err)
+	}
+	waitIndexed(t, plugin, "pets")
+	if n := count(); n != 0 {
+		t.Errorf("reindex after restart embedded %d texts, want 0", n)
+	}
+	if status := plugin.getIndexingStatus("pets"); !strings.Contains(status, "embedding cache: ") {
+		t.Errorf("indexing status lacks the embedding cache:\n%s", status)
+	}
+
+	// Without the cache every chunk of a new document is embedded, while a
+	// rewrite still reuses the embeddings of its previous version
+	plugin.Shutdown()
+	cfg["embedding_cache"] = false
+	plugin = startLocalTestPlugin(t, cfg)
+	vfs = plugin.GetFileSystem().(*vectorFS)
+	count = embeddedSince()
+	write("cats.txt", []string{paragraphs[0], paragraphs[2]})
+	meta, err = plugin.store.GetFileMetadataByName("pets", "cats.txt")
+	if err != nil {
+		t.Fatalf("GetFileMetadataByName() error = %v", err)
+	}
+	if cats, err := plugin.store.ListChunks("pets", meta.FileDigest); err != nil || count() != len(cats) {
+		t.Errorf("write without cache embedded %d texts, want %d (%v)", count(), len(cats), err)
+	}
+	count = embeddedSince()
+	write("pets.txt", paragraphs)
+	if n := count(); n != 1 {
+		t.Errorf("rewrite without cache embedded %d texts, want 1", n)
+	}
+	stats, err := vfs.Read("/pets/.stats", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read(.stats) error = %v", err)
+	}
+	if want := fmt.Sprintf("reused_chunks: %d\n", len(chunks)-1); !strings.Contains(string(stats), want) {
+		t.Errorf(".stats lacks %q:\n%s", want, stats)
+	}
+	meta, err = plugin.store.GetFileMetadataByName("pets", "pets.txt")
+	if err != nil {
+		t.Fatalf("GetFileMetadataByName() error = %v", err)
+	}
+	if rewritten, err := plugin.store.ListChunks("pets", meta.FileDigest); err != nil || len(rewritten) != len(chunks) {
+		t.Errorf("rewritten document has %d chunks, %v; want %d", len(rewritten), err, len(chunks))
+	}
+}
+
+func TestLocalModeNamespaceQuota(t *testing.T) {
+	cfg := localTestConfig(t)
+	cfg["chunk_size"] = 8
+	cfg["chunk_overlap"] = 0
+	cfg["max_documents"] = 2
+	plugin := startLocalTestPlugin(t, cfg)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	write := func(name, content string) error {
+		_, err := vfs.Write("/pets/docs/"+name, []byte(content), 0, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate)
+		waitIndexed(t, plugin, "pets")
+		return err
+	}
+	usage := func() NamespaceUsage {
+		t.Helper()
+		u, err := plugin.store.NamespaceUsage("pets")
+		if err != nil {
+			t.Fatalf("NamespaceUsage() error = %v", err)
+		}
+		return u
+	}
+
+	// The document limit refuses new documents, not rewrites
+	for name, content := range map[string]string{"cats.txt": "the cat sat", "dogs.txt": "a dog sat"} {
+		if err := write(name, content); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+	}
+	if err := write("birds.txt", "a bird sang"); !errors.Is(err, filesystem.ErrQuotaExceeded) {
+		t.Fatalf("Write() over the document quota error = %v, want ErrQuotaExceeded", err)
+	}
+	if _, err := plugin.store.GetFileMetadataByName("pets", "birds.txt"); err == nil {
+		t.Error("document refused by the quota was stored")
+	}
+	if err := write("dogs.txt", "a dog chased another dog"); err != nil {
+		t.Fatalf("rewrite error = %v", err)
+	}
+	if u := usage(); u.Documents != 2 || u.Bytes != int64(len("the cat sat")+len("a dog chased another dog")) || u.Chunks != 2 {
+		t.Errorf("usage = %+v, want 2 documents, 35 bytes and 2 chunks", u)
+	}
+	quota, err := vfs.Read("/pets/.quota", 0, -1)
+	if (err != nil && err != io.EOF) || !strings.Contains(string(quota), "documents: 2 / 2\n") ||
+		!strings.Contains(string(quota), "bytes: 35 / unlimited\n") {
+		t.Errorf("Read(.quota) = %q, %v", quota, err)
+	}
+
+	// Namespace overrides replace the configured limits
+	if _, err := vfs.Write("/pets/.quota", []byte("max_documents = 0\nmax_bytes = 45\n"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(.quota) error = %v", err)
+	}
+	if err := write("birds.txt", "a bird sang a long song"); !errors.Is(err, filesystem.ErrQuotaExceeded) ||
+		!strings.Contains(err.Error(), "bytes quota exceeded") {
+		t.Errorf("Write() over the byte quota error = %v", err)
+	}
+	if err := write("birds.txt", "a bird"); err != nil {
+		t.Errorf("Write() within the byte quota error = %v", err)
+	}
+	quota, _ = vfs.Read("/pets/.quota", 0, -1)
+	if !strings.Contains(string(quota), "documents: 3 / unlimited (namespace quota)\n") ||
+		!strings.Contains(string(quota), "bytes: 41 / 45 (namespace quota)\n") {
+		t.Errorf("Read(.quota) after override = %q", quota)
+	}
+	if _, err := vfs.Write("/pets/.quota", []byte("max_files = 3\n"), 0, filesystem.WriteFlagNone); err == nil {
+		t.Error("Write(.quota) with an unknown limit succeeded")
+	}
+
+	// A document whose chunks would pass the chunk quota fails indexing
+	used := usage().Chunks
+	update := fmt.Sprintf("max_bytes = default\nmax_chunks = %d\n", used+1)
+	if _, err := vfs.Write("/pets/.quota", []byte(update), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(.quota) error = %v", err)
+	}
+	if err := write("long.txt", "the cat naps\n\na dog digs\n\nthe cat purrs"); err != nil {
+		t.Fatalf("Write(long.txt) error = %v", err)
+	}
+	status, err := vfs.Read("/pets/.indexing.d/long.txt", 0, -1)
+	if (err != nil && err != io.EOF) || !strings.Contains(string(status), "state: failed") ||
+		!strings.Contains(string(status), "chunks quota exceeded") {
+		t.Errorf("status of long.txt = %q, %v", status, err)
+	}
+	if u := usage(); u.Chunks != used {
+		t.Errorf("chunks after refused indexing = %d, want %d", u.Chunks, used)
+	}
+
+	// Removing documents gives the quota back
+	before := usage()
+	if err := vfs.Remove("/pets/docs/long.txt"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if err := vfs.Remove("/pets/docs/cats.txt"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	after := usage()
+	if after.Documents != before.Documents-2 || after.Chunks != before.Chunks-1 ||
+		after.Bytes != before.Bytes-int64(len("the cat naps\n\na dog digs\n\nthe cat purrs")+len("the cat sat")) {
+		t.Errorf("usage after remove = %+v, before %+v", after, before)
+	}
+
+	// Counters of an untracked namespace are recomputed at startup
+	if _, err := plugin.store.(*SQLiteClient).db.Exec("DELETE FROM " + usageTable); err != nil {
+		t.Fatalf("DELETE error = %v", err)
+	}
+	plugin.Shutdown()
+	plugin = startLocalTestPlugin(t, cfg)
+	if u, err := plugin.store.NamespaceUsage("pets"); err != nil || u.Documents != after.Documents ||
+		u.Bytes != after.Bytes || u.Chunks != after.Chunks {
+		t.Errorf("usage after restart = %+v, %v; want %+v", u, err, after)
+	}
+}
+
+func TestLocalModeSoftDeleteNamespace(t *testing.T) {
+	cfg := localTestConfig(t)
+	cfg["soft_delete_retention"] = "1h"
+	plugin := startLocalTestPlugin(t, cfg)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if _, err := vfs.Write("/pets/docs/cats.txt", []byte("the cat sat"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	waitIndexed(t, plugin, "pets")
+	meta, err := plugin.store.GetFileMetadataByName("pets", "cats.txt")
+	if err != nil {
+		t.Fatalf("GetFileMetadataByName() error = %v", err)
+	}
+
+	// Deleting hides the namespace and keeps what it stores
+	if err := vfs.RemoveAll("/pets"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if _, err := vfs.Stat("/pets"); err == nil {
+		t.Error("Stat() of a deleted namespace succeeded")
+	}
+	entries, _ := vfs.ReadDir("/")
+	for _, e := range entries {
+		if e.Name == "pets" {
+			t.Error("deleted namespace listed in the root")
+		}
+	}
+	if err := vfs.Mkdir("/pets", 0755); !errors.Is(err, filesystem.ErrAlreadyExists) {
+		t.Errorf("Mkdir() of a deleted namespace error = %v, want ErrAlreadyExists", err)
+	}
+	deleted, err := vfs.ListDeleted("/")
+	if err != nil || len(deleted) != 1 || deleted[0].Name != "pets" ||
+		deleted[0].PurgeAt.Sub(deleted[0].DeletedAt) != time.Hour {
+		t.Fatalf("ListDeleted() = %+v, %v", deleted, err)
+	}
+	if _, err := vfs.ListDeleted("/pets"); !errors.Is(err, filesystem.ErrNotSupported) {
+		t.Errorf("ListDeleted(/pets) error = %v, want ErrNotSupported", err)
+	}
+
+	// Restoring brings the documents back
+	if err := vfs.Restore("/pets"); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if err := vfs.Restore("/pets"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Restore() of a visible namespace error = %v, want ErrNotFound", err)
+	}
+	data, err := vfs.Read("/pets/docs/cats.txt", 0, -1)
+	if (err != nil && err != io.EOF) || string(data) != "the cat sat" {
+		t.Errorf("Read() after restore = %q, %v", data, err)
+	}
+
+	// Past the retention period, the namespace and its documents are purged
+	if err := vfs.RemoveAll("/pets"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	plugin.purgeExpiredNamespaces(time.Now())
+	if deleted, _ := vfs.ListDeleted("/"); len(deleted) != 1 {
+		t.Errorf("namespace purged before its retention period: %+v", deleted)
+	}
+	plugin.purgeExpiredNamespaces(time.Now().Add(2 * time.Hour))
+	if deleted, _ := vfs.ListDeleted("/"); len(deleted) != 0 {
+		t.Errorf("ListDeleted() after purge = %+v", deleted)
+	}
+	if _, err := plugin.docs.DownloadDocument(context.Background(), "pets", meta.FileDigest); err == nil {
+		t.Error("stored document survived the purge")
+	}
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Errorf("Mkdir() after purge error = %v", err)
+	}
+}
+
+func TestLocalModeHardDeleteNamespace(t *testing.T) {
+	plugin := startLocalTestPlugin(t, localTestConfig(t))
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if _, err := vfs.Write("/pets/docs/cats.txt", []byte("the cat sat"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	waitIndexed(t, plugin, "pets")
+	meta, err := plugin.store.GetFileMetadataByName("pets", "cats.txt")
+	if err != nil {
+		t.Fatalf("GetFileMetadataByName() error = %v", err)
+	}
+
+	// Without a retention period, namespaces are deleted at once
+	if err := vfs.RemoveAll("/pets"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if deleted, err := vfs.ListDeleted("/"); err != nil || len(deleted) != 0 {
+		t.Errorf("ListDeleted() = %+v, %v", deleted, err)
+	}
+	if _, err := plugin.docs.DownloadDocument(context.Background(), "pets", meta.FileDigest); err == nil {
+		t.Error("stored document survived the delete")
+	}
+	if err := vfs.Restore("/pets"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Restore() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSoftDeleteRetentionValidation(t *testing.T) {
+	for _, value := range []string{"soon", "-1h"} {
+		cfg := localTestConfig(t)
+		cfg["soft_delete_retention"] = value
+		if err := NewVectorFSPlugin().Validate(cfg); err == nil {
+			t.Errorf("Validate() with soft_delete_retention %q succeeded", value)
+		}
+	}
+}
+
+func TestParseNamespaceConfig(t *testing.T) {
+	overlap, minScore := 0, 0.5
+	want := NamespaceConfig{ChunkSize: 256, ChunkOverlap: &overlap, ChunkStrategy: "sentence", EmbeddingModel: "m2", DefaultTopK: 5, MinScore: &minScore}
+	for _, data := range []string{
+		"# comment\nchunk_size = 256\nchunk_overlap = 0\nchunk_strategy = \"sentence\"\nembedding_model = \"m2\"\ndefault_topk = 5\nmin_score = 0.5\n",
+		`{"chunk_size": 256, "chunk_overlap": 0, "chunk_strategy": "sentence", "embedding_model": "m2", "default_topk": 5, "min_score": 0.5}`,
+	} {
+		cfg, err := parseNamespaceConfig([]byte(data))
+		if err != nil || !reflect.DeepEqual(cfg, want) {
+			t.Errorf("parseNamespaceConfig(%q) = %+v, %v", data, cfg, err)
+		}
+	}
+	if cfg, err := parseNamespaceConfig(nil); err != nil || cfg != (NamespaceConfig{}) {
+		t.Errorf("parseNamespaceConfig(empty) = %+v, %v", cfg, err)
+	}
+	for _, data := range []string{
+		"chunk_size = 25.5", "chunk_size = \"big\"", "chunk_size = 0", "tokenizer = \"tiktoken\"",
+		"chunk_size", `{"chunk_size": 256`, "embedding_model = m2",
+	} {
+		if _, err := parseNamespaceConfig([]byte(data)); !errors.Is(err, filesystem.ErrInvalidArgument) {
+			t.Errorf("parseNamespaceConfig(%q) error = %v, want ErrInvalidArgument", data, err)
+		}
+	}
+
+	chunker := ChunkerConfig{ChunkSize: 512, ChunkOverlap: 50}
+	search := SearchConfig{DefaultTopK: 10, MaxTopK: 100}
+	for _, cfg := range []NamespaceConfig{
+		{ChunkSize: 40}, {ChunkStrategy: "words"}, {DefaultTopK: 500}, {MinScore: new(float64)},
+		{WriteConsistency: "eventual"}, {ScoringHook: "no-such-hook"},
+	} {
+		if cfg.MinScore != nil {
+			*cfg.MinScore = 2
+		}
+		if err := cfg.validate(chunker, search); err == nil {
+			t.Errorf("validate(%+v) succeeded", cfg)
+		}
+	}
+}
+
+func TestLocalModeNamespaceConfig(t *testing.T) {
+	cfg := localTestConfig(t)
+	cfg["chunk_size"] = 512
+	cfg["chunk_overlap"] = 0
+	plugin := startLocalTestPlugin(t, cfg)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	for _, ns := range []string{"/pets", "/zoo"} {
+		if err := vfs.Mkdir(ns, 0755); err != nil {
+			t.Fatalf("Mkdir() error = %v", err)
+		}
+	}
+	readConfig := func() string {
+		t.Helper()
+		data, err := vfs.Read("/pets/.config", 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatalf("Read(.config) error = %v", err)
+		}
+		return string(data)
+	}
+	if data := readConfig(); !strings.Contains(data, "# chunk_size = 512\n") || !strings.Contains(data, "# embedding_model = \"nomic-embed-text\"\n") {
+		t.Errorf("Read(.config) = %q", data)
+	}
+
+	// Chunking and search defaults apply to their namespace only
+	if _, err := vfs.Write("/pets/.config", []byte("chunk_size = 4\n"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(.config) error = %v", err)
+	}
+	if _, err := vfs.Write("/pets/.config", []byte(`{"chunk_size": 4, "default_topk": 1}`), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(.config) error = %v", err)
+	}
+	content := "the cat sat on the mat. the dog sat on the log."
+	for _, ns := range []string{"pets", "zoo"} {
+		if _, err := vfs.Write("/"+ns+"/docs/animals.txt", []byte(content), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		waitIndexed(t, plugin, ns)
+	}
+	usage := func(ns string) int64 {
+		u, _ := plugin.store.NamespaceUsage(ns)
+		return u.Chunks
+	}
+	if pets, zoo := usage("pets"), usage("zoo"); pets < 2 || zoo != 1 {
+		t.Errorf("chunks = %d in pets, %d in zoo; want several and 1", pets, zoo)
+	}
+	if results, err := vfs.VectorSearch("pets", "cat", 0); err != nil || len(results) != 1 {
+		t.Errorf("VectorSearch(pets) = %d results, %v; want 1", len(results), err)
+	}
+	if data := readConfig(); !strings.Contains(data, "\nchunk_size = 4\n") || !strings.Contains(data, "\ndefault_topk = 1\n") ||
+		!strings.Contains(data, "# min_score = 0\n") {
+		t.Errorf("Read(.config) = %q", data)
+	}
+
+	// Invalid settings are refused and leave the config alone
+	for _, data := range []string{"default_topk = 1000", "chunk_strategy = \"words\"", "tokenizer = \"tiktoken\""} {
+		if _, err := vfs.Write("/pets/.config", []byte(data), 0, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrInvalidArgument) {
+			t.Errorf("Write(.config %q) error = %v, want ErrInvalidArgument", data, err)
+		}
+	}
+	if _, err := vfs.Read("/missing/.config", 0, -1); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Read(/missing/.config) error = %v, want ErrNotFound", err)
+	}
+
+	// A namespace model gets its own route and re-indexes the namespace
+	if _, err := vfs.Write("/pets/.config", []byte("embedding_model = \"cat-embed\"\n"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(.config) error = %v", err)
+	}
+	waitIndexed(t, plugin, "pets")
+	if route := plugin.embedder.Route("pets"); route != routeModelPrefix+"cat-embed" {
+		t.Errorf("Route(pets) = %q", route)
+	}
+	if route := plugin.embedder.Route("zoo"); route != routePrimary {
+		t.Errorf("Route(zoo) = %q", route)
+	}
+	if pets := usage("pets"); pets != 1 {
+		t.Errorf("chunks of pets after re-indexing with the plugin chunking = %d, want 1", pets)
+	}
+
+	// Overrides persist across restarts, until the namespace is deleted
+	plugin.Shutdown()
+	plugin = startLocalTestPlugin(t, cfg)
+	vfs = plugin.GetFileSystem().(*vectorFS)
+	if data := readConfig(); !strings.Contains(data, "\nembedding_model = \"cat-embed\"\n") {
+		t.Errorf("Read(.config) after restart = %q", data)
+	}
+	if err := vfs.RemoveAll("/pets"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if c, err := plugin.store.NamespaceConfig("pets"); err != nil || c != (NamespaceConfig{}) {
+		t.Errorf("NamespaceConfig() of a new namespace = %+v, %v", c, err)
+	}
+}
+
+func TestLocalModeWriteConsistencyIndexed(t *testing.T) {
+	cfg := localTestConfig(t)
+	cfg["write_consistency"] = WriteConsistencyIndexed
+	cfg["chunk_size"] = 8
+	cfg["chunk_overlap"] = 0
+	plugin := startLocalTestPlugin(t, cfg)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+
+	// Searchable as soon as the write returns, without waiting
+	for _, content := range []string{"the cat sat", "the cat sat"} {
+		if _, err := vfs.Write("/pets/docs/cats.txt", []byte(content), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if n := plugin.pendingIndexing("pets"); n != 0 {
+			t.Errorf("pendingIndexing() = %d after an indexed write", n)
+		}
+		if results, err := vfs.VectorSearch("pets", "cat", 1); err != nil || len(results) != 1 {
+			t.Errorf("VectorSearch() = %+v, %v; want the document just written", results, err)
+		}
+	}
+
+	// Indexing errors are returned, the document stays stored
+	if _, err := vfs.Write("/pets/.quota", []byte("max_chunks = 2\n"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(.quota) error = %v", err)
+	}
+	_, err := vfs.Write("/pets/docs/dogs.txt", []byte("a dog digs\n\na dog naps\n\na dog runs"), 0, filesystem.WriteFlagCreate)
+	if !errors.Is(err, filesystem.ErrQuotaExceeded) {
+		t.Errorf("Write() over the chunk quota error = %v, want ErrQuotaExceeded", err)
+	}
+	if _, err := vfs.Stat("/pets/docs/dogs.txt"); err != nil {
+		t.Errorf("Stat() of a document that failed indexing error = %v", err)
+	}
+}
+
+func TestLocalModeWriteConsistencyTimeout(t *testing.T) {
+	// An embedding service that, once blocked, answers only once released
+	var blocked atomic.Bool
+	released := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if blocked.Load() {
+			<-released
+		}
+		var req ollamaEmbedRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := ollamaEmbedResponse{}
+		for range req.Input {
+			resp.Embeddings = append(resp.Embeddings, []float32{1, 0, 0.1})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	cfg := localTestConfig(t)
+	cfg["embedding_endpoint"] = server.URL
+	cfg["index_wait_timeout"] = "50ms"
+	plugin := startLocalTestPlugin(t, cfg)
+	t.Cleanup(func() { close(released) })
+	blocked.Store(true)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	for _, ns := range []string{"/pets", "/zoo"} {
+		if err := vfs.Mkdir(ns, 0755); err != nil {
+			t.Fatalf("Mkdir() error = %v", err)
+		}
+	}
+
+	// Only the namespace asking for indexed writes waits
+	if _, err := vfs.Write("/pets/.config", []byte("write_consistency = \"indexed\"\n"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(.config) error = %v", err)
+	}
+	if _, err := vfs.Write("/zoo/docs/cats.txt", []byte("the cat sat"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Errorf("Write() to a visible namespace error = %v", err)
+	}
+	_, err := vfs.Write("/pets/docs/cats.txt", []byte("the cat sat"), 0, filesystem.WriteFlagCreate)
+	if !errors.Is(err, filesystem.ErrTimeout) {
+		t.Errorf("Write() error = %v, want ErrTimeout", err)
+	}
+	if _, err := vfs.Stat("/pets/docs/cats.txt"); err != nil {
+		t.Errorf("Stat() after a timed out write error = %v", err)
+	}
+}
+
+func TestLocalModePausesIndexingWhileProviderDown(t *testing.T) {
+	// An embedding service that answers 503 while down
+	var down atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var req ollamaEmbedRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := ollamaEmbedResponse{}
+		for range req.Input {
+			resp.Embeddings = append(resp.Embeddings, []float32{1, 0, 0.1})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	cfg := localTestConfig(t)
+	cfg["embedding_endpoint"] = server.URL
+	cfg["write_consistency"] = WriteConsistencyIndexed
+	plugin := startLocalTestPlugin(t, cfg)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if h := vfs.Health(); h.Degraded() {
+		t.Fatalf("Health() = %+v before the outage", h)
+	}
+
+	// Writes and reads go on while indexing waits
+	down.Store(true)
+	for name, content := range map[string]string{"cats.txt": "the cat sat", "dogs.txt": "the dog ran"} {
+		if _, err := vfs.Write("/pets/docs/"+name, []byte(content), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write(%s) while the provider is down error = %v", name, err)
+		}
+	}
+	if data, err := vfs.Read("/pets/docs/cats.txt", 0, -1); err != nil && err != io.EOF || string(data) != "the cat sat" {
+		t.Errorf("Read() while the provider is down = %q, %v", data, err)
+	}
+	h := vfs.Health()
+	if h.Mode != modeIndexingPaused || h.Degradations != 1 || !strings.Contains(h.Reason, "503") {
+		t.Errorf("Health() = %+v, want %s", h, modeIndexingPaused)
+	}
+	if n := plugin.pendingIndexing("pets"); n != 2 {
+		t.Errorf("pendingIndexing() = %d while paused, want 2", n)
+	}
+	if status := plugin.getIndexingStatus("pets"); !strings.Contains(status, "paused:") {
+		t.Errorf("indexing status does not say it is paused:\n%s", status)
+	}
+
+	// Indexing resumes once the provider answers
+	down.Store(false)
+	plugin.indexPause.resume()
+	waitIndexed(t, plugin, "pets")
+	if h := vfs.Health(); h.Degraded() || h.Degradations != 1 {
+		t.Errorf("Health() = %+v after the outage", h)
+	}
+	if results, err := vfs.VectorSearch("pets", "cat", 5); err != nil || len(results) != 2 {
+		t.Errorf("VectorSearch() = %+v, %v; want both documents", results, err)
+	}
+}
+
+func TestIndexPauseProbesOneWorker(t *testing.T) {
+	var p indexPause
+	shutdown := make(chan struct{})
+	if probe, ok := p.await(shutdown); probe || !ok {
+		t.Fatalf("await() = %v, %v while not paused", probe, ok)
+	}
+
+	outage := fmt.Errorf("%w: %w", errEmbeddingFailed, &embeddingAPIError{StatusCode: 503})
+	if !isEmbeddingOutage(outage) {
+		t.Fatal("isEmbeddingOutage() = false for a 503")
+	}
+	if isEmbeddingOutage(fmt.Errorf("%w: %w", errEmbeddingFailed, &embeddingAPIError{StatusCode: 400})) {
+		t.Error("isEmbeddingOutage() = true for a 400")
+	}
+	p.pause(outage, false)
+	p.pause(outage, false) // Documents in flight don't extend the backoff
+	if p.backoff != indexPauseMinBackoff {
+		t.Errorf("backoff = %v, want %v", p.backoff, indexPauseMinBackoff)
+	}
+
+	// Once the backoff is over, a single worker probes
+	p.mu.Lock()
+	p.retryAt = time.Now()
+	p.mu.Unlock()
+	if probe, ok := p.await(shutdown); !probe || !ok {
+		t.Fatalf("await() = %v, %v after the backoff, want a probe", probe, ok)
+	}
+	waiting := make(chan bool, 1)
+	go func() {
+		probe, _ := p.await(shutdown)
+		waiting <- probe
+	}()
+	select {
+	case <-waiting:
+		t.Fatal("a second worker stopped waiting during the probe")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A failed probe doubles the backoff, a successful one resumes all
+	p.pause(outage, true)
+	if p.backoff != 2*indexPauseMinBackoff {
+		t.Errorf("backoff = %v after a failed probe, want %v", p.backoff, 2*indexPauseMinBackoff)
+	}
+	p.resume()
+	select {
+	case probe := <-waiting:
+		if probe {
+			t.Error("await() = probe after indexing resumed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting worker not woken by resume")
+	}
+
+	p.pause(outage, false)
+	close(shutdown)
+	if _, ok := p.await(shutdown); ok {
+		t.Error("await() = ok after shutdown")
+	}
+}
+
+func TestParseWriteConsistency(t *testing.T) {
+	mode, timeout, err := parseWriteConsistency(map[string]interface{}{})
+	if err != nil || mode != WriteConsistencyVisible || timeout != defaultIndexWaitTimeout {
+		t.Errorf("parseWriteConsistency(defaults) = %q, %v, %v", mode, timeout, err)
+	}
+	mode, timeout, err = parseWriteConsistency(map[string]interface{}{"write_consistency": "indexed", "index_wait_timeout": "10s"})
+	if err != nil || mode != WriteConsistencyIndexed || timeout != 10*time.Second {
+		t.Errorf("parseWriteConsistency() = %q, %v, %v", mode, timeout, err)
+	}
+	for _, cfg := range []map[string]interface{}{
+		{"write_consistency": "eventual"}, {"index_wait_timeout": "soon"}, {"index_wait_timeout": "0s"},
+	} {
+		if _, _, err := parseWriteConsistency(cfg); err == nil {
+			t.Errorf("parseWriteConsistency(%v) succeeded", cfg)
+		}
+	}
+}
+
+func TestLocalModeGarbageCollection(t *testing.T) {
+	plugin := newLocalTestPlugin(t)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	ctx := context.Background()
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	for name, content := range map[string]string{"cats.txt": "the cat sat", "dogs.txt": "a dog ran"} {
+		if _, err := vfs.Write("/pets/docs/"+name, []byte(content), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+	}
+	waitIndexed(t, plugin, "pets")
+
+	// Leftovers of failed writes: stored content and chunks without
+	// metadata, and content lost under a document
+	old := time.Now().Add(-time.Hour)
+	for _, digest := range []string{"old-orphan", "new-orphan"} {
+		if err := plugin.docs.UploadDocument(ctx, "pets", digest, []byte("lost")); err != nil {
+			t.Fatalf("UploadDocument() error = %v", err)
+		}
+	}
+	if err := os.Chtimes(plugin.docs.buildKey("pets", "old-orphan"), old, old); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+	chunks := []ChunkData{{ChunkIndex: 0, ChunkText: "a lost cat", Embedding: []float32{1, 0, 0.1}}}
+	if err := plugin.store.InsertChunksBatch("pets", "orphan-chunks", chunks); err != nil {
+		t.Fatalf("InsertChunksBatch() error = %v", err)
+	}
+	dogs, err := plugin.store.GetFileMetadataByName("pets", "dogs.txt")
+	if err != nil {
+		t.Fatalf("GetFileMetadataByName() error = %v", err)
+	}
+	if err := plugin.docs.DeleteDocument(ctx, "pets", dogs.FileDigest); err != nil {
+		t.Fatalf("DeleteDocument() error = %v", err)
+	}
+	usageBefore, _ := plugin.store.NamespaceUsage("pets")
+
+	readReport := func() string {
+		t.Helper()
+		data, err := vfs.Read("/pets/.gc", 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatalf("Read(.gc) error = %v", err)
+		}
+		return string(data)
+	}
+	if report := readReport(); !strings.HasPrefix(report, "no garbage collection has run yet") {
+		t.Errorf("Read(.gc) before a collection = %q", report)
+	}
+
+	// A dry run reports without removing anything
+	if _, err := vfs.Write("/pets/.gc", []byte("dry-run\n"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(.gc dry-run) error = %v", err)
+	}
+	report := readReport()
+	for _, want := range []string{
+		"orphaned stored documents found (dry run): 1 (4 bytes)\n  old-orphan ",
+		"orphaned chunks found (dry run): 1 document(s)\n  orphan-chunks\n",
+		"documents missing their stored content: 1\n  docs/dogs.txt\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Read(.gc) after a dry run = %q, want %q", report, want)
+		}
+	}
+	if exists, _ := plugin.docs.DocumentExists(ctx, "pets", "old-orphan"); !exists {
+		t.Error("dry run removed a stored document")
+	}
+
+	// A collection removes the orphans past the grace period only
+	if _, err := vfs.Write("/pets/.gc", nil, 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(.gc) error = %v", err)
+	}
+	if report := readReport(); !strings.Contains(report, "orphaned stored documents removed: 1 (4 bytes)\n") ||
+		!strings.Contains(report, "orphaned chunks removed: 1 document(s)\n") {
+		t.Errorf("Read(.gc) after a collection = %q", report)
+	}
+	for digest, want := range map[string]bool{"old-orphan": false, "new-orphan": true} {
+		if exists, _ := plugin.docs.DocumentExists(ctx, "pets", digest); exists != want {
+			t.Errorf("DocumentExists(%s) = %v after a collection, want %v", digest, exists, want)
+		}
+	}
+	if orphans, err := plugin.store.ListOrphanedChunkDigests("pets"); err != nil || len(orphans) != 0 {
+		t.Errorf("ListOrphanedChunkDigests() = %v, %v after a collection", orphans, err)
+	}
+	if usage, _ := plugin.store.NamespaceUsage("pets"); usage.Chunks != usageBefore.Chunks-1 {
+		t.Errorf("chunks after a collection = %d, want %d", usage.Chunks, usageBefore.Chunks-1)
+	}
+	if results, err := vfs.VectorSearch("pets", "cat", 1); err != nil || len(results) != 1 || results[0].Content != "the cat sat" {
+		t.Errorf("VectorSearch() after a collection = %+v, %v", results, err)
+	}
+
+	if _, err := vfs.Write("/pets/.gc", []byte("everything"), 0, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("Write(.gc everything) error = %v, want ErrInvalidArgument", err)
+	}
+	if _, err := vfs.Read("/missing/.gc", 0, -1); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Read(/missing/.gc) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestParseGCConfig(t *testing.T) {
+	interval, grace, err := parseGCConfig(map[string]interface{}{})
+	if err != nil || interval != defaultGCInterval || grace != defaultGCGracePeriod {
+		t.Errorf("parseGCConfig(defaults) = %v, %v, %v", interval, grace, err)
+	}
+	interval, grace, err = parseGCConfig(map[string]interface{}{"gc_interval": "0", "gc_grace_period": "1h"})
+	if err != nil || interval != 0 || grace != time.Hour {
+		t.Errorf("parseGCConfig() = %v, %v, %v", interval, grace, err)
+	}
+	for _, cfg := range []map[string]interface{}{{"gc_interval": "hourly"}, {"gc_grace_period": "-1m"}} {
+		if _, _, err := parseGCConfig(cfg); err == nil {
+			t.Errorf("parseGCConfig(%v) succeeded", cfg)
+		}
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	for _, tt := range []struct {
+		data     []byte
+		text     string
+		encoding string
+	}{
+		{[]byte("café"), "café", encodingUTF8},
+		{append([]byte{0xEF, 0xBB, 0xBF}, "café"...), "café", encodingUTF8},
+		{[]byte{0xFF, 0xFE, 'c', 0, 0xE9, 0, '\n', 0}, "cé\n", encodingUTF16LE},
+		{[]byte{0xFE, 0xFF, 0, 'c', 0, 0xE9, 0x20, 0xAC}, "cé€", encodingUTF16BE},
+		{[]byte("caf\xe9 \x80\r\n"), "café €\r\n", encodingWindows1252},
+	} {
+		text, encoding, ok := decodeText(tt.data)
+		if !ok || text != tt.text || encoding != tt.encoding {
+			t.Errorf("decodeText(%q) = %q, %q, %v; want %q, %q", tt.data, text, encoding, ok, tt.text, tt.encoding)
+		}
+	}
+	for _, data := range [][]byte{
+		{0x00, 'c', 'a', 't'}, {'c', 0xff, 0x01, 't'}, {0xFF, 0xFE, 'c'}, {0xFF, 0xFE, 0x01, 0},
+	} {
+		if _, _, ok := decodeText(data); ok {
+			t.Errorf("decodeText(%q) decoded binary data", data)
+		}
+	}
+}
+
+func TestNormalizeText(t *testing.T) {
+	for in, want := range map[string]string{
+		"cafe\u0301":                "caf\u00e9",
+		"a\u0323\u0302":             "\u1ead",
+		"a\u0302\u0323":             "\u1ead",
+		"\u1100\u1161\u11a8":        "\uac01",
+		"a\u0301\u0301":             "\u00e1\u0301",
+		"line one\r\nline two\rend": "line one\nline two\nend",
+		"already composed \u00e9":   "already composed \u00e9",
+	} {
+		if got := normalizeText(in); got != want {
+			t.Errorf("normalizeText(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	for text, want := range map[string]string{
+		"The cat sat on the mat and the dog was with you for this walk.":           "en",
+		"Le chat est dans la maison et les chiens sont pour le jardin du voisin.":  "fr",
+		"Der Hund und die Katze sind nicht mit dem Auto auf der Straße gefahren.":  "de",
+		"El perro y los gatos del barrio son para la casa con las flores que hay.": "es",
+		"猫は毎日よく寝ています。犬は公園で走るのが好きです。":                                               "ja",
+		"猫在沙发上睡觉。狗在公园里跑来跑去，非常开心。今天天气很好。":                                           "zh",
+		"고양이가 소파에서 자고 있습니다. 강아지는 공원에서 뛰어놉니다.":                                      "ko",
+		"Кошка спит на диване, а собака бегает в парке каждый день.":               "ru",
+		"Кішка спить на дивані, а собака бігає в парку щодня і завжди.":            "uk",
+		"ok":                                 "",
+		"func main() { x := y + z; return }": "",
+	} {
+		if got := detectLanguage(text); got != want {
+			t.Errorf("detectLanguage(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestChunkUnspacedLanguage(t *testing.T) {
+	text := "猫在沙发上睡觉。狗在公园里跑来跑去。今天天气很好。"
+	cfg := ChunkerConfig{ChunkSize: 13, Strategy: ChunkStrategySentence, Language: "zh"}
+	var texts []string
+	for _, c := range ChunkDocument(text, cfg) {
+		texts = append(texts, c.Text)
+	}
+	want := []string{"猫在沙发上睡觉。狗在公园里跑来跑去。", "今天天气很好。"}
+	if !reflect.DeepEqual(texts, want) {
+		t.Errorf("ChunkDocument(zh) = %q, want %q", texts, want)
+	}
+}
+
+func TestLocalModeStats(t *testing.T) {
+	plugin := newLocalTestPlugin(t)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	readStats := func() map[string]string {
+		t.Helper()
+		data, err := vfs.Read("/pets/.stats", 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatalf("Read(.stats) error = %v", err)
+		}
+		stats := make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			key, value, _ := strings.Cut(line, ": ")
+			stats[key] = value
+		}
+		return stats
+	}
+	if stats := readStats(); stats["documents"] != "0" || stats["embedding_batches"] != "0" || stats["last_index"] != "none" {
+		t.Errorf("stats of an empty namespace = %v", stats)
+	}
+
+	for name, content := range map[string]string{"cats.txt": "the cat sat", "dogs.txt": "a dog ran"} {
+		if _, err := vfs.Write("/pets/docs/"+name, []byte(content), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+	}
+	waitIndexed(t, plugin, "pets")
+	if _, err := vfs.VectorSearch("pets", "cat", 5); err != nil {
+		t.Fatalf("VectorSearch() error = %v", err)
+	}
+
+	stats := readStats()
+	for key, want := range map[string]string{
+		"documents":          "2",
+		"chunks":             "2",
+		"stored_objects":     "2",
+		"stored_bytes":       "20",
+		"embedding_queries":  "1",
+		"embedding_batches":  "2",
+		"embedded_chunks":    "2",
+		"embedding_failures": "0",
+	} {
+		if stats[key] != want {
+			t.Errorf("%s = %q, want %q", key, stats[key], want)
+		}
+	}
+	if last := stats["last_index"]; !strings.HasPrefix(last, "cats.txt in ") && !strings.HasPrefix(last, "dogs.txt in ") {
+		t.Errorf("last_index = %q", last)
+	}
+
+	info, err := vfs.Stat("/pets/.stats")
+	if err != nil || info.Mode != 0444 {
+		t.Errorf("Stat(.stats) = %+v, %v", info, err)
+	}
+	if _, err := vfs.Read("/nowhere/.stats", 0, -1); err == nil {
+		t.Error("Read(.stats) of a missing namespace succeeded")
+	}
+}
+
+func TestLocalModeQueryCacheAndLimits(t *testing.T) {
+	plugin := newLocalTestPlugin(t)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	search := func(query string) error {
+		_, err := vfs.VectorSearch("pets", query, 5)
+		return err
+	}
+
+	// Repeated queries are embedded once
+	for i := 0; i < 3; i++ {
+		if err := search("cat"); err != nil {
+			t.Fatalf("VectorSearch() error = %v", err)
+		}
+	}
+	if usage := plugin.embedder.namespaceUsage("pets"); usage.Queries != 1 || usage.CachedQueries != 2 {
+		t.Errorf("usage after repeated queries = %+v, want 1 embedded and 2 cached", usage)
+	}
+
+	// Queries missing the cache are limited per namespace
+	if _, err := vfs.Write("/pets/.config", []byte("query_qps = 1\n"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("write .config error = %v", err)
+	}
+	if err := search("dog"); err != nil {
+		t.Fatalf("first query under query_qps error = %v", err)
+	}
+	err := search("bird")
+	var limited *filesystem.RateLimitedError
+	if !errors.As(err, &limited) || limited.RetryAfter <= 0 || limited.RetryAfter > time.Second {
+		t.Fatalf("query over query_qps error = %v, want rate limited", err)
+	}
+	if err := search("dog"); err != nil {
+		t.Errorf("cached query over query_qps error = %v", err)
+	}
+	if usage := plugin.embedder.namespaceUsage("pets"); usage.ThrottledQueries != 1 {
+		t.Errorf("throttled queries = %d, want 1", usage.ThrottledQueries)
+	}
+	if err := vfs.Mkdir("/birds", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if _, err := vfs.VectorSearch("birds", "bird", 5); err != nil {
+		t.Errorf("other namespace limited too: %v", err)
+	}
+
+	var limiter queryLimiter
+	now := time.Unix(0, 0)
+	limiter.now = func() time.Time { return now }
+	for i := 0; i < 4; i++ {
+		if _, ok := limiter.take("ns", 2, 4); !ok {
+			t.Fatalf("query %d of a burst of 4 refused", i+1)
+		}
+	}
+	if retryAfter, ok := limiter.take("ns", 2, 4); ok || retryAfter != 500*time.Millisecond {
+		t.Errorf("take() over the burst = %v, %v; want 500ms", retryAfter, ok)
+	}
+	now = now.Add(time.Second)
+	if _, ok := limiter.take("ns", 2, 4); !ok {
+		t.Error("take() refused after refilling")
+	}
+
+	cache := newQueryEmbeddingCache(2)
+	cache.put("a", []float32{1})
+	cache.put("b", []float32{2})
+	cache.get("a")
+	cache.put("c", []float32{3})
+	if _, ok := cache.get("b"); ok {
+		t.Error("least recently used entry kept")
+	}
+	if _, ok := cache.get("a"); !ok {
+		t.Error("recently used entry dropped")
+	}
+	if newQueryEmbeddingCache(0) != nil {
+		t.Error("query_cache_size 0 should disable the cache")
+	}
+}
+
+func TestLocalModeAnswersQuestions(t *testing.T) {
+	var mu sync.Mutex
+	var asked openAIAnswerRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewDecoder(r.Body).Decode(&asked)
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"Cats sleep all day [1]."}}]}`)
+	}))
+	defer server.Close()
+
+	cfg := localTestConfig(t)
+	cfg["answer_provider"] = AnswerProviderOpenAI
+	cfg["answer_endpoint"] = server.URL
+	cfg["answer_top_k"] = 1
+	plugin := startLocalTestPlugin(t, cfg)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if data, _ := vfs.Read("/pets/ask", 0, -1); !strings.HasPrefix(string(data), "no question asked yet") {
+		t.Errorf("ask before any question = %q", data)
+	}
+	vfs.Write("/pets/docs/cats.txt", []byte("The cat sleeps all day."), 0, filesystem.WriteFlagCreate)
+	vfs.Write("/pets/docs/dogs.txt", []byte("The dog barks."), 0, filesystem.WriteFlagCreate)
+	waitIndexed(t, plugin, "pets")
+
+	if _, err := vfs.Write("/pets/ask", []byte("What does the cat do?\n"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("write ask error = %v", err)
+	}
+	mu.Lock()
+	if len(asked.Messages) != 2 || asked.Messages[0].Content != defaultAnswerPrompt ||
+		!strings.Contains(asked.Messages[1].Content, "Source [1]:\n(pets/docs/cats.txt)\nThe cat sleeps all day.") ||
+		strings.Contains(asked.Messages[1].Content, "Source [2]") ||
+		!strings.HasSuffix(asked.Messages[1].Content, "Question: What does the cat do?\n") {
+		t.Errorf("chat request = %+v", asked)
+	}
+	mu.Unlock()
+
+	data, err := vfs.Read("/pets/ask", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("read ask error = %v", err)
+	}
+	answer := string(data)
+	if !strings.HasPrefix(answer, "question: What does the cat do?\n") || !strings.Contains(answer, "\nCats sleep all day [1].\n") ||
+		!strings.Contains(answer, "sources:\n[1] pets/docs/cats.txt (chunk ") {
+		t.Errorf("ask = %q", answer)
+	}
+
+	if _, err := vfs.Write("/pets/ask", []byte("  "), 0, filesystem.WriteFlagNone); err == nil {
+		t.Error("empty question accepted")
+	}
+	if _, err := vfs.Write("/missing/ask", []byte("why?"), 0, filesystem.WriteFlagNone); err == nil {
+		t.Error("question to a missing namespace accepted")
+	}
+
+	plain := newLocalTestPlugin(t)
+	pvfs := plain.GetFileSystem().(*vectorFS)
+	pvfs.Mkdir("/pets", 0755)
+	if _, err := pvfs.Write("/pets/ask", []byte("why?"), 0, filesystem.WriteFlagNone); err == nil ||
+		!strings.Contains(err.Error(), "answer_provider") {
+		t.Errorf("ask without answer_provider error = %v", err)
+	}
+	if _, err := parseAnswerConfig(map[string]interface{}{"answer_provider": "bogus"}); err == nil {
+		t.Error("unknown answer_provider accepted")
+	}
+}
+
+func TestParseTenantConfig(t *testing.T) {
+	isolation, admins, err := parseTenantConfig(map[string]interface{}{
+		"tenant_isolation": true, "tenant_admins": []interface{}{"ops", "audit"},
+	})
+	if err != nil || !isolation || !admins["ops"] || !admins["audit"] || len(admins) != 2 {
+		t.Errorf("parseTenantConfig() = %v, %v, %v", isolation, admins, err)
+	}
+	if _, admins, err := parseTenantConfig(map[string]interface{}{"tenant_admins": "ops, audit"}); err != nil || len(admins) != 2 {
+		t.Errorf("parseTenantConfig(string) = %v, %v", admins, err)
+	}
+	for _, cfg := range []map[string]interface{}{
+		{"tenant_isolation": "yes"},
+		{"tenant_admins": []interface{}{1}},
+		{"tenant_admins": 1},
+	} {
+		if _, _, err := parseTenantConfig(cfg); err == nil {
+			t.Errorf("parseTenantConfig(%v) succeeded", cfg)
+		}
+	}
+}
+
+func TestLocalModeTenantIsolation(t *testing.T) {
+	cfg := localTestConfig(t)
+	cfg["tenant_isolation"] = true
+	cfg["tenant_admins"] = []interface{}{"ops"}
+	plugin := startLocalTestPlugin(t, cfg)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+
+	for _, ns := range []struct{ owner, name string }{{"alice", "team-a"}, {"bob", "team_b"}, {"", "shared"}} {
+		if err := vfs.MkdirAs(ns.owner, "/"+ns.name, 0755); err != nil {
+			t.Fatalf("MkdirAs(%s) error = %v", ns.name, err)
+		}
+	}
+	// Creating an existing namespace fails and keeps its owner
+	if err := vfs.MkdirAs("ops", "/team-a", 0755); err == nil {
+		t.Error("MkdirAs(existing) succeeded")
+	}
+	if owner, _ := plugin.store.NamespaceOwner("team-a"); owner != "alice" {
+		t.Errorf("owner of team-a = %q, want alice", owner)
+	}
+	if err := vfs.MkdirAs("alice", "/team_b", 0755); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("MkdirAs(team_b) by alice error = %v, want permission denied", err)
+	}
+
+	tests := []struct {
+		identity, path string
+		recursive      bool
+		allowed        bool
+	}{
+		{"alice", "/team-a/docs/x.txt", false, true},
+		{"bob", "/team-a/docs/x.txt", false, false},
+		{"", "/team-a", false, false},
+		{"bob", "/shared/docs", false, true},
+		{"bob", "/{team_b,shared}/docs", false, true},
+		{"bob", "/{team_b,team-a}/docs", false, false},
+		{"bob", "/search-all", false, true},
+		{"bob", "/", false, true},
+		{"bob", "/", true, false},
+		{"ops", "/", true, true},
+		{"ops", "/team-a/.stats", false, true},
+	}
+	for _, tt := range tests {
+		err := vfs.AuthorizeTenant(tt.identity, tt.path, tt.recursive)
+		if allowed := err == nil; allowed != tt.allowed {
+			t.Errorf("AuthorizeTenant(%q, %s, %v) = %v, want allowed %v", tt.identity, tt.path, tt.recursive, err, tt.allowed)
+		}
+	}
+
+	entries, err := vfs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	names := func(identity string) string {
+		var visible []string
+		for _, e := range vfs.FilterTenant(identity, "/", entries) {
+			visible = append(visible, e.Name)
+		}
+		sort.Strings(visible)
+		return strings.Join(visible, ",")
+	}
+	for identity, want := range map[string]string{
+		"alice": "README,shared,team_a",
+		"bob":   "README,shared,team_b",
+		"":      "README,shared",
+		"ops":   "README,shared,team_a,team_b",
+	} {
+		if got := names(identity); got != want {
+			t.Errorf("%q lists %s, want %s", identity, got, want)
+		}
+	}
+
+	// Owners go with the namespace
+	if err := vfs.RemoveAll("/team-a"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if owner, _ := plugin.store.NamespaceOwner("team-a"); owner != "" {
+		t.Errorf("owner of removed team-a = %q", owner)
+	}
+}
+
+func TestLocalModeNormalizesDocuments(t *testing.T) {
+	plugin := newLocalTestPlugin(t)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	// A Windows-1252 document used to be binary, and is found by its
+	// composed text
+	latin1 := []byte("Le cat est dans la maison et les chiens sont pour le jardin du caf\xe9.\r\n")
+	decomposed := []byte("Le chien et le cat sont dans le cafe\u0301 pour la nuit avec les enfants.")
+	english := []byte("The cat sat on the mat and the dog was with you for this walk.")
+	for name, data := range map[string][]byte{"fr.txt": latin1, "nfd.txt": decomposed, "en.txt": english} {
+		if _, err := vfs.Write("/pets/docs/"+name, data, 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write(%s) error = %v", name, err)
+		}
+	}
+	waitIndexed(t, plugin, "pets")
+
+	if data, err := vfs.Read("/pets/docs/fr.txt", 0, -1); (err != nil && err != io.EOF) || !bytes.Equal(data, latin1) {
+		t.Errorf("Read() = %q, %v; want the document as written", data, err)
+	}
+	results, err := vfs.VectorSearch("pets", "cat -- filter:language=fr", 10)
+	if err != nil || len(results) != 2 {
+		t.Errorf("VectorSearch(language=fr) = %+v, %v", results, err)
+	}
+	for _, r := range results {
+		if !strings.Contains(r.Content, "caf\u00e9") || strings.Contains(r.Content, "\r") {
+			t.Errorf("VectorSearch() result %q is not normalized", r.Content)
+		}
+	}
+	if results, err := vfs.VectorSearch("pets", "cat -- filter:language=en", 10); err != nil || len(results) != 1 {
+		t.Errorf("VectorSearch(language=en) = %+v, %v", results, err)
+	}
+
+	// A sidecar sets the language itself
+	if _, err := vfs.Write("/pets/docs/en.txt.meta.json", []byte(`{"language": "en-GB"}`), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write(sidecar) error = %v", err)
+	}
+	if _, err := vfs.Write("/pets/.reindex", []byte("en.txt"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write(.reindex) error = %v", err)
+	}
+	waitIndexed(t, plugin, "pets")
+	if results, err := vfs.VectorSearch("pets", "cat -- filter:language=en-GB", 10); err != nil || len(results) != 1 {
+		t.Errorf("VectorSearch(language=en-GB) = %+v, %v", results, err)
+	}
+}
+
+func TestLocalModePreviewDir(t *testing.T) {
+	plugin := newLocalTestPlugin(t)
+	vfs := plugin.GetFileSystem().(*vectorFS)
+	if err := vfs.Mkdir("/pets", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	for _, doc := range []struct{ name, content string }{
+		{"cats.txt", "Cat care\nFeed the cat twice a day."},
+		{"more/dogs.txt", "a dog ran"},
+		{"guide.txt", "walk the dog"},
+		{"guide.txt.meta.json", `{"title":"Dog Guide"}`},
+	} {
+		if _, err := vfs.Write("/pets/docs/"+doc.name, []byte(doc.content), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write(%s) error = %v", doc.name, err)
+		}
+	}
+	waitIndexed(t, plugin, "pets")
+
+	entries, err := vfs.ReadDir("/pets/docs")
+	if err != nil {
+		t.Fatalf("ReadDir(docs) error = %v", err)
+	}
+	previews := filesystem.PreviewDir(vfs, "/pets/docs", entries, 12)
+	if p := previews["cats.txt"]; p.Title != "Cat care" || p.Text != "Cat care\nFee" || !p.Truncated {
+		t.Errorf("preview of cats.txt = %+v", p)
+	}
+	if p := previews["guide.txt"]; p.Title != "Dog Guide" || p.Text != "walk the dog" || p.Truncated {
+		t.Errorf("preview of guide.txt = %+v", p)
+	}
+	if _, ok := previews["more"]; ok {
+		t.Error("directory previewed")
+	}
+
+	previews = filesystem.PreviewDir(vfs, "/pets/docs/more", []filesystem.FileInfo{{Name: "dogs.txt"}}, 100)
+	if p := previews["dogs.txt"]; p.Text != "a dog ran" {
+		t.Errorf("preview of more/dogs.txt = %+v", p)
+	}
+	if previews := filesystem.PreviewDir(vfs, "/pets", []filesystem.FileInfo{{Name: ".indexing"}}, 100); previews != nil {
+		t.Errorf("namespace previews = %v, want none", previews)
+	}
+}
@@ -0,0 +1,122 @@
+package vectorfs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// Write consistency modes: when a write to docs/ returns
+const (
+	// WriteConsistencyVisible returns once the document is stored: it can
+	// be listed and read, and is searchable once indexed in the background
+	WriteConsistencyVisible = "visible"
+	// WriteConsistencyIndexed also waits until the document is indexed, so
+	// a search right after the write finds it
+	WriteConsistencyIndexed = "indexed"
+)
+
+// writeConsistencies lists the valid write_consistency values
+var writeConsistencies = []string{WriteConsistencyVisible, WriteConsistencyIndexed}
+
+// defaultIndexWaitTimeout bounds how long an indexed write waits
+const defaultIndexWaitTimeout = time.Minute
+
+// errIndexingAbandoned is returned to writers waiting on a document whose
+// indexing was dropped: it was removed, or the plugin shut down
+var errIndexingAbandoned = errors.New("indexing abandoned")
+
+func isWriteConsistency(mode string) bool {
+	for _, m := range writeConsistencies {
+		if mode == m {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWriteConsistency reads the write consistency of namespaces without
+// an override and how long indexed writes wait
+func parseWriteConsistency(cfg map[string]interface{}) (string, time.Duration, error) {
+	mode := config.GetStringConfig(cfg, "write_consistency", WriteConsistencyVisible)
+	if !isWriteConsistency(mode) {
+		return "", 0, fmt.Errorf("unsupported write_consistency: %s (supported: %s)", mode, strings.Join(writeConsistencies, ", "))
+	}
+	timeout := defaultIndexWaitTimeout
+	if value := config.GetStringConfig(cfg, "index_wait_timeout", ""); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid index_wait_timeout %q: %w", value, err)
+		}
+		if d <= 0 {
+			return "", 0, fmt.Errorf("index_wait_timeout must be positive")
+		}
+		timeout = d
+	}
+	return mode, timeout, nil
+}
+
+// writeConsistency returns the write consistency of a namespace
+func (v *VectorFSPlugin) writeConsistency(namespace string) string {
+	if mode := v.namespaceConfig(namespace).WriteConsistency; mode != "" {
+		return mode
+	}
+	return v.consistency
+}
+
+// notifyIndexWaiters wakes the writers waiting on a document. The caller
+// holds indexingStatusMu.
+func (v *VectorFSPlugin) notifyIndexWaiters(namespace, digest string, err error) {
+	for _, ch := range v.indexWaiters[namespace][digest] {
+		ch <- err
+	}
+	delete(v.indexWaiters[namespace], digest)
+	if len(v.indexWaiters[namespace]) == 0 {
+		delete(v.indexWaiters, namespace)
+	}
+}
+
+// waitIndexed blocks until a queued document is indexed and returns its
+// indexing error. Documents not pending return at once. It gives up after
+// the index wait timeout, or when the plugin shuts down.
+func (v *VectorFSPlugin) waitIndexed(namespace, digest, path string) error {
+	v.indexingStatusMu.Lock()
+	info := v.indexingStatus[namespace][digest]
+	if info != nil && info.pending() {
+		if paused, _ := v.indexPause.isPaused(); paused {
+			v.indexingStatusMu.Unlock()
+			return errIndexingPaused
+		}
+	}
+	if info == nil || !info.pending() {
+		v.indexingStatusMu.Unlock()
+		if info != nil {
+			return info.err
+		}
+		return nil
+	}
+	ch := make(chan error, 1)
+	if v.indexWaiters == nil {
+		v.indexWaiters = make(map[string]map[string][]chan error)
+	}
+	if v.indexWaiters[namespace] == nil {
+		v.indexWaiters[namespace] = make(map[string][]chan error)
+	}
+	v.indexWaiters[namespace][digest] = append(v.indexWaiters[namespace][digest], ch)
+	v.indexingStatusMu.Unlock()
+
+	timer := time.NewTimer(v.indexWaitTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-ch:
+		return err
+	case <-timer.C:
+		return &filesystem.TimeoutError{Path: path, Op: "index", Timeout: v.indexWaitTimeout}
+	case <-v.shutdown:
+		return errIndexingAbandoned
+	}
+}
@@ -0,0 +1,402 @@
+package versionfs
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PluginName = "versionfs"
+
+	// VersionsDir is where the stored versions appear in the mount
+	VersionsDir = "/.versions"
+
+	defaultMaxVersions = 10
+	defaultMaxFileSize = 64 * 1024 * 1024
+)
+
+// VersionFSPlugin stacks on another AGFS path and keeps old versions of its
+// files
+type VersionFSPlugin struct {
+	rootFS     filesystem.FileSystem
+	fileSystem *versionFS
+}
+
+// NewVersionFSPlugin creates a new VersionFS plugin
+func NewVersionFSPlugin() *VersionFSPlugin {
+	return &VersionFSPlugin{}
+}
+
+// SetRootFS sets the file system the source path is resolved in
+func (p *VersionFSPlugin) SetRootFS(rootFS filesystem.FileSystem) {
+	p.rootFS = rootFS
+}
+
+func (p *VersionFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *VersionFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"source", "store", "max_versions", "max_file_size", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+
+	source, err := config.RequireString(cfg, "source")
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(source, "/") {
+		return fmt.Errorf("source must be an absolute AGFS path")
+	}
+	if err := config.ValidateStringType(cfg, "store"); err != nil {
+		return err
+	}
+	if store := config.GetStringConfig(cfg, "store", ""); store != "" && !strings.HasPrefix(store, "/") {
+		return fmt.Errorf("store must be an absolute AGFS path")
+	}
+	if mountPath := config.GetStringConfig(cfg, "mount_path", ""); mountPath != "" && isWithin(mountPath, source) {
+		return fmt.Errorf("versionfs cannot be mounted inside its source %s", source)
+	}
+
+	if err := config.ValidateIntType(cfg, "max_versions"); err != nil {
+		return err
+	}
+	if config.GetIntConfig(cfg, "max_versions", defaultMaxVersions) < 1 {
+		return fmt.Errorf("max_versions must be at least 1")
+	}
+	if _, err := config.GetSizeConfig(cfg, "max_file_size", defaultMaxFileSize); err != nil {
+		return err
+	}
+	return nil
+}
+
+// isWithin reports whether p is dir or below it
+func isWithin(p, dir string) bool {
+	p, dir = filesystem.NormalizePath(p), filesystem.NormalizePath(dir)
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+func (p *VersionFSPlugin) Initialize(cfg map[string]interface{}) error {
+	if p.rootFS == nil {
+		return fmt.Errorf("versionfs needs the root file system; mount it through the server")
+	}
+	source := filesystem.NormalizePath(config.GetStringConfig(cfg, "source", ""))
+	store := config.GetStringConfig(cfg, "store", "")
+	if store == "" {
+		store = path.Join(source, VersionsDir)
+	}
+	maxFileSize, err := config.GetSizeConfig(cfg, "max_file_size", defaultMaxFileSize)
+	if err != nil {
+		return err
+	}
+
+	p.fileSystem = &versionFS{
+		root:        p.rootFS,
+		source:      source,
+		store:       filesystem.NormalizePath(store),
+		maxVersions: config.GetIntConfig(cfg, "max_versions", defaultMaxVersions),
+		maxFileSize: maxFileSize,
+	}
+	log.Infof("[versionfs] Versioning %s (keeping %d versions in %s)", source, p.fileSystem.maxVersions, p.fileSystem.store)
+	return nil
+}
+
+func (p *VersionFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fileSystem
+}
+
+func (p *VersionFSPlugin) GetReadme() string {
+	return `VersionFS Plugin - File Versioning Overlay
+
+This plugin stacks on another AGFS path and keeps the last versions of
+every file written through it, as a cheap undo.
+
+STRUCTURE:
+  /<path>               - The files of the source path, read and written through
+  /<path>@v<n>          - Version n of a file (read-only)
+  /.versions/<path>/v<n> - The same versions, one directory per file
+
+USAGE:
+  Change a file, then look at or restore an earlier version:
+    echo "draft 1" > /versionfs/notes.txt
+    echo "draft 2" > /versionfs/notes.txt
+    ls /versionfs/.versions/notes.txt          # v1
+    cat /versionfs/notes.txt@v1                # draft 1
+    cp /versionfs/notes.txt@v1 /versionfs/notes.txt
+
+  Deleted files keep their versions:
+    rm /versionfs/notes.txt
+    cat /versionfs/notes.txt@v2
+
+NOTES:
+  - A version is saved before each write, truncate or delete of a file;
+    unchanged content is not saved twice
+  - Only the newest max_versions versions are kept per file
+  - Versions stay with the path; after a rename, they are under the old name
+  - Changes made directly to the source path are not versioned
+
+VERSION: 1.0.0
+`
+}
+
+func (p *VersionFSPlugin) GetConfigParams() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{
+			Name:        "source",
+			Type:        "string",
+			Required:    true,
+			Default:     "",
+			Description: "AGFS path to version (e.g. /localfs/project)",
+		},
+		{
+			Name:        "store",
+			Type:        "string",
+			Required:    false,
+			Default:     "<source>/.versions",
+			Description: "AGFS path where versions are stored",
+		},
+		{
+			Name:        "max_versions",
+			Type:        "int",
+			Required:    false,
+			Default:     fmt.Sprint(defaultMaxVersions),
+			Description: "Versions kept per file",
+		},
+		{
+			Name:        "max_file_size",
+			Type:        "string",
+			Required:    false,
+			Default:     "64MB",
+			Description: "Larger files are not versioned",
+		},
+	}
+}
+
+func (p *VersionFSPlugin) Shutdown() error {
+	return nil
+}
+
+// versionFS passes operations through to the source path, saving the old
+// content of a file before changing it
+type versionFS struct {
+	root        filesystem.FileSystem
+	source      string // Versioned AGFS path
+	store       string // AGFS path holding <file>/v<n>
+	maxVersions int
+	maxFileSize int64
+	mu          sync.Mutex // Serializes changes so each saves a consistent version
+}
+
+// sourcePath maps a path in the mount to the source
+func (fs *versionFS) sourcePath(p string) string {
+	return path.Join(fs.source, filesystem.NormalizePath(p))
+}
+
+// resolve maps a path in the mount to the root file system. Paths in
+// .versions and @v<n> names are read-only.
+func (fs *versionFS) resolve(p string) (string, bool) {
+	p = filesystem.NormalizePath(p)
+	if p == VersionsDir || strings.HasPrefix(p, VersionsDir+"/") {
+		return path.Join(fs.store, strings.TrimPrefix(p, VersionsDir)), true
+	}
+	if file, n, ok := splitVersion(p); ok {
+		versioned := path.Join(fs.versionDir(file), versionFileName(n))
+		if _, err := fs.root.Stat(versioned); err == nil {
+			return versioned, true
+		}
+	}
+	return fs.sourcePath(p), false
+}
+
+// writable resolves p for a change, rejecting read-only paths
+func (fs *versionFS) writable(op, p string) (string, error) {
+	resolved, readOnly := fs.resolve(p)
+	if readOnly {
+		return "", filesystem.NewPermissionDeniedError(op, p, "versions are read-only")
+	}
+	return resolved, nil
+}
+
+// hidesStore reports whether the store is inside the source, so that it
+// shows up in listings of the source and must be hidden there
+func (fs *versionFS) hidesStore(dir string) (string, bool) {
+	if path.Dir(fs.store) != fs.sourcePath(dir) {
+		return "", false
+	}
+	return path.Base(fs.store), true
+}
+
+func (fs *versionFS) Create(p string) error {
+	resolved, err := fs.writable("create", p)
+	if err != nil {
+		return err
+	}
+	return fs.root.Create(resolved)
+}
+
+func (fs *versionFS) Mkdir(p string, perm uint32) error {
+	resolved, err := fs.writable("mkdir", p)
+	if err != nil {
+		return err
+	}
+	return fs.root.Mkdir(resolved, perm)
+}
+
+func (fs *versionFS) Remove(p string) error {
+	resolved, err := fs.writable("remove", p)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.saveVersion(p); err != nil {
+		return err
+	}
+	return fs.root.Remove(resolved)
+}
+
+// RemoveAll saves a version when removing a single file; files removed
+// with a directory are not versioned
+func (fs *versionFS) RemoveAll(p string) error {
+	resolved, err := fs.writable("remove", p)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.saveVersion(p); err != nil {
+		return err
+	}
+	return fs.root.RemoveAll(resolved)
+}
+
+func (fs *versionFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	resolved, _ := fs.resolve(p)
+	return fs.root.Read(resolved, offset, size)
+}
+
+func (fs *versionFS) Write(p string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	resolved, err := fs.writable("write", p)
+	if err != nil {
+		return 0, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.saveVersion(p); err != nil {
+		return 0, fmt.Errorf("failed to save version: %w", err)
+	}
+	return fs.root.Write(resolved, data, offset, flags)
+}
+
+func (fs *versionFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	resolved, readOnly := fs.resolve(p)
+	infos, err := fs.root.ReadDir(resolved)
+	if err != nil || readOnly {
+		return infos, err
+	}
+
+	if hidden, ok := fs.hidesStore(p); ok {
+		filtered := infos[:0]
+		for _, info := range infos {
+			if info.Name != hidden {
+				filtered = append(filtered, info)
+			}
+		}
+		infos = filtered
+	}
+	if filesystem.NormalizePath(p) == "/" {
+		if info, err := fs.root.Stat(fs.store); err == nil && info.IsDir {
+			info.Name = strings.TrimPrefix(VersionsDir, "/")
+			infos = append(infos, *info)
+		}
+	}
+	return infos, nil
+}
+
+func (fs *versionFS) Stat(p string) (*filesystem.FileInfo, error) {
+	resolved, readOnly := fs.resolve(p)
+	info, err := fs.root.Stat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if readOnly {
+		info.Name = path.Base(filesystem.NormalizePath(p))
+		info.Mode &^= 0222
+	}
+	return info, nil
+}
+
+func (fs *versionFS) Rename(oldPath, newPath string) error {
+	oldResolved, err := fs.writable("rename", oldPath)
+	if err != nil {
+		return err
+	}
+	newResolved, err := fs.writable("rename", newPath)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	// The file being replaced, if any, can be recovered from its versions
+	if err := fs.saveVersion(newPath); err != nil {
+		return err
+	}
+	return fs.root.Rename(oldResolved, newResolved)
+}
+
+func (fs *versionFS) Chmod(p string, mode uint32) error {
+	resolved, err := fs.writable("chmod", p)
+	if err != nil {
+		return err
+	}
+	return fs.root.Chmod(resolved, mode)
+}
+
+func (fs *versionFS) Open(p string) (io.ReadCloser, error) {
+	resolved, _ := fs.resolve(p)
+	return fs.root.Open(resolved)
+}
+
+func (fs *versionFS) OpenWrite(p string) (io.WriteCloser, error) {
+	resolved, err := fs.writable("write", p)
+	if err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.saveVersion(p); err != nil {
+		return nil, fmt.Errorf("failed to save version: %w", err)
+	}
+	return fs.root.OpenWrite(resolved)
+}
+
+func (fs *versionFS) Truncate(p string, size int64) error {
+	resolved, err := fs.writable("truncate", p)
+	if err != nil {
+		return err
+	}
+	truncater, ok := fs.root.(filesystem.Truncater)
+	if !ok {
+		return filesystem.NewNotSupportedError("truncate", p)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.saveVersion(p); err != nil {
+		return fmt.Errorf("failed to save version: %w", err)
+	}
+	return truncater.Truncate(resolved, size)
+}
+
+// Ensure VersionFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*VersionFSPlugin)(nil)
+var _ filesystem.FileSystem = (*versionFS)(nil)
+var _ filesystem.Truncater = (*versionFS)(nil)
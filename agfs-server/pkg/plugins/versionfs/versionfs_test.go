@@ -0,0 +1,197 @@
+package versionfs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func newTestVersionFS(t *testing.T, cfg map[string]interface{}) (*versionFS, filesystem.FileSystem) {
+	t.Helper()
+	root := memfs.NewMemoryFS()
+	if err := root.Mkdir("/data", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+
+	if cfg == nil {
+		cfg = map[string]interface{}{}
+	}
+	cfg["source"] = "/data"
+	p := NewVersionFSPlugin()
+	p.SetRootFS(root)
+	if err := p.Validate(cfg); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if err := p.Initialize(cfg); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	return p.GetFileSystem().(*versionFS), root
+}
+
+func readString(t *testing.T, fs filesystem.FileSystem, path string) string {
+	t.Helper()
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && len(data) == 0 {
+		t.Fatalf("Read(%s) error = %v", path, err)
+	}
+	return string(data)
+}
+
+func TestVersionsOnWrite(t *testing.T) {
+	fs, root := newTestVersionFS(t, nil)
+
+	for _, content := range []string{"one", "two", "two", "three"} {
+		if _, err := fs.Write("/notes.txt", []byte(content), -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	if got := readString(t, root, "/data/notes.txt"); got != "three" {
+		t.Errorf("source content = %q, want three", got)
+	}
+	if got := readString(t, fs, "/notes.txt@v1"); got != "one" {
+		t.Errorf("notes.txt@v1 = %q, want one", got)
+	}
+	if got := readString(t, fs, "/notes.txt@v2"); got != "two" {
+		t.Errorf("notes.txt@v2 = %q, want two", got)
+	}
+	if _, err := fs.Stat("/notes.txt@v3"); err == nil {
+		t.Error("Stat(notes.txt@v3) succeeded; rewriting unchanged content must not add a version")
+	}
+
+	info, err := fs.Stat("/notes.txt@v1")
+	if err != nil {
+		t.Fatalf("Stat(@v1) error = %v", err)
+	}
+	if info.Name != "notes.txt@v1" {
+		t.Errorf("Stat(@v1).Name = %q", info.Name)
+	}
+
+	infos, err := fs.ReadDir("/.versions/notes.txt")
+	if err != nil {
+		t.Fatalf("ReadDir(.versions) error = %v", err)
+	}
+	if len(infos) != 2 {
+		t.Errorf("ReadDir(.versions/notes.txt) = %d entries, want 2", len(infos))
+	}
+}
+
+func TestVersionsDirInRootListing(t *testing.T) {
+	fs, _ := newTestVersionFS(t, nil)
+	fs.Write("/a.txt", []byte("1"), -1, filesystem.WriteFlagCreate)
+	fs.Write("/a.txt", []byte("2"), -1, filesystem.WriteFlagTruncate)
+
+	infos, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	var versions int
+	for _, info := range infos {
+		if info.Name == ".versions" {
+			versions++
+		}
+	}
+	if versions != 1 {
+		t.Errorf("root listing has %d .versions entries, want 1", versions)
+	}
+}
+
+func TestVersionsSurviveRemove(t *testing.T) {
+	fs, _ := newTestVersionFS(t, nil)
+	fs.Write("/a.txt", []byte("keep me"), -1, filesystem.WriteFlagCreate)
+
+	if err := fs.Remove("/a.txt"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := fs.Stat("/a.txt"); err == nil {
+		t.Fatal("a.txt still exists after Remove")
+	}
+	if got := readString(t, fs, "/a.txt@v1"); got != "keep me" {
+		t.Errorf("a.txt@v1 = %q, want keep me", got)
+	}
+
+	// Restore by copying the version back
+	data, _ := fs.Read("/a.txt@v1", 0, -1)
+	if _, err := fs.Write("/a.txt", data, -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if got := readString(t, fs, "/a.txt"); got != "keep me" {
+		t.Errorf("restored a.txt = %q", got)
+	}
+}
+
+func TestVersionsPruned(t *testing.T) {
+	fs, _ := newTestVersionFS(t, map[string]interface{}{"max_versions": 2})
+
+	for _, content := range []string{"1", "2", "3", "4"} {
+		fs.Write("/a.txt", []byte(content), -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate)
+	}
+	if _, err := fs.Stat("/a.txt@v1"); err == nil {
+		t.Error("a.txt@v1 was not pruned")
+	}
+	if got := readString(t, fs, "/a.txt@v3"); got != "3" {
+		t.Errorf("a.txt@v3 = %q, want 3", got)
+	}
+}
+
+func TestVersionsTruncateAndRename(t *testing.T) {
+	fs, _ := newTestVersionFS(t, nil)
+	fs.Write("/a.txt", []byte("hello"), -1, filesystem.WriteFlagCreate)
+
+	if err := fs.Truncate("/a.txt", 0); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+	if got := readString(t, fs, "/a.txt@v1"); got != "hello" {
+		t.Errorf("a.txt@v1 after truncate = %q, want hello", got)
+	}
+
+	// Versions stay with the path they were saved under
+	if err := fs.Rename("/a.txt", "/b.txt"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if got := readString(t, fs, "/a.txt@v1"); got != "hello" {
+		t.Errorf("a.txt@v1 after rename = %q, want hello", got)
+	}
+	if _, err := fs.Stat("/b.txt@v1"); err == nil {
+		t.Error("b.txt@v1 exists after rename")
+	}
+}
+
+func TestVersionsReadOnly(t *testing.T) {
+	fs, _ := newTestVersionFS(t, nil)
+	fs.Write("/a.txt", []byte("1"), -1, filesystem.WriteFlagCreate)
+	fs.Write("/a.txt", []byte("2"), -1, filesystem.WriteFlagTruncate)
+
+	if _, err := fs.Write("/a.txt@v1", []byte("x"), -1, filesystem.WriteFlagTruncate); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Write(@v1) error = %v, want permission denied", err)
+	}
+	if err := fs.Remove("/.versions/a.txt/v1"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Remove(.versions) error = %v, want permission denied", err)
+	}
+	if err := fs.Rename("/a.txt", "/.versions/x"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Rename(into .versions) error = %v, want permission denied", err)
+	}
+}
+
+func TestVersionFSValidate(t *testing.T) {
+	p := NewVersionFSPlugin()
+	tests := []struct {
+		name string
+		cfg  map[string]interface{}
+	}{
+		{"missing source", map[string]interface{}{}},
+		{"relative source", map[string]interface{}{"source": "data"}},
+		{"mounted inside source", map[string]interface{}{"source": "/data", "mount_path": "/data/v"}},
+		{"zero max_versions", map[string]interface{}{"source": "/data", "max_versions": 0}},
+		{"unknown key", map[string]interface{}{"source": "/data", "depth": 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p.Validate(tt.cfg); err == nil {
+				t.Error("Validate() succeeded, want error")
+			}
+		})
+	}
+}
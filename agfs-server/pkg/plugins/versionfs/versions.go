@@ -0,0 +1,138 @@
+package versionfs
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// versionSuffix matches the @v<n> suffix that names an old version of a file
+var versionSuffix = regexp.MustCompile(`^(.+)@v([1-9][0-9]*)$`)
+
+// versionFileName is the name of version n inside a file's version directory
+func versionFileName(n int) string {
+	return "v" + strconv.Itoa(n)
+}
+
+// parseVersionFileName returns the version number of a stored version
+func parseVersionFileName(name string) (int, bool) {
+	if !strings.HasPrefix(name, "v") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(name[1:])
+	return n, err == nil && n > 0
+}
+
+// splitVersion splits "/docs/a.txt@v3" into "/docs/a.txt" and 3
+func splitVersion(p string) (string, int, bool) {
+	m := versionSuffix.FindStringSubmatch(p)
+	if m == nil {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(m[2])
+	if err != nil {
+		return "", 0, false
+	}
+	return m[1], n, true
+}
+
+// versionDir returns where the versions of file p are stored
+func (fs *versionFS) versionDir(p string) string {
+	return path.Join(fs.store, p)
+}
+
+// versions returns the stored version numbers of file p, oldest first
+func (fs *versionFS) versions(p string) []int {
+	entries, err := fs.root.ReadDir(fs.versionDir(p))
+	if err != nil {
+		return nil
+	}
+	var numbers []int
+	for _, entry := range entries {
+		if n, ok := parseVersionFileName(entry.Name); ok && !entry.IsDir {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// readAll reads a whole file from the root file system
+func (fs *versionFS) readAll(p string) ([]byte, error) {
+	data, err := fs.root.Read(p, 0, -1)
+	if err == io.EOF {
+		err = nil
+	}
+	return data, err
+}
+
+// mkdirAll creates dir and its missing parents on the root file system
+func (fs *versionFS) mkdirAll(dir string) error {
+	if info, err := fs.root.Stat(dir); err == nil {
+		if !info.IsDir {
+			return filesystem.NewNotDirectoryError(dir)
+		}
+		return nil
+	}
+	if parent := path.Dir(dir); parent != dir {
+		if err := fs.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	return fs.root.Mkdir(dir, 0755)
+}
+
+// saveVersion records the current content of file p before it is changed.
+// Directories, missing files and files over maxFileSize are skipped, and
+// content equal to the newest version is not stored twice.
+// Caller must hold fs.mu.
+func (fs *versionFS) saveVersion(p string) error {
+	info, err := fs.root.Stat(fs.sourcePath(p))
+	if err != nil || info.IsDir {
+		return nil
+	}
+	if fs.maxFileSize > 0 && info.Size > fs.maxFileSize {
+		log.Debugf("[versionfs] not versioning %s: %d bytes is over max_file_size", p, info.Size)
+		return nil
+	}
+	data, err := fs.readAll(fs.sourcePath(p))
+	if err != nil {
+		return err
+	}
+
+	dir := fs.versionDir(p)
+	numbers := fs.versions(p)
+	next := 1
+	if len(numbers) > 0 {
+		latest := numbers[len(numbers)-1]
+		if prev, err := fs.readAll(path.Join(dir, versionFileName(latest))); err == nil && bytes.Equal(prev, data) {
+			return nil
+		}
+		next = latest + 1
+	}
+
+	if err := fs.mkdirAll(dir); err != nil {
+		return err
+	}
+	if _, err := fs.root.Write(path.Join(dir, versionFileName(next)), data, -1,
+		filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+		return err
+	}
+
+	numbers = append(numbers, next)
+	for len(numbers) > fs.maxVersions {
+		if err := fs.root.Remove(path.Join(dir, versionFileName(numbers[0]))); err != nil {
+			log.Warnf("[versionfs] failed to prune version %d of %s: %v", numbers[0], p, err)
+			break
+		}
+		numbers = numbers[1:]
+	}
+	return nil
+}
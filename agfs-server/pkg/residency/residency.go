@@ -0,0 +1,276 @@
+// Package residency keeps data in the regions it may be stored in. Paths,
+// usually mount points, are pinned to regions, and rules require the writes
+// matching path patterns or identities, or tagged with a region by the
+// client, to land under a path pinned to that region. Writes elsewhere are
+// rejected and kept in a bounded history of violations, in memory.
+package residency
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// DefaultRetained is the number of violations kept when Config.Retained is
+// zero
+const DefaultRetained = 100
+
+// Rule requires the writes it matches to be stored in a region. A rule
+// matches a write whose path matches one of Paths, if any, by a caller
+// matching one of Identities, if any.
+type Rule struct {
+	Name       string   `json:"name"`                 // Shown in errors and violations
+	Paths      []string `json:"paths,omitempty"`      // Glob patterns of absolute paths; "**" matches any number of elements
+	Identities []string `json:"identities,omitempty"` // path.Match patterns of caller identities
+	Region     string   `json:"region"`               // Region the writes must be stored in
+}
+
+// Config describes a residency policy
+type Config struct {
+	Regions  map[string]string // Region of each path prefix, e.g. "/s3-eu": "eu-west"
+	Rules    []Rule
+	Retained int // Violations kept for inspection
+}
+
+// Write is a change checked against the policy
+type Write struct {
+	Identity string
+	Method   string
+	Endpoint string // API path below /api/v1
+	Path     string // Path written
+	Stored   string // Path the data lands at, if symbolic links lead Path elsewhere
+	Region   string // Region the client tagged the data with, if any
+}
+
+// Violation is a write the policy rejected
+type Violation struct {
+	Time     time.Time `json:"time"`
+	Identity string    `json:"identity,omitempty"`
+	Method   string    `json:"method"`
+	Endpoint string    `json:"endpoint"`
+	Path     string    `json:"path"`
+	Stored   string    `json:"stored,omitempty"` // Path the data would have landed at, if not Path
+	Rule     string    `json:"rule"`             // What required the region: a rule, or the client's tag
+	Required string    `json:"required"`         // Region the data must be stored in
+	Region   string    `json:"region,omitempty"` // Region of the path, "" if it has none
+}
+
+// Pin is a path prefix pinned to a region
+type Pin struct {
+	Path   string `json:"path"`
+	Region string `json:"region"`
+}
+
+// Policy checks writes against the residency rules
+type Policy struct {
+	pins     []Pin // Longest path first
+	rules    []Rule
+	retained int
+
+	mu         sync.Mutex
+	violations []Violation // Oldest first
+}
+
+// NewPolicy creates a residency policy
+func NewPolicy(cfg Config) (*Policy, error) {
+	if len(cfg.Regions) == 0 {
+		return nil, fmt.Errorf("residency needs at least one path pinned to a region")
+	}
+	p := &Policy{retained: cfg.Retained}
+	if p.retained <= 0 {
+		p.retained = DefaultRetained
+	}
+
+	regions := make(map[string]bool)
+	for prefix, region := range cfg.Regions {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("region path %q must be absolute", prefix)
+		}
+		if region == "" {
+			return nil, fmt.Errorf("region of %s must not be empty", prefix)
+		}
+		p.pins = append(p.pins, Pin{Path: filesystem.NormalizePath(prefix), Region: region})
+		regions[region] = true
+	}
+	sort.Slice(p.pins, func(i, j int) bool {
+		if len(p.pins[i].Path) != len(p.pins[j].Path) {
+			return len(p.pins[i].Path) > len(p.pins[j].Path)
+		}
+		return p.pins[i].Path < p.pins[j].Path
+	})
+
+	for i, rule := range cfg.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i)
+		}
+		if len(rule.Paths) == 0 && len(rule.Identities) == 0 {
+			return nil, fmt.Errorf("residency rule %q needs paths or identities", rule.Name)
+		}
+		if !regions[rule.Region] {
+			return nil, fmt.Errorf("residency rule %q requires region %q, which no path is pinned to", rule.Name, rule.Region)
+		}
+		for _, pattern := range rule.Paths {
+			if _, err := path.Match(pattern, ""); err != nil || !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "**") {
+				return nil, fmt.Errorf("residency rule %q: invalid path pattern %q", rule.Name, pattern)
+			}
+		}
+		for _, pattern := range rule.Identities {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("residency rule %q: invalid identity pattern %q", rule.Name, pattern)
+			}
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p, nil
+}
+
+// Pins returns the paths pinned to regions, longest first
+func (p *Policy) Pins() []Pin {
+	return append([]Pin(nil), p.pins...)
+}
+
+// Rules returns the residency rules
+func (p *Policy) Rules() []Rule {
+	return append([]Rule{}, p.rules...)
+}
+
+// Region returns the region p is stored in, "" if no pinned path covers it
+func (p *Policy) Region(path string) string {
+	path = filesystem.NormalizePath(path)
+	for _, pin := range p.pins {
+		if path == pin.Path || pin.Path == "/" || strings.HasPrefix(path, pin.Path+"/") {
+			return pin.Region
+		}
+	}
+	return ""
+}
+
+// PathsIn returns the pinned paths of a region
+func (p *Policy) PathsIn(region string) []string {
+	var paths []string
+	for _, pin := range p.pins {
+		if pin.Region == region {
+			paths = append(paths, pin.Path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Check returns a permission denied error, and records the violation, if a
+// rule or the client's region tag requires w to be stored in another region
+// than that of its path
+func (p *Policy) Check(w Write) error {
+	v, err := p.decide(w)
+	if err != nil {
+		p.record(v)
+	}
+	return err
+}
+
+// Preview returns the error Check would, without recording a violation,
+// for dry runs
+func (p *Policy) Preview(w Write) error {
+	_, err := p.decide(w)
+	return err
+}
+
+// decide returns the violation of w and its error, or a nil error if w is
+// stored in the region it must be
+func (p *Policy) decide(w Write) (Violation, error) {
+	w.Path = filesystem.NormalizePath(w.Path)
+	stored := w.Path
+	if w.Stored != "" {
+		stored = filesystem.NormalizePath(w.Stored)
+	}
+	// The data is in the region of where it lands, and rules apply to
+	// both the path written and that one
+	region := p.Region(stored)
+
+	rule, required := "client tag", w.Region
+	if required == "" || required == region {
+		rule, required = "", ""
+		for _, r := range p.rules {
+			if r.Region != region && (r.matches(w.Identity, w.Path) || r.matches(w.Identity, stored)) {
+				rule, required = "residency rule "+strconv.Quote(r.Name), r.Region
+				break
+			}
+		}
+	}
+	if required == "" {
+		return Violation{}, nil
+	}
+
+	v := Violation{
+		Time:     time.Now(),
+		Identity: w.Identity,
+		Method:   w.Method,
+		Endpoint: w.Endpoint,
+		Path:     w.Path,
+		Rule:     rule,
+		Required: required,
+		Region:   region,
+	}
+	reason := fmt.Sprintf("%s requires region %s", rule, required)
+	if stored != w.Path {
+		v.Stored = stored
+		reason += ", the path links to " + stored
+		if region != "" {
+			reason += " in region " + region
+		}
+	} else if region != "" {
+		reason += ", the path is in region " + region
+	}
+	if paths := p.PathsIn(required); len(paths) > 0 {
+		reason += "; write it under " + strings.Join(paths, " or ")
+	}
+	return v, filesystem.NewPermissionDeniedError("write", w.Path, reason)
+}
+
+// matches reports whether the rule applies to identity writing p
+func (r Rule) matches(identity, p string) bool {
+	return matchAny(r.Paths, p, filesystem.MatchGlob) && matchAny(r.Identities, identity, func(pattern, name string) bool {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	})
+}
+
+// matchAny reports whether name matches one of patterns, or patterns is
+// empty
+func matchAny(patterns []string, name string, match func(pattern, name string) bool) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if match(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) record(v Violation) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.violations = append(p.violations, v)
+	if len(p.violations) > p.retained {
+		p.violations = append([]Violation(nil), p.violations[len(p.violations)-p.retained:]...)
+	}
+}
+
+// Violations returns the recorded violations, most recent first
+func (p *Policy) Violations() []Violation {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]Violation, len(p.violations))
+	for i, v := range p.violations {
+		out[len(out)-1-i] = v
+	}
+	return out
+}
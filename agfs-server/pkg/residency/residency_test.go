@@ -0,0 +1,107 @@
+package residency
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func newTestPolicy(t *testing.T) *Policy {
+	t.Helper()
+	p, err := NewPolicy(Config{
+		Regions: map[string]string{"/s3-eu": "eu-west", "/s3-eu-2": "eu-west", "/s3-us": "us-east", "/s3-us/eu-cache": "eu-west"},
+		Rules: []Rule{
+			{Name: "eu-customers", Paths: []string{"**/customers/eu/**"}, Region: "eu-west"},
+			{Name: "eu-agents", Identities: []string{"eu-*"}, Region: "eu-west"},
+		},
+		Retained: 2,
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	return p
+}
+
+func TestRegion(t *testing.T) {
+	p := newTestPolicy(t)
+	tests := map[string]string{
+		"/s3-eu":                  "eu-west",
+		"/s3-eu/a.txt":            "eu-west",
+		"/s3-eu-2/a.txt":          "eu-west",
+		"/s3-us/a.txt":            "us-east",
+		"/s3-us/eu-cache/a.txt":   "eu-west",
+		"/s3-usa/a.txt":           "",
+		"/local/customers/eu/x":   "",
+		"/s3-us/eu-cache-2/a.txt": "us-east",
+	}
+	for path, want := range tests {
+		if got := p.Region(path); got != want {
+			t.Errorf("Region(%s) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p := newTestPolicy(t)
+	tests := []struct {
+		write Write
+		rule  string // Empty when allowed
+	}{
+		{Write{Identity: "us-agent", Path: "/s3-eu/customers/eu/a.json"}, ""},
+		// Data is in the region of where links lead
+		{Write{Identity: "us-agent", Path: "/s3-eu/link/a.json", Stored: "/s3-us/dump/a.json", Region: "eu-west"}, "client tag"},
+		{Write{Identity: "us-agent", Path: "/s3-us/link/a.json", Stored: "/s3-eu/dump/a.json", Region: "eu-west"}, ""},
+		{Write{Identity: "us-agent", Path: "/s3-us/customers/eu/a.json", Stored: "/s3-eu/dump/a.json"}, ""},
+		{Write{Identity: "us-agent", Path: "/s3-eu/customers/eu/a.json", Stored: "/s3-us/dump/a.json"}, `residency rule "eu-customers"`},
+		{Write{Identity: "us-agent", Path: "/s3-us/customers/eu/a.json"}, `residency rule "eu-customers"`},
+		{Write{Identity: "us-agent", Path: "/local/customers/eu/a.json"}, `residency rule "eu-customers"`},
+		{Write{Identity: "us-agent", Path: "/s3-us/customers/us/a.json"}, ""},
+		{Write{Identity: "eu-agent", Path: "/s3-us/notes.txt"}, `residency rule "eu-agents"`},
+		{Write{Identity: "eu-agent", Path: "/s3-us/eu-cache/notes.txt"}, ""},
+		{Write{Identity: "us-agent", Path: "/s3-us/notes.txt", Region: "eu-west"}, "client tag"},
+		{Write{Identity: "us-agent", Path: "/s3-eu/notes.txt", Region: "eu-west"}, ""},
+	}
+	for _, tt := range tests {
+		err := p.Check(tt.write)
+		if tt.rule == "" {
+			if err != nil {
+				t.Errorf("Check(%+v) error = %v", tt.write, err)
+			}
+			continue
+		}
+		if !errors.Is(err, filesystem.ErrPermissionDenied) || !strings.Contains(err.Error(), tt.rule) ||
+			!strings.Contains(err.Error(), "write it under /s3-eu or /s3-eu-2 or /s3-us/eu-cache") {
+			t.Errorf("Check(%+v) error = %v, want a violation of %s", tt.write, err, tt.rule)
+		}
+	}
+
+	violations := p.Violations()
+	if len(violations) != 2 {
+		t.Fatalf("Violations() = %+v, want the last 2", violations)
+	}
+	if v := violations[0]; v.Rule != "client tag" || v.Required != "eu-west" || v.Region != "us-east" || v.Path != "/s3-us/notes.txt" {
+		t.Errorf("latest violation = %+v", v)
+	}
+	if v := violations[1]; v.Rule != `residency rule "eu-agents"` || v.Identity != "eu-agent" {
+		t.Errorf("previous violation = %+v", v)
+	}
+}
+
+func TestNewPolicyValidation(t *testing.T) {
+	regions := map[string]string{"/s3-eu": "eu-west"}
+	for name, cfg := range map[string]Config{
+		"no regions":     {},
+		"relative path":  {Regions: map[string]string{"s3-eu": "eu-west"}},
+		"empty region":   {Regions: map[string]string{"/s3-eu": ""}},
+		"unmatched rule": {Regions: regions, Rules: []Rule{{Name: "r", Region: "eu-west"}}},
+		"unknown region": {Regions: regions, Rules: []Rule{{Name: "r", Paths: []string{"/x/**"}, Region: "ap-south"}}},
+		"relative glob":  {Regions: regions, Rules: []Rule{{Name: "r", Paths: []string{"x/*"}, Region: "eu-west"}}},
+		"bad identity":   {Regions: regions, Rules: []Rule{{Name: "r", Identities: []string{"["}, Region: "eu-west"}}},
+	} {
+		if _, err := NewPolicy(cfg); err == nil {
+			t.Errorf("NewPolicy(%s) succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package sessions
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// maxEditDistance bounds the work spent on a diff; files that differ in
+// more lines are shown as a full replacement
+const maxEditDistance = 1000
+
+// edit is one line of an edit script: ' ' kept, '-' removed, '+' added
+type edit struct {
+	kind byte
+	line string
+}
+
+// UnifiedDiff returns a unified diff turning before into after for the file
+// at path. before is nil for a file that did not exist. Binary content is
+// reported without a line diff. It returns "" if nothing changed.
+func UnifiedDiff(path string, before, after []byte) string {
+	if before != nil && string(before) == string(after) {
+		return ""
+	}
+	from := "a" + path
+	if before == nil {
+		from = "/dev/null"
+	}
+	if !isText(before) || !isText(after) {
+		return fmt.Sprintf("Binary files %s and b%s differ\n", from, path)
+	}
+
+	a, b := splitLines(string(before)), splitLines(string(after))
+	edits, ok := diffLines(a, b)
+	if !ok {
+		edits = edits[:0]
+		for _, line := range a {
+			edits = append(edits, edit{'-', line})
+		}
+		for _, line := range b {
+			edits = append(edits, edit{'+', line})
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ b%s\n", from, path)
+	writeHunks(&sb, edits)
+	return sb.String()
+}
+
+// isText reports whether data can be shown as lines
+func isText(data []byte) bool {
+	return len(data) == 0 || filesystem.DetectEncoding(data) == "utf-8"
+}
+
+// splitLines splits s after each newline; the last line may lack one
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a shortest edit script from a to b with Myers'
+// algorithm. It gives up and returns false past maxEditDistance edits.
+func diffLines(a, b []string) ([]edit, bool) {
+	n, m := len(a), len(b)
+	limit := n + m
+	if limit > maxEditDistance {
+		limit = maxEditDistance
+	}
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+
+	// trace[d] holds v for diagonals -d..d before step d
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// backtrack walks the Myers trace back from the end of both inputs
+func backtrack(trace [][]int, a, b []string) []edit {
+	var edits []edit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		snapshot := trace[d]
+		get := func(k int) int { return snapshot[k+d] }
+
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && get(k-1) < get(k+1)) {
+			prevK = k + 1
+		}
+		prevX := get(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			edits = append(edits, edit{' ', a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			edits = append(edits, edit{'+', b[y-1]})
+			y--
+		} else {
+			edits = append(edits, edit{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		edits = append(edits, edit{' ', a[x-1]})
+		x--
+		y--
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// writeHunks writes the changed parts of edits as unified diff hunks
+func writeHunks(sb *strings.Builder, edits []edit) {
+	// Line numbers in a and b before each edit
+	aLine := make([]int, len(edits)+1)
+	bLine := make([]int, len(edits)+1)
+	for i, e := range edits {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if e.kind != '+' {
+			aLine[i+1]++
+		}
+		if e.kind != '-' {
+			bLine[i+1]++
+		}
+	}
+
+	for i := 0; i < len(edits); {
+		if edits[i].kind == ' ' {
+			i++
+			continue
+		}
+		// Extend the hunk while changes are close enough to share context
+		start := max(i-diffContext, 0)
+		last := i
+		for j := i + 1; j < len(edits) && j-last <= 2*diffContext; j++ {
+			if edits[j].kind != ' ' {
+				last = j
+			}
+		}
+		end := min(last+1+diffContext, len(edits))
+
+		aStart, aLen := aLine[start], aLine[end]-aLine[start]
+		bStart, bLen := bLine[start], bLine[end]-bLine[start]
+		if aLen > 0 {
+			aStart++
+		}
+		if bLen > 0 {
+			bStart++
+		}
+		fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+		for _, e := range edits[start:end] {
+			sb.WriteByte(e.kind)
+			sb.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+}
@@ -0,0 +1,106 @@
+package sessions
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Mismatch is a replayed request whose status differs from the recording
+type Mismatch struct {
+	Seq      int64  `json:"seq"`
+	Method   string `json:"method"`
+	Endpoint string `json:"endpoint"`
+	Recorded int    `json:"recorded"`
+	Replayed int    `json:"replayed"`
+}
+
+// ReplayResult summarizes a replay
+type ReplayResult struct {
+	Replayed   int        `json:"replayed"`
+	Skipped    int        `json:"skipped"` // Reads and requests whose body was not kept
+	Mismatches []Mismatch `json:"mismatches,omitempty"`
+}
+
+// Replay re-issues the requests recorded in the session directory dir of fs
+// that may have changed state, in order, against handler, which serves the
+// /api/v1 routes. Reads are skipped. Requests are sent without credentials,
+// so handler must not require them. File handles get new ids on replay, so
+// handle operations are not reproducible.
+func Replay(fs filesystem.FileSystem, dir string, handler http.Handler) (*ReplayResult, error) {
+	entries, err := ReadTrace(fs, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReplayResult{}
+	for _, entry := range entries {
+		if entry.Method == http.MethodGet || entry.Method == http.MethodHead || entry.BodyTruncated {
+			result.Skipped++
+			continue
+		}
+
+		var body []byte
+		if entry.Body != "" {
+			body, err = fs.Read(path.Join(dir, entry.Body), 0, -1)
+			if err != nil && err != io.EOF {
+				return result, fmt.Errorf("failed to read body of request %d: %w", entry.Seq, err)
+			}
+		}
+		target := "/api/v1" + entry.Endpoint
+		if entry.Query != "" {
+			target += "?" + entry.Query
+		}
+		req, err := http.NewRequest(entry.Method, target, bytes.NewReader(body))
+		if err != nil {
+			return result, fmt.Errorf("invalid request %d: %w", entry.Seq, err)
+		}
+		for k, v := range entry.Header {
+			req.Header.Set(k, v)
+		}
+
+		w := &statusWriter{header: make(http.Header)}
+		handler.ServeHTTP(w, req)
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		result.Replayed++
+		if w.status != entry.Status {
+			result.Mismatches = append(result.Mismatches, Mismatch{
+				Seq:      entry.Seq,
+				Method:   entry.Method,
+				Endpoint: entry.Endpoint,
+				Recorded: entry.Status,
+				Replayed: w.status,
+			})
+		}
+	}
+	return result, nil
+}
+
+// statusWriter is a ResponseWriter that keeps only the status code
+type statusWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *statusWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
@@ -0,0 +1,249 @@
+// Package sessions records the file system activity of callers into
+// replayable traces. Each session is a directory under one AGFS path (by
+// default /sessions/<id>) holding an NDJSON trace of every request, the
+// bodies sent with them and unified diffs of the files they changed.
+package sessions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Defaults applied when the corresponding Config field is zero
+const (
+	DefaultPath       = "/sessions"
+	DefaultMaxContent = 1 << 20
+)
+
+// Files in a session directory
+const (
+	InfoFile  = "session.json" // SessionInfo
+	TraceFile = "trace.ndjson" // One Entry per line, in order
+	DataDir   = "data"         // Request bodies, named by sequence number
+	DiffDir   = "diffs"        // Unified diffs, named by sequence number
+)
+
+// idPattern restricts session ids to names usable as a single path element
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,127}$`)
+
+// Config describes which requests are recorded and where
+type Config struct {
+	Path       string   // AGFS path sessions are stored under
+	Identities []string // path.Match patterns of identities that are always recorded
+	MaxContent int64    // Largest body or file content kept for a request
+}
+
+// SessionInfo describes a session; it is stored in InfoFile
+type SessionInfo struct {
+	ID       string    `json:"id"`
+	Identity string    `json:"identity,omitempty"` // Identity that started the session
+	Started  time.Time `json:"started"`
+}
+
+// Entry is one recorded request
+type Entry struct {
+	Seq        int64             `json:"seq"`
+	Time       time.Time         `json:"time"`
+	Identity   string            `json:"identity,omitempty"`
+	Method     string            `json:"method"`
+	Endpoint   string            `json:"endpoint"` // API path below /api/v1, e.g. "/files"
+	Query      string            `json:"query,omitempty"`
+	Header     map[string]string `json:"header,omitempty"` // Headers needed to replay the request
+	Status     int               `json:"status"`
+	DurationMs float64           `json:"duration_ms"`
+	BytesIn    int64             `json:"bytes_in,omitempty"`
+	BytesOut   int64             `json:"bytes_out,omitempty"`
+	Error      string            `json:"error,omitempty"`
+
+	Body          string `json:"body,omitempty"`           // Body file, relative to the session
+	BodyTruncated bool   `json:"body_truncated,omitempty"` // Body exceeded MaxContent and was not kept
+	Diff          string `json:"diff,omitempty"`           // Diff file, relative to the session
+}
+
+// Change is the content of a file before and after a request. Before is nil
+// if the file did not exist.
+type Change struct {
+	Path   string
+	Before []byte
+	After  []byte
+}
+
+// Recorder appends requests to session traces on a file system
+type Recorder struct {
+	fs      filesystem.FileSystem
+	cfg     Config
+	started time.Time
+
+	mu   sync.Mutex
+	next map[string]int64 // Next sequence number per open session
+}
+
+// NewRecorder creates a recorder storing sessions on fs
+func NewRecorder(fs filesystem.FileSystem, cfg Config) (*Recorder, error) {
+	if cfg.Path == "" {
+		cfg.Path = DefaultPath
+	}
+	if cfg.MaxContent <= 0 {
+		cfg.MaxContent = DefaultMaxContent
+	}
+	if !strings.HasPrefix(cfg.Path, "/") {
+		return nil, fmt.Errorf("sessions path must be an absolute AGFS path")
+	}
+	for _, pattern := range cfg.Identities {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid identity pattern %q: %w", pattern, err)
+		}
+	}
+	cfg.Path = filesystem.NormalizePath(cfg.Path)
+
+	return &Recorder{
+		fs:      fs,
+		cfg:     cfg,
+		started: time.Now(),
+		next:    make(map[string]int64),
+	}, nil
+}
+
+// Path returns where sessions are stored
+func (r *Recorder) Path() string {
+	return r.cfg.Path
+}
+
+// MaxContent returns the largest body or file content kept for a request
+func (r *Recorder) MaxContent() int64 {
+	return r.cfg.MaxContent
+}
+
+// Session returns the session a request belongs to, or "" if it is not
+// recorded. A session id requested by the caller wins; otherwise identities
+// matching Config.Identities are recorded into one session per identity
+// and server run.
+func (r *Recorder) Session(identity, requested string) (string, error) {
+	if requested != "" {
+		if !idPattern.MatchString(requested) {
+			return "", filesystem.NewInvalidArgumentError("session", requested, "cannot be used as a directory name")
+		}
+		return requested, nil
+	}
+	if identity == "" || !idPattern.MatchString(identity) {
+		return "", nil
+	}
+	for _, pattern := range r.cfg.Identities {
+		if ok, _ := path.Match(pattern, identity); ok {
+			return identity + "-" + r.started.UTC().Format("20060102T150405"), nil
+		}
+	}
+	return "", nil
+}
+
+// Record appends entry to session, storing body and the diff of change
+// next to the trace. change may be nil. Entry.Seq and the file references
+// are filled in.
+func (r *Recorder) Record(session string, entry Entry, body []byte, change *Change) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dir := path.Join(r.cfg.Path, session)
+	seq, ok := r.next[session]
+	if !ok {
+		var err error
+		if seq, err = r.open(dir, session, entry.Identity); err != nil {
+			return fmt.Errorf("failed to open session %s: %w", session, err)
+		}
+	}
+	entry.Seq = seq
+	name := fmt.Sprintf("%06d", seq)
+
+	if len(body) > 0 && !entry.BodyTruncated {
+		entry.Body = path.Join(DataDir, name)
+		if _, err := r.fs.Write(path.Join(dir, entry.Body), body, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+			return fmt.Errorf("failed to store body: %w", err)
+		}
+	}
+	if change != nil {
+		if diff := UnifiedDiff(change.Path, change.Before, change.After); diff != "" {
+			entry.Diff = path.Join(DiffDir, name+".diff")
+			if _, err := r.fs.Write(path.Join(dir, entry.Diff), []byte(diff), -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+				return fmt.Errorf("failed to store diff: %w", err)
+			}
+		}
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := r.fs.Write(path.Join(dir, TraceFile), line, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagAppend); err != nil {
+		return fmt.Errorf("failed to append to trace: %w", err)
+	}
+	r.next[session] = seq + 1
+	return nil
+}
+
+// open prepares the directory of a session and returns its next sequence
+// number. Sessions recorded before a restart are continued.
+func (r *Recorder) open(dir, session, identity string) (int64, error) {
+	if data, err := r.fs.Read(path.Join(dir, TraceFile), 0, -1); err == nil || err == io.EOF {
+		return int64(bytes.Count(data, []byte{'\n'})) + 1, nil
+	}
+
+	for _, d := range []string{r.cfg.Path, dir, path.Join(dir, DataDir), path.Join(dir, DiffDir)} {
+		if err := mkdirAll(r.fs, d); err != nil {
+			return 0, err
+		}
+	}
+	info, err := json.MarshalIndent(SessionInfo{ID: session, Identity: identity, Started: time.Now()}, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.fs.Write(path.Join(dir, InfoFile), info, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// mkdirAll creates dir and its missing parents on fs
+func mkdirAll(fs filesystem.FileSystem, dir string) error {
+	if info, err := fs.Stat(dir); err == nil {
+		if !info.IsDir {
+			return filesystem.NewNotDirectoryError(dir)
+		}
+		return nil
+	}
+	if parent := path.Dir(dir); parent != dir {
+		if err := mkdirAll(fs, parent); err != nil {
+			return err
+		}
+	}
+	return fs.Mkdir(dir, 0755)
+}
+
+// ReadTrace returns the entries recorded in the session directory dir
+func ReadTrace(fs filesystem.FileSystem, dir string) ([]Entry, error) {
+	data, err := fs.Read(path.Join(dir, TraceFile), 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	var entries []Entry
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var entry Entry
+		if err := dec.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid trace: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
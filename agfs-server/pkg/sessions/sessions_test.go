@@ -0,0 +1,137 @@
+package sessions
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after []byte
+		want          string
+	}{
+		{"unchanged", []byte("a\n"), []byte("a\n"), ""},
+		{"new file", nil, []byte("a\nb\n"), "--- /dev/null\n+++ b/f\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{
+			"changed line",
+			[]byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n"),
+			[]byte("1\n2\n3\n4\nfive\n6\n7\n8\n9\n"),
+			"--- a/f\n+++ b/f\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			"no trailing newline",
+			[]byte("a\n"),
+			[]byte("a\nb"),
+			"--- a/f\n+++ b/f\n@@ -1,1 +1,2 @@\n a\n+b\n\\ No newline at end of file\n",
+		},
+		{"binary", []byte("a"), []byte{0, 1, 2}, "Binary files a/f and b/f differ\n"},
+	}
+	for _, tt := range tests {
+		if got := UnifiedDiff("/f", tt.before, tt.after); got != tt.want {
+			t.Errorf("%s: UnifiedDiff() =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnifiedDiffSeparateHunks(t *testing.T) {
+	var before, after strings.Builder
+	for i := 0; i < 30; i++ {
+		line := strings.Repeat("x", i) + "\n"
+		before.WriteString(line)
+		if i == 2 || i == 25 {
+			line = "changed\n"
+		}
+		after.WriteString(line)
+	}
+	diff := UnifiedDiff("/f", []byte(before.String()), []byte(after.String()))
+	if n := strings.Count(diff, "@@ -"); n != 2 {
+		t.Errorf("diff has %d hunks, want 2:\n%s", n, diff)
+	}
+}
+
+func TestSession(t *testing.T) {
+	r, err := NewRecorder(memfs.NewMemoryFS(), Config{Identities: []string{"agent-*"}})
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+	if id, _ := r.Session("alice", ""); id != "" {
+		t.Errorf("Session(alice) = %q, want unrecorded", id)
+	}
+	if id, _ := r.Session("alice", "debug-1"); id != "debug-1" {
+		t.Errorf("Session(alice, debug-1) = %q, want debug-1", id)
+	}
+	if id, _ := r.Session("agent-7", ""); !strings.HasPrefix(id, "agent-7-") {
+		t.Errorf("Session(agent-7) = %q, want agent-7-<start>", id)
+	}
+	if _, err := r.Session("", "../x"); err == nil {
+		t.Error("Session(../x) succeeded, want invalid argument")
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	store := memfs.NewMemoryFS()
+	r, err := NewRecorder(store, Config{Path: "/traces", MaxContent: 16})
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+
+	r.Record("s1", Entry{Method: http.MethodGet, Endpoint: "/files", Query: "path=/a", Status: 200}, nil, nil)
+	r.Record("s1", Entry{Method: http.MethodPut, Endpoint: "/files", Query: "path=/a", Status: 200},
+		[]byte("hello\n"), &Change{Path: "/a", After: []byte("hello\n")})
+	r.Record("s1", Entry{Method: http.MethodPut, Endpoint: "/files", Query: "path=/big", Status: 200, BodyTruncated: true}, nil, nil)
+
+	entries, err := ReadTrace(store, "/traces/s1")
+	if err != nil {
+		t.Fatalf("ReadTrace() error = %v", err)
+	}
+	if len(entries) != 3 || entries[0].Seq != 1 || entries[2].Seq != 3 {
+		t.Fatalf("trace = %+v, want 3 entries numbered from 1", entries)
+	}
+	if entries[1].Body != "data/000002" || entries[1].Diff != "diffs/000002.diff" {
+		t.Errorf("entry 2 files = %q, %q", entries[1].Body, entries[1].Diff)
+	}
+	if diff, _ := store.Read("/traces/s1/"+entries[1].Diff, 0, -1); !strings.Contains(string(diff), "+hello") {
+		t.Errorf("diff = %q, want added line", diff)
+	}
+
+	// A new recorder continues the numbering
+	r2, _ := NewRecorder(store, Config{Path: "/traces"})
+	r2.Record("s1", Entry{Method: http.MethodDelete, Endpoint: "/files", Query: "path=/a", Status: 200}, nil, nil)
+	if entries, _ = ReadTrace(store, "/traces/s1"); entries[3].Seq != 4 {
+		t.Errorf("seq after reopening = %d, want 4", entries[3].Seq)
+	}
+
+	target := memfs.NewMemoryFS()
+	var seen []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		seen = append(seen, req.Method+" "+req.URL.String())
+		if req.Method == http.MethodPut {
+			data := make([]byte, 64)
+			n, _ := req.Body.Read(data)
+			target.Write(req.URL.Query().Get("path"), data[:n], -1, filesystem.WriteFlagCreate)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	result, err := Replay(store, "/traces/s1", handler)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if result.Replayed != 2 || result.Skipped != 2 {
+		t.Errorf("Replay() = %+v, want 2 replayed and 2 skipped", result)
+	}
+	if len(result.Mismatches) != 1 || result.Mismatches[0].Seq != 4 {
+		t.Errorf("mismatches = %+v, want the delete", result.Mismatches)
+	}
+	if data, _ := target.Read("/a", 0, -1); string(data) != "hello\n" {
+		t.Errorf("replayed content = %q, want hello", data)
+	}
+	if seen[0] != "PUT /api/v1/files?path=/a" {
+		t.Errorf("first replayed request = %q", seen[0])
+	}
+}
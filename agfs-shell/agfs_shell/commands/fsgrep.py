@@ -21,7 +21,9 @@ def cmd_fsgrep(process: Process) -> int:
     Options:
         -r          Recursive search (default for directories)
         -i          Case insensitive (for text grep, not VectorFS)
-        -n NUM      Return top N results (for VectorFS, default 10)
+        -n NUM      Return top N results (for VectorFS, default 10, or the
+                    namespace's default_topk if it sets one)
+        -m NUM      Same as -n, as in grep -m
         -c          Count matches only
         -q          Quiet mode (only show if matches found)
 
@@ -32,6 +34,7 @@ def cmd_fsgrep(process: Process) -> int:
         # VectorFS semantic search
         fsgrep "container orchestration" /vectorfs/project/docs
         fsgrep -n 5 "infrastructure automation" /vectorfs/namespace/docs
+        fsgrep "deploy -- topk:25 minscore:0.7" /vectorfs/namespace/docs
 
         # Regular text grep on other filesystems
         fsgrep "error" /local/tmp/app.log
@@ -41,7 +44,8 @@ def cmd_fsgrep(process: Process) -> int:
     VectorFS Features:
         - Semantic search using embeddings
         - Returns results ranked by relevance
-        - Use -n to control how many results to return
+        - Use -n / -m (or a topk:N modifier) to control how many results to return
+        - Use a minscore:S modifier to drop results scoring below S
         - Automatically searches all documents in namespace
     """
     # Parse options
@@ -49,7 +53,7 @@ def cmd_fsgrep(process: Process) -> int:
     case_insensitive = False
     count_only = False
     quiet = False
-    limit = 0  # 0 means use default (10 for VectorFS)
+    limit = 0  # 0 means use the filesystem's default
 
     args = process.args[:]
 
@@ -58,18 +62,18 @@ def cmd_fsgrep(process: Process) -> int:
         if opt == '--':
             break
 
-        # Handle -n with number argument
-        if opt == '-n':
+        # Handle -n / -m with number argument
+        if opt in ('-n', '-m'):
             if not args:
-                process.stderr.write("fsgrep: option '-n' requires an argument\n")
+                process.stderr.write(f"fsgrep: option '{opt}' requires an argument\n")
                 return 2
             try:
                 limit = int(args.pop(0))
                 if limit <= 0:
-                    process.stderr.write("fsgrep: invalid number for -n: must be positive\n")
+                    process.stderr.write(f"fsgrep: invalid number for {opt}: must be positive\n")
                     return 2
             except ValueError:
-                process.stderr.write("fsgrep: invalid number for -n\n")
+                process.stderr.write(f"fsgrep: invalid number for {opt}\n")
                 return 2
             continue
 
@@ -78,18 +82,18 @@ def cmd_fsgrep(process: Process) -> int:
                 recursive = True
             elif char == 'i':
                 case_insensitive = True
-            elif char == 'n':
-                # -n combined with other options, need to check next arg
+            elif char in ('n', 'm'):
+                # -n / -m combined with other options, need to check next arg
                 if not args:
-                    process.stderr.write("fsgrep: option '-n' requires an argument\n")
+                    process.stderr.write(f"fsgrep: option '-{char}' requires an argument\n")
                     return 2
                 try:
                     limit = int(args.pop(0))
                     if limit <= 0:
-                        process.stderr.write("fsgrep: invalid number for -n: must be positive\n")
+                        process.stderr.write(f"fsgrep: invalid number for -{char}: must be positive\n")
                         return 2
                 except ValueError:
-                    process.stderr.write("fsgrep: invalid number for -n\n")
+                    process.stderr.write(f"fsgrep: invalid number for -{char}\n")
                     return 2
             elif char == 'c':
                 count_only = True
@@ -120,7 +120,8 @@ class ShellCompleter:
 
         # Get directory listing from AGFS
         try:
-            entries = self.filesystem.list_directory(directory)
+            # Only names are needed, which is much faster on large directories
+            entries = self.filesystem.list_directory(directory, names_only=True)
 
             # Determine if we should return relative or absolute paths
             return_relative = not text.startswith('/')
@@ -139,7 +140,7 @@ class ShellCompleter:
                         abs_path = f"{dir_clean}/{name}"
 
                     # Add trailing slash for directories
-                    if entry.get('type') == 'directory':
+                    if entry.get('isDir') or entry.get('type') == 'directory':
                         abs_path += '/'
 
                     # Convert to relative path if needed
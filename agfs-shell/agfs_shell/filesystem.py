@@ -173,12 +173,13 @@ class AGFSFileSystem(FileSystemInterface):
         except AGFSClientError:
             return False
 
-    def list_directory(self, path: str):
+    def list_directory(self, path: str, names_only: bool = False):
         """
         List directory contents
 
         Args:
             path: Directory path in AGFS
+            names_only: Only list the name and isDir of each entry
 
         Returns:
             List of file info dicts
@@ -187,7 +188,7 @@ class AGFSFileSystem(FileSystemInterface):
             AGFSClientError: If directory cannot be listed
         """
         try:
-            return self.client.ls(path)
+            return self.client.ls(path, names_only=names_only)
         except AGFSClientError as e:
             # SDK error already includes path, don't duplicate it
             raise AGFSClientError(str(e))
@@ -75,12 +75,14 @@ class FileSystemInterface(ABC):
         pass
 
     @abstractmethod
-    def list_directory(self, path: str) -> List[Dict[str, Any]]:
+    def list_directory(self, path: str, names_only: bool = False) -> List[Dict[str, Any]]:
         """
         List directory contents.
 
         Args:
             path: Directory path
+            names_only: Only the name and whether it is a directory are
+                needed, which some backends list much faster
 
         Returns:
             List of file/directory metadata dicts with keys:
@@ -100,7 +100,7 @@ class MockFileSystem:
 
         return None
 
-    def list_directory(self, path: str) -> List[Dict[str, Any]]:
+    def list_directory(self, path: str, names_only: bool = False) -> List[Dict[str, Any]]:
         """List directory contents."""
         if path not in self.directories:
             raise FileNotFoundError(f"Directory not found: {path}")